@@ -0,0 +1,8 @@
+#!/usr/bin/env node
+
+import { main } from '../dist/index.js';
+
+main(['daemon', ...process.argv.slice(2)]).catch(err => {
+  console.error('Failed to start mcpsd:', err);
+  process.exit(1);
+});
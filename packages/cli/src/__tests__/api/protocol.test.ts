@@ -0,0 +1,38 @@
+import { describe, it, expect } from 'vitest';
+import { validateAnalysisRequest } from '../../api/protocol.js';
+
+describe('validateAnalysisRequest', () => {
+  it('should accept source with optional path and format options', () => {
+    expect(validateAnalysisRequest({ source: 'x = 1' })).toEqual({
+      source: 'x = 1',
+    });
+    expect(
+      validateAnalysisRequest({
+        source: '',
+        path: '/work/main.mcps',
+        printWidth: 100,
+        indentWidth: 4,
+      })
+    ).toEqual({
+      source: '',
+      path: '/work/main.mcps',
+      printWidth: 100,
+      indentWidth: 4,
+    });
+  });
+
+  it('should name the invalid field', () => {
+    expect(() => validateAnalysisRequest([])).toThrow(
+      'request must be a JSON object'
+    );
+    expect(() => validateAnalysisRequest({ source: 1 })).toThrow(
+      '"source" must be a string'
+    );
+    expect(() =>
+      validateAnalysisRequest({ source: '', path: 'main.mcps' })
+    ).toThrow('"path" must be an absolute path');
+    expect(() =>
+      validateAnalysisRequest({ source: '', printWidth: 0 })
+    ).toThrow('"printWidth" must be a positive integer');
+  });
+});
@@ -0,0 +1,113 @@
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import type { AddressInfo } from 'net';
+import { setParseLimits } from '@mcpscript/transpiler';
+import { startAnalysisServer } from '../../api/server.js';
+import {
+  CHECK_PATH,
+  FORMAT_PATH,
+  HEALTH_PATH,
+  PARSE_PATH,
+} from '../../api/protocol.js';
+
+describe('analysis service', () => {
+  let url: string;
+  let dir: string;
+  let close: () => Promise<void>;
+
+  const post = async (path: string, body: unknown) => {
+    const response = await fetch(`${url}${path}`, {
+      method: 'POST',
+      body: JSON.stringify(body),
+    });
+    return { status: response.status, body: await response.json() };
+  };
+
+  beforeAll(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-api-'));
+    await writeFile(
+      join(dir, 'lib.mcps'),
+      'tool twice(x) {\n  return x * 2\n}\n'
+    );
+    const server = await startAnalysisServer({ host: '127.0.0.1', port: 0 });
+    url = `http://127.0.0.1:${(server.address() as AddressInfo).port}`;
+    close = () => new Promise(resolve => server.close(() => resolve()));
+  });
+
+  afterAll(async () => {
+    await close();
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should report its version', async () => {
+    const response = await fetch(`${url}${HEALTH_PATH}`);
+    expect(response.status).toBe(200);
+    expect(await response.json()).toHaveProperty('version');
+  });
+
+  it('should return the syntax tree and syntax errors', async () => {
+    const valid = await post(PARSE_PATH, { source: 'x = 1' });
+    expect(valid.status).toBe(200);
+    expect(valid.body.diagnostics).toEqual([]);
+    expect(valid.body.tree).toMatch(/^\(source_file/);
+
+    const invalid = await post(PARSE_PATH, { source: 'x = (1' });
+    expect(invalid.body.diagnostics[0]).toMatchObject({ code: 'syntax' });
+  });
+
+  it('should check undefined variables', async () => {
+    const { body } = await post(CHECK_PATH, { source: 'print(missing)' });
+    expect(body.diagnostics).toHaveLength(1);
+    expect(body.diagnostics[0]).toMatchObject({
+      code: 'undefined-variable',
+      range: { start: { line: 0, character: 6 } },
+    });
+  });
+
+  it('should resolve imports relative to the given path', async () => {
+    const source = 'import "./lib"\nprint(twice(2))\n';
+    const { body } = await post(CHECK_PATH, {
+      source,
+      path: join(dir, 'main.mcps'),
+    });
+    expect(body.diagnostics).toEqual([]);
+  });
+
+  it('should format source with the given options', async () => {
+    const { body } = await post(FORMAT_PATH, {
+      source: 'x=[1,2]',
+      indentWidth: 4,
+    });
+    expect(body).toEqual({ formatted: 'x = [1, 2]\n', diagnostics: [] });
+
+    const invalid = await post(FORMAT_PATH, { source: 'x = (' });
+    expect(invalid.body.formatted).toBeUndefined();
+    expect(invalid.body.diagnostics).not.toEqual([]);
+  });
+
+  it('should reject source over the parse limits', async () => {
+    setParseLimits({ maxDepth: 20 });
+    try {
+      const response = await post(PARSE_PATH, {
+        source: `x = ${'['.repeat(30)}${']'.repeat(30)}`,
+      });
+      expect(response).toEqual({
+        status: 413,
+        body: { error: 'Syntax is nested more than 20 levels deep' },
+      });
+    } finally {
+      setParseLimits({});
+    }
+  });
+
+  it('should reject invalid requests', async () => {
+    expect((await post(CHECK_PATH, { text: 'x' })).body).toEqual({
+      error: '"source" must be a string',
+    });
+    expect((await post('/v1/compile', { source: 'x' })).status).toBe(404);
+    const response = await fetch(`${url}${CHECK_PATH}`);
+    expect(response.status).toBe(405);
+  });
+});
@@ -0,0 +1,66 @@
+import { describe, it, expect, vi, afterEach } from 'vitest';
+import {
+  APPROVAL_TOKEN_VARIABLE,
+  approvalWebhook,
+  headlessQuestions,
+} from '../approvals.js';
+
+describe('approvalWebhook', () => {
+  afterEach(() => {
+    delete process.env[APPROVAL_TOKEN_VARIABLE];
+  });
+
+  it('should post questions and return the answers', async () => {
+    process.env[APPROVAL_TOKEN_VARIABLE] = 'secret';
+    const fetchImpl = vi.fn(
+      async () => new Response(JSON.stringify({ answer: 1 }))
+    );
+    const ask = approvalWebhook(
+      { webhook: 'https://example.com/approve' },
+      '/scripts/deploy.mcps',
+      fetchImpl as typeof fetch
+    );
+    const question = {
+      kind: 'select' as const,
+      message: 'Deploy to?',
+      options: ['staging', 'production'],
+    };
+
+    await expect(ask(question)).resolves.toBe(1);
+    const [url, init] = fetchImpl.mock.calls[0] as unknown as [
+      string,
+      RequestInit,
+    ];
+    expect(url).toBe('https://example.com/approve');
+    expect(init.headers).toEqual({
+      'Content-Type': 'application/json',
+      Authorization: 'Bearer secret',
+    });
+    expect(JSON.parse(String(init.body))).toEqual({
+      script: '/scripts/deploy.mcps',
+      question,
+    });
+  });
+
+  it('should fail when the webhook does not answer', async () => {
+    const ask = approvalWebhook(
+      { webhook: 'https://example.com/approve' },
+      'deploy.mcps',
+      (async () => new Response('', { status: 503 })) as typeof fetch
+    );
+
+    await expect(
+      ask({ kind: 'confirm', message: 'Deploy now?', default: false })
+    ).rejects.toThrow('Approval webhook answered 503 to: Deploy now?');
+  });
+});
+
+describe('headlessQuestions', () => {
+  it('should refuse questions without a webhook', async () => {
+    const ask = headlessQuestions(undefined, 'deploy.mcps', 'triggered runs');
+
+    await expect(ask({ kind: 'text', message: 'Ticket?' })).rejects.toThrow(
+      'prompt.text() is not available in triggered runs without an approval webhook'
+    );
+  });
+});
@@ -0,0 +1,122 @@
+import { describe, it, expect } from 'vitest';
+import { mkdtempSync, rmSync, writeFileSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { parseSource } from '@mcpscript/transpiler';
+import {
+  findMain,
+  flagName,
+  formatScriptHelp,
+  mainArguments,
+  parseScriptArguments,
+  scriptFlags,
+} from '../arguments.js';
+
+const SOURCE = `// Deploy a service
+//
+// @param service Service to deploy
+// @param replicas Number of replicas
+tool main(service: string, replicas: number = 3, dryRun?: boolean, tags?: string[], config?: { region: string }) {
+  print(service)
+}
+`;
+
+function flags() {
+  return scriptFlags(findMain(parseSource(SOURCE), SOURCE)!);
+}
+
+describe('script flags', () => {
+  it('should name flags in kebab case', () => {
+    expect(flagName('dryRun')).toBe('--dry-run');
+    expect(flagName('max_items')).toBe('--max-items');
+    expect(flags().map(flag => flag.flag)).toEqual([
+      '--service',
+      '--replicas',
+      '--dry-run',
+      '--tags',
+      '--config',
+    ]);
+  });
+
+  it('should read flags by the types of the parameters', () => {
+    expect(
+      parseScriptArguments(flags(), [
+        '--service',
+        'api',
+        '--replicas=5',
+        '--dry-run',
+        '--tags',
+        'a',
+        '--tags',
+        'b',
+        '--config',
+        '{"region":"eu"}',
+      ])
+    ).toEqual({
+      service: 'api',
+      replicas: 5,
+      dryRun: true,
+      tags: ['a', 'b'],
+      config: { region: 'eu' },
+    });
+    expect(
+      parseScriptArguments(flags(), ['--service', 'api', '--no-dry-run'])
+    ).toEqual({ service: 'api', dryRun: false });
+  });
+
+  it('should read bytes flags from the files they name', () => {
+    const dir = mkdtempSync(join(tmpdir(), 'mcps-args-'));
+    const file = join(dir, 'logo.png');
+    writeFileSync(file, Buffer.from([0x89, 0x50, 0x4e, 0x47]));
+    const image = [
+      { name: 'image', flag: '--image', type: 'bytes', required: true },
+    ];
+    try {
+      expect(parseScriptArguments(image, ['--image', file])).toEqual({
+        image: Buffer.from([0x89, 0x50, 0x4e, 0x47]),
+      });
+      expect(() =>
+        parseScriptArguments(image, ['--image', join(dir, 'missing.png')])
+      ).toThrow(/^--image must name a readable file/);
+    } finally {
+      rmSync(dir, { recursive: true, force: true });
+    }
+  });
+
+  it('should refuse missing, unknown and malformed flags', () => {
+    expect(() => parseScriptArguments(flags(), [])).toThrow(
+      'Missing --service'
+    );
+    expect(() =>
+      parseScriptArguments(flags(), ['--service', 'api', '--replica', '2'])
+    ).toThrow('Unknown flag --replica');
+    expect(() =>
+      parseScriptArguments(flags(), ['--service', 'api', '--replicas', 'x'])
+    ).toThrow('--replicas must be a number, got x');
+    expect(() =>
+      mainArguments('deploy.mcps', parseSource(SOURCE), SOURCE, ['--service'])
+    ).toThrow('--service needs a value (see mcps run deploy.mcps -- --help)');
+    expect(() =>
+      mainArguments('plain.mcps', parseSource('x = 1'), 'x = 1', ['--a', '1'])
+    ).toThrow('plain.mcps declares no main tool, so it takes no flags');
+  });
+
+  it('should list the flags with their descriptions', () => {
+    const main = findMain(parseSource(SOURCE), SOURCE)!;
+    expect(formatScriptHelp('deploy.mcps', scriptFlags(main), main.doc)).toBe(
+      [
+        'Usage: mcps run deploy.mcps -- [flags]',
+        '',
+        'Deploy a service',
+        '',
+        'Flags:',
+        '  --service <string>             Service to deploy (required)',
+        '  --replicas <number>            Number of replicas (default: 3)',
+        '  --dry-run, --no-dry-run',
+        '  --tags <string[]>              (repeatable)',
+        '  --config <{ region: string }>',
+        '  --help                         Show this help',
+      ].join('\n')
+    );
+  });
+});
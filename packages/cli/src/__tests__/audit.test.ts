@@ -0,0 +1,144 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  AdvisoryDatabase,
+  auditPackages,
+  compareVersions,
+  serverPackage,
+} from '../audit.js';
+
+describe('serverPackage', () => {
+  it('should find the packages of npm and PyPI runners', () => {
+    expect(
+      serverPackage({
+        name: 'fs',
+        transport: 'stdio',
+        command: 'npx',
+        args: ['-y', '@modelcontextprotocol/server-filesystem@0.6.2', '/tmp'],
+      })
+    ).toEqual({
+      server: 'fs',
+      ecosystem: 'npm',
+      name: '@modelcontextprotocol/server-filesystem',
+      version: '0.6.2',
+      specifier: '@modelcontextprotocol/server-filesystem@0.6.2',
+    });
+    expect(
+      serverPackage({
+        name: 'fetch',
+        transport: 'stdio',
+        command: 'uvx',
+        args: ['--from', 'mcp-server-fetch[cli]==2025.4.7', 'mcp-fetch'],
+      })
+    ).toMatchObject({
+      ecosystem: 'PyPI',
+      name: 'mcp-server-fetch',
+      version: '2025.4.7',
+    });
+    expect(
+      serverPackage({
+        name: 'git',
+        transport: 'stdio',
+        command: '/usr/local/bin/pipx',
+        args: ['run', 'mcp-server-git'],
+      })
+    ).toEqual({
+      server: 'git',
+      ecosystem: 'PyPI',
+      name: 'mcp-server-git',
+      specifier: 'mcp-server-git',
+    });
+  });
+
+  it('should skip other programs and computed specifiers', () => {
+    expect(
+      serverPackage({ name: 'local', transport: 'stdio', command: 'node' })
+    ).toBeUndefined();
+    expect(
+      serverPackage({
+        name: 'fs',
+        transport: 'stdio',
+        command: 'npx',
+        args: ['${FS_PACKAGE}'],
+      })
+    ).toBeUndefined();
+  });
+});
+
+describe('compareVersions', () => {
+  it('should compare versions by their numbers', () => {
+    expect(compareVersions('1.10.0', '1.9.3')).toBeGreaterThan(0);
+    expect(compareVersions('2025.4.7', '2025.4.7')).toBe(0);
+    expect(compareVersions('0.6', '0.6.1')).toBeLessThan(0);
+  });
+});
+
+describe('auditPackages', () => {
+  const fetchImpl = vi.fn(async (url: string) => {
+    if (url.startsWith('https://registry.npmjs.org/')) {
+      return Response.json({ version: '2.1.0' });
+    }
+    if (url.startsWith('https://pypi.org/')) {
+      return new Response('Not Found', { status: 404 });
+    }
+    return Response.json({
+      vulns: [
+        {
+          id: 'GHSA-aaaa-bbbb-cccc',
+          summary: 'Path traversal outside allowed directories',
+          database_specific: { severity: 'HIGH' },
+        },
+      ],
+    });
+  });
+
+  it('should report advisories, newer releases and missing versions', async () => {
+    const database = new AdvisoryDatabase(fetchImpl as typeof fetch);
+    const findings = await auditPackages(
+      [
+        {
+          server: 'fs',
+          ecosystem: 'npm',
+          name: '@scope/server',
+          version: '1.4.0',
+          specifier: '@scope/server@1.4.0',
+        },
+        {
+          server: 'docs',
+          ecosystem: 'npm',
+          name: '@scope/server',
+          specifier: '@scope/server',
+        },
+        {
+          server: 'private',
+          ecosystem: 'PyPI',
+          name: 'internal-server',
+          specifier: 'internal-server',
+        },
+      ],
+      database
+    );
+
+    expect(findings.map(f => [f.server, f.kind, f.severity])).toEqual([
+      ['fs', 'outdated', 'moderate'],
+      ['fs', 'vulnerable', 'high'],
+      ['docs', 'unpinned', 'low'],
+      ['docs', 'vulnerable', 'high'],
+      ['private', 'unpinned', 'low'],
+    ]);
+    expect(findings[1]).toMatchObject({
+      version: '1.4.0',
+      message:
+        '@scope/server 1.4.0 is affected by GHSA-aaaa-bbbb-cccc: Path traversal outside allowed directories',
+      url: 'https://osv.dev/vulnerability/GHSA-aaaa-bbbb-cccc',
+    });
+    expect(findings[2].message).toBe(
+      '@scope/server runs the latest version when the server starts; pin one, such as @scope/server@2.1.0'
+    );
+    // The latest release of a package is looked up once
+    expect(
+      fetchImpl.mock.calls.filter(([url]) =>
+        url.startsWith('https://registry.npmjs.org/@scope%2fserver')
+      )
+    ).toHaveLength(1);
+  });
+});
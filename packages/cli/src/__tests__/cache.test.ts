@@ -0,0 +1,54 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { AnalysisCache, contentHash } from '../cache.js';
+
+describe('AnalysisCache', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-cache-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should return stored values by key', async () => {
+    const cache = new AnalysisCache(join(dir, 'cache'), '1.0.0');
+    expect(await cache.get(['check', 'a'])).toBeUndefined();
+
+    await cache.set(['check', 'a'], { diagnostics: [] });
+    expect(await cache.get(['check', 'a'])).toEqual({ diagnostics: [] });
+    expect(await cache.get(['check', 'b'])).toBeUndefined();
+  });
+
+  it('should keep entries of other versions apart', async () => {
+    await new AnalysisCache(join(dir, 'cache'), '1.0.0').set(['a'], 1);
+    const cache = new AnalysisCache(join(dir, 'cache'), '1.1.0');
+    expect(await cache.get(['a'])).toBeUndefined();
+  });
+
+  it('should drop values whose dependencies changed', async () => {
+    const cache = new AnalysisCache(join(dir, 'cache'), '1.0.0');
+    const lib = join(dir, 'lib.mcps');
+    await writeFile(lib, 'x = 1\n');
+    await cache.set(['a'], 1, { [lib]: contentHash('x = 1\n') });
+    expect(await cache.get(['a'])).toBe(1);
+
+    await writeFile(lib, 'x = 2\n');
+    expect(await cache.get(['a'])).toBeUndefined();
+
+    await rm(lib);
+    expect(await cache.get(['a'])).toBeUndefined();
+  });
+
+  it('should treat an unusable cache directory as empty', async () => {
+    const file = join(dir, 'file');
+    await writeFile(file, '');
+    const cache = new AnalysisCache(join(file, 'cache'), '1.0.0');
+    await cache.set(['a'], 1);
+    expect(await cache.get(['a'])).toBeUndefined();
+  });
+});
@@ -0,0 +1,79 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { readdir, mkdtemp, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import type { Checkpoint } from '@mcpscript/runtime';
+import {
+  checkpointStore,
+  FileCheckpointStore,
+  SqliteCheckpointStore,
+} from '../checkpoints.js';
+
+// node:sqlite needs no flag from Node.js 22.13 on
+const [major, minor] = process.versions.node.split('.').map(Number);
+const hasSqlite = major > 22 || (major === 22 && minor >= 13);
+
+const checkpoint: Checkpoint = {
+  script: 'abc123',
+  statement: 3,
+  variables: { total: 42 },
+  time: 1760617530000,
+};
+
+describe('checkpoint stores', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-checkpoints-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should keep the latest checkpoint of a run in a file', async () => {
+    const store = new FileCheckpointStore(join(dir, 'runs'));
+    expect(await store.load('run-1')).toBeUndefined();
+
+    await store.save('run-1', { ...checkpoint, statement: 2 });
+    await store.save('run-1', checkpoint);
+    expect(await store.load('run-1')).toEqual(checkpoint);
+    expect(await readdir(join(dir, 'runs'))).toEqual(['run-1.json']);
+
+    await store.delete('run-1');
+    expect(await store.load('run-1')).toBeUndefined();
+    await expect(store.delete('run-1')).resolves.toBeUndefined();
+  });
+
+  it('should refuse run ids that are not file names', async () => {
+    const store = new FileCheckpointStore(dir);
+    await expect(store.load('../secrets')).rejects.toThrow(
+      'Invalid run id: ../secrets'
+    );
+  });
+
+  it('should use the store of the project config', async () => {
+    const store = await checkpointStore({
+      config: { checkpoints: { path: 'state' } },
+      path: join(dir, '.mcpsrc'),
+    });
+    await store.save('run-1', checkpoint);
+    expect(await readdir(join(dir, 'state'))).toEqual(['run-1.json']);
+  });
+
+  it.runIf(hasSqlite)(
+    'should keep checkpoints in a SQLite database',
+    async () => {
+      const store = await SqliteCheckpointStore.open(join(dir, 'runs.db'));
+      await store.save('run-1', checkpoint);
+      await store.save('run-1', { ...checkpoint, statement: 4 });
+      expect(await store.load('run-1')).toEqual({
+        ...checkpoint,
+        statement: 4,
+      });
+      await store.delete('run-1');
+      expect(await store.load('run-1')).toBeUndefined();
+      store.close();
+    }
+  );
+});
@@ -0,0 +1,377 @@
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { tmpdir } from 'os';
+import {
+  CONFIG_FILE_NAME,
+  loadProjectConfig,
+  moduleSearchPaths,
+  secretProviders,
+  spillOptions,
+  validateProjectConfig,
+} from '../config.js';
+
+const TEST_DIR = join(tmpdir(), `mcps-config-test-${process.pid}`);
+
+describe('loadProjectConfig', () => {
+  beforeAll(async () => {
+    await mkdir(join(TEST_DIR, 'project', 'scripts'), { recursive: true });
+    await writeFile(
+      join(TEST_DIR, 'project', CONFIG_FILE_NAME),
+      JSON.stringify({ redaction: { keys: ['password'] } }),
+      'utf-8'
+    );
+    await mkdir(join(TEST_DIR, 'broken'), { recursive: true });
+    await writeFile(join(TEST_DIR, 'broken', CONFIG_FILE_NAME), '{', 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should find the config in a parent directory', async () => {
+    const loaded = await loadProjectConfig(
+      join(TEST_DIR, 'project', 'scripts')
+    );
+
+    expect(loaded.path).toBe(join(TEST_DIR, 'project', CONFIG_FILE_NAME));
+    expect(loaded.config).toEqual({ redaction: { keys: ['password'] } });
+  });
+
+  it('should report invalid JSON with the file path', async () => {
+    await expect(loadProjectConfig(join(TEST_DIR, 'broken'))).rejects.toThrow(
+      `Invalid ${join(TEST_DIR, 'broken', CONFIG_FILE_NAME)}`
+    );
+  });
+});
+
+describe('moduleSearchPaths', () => {
+  it('should resolve module paths against the config file', () => {
+    const paths = moduleSearchPaths({
+      config: { modulePaths: ['lib', '/shared/mcps'] },
+      path: join(TEST_DIR, 'project', CONFIG_FILE_NAME),
+    });
+
+    expect(paths).toEqual([join(TEST_DIR, 'project', 'lib'), '/shared/mcps']);
+  });
+});
+
+describe('spillOptions', () => {
+  it('should resolve the spill directory against the config file', () => {
+    expect(spillOptions({ config: {} })).toBeUndefined();
+    expect(
+      spillOptions({
+        config: { spill: { threshold: 1024, dir: 'tmp' } },
+        path: join(TEST_DIR, 'project', CONFIG_FILE_NAME),
+      })
+    ).toEqual({ threshold: 1024, dir: join(TEST_DIR, 'project', 'tmp') });
+  });
+});
+
+describe('secretProviders', () => {
+  it('should default to the environment', () => {
+    expect(
+      secretProviders({ config: {} }).map(provider => provider.name)
+    ).toEqual(['env']);
+  });
+
+  it('should resolve secret directories against the config file', () => {
+    const providers = secretProviders({
+      config: { secrets: [{ file: 'secrets' }, { command: ['pass'] }, 'env'] },
+      path: join(TEST_DIR, 'project', CONFIG_FILE_NAME),
+    });
+
+    expect(providers.map(provider => provider.name)).toEqual([
+      `file ${join(TEST_DIR, 'project', 'secrets')}`,
+      'command pass',
+      'env',
+    ]);
+  });
+});
+
+describe('validateProjectConfig', () => {
+  it('should accept an empty config', () => {
+    expect(validateProjectConfig({})).toEqual({});
+  });
+
+  it('should reject non-object configs', () => {
+    expect(() => validateProjectConfig([])).toThrow('must be a JSON object');
+  });
+
+  it('should name invalid redaction fields', () => {
+    expect(() =>
+      validateProjectConfig({ redaction: { patterns: 'sk-.*' } })
+    ).toThrow('"redaction.patterns" must be an array of strings');
+    expect(() =>
+      validateProjectConfig({ redaction: { replacement: 1 } })
+    ).toThrow('"redaction.replacement" must be a string');
+  });
+
+  it('should reject module paths that are not strings', () => {
+    expect(() => validateProjectConfig({ modulePaths: 'lib' })).toThrow(
+      '"modulePaths" must be an array of strings'
+    );
+  });
+
+  it('should reject unknown language features', () => {
+    expect(() => validateProjectConfig({ features: 'all' })).toThrow(
+      '"features" must be an array of strings'
+    );
+    expect(() => validateProjectConfig({ features: ['macros'] })).toThrow(
+      '"features" has unknown feature macros'
+    );
+  });
+
+  it('should check lint rule severities, plugins and packs', () => {
+    const lint = { rules: { 'unused-variable': 'off' }, plugins: ['rules.js'] };
+    expect(validateProjectConfig({ lint })).toEqual({ lint });
+    expect(() =>
+      validateProjectConfig({ lint: { rules: { 'unused-variable': 'info' } } })
+    ).toThrow(
+      '"lint.rules.unused-variable" must be "error", "warning" or "off"'
+    );
+    expect(() =>
+      validateProjectConfig({ lint: { plugins: 'rules.js' } })
+    ).toThrow('"lint.plugins" must be an array of strings');
+    expect(validateProjectConfig({ lint: { packs: ['prompts'] } })).toEqual({
+      lint: { packs: ['prompts'] },
+    });
+    expect(() =>
+      validateProjectConfig({ lint: { packs: ['style'] } })
+    ).toThrow('"lint.packs" must be an array of lint packs: prompts');
+  });
+
+  it('should check the descriptions of served tools', () => {
+    const serve = {
+      tools: { deploy: { description: 'Deploy', parameters: { env: 'Env' } } },
+    };
+    expect(validateProjectConfig({ serve })).toEqual({ serve });
+    expect(() =>
+      validateProjectConfig({
+        serve: { tools: { deploy: { description: 1 } } },
+      })
+    ).toThrow('"serve.tools.deploy.description" must be a string');
+    expect(() =>
+      validateProjectConfig({
+        serve: { tools: { deploy: { parameters: { env: true } } } },
+      })
+    ).toThrow(
+      '"serve.tools.deploy.parameters" must map parameter names to descriptions'
+    );
+  });
+
+  it('should check the credentials of served scripts', () => {
+    const serve = {
+      auth: [
+        { type: 'apiKey', keys: [{ subject: 'ci', env: 'CI_KEY' }] },
+        {
+          type: 'oidc',
+          issuer: 'https://login.example.com',
+          claims: ['email'],
+        },
+        { type: 'mtls', subject: 'san' },
+      ],
+      tls: { cert: 'tls/cert.pem', key: 'tls/key.pem', ca: 'tls/ca.pem' },
+    };
+    expect(validateProjectConfig({ serve })).toEqual({ serve });
+    expect(() =>
+      validateProjectConfig({ serve: { auth: { type: 'apiKey' } } })
+    ).toThrow('"serve.auth" must be an array');
+    expect(() =>
+      validateProjectConfig({
+        serve: { auth: [{ type: 'apiKey', keys: [{ subject: 'ci' }] }] },
+      })
+    ).toThrow(
+      '"serve.auth[0].keys" must be an array of { "subject", "env" } objects'
+    );
+    expect(() =>
+      validateProjectConfig({
+        serve: { auth: [{ type: 'oidc', issuer: 'x' }] },
+      })
+    ).toThrow('"serve.auth[0].issuer" must be a URL');
+    expect(() =>
+      validateProjectConfig({ serve: { auth: [{ type: 'mtls' }] } })
+    ).toThrow(
+      '"serve.auth[0]" needs "serve.tls.ca" to check client certificates'
+    );
+    expect(() =>
+      validateProjectConfig({ serve: { auth: [{ type: 'basic' }] } })
+    ).toThrow('"serve.auth[0].type" must be "apiKey", "oidc" or "mtls"');
+    expect(() =>
+      validateProjectConfig({ serve: { tls: { cert: 'tls/cert.pem' } } })
+    ).toThrow(
+      '"serve.tls" must be an object with cert, key and optional ca files'
+    );
+  });
+
+  it('should check the settings of served triggers', () => {
+    const serve = { triggers: { status: { readOnly: true, maxAge: 30 } } };
+    expect(validateProjectConfig({ serve })).toEqual({ serve });
+    expect(() =>
+      validateProjectConfig({ serve: { triggers: { status: true } } })
+    ).toThrow('"serve.triggers.status" must be an object');
+    expect(() =>
+      validateProjectConfig({
+        serve: { triggers: { status: { readOnly: 'yes' } } },
+      })
+    ).toThrow('"serve.triggers.status.readOnly" must be a boolean');
+    expect(() =>
+      validateProjectConfig({ serve: { triggers: { status: { maxAge: 0 } } } })
+    ).toThrow('"serve.triggers.status.maxAge" must be a positive integer');
+  });
+
+  it('should check the signing policy', () => {
+    const signing = { trustedKeys: ['keys/team.pub'], required: true };
+    expect(validateProjectConfig({ signing })).toEqual({ signing });
+    expect(() => validateProjectConfig({ signing: { required: true } })).toThrow(
+      '"signing.trustedKeys" must be an array of strings'
+    );
+    expect(() =>
+      validateProjectConfig({ signing: { trustedKeys: [], required: 'yes' } })
+    ).toThrow('"signing.required" must be a boolean');
+  });
+
+  it('should check the roles', () => {
+    const roles = {
+      reader: { allowTools: ['fs.read*'], maxToolCalls: 50 },
+      operator: {
+        requireApproval: ['fs.write*'],
+        approvedCalls: [{ tool: 'fs.writeFile', input: { path: 'out/*' } }],
+        maxTimeout: 60000,
+        callers: ['*@ops.example'],
+      },
+    };
+    expect(validateProjectConfig({ roles })).toEqual({ roles });
+    expect(() => validateProjectConfig({ roles: [] })).toThrow(
+      '"roles" must be an object'
+    );
+    expect(() =>
+      validateProjectConfig({ roles: { reader: { allowTools: 'fs.*' } } })
+    ).toThrow('"roles.reader.allowTools" must be an array of strings');
+    expect(() =>
+      validateProjectConfig({ roles: { reader: { maxToolCalls: 0 } } })
+    ).toThrow('"roles.reader.maxToolCalls" must be a positive integer');
+    expect(() =>
+      validateProjectConfig({ roles: { operator: { callers: 'alice' } } })
+    ).toThrow('"roles.operator.callers" must be an array of strings');
+    expect(() =>
+      validateProjectConfig({
+        roles: { operator: { approvedCalls: [{ input: {} }] } },
+      })
+    ).toThrow(
+      '"roles.operator.approvedCalls[0]" must be an object with a tool pattern and optional input'
+    );
+  });
+
+  it('should check the secret providers', () => {
+    const secrets = ['env', { file: '/run/secrets' }, { command: ['pass'] }];
+    expect(validateProjectConfig({ secrets })).toEqual({ secrets });
+    expect(() => validateProjectConfig({ secrets: 'env' })).toThrow(
+      '"secrets" must be an array'
+    );
+    expect(() =>
+      validateProjectConfig({ secrets: ['env', { command: [] }] })
+    ).toThrow('"secrets[1]" must be "env", { "file": <directory> } or');
+  });
+
+  it('should check the project metadata', () => {
+    const project = { name: 'triage-bot', version: '1.4.0' };
+    expect(validateProjectConfig({ project })).toEqual({ project });
+    expect(() => validateProjectConfig({ project: ['triage-bot'] })).toThrow(
+      '"project" must be an object'
+    );
+    expect(() => validateProjectConfig({ project: { version: 1.4 } })).toThrow(
+      '"project.version" must be a string'
+    );
+  });
+
+  it('should check the checkpoint store', () => {
+    const checkpoints = { store: 'sqlite', path: 'state/runs.db' };
+    expect(validateProjectConfig({ checkpoints })).toEqual({ checkpoints });
+    expect(() =>
+      validateProjectConfig({ checkpoints: { store: 'redis' } })
+    ).toThrow('"checkpoints.store" must be "file" or "sqlite"');
+  });
+
+  it('should check the storage backend', () => {
+    const storage = { backend: 's3', bucket: 'runs', region: 'eu-west-1' };
+    expect(validateProjectConfig({ storage })).toEqual({ storage });
+    expect(() =>
+      validateProjectConfig({ storage: { backend: 'redis' } })
+    ).toThrow('"storage.backend" must be one of file, sqlite, s3, postgres');
+    expect(() => validateProjectConfig({ storage: { backend: 's3' } })).toThrow(
+      '"storage.bucket" must be a string'
+    );
+    expect(() =>
+      validateProjectConfig({ storage: { backend: 'postgres', url: 5 } })
+    ).toThrow('"storage.url" must be a string');
+  });
+
+  it('should check the embedding model and vector index', () => {
+    const config = {
+      embeddings: { provider: 'openai', model: 'text-embedding-3-small' },
+      vectors: { path: 'data/vectors' },
+    };
+    expect(validateProjectConfig(config)).toEqual(config);
+    expect(() =>
+      validateProjectConfig({ embeddings: { model: 'nomic-embed-text' } })
+    ).toThrow('"embeddings.provider" must be a string');
+    expect(() =>
+      validateProjectConfig({
+        embeddings: { provider: 'openai', dimensions: 0 },
+      })
+    ).toThrow('"embeddings.dimensions" must be a positive integer');
+  });
+
+  it('should check when tool results are spilled', () => {
+    const spill = { threshold: 16777216, dir: 'tmp' };
+    expect(validateProjectConfig({ spill })).toEqual({ spill });
+    expect(() => validateProjectConfig({ spill: { dir: 'tmp' } })).toThrow(
+      '"spill.threshold" must be a positive integer'
+    );
+    expect(() =>
+      validateProjectConfig({ spill: { threshold: 1024, dir: 1 } })
+    ).toThrow('"spill.dir" must be a string');
+  });
+
+  it('should check the approval webhook', () => {
+    const approvals = { webhook: 'https://example.com/approve', timeout: 600 };
+    expect(validateProjectConfig({ approvals })).toEqual({ approvals });
+    expect(() =>
+      validateProjectConfig({ approvals: { webhook: 'approve' } })
+    ).toThrow('"approvals.webhook" must be a URL');
+    expect(() =>
+      validateProjectConfig({
+        approvals: { webhook: 'https://example.com', timeout: -1 },
+      })
+    ).toThrow('"approvals.timeout" must be a positive integer');
+  });
+
+  it('should check the exit codes', () => {
+    const exitCodes = { errorResults: 3, partialFailures: 0, lintWarnings: 1 };
+    expect(validateProjectConfig({ exitCodes })).toEqual({ exitCodes });
+    expect(() =>
+      validateProjectConfig({ exitCodes: { partialFailures: 256 } })
+    ).toThrow('"exitCodes.partialFailures" must be an integer from 0 to 255');
+  });
+
+  it('should check the locale', () => {
+    expect(validateProjectConfig({ locale: 'de-DE' })).toEqual({
+      locale: 'de-DE',
+    });
+    expect(() => validateProjectConfig({ locale: 'not a locale' })).toThrow(
+      '"locale" must be a locale such as "de-DE"'
+    );
+  });
+
+  it('should check the message settings', () => {
+    const messages = { language: 'fr', catalogs: { fr: 'messages/fr.json' } };
+    expect(validateProjectConfig({ messages })).toEqual({ messages });
+    expect(() => validateProjectConfig({ messages: 'fr' })).toThrow(
+      '"messages" must be an object'
+    );
+    expect(() =>
+      validateProjectConfig({ messages: { catalogs: { fr: 1 } } })
+    ).toThrow('"messages.catalogs.fr" must be a string');
+  });
+});
@@ -0,0 +1,202 @@
+// Tests for the MCP Script debug adapter
+import { describe, it, expect, beforeEach } from 'vitest';
+import { PassThrough } from 'stream';
+import type { ScriptDebugger } from '@mcpscript/runtime';
+import { DebugAdapter, type ScriptLauncher } from '../../debug/adapter.js';
+import { Connection } from '../../lsp/transport.js';
+import type {
+  DebugMessage,
+  EventMessage,
+  ResponseMessage,
+} from '../../debug/protocol.js';
+
+/**
+ * Stands in for a compiled script: hooks as generated for
+ *
+ *   1  x = 2
+ *   2  y = square(x)
+ *   3  (in square) return n * n
+ *   4  print(y)
+ */
+async function script(
+  debug: ScriptDebugger,
+  output: (text: string, category: 'stdout' | 'stderr') => void
+): Promise<number> {
+  const square = (n: number) =>
+    debug.call('square', async () => {
+      await debug.before(3, 3, { n: () => n });
+      return n * n;
+    });
+  await debug.before(1, 1, {});
+  const x = 2;
+  await debug.before(2, 1, { x: () => x });
+  const y = await square(x);
+  await debug.before(4, 1, { x: () => x, y: () => y });
+  output(String(y), 'stdout');
+  return 0;
+}
+
+describe('DebugAdapter', () => {
+  let adapter: DebugAdapter;
+  let sent: DebugMessage[];
+  let exitCode: number | undefined;
+  let seq: number;
+  let finished: Promise<void>;
+
+  const launcher: ScriptLauncher = (program, debug, output) => {
+    const run = script(debug, output);
+    finished = run.then(() => new Promise(resolve => setImmediate(resolve)));
+    return run;
+  };
+
+  const request = (command: string, args?: unknown): ResponseMessage => {
+    const id = seq++;
+    adapter.handleMessage({
+      seq: id,
+      type: 'request',
+      command,
+      arguments: args,
+    });
+    return sent.find(
+      m => m.type === 'response' && m.request_seq === id
+    ) as ResponseMessage;
+  };
+
+  const events = (name: string) =>
+    sent.filter(
+      (m): m is EventMessage => m.type === 'event' && m.event === name
+    );
+
+  const reasons = () =>
+    events('stopped').map(e => (e.body as { reason: string }).reason);
+
+  /** Wait for the script to pause or finish */
+  const settle = () => new Promise(resolve => setImmediate(resolve));
+
+  const startSession = async (breakpoints: number[], stopOnEntry = false) => {
+    request('initialize', { adapterID: 'mcps' });
+    request('launch', { program: '/work/square.mcps', stopOnEntry });
+    request('setBreakpoints', {
+      source: { path: '/work/square.mcps' },
+      breakpoints: breakpoints.map(line => ({ line })),
+    });
+    request('configurationDone');
+    await settle();
+  };
+
+  beforeEach(() => {
+    sent = [];
+    exitCode = undefined;
+    seq = 1;
+    const output = new PassThrough();
+    const connection = new Connection<DebugMessage>(new PassThrough(), output);
+    connection.send = message => void sent.push(message);
+    adapter = new DebugAdapter(connection, launcher, code => {
+      exitCode = code;
+    });
+  });
+
+  it('should send the initialized event after the initialize response', () => {
+    const response = request('initialize', { adapterID: 'mcps' });
+    expect(response.success).toBe(true);
+    expect(response.body).toMatchObject({
+      supportsConfigurationDoneRequest: true,
+    });
+    expect(sent[sent.length - 1]).toMatchObject({
+      type: 'event',
+      event: 'initialized',
+    });
+  });
+
+  it('should reject programs that are not scripts', () => {
+    const response = request('launch', { program: 'square.js' });
+    expect(response.success).toBe(false);
+    expect(response.message).toBe('program must be the path of a .mcps file');
+  });
+
+  it('should pause at breakpoints and show the stack and locals', async () => {
+    await startSession([3]);
+    expect(events('stopped').map(e => e.body)).toEqual([
+      { reason: 'breakpoint', threadId: 1, allThreadsStopped: true },
+    ]);
+
+    const trace = request('stackTrace', { threadId: 1 }).body as {
+      stackFrames: { name: string; line: number; source: { name: string } }[];
+    };
+    expect(trace.stackFrames.map(f => [f.name, f.line])).toEqual([
+      ['square', 3],
+      ['<script>', 2],
+    ]);
+    expect(trace.stackFrames[0].source.name).toBe('square.mcps');
+
+    const { scopes } = request('scopes', { frameId: 0 }).body as {
+      scopes: { name: string; variablesReference: number }[];
+    };
+    expect(scopes.map(s => s.name)).toEqual(['Locals', 'Pending Tool Calls']);
+    const locals = request('variables', {
+      variablesReference: scopes[0].variablesReference,
+    }).body;
+    expect(locals).toEqual({
+      variables: [{ name: 'n', value: '2', variablesReference: 0 }],
+    });
+
+    request('continue', { threadId: 1 });
+    await finished;
+    expect(events('output').map(e => e.body)).toEqual([
+      { category: 'stdout', output: '4\n' },
+    ]);
+    expect(events('exited').map(e => e.body)).toEqual([{ exitCode: 0 }]);
+    expect(events('terminated')).toHaveLength(1);
+  });
+
+  it('should step over tool calls and show what they returned', async () => {
+    await startSession([2]);
+    request('next', { threadId: 1 });
+    await settle();
+
+    expect(reasons()).toEqual(['breakpoint', 'step']);
+    const { scopes } = request('scopes', { frameId: 0 }).body as {
+      scopes: { variablesReference: number }[];
+    };
+    const { variables } = request('variables', {
+      variablesReference: scopes[0].variablesReference,
+    }).body as { variables: { name: string; value: string }[] };
+    expect(variables.map(v => [v.name, v.value])).toEqual([
+      ['square returned', '4'],
+      ['x', '2'],
+      ['y', '4'],
+    ]);
+
+    request('continue', { threadId: 1 });
+    await finished;
+  });
+
+  it('should stop on entry and step into tools', async () => {
+    await startSession([], true);
+    for (let i = 0; i < 3; i++) {
+      request('stepIn', { threadId: 1 });
+      await settle();
+    }
+    expect(reasons()).toEqual(['entry', 'step', 'step', 'step']);
+    const trace = request('stackTrace', { threadId: 1 }).body as {
+      stackFrames: { line: number }[];
+    };
+    expect(trace.stackFrames.map(f => f.line)).toEqual([4]);
+
+    request('continue', { threadId: 1 });
+    await finished;
+  });
+
+  it('should report unsupported requests', () => {
+    const response = request('evaluate', { expression: 'x' });
+    expect(response.success).toBe(false);
+    expect(response.message).toBe('Unsupported request: evaluate');
+  });
+
+  it('should exit after a disconnect request', async () => {
+    request('initialize');
+    request('disconnect');
+    await settle();
+    expect(exitCode).toBe(0);
+  });
+});
@@ -0,0 +1,139 @@
+import { describe, it, expect } from 'vitest';
+import type { ScriptDocs } from '@mcpscript/transpiler';
+import {
+  docBlocks,
+  indexBlocks,
+  renderHtml,
+  renderMarkdown,
+} from '../docgen.js';
+
+const docs: ScriptDocs = {
+  doc: 'Daily report\n\nSummarizes <open> issues.',
+  servers: [
+    {
+      name: 'gh',
+      doc: 'Issues and pull requests',
+      target: 'gh-server',
+      tools: ['listIssues'],
+    },
+  ],
+  models: [{ name: 'gpt', provider: 'openai', model: 'gpt-4o' }],
+  agents: [
+    {
+      name: 'Lead',
+      model: 'gpt',
+      delegates: ['Writer'],
+      tools: ['fetch'],
+      mcpTools: ['gh.listIssues'],
+    },
+  ],
+  tools: [
+    {
+      name: 'fetch',
+      doc: 'Fetch the open issues',
+      signature: 'fetch(repo: string, state?: string | null): string',
+      parameters: [
+        { name: 'repo', optional: false, type: 'string' },
+        { name: 'state', optional: true, type: 'string | null' },
+      ],
+      returnType: 'string',
+      mcpTools: ['gh.listIssues'],
+    },
+  ],
+  mcpTools: ['gh.listIssues'],
+};
+
+describe('renderMarkdown', () => {
+  it('should document a script section by section', () => {
+    expect(renderMarkdown(docBlocks('/work/report.mcps', docs))).toBe(
+      [
+        '# report.mcps',
+        '',
+        'Daily report',
+        '',
+        'Summarizes <open> issues.',
+        '',
+        '- MCP tools: `gh.listIssues`',
+        '',
+        '## Tools',
+        '',
+        '### `fetch(repo: string, state?: string | null): string`',
+        '',
+        'Fetch the open issues',
+        '',
+        '| Parameter | Type | Required |',
+        '| --- | --- | --- |',
+        '| `repo` | `string` | yes |',
+        '| `state` | `string \\| null` | no |',
+        '',
+        '- Returns: `string`',
+        '- MCP tools: `gh.listIssues`',
+        '',
+        '## Agents',
+        '',
+        '### Lead',
+        '',
+        '- Model: `gpt`',
+        '- Delegates to: `Writer`',
+        '- Tools: `fetch`',
+        '- MCP tools: `gh.listIssues`',
+        '',
+        '## MCP Servers',
+        '',
+        '### gh',
+        '',
+        'Issues and pull requests',
+        '',
+        '- Server: `gh-server`',
+        '- Tools used: `listIssues`',
+        '',
+        '## Models',
+        '',
+        '### gpt',
+        '',
+        '- Provider: `openai`',
+        '- Model: `gpt-4o`',
+        '',
+      ].join('\n')
+    );
+  });
+
+  it('should leave out empty sections', () => {
+    const empty = { servers: [], models: [], agents: [], tools: [] };
+    expect(
+      renderMarkdown(docBlocks('empty.mcps', { ...empty, mcpTools: [] }))
+    ).toBe('# empty.mcps\n\n- MCP tools: none\n');
+  });
+
+  it('should link the pages of an index', () => {
+    const blocks = indexBlocks([
+      { file: '/work/report.mcps', link: 'report.md', docs },
+    ]);
+    expect(renderMarkdown(blocks)).toBe(
+      '# Scripts\n\n- [report.mcps](report.md): Daily report\n'
+    );
+  });
+});
+
+describe('renderHtml', () => {
+  it('should escape text and mark code', () => {
+    const html = renderHtml('report.mcps', docBlocks('report.mcps', docs));
+    expect(html).toContain('<title>report.mcps</title>');
+    expect(html).toContain(
+      '<p>Daily report</p>\n<p>Summarizes &lt;open&gt; issues.</p>'
+    );
+    expect(html).toContain('<li>MCP tools: <code>gh.listIssues</code></li>');
+    expect(html).toContain(
+      '<tr><td><code>state</code></td><td><code>string | null</code></td><td>no</td></tr>'
+    );
+  });
+
+  it('should link the pages of an index', () => {
+    const blocks = indexBlocks([
+      { file: 'report.mcps', link: 'report.html', docs },
+    ]);
+    expect(renderHtml('Scripts', blocks)).toContain(
+      '<li><a href="report.html">report.mcps</a>: Daily report</li>'
+    );
+  });
+});
@@ -0,0 +1,106 @@
+// End-to-end tests for bench command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, readFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_bench');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+describe('Bench Command', () => {
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should run the internal benchmarks and save a baseline', async () => {
+    const baselinePath = join(TEST_DIR, 'baseline.json');
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'bench',
+      '--internal',
+      '--lines',
+      '300',
+      '--time',
+      '0',
+      '--format',
+      'json',
+      '--save',
+      baselinePath,
+    ]);
+
+    const names = JSON.parse(stdout).results.map(
+      (r: { name: string }) => r.name
+    );
+    expect(names).toContain('BenchmarkParseLarge');
+    expect(names).toContain('BenchmarkQueryHighlights');
+    const saved = JSON.parse(await readFile(baselinePath, 'utf-8'));
+    expect(saved.results).toHaveLength(names.length);
+  });
+
+  it('should benchmark a script file', async () => {
+    const scriptPath = join(TEST_DIR, 'script.mcps');
+    await writeFile(scriptPath, 'x = 1\nprint(x)\n', 'utf-8');
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'bench',
+      '--time',
+      '0',
+      scriptPath,
+    ]);
+
+    expect(stdout).toMatch(/^BenchmarkParseLarge +\d+ runs {2}mean/m);
+  });
+
+  it('should fail when a benchmark regressed', async () => {
+    const baselinePath = join(TEST_DIR, 'fast.json');
+    await writeFile(
+      baselinePath,
+      JSON.stringify({
+        results: [
+          {
+            name: 'BenchmarkParseLarge',
+            iterations: 1,
+            meanMs: 1e-9,
+            minMs: 1e-9,
+            p95Ms: 1e-9,
+          },
+        ],
+      })
+    );
+
+    const result = execFileAsync('node', [
+      CLI_PATH,
+      'bench',
+      '--internal',
+      '--lines',
+      '300',
+      '--time',
+      '0',
+      '--baseline',
+      baselinePath,
+    ]);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('BenchmarkParseLarge regressed'),
+    });
+  });
+
+  it('should require a file or --internal', async () => {
+    const result = execFileAsync('node', [CLI_PATH, 'bench']);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('Pass either a file or --internal'),
+    });
+  });
+});
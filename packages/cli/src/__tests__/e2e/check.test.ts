@@ -0,0 +1,147 @@
+// End-to-end tests for check command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_check');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+const CACHE_DIR = join(TEST_DIR, 'cache');
+
+function check(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'check', ...args], {
+    env: { ...process.env, MCPS_CACHE_DIR: CACHE_DIR },
+  });
+}
+
+describe('Check Command', () => {
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print warnings without failing', async () => {
+    const scriptPath = join(TEST_DIR, 'unused.mcps');
+    await writeFile(scriptPath, 'x = 1\nprint("done")\n', 'utf-8');
+
+    const { stdout } = await check(scriptPath);
+
+    expect(stdout).toBe(
+      `${scriptPath}:1:1: warning: 'x' is assigned but never used (unused-variable)\n`
+    );
+  });
+
+  it('should fail on errors and report them as JSON', async () => {
+    const scriptPath = join(TEST_DIR, 'errors.mcps');
+    await writeFile(scriptPath, 'print(missing)\n', 'utf-8');
+
+    const result = check('--format', 'json', scriptPath);
+
+    await expect(result).rejects.toMatchObject({ code: 1 });
+    const { stdout } = await result.catch(error => error);
+    expect(JSON.parse(stdout)).toMatchObject({
+      files: [
+        {
+          path: scriptPath,
+          diagnostics: [{ code: 'undefined-variable', severity: 1 }],
+        },
+      ],
+    });
+  });
+
+  it('should apply rule settings and plugins from .mcpsrc', async () => {
+    const dir = join(TEST_DIR, 'project');
+    await mkdir(dir, { recursive: true });
+    await writeFile(
+      join(dir, '.mcpsrc'),
+      JSON.stringify({
+        lint: { rules: { 'unused-variable': 'off' }, plugins: ['rules.mjs'] },
+      }),
+      'utf-8'
+    );
+    await writeFile(
+      join(dir, 'rules.mjs'),
+      `export const rules = [{
+  name: 'no-print',
+  description: 'Calls to print',
+  severity: 'error',
+  check({ tree, report }) {
+    for (const call of tree.rootNode.descendantsOfType('call_expression')) {
+      if (call.firstNamedChild.text === 'print') report(call, 'No print');
+    }
+  },
+}];
+`,
+      'utf-8'
+    );
+    await writeFile(join(dir, 'a.mcps'), 'x = 1\nprint("hi")\n', 'utf-8');
+
+    const result = check(dir);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stdout: `${join(dir, 'a.mcps')}:2:1: error: No print (no-print)\n`,
+    });
+  });
+
+  it('should reuse results until a file or its imports change', async () => {
+    const dir = join(TEST_DIR, 'cached');
+    await mkdir(dir, { recursive: true });
+    const scriptPath = join(dir, 'main.mcps');
+    const libPath = join(dir, 'lib.mcps');
+    await writeFile(scriptPath, 'import "./lib"\nprint(twice(2))\n', 'utf-8');
+    await writeFile(libPath, 'tool twice(x) {\n  return x * 2\n}\n', 'utf-8');
+
+    expect((await check(scriptPath)).stdout).toBe('');
+    expect((await check(scriptPath)).stdout).toBe('');
+
+    await writeFile(libPath, 'tool half(x) {\n  return x / 2\n}\n', 'utf-8');
+    await expect(check(scriptPath)).rejects.toMatchObject({
+      stdout: expect.stringContaining('undefined-variable'),
+    });
+  });
+
+  it('should report variables and secrets servers reference that have no value', async () => {
+    const dir = join(TEST_DIR, 'secrets');
+    await mkdir(join(dir, 'secrets'), { recursive: true });
+    await writeFile(
+      join(dir, '.mcpsrc'),
+      JSON.stringify({ secrets: [{ file: 'secrets' }] }),
+      'utf-8'
+    );
+    await writeFile(join(dir, 'secrets', 'TOKEN'), 'tok-12345\n', 'utf-8');
+    const scriptPath = join(dir, 'main.mcps');
+    await writeFile(
+      scriptPath,
+      `mcp github {
+  command: "\${MCPS_CHECK_HOME}/github-mcp",
+  env: { TOKEN: "\${secret:TOKEN}", OTHER: "\${secret:OTHER}" },
+  args: ["\${MCPS_CHECK_MISSING}"]
+}
+`,
+      'utf-8'
+    );
+
+    const run = () =>
+      execFileAsync('node', [CLI_PATH, 'check', scriptPath], {
+        env: {
+          ...process.env,
+          MCPS_CACHE_DIR: CACHE_DIR,
+          MCPS_CHECK_HOME: '/opt',
+        },
+      });
+    const expected =
+      `${scriptPath}:3:43: error: Secret OTHER was not found in file ${join(dir, 'secrets')} (missing-secret)\n` +
+      `${scriptPath}:4:10: error: Environment variable MCPS_CHECK_MISSING is not set (missing-variable)\n`;
+
+    // Cached results are checked against the environment again
+    await expect(run()).rejects.toMatchObject({ code: 1, stdout: expected });
+    await expect(run()).rejects.toMatchObject({ code: 1, stdout: expected });
+  });
+});
@@ -54,7 +54,7 @@ print(x)`;
 
     expect(stdout).toContain('// Initialize model configurations');
     expect(stdout).toContain('const __models = {};');
-    expect(stdout).toContain('const myModel = new __llamaindex_OpenAI');
+    expect(stdout).toContain('const myModel = __createModel({ provider: "openai"');
   });
 
   it('should error on non-.mcps files', async () => {
@@ -66,6 +66,16 @@ print(x)`;
     ).rejects.toThrow();
   });
 
+  it('should report every syntax error with its position', async () => {
+    const scriptPath = join(TEST_DIR, 'broken.mcps');
+    await writeFile(scriptPath, 'x = 1 $ 2\nprint(x)\ny = 3 $ 4', 'utf-8');
+
+    const report = /Syntax errors in .*broken\.mcps:\n1:\d+: .*\n3:\d+: /;
+    await expect(
+      execFileAsync('node', [CLI_PATH, 'compile', scriptPath])
+    ).rejects.toMatchObject({ stderr: expect.stringMatching(report) });
+  });
+
   it('should compile complex expressions', async () => {
     const scriptPath = join(TEST_DIR, 'expressions.mcps');
     const script = `result = (5 + 3) * 2
@@ -0,0 +1,81 @@
+// End-to-end tests for diff command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_diff');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const OLD_SCRIPT = `mcp fs { command: "npx" }
+
+text = fs.read("notes.txt")
+print(text)
+`;
+
+const NEW_SCRIPT = `mcp fs { command: "npx" }
+mcp db { command: "uvx" }
+
+text = db.read("notes.txt")
+`;
+
+function diff(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'diff', ...args]);
+}
+
+describe('Diff Command', () => {
+  const oldPath = join(TEST_DIR, 'old.mcps');
+  const newPath = join(TEST_DIR, 'new.mcps');
+
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+    await writeFile(oldPath, OLD_SCRIPT, 'utf-8');
+    await writeFile(newPath, NEW_SCRIPT, 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print the changes with their locations', async () => {
+    const { stdout } = await diff(oldPath, newPath);
+
+    expect(stdout).toBe(
+      `${newPath}:2:1: + Added mcp db\n` +
+        `${newPath}:4:8: > Retargeted fs.read(...) to db.read(...)\n` +
+        `${oldPath}:4:1: - Removed print(text)\n`
+    );
+  });
+
+  it('should print the changes as JSON', async () => {
+    const { stdout } = await diff('--format', 'json', oldPath, newPath);
+
+    expect(JSON.parse(stdout)).toMatchObject({
+      old: oldPath,
+      new: newPath,
+      changes: [
+        { kind: 'added', message: 'Added mcp db' },
+        { kind: 'retargeted', before: { start: { line: 3, column: 8 } } },
+        { kind: 'removed', message: 'Removed print(text)' },
+      ],
+    });
+  });
+
+  it('should print nothing for the same script', async () => {
+    const { stdout } = await diff(oldPath, oldPath);
+    expect(stdout).toBe('');
+  });
+
+  it('should fail on syntax errors', async () => {
+    const brokenPath = join(TEST_DIR, 'broken.mcps');
+    await writeFile(brokenPath, 'x = (', 'utf-8');
+
+    await expect(diff(oldPath, brokenPath)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining(`Syntax errors in ${brokenPath}`),
+    });
+  });
+});
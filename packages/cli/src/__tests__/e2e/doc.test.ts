@@ -0,0 +1,93 @@
+// End-to-end tests for doc command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, readFile, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_doc');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const SERVERS_MODULE = `// GitHub issues
+mcp gh { command: "gh-mcp" }
+`;
+
+const SCRIPT = `// Triage
+//
+// Labels the open issues of a repository.
+
+import "./servers.mcps"
+
+model claude { provider: "anthropic", model: "claude-sonnet-4-5" }
+
+// Labels issues it is given
+agent Labeler { model: claude, tools: [gh.addLabels] }
+
+// Fetch the open issues of a repository
+tool openIssues(repo: string): any[] {
+  return gh.listIssues(repo)
+}
+
+issues = openIssues("mcpscript/mcpscript")
+result = "Label these: " + issues | Labeler
+`;
+
+function doc(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'doc', ...args]);
+}
+
+describe('Doc Command', () => {
+  const scriptPath = join(TEST_DIR, 'triage.mcps');
+
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+    await writeFile(join(TEST_DIR, 'servers.mcps'), SERVERS_MODULE, 'utf-8');
+    await writeFile(scriptPath, SCRIPT, 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print Markdown documentation of a script', async () => {
+    const { stdout } = await doc(scriptPath);
+
+    expect(stdout).toContain(
+      '# triage.mcps\n\nTriage\n\nLabels the open issues of a repository.\n\n' +
+        '- MCP tools: `gh.addLabels`, `gh.listIssues`\n'
+    );
+    expect(stdout).toContain(
+      '### `openIssues(repo: string): any[]`\n\n' +
+        'Fetch the open issues of a repository\n'
+    );
+    expect(stdout).toContain(
+      '### Labeler\n\nLabels issues it is given\n\n' +
+        '- Model: `claude`\n- MCP tools: `gh.addLabels`\n'
+    );
+  });
+
+  it('should write a page per script and an index', async () => {
+    const output = join(TEST_DIR, 'docs');
+    const { stdout } = await doc('--format', 'html', '-o', output, TEST_DIR);
+
+    expect(stdout).toContain(`Wrote ${join(output, 'index.html')}`);
+    const index = await readFile(join(output, 'index.html'), 'utf-8');
+    expect(index).toContain(
+      '<li><a href="servers.html">servers.mcps</a></li>'
+    );
+    expect(index).toContain(
+      '<li><a href="triage.html">triage.mcps</a>: Triage</li>'
+    );
+    const page = await readFile(join(output, 'servers.html'), 'utf-8');
+    expect(page).toContain('<h3>gh</h3>\n<p>GitHub issues</p>');
+  });
+
+  it('should reject unknown formats', async () => {
+    await expect(doc('--format', 'pdf', scriptPath)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('format must be "markdown" or "html"'),
+    });
+  });
+});
@@ -0,0 +1,137 @@
+// End-to-end tests for export command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_export');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const SERVERS_MODULE = `mcp gh {
+  command: "gh-mcp",
+  env: { TOKEN: env.GITHUB_TOKEN }
+}
+`;
+
+const SCRIPT = `import "./servers.mcps"
+
+mcp fs {
+  command: "npx",
+  args: ["-y", "@modelcontextprotocol/server-filesystem", "./data"]
+}
+
+model claude { provider: "anthropic", apiKey: env.ANTHROPIC_API_KEY }
+
+agent Triage { model: claude, tools: [gh] }
+
+notes = fs.readFile("./data/notes.txt")
+issues = gh.listIssues("https://github.com/mcpscript/mcpscript")
+`;
+
+function exportCapabilities(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'export', 'capabilities', ...args]);
+}
+
+describe('Export Command', () => {
+  const scriptPath = join(TEST_DIR, 'triage.mcps');
+
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+    await writeFile(join(TEST_DIR, 'servers.mcps'), SERVERS_MODULE, 'utf-8');
+    await writeFile(scriptPath, SCRIPT, 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print the capabilities of a script and its imports as JSON', async () => {
+    const { stdout } = await exportCapabilities(scriptPath);
+
+    expect(JSON.parse(stdout)).toEqual({
+      file: scriptPath,
+      servers: [
+        {
+          name: 'gh',
+          transport: 'stdio',
+          command: 'gh-mcp',
+          env: ['TOKEN'],
+        },
+        {
+          name: 'fs',
+          transport: 'stdio',
+          command: 'npx',
+          args: ['-y', '@modelcontextprotocol/server-filesystem', './data'],
+        },
+      ],
+      tools: [
+        { server: 'fs', tool: 'readFile', callers: ['script'] },
+        { server: 'gh', tool: '*', callers: ['agent Triage'] },
+        { server: 'gh', tool: 'listIssues', callers: ['script'] },
+      ],
+      models: [{ name: 'claude', provider: 'anthropic' }],
+      filesystemRoots: ['./data'],
+      networkEndpoints: ['https://github.com/mcpscript/mcpscript'],
+      secrets: [
+        { name: 'ANTHROPIC_API_KEY', usedBy: ['model claude'] },
+        { name: 'GITHUB_TOKEN', usedBy: ['mcp gh'] },
+      ],
+    });
+  });
+
+  it('should print the capabilities as text', async () => {
+    const { stdout } = await exportCapabilities('--format', 'text', scriptPath);
+
+    expect(stdout).toContain(
+      'Servers:\n  gh (stdio): gh-mcp\n' +
+        '  fs (stdio): npx -y @modelcontextprotocol/server-filesystem ./data\n'
+    );
+    expect(stdout).toContain('Filesystem roots:\n  ./data\n');
+    expect(stdout).toContain(
+      'Secrets:\n  ANTHROPIC_API_KEY (model claude)\n  GITHUB_TOKEN (mcp gh)\n'
+    );
+  });
+
+  it('should list the locked tools of servers given to agents', async () => {
+    const lockPath = join(TEST_DIR, 'mcps-tools.lock.json');
+    const lock = {
+      version: 1,
+      servers: {
+        gh: [
+          { name: 'createIssue', inputSchema: { type: 'object' } },
+          { name: 'listIssues', inputSchema: { type: 'object' } },
+        ],
+      },
+    };
+    await writeFile(lockPath, JSON.stringify(lock), 'utf-8');
+
+    try {
+      const { stdout } = await exportCapabilities(scriptPath);
+
+      expect(JSON.parse(stdout).tools).toEqual([
+        { server: 'fs', tool: 'readFile', callers: ['script'] },
+        { server: 'gh', tool: 'createIssue', callers: ['agent Triage'] },
+        {
+          server: 'gh',
+          tool: 'listIssues',
+          callers: ['agent Triage', 'script'],
+        },
+      ]);
+    } finally {
+      await rm(lockPath, { force: true });
+    }
+  });
+
+  it('should fail on syntax errors', async () => {
+    const brokenPath = join(TEST_DIR, 'broken.mcps');
+    await writeFile(brokenPath, 'x = (', 'utf-8');
+
+    await expect(exportCapabilities(brokenPath)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining(`Syntax errors in ${brokenPath}`),
+    });
+  });
+});
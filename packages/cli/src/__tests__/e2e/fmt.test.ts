@@ -0,0 +1,100 @@
+// End-to-end tests for fmt command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, readFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_fmt');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const UNFORMATTED = `mcp filesystem {command:"npx",args:["-y","server"]}
+x=1+2
+`;
+
+const FORMATTED = `mcp filesystem {
+  command: "npx",
+  args: ["-y", "server"],
+}
+x = 1 + 2
+`;
+
+describe('Fmt Command', () => {
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print the formatted source', async () => {
+    const scriptPath = join(TEST_DIR, 'print.mcps');
+    await writeFile(scriptPath, UNFORMATTED, 'utf-8');
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'fmt',
+      scriptPath,
+    ]);
+
+    expect(stdout).toBe(FORMATTED);
+    expect(await readFile(scriptPath, 'utf-8')).toBe(UNFORMATTED);
+  });
+
+  it('should write the formatted source with -w', async () => {
+    const scriptPath = join(TEST_DIR, 'write.mcps');
+    await writeFile(scriptPath, UNFORMATTED, 'utf-8');
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'fmt',
+      '-w',
+      scriptPath,
+    ]);
+
+    expect(stdout).toBe('');
+    expect(await readFile(scriptPath, 'utf-8')).toBe(FORMATTED);
+  });
+
+  it('should print a diff and fail with -d', async () => {
+    const scriptPath = join(TEST_DIR, 'diff.mcps');
+    await writeFile(scriptPath, UNFORMATTED, 'utf-8');
+
+    const result = execFileAsync('node', [CLI_PATH, 'fmt', '-d', scriptPath]);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stdout: expect.stringContaining('+x = 1 + 2'),
+    });
+  });
+
+  it('should pass -d for formatted files in a directory', async () => {
+    const dir = join(TEST_DIR, 'clean');
+    await mkdir(dir, { recursive: true });
+    await writeFile(join(dir, 'a.mcps'), FORMATTED, 'utf-8');
+    await writeFile(join(dir, 'notes.txt'), 'not a script', 'utf-8');
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'fmt',
+      '-d',
+      dir,
+    ]);
+
+    expect(stdout).toBe('');
+  });
+
+  it('should fail on syntax errors', async () => {
+    const scriptPath = join(TEST_DIR, 'broken.mcps');
+    await writeFile(scriptPath, 'x = (1 + 2', 'utf-8');
+
+    await expect(
+      execFileAsync('node', [CLI_PATH, 'fmt', scriptPath])
+    ).rejects.toMatchObject({
+      stderr: expect.stringContaining('Parse error'),
+    });
+  });
+});
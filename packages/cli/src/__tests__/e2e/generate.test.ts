@@ -0,0 +1,86 @@
+// End-to-end tests for generate command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_generate');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const SCRIPT = 'mcp filesystem {\n  command: "fs-server"\n}\n';
+
+describe('Generate Command', () => {
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should generate Go bindings from the lockfile', async () => {
+    const dir = join(TEST_DIR, 'locked');
+    await mkdir(dir, { recursive: true });
+    const scriptPath = join(dir, 'main.mcps');
+    await writeFile(scriptPath, SCRIPT, 'utf-8');
+    const lock = {
+      version: 1,
+      servers: {
+        filesystem: [
+          {
+            name: 'readFile',
+            inputSchema: {
+              type: 'object',
+              properties: { path: { type: 'string' } },
+              required: ['path'],
+            },
+          },
+        ],
+      },
+    };
+    await writeFile(join(dir, 'mcps-tools.lock.json'), JSON.stringify(lock));
+
+    const { stdout } = await execFileAsync('node', [
+      CLI_PATH,
+      'generate',
+      'go',
+      '--offline',
+      '--package',
+      'fsclient',
+      scriptPath,
+    ]);
+
+    expect(stdout).toContain('package fsclient\n');
+    expect(stdout).toContain('Path string `json:"path"`');
+    expect(stdout).toContain(
+      'func (c *FilesystemClient) ReadFile(ctx context.Context, input FilesystemReadFileInput) (json.RawMessage, error) {'
+    );
+  });
+
+  it('should fail offline when a server is not in the lockfile', async () => {
+    const dir = join(TEST_DIR, 'unlocked');
+    await mkdir(dir, { recursive: true });
+    const scriptPath = join(dir, 'main.mcps');
+    await writeFile(scriptPath, SCRIPT, 'utf-8');
+    await writeFile(
+      join(dir, 'mcps-tools.lock.json'),
+      JSON.stringify({ version: 1, servers: {} })
+    );
+
+    const result = execFileAsync('node', [
+      CLI_PATH,
+      'generate',
+      'go',
+      '--offline',
+      scriptPath,
+    ]);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('has no tools for filesystem'),
+    });
+  });
+});
@@ -0,0 +1,80 @@
+// End-to-end tests for highlight command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_highlight');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+function highlight(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'highlight', ...args]);
+}
+
+describe('Highlight Command', () => {
+  const scriptPath = join(TEST_DIR, 'count.mcps');
+  const source = 'count = 42 // <answer>\n';
+
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+    await writeFile(scriptPath, source, 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should color the script for a terminal', async () => {
+    const { stdout } = await highlight(scriptPath);
+
+    expect(stdout).toContain('\x1b[38;2;209;154;102m42\x1b[0m');
+    expect(stdout.replace(/\x1b\[[0-9;]*m/g, '')).toBe(source);
+  });
+
+  it('should print the script as HTML', async () => {
+    const { stdout } = await highlight('--format', 'html', scriptPath);
+
+    expect(stdout).toBe(
+      '<span class="tok-variable">count</span> ' +
+        '<span class="tok-operator">=</span> ' +
+        '<span class="tok-number">42</span> ' +
+        '<span class="tok-comment">// &lt;answer&gt;</span>\n'
+    );
+  });
+
+  it('should print a page with the stylesheet of a theme', async () => {
+    const { stdout } = await highlight(
+      '-f',
+      'html',
+      '--theme',
+      'light',
+      '--standalone',
+      scriptPath
+    );
+
+    expect(stdout).toMatch(/^<!DOCTYPE html>/);
+    expect(stdout).toContain('<title>count.mcps</title>');
+    expect(stdout).toContain('.mcps-highlight .tok-number { color: #0550ae; }');
+    expect(stdout).toContain(
+      '<pre class="mcps-highlight"><code><span class="tok-variable">count'
+    );
+  });
+
+  it('should reject unknown themes and formats', async () => {
+    await expect(
+      highlight('--theme', 'neon', scriptPath)
+    ).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining(
+        'Unknown theme "neon"; choose one of dark, light'
+      ),
+    });
+    await expect(highlight('-f', 'rtf', scriptPath)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('format must be "ansi" or "html"'),
+    });
+  });
+});
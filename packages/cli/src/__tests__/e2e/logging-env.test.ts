@@ -81,6 +81,29 @@ describe('E2E: Logging and Environment', () => {
         123
       );
     });
+
+    it('should redact logged values with the project rules', async () => {
+      const source = `credentials = { user: "alice", password: "hunter2" }
+log.info("Token sk-abc123 for", credentials)
+print(credentials.password)`;
+      const ast = parseSource(source);
+      const code = generateCode(ast);
+      const consoleLogSpy = vi
+        .spyOn(console, 'log')
+        .mockImplementation(() => {});
+      await executeInVM(code, {
+        redaction: { patterns: ['sk-\\w+'], keys: ['password'] },
+      });
+
+      expect(consoleInfoSpy).toHaveBeenCalledWith(
+        '[INFO]',
+        'Token [REDACTED] for',
+        { user: 'alice', password: '[REDACTED]' }
+      );
+      // Scripts still see the real values
+      expect(consoleLogSpy).toHaveBeenCalledWith('hunter2');
+      consoleLogSpy.mockRestore();
+    });
   });
 
   describe('Environment Variables', () => {
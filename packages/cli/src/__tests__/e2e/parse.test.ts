@@ -0,0 +1,74 @@
+// End-to-end tests for parse command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_parse');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+function parse(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'parse', ...args]);
+}
+
+describe('Parse Command', () => {
+  const scriptPath = join(TEST_DIR, 'count.mcps');
+
+  beforeAll(async () => {
+    await mkdir(TEST_DIR, { recursive: true });
+    await writeFile(scriptPath, 'count = 42\n', 'utf-8');
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should print the syntax tree as an S-expression', async () => {
+    const { stdout } = await parse(scriptPath);
+
+    expect(stdout).toContain('(source_file [0, 0] - [1, 0]\n');
+    expect(stdout).toContain('(identifier [0, 0] - [0, 5])');
+  });
+
+  it('should print the syntax tree as JSON', async () => {
+    const { stdout } = await parse('--json', scriptPath);
+
+    expect(JSON.parse(stdout)).toMatchObject({
+      type: 'source_file',
+      startByte: 0,
+      endByte: 11,
+      children: [{ type: 'statement' }],
+    });
+  });
+
+  it('should print the AST as JSON', async () => {
+    const { stdout } = await parse('--ast', scriptPath);
+
+    expect(JSON.parse(stdout)).toMatchObject([
+      {
+        type: 'assignment',
+        value: { type: 'number', value: 42 },
+        location: { start: { line: 1, column: 1 } },
+      },
+    ]);
+  });
+
+  it('should print trees with errors and fail', async () => {
+    const brokenPath = join(TEST_DIR, 'broken.mcps');
+    await writeFile(brokenPath, 'x = (\n', 'utf-8');
+
+    const result = parse(brokenPath);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stdout: expect.stringContaining('(source_file'),
+    });
+    await expect(parse('--ast', brokenPath)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining(`Syntax errors in ${brokenPath}`),
+    });
+  });
+});
@@ -0,0 +1,74 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource, generateCode } from '@mcpscript/transpiler';
+import { executeInVM, ScriptError } from '@mcpscript/runtime';
+import { formatScriptError } from '../../ui/script-error.js';
+
+const SOURCE = `tool lookup(record) {
+  return record.missing.field
+}
+
+x = 1
+lookup({})
+`;
+
+async function runScript(source: string): Promise<unknown> {
+  const ast = parseSource(source);
+  const code = generateCode(ast, { sourcePositions: true });
+  try {
+    await executeInVM(code, {
+      timeout: 5000,
+      sourceFile: 'lookup.mcps',
+      source,
+    });
+  } catch (error) {
+    return error;
+  }
+  throw new Error('Expected the script to fail');
+}
+
+describe('E2E - Stack Traces', () => {
+  it('should report runtime errors at script locations', async () => {
+    const error = await runScript(SOURCE);
+
+    expect(error).toBeInstanceOf(ScriptError);
+    const { scriptStack } = error as ScriptError;
+    expect(scriptStack[0]).toEqual({
+      tool: 'lookup',
+      file: 'lookup.mcps',
+      line: 2,
+      column: 3,
+      excerpt: '  return record.missing.field',
+    });
+    expect(scriptStack[scriptStack.length - 1]).toMatchObject({
+      tool: undefined,
+      line: 6,
+      column: 1,
+    });
+  });
+
+  it('should keep the original error type and message', async () => {
+    const error = (await runScript(SOURCE)) as ScriptError;
+
+    expect(error.name).toBe('TypeError');
+    expect(error.message).toContain("reading 'field'");
+    expect(error.stack).toContain('at lookup (lookup.mcps:2:3)');
+    expect(error.stack).not.toContain('mcps-generated.js');
+  });
+
+  it('should render the trace with source excerpts', async () => {
+    const error = (await runScript(SOURCE)) as ScriptError;
+
+    const lines = formatScriptError(error, false).split('\n');
+    expect(lines[0]).toBe(`TypeError: ${error.message}`);
+    expect(lines[1]).toBe('  at lookup (lookup.mcps:2:3)');
+    expect(lines[2]).toBe('    2 │   return record.missing.field');
+  });
+
+  it('should report errors in top-level code without a tool', async () => {
+    const error = (await runScript('x = null\ny = x.value\n')) as ScriptError;
+
+    expect(error.scriptStack).toEqual([
+      expect.objectContaining({ tool: undefined, line: 2, column: 1 }),
+    ]);
+  });
+});
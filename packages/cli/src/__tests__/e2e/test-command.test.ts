@@ -0,0 +1,179 @@
+// End-to-end tests for test command
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdir, writeFile, rm } from 'fs/promises';
+import { join } from 'path';
+import { execFile } from 'child_process';
+import { promisify } from 'util';
+
+const execFileAsync = promisify(execFile);
+
+const TEST_DIR = join(process.cwd(), 'tmp_e2e_test_test');
+const CLI_PATH = join(process.cwd(), 'bin', 'mcps.mjs');
+
+const WORKFLOW = `mcp fs { command: "fs-server-that-does-not-exist" }
+
+tool summarize(path) {
+  text = fs.readFile(path)
+  return "summary: " + text
+}
+`;
+
+const PASSING_TEST = `import "./workflow.mcps"
+
+mock("fs.readFile", "hello")
+assertEqual(summarize("notes.txt"), "summary: hello")
+assertEqual(mock.calls("fs.readFile"), [{ arg0: "notes.txt" }])
+
+mock.fail("fs.readFile", "File not found")
+assertEqual(assertThrows(summarize, "missing.txt"), "File not found")
+assert(mock.calls("fs.readFile")[1].arg0 == "missing.txt")
+`;
+
+const FAILING_TEST = `import "./workflow.mcps"
+
+mock("fs.readFile", "hello")
+print("checking the summary")
+assertEqual(summarize("notes.txt"), "summary: goodbye")
+`;
+
+function test(...args: string[]) {
+  return execFileAsync('node', [CLI_PATH, 'test', ...args]);
+}
+
+describe('Test Command', () => {
+  const passingDir = join(TEST_DIR, 'passing');
+  const failingDir = join(TEST_DIR, 'failing');
+
+  beforeAll(async () => {
+    await mkdir(passingDir, { recursive: true });
+    await mkdir(failingDir, { recursive: true });
+    for (const dir of [passingDir, failingDir]) {
+      await writeFile(join(dir, 'workflow.mcps'), WORKFLOW, 'utf-8');
+    }
+    await writeFile(
+      join(passingDir, 'workflow_test.mcps'),
+      PASSING_TEST,
+      'utf-8'
+    );
+    await writeFile(
+      join(failingDir, 'workflow_test.mcps'),
+      FAILING_TEST,
+      'utf-8'
+    );
+  });
+
+  afterAll(async () => {
+    await rm(TEST_DIR, { recursive: true, force: true });
+  });
+
+  it('should run test files with mocked servers', async () => {
+    const { stdout } = await test(passingDir);
+
+    expect(stdout).toMatch(
+      /^PASS .*passing\/workflow_test\.mcps \(\d+ ms\)\n/
+    );
+    expect(stdout).toContain('1 passed, 0 failed');
+  });
+
+  it('should name tool inputs after their locked schemas', async () => {
+    const lockedDir = join(TEST_DIR, 'locked');
+    await mkdir(lockedDir, { recursive: true });
+    await writeFile(join(lockedDir, 'workflow.mcps'), WORKFLOW, 'utf-8');
+    await writeFile(
+      join(lockedDir, 'mcps-tools.lock.json'),
+      JSON.stringify({
+        version: 1,
+        servers: {
+          fs: [
+            {
+              name: 'readFile',
+              inputSchema: {
+                type: 'object',
+                properties: { path: { type: 'string' } },
+              },
+            },
+          ],
+        },
+      }),
+      'utf-8'
+    );
+    await writeFile(
+      join(lockedDir, 'workflow_test.mcps'),
+      `import "./workflow.mcps"
+mock("fs.readFile", "hello")
+summarize("notes.txt")
+assertEqual(mock.calls("fs.readFile"), [{ path: "notes.txt" }])
+`,
+      'utf-8'
+    );
+
+    const { stdout } = await test(lockedDir);
+    expect(stdout).toContain('1 passed, 0 failed');
+  });
+
+  it('should run test cases with their hooks and fixtures', async () => {
+    const casesDir = join(TEST_DIR, 'cases');
+    await mkdir(casesDir, { recursive: true });
+    await writeFile(join(casesDir, 'workflow.mcps'), WORKFLOW, 'utf-8');
+    await writeFile(
+      join(casesDir, 'notes.json'),
+      JSON.stringify({ text: 'hello' }),
+      'utf-8'
+    );
+    await writeFile(
+      join(casesDir, 'workflow_test.mcps'),
+      `import "./workflow.mcps"
+
+fixture notes = fixtures.load("notes.json")
+
+beforeEach {
+  mock("fs.readFile", notes.text)
+}
+
+test "summarizes the file" {
+  assertEqual(summarize("notes.txt"), "summary: hello")
+  assertEqual(mock.calls("fs.readFile"), [{ arg0: "notes.txt" }])
+}
+
+test "reports missing files" {
+  mock.fail("fs.readFile", "File not found")
+  assertEqual(summarize("missing.txt"), "summary: hello")
+}
+`,
+      'utf-8'
+    );
+
+    const result = test(casesDir);
+    await expect(result).rejects.toMatchObject({ code: 1 });
+    const { stdout } = await result.catch(error => error);
+    expect(stdout).toMatch(/ {2}ok summarizes the file \(\d+ ms\)/);
+    expect(stdout).toMatch(/ {2}FAIL reports missing files \(\d+ ms\)/);
+    expect(stdout).toContain('File not found');
+    expect(stdout).toContain('1 passed, 1 failed');
+  });
+
+  it('should report failed assertions with the output of the test', async () => {
+    const result = test(passingDir, failingDir);
+
+    await expect(result).rejects.toMatchObject({
+      code: 1,
+      stdout: expect.stringContaining(
+        'expected "summary: goodbye", got "summary: hello"'
+      ),
+    });
+    const { stdout } = await result.catch(error => error);
+    expect(stdout).toMatch(/FAIL .*failing\/workflow_test\.mcps/);
+    expect(stdout).toContain('    checking the summary');
+    expect(stdout).toContain('1 passed, 1 failed');
+  });
+
+  it('should fail when there are no test files', async () => {
+    const emptyDir = join(TEST_DIR, 'empty');
+    await mkdir(emptyDir, { recursive: true });
+
+    await expect(test(emptyDir)).rejects.toMatchObject({
+      code: 1,
+      stderr: expect.stringContaining('no *_test.mcps files found'),
+    });
+  });
+});
@@ -0,0 +1,238 @@
+import { describe, it, expect, beforeEach, afterEach, vi } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { ModelProviderRegistry } from '@mcpscript/runtime';
+import {
+  checkExpectations,
+  formatEvalReport,
+  judgeReply,
+  passRate,
+  runEvalSuite,
+  validateEvalSuite,
+  type EvalSuiteResult,
+} from '../evals.js';
+
+function fakeModel(reply: string, grade: string) {
+  return {
+    exec: vi.fn(async () => ({
+      newMessages: [{ role: 'assistant', content: reply }],
+      toolCalls: [],
+    })),
+    chat: vi.fn(async () => ({
+      message: { role: 'assistant', content: grade },
+    })),
+  };
+}
+
+describe('validateEvalSuite', () => {
+  const scenario = { name: 'closes duplicates', input: 'Issue #12' };
+
+  it('should accept a suite', () => {
+    const suite = {
+      script: './triage.mcps',
+      agent: 'Triager',
+      runs: 3,
+      scenarios: [
+        {
+          ...scenario,
+          mocks: { 'github.getIssue': { returns: { title: 'Crash' } } },
+          expect: { contains: ['duplicate'], calls: ['github.closeIssue'] },
+          judge: { rubric: 'Explains why', model: 'claude', threshold: 0.8 },
+        },
+      ],
+    };
+    expect(validateEvalSuite(suite)).toEqual(suite);
+  });
+
+  it('should name the first invalid field', () => {
+    const suite = { script: 'a.mcps', agent: 'A' };
+    expect(() => validateEvalSuite({ ...suite, scenarios: [] })).toThrow(
+      '"scenarios" must be a non-empty array'
+    );
+    expect(() =>
+      validateEvalSuite({
+        ...suite,
+        scenarios: [{ ...scenario, mocks: { 'github.getIssue': 'Crash' } }],
+      })
+    ).toThrow('"scenarios[0].mocks.github.getIssue" must be { "returns"');
+    expect(() =>
+      validateEvalSuite({
+        ...suite,
+        scenarios: [{ ...scenario, judge: { rubric: 'Polite' } }],
+      })
+    ).toThrow('"scenarios[0].judge.model" must be set');
+    expect(() =>
+      validateEvalSuite({
+        ...suite,
+        scenarios: [{ ...scenario, expect: { matches: '(' } }],
+      })
+    ).toThrow('"scenarios[0].expect.matches" must be a regular expression');
+  });
+});
+
+describe('checkExpectations', () => {
+  it('should report each failed check', () => {
+    expect(
+      checkExpectations(
+        {
+          contains: ['duplicate', '#7'],
+          notContains: ['sorry'],
+          matches: '^Closed',
+          calls: ['github.closeIssue'],
+          noCalls: ['github.deleteRepo'],
+        },
+        'Sorry, I closed it as a duplicate',
+        ['github.getIssue', 'github.deleteRepo']
+      )
+    ).toEqual([
+      'reply does not contain "#7"',
+      'reply does not match ^Closed',
+      'github.closeIssue was not called',
+      'github.deleteRepo was called',
+    ]);
+  });
+});
+
+describe('judgeReply', () => {
+  it('should read the score from the judge answer', async () => {
+    const model = fakeModel('', 'Grade: {"score": 0.4, "reason": "Too vague"}');
+    await expect(
+      judgeReply(model, 'Names the cause', 'Why?', 'It broke')
+    ).resolves.toEqual({ score: 0.4, reason: 'Too vague' });
+    expect(model.chat.mock.calls[0]).toMatchObject([
+      { messages: [{ content: expect.stringContaining('Names the cause') }] },
+    ]);
+  });
+
+  it('should fail when the judge gives no score', async () => {
+    await expect(
+      judgeReply(fakeModel('', 'Looks fine'), 'Polite', 'Hi', 'Hello')
+    ).rejects.toThrow('judge gave no score: Looks fine');
+  });
+});
+
+describe('runEvalSuite', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-evals-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should score each run of each scenario', async () => {
+    await writeFile(
+      join(dir, 'triage.mcps'),
+      'model bot { provider: "fake" }\nagent Triager { model: bot }\n'
+    );
+    const suite = join(dir, 'triage.eval.json');
+    await writeFile(
+      suite,
+      JSON.stringify({
+        script: './triage.mcps',
+        agent: 'Triager',
+        runs: 2,
+        judgeModel: 'bot',
+        scenarios: [
+          {
+            name: 'duplicate',
+            input: 'Issue #12 repeats #7',
+            expect: { contains: ['duplicate'] },
+            judge: { rubric: 'Names the original issue' },
+          },
+          {
+            name: 'question',
+            input: 'How do I log in?',
+            expect: { contains: ['docs'] },
+          },
+        ],
+      })
+    );
+    const model = fakeModel(
+      'Closed as a duplicate of #7',
+      '{"score": 0.9, "reason": "Names #7"}'
+    );
+    const modelProviders = new ModelProviderRegistry({
+      fake: () => model as never,
+    });
+
+    const result = await runEvalSuite(suite, { modelProviders });
+    expect(result.error).toBeUndefined();
+    expect(result.scenarios).toMatchObject([
+      {
+        name: 'duplicate',
+        runs: [
+          { passed: true, score: 0.9 },
+          { passed: true, score: 0.9 },
+        ],
+      },
+      {
+        name: 'question',
+        runs: [
+          { passed: false, failures: ['reply does not contain "docs"'] },
+          { passed: false },
+        ],
+      },
+    ]);
+    expect(model.exec.mock.calls[0]).toMatchObject([
+      { messages: [{ role: 'user', content: 'Issue #12 repeats #7' }] },
+    ]);
+  });
+
+  it('should report suites that cannot run', async () => {
+    const suite = join(dir, 'broken.eval.json');
+    await writeFile(suite, JSON.stringify({ script: 'a.mcps' }));
+    expect((await runEvalSuite(suite)).error).toBe(
+      'Invalid suite: "agent" must be a string'
+    );
+  });
+});
+
+describe('formatEvalReport', () => {
+  it('should show the pass rate of each scenario and of all runs', () => {
+    const run = { reply: '', calls: [], duration: 5 };
+    const results: EvalSuiteResult[] = [
+      {
+        file: 'triage.eval.json',
+        agent: 'Triager',
+        scenarios: [
+          {
+            name: 'duplicate',
+            runs: [
+              { ...run, passed: true, failures: [] },
+              { ...run, passed: true, failures: [] },
+            ],
+          },
+          {
+            name: 'question',
+            runs: [
+              { ...run, passed: true, failures: [] },
+              {
+                ...run,
+                passed: false,
+                failures: ['reply does not contain "docs"'],
+              },
+            ],
+          },
+        ],
+      },
+      { file: 'broken.eval.json', agent: '', scenarios: [], error: 'Oops' },
+    ];
+
+    expect(passRate(results)).toEqual({ passed: 3, total: 4 });
+    expect(formatEvalReport(results)).toBe(`triage.eval.json (agent Triager)
+  PASS duplicate (2/2)
+  FAIL question (1/2)
+      run 2: reply does not contain "docs"
+  3/4 runs passed (75%)
+
+ERROR broken.eval.json
+    Oops
+
+Pass rate: 3/4 runs (75%)
+`);
+  });
+});
@@ -0,0 +1,41 @@
+import { describe, it, expect } from 'vitest';
+import { RunOutcome } from '@mcpscript/runtime';
+import { outcomeFailure } from '../exit-codes.js';
+
+function outcome(): RunOutcome {
+  const outcome = new RunOutcome();
+  outcome.record(
+    'partialFailure',
+    'parallelMap(repos): 1 of 3 items failed: items[2]: not found'
+  );
+  outcome.record('errorResult', 'gh.merge answered with an error: conflict');
+  outcome.record('errorResult', 'gh.label answered with an error: missing');
+  return outcome;
+}
+
+describe('outcomeFailure', () => {
+  it('should let runs with issues succeed by default', () => {
+    expect(outcomeFailure(outcome())).toBeUndefined();
+    expect(
+      outcomeFailure(outcome(), { errorResults: 0, partialFailures: 0 })
+    ).toBeUndefined();
+    expect(
+      outcomeFailure(new RunOutcome(), { errorResults: 3 })
+    ).toBeUndefined();
+  });
+
+  it('should fail with the code of the first issue that has one', () => {
+    expect(
+      outcomeFailure(outcome(), { errorResults: 3, partialFailures: 2 })
+    ).toEqual({
+      code: 3,
+      message:
+        'gh.merge answered with an error: conflict\n' +
+        'gh.label answered with an error: missing',
+    });
+    expect(outcomeFailure(outcome(), { partialFailures: 2 })).toEqual({
+      code: 2,
+      message: 'parallelMap(repos): 1 of 3 items failed: items[2]: not found',
+    });
+  });
+});
@@ -0,0 +1,92 @@
+import { describe, it, expect } from 'vitest';
+import { generateGoBindings, goName } from '../../generate/go.js';
+
+describe('Go bindings', () => {
+  it('should convert names to exported Go identifiers', () => {
+    expect(goName('read_file')).toBe('ReadFile');
+    expect(goName('readFile')).toBe('ReadFile');
+    expect(goName('user-id')).toBe('UserID');
+    expect(goName('getHTTPUrl')).toBe('GetHTTPURL');
+    expect(goName('3d')).toBe('X3d');
+  });
+
+  it('should generate a client with a method and input struct per tool', () => {
+    const source = generateGoBindings(
+      {
+        filesystem: [
+          {
+            name: 'read_file',
+            description: 'Read a file',
+            inputSchema: {
+              type: 'object',
+              properties: {
+                path: { type: 'string', description: 'Path of the file' },
+                limit: { type: 'integer' },
+                tags: { type: 'array', items: { type: 'string' } },
+              },
+              required: ['path'],
+            },
+          },
+        ],
+      },
+      { packageName: 'fs' }
+    );
+
+    expect(source).toMatch(/^\/\/ Code generated by mcps generate go/);
+    expect(source).toContain('package fs\n');
+    expect(source).toContain(
+      'func NewFilesystemClient(caller ToolCaller) *FilesystemClient {'
+    );
+    expect(source).toContain(
+      [
+        'type FilesystemReadFileInput struct {',
+        '\t// Path of the file',
+        '\tPath  string   `json:"path"`',
+        '\tLimit *int64   `json:"limit,omitempty"`',
+        '\tTags  []string `json:"tags,omitempty"`',
+        '}',
+      ].join('\n')
+    );
+    expect(source).toContain(
+      [
+        '// ReadFile calls the read_file tool.',
+        '//',
+        '// Read a file',
+        'func (c *FilesystemClient) ReadFile(ctx context.Context, input FilesystemReadFileInput) (json.RawMessage, error) {',
+        '\treturn c.caller.CallTool(ctx, "filesystem", "read_file", input)',
+        '}',
+      ].join('\n')
+    );
+  });
+
+  it('should declare structs for nested objects', () => {
+    const source = generateGoBindings({
+      search: [
+        {
+          name: 'query',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              filter: {
+                type: 'object',
+                properties: { after: { type: ['string', 'null'] } },
+                required: ['after'],
+              },
+              options: { type: 'object' },
+            },
+          },
+        },
+      ],
+    });
+
+    expect(source).toContain(
+      'type SearchQueryInputFilter struct {\n\tAfter *string `json:"after"`\n}'
+    );
+    expect(source).toContain(
+      '\tFilter  *SearchQueryInputFilter `json:"filter,omitempty"`'
+    );
+    expect(source).toContain(
+      '\tOptions map[string]any          `json:"options,omitempty"`'
+    );
+  });
+});
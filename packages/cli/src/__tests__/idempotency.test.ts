@@ -0,0 +1,46 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, readFile, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { FileIdempotencyStore, idempotencyFile } from '../idempotency.js';
+
+describe('idempotency stores', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-idempotency-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should keep the records of a script in a file', async () => {
+    const path = idempotencyFile('post.mcps', join(dir, 'records'));
+    const store = new FileIdempotencyStore(path);
+    expect(await store.get('post-1')).toBeUndefined();
+
+    await Promise.all([
+      store.set('post-1', { result: 1, at: '2026-01-01T00:00:00.000Z' }),
+      store.set('post-2', { result: 2, at: '2026-01-01T00:00:00.000Z' }),
+    ]);
+    expect(Object.keys(JSON.parse(await readFile(path, 'utf-8')))).toEqual([
+      'post-1',
+      'post-2',
+    ]);
+
+    const reopened = new FileIdempotencyStore(path);
+    expect(await reopened.get('post-2')).toEqual({
+      result: 2,
+      at: '2026-01-01T00:00:00.000Z',
+    });
+    expect(await reopened.get('constructor')).toBeUndefined();
+  });
+
+  it('should name files after the script', () => {
+    expect(idempotencyFile('a.mcps', dir)).toBe(idempotencyFile('a.mcps', dir));
+    expect(idempotencyFile('a.mcps', dir)).not.toBe(
+      idempotencyFile('b.mcps', dir)
+    );
+  });
+});
@@ -0,0 +1,767 @@
+// Tests for the MCP Script language server
+import {
+  describe,
+  it,
+  expect,
+  beforeEach,
+  afterAll,
+  beforeAll,
+  vi,
+} from 'vitest';
+import { PassThrough } from 'stream';
+import { setParseLimits } from '@mcpscript/transpiler';
+import { mkdirSync, mkdtempSync, rmSync, writeFileSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { pathToFileURL } from 'url';
+import { LanguageServer } from '../../lsp/server.js';
+import { Connection } from '../../lsp/transport.js';
+import {
+  SEMANTIC_TOKEN_TYPES,
+  type Message,
+  type ResponseMessage,
+} from '../../lsp/protocol.js';
+
+const URI = 'file:///test.mcps';
+
+const SOURCE = `mcp filesystem {
+  command: "npx",
+}
+
+tool greet(name: string): string {
+  message = "Hello " + name
+  return message
+}
+
+total = 0
+total = total + 1
+print(greet("Ada"))
+`;
+
+describe('LanguageServer', () => {
+  let server: LanguageServer;
+  let sent: Message[];
+  let exitCode: number | undefined;
+  let nextId: number;
+
+  const request = (method: string, params?: unknown): ResponseMessage => {
+    const id = nextId++;
+    server.handleMessage({ jsonrpc: '2.0', id, method, params });
+    const response = sent.find(m => 'id' in m && m.id === id);
+    return response as ResponseMessage;
+  };
+
+  /** A request answered once the server has finished working on it */
+  const requestAsync = async (
+    method: string,
+    params?: unknown
+  ): Promise<ResponseMessage> => {
+    const id = nextId;
+    request(method, params);
+    return vi.waitFor(() => {
+      const response = sent.find(m => 'id' in m && m.id === id);
+      expect(response).toBeDefined();
+      return response as ResponseMessage;
+    });
+  };
+
+  const notify = (method: string, params?: unknown): void => {
+    server.handleMessage({ jsonrpc: '2.0', method, params });
+  };
+
+  const lastDiagnostics = () => {
+    const published = sent.filter(
+      m => 'method' in m && m.method === 'textDocument/publishDiagnostics'
+    );
+    const last = published[published.length - 1] as {
+      params: { diagnostics: { message: string; code?: string }[] };
+    };
+    return last.params.diagnostics;
+  };
+
+  const open = (text: string) =>
+    notify('textDocument/didOpen', {
+      textDocument: { uri: URI, languageId: 'mcpscript', version: 1, text },
+    });
+
+  beforeEach(() => {
+    sent = [];
+    const connection = new Connection(new PassThrough(), new PassThrough());
+    vi.spyOn(connection, 'send').mockImplementation(message => {
+      sent.push(message);
+    });
+    exitCode = undefined;
+    nextId = 1;
+    server = new LanguageServer(connection, code => {
+      exitCode = code;
+    });
+  });
+
+  describe('lifecycle', () => {
+    it('should reject requests before initialize', () => {
+      const response = request('textDocument/documentSymbol', {
+        textDocument: { uri: URI },
+      });
+      expect(response.error?.code).toBe(-32002);
+    });
+
+    it('should advertise its capabilities', () => {
+      const response = request('initialize', { capabilities: {} });
+      const result = response.result as {
+        capabilities: Record<string, unknown>;
+      };
+      expect(result.capabilities).toMatchObject({
+        textDocumentSync: 2,
+        documentSymbolProvider: true,
+        definitionProvider: true,
+        renameProvider: true,
+        foldingRangeProvider: true,
+        workspaceSymbolProvider: true,
+        semanticTokensProvider: {
+          legend: { tokenTypes: SEMANTIC_TOKEN_TYPES },
+          full: { delta: true },
+        },
+      });
+    });
+
+    it('should exit cleanly only after shutdown', () => {
+      request('initialize', {});
+      notify('exit');
+      expect(exitCode).toBe(1);
+
+      request('shutdown');
+      notify('exit');
+      expect(exitCode).toBe(0);
+    });
+
+    it('should report unknown methods', () => {
+      request('initialize', {});
+      expect(request('workspace/unknown').error?.code).toBe(-32601);
+    });
+  });
+
+  describe('documents', () => {
+    beforeEach(() => {
+      request('initialize', {});
+    });
+
+    it('should publish no diagnostics for a valid document', () => {
+      open(SOURCE);
+      expect(lastDiagnostics()).toEqual([]);
+    });
+
+    it('should publish syntax errors', () => {
+      open('x = (1 + 2\nprint(x)');
+      const diagnostics = lastDiagnostics();
+      expect(diagnostics.length).toBeGreaterThan(0);
+      expect(diagnostics[0].code).toBe('syntax');
+    });
+
+    it('should publish type errors at their location', () => {
+      open(`tool double(n: number): number {
+  return n * 2
+}
+double("two")`);
+      expect(lastDiagnostics()).toEqual([
+        expect.objectContaining({
+          code: 'argument-type',
+          range: {
+            start: { line: 3, character: 7 },
+            end: { line: 3, character: 12 },
+          },
+        }),
+      ]);
+    });
+
+    it('should publish undefined variables at the reference', () => {
+      open('x = 1\nprint(y)');
+      expect(lastDiagnostics()).toEqual([
+        expect.objectContaining({
+          code: 'undefined-variable',
+          range: {
+            start: { line: 1, character: 6 },
+            end: { line: 1, character: 7 },
+          },
+        }),
+      ]);
+    });
+
+    it('should update diagnostics on change and clear them on close', () => {
+      open('x = (');
+      expect(lastDiagnostics().length).toBeGreaterThan(0);
+
+      notify('textDocument/didChange', {
+        textDocument: { uri: URI, version: 2 },
+        contentChanges: [{ text: 'x = 1' }],
+      });
+      expect(lastDiagnostics()).toEqual([]);
+
+      notify('textDocument/didClose', { textDocument: { uri: URI } });
+      expect(lastDiagnostics()).toEqual([]);
+      const response = request('textDocument/documentSymbol', {
+        textDocument: { uri: URI },
+      });
+      expect(response.error).toBeDefined();
+    });
+
+    it('should report documents over the parse limits', () => {
+      setParseLimits({ maxSourceLength: 20 });
+      try {
+        open('message = "a long greeting"');
+        expect(lastDiagnostics()).toEqual([
+          expect.objectContaining({
+            code: 'limit',
+            message: 'Source is 27 characters long, more than the limit of 20',
+          }),
+        ]);
+
+        // Edits apply to the text even though it was not parsed
+        notify('textDocument/didChange', {
+          textDocument: { uri: URI, version: 2 },
+          contentChanges: [
+            {
+              range: {
+                start: { line: 0, character: 11 },
+                end: { line: 0, character: 20 },
+              },
+              text: '',
+            },
+          ],
+        });
+        expect(lastDiagnostics()).toEqual([]);
+        const response = request('textDocument/documentSymbol', {
+          textDocument: { uri: URI },
+        });
+        const symbols = response.result as { name: string }[];
+        expect(symbols.map(s => s.name)).toEqual(['message']);
+      } finally {
+        setParseLimits({});
+      }
+    });
+
+    it('should apply incremental changes', () => {
+      open('x = 1\ny = x');
+      notify('textDocument/didChange', {
+        textDocument: { uri: URI, version: 2 },
+        contentChanges: [
+          {
+            range: {
+              start: { line: 1, character: 4 },
+              end: { line: 1, character: 5 },
+            },
+            text: '(',
+          },
+        ],
+      });
+      expect(lastDiagnostics().length).toBeGreaterThan(0);
+
+      notify('textDocument/didChange', {
+        textDocument: { uri: URI, version: 3 },
+        contentChanges: [
+          {
+            range: {
+              start: { line: 1, character: 4 },
+              end: { line: 1, character: 5 },
+            },
+            text: 'x + 1',
+          },
+          {
+            range: {
+              start: { line: 0, character: 0 },
+              end: { line: 0, character: 0 },
+            },
+            text: 'total = 0\n',
+          },
+        ],
+      });
+      expect(lastDiagnostics()).toEqual([]);
+
+      const response = request('textDocument/documentSymbol', {
+        textDocument: { uri: URI },
+      });
+      const symbols = response.result as { name: string }[];
+      expect(symbols.map(s => s.name)).toEqual(['total', 'x', 'y']);
+    });
+
+    it('should list document symbols', () => {
+      open(SOURCE);
+      const response = request('textDocument/documentSymbol', {
+        textDocument: { uri: URI },
+      });
+      const symbols = response.result as { name: string; kind: number }[];
+      expect(symbols.map(s => [s.name, s.kind])).toEqual([
+        ['filesystem', 2],
+        ['greet', 12],
+        ['total', 13],
+      ]);
+    });
+
+    it('should compute folding ranges', () => {
+      open(SOURCE);
+      const response = request('textDocument/foldingRange', {
+        textDocument: { uri: URI },
+      });
+      expect(response.result).toEqual([
+        { startLine: 0, endLine: 2 },
+        { startLine: 4, endLine: 7 },
+      ]);
+    });
+
+    it('should go to the definition of a parameter', () => {
+      open(SOURCE);
+      const response = request('textDocument/definition', {
+        textDocument: { uri: URI },
+        position: { line: 5, character: 24 },
+      });
+      expect(response.result).toEqual({
+        uri: URI,
+        range: {
+          start: { line: 4, character: 11 },
+          end: { line: 4, character: 15 },
+        },
+      });
+    });
+
+    it('should go to the first assignment of a variable', () => {
+      open(SOURCE);
+      const response = request('textDocument/definition', {
+        textDocument: { uri: URI },
+        position: { line: 10, character: 9 },
+      });
+      expect(response.result).toMatchObject({
+        range: { start: { line: 9, character: 0 } },
+      });
+    });
+
+    it('should go to a hoisted tool declaration', () => {
+      open(SOURCE);
+      const response = request('textDocument/definition', {
+        textDocument: { uri: URI },
+        position: { line: 11, character: 7 },
+      });
+      expect(response.result).toMatchObject({
+        range: { start: { line: 4, character: 5 } },
+      });
+    });
+
+    it('should rename a variable and its rebinding assignments', () => {
+      open(SOURCE);
+      const response = request('textDocument/rename', {
+        textDocument: { uri: URI },
+        position: { line: 10, character: 10 },
+        newName: 'sum',
+      });
+      const line = (n: number, start: number) => ({
+        range: {
+          start: { line: n, character: start },
+          end: { line: n, character: start + 5 },
+        },
+        newText: 'sum',
+      });
+      expect(response.result).toEqual({
+        changes: { [URI]: [line(9, 0), line(10, 0), line(10, 8)] },
+      });
+    });
+
+    it('should reject renaming to an invalid identifier', () => {
+      open(SOURCE);
+      const response = request('textDocument/rename', {
+        textDocument: { uri: URI },
+        position: { line: 9, character: 0 },
+        newName: 'not valid',
+      });
+      expect(response.error?.code).toBe(-32602);
+    });
+
+    it('should encode semantic tokens', () => {
+      open('tool f() {\n  return 1\n}');
+      const response = request('textDocument/semanticTokens/full', {
+        textDocument: { uri: URI },
+      });
+      const { data } = response.result as { data: number[] };
+      const type = (name: string) =>
+        SEMANTIC_TOKEN_TYPES.indexOf(name as (typeof SEMANTIC_TOKEN_TYPES)[0]);
+      // Each token is [deltaLine, deltaStart, length, type, modifiers]
+      expect(data.slice(0, 10)).toEqual([
+        0,
+        0,
+        4,
+        type('keyword'),
+        0,
+        0,
+        5,
+        1,
+        type('function'),
+        0,
+      ]);
+      expect(data.slice(-10)).toEqual([
+        1,
+        2,
+        6,
+        type('keyword'),
+        0,
+        0,
+        7,
+        1,
+        type('number'),
+        0,
+      ]);
+    });
+
+    it('should send the changes to the tokens since a result', () => {
+      open('x = 1\ny = 2\n');
+      const textDocument = { uri: URI };
+      const full = request('textDocument/semanticTokens/full', {
+        textDocument,
+      }).result as { resultId: string; data: number[] };
+
+      notify('textDocument/didChange', {
+        textDocument: { uri: URI, version: 2 },
+        contentChanges: [
+          {
+            range: {
+              start: { line: 1, character: 4 },
+              end: { line: 1, character: 5 },
+            },
+            text: '"two"',
+          },
+        ],
+      });
+      const delta = request('textDocument/semanticTokens/full/delta', {
+        textDocument,
+        previousResultId: full.resultId,
+      }).result as {
+        resultId: string;
+        edits: { start: number; deleteCount: number; data: number[] }[];
+      };
+      expect(delta.resultId).not.toBe(full.resultId);
+      expect(delta.edits).toHaveLength(1);
+
+      // Applied to the previous tokens, the edit gives the current ones
+      const [edit] = delta.edits;
+      expect(edit.start).toBeGreaterThan(0);
+      const patched = [...full.data];
+      patched.splice(edit.start, edit.deleteCount, ...edit.data);
+      const current = request('textDocument/semanticTokens/full', {
+        textDocument,
+      }).result as { data: number[] };
+      expect(patched).toEqual(current.data);
+    });
+
+    it('should send all tokens when the previous result is unknown', () => {
+      open('x = 1');
+      const response = request('textDocument/semanticTokens/full/delta', {
+        textDocument: { uri: URI },
+        previousResultId: 'stale',
+      });
+      expect(response.result).toMatchObject({
+        resultId: expect.any(String),
+        data: expect.any(Array),
+      });
+    });
+  });
+
+  describe('imports', () => {
+    let dir: string;
+    let mainUri: string;
+
+    beforeAll(() => {
+      dir = mkdtempSync(join(tmpdir(), 'mcps-lsp-imports-'));
+      writeFileSync(
+        join(dir, 'tools.mcps'),
+        'tool shout(text: string): string {\n  return text + "!"\n}\n'
+      );
+      mainUri = pathToFileURL(join(dir, 'main.mcps')).href;
+    });
+
+    afterAll(() => {
+      rmSync(dir, { recursive: true, force: true });
+    });
+
+    beforeEach(() => {
+      request('initialize', {});
+    });
+
+    const openMain = (text: string) =>
+      notify('textDocument/didOpen', {
+        textDocument: {
+          uri: mainUri,
+          languageId: 'mcpscript',
+          version: 1,
+          text,
+        },
+      });
+
+    it('should resolve names declared in imported modules', () => {
+      openMain('import "./tools"\nprint(shout("hi"))\n');
+      expect(lastDiagnostics()).toEqual([]);
+
+      const response = request('textDocument/definition', {
+        textDocument: { uri: mainUri },
+        position: { line: 1, character: 7 },
+      });
+      expect(response.result).toEqual({
+        uri: pathToFileURL(join(dir, 'tools.mcps')).href,
+        range: {
+          start: { line: 0, character: 5 },
+          end: { line: 0, character: 10 },
+        },
+      });
+    });
+
+    it('should report imports that cannot be found', () => {
+      openMain('import "./missing"\nprint(shout("hi"))\n');
+      const diagnostics = lastDiagnostics();
+      expect(diagnostics).toHaveLength(1);
+      expect(diagnostics[0].message).toContain('missing.mcps');
+    });
+  });
+
+  describe('tool calls', () => {
+    let dir: string;
+    let uri: string;
+
+    beforeAll(() => {
+      dir = mkdtempSync(join(tmpdir(), 'mcps-lsp-tools-'));
+      const tools = [
+        {
+          name: 'readFile',
+          inputSchema: {
+            type: 'object',
+            properties: { path: { type: 'string' } },
+            required: ['path'],
+          },
+        },
+      ];
+      writeFileSync(
+        join(dir, 'mcps-tools.lock.json'),
+        JSON.stringify({ version: 1, servers: { fs: tools } })
+      );
+      uri = pathToFileURL(join(dir, 'main.mcps')).href;
+    });
+
+    afterAll(() => {
+      rmSync(dir, { recursive: true, force: true });
+    });
+
+    it('should offer to add missing required properties', () => {
+      request('initialize', {});
+      notify('textDocument/didOpen', {
+        textDocument: {
+          uri,
+          languageId: 'mcpscript',
+          version: 1,
+          text: 'mcp fs {\n  command: "fs"\n}\nfs.readFile({})\n',
+        },
+      });
+      const diagnostics = lastDiagnostics();
+      expect(diagnostics).toEqual([
+        expect.objectContaining({
+          code: 'tool-call',
+          message: "Missing required parameter 'path' of fs.readFile",
+        }),
+      ]);
+
+      const response = request('textDocument/codeAction', {
+        textDocument: { uri },
+        range: {
+          start: { line: 3, character: 0 },
+          end: { line: 3, character: 0 },
+        },
+        context: { diagnostics },
+      });
+      expect(response.result).toEqual([
+        {
+          title: "Add required property 'path'",
+          kind: 'quickfix',
+          diagnostics,
+          edit: {
+            changes: {
+              [uri]: [
+                {
+                  range: {
+                    start: { line: 3, character: 13 },
+                    end: { line: 3, character: 13 },
+                  },
+                  newText: ' path: "" ',
+                },
+              ],
+            },
+          },
+        },
+      ]);
+    });
+  });
+
+  describe('refactorings', () => {
+    let dir: string;
+    let uri: string;
+
+    const open = (text: string): void => {
+      request('initialize', {});
+      notify('textDocument/didOpen', {
+        textDocument: { uri, languageId: 'mcpscript', version: 1, text },
+      });
+    };
+
+    const codeActions = (line: number, character: number) =>
+      request('textDocument/codeAction', {
+        textDocument: { uri },
+        range: {
+          start: { line, character },
+          end: { line, character },
+        },
+        context: { diagnostics: [] },
+      }).result as { title: string; kind: string; edit: unknown }[];
+
+    beforeAll(() => {
+      dir = mkdtempSync(join(tmpdir(), 'mcps-lsp-refactor-'));
+      writeFileSync(join(dir, 'lib.mcps'), 'tool shout(s: string) {}\n');
+      uri = pathToFileURL(join(dir, 'main.mcps')).href;
+    });
+
+    afterAll(() => {
+      rmSync(dir, { recursive: true, force: true });
+    });
+
+    it('should offer to inline a variable', () => {
+      open('import "./lib"\n\nx = 1\nprint(x + 2)\nshout("a")\n');
+      expect(codeActions(2, 0)).toEqual([
+        {
+          title: 'Inline variable x',
+          kind: 'refactor.inline',
+          edit: {
+            changes: {
+              [uri]: [
+                {
+                  range: {
+                    start: { line: 2, character: 0 },
+                    end: { line: 3, character: 0 },
+                  },
+                  newText: '',
+                },
+                {
+                  range: {
+                    start: { line: 3, character: 6 },
+                    end: { line: 3, character: 7 },
+                  },
+                  newText: '1',
+                },
+              ],
+            },
+          },
+        },
+      ]);
+    });
+
+    it('should offer to move a declaration to an imported module', () => {
+      open(
+        'import "./lib"\n\ntool greet(name: string) {\n  print(name)\n}\n'
+      );
+      const [move] = codeActions(2, 5);
+      expect(move).toMatchObject({
+        title: 'Move tool greet to lib.mcps',
+        kind: 'refactor.move',
+      });
+      expect(Object.keys((move.edit as { changes: object }).changes)).toEqual(
+        [uri, pathToFileURL(join(dir, 'lib.mcps')).href]
+      );
+    });
+
+    it('should organize imports on save', () => {
+      open('import "./lib"\nimport "./lib.mcps"\n\nprint(1)\n');
+      const response = request('textDocument/codeAction', {
+        textDocument: { uri },
+        range: {
+          start: { line: 0, character: 0 },
+          end: { line: 0, character: 0 },
+        },
+        context: { diagnostics: [], only: ['source.organizeImports'] },
+      });
+      expect(response.result).toEqual([
+        {
+          title: 'Organize imports',
+          kind: 'source.organizeImports',
+          edit: {
+            changes: {
+              [uri]: [
+                {
+                  range: {
+                    start: { line: 0, character: 0 },
+                    end: { line: 3, character: 0 },
+                  },
+                  newText: '',
+                },
+              ],
+            },
+          },
+        },
+      ]);
+    });
+  });
+
+  describe('workspace symbols', () => {
+    let dir: string;
+
+    beforeAll(() => {
+      dir = mkdtempSync(join(tmpdir(), 'mcps-lsp-symbols-'));
+      writeFileSync(join(dir, 'main.mcps'), 'total = 0\nprint(greet("Ada"))\n');
+      mkdirSync(join(dir, 'lib'));
+      writeFileSync(
+        join(dir, 'lib', 'greet.mcps'),
+        'tool greet(name: string): string {\n  return "Hello " + name\n}\n'
+      );
+      mkdirSync(join(dir, 'node_modules'));
+      writeFileSync(
+        join(dir, 'node_modules', 'dep.mcps'),
+        'tool greeter() {}\n'
+      );
+    });
+
+    afterAll(() => {
+      rmSync(dir, { recursive: true, force: true });
+    });
+
+    beforeEach(() => {
+      request('initialize', { rootUri: pathToFileURL(dir).href });
+    });
+
+    const symbols = async (query: string) =>
+      (await requestAsync('workspace/symbol', { query })).result;
+
+    it('should find definitions in the workspace folders', async () => {
+      expect(await symbols('grt')).toEqual([
+        {
+          name: 'greet',
+          kind: 12,
+          location: {
+            uri: pathToFileURL(join(dir, 'lib', 'greet.mcps')).href,
+            range: {
+              start: { line: 0, character: 5 },
+              end: { line: 0, character: 10 },
+            },
+          },
+        },
+      ]);
+      expect(await symbols('TOTAL')).toMatchObject([
+        { name: 'total', kind: 13 },
+      ]);
+    });
+
+    it('should use open documents as edited', async () => {
+      const uri = pathToFileURL(join(dir, 'main.mcps')).href;
+      notify('textDocument/didOpen', {
+        textDocument: {
+          uri,
+          languageId: 'mcpscript',
+          version: 1,
+          text: 'count = 0\n',
+        },
+      });
+      expect(await symbols('total')).toEqual([]);
+      expect(await symbols('count')).toMatchObject([
+        { name: 'count', location: { uri } },
+      ]);
+    });
+  });
+});
@@ -0,0 +1,61 @@
+// Tests for LSP message framing
+import { describe, it, expect } from 'vitest';
+import { PassThrough } from 'stream';
+import {
+  Connection,
+  MessageReader,
+  encodeMessage,
+} from '../../lsp/transport.js';
+import type { Message } from '../../lsp/protocol.js';
+
+const ping: Message = { jsonrpc: '2.0', id: 1, method: 'ping' };
+
+describe('LSP transport', () => {
+  it('should frame messages with a Content-Length header', () => {
+    const encoded = encodeMessage(ping).toString('utf-8');
+    const body = JSON.stringify(ping);
+    expect(encoded).toBe(`Content-Length: ${body.length}\r\n\r\n${body}`);
+  });
+
+  it('should count multi-byte characters in the content length', () => {
+    const message: Message = { jsonrpc: '2.0', method: 'note', params: 'é' };
+    const encoded = encodeMessage(message);
+    const [header] = encoded.toString('utf-8').split('\r\n');
+    const bodyLength = Buffer.byteLength(JSON.stringify(message), 'utf-8');
+    expect(header).toBe(`Content-Length: ${bodyLength}`);
+  });
+
+  it('should decode messages split across chunks', () => {
+    const reader = new MessageReader();
+    const encoded = encodeMessage(ping);
+    expect(reader.push(encoded.subarray(0, 10))).toEqual([]);
+    expect(reader.push(encoded.subarray(10, 30))).toEqual([]);
+    expect(reader.push(encoded.subarray(30))).toEqual([ping]);
+  });
+
+  it('should decode several messages from one chunk', () => {
+    const reader = new MessageReader();
+    const second: Message = { jsonrpc: '2.0', method: 'initialized' };
+    const chunk = Buffer.concat([encodeMessage(ping), encodeMessage(second)]);
+    expect(reader.push(chunk)).toEqual([ping, second]);
+  });
+
+  it('should reject headers without a content length', () => {
+    const reader = new MessageReader();
+    expect(() => reader.push(Buffer.from('Bogus: 1\r\n\r\n{}'))).toThrow(
+      'Invalid message header'
+    );
+    expect(reader.push(encodeMessage(ping))).toEqual([ping]);
+  });
+
+  it('should deliver messages from the input stream', async () => {
+    const input = new PassThrough();
+    const output = new PassThrough();
+    const connection = new Connection(input, output);
+    const received = new Promise<Message>(resolve =>
+      connection.listen(resolve)
+    );
+    input.write(encodeMessage(ping));
+    await expect(received).resolves.toEqual(ping);
+  });
+});
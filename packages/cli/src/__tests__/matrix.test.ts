@@ -0,0 +1,75 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '@mcpscript/transpiler';
+import { aggregateMatrix, type MatrixRun } from '@mcpscript/runtime';
+import { findMain } from '../arguments.js';
+import { checkMatrix, formatMatrixResults } from '../matrix.js';
+
+const SOURCE = `tool main(model: string, temperature: number = 0) {
+  return model
+}
+`;
+
+function main() {
+  return findMain(parseSource(SOURCE), SOURCE);
+}
+
+describe('checkMatrix', () => {
+  it('should accept matrices of the parameters of main', () => {
+    expect(() =>
+      checkMatrix({ model: ['a', 'b'], temperature: [0, 1] }, main(), 'x.mcps')
+    ).not.toThrow();
+  });
+
+  it('should reject parameters main does not have', () => {
+    expect(() =>
+      checkMatrix({ model: ['a'], topP: [1] }, main(), 'x.mcps')
+    ).toThrow('main has no parameter named topP');
+  });
+
+  it('should reject matrices leaving out required parameters', () => {
+    expect(() => checkMatrix({ temperature: [0] }, main(), 'x.mcps')).toThrow(
+      'The matrix gives no values for model, which main requires'
+    );
+  });
+
+  it('should reject scripts without a main tool', () => {
+    expect(() => checkMatrix({}, undefined, 'x.mcps')).toThrow(
+      'x.mcps declares no main tool'
+    );
+  });
+});
+
+describe('formatMatrixResults', () => {
+  it('should print the runs, the values compared and a summary', () => {
+    const runs: MatrixRun[] = [
+      { args: { model: 'a' }, status: 'ok', result: 'yes', durationMs: 120 },
+      {
+        args: { model: 'b' },
+        status: 'failed',
+        error: 'Rate limited',
+        durationMs: 2500,
+      },
+    ];
+    expect(formatMatrixResults(runs, aggregateMatrix(runs))).toBe(
+      `#  --model  status  time    result
+1  a        ok      120 ms  yes
+2  b        failed  2.50 s  Rate limited
+
+value      runs  failed  mean time
+--model a  1     0       120 ms
+--model b  1     1       2.50 s
+
+2 runs, 1 failed
+`
+    );
+  });
+
+  it('should cut long results short', () => {
+    const output = formatMatrixResults(
+      [{ args: {}, status: 'ok', result: 'x'.repeat(100), durationMs: 1 }],
+      []
+    );
+    expect(output).toContain(`${'x'.repeat(37)}...`);
+    expect(output).toContain('1 run, 0 failed');
+  });
+});
@@ -0,0 +1,302 @@
+// Tests for serving workflows as MCP tools
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { PassThrough } from 'stream';
+import type {
+  Message,
+  NotificationMessage,
+  ResponseMessage,
+} from '../../lsp/protocol.js';
+import {
+  describeWorkflow,
+  loadWorkflows,
+  WorkflowServer,
+} from '../../mcp-server/server.js';
+import { LineConnection, LineReader } from '../../mcp-server/transport.js';
+import { RunManager } from '../../remote/runs.js';
+
+// Stands in for the real worker: report.mcps prints twice with a pause in
+// between, sends an agent message and fails; wait.mcps runs until cancelled;
+// deploy.mcps prints its arguments
+const FAKE_WORKER = `
+process.once('message', request => {
+  if (request.file.endsWith('wait.mcps')) {
+    setInterval(() => {}, 1000);
+    return;
+  }
+  if (request.file.endsWith('deploy.mcps')) {
+    console.log(JSON.stringify(request.args));
+    process.exit(0);
+  }
+  console.log('loading');
+  setTimeout(() => {
+    console.log('done');
+    const message = { type: 'message', title: 'Agent[writer]', body: 'hi' };
+    process.send(message, () => process.exit(2));
+  }, 100);
+});
+`;
+
+interface ProgressParams {
+  progressToken: string;
+  progress: number;
+  message: string;
+}
+
+const DEPLOY = [
+  '// Deploys a service',
+  '',
+  '// Rolls out the latest build',
+  '// @param service Name of the service',
+  '// @param replicas How many instances to run',
+  'tool main(service: string, replicas: number = 3, tags?: string[]) {',
+  '  print(service)',
+  '}',
+].join('\n');
+
+describe('describeWorkflow', () => {
+  it('should describe a workflow by the comment its script starts with', () => {
+    expect(
+      describeWorkflow(
+        '//\n// Daily report\n// for the team\n//\n// Details\nx = 1',
+        'report.mcps'
+      ).description
+    ).toBe('Daily report for the team\n\nDetails');
+    expect(describeWorkflow('x = 1 // note', 'x.mcps')).toEqual({
+      description: 'Run the workflow x.mcps',
+      inputSchema: { type: 'object', properties: {} },
+    });
+  });
+
+  it('should take the arguments from the parameters of main', () => {
+    expect(describeWorkflow(DEPLOY, 'deploy.mcps')).toEqual({
+      description: 'Deploys a service\n\nRolls out the latest build',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          service: { type: 'string', description: 'Name of the service' },
+          replicas: {
+            type: 'number',
+            description: 'How many instances to run',
+            default: 3,
+          },
+          tags: { type: 'array', items: { type: 'string' } },
+        },
+        required: ['service'],
+      },
+    });
+  });
+
+  it('should use the descriptions of an override', () => {
+    const { description, inputSchema } = describeWorkflow(
+      DEPLOY,
+      'deploy.mcps',
+      {
+        description: 'Deploy a service to production',
+        parameters: { tags: 'Labels of the release' },
+      }
+    );
+    expect(description).toBe('Deploy a service to production');
+    expect(inputSchema.properties?.tags).toEqual({
+      type: 'array',
+      items: { type: 'string' },
+      description: 'Labels of the release',
+    });
+    expect(() =>
+      describeWorkflow(DEPLOY, 'deploy.mcps', { parameters: { region: 'x' } })
+    ).toThrow(
+      'deploy.mcps: main has no parameter named region, which .mcpsrc describes'
+    );
+  });
+});
+
+describe('LineReader', () => {
+  it('should split messages across and within chunks', () => {
+    const reader = new LineReader();
+    expect(reader.push('{"a":1}\n{"b"')).toEqual(['{"a":1}']);
+    expect(reader.push(':2}\n\n')).toEqual(['{"b":2}']);
+    // A character split across chunks
+    const bytes = Buffer.from('"é"\n');
+    expect(reader.push(bytes.subarray(0, 2))).toEqual([]);
+    expect(reader.push(bytes.subarray(2))).toEqual(['"é"']);
+  });
+});
+
+describe('WorkflowServer', () => {
+  let dir: string;
+  let sent: Message[];
+  let server: WorkflowServer;
+  let nextId = 1;
+
+  const request = (method: string, params?: unknown): number => {
+    const id = nextId++;
+    server.handleMessage({ jsonrpc: '2.0', id, method, params });
+    return id;
+  };
+
+  const response = async (id: number): Promise<ResponseMessage> => {
+    for (;;) {
+      const found = sent.find(
+        m => 'id' in m && m.id === id && !('method' in m)
+      );
+      if (found) {
+        return found as ResponseMessage;
+      }
+      await new Promise(resolve => setTimeout(resolve, 10));
+    }
+  };
+
+  const progress = () =>
+    sent.filter(
+      (m): m is NotificationMessage =>
+        'method' in m && m.method === 'notifications/progress'
+    );
+
+  beforeAll(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-serve-'));
+    const workerPath = join(dir, 'worker.cjs');
+    await writeFile(workerPath, FAKE_WORKER, 'utf-8');
+    await writeFile(join(dir, 'report.mcps'), '// Daily report\nprint(1)');
+    await writeFile(join(dir, 'wait.mcps'), 'print(1)');
+    await writeFile(join(dir, 'deploy.mcps'), DEPLOY);
+    await writeFile(
+      join(dir, '.mcpsrc'),
+      JSON.stringify({
+        serve: { tools: { deploy: { description: 'Deploy a service' } } },
+      })
+    );
+
+    const output = new PassThrough();
+    const connection = new LineConnection(new PassThrough(), output);
+    sent = [];
+    const reader = new LineReader();
+    output.on('data', chunk => {
+      sent.push(...reader.push(chunk).map(line => JSON.parse(line)));
+    });
+    server = new WorkflowServer(
+      connection,
+      await loadWorkflows(
+        ['report.mcps', 'wait.mcps', 'deploy.mcps'].map(file => join(dir, file))
+      ),
+      new RunManager({ workerPath }),
+      { heartbeat: 40 }
+    );
+  });
+
+  afterAll(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should list workflows as tools', async () => {
+    const init = await response(
+      request('initialize', { protocolVersion: '2025-03-26' })
+    );
+    expect(init.result).toMatchObject({
+      protocolVersion: '2025-03-26',
+      capabilities: { tools: {} },
+      serverInfo: { name: 'mcps' },
+    });
+
+    const list = await response(request('tools/list'));
+    expect(list.result).toEqual({
+      tools: [
+        {
+          name: 'report',
+          description: 'Daily report',
+          inputSchema: { type: 'object', properties: {} },
+        },
+        {
+          name: 'wait',
+          description: 'Run the workflow wait.mcps',
+          inputSchema: { type: 'object', properties: {} },
+        },
+        {
+          name: 'deploy',
+          description: 'Deploy a service',
+          inputSchema: describeWorkflow(DEPLOY, 'deploy.mcps').inputSchema,
+        },
+      ],
+    });
+  });
+
+  it('should stream output as progress and return it as the result', async () => {
+    const id = request('tools/call', {
+      name: 'report',
+      _meta: { progressToken: 'report-1' },
+    });
+    const { result } = await response(id);
+
+    expect(result).toEqual({
+      content: [
+        { type: 'text', text: 'loading\ndone' },
+        { type: 'text', text: 'Agent[writer]: hi' },
+      ],
+      isError: true,
+    });
+    const params = progress().map(m => m.params as ProgressParams);
+    expect(params.every(p => p.progressToken === 'report-1')).toBe(true);
+    expect(params.map(p => p.progress)).toEqual(params.map((_, i) => i + 1));
+    // Quiet stretches, like the pause between the prints, are reported too
+    const heartbeat = /^report is still running after \d+ s$/;
+    expect(params.some(p => heartbeat.test(p.message))).toBe(true);
+    expect(
+      params.map(p => p.message).filter(message => !heartbeat.test(message))
+    ).toEqual(['loading', 'done', 'Agent[writer]: hi']);
+  });
+
+  it('should not answer cancelled calls', async () => {
+    const id = request('tools/call', { name: 'wait' });
+    await new Promise(resolve => setTimeout(resolve, 50));
+    server.handleMessage({
+      jsonrpc: '2.0',
+      method: 'notifications/cancelled',
+      params: { requestId: id },
+    });
+    // Answered requests after the cancelled one show it was handled
+    await response(request('ping'));
+    await new Promise(resolve => setTimeout(resolve, 200));
+    expect(sent.some(m => 'id' in m && m.id === id)).toBe(false);
+  });
+
+  it('should run workflows with the arguments of the call', async () => {
+    const { result } = await response(
+      request('tools/call', { name: 'deploy', arguments: { service: 'api' } })
+    );
+    expect(result).toEqual({
+      content: [{ type: 'text', text: '{"service":"api"}' }],
+      isError: false,
+    });
+
+    const { error } = await response(
+      request('tools/call', { name: 'wait', arguments: { service: 'api' } })
+    );
+    expect(error).toEqual({
+      code: -32602,
+      message: 'Tool wait takes no arguments',
+    });
+  });
+
+  it('should reject unknown tools', async () => {
+    const { error } = await response(
+      request('tools/call', { name: 'missing' })
+    );
+    expect(error).toEqual({ code: -32602, message: 'Unknown tool missing' });
+  });
+});
+
+describe('loadWorkflows', () => {
+  it('should refuse scripts that would be the same tool', async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-serve-'));
+    try {
+      await writeFile(join(dir, 'a b.mcps'), '');
+      await writeFile(join(dir, 'a.b.mcps'), '');
+      await expect(
+        loadWorkflows([join(dir, 'a b.mcps'), join(dir, 'a.b.mcps')])
+      ).rejects.toThrow('would both be served as the tool a_b');
+    } finally {
+      await rm(dir, { recursive: true, force: true });
+    }
+  });
+});
@@ -0,0 +1,65 @@
+import { describe, it, expect, afterEach } from 'vitest';
+import { parseSource } from '@mcpscript/transpiler';
+import {
+  checkScriptOffline,
+  OFFLINE_VARIABLE,
+  offlineMode,
+  OfflineScriptError,
+} from '../offline.js';
+
+const SCRIPT = `
+mcp filesystem {
+  command: "npx",
+  args: ["-y", "@modelcontextprotocol/server-filesystem", "/tmp"]
+}
+mcp search {
+  url: "https://search.example.com/mcp"
+}
+mcp wiki {
+  url: "http://localhost:8080/mcp"
+}
+model llama {
+  provider: "local",
+  model: "llama3"
+}
+model gpt {
+  provider: "openai",
+  model: "gpt-4o"
+}
+`;
+
+describe('offlineMode', () => {
+  afterEach(() => {
+    delete process.env[OFFLINE_VARIABLE];
+  });
+
+  it('should follow MCPS_OFFLINE', () => {
+    expect(offlineMode()).toBe(false);
+    process.env[OFFLINE_VARIABLE] = '1';
+    expect(offlineMode()).toBe(true);
+    process.env[OFFLINE_VARIABLE] = 'false';
+    expect(offlineMode()).toBe(false);
+  });
+});
+
+describe('checkScriptOffline', () => {
+  it('should list the servers and models on other hosts', () => {
+    let error: unknown;
+    try {
+      checkScriptOffline(parseSource(SCRIPT));
+    } catch (e) {
+      error = e;
+    }
+    expect(error).toBeInstanceOf(OfflineScriptError);
+    expect((error as OfflineScriptError).needs).toEqual([
+      'server search (https://search.example.com/mcp)',
+      'model gpt (openai)',
+    ]);
+  });
+
+  it('should leave urls built from variables to the run', () => {
+    expect(() =>
+      checkScriptOffline(parseSource('mcp search { url: env.SEARCH_URL }'))
+    ).not.toThrow();
+  });
+});
@@ -0,0 +1,114 @@
+import { describe, it, expect, vi } from 'vitest';
+import { parseSource, type MCPDeclaration } from '@mcpscript/transpiler';
+import { pinPackages, resolvePackage } from '../package-pins.js';
+
+const SERVERS = parseSource(`
+mcp fs {
+  command: "npx",
+  args: ["-y", "@modelcontextprotocol/server-filesystem", "/tmp"]
+}
+mcp fetch {
+  command: "uvx",
+  args: ["mcp-server-fetch==2025.4.7"]
+}
+mcp local {
+  command: "node",
+  args: ["server.js"]
+}
+`) as MCPDeclaration[];
+
+const fetchImpl = vi.fn(async (url: string) => {
+  if (url.startsWith('https://registry.npmjs.org/')) {
+    const version = url.endsWith('/latest') ? '0.7.0' : url.split('/').pop();
+    return Response.json({
+      version,
+      dist: { integrity: `sha512-${version}` },
+    });
+  }
+  if (url === 'https://pypi.org/pypi/mcp-server-fetch/2025.4.7/json') {
+    return Response.json({ info: { version: '2025.4.7' } });
+  }
+  return new Response('Not Found', { status: 404 });
+});
+
+describe('resolvePackage', () => {
+  it('should resolve packages to exact versions', async () => {
+    await expect(
+      resolvePackage(
+        { ecosystem: 'npm', name: '@scope/server' },
+        undefined,
+        fetchImpl as typeof fetch
+      )
+    ).resolves.toEqual({
+      ecosystem: 'npm',
+      name: '@scope/server',
+      version: '0.7.0',
+      integrity: 'sha512-0.7.0',
+    });
+    await expect(
+      resolvePackage(
+        { ecosystem: 'PyPI', name: 'missing' },
+        '1.0.0',
+        fetchImpl as typeof fetch
+      )
+    ).rejects.toThrow('PyPI has no version 1.0.0 of package missing');
+  });
+});
+
+describe('pinPackages', () => {
+  it('should pin the packages servers run', async () => {
+    const pins = await pinPackages(
+      SERVERS,
+      {},
+      { fetchImpl: fetchImpl as typeof fetch }
+    );
+    expect(pins).toEqual({
+      fs: {
+        ecosystem: 'npm',
+        name: '@modelcontextprotocol/server-filesystem',
+        version: '0.7.0',
+        integrity: 'sha512-0.7.0',
+      },
+      fetch: {
+        ecosystem: 'PyPI',
+        name: 'mcp-server-fetch',
+        version: '2025.4.7',
+      },
+    });
+  });
+
+  it('should keep pinned versions until updated', async () => {
+    const pinned = {
+      fs: {
+        ecosystem: 'npm' as const,
+        name: '@modelcontextprotocol/server-filesystem',
+        version: '0.6.2',
+        integrity: 'sha512-0.6.2',
+      },
+    };
+    const options = { fetchImpl: fetchImpl as typeof fetch };
+    expect((await pinPackages(SERVERS, pinned, options)).fs.version).toBe(
+      '0.6.2'
+    );
+    expect(
+      (await pinPackages(SERVERS, pinned, { ...options, update: true })).fs
+        .version
+    ).toBe('0.7.0');
+  });
+
+  it('should refuse versions published with another integrity', async () => {
+    const pinned = {
+      fs: {
+        ecosystem: 'npm' as const,
+        name: '@modelcontextprotocol/server-filesystem',
+        version: '0.6.2',
+        integrity: 'sha512-tampered',
+      },
+    };
+    await expect(
+      pinPackages(SERVERS, pinned, { fetchImpl: fetchImpl as typeof fetch })
+    ).rejects.toThrow(
+      '@modelcontextprotocol/server-filesystem 0.6.2 is published with another integrity than when it was locked (sha512-tampered)'
+    );
+  });
+});
@@ -0,0 +1,193 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '@mcpscript/transpiler';
+import { formatPlan, planRun } from '../plan.js';
+
+describe('planRun', () => {
+  it('should list the servers a run would start', () => {
+    const plan = planRun(
+      parseSource(`
+mcp filesystem {
+  command: "npx",
+  args: ["-y", "@modelcontextprotocol/server-filesystem", "/tmp"],
+  env: { TOKEN: env.TOKEN }
+}
+mcp remote {
+  url: "https://example.com/mcp",
+  headers: { Authorization: "Bearer " + env.TOKEN }
+}
+`)
+    );
+    expect(plan.servers).toEqual([
+      {
+        name: 'filesystem',
+        command: 'npx',
+        args: ['-y', '@modelcontextprotocol/server-filesystem', '/tmp'],
+      },
+      { name: 'remote', url: 'https://example.com/mcp' },
+    ]);
+  });
+
+  it('should describe models, agents and tools', () => {
+    const plan = planRun(
+      parseSource(`
+model gpt {
+  provider: "openai",
+  model: "gpt-4o",
+  apiKey: env.OPENAI_API_KEY
+}
+tool double(x) {
+  return x * 2
+}
+agent helper {
+  model: gpt,
+  tools: [double, filesystem.readFile]
+}
+`)
+    );
+    expect(plan.models).toEqual([
+      { name: 'gpt', provider: 'openai', model: 'gpt-4o' },
+    ]);
+    expect(plan.tools).toEqual([{ name: 'double', parameters: ['x'] }]);
+    expect(plan.agents).toEqual([
+      {
+        name: 'helper',
+        model: 'gpt',
+        tools: ['double', 'filesystem.readFile'],
+      },
+    ]);
+  });
+
+  it('should describe values only known at run time', () => {
+    const plan = planRun(
+      parseSource('mcp remote {\n  url: env.SERVER_URL + "/mcp"\n}\n')
+    );
+    expect(plan.servers[0].url).toBe('env.SERVER_URL + "/mcp"');
+  });
+});
+
+describe('planRun calls', () => {
+  const SERVERS = `mcp fs { command: "fs-server", args: ["/tmp"] }
+model gpt { provider: "openai", model: "gpt-4o" }
+agent Triage { model: gpt }
+`;
+
+  function calls(source: string) {
+    return planRun(parseSource(SERVERS + source)).calls;
+  }
+
+  it('should list calls in order with known arguments filled in', () => {
+    expect(
+      calls(`
+dir = "/tmp/" + "notes"
+count = 2 * 3
+text = fs.readFile(dir + "/a.txt", { limit: count })
+summary = "Summarize: " + text | Triage
+fs.writeFile(dir + "/summary.txt", summary)
+`)
+    ).toEqual([
+      {
+        result: '$1',
+        kind: 'tool',
+        target: 'fs.readFile',
+        arguments: ['"/tmp/notes/a.txt"', '{"limit":6}'],
+        within: [],
+      },
+      {
+        result: '$2',
+        kind: 'agent',
+        target: 'Triage',
+        arguments: ['"Summarize: " + $1'],
+        within: [],
+      },
+      {
+        result: '$3',
+        kind: 'tool',
+        target: 'fs.writeFile',
+        arguments: ['"/tmp/notes/summary.txt"', '$2'],
+        within: [],
+      },
+    ]);
+  });
+
+  it('should follow branches known before the run', () => {
+    const plan = calls(`
+verbose = false
+if (verbose) {
+  fs.listDirectory("/tmp")
+} else {
+  fs.readFile("a.txt")
+}
+`);
+    expect(plan.map(call => call.target)).toEqual(['fs.readFile']);
+  });
+
+  it('should label calls that may not happen or repeat', () => {
+    const plan = calls(`
+text = fs.readFile("a.txt")
+if (text == "") {
+  text = "empty"
+} else {
+  fs.writeFile("b.txt", text)
+}
+fs.writeFile("c.txt", text)
+for (i = 0; i < 3; i = i + 1) {
+  fs.readFile("part" + i)
+}
+parallel {
+  fs.readFile("x.txt")
+}
+`);
+    expect(plan.map(({ arguments: args, within }) => [args, within])).toEqual(
+      [
+        [['"a.txt"'], []],
+        [['"b.txt"', '$1'], ['unless $1 == ""']],
+        [['"c.txt"', 'text'], []],
+        [['"part" + i'], ['for i < 3']],
+        [['"x.txt"'], ['parallel']],
+      ]
+    );
+  });
+
+  it('should inline the calls of tools', () => {
+    const plan = calls(`
+tool summarize(path) {
+  text = fs.readFile(path)
+  return text | Triage
+}
+summarize("a.txt")
+`);
+    expect(plan).toMatchObject([
+      {
+        target: 'fs.readFile',
+        arguments: ['"a.txt"'],
+        within: ['tool summarize'],
+      },
+      { target: 'Triage', arguments: ['$1'], within: ['tool summarize'] },
+    ]);
+  });
+
+  it('should format the servers and calls of a plan', () => {
+    const plan = planRun(
+      parseSource(`${SERVERS}
+text = fs.readFile("a.txt")
+if (text != "") {
+  text | Triage
+}
+`)
+    );
+    expect(formatPlan(plan)).toBe(
+      [
+        'Servers:',
+        '  fs: fs-server /tmp',
+        '',
+        'Calls:',
+        '  $1 = fs.readFile("a.txt")',
+        '  $2 = $1 | Triage  [if $1 != ""]',
+        '',
+      ].join('\n')
+    );
+    expect(formatPlan(planRun([]))).toBe(
+      'Servers:\n  (none)\n\nCalls:\n  (none)\n'
+    );
+  });
+});
@@ -0,0 +1,103 @@
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { createServer } from 'http';
+import type { AddressInfo } from 'net';
+import { escapeHtml } from '@mcpscript/transpiler';
+import {
+  analyzePlayground,
+  createPlaygroundHandler,
+} from '../../playground/handler.js';
+
+describe('playground', () => {
+  describe('analyzePlayground', () => {
+    it('should highlight the source as HTML', () => {
+      const { html } = analyzePlayground({ source: 'x = "<b>" // note\n' });
+      expect(html).toBe(
+        '<span class="tok-variable">x</span> ' +
+          '<span class="tok-operator">=</span> ' +
+          '<span class="tok-string">&quot;&lt;b&gt;&quot;</span> ' +
+          '<span class="tok-comment">// note</span>\n'
+      );
+    });
+
+    it('should keep the source text when tags are removed', () => {
+      const source = 'tool double(x) {\n  return x * 2\n}\nprint(double(2))\n';
+      const { html } = analyzePlayground({ source });
+      expect(html).toContain('<span class="tok-keyword">tool</span>');
+      expect(html.replace(/<[^>]+>/g, '')).toBe(escapeHtml(source));
+    });
+
+    it('should return the tree and diagnostics', () => {
+      const valid = analyzePlayground({ source: 'x = 1' });
+      expect(valid.tree).toMatch(/^\(source_file/);
+      expect(valid.diagnostics).toEqual([]);
+
+      const invalid = analyzePlayground({ source: 'print(missing)' });
+      expect(invalid.diagnostics[0]).toMatchObject({
+        code: 'undefined-variable',
+      });
+    });
+
+    it('should only plan scripts without errors when asked to', () => {
+      const source = 'model gpt {\n  provider: "openai"\n}\n';
+      expect(analyzePlayground({ source }).plan).toBeUndefined();
+      expect(analyzePlayground({ source, plan: true }).plan).toMatchObject({
+        models: [{ name: 'gpt', provider: 'openai' }],
+      });
+      expect(
+        analyzePlayground({ source: 'x = (1', plan: true }).plan
+      ).toBeUndefined();
+    });
+  });
+
+  describe('createPlaygroundHandler', () => {
+    let url: string;
+    let close: () => Promise<void>;
+
+    beforeAll(async () => {
+      const server = createServer(
+        createPlaygroundHandler({ allowOrigin: '*' })
+      );
+      await new Promise<void>(resolve =>
+        server.listen(0, '127.0.0.1', () => resolve())
+      );
+      url = `http://127.0.0.1:${(server.address() as AddressInfo).port}`;
+      close = () => new Promise(resolve => server.close(() => resolve()));
+    });
+
+    afterAll(async () => {
+      await close();
+    });
+
+    it('should answer POST requests with the analysis', async () => {
+      const response = await fetch(`${url}/play`, {
+        method: 'POST',
+        body: JSON.stringify({ source: 'x = 1', plan: true }),
+      });
+      expect(response.status).toBe(200);
+      expect(response.headers.get('access-control-allow-origin')).toBe('*');
+      const body = await response.json();
+      expect(body).toHaveProperty('html');
+      expect(body.plan).toEqual({
+        servers: [],
+        models: [],
+        agents: [],
+        tools: [],
+        calls: [],
+      });
+    });
+
+    it('should reject malformed requests', async () => {
+      const get = await fetch(url);
+      expect(get.status).toBe(405);
+
+      const invalid = await fetch(url, {
+        method: 'POST',
+        body: JSON.stringify({ source: 1 }),
+      });
+      expect(invalid.status).toBe(400);
+      expect(await invalid.json()).toEqual({
+        error: '"source" must be a string',
+      });
+    });
+  });
+});
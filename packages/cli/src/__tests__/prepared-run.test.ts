@@ -0,0 +1,93 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { AnalysisCache } from '../cache.js';
+import { prepareRun } from '../prepared-run.js';
+import { flagArguments } from '../arguments.js';
+
+const SCRIPT = `import "./greeting"
+
+tool main(name: string, times: number = 1) {
+  print(greet(name))
+}
+`;
+
+describe('prepareRun', () => {
+  let dir: string;
+  let cache: AnalysisCache;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-prepared-'));
+    cache = new AnalysisCache(join(dir, 'cache'), '1.0.0');
+    await writeFile(
+      join(dir, 'greeting.mcps'),
+      'tool greet(name: string) {\n  return "Hello " + name\n}\n'
+    );
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should reuse the run prepared for an unchanged script', async () => {
+    const file = join(dir, 'hello.mcps');
+    const options = { codegen: { sourcePositions: true }, cache };
+    const set = vi.spyOn(cache, 'set');
+
+    const first = await prepareRun(file, SCRIPT, { config: {} }, options);
+    expect(first.jsCode).toContain('Hello ');
+    expect(first.flags?.map(flag => flag.flag)).toEqual(['--name', '--times']);
+    expect(flagArguments(file, first.flags, ['--name', 'Ada'])).toEqual({
+      name: 'Ada',
+    });
+
+    const second = await prepareRun(file, SCRIPT, { config: {} }, options);
+    expect(second).toEqual(first);
+    expect(set).toHaveBeenCalledTimes(1);
+
+    // Other compile options are prepared apart
+    await prepareRun(file, SCRIPT, { config: {} }, { ...options, codegen: {} });
+    expect(set).toHaveBeenCalledTimes(2);
+  });
+
+  it('should prepare the script again once an import changes', async () => {
+    const file = join(dir, 'hello.mcps');
+    const options = { codegen: {}, cache };
+    await prepareRun(file, SCRIPT, { config: {} }, options);
+
+    await writeFile(
+      join(dir, 'greeting.mcps'),
+      'tool greet(name: string) {\n  return "Hi " + name\n}\n'
+    );
+    const prepared = await prepareRun(file, SCRIPT, { config: {} }, options);
+    expect(prepared.jsCode).toContain('Hi ');
+  });
+
+  it('should cache nothing for scripts that fail their checks', async () => {
+    const file = join(dir, 'broken.mcps');
+    const source = 'x: number = "one"\n';
+    const set = vi.spyOn(cache, 'set');
+
+    for (let i = 0; i < 2; i++) {
+      await expect(
+        prepareRun(file, source, { config: {} }, { codegen: {}, cache })
+      ).rejects.toThrow();
+    }
+    expect(set).not.toHaveBeenCalled();
+  });
+
+  it('should take no flags for scripts without a main tool', async () => {
+    const file = join(dir, 'plain.mcps');
+    const prepared = await prepareRun(
+      file,
+      'print("hi")\n',
+      { config: {} },
+      { codegen: {} }
+    );
+    expect(prepared.flags).toBeUndefined();
+    expect(() => flagArguments(file, prepared.flags, ['--x', '1'])).toThrow(
+      `${file} declares no main tool, so it takes no flags`
+    );
+  });
+});
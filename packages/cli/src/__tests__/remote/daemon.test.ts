@@ -0,0 +1,487 @@
+import { describe, it, expect, beforeAll, afterAll, vi } from 'vitest';
+import { mkdir, mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import type { AddressInfo } from 'net';
+import { startDaemon } from '../../remote/daemon.js';
+import type { OidcVerifier } from '../../remote/oidc.js';
+import { readRunLog, runRemote } from '../../remote/client.js';
+import {
+  EventDecoder,
+  PROJECTS_PATH,
+  QUOTAS_PATH,
+  RUNS_PATH,
+  type RunEvent,
+  type RunStatus,
+} from '../../remote/protocol.js';
+
+// Stands in for the real worker: echoes the request and reports the
+// daemon's environment, which remote runs are meant to use, the role it
+// runs in and the caller it works for, with two entries of its log. A
+// script named wait.mcps keeps running until it is cancelled, and busy.mcps
+// calls two tools first
+const FAKE_WORKER = `
+const log = (severity, kind, message, server) => process.send({
+  type: 'log',
+  entry: { time: '2026-10-16T09:30:00.000Z', severity, kind, message, server },
+});
+process.once('message', request => {
+  if (request.file === 'busy.mcps') {
+    log('info', 'tool_call', 'Called fs.readFile', 'fs');
+    log('info', 'tool_call', 'Called fs.writeFile', 'fs');
+  }
+  if (request.file === 'wait.mcps' || request.file === 'busy.mcps') {
+    console.log('waiting in ' + process.cwd());
+    setInterval(() => {}, 1000);
+    return;
+  }
+  const role = request.role ? ' as ' + request.role : '';
+  console.log('running ' + request.file + role + ': ' + request.source);
+  console.error('secret is ' + process.env.EXECUTOR_SECRET);
+  console.error('caller is ' + JSON.stringify(request.caller));
+  log('debug', 'statement', 'print(1)');
+  log('info', 'tool_call', 'Called fs.readFile', 'fs');
+  const message = { type: 'message', title: 'Agent[helper]', body: 'done' };
+  process.send(message, () => process.exit(3));
+});
+`;
+
+const ISSUER = 'https://login.example.com';
+
+describe('remote execution', () => {
+  let dir: string;
+  let url: string;
+  let close: () => Promise<void>;
+
+  beforeAll(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcpsd-'));
+    const workerPath = join(dir, 'worker.cjs');
+    await writeFile(workerPath, FAKE_WORKER, 'utf-8');
+
+    process.env.EXECUTOR_SECRET = 'from-daemon';
+    await mkdir(join(dir, 'reports'));
+    await writeFile(join(dir, 'reports', 'daily.mcps'), 'print("daily")');
+    for (const version of ['v1', 'v2']) {
+      await mkdir(join(dir, `billing-${version}`));
+      await writeFile(
+        join(dir, `billing-${version}`, 'invoice.mcps'),
+        `print("${version}")`
+      );
+    }
+    // Accepts the tokens "oidc.<subject>" of an OIDC issuer
+    const verifier = {
+      verify: async (token: string) => {
+        if (!token.startsWith('oidc.')) {
+          throw new Error('the token has an invalid signature');
+        }
+        return { subject: token.split('.')[1], issuer: ISSUER };
+      },
+    } as unknown as OidcVerifier;
+    const { server } = await startDaemon({
+      host: '127.0.0.1',
+      port: 0,
+      token: 'test-token',
+      workerPath,
+      oidc: { issuer: ISSUER, tenant: 'auditor' },
+      verifier,
+      projects: {
+        reports: join(dir, 'reports'),
+        other: dir,
+        billing: {
+          versions: {
+            v1: join(dir, 'billing-v1'),
+            v2: join(dir, 'billing-v2'),
+          },
+          traffic: { v1: 100 },
+        },
+      },
+      tenants: [
+        {
+          name: 'limited',
+          token: 'limited-token',
+          maxConcurrentRuns: 1,
+          projects: ['reports'],
+          allowSource: false,
+        },
+        {
+          name: 'auditor',
+          token: 'auditor-token',
+          role: 'reader',
+          subject: 'audit-bot',
+        },
+        { name: 'metered', token: 'metered-token', maxToolCallsPerHour: 1 },
+      ],
+    });
+    url = `http://127.0.0.1:${(server.address() as AddressInfo).port}`;
+    close = () => new Promise(resolve => server.close(() => resolve()));
+  });
+
+  afterAll(async () => {
+    delete process.env.EXECUTOR_SECRET;
+    await close();
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should stream output and return the exit code', async () => {
+    const stdout: string[] = [];
+    const stderr: string[] = [];
+    const stdoutSpy = vi
+      .spyOn(process.stdout, 'write')
+      .mockImplementation(text => stdout.push(String(text)) > 0);
+    const stderrSpy = vi
+      .spyOn(process.stderr, 'write')
+      .mockImplementation(text => stderr.push(String(text)) > 0);
+
+    let code;
+    try {
+      code = await runRemote({
+        url,
+        token: 'test-token',
+        file: 'job.mcps',
+        source: 'print(1)',
+      });
+    } finally {
+      stdoutSpy.mockRestore();
+      stderrSpy.mockRestore();
+    }
+
+    expect(code).toBe(3);
+    expect(stdout.join('')).toBe('running job.mcps: print(1)\n');
+    expect(stderr.join('')).toContain('secret is from-daemon\n');
+    expect(stderr.join('')).toContain('Agent[helper]\ndone\n');
+  });
+
+  it('should reject requests without the token', async () => {
+    await expect(
+      runRemote({ url, token: 'wrong', file: 'job.mcps', source: '' })
+    ).rejects.toThrow('Remote run rejected (401): Missing or invalid token');
+  });
+
+  it('should reject invalid requests', async () => {
+    const response = await fetch(new URL(RUNS_PATH, url), {
+      method: 'POST',
+      headers: { Authorization: 'Bearer test-token' },
+      body: JSON.stringify({ file: 'job.mcps' }),
+    });
+
+    expect(response.status).toBe(400);
+    expect(await response.json()).toEqual({
+      error: '"source" must be a string',
+    });
+  });
+
+  const api = (
+    path: string,
+    init: RequestInit & { token?: string } = {}
+  ): Promise<Response> =>
+    fetch(new URL(path, url), {
+      ...init,
+      headers: { Authorization: `Bearer ${init.token ?? 'test-token'}` },
+    });
+
+  const submit = (request: object, token?: string) =>
+    api(RUNS_PATH, {
+      method: 'POST',
+      token,
+      body: JSON.stringify({ ...request, detach: true }),
+    });
+
+  const events = async (id: string, token?: string) => {
+    const response = await api(`${RUNS_PATH}/${id}/events`, { token });
+    return new EventDecoder().push(await response.text());
+  };
+
+  it('should report detached runs and replay their events', async () => {
+    const response = await submit({ file: 'job.mcps', source: 'x = 1' });
+    expect(response.status).toBe(202);
+    const run = (await response.json()) as RunStatus;
+    expect(response.headers.get('location')).toBe(`${RUNS_PATH}/${run.id}`);
+    expect(run).toMatchObject({ tenant: 'default', file: 'job.mcps' });
+
+    // The events stream ends when the run exits
+    expect(await events(run.id)).toContainEqual({ type: 'exit', code: 3 });
+    const status = await api(`${RUNS_PATH}/${run.id}`);
+    expect(await status.json()).toMatchObject({
+      id: run.id,
+      state: 'failed',
+      exitCode: 3,
+    });
+
+    const list = (await (await api(RUNS_PATH)).json()) as {
+      runs: RunStatus[];
+    };
+    expect(list.runs.map(r => r.id)).toContain(run.id);
+  });
+
+  it('should stream the log of a run, filtered by server and severity', async () => {
+    const response = await submit({ file: 'job.mcps', source: 'x = 1' });
+    const run = (await response.json()) as RunStatus;
+    const read = async (filter: object) => {
+      const seen: RunEvent[] = [];
+      const code = await readRunLog(
+        { url, token: 'test-token', id: run.id, ...filter },
+        event => seen.push(event)
+      );
+      return { code, seen };
+    };
+
+    const followed = await read({ follow: true, servers: ['fs'] });
+    expect(followed).toEqual({
+      code: 3,
+      seen: [
+        {
+          type: 'log',
+          entry: expect.objectContaining({ message: 'Called fs.readFile' }),
+        },
+        { type: 'exit', code: 3 },
+      ],
+    });
+
+    // The events stream leaves the log out
+    expect((await events(run.id)).map(event => event.type)).not.toContain(
+      'log'
+    );
+    const { seen } = await read({ level: 'info' });
+    expect(seen.map(event => event.type)).toEqual(
+      expect.arrayContaining(['output', 'message', 'log', 'exit'])
+    );
+    expect(seen).not.toContainEqual(
+      expect.objectContaining({
+        entry: expect.objectContaining({ severity: 'debug' }),
+      })
+    );
+
+    await expect(
+      readRunLog(
+        { url, token: 'test-token', id: run.id, level: 'loud' as 'info' },
+        () => {}
+      )
+    ).rejects.toThrow(
+      'Reading the log failed (400): "level" must be one of debug, info, warn, error, not loud'
+    );
+  });
+
+  it('should cancel a run', async () => {
+    const response = await submit({ file: 'wait.mcps', source: '' });
+    const run = (await response.json()) as RunStatus;
+
+    const cancelled = await api(`${RUNS_PATH}/${run.id}`, {
+      method: 'DELETE',
+    });
+    expect(cancelled.status).toBe(200);
+    await events(run.id);
+    const status = await api(`${RUNS_PATH}/${run.id}`);
+    expect(await status.json()).toMatchObject({ state: 'cancelled' });
+  });
+
+  it('should run scripts from a registered project', async () => {
+    const response = await submit(
+      { project: 'reports', file: 'daily.mcps' },
+      'limited-token'
+    );
+    const run = (await response.json()) as RunStatus;
+
+    expect(await events(run.id, 'limited-token')).toContainEqual({
+      type: 'output',
+      stream: 'stdout',
+      text: 'running daily.mcps: print("daily")\n',
+    });
+  });
+
+  it('should split runs between versions and roll the split back', async () => {
+    const invoice = async (version?: string) => {
+      const response = await submit({
+        project: 'billing',
+        file: 'invoice.mcps',
+        version,
+      });
+      const run = (await response.json()) as RunStatus;
+      await events(run.id);
+      return run.version;
+    };
+    const setTraffic = (traffic: object, token?: string) =>
+      api(`${PROJECTS_PATH}/billing/traffic`, {
+        method: 'PUT',
+        token,
+        body: JSON.stringify({ traffic }),
+      });
+
+    expect(await invoice()).toBe('v1');
+    expect(await invoice('v2')).toBe('v2');
+
+    const changed = await setTraffic({ v2: 100 });
+    expect(await changed.json()).toEqual({
+      name: 'billing',
+      versions: ['v1', 'v2'],
+      traffic: { v2: 100 },
+      previousTraffic: { v1: 100 },
+    });
+    expect(await invoice()).toBe('v2');
+
+    const rolledBack = await api(`${PROJECTS_PATH}/billing/rollback`, {
+      method: 'POST',
+    });
+    expect(await rolledBack.json()).toMatchObject({ traffic: { v1: 100 } });
+    expect(await invoice()).toBe('v1');
+
+    const refused = await setTraffic({ v2: 100 }, 'auditor-token');
+    expect(refused.status).toBe(403);
+    expect(await refused.json()).toEqual({
+      error: 'Tenant auditor may not change the traffic of project billing',
+    });
+    const invalid = await setTraffic({ v3: 100 });
+    expect(invalid.status).toBe(400);
+  });
+
+  it('should enforce tenant policies', async () => {
+    const reject = async (request: object, status: number, error: string) => {
+      const response = await submit(request, 'limited-token');
+      expect(response.status).toBe(status);
+      expect(await response.json()).toEqual({ error });
+    };
+
+    await reject(
+      { file: 'job.mcps', source: '' },
+      403,
+      'Tenant limited may only run projects'
+    );
+    await reject(
+      { project: 'other', file: 'wait.mcps' },
+      403,
+      'Tenant limited may not run project other'
+    );
+    await reject(
+      { project: 'reports', file: '../wait.mcps' },
+      404,
+      '"../wait.mcps" is outside the project'
+    );
+
+    const projects = await api(PROJECTS_PATH, { token: 'limited-token' });
+    expect(await projects.json()).toEqual({ projects: ['reports'] });
+  });
+
+  it('should run in the role of the tenant or the request', async () => {
+    const output = async (request: object, token?: string) => {
+      const response = await submit(request, token);
+      const run = (await response.json()) as RunStatus;
+      return (await events(run.id, token)).find(
+        event => event.type === 'output' && event.stream === 'stdout'
+      );
+    };
+
+    expect(
+      await output({ file: 'job.mcps', source: 'x = 1' }, 'auditor-token')
+    ).toMatchObject({ text: 'running job.mcps as reader: x = 1\n' });
+    expect(
+      await output({ file: 'job.mcps', source: 'x = 1', role: 'operator' })
+    ).toMatchObject({ text: 'running job.mcps as operator: x = 1\n' });
+
+    const response = await submit(
+      { file: 'job.mcps', source: '', role: 'admin' },
+      'auditor-token'
+    );
+    expect(response.status).toBe(403);
+    expect(await response.json()).toEqual({
+      error: 'Tenant auditor may only run as reader',
+    });
+  });
+
+  it('should limit concurrent runs per tenant', async () => {
+    await writeFile(join(dir, 'reports', 'wait.mcps'), '');
+    const first = await submit(
+      { project: 'reports', file: 'wait.mcps' },
+      'limited-token'
+    );
+    const run = (await first.json()) as RunStatus;
+
+    const second = await submit(
+      { project: 'reports', file: 'daily.mcps' },
+      'limited-token'
+    );
+    expect(second.status).toBe(429);
+
+    await api(`${RUNS_PATH}/${run.id}`, {
+      method: 'DELETE',
+      token: 'limited-token',
+    });
+    await events(run.id, 'limited-token');
+  });
+
+  it('should stop runs going over a quota and refuse more', async () => {
+    const response = await submit(
+      { file: 'busy.mcps', source: '' },
+      'metered-token'
+    );
+    const run = (await response.json()) as RunStatus;
+    expect(await events(run.id, 'metered-token')).toContainEqual({
+      type: 'error',
+      message:
+        'Run cancelled: Tenant metered has used its 1 tool calls of this hour',
+      quota: expect.objectContaining({
+        name: 'toolCallsPerHour',
+        limit: 1,
+        used: 2,
+      }),
+    });
+
+    const refused = await submit(
+      { file: 'job.mcps', source: '' },
+      'metered-token'
+    );
+    expect(refused.status).toBe(429);
+    expect(await refused.json()).toMatchObject({
+      error: 'Tenant metered has used its 1 tool calls of this hour',
+      quota: { name: 'toolCallsPerHour', limit: 1, used: 2 },
+    });
+
+    const quotas = await api(QUOTAS_PATH, { token: 'metered-token' });
+    expect(await quotas.json()).toMatchObject({
+      tenant: 'metered',
+      quotas: {
+        concurrentRuns: { used: 0 },
+        toolCallsPerHour: { limit: 1, used: 2 },
+        tokensPerDay: { used: 0 },
+      },
+    });
+  });
+
+  it('should pass on the caller of the token a run was started with', async () => {
+    const caller = async (token: string) => {
+      const response = await submit({ file: 'job.mcps', source: '' }, token);
+      const run = (await response.json()) as RunStatus;
+      const stderr = (await events(run.id, token))
+        .map(event =>
+          event.type === 'output' && event.stream === 'stderr' ? event.text : ''
+        )
+        .join('');
+      return { status: run.caller, passed: /caller is (.*)/.exec(stderr)?.[1] };
+    };
+
+    expect(await caller('auditor-token')).toEqual({
+      status: 'audit-bot',
+      passed: '{"subject":"audit-bot","tenant":"auditor"}',
+    });
+    // OIDC callers come under the configured tenant
+    expect(await caller('oidc.alice.sig')).toEqual({
+      status: 'alice',
+      passed: `{"subject":"alice","issuer":"${ISSUER}","tenant":"auditor"}`,
+    });
+
+    const forged = await api(RUNS_PATH, { token: 'forged.alice.sig' });
+    expect(forged.status).toBe(401);
+    expect(await forged.json()).toEqual({
+      error: 'Invalid token: the token has an invalid signature',
+    });
+  });
+
+  it("should hide other tenants' runs", async () => {
+    const response = await submit({ file: 'job.mcps', source: '' });
+    const run = (await response.json()) as RunStatus;
+    await events(run.id);
+
+    const hidden = await api(`${RUNS_PATH}/${run.id}`, {
+      token: 'limited-token',
+    });
+    expect(hidden.status).toBe(404);
+  });
+});
@@ -0,0 +1,160 @@
+import { describe, it, expect, vi } from 'vitest';
+import { generateKeyPairSync, sign, type KeyObject } from 'crypto';
+import {
+  isJwt,
+  KEYS_REFRESH_INTERVAL,
+  OidcVerifier,
+  validateOidcConfig,
+} from '../../remote/oidc.js';
+
+const ISSUER = 'https://login.example.com';
+const NOW = Date.parse('2026-10-16T09:30:00Z');
+
+const rsa = generateKeyPairSync('rsa', { modulusLength: 2048 });
+const ec = generateKeyPairSync('ec', { namedCurve: 'P-256' });
+
+function jwt(
+  claims: Record<string, unknown>,
+  { kid = 'rsa-1', alg = 'RS256', key = rsa.privateKey as KeyObject } = {}
+): string {
+  const encode = (part: object) =>
+    Buffer.from(JSON.stringify(part)).toString('base64url');
+  const signed = `${encode({ alg, kid, typ: 'JWT' })}.${encode(claims)}`;
+  const signature = sign(
+    `sha${alg.slice(2)}`,
+    Buffer.from(signed),
+    alg.startsWith('ES') ? { key, dsaEncoding: 'ieee-p1363' } : key
+  );
+  return `${signed}.${signature.toString('base64url')}`;
+}
+
+const CLAIMS = {
+  iss: ISSUER,
+  aud: 'mcps',
+  sub: 'alice',
+  email: 'alice@example.com',
+  exp: NOW / 1000 + 300,
+};
+
+/**
+ * A fetch answering the issuer's discovery document and its keys
+ */
+function issuer(keys: Record<string, KeyObject>) {
+  return vi.fn(async (url: string) => {
+    const body =
+      url === `${ISSUER}/.well-known/openid-configuration`
+        ? { issuer: ISSUER, jwks_uri: `${ISSUER}/keys` }
+        : {
+            keys: Object.entries(keys).map(([kid, key]) => ({
+              ...key.export({ format: 'jwk' }),
+              kid,
+            })),
+          };
+    return new Response(JSON.stringify(body));
+  });
+}
+
+describe('OidcVerifier', () => {
+  it('should identify the subject of a valid token', async () => {
+    const fetchImpl = issuer({ 'rsa-1': rsa.publicKey, 'ec-1': ec.publicKey });
+    const verifier = new OidcVerifier(
+      { issuer: ISSUER, audience: 'mcps', claims: ['email', 'groups'] },
+      fetchImpl as unknown as typeof fetch,
+      () => NOW
+    );
+
+    expect(await verifier.verify(jwt(CLAIMS))).toEqual({
+      subject: 'alice',
+      issuer: ISSUER,
+      claims: { email: 'alice@example.com' },
+    });
+    const ecToken = jwt(CLAIMS, {
+      kid: 'ec-1',
+      alg: 'ES256',
+      key: ec.privateKey,
+    });
+    expect(await verifier.verify(ecToken)).toMatchObject({ subject: 'alice' });
+    // Keys are fetched once, after discovery
+    expect(fetchImpl).toHaveBeenCalledTimes(2);
+  });
+
+  it('should refuse tokens that are not valid for the issuer', async () => {
+    const verifier = new OidcVerifier(
+      { issuer: ISSUER, audience: 'mcps' },
+      issuer({ 'rsa-1': rsa.publicKey }) as unknown as typeof fetch,
+      () => NOW
+    );
+    const other = generateKeyPairSync('rsa', { modulusLength: 2048 });
+
+    await expect(
+      verifier.verify(jwt(CLAIMS, { key: other.privateKey }))
+    ).rejects.toThrow('the token has an invalid signature');
+    await expect(
+      verifier.verify(jwt({ ...CLAIMS, iss: 'https://evil.example.com' }))
+    ).rejects.toThrow(`the token was not issued by ${ISSUER}`);
+    await expect(
+      verifier.verify(jwt({ ...CLAIMS, aud: ['other'] }))
+    ).rejects.toThrow('the token is not meant for mcps');
+    await expect(
+      verifier.verify(jwt({ ...CLAIMS, exp: NOW / 1000 - 120 }))
+    ).rejects.toThrow('the token has expired');
+    await expect(
+      verifier.verify(jwt({ ...CLAIMS, nbf: NOW / 1000 + 120 }))
+    ).rejects.toThrow('the token is not valid yet');
+    await expect(
+      verifier.verify(jwt({ ...CLAIMS, sub: undefined }))
+    ).rejects.toThrow('the token has no sub claim');
+    await expect(
+      verifier.verify(jwt(CLAIMS, { alg: 'HS256' }))
+    ).rejects.toThrow('unsupported signing algorithm HS256');
+  });
+
+  it('should fetch the keys again for a key it does not know', async () => {
+    const keys: Record<string, KeyObject> = { 'rsa-1': rsa.publicKey };
+    const fetchImpl = issuer(keys);
+    let now = NOW;
+    const verifier = new OidcVerifier(
+      { issuer: ISSUER, jwksUri: `${ISSUER}/keys` },
+      fetchImpl as unknown as typeof fetch,
+      () => now
+    );
+    await verifier.verify(jwt(CLAIMS));
+
+    // The issuer rotates to a new key
+    keys['ec-2'] = ec.publicKey;
+    const rotated = jwt(CLAIMS, {
+      kid: 'ec-2',
+      alg: 'ES256',
+      key: ec.privateKey,
+    });
+    await expect(verifier.verify(rotated)).rejects.toThrow(
+      'the issuer has no signing key ec-2'
+    );
+    now += KEYS_REFRESH_INTERVAL;
+    expect(await verifier.verify(rotated)).toMatchObject({ subject: 'alice' });
+    expect(fetchImpl).toHaveBeenCalledTimes(2);
+    expect(fetchImpl).toHaveBeenCalledWith(`${ISSUER}/keys`);
+  });
+});
+
+describe('validateOidcConfig', () => {
+  it('should name the invalid field', () => {
+    expect(() => validateOidcConfig('oidc', { issuer: ISSUER })).not.toThrow();
+    expect(() => validateOidcConfig('oidc', 'login')).toThrow(
+      '"oidc" must be an object'
+    );
+    expect(() =>
+      validateOidcConfig('oidc', { issuer: ISSUER, jwksUri: 'keys' })
+    ).toThrow('"oidc.jwksUri" must be a URL');
+    expect(() =>
+      validateOidcConfig('oidc', { issuer: ISSUER, subjectClaim: '' })
+    ).toThrow('"oidc.subjectClaim" must be a non-empty string');
+  });
+});
+
+describe('isJwt', () => {
+  it('should tell JWTs from other bearer tokens', () => {
+    expect(isJwt(jwt(CLAIMS))).toBe(true);
+    expect(isJwt('test-token')).toBe(false);
+  });
+});
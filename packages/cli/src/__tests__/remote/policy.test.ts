@@ -0,0 +1,134 @@
+import { describe, it, expect } from 'vitest';
+import { join } from 'path';
+import {
+  authenticate,
+  effectiveTimeout,
+  resolveProjectFile,
+  validateDaemonConfig,
+  type TenantPolicy,
+} from '../../remote/policy.js';
+
+const TENANTS: TenantPolicy[] = [
+  { name: 'ci', token: 'ci-token', maxTimeout: 60000 },
+  { name: 'ops', token: 'ops-token' },
+];
+
+describe('daemon policy', () => {
+  describe('validateDaemonConfig', () => {
+    it('should accept a valid config', () => {
+      const config = {
+        maxConcurrentRuns: 4,
+        projects: {
+          reports: './reports',
+          billing: {
+            versions: { v1: './billing-v1', v2: './billing-v2' },
+            traffic: { v1: 90, v2: 10 },
+          },
+        },
+        tenants: [
+          {
+            ...TENANTS[0],
+            subject: 'ci-bot',
+            projects: ['reports'],
+            allowSource: false,
+          },
+        ],
+        oidc: { issuer: 'https://login.example.com', tenant: 'ci' },
+      };
+      expect(validateDaemonConfig(config)).toEqual(config);
+    });
+
+    it('should name the invalid field', () => {
+      expect(() => validateDaemonConfig({ maxConcurrentRuns: 0 })).toThrow(
+        '"maxConcurrentRuns" must be a positive integer'
+      );
+      expect(() => validateDaemonConfig({ projects: { a: 1 } })).toThrow(
+        '"projects.a" must be a directory path'
+      );
+      expect(() =>
+        validateDaemonConfig({ tenants: [TENANTS[0], TENANTS[0]] })
+      ).toThrow('"tenants[1].name" must be a unique string');
+      expect(() =>
+        validateDaemonConfig({ tenants: [{ name: 'a', token: '' }] })
+      ).toThrow('"tenants[0].token" must be a non-empty string');
+      expect(() =>
+        validateDaemonConfig({
+          tenants: [{ name: 'a', token: 't', maxTimeout: -1 }],
+        })
+      ).toThrow('"tenants[0].maxTimeout" must be a positive integer');
+      expect(() =>
+        validateDaemonConfig({
+          tenants: [{ name: 'a', token: 't', maxTokensPerDay: 0 }],
+        })
+      ).toThrow('"tenants[0].maxTokensPerDay" must be a positive integer');
+      expect(() =>
+        validateDaemonConfig({ parseLimits: { maxDepth: 1.5 } })
+      ).toThrow('"parseLimits.maxDepth" must be a positive integer');
+      expect(() =>
+        validateDaemonConfig({ tenants: [{ name: 'a', token: 't', role: '' }] })
+      ).toThrow('"tenants[0].role" must be a non-empty string');
+      expect(() =>
+        validateDaemonConfig({
+          tenants: [{ name: 'a', token: 't', subject: 1 }],
+        })
+      ).toThrow('"tenants[0].subject" must be a non-empty string');
+      expect(() =>
+        validateDaemonConfig({
+          tenants: TENANTS,
+          oidc: { issuer: 'https://login.example.com', tenant: 'qa' },
+        })
+      ).toThrow('"oidc.tenant" must name one of the tenants');
+      expect(() =>
+        validateDaemonConfig({ tenants: TENANTS, oidc: { tenant: 'ci' } })
+      ).toThrow('"oidc.issuer" must be a URL');
+    });
+
+    it('should check the versions of projects and their traffic', () => {
+      const billing = (project: object) =>
+        validateDaemonConfig({ projects: { billing: project } });
+      expect(() => billing({ versions: {} })).toThrow(
+        '"projects.billing.versions" must map version names to directory paths'
+      );
+      expect(() =>
+        billing({ versions: { v1: 'a' }, traffic: { v1: -1 } })
+      ).toThrow(
+        '"projects.billing.traffic" must map versions to non-negative shares'
+      );
+      expect(() =>
+        billing({ versions: { v1: 'a' }, traffic: { v2: 100 } })
+      ).toThrow('"projects.billing.traffic" names unknown version v2');
+      expect(() =>
+        billing({ versions: { v1: 'a' }, traffic: { v1: 0 } })
+      ).toThrow(
+        '"projects.billing.traffic" must give at least one version a share'
+      );
+    });
+  });
+
+  it('should find the tenant presenting a token', () => {
+    expect(authenticate('Bearer ops-token', TENANTS)?.name).toBe('ops');
+    expect(authenticate('Bearer nope', TENANTS)).toBeUndefined();
+    expect(authenticate(undefined, TENANTS)).toBeUndefined();
+  });
+
+  it('should cap timeouts at the tenant maximum', () => {
+    expect(effectiveTimeout(TENANTS[0], 1000)).toBe(1000);
+    expect(effectiveTimeout(TENANTS[0], 120000)).toBe(60000);
+    expect(effectiveTimeout(TENANTS[0], 0)).toBe(60000);
+    expect(effectiveTimeout(TENANTS[0], undefined)).toBe(60000);
+    expect(effectiveTimeout(TENANTS[1], 0)).toBe(0);
+  });
+
+  it('should keep project files inside the project', () => {
+    const dir = join('/srv', 'reports');
+    expect(resolveProjectFile(dir, 'jobs/daily.mcps')).toBe(
+      join(dir, 'jobs', 'daily.mcps')
+    );
+    expect(() => resolveProjectFile(dir, '../secrets.mcps')).toThrow(
+      '"../secrets.mcps" is outside the project'
+    );
+    expect(() => resolveProjectFile(dir, '/etc/x.mcps')).toThrow(
+      'is outside the project'
+    );
+  });
+});
@@ -0,0 +1,69 @@
+import { describe, it, expect } from 'vitest';
+import { ProjectRegistry } from '../../remote/projects.js';
+
+function registry(random = () => 0) {
+  return new ProjectRegistry(
+    {
+      reports: '/srv/reports',
+      billing: {
+        versions: { v1: '/srv/billing-v1', v2: '/srv/billing-v2' },
+        traffic: { v1: 90, v2: 10 },
+      },
+    },
+    random
+  );
+}
+
+describe('ProjectRegistry', () => {
+  it('should split runs between versions by their traffic', () => {
+    expect(registry(() => 0.5).target('billing')).toEqual({
+      dir: '/srv/billing-v1',
+      version: 'v1',
+    });
+    expect(registry(() => 0.95).target('billing')).toEqual({
+      dir: '/srv/billing-v2',
+      version: 'v2',
+    });
+    expect(registry().target('reports')).toEqual({ dir: '/srv/reports' });
+  });
+
+  it('should run the version a run pins', () => {
+    expect(registry().target('billing', 'v2').dir).toBe('/srv/billing-v2');
+    expect(() => registry().target('billing', 'v3')).toThrow(
+      'Project billing has no version v3'
+    );
+    expect(() => registry().target('reports', 'v1')).toThrow(
+      'Project reports has no version v1'
+    );
+  });
+
+  it('should change the traffic and roll it back', () => {
+    const projects = registry(() => 0.5);
+    expect(projects.setTraffic('billing', { v2: 100 })).toEqual({
+      name: 'billing',
+      versions: ['v1', 'v2'],
+      traffic: { v2: 100 },
+      previousTraffic: { v1: 90, v2: 10 },
+    });
+    expect(projects.target('billing').version).toBe('v2');
+
+    expect(projects.rollback('billing').traffic).toEqual({ v1: 90, v2: 10 });
+    expect(projects.target('billing').version).toBe('v1');
+    expect(() => projects.rollback('billing')).toThrow(
+      'Project billing has no earlier traffic to roll back to'
+    );
+    expect(() => projects.setTraffic('billing', { v3: 1 })).toThrow(
+      '"traffic" names unknown version v3'
+    );
+    expect(() => projects.setTraffic('reports', { v1: 1 })).toThrow(
+      'Project reports has no versions'
+    );
+  });
+
+  it('should send every run to the first version without traffic', () => {
+    const projects = new ProjectRegistry({
+      billing: { versions: { v1: '/a', v2: '/b' } },
+    });
+    expect(projects.status('billing').traffic).toEqual({ v1: 100 });
+  });
+});
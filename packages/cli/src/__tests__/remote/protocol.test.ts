@@ -0,0 +1,105 @@
+import { describe, it, expect } from 'vitest';
+import {
+  EventDecoder,
+  encodeEvent,
+  matchesLogFilter,
+  parseLogFilter,
+  validateRunRequest,
+  type RunEvent,
+} from '../../remote/protocol.js';
+
+describe('remote protocol', () => {
+  describe('validateRunRequest', () => {
+    it('should accept a valid request', () => {
+      expect(
+        validateRunRequest({ file: 'job.mcps', source: 'x = 1', timeout: 0 })
+      ).toEqual({ file: 'job.mcps', source: 'x = 1', timeout: 0 });
+    });
+
+    it('should accept project runs without source', () => {
+      expect(
+        validateRunRequest({
+          file: 'jobs/daily.mcps',
+          project: 'reports',
+          detach: true,
+        })
+      ).toEqual({ file: 'jobs/daily.mcps', project: 'reports', detach: true });
+    });
+
+    it('should name the invalid field', () => {
+      expect(() => validateRunRequest([])).toThrow(
+        'request must be a JSON object'
+      );
+      expect(() => validateRunRequest({ file: 'job.js', source: '' })).toThrow(
+        '"file" must be a .mcps file name'
+      );
+      expect(() => validateRunRequest({ file: 'job.mcps' })).toThrow(
+        '"source" must be a string'
+      );
+      expect(() =>
+        validateRunRequest({ file: 'job.mcps', source: '', timeout: -1 })
+      ).toThrow('"timeout" must be a non-negative integer');
+      expect(() =>
+        validateRunRequest({ file: 'job.mcps', project: 1, source: '' })
+      ).toThrow('"project" must be a string');
+      expect(() =>
+        validateRunRequest({ file: 'job.mcps', source: '', detach: 'yes' })
+      ).toThrow('"detach" must be a boolean');
+      expect(() =>
+        validateRunRequest({ file: 'job.mcps', source: '', role: 1 })
+      ).toThrow('"role" must be a string');
+    });
+  });
+
+  describe('EventDecoder', () => {
+    it('should decode events split across chunks', () => {
+      const stream =
+        encodeEvent({ type: 'output', stream: 'stdout', text: 'hi\n' }) +
+        encodeEvent({ type: 'exit', code: 0 });
+      const decoder = new EventDecoder();
+
+      expect(decoder.push(stream.slice(0, 20))).toEqual([]);
+      expect(decoder.pending).toBe(true);
+      expect(decoder.push(stream.slice(20))).toEqual([
+        { type: 'output', stream: 'stdout', text: 'hi\n' },
+        { type: 'exit', code: 0 },
+      ]);
+      expect(decoder.pending).toBe(false);
+    });
+  });
+
+  describe('log filters', () => {
+    const log = (severity: 'debug' | 'error', server?: string): RunEvent => ({
+      type: 'log',
+      entry: {
+        time: '2026-10-16T09:30:00.000Z',
+        severity,
+        kind: 'tool_call',
+        message: 'Called fs.readFile',
+        ...(server && { server }),
+      },
+    });
+
+    it('should read filters from query parameters', () => {
+      expect(
+        parseLogFilter(new URLSearchParams('server=fs&server=git&level=warn'))
+      ).toEqual({ servers: ['fs', 'git'], level: 'warn' });
+      expect(parseLogFilter(new URLSearchParams())).toEqual({});
+      expect(() => parseLogFilter(new URLSearchParams('level=loud'))).toThrow(
+        '"level" must be one of debug, info, warn, error, not loud'
+      );
+    });
+
+    it('should keep events of the servers and severities asked for', () => {
+      const output: RunEvent = { type: 'output', stream: 'stdout', text: '' };
+      const filter = { servers: ['fs'], level: 'info' as const };
+      expect(matchesLogFilter(log('error', 'fs'), filter)).toBe(true);
+      expect(matchesLogFilter(log('debug', 'fs'), filter)).toBe(false);
+      expect(matchesLogFilter(log('error', 'git'), filter)).toBe(false);
+      expect(matchesLogFilter(output, filter)).toBe(false);
+      expect(matchesLogFilter(output, { level: 'info' })).toBe(true);
+      expect(matchesLogFilter(output, { level: 'warn' })).toBe(false);
+      expect(matchesLogFilter({ type: 'exit', code: 1 }, filter)).toBe(true);
+    });
+  });
+});
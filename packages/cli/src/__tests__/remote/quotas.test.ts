@@ -0,0 +1,71 @@
+import { describe, it, expect } from 'vitest';
+import type { LogEntry } from '@mcpscript/runtime';
+import { exceededQuota, QuotaTracker } from '../../remote/quotas.js';
+
+const TENANT = {
+  name: 'ci',
+  token: 'ci-token',
+  maxConcurrentRuns: 2,
+  maxToolCallsPerHour: 2,
+  maxTokensPerDay: 1000,
+};
+
+function entry(fields: Partial<LogEntry>): LogEntry {
+  return {
+    time: '2026-10-16T09:30:00.000Z',
+    severity: 'info',
+    kind: 'tool_call',
+    message: '',
+    ...fields,
+  };
+}
+
+describe('QuotaTracker', () => {
+  it('should count tool calls by hour and tokens by day', () => {
+    let now = Date.parse('2026-10-16T09:30:00Z');
+    const quotas = new QuotaTracker(() => now);
+    quotas.record('ci', entry({ server: 'fs', tool: 'readFile' }));
+    quotas.record('ci', entry({ tool: 'summarize' }));
+    quotas.record('ci', entry({ kind: 'agent_turn', tokens: 400 }));
+    quotas.record('other', entry({ server: 'fs', tool: 'readFile' }));
+
+    expect(quotas.status(TENANT, 1)).toEqual({
+      tenant: 'ci',
+      quotas: {
+        concurrentRuns: { limit: 2, used: 1 },
+        toolCallsPerHour: {
+          limit: 2,
+          used: 1,
+          resetsAt: '2026-10-16T10:00:00.000Z',
+        },
+        tokensPerDay: {
+          limit: 1000,
+          used: 400,
+          resetsAt: '2026-10-17T00:00:00.000Z',
+        },
+      },
+    });
+
+    now = Date.parse('2026-10-16T10:05:00Z');
+    const { quotas: later } = quotas.status(TENANT, 0);
+    expect(later.toolCallsPerHour.used).toBe(0);
+    expect(later.tokensPerDay.used).toBe(400);
+  });
+
+  it('should find the quotas used up or gone beyond', () => {
+    const quotas = new QuotaTracker();
+    quotas.record('ci', entry({ server: 'fs' }));
+    quotas.record('ci', entry({ server: 'fs' }));
+
+    expect(exceededQuota(quotas.status(TENANT, 0))).toMatchObject({
+      name: 'toolCallsPerHour',
+      limit: 2,
+      used: 2,
+    });
+    expect(exceededQuota(quotas.status(TENANT, 0), true)).toBeUndefined();
+    expect(exceededQuota(quotas.status(TENANT, 2), true)).toBeUndefined();
+    expect(
+      exceededQuota(quotas.status({ name: 'ci', token: 't' }, 5))
+    ).toBeUndefined();
+  });
+});
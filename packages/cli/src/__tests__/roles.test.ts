@@ -0,0 +1,98 @@
+import { describe, it, expect } from 'vitest';
+import { mkdtemp, readFile, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  alwaysApprovedCall,
+  findRole,
+  saveApprovedCall,
+} from '../roles.js';
+
+describe('findRole', () => {
+  const loaded = {
+    path: '/project/.mcpsrc',
+    config: {
+      roles: {
+        reader: { allowTools: ['fs.read*'], maxToolCalls: 20 },
+        operator: { requireApproval: ['fs.write*'], maxTimeout: 60000 },
+      },
+    },
+  };
+
+  it('should turn a role into an execution profile', () => {
+    expect(findRole(loaded, 'reader')).toEqual({
+      profile: { role: 'reader', allowTools: ['fs.read*'], maxToolCalls: 20 },
+    });
+    expect(findRole(loaded, 'operator')).toEqual({
+      profile: { role: 'operator', requireApproval: ['fs.write*'] },
+      maxTimeout: 60000,
+    });
+  });
+
+  it('should refuse roles the project does not define', () => {
+    expect(() => findRole(loaded, 'admin')).toThrow(
+      'Unknown role "admin"; /project/.mcpsrc defines reader, operator'
+    );
+    expect(() => findRole(loaded, 'toString')).toThrow('Unknown role');
+    expect(() => findRole({ config: {} }, 'reader')).toThrow(
+      'Unknown role "reader"; the project config defines no roles'
+    );
+  });
+});
+
+describe('alwaysApprovedCall', () => {
+  it('should approve calls with the same arguments by default', () => {
+    expect(alwaysApprovedCall('gh.createIssue', { repo: 'a/b' }, [])).toEqual({
+      tool: 'gh.createIssue',
+      input: { repo: 'a/b' },
+    });
+  });
+
+  it('should approve calls whose arguments match the patterns', () => {
+    expect(
+      alwaysApprovedCall('gh.createIssue', { repo: 'a/b', draft: true }, [
+        'repo=a/*',
+        'draft=true',
+        'label="7"',
+      ])
+    ).toEqual({
+      tool: 'gh.createIssue',
+      input: { repo: 'a/*', draft: true, label: '7' },
+    });
+    expect(() => alwaysApprovedCall('gh.createIssue', {}, ['repo'])).toThrow(
+      'Expected name=pattern, got repo'
+    );
+  });
+});
+
+describe('saveApprovedCall', () => {
+  it("should add the call to the role's approved calls", async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-roles-'));
+    const path = join(dir, '.mcpsrc');
+    try {
+      const config = {
+        locale: 'en-US',
+        roles: { operator: { requireApproval: ['gh.create*'] } },
+      };
+      await writeFile(path, JSON.stringify(config));
+      const loaded = { path, config };
+      await saveApprovedCall(loaded, 'operator', { tool: 'gh.createIssue' });
+      await saveApprovedCall(loaded, 'operator', { tool: 'gh.createPr' });
+
+      expect(JSON.parse(await readFile(path, 'utf-8'))).toEqual({
+        locale: 'en-US',
+        roles: {
+          operator: {
+            requireApproval: ['gh.create*'],
+            approvedCalls: [
+              { tool: 'gh.createIssue' },
+              { tool: 'gh.createPr' },
+            ],
+          },
+        },
+      });
+    } finally {
+      await rm(dir, { recursive: true, force: true });
+    }
+  });
+});
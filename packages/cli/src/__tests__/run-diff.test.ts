@@ -0,0 +1,122 @@
+import { describe, it, expect } from 'vitest';
+import type { ToolCallRecord, Transcript } from '@mcpscript/runtime';
+import { diffRuns, formatRunChange, valueDifferences } from '../run-diff.js';
+
+const START = 1_000_000;
+
+/**
+ * A transcript of calls answered the given milliseconds after the
+ * previous one
+ */
+function transcript(
+  calls: (Omit<ToolCallRecord, 'time'> & { after?: number })[],
+  extra: Partial<Transcript> = {}
+): Transcript {
+  let time = START;
+  return {
+    file: '/work/triage.mcps',
+    startedAt: START,
+    runs: [],
+    toolCalls: calls.map(({ after = 100, ...call }) => {
+      time += after;
+      return { ...call, time };
+    }),
+    ...extra,
+  };
+}
+
+function text(result: string) {
+  return { content: [{ type: 'text', text: result }] };
+}
+
+describe('valueDifferences', () => {
+  it('should give the paths of changed values', () => {
+    expect(
+      valueDifferences(
+        { query: 'bug', labels: ['a'], page: 1 },
+        { query: 'bugs', labels: ['a', 'b'], page: 1 }
+      )
+    ).toEqual([
+      { path: 'query', before: 'bug', after: 'bugs' },
+      { path: 'labels', before: ['a'], after: ['a', 'b'] },
+    ]);
+  });
+});
+
+describe('diffRuns', () => {
+  it('should find no changes between alike runs', () => {
+    const calls = [
+      { tool: 'github.search', input: { q: 'bug' }, result: text('3') },
+    ];
+    expect(diffRuns(transcript(calls), transcript(calls))).toEqual([]);
+  });
+
+  it('should line up calls made in only one of the runs', () => {
+    const before = transcript([
+      { tool: 'github.search', input: {}, result: text('3') },
+      { tool: 'github.createIssue', input: {}, result: text('#1') },
+      { tool: 'slack.post', input: {}, result: text('ok') },
+    ]);
+    const after = transcript([
+      { tool: 'github.search', input: {}, result: text('3') },
+      { tool: 'jira.createIssue', input: {}, result: text('J-1') },
+      { tool: 'slack.post', input: {}, result: text('ok') },
+    ]);
+    expect(diffRuns(before, after).map(formatRunChange)).toEqual([
+      '- call #2 github.createIssue: not called',
+      '+ call #2 jira.createIssue: called',
+    ]);
+  });
+
+  it('should compare the arguments, results and errors of calls', () => {
+    const before = transcript([
+      { tool: 'github.search', input: { q: 'bug' }, result: text('3') },
+      { tool: 'github.createIssue', input: {}, result: text('#1') },
+    ]);
+    const after = transcript([
+      { tool: 'github.search', input: { q: 'bugs' }, result: text('0') },
+      { tool: 'github.createIssue', input: {}, error: 'Rate limited' },
+    ]);
+    const changes = diffRuns(before, after);
+    expect(changes.map(formatRunChange)).toEqual([
+      '~ call #1 github.search: arguments q: "bug" -> "bugs"',
+      '~ call #1 github.search: result content[0].text: "3" -> "0"',
+      '~ call #2 github.createIssue: error: (none) -> "Rate limited"',
+    ]);
+    expect(changes[0]).toMatchObject({ before: 'bug', after: 'bugs' });
+  });
+
+  it('should report timings that changed by more than the threshold', () => {
+    const before = transcript([
+      { tool: 'fs.read', input: {}, result: text('a'), after: 200 },
+      { tool: 'fs.write', input: {}, result: text('ok'), after: 300 },
+    ]);
+    const after = transcript([
+      { tool: 'fs.read', input: {}, result: text('a'), after: 3200 },
+      { tool: 'fs.write', input: {}, result: text('ok'), after: 500 },
+    ]);
+    expect(diffRuns(before, after).map(formatRunChange)).toEqual([
+      '~ run: took 500 ms -> 3.70 s',
+      '~ call #1 fs.read: took 200 ms -> 3.20 s',
+    ]);
+    expect(diffRuns(before, after, { timingThreshold: 5000 })).toEqual([]);
+  });
+
+  it('should compare the replies of agent runs', () => {
+    const run = (reply: string) => ({
+      run: 1,
+      agent: 'Triager',
+      messages: [
+        { role: 'user' as const, content: 'Triage the issues' },
+        { role: 'assistant' as const, content: reply },
+      ],
+      startedAt: START,
+      finishedAt: START + 100,
+    });
+    const before = transcript([], { runs: [run('2 bugs')] });
+    const after = transcript([], { runs: [run('No bugs')] });
+    expect(diffRuns(before, after).map(formatRunChange)).toEqual([
+      '~ agent run #1 Triager: reply: "2 bugs" -> "No bugs"',
+    ]);
+  });
+});
@@ -0,0 +1,79 @@
+import { describe, it, expect } from 'vitest';
+import { mkdtemp, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { parseSource } from '@mcpscript/transpiler';
+import {
+  checkScriptPolicy,
+  loadSandboxPolicy,
+  SandboxPolicyError,
+} from '../sandbox.js';
+
+const SCRIPT = `
+mcp filesystem {
+  command: "npx",
+  args: ["-y", "@modelcontextprotocol/server-filesystem", "/tmp"]
+}
+mcp search {
+  url: "https://search.example.com/mcp"
+}
+text = filesystem.read_file("/tmp/notes.txt")
+results = search.query(text)
+`;
+
+describe('checkScriptPolicy', () => {
+  it('should accept scripts within the policy', () => {
+    expect(() =>
+      checkScriptPolicy(parseSource(SCRIPT), {
+        allowCommands: ['npx'],
+        allowHosts: ['*.example.com'],
+        allowTools: ['filesystem.read_*', 'search.*'],
+        maxProcesses: 1,
+      })
+    ).not.toThrow();
+  });
+
+  it('should list everything the script declares against the policy', () => {
+    let error: unknown;
+    try {
+      checkScriptPolicy(parseSource(SCRIPT), {
+        allowNetwork: false,
+        allowTools: ['filesystem.*'],
+      });
+    } catch (e) {
+      error = e;
+    }
+    expect(error).toBeInstanceOf(SandboxPolicyError);
+    expect((error as SandboxPolicyError).violations.map(v => v.rule)).toEqual([
+      'allowNetwork',
+      'allowTools',
+    ]);
+    expect((error as Error).message).toBe(
+      [
+        'The script breaks the sandbox policy:',
+        '  Policy does not allow network access, which server search needs',
+        '  Policy does not allow calling search.query',
+      ].join('\n')
+    );
+  });
+});
+
+describe('loadSandboxPolicy', () => {
+  it('should read and check policy files', async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-policy-'));
+    const valid = join(dir, 'policy.json');
+    await writeFile(valid, JSON.stringify({ maxRunTime: 60000 }));
+    await expect(loadSandboxPolicy(valid)).resolves.toEqual({
+      maxRunTime: 60000,
+    });
+
+    const invalid = join(dir, 'invalid.json');
+    await writeFile(invalid, JSON.stringify({ maxProcesses: 'two' }));
+    await expect(loadSandboxPolicy(invalid)).rejects.toThrow(
+      `Invalid sandbox policy ${invalid}: "maxProcesses" must be a non-negative integer`
+    );
+    await expect(loadSandboxPolicy(join(dir, 'missing.json'))).rejects.toThrow(
+      'Cannot read sandbox policy'
+    );
+  });
+});
@@ -0,0 +1,140 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { createPublicKey } from 'crypto';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import type { ModuleLoader } from '@mcpscript/transpiler';
+import {
+  enforceSigningPolicy,
+  generateSigningKeys,
+  keyId,
+  readSignature,
+  signaturePath,
+  signScript,
+  verifyScript,
+} from '../signing.js';
+
+const SOURCE = 'print("hello")\n';
+
+describe('script signing', () => {
+  let dir: string;
+  const keys = generateSigningKeys();
+  const publicKey = createPublicKey(keys.publicKey);
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-sign-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  /**
+   * Write a script with the signature of SOURCE, so other sources look
+   * changed after signing
+   */
+  async function writeScript(name: string, source = SOURCE, signed = true) {
+    const path = join(dir, name);
+    await writeFile(path, source);
+    if (signed) {
+      const signature = signScript(SOURCE, keys.privateKey);
+      await writeFile(signaturePath(path), JSON.stringify(signature));
+    }
+    return path;
+  }
+
+  it('should verify a signed script with the key that signed it', async () => {
+    const path = await writeScript('main.mcps');
+    const signature = readSignature(path);
+    expect(signature).toMatchObject({
+      version: 1,
+      algorithm: 'ed25519',
+      keyId: keyId(publicKey),
+    });
+    expect(verifyScript(path, SOURCE, signature, [publicKey])).toBe(publicKey);
+  });
+
+  it('should refuse scripts changed after signing', async () => {
+    const path = await writeScript('main.mcps', 'print("tampered")\n');
+    expect(() =>
+      verifyScript(path, 'print("tampered")\n', readSignature(path), [
+        publicKey,
+      ])
+    ).toThrow(
+      expect.objectContaining({
+        reason: 'tampered',
+        message: `${path} does not match its signature; it was changed after signing`,
+      })
+    );
+  });
+
+  it('should refuse signatures of untrusted keys', async () => {
+    const path = await writeScript('main.mcps');
+    const other = createPublicKey(generateSigningKeys().publicKey);
+    expect(() =>
+      verifyScript(path, SOURCE, readSignature(path), [other])
+    ).toThrow(expect.objectContaining({ reason: 'untrusted' }));
+  });
+
+  it('should report malformed signature files', async () => {
+    const path = await writeScript('main.mcps', SOURCE, false);
+    expect(readSignature(path)).toBeUndefined();
+    await writeFile(signaturePath(path), '{"version": 2}');
+    expect(() => readSignature(path)).toThrow(
+      expect.objectContaining({
+        reason: 'invalid',
+        message: `Invalid ${signaturePath(path)}: unsupported signature version 2`,
+      })
+    );
+  });
+
+  describe('enforceSigningPolicy', () => {
+    async function loadedConfig(required: boolean) {
+      await writeFile(join(dir, 'team.pub'), keys.publicKey);
+      return {
+        config: { signing: { trustedKeys: ['team.pub'], required } },
+        path: join(dir, '.mcpsrc'),
+      };
+    }
+
+    const files: Record<string, string> = {};
+    const loader: ModuleLoader = {
+      resolve: specifier => join(dir, specifier),
+      read: path => files[path],
+    };
+
+    it('should allow unsigned scripts unless signatures are required', async () => {
+      const path = await writeScript('main.mcps', SOURCE, false);
+      expect(
+        enforceSigningPolicy(await loadedConfig(false), path, SOURCE, loader)
+      ).toBeDefined();
+      expect(() =>
+        enforceSigningPolicy(
+          { config: {} },
+          path,
+          'print("anything")\n',
+          loader
+        )
+      ).not.toThrow();
+      const required = await loadedConfig(true);
+      expect(() =>
+        enforceSigningPolicy(required, path, SOURCE, loader)
+      ).toThrow(`${path} is not signed`);
+    });
+
+    it('should check the modules a script imports', async () => {
+      const path = await writeScript('main.mcps');
+      const module = await writeScript('servers.mcps', 'mcp fs {}\n');
+      files[module] = 'mcp fs {}\n';
+      const signed = enforceSigningPolicy(
+        await loadedConfig(false),
+        path,
+        SOURCE,
+        loader
+      );
+      expect(() => signed.read(module)).toThrow(
+        expect.objectContaining({ reason: 'tampered' })
+      );
+    });
+  });
+});
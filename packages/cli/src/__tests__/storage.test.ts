@@ -0,0 +1,194 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { readdir, readFile, mkdtemp, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  FileStorage,
+  projectStorage,
+  S3Storage,
+  SqliteStorage,
+  writeFileAtomic,
+  type Storage,
+} from '../storage.js';
+import { checkpointStore } from '../checkpoints.js';
+
+// node:sqlite needs no flag from Node.js 22.13 on
+const [major, minor] = process.versions.node.split('.').map(Number);
+const hasSqlite = major > 22 || (major === 22 && minor >= 13);
+
+async function exercise(storage: Storage) {
+  expect(await storage.read('checkpoints', 'run-1')).toBeUndefined();
+  await storage.write('checkpoints', 'run-1', '{"statement":2}');
+  await storage.write('checkpoints', 'run-1', '{"statement":3}');
+  expect(await storage.read('checkpoints', 'run-1')).toBe('{"statement":3}');
+
+  await storage.append('transcripts', 't1', '{"type":"script"}');
+  await storage.append('transcripts', 't1', '{"type":"run_start"}\n');
+  expect(await storage.read('transcripts', 't1')).toBe(
+    '{"type":"script"}\n{"type":"run_start"}\n'
+  );
+  expect((await storage.list('transcripts')).map(r => r.key)).toEqual([
+    't1',
+  ]);
+
+  expect(await storage.create('schedules', 'nightly.1', '{"a":1}')).toBe(true);
+  expect(await storage.create('schedules', 'nightly.1', '{"a":2}')).toBe(
+    false
+  );
+  expect(await storage.read('schedules', 'nightly.1')).toBe('{"a":1}');
+
+  await storage.delete('checkpoints', 'run-1');
+  expect(await storage.read('checkpoints', 'run-1')).toBeUndefined();
+  expect(await storage.list('checkpoints')).toEqual([]);
+  await expect(storage.write('runs', '../other', '{}')).rejects.toThrow(
+    'Invalid key of runs: ../other'
+  );
+}
+
+/**
+ * S3 storage keeping the objects of a bucket in memory, answering the
+ * commands the backend sends
+ */
+class MemoryS3Storage extends S3Storage {
+  constructor(private readonly bucket: Map<string, string>) {
+    super({ bucket: 'runs', prefix: 'mcps/' });
+  }
+
+  protected async send(command: string, input: any): Promise<any> {
+    switch (command) {
+      case 'GetObjectCommand': {
+        const body = this.bucket.get(input.Key);
+        if (body === undefined) {
+          throw Object.assign(new Error(input.Key), { name: 'NoSuchKey' });
+        }
+        return { Body: { transformToString: async () => body } };
+      }
+      case 'PutObjectCommand':
+        if (input.IfNoneMatch && this.bucket.has(input.Key)) {
+          throw Object.assign(new Error(input.Key), {
+            name: 'PreconditionFailed',
+          });
+        }
+        this.bucket.set(input.Key, input.Body);
+        return {};
+      case 'DeleteObjectCommand':
+        this.bucket.delete(input.Key);
+        return {};
+      case 'ListObjectsV2Command':
+        return {
+          Contents: [...this.bucket.keys()]
+            .filter(key => key.startsWith(input.Prefix))
+            .map(Key => ({ Key, LastModified: new Date() })),
+        };
+    }
+  }
+}
+
+describe('storage', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-storage-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should keep records in files by collection', async () => {
+    const storage = new FileStorage({
+      checkpoints: join(dir, 'checkpoints'),
+      transcripts: join(dir, 'transcripts'),
+      schedules: join(dir, 'schedules'),
+    });
+    await exercise(storage);
+    expect(await readdir(join(dir, 'transcripts'))).toEqual(['t1.jsonl']);
+  });
+
+  it('should append lines in the order they are given', async () => {
+    const storage = new FileStorage({ audit: join(dir, 'audit') });
+    const lines = Array.from({ length: 20 }, (_, i) => `{"n":${i}}`);
+    await Promise.all(lines.map(line => storage.append('audit', 'day', line)));
+    expect(await storage.read('audit', 'day')).toBe(
+      lines.map(line => `${line}\n`).join('')
+    );
+  });
+
+  it('should write files whole, creating their directory', async () => {
+    const path = join(dir, 'nested', 'records.json');
+    await writeFileAtomic(path, '[1]');
+    await writeFileAtomic(path, '[1,2]');
+    expect(await readFile(path, 'utf-8')).toBe('[1,2]');
+    expect(await readdir(join(dir, 'nested'))).toEqual(['records.json']);
+  });
+
+  it('should keep each line of a journal as an object in S3', async () => {
+    const bucket = new Map<string, string>();
+    await exercise(new MemoryS3Storage(bucket));
+
+    // Instances sharing the bucket append to the same journal
+    const instances = [
+      new MemoryS3Storage(bucket),
+      new MemoryS3Storage(bucket),
+    ];
+    const lines = (n: number) =>
+      Array.from({ length: 10 }, (_, i) => `{"instance":${n},"n":${i}}`);
+    await Promise.all(
+      instances.flatMap((storage, n) =>
+        lines(n).map(line => storage.append('audit', 'day', line))
+      )
+    );
+    const read = (await instances[0].read('audit', 'day'))!.split('\n');
+    for (const n of [0, 1]) {
+      expect(read.filter(line => line.includes(`"instance":${n}`))).toEqual(
+        lines(n)
+      );
+    }
+    expect((await instances[1].list('audit')).map(r => r.key)).toEqual([
+      'day',
+    ]);
+
+    await instances[1].delete('audit', 'day');
+    expect(await instances[0].read('audit', 'day')).toBeUndefined();
+    expect([...bucket.keys()].some(key => key.includes('audit/'))).toBe(
+      false
+    );
+  });
+
+  it.runIf(hasSqlite)(
+    'should keep records in a SQLite database',
+    async () => {
+      const storage = new SqliteStorage(join(dir, 'state', 'mcps.db'));
+      await exercise(storage);
+      await storage.close();
+    }
+  );
+
+  it('should open the backend the project config names', async () => {
+    const loaded = {
+      config: { storage: { backend: 'file' as const, path: 'state' } },
+      path: join(dir, '.mcpsrc'),
+    };
+    const storage = projectStorage(loaded);
+    await storage.append('audit', '2026-10-16', '{}');
+    expect(await readdir(join(dir, 'state', 'audit'))).toEqual([
+      '2026-10-16.jsonl',
+    ]);
+    const s3 = projectStorage({
+      config: { storage: { backend: 's3', bucket: 'runs', prefix: 'mcps/' } },
+    });
+    expect(s3.describe('runs')).toBe('s3://runs/mcps/runs');
+
+    // Checkpoints go to the project's storage unless configured apart
+    const checkpoints = await checkpointStore(loaded, storage);
+    await checkpoints.save('run-1', {
+      script: 'abc',
+      statement: 1,
+      variables: {},
+      time: 0,
+    });
+    expect(await readdir(join(dir, 'state', 'checkpoints'))).toEqual([
+      'run-1.json',
+    ]);
+  });
+});
@@ -0,0 +1,113 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { mkdir, mkdtemp, readFile, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  diffToolSchemas,
+  findToolsLock,
+  formatToolChanges,
+  lockedPackages,
+  readToolsLock,
+  TOOLS_LOCK_FILE,
+  writeToolsLock,
+} from '../tools-lock.js';
+
+describe('tools lockfile', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-lock-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should be found in parent directories', async () => {
+    const nested = join(dir, 'scripts', 'nested');
+    await mkdir(nested, { recursive: true });
+    expect(findToolsLock(nested)).toBeUndefined();
+
+    await writeFile(join(dir, TOOLS_LOCK_FILE), '{}');
+    expect(findToolsLock(nested)).toBe(join(dir, TOOLS_LOCK_FILE));
+  });
+
+  it('should round trip with servers and tools sorted by name', async () => {
+    const path = join(dir, TOOLS_LOCK_FILE);
+    const tool = (name: string) => ({
+      name,
+      inputSchema: { type: 'object' },
+    });
+    await writeToolsLock(path, {
+      version: 1,
+      servers: { web: [tool('fetch')], filesystem: [tool('b'), tool('a')] },
+    });
+
+    const lock = await readToolsLock(path);
+    expect(Object.keys(lock.servers)).toEqual(['filesystem', 'web']);
+    expect(lock.servers.filesystem.map(t => t.name)).toEqual(['a', 'b']);
+    expect(await readFile(path, 'utf-8')).toMatch(/\n$/);
+  });
+
+  it('should reject lockfiles of other versions or shapes', async () => {
+    const path = join(dir, TOOLS_LOCK_FILE);
+    await writeFile(path, JSON.stringify({ version: 2, servers: {} }));
+    await expect(readToolsLock(path)).rejects.toThrow(
+      'unsupported lockfile version 2'
+    );
+
+    await writeFile(path, JSON.stringify({ version: 1, servers: { a: {} } }));
+    await expect(readToolsLock(path)).rejects.toThrow(
+      '"servers.a" must be an array'
+    );
+
+    await writeFile(
+      path,
+      JSON.stringify({
+        version: 1,
+        servers: {},
+        packages: { fs: { ecosystem: 'cargo', name: 'fs', version: '1.0.0' } },
+      })
+    );
+    await expect(readToolsLock(path)).rejects.toThrow(
+      '"packages.fs" must have an ecosystem (npm or PyPI), a name and a version'
+    );
+  });
+
+  it('should keep the package pins of servers', async () => {
+    const path = join(dir, TOOLS_LOCK_FILE);
+    const fs = {
+      ecosystem: 'npm' as const,
+      name: '@modelcontextprotocol/server-filesystem',
+      version: '0.6.2',
+      integrity: 'sha512-abc',
+    };
+    await writeToolsLock(path, { version: 1, servers: {}, packages: { fs } });
+    expect(await lockedPackages(join(dir, 'main.mcps'))).toEqual({ fs });
+  });
+
+  it('should diff locked tools against the ones servers list', () => {
+    const tool = (name: string, properties: object = {}) => ({
+      name,
+      inputSchema: { type: 'object', properties },
+    });
+    const locked = {
+      fs: [tool('read', { path: {}, limit: {} }), tool('stat'), tool('old')],
+      other: [tool('kept')],
+    };
+    const current = {
+      fs: [tool('read', { limit: {}, path: {} }), tool('stat', { path: {} })],
+      web: [tool('fetch')],
+    };
+    const changes = diffToolSchemas(locked, current);
+    expect(changes).toEqual([
+      { server: 'fs', tool: 'old', change: 'removed' },
+      { server: 'fs', tool: 'stat', change: 'changed' },
+      { server: 'web', tool: 'fetch', change: 'added' },
+    ]);
+    expect(formatToolChanges(changes)).toBe(
+      '- fs.old\n~ fs.stat\n+ web.fetch'
+    );
+    expect(diffToolSchemas(locked, { fs: locked.fs })).toEqual([]);
+  });
+});
@@ -0,0 +1,263 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { existsSync } from 'fs';
+import { mkdtemp, rm, utimes, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { parseSource, generateCodeUnsafe } from '@mcpscript/transpiler';
+import type { Transcript } from '@mcpscript/runtime';
+import {
+  createTranscript,
+  findTranscript,
+  formatTranscript,
+  listTranscripts,
+  readTranscript,
+  recordedTestFile,
+  replayProgram,
+  transcriptId,
+  transcriptRecording,
+  uncoveredTools,
+} from '../transcripts.js';
+import { FileStorage } from '../storage.js';
+
+describe('transcripts', () => {
+  let dir: string;
+  let storage: FileStorage;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-transcripts-'));
+    storage = new FileStorage({ transcripts: dir });
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should name transcripts after the time and the script', () => {
+    const id = transcriptId(
+      '/work/daily report.mcps',
+      new Date('2026-10-16T14:25:30Z')
+    );
+    expect(id).toMatch(/^20261016-142530-daily_report-[0-9a-f]{4}$/);
+  });
+
+  it('should only write a transcript once an agent starts', async () => {
+    const transcript = createTranscript(
+      '/work/report.mcps',
+      undefined,
+      storage
+    );
+    const path = join(dir, `${transcript.id}.jsonl`);
+    expect(transcript.written).toBe(false);
+    expect(existsSync(path)).toBe(false);
+
+    const run = transcript.recorder.start('lead');
+    transcript.recorder.message(run, { role: 'user', content: 'Go' });
+    transcript.recorder.end(run);
+
+    expect(transcript.written).toBe(true);
+    expect(existsSync(path)).toBe(true);
+    await transcript.flushed();
+    const read = await readTranscript(transcript.id, storage);
+    expect(read.file).toBe('/work/report.mcps');
+    expect(read.runs).toMatchObject([
+      { run: 1, agent: 'lead', messages: [{ content: 'Go' }] },
+    ]);
+  });
+
+  it('should record what a replay started from', async () => {
+    const replayOf = { transcript: 'earlier', run: 2, message: 3 };
+    const transcript = createTranscript('/work/report.mcps', replayOf, storage);
+    transcript.recorder.start('lead');
+    await transcript.flushed();
+
+    expect((await readTranscript(transcript.id, storage)).replayOf).toEqual(
+      replayOf
+    );
+  });
+
+  it('should find transcripts by id, path or last', async () => {
+    const older = join(dir, 'older.jsonl');
+    const newer = join(dir, 'newer.jsonl');
+    await writeFile(older, '');
+    await writeFile(newer, '');
+    await utimes(older, new Date(1000), new Date(1000));
+
+    expect(await findTranscript('older', storage)).toBe('older');
+    expect(await findTranscript(newer, storage)).toBe(newer);
+    expect(await findTranscript('last', storage)).toBe('newer');
+    expect((await listTranscripts(storage)).map(t => t.id)).toEqual([
+      'newer',
+      'older',
+    ]);
+    await expect(findTranscript('missing', storage)).rejects.toThrow(
+      `No transcript missing in ${dir}`
+    );
+  });
+
+  it('should list no transcripts before the first run', async () => {
+    const none = new FileStorage({ transcripts: join(dir, 'none') });
+    expect(await listTranscripts(none)).toEqual([]);
+    await expect(findTranscript('last', none)).rejects.toThrow(
+      'There are no transcripts in'
+    );
+  });
+});
+
+describe('formatTranscript', () => {
+  const transcript: Transcript = {
+    file: '/work/report.mcps',
+    startedAt: Date.parse('2026-10-16T14:25:30Z'),
+    runs: [
+      {
+        run: 1,
+        agent: 'lead',
+        systemPrompt: 'Delegate writing',
+        startedAt: 0,
+        messages: [
+          { role: 'user', content: 'Get me a poem' },
+          {
+            role: 'assistant',
+            content: '',
+            options: {
+              toolCall: [
+                { id: 'c1', name: 'writer', input: { task: 'rain' } },
+              ],
+            },
+          },
+          {
+            role: 'user',
+            content: '',
+            options: {
+              toolResult: { id: 'c1', result: 'Poem', isError: false },
+            },
+          },
+        ],
+      },
+      {
+        run: 2,
+        agent: 'writer',
+        parent: 1,
+        startedAt: 0,
+        finishedAt: 1,
+        error: 'Agent writer used all of its 1 turns',
+        messages: [{ role: 'user', content: 'rain' }],
+      },
+    ],
+    toolCalls: [],
+  };
+
+  it('should number the messages of each run and nest sub-agent runs', () => {
+    expect(formatTranscript('t1', transcript)).toBe(
+      [
+        'Transcript t1',
+        'Script: /work/report.mcps',
+        'Started: 2026-10-16T14:25:30.000Z',
+        '',
+        '#1 lead',
+        '  system: Delegate writing',
+        '  1 user: Get me a poem',
+        '  2 assistant:',
+        '    -> writer({"task":"rain"})',
+        '  3 tool: Poem',
+        '  (did not finish)',
+        '',
+        '  #2 writer (delegated by #1 lead)',
+        '    1 user: rain',
+        '    failed: Agent writer used all of its 1 turns',
+        '',
+      ].join('\n')
+    );
+  });
+});
+
+describe('replayProgram', () => {
+  const statements = parseSource(`
+mcp fs { command: "fs-server" }
+model gpt { provider: "openai", model: "gpt-4o" }
+agent Writer { model: gpt, tools: [fs] }
+print("not replayed")
+result = "Go" | Writer
+`);
+
+  it('should keep the declarations and run only the replayed agent', () => {
+    const code = generateCodeUnsafe(
+      replayProgram(statements, 'Writer', 'a.mcps')
+    );
+    expect(code).toContain('__replayAgent(Writer)');
+    expect(code).not.toContain('not replayed');
+  });
+
+  it('should reject agents the script no longer declares', () => {
+    expect(() => replayProgram(statements, 'Editor', 'a.mcps')).toThrow(
+      'Agent Editor is no longer declared in a.mcps'
+    );
+  });
+});
+
+describe('recorded mocks', () => {
+  const recording = transcriptRecording('t1', {
+    file: '/work/report.mcps',
+    runs: [],
+    toolCalls: [
+      { tool: 'github.listIssues', input: {}, result: '[12]', time: 1 },
+      { tool: 'github.getIssue', input: { n: 12 }, result: '{}', time: 2 },
+      { tool: 'github.getIssue', input: { n: 13 }, error: 'Gone', time: 3 },
+    ],
+  });
+
+  it('should keep the calls without their times', () => {
+    expect(recording).toMatchObject({
+      transcript: 't1',
+      script: '/work/report.mcps',
+    });
+    expect(recording.calls[2]).toEqual({
+      tool: 'github.getIssue',
+      input: { n: 13 },
+      error: 'Gone',
+    });
+  });
+
+  it('should list the tools of the servers never called', () => {
+    expect(
+      uncoveredTools(recording, {
+        github: [
+          { name: 'listIssues' },
+          { name: 'getIssue' },
+          { name: 'closeIssue' },
+        ],
+        fs: [{ name: 'read' }],
+      })
+    ).toEqual(['github.closeIssue', 'fs.read']);
+  });
+
+  it('should write a test answering calls from the recording', () => {
+    expect(
+      recordedTestFile(recording, './report.mcps', 'report_recorded.json', [
+        'github.closeIssue',
+      ])
+    ).toBe(`// Tests bootstrapped from transcript t1, answering
+// tool calls as they were answered in the recorded run
+import "./report.mcps"
+
+fixture recording = fixtures.load("report_recorded.json")
+
+beforeEach {
+  mock.recorded(recording)
+}
+
+test "makes the tool calls of the recorded run" {
+  // Call the workflow as the recorded run did, then check its result
+  assertEqual(mock.calls("github.listIssues").length, 1)
+  assertEqual(mock.calls("github.getIssue").length, 2)
+}
+
+// Tools the recorded run never called, which no test covers yet:
+//   github.closeIssue
+`);
+  });
+
+  it('should write test files that parse', () => {
+    const source = recordedTestFile(recording, './report.mcps', 'r.json');
+    expect(() => parseSource(source)).not.toThrow();
+  });
+});
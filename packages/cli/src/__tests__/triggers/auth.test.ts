@@ -0,0 +1,154 @@
+import { describe, it, expect } from 'vitest';
+import type { IncomingMessage } from 'http';
+import {
+  apiKeyAuthenticator,
+  authenticateRequest,
+  configuredAuthenticators,
+  mtlsAuthenticator,
+  oidcAuthenticator,
+  sharedTokenAuthenticator,
+} from '../../triggers/auth.js';
+import type { OidcVerifier } from '../../remote/oidc.js';
+
+function request(
+  headers: Record<string, string> = {},
+  socket: object = {}
+): IncomingMessage {
+  return { headers, socket } as unknown as IncomingMessage;
+}
+
+const bearer = (token: string) => request({ authorization: `Bearer ${token}` });
+
+const CERTIFICATE = {
+  subject: { CN: 'build-agent' },
+  subjectaltname: 'DNS:agent.ci.example, DNS:ci.example',
+  fingerprint256: 'AB:CD',
+};
+
+describe('authenticators', () => {
+  it('should name the owner of an API key', async () => {
+    const keys = [
+      { subject: 'ci', key: 'ci-key' },
+      { subject: 'ops', key: 'ops-key' },
+    ];
+    const bearerKeys = apiKeyAuthenticator(keys);
+    expect(await bearerKeys.authenticate(bearer('ops-key'))).toEqual({
+      caller: { subject: 'ops' },
+    });
+    expect(await bearerKeys.authenticate(bearer('other'))).toBeUndefined();
+
+    const headerKeys = apiKeyAuthenticator(keys, 'X-Api-Key');
+    expect(
+      await headerKeys.authenticate(request({ 'x-api-key': 'ci-key' }))
+    ).toEqual({ caller: { subject: 'ci' } });
+    expect(await headerKeys.authenticate(bearer('ci-key'))).toBeUndefined();
+  });
+
+  it('should accept the shared token without a caller', async () => {
+    const shared = sharedTokenAuthenticator('serve-token');
+    expect(await shared.authenticate(bearer('serve-token'))).toEqual({});
+    expect(await shared.authenticate(request())).toBeUndefined();
+  });
+
+  it('should check only the JWTs of the issuer', async () => {
+    const verifier = {
+      verify: async () => {
+        throw new Error('the token has expired');
+      },
+    } as unknown as OidcVerifier;
+    const oidc = oidcAuthenticator(verifier);
+    expect(await oidc.authenticate(bearer('serve-token'))).toBeUndefined();
+    await expect(oidc.authenticate(bearer('a.b.c'))).rejects.toMatchObject({
+      status: 401,
+      message: 'Invalid token: the token has expired',
+    });
+  });
+
+  it('should name the caller of a trusted client certificate', async () => {
+    const socket = (authorized: boolean, certificate: object = CERTIFICATE) =>
+      request(
+        {},
+        {
+          authorized,
+          authorizationError: 'UNABLE_TO_VERIFY_LEAF_SIGNATURE',
+          getPeerCertificate: () => certificate,
+        }
+      );
+
+    expect(await mtlsAuthenticator().authenticate(socket(true))).toEqual({
+      caller: { subject: 'build-agent', claims: { fingerprint256: 'AB:CD' } },
+    });
+    expect(
+      await mtlsAuthenticator('san').authenticate(socket(true))
+    ).toMatchObject({ caller: { subject: 'agent.ci.example' } });
+    // Plain HTTP, or a client without a certificate
+    expect(await mtlsAuthenticator().authenticate(request())).toBeUndefined();
+    expect(
+      await mtlsAuthenticator().authenticate(socket(true, {}))
+    ).toBeUndefined();
+
+    await expect(
+      mtlsAuthenticator().authenticate(socket(false))
+    ).rejects.toMatchObject({
+      status: 401,
+      message:
+        'Client certificate not trusted: UNABLE_TO_VERIFY_LEAF_SIGNATURE',
+    });
+    await expect(
+      mtlsAuthenticator('san').authenticate(
+        socket(true, { subject: { CN: 'build-agent' } })
+      )
+    ).rejects.toThrow('Client certificate has no subject alternative name');
+  });
+});
+
+describe('authenticateRequest', () => {
+  const chain = [
+    sharedTokenAuthenticator('serve-token'),
+    apiKeyAuthenticator([{ subject: 'ci', key: 'ci-key' }]),
+  ];
+
+  it('should take the caller of the first authenticator to accept', async () => {
+    expect(await authenticateRequest(bearer('serve-token'), chain)).toBe(
+      undefined
+    );
+    expect(await authenticateRequest(bearer('ci-key'), chain)).toEqual({
+      subject: 'ci',
+    });
+    await expect(
+      authenticateRequest(bearer('other'), chain)
+    ).rejects.toMatchObject({
+      status: 401,
+      message: 'Missing or invalid credentials',
+    });
+  });
+
+  it('should accept any request without authenticators', async () => {
+    expect(await authenticateRequest(request(), [])).toBeUndefined();
+  });
+});
+
+describe('configuredAuthenticators', () => {
+  it('should read API keys from the environment', async () => {
+    const loaded = {
+      config: {
+        serve: {
+          auth: [
+            {
+              type: 'apiKey' as const,
+              keys: [{ subject: 'ci', env: 'CI_KEY' }],
+            },
+          ],
+        },
+      },
+    };
+
+    const [keys] = configuredAuthenticators(loaded, { CI_KEY: 'ci-key' });
+    expect(await keys.authenticate(bearer('ci-key'))).toEqual({
+      caller: { subject: 'ci' },
+    });
+    expect(() => configuredAuthenticators(loaded, {})).toThrow(
+      'serve.auth[0] reads the key of ci from $CI_KEY, which is not set'
+    );
+  });
+});
@@ -0,0 +1,97 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  cacheKey,
+  matchesEtag,
+  ResultCache,
+  resultEtag,
+} from '../../triggers/cache.js';
+import type { TriggerRunStatus } from '../../triggers/protocol.js';
+
+function runStatus(
+  id: string,
+  output: string[],
+  state: 'succeeded' | 'failed' = 'succeeded'
+): TriggerRunStatus {
+  return {
+    id,
+    trigger: 'status',
+    file: '/jobs/status.mcps',
+    cause: 'webhook',
+    state,
+    firedAt: new Date().toISOString(),
+    output,
+  };
+}
+
+describe('ResultCache', () => {
+  it('should keep successful results for their max age', async () => {
+    let now = 0;
+    const cache = new ResultCache(() => now);
+    const run = vi.fn(async () =>
+      runStatus(`run-${run.mock.calls.length}`, ['3 open'])
+    );
+
+    const first = await cache.result('status', 60, run);
+    expect(first.expires).toBe(60 * 1000);
+    now = 59 * 1000;
+    expect(await cache.result('status', 60, run)).toBe(first);
+    expect(run).toHaveBeenCalledTimes(1);
+
+    now = 60 * 1000;
+    const second = await cache.result('status', 60, run);
+    expect(second.status.id).toBe('run-2');
+    // The same output has the same ETag, whichever run reported it
+    expect(second.etag).toBe(first.etag);
+  });
+
+  it('should share a run in progress and forget failed ones', async () => {
+    const cache = new ResultCache();
+    const run = vi.fn(async () => runStatus('run-1', [], 'failed'));
+
+    const [first, second] = await Promise.all([
+      cache.result('status', 60, run),
+      cache.result('status', 60, run),
+    ]);
+    expect(second).toBe(first);
+    expect(run).toHaveBeenCalledTimes(1);
+
+    await cache.result('status', 60, run);
+    expect(run).toHaveBeenCalledTimes(2);
+  });
+
+  it('should run again after a run that threw', async () => {
+    const cache = new ResultCache();
+    await expect(
+      cache.result('status', 60, async () => {
+        throw new Error('Unknown trigger: status');
+      })
+    ).rejects.toThrow('Unknown trigger: status');
+    const result = await cache.result('status', 60, async () =>
+      runStatus('run-2', ['ok'])
+    );
+    expect(result.status.output).toEqual(['ok']);
+  });
+});
+
+describe('cacheKey', () => {
+  it('should key results on the trigger and the whole caller', () => {
+    expect(cacheKey('status', { subject: 'alice', tenant: 'ci' })).toBe(
+      cacheKey('status', { tenant: 'ci', subject: 'alice' })
+    );
+    expect(cacheKey('status', { subject: 'alice' })).not.toBe(
+      cacheKey('status', { subject: 'bob' })
+    );
+    expect(cacheKey('status')).not.toBe(cacheKey('report'));
+  });
+});
+
+describe('matchesEtag', () => {
+  it('should find the ETag among those a client has', () => {
+    const etag = resultEtag(runStatus('run-1', ['3 open']));
+    expect(matchesEtag(`"other", ${etag}`, etag)).toBe(true);
+    expect(matchesEtag(`W/${etag}`, etag)).toBe(true);
+    expect(matchesEtag('*', etag)).toBe(true);
+    expect(matchesEtag('"other"', etag)).toBe(false);
+    expect(matchesEtag(undefined, etag)).toBe(false);
+  });
+});
@@ -0,0 +1,74 @@
+import { describe, it, expect, afterEach } from 'vitest';
+import { mkdtemp, readdir, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  DirectoryEventQueue,
+  parseQueuedEvent,
+  type QueuedEvent,
+} from '../../triggers/queue.js';
+
+describe('parseQueuedEvent', () => {
+  it('should read an event and its payload', () => {
+    expect(parseQueuedEvent('{"event":"a","payload":{"n":1}}')).toEqual({
+      event: 'a',
+      payload: { n: 1 },
+    });
+    expect(parseQueuedEvent('{"event":"a"}')).toEqual({
+      event: 'a',
+      payload: null,
+    });
+  });
+
+  it('should reject files that are not events', () => {
+    expect(() => parseQueuedEvent('[]')).toThrow('an event must be an object');
+    expect(() => parseQueuedEvent('{"payload":1}')).toThrow(
+      'an event needs a name'
+    );
+    expect(() => parseQueuedEvent('{')).toThrow();
+  });
+});
+
+describe('DirectoryEventQueue', () => {
+  let dir: string | undefined;
+
+  afterEach(async () => {
+    if (dir) {
+      await rm(dir, { recursive: true, force: true });
+      dir = undefined;
+    }
+  });
+
+  it('should take events in name order and delete them', async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-queue-'));
+    await writeFile(join(dir, '2.json'), '{"event":"b","payload":2}');
+    await writeFile(join(dir, '1.json'), '{"event":"a","payload":1}');
+    await writeFile(join(dir, '3.json.tmp'), '{"event":"c"}');
+    await writeFile(join(dir, '4.json'), 'not json');
+
+    const warnings: string[] = [];
+    const queue = new DirectoryEventQueue(dir, {
+      warn: message => warnings.push(message),
+    });
+    const delivered: QueuedEvent[] = [];
+    await queue.take(event => delivered.push(event));
+
+    expect(delivered).toEqual([
+      { event: 'a', payload: 1 },
+      { event: 'b', payload: 2 },
+    ]);
+    expect((await readdir(dir)).sort()).toEqual([
+      '3.json.tmp',
+      '4.json.failed',
+    ]);
+    expect(warnings).toHaveLength(1);
+    expect(warnings[0]).toContain('4.json');
+  });
+
+  it('should wait for a directory that does not exist yet', async () => {
+    const queue = new DirectoryEventQueue(join(tmpdir(), 'mcps-no-queue'));
+    const delivered: QueuedEvent[] = [];
+    await queue.take(event => delivered.push(event));
+    expect(delivered).toEqual([]);
+  });
+});
@@ -0,0 +1,640 @@
+import { describe, it, expect, afterEach, vi } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import type { AddressInfo } from 'net';
+import {
+  currentCaller,
+  Redactor,
+  REDACTED,
+  RunOutcome,
+  type ScriptTrigger,
+} from '@mcpscript/runtime';
+import {
+  createTriggerServer,
+  loadTriggerScripts,
+  TriggerService,
+  type TriggerScript,
+} from '../../triggers/service.js';
+import {
+  EVENTS_PATH,
+  HEALTH_PATH,
+  RUNS_PATH,
+  TRIGGERS_PATH,
+  type EventDelivery,
+  type TriggerRunStatus,
+  type TriggerStatus,
+} from '../../triggers/protocol.js';
+import { EventDecoder } from '../../remote/protocol.js';
+import type { OidcVerifier } from '../../remote/oidc.js';
+import {
+  oidcAuthenticator,
+  sharedTokenAuthenticator,
+  type Authenticator,
+} from '../../triggers/auth.js';
+import { FileStorage } from '../../storage.js';
+
+function fakeScript(
+  file: string,
+  triggers: Array<Omit<ScriptTrigger, 'fire'> & { fire?: () => unknown }>
+): TriggerScript {
+  const script: TriggerScript = {
+    file,
+    triggers: [],
+    handlers: [],
+    close: vi.fn(async () => {}),
+  };
+  script.triggers = triggers.map(({ name, schedule, fire }) => ({
+    name,
+    schedule,
+    fire: async () => {
+      script.output?.(`${name} ran`);
+      return fire?.();
+    },
+  }));
+  return script;
+}
+
+/**
+ * A script handling the given events by printing their payloads
+ */
+function handlingScript(file: string, events: string[]): TriggerScript {
+  const script = fakeScript(file, []);
+  script.handlers = events.map(event => ({
+    event,
+    handle: async payload => {
+      script.output?.(`${event}: ${JSON.stringify(payload)}`);
+    },
+  }));
+  return script;
+}
+
+describe('TriggerService', () => {
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  it('should fire scheduled triggers at their next time', async () => {
+    vi.useFakeTimers({ now: new Date(2026, 0, 1, 1, 30) });
+    const script = fakeScript('/jobs/nightly.mcps', [
+      { name: 'nightly', schedule: '0 2 * * *' },
+    ]);
+    const service = new TriggerService([script]);
+    service.start();
+
+    expect(service.triggerStatus('nightly')?.nextRun).toBe(
+      new Date(2026, 0, 1, 2, 0).toISOString()
+    );
+    await vi.advanceTimersByTimeAsync(29 * 60_000);
+    expect(service.listRuns()).toEqual([]);
+
+    await vi.advanceTimersByTimeAsync(60_000);
+    const [run] = service.listRuns();
+    expect(run).toMatchObject({
+      trigger: 'nightly',
+      file: '/jobs/nightly.mcps',
+      cause: 'schedule',
+      state: 'succeeded',
+      output: ['nightly ran'],
+    });
+    expect(service.triggerStatus('nightly')?.nextRun).toBe(
+      new Date(2026, 0, 2, 2, 0).toISOString()
+    );
+
+    await service.stop();
+    expect(script.close).toHaveBeenCalled();
+  });
+
+  it('should take turns running the triggers of a script', async () => {
+    const order: string[] = [];
+    let release!: () => void;
+    const script = fakeScript('/jobs/deploy.mcps', [
+      {
+        name: 'build',
+        schedule: null,
+        fire: () =>
+          new Promise<void>(resolve => {
+            order.push('build');
+            release = resolve;
+          }),
+      },
+      { name: 'deploy', schedule: null, fire: () => order.push('deploy') },
+    ]);
+    const service = new TriggerService([script]);
+
+    const build = service.fire('build', 'webhook');
+    const deploy = service.fire('deploy', 'webhook');
+    await vi.waitFor(() => expect(order).toEqual(['build']));
+    expect(service.getRun(build.id)?.state).toBe('running');
+    expect(service.getRun(deploy.id)?.state).toBe('queued');
+
+    release();
+    expect(await service.settled(deploy.id)).toMatchObject({
+      state: 'succeeded',
+      output: ['deploy ran', '2'],
+    });
+    expect(service.getRun(build.id)?.output).toEqual(['build ran']);
+    expect(order).toEqual(['build', 'deploy']);
+  });
+
+  it('should record why a run failed', async () => {
+    const script = fakeScript('/jobs/broken.mcps', [
+      {
+        name: 'broken',
+        schedule: null,
+        fire: () => {
+          throw new Error('Tool failed');
+        },
+      },
+    ]);
+    const service = new TriggerService([script]);
+    const { id } = service.fire('broken', 'webhook');
+    expect(await service.settled(id)).toMatchObject({
+      state: 'failed',
+      error: expect.stringContaining('Tool failed'),
+    });
+    expect(() => service.fire('missing', 'webhook')).toThrow(
+      'Unknown trigger: missing'
+    );
+  });
+
+  it('should keep finished runs and an audit log in storage', async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-history-'));
+    const storage = new FileStorage({
+      runs: join(dir, 'runs'),
+      audit: join(dir, 'audit'),
+    });
+    try {
+      const script = fakeScript('/jobs/build.mcps', [
+        { name: 'build', schedule: null },
+      ]);
+      const first = new TriggerService([script], { storage });
+      const { id } = first.fire('build', 'webhook');
+      await first.settled(id);
+      await first.stop();
+
+      // A later service lists the run it no longer knows itself
+      const second = new TriggerService([script], { storage });
+      expect(await second.runHistory()).toMatchObject([
+        { id, trigger: 'build', state: 'succeeded', output: ['build ran'] },
+      ]);
+      expect(await second.findRun(id)).toMatchObject({ id });
+
+      const [day] = await storage.list('audit');
+      const entries = (await storage.read('audit', day.key))!
+        .trim()
+        .split('\n')
+        .map(line => JSON.parse(line));
+      expect(entries).toMatchObject([
+        { run: id, trigger: 'build', cause: 'webhook', state: 'queued' },
+        { run: id, state: 'succeeded' },
+      ]);
+    } finally {
+      await rm(dir, { recursive: true, force: true });
+    }
+  });
+
+  it('should redact stored runs and audit entries', async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-history-'));
+    const storage = new FileStorage({
+      runs: join(dir, 'runs'),
+      audit: join(dir, 'audit'),
+    });
+    try {
+      const script = fakeScript('/jobs/deploy.mcps', [
+        {
+          name: 'deploy',
+          schedule: null,
+          fire: () => {
+            throw new Error('Login failed with token tok_1234567890');
+          },
+        },
+      ]);
+      script.redactor = new Redactor({ patterns: ['tok_[0-9]+'] });
+      const service = new TriggerService([script], { storage });
+      const { id } = service.fire('deploy', 'webhook');
+      await service.settled(id);
+      await service.stop();
+
+      const [day] = await storage.list('audit');
+      const audit = (await storage.read('audit', day.key))!;
+      const stored = (await storage.read('runs', id))!;
+      for (const data of [audit, stored]) {
+        expect(data).toContain(`Login failed with token ${REDACTED}`);
+        expect(data).not.toContain('tok_1234567890');
+      }
+    } finally {
+      await rm(dir, { recursive: true, force: true });
+    }
+  });
+
+  it('should fire a scheduled run once among services sharing storage', async () => {
+    const dir = await mkdtemp(join(tmpdir(), 'mcps-schedules-'));
+    const storage = new FileStorage({ schedules: dir });
+    try {
+      vi.useFakeTimers({ now: new Date(2026, 0, 1, 1, 30) });
+      const services = [1, 2].map(
+        () =>
+          new TriggerService(
+            [
+              fakeScript('/jobs/nightly.mcps', [
+                { name: 'nightly', schedule: '0 2 * * *' },
+              ]),
+            ],
+            { storage }
+          )
+      );
+      services.forEach(service => service.start());
+
+      await vi.advanceTimersByTimeAsync(30 * 60_000);
+      await vi.waitFor(() =>
+        expect(services.flatMap(service => service.listRuns())).toHaveLength(1)
+      );
+      await Promise.all(services.map(service => service.stop()));
+      expect(services.flatMap(service => service.listRuns())).toHaveLength(1);
+
+      const claims = await storage.list('schedules');
+      expect(claims).toHaveLength(1);
+      const claim = await storage.read('schedules', claims[0].key);
+      expect(JSON.parse(claim!)).toMatchObject({
+        trigger: 'nightly',
+        scheduledFor: new Date(2026, 0, 1, 2, 0).toISOString(),
+      });
+    } finally {
+      await rm(dir, { recursive: true, force: true });
+    }
+  });
+
+  it('should fail runs with issues that have an exit code', async () => {
+    const script = fakeScript('/jobs/sync.mcps', [
+      {
+        name: 'sync',
+        schedule: null,
+        fire: () =>
+          script.outcome?.record(
+            'errorResult',
+            'crm.update answered with an error: locked'
+          ),
+      },
+    ]);
+    script.outcome = new RunOutcome();
+    script.exitCodes = { errorResults: 3 };
+    const service = new TriggerService([script]);
+
+    const failed = service.fire('sync', 'webhook');
+    expect(await service.settled(failed.id)).toMatchObject({
+      state: 'failed',
+      error: 'crm.update answered with an error: locked',
+    });
+
+    script.exitCodes = { partialFailures: 2 };
+    const passed = service.fire('sync', 'webhook');
+    expect(await service.settled(passed.id)).toMatchObject({
+      state: 'succeeded',
+    });
+    expect(script.outcome.issues).toHaveLength(1);
+  });
+
+  it('should deliver events to the handlers of the other scripts', async () => {
+    const deploy = handlingScript('/jobs/deploy.mcps', ['deploy.finished']);
+    const notify = handlingScript('/jobs/notify.mcps', [
+      'deploy.finished',
+      'deploy.failed',
+    ]);
+    const service = new TriggerService([deploy, notify]);
+    expect(service.listEvents()).toEqual([
+      { event: 'deploy.finished', file: '/jobs/deploy.mcps' },
+      { event: 'deploy.finished', file: '/jobs/notify.mcps' },
+      { event: 'deploy.failed', file: '/jobs/notify.mcps' },
+    ]);
+
+    const runs = service.deliver('deploy.finished', { v: 1 }, 'queue');
+    expect(runs).toMatchObject([
+      { event: 'deploy.finished', file: '/jobs/deploy.mcps', cause: 'queue' },
+      { event: 'deploy.finished', file: '/jobs/notify.mcps', cause: 'queue' },
+    ]);
+    expect(await service.settled(runs[1].id)).toMatchObject({
+      state: 'succeeded',
+      output: ['deploy.finished: {"v":1}'],
+    });
+
+    // What a script emits, its own handlers already handled
+    deploy.emitted?.('deploy.finished', 'again');
+    const [emitted] = service.listRuns().slice(-1);
+    expect(emitted).toMatchObject({ file: '/jobs/notify.mcps', cause: 'emit' });
+    expect(service.listRuns()).toHaveLength(3);
+    expect(service.deliver('unknown', null, 'queue')).toEqual([]);
+  });
+});
+
+describe('trigger server', () => {
+  let dir: string | undefined;
+
+  afterEach(async () => {
+    if (dir) {
+      await rm(dir, { recursive: true, force: true });
+      dir = undefined;
+    }
+  });
+
+  async function serve(
+    service: TriggerService,
+    auth?: Authenticator[]
+  ): Promise<{ url: string; close: () => Promise<void> }> {
+    const server = createTriggerServer(service, { auth });
+    await new Promise<void>(resolve =>
+      server.listen(0, '127.0.0.1', () => resolve())
+    );
+    return {
+      url: `http://127.0.0.1:${(server.address() as AddressInfo).port}`,
+      close: () => new Promise(resolve => server.close(() => resolve())),
+    };
+  }
+
+  it('should fire triggers on webhooks and report their runs', async () => {
+    const service = new TriggerService([
+      fakeScript('/jobs/report.mcps', [
+        { name: 'report', schedule: '@daily', fire: () => 'sent' },
+      ]),
+    ]);
+    const { url, close } = await serve(service, [
+      sharedTokenAuthenticator('serve-token'),
+    ]);
+    const auth = { Authorization: 'Bearer serve-token' };
+    try {
+      expect((await fetch(`${url}${HEALTH_PATH}`)).status).toBe(401);
+
+      const fired = await fetch(`${url}${TRIGGERS_PATH}/report`, {
+        method: 'POST',
+        headers: auth,
+      });
+      expect(fired.status).toBe(202);
+      const run = (await fired.json()) as TriggerRunStatus;
+      expect(fired.headers.get('location')).toBe(`${RUNS_PATH}/${run.id}`);
+      expect(run.cause).toBe('webhook');
+      await service.settled(run.id);
+
+      const status = await fetch(`${url}${RUNS_PATH}/${run.id}`, {
+        headers: auth,
+      });
+      expect(await status.json()).toMatchObject({
+        state: 'succeeded',
+        output: ['report ran', 'sent'],
+      });
+      const logs = await fetch(`${url}${RUNS_PATH}/${run.id}/logs`, {
+        headers: auth,
+      });
+      expect(new EventDecoder().push(await logs.text())).toEqual([
+        { type: 'output', stream: 'stdout', text: 'report ran\n' },
+        { type: 'output', stream: 'stdout', text: 'sent\n' },
+        { type: 'exit', code: 0 },
+      ]);
+
+      const list = await fetch(`${url}${TRIGGERS_PATH}`, { headers: auth });
+      const { triggers } = (await list.json()) as {
+        triggers: TriggerStatus[];
+      };
+      expect(triggers).toMatchObject([
+        {
+          name: 'report',
+          file: '/jobs/report.mcps',
+          schedule: '@daily',
+          lastRun: { id: run.id, state: 'succeeded' },
+        },
+      ]);
+
+      const runs = await fetch(`${url}${RUNS_PATH}`, { headers: auth });
+      expect(await runs.json()).toMatchObject({ runs: [{ id: run.id }] });
+
+      const unknown = await fetch(`${url}${TRIGGERS_PATH}/other`, {
+        method: 'POST',
+        headers: auth,
+      });
+      expect(unknown.status).toBe(404);
+      const wrongMethod = await fetch(`${url}${RUNS_PATH}`, {
+        method: 'POST',
+        headers: auth,
+      });
+      expect(wrongMethod.status).toBe(405);
+    } finally {
+      await close();
+    }
+  });
+
+  it('should serve the kept results of read-only triggers', async () => {
+    let count = 0;
+    const service = new TriggerService(
+      [
+        fakeScript('/jobs/status.mcps', [
+          { name: 'status', schedule: null, fire: () => ++count },
+          { name: 'deploy', schedule: null },
+        ]),
+      ],
+      { triggers: { status: { readOnly: true, maxAge: 30 } } }
+    );
+    const { url, close } = await serve(service);
+    const result = (name: string, headers: Record<string, string> = {}) =>
+      fetch(`${url}${TRIGGERS_PATH}/${name}/result`, { headers });
+    try {
+      // Requests at once share the run
+      const [first, second] = await Promise.all([
+        result('status'),
+        result('status'),
+      ]);
+      expect(first.status).toBe(200);
+      expect(first.headers.get('cache-control')).toMatch(
+        /^private, max-age=(29|30)$/
+      );
+      const run = (await first.json()) as TriggerRunStatus;
+      expect(run).toMatchObject({
+        trigger: 'status',
+        state: 'succeeded',
+        output: ['status ran', '1'],
+      });
+      expect(await second.json()).toEqual(run);
+
+      const etag = first.headers.get('etag')!;
+      const unchanged = await result('status', { 'If-None-Match': etag });
+      expect(unchanged.status).toBe(304);
+      expect(count).toBe(1);
+
+      expect(service.triggerStatus('status')).toMatchObject({
+        readOnly: true,
+      });
+      const notReadOnly = await result('deploy');
+      expect(notReadOnly.status).toBe(404);
+      expect(await notReadOnly.json()).toEqual({
+        error: 'Trigger deploy is not read-only, so its result is not served',
+      });
+      const fired = await fetch(`${url}${TRIGGERS_PATH}/status/result`, {
+        method: 'POST',
+      });
+      expect(fired.status).toBe(405);
+    } finally {
+      await close();
+    }
+  });
+
+  it('should refuse settings of triggers no script declares', () => {
+    expect(
+      () =>
+        new TriggerService([fakeScript('/jobs/status.mcps', [])], {
+          triggers: { status: { readOnly: true } },
+        })
+    ).toThrow(
+      '.mcpsrc has settings for the trigger status, which no served script declares'
+    );
+  });
+
+  it('should deliver posted events to their handlers', async () => {
+    const service = new TriggerService([
+      handlingScript('/jobs/notify.mcps', ['deploy.finished']),
+    ]);
+    const { url, close } = await serve(service);
+    try {
+      const posted = await fetch(`${url}${EVENTS_PATH}/deploy.finished`, {
+        method: 'POST',
+        body: JSON.stringify({ version: '1.2' }),
+      });
+      expect(posted.status).toBe(202);
+      const delivery = (await posted.json()) as EventDelivery;
+      expect(delivery).toMatchObject({
+        event: 'deploy.finished',
+        runs: [{ event: 'deploy.finished', cause: 'webhook' }],
+      });
+      expect(await service.settled(delivery.runs[0].id)).toMatchObject({
+        state: 'succeeded',
+        output: ['deploy.finished: {"version":"1.2"}'],
+      });
+
+      const list = await fetch(`${url}${EVENTS_PATH}`);
+      expect(await list.json()).toEqual({
+        events: [{ event: 'deploy.finished', file: '/jobs/notify.mcps' }],
+      });
+
+      const unhandled = await fetch(`${url}${EVENTS_PATH}/other`, {
+        method: 'POST',
+      });
+      expect(unhandled.status).toBe(404);
+      const malformed = await fetch(`${url}${EVENTS_PATH}/deploy.finished`, {
+        method: 'POST',
+        body: '{',
+      });
+      expect(malformed.status).toBe(400);
+    } finally {
+      await close();
+    }
+  });
+
+  it('should run for the OIDC caller presenting a token', async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-serve-'));
+    const storage = new FileStorage({
+      runs: join(dir, 'runs'),
+      audit: join(dir, 'audit'),
+    });
+    const service = new TriggerService(
+      [
+        fakeScript('/jobs/report.mcps', [
+          { name: 'report', schedule: null, fire: () => currentCaller() },
+        ]),
+      ],
+      { storage }
+    );
+    const alice = {
+      subject: 'alice',
+      issuer: 'https://login.example.com',
+      claims: { email: 'alice@example.com' },
+    };
+    const verifier = {
+      verify: async (token: string) => {
+        if (token !== 'alice.jwt.sig') {
+          throw new Error('the token has expired');
+        }
+        return alice;
+      },
+    } as unknown as OidcVerifier;
+    const { url, close } = await serve(service, [
+      sharedTokenAuthenticator('serve-token'),
+      oidcAuthenticator(verifier),
+    ]);
+    const fire = (token: string) =>
+      fetch(`${url}${TRIGGERS_PATH}/report`, {
+        method: 'POST',
+        headers: { Authorization: `Bearer ${token}` },
+      });
+    try {
+      const fired = await fire('alice.jwt.sig');
+      const run = (await fired.json()) as TriggerRunStatus;
+      expect(run.caller).toBe('alice');
+      // The run's tool calls pass on the caller
+      expect(await service.settled(run.id)).toMatchObject({
+        output: ['report ran', JSON.stringify(alice)],
+      });
+
+      // Clients sharing the service's token are not told apart
+      const shared = (await (await fire('serve-token')).json()) as {
+        id: string;
+      };
+      expect(shared).not.toHaveProperty('caller');
+      await service.settled(shared.id);
+
+      const expired = await fire('bob.jwt.sig');
+      expect(expired.status).toBe(401);
+      expect(await expired.json()).toEqual({
+        error: 'Invalid token: the token has expired',
+      });
+
+      await service.stop();
+      const [day] = await storage.list('audit');
+      const entries = (await storage.read('audit', day.key))!
+        .trim()
+        .split('\n')
+        .map(line => JSON.parse(line));
+      expect(entries.map(entry => entry.caller)).toEqual([
+        alice,
+        alice,
+        undefined,
+        undefined,
+      ]);
+    } finally {
+      await close();
+    }
+  });
+
+  it('should load only the scripts that declare triggers', async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-serve-'));
+    const ping = join(dir, 'ping.mcps');
+    const once = join(dir, 'once.mcps');
+    await writeFile(ping, 'reply = "pong"\ntrigger ping: webhook() -> reply\n');
+    await writeFile(once, 'print("should not run")\n');
+    const greet = join(dir, 'greet.mcps');
+    await writeFile(
+      greet,
+      'on event("greet") (name: string) { print("hello " + name) }\n'
+    );
+
+    const scripts = await loadTriggerScripts([ping, once, greet]);
+    expect(scripts.map(script => script.file)).toEqual([ping, greet]);
+    const service = new TriggerService(scripts);
+    const { id } = service.fire('ping', 'webhook');
+    expect(await service.settled(id)).toMatchObject({
+      state: 'succeeded',
+      output: ['pong'],
+    });
+    const [greeting] = service.deliver('greet', 'Ada', 'webhook');
+    expect(await service.settled(greeting.id)).toMatchObject({
+      state: 'succeeded',
+      output: ['hello Ada'],
+    });
+    // Runs log the statements they execute
+    expect(service.followRun(greeting.id)?.events).toContainEqual({
+      type: 'log',
+      entry: expect.objectContaining({
+        severity: 'debug',
+        kind: 'statement',
+        line: 1,
+      }),
+    });
+    await service.stop();
+  });
+});
@@ -0,0 +1,55 @@
+import { describe, it, expect } from 'vitest';
+import { createUnifiedDiff } from '../../ui/diff.js';
+
+const lines = (count: number) =>
+  Array.from({ length: count }, (_, i) => `line ${i + 1}`);
+
+const diffLines = (before: string[], after: string[]) =>
+  createUnifiedDiff('a.mcps', before.join('\n'), after.join('\n'));
+
+describe('createUnifiedDiff', () => {
+  it('should return an empty string for equal texts', () => {
+    expect(createUnifiedDiff('a.mcps', 'x = 1\n', 'x = 1\n')).toBe('');
+  });
+
+  it('should show a changed line with its context', () => {
+    const before = lines(10);
+    const after = [...before];
+    after[4] = 'changed';
+
+    expect(diffLines(before, after)).toBe(
+      [
+        '--- a.mcps',
+        '+++ a.mcps',
+        '@@ -2,7 +2,7 @@',
+        ' line 2',
+        ' line 3',
+        ' line 4',
+        '-line 5',
+        '+changed',
+        ' line 6',
+        ' line 7',
+        ' line 8',
+        '',
+      ].join('\n')
+    );
+  });
+
+  it('should split distant changes into separate hunks', () => {
+    const before = lines(20);
+    const after = [...before];
+    after[1] = 'first';
+    after.splice(15, 1);
+
+    const hunks = diffLines(before, after)
+      .split('\n')
+      .filter(l => l.startsWith('@@'));
+    expect(hunks).toEqual(['@@ -1,5 +1,5 @@', '@@ -13,7 +13,6 @@']);
+  });
+
+  it('should diff against an empty file', () => {
+    expect(createUnifiedDiff('a.mcps', '', 'x = 1\n')).toBe(
+      '--- a.mcps\n+++ a.mcps\n@@ -0,0 +1,1 @@\n+x = 1\n'
+    );
+  });
+});
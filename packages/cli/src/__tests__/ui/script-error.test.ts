@@ -0,0 +1,35 @@
+import { describe, it, expect } from 'vitest';
+import { formatScriptError } from '../../ui/script-error.js';
+
+// Escape sequences of ANSI colors and styles
+const ANSI_PATTERN = /\x1b\[[0-9;]*m/g;
+
+function failure() {
+  return Object.assign(new Error('record.missing is undefined'), {
+    name: 'TypeError',
+    scriptStack: [
+      {
+        tool: 'lookup',
+        file: 'lookup.mcps',
+        line: 2,
+        column: 3,
+        excerpt: '  return record.missing.field',
+      },
+    ],
+  });
+}
+
+describe('formatScriptError', () => {
+  it('should highlight source excerpts when colored', () => {
+    const lines = formatScriptError(failure(), true).split('\n');
+    expect(lines[2]).toContain('\x1b[38;2;');
+    expect(lines[2].replace(ANSI_PATTERN, '')).toBe(
+      '    2 │   return record.missing.field'
+    );
+  });
+
+  it('should print excerpts as they are without color', () => {
+    const lines = formatScriptError(failure(), false).split('\n');
+    expect(lines[2]).toBe('    2 │   return record.missing.field');
+  });
+});
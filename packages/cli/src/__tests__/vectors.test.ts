@@ -0,0 +1,50 @@
+import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { readdir, mkdtemp, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { embeddingModel, FileVectorStore, vectorStore } from '../vectors.js';
+
+const records = [{ id: 'a', vector: [1, 2, 3], text: 'hello' }];
+
+describe('vector stores', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-vectors-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should keep each collection in a file', async () => {
+    const store = new FileVectorStore(join(dir, 'index'));
+    expect(await store.load('docs')).toBeUndefined();
+
+    await store.save('docs', records);
+    expect(await store.load('docs')).toEqual(records);
+    expect(await readdir(join(dir, 'index'))).toEqual(['docs.json']);
+  });
+
+  it('should keep collections in the project by default', async () => {
+    const loaded = { config: {}, path: join(dir, '.mcpsrc') };
+    await vectorStore(loaded).save('docs', records);
+    expect(await readdir(join(dir, '.mcps', 'vectors'))).toEqual([
+      'docs.json',
+    ]);
+
+    const configured = {
+      config: { vectors: { path: 'data' } },
+      path: join(dir, '.mcpsrc'),
+    };
+    await vectorStore(configured).save('docs', records);
+    expect(await readdir(join(dir, 'data'))).toEqual(['docs.json']);
+  });
+
+  it('should read the embedding model of the project', () => {
+    expect(embeddingModel({ config: {} })).toBeUndefined();
+    expect(
+      embeddingModel({ config: { embeddings: { provider: 'local' } } })
+    ).toEqual({ provider: 'local' });
+  });
+});
@@ -0,0 +1,88 @@
+// Wire protocol of the analysis service started by `mcps api`
+//
+// Clients POST a JSON request to one of the analysis paths and get a JSON
+// response back. Diagnostics use the language server's format, with
+// zero-based lines and UTF-16 columns, so editors and web frontends can
+// show them directly.
+import { isAbsolute } from 'path';
+import type { Diagnostic } from '../lsp/protocol.js';
+
+export const PARSE_PATH = '/v1/parse';
+export const CHECK_PATH = '/v1/check';
+export const FORMAT_PATH = '/v1/format';
+export const HEALTH_PATH = '/v1/health';
+/** Served by the web playground's handler, see playground/handler.ts */
+export const PLAYGROUND_PATH = '/v1/playground';
+export const DEFAULT_API_PORT = 7338;
+/** Largest request body the service accepts */
+export const MAX_SOURCE_BYTES = 1024 * 1024;
+
+/**
+ * Source to analyze
+ */
+export interface AnalysisRequest {
+  source: string;
+  /**
+   * Absolute path of the file on the service's machine; imports are only
+   * resolved when it is given
+   */
+  path?: string;
+}
+
+export interface FormatRequest extends AnalysisRequest {
+  printWidth?: number;
+  indentWidth?: number;
+}
+
+export interface ParseResponse {
+  diagnostics: Diagnostic[];
+  /** Syntax tree as an S-expression */
+  tree: string;
+}
+
+export interface CheckResponse {
+  diagnostics: Diagnostic[];
+}
+
+/**
+ * The formatted source, or the syntax errors that prevented formatting
+ */
+export interface FormatResponse {
+  formatted?: string;
+  diagnostics: Diagnostic[];
+}
+
+function isPositiveInteger(value: unknown): boolean {
+  return typeof value === 'number' && Number.isInteger(value) && value > 0;
+}
+
+/**
+ * Check the shape of a parse, check or format request
+ */
+export function validateAnalysisRequest(value: unknown): FormatRequest {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('request must be a JSON object');
+  }
+  const { source, path, printWidth, indentWidth } = value as Record<
+    string,
+    unknown
+  >;
+  if (typeof source !== 'string') {
+    throw new Error('"source" must be a string');
+  }
+  if (path !== undefined && (typeof path !== 'string' || !isAbsolute(path))) {
+    throw new Error('"path" must be an absolute path');
+  }
+  if (printWidth !== undefined && !isPositiveInteger(printWidth)) {
+    throw new Error('"printWidth" must be a positive integer');
+  }
+  if (indentWidth !== undefined && !isPositiveInteger(indentWidth)) {
+    throw new Error('"indentWidth" must be a positive integer');
+  }
+  return {
+    source,
+    path,
+    printWidth: printWidth as number | undefined,
+    indentWidth: indentWidth as number | undefined,
+  };
+}
@@ -0,0 +1,142 @@
+// Analysis service: parse, check and format over HTTP for other tools
+import {
+  createServer,
+  type IncomingMessage,
+  type Server,
+  type ServerResponse,
+} from 'http';
+import { pathToFileURL } from 'url';
+import {
+  formatTree,
+  ParseError,
+  ParseLimitError,
+  parseTree,
+} from '@mcpscript/transpiler';
+import { HttpError, readJson, sendError, sendJson } from '../http.js';
+import {
+  collectDiagnostics,
+  collectSyntaxDiagnostics,
+  loadDocumentImports,
+} from '../lsp/analysis.js';
+import { createPlaygroundHandler } from '../playground/handler.js';
+import {
+  CHECK_PATH,
+  FORMAT_PATH,
+  HEALTH_PATH,
+  MAX_SOURCE_BYTES,
+  PARSE_PATH,
+  PLAYGROUND_PATH,
+  validateAnalysisRequest,
+  type CheckResponse,
+  type FormatRequest,
+  type FormatResponse,
+  type ParseResponse,
+} from './protocol.js';
+import packageJson from '../../package.json' with { type: 'json' };
+
+function parse({ source }: FormatRequest): ParseResponse {
+  const tree = parseTree(source);
+  return {
+    diagnostics: collectSyntaxDiagnostics(tree, source),
+    tree: tree.rootNode.toString(),
+  };
+}
+
+function check({ source, path }: FormatRequest): CheckResponse {
+  const tree = parseTree(source);
+  const imports = path
+    ? loadDocumentImports(pathToFileURL(path).href, tree, source)
+    : undefined;
+  return { diagnostics: collectDiagnostics(tree, source, imports) };
+}
+
+function format(request: FormatRequest): FormatResponse {
+  const { source, printWidth, indentWidth } = request;
+  const tree = parseTree(source);
+  try {
+    const formatted = formatTree(tree, source, { printWidth, indentWidth });
+    return { formatted, diagnostics: [] };
+  } catch (error) {
+    if (!(error instanceof ParseError)) {
+      throw error;
+    }
+    return { diagnostics: collectSyntaxDiagnostics(tree, source) };
+  }
+}
+
+const ANALYSES: Record<string, (request: FormatRequest) => unknown> = {
+  [PARSE_PATH]: parse,
+  [CHECK_PATH]: check,
+  [FORMAT_PATH]: format,
+};
+
+const playground = createPlaygroundHandler();
+
+async function route(
+  request: IncomingMessage,
+  response: ServerResponse
+): Promise<void> {
+  const { pathname } = new URL(request.url ?? '/', 'http://localhost');
+  const method = request.method ?? 'GET';
+
+  if (pathname === HEALTH_PATH) {
+    if (method !== 'GET') {
+      throw new HttpError(405, `Use GET for ${pathname}`);
+    }
+    return sendJson(response, 200, { version: packageJson.version });
+  }
+  if (pathname === PLAYGROUND_PATH) {
+    return playground(request, response);
+  }
+
+  const analyze = ANALYSES[pathname];
+  if (!analyze) {
+    throw new HttpError(404, `Unknown path: ${request.url}`);
+  }
+  if (method !== 'POST') {
+    throw new HttpError(405, `Use POST for ${pathname}`);
+  }
+  const body = await readJson(
+    request,
+    MAX_SOURCE_BYTES,
+    validateAnalysisRequest
+  );
+  let result: unknown;
+  try {
+    result = analyze(body);
+  } catch (error) {
+    if (error instanceof ParseLimitError) {
+      throw new HttpError(413, error.message);
+    }
+    throw error;
+  }
+  sendJson(response, 200, result);
+}
+
+/**
+ * Create the analysis service's HTTP server
+ * Requests are answered from one long-lived process, so tools can check
+ * many files without starting the CLI for each of them. The service has
+ * no authentication and reads the files imports refer to, so it should
+ * only listen on trusted interfaces
+ */
+export function createAnalysisServer(): Server {
+  return createServer((request, response) => {
+    route(request, response).catch(error => sendError(response, error));
+  });
+}
+
+/**
+ * Start the analysis service
+ */
+export async function startAnalysisServer(options: {
+  host: string;
+  port: number;
+}): Promise<Server> {
+  const server = createAnalysisServer();
+  await new Promise<void>((resolve, reject) => {
+    server.once('error', reject);
+    server.listen(options.port, options.host, () => resolve());
+  });
+  return server;
+}
@@ -0,0 +1,81 @@
+// Questions of runs nobody watches
+//
+// prompt.text(), prompt.select() and prompt.confirm() are asked at the
+// terminal in interactive runs. Runs without one, such as the triggered
+// runs of `mcps serve` and remote runs, send them to the approval webhook
+// of the project's config instead: a POST of { "script": <file>,
+// "question": { "kind": "text" | "select" | "confirm", "message": ...,
+// ... } }, which the webhook answers once someone has with { "answer":
+// ... }. The runtime checks the answer, so a webhook that answers a select
+// question with anything but the index of an option fails the script.
+import {
+  checkOffline,
+  type OperatorQuestion,
+  type QuestionHandler,
+} from '@mcpscript/runtime';
+import type { ApprovalsConfig } from './config.js';
+import { offlineMode } from './offline.js';
+
+/** How long a webhook may take to answer by default: ten minutes */
+export const DEFAULT_APPROVAL_TIMEOUT = 10 * 60 * 1000;
+
+/**
+ * Environment variable with a token sent to the webhook as a bearer token
+ */
+export const APPROVAL_TOKEN_VARIABLE = 'MCPS_APPROVAL_TOKEN';
+
+/**
+ * Ask a script's questions through the project's approval webhook, which
+ * offline runs only reach on this machine
+ */
+export function approvalWebhook(
+  approvals: ApprovalsConfig,
+  script: string,
+  fetchImpl: typeof fetch = fetch
+): QuestionHandler {
+  if (offlineMode()) {
+    checkOffline('the approval webhook', approvals.webhook);
+  }
+  return async (question: OperatorQuestion) => {
+    const token = process.env[APPROVAL_TOKEN_VARIABLE];
+    const response = await fetchImpl(approvals.webhook, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        ...(token ? { Authorization: `Bearer ${token}` } : {}),
+      },
+      body: JSON.stringify({ script, question }),
+      signal: AbortSignal.timeout(
+        approvals.timeout ?? DEFAULT_APPROVAL_TIMEOUT
+      ),
+    });
+    if (!response.ok) {
+      throw new Error(
+        `Approval webhook answered ${response.status} to: ${question.message}`
+      );
+    }
+    const body = (await response.json()) as { answer?: unknown } | null;
+    return body?.answer;
+  };
+}
+
+/**
+ * Questions handler of a run nobody watches: the approval webhook when the
+ * project has one, or a handler refusing every question
+ * @param where Kind of run, for the error, such as "triggered runs"
+ */
+export function headlessQuestions(
+  approvals: ApprovalsConfig | undefined,
+  script: string,
+  where: string
+): QuestionHandler {
+  if (approvals) {
+    return approvalWebhook(approvals, script);
+  }
+  return question =>
+    Promise.reject(
+      new Error(
+        `prompt.${question.kind}() is not available in ${where} without an approval webhook ("approvals" in .mcpsrc)`
+      )
+    );
+}
@@ -0,0 +1,240 @@
+// Command-line flags of scripts with a main tool
+//
+// A script declaring `tool main(service: string, replicas: number = 3)`
+// takes the arguments of main as flags after `--`: `mcps run deploy.mcps
+// -- --service api --replicas 5`. Flags are named after the parameters, in
+// kebab case, and their values are read by the parameter's type: numbers
+// and booleans as such, bytes from the file the flag names, arrays of
+// them from repeated flags, and other types as JSON. `mcps run
+// deploy.mcps -- --help` lists the flags with the descriptions of the
+// @param lines of main's doc comment.
+import { readFileSync } from 'fs';
+import {
+  collectDocs,
+  type ParameterDoc,
+  type Statement,
+  type ToolDoc,
+} from '@mcpscript/transpiler';
+import type { ScriptArguments } from '@mcpscript/runtime';
+
+/**
+ * A flag of a script, for a parameter of its main tool
+ */
+export interface ScriptFlag {
+  /** Name of the parameter */
+  name: string;
+  /** The flag, such as --dry-run for dryRun */
+  flag: string;
+  /** Type of the parameter as written, undefined when it has none */
+  type?: string;
+  required: boolean;
+  default?: string;
+  description?: string;
+}
+
+const PRIMITIVE = /^(string|number|boolean|bytes|any)$/;
+
+/**
+ * The flag of a parameter name: dryRun and dry_run both become --dry-run
+ */
+export function flagName(name: string): string {
+  return `--${name
+    .replace(/([a-z0-9])([A-Z])/g, '$1-$2')
+    .replace(/_/g, '-')
+    .toLowerCase()}`;
+}
+
+/**
+ * The flags of a script's main tool
+ */
+export function scriptFlags(main: ToolDoc): ScriptFlag[] {
+  return main.parameters.map((parameter: ParameterDoc) => ({
+    name: parameter.name,
+    flag: flagName(parameter.name),
+    type: parameter.type,
+    required: !parameter.optional && parameter.default === undefined,
+    ...(parameter.default !== undefined && { default: parameter.default }),
+    ...(parameter.doc && { description: parameter.doc }),
+  }));
+}
+
+/**
+ * Read one value of a flag by its type; arrays are read element by element
+ */
+function readValue(flag: ScriptFlag, type: string, text: string): unknown {
+  switch (type) {
+    case 'number': {
+      const value = Number(text);
+      if (text.trim() === '' || Number.isNaN(value)) {
+        throw new Error(`${flag.flag} must be a number, got ${text}`);
+      }
+      return value;
+    }
+    case 'boolean':
+      if (text !== 'true' && text !== 'false') {
+        throw new Error(`${flag.flag} must be true or false, got ${text}`);
+      }
+      return text === 'true';
+    case 'bytes':
+      try {
+        return readFileSync(text);
+      } catch {
+        throw new Error(`${flag.flag} must name a readable file, got ${text}`);
+      }
+    case 'string':
+    case 'any':
+      return text;
+    default:
+      try {
+        return JSON.parse(text);
+      } catch {
+        throw new Error(`${flag.flag} must be JSON of type ${type}`);
+      }
+  }
+}
+
+/**
+ * Read the arguments of a script's main tool from the flags after `--`
+ */
+export function parseScriptArguments(
+  flags: ScriptFlag[],
+  argv: string[]
+): ScriptArguments {
+  const args: ScriptArguments = {};
+  for (let index = 0; index < argv.length; index++) {
+    const arg = argv[index];
+    if (!arg.startsWith('--')) {
+      throw new Error(`Unexpected argument ${arg}, arguments are flags`);
+    }
+    const [written, inline] = arg.includes('=')
+      ? [arg.slice(0, arg.indexOf('=')), arg.slice(arg.indexOf('=') + 1)]
+      : [arg, undefined];
+    const negated = written.startsWith('--no-')
+      ? flags.find(f => f.flag === `--${written.slice(5)}`)
+      : undefined;
+    const flag =
+      negated?.type === 'boolean'
+        ? negated
+        : flags.find(f => f.flag === written || `--${f.name}` === written);
+    if (!flag) {
+      throw new Error(`Unknown flag ${written}`);
+    }
+    if (flag === negated && inline !== undefined) {
+      throw new Error(`${written} takes no value`);
+    }
+
+    const type = flag.type ?? 'any';
+    if (type === 'boolean' && inline === undefined) {
+      args[flag.name] = flag !== negated;
+      continue;
+    }
+    let text = inline;
+    if (text === undefined) {
+      index++;
+      if (index >= argv.length) {
+        throw new Error(`${flag.flag} needs a value`);
+      }
+      text = argv[index];
+    }
+
+    // Arrays of primitives are given one element per flag
+    const element = /^(.+)\[\]$/.exec(type)?.[1];
+    if (element && PRIMITIVE.test(element)) {
+      const values = (args[flag.name] as unknown[] | undefined) ?? [];
+      args[flag.name] = [...values, readValue(flag, element, text)];
+    } else {
+      args[flag.name] = readValue(flag, type, text);
+    }
+  }
+
+  const missing = flags.filter(f => f.required && !(f.name in args));
+  if (missing.length > 0) {
+    throw new Error(`Missing ${missing.map(f => f.flag).join(', ')}`);
+  }
+  return args;
+}
+
+/**
+ * The main tool of a script, with its doc comment, if it declares one
+ */
+export function findMain(
+  statements: Statement[],
+  source: string
+): ToolDoc | undefined {
+  return collectDocs(statements, source).tools.find(
+    tool => tool.name === 'main'
+  );
+}
+
+/**
+ * The arguments of a run of a script, read from the flags after `--`, or
+ * undefined when the script has no main tool and was given none
+ */
+export function mainArguments(
+  file: string,
+  statements: Statement[],
+  source: string,
+  argv: string[]
+): ScriptArguments | undefined {
+  const main = findMain(statements, source);
+  return flagArguments(file, main && scriptFlags(main), argv);
+}
+
+/**
+ * The arguments of a run read by the flags of the script's main tool, as
+ * kept in a prepared run, or undefined when it has no main tool
+ */
+export function flagArguments(
+  file: string,
+  flags: ScriptFlag[] | undefined,
+  argv: string[]
+): ScriptArguments | undefined {
+  if (!flags) {
+    if (argv.length > 0) {
+      throw new Error(`${file} declares no main tool, so it takes no flags`);
+    }
+    return undefined;
+  }
+  try {
+    return parseScriptArguments(flags, argv);
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`${message} (see mcps run ${file} -- --help)`);
+  }
+}
+
+/**
+ * Usage of a script's flags, for `mcps run <file> -- --help`
+ */
+export function formatScriptHelp(
+  file: string,
+  flags: ScriptFlag[],
+  doc?: string
+): string {
+  const lines = [`Usage: mcps run ${file} -- [flags]`];
+  if (doc) {
+    lines.push('', doc);
+  }
+  const rows = flags.map(flag => {
+    const type = flag.type ?? 'any';
+    const usage =
+      type === 'boolean'
+        ? `${flag.flag}, --no-${flag.flag.slice(2)}`
+        : flag.flag;
+    const value = type === 'boolean' ? '' : ` <${type}>`;
+    const notes = [
+      flag.description,
+      flag.required ? '(required)' : undefined,
+      flag.default !== undefined ? `(default: ${flag.default})` : undefined,
+      /\[\]$/.test(type) ? '(repeatable)' : undefined,
+    ].filter(Boolean);
+    return [`${usage}${value}`, notes.join(' ')];
+  });
+  rows.push(['--help', 'Show this help']);
+  const width = Math.max(...rows.map(([usage]) => usage.length));
+  lines.push('', 'Flags:');
+  for (const [usage, notes] of rows) {
+    lines.push(`  ${usage.padEnd(width)}  ${notes}`.trimEnd());
+  }
+  return lines.join('\n');
+}
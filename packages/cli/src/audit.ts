@@ -0,0 +1,271 @@
+// Dependency audit of the MCP servers scripts start
+//
+// Stdio servers are mostly packages run straight from a registry, such as
+// `npx -y @modelcontextprotocol/server-filesystem@2025.8.21` or
+// `uvx mcp-server-fetch==2025.4.7`. Their specifiers are found in the
+// command and args of server declarations and checked against the OSV
+// advisory database, which covers npm and PyPI, and against the registries
+// for newer releases. A specifier without an exact version runs whatever
+// is latest when the server starts, so it is reported as well, and its
+// latest release is what is checked for advisories.
+import type { ServerCapability } from '@mcpscript/transpiler';
+import {
+  findServerPackage,
+  packageSpecifier,
+  type PackageEcosystem,
+} from '@mcpscript/runtime';
+
+export type Ecosystem = PackageEcosystem;
+
+/** Severities of findings, from least to most severe */
+export const SEVERITIES = [
+  'info',
+  'low',
+  'moderate',
+  'high',
+  'critical',
+] as const;
+
+export type Severity = (typeof SEVERITIES)[number];
+
+/**
+ * A package a server declaration runs
+ */
+export interface ServerPackage {
+  server: string;
+  ecosystem: Ecosystem;
+  name: string;
+  /** Exact version, when the specifier pins one */
+  version?: string;
+  /** The specifier as written, such as "mcp-server-fetch==2025.4.7" */
+  specifier: string;
+}
+
+export interface DependencyFinding {
+  server: string;
+  ecosystem: Ecosystem;
+  package: string;
+  /** Version checked: the pinned one, or the latest release */
+  version?: string;
+  kind: 'vulnerable' | 'outdated' | 'unpinned';
+  severity: Severity;
+  message: string;
+  /** Advisory id, such as "GHSA-…" or "PYSEC-…" */
+  advisory?: string;
+  url?: string;
+}
+
+/**
+ * An advisory affecting a version of a package
+ */
+export interface Advisory {
+  id: string;
+  summary?: string;
+  severity: Severity;
+  url?: string;
+}
+
+const isDynamic = (value: string) =>
+  value.includes('${') || value.includes('<dynamic>');
+
+/**
+ * The registry package a server declaration runs, if it runs one through
+ * a known runner
+ */
+export function serverPackage(
+  server: ServerCapability
+): ServerPackage | undefined {
+  if (!server.command || isDynamic(server.command)) {
+    return undefined;
+  }
+  const found = findServerPackage(server.command, server.args);
+  if (!found || isDynamic(found.specifier)) {
+    return undefined;
+  }
+  const { ecosystem, name, version, specifier } = found;
+  return {
+    server: server.name,
+    ecosystem,
+    name,
+    ...(version && { version }),
+    specifier,
+  };
+}
+
+const OSV_URL = 'https://api.osv.dev/v1/query';
+
+interface NpmRelease {
+  version?: string;
+}
+
+interface PyPIProject {
+  info?: { version?: string };
+}
+
+interface OsvVulnerability {
+  id: string;
+  summary?: string;
+  /** GitHub advisories give a severity such as "HIGH" here */
+  database_specific?: { severity?: unknown };
+}
+
+interface OsvAnswer {
+  vulns?: OsvVulnerability[];
+}
+
+function osvSeverity(vulnerability: OsvVulnerability): Severity {
+  const severity = String(vulnerability.database_specific?.severity ?? '')
+    .toLowerCase()
+    .replace('medium', 'moderate');
+  return (SEVERITIES as readonly string[]).includes(severity)
+    ? (severity as Severity)
+    : 'moderate';
+}
+
+/**
+ * Client of the registries and the OSV advisory database; answers are
+ * kept for the audit, so a package several scripts run is looked up once
+ */
+export class AdvisoryDatabase {
+  private readonly latest = new Map<string, Promise<string | undefined>>();
+  private readonly advisories = new Map<string, Promise<Advisory[]>>();
+
+  constructor(private readonly fetchImpl: typeof fetch = fetch) {}
+
+  private async get(url: string, init?: RequestInit): Promise<unknown> {
+    const response = await this.fetchImpl(url, init);
+    if (response.status === 404) {
+      return undefined;
+    }
+    if (!response.ok) {
+      throw new Error(`${url} answered ${response.status}`);
+    }
+    return response.json();
+  }
+
+  /**
+   * The latest release of a package, or undefined when the registry does
+   * not have it
+   */
+  latestVersion(
+    ecosystem: Ecosystem,
+    name: string
+  ): Promise<string | undefined> {
+    const key = `${ecosystem}:${name}`;
+    let latest = this.latest.get(key);
+    if (!latest) {
+      latest =
+        ecosystem === 'npm'
+          ? this.get(
+              `https://registry.npmjs.org/${name.replace('/', '%2f')}/latest`
+            ).then(body => (body as NpmRelease | undefined)?.version)
+          : this.get(`https://pypi.org/pypi/${name}/json`).then(
+              body => (body as PyPIProject | undefined)?.info?.version
+            );
+      this.latest.set(key, latest);
+    }
+    return latest;
+  }
+
+  /**
+   * Advisories affecting a version of a package
+   */
+  vulnerabilities(
+    ecosystem: Ecosystem,
+    name: string,
+    version: string
+  ): Promise<Advisory[]> {
+    const key = `${ecosystem}:${name}@${version}`;
+    let advisories = this.advisories.get(key);
+    if (!advisories) {
+      advisories = this.get(OSV_URL, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ package: { name, ecosystem }, version }),
+      }).then(body =>
+        ((body as OsvAnswer | undefined)?.vulns ?? []).map(vulnerability => ({
+          id: vulnerability.id,
+          summary: vulnerability.summary,
+          severity: osvSeverity(vulnerability),
+          url: `https://osv.dev/vulnerability/${vulnerability.id}`,
+        }))
+      );
+      this.advisories.set(key, advisories);
+    }
+    return advisories;
+  }
+}
+
+/**
+ * Compare dotted versions by their numbers
+ */
+export function compareVersions(a: string, b: string): number {
+  const numbers = (version: string) =>
+    version.split(/[-+]/)[0].split('.').map(part => parseInt(part, 10) || 0);
+  const [left, right] = [numbers(a), numbers(b)];
+  for (let index = 0; index < Math.max(left.length, right.length); index++) {
+    const difference = (left[index] ?? 0) - (right[index] ?? 0);
+    if (difference !== 0) {
+      return difference;
+    }
+  }
+  return 0;
+}
+
+/**
+ * Check the packages of server declarations for advisories, newer
+ * releases and missing versions
+ */
+export async function auditPackages(
+  packages: ServerPackage[],
+  database: AdvisoryDatabase
+): Promise<DependencyFinding[]> {
+  const findings: DependencyFinding[] = [];
+  for (const pkg of packages) {
+    const { server, ecosystem, name, version } = pkg;
+    const latest = await database.latestVersion(ecosystem, name);
+    const finding = (
+      fields: Omit<DependencyFinding, 'server' | 'ecosystem' | 'package'>
+    ) => findings.push({ server, ecosystem, package: name, ...fields });
+
+    if (!version) {
+      finding({
+        version: latest,
+        kind: 'unpinned',
+        severity: 'low',
+        message:
+          `${pkg.specifier} runs the latest version when the server starts` +
+          (latest
+            ? `; pin one, such as ${packageSpecifier(ecosystem, name, latest)}`
+            : ''),
+      });
+    } else if (latest && compareVersions(version, latest) < 0) {
+      const major = (v: string) => v.split('.')[0];
+      finding({
+        version,
+        kind: 'outdated',
+        severity: major(version) !== major(latest) ? 'moderate' : 'info',
+        message: `${name} ${version} is outdated; the latest is ${latest}`,
+      });
+    }
+
+    const checked = version ?? latest;
+    if (!checked) {
+      continue;
+    }
+    const advisories = await database.vulnerabilities(ecosystem, name, checked);
+    for (const advisory of advisories) {
+      finding({
+        version: checked,
+        kind: 'vulnerable',
+        severity: advisory.severity,
+        message:
+          `${name} ${checked} is affected by ${advisory.id}` +
+          (advisory.summary ? `: ${advisory.summary}` : ''),
+        advisory: advisory.id,
+        url: advisory.url,
+      });
+    }
+  }
+  return findings;
+}
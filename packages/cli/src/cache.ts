@@ -0,0 +1,97 @@
+// Content-addressed cache of analysis results
+//
+// Entries are keyed by a hash of the CLI version and whatever the result
+// was computed from (such as a file's path and content). Results that also
+// depend on other files record those files' hashes, and are only reused
+// while every one of them is unchanged.
+import { createHash } from 'crypto';
+import { mkdir, readFile, rename, writeFile } from 'fs/promises';
+import { homedir } from 'os';
+import { dirname, join } from 'path';
+import packageJson from '../package.json' with { type: 'json' };
+
+interface CacheEntry<T> {
+  /** Content hashes of the files the value depends on, by path */
+  dependencies: Record<string, string>;
+  value: T;
+}
+
+export function contentHash(content: string | Buffer): string {
+  return createHash('sha256').update(content).digest('hex');
+}
+
+/**
+ * Where analysis results are cached: $MCPS_CACHE_DIR, or mcps in the
+ * user's cache directory
+ */
+export function defaultCacheDir(): string {
+  if (process.env.MCPS_CACHE_DIR) {
+    return process.env.MCPS_CACHE_DIR;
+  }
+  const base = process.env.XDG_CACHE_HOME || join(homedir(), '.cache');
+  return join(base, 'mcps');
+}
+
+async function fileHash(path: string): Promise<string | undefined> {
+  try {
+    return contentHash(await readFile(path));
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * A cache of JSON values on disk
+ * The cache is best effort: entries that cannot be read or written are
+ * treated as missing, so a broken cache only makes analysis slower
+ */
+export class AnalysisCache {
+  constructor(
+    private readonly dir: string = defaultCacheDir(),
+    private readonly version: string = packageJson.version
+  ) {}
+
+  private entryPath(key: unknown[]): string {
+    const hash = contentHash(JSON.stringify([this.version, ...key]));
+    return join(this.dir, hash.slice(0, 2), `${hash.slice(2)}.json`);
+  }
+
+  /**
+   * The value stored for a key, if the files it depends on are unchanged
+   */
+  async get<T>(key: unknown[]): Promise<T | undefined> {
+    let entry: CacheEntry<T>;
+    try {
+      entry = JSON.parse(await readFile(this.entryPath(key), 'utf-8'));
+    } catch {
+      return undefined;
+    }
+    for (const [path, hash] of Object.entries(entry.dependencies)) {
+      if ((await fileHash(path)) !== hash) {
+        return undefined;
+      }
+    }
+    return entry.value;
+  }
+
+  /**
+   * Store a value, with the content hashes of the files it depends on
+   */
+  async set<T>(
+    key: unknown[],
+    value: T,
+    dependencies: Record<string, string> = {}
+  ): Promise<void> {
+    const path = this.entryPath(key);
+    const entry: CacheEntry<T> = { dependencies, value };
+    // Write to a temporary file first so readers never see half an entry
+    const temporary = `${path}.${process.pid}.tmp`;
+    try {
+      await mkdir(dirname(path), { recursive: true });
+      await writeFile(temporary, JSON.stringify(entry), 'utf-8');
+      await rename(temporary, path);
+    } catch {
+      // Analysis still succeeds without the cache
+    }
+  }
+}
@@ -0,0 +1,175 @@
+// Checkpoints of the runs of mcps run
+//
+// A run saves its variables after each top-level statement of the script
+// under the run's id, which is also the id of its transcript. A run that
+// finishes deletes its checkpoint, so only failed and interrupted runs
+// leave one behind, for mcps run --resume. Checkpoints are kept as JSON
+// files in the user's state directory unless the project's .mcpsrc keeps
+// them in a SQLite database, or in the storage of its other run state.
+import { mkdirSync } from 'fs';
+import { readFile, rm } from 'fs/promises';
+import { dirname, join, resolve } from 'path';
+import type { Checkpoint, CheckpointStore } from '@mcpscript/runtime';
+import type { LoadedConfig } from './config.js';
+import {
+  defaultStorageDir,
+  projectStorage,
+  writeFileAtomic,
+  type Storage,
+} from './storage.js';
+
+const CHECKPOINT_EXTENSION = '.json';
+const DATABASE_NAME = 'checkpoints.db';
+
+/**
+ * Where checkpoints are kept: $MCPS_CHECKPOINT_DIR, or mcps/checkpoints in
+ * the user's state directory
+ */
+export function defaultCheckpointDir(): string {
+  return defaultStorageDir('checkpoints');
+}
+
+/**
+ * Refuse run ids that are not names of files, such as ../other
+ */
+function checkRunId(run: string): void {
+  if (!/^[A-Za-z0-9_-]+$/.test(run)) {
+    throw new Error(`Invalid run id: ${run}`);
+  }
+}
+
+/**
+ * Keeps the checkpoint of each run in a JSON file named after the run
+ */
+export class FileCheckpointStore implements CheckpointStore {
+  constructor(private readonly dir: string = defaultCheckpointDir()) {}
+
+  private path(run: string): string {
+    checkRunId(run);
+    return join(this.dir, `${run}${CHECKPOINT_EXTENSION}`);
+  }
+
+  async load(run: string): Promise<Checkpoint | undefined> {
+    try {
+      return JSON.parse(await readFile(this.path(run), 'utf-8'));
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return undefined;
+      }
+      throw error;
+    }
+  }
+
+  async save(run: string, checkpoint: Checkpoint): Promise<void> {
+    await writeFileAtomic(this.path(run), JSON.stringify(checkpoint));
+  }
+
+  async delete(run: string): Promise<void> {
+    await rm(this.path(run), { force: true });
+  }
+}
+
+/**
+ * The parts of node:sqlite the SQLite store uses
+ */
+interface SqliteDatabase {
+  exec(sql: string): void;
+  prepare(sql: string): {
+    get(...parameters: unknown[]): unknown;
+    run(...parameters: unknown[]): unknown;
+  };
+  close(): void;
+}
+
+/**
+ * Keeps the checkpoints of runs in a table of a SQLite database, using
+ * the node:sqlite module of Node.js 22.5 and later
+ */
+export class SqliteCheckpointStore implements CheckpointStore {
+  private constructor(private readonly db: SqliteDatabase) {}
+
+  static async open(path: string): Promise<SqliteCheckpointStore> {
+    let sqlite: { DatabaseSync: new (path: string) => SqliteDatabase };
+    try {
+      sqlite = await import('node:sqlite' as string);
+    } catch {
+      throw new Error(
+        `SQLite checkpoints need Node.js 22.5 or later, this is ${process.version}`
+      );
+    }
+    mkdirSync(dirname(path), { recursive: true });
+    const db = new sqlite.DatabaseSync(path);
+    db.exec(
+      'CREATE TABLE IF NOT EXISTS checkpoints (run TEXT PRIMARY KEY, checkpoint TEXT NOT NULL)'
+    );
+    return new SqliteCheckpointStore(db);
+  }
+
+  async load(run: string): Promise<Checkpoint | undefined> {
+    const row = this.db
+      .prepare('SELECT checkpoint FROM checkpoints WHERE run = ?')
+      .get(run) as { checkpoint: string } | undefined;
+    return row && JSON.parse(row.checkpoint);
+  }
+
+  async save(run: string, checkpoint: Checkpoint): Promise<void> {
+    this.db
+      .prepare(
+        'INSERT INTO checkpoints (run, checkpoint) VALUES (?, ?) ' +
+          'ON CONFLICT (run) DO UPDATE SET checkpoint = excluded.checkpoint'
+      )
+      .run(run, JSON.stringify(checkpoint));
+  }
+
+  async delete(run: string): Promise<void> {
+    this.db.prepare('DELETE FROM checkpoints WHERE run = ?').run(run);
+  }
+
+  close(): void {
+    this.db.close();
+  }
+}
+
+/**
+ * Keeps the checkpoint of each run in the storage of a project's run state
+ */
+export class StorageCheckpointStore implements CheckpointStore {
+  constructor(private readonly storage: Storage) {}
+
+  async load(run: string): Promise<Checkpoint | undefined> {
+    const data = await this.storage.read('checkpoints', run);
+    return data === undefined ? undefined : JSON.parse(data);
+  }
+
+  async save(run: string, checkpoint: Checkpoint): Promise<void> {
+    await this.storage.write('checkpoints', run, JSON.stringify(checkpoint));
+  }
+
+  async delete(run: string): Promise<void> {
+    await this.storage.delete('checkpoints', run);
+  }
+}
+
+/**
+ * The checkpoint store of a project, with its path resolved against the
+ * directory of the config file; without checkpoint settings, checkpoints
+ * go to the project's storage
+ */
+export async function checkpointStore(
+  loaded: LoadedConfig,
+  storage: Storage = projectStorage(loaded)
+): Promise<CheckpointStore> {
+  if (!loaded.config.checkpoints && loaded.config.storage) {
+    return new StorageCheckpointStore(storage);
+  }
+  const { store = 'file', path } = loaded.config.checkpoints ?? {};
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  if (store === 'sqlite') {
+    return SqliteCheckpointStore.open(
+      path
+        ? resolve(base, path)
+        : join(defaultCheckpointDir(), DATABASE_NAME)
+    );
+  }
+  return new FileCheckpointStore(path ? resolve(base, path) : undefined);
+}
@@ -0,0 +1,21 @@
+// mcps api command
+import type { AddressInfo } from 'net';
+import { startAnalysisServer } from '../api/server.js';
+import type { ApiCommandOptions } from '../types.js';
+
+export async function apiCommand(options: ApiCommandOptions): Promise<void> {
+  try {
+    const server = await startAnalysisServer(options);
+    const { address, port } = server.address() as AddressInfo;
+    console.error(`mcps api listening on http://${address}:${port}`);
+
+    const stop = () => server.close(() => process.exit(0));
+    process.once('SIGINT', stop);
+    process.once('SIGTERM', stop);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
@@ -0,0 +1,114 @@
+// mcps audit deps command
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import {
+  collectCapabilities,
+  createFileLoader,
+  loadProgram,
+} from '@mcpscript/transpiler';
+import { OfflineError } from '@mcpscript/runtime';
+import {
+  AdvisoryDatabase,
+  auditPackages,
+  SEVERITIES,
+  serverPackage,
+  type DependencyFinding,
+  type ServerPackage,
+} from '../audit.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import { offlineMode } from '../offline.js';
+import type { AuditDepsOptions } from '../types.js';
+import { collectFiles } from './fmt.js';
+
+interface FileReport {
+  path: string;
+  packages: ServerPackage[];
+  findings: DependencyFinding[];
+}
+
+/**
+ * The registry packages the servers of a script and its imports run
+ */
+async function scriptPackages(file: string): Promise<ServerPackage[]> {
+  const source = await readFile(file, 'utf-8');
+  const loaded = await loadProjectConfig(dirname(resolve(file)));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  const { servers } = collectCapabilities(
+    loadProgram(resolve(file), source, loader)
+  );
+  return servers.flatMap(server => serverPackage(server) ?? []);
+}
+
+function formatFinding(path: string, finding: DependencyFinding): string {
+  const url = finding.url ? ` (${finding.url})` : '';
+  return `${path}: ${finding.server}: ${finding.severity}: ${finding.message}${url}`;
+}
+
+/**
+ * Report advisories, newer releases and missing versions of the packages
+ * the servers of scripts run
+ */
+export async function auditDepsCommand(
+  options: AuditDepsOptions
+): Promise<void> {
+  const { format = 'text', failOn = 'high' } = options;
+
+  if (offlineMode()) {
+    console.error(
+      `Error: ${new OfflineError('auditing dependencies').message}`
+    );
+    process.exit(1);
+  }
+
+  let files: string[];
+  try {
+    files = await collectFiles(options.paths);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+
+  // Packages several scripts run are looked up once
+  const database = new AdvisoryDatabase();
+  const reports: FileReport[] = [];
+  let failed = false;
+  for (const file of files) {
+    try {
+      const packages = await scriptPackages(file);
+      const findings = await auditPackages(packages, database);
+      reports.push({ path: file, packages, findings });
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      console.error(`Error: ${file}: ${message}`);
+      failed = true;
+    }
+  }
+
+  if (format === 'json') {
+    process.stdout.write(JSON.stringify({ files: reports }, null, 2) + '\n');
+  } else {
+    for (const { path, findings } of reports) {
+      for (const finding of findings) {
+        process.stdout.write(formatFinding(path, finding) + '\n');
+      }
+    }
+    const packages = reports.reduce((n, r) => n + r.packages.length, 0);
+    const findings = reports.reduce((n, r) => n + r.findings.length, 0);
+    process.stdout.write(
+      `${findings} finding(s) in ${packages} server package(s)\n`
+    );
+  }
+
+  // Findings below the threshold are shown but do not fail the audit
+  const threshold = SEVERITIES.indexOf(failOn);
+  const severe = reports.some(report =>
+    report.findings.some(
+      finding => SEVERITIES.indexOf(finding.severity) >= threshold
+    )
+  );
+  if (failed || severe) {
+    process.exit(1);
+  }
+}
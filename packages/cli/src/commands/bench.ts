@@ -0,0 +1,94 @@
+// mcps bench command
+import { readFile, writeFile } from 'fs/promises';
+import {
+  generateCorpus,
+  internalBenchmarks,
+  runBenchmark,
+  compareBenchmarks,
+  type BenchmarkResult,
+} from '@mcpscript/transpiler';
+import type { BenchOptions } from '../types.js';
+
+function formatResults(results: BenchmarkResult[]): string {
+  const width = Math.max(...results.map(r => r.name.length));
+  return results
+    .map(
+      r =>
+        `${r.name.padEnd(width)}  ${String(r.iterations).padStart(6)} runs  ` +
+        `mean ${r.meanMs.toFixed(3).padStart(9)} ms  ` +
+        `p95 ${r.p95Ms.toFixed(3).padStart(9)} ms`
+    )
+    .join('\n');
+}
+
+async function readBaseline(path: string): Promise<BenchmarkResult[]> {
+  const data = JSON.parse(await readFile(path, 'utf-8'));
+  if (!Array.isArray(data?.results)) {
+    throw new Error(`${path} is not a benchmark baseline`);
+  }
+  return data.results;
+}
+
+/**
+ * Time parsing, queries, type checking and code generation, over a script
+ * or, with --internal, over a generated corpus of large scripts
+ * Fails when a baseline is given and a benchmark got slower than the
+ * threshold allows
+ */
+export async function benchCommand(options: BenchOptions): Promise<void> {
+  const { file, internal = false, threshold = 0.2 } = options;
+
+  if (internal === (file !== undefined)) {
+    console.error('Error: Pass either a file or --internal');
+    process.exit(1);
+  }
+  if (file && !file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  try {
+    const source = file
+      ? await readFile(file, 'utf-8')
+      : generateCorpus({ lines: options.lines });
+    const baseline = options.baseline
+      ? await readBaseline(options.baseline)
+      : undefined;
+
+    const results: BenchmarkResult[] = [];
+    for (const benchmark of internalBenchmarks(source)) {
+      results.push(runBenchmark(benchmark, { timeMs: options.timeMs }));
+    }
+
+    if (options.format === 'json') {
+      console.log(JSON.stringify({ results }, null, 2));
+    } else {
+      console.log(formatResults(results));
+    }
+    if (options.save) {
+      await writeFile(
+        options.save,
+        JSON.stringify({ results }, null, 2) + '\n',
+        'utf-8'
+      );
+    }
+
+    if (baseline) {
+      const regressions = compareBenchmarks(results, baseline, threshold);
+      for (const r of regressions) {
+        console.error(
+          `${r.name} regressed: mean ${r.meanMs.toFixed(3)} ms, ` +
+            `baseline ${r.baselineMs.toFixed(3)} ms ` +
+            `(+${((r.ratio - 1) * 100).toFixed(1)}%)`
+        );
+      }
+      if (regressions.length > 0) {
+        process.exit(1);
+      }
+    }
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+}
@@ -0,0 +1,311 @@
+// mcps check command
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import { pathToFileURL } from 'url';
+import { config as dotenvConfig } from 'dotenv';
+import { SecretNotFoundError, SecretStore } from '@mcpscript/runtime';
+import {
+  BUILTIN_LINT_RULES,
+  LINT_API_VERSION,
+  LINT_PACKS,
+  loadAnalyzer,
+  parseTree,
+  toolCallRule,
+  type LintRule,
+  type MessageTranslator,
+} from '@mcpscript/transpiler';
+import { AnalysisCache, contentHash } from '../cache.js';
+import {
+  loadProjectConfig,
+  secretProviders,
+  type LoadedConfig,
+} from '../config.js';
+import {
+  collectDiagnostics,
+  collectInterpolationReferences,
+  collectLintDiagnostics,
+  loadDocumentImports,
+  type DocumentImports,
+} from '../lsp/analysis.js';
+import { DiagnosticSeverity, type Diagnostic } from '../lsp/protocol.js';
+import type { CheckOptions } from '../types.js';
+import { findToolsLock, readToolsLock } from '../tools-lock.js';
+import { messageTranslator, translateDiagnostics } from '../messages.js';
+import { collectFiles } from './fmt.js';
+
+interface FileReport {
+  path: string;
+  diagnostics: Diagnostic[];
+}
+
+function pluginPaths(loaded: LoadedConfig): string[] {
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return (loaded.config.lint?.plugins ?? []).map(plugin =>
+    resolve(base, plugin)
+  );
+}
+
+/**
+ * Rules from a config's lint plugins: JavaScript modules that export
+ * `rules`, and the `apiVersion` they were written for if any, or
+ * WebAssembly analyzers
+ */
+async function loadPluginRules(loaded: LoadedConfig): Promise<LintRule[]> {
+  const rules: LintRule[] = [];
+  for (const plugin of pluginPaths(loaded)) {
+    if (plugin.endsWith('.wasm')) {
+      rules.push(...(await loadAnalyzer(await readFile(plugin), plugin)));
+      continue;
+    }
+    const module = await import(pathToFileURL(plugin).href);
+    if (!Array.isArray(module.rules)) {
+      throw new Error(`Lint plugin ${plugin} must export an array "rules"`);
+    }
+    if ((module.apiVersion ?? 1) > LINT_API_VERSION) {
+      throw new Error(
+        `Lint plugin ${plugin} needs lint API version ${module.apiVersion}; this version of mcps has ${LINT_API_VERSION}`
+      );
+    }
+    rules.push(...module.rules);
+  }
+  return rules;
+}
+
+/**
+ * Hashes of the files a check result depends on besides the script: its
+ * imports, the project config, lint plugins and tool schema lockfile
+ */
+async function checkDependencies(
+  imports: DocumentImports,
+  loaded: LoadedConfig,
+  lockPath: string | undefined
+): Promise<Record<string, string>> {
+  const dependencies: Record<string, string> = {};
+  const { graph } = imports;
+  for (const module of graph?.modules ?? []) {
+    if (module !== graph?.entry) {
+      dependencies[module.path] = contentHash(module.source);
+    }
+  }
+  for (const path of [loaded.path, ...pluginPaths(loaded), lockPath]) {
+    if (path) {
+      dependencies[path] = contentHash(await readFile(path));
+    }
+  }
+  return dependencies;
+}
+
+/**
+ * Errors for the variables and secrets a script's servers reference that
+ * have no value here
+ */
+async function referenceDiagnostics(
+  source: string,
+  secrets: SecretStore
+): Promise<Diagnostic[]> {
+  if (!source.includes('${')) {
+    return [];
+  }
+  const diagnostics: Diagnostic[] = [];
+  const tree = parseTree(source);
+  for (const { reference, range } of collectInterpolationReferences(
+    tree,
+    source
+  )) {
+    let message: string | undefined;
+    if (reference.kind === 'env') {
+      if (process.env[reference.name] === undefined) {
+        message = `Environment variable ${reference.name} is not set`;
+      }
+    } else {
+      message = await secrets.get(reference.name).then(
+        () => undefined,
+        error => {
+          if (error instanceof SecretNotFoundError) {
+            return error.message;
+          }
+          throw error;
+        }
+      );
+    }
+    if (message) {
+      diagnostics.push({
+        range,
+        severity: DiagnosticSeverity.Error,
+        code: reference.kind === 'env' ? 'missing-variable' : 'missing-secret',
+        source: 'mcps',
+        message,
+      });
+    }
+  }
+  return diagnostics;
+}
+
+function sortDiagnostics(diagnostics: Diagnostic[]): Diagnostic[] {
+  return diagnostics.sort(
+    (a, b) =>
+      a.range.start.line - b.range.start.line ||
+      a.range.start.character - b.range.start.character
+  );
+}
+
+function formatDiagnostic(path: string, diagnostic: Diagnostic): string {
+  const { line, character } = diagnostic.range.start;
+  const severity =
+    diagnostic.severity === DiagnosticSeverity.Error ? 'error' : 'warning';
+  const code = diagnostic.code ? ` (${diagnostic.code})` : '';
+  return `${path}:${line + 1}:${character + 1}: ${severity}: ${diagnostic.message}${code}`;
+}
+
+export async function checkCommand(options: CheckOptions): Promise<void> {
+  const { format = 'text', cache = true } = options;
+  const analysisCache = cache ? new AnalysisCache() : undefined;
+  let failed = false;
+  // Variables are checked against the environment scripts run with
+  dotenvConfig({ quiet: true });
+
+  let files: string[];
+  try {
+    files = await collectFiles(options.files);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+
+  // Plugins are loaded once per config file, tool schemas once per lockfile
+  const pluginRules = new Map<string | undefined, Promise<LintRule[]>>();
+  const lockRules = new Map<string, Promise<LintRule>>();
+  const secretStores = new Map<string | undefined, SecretStore>();
+  const translators = new Map<string | undefined, MessageTranslator>();
+  const reports: FileReport[] = [];
+  // Exit codes of the files whose projects fail checks with warnings
+  const warningCodes = new Map<string, number>();
+  for (const file of files) {
+    if (!file.endsWith('.mcps')) {
+      console.error(`Error: ${file}: File must have .mcps extension`);
+      failed = true;
+      continue;
+    }
+
+    try {
+      const source = await readFile(file, 'utf-8');
+      const loaded = await loadProjectConfig(dirname(resolve(file)));
+      warningCodes.set(file, loaded.config.exitCodes?.lintWarnings ?? 0);
+      const lockPath = findToolsLock(dirname(resolve(file)));
+      const key = [
+        'check',
+        resolve(file),
+        source,
+        loaded.path ?? null,
+        lockPath ?? null,
+      ];
+      if (!translators.has(loaded.path)) {
+        translators.set(loaded.path, messageTranslator(loaded));
+      }
+      const translator = translators.get(loaded.path)!;
+      if (!secretStores.has(loaded.path)) {
+        secretStores.set(
+          loaded.path,
+          new SecretStore(secretProviders(loaded))
+        );
+      }
+      // Variables and secrets change without the script changing, so
+      // they are looked up on every check
+      const references = await referenceDiagnostics(
+        source,
+        secretStores.get(loaded.path)!
+      );
+      const cached = await analysisCache?.get<Diagnostic[]>(key);
+      if (cached) {
+        reports.push({
+          path: file,
+          diagnostics: translateDiagnostics(
+            translator,
+            sortDiagnostics([...cached, ...references])
+          ),
+        });
+        continue;
+      }
+
+      if (!pluginRules.has(loaded.path)) {
+        pluginRules.set(loaded.path, loadPluginRules(loaded));
+      }
+      const rules = [
+        ...BUILTIN_LINT_RULES,
+        ...(loaded.config.lint?.packs ?? []).flatMap(pack => LINT_PACKS[pack]),
+        ...(await pluginRules.get(loaded.path)!),
+      ];
+      if (lockPath) {
+        if (!lockRules.has(lockPath)) {
+          lockRules.set(
+            lockPath,
+            readToolsLock(lockPath).then(lock => toolCallRule(lock.servers))
+          );
+        }
+        rules.push(await lockRules.get(lockPath)!);
+      }
+
+      const tree = parseTree(source);
+      const uri = pathToFileURL(resolve(file)).href;
+      const imports = loadDocumentImports(uri, tree, source);
+      const diagnostics: Diagnostic[] = [
+        ...collectDiagnostics(tree, source, imports),
+        ...collectLintDiagnostics(tree, source, imports, {
+          rules,
+          severities: loaded.config.lint?.rules,
+          path: resolve(file),
+        }),
+      ];
+      reports.push({
+        path: file,
+        diagnostics: translateDiagnostics(
+          translator,
+          sortDiagnostics([...diagnostics, ...references])
+        ),
+      });
+
+      // A missing import may appear later, so such results are not kept
+      if (analysisCache && !imports.error) {
+        const dependencies = await checkDependencies(
+          imports,
+          loaded,
+          lockPath
+        );
+        await analysisCache.set(key, diagnostics, dependencies);
+      }
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      console.error(`Error: ${file}: ${message}`);
+      failed = true;
+    }
+  }
+
+  if (format === 'json') {
+    process.stdout.write(JSON.stringify({ files: reports }, null, 2) + '\n');
+  } else {
+    for (const { path, diagnostics } of reports) {
+      for (const diagnostic of diagnostics) {
+        process.stdout.write(formatDiagnostic(path, diagnostic) + '\n');
+      }
+    }
+  }
+
+  // Warnings are shown but only errors fail the run, unless the project
+  // gives warnings an exit code
+  const hasErrors = reports.some(report =>
+    report.diagnostics.some(d => d.severity === DiagnosticSeverity.Error)
+  );
+  if (failed || hasErrors) {
+    process.exit(1);
+  }
+  const warned = reports.find(
+    report =>
+      warningCodes.get(report.path) &&
+      report.diagnostics.some(d => d.severity === DiagnosticSeverity.Warning)
+  );
+  if (warned) {
+    process.exit(warningCodes.get(warned.path));
+  }
+}
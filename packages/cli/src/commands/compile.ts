@@ -1,7 +1,19 @@
 // mcps compile command
 import { readFile } from 'fs/promises';
-import { parseSource, generateCode } from '@mcpscript/transpiler';
+import { dirname, resolve } from 'path';
+import {
+  loadProgram,
+  createFileLoader,
+  generateCode,
+  checkTypes,
+  TypeCheckError,
+  ParseError,
+  ModuleError,
+  formatLocation,
+  getLocation,
+} from '@mcpscript/transpiler';
 import type { CompileOptions } from '../types.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
 
 export async function compileCommand(options: CompileOptions): Promise<void> {
   const { file } = options;
@@ -15,8 +27,13 @@ export async function compileCommand(options: CompileOptions): Promise<void> {
     // Read the source file
     const source = await readFile(file, 'utf-8');
 
-    // Parse the source
-    const ast = parseSource(source);
+    // Parse the source together with the modules it imports
+    const loaded = await loadProjectConfig(dirname(file));
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    const ast = loadProgram(resolve(file), source, loader);
+
+    // Check declared tool signatures
+    checkTypes(ast);
 
     // Generate JavaScript code
     const jsCode = generateCode(ast);
@@ -24,7 +41,20 @@ export async function compileCommand(options: CompileOptions): Promise<void> {
     // Print to stdout
     console.log(jsCode);
   } catch (error) {
-    if (error instanceof Error) {
+    if (error instanceof ParseError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${d.message}`
+      );
+      console.error(`Syntax errors in ${file}:\n${lines.join('\n')}`);
+    } else if (error instanceof TypeCheckError) {
+      console.error(`Type errors in ${file}:\n${error.message}`);
+    } else if (error instanceof ModuleError) {
+      const location = error.statement && getLocation(error.statement);
+      const where = location
+        ? `${error.modulePath}:${formatLocation(location)}: `
+        : '';
+      console.error(`Error: ${where}${error.message}`);
+    } else if (error instanceof Error) {
       console.error(`Error: ${error.message}`);
       if (error.stack) {
         console.error('\nStack trace:');
@@ -0,0 +1,45 @@
+// mcps daemon command (also installed as mcpsd)
+import type { AddressInfo } from 'net';
+import { config as dotenvConfig } from 'dotenv';
+import { startDaemon } from '../remote/daemon.js';
+import { loadDaemonConfig, type DaemonConfig } from '../remote/policy.js';
+import type { DaemonCommandOptions } from '../types.js';
+
+export async function daemonCommand(
+  options: DaemonCommandOptions
+): Promise<void> {
+  // Runs inherit the daemon's environment, including its .env file
+  dotenvConfig({ quiet: true });
+
+  try {
+    const config: DaemonConfig = options.config
+      ? await loadDaemonConfig(options.config)
+      : {};
+    const token = process.env.MCPSD_TOKEN;
+    if (!token && !config.tenants?.length) {
+      console.error('Error: set MCPSD_TOKEN or configure tenants in --config');
+      process.exit(1);
+    }
+
+    const { server, runs } = await startDaemon({
+      ...config,
+      host: options.host,
+      port: options.port,
+      token,
+    });
+    const { address, port } = server.address() as AddressInfo;
+    console.error(`mcpsd listening on http://${address}:${port}`);
+
+    const stop = () => {
+      runs.cancelAll();
+      server.close(() => process.exit(0));
+    };
+    process.once('SIGINT', stop);
+    process.once('SIGTERM', stop);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
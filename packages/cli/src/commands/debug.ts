@@ -0,0 +1,96 @@
+// mcps debug command
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import { config as dotenvConfig } from 'dotenv';
+import {
+  loadProgram,
+  createFileLoader,
+  generateCode,
+  checkTypes,
+  TypeCheckError,
+} from '@mcpscript/transpiler';
+import { executeInVM } from '@mcpscript/runtime';
+import { Connection } from '../lsp/transport.js';
+import { DebugAdapter, type ScriptLauncher } from '../debug/adapter.js';
+import type { DebugMessage } from '../debug/protocol.js';
+import {
+  loadProjectConfig,
+  moduleSearchPaths,
+  secretProviders,
+} from '../config.js';
+import { offlineMode } from '../offline.js';
+import { lockedPackages } from '../tools-lock.js';
+import { formatScriptError } from '../ui/script-error.js';
+
+/**
+ * Run a script the way mcps run does, with debug hooks compiled in
+ */
+export const launchScript: ScriptLauncher = async (
+  program,
+  debug,
+  output,
+  serverManager
+) => {
+  try {
+    const source = await readFile(program, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(program));
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    const ast = loadProgram(resolve(program), source, loader);
+    checkTypes(ast);
+    const jsCode = generateCode(ast, {
+      sourcePositions: true,
+      debugHooks: true,
+    });
+
+    await executeInVM(jsCode, {
+      // Time spent paused does not count
+      timeout: 0,
+      addMessage: ({ title, body }) =>
+        output(title ? `${title}: ${body}` : body, 'stdout'),
+      userInput: () =>
+        Promise.reject(new Error('input() is not available while debugging')),
+      ask: question =>
+        Promise.reject(
+          new Error(
+            `prompt.${question.kind}() is not available while debugging`
+          )
+        ),
+      serverManager,
+      sourceFile: program,
+      source,
+      redaction: loaded.config.redaction,
+      secretProviders: secretProviders(loaded),
+      project: loaded.config.project,
+      debugger: debug,
+      offline: offlineMode(),
+      packages: await lockedPackages(program),
+    });
+    return 0;
+  } catch (error) {
+    const message =
+      error instanceof TypeCheckError
+        ? `Type errors in ${program}:\n${error.message}`
+        : error instanceof Error
+          ? formatScriptError(error, false)
+          : String(error);
+    output(message, 'stderr');
+    return 1;
+  }
+};
+
+export async function debugCommand(): Promise<void> {
+  dotenvConfig({ quiet: true });
+
+  // stdout carries the protocol, so anything else must go to stderr
+  const connection = new Connection<DebugMessage>(
+    process.stdin,
+    process.stdout
+  );
+  const adapter = new DebugAdapter(connection, launchScript, code =>
+    process.exit(code)
+  );
+  adapter.start();
+
+  // The client closing stdin without a disconnect request is a crash
+  process.stdin.on('end', () => process.exit(1));
+}
@@ -0,0 +1,65 @@
+// mcps diff command
+import { readFile } from 'fs/promises';
+import {
+  diffPrograms,
+  formatChange,
+  formatLocation,
+  parseSource,
+  ParseError,
+  type ScriptChange,
+  type Statement,
+} from '@mcpscript/transpiler';
+import type { DiffOptions } from '../types.js';
+
+async function parseFile(file: string): Promise<Statement[]> {
+  const source = await readFile(file, 'utf-8');
+  try {
+    return parseSource(source);
+  } catch (error) {
+    if (error instanceof ParseError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${d.message}`
+      );
+      throw new Error(`Syntax errors in ${file}:\n${lines.join('\n')}`);
+    }
+    throw error;
+  }
+}
+
+/**
+ * Prefix a change with where it is: in the new file, or in the old one
+ * for what was removed
+ */
+function formatChangeIn(options: DiffOptions, change: ScriptChange): string {
+  const [file, location] = change.after
+    ? [options.after, change.after]
+    : [options.before, change.before];
+  return `${file}:${formatLocation(location)}: ${formatChange(change)}`;
+}
+
+export async function diffCommand(options: DiffOptions): Promise<void> {
+  try {
+    const changes = diffPrograms(
+      await parseFile(options.before),
+      await parseFile(options.after)
+    );
+    if (options.format === 'json') {
+      console.log(
+        JSON.stringify(
+          { old: options.before, new: options.after, changes },
+          null,
+          2
+        )
+      );
+    } else {
+      for (const change of changes) {
+        console.log(formatChangeIn(options, change));
+      }
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
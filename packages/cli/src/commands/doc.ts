@@ -0,0 +1,121 @@
+// mcps doc command
+import { mkdir, readFile, writeFile } from 'fs/promises';
+import { basename, dirname, join, resolve } from 'path';
+import {
+  collectDocs,
+  createFileLoader,
+  formatLocation,
+  loadModuleGraph,
+  ParseError,
+  type ScriptDocs,
+} from '@mcpscript/transpiler';
+import type { DocOptions } from '../types.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import {
+  docBlocks,
+  indexBlocks,
+  renderHtml,
+  renderMarkdown,
+  type DocBlock,
+} from '../docgen.js';
+import { collectFiles } from './fmt.js';
+
+interface Page {
+  file: string;
+  /** File name of the page, without extension */
+  name: string;
+  docs: ScriptDocs;
+}
+
+/**
+ * Documentation of a script; the modules it imports are read to find the
+ * MCP tools it calls through them
+ */
+async function readPage(file: string): Promise<Page> {
+  const source = await readFile(file, 'utf-8');
+  const loaded = await loadProjectConfig(dirname(resolve(file)));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  try {
+    const graph = loadModuleGraph(resolve(file), loader, source);
+    const imported = graph.modules
+      .filter(module => module !== graph.entry)
+      .flatMap(module => module.statements);
+    return {
+      file,
+      name: basename(file, '.mcps'),
+      docs: collectDocs(graph.entry.statements, source, imported),
+    };
+  } catch (error) {
+    if (error instanceof ParseError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${d.message}`
+      );
+      throw new Error(`Syntax errors in ${file}:\n${lines.join('\n')}`);
+    }
+    throw error;
+  }
+}
+
+/**
+ * Print reference documentation of scripts, or write a page per script and
+ * an index of them to a directory
+ */
+export async function docCommand(options: DocOptions): Promise<void> {
+  const format = options.format ?? 'markdown';
+  const extension = format === 'html' ? 'html' : 'md';
+  const render = (title: string, blocks: DocBlock[]) =>
+    format === 'html' ? renderHtml(title, blocks) : renderMarkdown(blocks);
+
+  try {
+    const pages: Page[] = [];
+    for (const file of await collectFiles(options.paths)) {
+      const page = await readPage(file);
+      const existing = pages.find(p => p.name === page.name);
+      if (options.output && existing) {
+        throw new Error(
+          `${existing.file} and ${file} would both be documented as ${page.name}.${extension}`
+        );
+      }
+      if (options.output && page.name === 'index') {
+        throw new Error(`${file} would be documented in place of the index`);
+      }
+      pages.push(page);
+    }
+    if (pages.length === 0) {
+      throw new Error('No .mcps files found');
+    }
+
+    if (!options.output) {
+      const blocks = pages.flatMap(page => docBlocks(page.file, page.docs));
+      process.stdout.write(render('Scripts', blocks));
+      return;
+    }
+
+    await mkdir(options.output, { recursive: true });
+    const written: string[] = [];
+    for (const page of pages) {
+      const path = join(options.output, `${page.name}.${extension}`);
+      await writeFile(
+        path,
+        render(basename(page.file), docBlocks(page.file, page.docs))
+      );
+      written.push(path);
+    }
+    const index = join(options.output, `index.${extension}`);
+    const links = pages.map(page => ({
+      file: page.file,
+      link: `${page.name}.${extension}`,
+      docs: page.docs,
+    }));
+    await writeFile(index, render('Scripts', indexBlocks(links)));
+    written.push(index);
+    for (const path of written) {
+      console.log(`Wrote ${path}`);
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
@@ -0,0 +1,66 @@
+// mcps eval command
+import { stat, writeFile } from 'fs/promises';
+import { config as dotenvConfig } from 'dotenv';
+import type { EvalOptions } from '../types.js';
+import {
+  EVAL_FILE_SUFFIX,
+  formatEvalReport,
+  passRate,
+  runEvalSuite,
+  type EvalSuiteResult,
+} from '../evals.js';
+import { collectFiles } from './fmt.js';
+
+/**
+ * Suite files among the given paths: files named explicitly, and the
+ * *.eval.json files of directories
+ */
+async function collectSuiteFiles(paths: string[]): Promise<string[]> {
+  const files: string[] = [];
+  for (const path of paths) {
+    if ((await stat(path)).isDirectory()) {
+      files.push(...(await collectFiles([path], EVAL_FILE_SUFFIX)));
+    } else {
+      files.push(path);
+    }
+  }
+  return files;
+}
+
+export async function evalCommand(options: EvalOptions): Promise<void> {
+  // Agents use the same models and keys as runs
+  dotenvConfig({ quiet: true });
+
+  let files: string[];
+  try {
+    files = await collectSuiteFiles(options.paths);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+  if (files.length === 0) {
+    console.error(`Error: no *${EVAL_FILE_SUFFIX} files found`);
+    process.exit(1);
+  }
+
+  const results: EvalSuiteResult[] = [];
+  for (const file of files) {
+    results.push(await runEvalSuite(file, { timeout: options.timeout }));
+  }
+
+  const { passed, total } = passRate(results);
+  const json = `${JSON.stringify({ suites: results, passed, total }, null, 2)}\n`;
+  process.stdout.write(
+    options.format === 'json' ? json : formatEvalReport(results)
+  );
+  if (options.output) {
+    await writeFile(options.output, json, 'utf-8');
+  }
+
+  const rate = total === 0 ? 0 : passed / total;
+  if (results.some(result => result.error) || rate < options.minPassRate) {
+    process.exit(1);
+  }
+}
@@ -0,0 +1,140 @@
+// mcps export command
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import {
+  collectCapabilities,
+  createFileLoader,
+  formatLocation,
+  loadProgram,
+  ParseError,
+  type CapabilityManifest,
+  type Statement,
+  type ToolCapability,
+} from '@mcpscript/transpiler';
+import type { ExportCapabilitiesOptions } from '../types.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import { findToolsLock, readToolsLock } from '../tools-lock.js';
+
+async function loadFile(file: string): Promise<Statement[]> {
+  const source = await readFile(file, 'utf-8');
+  const loaded = await loadProjectConfig(dirname(resolve(file)));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  try {
+    return loadProgram(resolve(file), source, loader);
+  } catch (error) {
+    if (error instanceof ParseError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${d.message}`
+      );
+      throw new Error(`Syntax errors in ${file}:\n${lines.join('\n')}`);
+    }
+    throw error;
+  }
+}
+
+/**
+ * Replace the "*" of agents given a whole server with the tools the server
+ * listed when it was locked
+ */
+function expandTools(
+  tools: ToolCapability[],
+  locked: Record<string, { name: string }[]>
+): ToolCapability[] {
+  const expanded = new Map<string, ToolCapability>();
+  const add = (server: string, tool: string, callers: string[]) => {
+    const key = `${server}.${tool}`;
+    const entry = expanded.get(key) ?? { server, tool, callers: [] };
+    for (const caller of callers) {
+      if (!entry.callers.includes(caller)) {
+        entry.callers.push(caller);
+      }
+    }
+    expanded.set(key, entry);
+  };
+  for (const { server, tool, callers } of tools) {
+    const schemas = tool === '*' ? locked[server] : undefined;
+    if (schemas) {
+      schemas.forEach(schema => add(server, schema.name, callers));
+    } else {
+      add(server, tool, callers);
+    }
+  }
+  return [...expanded.values()].sort(
+    (a, b) => a.server.localeCompare(b.server) || a.tool.localeCompare(b.tool)
+  );
+}
+
+function formatSection(title: string, lines: string[]): string {
+  const body = lines.length > 0 ? lines : ['(none)'];
+  return `${title}:\n${body.map(line => `  ${line}`).join('\n')}`;
+}
+
+function formatManifest(manifest: CapabilityManifest): string {
+  return [
+    formatSection(
+      'Servers',
+      manifest.servers.map(server => {
+        const target =
+          server.url ?? [server.command, ...(server.args ?? [])].join(' ');
+        return `${server.name} (${server.transport}): ${target}`;
+      })
+    ),
+    formatSection(
+      'Tools',
+      manifest.tools.map(
+        tool => `${tool.server}.${tool.tool} (${tool.callers.join(', ')})`
+      )
+    ),
+    formatSection(
+      'Models',
+      manifest.models.map(model =>
+        [model.name, model.provider, model.model, model.baseURL]
+          .filter(Boolean)
+          .join(' ')
+      )
+    ),
+    formatSection('Filesystem roots', manifest.filesystemRoots),
+    formatSection('Network endpoints', manifest.networkEndpoints),
+    formatSection(
+      'Secrets',
+      manifest.secrets.map(
+        secret => `${secret.name} (${secret.usedBy.join(', ')})`
+      )
+    ),
+  ].join('\n\n');
+}
+
+/**
+ * Print the capability manifest of a script and the modules it imports
+ */
+export async function exportCapabilitiesCommand(
+  options: ExportCapabilitiesOptions
+): Promise<void> {
+  const { file } = options;
+
+  if (!file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  try {
+    const statements = await loadFile(file);
+    const manifest = collectCapabilities(statements);
+    const lockPath = findToolsLock(dirname(resolve(file)));
+    if (lockPath) {
+      const lock = await readToolsLock(lockPath);
+      manifest.tools = expandTools(manifest.tools, lock.servers);
+    }
+
+    if (options.format === 'text') {
+      console.log(formatManifest(manifest));
+    } else {
+      console.log(JSON.stringify({ file, ...manifest }, null, 2));
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
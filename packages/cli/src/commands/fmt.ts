@@ -0,0 +1,113 @@
+// mcps fmt command
+import { readFile, readdir, stat, writeFile } from 'fs/promises';
+import { dirname, join, resolve } from 'path';
+import {
+  createFileLoader,
+  formatSource,
+  loadModuleGraph,
+  unusedImports,
+} from '@mcpscript/transpiler';
+import type { FmtOptions } from '../types.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import { createUnifiedDiff } from '../ui/diff.js';
+
+/**
+ * Expand directories into the files they contain ending in the suffix
+ */
+export async function collectFiles(
+  paths: string[],
+  suffix = '.mcps'
+): Promise<string[]> {
+  const files: string[] = [];
+  for (const path of paths) {
+    if (!(await stat(path)).isDirectory()) {
+      files.push(path);
+      continue;
+    }
+    const entries = await readdir(path, { withFileTypes: true });
+    const children = entries
+      .filter(entry =>
+        entry.isDirectory()
+          ? !entry.name.startsWith('.') && entry.name !== 'node_modules'
+          : entry.name.endsWith(suffix)
+      )
+      .map(entry => join(path, entry.name))
+      .sort();
+    files.push(...(await collectFiles(children, suffix)));
+  }
+  return files;
+}
+
+/**
+ * Imports of a script that give it nothing it uses, found by loading the
+ * modules it imports
+ */
+async function findUnusedImports(
+  file: string,
+  source: string
+): Promise<string[]> {
+  const path = resolve(file);
+  const loaded = await loadProjectConfig(dirname(path));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  return unusedImports(loadModuleGraph(path, loader, source));
+}
+
+export async function fmtCommand(options: FmtOptions): Promise<void> {
+  const { write = false, diff = false, organizeImports = false } = options;
+  let failed = false;
+  let changed = false;
+
+  let files: string[];
+  try {
+    files = await collectFiles(options.files);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+
+  for (const file of files) {
+    if (!file.endsWith('.mcps')) {
+      console.error(`Error: ${file}: File must have .mcps extension`);
+      failed = true;
+      continue;
+    }
+
+    let source: string;
+    let formatted: string;
+    try {
+      source = await readFile(file, 'utf-8');
+      formatted = formatSource(source, {
+        organizeImports,
+        ...(organizeImports && {
+          unusedImports: await findUnusedImports(file, source),
+        }),
+      });
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      console.error(`Error: ${file}: ${message}`);
+      failed = true;
+      continue;
+    }
+
+    if (formatted !== source) {
+      changed = true;
+    }
+    if (diff) {
+      process.stdout.write(createUnifiedDiff(file, source, formatted));
+    }
+    if (write) {
+      if (formatted !== source) {
+        await writeFile(file, formatted, 'utf-8');
+      }
+    } else if (!diff) {
+      process.stdout.write(formatted);
+    }
+  }
+
+  // In diff mode unformatted files fail the run, so it can gate CI
+  if (failed || (diff && changed)) {
+    process.exit(1);
+  }
+}
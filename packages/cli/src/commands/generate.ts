@@ -0,0 +1,74 @@
+// mcps generate command
+import { writeFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import { config as dotenvConfig } from 'dotenv';
+import type { ToolSchema } from '@mcpscript/runtime';
+import type { GenerateGoOptions } from '../types.js';
+import { loadProjectConfig, type LoadedConfig } from '../config.js';
+import { generateGoBindings } from '../generate/go.js';
+import {
+  findToolsLock,
+  lockedPackages,
+  readToolsLock,
+} from '../tools-lock.js';
+import { declaredServers, listServerTools } from './lock.js';
+
+/**
+ * Tools of the servers a script declares, from the lockfile
+ */
+async function lockedServerTools(
+  file: string,
+  loaded: LoadedConfig
+): Promise<Record<string, ToolSchema[]>> {
+  const path = findToolsLock(dirname(resolve(file)));
+  if (!path) {
+    throw new Error(`No tools lockfile found for ${file}; run "mcps lock"`);
+  }
+  const lock = await readToolsLock(path);
+  const names = (await declaredServers(file, loaded)).map(s => s.name);
+  const missing = names.filter(name => !lock.servers[name]);
+  if (missing.length > 0) {
+    throw new Error(
+      `${path} has no tools for ${missing.join(', ')}; run "mcps lock ${file}"`
+    );
+  }
+  return Object.fromEntries(names.map(name => [name, lock.servers[name]]));
+}
+
+/**
+ * Generate Go structs and client wrappers for the tools of the MCP servers
+ * a script declares
+ */
+export async function generateGoCommand(
+  options: GenerateGoOptions
+): Promise<void> {
+  const { file, offline = false } = options;
+
+  // Servers are often configured through environment variables
+  dotenvConfig({ quiet: true });
+
+  if (!file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  try {
+    const loaded = await loadProjectConfig(dirname(resolve(file)));
+    const servers = offline
+      ? await lockedServerTools(file, loaded)
+      : await listServerTools(file, loaded, await lockedPackages(file));
+    const source = generateGoBindings(servers, {
+      packageName: options.packageName,
+    });
+
+    if (options.output) {
+      await writeFile(options.output, source, 'utf-8');
+    } else {
+      process.stdout.write(source);
+    }
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+}
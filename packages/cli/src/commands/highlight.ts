@@ -0,0 +1,62 @@
+// mcps highlight command
+import { readFile } from 'fs/promises';
+import { basename } from 'path';
+import {
+  HIGHLIGHT_THEMES,
+  escapeHtml,
+  highlightAnsi,
+  highlightCss,
+  highlightHtml,
+  parseTree,
+} from '@mcpscript/transpiler';
+import type { HighlightOptions } from '../types.js';
+
+/**
+ * A page showing the highlighted script, with the theme's stylesheet
+ */
+function standalonePage(file: string, html: string, css: string): string {
+  return [
+    '<!DOCTYPE html>',
+    '<html>',
+    '<head>',
+    '<meta charset="utf-8">',
+    `<title>${escapeHtml(basename(file))}</title>`,
+    `<style>\n${css}</style>`,
+    '</head>',
+    '<body>',
+    `<pre class="mcps-highlight"><code>${html}</code></pre>`,
+    '</body>',
+    '</html>',
+  ].join('\n');
+}
+
+export async function highlightCommand(
+  options: HighlightOptions
+): Promise<void> {
+  const { file, format = 'ansi', theme = 'dark', standalone } = options;
+
+  try {
+    if (!Object.hasOwn(HIGHLIGHT_THEMES, theme)) {
+      const themes = Object.keys(HIGHLIGHT_THEMES).join(', ');
+      throw new Error(`Unknown theme "${theme}"; choose one of ${themes}`);
+    }
+    const source = await readFile(file, 'utf-8');
+    // Scripts with syntax errors are highlighted as far as they parse
+    const tree = parseTree(source);
+
+    const selected = HIGHLIGHT_THEMES[theme];
+    if (format === 'ansi') {
+      process.stdout.write(highlightAnsi(tree, source, selected));
+    } else if (standalone) {
+      const html = highlightHtml(tree, source);
+      console.log(standalonePage(file, html, highlightCss(selected)));
+    } else {
+      process.stdout.write(highlightHtml(tree, source));
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
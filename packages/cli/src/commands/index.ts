@@ -1,3 +1,32 @@
 // Export all commands
 export { runCommand } from './run.js';
 export { compileCommand } from './compile.js';
+export { parseCommand } from './parse.js';
+export { highlightCommand } from './highlight.js';
+export { lspCommand } from './lsp.js';
+export { debugCommand } from './debug.js';
+export { fmtCommand } from './fmt.js';
+export { checkCommand } from './check.js';
+export { testCommand } from './test.js';
+export { evalCommand } from './eval.js';
+export { diffCommand } from './diff.js';
+export { daemonCommand } from './daemon.js';
+export { apiCommand } from './api.js';
+export { serveCommand } from './serve.js';
+export { logsCommand } from './logs.js';
+export { lockCommand } from './lock.js';
+export { toolsSyncCommand } from './tools.js';
+export { signCommand, verifyCommand } from './sign.js';
+export { generateGoCommand } from './generate.js';
+export { exportCapabilitiesCommand } from './export.js';
+export { extractPromptsCommand, refactorCommand } from './refactor.js';
+export { auditDepsCommand } from './audit.js';
+export { benchCommand } from './bench.js';
+export { docCommand } from './doc.js';
+export { runsDiffCommand } from './runs.js';
+export {
+  transcriptListCommand,
+  transcriptShowCommand,
+  transcriptReplayCommand,
+  transcriptMocksCommand,
+} from './transcript.js';
@@ -0,0 +1,136 @@
+// mcps lock command
+import { readFile } from 'fs/promises';
+import { dirname, join, resolve } from 'path';
+import { config as dotenvConfig } from 'dotenv';
+import {
+  createFileLoader,
+  generateCode,
+  loadProgram,
+  type MCPDeclaration,
+} from '@mcpscript/transpiler';
+import {
+  executeInVM,
+  MCPServerManager,
+  type PinnedPackage,
+  type ToolSchema,
+} from '@mcpscript/runtime';
+import { offlineMode } from '../offline.js';
+import { pinPackages } from '../package-pins.js';
+import type { LockOptions } from '../types.js';
+import {
+  loadProjectConfig,
+  moduleSearchPaths,
+  secretProviders,
+  type LoadedConfig,
+} from '../config.js';
+import {
+  findToolsLock,
+  readToolsLock,
+  TOOLS_LOCK_FILE,
+  writeToolsLock,
+  type ToolsLock,
+} from '../tools-lock.js';
+
+/**
+ * The MCP servers a script declares, including those of its imports
+ */
+export async function declaredServers(
+  file: string,
+  loaded: LoadedConfig
+): Promise<MCPDeclaration[]> {
+  const source = await readFile(file, 'utf-8');
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  const servers = loadProgram(resolve(file), source, loader).filter(
+    (statement): statement is MCPDeclaration =>
+      statement.type === 'mcp_declaration'
+  );
+  if (servers.length === 0) {
+    throw new Error(`${file} does not declare any MCP servers`);
+  }
+  return servers;
+}
+
+/**
+ * Start the MCP servers a script declares, at the given package versions,
+ * and list their tools
+ * Only the server declarations run; the rest of the script does not
+ */
+export async function listServerTools(
+  file: string,
+  loaded: LoadedConfig,
+  packages?: Record<string, PinnedPackage>
+): Promise<Record<string, ToolSchema[]>> {
+  const servers = await declaredServers(file, loaded);
+  const serverManager = new MCPServerManager();
+  try {
+    await executeInVM(generateCode(servers), {
+      serverManager,
+      redaction: loaded.config.redaction,
+      secretProviders: secretProviders(loaded),
+      offline: offlineMode(),
+      packages,
+    });
+  } finally {
+    await serverManager.closeAll();
+  }
+  return serverManager.toolSchemas();
+}
+
+/**
+ * Pin the packages of the MCP servers a script declares, start the servers
+ * at those versions, and write the pins and the tools the servers list to
+ * the lockfile so runs keep to them and `mcps check` can validate tool
+ * calls offline
+ */
+export async function lockCommand(options: LockOptions): Promise<void> {
+  const { file } = options;
+
+  // Servers are often configured through environment variables
+  dotenvConfig({ quiet: true });
+
+  if (!file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  try {
+    const loaded = await loadProjectConfig(dirname(resolve(file)));
+
+    // Servers of other scripts already in the lockfile are kept
+    const existing = findToolsLock(dirname(resolve(file)));
+    const path =
+      existing ?? join(dirname(loaded.path ?? resolve(file)), TOOLS_LOCK_FILE);
+    const lock: ToolsLock = existing
+      ? await readToolsLock(existing)
+      : { version: 1, servers: {} };
+
+    // Packages are pinned first, so the tools are those of the pinned
+    // versions; the script's servers replace their previous pins
+    const servers = await declaredServers(file, loaded);
+    const packages = await pinPackages(servers, lock.packages, {
+      update: options.update,
+    });
+    const schemas = await listServerTools(file, loaded, packages);
+    Object.assign(lock.servers, schemas);
+    const pins = Object.fromEntries(
+      Object.entries(lock.packages ?? {}).filter(
+        ([name]) => !servers.some(server => server.name === name)
+      )
+    );
+    Object.assign(pins, packages);
+    lock.packages = Object.keys(pins).length > 0 ? pins : undefined;
+    await writeToolsLock(path, lock);
+
+    const count = Object.values(schemas).reduce(
+      (total, tools) => total + tools.length,
+      0
+    );
+    console.error(
+      `Locked ${count} tools of ${Object.keys(schemas).length} servers and ${Object.keys(packages).length} packages in ${path}`
+    );
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+}
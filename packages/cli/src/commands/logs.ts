@@ -0,0 +1,80 @@
+// mcps logs command
+import { checkOffline, type LogEntry } from '@mcpscript/runtime';
+import { offlineMode } from '../offline.js';
+import { readRunLog } from '../remote/client.js';
+import { encodeEvent, type RunEvent } from '../remote/protocol.js';
+import type { LogsOptions } from '../types.js';
+
+/**
+ * A log entry as one line: its time (UTC), severity and message
+ */
+export function formatLogEntry(entry: LogEntry): string {
+  const time = entry.time.slice(11, 23);
+  const severity = entry.severity.toUpperCase().padEnd(5);
+  let message = entry.message;
+  if (entry.kind === 'statement') {
+    const where = entry.tool ? ` in ${entry.tool}` : '';
+    message = `line ${entry.line}${where}: ${message}`;
+  } else if (entry.durationMs !== undefined) {
+    message = `${message} (${Math.round(entry.durationMs)} ms)`;
+  }
+  return `${time} ${severity} ${message}`;
+}
+
+/**
+ * Write an event of a run's log to the terminal: output as the run printed
+ * it, and the rest as lines on stderr
+ */
+function printEvent(event: RunEvent): void {
+  switch (event.type) {
+    case 'output':
+      process[event.stream].write(event.text);
+      break;
+    case 'message':
+      process.stdout.write(
+        event.title ? `${event.title}\n${event.body}\n` : `${event.body}\n`
+      );
+      break;
+    case 'log':
+      process.stderr.write(`${formatLogEntry(event.entry)}\n`);
+      break;
+    case 'error':
+      process.stderr.write(`${event.message}\n`);
+      break;
+    case 'exit':
+      process.stderr.write(`Run exited with code ${event.code}\n`);
+      break;
+  }
+}
+
+export async function logsCommand(options: LogsOptions): Promise<void> {
+  // Runs of `mcps serve --http` are read with the service's token
+  const token = process.env.MCPS_REMOTE_TOKEN ?? process.env.MCPS_SERVE_TOKEN;
+
+  try {
+    // Offline, only executors on this machine can be asked
+    if (offlineMode()) {
+      checkOffline('reading run logs', options.url);
+    }
+    const code = await readRunLog(
+      {
+        url: options.url,
+        token,
+        id: options.id,
+        follow: options.follow,
+        servers: options.servers,
+        level: options.level,
+      },
+      options.json
+        ? event => process.stdout.write(encodeEvent(event))
+        : printEvent
+    );
+    // Following a run ends with its exit code, like running it
+    process.exit(options.follow ? (code ?? 0) : 0);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
@@ -0,0 +1,13 @@
+// mcps lsp command
+import { Connection } from '../lsp/transport.js';
+import { LanguageServer } from '../lsp/server.js';
+
+export async function lspCommand(): Promise<void> {
+  // stdout carries the protocol, so anything else must go to stderr
+  const connection = new Connection(process.stdin, process.stdout);
+  const server = new LanguageServer(connection, code => process.exit(code));
+  server.start();
+
+  // The client closing stdin without an exit notification is a crash
+  process.stdin.on('end', () => process.exit(1));
+}
@@ -0,0 +1,49 @@
+// mcps parse command
+import { readFile } from 'fs/promises';
+import {
+  formatLocation,
+  parseSource,
+  parseTree,
+  programToJSON,
+  syntaxTreeToJSON,
+  syntaxTreeToSExpression,
+  ParseError,
+} from '@mcpscript/transpiler';
+import type { ParseOptions } from '../types.js';
+
+export async function parseCommand(options: ParseOptions): Promise<void> {
+  const { file, format = 'sexp' } = options;
+
+  try {
+    const source = await readFile(file, 'utf-8');
+
+    if (format === 'ast') {
+      console.log(JSON.stringify(programToJSON(parseSource(source)), null, 2));
+      return;
+    }
+
+    // The syntax tree is printed even with errors, which show as ERROR
+    // and MISSING nodes, but the command then fails
+    const tree = parseTree(source);
+    console.log(
+      format === 'json'
+        ? JSON.stringify(syntaxTreeToJSON(tree, source), null, 2)
+        : syntaxTreeToSExpression(tree)
+    );
+    if (tree.rootNode.hasError) {
+      process.exit(1);
+    }
+  } catch (error) {
+    if (error instanceof ParseError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${d.message}`
+      );
+      console.error(`Syntax errors in ${file}:\n${lines.join('\n')}`);
+    } else {
+      console.error(
+        `Error: ${error instanceof Error ? error.message : String(error)}`
+      );
+    }
+    process.exit(1);
+  }
+}
@@ -0,0 +1,210 @@
+// mcps refactor command
+import { existsSync } from 'fs';
+import { readFile, writeFile } from 'fs/promises';
+import { dirname, relative, resolve } from 'path';
+import {
+  applyRefactorEdits,
+  createFileLoader,
+  extractPrompts,
+  extractWorkflow,
+  importedDeclarations,
+  inlineVariable,
+  loadModuleGraph,
+  moveDeclaration,
+  offsetAt,
+  parseTree,
+  RefactorError,
+  unusedName,
+  type RefactorSource,
+  type Refactoring,
+} from '@mcpscript/transpiler';
+import type { ExtractPromptsOptions, RefactorOptions } from '../types.js';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import { createUnifiedDiff } from '../ui/diff.js';
+import { collectFiles } from './fmt.js';
+
+/**
+ * A script with the declarations of the modules it imports
+ */
+async function refactorSource(file: string): Promise<RefactorSource> {
+  const path = resolve(file);
+  const content = await readFile(path, 'utf-8');
+  const tree = parseTree(content);
+  if (tree.rootNode.hasError) {
+    throw new Error(`${file} has syntax errors; run "mcps check ${file}"`);
+  }
+  const loaded = await loadProjectConfig(dirname(path));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  const graph = loadModuleGraph(path, loader, content);
+  return { path, content, tree, imports: importedDeclarations(graph) };
+}
+
+async function refactor(
+  source: RefactorSource,
+  options: RefactorOptions
+): Promise<Refactoring> {
+  const { operation } = options;
+  switch (operation.kind) {
+    case 'inline-variable':
+      return inlineVariable(
+        source,
+        offsetAt(source.content, {
+          row: operation.line - 1,
+          column: operation.column - 1,
+        })
+      );
+    case 'extract-workflow':
+      return extractWorkflow(
+        source,
+        {
+          start: offsetAt(source.content, {
+            row: operation.from - 1,
+            column: 0,
+          }),
+          end: offsetAt(source.content, {
+            row: operation.to - 1,
+            column: Infinity,
+          }),
+        },
+        operation.name ?? unusedName(source, 'extracted')
+      );
+    case 'move-declaration': {
+      const to = resolve(operation.to);
+      return moveDeclaration(source, operation.name, {
+        path: to,
+        ...(existsSync(to) && { content: await readFile(to, 'utf-8') }),
+      });
+    }
+  }
+}
+
+interface FileChange {
+  path: string;
+  before: string;
+  after: string;
+}
+
+/**
+ * Write changed files, or print a diff of them
+ */
+async function saveChanges(
+  changes: FileChange[],
+  write: boolean
+): Promise<void> {
+  for (const { path, before, after } of changes) {
+    if (write) {
+      await writeFile(path, after, 'utf-8');
+    } else {
+      process.stdout.write(
+        createUnifiedDiff(relative(process.cwd(), path), before, after)
+      );
+    }
+  }
+}
+
+/**
+ * Refactor a script, printing a diff of the files it changes, or with
+ * write, changing them
+ */
+export async function refactorCommand(
+  options: RefactorOptions
+): Promise<void> {
+  const { file, write = false } = options;
+
+  if (!file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  let source: RefactorSource;
+  let refactoring: Refactoring;
+  try {
+    source = await refactorSource(file);
+    refactoring = await refactor(source, options);
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+
+  const changes: FileChange[] = [];
+  for (const [path, edits] of Object.entries(refactoring.edits)) {
+    const before =
+      path === source.path
+        ? source.content
+        : existsSync(path)
+          ? await readFile(path, 'utf-8')
+          : '';
+    changes.push({ path, before, after: applyRefactorEdits(before, edits) });
+  }
+  await saveChanges(changes, write);
+  if (write) {
+    console.error(refactoring.title);
+  }
+}
+
+/**
+ * Move the system prompts agents write as strings into a prompts module,
+ * for every script of the paths, printing a diff of the files it changes,
+ * or with write, changing them
+ */
+export async function extractPromptsCommand(
+  options: ExtractPromptsOptions
+): Promise<void> {
+  const { paths, write = false } = options;
+  const to = resolve(options.to);
+  if (!to.endsWith('.mcps')) {
+    console.error('Error: The prompts module must have .mcps extension');
+    process.exit(1);
+  }
+
+  const original = existsSync(to) ? await readFile(to, 'utf-8') : '';
+  let prompts = original;
+  const changes: FileChange[] = [];
+  try {
+    for (const file of await collectFiles(paths)) {
+      if (resolve(file) === to) {
+        continue;
+      }
+      const source = await refactorSource(file);
+      let refactoring: Refactoring;
+      try {
+        refactoring = extractPrompts(source, { path: to, content: prompts });
+      } catch (error) {
+        // Scripts without inline prompts are left as they are
+        if (error instanceof RefactorError) {
+          continue;
+        }
+        throw error;
+      }
+      changes.push({
+        path: source.path,
+        before: source.content,
+        after: applyRefactorEdits(
+          source.content,
+          refactoring.edits[source.path]
+        ),
+      });
+      prompts = applyRefactorEdits(prompts, refactoring.edits[to]);
+    }
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+
+  if (changes.length === 0) {
+    console.error('No agent has a system prompt written as a string');
+    return;
+  }
+  await saveChanges(
+    [...changes, { path: to, before: original, after: prompts }],
+    write
+  );
+  if (write) {
+    const scripts = changes.length === 1 ? 'script' : 'scripts';
+    console.error(
+      `Extracted the prompts of ${changes.length} ${scripts} to ${relative(process.cwd(), to)}`
+    );
+  }
+}
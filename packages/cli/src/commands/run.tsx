@@ -1,12 +1,80 @@
 // mcps run command
 import React from 'react';
-import { readFile } from 'fs/promises';
+import { readFile, writeFile } from 'fs/promises';
+import { basename, dirname, extname, resolve } from 'path';
 import { render } from 'ink';
 import { config as dotenvConfig } from 'dotenv';
-import { parseSource, generateCode } from '@mcpscript/transpiler';
-import { AppMessage, AppState, executeInVM } from '@mcpscript/runtime';
+import {
+  loadProgram,
+  createFileLoader,
+  generateCode,
+  generateCodeUnsafe,
+  checkTypes,
+  formatLocation,
+  MessageTranslator,
+  TypeCheckError,
+  validateStatements,
+  type Statement,
+} from '@mcpscript/transpiler';
+import {
+  AppMessage,
+  AppState,
+  aggregateMatrix,
+  createTracer,
+  executeInVM,
+  FaultInjector,
+  MCPServerManager,
+  RunOutcome,
+  runMatrix,
+  TimelineExporter,
+  type ApprovedCall,
+  type CheckpointStore,
+  type EscalationHandler,
+  type ProgressHandler,
+} from '@mcpscript/runtime';
 import type { RunOptions } from '../types.js';
+import {
+  loadProjectConfig,
+  moduleSearchPaths,
+  secretProviders,
+  spillOptions,
+} from '../config.js';
+import { runRemote } from '../remote/client.js';
+import { enforceSigningPolicy } from '../signing.js';
+import { effectiveTimeout } from '../remote/policy.js';
+import {
+  alwaysApprovedCall,
+  findRole,
+  saveApprovedCall,
+} from '../roles.js';
+import { formatPlan, planRun } from '../plan.js';
+import { projectStorage, type Storage } from '../storage.js';
+import {
+  createTranscript,
+  replayProgram,
+  type TranscriptFile,
+} from '../transcripts.js';
+import { checkpointStore } from '../checkpoints.js';
+import { embeddingModel, vectorStore } from '../vectors.js';
+import { idempotencyStore } from '../idempotency.js';
+import { approvalWebhook, headlessQuestions } from '../approvals.js';
+import { outcomeFailure } from '../exit-codes.js';
+import { messageTranslator } from '../messages.js';
+import {
+  findMain,
+  flagArguments,
+  formatScriptHelp,
+  scriptFlags,
+} from '../arguments.js';
+import { AnalysisCache } from '../cache.js';
+import { prepareRun } from '../prepared-run.js';
+import { checkScriptPolicy, loadSandboxPolicy } from '../sandbox.js';
+import { loadFaultPlan } from '../faults.js';
+import { checkScriptOffline, offlineMode } from '../offline.js';
+import { lockedPackages } from '../tools-lock.js';
+import { checkMatrix, formatMatrixResults, readMatrix } from '../matrix.js';
 import { App } from '../ui/App.js';
+import { formatScriptError, supportsColor } from '../ui/script-error.js';
 
 export async function runCommand(options: RunOptions): Promise<void> {
   const { file } = options;
@@ -19,6 +87,26 @@ export async function runCommand(options: RunOptions): Promise<void> {
     process.exit(1);
   }
 
+  if (options.remote) {
+    await runOnExecutor(options, options.remote);
+    return;
+  }
+
+  if (options.dryRun) {
+    await printPlan(file, options.dryRun);
+    return;
+  }
+
+  if (options.matrix) {
+    await runMatrixCommand(options, options.matrix);
+    return;
+  }
+
+  if (options.args?.includes('--help')) {
+    await printScriptHelp(file);
+    return;
+  }
+
   // Initialize application state
   let appState: AppState = {
     messages: [],
@@ -33,7 +121,28 @@ export async function runCommand(options: RunOptions): Promise<void> {
   // State updater function that can be called from VM
   const addMessage = (msg: AppMessage) => {
     appState.messages.push(msg);
-    appState = { ...appState };
+    // A finished message replaces the one that was streaming
+    appState = { ...appState, streaming: undefined };
+    rerender(<App state={appState} />);
+  };
+
+  // Agent replies are shown as they stream in
+  const streamMessage = (title: string, delta: string) => {
+    const previous =
+      appState.streaming?.title === title ? appState.streaming.body : '';
+    appState = { ...appState, streaming: { title, body: previous + delta } };
+    rerender(<App state={appState} />);
+  };
+
+  // Parallel maps show how far they have got, and a summary once done
+  const progress: ProgressHandler = (title, { done, failed, total }) => {
+    const body = `${done} of ${total} done${failed > 0 ? `, ${failed} failed` : ''}`;
+    if (done < total) {
+      appState = { ...appState, progress: { title, body } };
+    } else {
+      appState.messages.push({ title, body });
+      appState = { ...appState, progress: undefined };
+    }
     rerender(<App state={appState} />);
   };
 
@@ -52,28 +161,446 @@ export async function runCommand(options: RunOptions): Promise<void> {
     });
   };
 
+  // Stop any MCP servers the script started if the user interrupts the
+  // run, and finish storing its transcript
+  const serverManager = new MCPServerManager();
+  const shutdown = (signal: NodeJS.Signals) => {
+    Promise.allSettled([
+      serverManager.closeAll(),
+      transcript?.flushed(),
+    ]).finally(() => {
+      process.exit(signal === 'SIGINT' ? 130 : 143);
+    });
+  };
+  process.once('SIGINT', shutdown);
+  process.once('SIGTERM', shutdown);
+
+  // Agent runs and tool calls are recorded for mcps transcript, in the
+  // project's storage once its config is read
+  const { replay } = options;
+  let storage: Storage | undefined;
+  let transcript: TranscriptFile | undefined;
+  const showTranscript = async () => {
+    if (!transcript?.written) {
+      return;
+    }
+    try {
+      await transcript.flushed();
+      addMessage({
+        title: 'Transcript',
+        body: `mcps transcript show ${transcript.id}`,
+      });
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      addMessage({ title: 'Transcript', body: `Not stored: ${message}` });
+    }
+  };
+
+  // Faults injected by a fault plan are reported once the run ends
+  let faults: FaultInjector | undefined;
+  const showFaults = () => {
+    const summary = faults?.summary();
+    if (summary) {
+      addMessage({ title: 'Faults', body: `Injected ${summary}` });
+    }
+  };
+
+  // The script's statements are checkpointed under the run's id, except
+  // in replays, so a run that fails can be resumed where it stopped
+  let runId = options.resume;
+  let checkpoints: CheckpointStore | undefined;
+  const showResume = async () => {
+    if (
+      checkpoints &&
+      runId &&
+      (await checkpoints.load(runId).catch(() => null))
+    ) {
+      addMessage({
+        title: 'Checkpoint',
+        body: `mcps run --resume ${runId} ${file}`,
+      });
+    }
+  };
+
+  // Errors are reported in the project's language once its config is read
+  let translator = new MessageTranslator();
   try {
-    // Read the source file
+    // Read the source file and the project config next to it
     const source = await readFile(file, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(file));
+    const { config } = loaded;
+    translator = messageTranslator(loaded);
+    const role = options.role ? findRole(loaded, options.role) : undefined;
+    storage = projectStorage(loaded);
+    transcript = createTranscript(resolve(file), replay?.from, storage);
+    runId ??= transcript.id;
+    checkpoints = replay ? undefined : await checkpointStore(loaded, storage);
+    const policy = options.policy
+      ? await loadSandboxPolicy(options.policy)
+      : undefined;
+    faults = options.faults
+      ? new FaultInjector(await loadFaultPlan(options.faults))
+      : undefined;
+
+    // Parse, check and compile the script, or reuse the run prepared for
+    // it before; replays compile only the agent they continue
+    const offline = offlineMode();
+    const prepared = await prepareRun(resolve(file), source, loaded, {
+      codegen: { sourcePositions: true, checkpoints: true },
+      policy,
+      offline,
+      cache: options.cache === false ? undefined : new AnalysisCache(),
+      compile: replay && (program => replayCode(program, replay.agent, file)),
+    });
+    const { jsCode } = prepared;
+    const args = flagArguments(file, prepared.flags, options.args ?? []);
 
-    // Parse the source
-    const ast = parseSource(source);
+    // Calls the role needs approval for are confirmed at the prompt;
+    // "always", optionally with name=pattern arguments, approves such calls
+    // from now on and saves them to the role in .mcpsrc
+    const approve = async (tool: string, input: unknown) => {
+      const answer = await handleUserInput(
+        `Role ${options.role} needs approval to call ${tool} with ${JSON.stringify(input)}. Allow it? (y/N, or always [name=pattern ...])`
+      );
+      const [word, ...patterns] = answer.trim().split(/\s+/);
+      if (!/^a(lways)?$/i.test(word)) {
+        return /^y(es)?$/i.test(answer.trim());
+      }
+      let rule: ApprovedCall;
+      try {
+        rule = alwaysApprovedCall(tool, input, patterns);
+      } catch (error) {
+        addMessage({ title: 'Not approved', body: (error as Error).message });
+        return false;
+      }
+      (role!.profile.approvedCalls ??= []).push(rule);
+      try {
+        await saveApprovedCall(loaded, options.role!, rule);
+        addMessage({
+          title: 'Approved',
+          body: `Added to ${loaded.path}: ${JSON.stringify(rule)}`,
+        });
+      } catch (error) {
+        const message = error instanceof Error ? error.message : String(error);
+        addMessage({ title: 'Approved', body: `Not saved: ${message}` });
+      }
+      return true;
+    };
 
-    // Generate JavaScript code
-    const jsCode = generateCode(ast);
+    // Output an agent's "escalate" guardrail rejected is decided on as well
+    const escalate: EscalationHandler = async (violation, output) => {
+      const answer = await handleUserInput(
+        `${violation.guardrail} of agent ${output.agent} rejected ${output.tool ? `a call of ${output.tool} with ${output.text}` : `the reply: ${output.text}`} (${violation.problem}). Use it anyway? (y/N)`
+      );
+      return /^y(es)?$/i.test(answer.trim());
+    };
+
+    // The timeline is rewritten as spans are exported, so a run that fails
+    // still leaves one
+    const timelineFile = options.timeline;
+    const timeline =
+      timelineFile &&
+      new TimelineExporter(
+        extname(timelineFile) === '.html' ? 'html' : 'chrome',
+        content => writeFile(timelineFile, content, 'utf-8'),
+        basename(file)
+      );
 
     // Execute the generated JavaScript in VM
+    const outcome = new RunOutcome();
     await executeInVM(jsCode, {
-      timeout: options.timeout,
+      timeout: role ? effectiveTimeout(role, options.timeout) : options.timeout,
       addMessage: addMessage,
+      streamMessage,
+      progress,
       userInput: handleUserInput,
+      // Without a terminal to ask at, questions go to the approval webhook
+      ask:
+        process.stdin.isTTY || !config.approvals
+          ? undefined
+          : approvalWebhook(config.approvals, resolve(file)),
+      serverManager,
+      sourceFile: file,
+      source,
+      redaction: config.redaction,
+      secretProviders: secretProviders(loaded),
+      project: config.project,
+      tracer:
+        (options.trace || options.timeline) &&
+        createTracer({
+          exporter: options.trace,
+          exporters: timeline ? [timeline] : [],
+          offline,
+        }),
+      profile: role?.profile,
+      policy,
+      faults,
+      traceLevel: options.traceLevel,
+      embeddings: embeddingModel(loaded),
+      vectors: vectorStore(loaded),
+      idempotency: idempotencyStore(file),
+      args,
+      outcome,
+      locale: config.locale,
+      spill: spillOptions(loaded),
+      offline,
+      packages: prepared.packages,
+      approve,
+      escalate,
+      transcript: transcript.recorder,
+      replay: replay?.messages,
+      checkpoints: checkpoints && {
+        store: checkpoints,
+        run: runId,
+        resume: options.resume !== undefined,
+      },
     });
+    showFaults();
+    await showTranscript();
+
+    // Tool error results and failed map items fail the run if the project
+    // gives them an exit code
+    const failure = outcomeFailure(outcome, config.exitCodes);
+    if (failure) {
+      addMessage({ title: 'Failed', body: failure.message });
+      await waitUntilExit();
+      process.exit(failure.code);
+    }
 
     // Wait for user to exit
     await waitUntilExit();
-  } catch (_) {
+  } catch (error) {
+    if (error instanceof TypeCheckError) {
+      const lines = error.diagnostics.map(
+        d => `${formatLocation(d.location)}: ${translator.translate(d.message)}`
+      );
+      addMessage({ title: 'Type error', body: lines.join('\n') });
+    } else if (error instanceof Error) {
+      addMessage({
+        title: 'Error',
+        body: formatScriptError(error, supportsColor(), message =>
+          translator.translate(message)
+        ),
+      });
+    }
+    showFaults();
+    await showTranscript();
+    await showResume();
     // Wait a bit for user to see the error
     await waitUntilExit();
     process.exit(1);
+  } finally {
+    process.off('SIGINT', shutdown);
+    process.off('SIGTERM', shutdown);
+    await storage?.close().catch(() => {});
+  }
+}
+
+/**
+ * Code that runs only the declarations of a script and then the replayed
+ * agent. The script is validated as a whole, since the __replayAgent call
+ * the replay adds is not a variable the script declares
+ */
+function replayCode(ast: Statement[], agent: string, file: string): string {
+  validateStatements(ast);
+  return generateCodeUnsafe(replayProgram(ast, agent, file), { main: false });
+}
+
+/**
+ * Print what running the script would start and call, without running it
+ */
+async function printPlan(file: string, format: 'text' | 'json') {
+  try {
+    const source = await readFile(file, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(file));
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    const ast = loadProgram(resolve(file), source, loader);
+    checkTypes(ast);
+
+    const plan = planRun(ast);
+    process.stdout.write(
+      format === 'json'
+        ? `${JSON.stringify(plan, null, 2)}\n`
+        : formatPlan(plan)
+    );
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
+
+/**
+ * Print the flags of the script's main tool
+ */
+async function printScriptHelp(file: string) {
+  try {
+    const source = await readFile(file, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(file));
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    const main = findMain(loadProgram(resolve(file), source, loader), source);
+    if (!main) {
+      throw new Error(`${file} declares no main tool, so it takes no flags`);
+    }
+    process.stdout.write(
+      `${formatScriptHelp(file, scriptFlags(main), main.doc)}\n`
+    );
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
+
+/**
+ * Run the script's main tool once per combination of the arguments in a
+ * matrix file, then print a table of the runs. Runs are not interactive:
+ * input() fails and questions go to the approval webhook, if any
+ */
+async function runMatrixCommand(
+  options: RunOptions,
+  matrixFile: string
+): Promise<void> {
+  const { file } = options;
+  const managers = new Set<MCPServerManager>();
+  const shutdown = (signal: NodeJS.Signals) => {
+    Promise.all([...managers].map(manager => manager.closeAll())).finally(
+      () => {
+        process.exit(signal === 'SIGINT' ? 130 : 143);
+      }
+    );
+  };
+  process.once('SIGINT', shutdown);
+  process.once('SIGTERM', shutdown);
+
+  try {
+    const matrix = await readMatrix(matrixFile);
+    const source = await readFile(file, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(file));
+    const { config } = loaded;
+    const role = options.role ? findRole(loaded, options.role) : undefined;
+    const policy = options.policy
+      ? await loadSandboxPolicy(options.policy)
+      : undefined;
+    const loader = enforceSigningPolicy(
+      loaded,
+      resolve(file),
+      source,
+      createFileLoader({ paths: moduleSearchPaths(loaded) })
+    );
+    const ast = loadProgram(resolve(file), source, loader);
+    checkMatrix(matrix, findMain(ast, source), file);
+
+    checkTypes(ast);
+    if (policy) {
+      checkScriptPolicy(ast, policy);
+    }
+    const offline = offlineMode();
+    if (offline) {
+      checkScriptOffline(ast);
+    }
+    const jsCode = generateCode(ast, { sourcePositions: true });
+    const packages = await lockedPackages(file);
+
+    const runs = await runMatrix(
+      matrix,
+      async (args, index) => {
+        const serverManager = new MCPServerManager();
+        managers.add(serverManager);
+        const outcome = new RunOutcome();
+        try {
+          await executeInVM(jsCode, {
+            timeout: role
+              ? effectiveTimeout(role, options.timeout)
+              : options.timeout,
+            addMessage: ({ title, body }: AppMessage) => {
+              const text = title ? `${title}: ${body}` : body;
+              process.stderr.write(`[#${index + 1}] ${text}\n`);
+            },
+            userInput: () =>
+              Promise.reject(
+                new Error('input() is not available in matrix runs')
+              ),
+            ask: headlessQuestions(
+              config.approvals,
+              resolve(file),
+              'matrix runs'
+            ),
+            serverManager,
+            sourceFile: file,
+            source,
+            redaction: config.redaction,
+            secretProviders: secretProviders(loaded),
+            project: config.project,
+            tracer:
+              options.trace &&
+              createTracer({ exporter: options.trace, offline }),
+            profile: role?.profile,
+            policy,
+            traceLevel: options.traceLevel,
+            embeddings: embeddingModel(loaded),
+            vectors: vectorStore(loaded),
+            args,
+            outcome,
+            locale: config.locale,
+            spill: spillOptions(loaded),
+            offline,
+            packages,
+          });
+        } finally {
+          managers.delete(serverManager);
+        }
+        const failure = outcomeFailure(outcome, config.exitCodes);
+        if (failure) {
+          throw new Error(failure.message);
+        }
+        return outcome.result;
+      },
+      { parallel: options.parallel ?? 4 }
+    );
+
+    process.stdout.write(formatMatrixResults(runs, aggregateMatrix(runs)));
+    if (runs.some(run => run.status === 'failed')) {
+      process.exit(1);
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  } finally {
+    process.off('SIGINT', shutdown);
+    process.off('SIGTERM', shutdown);
+  }
+}
+
+/**
+ * Submit the script to an mcpsd executor and exit with its exit code
+ */
+async function runOnExecutor(options: RunOptions, url: string): Promise<void> {
+  const token = process.env.MCPS_REMOTE_TOKEN;
+  if (!token) {
+    console.error('Error: set MCPS_REMOTE_TOKEN to run scripts remotely');
+    process.exit(1);
+  }
+
+  try {
+    const source = await readFile(options.file, 'utf-8');
+    const code = await runRemote({
+      url,
+      token,
+      file: basename(options.file),
+      source,
+      timeout: options.timeout,
+      role: options.role,
+    });
+    process.exit(code);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
   }
 }
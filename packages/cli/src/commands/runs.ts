@@ -0,0 +1,52 @@
+// mcps runs command
+import type { RunsDiffOptions } from '../types.js';
+import { loadProjectConfig } from '../config.js';
+import { projectStorage, type Storage } from '../storage.js';
+import {
+  findTranscript,
+  foundTranscriptId,
+  readTranscript,
+} from '../transcripts.js';
+import { diffRuns, formatRunChange } from '../run-diff.js';
+
+/**
+ * Compare two recorded runs of a workflow by the transcripts they left,
+ * printing the tool calls, arguments, results, timings and agent replies
+ * that differ
+ */
+export async function runsDiffCommand(options: RunsDiffOptions): Promise<void> {
+  let storage: Storage;
+  try {
+    storage = projectStorage(await loadProjectConfig(process.cwd()));
+    const found = [
+      await findTranscript(options.before, storage),
+      await findTranscript(options.after, storage),
+    ];
+    const [before, after] = await Promise.all(
+      found.map(reference => readTranscript(reference, storage))
+    );
+    const [oldId, newId] = found.map(foundTranscriptId);
+    const changes = diffRuns(before, after, {
+      timingThreshold: options.timingThreshold,
+    });
+
+    if (options.format === 'json') {
+      console.log(JSON.stringify({ old: oldId, new: newId, changes }, null, 2));
+    } else {
+      console.log(`--- ${oldId}${before.file ? ` (${before.file})` : ''}`);
+      console.log(`+++ ${newId}${after.file ? ` (${after.file})` : ''}`);
+      for (const change of changes) {
+        console.log(formatRunChange(change));
+      }
+      if (changes.length === 0) {
+        console.log('The runs made the same calls with the same results');
+      }
+    }
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+  await storage.close();
+}
@@ -0,0 +1,158 @@
+// mcps serve command
+import type { AddressInfo } from 'net';
+import { fileURLToPath } from 'url';
+import { config as dotenvConfig } from 'dotenv';
+import { LineConnection } from '../mcp-server/transport.js';
+import {
+  loadWorkflows,
+  WorkflowServer,
+  type Workflow,
+} from '../mcp-server/server.js';
+import { RunManager } from '../remote/runs.js';
+import {
+  createTriggerServer,
+  loadTriggerScripts,
+  TriggerService,
+} from '../triggers/service.js';
+import { DirectoryEventQueue } from '../triggers/queue.js';
+import {
+  configuredAuthenticators,
+  configuredTls,
+  sharedTokenAuthenticator,
+} from '../triggers/auth.js';
+import { loadProjectConfig } from '../config.js';
+import { projectStorage } from '../storage.js';
+import type { ServeOptions } from '../types.js';
+import { collectFiles } from './fmt.js';
+import { TEST_FILE_SUFFIX } from './test.js';
+
+export async function serveCommand(options: ServeOptions): Promise<void> {
+  // Workflows see the same environment as runs
+  dotenvConfig({ quiet: true });
+
+  if (options.http) {
+    await serveTriggers(options, options.http);
+    return;
+  }
+
+  let workflows: Workflow[];
+  try {
+    const files = await collectFiles(options.paths);
+    workflows = await loadWorkflows(
+      files.filter(file => !file.endsWith(TEST_FILE_SUFFIX))
+    );
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+  if (workflows.length === 0) {
+    console.error('Error: no workflows found');
+    process.exit(1);
+  }
+
+  // stdout carries the protocol, so anything else must go to stderr
+  const runs = new RunManager({
+    workerPath: fileURLToPath(new URL('../remote/worker.js', import.meta.url)),
+  });
+  const server = new WorkflowServer(
+    new LineConnection(process.stdin, process.stdout),
+    workflows,
+    runs,
+    { timeout: options.timeout }
+  );
+  server.start();
+  console.error(
+    `mcps serving ${workflows.map(w => w.name).join(', ')} over MCP on stdio`
+  );
+
+  // The client closing stdin ends the session and its runs
+  process.stdin.on('end', () => {
+    runs.cancelAll();
+    process.exit(0);
+  });
+}
+
+/**
+ * Run the scripts that declare triggers or event handlers, and fire them
+ * and deliver events to them until interrupted
+ */
+async function serveTriggers(
+  options: ServeOptions,
+  { host, port }: { host: string; port: number }
+): Promise<void> {
+  try {
+    const files = await collectFiles(options.paths);
+    const scripts = await loadTriggerScripts(
+      files.filter(file => !file.endsWith(TEST_FILE_SUFFIX)),
+      { timeout: options.timeout }
+    );
+    if (scripts.length === 0) {
+      console.error('Error: no triggers or event handlers found');
+      process.exit(1);
+    }
+
+    // Run history and the audit log are kept in the storage the project
+    // the service is started in configures, which instances may share
+    const loaded = await loadProjectConfig(process.cwd());
+    const storage = loaded.config.storage ? projectStorage(loaded) : undefined;
+    const service = new TriggerService(scripts, {
+      timeout: options.timeout,
+      storage,
+      warn: message => console.error(`Warning: ${message}`),
+      triggers: loaded.config.serve?.triggers,
+    });
+    // Clients present the shared token, or the credentials the project
+    // configures, which name the caller runs work for
+    const token = process.env.MCPS_SERVE_TOKEN;
+    const tls = await configuredTls(loaded);
+    const server = createTriggerServer(service, {
+      auth: [
+        ...(token ? [sharedTokenAuthenticator(token)] : []),
+        ...configuredAuthenticators(loaded),
+      ],
+      tls,
+    });
+    await new Promise<void>((resolve, reject) => {
+      server.once('error', reject);
+      server.listen(port, host, () => resolve());
+    });
+    service.start();
+    const queue = options.queue
+      ? new DirectoryEventQueue(options.queue, {
+          warn: message => console.error(`Warning: ${message}`),
+        })
+      : undefined;
+    queue?.start(({ event, payload }) => {
+      if (service.deliver(event, payload, 'queue').length === 0) {
+        console.error(`Warning: no handler for event ${event}`);
+      }
+    });
+    const { address, port: actualPort } = server.address() as AddressInfo;
+    const names = [
+      ...service.listTriggers().map(trigger => `trigger ${trigger.name}`),
+      ...new Set(service.listEvents().map(({ event }) => `event ${event}`)),
+    ];
+    const scheme = tls ? 'https' : 'http';
+    console.error(
+      `mcps serving ${names.join(', ')} on ${scheme}://${address}:${actualPort}`
+    );
+
+    // Runs in progress finish before the scripts' servers are stopped
+    const stop = () => {
+      server.close();
+      Promise.resolve(queue?.stop())
+        .then(() => service.stop())
+        .then(() => storage?.close())
+        .finally(() => process.exit(0));
+    };
+    process.once('SIGINT', stop);
+    process.once('SIGTERM', stop);
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+}
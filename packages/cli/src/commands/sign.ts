@@ -0,0 +1,99 @@
+// mcps sign and mcps verify commands
+import { readFile, writeFile } from 'fs/promises';
+import { dirname } from 'path';
+import type { KeyObject } from 'crypto';
+import { loadProjectConfig } from '../config.js';
+import {
+  generateSigningKeys,
+  keyId,
+  readPublicKeys,
+  readSignature,
+  signaturePath,
+  signingPolicy,
+  signScript,
+  verifyScript,
+} from '../signing.js';
+import type { SignOptions, VerifyOptions } from '../types.js';
+import { collectFiles } from './fmt.js';
+
+function fail(error: unknown): never {
+  console.error(
+    `Error: ${error instanceof Error ? error.message : String(error)}`
+  );
+  process.exit(1);
+}
+
+/**
+ * Write a new key pair as <name>.key and <name>.pub, never overwriting
+ */
+async function generateKeys(name: string): Promise<void> {
+  const { publicKey, privateKey } = generateSigningKeys();
+  await writeFile(`${name}.key`, privateKey, { flag: 'wx', mode: 0o600 });
+  await writeFile(`${name}.pub`, publicKey, { flag: 'wx' });
+  console.log(`Wrote ${name}.key (keep it secret) and ${name}.pub`);
+}
+
+export async function signCommand(options: SignOptions): Promise<void> {
+  try {
+    if (options.generateKey) {
+      await generateKeys(options.generateKey);
+      if (options.files.length === 0) {
+        return;
+      }
+    }
+
+    // The private key comes from a file, or from the environment in CI
+    const privateKey = options.key
+      ? await readFile(options.key, 'utf-8')
+      : process.env.MCPS_SIGNING_KEY;
+    if (!privateKey) {
+      fail('pass --key or set MCPS_SIGNING_KEY to sign scripts');
+    }
+
+    for (const file of await collectFiles(options.files)) {
+      const signature = signScript(await readFile(file, 'utf-8'), privateKey);
+      const path = signaturePath(file);
+      await writeFile(path, `${JSON.stringify(signature, null, 2)}\n`);
+      console.log(`Signed ${file} with key ${signature.keyId}`);
+    }
+  } catch (error) {
+    fail(error);
+  }
+}
+
+export async function verifyCommand(options: VerifyOptions): Promise<void> {
+  let files: string[];
+  let keys: KeyObject[] | undefined;
+  try {
+    files = await collectFiles(options.files);
+    keys = options.keys?.length ? readPublicKeys(options.keys) : undefined;
+  } catch (error) {
+    fail(error);
+  }
+
+  let failed = false;
+  for (const file of files) {
+    try {
+      // Without --key, each file is checked against its project's keys
+      const trustedKeys =
+        keys ??
+        signingPolicy(await loadProjectConfig(dirname(file)))?.trustedKeys;
+      if (!trustedKeys) {
+        throw new Error(
+          'no trusted keys; pass --key or set "signing.trustedKeys" in .mcpsrc'
+        );
+      }
+      const source = await readFile(file, 'utf-8');
+      const key = verifyScript(file, source, readSignature(file), trustedKeys);
+      console.log(`${file}: signed with key ${keyId(key)}`);
+    } catch (error) {
+      console.error(
+        `Error: ${error instanceof Error ? error.message : String(error)}`
+      );
+      failed = true;
+    }
+  }
+  if (failed) {
+    process.exit(1);
+  }
+}
@@ -0,0 +1,227 @@
+// mcps test command
+import { readFile, stat } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import { config as dotenvConfig } from 'dotenv';
+import {
+  checkTypes,
+  collectCapabilities,
+  createFileLoader,
+  generateCode,
+  loadProgram,
+  TypeCheckError,
+  type Statement,
+} from '@mcpscript/transpiler';
+import {
+  executeInVM,
+  FaultInjector,
+  MockServers,
+  TestSuite,
+  type AppMessage,
+  type FaultPlan,
+  type ToolSchema,
+} from '@mcpscript/runtime';
+import { loadProjectConfig, moduleSearchPaths } from '../config.js';
+import { loadFaultPlan } from '../faults.js';
+import { findToolsLock, readToolsLock } from '../tools-lock.js';
+import { offlineMode } from '../offline.js';
+import type { TestOptions } from '../types.js';
+import { formatScriptError } from '../ui/script-error.js';
+import { collectFiles } from './fmt.js';
+
+export const TEST_FILE_SUFFIX = '_test.mcps';
+
+/**
+ * Test files among the given paths: files named explicitly, and the
+ * *_test.mcps files of directories
+ */
+export async function collectTestFiles(paths: string[]): Promise<string[]> {
+  const files: string[] = [];
+  for (const path of paths) {
+    if ((await stat(path)).isDirectory()) {
+      const found = await collectFiles([path]);
+      files.push(...found.filter(file => file.endsWith(TEST_FILE_SUFFIX)));
+    } else {
+      files.push(path);
+    }
+  }
+  return files;
+}
+
+/**
+ * Tools the mock servers of a test list: every tool of a server in the
+ * lockfile, and the tools the script calls, which without a locked schema
+ * take their arguments as arg0, arg1, ...
+ */
+export function mockSchemas(
+  statements: Statement[],
+  locked: Record<string, ToolSchema[]> = {}
+): Record<string, ToolSchema[]> {
+  const { servers, tools } = collectCapabilities(statements);
+  const schemas: Record<string, ToolSchema[]> = {};
+  for (const { name } of servers) {
+    schemas[name] = [...(locked[name] ?? [])];
+  }
+  for (const { server, tool } of tools) {
+    const listed = schemas[server] ?? [];
+    if (tool !== '*' && !listed.some(schema => schema.name === tool)) {
+      listed.push({ name: tool, inputSchema: { type: 'object' } });
+    }
+    schemas[server] = listed;
+  }
+  return schemas;
+}
+
+interface TestCase {
+  name: string;
+  duration: number;
+  error?: string;
+}
+
+interface TestResult {
+  file: string;
+  /** Milliseconds the test took */
+  duration: number;
+  error?: string;
+  /** Test cases the file declares, in order */
+  cases: TestCase[];
+  /** What the test printed, shown when it fails */
+  output: string[];
+  /** Faults injected into its mock servers' calls, such as "1 drop" */
+  faults?: string;
+}
+
+function describeError(error: unknown): string {
+  if (error instanceof TypeCheckError) {
+    return error.message;
+  }
+  return error instanceof Error ? formatScriptError(error) : String(error);
+}
+
+async function runTestFile(
+  file: string,
+  timeout: number | undefined,
+  plan: FaultPlan | undefined
+): Promise<TestResult> {
+  const started = Date.now();
+  // Each file draws the plan's faults afresh, so it sees the same ones
+  // however many files run before it
+  const faults = plan && new FaultInjector(plan);
+  const output: string[] = [];
+  const addMessage = ({ title, body }: AppMessage) => {
+    output.push(title ? `${title}: ${body}` : body);
+  };
+  let suite: TestSuite | undefined;
+  const result = (error?: string): TestResult => ({
+    file,
+    duration: Date.now() - started,
+    error,
+    cases: (suite?.results ?? []).map(({ name, duration, error }) => ({
+      name,
+      duration,
+      ...(error !== undefined && { error: describeError(error) }),
+    })),
+    output,
+    faults: faults?.summary() || undefined,
+  });
+
+  try {
+    const source = await readFile(file, 'utf-8');
+    const loaded = await loadProjectConfig(dirname(file));
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    const ast = loadProgram(resolve(file), source, loader);
+    checkTypes(ast);
+
+    const lockPath = findToolsLock(dirname(resolve(file)));
+    const lock = lockPath ? await readToolsLock(lockPath) : undefined;
+    const mocks = new MockServers(mockSchemas(ast, lock?.servers));
+    suite = new TestSuite(mocks, dirname(resolve(file)));
+    await executeInVM(generateCode(ast, { sourcePositions: true }), {
+      timeout,
+      addMessage,
+      sourceFile: file,
+      source,
+      redaction: loaded.config.redaction,
+      project: loaded.config.project,
+      mocks,
+      faults,
+      tests: suite,
+      offline: offlineMode(),
+    });
+    return result();
+  } catch (error) {
+    return result(describeError(error));
+  }
+}
+
+function indent(text: string): string {
+  return text.replace(/^/gm, '    ');
+}
+
+export async function testCommand(options: TestOptions): Promise<void> {
+  // Tests see the same environment as runs
+  dotenvConfig({ quiet: true });
+
+  let files: string[];
+  let plan: FaultPlan | undefined;
+  try {
+    files = await collectTestFiles(options.paths);
+    plan = options.faults ? await loadFaultPlan(options.faults) : undefined;
+  } catch (error) {
+    console.error(
+      `Error: ${error instanceof Error ? error.message : String(error)}`
+    );
+    process.exit(1);
+  }
+  if (files.length === 0) {
+    console.error(`Error: no *${TEST_FILE_SUFFIX} files found`);
+    process.exit(1);
+  }
+
+  // Files declaring test cases count once per case, other files once
+  let passed = 0;
+  let failed = 0;
+  for (const file of files) {
+    const { duration, error, cases, output, faults } = await runTestFile(
+      file,
+      options.timeout,
+      plan
+    );
+    const failedCases = cases.filter(testCase => testCase.error).length;
+    if (cases.length > 0) {
+      passed += cases.length - failedCases;
+      failed += failedCases;
+    } else if (!error) {
+      passed++;
+    }
+    if (error) {
+      failed++;
+    }
+    const ok = !error && failedCases === 0;
+    console.log(`${ok ? 'PASS' : 'FAIL'} ${file} (${duration} ms)`);
+    if (faults) {
+      console.log(`  Injected ${faults}`);
+    }
+    for (const testCase of cases) {
+      console.log(
+        `  ${testCase.error ? 'FAIL' : 'ok'} ${testCase.name} (${testCase.duration} ms)`
+      );
+      if (testCase.error) {
+        console.log(indent(indent(testCase.error)));
+      }
+    }
+    if (ok) {
+      continue;
+    }
+    if (error) {
+      console.log(indent(error));
+    }
+    if (output.length > 0) {
+      console.log(`  Output:\n${indent(output.join('\n'))}`);
+    }
+  }
+
+  console.log(`\n${passed} passed, ${failed} failed`);
+  if (failed > 0) {
+    process.exit(1);
+  }
+}
@@ -0,0 +1,70 @@
+// mcps tools command
+import { dirname, resolve } from 'path';
+import { config as dotenvConfig } from 'dotenv';
+import type { ToolsSyncOptions } from '../types.js';
+import { loadProjectConfig } from '../config.js';
+import {
+  diffToolSchemas,
+  findToolsLock,
+  formatToolChanges,
+  readToolsLock,
+  writeToolsLock,
+} from '../tools-lock.js';
+import { listServerTools } from './lock.js';
+
+/**
+ * Start the MCP servers a script declares at their pinned versions and
+ * bring the tools of the lockfile up to date with the ones they list,
+ * printing what changed; with check, fail instead when anything did, so
+ * CI notices a stale lockfile
+ */
+export async function toolsSyncCommand(
+  options: ToolsSyncOptions
+): Promise<void> {
+  const { file, check = false } = options;
+
+  // Servers are often configured through environment variables
+  dotenvConfig({ quiet: true });
+
+  if (!file.endsWith('.mcps')) {
+    console.error('Error: File must have .mcps extension');
+    process.exit(1);
+  }
+
+  let stale: boolean;
+  try {
+    const path = findToolsLock(dirname(resolve(file)));
+    if (!path) {
+      throw new Error(`No tools lockfile found for ${file}; run "mcps lock"`);
+    }
+    const lock = await readToolsLock(path);
+    const loaded = await loadProjectConfig(dirname(resolve(file)));
+    // Pins stay as they are; "mcps lock --update" moves them
+    const schemas = await listServerTools(file, loaded, lock.packages);
+    const changes = diffToolSchemas(lock.servers, schemas);
+    stale = changes.length > 0;
+
+    if (!stale) {
+      console.error(`The tools in ${path} are up to date`);
+    } else {
+      process.stdout.write(formatToolChanges(changes) + '\n');
+      if (check) {
+        console.error(
+          `${path} is out of date with ${changes.length} tool changes; run "mcps tools sync ${file}"`
+        );
+      } else {
+        Object.assign(lock.servers, schemas);
+        await writeToolsLock(path, lock);
+        console.error(`Updated ${changes.length} tools in ${path}`);
+      }
+    }
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    console.error(`Error: ${message}`);
+    process.exit(1);
+  }
+
+  if (check && stale) {
+    process.exit(1);
+  }
+}
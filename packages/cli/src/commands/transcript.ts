@@ -0,0 +1,209 @@
+// mcps transcript command
+import { existsSync } from 'fs';
+import { readFile, writeFile } from 'fs/promises';
+import { basename, dirname, join, relative, sep } from 'path';
+import { replayMessages } from '@mcpscript/runtime';
+import { parseSource } from '@mcpscript/transpiler';
+import type {
+  TranscriptMocksOptions,
+  TranscriptReplayOptions,
+  TranscriptShowOptions,
+} from '../types.js';
+import { loadProjectConfig } from '../config.js';
+import { projectStorage, type Storage } from '../storage.js';
+import {
+  findTranscript,
+  formatTranscript,
+  foundTranscriptId,
+  listTranscripts,
+  readTranscript,
+  recordedTestFile,
+  transcriptRecording,
+  uncoveredTools,
+} from '../transcripts.js';
+import { findToolsLock, readToolsLock } from '../tools-lock.js';
+import { runCommand } from './run.js';
+
+function fail(error: unknown): never {
+  console.error(
+    `Error: ${error instanceof Error ? error.message : String(error)}`
+  );
+  process.exit(1);
+}
+
+/**
+ * Run a command with the storage of the project in the current directory,
+ * which transcripts are recorded in
+ */
+async function withStorage(
+  command: (storage: Storage) => Promise<void>
+): Promise<void> {
+  let storage: Storage;
+  try {
+    storage = projectStorage(await loadProjectConfig(process.cwd()));
+    await command(storage);
+  } catch (error) {
+    fail(error);
+  }
+  await storage.close();
+}
+
+/**
+ * List the recorded transcripts, newest first
+ */
+export async function transcriptListCommand(): Promise<void> {
+  await withStorage(async storage => {
+    for (const summary of await listTranscripts(storage)) {
+      const transcript = await readTranscript(summary.id, storage);
+      const agents = [...new Set(transcript.runs.map(run => run.agent))];
+      console.log(
+        `${summary.id}  ${transcript.runs.length} agent runs (${agents.join(', ')})`
+      );
+    }
+  });
+}
+
+/**
+ * Print the agent runs of a transcript
+ */
+export async function transcriptShowCommand(
+  options: TranscriptShowOptions
+): Promise<void> {
+  await withStorage(async storage => {
+    const found = await findTranscript(options.transcript, storage);
+    const transcript = await readTranscript(found, storage);
+    if (options.format === 'json') {
+      console.log(JSON.stringify(transcript, null, 2));
+    } else {
+      process.stdout.write(
+        formatTranscript(foundTranscriptId(found), transcript)
+      );
+    }
+  });
+}
+
+/**
+ * Run an agent of a transcript again, continuing its conversation from one
+ * of its messages with the script as it is now
+ */
+export async function transcriptReplayCommand(
+  options: TranscriptReplayOptions
+): Promise<void> {
+  const point = /^(\d+)\.(\d+)$/.exec(options.from);
+  if (!point) {
+    console.error(
+      'Error: --from must be an agent run and message, e.g. 1.3 for the third message of run #1'
+    );
+    process.exit(1);
+  }
+  const from = {
+    transcript: options.transcript,
+    run: Number(point[1]),
+    message: Number(point[2]),
+  };
+
+  let replay!: Awaited<ReturnType<typeof replayMessages>>;
+  let file!: string;
+  await withStorage(async storage => {
+    const found = await findTranscript(options.transcript, storage);
+    const transcript = await readTranscript(found, storage);
+    if (!transcript.file) {
+      throw new Error(`${found} does not say which script it is of`);
+    }
+    from.transcript = foundTranscriptId(found);
+    replay = replayMessages(transcript, from.run, from.message);
+    file = transcript.file;
+  });
+
+  await runCommand({
+    file,
+    timeout: options.timeout,
+    replay: { from, ...replay },
+  });
+}
+
+/**
+ * Servers the script declares, with their locked tools
+ */
+async function lockedServers(
+  file: string
+): Promise<Record<string, { name: string }[]> | undefined> {
+  const lock = findToolsLock(dirname(file));
+  if (!lock) {
+    return undefined;
+  }
+  const { servers } = await readToolsLock(lock);
+  const declared = parseSource(await readFile(file, 'utf-8')).flatMap(stmt =>
+    stmt.type === 'mcp_declaration' ? [stmt.name] : []
+  );
+  return Object.fromEntries(
+    declared.map(name => [name, servers[name] ?? []])
+  );
+}
+
+/**
+ * Write a test file answering a script's tool calls as a transcript
+ * recorded them, next to a recording of the calls
+ */
+export async function transcriptMocksCommand(
+  options: TranscriptMocksOptions
+): Promise<void> {
+  await withStorage(async storage => {
+    const found = await findTranscript(options.transcript, storage);
+    const id = foundTranscriptId(found);
+    const transcript = await readTranscript(found, storage);
+    const file = transcript.file;
+    if (!file) {
+      throw new Error(`${found} does not say which script it is of`);
+    }
+    const recording = transcriptRecording(id, transcript);
+    if (recording.calls.length === 0) {
+      throw new Error(
+        `Transcript ${id} recorded no tool calls, so there is nothing to mock`
+      );
+    }
+
+    const out = options.out ?? dirname(file);
+    const name = basename(file, '.mcps');
+    const testPath = join(out, `${name}_test.mcps`);
+    const recordingPath = join(out, `${name}_recorded.json`);
+    for (const target of [testPath, recordingPath]) {
+      if (existsSync(target)) {
+        throw new Error(
+          `${target} already exists; remove it or write elsewhere with --out`
+        );
+      }
+    }
+
+    const servers = existsSync(file) ? await lockedServers(file) : undefined;
+    const uncovered = servers ? uncoveredTools(recording, servers) : [];
+    const script = relative(out, file).split(sep).join('/');
+    await writeFile(
+      recordingPath,
+      `${JSON.stringify(recording, null, 2)}\n`,
+      'utf-8'
+    );
+    await writeFile(
+      testPath,
+      recordedTestFile(
+        recording,
+        script.startsWith('.') ? script : `./${script}`,
+        basename(recordingPath),
+        uncovered
+      ),
+      'utf-8'
+    );
+
+    const tools = new Set(recording.calls.map(call => call.tool));
+    console.log(
+      `Wrote ${testPath} and ${recordingPath} with ${recording.calls.length} calls of ${tools.size} tools`
+    );
+    if (!servers) {
+      console.error(
+        'Warning: No mcps-tools.lock.json for the script; run mcps lock so the mocks list its tools'
+      );
+    } else if (uncovered.length > 0) {
+      console.log(`Never called: ${uncovered.join(', ')}`);
+    }
+  });
+}
@@ -0,0 +1,890 @@
+// Project configuration (.mcpsrc)
+import { readFileSync } from 'fs';
+import { dirname, join, resolve } from 'path';
+import {
+  commandSecretProvider,
+  envSecretProvider,
+  type ApprovedCall,
+  fileSecretProvider,
+  type ProjectMetadata,
+  type RedactionRules,
+  type SecretProvider,
+  type SpillOptions,
+} from '@mcpscript/runtime';
+import {
+  EXPERIMENTAL_FEATURES,
+  LINT_PACKS,
+  setLanguageFeatures,
+} from '@mcpscript/transpiler';
+import { EXIT_CODE_SETTINGS, type ExitCodesConfig } from './exit-codes.js';
+import { validateOidcConfig, type OidcConfig } from './remote/oidc.js';
+
+export const CONFIG_FILE_NAME = '.mcpsrc';
+
+/**
+ * Settings shared by every script in a project, read from a JSON .mcpsrc
+ * file in the script's directory or one of its parents
+ */
+export interface ProjectConfig {
+  /** Masking of sensitive data in logs and other records */
+  redaction?: RedactionRules;
+  /** Directories searched for imports that are not relative paths */
+  modulePaths?: string[];
+  /** Experimental language features the project's scripts may use */
+  features?: string[];
+  /** Settings of `mcps check` */
+  lint?: LintConfig;
+  /** Signatures scripts must have to run, see `mcps sign` */
+  signing?: SigningConfig;
+  /** Execution profiles runs can be started in, by role name */
+  roles?: Record<string, RoleConfig>;
+  /**
+   * Where ${secret:NAME} references of server options are looked up, in
+   * order (default: ["env"])
+   */
+  secrets?: SecretProviderConfig[];
+  /**
+   * Metadata of the project, such as its name and version, which prompts
+   * reference as ${project.KEY}
+   */
+  project?: ProjectMetadata;
+  /**
+   * Where runs keep their checkpoints, transcripts, history and audit log
+   * (default: files in the user's state directory)
+   */
+  storage?: StorageConfig;
+  /** Where runs keep the checkpoints they are resumed from */
+  checkpoints?: CheckpointConfig;
+  /** Model embed() and the vector index use unless given another */
+  embeddings?: EmbeddingConfig;
+  /** Where the vector index keeps its collections */
+  vectors?: VectorsConfig;
+  /** When runs keep large tool results on disk instead of in memory */
+  spill?: SpillConfig;
+  /** Where runs nobody watches send the questions scripts ask */
+  approvals?: ApprovalsConfig;
+  /**
+   * Exit codes of runs with tool error results or failed parallel map
+   * items, and of checks with lint warnings (by default they succeed)
+   */
+  exitCodes?: ExitCodesConfig;
+  /**
+   * Locale the format builtins write numbers, amounts and dates in, such
+   * as "de-DE" (default: the locale of the process)
+   */
+  locale?: string;
+  /** Language of diagnostics, and the project's own message catalogs */
+  messages?: MessagesConfig;
+  /** Settings of `mcps serve` */
+  serve?: ServeConfig;
+}
+
+/**
+ * Settings of `mcps serve`
+ */
+export interface ServeConfig {
+  /**
+   * Descriptions of the tools workflows are served as by `--mcp`, by tool
+   * name, which replace those of the scripts' comments
+   */
+  tools?: Record<string, ToolDescriptionConfig>;
+  /**
+   * Credentials `--http` accepts besides $MCPS_SERVE_TOKEN, tried in turn;
+   * the runs a request starts work for the caller they name
+   */
+  auth?: ServeAuthConfig[];
+  /** Certificate `--http` serves HTTPS with */
+  tls?: ServeTlsConfig;
+  /** Settings of the triggers `--http` serves, by trigger name */
+  triggers?: Record<string, ServeTriggerConfig>;
+}
+
+export interface ServeTriggerConfig {
+  /**
+   * Whether the trigger only reads what it reports, so that its result is
+   * served and kept for maxAge seconds
+   */
+  readOnly?: boolean;
+  /** How long its result is kept, in seconds (default: 60) */
+  maxAge?: number;
+}
+
+/**
+ * One kind of credentials of `mcps serve --http`: API keys, the tokens of
+ * an OIDC issuer, or client certificates, which need `tls`
+ */
+export type ServeAuthConfig =
+  | { type: 'apiKey'; keys: ApiKeyConfig[]; header?: string }
+  | ({ type: 'oidc' } & OidcConfig)
+  | { type: 'mtls'; subject?: 'cn' | 'san' };
+
+export interface ApiKeyConfig {
+  /** Caller the key belongs to */
+  subject: string;
+  /** Environment variable holding the key */
+  env: string;
+}
+
+/**
+ * PEM files, relative to the config file
+ */
+export interface ServeTlsConfig {
+  cert: string;
+  key: string;
+  /** Certificate authorities client certificates must be signed by */
+  ca?: string;
+}
+
+export interface ToolDescriptionConfig {
+  description?: string;
+  /** Descriptions of the tool's arguments, by parameter name of main */
+  parameters?: Record<string, string>;
+}
+
+/**
+ * Language diagnostics are written in (overridden by $MCPS_LANG), and
+ * message catalogs by language, as JSON files relative to the config file
+ * that take precedence over the built-in ones
+ */
+export interface MessagesConfig {
+  language?: string;
+  catalogs?: Record<string, string>;
+}
+
+/**
+ * Approval webhook answering the prompt.text(), prompt.select() and
+ * prompt.confirm() questions of runs without a terminal, see approvals.ts
+ */
+export interface ApprovalsConfig {
+  webhook: string;
+  /** Milliseconds to wait for an answer (default: ten minutes) */
+  timeout?: number;
+}
+
+/**
+ * Embedding model of a project; API keys come from the provider's
+ * environment variable, such as OPENAI_API_KEY
+ */
+export interface EmbeddingConfig {
+  provider: string;
+  model?: string;
+  baseURL?: string;
+  /** Length of the vectors, for models that can shorten them */
+  dimensions?: number;
+}
+
+/**
+ * Directory of the vector index's collections, relative to the config
+ * file (default: .mcps/vectors)
+ */
+export interface VectorsConfig {
+  path?: string;
+}
+
+/**
+ * Tool results over the threshold, in bytes, are kept in temporary files
+ * under dir, relative to the config file (default: the temp directory)
+ */
+export interface SpillConfig {
+  threshold: number;
+  dir?: string;
+}
+
+/**
+ * Checkpoint store of a project: JSON files in a directory (the default),
+ * or a SQLite database file; paths are relative to the config file
+ */
+export interface CheckpointConfig {
+  store?: 'file' | 'sqlite';
+  path?: string;
+}
+
+/**
+ * Storage backend of a project's run state: files in a directory, a SQLite
+ * database file (paths are relative to the config file), an S3 bucket or a
+ * Postgres database, whose URL defaults to $MCPS_STORAGE_URL
+ */
+export type StorageConfig =
+  | { backend: 'file' | 'sqlite'; path?: string }
+  | {
+      backend: 's3';
+      bucket: string;
+      prefix?: string;
+      region?: string;
+      endpoint?: string;
+    }
+  | { backend: 'postgres'; url?: string };
+
+export const STORAGE_BACKENDS = ['file', 'sqlite', 's3', 'postgres'];
+
+/**
+ * A source of secrets: the environment, the files of a directory (relative
+ * to the config file) or a command printing the secret, with {name} in its
+ * arguments replaced by the secret's name
+ */
+export type SecretProviderConfig =
+  | 'env'
+  | { file: string }
+  | { command: string[] };
+
+/**
+ * Lint settings of a project
+ */
+export interface LintConfig {
+  /** Severities by rule name: "error", "warning" or "off" */
+  rules?: Record<string, 'error' | 'warning' | 'off'>;
+  /**
+   * JavaScript modules with project-specific rules, exported as `rules`
+   * (paths are relative to the config file)
+   */
+  plugins?: string[];
+  /** Optional rule packs to enable, such as "prompts" */
+  packs?: string[];
+}
+
+/**
+ * Signing policy of a project
+ */
+export interface SigningConfig {
+  /**
+   * Ed25519 public keys (PEM files, relative to the config file) that
+   * scripts and the modules they import must be signed with
+   */
+  trustedKeys: string[];
+  /** Refuse unsigned scripts too, not only tampered ones (default: false) */
+  required?: boolean;
+}
+
+/**
+ * What runs started in a role may do, see `mcps run --as`
+ */
+export interface RoleConfig {
+  /**
+   * Tools the role may call, as "server.tool" patterns in which * matches
+   * any text (all tools when omitted)
+   */
+  allowTools?: string[];
+  /** Tools each call of which must be approved first, as patterns */
+  requireApproval?: string[];
+  /**
+   * Calls of those tools approved for good, such as those answered
+   * "always" at the prompt
+   */
+  approvedCalls?: ApprovedCall[];
+  /** Most MCP tool calls one run may make */
+  maxToolCalls?: number;
+  /** Longest timeout in milliseconds, also used when a run asks for none */
+  maxTimeout?: number;
+  /**
+   * Subjects of the callers mcpsd may start runs in the role for, as
+   * patterns in which * matches any text (any caller when omitted)
+   */
+  callers?: string[];
+}
+
+export interface LoadedConfig {
+  config: ProjectConfig;
+  /** Path of the file the config was read from, if one was found */
+  path?: string;
+}
+
+function isStringArray(value: unknown): value is string[] {
+  return Array.isArray(value) && value.every(item => typeof item === 'string');
+}
+
+function isLocale(value: string): boolean {
+  try {
+    return Intl.getCanonicalLocales(value).length === 1;
+  } catch {
+    return false;
+  }
+}
+
+function isUrl(value: string): boolean {
+  try {
+    new URL(value);
+    return true;
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * Check the shape of a parsed config, naming the first invalid field
+ */
+export function validateProjectConfig(value: unknown): ProjectConfig {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('config must be a JSON object');
+  }
+
+  const {
+    redaction,
+    modulePaths,
+    features,
+    lint,
+    signing,
+    roles,
+    secrets,
+    project,
+    storage,
+    checkpoints,
+    embeddings,
+    vectors,
+    spill,
+    approvals,
+    exitCodes,
+    locale,
+    messages,
+    serve,
+  } = value as Record<string, unknown>;
+  if (redaction !== undefined) {
+    if (typeof redaction !== 'object' || redaction === null) {
+      throw new Error('"redaction" must be an object');
+    }
+    const rules = redaction as Record<string, unknown>;
+    for (const field of ['patterns', 'keys', 'toolArguments', 'environment']) {
+      if (rules[field] !== undefined && !isStringArray(rules[field])) {
+        throw new Error(`"redaction.${field}" must be an array of strings`);
+      }
+    }
+    if (
+      rules.replacement !== undefined &&
+      typeof rules.replacement !== 'string'
+    ) {
+      throw new Error('"redaction.replacement" must be a string');
+    }
+  }
+
+  if (modulePaths !== undefined && !isStringArray(modulePaths)) {
+    throw new Error('"modulePaths" must be an array of strings');
+  }
+
+  if (features !== undefined) {
+    if (!isStringArray(features)) {
+      throw new Error('"features" must be an array of strings');
+    }
+    const known = EXPERIMENTAL_FEATURES.map(feature => feature.name);
+    const unknown = features.find(name => !known.includes(name));
+    if (unknown !== undefined) {
+      throw new Error(
+        known.length > 0
+          ? `"features" has unknown feature ${unknown}, expected one of ${known.join(', ')}`
+          : `"features" has unknown feature ${unknown}, no features are experimental`
+      );
+    }
+  }
+
+  if (lint !== undefined) {
+    if (typeof lint !== 'object' || lint === null || Array.isArray(lint)) {
+      throw new Error('"lint" must be an object');
+    }
+    const { rules, plugins, packs } = lint as Record<string, unknown>;
+    if (rules !== undefined) {
+      if (typeof rules !== 'object' || rules === null) {
+        throw new Error('"lint.rules" must be an object');
+      }
+      for (const [name, severity] of Object.entries(rules)) {
+        if (!['error', 'warning', 'off'].includes(severity as string)) {
+          throw new Error(
+            `"lint.rules.${name}" must be "error", "warning" or "off"`
+          );
+        }
+      }
+    }
+    if (plugins !== undefined && !isStringArray(plugins)) {
+      throw new Error('"lint.plugins" must be an array of strings');
+    }
+    if (
+      packs !== undefined &&
+      (!isStringArray(packs) || packs.some(pack => !LINT_PACKS[pack]))
+    ) {
+      throw new Error(
+        `"lint.packs" must be an array of lint packs: ${Object.keys(LINT_PACKS).join(', ')}`
+      );
+    }
+  }
+
+  if (signing !== undefined) {
+    if (
+      typeof signing !== 'object' ||
+      signing === null ||
+      Array.isArray(signing)
+    ) {
+      throw new Error('"signing" must be an object');
+    }
+    const { trustedKeys, required } = signing as Record<string, unknown>;
+    if (!isStringArray(trustedKeys)) {
+      throw new Error('"signing.trustedKeys" must be an array of strings');
+    }
+    if (required !== undefined && typeof required !== 'boolean') {
+      throw new Error('"signing.required" must be a boolean');
+    }
+  }
+
+  if (roles !== undefined) {
+    if (typeof roles !== 'object' || roles === null || Array.isArray(roles)) {
+      throw new Error('"roles" must be an object');
+    }
+    for (const [name, role] of Object.entries(roles)) {
+      const field = `roles.${name}`;
+      if (typeof role !== 'object' || role === null || Array.isArray(role)) {
+        throw new Error(`"${field}" must be an object`);
+      }
+      const settings = role as Record<string, unknown>;
+      for (const list of ['allowTools', 'requireApproval', 'callers']) {
+        if (settings[list] !== undefined && !isStringArray(settings[list])) {
+          throw new Error(`"${field}.${list}" must be an array of strings`);
+        }
+      }
+      const { approvedCalls } = settings;
+      if (approvedCalls !== undefined) {
+        if (!Array.isArray(approvedCalls)) {
+          throw new Error(`"${field}.approvedCalls" must be an array`);
+        }
+        approvedCalls.forEach((rule: unknown, index) => {
+          const { tool, input } = (rule ?? {}) as Record<string, unknown>;
+          if (
+            typeof tool !== 'string' ||
+            (input !== undefined &&
+              (typeof input !== 'object' ||
+                input === null ||
+                Array.isArray(input)))
+          ) {
+            throw new Error(
+              `"${field}.approvedCalls[${index}]" must be an object with a tool pattern and optional input`
+            );
+          }
+        });
+      }
+      for (const limit of ['maxToolCalls', 'maxTimeout']) {
+        const value = settings[limit];
+        if (
+          value !== undefined &&
+          !(typeof value === 'number' && Number.isInteger(value) && value > 0)
+        ) {
+          throw new Error(`"${field}.${limit}" must be a positive integer`);
+        }
+      }
+    }
+  }
+
+  if (secrets !== undefined) {
+    if (!Array.isArray(secrets)) {
+      throw new Error('"secrets" must be an array');
+    }
+    secrets.forEach((provider: unknown, index) => {
+      const field = `secrets[${index}]`;
+      if (provider === 'env') {
+        return;
+      }
+      const { file, command } = (provider ?? {}) as Record<string, unknown>;
+      if (typeof file === 'string' && command === undefined) {
+        return;
+      }
+      if (isStringArray(command) && command.length > 0 && file === undefined) {
+        return;
+      }
+      throw new Error(
+        `"${field}" must be "env", { "file": <directory> } or ` +
+          '{ "command": [<program>, <arguments>...] }'
+      );
+    });
+  }
+
+  if (project !== undefined) {
+    if (
+      typeof project !== 'object' ||
+      project === null ||
+      Array.isArray(project)
+    ) {
+      throw new Error('"project" must be an object');
+    }
+    for (const [key, metadata] of Object.entries(project)) {
+      if (typeof metadata !== 'string') {
+        throw new Error(`"project.${key}" must be a string`);
+      }
+    }
+  }
+
+  if (storage !== undefined) {
+    if (
+      typeof storage !== 'object' ||
+      storage === null ||
+      Array.isArray(storage)
+    ) {
+      throw new Error('"storage" must be an object');
+    }
+    const fields = storage as Record<string, unknown>;
+    if (!STORAGE_BACKENDS.includes(fields.backend as string)) {
+      throw new Error(
+        `"storage.backend" must be one of ${STORAGE_BACKENDS.join(', ')}`
+      );
+    }
+    if (fields.backend === 's3' && typeof fields.bucket !== 'string') {
+      throw new Error('"storage.bucket" must be a string');
+    }
+    for (const field of ['path', 'prefix', 'region', 'endpoint', 'url']) {
+      if (fields[field] !== undefined && typeof fields[field] !== 'string') {
+        throw new Error(`"storage.${field}" must be a string`);
+      }
+    }
+  }
+
+  if (checkpoints !== undefined) {
+    if (
+      typeof checkpoints !== 'object' ||
+      checkpoints === null ||
+      Array.isArray(checkpoints)
+    ) {
+      throw new Error('"checkpoints" must be an object');
+    }
+    const { store, path } = checkpoints as Record<string, unknown>;
+    if (store !== undefined && store !== 'file' && store !== 'sqlite') {
+      throw new Error('"checkpoints.store" must be "file" or "sqlite"');
+    }
+    if (path !== undefined && typeof path !== 'string') {
+      throw new Error('"checkpoints.path" must be a string');
+    }
+  }
+
+  if (embeddings !== undefined) {
+    if (
+      typeof embeddings !== 'object' ||
+      embeddings === null ||
+      Array.isArray(embeddings)
+    ) {
+      throw new Error('"embeddings" must be an object');
+    }
+    const fields = embeddings as Record<string, unknown>;
+    if (typeof fields.provider !== 'string') {
+      throw new Error('"embeddings.provider" must be a string');
+    }
+    for (const field of ['model', 'baseURL']) {
+      if (fields[field] !== undefined && typeof fields[field] !== 'string') {
+        throw new Error(`"embeddings.${field}" must be a string`);
+      }
+    }
+    if (
+      fields.dimensions !== undefined &&
+      !(Number.isInteger(fields.dimensions) && Number(fields.dimensions) > 0)
+    ) {
+      throw new Error('"embeddings.dimensions" must be a positive integer');
+    }
+  }
+
+  if (vectors !== undefined) {
+    if (typeof vectors !== 'object' || vectors === null) {
+      throw new Error('"vectors" must be an object');
+    }
+    const { path } = vectors as Record<string, unknown>;
+    if (path !== undefined && typeof path !== 'string') {
+      throw new Error('"vectors.path" must be a string');
+    }
+  }
+
+  if (spill !== undefined) {
+    if (typeof spill !== 'object' || spill === null || Array.isArray(spill)) {
+      throw new Error('"spill" must be an object');
+    }
+    const { threshold, dir } = spill as Record<string, unknown>;
+    if (
+      !(
+        typeof threshold === 'number' &&
+        Number.isInteger(threshold) &&
+        threshold > 0
+      )
+    ) {
+      throw new Error('"spill.threshold" must be a positive integer');
+    }
+    if (dir !== undefined && typeof dir !== 'string') {
+      throw new Error('"spill.dir" must be a string');
+    }
+  }
+
+  if (approvals !== undefined) {
+    if (
+      typeof approvals !== 'object' ||
+      approvals === null ||
+      Array.isArray(approvals)
+    ) {
+      throw new Error('"approvals" must be an object');
+    }
+    const { webhook, timeout } = approvals as Record<string, unknown>;
+    if (typeof webhook !== 'string' || !isUrl(webhook)) {
+      throw new Error('"approvals.webhook" must be a URL');
+    }
+    if (
+      timeout !== undefined &&
+      !(typeof timeout === 'number' && Number.isInteger(timeout) && timeout > 0)
+    ) {
+      throw new Error('"approvals.timeout" must be a positive integer');
+    }
+  }
+
+  if (exitCodes !== undefined) {
+    if (
+      typeof exitCodes !== 'object' ||
+      exitCodes === null ||
+      Array.isArray(exitCodes)
+    ) {
+      throw new Error('"exitCodes" must be an object');
+    }
+    const codes = exitCodes as Record<string, unknown>;
+    for (const setting of EXIT_CODE_SETTINGS) {
+      const code = codes[setting];
+      if (
+        code !== undefined &&
+        !(Number.isInteger(code) && Number(code) >= 0 && Number(code) <= 255)
+      ) {
+        throw new Error(
+          `"exitCodes.${setting}" must be an integer from 0 to 255`
+        );
+      }
+    }
+  }
+
+  if (
+    locale !== undefined &&
+    !(typeof locale === 'string' && isLocale(locale))
+  ) {
+    throw new Error('"locale" must be a locale such as "de-DE"');
+  }
+
+  if (messages !== undefined) {
+    if (
+      typeof messages !== 'object' ||
+      messages === null ||
+      Array.isArray(messages)
+    ) {
+      throw new Error('"messages" must be an object');
+    }
+    const { language, catalogs } = messages as Record<string, unknown>;
+    if (language !== undefined && typeof language !== 'string') {
+      throw new Error('"messages.language" must be a string');
+    }
+    if (catalogs !== undefined) {
+      if (
+        typeof catalogs !== 'object' ||
+        catalogs === null ||
+        Array.isArray(catalogs)
+      ) {
+        throw new Error('"messages.catalogs" must be an object');
+      }
+      for (const [name, path] of Object.entries(catalogs)) {
+        if (typeof path !== 'string') {
+          throw new Error(`"messages.catalogs.${name}" must be a string`);
+        }
+      }
+    }
+  }
+
+  if (serve !== undefined) {
+    if (typeof serve !== 'object' || serve === null || Array.isArray(serve)) {
+      throw new Error('"serve" must be an object');
+    }
+    const { tools, auth, tls, triggers } = serve as Record<string, unknown>;
+    if (tools !== undefined) {
+      if (typeof tools !== 'object' || tools === null || Array.isArray(tools)) {
+        throw new Error('"serve.tools" must be an object');
+      }
+      for (const [name, tool] of Object.entries(tools)) {
+        const field = `serve.tools.${name}`;
+        if (typeof tool !== 'object' || tool === null || Array.isArray(tool)) {
+          throw new Error(`"${field}" must be an object`);
+        }
+        const { description, parameters } = tool as Record<string, unknown>;
+        if (description !== undefined && typeof description !== 'string') {
+          throw new Error(`"${field}.description" must be a string`);
+        }
+        if (
+          parameters !== undefined &&
+          (typeof parameters !== 'object' ||
+            parameters === null ||
+            Object.values(parameters).some(text => typeof text !== 'string'))
+        ) {
+          throw new Error(
+            `"${field}.parameters" must map parameter names to descriptions`
+          );
+        }
+      }
+    }
+    if (triggers !== undefined) {
+      if (
+        typeof triggers !== 'object' ||
+        triggers === null ||
+        Array.isArray(triggers)
+      ) {
+        throw new Error('"serve.triggers" must be an object');
+      }
+      for (const [name, trigger] of Object.entries(triggers)) {
+        const field = `serve.triggers.${name}`;
+        if (
+          typeof trigger !== 'object' ||
+          trigger === null ||
+          Array.isArray(trigger)
+        ) {
+          throw new Error(`"${field}" must be an object`);
+        }
+        const { readOnly, maxAge } = trigger as Record<string, unknown>;
+        if (readOnly !== undefined && typeof readOnly !== 'boolean') {
+          throw new Error(`"${field}.readOnly" must be a boolean`);
+        }
+        if (
+          maxAge !== undefined &&
+          !(
+            typeof maxAge === 'number' &&
+            Number.isInteger(maxAge) &&
+            maxAge > 0
+          )
+        ) {
+          throw new Error(`"${field}.maxAge" must be a positive integer`);
+        }
+      }
+    }
+    if (tls !== undefined) {
+      const { cert, key, ca } = (tls ?? {}) as Record<string, unknown>;
+      if (
+        typeof cert !== 'string' ||
+        typeof key !== 'string' ||
+        (ca !== undefined && typeof ca !== 'string')
+      ) {
+        throw new Error(
+          '"serve.tls" must be an object with cert, key and optional ca files'
+        );
+      }
+    }
+    if (auth !== undefined) {
+      if (!Array.isArray(auth)) {
+        throw new Error('"serve.auth" must be an array');
+      }
+      auth.forEach((entry: unknown, index) => {
+        const field = `serve.auth[${index}]`;
+        const { type, keys, header, subject } = (entry ?? {}) as Record<
+          string,
+          unknown
+        >;
+        if (type === 'apiKey') {
+          if (
+            !Array.isArray(keys) ||
+            !keys.every(
+              key =>
+                typeof key?.subject === 'string' && typeof key?.env === 'string'
+            )
+          ) {
+            throw new Error(
+              `"${field}.keys" must be an array of { "subject", "env" } objects`
+            );
+          }
+          if (header !== undefined && typeof header !== 'string') {
+            throw new Error(`"${field}.header" must be a string`);
+          }
+        } else if (type === 'oidc') {
+          validateOidcConfig(field, entry);
+        } else if (type === 'mtls') {
+          if (subject !== undefined && subject !== 'cn' && subject !== 'san') {
+            throw new Error(`"${field}.subject" must be "cn" or "san"`);
+          }
+          if ((tls as ServeTlsConfig | undefined)?.ca === undefined) {
+            throw new Error(
+              `"${field}" needs "serve.tls.ca" to check client certificates`
+            );
+          }
+        } else {
+          throw new Error(`"${field}.type" must be "apiKey", "oidc" or "mtls"`);
+        }
+      });
+    }
+  }
+
+  return value as ProjectConfig;
+}
+
+/**
+ * Module search directories of a loaded config, resolved against the
+ * directory of the config file
+ */
+export function moduleSearchPaths(loaded: LoadedConfig): string[] {
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return (loaded.config.modulePaths ?? []).map(dir => resolve(base, dir));
+}
+
+/**
+ * When runs of a loaded config spill tool results, with the directory
+ * resolved against the directory of the config file
+ */
+export function spillOptions(loaded: LoadedConfig): SpillOptions | undefined {
+  const { spill } = loaded.config;
+  if (!spill) {
+    return undefined;
+  }
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return {
+    threshold: spill.threshold,
+    ...(spill.dir !== undefined && { dir: resolve(base, spill.dir) }),
+  };
+}
+
+/**
+ * Secret providers of a loaded config, with directories resolved against
+ * the directory of the config file
+ */
+export function secretProviders(loaded: LoadedConfig): SecretProvider[] {
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return (loaded.config.secrets ?? ['env']).map(provider =>
+    provider === 'env'
+      ? envSecretProvider()
+      : 'file' in provider
+        ? fileSecretProvider(resolve(base, provider.file))
+        : commandSecretProvider(provider.command)
+  );
+}
+
+/**
+ * Find and load the project config for a directory, enabling its language
+ * features for the scripts parsed from then on
+ * Returns an empty config when no .mcpsrc file exists
+ */
+export async function loadProjectConfig(
+  startDir: string
+): Promise<LoadedConfig> {
+  return loadProjectConfigSync(startDir);
+}
+
+/**
+ * Synchronous form of loadProjectConfig, for callers such as the language
+ * server that answer requests without waiting
+ */
+export function loadProjectConfigSync(startDir: string): LoadedConfig {
+  let dir = resolve(startDir);
+  for (;;) {
+    const path = join(dir, CONFIG_FILE_NAME);
+    let content: string | undefined;
+    try {
+      content = readFileSync(path, 'utf-8');
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code !== 'ENOENT') {
+        throw error;
+      }
+    }
+
+    if (content !== undefined) {
+      let config: ProjectConfig;
+      try {
+        config = validateProjectConfig(JSON.parse(content));
+      } catch (error) {
+        const reason = error instanceof Error ? error.message : String(error);
+        throw new Error(`Invalid ${path}: ${reason}`);
+      }
+      // Scripts parsed from here on may use the project's features
+      setLanguageFeatures(config.features ?? []);
+      return { config, path };
+    }
+
+    const parent = dirname(dir);
+    if (parent === dir) {
+      setLanguageFeatures([]);
+      return { config: {} };
+    }
+    dir = parent;
+  }
+}
@@ -0,0 +1,293 @@
+// Debug adapter for MCP Script
+//
+// Speaks the Debug Adapter Protocol to an editor and runs the script it is
+// asked to launch under a ScriptDebugger. There is one thread; frames are
+// the top-level script and the tools it has called, and every frame has a
+// scope listing the MCP tool calls still waiting for an answer.
+import { basename } from 'path';
+import { inspect } from 'util';
+import {
+  MCPServerManager,
+  ScriptDebugger,
+  type DebugFrame,
+} from '@mcpscript/runtime';
+import type { Connection } from '../lsp/transport.js';
+import {
+  THREAD_ID,
+  type DebugMessage,
+  type LaunchArguments,
+  type RequestMessage,
+  type Scope,
+  type SetBreakpointsArguments,
+  type StackFrame,
+  type Variable,
+} from './protocol.js';
+
+/**
+ * Run a script under a debugger, passing what it prints to output, and
+ * resolve with its exit code once it has finished
+ */
+export type ScriptLauncher = (
+  program: string,
+  debug: ScriptDebugger,
+  output: (text: string, category: 'stdout' | 'stderr') => void,
+  serverManager: MCPServerManager
+) => Promise<number>;
+
+/**
+ * Serves a debugging session for one script over a connection
+ */
+export class DebugAdapter {
+  private seq = 1;
+  private program?: string;
+  private debug?: ScriptDebugger;
+  private breakpoints: number[] = [];
+  private configured = false;
+  private running = false;
+  private readonly serverManager = new MCPServerManager();
+  /** Children of the variables shown since the script last paused */
+  private references = new Map<number, () => Variable[]>();
+  private nextReference = 1;
+
+  constructor(
+    private readonly connection: Connection<DebugMessage>,
+    private readonly launch: ScriptLauncher,
+    private readonly onExit: (code: number) => void
+  ) {}
+
+  start(): void {
+    this.connection.listen(
+      message => this.handleMessage(message),
+      error => console.error(`mcps debug: ${error.message}`)
+    );
+  }
+
+  handleMessage(message: DebugMessage): void {
+    if (message.type !== 'request') {
+      return;
+    }
+    let body: unknown;
+    try {
+      body = this.dispatchRequest(message.command, message.arguments);
+    } catch (error) {
+      this.respond(message, {
+        success: false,
+        message: error instanceof Error ? error.message : String(error),
+      });
+      return;
+    }
+    this.respond(message, { success: true, body });
+
+    // Some requests are followed by events or actions, once answered
+    switch (message.command) {
+      case 'initialize':
+        this.sendEvent('initialized');
+        break;
+      case 'launch':
+      case 'configurationDone':
+        this.runWhenReady();
+        break;
+      case 'disconnect':
+      case 'terminate':
+        void this.serverManager
+          .closeAll()
+          .catch(() => {})
+          .finally(() => this.onExit(0));
+        break;
+    }
+  }
+
+  private dispatchRequest(command: string, args: unknown): unknown {
+    switch (command) {
+      case 'initialize':
+        return {
+          supportsConfigurationDoneRequest: true,
+          supportsTerminateRequest: true,
+        };
+      case 'launch': {
+        const { program, stopOnEntry } = (args ?? {}) as LaunchArguments;
+        if (typeof program !== 'string' || !program.endsWith('.mcps')) {
+          throw new Error('program must be the path of a .mcps file');
+        }
+        this.program = program;
+        this.debug = new ScriptDebugger({
+          stopOnEntry,
+          onStop: reason => {
+            this.references.clear();
+            this.sendEvent('stopped', {
+              reason,
+              threadId: THREAD_ID,
+              allThreadsStopped: true,
+            });
+          },
+        });
+        this.debug.setBreakpoints(this.breakpoints);
+        return undefined;
+      }
+      case 'setBreakpoints': {
+        const { breakpoints = [] } = args as SetBreakpointsArguments;
+        this.breakpoints = breakpoints.map(b => b.line);
+        this.debug?.setBreakpoints(this.breakpoints);
+        return {
+          breakpoints: this.breakpoints.map(line => ({ verified: true, line })),
+        };
+      }
+      case 'configurationDone':
+        this.configured = true;
+        return undefined;
+      case 'threads':
+        return { threads: [{ id: THREAD_ID, name: 'script' }] };
+      case 'stackTrace': {
+        const stackFrames = this.frames().map(
+          (frame, id): StackFrame => ({
+            id,
+            name: frame.tool ?? '<script>',
+            source: { name: basename(this.program!), path: this.program! },
+            line: frame.line,
+            column: frame.column,
+          })
+        );
+        return { stackFrames, totalFrames: stackFrames.length };
+      }
+      case 'scopes': {
+        const { frameId } = args as { frameId: number };
+        const frame = this.frames()[frameId];
+        if (!frame) {
+          throw new Error(`No frame ${frameId}`);
+        }
+        const scopes: Scope[] = [
+          {
+            name: 'Locals',
+            variablesReference: this.reference(() => this.locals(frame)),
+            expensive: false,
+          },
+          {
+            name: 'Pending Tool Calls',
+            variablesReference: this.reference(() => this.pendingCalls()),
+            expensive: false,
+          },
+        ];
+        return { scopes };
+      }
+      case 'variables': {
+        const { variablesReference } = args as { variablesReference: number };
+        const children = this.references.get(variablesReference);
+        return { variables: children ? children() : [] };
+      }
+      case 'continue':
+        this.references.clear();
+        this.debug?.continue();
+        return { allThreadsContinued: true };
+      case 'next':
+        this.references.clear();
+        this.debug?.next();
+        return undefined;
+      case 'stepIn':
+        this.references.clear();
+        this.debug?.stepIn();
+        return undefined;
+      case 'stepOut':
+        this.references.clear();
+        this.debug?.stepOut();
+        return undefined;
+      case 'pause':
+        this.debug?.pause();
+        return undefined;
+      case 'disconnect':
+      case 'terminate':
+        return undefined;
+      default:
+        throw new Error(`Unsupported request: ${command}`);
+    }
+  }
+
+  /**
+   * Start the script once it has been launched and the client has sent
+   * its breakpoints
+   */
+  private runWhenReady(): void {
+    if (this.running || !this.configured || !this.debug) {
+      return;
+    }
+    this.running = true;
+    const output = (text: string, category: 'stdout' | 'stderr') =>
+      this.sendEvent('output', { category, output: `${text}\n` });
+    this.launch(this.program!, this.debug, output, this.serverManager)
+      .catch((error: unknown) => {
+        const message = error instanceof Error ? error.message : String(error);
+        output(message, 'stderr');
+        return 1;
+      })
+      .then(exitCode => {
+        this.sendEvent('exited', { exitCode });
+        this.sendEvent('terminated');
+      });
+  }
+
+  private frames(): DebugFrame[] {
+    return this.debug?.frames() ?? [];
+  }
+
+  private locals(frame: DebugFrame): Variable[] {
+    const variables = Object.entries(frame.variables()).map(([name, value]) =>
+      this.variable(name, value)
+    );
+    if (frame.returned) {
+      const { tool, value } = frame.returned;
+      variables.unshift(this.variable(`${tool} returned`, value));
+    }
+    return variables;
+  }
+
+  private pendingCalls(): Variable[] {
+    const now = Date.now();
+    return (this.debug?.pendingCalls() ?? []).map(call =>
+      this.variable(
+        `${call.tool} #${call.id}`,
+        call.input,
+        `waiting for ${now - call.startedAt} ms`
+      )
+    );
+  }
+
+  /**
+   * A variable, whose properties or elements can be expanded
+   */
+  private variable(name: string, value: unknown, display?: string): Variable {
+    const expandable = typeof value === 'object' && value !== null;
+    return {
+      name,
+      value: display ?? inspect(value, { depth: 0, breakLength: Infinity }),
+      variablesReference: expandable
+        ? this.reference(() =>
+            Object.entries(value).map(([key, child]) =>
+              this.variable(key, child)
+            )
+          )
+        : 0,
+    };
+  }
+
+  private reference(children: () => Variable[]): number {
+    const reference = this.nextReference++;
+    this.references.set(reference, children);
+    return reference;
+  }
+
+  private respond(
+    request: RequestMessage,
+    result: { success: boolean; message?: string; body?: unknown }
+  ): void {
+    this.connection.send({
+      seq: this.seq++,
+      type: 'response',
+      request_seq: request.seq,
+      command: request.command,
+      ...result,
+    });
+  }
+
+  private sendEvent(event: string, body?: unknown): void {
+    this.connection.send({ seq: this.seq++, type: 'event', event, body });
+  }
+}
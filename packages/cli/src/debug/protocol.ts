@@ -0,0 +1,63 @@
+// The subset of the Debug Adapter Protocol used by mcps debug
+
+export interface RequestMessage {
+  seq: number;
+  type: 'request';
+  command: string;
+  arguments?: unknown;
+}
+
+export interface ResponseMessage {
+  seq: number;
+  type: 'response';
+  request_seq: number;
+  command: string;
+  success: boolean;
+  message?: string;
+  body?: unknown;
+}
+
+export interface EventMessage {
+  seq: number;
+  type: 'event';
+  event: string;
+  body?: unknown;
+}
+
+export type DebugMessage = RequestMessage | ResponseMessage | EventMessage;
+
+export interface LaunchArguments {
+  /** Path of the .mcps script to run */
+  program: string;
+  /** Pause before the first statement */
+  stopOnEntry?: boolean;
+}
+
+export interface SetBreakpointsArguments {
+  source: { path?: string };
+  breakpoints?: { line: number }[];
+}
+
+export interface StackFrame {
+  id: number;
+  name: string;
+  source: { name: string; path: string };
+  line: number;
+  column: number;
+}
+
+export interface Scope {
+  name: string;
+  variablesReference: number;
+  expensive: boolean;
+}
+
+export interface Variable {
+  name: string;
+  value: string;
+  /** Reference to the children of an object or array, 0 for none */
+  variablesReference: number;
+}
+
+/** The only thread: parallel branches pause and step together */
+export const THREAD_ID = 1;
@@ -0,0 +1,242 @@
+// Reference pages of scripts, for mcps doc
+//
+// A page is built as a list of blocks first, which are then written as
+// Markdown or HTML, so both formats document the same things. Text in
+// blocks marks code with backticks, as Markdown does.
+import { basename } from 'path';
+import { escapeHtml, type ScriptDocs } from '@mcpscript/transpiler';
+
+export type DocFormat = 'markdown' | 'html';
+
+/**
+ * Part of a page
+ */
+export type DocBlock =
+  | { kind: 'heading'; level: 1 | 2 | 3; text: string }
+  /** A doc comment, written as its author wrote it */
+  | { kind: 'doc'; text: string }
+  | { kind: 'list'; items: string[] }
+  | { kind: 'table'; header: string[]; rows: string[][] };
+
+const code = (text: string) => `\`${text}\``;
+
+function codeList(items: string[]): string {
+  return items.map(code).join(', ');
+}
+
+function mcpToolsItem(tools: string[]): string {
+  return `MCP tools: ${tools.length > 0 ? codeList(tools) : 'none'}`;
+}
+
+/**
+ * The blocks documenting one script
+ */
+export function docBlocks(file: string, docs: ScriptDocs): DocBlock[] {
+  const blocks: DocBlock[] = [
+    { kind: 'heading', level: 1, text: basename(file) },
+  ];
+  if (docs.doc) {
+    blocks.push({ kind: 'doc', text: docs.doc });
+  }
+  blocks.push({ kind: 'list', items: [mcpToolsItem(docs.mcpTools)] });
+
+  if (docs.tools.length > 0) {
+    blocks.push({ kind: 'heading', level: 2, text: 'Tools' });
+    for (const tool of docs.tools) {
+      blocks.push({ kind: 'heading', level: 3, text: code(tool.signature) });
+      if (tool.doc) {
+        blocks.push({ kind: 'doc', text: tool.doc });
+      }
+      if (tool.parameters.length > 0) {
+        // Descriptions get a column when the tool's comment has any
+        const described = tool.parameters.some(p => p.doc);
+        blocks.push({
+          kind: 'table',
+          header: [
+            'Parameter',
+            'Type',
+            'Required',
+            ...(described ? ['Description'] : []),
+          ],
+          rows: tool.parameters.map(p => [
+            code(p.name),
+            code(p.type ?? 'any'),
+            p.default !== undefined
+              ? `no (default ${code(p.default)})`
+              : p.optional
+                ? 'no'
+                : 'yes',
+            ...(described ? [p.doc ?? ''] : []),
+          ]),
+        });
+      }
+      blocks.push({
+        kind: 'list',
+        items: [
+          `Returns: ${code(tool.returnType ?? 'any')}`,
+          mcpToolsItem(tool.mcpTools),
+        ],
+      });
+    }
+  }
+
+  if (docs.agents.length > 0) {
+    blocks.push({ kind: 'heading', level: 2, text: 'Agents' });
+    for (const agent of docs.agents) {
+      blocks.push({ kind: 'heading', level: 3, text: agent.name });
+      if (agent.doc) {
+        blocks.push({ kind: 'doc', text: agent.doc });
+      }
+      const items = agent.model ? [`Model: ${code(agent.model)}`] : [];
+      if (agent.delegates.length > 0) {
+        items.push(`Delegates to: ${codeList(agent.delegates)}`);
+      }
+      if (agent.tools.length > 0) {
+        items.push(`Tools: ${codeList(agent.tools)}`);
+      }
+      items.push(mcpToolsItem(agent.mcpTools));
+      blocks.push({ kind: 'list', items });
+    }
+  }
+
+  if (docs.servers.length > 0) {
+    blocks.push({ kind: 'heading', level: 2, text: 'MCP Servers' });
+    for (const server of docs.servers) {
+      blocks.push({ kind: 'heading', level: 3, text: server.name });
+      if (server.doc) {
+        blocks.push({ kind: 'doc', text: server.doc });
+      }
+      const items = server.target ? [`Server: ${code(server.target)}`] : [];
+      const used = server.tools.includes('*')
+        ? 'any'
+        : codeList(server.tools) || 'none';
+      items.push(`Tools used: ${used}`);
+      blocks.push({ kind: 'list', items });
+    }
+  }
+
+  if (docs.models.length > 0) {
+    blocks.push({ kind: 'heading', level: 2, text: 'Models' });
+    for (const model of docs.models) {
+      blocks.push({ kind: 'heading', level: 3, text: model.name });
+      if (model.doc) {
+        blocks.push({ kind: 'doc', text: model.doc });
+      }
+      const items = [
+        model.provider && `Provider: ${code(model.provider)}`,
+        model.model && `Model: ${code(model.model)}`,
+      ].filter((item): item is string => Boolean(item));
+      if (items.length > 0) {
+        blocks.push({ kind: 'list', items });
+      }
+    }
+  }
+  return blocks;
+}
+
+/**
+ * The blocks of an index of pages, each with the first line of its
+ * script's comment
+ */
+export function indexBlocks(
+  pages: { file: string; link: string; docs: ScriptDocs }[]
+): DocBlock[] {
+  return [
+    { kind: 'heading', level: 1, text: 'Scripts' },
+    {
+      kind: 'list',
+      items: pages.map(({ file, link, docs }) => {
+        const summary = docs.doc?.split('\n')[0];
+        const name = `[${basename(file)}](${link})`;
+        return summary ? `${name}: ${summary}` : name;
+      }),
+    },
+  ];
+}
+
+function markdownCell(text: string): string {
+  return text.replace(/\|/g, '\\|');
+}
+
+export function renderMarkdown(blocks: DocBlock[]): string {
+  const parts = blocks.map(block => {
+    switch (block.kind) {
+      case 'heading':
+        return `${'#'.repeat(block.level)} ${block.text}`;
+      case 'doc':
+        return block.text;
+      case 'list':
+        return block.items.map(item => `- ${item}`).join('\n');
+      case 'table':
+        return [
+          block.header,
+          block.header.map(() => '---'),
+          ...block.rows,
+        ]
+          .map(row => `| ${row.map(markdownCell).join(' | ')} |`)
+          .join('\n');
+    }
+  });
+  return `${parts.join('\n\n')}\n`;
+}
+
+/**
+ * HTML of text with `code` spans and [links](target)
+ */
+function inlineHtml(text: string): string {
+  return escapeHtml(text)
+    .replace(/`([^`]+)`/g, '<code>$1</code>')
+    .replace(/\[([^\]]+)\]\(([^)]+)\)/g, '<a href="$2">$1</a>');
+}
+
+const STYLE = [
+  'body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }',
+  'code { font-family: ui-monospace, monospace; background: #f3f3f3; padding: 0 0.2em; }',
+  'table { border-collapse: collapse; }',
+  'th, td { border: 1px solid #ddd; padding: 0.2em 0.6em; text-align: left; }',
+].join('\n');
+
+export function renderHtml(title: string, blocks: DocBlock[]): string {
+  const body = blocks.map(block => {
+    switch (block.kind) {
+      case 'heading':
+        return `<h${block.level}>${inlineHtml(block.text)}</h${block.level}>`;
+      case 'doc':
+        // Blank lines separate the paragraphs of a comment
+        return block.text
+          .split(/\n\s*\n/)
+          .map(paragraph => `<p>${inlineHtml(paragraph)}</p>`)
+          .join('\n');
+      case 'list':
+        return [
+          '<ul>',
+          ...block.items.map(item => `<li>${inlineHtml(item)}</li>`),
+          '</ul>',
+        ].join('\n');
+      case 'table':
+        return [
+          '<table>',
+          `<tr>${block.header.map(cell => `<th>${inlineHtml(cell)}</th>`).join('')}</tr>`,
+          ...block.rows.map(
+            row =>
+              `<tr>${row.map(cell => `<td>${inlineHtml(cell)}</td>`).join('')}</tr>`
+          ),
+          '</table>',
+        ].join('\n');
+    }
+  });
+  return [
+    '<!DOCTYPE html>',
+    '<html>',
+    '<head>',
+    '<meta charset="utf-8">',
+    `<title>${escapeHtml(title)}</title>`,
+    `<style>\n${STYLE}\n</style>`,
+    '</head>',
+    '<body>',
+    ...body,
+    '</body>',
+    '</html>',
+    '',
+  ].join('\n');
+}
@@ -0,0 +1,528 @@
+// Evaluation suites of agents, for mcps eval
+//
+// A suite is a JSON file (*.eval.json) naming a script, one of its agents
+// and scenarios to run the agent in. A scenario gives the agent's input,
+// the responses of the mock servers standing in for the script's MCP
+// servers, and how the agent's reply is scored: checks of its text and of
+// the tools the agent called, and a rubric a model of the script grades
+// the reply by. Models do not answer the same way every time, so each
+// scenario runs several times and the suite reports how many runs passed.
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import {
+  checkTypes,
+  createFileLoader,
+  generateCodeUnsafe,
+  loadProgram,
+  validateStatements,
+  type ExpressionStatement,
+  type Statement,
+} from '@mcpscript/transpiler';
+import {
+  executeInVM,
+  MockServers,
+  type ModelProviderRegistry,
+} from '@mcpscript/runtime';
+import { loadProjectConfig, moduleSearchPaths } from './config.js';
+import { offlineMode } from './offline.js';
+import { findToolsLock, readToolsLock } from './tools-lock.js';
+import { mockSchemas } from './commands/test.js';
+import { replayProgram } from './transcripts.js';
+
+export const EVAL_FILE_SUFFIX = '.eval.json';
+
+/** Score a judged reply needs when its scenario sets none */
+export const DEFAULT_JUDGE_THRESHOLD = 0.7;
+
+/**
+ * What a mock tool does when called: return a value or fail with a message
+ */
+export type MockBehavior = { returns: unknown } | { fails: string };
+
+/**
+ * Checks of an agent's reply and of the tools it called
+ */
+export interface EvalExpectations {
+  /** Texts the reply must contain */
+  contains?: string[];
+  /** Texts the reply must not contain */
+  notContains?: string[];
+  /** Regular expression the reply must match */
+  matches?: string;
+  /** Tools, as "server.tool", the agent must call */
+  calls?: string[];
+  /** Tools the agent must not call */
+  noCalls?: string[];
+}
+
+/**
+ * A rubric a model grades the reply by, with a score from 0 to 1
+ */
+export interface EvalJudge {
+  rubric: string;
+  /** Model of the script that grades (default: the suite's judgeModel) */
+  model?: string;
+  /** Lowest passing score (default: 0.7) */
+  threshold?: number;
+}
+
+export interface EvalScenario {
+  name: string;
+  /** Message the agent is run with */
+  input: string;
+  /** Behavior of the mock tools, by "server.tool" */
+  mocks?: Record<string, MockBehavior>;
+  expect?: EvalExpectations;
+  judge?: EvalJudge;
+}
+
+export interface EvalSuite {
+  /** Script declaring the agent, relative to the suite */
+  script: string;
+  agent: string;
+  /** Runs of each scenario (default: 1) */
+  runs?: number;
+  /** Model of the script that grades rubrics */
+  judgeModel?: string;
+  scenarios: EvalScenario[];
+}
+
+function isStringArray(value: unknown): value is string[] {
+  return Array.isArray(value) && value.every(item => typeof item === 'string');
+}
+
+function isObject(value: unknown): value is Record<string, unknown> {
+  return typeof value === 'object' && value !== null && !Array.isArray(value);
+}
+
+/**
+ * Check the shape of a parsed suite, naming the first invalid field
+ */
+export function validateEvalSuite(value: unknown): EvalSuite {
+  if (!isObject(value)) {
+    throw new Error('suite must be a JSON object');
+  }
+  const { script, agent, runs, judgeModel, scenarios } = value;
+  if (typeof script !== 'string') {
+    throw new Error('"script" must be a string');
+  }
+  if (typeof agent !== 'string') {
+    throw new Error('"agent" must be a string');
+  }
+  if (
+    runs !== undefined &&
+    !(typeof runs === 'number' && Number.isInteger(runs) && runs > 0)
+  ) {
+    throw new Error('"runs" must be a positive integer');
+  }
+  if (judgeModel !== undefined && typeof judgeModel !== 'string') {
+    throw new Error('"judgeModel" must be a string');
+  }
+  if (!Array.isArray(scenarios) || scenarios.length === 0) {
+    throw new Error('"scenarios" must be a non-empty array');
+  }
+
+  scenarios.forEach((scenario: unknown, index) => {
+    const field = `scenarios[${index}]`;
+    if (!isObject(scenario)) {
+      throw new Error(`"${field}" must be an object`);
+    }
+    const { name, input, mocks, expect, judge } = scenario;
+    if (typeof name !== 'string') {
+      throw new Error(`"${field}.name" must be a string`);
+    }
+    if (typeof input !== 'string') {
+      throw new Error(`"${field}.input" must be a string`);
+    }
+
+    if (mocks !== undefined) {
+      if (!isObject(mocks)) {
+        throw new Error(`"${field}.mocks" must be an object`);
+      }
+      for (const [tool, behavior] of Object.entries(mocks)) {
+        const valid =
+          isObject(behavior) &&
+          Object.keys(behavior).length === 1 &&
+          ('returns' in behavior || typeof behavior.fails === 'string');
+        if (!valid) {
+          throw new Error(
+            `"${field}.mocks.${tool}" must be { "returns": <value> } or { "fails": <message> }`
+          );
+        }
+      }
+    }
+
+    if (expect !== undefined) {
+      if (!isObject(expect)) {
+        throw new Error(`"${field}.expect" must be an object`);
+      }
+      for (const list of ['contains', 'notContains', 'calls', 'noCalls']) {
+        if (expect[list] !== undefined && !isStringArray(expect[list])) {
+          throw new Error(
+            `"${field}.expect.${list}" must be an array of strings`
+          );
+        }
+      }
+      if (expect.matches !== undefined) {
+        try {
+          if (typeof expect.matches !== 'string') {
+            throw new TypeError();
+          }
+          new RegExp(expect.matches);
+        } catch {
+          throw new Error(
+            `"${field}.expect.matches" must be a regular expression`
+          );
+        }
+      }
+    }
+
+    if (judge !== undefined) {
+      if (!isObject(judge) || typeof judge.rubric !== 'string') {
+        throw new Error(`"${field}.judge" must be an object with a rubric`);
+      }
+      if (judge.model === undefined && judgeModel === undefined) {
+        throw new Error(
+          `"${field}.judge.model" must be set when the suite has no judgeModel`
+        );
+      }
+      if (judge.model !== undefined && typeof judge.model !== 'string') {
+        throw new Error(`"${field}.judge.model" must be a string`);
+      }
+      const { threshold } = judge;
+      if (
+        threshold !== undefined &&
+        !(typeof threshold === 'number' && threshold >= 0 && threshold <= 1)
+      ) {
+        throw new Error(
+          `"${field}.judge.threshold" must be a number from 0 to 1`
+        );
+      }
+    }
+  });
+
+  return value as unknown as EvalSuite;
+}
+
+/**
+ * How the reply fails the checks of a scenario; empty when it passes
+ */
+export function checkExpectations(
+  expectations: EvalExpectations,
+  reply: string,
+  calls: string[]
+): string[] {
+  const failures: string[] = [];
+  for (const text of expectations.contains ?? []) {
+    if (!reply.includes(text)) {
+      failures.push(`reply does not contain ${JSON.stringify(text)}`);
+    }
+  }
+  for (const text of expectations.notContains ?? []) {
+    if (reply.includes(text)) {
+      failures.push(`reply contains ${JSON.stringify(text)}`);
+    }
+  }
+  if (
+    expectations.matches !== undefined &&
+    !new RegExp(expectations.matches).test(reply)
+  ) {
+    failures.push(`reply does not match ${expectations.matches}`);
+  }
+  for (const tool of expectations.calls ?? []) {
+    if (!calls.includes(tool)) {
+      failures.push(`${tool} was not called`);
+    }
+  }
+  for (const tool of expectations.noCalls ?? []) {
+    if (calls.includes(tool)) {
+      failures.push(`${tool} was called`);
+    }
+  }
+  return failures;
+}
+
+/**
+ * The part of a model the judge uses
+ */
+export interface JudgeModel {
+  chat(params: {
+    messages: Array<{ role: 'user'; content: string }>;
+  }): Promise<{ message: { content: unknown } }>;
+}
+
+function messageText(content: unknown): string {
+  if (typeof content === 'string') {
+    return content;
+  }
+  return Array.isArray(content)
+    ? content.map(part => (part.type === 'text' ? part.text : '')).join('')
+    : '';
+}
+
+/**
+ * Have a model grade a reply by a rubric
+ */
+export async function judgeReply(
+  llm: JudgeModel,
+  rubric: string,
+  input: string,
+  reply: string
+): Promise<{ score: number; reason: string }> {
+  const prompt = [
+    'You grade the reply of an AI agent to a task by a rubric.',
+    `Task:\n${input}`,
+    `Reply:\n${reply}`,
+    `Rubric:\n${rubric}`,
+    'Answer with JSON only, in the form {"score": <number from 0 to 1>, "reason": "<one sentence>"}.',
+  ].join('\n\n');
+  const response = await llm.chat({
+    messages: [{ role: 'user', content: prompt }],
+  });
+  const answer = messageText(response.message.content);
+  const json = answer.match(/\{[\s\S]*\}/)?.[0];
+  let grade: { score?: unknown; reason?: unknown } = {};
+  try {
+    grade = json ? JSON.parse(json) : {};
+  } catch {
+    // Reported as an answer without a score below
+  }
+  if (typeof grade.score !== 'number') {
+    throw new Error(`judge gave no score: ${answer.trim()}`);
+  }
+  return {
+    score: grade.score,
+    reason: typeof grade.reason === 'string' ? grade.reason : '',
+  };
+}
+
+export interface EvalRunResult {
+  passed: boolean;
+  /** The agent's final reply */
+  reply: string;
+  /** Tools the agent called, as "server.tool", in order */
+  calls: string[];
+  /** Why the run failed */
+  failures: string[];
+  /** Score the judge gave, for scenarios with a rubric */
+  score?: number;
+  /** Milliseconds the run took */
+  duration: number;
+}
+
+export interface EvalScenarioResult {
+  name: string;
+  runs: EvalRunResult[];
+}
+
+export interface EvalSuiteResult {
+  file: string;
+  agent: string;
+  scenarios: EvalScenarioResult[];
+  /** Why the suite could not run at all */
+  error?: string;
+}
+
+export interface EvalRunOptions {
+  /** Timeout of each run in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** Providers of the script's models (default: the built-in ones) */
+  modelProviders?: ModelProviderRegistry;
+}
+
+/** Variables the evaluated program leaves its results in */
+const REPLY_VARIABLE = '__evalReply';
+const JUDGE_VARIABLE = '__evalJudge';
+
+/**
+ * The declarations of a script, a run of the agent on the scenario's input
+ * and, for scenarios with a rubric, the judging model, each kept in a
+ * variable of the context
+ */
+function evalProgram(
+  statements: Statement[],
+  agent: string,
+  file: string,
+  judgeModel: string | undefined
+): Statement[] {
+  const program = replayProgram(statements, agent, file);
+  const run = program.pop() as ExpressionStatement;
+  program.push({
+    type: 'assignment',
+    target: { type: 'identifier', name: REPLY_VARIABLE },
+    value: run.expression,
+  });
+  if (judgeModel !== undefined) {
+    if (
+      !statements.some(
+        stmt => stmt.type === 'model_declaration' && stmt.name === judgeModel
+      )
+    ) {
+      throw new Error(`Model ${judgeModel} is not declared in ${file}`);
+    }
+    program.push({
+      type: 'assignment',
+      target: { type: 'identifier', name: JUDGE_VARIABLE },
+      value: { type: 'identifier', name: judgeModel },
+    });
+  }
+  return program;
+}
+
+/**
+ * Run the scenarios of a suite file
+ */
+export async function runEvalSuite(
+  file: string,
+  options: EvalRunOptions = {}
+): Promise<EvalSuiteResult> {
+  let suite: EvalSuite;
+  try {
+    suite = validateEvalSuite(JSON.parse(await readFile(file, 'utf-8')));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    return {
+      file,
+      agent: '',
+      scenarios: [],
+      error: `Invalid suite: ${reason}`,
+    };
+  }
+  const result: EvalSuiteResult = { file, agent: suite.agent, scenarios: [] };
+
+  let ast: Statement[];
+  const script = resolve(dirname(file), suite.script);
+  const loaded = await loadProjectConfig(dirname(script));
+  try {
+    const source = await readFile(script, 'utf-8');
+    const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+    ast = loadProgram(script, source, loader);
+    checkTypes(ast);
+    validateStatements(ast);
+  } catch (error) {
+    result.error = error instanceof Error ? error.message : String(error);
+    return result;
+  }
+  const lockPath = findToolsLock(dirname(script));
+  const lock = lockPath ? await readToolsLock(lockPath) : undefined;
+
+  for (const scenario of suite.scenarios) {
+    const runs: EvalRunResult[] = [];
+    for (let run = 0; run < (suite.runs ?? 1); run++) {
+      const started = Date.now();
+      const mocks = new MockServers(mockSchemas(ast, lock?.servers));
+      for (const [tool, behavior] of Object.entries(scenario.mocks ?? {})) {
+        if ('fails' in behavior) {
+          mocks.fail(tool, behavior.fails);
+        } else {
+          mocks.respond(tool, behavior.returns);
+        }
+      }
+
+      const judge = scenario.judge;
+      const judgeModel = judge && (judge.model ?? suite.judgeModel);
+      let reply = '';
+      let score: number | undefined;
+      const failures: string[] = [];
+      try {
+        const program = evalProgram(ast, suite.agent, script, judgeModel);
+        const context = await executeInVM(generateCodeUnsafe(program), {
+          timeout: options.timeout,
+          mocks,
+          replay: [{ role: 'user', content: scenario.input }],
+          modelProviders: options.modelProviders,
+          redaction: loaded.config.redaction,
+          project: loaded.config.project,
+          offline: offlineMode(),
+        });
+        const conversation = context[REPLY_VARIABLE] as { result(): string };
+        reply = conversation.result();
+        const calls = mocks.calls().map(call => call.tool);
+        failures.push(
+          ...checkExpectations(scenario.expect ?? {}, reply, calls)
+        );
+
+        if (judge) {
+          const grade = await judgeReply(
+            context[JUDGE_VARIABLE] as JudgeModel,
+            judge.rubric,
+            scenario.input,
+            reply
+          );
+          score = grade.score;
+          const threshold = judge.threshold ?? DEFAULT_JUDGE_THRESHOLD;
+          if (score < threshold) {
+            failures.push(
+              `judge scored ${score} (below ${threshold})${grade.reason && `: ${grade.reason}`}`
+            );
+          }
+        }
+      } catch (error) {
+        failures.push(error instanceof Error ? error.message : String(error));
+      }
+      runs.push({
+        passed: failures.length === 0,
+        reply,
+        calls: mocks.calls().map(call => call.tool),
+        failures,
+        ...(score !== undefined && { score }),
+        duration: Date.now() - started,
+      });
+    }
+    result.scenarios.push({ name: scenario.name, runs });
+  }
+  return result;
+}
+
+/**
+ * Runs that passed out of all runs of the given results
+ */
+export function passRate(results: EvalSuiteResult[]): {
+  passed: number;
+  total: number;
+} {
+  const runs = results.flatMap(result =>
+    result.scenarios.flatMap(scenario => scenario.runs)
+  );
+  return {
+    passed: runs.filter(run => run.passed).length,
+    total: runs.length,
+  };
+}
+
+function percent(passed: number, total: number): string {
+  return `${total === 0 ? 0 : Math.round((passed / total) * 100)}%`;
+}
+
+/**
+ * A report of suite results as text: each scenario with the runs that
+ * passed and why the others failed, and the pass rate of all runs
+ */
+export function formatEvalReport(results: EvalSuiteResult[]): string {
+  const lines: string[] = [];
+  for (const result of results) {
+    if (result.error) {
+      lines.push(`ERROR ${result.file}`, `    ${result.error}`, '');
+      continue;
+    }
+    lines.push(`${result.file} (agent ${result.agent})`);
+    for (const scenario of result.scenarios) {
+      const passed = scenario.runs.filter(run => run.passed).length;
+      const status = passed === scenario.runs.length ? 'PASS' : 'FAIL';
+      lines.push(
+        `  ${status} ${scenario.name} (${passed}/${scenario.runs.length})`
+      );
+      scenario.runs.forEach((run, index) => {
+        for (const failure of run.failures) {
+          lines.push(`      run ${index + 1}: ${failure}`);
+        }
+      });
+    }
+    const { passed, total } = passRate([result]);
+    lines.push(`  ${passed}/${total} runs passed (${percent(passed, total)})`);
+    lines.push('');
+  }
+  const { passed, total } = passRate(results);
+  lines.push(`Pass rate: ${passed}/${total} runs (${percent(passed, total)})`);
+  return `${lines.join('\n')}\n`;
+}
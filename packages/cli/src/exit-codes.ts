@@ -0,0 +1,63 @@
+// Exit codes of runs and checks that got into trouble without failing
+//
+// A run goes on when an MCP tool answers with an error result or items of
+// a parallel map fail, and `mcps check` passes with lint warnings. The
+// "exitCodes" of .mcpsrc decide whether they fail the run after all:
+//
+//   { "exitCodes": { "errorResults": 3, "partialFailures": 2 } }
+//
+// A run that had error results then exits with 3, and mcpsd and mcps serve
+// count it as failed. Unset and 0 codes leave such runs succeeded.
+import type { RunIssueKind, RunOutcome } from '@mcpscript/runtime';
+
+/**
+ * Exit codes for the issues that do not fail a run or check by themselves
+ */
+export interface ExitCodesConfig {
+  /** When MCP tools answered the script's calls with error results */
+  errorResults?: number;
+  /** When items of the script's parallel maps failed */
+  partialFailures?: number;
+  /** When `mcps check` found lint warnings but no errors */
+  lintWarnings?: number;
+}
+
+export const EXIT_CODE_SETTINGS = [
+  'errorResults',
+  'partialFailures',
+  'lintWarnings',
+] as const;
+
+/**
+ * Issues of a run by their setting, in the order they take precedence
+ */
+const RUN_ISSUES: Array<[keyof ExitCodesConfig, RunIssueKind]> = [
+  ['errorResults', 'errorResult'],
+  ['partialFailures', 'partialFailure'],
+];
+
+/**
+ * Why a run that finished failed by the project's exit codes
+ */
+export interface OutcomeFailure {
+  code: number;
+  message: string;
+}
+
+/**
+ * Whether a run that finished failed by the project's exit codes, for the
+ * first kind of issue it had that is given one
+ */
+export function outcomeFailure(
+  outcome: RunOutcome,
+  exitCodes: ExitCodesConfig = {}
+): OutcomeFailure | undefined {
+  for (const [setting, kind] of RUN_ISSUES) {
+    const code = exitCodes[setting] ?? 0;
+    const issues = outcome.of(kind);
+    if (code !== 0 && issues.length > 0) {
+      return { code, message: issues.map(issue => issue.message).join('\n') };
+    }
+  }
+  return undefined;
+}
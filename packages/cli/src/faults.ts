@@ -0,0 +1,27 @@
+// Fault plans of mcps run --faults and mcps test --faults
+//
+// A fault plan is JSON naming the servers whose tool calls misbehave and
+// how: delayed, dropped or malformed responses and connections closing
+// mid-call. It is meant for test and staging runs that check a script's
+// retries and compensation hold up.
+import { readFile } from 'fs/promises';
+import { checkFaultPlan, type FaultPlan } from '@mcpscript/runtime';
+
+/**
+ * Read and check a fault plan file
+ */
+export async function loadFaultPlan(path: string): Promise<FaultPlan> {
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(await readFile(path, 'utf-8'));
+  } catch (error) {
+    throw new Error(
+      `Cannot read fault plan ${path}: ${error instanceof Error ? error.message : String(error)}`
+    );
+  }
+  try {
+    return checkFaultPlan(parsed);
+  } catch (error) {
+    throw new Error(`Invalid fault plan ${path}: ${(error as Error).message}`);
+  }
+}
@@ -0,0 +1,264 @@
+// Go bindings for MCP tools, generated from their input schemas
+import type { JSONSchema, ToolSchema } from '@mcpscript/runtime';
+
+export interface GoBindingOptions {
+  /** Go package name (default: "tools") */
+  packageName?: string;
+}
+
+/** Words Go spells in capitals inside identifiers */
+const INITIALISMS = new Set([
+  'API',
+  'HTML',
+  'HTTP',
+  'HTTPS',
+  'ID',
+  'IP',
+  'JSON',
+  'SQL',
+  'URI',
+  'URL',
+  'UUID',
+  'XML',
+]);
+
+/**
+ * An exported Go identifier for a tool, server or parameter name such as
+ * "read_file", "readFile" or "user-id"
+ */
+export function goName(name: string): string {
+  const words = name
+    .replace(/([a-z0-9])([A-Z])/g, '$1 $2')
+    .replace(/([A-Z]+)([A-Z][a-z])/g, '$1 $2')
+    .split(/[^A-Za-z0-9]+/)
+    .filter(Boolean);
+  const identifier = words
+    .map(word => {
+      const upper = word.toUpperCase();
+      return INITIALISMS.has(upper)
+        ? upper
+        : upper[0] + word.slice(1).toLowerCase();
+    })
+    .join('');
+  if (!identifier) {
+    return 'X';
+  }
+  return /^[0-9]/.test(identifier) ? `X${identifier}` : identifier;
+}
+
+/**
+ * A Go comment, one "//" line per line of text
+ */
+function goComment(text: string | undefined, indent = ''): string[] {
+  if (!text?.trim()) {
+    return [];
+  }
+  return text
+    .trim()
+    .split('\n')
+    .map(line => `${indent}// ${line.trimEnd()}`.trimEnd());
+}
+
+interface GoField {
+  comment: string[];
+  name: string;
+  type: string;
+  tag: string;
+}
+
+/**
+ * Emits the declarations of one file, naming nested types after the path
+ * to them
+ */
+class GoWriter {
+  private readonly declarations: string[][] = [];
+
+  /**
+   * The Go type of a schema; object schemas with properties become
+   * structs named `name`
+   */
+  typeOf(schema: JSONSchema, name: string): string {
+    const types = (
+      Array.isArray(schema.type) ? schema.type : [schema.type]
+    ).filter(type => type !== undefined && type !== 'null');
+    if (types.length !== 1) {
+      return 'any';
+    }
+    switch (types[0]) {
+      case 'string':
+        return 'string';
+      case 'integer':
+        return 'int64';
+      case 'number':
+        return 'float64';
+      case 'boolean':
+        return 'bool';
+      case 'array':
+        return `[]${schema.items ? this.typeOf(schema.items, `${name}Item`) : 'any'}`;
+      case 'object':
+        if (schema.properties && Object.keys(schema.properties).length > 0) {
+          this.struct(name, schema);
+          return name;
+        }
+        return 'map[string]any';
+      default:
+        return 'any';
+    }
+  }
+
+  /**
+   * Declare a struct with a field per property
+   * Optional and nullable scalars are pointers, so that zero values are
+   * still sent; every optional field is left out when unset
+   */
+  struct(name: string, schema: JSONSchema, description?: string): void {
+    const required = new Set(schema.required ?? []);
+    const used = new Set<string>();
+    const fields: GoField[] = [];
+    for (const [property, propertySchema] of Object.entries(
+      schema.properties ?? {}
+    )) {
+      let fieldName = goName(property);
+      for (let n = 2; used.has(fieldName); n++) {
+        fieldName = `${goName(property)}${n}`;
+      }
+      used.add(fieldName);
+
+      let type = this.typeOf(propertySchema, `${name}${fieldName}`);
+      const nullable =
+        Array.isArray(propertySchema.type) &&
+        propertySchema.type.includes('null');
+      const optional = !required.has(property);
+      const isReference =
+        type === 'any' || type.startsWith('[]') || type.startsWith('map[');
+      if ((optional || nullable) && !isReference) {
+        type = `*${type}`;
+      }
+      fields.push({
+        comment: goComment(propertySchema.description, '\t'),
+        name: fieldName,
+        type,
+        tag: `\`json:"${property}${optional ? ',omitempty' : ''}"\``,
+      });
+    }
+
+    const lines = goComment(
+      description ?? `${name} is generated from a JSON schema.`
+    );
+    if (fields.length === 0) {
+      lines.push(`type ${name} struct{}`);
+    } else {
+      lines.push(`type ${name} struct {`);
+      // Fields with a comment start a new block, separated by a blank line;
+      // names and types are aligned in columns within a block, as by gofmt
+      const blocks: GoField[][] = [];
+      for (const field of fields) {
+        if (field.comment.length > 0 || blocks.length === 0) {
+          blocks.push([]);
+        }
+        blocks[blocks.length - 1].push(field);
+      }
+      blocks.forEach((block, index) => {
+        if (index > 0) {
+          lines.push('');
+        }
+        const nameWidth = Math.max(...block.map(f => f.name.length));
+        const typeWidth = Math.max(...block.map(f => f.type.length));
+        for (const field of block) {
+          lines.push(
+            ...field.comment,
+            `\t${field.name.padEnd(nameWidth)} ${field.type.padEnd(typeWidth)} ${field.tag}`
+          );
+        }
+      });
+      lines.push('}');
+    }
+    this.declarations.push(lines);
+  }
+
+  add(lines: string[]): void {
+    this.declarations.push(lines);
+  }
+
+  toString(): string {
+    return this.declarations.map(lines => lines.join('\n')).join('\n\n');
+  }
+}
+
+/**
+ * Go source declaring, for each MCP server, a client type with a method per
+ * tool and an input struct per tool
+ * The client sends calls through a ToolCaller the embedding program
+ * provides, so the bindings work with any Go MCP client
+ */
+export function generateGoBindings(
+  servers: Record<string, ToolSchema[]>,
+  options: GoBindingOptions = {}
+): string {
+  const { packageName = 'tools' } = options;
+  const writer = new GoWriter();
+
+  writer.add([
+    '// ToolCaller calls a tool on an MCP server and returns the result.',
+    'type ToolCaller interface {',
+    '\tCallTool(ctx context.Context, server, tool string, input any) (json.RawMessage, error)',
+    '}',
+  ]);
+
+  const serverNames = Object.keys(servers).sort((a, b) => a.localeCompare(b));
+  for (const server of serverNames) {
+    const client = `${goName(server)}Client`;
+    writer.add([
+      `// ${client} calls the tools of the "${server}" MCP server.`,
+      `type ${client} struct {`,
+      '\tcaller ToolCaller',
+      '}',
+    ]);
+    writer.add([
+      `// New${client} returns a client sending calls through caller.`,
+      `func New${client}(caller ToolCaller) *${client} {`,
+      `\treturn &${client}{caller: caller}`,
+      '}',
+    ]);
+
+    const tools = [...servers[server]].sort((a, b) =>
+      a.name.localeCompare(b.name)
+    );
+    const methods = new Set<string>();
+    for (const tool of tools) {
+      let method = goName(tool.name);
+      for (let n = 2; methods.has(method); n++) {
+        method = `${goName(tool.name)}${n}`;
+      }
+      methods.add(method);
+
+      const input = `${goName(server)}${method}Input`;
+      writer.struct(
+        input,
+        { ...tool.inputSchema, type: 'object' },
+        `${input} is the input of the ${server}.${tool.name} tool.`
+      );
+      writer.add([
+        `// ${method} calls the ${tool.name} tool.`,
+        ...(tool.description ? ['//', ...goComment(tool.description)] : []),
+        `func (c *${client}) ${method}(ctx context.Context, input ${input}) (json.RawMessage, error) {`,
+        `\treturn c.caller.CallTool(ctx, ${JSON.stringify(server)}, ${JSON.stringify(tool.name)}, input)`,
+        '}',
+      ]);
+    }
+  }
+
+  return [
+    '// Code generated by mcps generate go. DO NOT EDIT.',
+    '',
+    `package ${packageName}`,
+    '',
+    'import (',
+    '\t"context"',
+    '\t"encoding/json"',
+    ')',
+    '',
+    writer.toString(),
+    '',
+  ].join('\n');
+}
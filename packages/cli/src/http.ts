@@ -0,0 +1,77 @@
+// Helpers shared by the CLI's JSON-over-HTTP services
+import type { IncomingMessage, ServerResponse } from 'http';
+
+/**
+ * An error answered with the given HTTP status, and fields telling callers
+ * more than the message, such as the quota a request went over
+ */
+export class HttpError extends Error {
+  constructor(
+    public readonly status: number,
+    message: string,
+    public readonly details?: Record<string, unknown>
+  ) {
+    super(message);
+  }
+}
+
+/**
+ * Read a request body, refusing bodies larger than the limit
+ */
+export async function readBody(
+  request: IncomingMessage,
+  limit: number
+): Promise<string> {
+  const chunks: Buffer[] = [];
+  let size = 0;
+  for await (const chunk of request as AsyncIterable<Buffer>) {
+    size += chunk.length;
+    if (size > limit) {
+      throw new HttpError(413, 'Request body is too large');
+    }
+    chunks.push(chunk);
+  }
+  return Buffer.concat(chunks).toString('utf-8');
+}
+
+/**
+ * Read and parse a JSON request body
+ * Malformed JSON and bodies rejected by the validator are answered with 400
+ */
+export async function readJson<T>(
+  request: IncomingMessage,
+  limit: number,
+  validate: (value: unknown) => T
+): Promise<T> {
+  const body = await readBody(request, limit);
+  try {
+    return validate(JSON.parse(body));
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new HttpError(400, message);
+  }
+}
+
+export function sendJson(
+  response: ServerResponse,
+  status: number,
+  body: unknown
+): void {
+  response.writeHead(status, { 'Content-Type': 'application/json' });
+  response.end(JSON.stringify(body));
+}
+
+/**
+ * Answer a failed request with its status and an { error } body, with the
+ * details of an HttpError
+ */
+export function sendError(response: ServerResponse, error: unknown): void {
+  const status = error instanceof HttpError ? error.status : 500;
+  const message = error instanceof Error ? error.message : String(error);
+  const details = error instanceof HttpError ? error.details : undefined;
+  if (response.headersSent) {
+    response.end();
+  } else {
+    sendJson(response, status, { error: message, ...details });
+  }
+}
@@ -0,0 +1,80 @@
+// Completed idempotent calls of the runs of mcps run
+//
+// Calls a script marks @idempotent are recorded by key once they complete,
+// so running the script again, or resuming it, skips them. Keys are chosen
+// by each script, so the records are kept per script: one JSON file for
+// each script path, in the user's state directory.
+import { createHash } from 'crypto';
+import { readFile } from 'fs/promises';
+import { homedir } from 'os';
+import { join, resolve } from 'path';
+import type { IdempotencyRecord, IdempotencyStore } from '@mcpscript/runtime';
+
+/**
+ * Where idempotent calls are recorded: $MCPS_IDEMPOTENCY_DIR, or
+ * mcps/idempotency in the user's state directory
+ */
+export function defaultIdempotencyDir(): string {
+  if (process.env.MCPS_IDEMPOTENCY_DIR) {
+    return process.env.MCPS_IDEMPOTENCY_DIR;
+  }
+  const base =
+    process.env.XDG_STATE_HOME || join(homedir(), '.local', 'state');
+  return join(base, 'mcps', 'idempotency');
+}
+
+/**
+ * File the idempotent calls of a script are recorded in, named after a
+ * hash of the script's path
+ */
+export function idempotencyFile(
+  script: string,
+  dir: string = defaultIdempotencyDir()
+): string {
+  const hash = createHash('sha256').update(resolve(script)).digest('hex');
+  return join(dir, `${hash.slice(0, 16)}.json`);
+}
+
+/**
+ * Keeps the idempotent calls of a script in a JSON file of records by key
+ */
+export class FileIdempotencyStore implements IdempotencyStore {
+  private records?: Promise<Record<string, IdempotencyRecord>>;
+  /** Saves in progress, so concurrent calls don't write over each other */
+  private saving: Promise<void> = Promise.resolve();
+
+  constructor(private readonly path: string) {}
+
+  private load(): Promise<Record<string, IdempotencyRecord>> {
+    this.records ??= readFile(this.path, 'utf-8').then(
+      content => JSON.parse(content),
+      error => {
+        if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+          return {};
+        }
+        throw error;
+      }
+    );
+    return this.records;
+  }
+
+  async get(key: string): Promise<IdempotencyRecord | undefined> {
+    const records = await this.load();
+    return Object.hasOwn(records, key) ? records[key] : undefined;
+  }
+
+  async set(key: string, record: IdempotencyRecord): Promise<void> {
+    const records = await this.load();
+    records[key] = record;
+    const save = () => writeFileAtomic(this.path, JSON.stringify(records));
+    this.saving = this.saving.then(save, save);
+    await this.saving;
+  }
+}
+
+/**
+ * The store of the idempotent calls of a script
+ */
+export function idempotencyStore(script: string): IdempotencyStore {
+  return new FileIdempotencyStore(idempotencyFile(script));
+}
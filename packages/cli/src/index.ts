@@ -1,11 +1,202 @@
 // @mcpscript/cli - Command line interface
 import { Command } from 'commander';
-import { runCommand, compileCommand } from './commands/index.js';
-import type { RunOptions, CompileOptions } from './types.js';
+import {
+  LOG_SEVERITIES,
+  TRACE_LEVELS,
+  type LogSeverity,
+  type TraceLevel,
+} from '@mcpscript/runtime';
+import {
+  runCommand,
+  compileCommand,
+  parseCommand,
+  highlightCommand,
+  lspCommand,
+  debugCommand,
+  fmtCommand,
+  checkCommand,
+  testCommand,
+  evalCommand,
+  diffCommand,
+  lockCommand,
+  toolsSyncCommand,
+  signCommand,
+  verifyCommand,
+  generateGoCommand,
+  exportCapabilitiesCommand,
+  refactorCommand,
+  extractPromptsCommand,
+  auditDepsCommand,
+  benchCommand,
+  docCommand,
+  transcriptListCommand,
+  transcriptShowCommand,
+  transcriptReplayCommand,
+  transcriptMocksCommand,
+  runsDiffCommand,
+  daemonCommand,
+  apiCommand,
+  serveCommand,
+  logsCommand,
+} from './commands/index.js';
+import type {
+  RunOptions,
+  CompileOptions,
+  ParseOptions,
+  HighlightOptions,
+  FmtOptions,
+  CheckOptions,
+  TestOptions,
+  EvalOptions,
+  DiffOptions,
+  LockOptions,
+  ToolsSyncOptions,
+  SignOptions,
+  VerifyOptions,
+  GenerateGoOptions,
+  ExportCapabilitiesOptions,
+  RefactorOptions,
+  ExtractPromptsOptions,
+  AuditDepsOptions,
+  BenchOptions,
+  DocOptions,
+  TranscriptShowOptions,
+  TranscriptReplayOptions,
+  TranscriptMocksOptions,
+  RunsDiffOptions,
+  LogsOptions,
+} from './types.js';
+import { DEFAULT_DAEMON_PORT } from './remote/protocol.js';
+import { DEFAULT_API_PORT } from './api/protocol.js';
+import { DEFAULT_SERVE_PORT } from './triggers/protocol.js';
+import { OFFLINE_VARIABLE, offlineMode } from './offline.js';
+import { SEVERITIES, type Severity } from './audit.js';
 import packageJson from '../package.json' with { type: 'json' };
 
 // Re-export types for consumers
-export type { RunOptions, CompileOptions } from './types.js';
+export type {
+  RunOptions,
+  CompileOptions,
+  ParseOptions,
+  HighlightOptions,
+  FmtOptions,
+  CheckOptions,
+  TestOptions,
+  EvalOptions,
+  DiffOptions,
+  LockOptions,
+  ToolsSyncOptions,
+  SignOptions,
+  VerifyOptions,
+  GenerateGoOptions,
+  ExportCapabilitiesOptions,
+  RefactorOptions,
+  ExtractPromptsOptions,
+  AuditDepsOptions,
+  BenchOptions,
+  DocOptions,
+  TranscriptShowOptions,
+  TranscriptReplayOptions,
+  TranscriptMocksOptions,
+  RunsDiffOptions,
+  LogsOptions,
+} from './types.js';
+
+type RunFlags = {
+  timeout: string;
+  remote?: string;
+  trace?: string | true;
+  traceLevel?: string;
+  timeline?: string;
+  as?: string;
+  dryRun?: string | true;
+  resume?: string;
+  policy?: string;
+  faults?: string;
+  matrix?: string;
+  parallel?: string;
+  cache: boolean;
+};
+type DaemonFlags = { host: string; port: string; config?: string };
+type ApiFlags = { host: string; port: string };
+type LogsFlags = {
+  url: string;
+  follow?: boolean;
+  server?: string[];
+  level?: string;
+  json?: boolean;
+};
+type ServeFlags = {
+  mcp?: boolean;
+  http?: boolean;
+  host: string;
+  port: string;
+  queue?: string;
+  timeout: string;
+};
+type CheckFlags = { format: string; cache: boolean };
+type TestFlags = { timeout: string; faults?: string };
+type EvalFlags = {
+  timeout: string;
+  format: string;
+  output?: string;
+  minPassRate: string;
+};
+type DiffFlags = { format: string };
+type ParseFlags = { json?: boolean; ast?: boolean };
+type HighlightFlags = { format: string; theme: string; standalone?: boolean };
+type SignFlags = { key?: string; generateKey?: string };
+type VerifyFlags = { key?: string[] };
+type GenerateGoFlags = { package: string; output?: string };
+type ExportFlags = { format: string };
+type InlineVariableFlags = { at: string; write?: boolean };
+type ExtractWorkflowFlags = { lines: string; name?: string; write?: boolean };
+type MoveDeclarationFlags = { to: string; write?: boolean };
+type ExtractPromptsFlags = { to: string; write?: boolean };
+type LockFlags = { update?: boolean };
+type ToolsSyncFlags = { check?: boolean };
+type AuditDepsFlags = { format: string; failOn: string };
+type BenchFlags = {
+  internal?: boolean;
+  lines: string;
+  time: string;
+  format: string;
+  save?: string;
+  baseline?: string;
+  threshold: string;
+};
+type DocFlags = { format: string; output?: string };
+type TranscriptShowFlags = { format: string };
+type TranscriptReplayFlags = { from: string; timeout: string };
+type TranscriptMocksFlags = { out?: string };
+type RunsDiffFlags = { format: string; timingThreshold: string };
+
+/**
+ * Parse a flag of 1-based numbers joined by a separator, such as 3:14
+ */
+function parseNumbers(
+  value: string,
+  separator: string,
+  flag: string
+): [number, number] {
+  const parts = value.split(separator).map(part => parseInt(part, 10));
+  if (parts.length !== 2 || parts.some(part => isNaN(part) || part < 1)) {
+    console.error(
+      `Error: ${flag} must be two numbers from 1 joined by "${separator}"`
+    );
+    process.exit(1);
+  }
+  return parts as [number, number];
+}
+
+function parsePort(value: string): number {
+  const port = parseInt(value, 10);
+  if (isNaN(port) || port < 0 || port > 65535) {
+    console.error('Error: port must be a number from 0 to 65535');
+    process.exit(1);
+  }
+  return port;
+}
 
 export async function main(args: string[]): Promise<void> {
   const program = new Command();
@@ -13,26 +204,178 @@ export async function main(args: string[]): Promise<void> {
   program
     .name('mcps')
     .description(packageJson.description)
-    .version(packageJson.version);
+    .version(packageJson.version)
+    .option(
+      '--offline',
+      `forbid network access: servers, models and services on other hosts are refused (same as ${OFFLINE_VARIABLE}=1)`
+    )
+    .hook('preAction', () => {
+      // Set for the whole process, so runs in workers are offline too
+      if (program.opts().offline) {
+        process.env[OFFLINE_VARIABLE] = '1';
+      }
+    });
 
   program
-    .command('run <file>')
-    .description('Run a MCP Script file')
+    .command('run <file> [args...]')
+    .description(
+      'Run a MCP Script file; flags after -- are the arguments of its main tool (-- --help lists them)'
+    )
     .option(
       '-t, --timeout <ms>',
       'execution timeout in milliseconds (0 = no timeout)',
       '30000'
     )
-    .action(async (file: string, cmdOptions: { timeout: string }) => {
+    .option(
+      '-r, --remote <url>',
+      'run on an mcpsd executor (token read from MCPS_REMOTE_TOKEN)'
+    )
+    .option(
+      '--trace [exporter]',
+      'record OpenTelemetry traces and metrics: otlp (default) or console'
+    )
+    .option(
+      '--timeline <file>',
+      'write a timeline of the tool calls, retries and model requests of the run: HTML for .html files, a Chrome trace otherwise'
+    )
+    .option(
+      '--trace-level <level>',
+      `which @trace points record their values: ${TRACE_LEVELS.join(', ')} (default: info)`
+    )
+    .option(
+      '--as <role>',
+      'run in a role of .mcpsrc, limiting the tools the script may call'
+    )
+    .option(
+      '--dry-run [format]',
+      'print the servers and tool calls of the run without running it: text (default) or json'
+    )
+    .option(
+      '--resume <run-id>',
+      'continue a failed run from the last statement it finished'
+    )
+    .option(
+      '--policy <file>',
+      'limit the servers, tools, processes, network access and run time of the script with a JSON sandbox policy'
+    )
+    .option(
+      '--faults <file>',
+      'inject latency, dropped responses, malformed JSON and disconnects into tool calls as a JSON fault plan describes'
+    )
+    .option(
+      '--matrix <file>',
+      'run main once per combination of the argument values in a JSON file and print a table of the runs'
+    )
+    .option('--parallel <n>', 'most matrix runs at once', '4')
+    .option(
+      '--no-cache',
+      'parse, check and compile the script afresh, ignoring the run prepared for it before'
+    )
+    .action(async (file: string, args: string[], cmdOptions: RunFlags) => {
       const timeout = parseInt(cmdOptions.timeout, 10);
       if (isNaN(timeout) || timeout < 0) {
         console.error('Error: timeout must be a non-negative number');
         process.exit(1);
       }
 
+      const trace = cmdOptions.trace === true ? 'otlp' : cmdOptions.trace;
+      if (trace !== undefined && trace !== 'otlp' && trace !== 'console') {
+        console.error('Error: trace exporter must be otlp or console');
+        process.exit(1);
+      }
+      if (trace && cmdOptions.remote) {
+        console.error('Error: --trace cannot be used with --remote');
+        process.exit(1);
+      }
+      const traceLevel = cmdOptions.traceLevel as TraceLevel | undefined;
+      if (traceLevel !== undefined && !TRACE_LEVELS.includes(traceLevel)) {
+        console.error(
+          `Error: trace level must be one of ${TRACE_LEVELS.join(', ')}`
+        );
+        process.exit(1);
+      }
+      if (traceLevel && cmdOptions.remote) {
+        console.error('Error: --trace-level cannot be used with --remote');
+        process.exit(1);
+      }
+      if (cmdOptions.timeline && cmdOptions.remote) {
+        console.error('Error: --timeline cannot be used with --remote');
+        process.exit(1);
+      }
+
+      const dryRun = cmdOptions.dryRun === true ? 'text' : cmdOptions.dryRun;
+      if (dryRun !== undefined && dryRun !== 'text' && dryRun !== 'json') {
+        console.error('Error: dry-run format must be text or json');
+        process.exit(1);
+      }
+      if (dryRun && cmdOptions.remote) {
+        console.error('Error: --dry-run cannot be used with --remote');
+        process.exit(1);
+      }
+      if (cmdOptions.resume && (cmdOptions.remote || dryRun)) {
+        console.error(
+          'Error: --resume cannot be used with --remote or --dry-run'
+        );
+        process.exit(1);
+      }
+      if (cmdOptions.policy && cmdOptions.remote) {
+        console.error('Error: --policy cannot be used with --remote');
+        process.exit(1);
+      }
+      if (cmdOptions.faults && (cmdOptions.remote || cmdOptions.matrix)) {
+        console.error(
+          'Error: --faults cannot be used with --remote or --matrix'
+        );
+        process.exit(1);
+      }
+      if (args.length > 0 && cmdOptions.remote) {
+        console.error('Error: script flags cannot be used with --remote');
+        process.exit(1);
+      }
+      if (cmdOptions.remote && offlineMode()) {
+        console.error('Error: --remote cannot be used offline');
+        process.exit(1);
+      }
+      if (
+        cmdOptions.matrix &&
+        (cmdOptions.remote ||
+          dryRun ||
+          cmdOptions.resume ||
+          cmdOptions.timeline)
+      ) {
+        console.error(
+          'Error: --matrix cannot be used with --remote, --dry-run, --resume or --timeline'
+        );
+        process.exit(1);
+      }
+      if (cmdOptions.matrix && args.length > 0) {
+        console.error(
+          'Error: script flags cannot be used with --matrix; put fixed values in the matrix file'
+        );
+        process.exit(1);
+      }
+      const parallel = Number(cmdOptions.parallel);
+      if (!Number.isInteger(parallel) || parallel < 1) {
+        console.error('Error: --parallel must be a positive integer');
+        process.exit(1);
+      }
+
       const options: RunOptions = {
         file,
         timeout: timeout === 0 ? 0 : timeout,
+        remote: cmdOptions.remote,
+        trace,
+        traceLevel,
+        timeline: cmdOptions.timeline,
+        role: cmdOptions.as,
+        dryRun,
+        resume: cmdOptions.resume,
+        policy: cmdOptions.policy,
+        faults: cmdOptions.faults,
+        args,
+        matrix: cmdOptions.matrix,
+        parallel,
+        cache: cmdOptions.cache,
       };
       await runCommand(options);
     });
@@ -47,5 +390,743 @@ export async function main(args: string[]): Promise<void> {
       await compileCommand(options);
     });
 
+  program
+    .command('parse <file>')
+    .description(
+      'Print the syntax tree of a MCP Script file as an S-expression or JSON'
+    )
+    .option('--json', 'print the syntax tree as JSON')
+    .option('--ast', 'print the AST as JSON instead of the syntax tree')
+    .action(async (file: string, cmdOptions: ParseFlags) => {
+      if (cmdOptions.json && cmdOptions.ast) {
+        console.error('Error: --json and --ast cannot be used together');
+        process.exit(1);
+      }
+      const options: ParseOptions = {
+        file,
+        format: cmdOptions.ast ? 'ast' : cmdOptions.json ? 'json' : 'sexp',
+      };
+      await parseCommand(options);
+    });
+
+  program
+    .command('highlight <file>')
+    .description(
+      'Print a MCP Script file with syntax highlighting for a terminal or as HTML'
+    )
+    .option('-f, --format <format>', 'output format: ansi or html', 'ansi')
+    .option('--theme <theme>', 'color theme: dark or light', 'dark')
+    .option('--standalone', 'print a complete HTML page with a stylesheet')
+    .action(async (file: string, cmdOptions: HighlightFlags) => {
+      if (cmdOptions.format !== 'ansi' && cmdOptions.format !== 'html') {
+        console.error('Error: format must be "ansi" or "html"');
+        process.exit(1);
+      }
+      if (cmdOptions.standalone && cmdOptions.format !== 'html') {
+        console.error('Error: --standalone requires --format html');
+        process.exit(1);
+      }
+      const options: HighlightOptions = {
+        file,
+        format: cmdOptions.format,
+        theme: cmdOptions.theme,
+        standalone: cmdOptions.standalone,
+      };
+      await highlightCommand(options);
+    });
+
+  program
+    .command('fmt <paths...>')
+    .description(
+      'Format MCP Script files (directories are searched for .mcps files)'
+    )
+    .option('-w, --write', 'write the formatted source back to the files')
+    .option('-d, --diff', 'print a diff and fail if any file is unformatted')
+    .option(
+      '--organize-imports',
+      'sort the imports and remove duplicate ones and ones the file does not use'
+    )
+    .action(async (paths: string[], cmdOptions: Omit<FmtOptions, 'files'>) => {
+      const options: FmtOptions = {
+        files: paths,
+        write: cmdOptions.write,
+        diff: cmdOptions.diff,
+        organizeImports: cmdOptions.organizeImports,
+      };
+      await fmtCommand(options);
+    });
+
+  program
+    .command('check <paths...>')
+    .description(
+      'Check MCP Script files for errors and likely mistakes (directories are searched for .mcps files)'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .option('--no-cache', 'analyze every file, ignoring cached results')
+    .action(async (paths: string[], cmdOptions: CheckFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const options: CheckOptions = {
+        files: paths,
+        format: cmdOptions.format,
+        cache: cmdOptions.cache,
+      };
+      await checkCommand(options);
+    });
+
+  program
+    .command('test [paths...]')
+    .description(
+      'Run *_test.mcps files with their MCP servers replaced by mocks (directories are searched, default: .)'
+    )
+    .option(
+      '-t, --timeout <ms>',
+      'timeout of each test file in milliseconds (0 = no timeout)',
+      '30000'
+    )
+    .option(
+      '--faults <file>',
+      'inject latency, dropped responses, malformed JSON and disconnects into the calls of mock servers as a JSON fault plan describes'
+    )
+    .action(async (paths: string[], cmdOptions: TestFlags) => {
+      const timeout = parseInt(cmdOptions.timeout, 10);
+      if (isNaN(timeout) || timeout < 0) {
+        console.error('Error: timeout must be a non-negative number');
+        process.exit(1);
+      }
+      const options: TestOptions = {
+        paths: paths.length > 0 ? paths : ['.'],
+        timeout,
+        faults: cmdOptions.faults,
+      };
+      await testCommand(options);
+    });
+
+  program
+    .command('eval [paths...]')
+    .alias('eval-suite')
+    .description(
+      'Run the scenarios of *.eval.json suites against agents with mock MCP servers and report pass rates (directories are searched, default: .)'
+    )
+    .option(
+      '-t, --timeout <ms>',
+      'timeout of each run in milliseconds (0 = no timeout)',
+      '120000'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .option('-o, --output <file>', 'also write the results to a JSON file')
+    .option(
+      '--min-pass-rate <rate>',
+      'fraction of runs that must pass, from 0 to 1',
+      '1'
+    )
+    .action(async (paths: string[], cmdOptions: EvalFlags) => {
+      const timeout = parseInt(cmdOptions.timeout, 10);
+      if (isNaN(timeout) || timeout < 0) {
+        console.error('Error: timeout must be a non-negative number');
+        process.exit(1);
+      }
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const minPassRate = Number(cmdOptions.minPassRate);
+      if (isNaN(minPassRate) || minPassRate < 0 || minPassRate > 1) {
+        console.error('Error: min-pass-rate must be a number from 0 to 1');
+        process.exit(1);
+      }
+      const options: EvalOptions = {
+        paths: paths.length > 0 ? paths : ['.'],
+        timeout,
+        format: cmdOptions.format,
+        output: cmdOptions.output,
+        minPassRate,
+      };
+      await evalCommand(options);
+    });
+
+  program
+    .command('diff <old> <new>')
+    .description(
+      'Compare two versions of a MCP Script file by structure: declarations, arguments and call targets'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .action(async (before: string, after: string, cmdOptions: DiffFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const options: DiffOptions = {
+        before,
+        after,
+        format: cmdOptions.format,
+      };
+      await diffCommand(options);
+    });
+
+  program
+    .command('lock <file>')
+    .description(
+      'Pin the npm and PyPI packages of the MCP servers a file declares, start the servers and save their tool schemas for mcps check'
+    )
+    .option(
+      '-u, --update',
+      'move packages the file gives no version to their latest release'
+    )
+    .action(async (file: string, cmdOptions: LockFlags) => {
+      const options: LockOptions = {
+        file,
+        update: cmdOptions.update,
+      };
+      await lockCommand(options);
+    });
+
+  const tools = program
+    .command('tools')
+    .description('Keep the locked tool schemas of MCP servers up to date');
+
+  tools
+    .command('sync <file>')
+    .description(
+      'Start the MCP servers a file declares and update the tools of the lockfile to the ones they list, printing what changed'
+    )
+    .option(
+      '--check',
+      'fail if the lockfile is out of date instead of updating it, for CI'
+    )
+    .action(async (file: string, cmdOptions: ToolsSyncFlags) => {
+      const options: ToolsSyncOptions = {
+        file,
+        check: cmdOptions.check,
+      };
+      await toolsSyncCommand(options);
+    });
+
+  program
+    .command('sign [paths...]')
+    .description(
+      'Sign MCP Script files with an Ed25519 key, writing <file>.sig next to each'
+    )
+    .option('-k, --key <file>', 'private key (default: $MCPS_SIGNING_KEY)')
+    .option(
+      '--generate-key <name>',
+      'write a new key pair as <name>.key and <name>.pub'
+    )
+    .action(async (paths: string[], cmdOptions: SignFlags) => {
+      if (paths.length === 0 && !cmdOptions.generateKey) {
+        console.error('Error: give files to sign, or --generate-key');
+        process.exit(1);
+      }
+      const options: SignOptions = {
+        files: paths,
+        key: cmdOptions.key,
+        generateKey: cmdOptions.generateKey,
+      };
+      await signCommand(options);
+    });
+
+  program
+    .command('verify <paths...>')
+    .description(
+      'Check that MCP Script files are signed with a trusted key and unchanged since'
+    )
+    .option(
+      '-k, --key <file...>',
+      'public keys to trust (default: signing.trustedKeys of .mcpsrc)'
+    )
+    .action(async (paths: string[], cmdOptions: VerifyFlags) => {
+      const options: VerifyOptions = {
+        files: paths,
+        keys: cmdOptions.key,
+      };
+      await verifyCommand(options);
+    });
+
+  const generate = program
+    .command('generate')
+    .description('Generate code from the tool schemas of MCP servers');
+
+  generate
+    .command('go <file>')
+    .description(
+      'Generate Go structs and typed client wrappers for the tools of the MCP servers a file declares'
+    )
+    .option('-p, --package <name>', 'Go package name', 'tools')
+    .option('-o, --output <file>', 'write to a file instead of stdout')
+    // Offline, tool schemas are read from mcps-tools.lock.json instead of
+    // starting the servers
+    .action(async (file: string, cmdOptions: GenerateGoFlags) => {
+      const options: GenerateGoOptions = {
+        file,
+        packageName: cmdOptions.package,
+        output: cmdOptions.output,
+        offline: offlineMode(),
+      };
+      await generateGoCommand(options);
+    });
+
+  const exportCmd = program
+    .command('export')
+    .description('Export facts about MCP Script files for review');
+
+  exportCmd
+    .command('capabilities <file>')
+    .description(
+      'List the servers, tools, filesystem roots, network endpoints and secrets a file may use'
+    )
+    .option('-f, --format <format>', 'output format: json or text', 'json')
+    .action(async (file: string, cmdOptions: ExportFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const options: ExportCapabilitiesOptions = {
+        file,
+        format: cmdOptions.format,
+      };
+      await exportCapabilitiesCommand(options);
+    });
+
+  const refactor = program
+    .command('refactor')
+    .description(
+      'Refactor MCP Script files, printing a diff of the changes unless --write is given'
+    );
+
+  refactor
+    .command('inline-variable <file>')
+    .description('Replace the uses of a variable assigned once with its value')
+    .requiredOption('--at <line:column>', 'position of the variable')
+    .option('-w, --write', 'change the files instead of printing a diff')
+    .action(async (file: string, cmdOptions: InlineVariableFlags) => {
+      const [line, column] = parseNumbers(cmdOptions.at, ':', '--at');
+      const options: RefactorOptions = {
+        file,
+        operation: { kind: 'inline-variable', line, column },
+        write: cmdOptions.write,
+      };
+      await refactorCommand(options);
+    });
+
+  refactor
+    .command('extract-workflow <file>')
+    .description(
+      'Move the statements of a range of lines into a new tool called where they were'
+    )
+    .requiredOption('--lines <from-to>', 'lines of the statements')
+    .option('-n, --name <name>', 'name of the tool (default: extracted)')
+    .option('-w, --write', 'change the files instead of printing a diff')
+    .action(async (file: string, cmdOptions: ExtractWorkflowFlags) => {
+      const [from, to] = parseNumbers(cmdOptions.lines, '-', '--lines');
+      const options: RefactorOptions = {
+        file,
+        operation: {
+          kind: 'extract-workflow',
+          from,
+          to,
+          name: cmdOptions.name,
+        },
+        write: cmdOptions.write,
+      };
+      await refactorCommand(options);
+    });
+
+  refactor
+    .command('move-declaration <file> <name>')
+    .description(
+      'Move a top-level declaration to another module, importing it where it is still used'
+    )
+    .requiredOption('--to <file>', 'module to move the declaration to')
+    .option('-w, --write', 'change the files instead of printing a diff')
+    .action(
+      async (file: string, name: string, cmdOptions: MoveDeclarationFlags) => {
+        const options: RefactorOptions = {
+          file,
+          operation: { kind: 'move-declaration', name, to: cmdOptions.to },
+          write: cmdOptions.write,
+        };
+        await refactorCommand(options);
+      }
+    );
+
+  refactor
+    .command('extract-prompts <paths...>')
+    .description(
+      'Move the system prompts agents write as strings into a prompts module (directories are searched for .mcps files)'
+    )
+    .requiredOption('--to <file>', 'module to move the prompts to')
+    .option('-w, --write', 'change the files instead of printing a diff')
+    .action(async (paths: string[], cmdOptions: ExtractPromptsFlags) => {
+      const options: ExtractPromptsOptions = {
+        paths,
+        to: cmdOptions.to,
+        write: cmdOptions.write,
+      };
+      await extractPromptsCommand(options);
+    });
+
+  const audit = program
+    .command('audit')
+    .description('Audit what MCP Script files depend on');
+
+  audit
+    .command('deps <paths...>')
+    .description(
+      'Check the npm and PyPI packages servers run for advisories, newer releases and missing versions (directories are searched for .mcps files)'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .option(
+      '--fail-on <severity>',
+      `least severity failing the audit: ${SEVERITIES.join(', ')}`,
+      'high'
+    )
+    .action(async (paths: string[], cmdOptions: AuditDepsFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      if (!(SEVERITIES as readonly string[]).includes(cmdOptions.failOn)) {
+        console.error(
+          `Error: severity must be one of ${SEVERITIES.join(', ')}`
+        );
+        process.exit(1);
+      }
+      const options: AuditDepsOptions = {
+        paths,
+        format: cmdOptions.format,
+        failOn: cmdOptions.failOn as Severity,
+      };
+      await auditDepsCommand(options);
+    });
+
+  program
+    .command('bench [file]')
+    .description(
+      'Benchmark parsing, queries, type checking and compilation of a script'
+    )
+    .option(
+      '--internal',
+      'benchmark a generated corpus of large scripts instead of a file'
+    )
+    .option('--lines <lines>', 'lines of the generated corpus', '5000')
+    .option('-t, --time <ms>', 'minimum time per benchmark', '1000')
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .option('--save <file>', 'save the results as a baseline')
+    .option(
+      '--baseline <file>',
+      'fail if a benchmark is slower than in a saved baseline'
+    )
+    .option(
+      '--threshold <percent>',
+      'slowdown that counts as a regression',
+      '20'
+    )
+    .action(async (file: string | undefined, cmdOptions: BenchFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const lines = parseInt(cmdOptions.lines, 10);
+      const timeMs = parseInt(cmdOptions.time, 10);
+      const threshold = parseFloat(cmdOptions.threshold);
+      if ([lines, timeMs, threshold].some(n => isNaN(n) || n < 0)) {
+        console.error('Error: lines, time and threshold must be numbers');
+        process.exit(1);
+      }
+      const options: BenchOptions = {
+        file,
+        internal: cmdOptions.internal,
+        lines,
+        timeMs,
+        format: cmdOptions.format,
+        save: cmdOptions.save,
+        baseline: cmdOptions.baseline,
+        threshold: threshold / 100,
+      };
+      await benchCommand(options);
+    });
+
+  program
+    .command('doc <paths...>')
+    .description(
+      'Generate reference documentation from the declarations and doc comments of MCP Script files'
+    )
+    .option(
+      '-f, --format <format>',
+      'output format: markdown or html',
+      'markdown'
+    )
+    .option(
+      '-o, --output <dir>',
+      'write a page per file and an index to a directory instead of stdout'
+    )
+    .action(async (paths: string[], cmdOptions: DocFlags) => {
+      if (cmdOptions.format !== 'markdown' && cmdOptions.format !== 'html') {
+        console.error('Error: format must be "markdown" or "html"');
+        process.exit(1);
+      }
+      const options: DocOptions = {
+        paths,
+        format: cmdOptions.format,
+        output: cmdOptions.output,
+      };
+      await docCommand(options);
+    });
+
+  const transcript = program
+    .command('transcript')
+    .description(
+      'Show and replay the recorded agent runs of mcps run, or mock its tool calls'
+    );
+
+  transcript
+    .command('list')
+    .description('List the recorded transcripts, newest first')
+    .action(async () => {
+      await transcriptListCommand();
+    });
+
+  transcript
+    .command('show <transcript>')
+    .description(
+      'Show the messages and tool calls of each agent run of a transcript (an id, a path or "last")'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .action(async (reference: string, cmdOptions: TranscriptShowFlags) => {
+      if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+        console.error('Error: format must be "text" or "json"');
+        process.exit(1);
+      }
+      const options: TranscriptShowOptions = {
+        transcript: reference,
+        format: cmdOptions.format,
+      };
+      await transcriptShowCommand(options);
+    });
+
+  transcript
+    .command('replay <transcript>')
+    .description(
+      'Run an agent of a transcript again from one of its messages, with the script as it is now'
+    )
+    .requiredOption(
+      '--from <run.message>',
+      'agent run and message to continue from, as numbered by show'
+    )
+    .option(
+      '-t, --timeout <ms>',
+      'execution timeout in milliseconds (0 = no timeout)',
+      '30000'
+    )
+    .action(async (reference: string, cmdOptions: TranscriptReplayFlags) => {
+      const timeout = parseInt(cmdOptions.timeout, 10);
+      if (isNaN(timeout) || timeout < 0) {
+        console.error('Error: timeout must be a non-negative number');
+        process.exit(1);
+      }
+      const options: TranscriptReplayOptions = {
+        transcript: reference,
+        from: cmdOptions.from,
+        timeout,
+      };
+      await transcriptReplayCommand(options);
+    });
+
+  transcript
+    .command('mocks <transcript>')
+    .description(
+      'Write a test file of the script answering its tool calls as the transcript recorded them'
+    )
+    .option(
+      '-o, --out <dir>',
+      "directory to write the test file and recording to (default: the script's)"
+    )
+    .action(async (reference: string, cmdOptions: TranscriptMocksFlags) => {
+      const options: TranscriptMocksOptions = {
+        transcript: reference,
+        out: cmdOptions.out,
+      };
+      await transcriptMocksCommand(options);
+    });
+
+  const runs = program
+    .command('runs')
+    .description('Compare the runs of mcps run recorded in transcripts');
+
+  runs
+    .command('diff <old> <new>')
+    .description(
+      'Show the tool calls, arguments, results, timings and agent replies in which two recorded runs differ (ids, paths or "last")'
+    )
+    .option('-f, --format <format>', 'output format: text or json', 'text')
+    .option(
+      '--timing-threshold <ms>',
+      'smallest change in how long a call, agent run or the run took to report',
+      '1000'
+    )
+    .action(
+      async (before: string, after: string, cmdOptions: RunsDiffFlags) => {
+        if (cmdOptions.format !== 'text' && cmdOptions.format !== 'json') {
+          console.error('Error: format must be "text" or "json"');
+          process.exit(1);
+        }
+        const timingThreshold = parseInt(cmdOptions.timingThreshold, 10);
+        if (isNaN(timingThreshold) || timingThreshold < 0) {
+          console.error(
+            'Error: timing threshold must be a non-negative number'
+          );
+          process.exit(1);
+        }
+        const options: RunsDiffOptions = {
+          before,
+          after,
+          format: cmdOptions.format,
+          timingThreshold,
+        };
+        await runsDiffCommand(options);
+      }
+    );
+
+  program
+    .command('lsp')
+    .description('Start the MCP Script language server on stdio')
+    .action(async () => {
+      await lspCommand();
+    });
+
+  program
+    .command('debug')
+    .description(
+      'Start the MCP Script debug adapter (Debug Adapter Protocol) on stdio'
+    )
+    .action(async () => {
+      await debugCommand();
+    });
+
+  program
+    .command('daemon')
+    .description(
+      'Start an mcpsd executor for remote runs (token read from MCPSD_TOKEN)'
+    )
+    .option('-c, --config <file>', 'tenants, projects and limits (JSON)')
+    .option('-H, --host <host>', 'address to listen on', '127.0.0.1')
+    .option(
+      '-p, --port <port>',
+      'port to listen on',
+      String(DEFAULT_DAEMON_PORT)
+    )
+    .action(async (cmdOptions: DaemonFlags) => {
+      await daemonCommand({
+        host: cmdOptions.host,
+        port: parsePort(cmdOptions.port),
+        config: cmdOptions.config,
+      });
+    });
+
+  program
+    .command('api')
+    .description(
+      'Start an HTTP service that parses, checks and formats scripts'
+    )
+    .option('-H, --host <host>', 'address to listen on', '127.0.0.1')
+    .option('-p, --port <port>', 'port to listen on', String(DEFAULT_API_PORT))
+    .action(async (cmdOptions: ApiFlags) => {
+      await apiCommand({
+        host: cmdOptions.host,
+        port: parsePort(cmdOptions.port),
+      });
+    });
+
+  program
+    .command('serve <paths...>')
+    .description('Serve workflows as tools to other programs')
+    .option('--mcp', 'serve them as the tools of an MCP server on stdio')
+    .option(
+      '--http',
+      'fire their triggers on schedule and on webhooks, and deliver events to their handlers, with run history over HTTP (token read from MCPS_SERVE_TOKEN)'
+    )
+    .option(
+      '-H, --host <host>',
+      'address to listen on with --http',
+      '127.0.0.1'
+    )
+    .option(
+      '-p, --port <port>',
+      'port to listen on with --http',
+      String(DEFAULT_SERVE_PORT)
+    )
+    .option(
+      '--queue <dir>',
+      'with --http, also deliver the events put in a directory as JSON files'
+    )
+    .option(
+      '-t, --timeout <ms>',
+      'timeout of each call or triggered run in milliseconds (0 = no timeout)',
+      '0'
+    )
+    .action(async (paths: string[], cmdOptions: ServeFlags) => {
+      if (cmdOptions.mcp === cmdOptions.http) {
+        console.error('Error: choose a protocol to serve with --mcp or --http');
+        process.exit(1);
+      }
+      if (cmdOptions.queue && !cmdOptions.http) {
+        console.error('Error: --queue needs --http');
+        process.exit(1);
+      }
+      const timeout = parseInt(cmdOptions.timeout, 10);
+      if (isNaN(timeout) || timeout < 0) {
+        console.error('Error: timeout must be a non-negative number');
+        process.exit(1);
+      }
+      await serveCommand({
+        paths,
+        timeout,
+        http: cmdOptions.http
+          ? { host: cmdOptions.host, port: parsePort(cmdOptions.port) }
+          : undefined,
+        queue: cmdOptions.queue,
+      });
+    });
+
+  program
+    .command('logs <run-id>')
+    .description(
+      'Show the output and log of a run on an mcpsd executor or mcps serve --http: statements executed, tool calls and agent steps (token read from MCPS_REMOTE_TOKEN or MCPS_SERVE_TOKEN)'
+    )
+    .option(
+      '-u, --url <url>',
+      'executor or service the run is on',
+      `http://127.0.0.1:${DEFAULT_DAEMON_PORT}`
+    )
+    .option('-f, --follow', 'keep streaming events until the run exits')
+    .option('-s, --server <names...>', 'only the tool calls of these servers')
+    .option(
+      '-l, --level <severity>',
+      `least severity shown: ${LOG_SEVERITIES.join(', ')}`
+    )
+    .option('--json', 'print the events as newline-delimited JSON')
+    .action(async (id: string, cmdOptions: LogsFlags) => {
+      if (
+        cmdOptions.level !== undefined &&
+        !LOG_SEVERITIES.includes(cmdOptions.level as LogSeverity)
+      ) {
+        console.error(
+          `Error: severity must be one of ${LOG_SEVERITIES.join(', ')}`
+        );
+        process.exit(1);
+      }
+      const options: LogsOptions = {
+        id,
+        url: cmdOptions.url,
+        follow: cmdOptions.follow,
+        servers: cmdOptions.server,
+        level: cmdOptions.level as LogSeverity | undefined,
+        json: cmdOptions.json,
+      };
+      await logsCommand(options);
+    });
+
   await program.parseAsync(args, { from: 'user' });
 }
@@ -0,0 +1,905 @@
+// Editor features computed from the syntax tree and grammar queries
+import { dirname } from 'path';
+import { fileURLToPath, pathToFileURL } from 'url';
+import {
+  AstArena,
+  buildSymbolTable,
+  createFileLoader,
+  extractWorkflow,
+  featureDiagnostics,
+  getLocation,
+  getQuery,
+  highlightTokens,
+  importedDeclarations,
+  inlineVariable,
+  interpolatedStrings,
+  interpolationReferences,
+  InterpolationError,
+  linkModules,
+  lint,
+  loadModuleGraph,
+  ModuleError,
+  moveDeclaration,
+  offsetAt,
+  organizeImports,
+  RefactorError,
+  statementsFromTree,
+  syntaxDiagnostics,
+  validateStatements,
+  typecheck,
+  UndefinedVariableError,
+  unusedImports,
+  unusedName,
+  parseInterpolation,
+  type ImportedDeclaration,
+  type InterpolationReference,
+  type LintOptions,
+  type ModuleGraph,
+  type ParseLimitError,
+  type RefactorSource,
+  type Refactoring,
+  type Statement,
+  type SyntaxDiagnostic,
+  type SyntaxNode,
+  type SyntaxTree,
+  type SourceLocation,
+  type Tag,
+} from '@mcpscript/transpiler';
+import { loadProjectConfigSync, moduleSearchPaths } from '../config.js';
+import {
+  DiagnosticSeverity,
+  SymbolKind,
+  SEMANTIC_TOKEN_TYPES,
+  type CodeAction,
+  type Diagnostic,
+  type DocumentSymbol,
+  type FoldingRange,
+  type Position,
+  type Range,
+  type SemanticTokenType,
+  type SemanticTokensEdit,
+  type SymbolInformation,
+  type TextEdit,
+} from './protocol.js';
+
+const SOURCE = 'mcps';
+
+/**
+ * Range covered by a syntax node
+ */
+export function nodeRange(node: SyntaxNode): Range {
+  return {
+    start: {
+      line: node.startPosition.row,
+      character: node.startPosition.column,
+    },
+    end: { line: node.endPosition.row, character: node.endPosition.column },
+  };
+}
+
+function locationRange(location: SourceLocation): Range {
+  return {
+    start: {
+      line: location.start.line - 1,
+      character: location.start.column - 1,
+    },
+    end: { line: location.end.line - 1, character: location.end.column - 1 },
+  };
+}
+
+/**
+ * The modules a document imports, or the error that stopped them loading
+ */
+export interface DocumentImports {
+  /** File path of the document */
+  path?: string;
+  graph?: ModuleGraph;
+  error?: ModuleError;
+  /** Declarations visible through the imports */
+  declarations: ImportedDeclaration[];
+}
+
+/**
+ * Load the modules a file document imports from disk, using the search
+ * paths of its project config
+ */
+export function loadDocumentImports(
+  uri: string,
+  tree: SyntaxTree,
+  content: string
+): DocumentImports {
+  const hasImports = tree.rootNode.namedChildren.some(
+    statement => statement.firstNamedChild?.type === 'import_statement'
+  );
+  if (!hasImports || !uri.startsWith('file:') || tree.rootNode.hasError) {
+    return { declarations: [] };
+  }
+
+  const path = fileURLToPath(uri);
+  try {
+    const paths = moduleSearchPaths(loadProjectConfigSync(dirname(path)));
+    const graph = loadModuleGraph(path, createFileLoader({ paths }), content);
+    return { path, graph, declarations: importedDeclarations(graph) };
+  } catch (error) {
+    if (error instanceof ModuleError) {
+      return { path, error, declarations: [] };
+    }
+    throw error;
+  }
+}
+
+/**
+ * Place an import problem on the import it came from, or on the first
+ * import when it was found in another module
+ */
+function importDiagnostic(
+  tree: SyntaxTree,
+  error: ModuleError,
+  path: string | undefined
+): Diagnostic {
+  const location = error.statement && getLocation(error.statement);
+  const firstImport = tree.rootNode.namedChildren.find(
+    statement => statement.firstNamedChild?.type === 'import_statement'
+  );
+  return {
+    range:
+      location && error.modulePath === path
+        ? locationRange(location)
+        : nodeRange(firstImport ?? tree.rootNode),
+    severity: DiagnosticSeverity.Error,
+    code: 'import',
+    source: SOURCE,
+    message: error.message,
+  };
+}
+
+/**
+ * A document too large to parse, reported where the limit was exceeded
+ * or at its start
+ */
+export function limitDiagnostic(error: ParseLimitError): Diagnostic {
+  const start = { line: 0, character: 0 };
+  return {
+    range: error.location
+      ? locationRange(error.location)
+      : { start, end: start },
+    severity: DiagnosticSeverity.Error,
+    code: 'limit',
+    source: SOURCE,
+    message: error.message,
+  };
+}
+
+function syntaxDiagnostic(diagnostic: SyntaxDiagnostic): Diagnostic {
+  return {
+    range: locationRange(diagnostic.location),
+    severity: DiagnosticSeverity.Error,
+    code: 'syntax',
+    source: SOURCE,
+    message: diagnostic.message,
+  };
+}
+
+/**
+ * Syntax errors of a document only
+ */
+export function collectSyntaxDiagnostics(
+  tree: SyntaxTree,
+  content: string
+): Diagnostic[] {
+  return syntaxDiagnostics(tree, content).map(syntaxDiagnostic);
+}
+
+/**
+ * Syntax errors, or semantic errors when the document parses cleanly
+ */
+export function collectDiagnostics(
+  tree: SyntaxTree,
+  content: string,
+  imports: DocumentImports = { declarations: [] }
+): Diagnostic[] {
+  if (tree.rootNode.hasError) {
+    return collectSyntaxDiagnostics(tree, content);
+  }
+  // Experimental constructs of features the project did not enable
+  const disabled = featureDiagnostics(tree);
+  if (disabled.length > 0) {
+    return disabled.map(syntaxDiagnostic);
+  }
+
+  // The statements are only needed while checking, so their nodes are
+  // pooled for the next edit
+  const arena = new AstArena();
+  try {
+    let statements;
+    try {
+      statements = statementsFromTree(tree, content, arena);
+    } catch (error) {
+      return [
+        {
+          range: nodeRange(tree.rootNode),
+          severity: DiagnosticSeverity.Error,
+          source: SOURCE,
+          message: error instanceof Error ? error.message : String(error),
+        },
+      ];
+    }
+    return programDiagnostics(tree, statements, imports);
+  } finally {
+    arena.release();
+  }
+}
+
+/**
+ * Semantic errors of a document's statements
+ */
+function programDiagnostics(
+  tree: SyntaxTree,
+  statements: Statement[],
+  imports: DocumentImports
+): Diagnostic[] {
+  const diagnostics: Diagnostic[] = [];
+
+  // Check the document together with the declarations it imports
+  let program: Statement[] = statements;
+  let importFailed = imports.error !== undefined;
+  if (imports.error) {
+    diagnostics.push(importDiagnostic(tree, imports.error, imports.path));
+  } else if (imports.graph) {
+    try {
+      program = linkModules(imports.graph);
+    } catch (error) {
+      if (!(error instanceof ModuleError)) {
+        throw error;
+      }
+      diagnostics.push(importDiagnostic(tree, error, imports.path));
+      importFailed = true;
+    }
+  }
+
+  // Names from modules that failed to load would all look undefined
+  try {
+    if (!importFailed) {
+      validateStatements(program);
+    }
+  } catch (error) {
+    if (!(error instanceof UndefinedVariableError)) {
+      throw error;
+    }
+    const reference = buildSymbolTable(tree, imports.declarations)
+      .unresolved()
+      .find(ref => ref.node.text === error.variable);
+    diagnostics.push({
+      range: nodeRange(reference?.node ?? tree.rootNode),
+      severity: DiagnosticSeverity.Error,
+      code: 'undefined-variable',
+      source: SOURCE,
+      message: error.message,
+    });
+  }
+
+  for (const diagnostic of typecheck(program)) {
+    diagnostics.push({
+      range: diagnostic.location
+        ? locationRange(diagnostic.location)
+        : nodeRange(tree.rootNode),
+      severity:
+        diagnostic.severity === 'error'
+          ? DiagnosticSeverity.Error
+          : DiagnosticSeverity.Warning,
+      code: diagnostic.code,
+      source: SOURCE,
+      message: diagnostic.message,
+    });
+  }
+
+  return diagnostics;
+}
+
+/**
+ * Problems found by lint rules, for documents without syntax errors
+ */
+export function collectLintDiagnostics(
+  tree: SyntaxTree,
+  content: string,
+  imports: DocumentImports = { declarations: [] },
+  options: Omit<LintOptions, 'imports'> = {}
+): Diagnostic[] {
+  if (tree.rootNode.hasError) {
+    return [];
+  }
+  const { declarations } = imports;
+  return lint(tree, content, { ...options, imports: declarations }).map(
+    diagnostic => ({
+      range: locationRange(diagnostic.location),
+      severity:
+        diagnostic.severity === 'error'
+          ? DiagnosticSeverity.Error
+          : DiagnosticSeverity.Warning,
+      code: diagnostic.rule,
+      source: SOURCE,
+      message: diagnostic.message,
+      ...(diagnostic.fix && {
+        data: {
+          fix: {
+            title: diagnostic.fix.title,
+            edits: diagnostic.fix.edits.map(edit => ({
+              range: locationRange(edit.location),
+              newText: edit.newText,
+            })),
+          },
+        },
+      }),
+    })
+  );
+}
+
+/**
+ * Quick fixes of a document's diagnostics that come with one
+ */
+export function quickFixes(
+  uri: string,
+  diagnostics: Diagnostic[]
+): CodeAction[] {
+  return diagnostics.flatMap(diagnostic => {
+    const fix = diagnostic.data?.fix;
+    return fix
+      ? [
+          {
+            title: fix.title,
+            kind: 'quickfix' as const,
+            diagnostics: [diagnostic],
+            edit: { changes: { [uri]: fix.edits } },
+          },
+        ]
+      : [];
+  });
+}
+
+/**
+ * A refactoring as a code action, its files' edits keyed by URI
+ */
+function refactorAction(
+  kind: CodeAction['kind'],
+  refactoring: Refactoring,
+  source: RefactorSource,
+  uri: string
+): CodeAction {
+  const changes: Record<string, TextEdit[]> = {};
+  for (const [path, edits] of Object.entries(refactoring.edits)) {
+    changes[path === source.path ? uri : pathToFileURL(path).href] =
+      edits.map(edit => ({
+        range: locationRange(edit.location),
+        newText: edit.newText,
+      }));
+  }
+  return { title: refactoring.title, kind, edit: { changes } };
+}
+
+/**
+ * Refactorings of a document at a range: inlining the variable at its
+ * start, extracting the statements in it to a workflow, and moving the
+ * declaration named at its start to one of the modules the document
+ * imports. Refactorings that cannot be done there are left out.
+ */
+export function refactorActions(
+  uri: string,
+  tree: SyntaxTree,
+  content: string,
+  imports: DocumentImports,
+  range: Range
+): CodeAction[] {
+  if (tree.rootNode.hasError) {
+    return [];
+  }
+  const source: RefactorSource = {
+    path: imports.path ?? uri,
+    content,
+    tree,
+    imports: imports.declarations,
+  };
+  const start = offsetAt(content, {
+    row: range.start.line,
+    column: range.start.character,
+  });
+  const end = offsetAt(content, {
+    row: range.end.line,
+    column: range.end.character,
+  });
+
+  const actions: CodeAction[] = [];
+  const attempt = (kind: CodeAction['kind'], refactor: () => Refactoring) => {
+    try {
+      actions.push(refactorAction(kind, refactor(), source, uri));
+    } catch (error) {
+      if (!(error instanceof RefactorError)) {
+        throw error;
+      }
+    }
+  };
+  attempt('refactor.inline', () => inlineVariable(source, start));
+  if (end > start) {
+    attempt('refactor.extract', () =>
+      extractWorkflow(source, { start, end }, unusedName(source, 'extracted'))
+    );
+  }
+  const identifier = identifierAt(tree, range.start);
+  const { graph } = imports;
+  if (identifier?.parent?.parent?.type === 'statement') {
+    for (const module of graph?.modules ?? []) {
+      if (module !== graph?.entry) {
+        attempt('refactor.move', () =>
+          moveDeclaration(source, identifier.text, {
+            path: module.path,
+            content: module.source,
+          })
+        );
+      }
+    }
+  }
+  return actions;
+}
+
+/**
+ * Organizing the imports of a document, as the source action editors run
+ * on save; imports are only found unused once the modules have loaded
+ */
+export function organizeImportsActions(
+  uri: string,
+  tree: SyntaxTree,
+  content: string,
+  imports: DocumentImports
+): CodeAction[] {
+  if (tree.rootNode.hasError) {
+    return [];
+  }
+  const source: RefactorSource = { path: imports.path ?? uri, content, tree };
+  const unused = imports.graph ? unusedImports(imports.graph) : [];
+  const refactoring = organizeImports(source, unused);
+  if (refactoring.edits[source.path].length === 0) {
+    return [];
+  }
+  return [refactorAction('source.organizeImports', refactoring, source, uri)];
+}
+
+/**
+ * An environment variable or secret an mcp declaration references, and
+ * where
+ */
+export interface ReferenceSite {
+  reference: InterpolationReference;
+  range: Range;
+}
+
+/**
+ * The ${NAME} and ${secret:NAME} references of a document's servers, for
+ * documents without syntax errors
+ */
+export function collectInterpolationReferences(
+  tree: SyntaxTree,
+  content: string
+): ReferenceSite[] {
+  if (tree.rootNode.hasError) {
+    return [];
+  }
+  const arena = new AstArena();
+  try {
+    let statements: Statement[];
+    try {
+      statements = statementsFromTree(tree, content, arena);
+    } catch {
+      // Reported by collectDiagnostics
+      return [];
+    }
+    const sites: ReferenceSite[] = [];
+    for (const stmt of statements) {
+      if (stmt.type !== 'mcp_declaration') {
+        continue;
+      }
+      for (const literal of interpolatedStrings(stmt.config)) {
+        let references: InterpolationReference[];
+        try {
+          references = interpolationReferences(
+            parseInterpolation(literal.value)
+          );
+        } catch (error) {
+          // Malformed references are reported by the type checker
+          if (error instanceof InterpolationError) {
+            continue;
+          }
+          throw error;
+        }
+        const location = getLocation(literal);
+        const range = location
+          ? locationRange(location)
+          : nodeRange(tree.rootNode);
+        for (const reference of references) {
+          sites.push({ reference, range });
+        }
+      }
+    }
+    return sites;
+  } finally {
+    arena.release();
+  }
+}
+
+/**
+ * Top-level declarations and variables as document symbols
+ */
+export function collectSymbols(tree: SyntaxTree): DocumentSymbol[] {
+  const symbols: DocumentSymbol[] = [];
+  const seenVariables = new Set<string>();
+
+  for (const statement of tree.rootNode.namedChildren) {
+    const node = statement.firstNamedChild;
+    if (!node) {
+      continue;
+    }
+    const name = node.namedChildren.find(c => c.type === 'identifier');
+
+    switch (node.type) {
+      case 'tool_declaration':
+        if (name) {
+          const params = node.namedChildren.find(
+            c => c.type === 'parameter_list'
+          );
+          symbols.push({
+            name: name.text,
+            detail: `tool(${params?.text ?? ''})`,
+            kind: SymbolKind.Function,
+            range: nodeRange(node),
+            selectionRange: nodeRange(name),
+          });
+        }
+        break;
+      case 'pipeline_declaration':
+        if (name) {
+          const params = node.namedChildren.find(
+            c => c.type === 'parameter_list'
+          );
+          symbols.push({
+            name: name.text,
+            detail: `pipeline(${params?.text ?? ''})`,
+            kind: SymbolKind.Function,
+            range: nodeRange(node),
+            selectionRange: nodeRange(name),
+          });
+        }
+        break;
+      case 'mcp_declaration':
+      case 'model_declaration':
+      case 'agent_declaration':
+      case 'prompt_declaration':
+        if (name) {
+          const keyword = node.type.replace('_declaration', '');
+          symbols.push({
+            name: name.text,
+            detail: keyword,
+            kind:
+              node.type === 'mcp_declaration'
+                ? SymbolKind.Module
+                : node.type === 'prompt_declaration'
+                  ? SymbolKind.String
+                  : SymbolKind.Object,
+            range: nodeRange(node),
+            selectionRange: nodeRange(name),
+          });
+        }
+        break;
+      case 'trigger_declaration':
+        if (name) {
+          symbols.push({
+            name: name.text,
+            detail: 'trigger',
+            kind: SymbolKind.Event,
+            range: nodeRange(node),
+            selectionRange: nodeRange(name),
+          });
+        }
+        break;
+      case 'event_handler': {
+        const event = node.namedChildren.find(c => c.type === 'string');
+        if (event) {
+          symbols.push({
+            name: event.text.slice(1, -1),
+            detail: 'on event',
+            kind: SymbolKind.Event,
+            range: nodeRange(node),
+            selectionRange: nodeRange(event),
+          });
+        }
+        break;
+      }
+      case 'test_declaration': {
+        const test = node.namedChildren.find(c => c.type === 'string');
+        if (test) {
+          symbols.push({
+            name: test.text.slice(1, -1),
+            detail: 'test',
+            kind: SymbolKind.Method,
+            range: nodeRange(node),
+            selectionRange: nodeRange(test),
+          });
+        }
+        break;
+      }
+      case 'notification_handler': {
+        const notification = node.namedChildren.find(c => c.type === 'string');
+        if (name && notification) {
+          symbols.push({
+            name: `${name.text} ${notification.text.slice(1, -1)}`,
+            detail: 'on notification',
+            kind: SymbolKind.Event,
+            range: nodeRange(node),
+            selectionRange: nodeRange(notification),
+          });
+        }
+        break;
+      }
+      case 'assignment': {
+        const target = node.firstNamedChild?.firstNamedChild;
+        const isNew =
+          target?.type === 'identifier' && !seenVariables.has(target.text);
+        if (target && isNew) {
+          seenVariables.add(target.text);
+          symbols.push({
+            name: target.text,
+            kind: SymbolKind.Variable,
+            range: nodeRange(node),
+            selectionRange: nodeRange(target),
+          });
+        }
+        break;
+      }
+      default:
+        break;
+    }
+  }
+
+  return symbols;
+}
+
+const TAG_SYMBOL_KINDS: Record<string, SymbolInformation['kind']> = {
+  function: SymbolKind.Function,
+  module: SymbolKind.Module,
+  model: SymbolKind.Object,
+  agent: SymbolKind.Object,
+  prompt: SymbolKind.String,
+  trigger: SymbolKind.Event,
+  variable: SymbolKind.Variable,
+};
+
+/**
+ * Whether the characters of a query appear in a name in order, ignoring
+ * case, so that "gtu" finds getUser
+ */
+function fuzzyMatch(name: string, query: string): boolean {
+  const lower = name.toLowerCase();
+  let from = 0;
+  for (const char of query.toLowerCase()) {
+    const found = lower.indexOf(char, from);
+    if (found === -1) {
+      return false;
+    }
+    from = found + 1;
+  }
+  return true;
+}
+
+/**
+ * Definitions among indexed tags whose name matches a workspace symbol
+ * query, located in the files the tags were indexed under (URIs)
+ */
+export function workspaceSymbols(
+  tags: Tag[],
+  query: string
+): SymbolInformation[] {
+  return tags
+    .filter(tag => tag.definition && fuzzyMatch(tag.name, query))
+    .map(tag => ({
+      name: tag.name,
+      kind: TAG_SYMBOL_KINDS[tag.kind] ?? SymbolKind.Variable,
+      location: {
+        uri: tag.file,
+        range: {
+          start: {
+            line: tag.nameRange.start.row,
+            character: tag.nameRange.start.column,
+          },
+          end: {
+            line: tag.nameRange.end.row,
+            character: tag.nameRange.end.column,
+          },
+        },
+      },
+    }));
+}
+
+/**
+ * Folding ranges from the folds query (only regions spanning lines)
+ */
+export function collectFoldingRanges(tree: SyntaxTree): FoldingRange[] {
+  const ranges: FoldingRange[] = [];
+  const seen = new Set<number>();
+  for (const { node } of getQuery('folds').captures(tree.rootNode)) {
+    const startLine = node.startPosition.row;
+    const endLine = node.endPosition.row;
+    if (endLine > startLine && !seen.has(startLine)) {
+      seen.add(startLine);
+      ranges.push({ startLine, endLine });
+    }
+  }
+  return ranges;
+}
+
+const CAPTURE_TOKEN_TYPES: Record<string, SemanticTokenType> = {
+  namespace: 'namespace',
+  type: 'type',
+  function: 'function',
+  'function.call': 'function',
+  'function.method': 'method',
+  'variable.parameter': 'parameter',
+  variable: 'variable',
+  property: 'property',
+  keyword: 'keyword',
+  'keyword.control': 'keyword',
+  boolean: 'keyword',
+  comment: 'comment',
+  string: 'string',
+  number: 'number',
+  operator: 'operator',
+  'type.builtin': 'type',
+};
+
+interface SemanticToken {
+  line: number;
+  character: number;
+  length: number;
+  type: SemanticTokenType;
+}
+
+/**
+ * Semantic tokens from the highlights query, encoded as LSP relative data
+ */
+export function collectSemanticTokens(
+  tree: SyntaxTree,
+  content: string
+): number[] {
+  const lines = content.split('\n');
+  const tokens: SemanticToken[] = [];
+  for (const { name, startPosition, endPosition } of highlightTokens(tree)) {
+    const type = CAPTURE_TOKEN_TYPES[name];
+    if (!type) {
+      continue;
+    }
+    // Tokens may not span lines, so split multi-line nodes per line
+    for (let row = startPosition.row; row <= endPosition.row; row++) {
+      const start = row === startPosition.row ? startPosition.column : 0;
+      const lineLength = (lines[row] ?? '').length;
+      const end = row === endPosition.row ? endPosition.column : lineLength;
+      if (end > start) {
+        tokens.push({ line: row, character: start, length: end - start, type });
+      }
+    }
+  }
+
+  tokens.sort((a, b) => a.line - b.line || a.character - b.character);
+
+  const data: number[] = [];
+  let previousLine = 0;
+  let previousCharacter = 0;
+  let previousEnd = -1;
+  for (const token of tokens) {
+    const sameLine = data.length > 0 && token.line === previousLine;
+    // Drop tokens nested inside one that was already emitted
+    if (sameLine && token.character < previousEnd) {
+      continue;
+    }
+    data.push(
+      token.line - previousLine,
+      sameLine ? token.character - previousCharacter : token.character,
+      token.length,
+      SEMANTIC_TOKEN_TYPES.indexOf(token.type),
+      0
+    );
+    previousLine = token.line;
+    previousCharacter = token.character;
+    previousEnd = token.character + token.length;
+  }
+  return data;
+}
+
+/**
+ * The edit turning previous semantic token data into the next, replacing
+ * what lies between their common start and common end; none if they are
+ * equal
+ */
+export function semanticTokensEdits(
+  previous: number[],
+  next: number[]
+): SemanticTokensEdit[] {
+  let start = 0;
+  const shorter = Math.min(previous.length, next.length);
+  while (start < shorter && previous[start] === next[start]) {
+    start++;
+  }
+  if (start === previous.length && start === next.length) {
+    return [];
+  }
+  let end = 0;
+  while (
+    end < shorter - start &&
+    previous[previous.length - 1 - end] === next[next.length - 1 - end]
+  ) {
+    end++;
+  }
+  return [
+    {
+      start,
+      deleteCount: previous.length - start - end,
+      data: next.slice(start, next.length - end),
+    },
+  ];
+}
+
+/**
+ * Find the identifier at (or just before) a position
+ */
+function identifierAt(
+  tree: SyntaxTree,
+  position: Position
+): SyntaxNode | null {
+  for (const column of [position.character, position.character - 1]) {
+    if (column < 0) {
+      continue;
+    }
+    const node = tree.rootNode.descendantForPosition({
+      row: position.line,
+      column,
+    });
+    if (node.type === 'identifier') {
+      return node;
+    }
+  }
+  return null;
+}
+
+/**
+ * Go to the definition of the identifier at a position
+ */
+export function findDefinitionAt(
+  tree: SyntaxTree,
+  position: Position,
+  imports: ImportedDeclaration[] = []
+): { range: Range; path?: string } | null {
+  const identifier = identifierAt(tree, position);
+  if (!identifier) {
+    return null;
+  }
+
+  // Works for references and for assignments that rebind a variable
+  const symbol = buildSymbolTable(tree, imports).lookup(identifier);
+  return symbol ? { range: nodeRange(symbol.node), path: symbol.path } : null;
+}
+
+/**
+ * Ranges to replace when renaming the symbol at a position
+ */
+export function findRenameRanges(
+  tree: SyntaxTree,
+  position: Position,
+  imports: ImportedDeclaration[] = []
+): Range[] | null {
+  const identifier = identifierAt(tree, position);
+  if (!identifier) {
+    return null;
+  }
+  const table = buildSymbolTable(tree, imports);
+  const symbol = table.lookup(identifier);
+  // Declarations of other modules are renamed from their own file
+  if (!symbol || symbol.path !== undefined) {
+    return null;
+  }
+  return table.occurrences(symbol).map(nodeRange);
+}
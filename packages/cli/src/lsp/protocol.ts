@@ -0,0 +1,159 @@
+// The subset of the Language Server Protocol used by mcps lsp
+
+/**
+ * A zero-based position (character offsets are UTF-16 code units)
+ */
+export interface Position {
+  line: number;
+  character: number;
+}
+
+export interface Range {
+  start: Position;
+  end: Position;
+}
+
+export interface Location {
+  uri: string;
+  range: Range;
+}
+
+export interface TextEdit {
+  range: Range;
+  newText: string;
+}
+
+export interface WorkspaceEdit {
+  changes: Record<string, TextEdit[]>;
+}
+
+export const DiagnosticSeverity = {
+  Error: 1,
+  Warning: 2,
+  Information: 3,
+  Hint: 4,
+} as const;
+
+export interface Diagnostic {
+  range: Range;
+  severity: (typeof DiagnosticSeverity)[keyof typeof DiagnosticSeverity];
+  code?: string;
+  source: string;
+  message: string;
+  /** Kept by the editor and sent back with code action requests */
+  data?: { fix?: QuickFix };
+}
+
+/**
+ * A fix of a diagnostic, as the server attaches it to the diagnostic
+ */
+export interface QuickFix {
+  title: string;
+  edits: TextEdit[];
+}
+
+export interface CodeAction {
+  title: string;
+  kind:
+    | 'quickfix'
+    | 'refactor.inline'
+    | 'refactor.extract'
+    | 'refactor.move'
+    | 'source.organizeImports';
+  /** Diagnostics a quick fix fixes */
+  diagnostics?: Diagnostic[];
+  edit: WorkspaceEdit;
+}
+
+export const SymbolKind = {
+  Module: 2,
+  Namespace: 3,
+  Function: 12,
+  Variable: 13,
+  String: 15,
+  Object: 19,
+  Event: 24,
+} as const;
+
+export interface DocumentSymbol {
+  name: string;
+  detail?: string;
+  kind: (typeof SymbolKind)[keyof typeof SymbolKind];
+  range: Range;
+  selectionRange: Range;
+  children?: DocumentSymbol[];
+}
+
+export interface SymbolInformation {
+  name: string;
+  kind: (typeof SymbolKind)[keyof typeof SymbolKind];
+  location: Location;
+}
+
+export interface FoldingRange {
+  startLine: number;
+  endLine: number;
+}
+
+/**
+ * Semantic token types reported by the server, in legend order
+ */
+export const SEMANTIC_TOKEN_TYPES = [
+  'namespace',
+  'type',
+  'function',
+  'method',
+  'parameter',
+  'variable',
+  'property',
+  'keyword',
+  'comment',
+  'string',
+  'number',
+  'operator',
+] as const;
+
+export type SemanticTokenType = (typeof SEMANTIC_TOKEN_TYPES)[number];
+
+/**
+ * A change to the data of the previous semantic tokens result
+ */
+export interface SemanticTokensEdit {
+  start: number;
+  deleteCount: number;
+  data?: number[];
+}
+
+/**
+ * JSON-RPC 2.0 message shapes
+ */
+export interface RequestMessage {
+  jsonrpc: '2.0';
+  id: number | string;
+  method: string;
+  params?: unknown;
+}
+
+export interface NotificationMessage {
+  jsonrpc: '2.0';
+  method: string;
+  params?: unknown;
+}
+
+export interface ResponseMessage {
+  jsonrpc: '2.0';
+  id: number | string | null;
+  result?: unknown;
+  error?: { code: number; message: string };
+}
+
+export type Message = RequestMessage | NotificationMessage | ResponseMessage;
+
+export const ErrorCodes = {
+  ParseError: -32700,
+  InvalidRequest: -32600,
+  MethodNotFound: -32601,
+  InvalidParams: -32602,
+  InternalError: -32603,
+  ServerNotInitialized: -32002,
+} as const;
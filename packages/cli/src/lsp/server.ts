@@ -0,0 +1,565 @@
+// Language server for MCP Script
+import { statSync } from 'fs';
+import { readFile } from 'fs/promises';
+import {
+  applyTextEdit,
+  indexTags,
+  MessageTranslator,
+  ParseLimitError,
+  parseTree,
+  reparseTree,
+  toolCallRule,
+  type LintRule,
+  type SyntaxEdit,
+  type SyntaxTree,
+} from '@mcpscript/transpiler';
+import {
+  collectDiagnostics,
+  collectFoldingRanges,
+  collectLintDiagnostics,
+  collectSemanticTokens,
+  collectSymbols,
+  findDefinitionAt,
+  findRenameRanges,
+  limitDiagnostic,
+  loadDocumentImports,
+  organizeImportsActions,
+  quickFixes,
+  refactorActions,
+  semanticTokensEdits,
+  workspaceSymbols,
+  type DocumentImports,
+} from './analysis.js';
+import {
+  ErrorCodes,
+  SEMANTIC_TOKEN_TYPES,
+  type Diagnostic,
+  type Message,
+  type Position,
+  type Range,
+  type RequestMessage,
+  type SymbolInformation,
+  type WorkspaceEdit,
+} from './protocol.js';
+import { dirname } from 'path';
+import { fileURLToPath, pathToFileURL } from 'url';
+import type { Connection } from './transport.js';
+import { collectFiles } from '../commands/fmt.js';
+import { loadProjectConfigSync } from '../config.js';
+import { findToolsLock, readToolsLockSync } from '../tools-lock.js';
+import { messageTranslator, translateDiagnostics } from '../messages.js';
+import packageJson from '../../package.json' with { type: 'json' };
+
+interface TextDocument {
+  uri: string;
+  content: string;
+  tree: SyntaxTree;
+  imports: DocumentImports;
+  /** Whether the content was too large to parse; tree is then empty */
+  oversized?: boolean;
+}
+
+interface TextDocumentParams {
+  textDocument: { uri: string; text?: string };
+}
+
+interface DidChangeParams extends TextDocumentParams {
+  /** Changes to a range of the document, or a full replacement */
+  contentChanges: { range?: Range; text: string }[];
+}
+
+interface PositionParams extends TextDocumentParams {
+  position: Position;
+}
+
+interface RenameParams extends PositionParams {
+  newName: string;
+}
+
+interface CodeActionParams extends TextDocumentParams {
+  range: Range;
+  context: { diagnostics: Diagnostic[]; only?: string[] };
+}
+
+interface InitializeParams {
+  rootUri?: string | null;
+  workspaceFolders?: { uri: string }[] | null;
+}
+
+class ResponseError extends Error {
+  constructor(
+    public readonly code: number,
+    message: string
+  ) {
+    super(message);
+  }
+}
+
+/**
+ * Serves editor requests for open .mcps documents over a connection
+ */
+export class LanguageServer {
+  private readonly documents = new Map<string, TextDocument>();
+  /** The last semantic tokens sent for each document, to send deltas to */
+  private readonly semanticTokens = new Map<
+    string,
+    { resultId: string; data: number[] }
+  >();
+  private nextResultId = 1;
+  /** Tool call rules of the lockfiles read, by path, with their mtime */
+  private readonly toolCallRules = new Map<
+    string,
+    { modified: number; rule: LintRule }
+  >();
+  /** Directories of the workspace folders, searched for workspace symbols */
+  private roots: string[] = [];
+  private initialized = false;
+  private shutdownRequested = false;
+
+  constructor(
+    private readonly connection: Connection,
+    private readonly onExit: (code: number) => void
+  ) {}
+
+  start(): void {
+    this.connection.listen(
+      message => this.handleMessage(message),
+      error => console.error(`mcps lsp: ${error.message}`)
+    );
+  }
+
+  handleMessage(message: Message): void {
+    if (!('method' in message)) {
+      // Responses to server-initiated requests are not used
+      return;
+    }
+    if ('id' in message) {
+      this.handleRequest(message);
+    } else {
+      this.handleNotification(message.method, message.params);
+    }
+  }
+
+  private handleRequest(request: RequestMessage): void {
+    try {
+      const result = this.dispatchRequest(request.method, request.params);
+      if (result instanceof Promise) {
+        // Answered once the work is done; other messages are served meanwhile
+        result.then(
+          value => this.sendResult(request, value),
+          error => this.sendError(request, error)
+        );
+      } else {
+        this.sendResult(request, result);
+      }
+    } catch (error) {
+      this.sendError(request, error);
+    }
+  }
+
+  private sendResult(request: RequestMessage, result: unknown): void {
+    this.connection.send({ jsonrpc: '2.0', id: request.id, result });
+  }
+
+  private sendError(request: RequestMessage, error: unknown): void {
+    const code =
+      error instanceof ResponseError ? error.code : ErrorCodes.InternalError;
+    this.connection.send({
+      jsonrpc: '2.0',
+      id: request.id,
+      error: {
+        code,
+        message: error instanceof Error ? error.message : String(error),
+      },
+    });
+  }
+
+  private dispatchRequest(method: string, params: unknown): unknown {
+    if (method === 'initialize') {
+      this.initialized = true;
+      const { rootUri, workspaceFolders } = (params ?? {}) as InitializeParams;
+      const folders =
+        workspaceFolders?.map(folder => folder.uri) ??
+        (rootUri ? [rootUri] : []);
+      this.roots = folders
+        .filter(uri => uri.startsWith('file:'))
+        .map(uri => fileURLToPath(uri));
+      return {
+        capabilities: {
+          textDocumentSync: 2, // incremental document sync
+          documentSymbolProvider: true,
+          definitionProvider: true,
+          renameProvider: true,
+          foldingRangeProvider: true,
+          workspaceSymbolProvider: true,
+          codeActionProvider: {
+            codeActionKinds: [
+              'quickfix',
+              'refactor.inline',
+              'refactor.extract',
+              'refactor.move',
+              'source.organizeImports',
+            ],
+          },
+          semanticTokensProvider: {
+            legend: { tokenTypes: SEMANTIC_TOKEN_TYPES, tokenModifiers: [] },
+            full: { delta: true },
+          },
+        },
+        serverInfo: { name: 'mcps', version: packageJson.version },
+      };
+    }
+
+    if (!this.initialized) {
+      throw new ResponseError(
+        ErrorCodes.ServerNotInitialized,
+        'Server has not been initialized'
+      );
+    }
+
+    switch (method) {
+      case 'shutdown':
+        this.shutdownRequested = true;
+        return null;
+      case 'textDocument/documentSymbol':
+        return collectSymbols(this.getDocument(params).tree);
+      case 'textDocument/foldingRange':
+        return collectFoldingRanges(this.getDocument(params).tree);
+      case 'textDocument/semanticTokens/full': {
+        const document = this.getDocument(params);
+        return this.semanticTokensResult(document);
+      }
+      case 'textDocument/semanticTokens/full/delta': {
+        const document = this.getDocument(params);
+        const { previousResultId } = params as { previousResultId: string };
+        const previous = this.semanticTokens.get(document.uri);
+        const result = this.semanticTokensResult(document);
+        if (previous?.resultId !== previousResultId) {
+          return result;
+        }
+        return {
+          resultId: result.resultId,
+          edits: semanticTokensEdits(previous.data, result.data),
+        };
+      }
+      case 'textDocument/definition': {
+        const document = this.getDocument(params);
+        const { position } = params as PositionParams;
+        const definition = findDefinitionAt(
+          document.tree,
+          position,
+          document.imports.declarations
+        );
+        if (!definition) {
+          return null;
+        }
+        const uri = definition.path
+          ? pathToFileURL(definition.path).href
+          : document.uri;
+        return { uri, range: definition.range };
+      }
+      case 'workspace/symbol': {
+        const { query } = params as { query: string };
+        return this.findWorkspaceSymbols(query);
+      }
+      case 'textDocument/rename': {
+        const document = this.getDocument(params);
+        const { position, newName } = params as RenameParams;
+        if (!/^[A-Za-z_][A-Za-z0-9_]*$/.test(newName)) {
+          throw new ResponseError(
+            ErrorCodes.InvalidParams,
+            `Invalid identifier: ${newName}`
+          );
+        }
+        const ranges = findRenameRanges(
+          document.tree,
+          position,
+          document.imports.declarations
+        );
+        if (!ranges) {
+          return null;
+        }
+        const edit: WorkspaceEdit = {
+          changes: {
+            [document.uri]: ranges.map(range => ({ range, newText: newName })),
+          },
+        };
+        return edit;
+      }
+      case 'textDocument/codeAction': {
+        const document = this.getDocument(params);
+        const { range, context } = params as CodeActionParams;
+        const actions = [
+          ...quickFixes(document.uri, context.diagnostics),
+          ...refactorActions(
+            document.uri,
+            document.tree,
+            document.content,
+            document.imports,
+            range
+          ),
+          ...organizeImportsActions(
+            document.uri,
+            document.tree,
+            document.content,
+            document.imports
+          ),
+        ];
+        // Editors running actions on save ask for the kinds they run
+        const { only } = context;
+        return only
+          ? actions.filter(action =>
+              only.some(
+                kind =>
+                  action.kind === kind || action.kind.startsWith(`${kind}.`)
+              )
+            )
+          : actions;
+      }
+      default:
+        throw new ResponseError(
+          ErrorCodes.MethodNotFound,
+          `Unhandled method: ${method}`
+        );
+    }
+  }
+
+  /**
+   * Semantic tokens of a document, remembered so that the next request
+   * can be answered with the changes to them
+   */
+  private semanticTokensResult(document: TextDocument): {
+    resultId: string;
+    data: number[];
+  } {
+    const result = {
+      resultId: String(this.nextResultId++),
+      data: collectSemanticTokens(document.tree, document.content),
+    };
+    this.semanticTokens.set(document.uri, result);
+    return result;
+  }
+
+  /**
+   * Definitions matching a query in the .mcps files of the workspace
+   * folders and the open documents, which are used as edited rather than
+   * as saved
+   */
+  private async findWorkspaceSymbols(
+    query: string
+  ): Promise<SymbolInformation[]> {
+    const open = new Map<string, TextDocument>();
+    for (const document of this.documents.values()) {
+      open.set(normalizeUri(document.uri), document);
+    }
+    const uris = new Set(open.keys());
+    for (const root of this.roots) {
+      try {
+        for (const file of await collectFiles([root])) {
+          uris.add(pathToFileURL(file).href);
+        }
+      } catch {
+        // A workspace folder that has gone away has no symbols
+      }
+    }
+
+    // Files that cannot be read or parsed are left out of the results
+    const { tags } = await indexTags([...uris], async uri => {
+      const document = open.get(uri);
+      if (document && !document.oversized) {
+        return document.tree;
+      }
+      return readFile(fileURLToPath(uri), 'utf-8');
+    });
+    for (const tag of tags) {
+      tag.file = open.get(tag.file)?.uri ?? tag.file;
+    }
+    return workspaceSymbols(tags, query);
+  }
+
+  private handleNotification(method: string, params: unknown): void {
+    switch (method) {
+      case 'exit':
+        this.onExit(this.shutdownRequested ? 0 : 1);
+        break;
+      case 'textDocument/didOpen': {
+        const { textDocument } = params as TextDocumentParams;
+        this.updateDocument(textDocument.uri, textDocument.text ?? '');
+        break;
+      }
+      case 'textDocument/didChange': {
+        const { textDocument, contentChanges } = params as DidChangeParams;
+        this.changeDocument(textDocument.uri, contentChanges);
+        break;
+      }
+      case 'textDocument/didClose': {
+        const { textDocument } = params as TextDocumentParams;
+        this.documents.delete(textDocument.uri);
+        this.semanticTokens.delete(textDocument.uri);
+        this.publishDiagnostics(textDocument.uri, []);
+        break;
+      }
+      default:
+        // Other notifications (initialized, $/cancelRequest, ...) need no reply
+        break;
+    }
+  }
+
+  private getDocument(params: unknown): TextDocument {
+    const { textDocument } = params as TextDocumentParams;
+    const document = this.documents.get(textDocument.uri);
+    if (!document) {
+      throw new ResponseError(
+        ErrorCodes.InvalidRequest,
+        `Unknown document: ${textDocument.uri}`
+      );
+    }
+    return document;
+  }
+
+  private updateDocument(
+    uri: string,
+    content: string,
+    parse: () => SyntaxTree = () => parseTree(content)
+  ): void {
+    let tree: SyntaxTree;
+    try {
+      tree = parse();
+    } catch (error) {
+      if (!(error instanceof ParseLimitError)) {
+        throw error;
+      }
+      // Keep the text so later edits apply to it, but analyze nothing
+      this.documents.set(uri, {
+        uri,
+        content,
+        tree: parseTree(''),
+        imports: { declarations: [] },
+        oversized: true,
+      });
+      this.publishDiagnostics(uri, [limitDiagnostic(error)]);
+      return;
+    }
+
+    let diagnostics;
+    let imports: DocumentImports = { declarations: [] };
+    try {
+      imports = loadDocumentImports(uri, tree, content);
+      diagnostics = translateDiagnostics(documentTranslator(uri), [
+        ...collectDiagnostics(tree, content, imports),
+        ...this.toolCallDiagnostics(uri, tree, content, imports),
+      ]);
+    } catch (error) {
+      console.error(
+        `mcps lsp: failed to analyze ${uri}: ${
+          error instanceof Error ? error.message : String(error)
+        }`
+      );
+      diagnostics = [];
+    }
+    this.documents.set(uri, { uri, content, tree, imports });
+    this.publishDiagnostics(uri, diagnostics);
+  }
+
+  /**
+   * Apply editor changes in order, reparsing incrementally from the
+   * previous tree unless the whole document was replaced
+   */
+  private changeDocument(
+    uri: string,
+    changes: DidChangeParams['contentChanges']
+  ): void {
+    const document = this.documents.get(uri);
+    let content = document?.content ?? '';
+    let edits: SyntaxEdit[] | undefined = document ? [] : undefined;
+
+    for (const change of changes) {
+      if (change.range) {
+        const { start, end } = change.range;
+        const result = applyTextEdit(
+          content,
+          { row: start.line, column: start.character },
+          { row: end.line, column: end.character },
+          change.text
+        );
+        content = result.content;
+        edits?.push(result.edit);
+      } else {
+        content = change.text;
+        edits = undefined;
+      }
+    }
+
+    this.updateDocument(uri, content, () =>
+      document && edits && !document.oversized
+        ? reparseTree(document.tree, content, edits)
+        : parseTree(content)
+    );
+  }
+
+  /**
+   * Problems of a file document's MCP tool calls, checked against the
+   * schemas of its project's lockfile, if it has one, with quick fixes
+   */
+  private toolCallDiagnostics(
+    uri: string,
+    tree: SyntaxTree,
+    content: string,
+    imports: DocumentImports
+  ): Diagnostic[] {
+    if (!uri.startsWith('file:')) {
+      return [];
+    }
+    const dir = dirname(fileURLToPath(uri));
+    const lockPath = findToolsLock(dir);
+    if (!lockPath) {
+      return [];
+    }
+    try {
+      const modified = statSync(lockPath).mtimeMs;
+      let cached = this.toolCallRules.get(lockPath);
+      if (cached?.modified !== modified) {
+        const { servers } = readToolsLockSync(lockPath);
+        cached = { modified, rule: toolCallRule(servers) };
+        this.toolCallRules.set(lockPath, cached);
+      }
+      return collectLintDiagnostics(tree, content, imports, {
+        rules: [cached.rule],
+        severities: loadProjectConfigSync(dir).config.lint?.rules,
+      });
+    } catch (error) {
+      // The document's other problems are still shown
+      console.error(
+        `mcps lsp: ${error instanceof Error ? error.message : String(error)}`
+      );
+      return [];
+    }
+  }
+
+  private publishDiagnostics(uri: string, diagnostics: unknown[]): void {
+    this.connection.send({
+      jsonrpc: '2.0',
+      method: 'textDocument/publishDiagnostics',
+      params: { uri, diagnostics },
+    });
+  }
+}
+
+/**
+ * The translator of a document's diagnostics, chosen by the project config
+ * next to it
+ */
+function documentTranslator(uri: string): MessageTranslator {
+  if (!uri.startsWith('file:')) {
+    return new MessageTranslator();
+  }
+  return messageTranslator(loadProjectConfigSync(dirname(fileURLToPath(uri))));
+}
+
+/**
+ * The URI of a file in the form pathToFileURL gives, so that URIs editors
+ * encode differently compare equal
+ */
+function normalizeUri(uri: string): string {
+  return uri.startsWith('file:') ? pathToFileURL(fileURLToPath(uri)).href : uri;
+}
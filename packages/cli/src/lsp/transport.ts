@@ -0,0 +1,94 @@
+// JSON-RPC transport with LSP Content-Length framing, which the Debug
+// Adapter Protocol uses as well
+import type { Readable, Writable } from 'stream';
+import type { Message } from './protocol.js';
+
+const HEADER_DELIMITER = '\r\n\r\n';
+
+/**
+ * Incrementally decode framed messages from a byte stream
+ */
+export class MessageReader<T = Message> {
+  private buffer = Buffer.alloc(0);
+
+  /**
+   * Append a chunk and return every message it completes
+   */
+  push(chunk: Buffer): T[] {
+    this.buffer = Buffer.concat([this.buffer, chunk]);
+    const messages: T[] = [];
+
+    for (;;) {
+      const headerEnd = this.buffer.indexOf(HEADER_DELIMITER);
+      if (headerEnd === -1) {
+        break;
+      }
+
+      const header = this.buffer.subarray(0, headerEnd).toString('ascii');
+      const match = /Content-Length:\s*(\d+)/i.exec(header);
+      if (!match) {
+        // Nothing after a malformed header can be trusted
+        this.buffer = Buffer.alloc(0);
+        throw new Error(`Invalid message header: ${header}`);
+      }
+
+      const length = parseInt(match[1], 10);
+      const bodyStart = headerEnd + HEADER_DELIMITER.length;
+      if (this.buffer.length < bodyStart + length) {
+        break;
+      }
+
+      const body = this.buffer.subarray(bodyStart, bodyStart + length);
+      this.buffer = this.buffer.subarray(bodyStart + length);
+      messages.push(JSON.parse(body.toString('utf-8')) as T);
+    }
+
+    return messages;
+  }
+}
+
+/**
+ * Encode a message with its Content-Length header
+ */
+export function encodeMessage(message: object): Buffer {
+  const body = Buffer.from(JSON.stringify(message), 'utf-8');
+  const header = `Content-Length: ${body.length}${HEADER_DELIMITER}`;
+  return Buffer.concat([Buffer.from(header, 'ascii'), body]);
+}
+
+/**
+ * A bidirectional message connection over a pair of streams
+ */
+export class Connection<T = Message> {
+  private readonly reader = new MessageReader<T>();
+
+  constructor(
+    private readonly input: Readable,
+    private readonly output: Writable
+  ) {}
+
+  /**
+   * Start delivering incoming messages to the handler
+   */
+  listen(
+    onMessage: (message: T) => void,
+    onError: (error: Error) => void = () => {}
+  ): void {
+    this.input.on('data', (chunk: Buffer) => {
+      let messages: T[];
+      try {
+        messages = this.reader.push(chunk);
+      } catch (error) {
+        onError(error instanceof Error ? error : new Error(String(error)));
+        return;
+      }
+      for (const message of messages) {
+        onMessage(message);
+      }
+    });
+  }
+
+  send(message: T): void {
+    this.output.write(encodeMessage(message));
+  }
+}
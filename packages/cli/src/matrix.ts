@@ -0,0 +1,136 @@
+// Parameter sweeps of scripts: `mcps run <file> --matrix params.json`
+//
+// The matrix file gives the values to try for the parameters of the
+// script's main tool, such as { "model": ["gpt-4o", "claude"] }, and the
+// script is run once per combination. The results are printed as a table
+// of the runs, followed by how the runs with each value went.
+import { readFile } from 'fs/promises';
+import type { ToolDoc } from '@mcpscript/transpiler';
+import type {
+  MatrixAggregate,
+  MatrixRun,
+  ParameterMatrix,
+} from '@mcpscript/runtime';
+import { flagName, scriptFlags } from './arguments.js';
+
+// Results longer than this are cut short in the table
+const RESULT_WIDTH = 40;
+
+/**
+ * Read a matrix file
+ */
+export async function readMatrix(path: string): Promise<ParameterMatrix> {
+  let matrix: unknown;
+  try {
+    matrix = JSON.parse(await readFile(path, 'utf-8'));
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`Cannot read matrix ${path}: ${message}`, {
+      cause: error,
+    });
+  }
+  if (typeof matrix !== 'object' || matrix === null || Array.isArray(matrix)) {
+    throw new Error(
+      `Matrix ${path} must be an object of the values to try by parameter name`
+    );
+  }
+  return matrix as ParameterMatrix;
+}
+
+/**
+ * Check that a matrix names parameters of main and gives a value for each
+ * parameter main requires, since otherwise every run would fail alike
+ */
+export function checkMatrix(
+  matrix: ParameterMatrix,
+  main: ToolDoc | undefined,
+  file: string
+): void {
+  if (!main) {
+    throw new Error(
+      `${file} declares no main tool, so a matrix has no parameters to vary`
+    );
+  }
+  const flags = scriptFlags(main);
+  const unknown = Object.keys(matrix).filter(
+    name => !flags.some(flag => flag.name === name)
+  );
+  if (unknown.length > 0) {
+    throw new Error(`main has no parameter named ${unknown.join(', ')}`);
+  }
+  const missing = flags.filter(flag => flag.required && !(flag.name in matrix));
+  if (missing.length > 0) {
+    throw new Error(
+      `The matrix gives no values for ${missing.map(flag => flag.name).join(', ')}, which main requires`
+    );
+  }
+}
+
+function formatValue(value: unknown): string {
+  const text = typeof value === 'string' ? value : JSON.stringify(value);
+  if (text === undefined) {
+    return '-';
+  }
+  return text.length > RESULT_WIDTH
+    ? `${text.slice(0, RESULT_WIDTH - 3)}...`
+    : text;
+}
+
+function formatDuration(ms: number): string {
+  return ms < 1000 ? `${Math.round(ms)} ms` : `${(ms / 1000).toFixed(2)} s`;
+}
+
+function formatTable(rows: string[][]): string[] {
+  const widths = rows[0].map((_, column) =>
+    Math.max(...rows.map(row => row[column].length))
+  );
+  return rows.map(row =>
+    row
+      .map((cell, column) => cell.padEnd(widths[column]))
+      .join('  ')
+      .trimEnd()
+  );
+}
+
+/**
+ * The table of a matrix's runs, in the order of their combinations, then
+ * how the runs with each value of the parameters varied went
+ */
+export function formatMatrixResults(
+  runs: MatrixRun[],
+  aggregates: MatrixAggregate[]
+): string {
+  const parameters = [...new Set(runs.flatMap(run => Object.keys(run.args)))];
+  const lines = formatTable([
+    ['#', ...parameters.map(flagName), 'status', 'time', 'result'],
+    ...runs.map((run, index) => [
+      String(index + 1),
+      ...parameters.map(name => formatValue(run.args[name])),
+      run.status,
+      formatDuration(run.durationMs),
+      run.status === 'ok' ? formatValue(run.result) : (run.error ?? ''),
+    ]),
+  ]);
+
+  if (aggregates.length > 0) {
+    lines.push(
+      '',
+      ...formatTable([
+        ['value', 'runs', 'failed', 'mean time'],
+        ...aggregates.map(aggregate => [
+          `${flagName(aggregate.parameter)} ${formatValue(aggregate.value)}`,
+          String(aggregate.runs),
+          String(aggregate.failed),
+          formatDuration(aggregate.meanMs),
+        ]),
+      ])
+    );
+  }
+
+  const failed = runs.filter(run => run.status === 'failed').length;
+  lines.push(
+    '',
+    `${runs.length} run${runs.length === 1 ? '' : 's'}, ${failed} failed`
+  );
+  return `${lines.join('\n')}\n`;
+}
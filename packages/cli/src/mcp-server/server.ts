@@ -0,0 +1,407 @@
+// MCP server exposing workflows as tools, for mcps serve --mcp
+//
+// Each workflow is a tool described by the comment its script starts with
+// and the doc comment of its main tool, whose parameters are the tool's
+// arguments, typed by their annotations and described by their @param
+// lines; the project's .mcpsrc can replace any of these descriptions. A
+// call runs the workflow in a worker process, as mcpsd runs do. When the
+// client sends a progress token with the call, the run's output is
+// reported as progress notifications while it happens, and runs that are
+// quiet for a while report that they are still running, so hosts that
+// extend their timeouts on progress keep waiting for long workflows. The
+// output also makes up the result, one content item per message.
+import { readFile } from 'fs/promises';
+import { basename, dirname, resolve } from 'path';
+import {
+  collectDocs,
+  parseSource,
+  typeToJsonSchema,
+  type ToolDeclaration,
+} from '@mcpscript/transpiler';
+import type { JSONSchema, ScriptArguments } from '@mcpscript/runtime';
+import {
+  ErrorCodes,
+  type Message,
+  type RequestMessage,
+} from '../lsp/protocol.js';
+import type { RunEvent } from '../remote/protocol.js';
+import type { RunManager } from '../remote/runs.js';
+import { loadProjectConfig, type ToolDescriptionConfig } from '../config.js';
+import type { LineConnection } from './transport.js';
+import packageJson from '../../package.json' with { type: 'json' };
+
+/** Protocol version answered to clients that do not ask for one */
+export const MCP_PROTOCOL_VERSION = '2025-06-18';
+/** Milliseconds a run may be quiet before progress is reported anyway */
+export const DEFAULT_HEARTBEAT = 10_000;
+
+/**
+ * A script served as a tool
+ */
+export interface Workflow {
+  /** Tool name: the file name without .mcps */
+  name: string;
+  /** Absolute path of the script */
+  file: string;
+  description: string;
+  /** Arguments of the script's main tool, if it declares one */
+  inputSchema: JSONSchema;
+}
+
+/**
+ * Description and input schema of a workflow, from the doc comments and
+ * parameter types of its script, with the descriptions of an override in
+ * their place
+ */
+export function describeWorkflow(
+  source: string,
+  file: string,
+  override: ToolDescriptionConfig = {}
+): Pick<Workflow, 'description' | 'inputSchema'> {
+  const statements = parseSource(source);
+  const docs = collectDocs(statements, source);
+  const main = docs.tools.find(tool => tool.name === 'main');
+  const declaration = statements.find(
+    (statement): statement is ToolDeclaration =>
+      statement.type === 'tool_declaration' && statement.name === 'main'
+  );
+
+  // Lines of a paragraph are joined, as hosts wrap descriptions themselves
+  const paragraphs = [docs.doc, main?.doc]
+    .flatMap(doc => doc?.split(/\n\s*\n/) ?? [])
+    .map(paragraph => paragraph.replace(/\s*\n\s*/g, ' '));
+  const description =
+    override.description ??
+    (paragraphs.length > 0
+      ? paragraphs.join('\n\n')
+      : `Run the workflow ${basename(file)}`);
+
+  const parameters = declaration?.parameters ?? [];
+  for (const name of Object.keys(override.parameters ?? {})) {
+    if (!parameters.some(parameter => parameter.name === name)) {
+      throw new Error(
+        `${file}: main has no parameter named ${name}, which .mcpsrc describes`
+      );
+    }
+  }
+  const properties: Record<string, JSONSchema> = {};
+  const required: string[] = [];
+  for (const parameter of parameters) {
+    const doc = main?.parameters.find(p => p.name === parameter.name);
+    const text = override.parameters?.[parameter.name] ?? doc?.doc;
+    properties[parameter.name] = {
+      ...(parameter.typeAnnotation &&
+        typeToJsonSchema(parameter.typeAnnotation)),
+      ...(text && { description: text }),
+      ...defaultOf(doc?.default),
+    };
+    if (!parameter.optional && !parameter.defaultValue) {
+      required.push(parameter.name);
+    }
+  }
+  return {
+    description,
+    inputSchema: {
+      type: 'object',
+      properties,
+      ...(required.length > 0 && { required }),
+    },
+  };
+}
+
+/**
+ * The JSON Schema default of a parameter's default value, when it is
+ * written as JSON; computed defaults are left out
+ */
+function defaultOf(value: string | undefined): JSONSchema {
+  if (value === undefined) {
+    return {};
+  }
+  try {
+    return { default: JSON.parse(value) };
+  } catch {
+    return {};
+  }
+}
+
+/**
+ * Workflows of the given scripts; file names must make distinct tools
+ */
+export async function loadWorkflows(files: string[]): Promise<Workflow[]> {
+  const workflows = new Map<string, Workflow>();
+  for (const path of files) {
+    const file = resolve(path);
+    const name = basename(file, '.mcps').replace(/[^A-Za-z0-9_-]/g, '_');
+    const existing = workflows.get(name);
+    if (existing) {
+      throw new Error(
+        `${existing.file} and ${file} would both be served as the tool ${name}`
+      );
+    }
+    const source = await readFile(file, 'utf-8');
+    const { config } = await loadProjectConfig(dirname(file));
+    workflows.set(name, {
+      name,
+      file,
+      ...describeWorkflow(source, file, config.serve?.tools?.[name]),
+    });
+  }
+  return [...workflows.values()];
+}
+
+export interface WorkflowServerOptions {
+  /** Execution timeout of each call in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** Milliseconds a run may be quiet before progress is reported anyway */
+  heartbeat?: number;
+}
+
+interface CallToolParams {
+  name?: unknown;
+  arguments?: unknown;
+  _meta?: { progressToken?: string | number };
+}
+
+class ResponseError extends Error {
+  constructor(
+    public readonly code: number,
+    message: string
+  ) {
+    super(message);
+  }
+}
+
+/**
+ * Serves workflows as the tools of an MCP server over a connection
+ */
+export class WorkflowServer {
+  private readonly workflows: Map<string, Workflow>;
+  /** Cancels the runs of calls in progress, by request id */
+  private readonly calls = new Map<string | number, () => void>();
+  /** Calls the client cancelled, which are not answered */
+  private readonly cancelled = new Set<string | number>();
+
+  constructor(
+    private readonly connection: LineConnection,
+    workflows: Workflow[],
+    private readonly runs: RunManager,
+    private readonly options: WorkflowServerOptions = {}
+  ) {
+    this.workflows = new Map(workflows.map(w => [w.name, w]));
+  }
+
+  start(): void {
+    this.connection.listen(
+      message => this.handleMessage(message),
+      error => console.error(`mcps serve: ${error.message}`)
+    );
+  }
+
+  handleMessage(message: Message): void {
+    if (!('method' in message)) {
+      // The server sends no requests, so there are no responses to handle
+      return;
+    }
+    if (!('id' in message)) {
+      this.handleNotification(message.method, message.params);
+      return;
+    }
+    const request = message;
+    this.dispatchRequest(request).then(
+      result => {
+        if (!this.cancelled.delete(request.id)) {
+          this.connection.send({ jsonrpc: '2.0', id: request.id, result });
+        }
+      },
+      error =>
+        this.connection.send({
+          jsonrpc: '2.0',
+          id: request.id,
+          error: {
+            code:
+              error instanceof ResponseError
+                ? error.code
+                : ErrorCodes.InternalError,
+            message: error instanceof Error ? error.message : String(error),
+          },
+        })
+    );
+  }
+
+  private handleNotification(method: string, params: unknown): void {
+    // Other notifications, such as notifications/initialized, need nothing
+    if (method === 'notifications/cancelled') {
+      const { requestId } = (params ?? {}) as { requestId?: string | number };
+      const cancel = requestId !== undefined && this.calls.get(requestId);
+      if (cancel) {
+        this.cancelled.add(requestId);
+        cancel();
+      }
+    }
+  }
+
+  private async dispatchRequest(request: RequestMessage): Promise<unknown> {
+    switch (request.method) {
+      case 'initialize': {
+        const { protocolVersion } = (request.params ?? {}) as {
+          protocolVersion?: unknown;
+        };
+        return {
+          // Tools are all this server offers, which every version supports
+          protocolVersion:
+            typeof protocolVersion === 'string'
+              ? protocolVersion
+              : MCP_PROTOCOL_VERSION,
+          capabilities: { tools: {} },
+          serverInfo: { name: 'mcps', version: packageJson.version },
+        };
+      }
+      case 'ping':
+        return {};
+      case 'tools/list':
+        return {
+          tools: [...this.workflows.values()].map(workflow => ({
+            name: workflow.name,
+            description: workflow.description,
+            inputSchema: workflow.inputSchema,
+          })),
+        };
+      case 'tools/call':
+        return this.callTool(request);
+      default:
+        throw new ResponseError(
+          ErrorCodes.MethodNotFound,
+          `Unknown method ${request.method}`
+        );
+    }
+  }
+
+  private async callTool(request: RequestMessage): Promise<unknown> {
+    const {
+      name,
+      arguments: args,
+      _meta,
+    } = (request.params ?? {}) as CallToolParams;
+    const workflow =
+      typeof name === 'string' ? this.workflows.get(name) : undefined;
+    if (!workflow) {
+      throw new ResponseError(
+        ErrorCodes.InvalidParams,
+        `Unknown tool ${String(name)}`
+      );
+    }
+    if (
+      args !== undefined &&
+      (typeof args !== 'object' || args === null || Array.isArray(args))
+    ) {
+      throw new ResponseError(
+        ErrorCodes.InvalidParams,
+        'Tool arguments must be an object'
+      );
+    }
+    // Arguments are checked against main's parameters when the run calls it
+    if (
+      args &&
+      Object.keys(args).length > 0 &&
+      Object.keys(workflow.inputSchema.properties ?? {}).length === 0
+    ) {
+      throw new ResponseError(
+        ErrorCodes.InvalidParams,
+        `Tool ${workflow.name} takes no arguments`
+      );
+    }
+
+    // Scripts are read for each call, so edits apply without a restart
+    const source = await readFile(workflow.file, 'utf-8');
+    const run = this.runs.submit({
+      tenant: 'mcp',
+      file: workflow.file,
+      source,
+      timeout: this.options.timeout,
+      cwd: dirname(workflow.file),
+      args: args as ScriptArguments | undefined,
+    });
+    this.calls.set(request.id, () => run.cancel());
+
+    const token = _meta?.progressToken;
+    let progress = 0;
+    const report = (message: string) => {
+      if (token !== undefined) {
+        this.connection.send({
+          jsonrpc: '2.0',
+          method: 'notifications/progress',
+          params: { progressToken: token, progress: ++progress, message },
+        });
+      }
+    };
+
+    const started = Date.now();
+    const heartbeat = this.options.heartbeat ?? DEFAULT_HEARTBEAT;
+    let timer: NodeJS.Timeout | undefined;
+    const waitQuietly = () => {
+      clearTimeout(timer);
+      timer = setTimeout(() => {
+        const seconds = Math.round((Date.now() - started) / 1000);
+        report(`${workflow.name} is still running after ${seconds} s`);
+        waitQuietly();
+      }, heartbeat);
+    };
+    waitQuietly();
+
+    // Consecutive printed output is one item of the result
+    const content: { text: string; printed: boolean }[] = [];
+    const onEvent = (event: RunEvent) => {
+      let text: string;
+      switch (event.type) {
+        case 'output':
+          if (event.stream === 'stderr') {
+            // Logs of the workflow's MCP servers go to the host's logs
+            process.stderr.write(event.text);
+            return;
+          }
+          text = event.text;
+          break;
+        case 'message':
+          text = `${event.title}: ${event.body}`;
+          break;
+        case 'error':
+          text = event.message;
+          break;
+        case 'exit':
+          return;
+      }
+      const printed = event.type === 'output';
+      const last = content[content.length - 1];
+      if (printed && last?.printed) {
+        last.text += text;
+      } else {
+        content.push({ text, printed });
+      }
+      report(text.trimEnd());
+      waitQuietly();
+    };
+    run.on('event', onEvent);
+
+    return new Promise(resolve => {
+      run.once('finish', () => {
+        clearTimeout(timer);
+        run.off('event', onEvent);
+        this.calls.delete(request.id);
+        const failed = run.exitCode !== 0;
+        if (content.length === 0) {
+          const outcome = failed
+            ? `failed with exit code ${run.exitCode}`
+            : 'finished';
+          content.push({ text: `${workflow.name} ${outcome}`, printed: false });
+        }
+        resolve({
+          content: content.map(({ text }) => ({
+            type: 'text',
+            text: text.trimEnd(),
+          })),
+          isError: failed,
+        });
+      });
+    });
+  }
+}
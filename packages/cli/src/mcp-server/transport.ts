@@ -0,0 +1,62 @@
+// JSON-RPC transport for MCP over stdio: one message per line
+import { StringDecoder } from 'string_decoder';
+import type { Readable, Writable } from 'stream';
+import type { Message } from '../lsp/protocol.js';
+
+/**
+ * Incrementally split a byte stream into lines
+ */
+export class LineReader {
+  private buffer = '';
+  private readonly decoder = new StringDecoder('utf-8');
+
+  /**
+   * Append a chunk and return every non-empty line it completes
+   */
+  push(chunk: Buffer | string): string[] {
+    // Characters split across chunks are completed by the next one
+    this.buffer +=
+      typeof chunk === 'string' ? chunk : this.decoder.write(chunk);
+    const lines = this.buffer.split('\n');
+    this.buffer = lines.pop() ?? '';
+    return lines.map(line => line.trim()).filter(line => line.length > 0);
+  }
+}
+
+/**
+ * A bidirectional line-delimited message connection over a pair of streams
+ */
+export class LineConnection {
+  private readonly reader = new LineReader();
+
+  constructor(
+    private readonly input: Readable,
+    private readonly output: Writable
+  ) {}
+
+  /**
+   * Start delivering incoming messages to the handler
+   */
+  listen(
+    onMessage: (message: Message) => void,
+    onError: (error: Error) => void = () => {}
+  ): void {
+    this.input.on('data', (chunk: Buffer) => {
+      for (const line of this.reader.push(chunk)) {
+        let message: Message;
+        try {
+          message = JSON.parse(line) as Message;
+        } catch (error) {
+          // A malformed line does not affect the lines after it
+          onError(error instanceof Error ? error : new Error(String(error)));
+          continue;
+        }
+        onMessage(message);
+      }
+    });
+  }
+
+  send(message: Message): void {
+    this.output.write(`${JSON.stringify(message)}\n`);
+  }
+}
@@ -0,0 +1,77 @@
+// Language of the diagnostics the CLI writes
+//
+// `mcps check`, `mcps run` and the language server write diagnostics in
+// the language named by $MCPS_LANG, or else by "messages.language" in
+// .mcpsrc, such as "de". Codes and rule names stay in English, so tools
+// matching on them work in every language:
+//
+//   { "messages": { "language": "fr", "catalogs": { "fr": "fr.json" } } }
+//
+// A project's catalog for the language is tried before the built-in one,
+// and messages neither translates stay in English.
+import { readFileSync } from 'fs';
+import { dirname, resolve } from 'path';
+import {
+  MessageTranslator,
+  messageCatalog,
+  type MessageCatalog,
+} from '@mcpscript/transpiler';
+import type { LoadedConfig } from './config.js';
+import type { Diagnostic } from './lsp/protocol.js';
+
+/**
+ * The language diagnostics are written in, undefined for English
+ */
+export function messageLanguage(loaded: LoadedConfig): string | undefined {
+  const language =
+    process.env.MCPS_LANG || loaded.config.messages?.language || undefined;
+  return language && !/^en\b/i.test(language) ? language : undefined;
+}
+
+/**
+ * Read a project's message catalog
+ */
+function readCatalog(path: string): MessageCatalog {
+  const catalog: unknown = JSON.parse(readFileSync(path, 'utf-8'));
+  if (
+    typeof catalog !== 'object' ||
+    catalog === null ||
+    Array.isArray(catalog) ||
+    Object.values(catalog).some(text => typeof text !== 'string')
+  ) {
+    throw new Error(`Message catalog ${path} must map messages to strings`);
+  }
+  return catalog as MessageCatalog;
+}
+
+/**
+ * The translator of a project's diagnostics, which leaves messages in
+ * English when no other language is chosen
+ */
+export function messageTranslator(loaded: LoadedConfig): MessageTranslator {
+  const language = messageLanguage(loaded);
+  if (!language) {
+    return new MessageTranslator();
+  }
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  const catalogs = loaded.config.messages?.catalogs ?? {};
+  const own = catalogs[language] ?? catalogs[language.split(/[-_.]/)[0]];
+  const builtIn = messageCatalog(language);
+  return new MessageTranslator(
+    ...(own ? [readCatalog(resolve(base, own))] : []),
+    ...(builtIn ? [builtIn] : [])
+  );
+}
+
+/**
+ * Diagnostics with their messages translated
+ */
+export function translateDiagnostics(
+  translator: MessageTranslator,
+  diagnostics: Diagnostic[]
+): Diagnostic[] {
+  return diagnostics.map(diagnostic => ({
+    ...diagnostic,
+    message: translator.translate(diagnostic.message),
+  }));
+}
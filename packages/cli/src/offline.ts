@@ -0,0 +1,68 @@
+// Offline mode of the CLI: mcps --offline, or MCPS_OFFLINE=1
+//
+// Offline runs refuse everything that would reach another host (see
+// offline.ts of the runtime). The servers and models a script declares are
+// checked before it starts, so a script that could only fail offline is
+// refused without starting any of its servers; urls built from variables
+// are left to be checked when the script runs. Commands that only talk to
+// the network, such as runs on a remote executor, are refused outright.
+import { collectCapabilities, type Statement } from '@mcpscript/transpiler';
+import { isLoopbackURL, modelEndpoint } from '@mcpscript/runtime';
+
+/** Environment variable turning offline mode on, set by --offline */
+export const OFFLINE_VARIABLE = 'MCPS_OFFLINE';
+
+/**
+ * Whether the CLI runs offline
+ */
+export function offlineMode(): boolean {
+  const value = process.env[OFFLINE_VARIABLE]?.trim().toLowerCase();
+  return !!value && value !== '0' && value !== 'false';
+}
+
+/**
+ * Error refusing to run a script offline that declares servers or models
+ * on other hosts
+ */
+export class OfflineScriptError extends Error {
+  constructor(public readonly needs: string[]) {
+    super(
+      [
+        'The script needs the network, which offline mode does not allow:',
+        ...needs.map(need => `  ${need}`),
+      ].join('\n')
+    );
+    this.name = 'OfflineScriptError';
+  }
+}
+
+const isDynamic = (value: string) =>
+  value.includes('${') || value.includes('<dynamic>');
+
+/**
+ * Throw an OfflineScriptError when a script declares servers or models
+ * that need the network
+ */
+export function checkScriptOffline(statements: Statement[]): void {
+  const { servers, models } = collectCapabilities(statements);
+  const needs: string[] = [];
+  for (const { name, url } of servers) {
+    if (url !== undefined && !isDynamic(url) && !isLoopbackURL(url)) {
+      needs.push(`server ${name} (${url})`);
+    }
+  }
+  for (const { name, provider, baseURL } of models) {
+    if (provider === undefined || isDynamic(provider)) {
+      continue;
+    }
+    const endpoint = modelEndpoint({ provider, baseURL });
+    if (endpoint === undefined) {
+      needs.push(`model ${name} (${provider})`);
+    } else if (!isDynamic(endpoint) && !isLoopbackURL(endpoint)) {
+      needs.push(`model ${name} (${endpoint})`);
+    }
+  }
+  if (needs.length > 0) {
+    throw new OfflineScriptError(needs);
+  }
+}
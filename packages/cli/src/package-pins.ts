@@ -0,0 +1,112 @@
+// Package pins of mcps lock
+//
+// `mcps lock` resolves the npm and PyPI packages stdio servers run to
+// exact versions, with the integrity the npm registry publishes for them,
+// and keeps them in mcps-tools.lock.json. Runs then start the servers at
+// those versions (see packages.ts of the runtime). A package already
+// pinned keeps its version when the script does not name one, until
+// `mcps lock --update`; locking a pinned version again checks that the
+// registry still publishes it with the same integrity.
+import {
+  collectCapabilities,
+  type MCPDeclaration,
+} from '@mcpscript/transpiler';
+import { checkOffline, type PinnedPackage } from '@mcpscript/runtime';
+import { serverPackage, type ServerPackage } from './audit.js';
+import { offlineMode } from './offline.js';
+
+interface NpmVersion {
+  version?: string;
+  dist?: { integrity?: string };
+}
+
+interface PyPIRelease {
+  info?: { version?: string };
+}
+
+/**
+ * Resolve a package to the exact version the registry has for it: the
+ * given version, or else the latest one
+ */
+export async function resolvePackage(
+  pkg: Pick<ServerPackage, 'ecosystem' | 'name'>,
+  version: string | undefined,
+  fetchImpl: typeof fetch = fetch
+): Promise<PinnedPackage> {
+  const { ecosystem, name } = pkg;
+  const url =
+    ecosystem === 'npm'
+      ? `https://registry.npmjs.org/${name.replace('/', '%2f')}/${version ?? 'latest'}`
+      : `https://pypi.org/pypi/${name}/${version ? `${version}/` : ''}json`;
+  const response = await fetchImpl(url);
+  if (response.status === 404) {
+    throw new Error(
+      `${ecosystem} has no ${version ? `version ${version} of ` : ''}package ${name}`
+    );
+  }
+  if (!response.ok) {
+    throw new Error(`${url} answered ${response.status}`);
+  }
+  if (ecosystem === 'npm') {
+    const body = (await response.json()) as NpmVersion;
+    if (!body.version) {
+      throw new Error(`${url} did not answer with a version`);
+    }
+    return {
+      ecosystem,
+      name,
+      version: body.version,
+      ...(body.dist?.integrity && { integrity: body.dist.integrity }),
+    };
+  }
+  const body = (await response.json()) as PyPIRelease;
+  if (!body.info?.version) {
+    throw new Error(`${url} did not answer with a version`);
+  }
+  return { ecosystem, name, version: body.info.version };
+}
+
+/**
+ * Pin the packages of server declarations, keeping the versions of those
+ * already pinned unless `update` is set
+ */
+export async function pinPackages(
+  servers: MCPDeclaration[],
+  pinned: Record<string, PinnedPackage> = {},
+  options: { update?: boolean; fetchImpl?: typeof fetch } = {}
+): Promise<Record<string, PinnedPackage>> {
+  const pins: Record<string, PinnedPackage> = {};
+  const packages = collectCapabilities(servers).servers.flatMap(
+    server => serverPackage(server) ?? []
+  );
+  for (const pkg of packages) {
+    const previous = pinned[pkg.server];
+    const kept =
+      previous?.ecosystem === pkg.ecosystem && previous.name === pkg.name
+        ? previous
+        : undefined;
+    const version = pkg.version ?? (options.update ? undefined : kept?.version);
+
+    // Offline, pins that need no lookup are kept as they are
+    if (kept && kept.version === version && offlineMode()) {
+      pins[pkg.server] = kept;
+      continue;
+    }
+    if (offlineMode()) {
+      checkOffline(`pinning ${pkg.name}`);
+    }
+
+    const pin = await resolvePackage(pkg, version, options.fetchImpl);
+    if (
+      kept?.integrity &&
+      kept.version === pin.version &&
+      pin.integrity !== kept.integrity
+    ) {
+      throw new Error(
+        `${pin.name} ${pin.version} is published with another integrity than when it was locked (${kept.integrity})`
+      );
+    }
+    pins[pkg.server] = pin;
+  }
+  return pins;
+}
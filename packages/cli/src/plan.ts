@@ -0,0 +1,730 @@
+// Dry-run plans: what running a script would set up and call, without
+// running it
+//
+// Plans come from the statements alone. Calls are listed in the order the
+// script makes them, with tools inlined where they are called; values
+// known before the run are filled into their arguments, and results of
+// earlier calls appear as $1, $2, ... Calls in branches, loops and parallel
+// blocks list the blocks they are made in, since they may not happen or
+// happen more than once.
+import type {
+  BinaryExpression,
+  CallExpression,
+  Expression,
+  ObjectLiteral,
+  Statement,
+  ToolDeclaration,
+} from '@mcpscript/transpiler';
+
+/**
+ * A value from the script: literals as they are, anything computed at run
+ * time (env.API_KEY, "a" + b...) as a short description of its source
+ */
+export type PlanValue =
+  | string
+  | number
+  | boolean
+  | PlanValue[]
+  | { [key: string]: PlanValue };
+
+export interface ServerPlan {
+  name: string;
+  /** Command and arguments of a server started as a local process */
+  command?: PlanValue;
+  args?: PlanValue;
+  /** Address of a remote server */
+  url?: PlanValue;
+  transport?: PlanValue;
+}
+
+export interface ModelPlan {
+  name: string;
+  provider?: PlanValue;
+  model?: PlanValue;
+}
+
+export interface AgentPlan {
+  name: string;
+  model?: PlanValue;
+  tools: PlanValue[];
+}
+
+export interface ToolPlan {
+  name: string;
+  parameters: string[];
+}
+
+/**
+ * An MCP tool call or agent run the script would make
+ */
+export interface CallPlan {
+  /** How later calls refer to the result: "$1", "$2", ... */
+  result: string;
+  kind: 'tool' | 'agent';
+  /** Tool as "server.tool", or the agent's name */
+  target: string;
+  /**
+   * Arguments as source text with known values filled in, such as
+   * "/tmp/notes.txt" or "Summarize: " + $1; an agent's is its input
+   */
+  arguments: string[];
+  /**
+   * Blocks the call is made in, outermost first: "if $1 != \"\"",
+   * "while more", "parallel", "tool summarize"...
+   */
+  within: string[];
+}
+
+/**
+ * The servers a run would start, the models, agents and tools it would
+ * create and the calls it would make. Option values that may hold secrets
+ * (API keys, headers, server environment) are left out
+ */
+export interface RunPlan {
+  servers: ServerPlan[];
+  models: ModelPlan[];
+  agents: AgentPlan[];
+  tools: ToolPlan[];
+  calls: CallPlan[];
+}
+
+/**
+ * Source-like description of an expression that is only known at run time
+ */
+function describeExpression(expression: Expression): string {
+  switch (expression.type) {
+    case 'identifier':
+      return expression.name;
+    case 'string':
+      return JSON.stringify(expression.value);
+    case 'number':
+    case 'boolean':
+      return String(expression.value);
+    case 'member':
+      return `${describeExpression(expression.object)}.${expression.property}`;
+    case 'bracket':
+      return (
+        `${describeExpression(expression.object)}` +
+        `[${describeExpression(expression.index)}]`
+      );
+    case 'call':
+      return (
+        `${describeExpression(expression.callee)}` +
+        `(${expression.arguments.map(describeExpression).join(', ')})`
+      );
+    case 'unary':
+      return `${expression.operator}${describeExpression(expression.operand)}`;
+    case 'binary':
+      return [
+        describeExpression(expression.left),
+        expression.operator,
+        describeExpression(expression.right),
+      ].join(' ');
+    case 'array':
+      return `[${expression.elements.map(describeExpression).join(', ')}]`;
+    case 'object':
+      return `{ ${expression.properties
+        .map(({ key, value }) => `${key}: ${describeExpression(value)}`)
+        .join(', ')} }`;
+  }
+}
+
+export function planValue(expression: Expression): PlanValue {
+  switch (expression.type) {
+    case 'string':
+    case 'number':
+    case 'boolean':
+      return expression.value;
+    case 'array':
+      return expression.elements.map(planValue);
+    case 'object':
+      return Object.fromEntries(
+        expression.properties.map(({ key, value }) => [key, planValue(value)])
+      );
+    default:
+      return describeExpression(expression);
+  }
+}
+
+function option(config: ObjectLiteral, key: string): PlanValue | undefined {
+  const property = config.properties.find(p => p.key === key);
+  return property ? planValue(property.value) : undefined;
+}
+
+/**
+ * Plan of a script's top-level declarations
+ * Statements are only inspected, so scripts cannot start processes or
+ * reach the network while a plan is made
+ */
+export function planRun(statements: Statement[]): RunPlan {
+  const plan: RunPlan = {
+    servers: [],
+    models: [],
+    agents: [],
+    tools: [],
+    calls: [],
+  };
+  for (const statement of statements) {
+    switch (statement.type) {
+      case 'mcp_declaration': {
+        const { name, config } = statement;
+        const server: ServerPlan = { name };
+        for (const key of ['command', 'args', 'url', 'transport'] as const) {
+          const value = option(config, key);
+          if (value !== undefined) {
+            server[key] = value;
+          }
+        }
+        plan.servers.push(server);
+        break;
+      }
+      case 'model_declaration':
+        plan.models.push({
+          name: statement.name,
+          provider: option(statement.config, 'provider'),
+          model: option(statement.config, 'model'),
+        });
+        break;
+      case 'agent_declaration': {
+        const tools = option(statement.config, 'tools');
+        plan.agents.push({
+          name: statement.name,
+          model: option(statement.config, 'model'),
+          tools: Array.isArray(tools) ? tools : [],
+        });
+        break;
+      }
+      case 'tool_declaration':
+      case 'pipeline_declaration':
+        plan.tools.push({
+          name: statement.name,
+          parameters: statement.parameters.map(p => p.name),
+        });
+        break;
+    }
+  }
+  plan.calls = new CallTracer(plan, statements).trace(statements);
+  return plan;
+}
+
+/**
+ * A value known before the run, or the source text it is computed from
+ */
+type Traced = { known: true; value: unknown } | { known: false; text: string };
+
+type Scope = Map<string, Traced>;
+
+function known(value: unknown): Traced {
+  return { known: true, value };
+}
+
+function computed(text: string): Traced {
+  return { known: false, text };
+}
+
+function sourceText(traced: Traced): string {
+  return traced.known
+    ? (JSON.stringify(traced.value) ?? 'null')
+    : traced.text;
+}
+
+function isPrimitive(value: unknown): boolean {
+  return value === null || typeof value !== 'object';
+}
+
+/**
+ * Value of a binary expression whose operands are known, or undefined
+ * when the operator does not compute one before the run
+ */
+function fold(
+  operator: BinaryExpression['operator'],
+  left: unknown,
+  right: unknown
+): Traced | undefined {
+  // Operands are numbers, strings, booleans and null here
+  const [a, b] = [left, right] as [number, number];
+  switch (operator) {
+    case '+':
+      return known(a + b);
+    case '-':
+      return known(a - b);
+    case '*':
+      return known(a * b);
+    case '/':
+      return known(a / b);
+    case '%':
+      return known(a % b);
+    case '==':
+      return known(a == b);
+    case '!=':
+      return known(a != b);
+    case '<':
+      return known(a < b);
+    case '>':
+      return known(a > b);
+    case '<=':
+      return known(a <= b);
+    case '>=':
+      return known(a >= b);
+    case '&&':
+      return known(a && b);
+    case '||':
+      return known(a || b);
+    case '??':
+      return known(a ?? b);
+    default:
+      return undefined;
+  }
+}
+
+/**
+ * Variables a statement may assign, which are not known after it when it
+ * runs conditionally or repeatedly
+ */
+function assignedNames(statement: Statement, names = new Set<string>()) {
+  switch (statement.type) {
+    case 'assignment':
+      if (statement.target.type === 'identifier') {
+        names.add(statement.target.name);
+      }
+      break;
+    case 'block_statement':
+      statement.statements.forEach(s => assignedNames(s, names));
+      break;
+    case 'if_statement':
+      assignedNames(statement.then, names);
+      if (statement.else) {
+        assignedNames(statement.else, names);
+      }
+      break;
+    case 'while_statement':
+      assignedNames(statement.body, names);
+      break;
+    case 'for_statement':
+      for (const part of [statement.init, statement.update, statement.body]) {
+        if (part) {
+          assignedNames(part, names);
+        }
+      }
+      break;
+    case 'parallel_statement':
+      statement.branches.forEach(s => assignedNames(s, names));
+      break;
+    case 'notification_handler':
+      assignedNames(statement.body, names);
+      break;
+    case 'parallel_map_statement':
+      if (statement.target?.type === 'identifier') {
+        names.add(statement.target.name);
+      }
+      break;
+    case 'annotated_statement':
+    case 'compensated_statement':
+      assignedNames(statement.statement, names);
+      break;
+  }
+  return names;
+}
+
+/**
+ * Walks a script's statements in order, recording its MCP tool calls and
+ * agent runs
+ */
+class CallTracer {
+  private readonly calls: CallPlan[] = [];
+  private readonly servers: Set<string>;
+  private readonly agents: Set<string>;
+  private readonly tools = new Map<string, ToolDeclaration>();
+  private readonly within: string[] = [];
+  /** Tools being inlined, so recursive tools are only walked once */
+  private readonly inlining: string[] = [];
+
+  constructor(plan: RunPlan, statements: Statement[]) {
+    this.servers = new Set(plan.servers.map(server => server.name));
+    this.agents = new Set(plan.agents.map(agent => agent.name));
+    for (const statement of statements) {
+      if (statement.type === 'tool_declaration') {
+        this.tools.set(statement.name, statement);
+      }
+    }
+  }
+
+  trace(statements: Statement[]): CallPlan[] {
+    this.statements(statements, new Map());
+    // The main tool runs after the top-level code, with arguments only the
+    // run knows
+    const main = this.tools.get('main');
+    if (main) {
+      this.inline(
+        main,
+        main.parameters.map(parameter => computed(parameter.name))
+      );
+    }
+    return this.calls;
+  }
+
+  private statements(statements: Statement[], scope: Scope): void {
+    for (const statement of statements) {
+      this.statement(statement, scope);
+    }
+  }
+
+  private statement(statement: Statement, scope: Scope): void {
+    switch (statement.type) {
+      case 'assignment': {
+        const value = this.evaluate(statement.value, scope);
+        if (statement.target.type === 'identifier') {
+          scope.set(statement.target.name, value);
+        } else {
+          this.evaluate(statement.target.object, scope);
+        }
+        break;
+      }
+      case 'expression_statement':
+        this.evaluate(statement.expression, scope);
+        break;
+      case 'annotated_statement': {
+        // Trace points only record values
+        if (statement.annotation.name === 'trace') {
+          this.statement(statement.statement, scope);
+          break;
+        }
+        // Idempotent calls are skipped when made before with the same key
+        const args = statement.annotation.arguments.map(arg =>
+          this.evaluate(arg.value, scope)
+        );
+        this.within.push(
+          `@${statement.annotation.name}(${args.map(sourceText).join(', ')})`
+        );
+        this.statement(statement.statement, scope);
+        this.within.pop();
+        break;
+      }
+      case 'compensated_statement':
+        // The block only runs when a later step fails
+        this.statement(statement.statement, scope);
+        this.block('compensate', statement.compensation, scope);
+        break;
+      case 'return_statement':
+        if (statement.value) {
+          this.evaluate(statement.value, scope);
+        }
+        break;
+      case 'block_statement':
+        this.statements(statement.statements, scope);
+        break;
+      case 'if_statement': {
+        const condition = this.evaluate(statement.condition, scope);
+        if (condition.known) {
+          const taken = condition.value ? statement.then : statement.else;
+          if (taken) {
+            this.statement(taken, scope);
+          }
+          break;
+        }
+        this.block(`if ${condition.text}`, statement.then, scope);
+        if (statement.else) {
+          this.block(`unless ${condition.text}`, statement.else, scope);
+        }
+        this.forget(statement, scope);
+        break;
+      }
+      case 'while_statement': {
+        this.forget(statement, scope);
+        const condition = this.evaluate(statement.condition, scope);
+        this.block(`while ${sourceText(condition)}`, statement.body, scope);
+        break;
+      }
+      case 'for_statement': {
+        if (statement.init) {
+          this.statement(statement.init, scope);
+        }
+        this.forget(statement, scope);
+        const condition = statement.condition
+          ? sourceText(this.evaluate(statement.condition, scope))
+          : 'ever';
+        this.within.push(`for ${condition}`);
+        this.statement(statement.body, scope);
+        if (statement.update) {
+          this.statement(statement.update, scope);
+        }
+        this.within.pop();
+        this.forget(statement, scope);
+        break;
+      }
+      case 'parallel_statement':
+        for (const branch of statement.branches) {
+          this.block('parallel', branch, scope);
+        }
+        this.forget(statement, scope);
+        break;
+      case 'parallel_map_statement': {
+        const items = this.evaluate(statement.items, scope);
+        if (statement.limit) {
+          this.evaluate(statement.limit, scope);
+        }
+        const body = new Map(scope);
+        body.set(statement.item, computed(statement.item));
+        this.within.push(
+          `parallelMap ${statement.item} of ${sourceText(items)}`
+        );
+        this.statements(statement.body, body);
+        this.within.pop();
+        this.forget(statement, scope);
+        break;
+      }
+      case 'event_handler': {
+        // Handlers only run when their events arrive
+        const body = new Map(scope);
+        if (statement.parameter) {
+          const { name } = statement.parameter;
+          body.set(name, computed(name));
+        }
+        this.within.push(`on event ${JSON.stringify(statement.event)}`);
+        this.statement(statement.body, body);
+        this.within.pop();
+        break;
+      }
+      case 'notification_handler': {
+        // Handlers run whenever their notifications arrive, so what they
+        // assign is not known once they are registered
+        if (statement.resource) {
+          this.evaluate(statement.resource, scope);
+        }
+        const body = new Map(scope);
+        if (statement.parameter) {
+          const { name } = statement.parameter;
+          body.set(name, computed(name));
+        }
+        this.within.push(
+          `on notification ${statement.server} ${JSON.stringify(statement.notification)}`
+        );
+        this.statement(statement.body, body);
+        this.within.pop();
+        this.forget(statement, scope);
+        break;
+      }
+      case 'listen_statement':
+        if (statement.until) {
+          this.evaluate(statement.until, scope);
+        }
+        if (statement.timeout) {
+          this.evaluate(statement.timeout, scope);
+        }
+        break;
+    }
+  }
+
+  /**
+   * Walk a statement that may not run, or run more than once, from the
+   * variables as they were before it
+   */
+  private block(label: string, statement: Statement, scope: Scope): void {
+    this.within.push(label);
+    this.statement(statement, new Map(scope));
+    this.within.pop();
+  }
+
+  /**
+   * Variables a statement may have assigned are no longer known
+   */
+  private forget(statement: Statement, scope: Scope): void {
+    for (const name of assignedNames(statement)) {
+      scope.set(name, computed(name));
+    }
+  }
+
+  private record(kind: CallPlan['kind'], target: string, args: Traced[]) {
+    const result = `$${this.calls.length + 1}`;
+    this.calls.push({
+      result,
+      kind,
+      target,
+      arguments: args.map(sourceText),
+      within: [...this.within],
+    });
+    return computed(result);
+  }
+
+  private evaluate(expression: Expression, scope: Scope): Traced {
+    switch (expression.type) {
+      case 'string':
+      case 'number':
+      case 'boolean':
+        return known(expression.value);
+      case 'identifier':
+        return scope.get(expression.name) ?? computed(expression.name);
+      case 'array': {
+        const elements = expression.elements.map(e => this.evaluate(e, scope));
+        return elements.every(e => e.known)
+          ? known(elements.map(e => (e.known ? e.value : undefined)))
+          : computed(`[${elements.map(sourceText).join(', ')}]`);
+      }
+      case 'object': {
+        const entries = expression.properties.map(
+          ({ key, value }) => [key, this.evaluate(value, scope)] as const
+        );
+        return entries.every(([, value]) => value.known)
+          ? known(
+              Object.fromEntries(
+                entries.map(([key, value]) => [
+                  key,
+                  value.known ? value.value : undefined,
+                ])
+              )
+            )
+          : computed(
+              `{ ${entries
+                .map(([key, value]) => `${key}: ${sourceText(value)}`)
+                .join(', ')} }`
+            );
+      }
+      case 'member': {
+        const object = this.evaluate(expression.object, scope);
+        if (
+          object.known &&
+          typeof object.value === 'object' &&
+          object.value !== null &&
+          Object.hasOwn(object.value, expression.property)
+        ) {
+          return known(
+            (object.value as Record<string, unknown>)[expression.property]
+          );
+        }
+        return computed(`${sourceText(object)}.${expression.property}`);
+      }
+      case 'bracket': {
+        const object = this.evaluate(expression.object, scope);
+        const index = this.evaluate(expression.index, scope);
+        return computed(`${sourceText(object)}[${sourceText(index)}]`);
+      }
+      case 'unary': {
+        const operand = this.evaluate(expression.operand, scope);
+        if (operand.known && isPrimitive(operand.value)) {
+          return known(
+            expression.operator === '!'
+              ? !operand.value
+              : -(operand.value as number)
+          );
+        }
+        return computed(`${expression.operator}${sourceText(operand)}`);
+      }
+      case 'binary':
+        return this.binary(expression, scope);
+      case 'call':
+        return this.call(expression, scope);
+    }
+  }
+
+  private binary(expression: BinaryExpression, scope: Scope): Traced {
+    const left = this.evaluate(expression.left, scope);
+    const { operator, right } = expression;
+    // Piping into a declared agent runs it
+    if (
+      operator === '|' &&
+      right.type === 'identifier' &&
+      this.agents.has(right.name) &&
+      !scope.has(right.name)
+    ) {
+      return this.record('agent', right.name, [left]);
+    }
+    const value = this.evaluate(right, scope);
+    if (
+      left.known &&
+      value.known &&
+      isPrimitive(left.value) &&
+      isPrimitive(value.value)
+    ) {
+      const folded = fold(operator, left.value, value.value);
+      if (folded) {
+        return folded;
+      }
+    }
+    return computed(`${sourceText(left)} ${operator} ${sourceText(value)}`);
+  }
+
+  private call(expression: CallExpression, scope: Scope): Traced {
+    const args = expression.arguments.map(a => this.evaluate(a, scope));
+    const { callee } = expression;
+    if (
+      callee.type === 'member' &&
+      callee.object.type === 'identifier' &&
+      this.servers.has(callee.object.name) &&
+      !scope.has(callee.object.name)
+    ) {
+      return this.record(
+        'tool',
+        `${callee.object.name}.${callee.property}`,
+        args
+      );
+    }
+
+    const name = callee.type === 'identifier' ? callee.name : undefined;
+    const tool = name && !scope.has(name) ? this.tools.get(name) : undefined;
+    if (tool && !this.inlining.includes(tool.name)) {
+      this.inline(tool, args);
+    }
+
+    const target = name ?? sourceText(this.evaluate(callee, scope));
+    return computed(`${target}(${args.map(sourceText).join(', ')})`);
+  }
+
+  /**
+   * Walk the body of a tool as called with the given arguments
+   */
+  private inline(tool: ToolDeclaration, args: Traced[]): void {
+    // Tools see their parameters, bound to the arguments of this call or
+    // else to their default values
+    const parameters: Scope = new Map();
+    tool.parameters.forEach((parameter, index) =>
+      parameters.set(
+        parameter.name,
+        args[index] ??
+          (parameter.defaultValue
+            ? this.evaluate(parameter.defaultValue, parameters)
+            : known(null))
+      )
+    );
+    this.inlining.push(tool.name);
+    this.within.push(`tool ${tool.name}`);
+    this.statements(tool.body.statements, parameters);
+    this.within.pop();
+    this.inlining.pop();
+  }
+}
+
+function formatValue(value: PlanValue | undefined): string {
+  if (Array.isArray(value)) {
+    return value.map(formatValue).join(' ');
+  }
+  return typeof value === 'string' ? value : JSON.stringify(value);
+}
+
+/**
+ * Render a plan for the terminal: the servers a run would start, then its
+ * calls in order
+ */
+export function formatPlan(plan: RunPlan): string {
+  const lines = ['Servers:'];
+  for (const { name, command, args, url } of plan.servers) {
+    const start = url !== undefined ? url : [command, args];
+    lines.push(`  ${name}: ${formatValue(start as PlanValue)}`);
+  }
+  if (plan.servers.length === 0) {
+    lines.push('  (none)');
+  }
+
+  lines.push('', 'Calls:');
+  for (const { result, kind, target, arguments: args, within } of plan.calls) {
+    const call =
+      kind === 'agent'
+        ? `${args[0]} | ${target}`
+        : `${target}(${args.join(', ')})`;
+    const where = within.length > 0 ? `  [${within.join('; ')}]` : '';
+    lines.push(`  ${result} = ${call}${where}`);
+  }
+  if (plan.calls.length === 0) {
+    lines.push('  (none)');
+  }
+  return `${lines.join('\n')}\n`;
+}
@@ -0,0 +1,117 @@
+// Web playground backend: highlight, parse, check and plan a script
+import type { RequestListener } from 'http';
+import {
+  AstArena,
+  highlightHtml,
+  parseTree,
+  statementsFromTree,
+} from '@mcpscript/transpiler';
+import { HttpError, readJson, sendError, sendJson } from '../http.js';
+import { collectDiagnostics } from '../lsp/analysis.js';
+import { DiagnosticSeverity, type Diagnostic } from '../lsp/protocol.js';
+import { planRun, type RunPlan } from '../plan.js';
+
+/** Largest script the playground accepts */
+export const MAX_PLAYGROUND_BYTES = 256 * 1024;
+
+export interface PlaygroundRequest {
+  source: string;
+  /** Also return what running the script would set up */
+  plan?: boolean;
+}
+
+export interface PlaygroundResponse {
+  /** The source as HTML, with tokens in <span class="tok-..."> elements */
+  html: string;
+  /** Syntax tree as an S-expression */
+  tree: string;
+  diagnostics: Diagnostic[];
+  /** Only given when asked for and the script has no errors */
+  plan?: RunPlan;
+}
+
+export interface PlaygroundOptions {
+  /** Origin allowed to call the handler from a browser, e.g. "*" */
+  allowOrigin?: string;
+}
+
+/**
+ * Check the shape of a playground request
+ */
+export function validatePlaygroundRequest(value: unknown): PlaygroundRequest {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('request must be a JSON object');
+  }
+  const { source, plan } = value as Record<string, unknown>;
+  if (typeof source !== 'string') {
+    throw new Error('"source" must be a string');
+  }
+  if (plan !== undefined && typeof plan !== 'boolean') {
+    throw new Error('"plan" must be a boolean');
+  }
+  return { source, plan };
+}
+
+/**
+ * Everything the playground shows for a script
+ * Imports are not resolved, since playground scripts are not files
+ */
+export function analyzePlayground(
+  request: PlaygroundRequest
+): PlaygroundResponse {
+  const { source } = request;
+  const tree = parseTree(source);
+  const diagnostics = collectDiagnostics(tree, source);
+  const response: PlaygroundResponse = {
+    html: highlightHtml(tree, source),
+    tree: tree.rootNode.toString(),
+    diagnostics,
+  };
+  const hasErrors = diagnostics.some(
+    diagnostic => diagnostic.severity === DiagnosticSeverity.Error
+  );
+  if (request.plan && !hasErrors) {
+    const arena = new AstArena();
+    try {
+      response.plan = planRun(statementsFromTree(tree, source, arena));
+    } finally {
+      arena.release();
+    }
+  }
+  return response;
+}
+
+/**
+ * HTTP handler for hosting the playground
+ * It answers POST requests with a JSON PlaygroundRequest body on any path,
+ * so it can be mounted wherever the host likes. Scripts are never run
+ */
+export function createPlaygroundHandler(
+  options: PlaygroundOptions = {}
+): RequestListener {
+  return (request, response) => {
+    if (options.allowOrigin) {
+      response.setHeader('Access-Control-Allow-Origin', options.allowOrigin);
+      response.setHeader('Access-Control-Allow-Headers', 'Content-Type');
+      response.setHeader('Access-Control-Allow-Methods', 'POST');
+    }
+    if (request.method === 'OPTIONS') {
+      response.writeHead(204);
+      response.end();
+      return;
+    }
+
+    const handle = async () => {
+      if (request.method !== 'POST') {
+        throw new HttpError(405, 'Use POST for the playground');
+      }
+      const body = await readJson(
+        request,
+        MAX_PLAYGROUND_BYTES,
+        validatePlaygroundRequest
+      );
+      sendJson(response, 200, analyzePlayground(body));
+    };
+    handle().catch(error => sendError(response, error));
+  };
+}
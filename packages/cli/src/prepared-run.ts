@@ -0,0 +1,157 @@
+// Prepared runs of scripts, cached between runs
+//
+// Before a script runs, it is parsed with the modules it imports, type
+// checked, checked against the sandbox policy and offline mode, compiled,
+// and the flags of its main tool and the package pins of its lockfile are
+// read. For a script run every minute that is most of its startup, and
+// nothing of it changes until the script does, so the result is kept in
+// the analysis cache, keyed by the script's path and content and by how it
+// is prepared: the compile options, the policy, offline mode and the parse
+// limits. An entry is reused while the modules the script imports, the
+// project's .mcpsrc and the lockfile are unchanged. Arguments are read by
+// the kept flags on every run, so runs with other arguments share it.
+// Projects with a signing policy verify every run, so theirs are not cached.
+// Server bindings are left to each run, since they resolve variables and
+// secrets, which must not be written to the cache or outlive a rotation.
+import { readFile } from 'fs/promises';
+import { dirname } from 'path';
+import {
+  checkTypes,
+  createFileLoader,
+  generateCode,
+  linkModules,
+  loadModuleGraph,
+  parseLimits,
+  type CodegenOptions,
+  type ModuleGraph,
+  type Statement,
+} from '@mcpscript/transpiler';
+import type { PinnedPackage, SandboxPolicy } from '@mcpscript/runtime';
+import { findMain, scriptFlags, type ScriptFlag } from './arguments.js';
+import { AnalysisCache, contentHash } from './cache.js';
+import { moduleSearchPaths, type LoadedConfig } from './config.js';
+import { checkScriptOffline } from './offline.js';
+import { checkScriptPolicy } from './sandbox.js';
+import { enforceSigningPolicy, signingPolicy } from './signing.js';
+import { findToolsLock, readToolsLock } from './tools-lock.js';
+
+/**
+ * A script ready to run
+ */
+export interface PreparedRun {
+  /** The compiled script */
+  jsCode: string;
+  /** Flags of the script's main tool, undefined when it has none */
+  flags?: ScriptFlag[];
+  /** Package pins of the script's lockfile, if it has one */
+  packages?: Record<string, PinnedPackage>;
+}
+
+export interface PrepareOptions {
+  /** How the script is compiled */
+  codegen: CodegenOptions;
+  /** Sandbox policy the script's servers and calls must keep to */
+  policy?: SandboxPolicy;
+  /** Refuse servers and models that need the network */
+  offline?: boolean;
+  /** Cache of prepared runs; without one the script is prepared afresh */
+  cache?: AnalysisCache;
+  /**
+   * Compile the program instead of generating code with the compile
+   * options, as replays do; runs compiled so are not cached
+   */
+  compile?: (program: Statement[]) => string;
+}
+
+/**
+ * Files a prepared run depends on besides the script, with their hashes
+ */
+async function runDependencies(
+  graph: ModuleGraph,
+  loaded: LoadedConfig,
+  lockPath: string | undefined
+): Promise<Record<string, string>> {
+  const dependencies: Record<string, string> = {};
+  for (const module of graph.modules) {
+    if (module !== graph.entry) {
+      dependencies[module.path] = contentHash(module.source);
+    }
+  }
+  for (const path of [loaded.path, lockPath]) {
+    if (path) {
+      dependencies[path] = contentHash(await readFile(path));
+    }
+  }
+  return dependencies;
+}
+
+/**
+ * Prepare a script to run, or reuse the run prepared for it before
+ * Errors of the script, such as type errors or calls the policy does not
+ * allow, are thrown, and nothing is cached for it.
+ */
+export async function prepareRun(
+  file: string,
+  source: string,
+  loaded: LoadedConfig,
+  options: PrepareOptions
+): Promise<PreparedRun> {
+  const cache =
+    options.compile || signingPolicy(loaded) ? undefined : options.cache;
+  // A lockfile added later changes the key, as its absence is no file to
+  // depend on
+  const lockPath = findToolsLock(dirname(file));
+  const key = [
+    'run',
+    file,
+    contentHash(source),
+    options.codegen,
+    options.policy ?? null,
+    options.offline ?? false,
+    parseLimits(),
+    loaded.path ?? null,
+    lockPath ?? null,
+  ];
+  const cached = await cache?.get<PreparedRun>(key);
+  if (cached) {
+    return cached;
+  }
+
+  // Refuse tampered or, if the project requires it, unsigned scripts
+  const loader = enforceSigningPolicy(
+    loaded,
+    file,
+    source,
+    createFileLoader({ paths: moduleSearchPaths(loaded) })
+  );
+  const graph = loadModuleGraph(file, loader, source);
+  const program = linkModules(graph);
+
+  // Check declared tool signatures before running anything, and refuse
+  // scripts declaring servers or calls the sandbox policy does not allow,
+  // or servers and models that need the network in offline mode
+  checkTypes(program);
+  if (options.policy) {
+    checkScriptPolicy(program, options.policy);
+  }
+  if (options.offline) {
+    checkScriptOffline(program);
+  }
+
+  const main = findMain(program, source);
+  const prepared: PreparedRun = {
+    jsCode: options.compile
+      ? options.compile(program)
+      : generateCode(program, options.codegen),
+    ...(main && { flags: scriptFlags(main) }),
+    ...(lockPath && { packages: (await readToolsLock(lockPath)).packages }),
+  };
+  if (cache) {
+    await cache.set(
+      key,
+      prepared,
+      await runDependencies(graph, loaded, lockPath)
+    );
+  }
+  return prepared;
+}
@@ -0,0 +1,138 @@
+// Client side of `mcps run --remote` and `mcps logs`
+import {
+  EventDecoder,
+  RUNS_PATH,
+  type LogFilter,
+  type RunEvent,
+  type RunRequest,
+} from './protocol.js';
+
+export interface RemoteRunOptions extends RunRequest {
+  /** Base URL of the mcpsd executor */
+  url: string;
+  token: string;
+}
+
+export interface RunLogOptions extends LogFilter {
+  /** Base URL of the mcpsd executor or `mcps serve --http` service */
+  url: string;
+  token?: string;
+  /** Run to read the log of */
+  id: string;
+  /** Keep streaming events until the run exits */
+  follow?: boolean;
+}
+
+/**
+ * Error for a response the executor answered with an error status
+ */
+async function rejection(response: Response, what: string): Promise<Error> {
+  let reason = response.statusText;
+  try {
+    reason = ((await response.json()) as { error: string }).error;
+  } catch {
+    // Keep the status text when the body is not the expected JSON
+  }
+  return new Error(`${what} (${response.status}): ${reason}`);
+}
+
+/**
+ * Hand the events of a response stream to a callback as they arrive
+ * Resolves with the exit code of the run once its exit event arrives, or
+ * undefined if the stream ends before that
+ */
+async function readEvents(
+  body: ReadableStream<Uint8Array>,
+  onEvent: (event: RunEvent) => void
+): Promise<number | undefined> {
+  const decoder = new EventDecoder();
+  const text = new TextDecoder();
+  const reader = body.getReader();
+  for (;;) {
+    const { done, value } = await reader.read();
+    if (done) {
+      return undefined;
+    }
+    for (const event of decoder.push(text.decode(value, { stream: true }))) {
+      onEvent(event);
+      if (event.type === 'exit') {
+        await reader.cancel();
+        return event.code;
+      }
+    }
+  }
+}
+
+/**
+ * Submit a script to a remote executor and relay its output locally
+ * Resolves with the exit code of the remote run
+ */
+export async function runRemote(options: RemoteRunOptions): Promise<number> {
+  const { url, token, ...request } = options;
+  const response = await fetch(new URL(RUNS_PATH, url), {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${token}`,
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(request),
+  });
+
+  if (!response.ok || !response.body) {
+    throw await rejection(response, 'Remote run rejected');
+  }
+
+  const code = await readEvents(response.body, event => {
+    switch (event.type) {
+      case 'output':
+        process[event.stream].write(event.text);
+        break;
+      case 'message':
+        process.stderr.write(
+          event.title ? `${event.title}\n${event.body}\n` : `${event.body}\n`
+        );
+        break;
+      case 'error':
+        process.stderr.write(`${event.message}\n`);
+        break;
+    }
+  });
+  if (code === undefined) {
+    throw new Error('Connection to the remote executor closed unexpectedly');
+  }
+  return code;
+}
+
+/**
+ * Read the output and log of a run on an mcpsd executor or an
+ * `mcps serve --http` service, handing each event to `onEvent`
+ * Resolves with the exit code of the run if it has exited
+ */
+export async function readRunLog(
+  options: RunLogOptions,
+  onEvent: (event: RunEvent) => void
+): Promise<number | undefined> {
+  const { url, token, id, follow, servers, level } = options;
+  const logUrl = new URL(`${RUNS_PATH}/${encodeURIComponent(id)}/logs`, url);
+  for (const server of servers ?? []) {
+    logUrl.searchParams.append('server', server);
+  }
+  if (level) {
+    logUrl.searchParams.set('level', level);
+  }
+  if (follow) {
+    logUrl.searchParams.set('follow', 'true');
+  }
+  const response = await fetch(logUrl, {
+    headers: token ? { Authorization: `Bearer ${token}` } : {},
+  });
+  if (!response.ok || !response.body) {
+    throw await rejection(response, 'Reading the log failed');
+  }
+
+  const code = await readEvents(response.body, onEvent);
+  if (code === undefined && follow) {
+    throw new Error(`Connection to ${url} closed before run ${id} exited`);
+  }
+  return code;
+}
@@ -0,0 +1,497 @@
+// mcpsd: executes scripts submitted by `mcps run --remote`
+import {
+  createServer,
+  type IncomingMessage,
+  type Server,
+  type ServerResponse,
+} from 'http';
+import type { EventEmitter } from 'events';
+import { readFile } from 'fs/promises';
+import { fileURLToPath } from 'url';
+import { DEFAULT_PARSE_LIMITS } from '@mcpscript/transpiler';
+import type { CallerIdentity } from '@mcpscript/runtime';
+import { HttpError, readJson, sendError, sendJson } from '../http.js';
+import {
+  MAX_REQUEST_BYTES,
+  PROJECTS_PATH,
+  QUOTAS_PATH,
+  RUNS_PATH,
+  encodeEvent,
+  matchesLogFilter,
+  parseLogFilter,
+  validateRunRequest,
+  validateTrafficRequest,
+  type LogFilter,
+  type RunEvent,
+  type RunRequest,
+} from './protocol.js';
+import {
+  authenticate,
+  effectiveTimeout,
+  resolveProjectFile,
+  type DaemonConfig,
+  type TenantPolicy,
+} from './policy.js';
+import { isJwt, OidcVerifier } from './oidc.js';
+import { ProjectRegistry } from './projects.js';
+import { exceededQuota, quotaMessage, QuotaTracker } from './quotas.js';
+import { RunManager, type RunSpec } from './runs.js';
+
+export interface DaemonOptions extends DaemonConfig {
+  /** Token of an unrestricted "default" tenant */
+  token?: string;
+  /** Module forked for each run (defaults to the bundled worker) */
+  workerPath?: string;
+  /** Checks the tokens of OIDC callers (made from `oidc` unless testing) */
+  verifier?: OidcVerifier;
+}
+
+/**
+ * Who sent a request: the tenant it comes under and the caller its runs
+ * work for
+ */
+interface Client {
+  tenant: TenantPolicy;
+  caller: CallerIdentity;
+}
+
+export interface Daemon {
+  server: Server;
+  runs: RunManager;
+}
+
+/**
+ * A run whose events can be streamed, emitting "event" for each recorded
+ * event and "finish" once it has exited: the daemon's runs, and the runs
+ * of `mcps serve --http`
+ */
+export interface StreamedRun extends EventEmitter {
+  readonly events: RunEvent[];
+  readonly finished: boolean;
+}
+
+/** Events of the events stream, which leaves out the run's log */
+const outputEvents = (event: RunEvent) => event.type !== 'log';
+
+/**
+ * Stream a run's recorded and future events as NDJSON until it exits,
+ * keeping those `include` accepts; without `follow`, only the recorded
+ * ones are streamed
+ */
+export function streamEvents(
+  response: ServerResponse,
+  run: StreamedRun,
+  include: (event: RunEvent) => boolean = outputEvents,
+  follow = true
+): void {
+  response.writeHead(200, { 'Content-Type': 'application/x-ndjson' });
+  for (const event of run.events) {
+    if (include(event)) {
+      response.write(encodeEvent(event));
+    }
+  }
+  if (run.finished || !follow) {
+    response.end();
+    return;
+  }
+  const onEvent = (event: RunEvent) => {
+    if (include(event)) {
+      response.write(encodeEvent(event));
+    }
+  };
+  const onFinish = () => response.end();
+  run.on('event', onEvent);
+  run.once('finish', onFinish);
+  response.on('close', () => {
+    run.off('event', onEvent);
+    run.off('finish', onFinish);
+  });
+}
+
+/**
+ * Stream a run's events with its log, as filtered by the query parameters;
+ * the stream goes on until the run exits when `follow` is true
+ */
+export function streamLog(
+  response: ServerResponse,
+  run: StreamedRun,
+  params: URLSearchParams
+): void {
+  let filter: LogFilter;
+  try {
+    filter = parseLogFilter(params);
+  } catch (error) {
+    throw new HttpError(400, (error as Error).message);
+  }
+  streamEvents(
+    response,
+    run,
+    event => matchesLogFilter(event, filter),
+    params.get('follow') === 'true'
+  );
+}
+
+/**
+ * Tenants allowed by the options, with MCPSD_TOKEN's unrestricted tenant
+ */
+function tenantsOf(options: DaemonOptions): TenantPolicy[] {
+  const tenants = [...(options.tenants ?? [])];
+  if (options.token) {
+    tenants.push({ name: 'default', token: options.token, allowDeploy: true });
+  }
+  if (tenants.length === 0) {
+    throw new Error('mcpsd needs a token or at least one tenant');
+  }
+  return tenants;
+}
+
+/**
+ * Create the executor's HTTP server
+ * Each run is executed in its own child process, which inherits the
+ * daemon's environment, so secrets and project config stay on the
+ * executor's machine. Submitted source runs in the daemon's working
+ * directory; project runs in the project's directory
+ */
+export function createDaemon(options: DaemonOptions): Daemon {
+  const tenants = tenantsOf(options);
+  const verifier =
+    options.verifier ?? (options.oidc && new OidcVerifier(options.oidc));
+  const projects = new ProjectRegistry(options.projects ?? {});
+  const quotas = new QuotaTracker();
+  const runs = new RunManager({
+    workerPath:
+      options.workerPath ??
+      fileURLToPath(new URL('./worker.js', import.meta.url)),
+    maxConcurrentRuns: options.maxConcurrentRuns,
+  });
+
+  const allowedProjects = (tenant: TenantPolicy): string[] =>
+    projects.names().filter(
+      name => !tenant.projects || tenant.projects.includes(name)
+    );
+
+  // Scripts the worker would refuse to parse are rejected up front
+  const maxSourceLength =
+    options.parseLimits?.maxSourceLength ??
+    DEFAULT_PARSE_LIMITS.maxSourceLength;
+  const checkSourceLength = (source: string): void => {
+    if (source.length > maxSourceLength) {
+      throw new HttpError(
+        413,
+        `Script is ${source.length} characters long, more than the limit of ${maxSourceLength}`
+      );
+    }
+  };
+
+  /**
+   * The client presenting a tenant's token, or the token of an OIDC caller
+   */
+  async function identify(header: string | undefined): Promise<Client> {
+    const tenant = authenticate(header, tenants);
+    if (tenant) {
+      return {
+        tenant,
+        caller: { subject: tenant.subject ?? tenant.name, tenant: tenant.name },
+      };
+    }
+    const token = /^Bearer (.+)$/.exec(header ?? '')?.[1];
+    if (!verifier || !token || !isJwt(token)) {
+      throw new HttpError(401, 'Missing or invalid token');
+    }
+    let caller: CallerIdentity;
+    try {
+      caller = await verifier.verify(token);
+    } catch (error) {
+      throw new HttpError(401, `Invalid token: ${(error as Error).message}`);
+    }
+    const policy = tenants.find(({ name }) => name === options.oidc?.tenant);
+    if (!policy) {
+      throw new HttpError(401, 'Missing or invalid token');
+    }
+    return { tenant: policy, caller: { ...caller, tenant: policy.name } };
+  }
+
+  async function prepareRun(
+    request: RunRequest,
+    { tenant, caller }: Client
+  ): Promise<RunSpec> {
+    const timeout = effectiveTimeout(tenant, request.timeout);
+    if (
+      tenant.role !== undefined &&
+      request.role !== undefined &&
+      request.role !== tenant.role
+    ) {
+      throw new HttpError(
+        403,
+        `Tenant ${tenant.name} may only run as ${tenant.role}`
+      );
+    }
+    const role = tenant.role ?? request.role;
+    if (request.project === undefined) {
+      if (tenant.allowSource === false) {
+        throw new HttpError(403, `Tenant ${tenant.name} may only run projects`);
+      }
+      checkSourceLength(request.source!);
+      return {
+        tenant: tenant.name,
+        file: request.file,
+        source: request.source!,
+        timeout,
+        role,
+        cwd: process.cwd(),
+        parseLimits: options.parseLimits,
+        caller,
+      };
+    }
+
+    if (!projects.has(request.project)) {
+      throw new HttpError(404, `Unknown project: ${request.project}`);
+    }
+    if (!allowedProjects(tenant).includes(request.project)) {
+      throw new HttpError(
+        403,
+        `Tenant ${tenant.name} may not run project ${request.project}`
+      );
+    }
+    if (request.source !== undefined && tenant.allowSource === false) {
+      throw new HttpError(403, `Tenant ${tenant.name} may only run projects`);
+    }
+    const { dir, version } = projects.target(request.project, request.version);
+
+    let source = request.source;
+    if (source === undefined) {
+      let path: string | undefined;
+      try {
+        path = resolveProjectFile(dir, request.file);
+        source = await readFile(path, 'utf-8');
+      } catch (error) {
+        throw new HttpError(
+          404,
+          path
+            ? `Script not found in project ${request.project}: ${request.file}`
+            : (error as Error).message
+        );
+      }
+    }
+    checkSourceLength(source);
+    return {
+      tenant: tenant.name,
+      file: request.file,
+      source,
+      project: request.project,
+      version,
+      timeout,
+      role,
+      cwd: dir,
+      parseLimits: options.parseLimits,
+      caller,
+    };
+  }
+
+  async function submit(
+    request: IncomingMessage,
+    response: ServerResponse,
+    client: Client
+  ): Promise<void> {
+    const runRequest = await readJson(
+      request,
+      MAX_REQUEST_BYTES,
+      validateRunRequest
+    );
+
+    const { tenant } = client;
+    const spec = await prepareRun(runRequest, client);
+    const full = exceededQuota(
+      quotas.status(tenant, runs.activeRuns(tenant.name))
+    );
+    if (full) {
+      throw new HttpError(429, quotaMessage(tenant.name, full), {
+        quota: full,
+      });
+    }
+
+    const run = runs.submit(spec);
+    // What the run uses counts against the tenant's quotas, and the run is
+    // stopped once the tenant goes over one
+    const count = (event: RunEvent) => {
+      if (event.type !== 'log') {
+        return;
+      }
+      quotas.record(tenant.name, event.entry);
+      const over = exceededQuota(
+        quotas.status(tenant, runs.activeRuns(tenant.name)),
+        true
+      );
+      if (over) {
+        run.off('event', count);
+        run.record({
+          type: 'error',
+          message: `Run cancelled: ${quotaMessage(tenant.name, over)}`,
+          quota: over,
+        });
+        run.cancel();
+      }
+    };
+    run.on('event', count);
+    if (runRequest.detach) {
+      response.setHeader('Location', `${RUNS_PATH}/${run.id}`);
+      return sendJson(response, 202, run.status());
+    }
+
+    streamEvents(response, run);
+    // Stop an attached run (and its servers) if the client goes away
+    response.on('close', () => {
+      if (!response.writableFinished) {
+        run.cancel();
+      }
+    });
+  }
+
+  /**
+   * Report a project, or change how its runs are split between versions
+   */
+  async function manageProject(
+    request: IncomingMessage,
+    response: ServerResponse,
+    tenant: TenantPolicy,
+    name: string,
+    action: string | undefined
+  ): Promise<void> {
+    if (!projects.has(name) || !allowedProjects(tenant).includes(name)) {
+      throw new HttpError(404, `Unknown project: ${name}`);
+    }
+    if (action === undefined) {
+      return sendJson(response, 200, projects.status(name));
+    }
+    if (!tenant.allowDeploy) {
+      throw new HttpError(
+        403,
+        `Tenant ${tenant.name} may not change the traffic of project ${name}`
+      );
+    }
+    if (action === 'rollback') {
+      return sendJson(response, 200, projects.rollback(name));
+    }
+    const { traffic } = await readJson(
+      request,
+      MAX_REQUEST_BYTES,
+      validateTrafficRequest
+    );
+    sendJson(response, 200, projects.setTraffic(name, traffic));
+  }
+
+  async function route(
+    request: IncomingMessage,
+    response: ServerResponse
+  ): Promise<void> {
+    const { pathname, searchParams } = new URL(
+      request.url ?? '/',
+      'http://localhost'
+    );
+    const method = request.method ?? 'GET';
+    // Run paths are RUNS_PATH/<id>, RUNS_PATH/<id>/events and
+    // RUNS_PATH/<id>/logs
+    const [id, resource, ...rest] = pathname.startsWith(`${RUNS_PATH}/`)
+      ? pathname.slice(RUNS_PATH.length + 1).split('/')
+      : [];
+    // Project paths are PROJECTS_PATH/<name>, PROJECTS_PATH/<name>/traffic
+    // and PROJECTS_PATH/<name>/rollback
+    const [project, action, ...extra] = pathname.startsWith(
+      `${PROJECTS_PATH}/`
+    )
+      ? pathname.slice(PROJECTS_PATH.length + 1).split('/')
+      : [];
+
+    let methods: string[];
+    if (pathname === PROJECTS_PATH || pathname === QUOTAS_PATH) {
+      methods = ['GET'];
+    } else if (project && action === undefined) {
+      methods = ['GET'];
+    } else if (project && action === 'traffic' && extra.length === 0) {
+      methods = ['PUT'];
+    } else if (project && action === 'rollback' && extra.length === 0) {
+      methods = ['POST'];
+    } else if (pathname === RUNS_PATH) {
+      methods = ['GET', 'POST'];
+    } else if (id && resource === undefined) {
+      methods = ['GET', 'DELETE'];
+    } else if (
+      id &&
+      (resource === 'events' || resource === 'logs') &&
+      rest.length === 0
+    ) {
+      methods = ['GET'];
+    } else {
+      throw new HttpError(404, `Unknown path: ${request.url}`);
+    }
+    if (!methods.includes(method)) {
+      throw new HttpError(405, `Use ${methods.join(' or ')} for ${pathname}`);
+    }
+
+    const client = await identify(request.headers.authorization);
+    const { tenant } = client;
+
+    if (pathname === PROJECTS_PATH) {
+      return sendJson(response, 200, { projects: allowedProjects(tenant) });
+    }
+    if (pathname === QUOTAS_PATH) {
+      return sendJson(
+        response,
+        200,
+        quotas.status(tenant, runs.activeRuns(tenant.name))
+      );
+    }
+    if (project) {
+      return manageProject(
+        request,
+        response,
+        tenant,
+        decodeURIComponent(project),
+        action
+      );
+    }
+
+    if (pathname === RUNS_PATH) {
+      if (method === 'POST') {
+        return submit(request, response, client);
+      }
+      const list = runs.list(tenant.name).map(run => run.status());
+      return sendJson(response, 200, { runs: list });
+    }
+
+    // Runs of other tenants are reported as missing
+    const run = runs.get(id);
+    if (!run || run.spec.tenant !== tenant.name) {
+      throw new HttpError(404, `Unknown run: ${id}`);
+    }
+    if (resource === 'events') {
+      return streamEvents(response, run);
+    }
+    if (resource === 'logs') {
+      return streamLog(response, run, searchParams);
+    }
+    if (method === 'DELETE') {
+      run.cancel();
+    }
+    sendJson(response, 200, run.status());
+  }
+
+  const server = createServer((request, response) => {
+    route(request, response).catch(error => sendError(response, error));
+  });
+  return { server, runs };
+}
+
+/**
+ * Start the executor and keep it running until interrupted
+ */
+export async function startDaemon(
+  options: DaemonOptions & { host: string; port: number }
+): Promise<Daemon> {
+  const daemon = createDaemon(options);
+  await new Promise<void>((resolve, reject) => {
+    daemon.server.once('error', reject);
+    daemon.server.listen(options.port, options.host, () => resolve());
+  });
+  return daemon;
+}
@@ -0,0 +1,263 @@
+// OIDC callers of mcpsd and `mcps serve --http`
+//
+// Besides the tokens configured for them, the servers accept the ID or
+// access tokens of an OpenID Connect issuer, so that runs work for the
+// user who asked for them. A token is a JWT signed with one of the keys
+// the issuer publishes: they are found through its discovery document,
+// kept, and fetched again when a token names a key that is not known yet.
+// The token's subject, and the claims configured to be passed on, make up
+// the identity runs forward to the MCP servers they call.
+import { constants, createPublicKey, verify, type KeyObject } from 'crypto';
+import type { CallerIdentity } from '@mcpscript/runtime';
+
+/** Clock difference allowed when checking when a token is valid */
+export const CLOCK_LEEWAY = 60 * 1000;
+/** Shortest time between fetches of the issuer's keys */
+export const KEYS_REFRESH_INTERVAL = 60 * 1000;
+
+/**
+ * Issuer whose tokens callers may present
+ */
+export interface OidcConfig {
+  /** Issuer URL, as in the "iss" claim of its tokens */
+  issuer: string;
+  /** Audience tokens must be issued for, such as a client id */
+  audience?: string;
+  /** Where the issuer's keys are (found by discovery when omitted) */
+  jwksUri?: string;
+  /** Claim naming the caller (default: "sub") */
+  subjectClaim?: string;
+  /** Claims passed on to MCP servers with the subject, such as "email" */
+  claims?: string[];
+}
+
+function isUrl(value: unknown): boolean {
+  try {
+    new URL(value as string);
+    return typeof value === 'string';
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * Check the shape of OIDC settings, naming the first invalid field
+ */
+export function validateOidcConfig(field: string, value: unknown): void {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error(`"${field}" must be an object`);
+  }
+  const settings = value as Record<string, unknown>;
+  for (const url of ['issuer', 'jwksUri']) {
+    if (
+      (url === 'issuer' || settings[url] !== undefined) &&
+      !isUrl(settings[url])
+    ) {
+      throw new Error(`"${field}.${url}" must be a URL`);
+    }
+  }
+  for (const name of ['audience', 'subjectClaim']) {
+    const setting = settings[name];
+    if (setting !== undefined && (typeof setting !== 'string' || !setting)) {
+      throw new Error(`"${field}.${name}" must be a non-empty string`);
+    }
+  }
+  const { claims } = settings;
+  if (
+    claims !== undefined &&
+    !(Array.isArray(claims) && claims.every(claim => typeof claim === 'string'))
+  ) {
+    throw new Error(`"${field}.claims" must be an array of strings`);
+  }
+}
+
+/**
+ * Whether a bearer token has the shape of a JWT
+ */
+export function isJwt(token: string): boolean {
+  return /^[\w-]+\.[\w-]+\.[\w-]*$/.test(token);
+}
+
+interface JwtHeader {
+  alg?: string;
+  kid?: string;
+}
+
+/**
+ * How a JWT algorithm's signatures are checked with node's crypto
+ */
+function signatureCheck(
+  alg: string | undefined
+): { hash: string | null; options: object } | undefined {
+  const match = /^(RS|PS|ES)(256|384|512)$/.exec(alg ?? '');
+  if (match) {
+    const [, family, bits] = match;
+    return {
+      hash: `sha${bits}`,
+      options:
+        family === 'ES'
+          ? { dsaEncoding: 'ieee-p1363' }
+          : family === 'PS'
+            ? {
+                padding: constants.RSA_PKCS1_PSS_PADDING,
+                saltLength: Number(bits) / 8,
+              }
+            : {},
+    };
+  }
+  return alg === 'EdDSA' ? { hash: null, options: {} } : undefined;
+}
+
+function decodePart(part: string, name: string): Record<string, unknown> {
+  try {
+    const value = JSON.parse(Buffer.from(part, 'base64url').toString('utf-8'));
+    if (typeof value === 'object' && value !== null && !Array.isArray(value)) {
+      return value;
+    }
+  } catch {
+    // Reported below
+  }
+  throw new Error(`the token's ${name} is not a JSON object`);
+}
+
+/**
+ * Checks the tokens of an OIDC issuer
+ */
+export class OidcVerifier {
+  private keys?: Promise<Map<string, KeyObject>>;
+  private keysFetchedAt = 0;
+
+  /**
+   * @param now Current time; Date.now unless testing
+   */
+  constructor(
+    private readonly config: OidcConfig,
+    private readonly fetchImpl: typeof fetch = fetch,
+    private readonly now: () => number = Date.now
+  ) {}
+
+  /**
+   * The caller a token identifies, throwing when it is not a valid token
+   * of the issuer
+   */
+  async verify(token: string): Promise<CallerIdentity> {
+    const [headerPart, payloadPart, signaturePart] = token.split('.');
+    if (!isJwt(token)) {
+      throw new Error('the token is not a JWT');
+    }
+    const header = decodePart(headerPart, 'header') as JwtHeader;
+    const claims = decodePart(payloadPart, 'payload');
+
+    const check = signatureCheck(header.alg);
+    if (!check) {
+      throw new Error(`unsupported signing algorithm ${header.alg}`);
+    }
+    const key = await this.key(header.kid);
+    const signed = verify(
+      check.hash,
+      Buffer.from(`${headerPart}.${payloadPart}`),
+      { key, ...check.options },
+      Buffer.from(signaturePart, 'base64url')
+    );
+    if (!signed) {
+      throw new Error('the token has an invalid signature');
+    }
+
+    const { issuer, audience, subjectClaim = 'sub' } = this.config;
+    if (claims.iss !== issuer) {
+      throw new Error(`the token was not issued by ${issuer}`);
+    }
+    const audiences = Array.isArray(claims.aud) ? claims.aud : [claims.aud];
+    if (audience !== undefined && !audiences.includes(audience)) {
+      throw new Error(`the token is not meant for ${audience}`);
+    }
+    // Times in tokens are in seconds
+    const now = this.now() / 1000;
+    const leeway = CLOCK_LEEWAY / 1000;
+    if (typeof claims.exp !== 'number' || claims.exp + leeway < now) {
+      throw new Error('the token has expired');
+    }
+    if (typeof claims.nbf === 'number' && claims.nbf - leeway > now) {
+      throw new Error('the token is not valid yet');
+    }
+    const subject = claims[subjectClaim];
+    if (typeof subject !== 'string' || subject === '') {
+      throw new Error(`the token has no ${subjectClaim} claim`);
+    }
+
+    const passed = (this.config.claims ?? []).filter(name =>
+      Object.hasOwn(claims, name)
+    );
+    return {
+      subject,
+      issuer,
+      ...(passed.length > 0 && {
+        claims: Object.fromEntries(passed.map(name => [name, claims[name]])),
+      }),
+    };
+  }
+
+  /**
+   * The issuer's key a token is signed with; keys are fetched again for a
+   * key id not known yet, at most once per KEYS_REFRESH_INTERVAL
+   */
+  private async key(kid: string | undefined): Promise<KeyObject> {
+    const find = (keys: Map<string, KeyObject>) =>
+      kid === undefined && keys.size === 1
+        ? [...keys.values()][0]
+        : keys.get(kid ?? '');
+    let key = this.keys && find(await this.keys);
+    if (!key && this.now() - this.keysFetchedAt >= KEYS_REFRESH_INTERVAL) {
+      this.keysFetchedAt = this.now();
+      this.keys = this.fetchKeys();
+      this.keys.catch(() => {
+        this.keys = undefined;
+      });
+      key = find(await this.keys);
+    }
+    if (!key) {
+      throw new Error(
+        kid === undefined
+          ? 'the token names no signing key'
+          : `the issuer has no signing key ${kid}`
+      );
+    }
+    return key;
+  }
+
+  private async fetchKeys(): Promise<Map<string, KeyObject>> {
+    const { issuer, jwksUri } = this.config;
+    let uri = jwksUri;
+    if (uri === undefined) {
+      const discovery = (await this.get(
+        `${issuer.replace(/\/$/, '')}/.well-known/openid-configuration`
+      )) as { jwks_uri?: unknown };
+      if (typeof discovery.jwks_uri !== 'string') {
+        throw new Error(`${issuer} publishes no jwks_uri`);
+      }
+      uri = discovery.jwks_uri;
+    }
+    const { keys } = (await this.get(uri)) as { keys?: unknown };
+    const found = new Map<string, KeyObject>();
+    for (const jwk of Array.isArray(keys) ? keys : []) {
+      // Keys for encryption, and of kinds node cannot read, are left out
+      if (jwk?.use === 'enc') {
+        continue;
+      }
+      try {
+        found.set(jwk.kid ?? '', createPublicKey({ key: jwk, format: 'jwk' }));
+      } catch {
+        continue;
+      }
+    }
+    return found;
+  }
+
+  private async get(url: string): Promise<unknown> {
+    const response = await this.fetchImpl(url);
+    if (!response.ok) {
+      throw new Error(`${url} answered ${response.status}`);
+    }
+    return response.json();
+  }
+}
@@ -0,0 +1,322 @@
+// Tenants and registered projects of an mcpsd executor
+import { readFile } from 'fs/promises';
+import { timingSafeEqual } from 'crypto';
+import { dirname, isAbsolute, relative, resolve } from 'path';
+import type { ParseLimits } from '@mcpscript/transpiler';
+import { validateOidcConfig, type OidcConfig } from './oidc.js';
+
+/**
+ * What a client presenting a given token may do
+ */
+export interface TenantPolicy {
+  name: string;
+  /** Token the tenant presents as "Authorization: Bearer <token>" */
+  token: string;
+  /**
+   * Caller the runs started with the token work for, as passed on to MCP
+   * servers (defaults to the tenant's name)
+   */
+  subject?: string;
+  /** Runs the tenant may have queued or running at once */
+  maxConcurrentRuns?: number;
+  /** MCP tool calls the tenant's runs may make each hour (UTC) */
+  maxToolCallsPerHour?: number;
+  /** LLM tokens the tenant's agents may use each day (UTC), as estimated */
+  maxTokensPerDay?: number;
+  /** Longest timeout in milliseconds, also used when a run asks for none */
+  maxTimeout?: number;
+  /** Registered projects the tenant may run (all when omitted) */
+  projects?: string[];
+  /** Whether the tenant may submit source code (defaults to true) */
+  allowSource?: boolean;
+  /**
+   * Whether the tenant may change how the runs of its projects are split
+   * between their versions (defaults to false)
+   */
+  allowDeploy?: boolean;
+  /**
+   * Role of the project's .mcpsrc the tenant's runs are started in; runs
+   * of tenants without one may ask for any role
+   */
+  role?: string;
+}
+
+/**
+ * A project registered in several versions, such as releases checked out
+ * side by side
+ */
+export interface VersionedProject {
+  /** Directories of the versions by name */
+  versions: Record<string, string>;
+  /**
+   * Share of the runs each version gets, such as { "v1": 90, "v2": 10 };
+   * without it, every run goes to the first version
+   */
+  traffic?: Record<string, number>;
+}
+
+/**
+ * Settings read from the file passed to `mcpsd --config`
+ */
+export interface DaemonConfig {
+  tenants?: TenantPolicy[];
+  /**
+   * Project directories, or versions of projects, by name; relative paths
+   * start at the config file
+   */
+  projects?: Record<string, string | VersionedProject>;
+  /** Runs executed at once across all tenants; later runs are queued */
+  maxConcurrentRuns?: number;
+  /** Caps on the size of the scripts runs may parse */
+  parseLimits?: ParseLimits;
+  /** Issuer whose tokens callers may present in place of a tenant's */
+  oidc?: DaemonOidcConfig;
+}
+
+/**
+ * OIDC callers of an executor, whose runs work for the token's subject
+ */
+export interface DaemonOidcConfig extends OidcConfig {
+  /** Tenant whose policy and quotas the callers' runs come under */
+  tenant: string;
+}
+
+function isPositiveInteger(value: unknown): boolean {
+  return typeof value === 'number' && Number.isInteger(value) && value > 0;
+}
+
+/**
+ * Check the shape of a parsed daemon config, naming the first invalid field
+ */
+export function validateDaemonConfig(value: unknown): DaemonConfig {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('config must be a JSON object');
+  }
+
+  const { tenants, projects, maxConcurrentRuns, parseLimits, oidc } =
+    value as Record<string, unknown>;
+  if (
+    maxConcurrentRuns !== undefined &&
+    !isPositiveInteger(maxConcurrentRuns)
+  ) {
+    throw new Error('"maxConcurrentRuns" must be a positive integer');
+  }
+
+  if (parseLimits !== undefined) {
+    if (typeof parseLimits !== 'object' || parseLimits === null) {
+      throw new Error('"parseLimits" must be an object');
+    }
+    const limits = parseLimits as Record<string, unknown>;
+    for (const limit of ['maxSourceLength', 'maxDepth', 'maxNodes']) {
+      if (limits[limit] !== undefined && !isPositiveInteger(limits[limit])) {
+        throw new Error(`"parseLimits.${limit}" must be a positive integer`);
+      }
+    }
+  }
+
+  if (projects !== undefined) {
+    if (typeof projects !== 'object' || projects === null) {
+      throw new Error('"projects" must be an object');
+    }
+    for (const [name, project] of Object.entries(projects)) {
+      if (typeof project === 'string') {
+        continue;
+      }
+      const { versions, traffic } = (project ?? {}) as Record<string, unknown>;
+      if (typeof versions !== 'object' || versions === null) {
+        throw new Error(
+          `"projects.${name}" must be a directory path or an object with versions`
+        );
+      }
+      const names = Object.keys(versions);
+      if (
+        names.length === 0 ||
+        Object.values(versions).some(dir => typeof dir !== 'string')
+      ) {
+        throw new Error(
+          `"projects.${name}.versions" must map version names to directory paths`
+        );
+      }
+      if (traffic !== undefined) {
+        validateTraffic(`projects.${name}.traffic`, traffic, names);
+      }
+    }
+  }
+
+  if (tenants !== undefined) {
+    if (!Array.isArray(tenants)) {
+      throw new Error('"tenants" must be an array');
+    }
+    const names = new Set<string>();
+    tenants.forEach((tenant: Record<string, unknown>, index) => {
+      const field = `tenants[${index}]`;
+      if (typeof tenant !== 'object' || tenant === null) {
+        throw new Error(`"${field}" must be an object`);
+      }
+      if (typeof tenant.name !== 'string' || names.has(tenant.name)) {
+        throw new Error(`"${field}.name" must be a unique string`);
+      }
+      names.add(tenant.name);
+      for (const name of ['token', 'subject']) {
+        if (
+          (name === 'token' || tenant[name] !== undefined) &&
+          (typeof tenant[name] !== 'string' || tenant[name] === '')
+        ) {
+          throw new Error(`"${field}.${name}" must be a non-empty string`);
+        }
+      }
+      for (const limit of [
+        'maxConcurrentRuns',
+        'maxTimeout',
+        'maxToolCallsPerHour',
+        'maxTokensPerDay',
+      ]) {
+        if (tenant[limit] !== undefined && !isPositiveInteger(tenant[limit])) {
+          throw new Error(`"${field}.${limit}" must be a positive integer`);
+        }
+      }
+      if (
+        tenant.projects !== undefined &&
+        !(
+          Array.isArray(tenant.projects) &&
+          tenant.projects.every(p => typeof p === 'string')
+        )
+      ) {
+        throw new Error(`"${field}.projects" must be an array of strings`);
+      }
+      for (const flag of ['allowSource', 'allowDeploy']) {
+        if (tenant[flag] !== undefined && typeof tenant[flag] !== 'boolean') {
+          throw new Error(`"${field}.${flag}" must be a boolean`);
+        }
+      }
+      if (
+        tenant.role !== undefined &&
+        (typeof tenant.role !== 'string' || tenant.role === '')
+      ) {
+        throw new Error(`"${field}.role" must be a non-empty string`);
+      }
+    });
+  }
+
+  if (oidc !== undefined) {
+    validateOidcConfig('oidc', oidc);
+    const { tenant } = oidc as Record<string, unknown>;
+    const names = Array.isArray(tenants)
+      ? tenants.map((policy: TenantPolicy) => policy.name)
+      : [];
+    if (typeof tenant !== 'string' || !names.includes(tenant)) {
+      throw new Error('"oidc.tenant" must name one of the tenants');
+    }
+  }
+
+  return value as DaemonConfig;
+}
+
+/**
+ * Check how runs are split between the versions of a project: shares of
+ * versions it has, at least one of them above 0
+ */
+export function validateTraffic(
+  field: string,
+  value: unknown,
+  versions: string[]
+): Record<string, number> {
+  if (
+    typeof value !== 'object' ||
+    value === null ||
+    Array.isArray(value) ||
+    Object.values(value).some(
+      share => typeof share !== 'number' || !(share >= 0)
+    )
+  ) {
+    throw new Error(`"${field}" must map versions to non-negative shares`);
+  }
+  const traffic = value as Record<string, number>;
+  for (const version of Object.keys(traffic)) {
+    if (!versions.includes(version)) {
+      throw new Error(`"${field}" names unknown version ${version}`);
+    }
+  }
+  if (!Object.values(traffic).some(share => share > 0)) {
+    throw new Error(`"${field}" must give at least one version a share`);
+  }
+  return traffic;
+}
+
+/**
+ * Read a daemon config file, resolving project directories against it
+ */
+export async function loadDaemonConfig(path: string): Promise<DaemonConfig> {
+  let config: DaemonConfig;
+  try {
+    config = validateDaemonConfig(JSON.parse(await readFile(path, 'utf-8')));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new Error(`Invalid ${path}: ${reason}`);
+  }
+
+  const base = dirname(resolve(path));
+  const projects: Record<string, string | VersionedProject> = {};
+  for (const [name, project] of Object.entries(config.projects ?? {})) {
+    projects[name] =
+      typeof project === 'string'
+        ? resolve(base, project)
+        : {
+            ...project,
+            versions: Object.fromEntries(
+              Object.entries(project.versions).map(([version, dir]) => [
+                version,
+                resolve(base, dir),
+              ])
+            ),
+          };
+  }
+  return { ...config, projects };
+}
+
+/**
+ * Find the tenant whose token is presented in an Authorization header
+ */
+export function authenticate(
+  header: string | undefined,
+  tenants: TenantPolicy[]
+): TenantPolicy | undefined {
+  const actual = Buffer.from(header ?? '');
+  // Compare against every tenant so timing does not reveal which matched
+  let match: TenantPolicy | undefined;
+  for (const tenant of tenants) {
+    const expected = Buffer.from(`Bearer ${tenant.token}`);
+    if (
+      actual.length === expected.length &&
+      timingSafeEqual(actual, expected)
+    ) {
+      match = tenant;
+    }
+  }
+  return match;
+}
+
+/**
+ * Timeout a run gets under a tenant's policy or a role (0 = no timeout)
+ */
+export function effectiveTimeout(
+  limits: { maxTimeout?: number },
+  requested: number | undefined
+): number | undefined {
+  if (limits.maxTimeout === undefined) {
+    return requested;
+  }
+  return requested ? Math.min(requested, limits.maxTimeout) : limits.maxTimeout;
+}
+
+/**
+ * Resolve a script inside a project directory, refusing paths that leave it
+ */
+export function resolveProjectFile(dir: string, file: string): string {
+  const path = resolve(dir, file);
+  const inside = relative(dir, path);
+  if (inside.startsWith('..') || isAbsolute(inside)) {
+    throw new Error(`"${file}" is outside the project`);
+  }
+  return path;
+}
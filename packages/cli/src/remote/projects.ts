@@ -0,0 +1,175 @@
+// Registered projects of an mcpsd executor and the versions runs go to
+//
+// A project is one directory, or several versions of it, such as releases
+// checked out side by side. Runs of a versioned project go to the version
+// they pin, or to one picked by the project's traffic split, which tenants
+// allowed to deploy change while the executor runs: shifting runs to a new
+// version a share at a time, and rolling back to the split before.
+import { HttpError } from '../http.js';
+import { validateTraffic, type VersionedProject } from './policy.js';
+
+/**
+ * A project as reported by its endpoint
+ */
+export interface ProjectStatus {
+  name: string;
+  /** Versions of the project, which runs may pin */
+  versions?: string[];
+  /** Share of the runs each version gets */
+  traffic?: Record<string, number>;
+  /** Split a rollback goes back to */
+  previousTraffic?: Record<string, number>;
+}
+
+interface RegisteredProject {
+  dir?: string;
+  versions?: Record<string, string>;
+  traffic?: Record<string, number>;
+  /** Earlier splits, the latest last */
+  history: Record<string, number>[];
+}
+
+/**
+ * Where a run of a project executes
+ */
+export interface ProjectTarget {
+  dir: string;
+  version?: string;
+}
+
+/**
+ * The projects an executor runs scripts from, with their traffic splits
+ * Changed splits last until the executor restarts.
+ */
+export class ProjectRegistry {
+  private readonly projects = new Map<string, RegisteredProject>();
+
+  /**
+   * @param random Picks versions by traffic; Math.random unless testing
+   */
+  constructor(
+    projects: Record<string, string | VersionedProject>,
+    private readonly random: () => number = Math.random
+  ) {
+    for (const [name, project] of Object.entries(projects)) {
+      this.projects.set(
+        name,
+        typeof project === 'string'
+          ? { dir: project, history: [] }
+          : {
+              versions: project.versions,
+              traffic: project.traffic ?? {
+                [Object.keys(project.versions)[0]]: 100,
+              },
+              history: [],
+            }
+      );
+    }
+  }
+
+  names(): string[] {
+    return [...this.projects.keys()];
+  }
+
+  has(name: string): boolean {
+    return this.projects.has(name);
+  }
+
+  status(name: string): ProjectStatus {
+    const project = this.get(name);
+    if (!project.versions) {
+      return { name };
+    }
+    return {
+      name,
+      versions: Object.keys(project.versions),
+      traffic: project.traffic,
+      previousTraffic: project.history[project.history.length - 1],
+    };
+  }
+
+  /**
+   * The directory a run of a project executes in: that of the version it
+   * pins, or of one picked by the project's traffic split
+   */
+  target(name: string, version?: string): ProjectTarget {
+    const project = this.get(name);
+    if (!project.versions) {
+      if (version !== undefined) {
+        throw new HttpError(404, `Project ${name} has no version ${version}`);
+      }
+      return { dir: project.dir! };
+    }
+    version ??= this.pick(project.traffic!);
+    const dir = project.versions[version];
+    if (dir === undefined) {
+      throw new HttpError(404, `Project ${name} has no version ${version}`);
+    }
+    return { dir, version };
+  }
+
+  /**
+   * Split the runs of a project between its versions from now on
+   */
+  setTraffic(name: string, traffic: unknown): ProjectStatus {
+    const project = this.versioned(name);
+    let split: Record<string, number>;
+    try {
+      split = validateTraffic(
+        'traffic',
+        traffic,
+        Object.keys(project.versions!)
+      );
+    } catch (error) {
+      throw new HttpError(400, (error as Error).message);
+    }
+    project.history.push(project.traffic!);
+    project.traffic = { ...split };
+    return this.status(name);
+  }
+
+  /**
+   * Go back to the split of a project before its last change
+   */
+  rollback(name: string): ProjectStatus {
+    const project = this.versioned(name);
+    const previous = project.history.pop();
+    if (!previous) {
+      throw new HttpError(
+        409,
+        `Project ${name} has no earlier traffic to roll back to`
+      );
+    }
+    project.traffic = previous;
+    return this.status(name);
+  }
+
+  private get(name: string): RegisteredProject {
+    const project = this.projects.get(name);
+    if (!project) {
+      throw new HttpError(404, `Unknown project: ${name}`);
+    }
+    return project;
+  }
+
+  private versioned(name: string): RegisteredProject {
+    const project = this.get(name);
+    if (!project.versions) {
+      throw new HttpError(409, `Project ${name} has no versions`);
+    }
+    return project;
+  }
+
+  private pick(traffic: Record<string, number>): string {
+    const shares = Object.entries(traffic).filter(([, share]) => share > 0);
+    const total = shares.reduce((sum, [, share]) => sum + share, 0);
+    let point = this.random() * total;
+    for (const [version, share] of shares) {
+      point -= share;
+      if (point < 0) {
+        return version;
+      }
+    }
+    return shares[shares.length - 1][0];
+  }
+}
@@ -0,0 +1,258 @@
+// Wire protocol between `mcps run --remote` and the mcpsd executor
+//
+// The client POSTs a RunRequest as JSON to RUNS_PATH with a bearer token.
+// The daemon answers with a stream of newline-delimited JSON RunEvents
+// that ends with an "exit" event, or with the run's RunStatus when the
+// request is detached. Runs can then be inspected at RUNS_PATH/<id>, their
+// events streamed from RUNS_PATH/<id>/events and cancelled with DELETE.
+// RUNS_PATH/<id>/logs answers with the same events and the run's structured
+// log (statements executed, tool calls and agent steps), filtered by the
+// `server` and `level` query parameters; with `follow=true` it goes on
+// streaming them until the run exits. PROJECTS_PATH/<name> reports a
+// registered project; the versions of a versioned one are split runs by
+// PUT PROJECTS_PATH/<name>/traffic, and POST PROJECTS_PATH/<name>/rollback
+// goes back to the split before. QUOTAS_PATH reports how much of its
+// quotas the tenant has used; requests and runs going over one are answered
+// with, or stopped by, an error naming the quota.
+import {
+  atLeast,
+  LOG_SEVERITIES,
+  type LogEntry,
+  type LogSeverity,
+} from '@mcpscript/runtime';
+
+export const RUNS_PATH = '/v1/runs';
+export const PROJECTS_PATH = '/v1/projects';
+export const QUOTAS_PATH = '/v1/quotas';
+export const DEFAULT_DAEMON_PORT = 7337;
+/** Largest request body the daemon accepts */
+export const MAX_REQUEST_BYTES = 1024 * 1024;
+
+/**
+ * A script submitted for remote execution
+ */
+export interface RunRequest {
+  /** File name used in error messages, or the script's path in a project */
+  file: string;
+  /** Source to run; omitted to run `file` from a registered project */
+  source?: string;
+  /** Registered project the run executes in */
+  project?: string;
+  /** Version of the project to run, instead of the one traffic picks */
+  version?: string;
+  /** Execution timeout in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** Answer with the run's status instead of streaming its events */
+  detach?: boolean;
+  /** Role of the project's .mcpsrc to run in */
+  role?: string;
+}
+
+export type RunState =
+  | 'queued'
+  | 'running'
+  | 'succeeded'
+  | 'failed'
+  | 'cancelled';
+
+/**
+ * A run as reported by the status endpoints
+ */
+export interface RunStatus {
+  id: string;
+  tenant: string;
+  file: string;
+  project?: string;
+  /** Version of the project the run executes */
+  version?: string;
+  /** Subject of the caller the run works for */
+  caller?: string;
+  state: RunState;
+  exitCode?: number;
+  /** ISO timestamps */
+  createdAt: string;
+  startedAt?: string;
+  finishedAt?: string;
+}
+
+export type QuotaName = 'concurrentRuns' | 'toolCallsPerHour' | 'tokensPerDay';
+
+/**
+ * How much of a quota a tenant has used
+ */
+export interface QuotaUsage {
+  /** Unlimited when omitted */
+  limit?: number;
+  used: number;
+  /** ISO timestamp of the start of the next hour or day */
+  resetsAt?: string;
+}
+
+/**
+ * The quotas of a tenant, as reported at QUOTAS_PATH
+ */
+export interface TenantQuotas {
+  tenant: string;
+  quotas: Record<QuotaName, QuotaUsage>;
+}
+
+/**
+ * A quota a request or run went over, sent with the error it fails with
+ */
+export interface QuotaExceeded extends QuotaUsage {
+  name: QuotaName;
+  limit: number;
+}
+
+/**
+ * Output of a remote run, in the order it was produced
+ */
+export type RunEvent =
+  | { type: 'output'; stream: 'stdout' | 'stderr'; text: string }
+  | { type: 'message'; title: string; body: string }
+  | { type: 'error'; message: string; quota?: QuotaExceeded }
+  | { type: 'log'; entry: LogEntry }
+  | { type: 'exit'; code: number };
+
+/**
+ * Which events a client following a run's log wants
+ */
+export interface LogFilter {
+  /** Only the log entries of these servers */
+  servers?: string[];
+  /** Least severity of the events (default: debug) */
+  level?: LogSeverity;
+}
+
+/**
+ * Check the shape of a submitted run request
+ */
+export function validateRunRequest(value: unknown): RunRequest {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('request must be a JSON object');
+  }
+  const { file, source, project, version, timeout, detach, role } =
+    value as Record<string, unknown>;
+  if (typeof file !== 'string' || !file.endsWith('.mcps')) {
+    throw new Error('"file" must be a .mcps file name');
+  }
+  if (project !== undefined && typeof project !== 'string') {
+    throw new Error('"project" must be a string');
+  }
+  if (
+    version !== undefined &&
+    (typeof version !== 'string' || project === undefined)
+  ) {
+    throw new Error('"version" must be a string, given with "project"');
+  }
+  if (
+    (project === undefined || source !== undefined) &&
+    typeof source !== 'string'
+  ) {
+    throw new Error('"source" must be a string');
+  }
+  if (
+    timeout !== undefined &&
+    (typeof timeout !== 'number' || !Number.isInteger(timeout) || timeout < 0)
+  ) {
+    throw new Error('"timeout" must be a non-negative integer');
+  }
+  if (detach !== undefined && typeof detach !== 'boolean') {
+    throw new Error('"detach" must be a boolean');
+  }
+  if (role !== undefined && typeof role !== 'string') {
+    throw new Error('"role" must be a string');
+  }
+  return {
+    file,
+    source: source as string | undefined,
+    project,
+    version,
+    timeout,
+    detach,
+    role,
+  };
+}
+
+/**
+ * Check the shape of a new traffic split; the versions it names are
+ * checked against the project
+ */
+export function validateTrafficRequest(value: unknown): { traffic: unknown } {
+  if (typeof value !== 'object' || value === null || !('traffic' in value)) {
+    throw new Error('request must be a JSON object with "traffic"');
+  }
+  return value as { traffic: unknown };
+}
+
+/**
+ * Serialize an event as one line of the response stream
+ */
+export function encodeEvent(event: RunEvent): string {
+  return `${JSON.stringify(event)}\n`;
+}
+
+/**
+ * Splits a response stream into events as chunks arrive
+ */
+export class EventDecoder {
+  private buffer = '';
+
+  push(chunk: string): RunEvent[] {
+    this.buffer += chunk;
+    const lines = this.buffer.split('\n');
+    this.buffer = lines.pop() ?? '';
+    return lines
+      .filter(line => line.trim() !== '')
+      .map(line => JSON.parse(line) as RunEvent);
+  }
+
+  /**
+   * Whether a partial event is still waiting for the rest of its line
+   */
+  get pending(): boolean {
+    return this.buffer.trim() !== '';
+  }
+}
+
+/**
+ * Read a log filter from the query parameters of a request
+ */
+export function parseLogFilter(params: URLSearchParams): LogFilter {
+  const servers = params.getAll('server');
+  const level = params.get('level') ?? undefined;
+  if (level !== undefined && !LOG_SEVERITIES.includes(level as LogSeverity)) {
+    throw new Error(
+      `"level" must be one of ${LOG_SEVERITIES.join(', ')}, not ${level}`
+    );
+  }
+  return {
+    ...(servers.length > 0 && { servers }),
+    ...(level && { level: level as LogSeverity }),
+  };
+}
+
+/**
+ * Whether an event passes a log filter
+ * Output and messages count as info and errors as error; events other than
+ * log entries have no server, so they are left out when servers are given.
+ * The exit event always passes, since it ends the stream
+ */
+export function matchesLogFilter(event: RunEvent, filter: LogFilter): boolean {
+  if (event.type === 'exit') {
+    return true;
+  }
+  if (filter.servers) {
+    const server = event.type === 'log' ? event.entry.server : undefined;
+    if (server === undefined || !filter.servers.includes(server)) {
+      return false;
+    }
+  }
+  const severity =
+    event.type === 'log'
+      ? event.entry.severity
+      : event.type === 'error'
+        ? 'error'
+        : 'info';
+  return atLeast(severity, filter.level ?? 'debug');
+}
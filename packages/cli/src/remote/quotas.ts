@@ -0,0 +1,123 @@
+// Quotas of the tenants of an mcpsd executor
+//
+// Besides the runs it may have at once, a tenant can be limited in the MCP
+// tool calls its runs make each hour and the LLM tokens they use each day.
+// Both are counted from the log entries runs send, tokens as the agents
+// estimate them, over UTC calendar hours and days. A tenant that has used
+// up a quota cannot start runs until it resets, and a run that goes over
+// one is cancelled.
+import type { LogEntry } from '@mcpscript/runtime';
+import type { TenantPolicy } from './policy.js';
+import type {
+  QuotaExceeded,
+  QuotaName,
+  QuotaUsage,
+  TenantQuotas,
+} from './protocol.js';
+
+const HOUR = 60 * 60 * 1000;
+const DAY = 24 * HOUR;
+
+/**
+ * What a tenant used in the current hour or day
+ */
+interface Window {
+  start: number;
+  used: number;
+}
+
+/**
+ * Counts what the runs of each tenant use
+ */
+export class QuotaTracker {
+  private readonly toolCalls = new Map<string, Window>();
+  private readonly tokens = new Map<string, Window>();
+
+  /**
+   * @param now Current time; Date.now unless testing
+   */
+  constructor(private readonly now: () => number = Date.now) {}
+
+  /**
+   * Count the tool call or agent turn of a run's log entry
+   */
+  record(tenant: string, entry: LogEntry): void {
+    if (entry.kind === 'tool_call' && entry.server !== undefined) {
+      this.window(this.toolCalls, tenant, HOUR).used++;
+    } else if (entry.kind === 'agent_turn' && entry.tokens) {
+      this.window(this.tokens, tenant, DAY).used += entry.tokens;
+    }
+  }
+
+  /**
+   * How much of its quotas a tenant has used
+   * @param activeRuns Runs of the tenant queued or running
+   */
+  status(tenant: TenantPolicy, activeRuns: number): TenantQuotas {
+    const usage = (
+      windows: Map<string, Window>,
+      length: number,
+      limit: number | undefined
+    ): QuotaUsage => {
+      const { start, used } = this.window(windows, tenant.name, length);
+      return { limit, used, resetsAt: new Date(start + length).toISOString() };
+    };
+    return {
+      tenant: tenant.name,
+      quotas: {
+        concurrentRuns: { limit: tenant.maxConcurrentRuns, used: activeRuns },
+        toolCallsPerHour: usage(
+          this.toolCalls,
+          HOUR,
+          tenant.maxToolCallsPerHour
+        ),
+        tokensPerDay: usage(this.tokens, DAY, tenant.maxTokensPerDay),
+      },
+    };
+  }
+
+  private window(
+    windows: Map<string, Window>,
+    tenant: string,
+    length: number
+  ): Window {
+    const start = Math.floor(this.now() / length) * length;
+    let window = windows.get(tenant);
+    if (!window || window.start !== start) {
+      window = { start, used: 0 };
+      windows.set(tenant, window);
+    }
+    return window;
+  }
+}
+
+/**
+ * The first quota a tenant has used up, leaving no room for another run,
+ * or with `over`, the first it has gone beyond
+ */
+export function exceededQuota(
+  { quotas }: TenantQuotas,
+  over = false
+): QuotaExceeded | undefined {
+  for (const [name, usage] of Object.entries(quotas)) {
+    const { limit, used } = usage;
+    if (limit !== undefined && (over ? used > limit : used >= limit)) {
+      return { name: name as QuotaName, ...usage, limit };
+    }
+  }
+  return undefined;
+}
+
+/**
+ * Message of an error for a quota a tenant has used up or gone beyond
+ */
+export function quotaMessage(tenant: string, quota: QuotaExceeded): string {
+  switch (quota.name) {
+    case 'concurrentRuns':
+      return `Tenant ${tenant} already has ${quota.limit} runs in progress`;
+    case 'toolCallsPerHour':
+      return `Tenant ${tenant} has used its ${quota.limit} tool calls of this hour`;
+    case 'tokensPerDay':
+      return `Tenant ${tenant} has used its ${quota.limit} tokens of today`;
+  }
+}
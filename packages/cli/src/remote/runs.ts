@@ -0,0 +1,244 @@
+// Runs managed by mcpsd: queueing, worker processes and event logs
+import { EventEmitter } from 'events';
+import { fork, type ChildProcess } from 'child_process';
+import { randomUUID } from 'crypto';
+import type { ParseLimits } from '@mcpscript/transpiler';
+import type { CallerIdentity, ScriptArguments } from '@mcpscript/runtime';
+import type { RunEvent, RunState, RunStatus } from './protocol.js';
+
+/** Events kept per run for clients that attach late */
+export const MAX_RUN_EVENTS = 10_000;
+/** Finished runs kept for status queries before the oldest are forgotten */
+export const MAX_FINISHED_RUNS = 100;
+/** Exit code reported for runs cancelled before they started */
+export const CANCELLED_EXIT_CODE = 143;
+
+/**
+ * Everything a worker needs to execute a run
+ */
+export interface RunSpec {
+  tenant: string;
+  file: string;
+  source: string;
+  project?: string;
+  /** Version of the project the run executes */
+  version?: string;
+  timeout?: number;
+  /** Role of the project's .mcpsrc the run is started in */
+  role?: string;
+  /** Working directory of the worker (project config is read from it) */
+  cwd: string;
+  /** Caps on the size of the script and the modules it imports */
+  parseLimits?: ParseLimits;
+  /** Arguments of the script's main tool */
+  args?: ScriptArguments;
+  /** Caller the run works for, passed on to the MCP servers it calls */
+  caller?: CallerIdentity;
+}
+
+/**
+ * Message sent to a worker to start its run
+ */
+export type WorkerRequest = Pick<
+  RunSpec,
+  'file' | 'source' | 'timeout' | 'role' | 'parseLimits' | 'args' | 'caller'
+>;
+
+/**
+ * One submitted run
+ * Emits "event" for each recorded event and "finish" once it has exited
+ */
+export class Run extends EventEmitter {
+  readonly id = randomUUID();
+  readonly createdAt = new Date();
+  startedAt?: Date;
+  finishedAt?: Date;
+  state: RunState = 'queued';
+  exitCode?: number;
+  /** Recorded events, oldest first (trimmed to MAX_RUN_EVENTS) */
+  readonly events: RunEvent[] = [];
+  private worker?: ChildProcess;
+  private cancelled = false;
+
+  constructor(readonly spec: RunSpec) {
+    super();
+  }
+
+  get finished(): boolean {
+    return this.finishedAt !== undefined;
+  }
+
+  status(): RunStatus {
+    return {
+      id: this.id,
+      tenant: this.spec.tenant,
+      file: this.spec.file,
+      project: this.spec.project,
+      version: this.spec.version,
+      caller: this.spec.caller?.subject,
+      state: this.state,
+      exitCode: this.exitCode,
+      createdAt: this.createdAt.toISOString(),
+      startedAt: this.startedAt?.toISOString(),
+      finishedAt: this.finishedAt?.toISOString(),
+    };
+  }
+
+  record(event: RunEvent): void {
+    this.events.push(event);
+    if (this.events.length > MAX_RUN_EVENTS) {
+      this.events.shift();
+    }
+    this.emit('event', event);
+  }
+
+  /**
+   * Start the run in a forked worker
+   */
+  start(workerPath: string): void {
+    this.state = 'running';
+    this.startedAt = new Date();
+
+    const worker = fork(workerPath, [], {
+      cwd: this.spec.cwd,
+      stdio: ['ignore', 'pipe', 'pipe', 'ipc'],
+      serialization: 'json',
+    });
+    this.worker = worker;
+    worker.stdout?.setEncoding('utf-8');
+    worker.stderr?.setEncoding('utf-8');
+    worker.stdout?.on('data', (text: string) =>
+      this.record({ type: 'output', stream: 'stdout', text })
+    );
+    worker.stderr?.on('data', (text: string) =>
+      this.record({ type: 'output', stream: 'stderr', text })
+    );
+    worker.on('message', event => this.record(event as RunEvent));
+    worker.on('error', error =>
+      this.record({ type: 'error', message: error.message })
+    );
+    worker.on('close', (code, signal) =>
+      this.finish(code ?? (signal ? 1 : 0))
+    );
+
+    const { file, source, timeout, role, parseLimits, args, caller } =
+      this.spec;
+    const request: WorkerRequest = {
+      file,
+      source,
+      timeout,
+      role,
+      parseLimits,
+      args,
+      caller,
+    };
+    worker.send(request);
+  }
+
+  /**
+   * Stop the run; queued runs finish immediately
+   */
+  cancel(): void {
+    if (this.finished) {
+      return;
+    }
+    this.cancelled = true;
+    if (this.worker) {
+      this.worker.kill('SIGTERM');
+    } else {
+      this.finish(CANCELLED_EXIT_CODE);
+    }
+  }
+
+  private finish(code: number): void {
+    this.exitCode = code;
+    this.finishedAt = new Date();
+    this.state = this.cancelled
+      ? 'cancelled'
+      : code === 0
+        ? 'succeeded'
+        : 'failed';
+    this.record({ type: 'exit', code });
+    this.emit('finish');
+  }
+}
+
+export interface RunManagerOptions {
+  /** Module forked for each run */
+  workerPath: string;
+  /** Runs executed at once; later runs wait in a queue */
+  maxConcurrentRuns?: number;
+}
+
+/**
+ * Queues submitted runs and starts them as capacity allows
+ */
+export class RunManager {
+  private readonly runs = new Map<string, Run>();
+  private readonly queue: Run[] = [];
+  private running = 0;
+
+  constructor(private readonly options: RunManagerOptions) {}
+
+  submit(spec: RunSpec): Run {
+    const run = new Run(spec);
+    this.runs.set(run.id, run);
+    run.once('finish', () => {
+      if (run.startedAt) {
+        this.running--;
+      } else {
+        this.queue.splice(this.queue.indexOf(run), 1);
+      }
+      this.prune();
+      this.startQueued();
+    });
+    this.queue.push(run);
+    this.startQueued();
+    return run;
+  }
+
+  get(id: string): Run | undefined {
+    return this.runs.get(id);
+  }
+
+  /**
+   * Known runs, oldest first, optionally only those of one tenant
+   */
+  list(tenant?: string): Run[] {
+    return [...this.runs.values()].filter(
+      run => tenant === undefined || run.spec.tenant === tenant
+    );
+  }
+
+  /**
+   * Number of queued or running runs of a tenant
+   */
+  activeRuns(tenant: string): number {
+    return this.list(tenant).filter(run => !run.finished).length;
+  }
+
+  /**
+   * Cancel every run, e.g. when the daemon shuts down
+   */
+  cancelAll(): void {
+    for (const run of this.runs.values()) {
+      run.cancel();
+    }
+  }
+
+  private startQueued(): void {
+    const limit = this.options.maxConcurrentRuns ?? Infinity;
+    while (this.queue.length > 0 && this.running < limit) {
+      const run = this.queue.shift()!;
+      this.running++;
+      run.start(this.options.workerPath);
+    }
+  }
+
+  private prune(): void {
+    const finished = [...this.runs.values()].filter(run => run.finished);
+    for (const run of finished.slice(0, -MAX_FINISHED_RUNS)) {
+      this.runs.delete(run.id);
+    }
+  }
+}
@@ -0,0 +1,135 @@
+// Child process that executes one remote run for mcpsd
+//
+// The daemon forks this module, sends the WorkerRequest over IPC and relays
+// the events it sends back, including the entries of the run's log. Console
+// output and server stderr reach the daemon through the process's stdout
+// and stderr pipes.
+import { resolve } from 'path';
+import { TypeCheckError, setParseLimits } from '@mcpscript/transpiler';
+import {
+  executeInVM,
+  MCPServerManager,
+  RunLog,
+  RunOutcome,
+} from '@mcpscript/runtime';
+import { loadProjectConfig, secretProviders, spillOptions } from '../config.js';
+import { formatScriptError } from '../ui/script-error.js';
+import { findRole } from '../roles.js';
+import { headlessQuestions } from '../approvals.js';
+import { outcomeFailure } from '../exit-codes.js';
+import { offlineMode } from '../offline.js';
+import { AnalysisCache } from '../cache.js';
+import { prepareRun } from '../prepared-run.js';
+import { effectiveTimeout } from './policy.js';
+import type { RunEvent } from './protocol.js';
+import type { WorkerRequest } from './runs.js';
+
+// Events are delivered in order, so waiting for the last one to be sent
+// means every earlier one has been sent as well
+let lastSent: Promise<void> = Promise.resolve();
+
+function send(event: RunEvent): Promise<void> {
+  lastSent = new Promise(resolve => {
+    if (!process.send) {
+      resolve();
+      return;
+    }
+    process.send(event, undefined, {}, () => resolve());
+  });
+  return lastSent;
+}
+
+const serverManager = new MCPServerManager();
+process.once('SIGTERM', () => {
+  serverManager.closeAll().finally(() => process.exit(143));
+});
+
+async function run(request: WorkerRequest): Promise<number> {
+  try {
+    setParseLimits(request.parseLimits ?? {});
+    const loaded = await loadProjectConfig(process.cwd());
+    const { config } = loaded;
+    const role = request.role ? findRole(loaded, request.role) : undefined;
+    // Imports resolve on the executor, relative to the run's directory.
+    // Debug hooks report the statements the run executes to its log
+    const prepared = await prepareRun(
+      resolve(request.file),
+      request.source,
+      loaded,
+      {
+        codegen: { sourcePositions: true, debugHooks: true },
+        cache: new AnalysisCache(),
+      }
+    );
+    const log = new RunLog(entry => void send({ type: 'log', entry }), {
+      source: request.source,
+    });
+
+    const outcome = new RunOutcome();
+    await executeInVM(prepared.jsCode, {
+      timeout: role ? effectiveTimeout(role, request.timeout) : request.timeout,
+      // print() output goes to stdout so it can be piped on the client;
+      // titled messages (agent conversations) are sent as events
+      addMessage: ({ title, body }) => {
+        if (title) {
+          void send({ type: 'message', title, body });
+        } else {
+          process.stdout.write(`${body}\n`);
+        }
+      },
+      userInput: () =>
+        Promise.reject(new Error('input() is not available in remote runs')),
+      ask: headlessQuestions(
+        config.approvals,
+        resolve(request.file),
+        'remote runs'
+      ),
+      serverManager,
+      debugger: log.debugger,
+      tracer: log.tracer,
+      sourceFile: request.file,
+      source: request.source,
+      redaction: config.redaction,
+      secretProviders: secretProviders(loaded),
+      project: config.project,
+      // Nobody can approve calls in remote runs, so the calls a role needs
+      // approval for are refused
+      profile: role?.profile,
+      caller: request.caller,
+      outcome,
+      locale: config.locale,
+      spill: spillOptions(loaded),
+      offline: offlineMode(),
+      packages: prepared.packages,
+      args: request.args,
+    });
+
+    // The run fails anyway if the project gives its issues an exit code
+    const failure = outcomeFailure(outcome, config.exitCodes);
+    if (failure) {
+      await send({ type: 'error', message: failure.message });
+      return failure.code;
+    }
+    return 0;
+  } catch (error) {
+    const message =
+      error instanceof TypeCheckError
+        ? `Type errors in ${request.file}:\n${error.message}`
+        : error instanceof Error
+          ? formatScriptError(error, false)
+          : String(error);
+    await send({ type: 'error', message });
+    return 1;
+  }
+}
+
+process.once('message', (request: WorkerRequest) => {
+  run(request).then(async code => {
+    // Exit once pending output is written, even if the script left timers
+    // or handles behind
+    await lastSent;
+    process.stdout.write('', () => {
+      process.stderr.write('', () => process.exit(code));
+    });
+  });
+});
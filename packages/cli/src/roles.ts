@@ -0,0 +1,95 @@
+// Roles: execution profiles a project defines in .mcpsrc
+//
+// `mcps run --as <role>` starts a run in a role, and mcpsd tenants can be
+// bound to one. A role limits the MCP tools a run may call and how often,
+// names the calls a person must approve, and caps the run's timeout.
+// Calls answered "always" at the approval prompt are added to the role's
+// approvedCalls, so the approvals of a project converge to a policy kept,
+// and reviewed, with its .mcpsrc.
+import { readFile, writeFile } from 'fs/promises';
+import type { ApprovedCall, ExecutionProfile } from '@mcpscript/runtime';
+import type { LoadedConfig, RoleConfig } from './config.js';
+
+/**
+ * A role resolved from a project config
+ */
+export interface Role {
+  profile: ExecutionProfile;
+  /** Longest timeout in milliseconds runs in the role may have */
+  maxTimeout?: number;
+}
+
+/**
+ * Find a role in a loaded config
+ * Throws when the project does not define it, so that a run meant to be
+ * restricted never runs without its restrictions
+ */
+export function findRole(loaded: LoadedConfig, name: string): Role {
+  const roles: Record<string, RoleConfig> = loaded.config.roles ?? {};
+  if (!Object.hasOwn(roles, name)) {
+    const where = loaded.path ?? 'the project config';
+    const defined = Object.keys(roles);
+    throw new Error(
+      defined.length > 0
+        ? `Unknown role "${name}"; ${where} defines ${defined.join(', ')}`
+        : `Unknown role "${name}"; ${where} defines no roles`
+    );
+  }
+  const { maxTimeout, ...limits } = roles[name];
+  return { profile: { role: name, ...limits }, maxTimeout };
+}
+
+
+/**
+ * The calls an "always" answer approves: calls of the tool whose arguments
+ * match the name=pattern pairs given, or calls with the same arguments as
+ * this one when none are given. Patterns that are JSON numbers, booleans,
+ * null or quoted strings match that value; others match strings, with *
+ * matching any text
+ */
+export function alwaysApprovedCall(
+  tool: string,
+  input: unknown,
+  patterns: string[]
+): ApprovedCall {
+  if (patterns.length === 0) {
+    return typeof input === 'object' && input !== null
+      ? { tool, input: input as Record<string, unknown> }
+      : { tool };
+  }
+  const args: Record<string, unknown> = {};
+  for (const pair of patterns) {
+    const separator = pair.indexOf('=');
+    if (separator <= 0) {
+      throw new Error(`Expected name=pattern, got ${pair}`);
+    }
+    const pattern = pair.slice(separator + 1);
+    let value: unknown = pattern;
+    try {
+      value = JSON.parse(pattern);
+    } catch {
+      // Anything but JSON is a pattern of text
+    }
+    args[pair.slice(0, separator)] =
+      typeof value === 'object' && value !== null ? pattern : value;
+  }
+  return { tool, input: args };
+}
+
+/**
+ * Add an approved call to a role in the project's .mcpsrc
+ */
+export async function saveApprovedCall(
+  loaded: LoadedConfig,
+  role: string,
+  rule: ApprovedCall
+): Promise<void> {
+  if (!loaded.path) {
+    throw new Error('The project has no .mcpsrc to keep approvals in');
+  }
+  // Read again, so calls approved since the run started are kept
+  const config = JSON.parse(await readFile(loaded.path, 'utf-8'));
+  const settings = config.roles[role];
+  settings.approvedCalls = [...(settings.approvedCalls ?? []), rule];
+  await writeFile(loaded.path, `${JSON.stringify(config, null, 2)}\n`, 'utf-8');
+}
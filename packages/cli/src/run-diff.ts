@@ -0,0 +1,329 @@
+// Comparing two recorded runs: `mcps runs diff <old> <new>`
+//
+// When an automation that used to work starts behaving differently, the
+// transcripts of a good run and a bad one say where they parted. The tool
+// calls of the runs are lined up by longest common subsequence of their
+// tools, so a call made or skipped in one run shows up as added or
+// removed without every later call counting as changed. Calls made in
+// both are compared by their arguments, results and errors, and by how
+// long they took; agent runs by their final replies and errors.
+import type {
+  AgentRunRecord,
+  ToolCallRecord,
+  Transcript,
+} from '@mcpscript/runtime';
+
+// Values longer than this are cut short in text output
+const VALUE_WIDTH = 60;
+
+/**
+ * - added/removed: a tool call or agent run made in only one of the runs
+ * - changed: arguments, a result, an error, an agent's reply or a timing
+ */
+export type RunChangeKind = 'added' | 'removed' | 'changed';
+
+export interface RunChange {
+  kind: RunChangeKind;
+  /** What changed, e.g. "call #3 github.search" or "agent run #1 triage" */
+  subject: string;
+  message: string;
+  /** The value in the old run, for changes of values */
+  before?: unknown;
+  /** The value in the new run */
+  after?: unknown;
+}
+
+export interface RunDiffOptions {
+  /**
+   * Milliseconds a timing must change by to be reported (default: 1000);
+   * it must also at least double or halve
+   */
+  timingThreshold?: number;
+}
+
+/**
+ * Line up two lists by longest common subsequence of their keys, visiting
+ * items in order: with both indexes when matched, or with one when the
+ * item is only in that list
+ */
+function align<T>(
+  before: T[],
+  after: T[],
+  key: (item: T) => string,
+  visit: (a: number | undefined, b: number | undefined) => void
+): void {
+  const [a, b] = [before.map(key), after.map(key)];
+  const lengths = Array.from({ length: a.length + 1 }, () =>
+    new Array<number>(b.length + 1).fill(0)
+  );
+  for (let i = a.length - 1; i >= 0; i--) {
+    for (let j = b.length - 1; j >= 0; j--) {
+      lengths[i][j] =
+        a[i] === b[j]
+          ? lengths[i + 1][j + 1] + 1
+          : Math.max(lengths[i + 1][j], lengths[i][j + 1]);
+    }
+  }
+  let [i, j] = [0, 0];
+  while (i < a.length || j < b.length) {
+    if (i < a.length && j < b.length && a[i] === b[j]) {
+      visit(i++, j++);
+    } else if (
+      j === b.length ||
+      (i < a.length && lengths[i + 1][j] >= lengths[i][j + 1])
+    ) {
+      visit(i++, undefined);
+    } else {
+      visit(undefined, j++);
+    }
+  }
+}
+
+function isObject(value: unknown): value is Record<string, unknown> {
+  return typeof value === 'object' && value !== null && !Array.isArray(value);
+}
+
+/**
+ * The paths at which two JSON values differ, such as "query" or
+ * "content[0].text"; arrays of different lengths differ as a whole
+ */
+export function valueDifferences(
+  before: unknown,
+  after: unknown,
+  path = ''
+): { path: string; before: unknown; after: unknown }[] {
+  if (isObject(before) && isObject(after)) {
+    const keys = [...new Set([...Object.keys(before), ...Object.keys(after)])];
+    return keys.flatMap(key =>
+      valueDifferences(before[key], after[key], path ? `${path}.${key}` : key)
+    );
+  }
+  if (
+    Array.isArray(before) &&
+    Array.isArray(after) &&
+    before.length === after.length
+  ) {
+    return before.flatMap((item, index) =>
+      valueDifferences(item, after[index], `${path}[${index}]`)
+    );
+  }
+  return JSON.stringify(before) === JSON.stringify(after)
+    ? []
+    : [{ path, before, after }];
+}
+
+function formatValue(value: unknown): string {
+  if (value === undefined) {
+    return '(none)';
+  }
+  const text = JSON.stringify(value);
+  return text.length > VALUE_WIDTH
+    ? `${text.slice(0, VALUE_WIDTH - 3)}...`
+    : text;
+}
+
+function formatDuration(ms: number): string {
+  return ms < 1000 ? `${Math.round(ms)} ms` : `${(ms / 1000).toFixed(2)} s`;
+}
+
+/**
+ * Milliseconds each call took, from the previous call's answer or the
+ * start of the run; calls are only recorded once answered
+ */
+function callDurations(transcript: Transcript): number[] {
+  let previous = transcript.startedAt ?? transcript.toolCalls[0]?.time;
+  return transcript.toolCalls.map(call => {
+    const duration = call.time - (previous ?? call.time);
+    previous = call.time;
+    return Math.max(0, duration);
+  });
+}
+
+/**
+ * Milliseconds from the start of a run to the last thing it recorded
+ */
+function runDuration(transcript: Transcript): number | undefined {
+  const times = [
+    ...transcript.toolCalls.map(call => call.time),
+    ...transcript.runs.map(run => run.finishedAt ?? run.startedAt),
+  ];
+  return transcript.startedAt === undefined || times.length === 0
+    ? undefined
+    : Math.max(...times) - transcript.startedAt;
+}
+
+/**
+ * The text of the last reply of an agent run
+ */
+function agentOutput(record: AgentRunRecord): string | undefined {
+  const reply = [...record.messages]
+    .reverse()
+    .find(message => message.role === 'assistant');
+  if (!reply) {
+    return undefined;
+  }
+  return typeof reply.content === 'string'
+    ? reply.content
+    : reply.content
+        .map(part => (part.type === 'text' ? part.text : ''))
+        .join('');
+}
+
+class RunDiff {
+  readonly changes: RunChange[] = [];
+  private readonly threshold: number;
+
+  constructor(options: RunDiffOptions) {
+    this.threshold = options.timingThreshold ?? 1000;
+  }
+
+  private add(
+    kind: RunChangeKind,
+    subject: string,
+    message: string,
+    values?: { before: unknown; after: unknown }
+  ) {
+    this.changes.push({ kind, subject, message, ...values });
+  }
+
+  private timing(subject: string, before?: number, after?: number) {
+    if (before === undefined || after === undefined) {
+      return;
+    }
+    const shorter = Math.min(before, after);
+    const longer = Math.max(before, after);
+    if (longer - shorter >= this.threshold && longer >= shorter * 2) {
+      this.add(
+        'changed',
+        subject,
+        `took ${formatDuration(before)} -> ${formatDuration(after)}`,
+        { before, after }
+      );
+    }
+  }
+
+  private values(
+    subject: string,
+    label: string,
+    before: unknown,
+    after: unknown
+  ) {
+    for (const difference of valueDifferences(before, after)) {
+      const where = difference.path ? `${label} ${difference.path}` : label;
+      this.add(
+        'changed',
+        subject,
+        `${where}: ${formatValue(difference.before)} -> ${formatValue(difference.after)}`,
+        { before: difference.before, after: difference.after }
+      );
+    }
+  }
+
+  toolCalls(before: Transcript, after: Transcript) {
+    const [old, now] = [before.toolCalls, after.toolCalls];
+    const [oldDurations, newDurations] = [
+      callDurations(before),
+      callDurations(after),
+    ];
+    const subject = (call: ToolCallRecord, index: number) =>
+      `call #${index + 1} ${call.tool}`;
+    align(old, now, call => call.tool, (a, b) => {
+      if (b === undefined) {
+        this.add('removed', subject(old[a!], a!), 'not called');
+        return;
+      }
+      const label = subject(now[b], b);
+      if (a === undefined) {
+        this.add('added', label, 'called');
+        return;
+      }
+      this.values(label, 'arguments', old[a].input, now[b].input);
+      const [oldError, newError] = [old[a].error, now[b].error];
+      if (oldError !== newError) {
+        this.add(
+          'changed',
+          label,
+          `error: ${formatValue(oldError)} -> ${formatValue(newError)}`,
+          { before: oldError, after: newError }
+        );
+      } else if (oldError === undefined) {
+        this.values(label, 'result', old[a].result, now[b].result);
+      }
+      this.timing(label, oldDurations[a], newDurations[b]);
+    });
+  }
+
+  agentRuns(before: Transcript, after: Transcript) {
+    const [old, now] = [before.runs, after.runs];
+    const subject = (record: AgentRunRecord) =>
+      `agent run #${record.run} ${record.agent}`;
+    const took = (record: AgentRunRecord) =>
+      record.finishedAt === undefined
+        ? undefined
+        : record.finishedAt - record.startedAt;
+    align(old, now, record => record.agent, (a, b) => {
+      if (b === undefined) {
+        this.add('removed', subject(old[a!]), 'not run');
+        return;
+      }
+      const label = subject(now[b]);
+      if (a === undefined) {
+        this.add('added', label, 'run');
+        return;
+      }
+      const [oldError, newError] = [old[a].error, now[b].error];
+      if (oldError !== newError) {
+        this.add(
+          'changed',
+          label,
+          `error: ${formatValue(oldError)} -> ${formatValue(newError)}`,
+          { before: oldError, after: newError }
+        );
+      }
+      this.values(label, 'reply', agentOutput(old[a]), agentOutput(now[b]));
+      this.timing(label, took(old[a]), took(now[b]));
+    });
+  }
+
+  run(before: Transcript, after: Transcript) {
+    if (before.file !== after.file) {
+      this.add(
+        'changed',
+        'run',
+        `script: ${formatValue(before.file)} -> ${formatValue(after.file)}`,
+        { before: before.file, after: after.file }
+      );
+    }
+    this.timing('run', runDuration(before), runDuration(after));
+  }
+}
+
+/**
+ * Compare two recorded runs of a workflow: the tool calls made in only
+ * one of them, the arguments, results, errors and timings of the calls
+ * made in both, and the replies of their agent runs
+ */
+export function diffRuns(
+  before: Transcript,
+  after: Transcript,
+  options: RunDiffOptions = {}
+): RunChange[] {
+  const diff = new RunDiff(options);
+  diff.run(before, after);
+  diff.toolCalls(before, after);
+  diff.agentRuns(before, after);
+  return diff.changes;
+}
+
+const CHANGE_SIGNS: Record<RunChangeKind, string> = {
+  added: '+',
+  removed: '-',
+  changed: '~',
+};
+
+/**
+ * Format a change on one line as "<sign> <subject>: <message>"
+ */
+export function formatRunChange(change: RunChange): string {
+  return `${CHANGE_SIGNS[change.kind]} ${change.subject}: ${change.message}`;
+}
@@ -0,0 +1,66 @@
+// Sandbox policies of mcps run --policy
+//
+// A policy file is JSON limiting the servers, commands, tools, hosts,
+// server processes and run time of a script. The servers and tool calls
+// the script declares are checked against it before the script starts, so
+// a script that could only break the policy is refused without running;
+// what is only known at runtime is checked as the script runs.
+import { readFile } from 'fs/promises';
+import { collectCapabilities, type Statement } from '@mcpscript/transpiler';
+import {
+  checkSandboxPolicy,
+  policyViolations,
+  type PolicyViolation,
+  type SandboxPolicy,
+} from '@mcpscript/runtime';
+
+/**
+ * Read and check a policy file
+ */
+export async function loadSandboxPolicy(path: string): Promise<SandboxPolicy> {
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(await readFile(path, 'utf-8'));
+  } catch (error) {
+    throw new Error(
+      `Cannot read sandbox policy ${path}: ${error instanceof Error ? error.message : String(error)}`
+    );
+  }
+  try {
+    return checkSandboxPolicy(parsed);
+  } catch (error) {
+    throw new Error(
+      `Invalid sandbox policy ${path}: ${(error as Error).message}`
+    );
+  }
+}
+
+/**
+ * Error refusing to run a script that declares what its policy does not
+ * allow
+ */
+export class SandboxPolicyError extends Error {
+  constructor(public readonly violations: PolicyViolation[]) {
+    super(
+      [
+        'The script breaks the sandbox policy:',
+        ...violations.map(violation => `  ${violation.message}`),
+      ].join('\n')
+    );
+    this.name = 'SandboxPolicyError';
+  }
+}
+
+/**
+ * Throw a SandboxPolicyError when the servers or tool calls a script
+ * declares break the policy
+ */
+export function checkScriptPolicy(
+  statements: Statement[],
+  policy: SandboxPolicy
+): void {
+  const violations = policyViolations(policy, collectCapabilities(statements));
+  if (violations.length > 0) {
+    throw new SandboxPolicyError(violations);
+  }
+}
@@ -0,0 +1,290 @@
+// Script signatures, for organizations distributing automations
+//
+// `mcps sign` signs a script with an Ed25519 private key and writes the
+// signature next to it, as <script>.mcps.sig. A project whose .mcpsrc
+// lists trusted public keys under "signing" refuses to run scripts, or
+// import modules, that were changed after signing or signed with another
+// key; with "required" set it refuses unsigned ones as well.
+// Keys are plain Ed25519 PEM files, signed with node:crypto alone: age keys
+// cannot sign, and sigstore needs an OIDC login and a public log online.
+import {
+  createHash,
+  createPrivateKey,
+  createPublicKey,
+  generateKeyPairSync,
+  sign,
+  verify,
+  type KeyObject,
+} from 'crypto';
+import { readFileSync } from 'fs';
+import { dirname, resolve } from 'path';
+import type { ModuleLoader } from '@mcpscript/transpiler';
+import type { LoadedConfig } from './config.js';
+
+export const SIGNATURE_EXTENSION = '.sig';
+
+/**
+ * Contents of a signature file
+ */
+export interface ScriptSignature {
+  version: 1;
+  algorithm: 'ed25519';
+  /** Fingerprint of the public key that verifies the signature */
+  keyId: string;
+  /** SHA-256 of the signed source, as "sha256:<hex>" */
+  digest: string;
+  /** Ed25519 signature of the source, base64 */
+  signature: string;
+}
+
+/**
+ * - unsigned: no signature file
+ * - tampered: the source changed since it was signed
+ * - untrusted: signed with a key the project does not trust
+ * - invalid: the signature file is malformed
+ */
+export type SignatureFailure =
+  | 'unsigned'
+  | 'tampered'
+  | 'untrusted'
+  | 'invalid';
+
+export class SignatureError extends Error {
+  constructor(
+    message: string,
+    public readonly reason: SignatureFailure
+  ) {
+    super(message);
+    this.name = 'SignatureError';
+  }
+}
+
+/**
+ * What a project requires of the scripts it runs
+ */
+export interface SigningPolicy {
+  /** Refuse scripts without a signature, not only tampered ones */
+  required: boolean;
+  trustedKeys: KeyObject[];
+}
+
+export function signaturePath(scriptPath: string): string {
+  return `${scriptPath}${SIGNATURE_EXTENSION}`;
+}
+
+/**
+ * Generate an Ed25519 key pair, as PEM
+ */
+export function generateSigningKeys(): {
+  publicKey: string;
+  privateKey: string;
+} {
+  const { publicKey, privateKey } = generateKeyPairSync('ed25519');
+  return {
+    publicKey: publicKey.export({ type: 'spki', format: 'pem' }).toString(),
+    privateKey: privateKey.export({ type: 'pkcs8', format: 'pem' }).toString(),
+  };
+}
+
+/**
+ * Short fingerprint of a public key: SHA-256 of its DER encoding
+ */
+export function keyId(publicKey: KeyObject): string {
+  const der = publicKey.export({ type: 'spki', format: 'der' });
+  return createHash('sha256').update(der).digest('hex').slice(0, 16);
+}
+
+function digestOf(source: string): string {
+  return `sha256:${createHash('sha256').update(source).digest('hex')}`;
+}
+
+/**
+ * Sign a script's source with a PEM private key
+ */
+export function signScript(
+  source: string,
+  privateKeyPem: string
+): ScriptSignature {
+  const privateKey = createPrivateKey(privateKeyPem);
+  if (privateKey.asymmetricKeyType !== 'ed25519') {
+    throw new Error('Signing keys must be Ed25519 private keys');
+  }
+  return {
+    version: 1,
+    algorithm: 'ed25519',
+    keyId: keyId(createPublicKey(privateKey)),
+    digest: digestOf(source),
+    signature: sign(null, Buffer.from(source), privateKey).toString('base64'),
+  };
+}
+
+/**
+ * Check the shape of a parsed signature file
+ */
+export function validateSignature(value: unknown): ScriptSignature {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('signature must be a JSON object');
+  }
+  const { version, algorithm, keyId, digest, signature } = value as Record<
+    string,
+    unknown
+  >;
+  if (version !== 1) {
+    throw new Error(`unsupported signature version ${String(version)}`);
+  }
+  if (algorithm !== 'ed25519') {
+    throw new Error(`unsupported signature algorithm ${String(algorithm)}`);
+  }
+  for (const [name, field] of Object.entries({ keyId, digest, signature })) {
+    if (typeof field !== 'string') {
+      throw new Error(`"${name}" must be a string`);
+    }
+  }
+  return value as ScriptSignature;
+}
+
+/**
+ * Read the signature file of a script, if it has one
+ */
+export function readSignature(
+  scriptPath: string
+): ScriptSignature | undefined {
+  const path = signaturePath(scriptPath);
+  let content: string;
+  try {
+    content = readFileSync(path, 'utf-8');
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+      return undefined;
+    }
+    throw error;
+  }
+  try {
+    return validateSignature(JSON.parse(content));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new SignatureError(`Invalid ${path}: ${reason}`, 'invalid');
+  }
+}
+
+/**
+ * Verify a script's source against its signature, returning the key that
+ * signed it
+ * Throws a SignatureError when the script is unsigned, was changed, or was
+ * signed with none of the trusted keys
+ */
+export function verifyScript(
+  scriptPath: string,
+  source: string,
+  signature: ScriptSignature | undefined,
+  trustedKeys: KeyObject[]
+): KeyObject {
+  if (!signature) {
+    throw new SignatureError(`${scriptPath} is not signed`, 'unsigned');
+  }
+  const key = trustedKeys.find(key => keyId(key) === signature.keyId);
+  if (!key) {
+    throw new SignatureError(
+      `${scriptPath} is signed with untrusted key ${signature.keyId}`,
+      'untrusted'
+    );
+  }
+  const valid =
+    signature.digest === digestOf(source) &&
+    verify(
+      null,
+      Buffer.from(source),
+      key,
+      Buffer.from(signature.signature, 'base64')
+    );
+  if (!valid) {
+    throw new SignatureError(
+      `${scriptPath} does not match its signature; it was changed after signing`,
+      'tampered'
+    );
+  }
+  return key;
+}
+
+/**
+ * Read PEM public keys
+ */
+export function readPublicKeys(paths: string[]): KeyObject[] {
+  return paths.map(path => {
+    const key = createPublicKey(readFileSync(path, 'utf-8'));
+    if (key.asymmetricKeyType !== 'ed25519') {
+      throw new Error(`${path} is not an Ed25519 public key`);
+    }
+    return key;
+  });
+}
+
+/**
+ * The signing policy of a loaded config, if it has one
+ * Key paths are relative to the config file.
+ */
+export function signingPolicy(
+  loaded: LoadedConfig
+): SigningPolicy | undefined {
+  const { signing } = loaded.config;
+  if (!signing) {
+    return undefined;
+  }
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return {
+    required: signing.required ?? false,
+    trustedKeys: readPublicKeys(
+      signing.trustedKeys.map(path => resolve(base, path))
+    ),
+  };
+}
+
+/**
+ * Enforce a signing policy on one script: signed scripts must verify, and
+ * unsigned ones are refused when signatures are required
+ */
+export function checkScriptSignature(
+  scriptPath: string,
+  source: string,
+  policy: SigningPolicy
+): void {
+  const signature = readSignature(scriptPath);
+  if (signature || policy.required) {
+    verifyScript(scriptPath, source, signature, policy.trustedKeys);
+  }
+}
+
+/**
+ * A module loader that enforces a signing policy on every module it reads
+ */
+export function signedLoader(
+  loader: ModuleLoader,
+  policy: SigningPolicy
+): ModuleLoader {
+  return {
+    ...loader,
+    read: modulePath => {
+      const source = loader.read(modulePath);
+      checkScriptSignature(modulePath, source, policy);
+      return source;
+    },
+  };
+}
+
+/**
+ * Enforce the signing policy of a project, if it has one, on a script
+ * about to run, returning a loader that enforces it on its imports too
+ */
+export function enforceSigningPolicy(
+  loaded: LoadedConfig,
+  scriptPath: string,
+  source: string,
+  loader: ModuleLoader
+): ModuleLoader {
+  const policy = signingPolicy(loaded);
+  if (!policy) {
+    return loader;
+  }
+  checkScriptSignature(scriptPath, source, policy);
+  return signedLoader(loader, policy);
+}
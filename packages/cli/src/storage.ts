@@ -0,0 +1,812 @@
+// Storage of run state: checkpoints, run history, transcripts, audit logs
+// and the scheduled runs instances of a service have claimed
+//
+// Records are kept by collection and key, either as documents written
+// whole, such as checkpoints, or as journals appended to a line at a time,
+// such as transcripts. Runs keep them in files in the user's state
+// directory unless the project's .mcpsrc names a SQLite database, an S3
+// bucket or a Postgres database, which serve-mode deployments share
+// between their instances. The S3 and Postgres backends use the
+// @aws-sdk/client-s3 and pg packages, which are installed separately.
+import { randomUUID } from 'crypto';
+import { mkdirSync } from 'fs';
+import {
+  appendFile,
+  mkdir,
+  readdir,
+  readFile,
+  rename,
+  rm,
+  stat,
+  writeFile,
+} from 'fs/promises';
+import { homedir } from 'os';
+import { dirname, join, resolve } from 'path';
+import type { LoadedConfig, StorageConfig } from './config.js';
+
+export type StorageCollection =
+  | 'checkpoints'
+  | 'runs'
+  | 'transcripts'
+  | 'audit'
+  | 'schedules';
+
+export const STORAGE_COLLECTIONS: StorageCollection[] = [
+  'checkpoints',
+  'runs',
+  'transcripts',
+  'audit',
+  'schedules',
+];
+
+/** Extensions of the files of each collection in a directory */
+const EXTENSIONS: Record<StorageCollection, string> = {
+  checkpoints: '.json',
+  runs: '.json',
+  transcripts: '.jsonl',
+  audit: '.jsonl',
+  schedules: '.json',
+};
+
+/** Collections appended to a line at a time rather than written whole */
+const JOURNALS = new Set<StorageCollection>(['transcripts', 'audit']);
+
+/** Variables naming the directory of a collection, for local runs */
+const DIRECTORY_VARIABLES: Partial<Record<StorageCollection, string>> = {
+  checkpoints: 'MCPS_CHECKPOINT_DIR',
+  transcripts: 'MCPS_TRANSCRIPT_DIR',
+};
+
+const TABLE = 'mcps_records';
+
+export interface StoredRecord {
+  key: string;
+  modified: Date;
+}
+
+/**
+ * Where run state is kept
+ * Lines appended to a journal are kept in the order append() is called.
+ */
+export interface Storage {
+  read(
+    collection: StorageCollection,
+    key: string
+  ): Promise<string | undefined>;
+  /** Replace a document, or create it */
+  write(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<void>;
+  /**
+   * Create a document unless it exists, atomically even between instances
+   * sharing the storage; false when it already existed
+   */
+  create(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<boolean>;
+  /** Add a line to a journal, creating it if need be */
+  append(
+    collection: StorageCollection,
+    key: string,
+    line: string
+  ): Promise<void>;
+  delete(collection: StorageCollection, key: string): Promise<void>;
+  /** Records of a collection, newest first */
+  list(collection: StorageCollection): Promise<StoredRecord[]>;
+  /** Where a collection is kept, for messages */
+  describe(collection: StorageCollection): string;
+  close(): Promise<void>;
+}
+
+/**
+ * Error thrown for storage that cannot be opened or used
+ */
+export class StorageError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'StorageError';
+  }
+}
+
+/**
+ * Refuse keys that are not names of files, such as ../other
+ */
+function checkKey(collection: StorageCollection, key: string): void {
+  if (!/^[A-Za-z0-9_-][A-Za-z0-9_.-]*$/.test(key)) {
+    throw new StorageError(`Invalid key of ${collection}: ${key}`);
+  }
+}
+
+function newestFirst(a: StoredRecord, b: StoredRecord): number {
+  return (
+    b.modified.getTime() - a.modified.getTime() || b.key.localeCompare(a.key)
+  );
+}
+
+/**
+ * The mcps directory of the user's state directory
+ */
+function stateDir(): string {
+  const base =
+    process.env.XDG_STATE_HOME || join(homedir(), '.local', 'state');
+  return join(base, 'mcps');
+}
+
+/**
+ * Directory of a collection in the user's state directory, or the one its
+ * variable names
+ */
+export function defaultStorageDir(collection: StorageCollection): string {
+  const variable = DIRECTORY_VARIABLES[collection];
+  if (variable && process.env[variable]) {
+    return process.env[variable]!;
+  }
+  return join(stateDir(), collection);
+}
+
+/**
+ * Write a file next to where it goes and rename it over the file, so a
+ * crash while writing leaves the previous contents
+ * The file's directory is created if needed.
+ */
+export async function writeFileAtomic(
+  path: string,
+  data: string
+): Promise<void> {
+  await mkdir(dirname(path), { recursive: true });
+  await writeFile(`${path}.tmp`, data, 'utf-8');
+  await rename(`${path}.tmp`, path);
+}
+
+/**
+ * Keeps each record in a file of the directory of its collection
+ * Lines appended to a journal are written one after another in the order
+ * append() is called, without blocking the run. A crash loses the lines
+ * whose append() had not resolved yet, possibly leaving the last of them
+ * partly written, but never stores lines out of order.
+ */
+export class FileStorage implements Storage {
+  /** Appends in progress by file, made one after another in order */
+  private readonly appending = new Map<string, Promise<void>>();
+
+  /**
+   * @param dirs Directories of collections, instead of those in the
+   *   user's state directory
+   */
+  constructor(
+    private readonly dirs: Partial<Record<StorageCollection, string>> = {}
+  ) {}
+
+  private dir(collection: StorageCollection): string {
+    return this.dirs[collection] ?? defaultStorageDir(collection);
+  }
+
+  private path(collection: StorageCollection, key: string): string {
+    checkKey(collection, key);
+    return join(this.dir(collection), `${key}${EXTENSIONS[collection]}`);
+  }
+
+  async read(
+    collection: StorageCollection,
+    key: string
+  ): Promise<string | undefined> {
+    try {
+      return await readFile(this.path(collection, key), 'utf-8');
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return undefined;
+      }
+      throw error;
+    }
+  }
+
+  async write(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<void> {
+    await writeFileAtomic(this.path(collection, key), data);
+  }
+
+  async create(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<boolean> {
+    const path = this.path(collection, key);
+    await mkdir(this.dir(collection), { recursive: true });
+    try {
+      await writeFile(path, data, { encoding: 'utf-8', flag: 'wx' });
+      return true;
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'EEXIST') {
+        return false;
+      }
+      throw error;
+    }
+  }
+
+  async append(
+    collection: StorageCollection,
+    key: string,
+    line: string
+  ): Promise<void> {
+    const path = this.path(collection, key);
+    const text = line.endsWith('\n') ? line : `${line}\n`;
+    const previous = this.appending.get(path) ?? Promise.resolve();
+    const appended = previous
+      .catch(() => {})
+      .then(async () => {
+        await mkdir(this.dir(collection), { recursive: true });
+        await appendFile(path, text, 'utf-8');
+      });
+    this.appending.set(path, appended);
+    try {
+      await appended;
+    } finally {
+      if (this.appending.get(path) === appended) {
+        this.appending.delete(path);
+      }
+    }
+  }
+
+  async delete(collection: StorageCollection, key: string): Promise<void> {
+    await rm(this.path(collection, key), { force: true });
+  }
+
+  async list(collection: StorageCollection): Promise<StoredRecord[]> {
+    const dir = this.dir(collection);
+    const extension = EXTENSIONS[collection];
+    let names: string[];
+    try {
+      names = await readdir(dir);
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return [];
+      }
+      throw error;
+    }
+    const records = await Promise.all(
+      names
+        .filter(name => name.endsWith(extension))
+        .map(async name => ({
+          key: name.slice(0, -extension.length),
+          modified: (await stat(join(dir, name))).mtime,
+        }))
+    );
+    return records.sort(newestFirst);
+  }
+
+  describe(collection: StorageCollection): string {
+    return this.dir(collection);
+  }
+
+  async close(): Promise<void> {}
+}
+
+/**
+ * The parts of a SQL database the SQL backends use, with rows of records
+ * as the queries below select them
+ */
+interface SqlDatabase {
+  query(
+    sql: string,
+    parameters: unknown[]
+  ): Promise<Array<{ key?: string; data?: string; modified?: unknown }>>;
+  close(): Promise<void>;
+}
+
+/**
+ * Keeps records in a table of a SQL database, connected to on first use
+ * Journals are appended to by concatenating the line in the database, so
+ * instances sharing the database do not overwrite each other's lines.
+ */
+abstract class SqlStorage implements Storage {
+  private database?: Promise<SqlDatabase>;
+
+  /**
+   * @param placeholder Parameter n of a query, such as ? or $1
+   */
+  protected constructor(
+    private readonly placeholder: (n: number) => string
+  ) {}
+
+  protected abstract connect(): Promise<SqlDatabase>;
+
+  abstract describe(collection: StorageCollection): string;
+
+  private async query(sql: string, parameters: unknown[]) {
+    this.database ??= this.connect().then(async database => {
+      await database.query(
+        `CREATE TABLE IF NOT EXISTS ${TABLE} (` +
+          'collection TEXT NOT NULL, key TEXT NOT NULL, data TEXT NOT NULL, ' +
+          'modified BIGINT NOT NULL, PRIMARY KEY (collection, key))',
+        []
+      );
+      return database;
+    });
+    const database = await this.database;
+    const numbered = sql.replace(/\?(\d)/g, (_, n) =>
+      this.placeholder(Number(n))
+    );
+    return database.query(numbered, parameters);
+  }
+
+  async read(
+    collection: StorageCollection,
+    key: string
+  ): Promise<string | undefined> {
+    const [row] = await this.query(
+      `SELECT data FROM ${TABLE} WHERE collection = ?1 AND key = ?2`,
+      [collection, key]
+    );
+    return row?.data;
+  }
+
+  async write(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<void> {
+    checkKey(collection, key);
+    await this.query(
+      `INSERT INTO ${TABLE} (collection, key, data, modified) ` +
+        'VALUES (?1, ?2, ?3, ?4) ON CONFLICT (collection, key) ' +
+        'DO UPDATE SET data = excluded.data, modified = excluded.modified',
+      [collection, key, data, Date.now()]
+    );
+  }
+
+  async create(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<boolean> {
+    checkKey(collection, key);
+    const rows = await this.query(
+      `INSERT INTO ${TABLE} (collection, key, data, modified) ` +
+        'VALUES (?1, ?2, ?3, ?4) ON CONFLICT (collection, key) DO NOTHING ' +
+        'RETURNING key',
+      [collection, key, data, Date.now()]
+    );
+    return rows.length > 0;
+  }
+
+  async append(
+    collection: StorageCollection,
+    key: string,
+    line: string
+  ): Promise<void> {
+    checkKey(collection, key);
+    await this.query(
+      `INSERT INTO ${TABLE} (collection, key, data, modified) ` +
+        'VALUES (?1, ?2, ?3, ?4) ON CONFLICT (collection, key) ' +
+        `DO UPDATE SET data = ${TABLE}.data || excluded.data, ` +
+        'modified = excluded.modified',
+      [collection, key, line.endsWith('\n') ? line : `${line}\n`, Date.now()]
+    );
+  }
+
+  async delete(collection: StorageCollection, key: string): Promise<void> {
+    await this.query(
+      `DELETE FROM ${TABLE} WHERE collection = ?1 AND key = ?2`,
+      [collection, key]
+    );
+  }
+
+  async list(collection: StorageCollection): Promise<StoredRecord[]> {
+    const rows = await this.query(
+      `SELECT key, modified FROM ${TABLE} WHERE collection = ?1`,
+      [collection]
+    );
+    return rows
+      .map(row => ({
+        key: String(row.key),
+        modified: new Date(Number(row.modified)),
+      }))
+      .sort(newestFirst);
+  }
+
+  async close(): Promise<void> {
+    if (this.database) {
+      const database = await this.database;
+      this.database = undefined;
+      await database.close();
+    }
+  }
+}
+
+/**
+ * The parts of node:sqlite the SQLite backend uses
+ */
+interface SqliteDatabase {
+  exec(sql: string): void;
+  prepare(sql: string): {
+    all(...parameters: unknown[]): unknown[];
+    run(...parameters: unknown[]): unknown;
+  };
+  close(): void;
+}
+
+/**
+ * Keeps records in a SQLite database file, using the node:sqlite module of
+ * Node.js 22.5 and later
+ */
+export class SqliteStorage extends SqlStorage {
+  constructor(private readonly path: string) {
+    super(() => '?');
+  }
+
+  protected async connect(): Promise<SqlDatabase> {
+    let sqlite: { DatabaseSync: new (path: string) => SqliteDatabase };
+    try {
+      sqlite = await import('node:sqlite' as string);
+    } catch {
+      throw new StorageError(
+        `SQLite storage needs Node.js 22.5 or later, this is ${process.version}`
+      );
+    }
+    mkdirSync(dirname(this.path), { recursive: true });
+    const db = new sqlite.DatabaseSync(this.path);
+    return {
+      query: async (sql, parameters) => {
+        const statement = db.prepare(sql);
+        if (/^\s*SELECT|\bRETURNING\b/i.test(sql)) {
+          return statement.all(...parameters) as Awaited<
+            ReturnType<SqlDatabase['query']>
+          >;
+        }
+        statement.run(...parameters);
+        return [];
+      },
+      close: async () => db.close(),
+    };
+  }
+
+  describe(collection: StorageCollection): string {
+    return `${collection} of ${this.path}`;
+  }
+}
+
+/**
+ * The parts of a pg client the Postgres backend uses
+ */
+interface PgClient {
+  connect(): Promise<void>;
+  query(
+    sql: string,
+    parameters: unknown[]
+  ): Promise<{ rows: Awaited<ReturnType<SqlDatabase['query']>> }>;
+  end(): Promise<void>;
+}
+
+/**
+ * Keeps records in a Postgres database, using the pg package
+ * One connection is used, whose queries run in the order they are sent.
+ */
+export class PostgresStorage extends SqlStorage {
+  /**
+   * @param url Connection URL; without one, pg reads the PG* environment
+   *   variables
+   */
+  constructor(private readonly url?: string) {
+    super(n => `$${n}`);
+  }
+
+  protected async connect(): Promise<SqlDatabase> {
+    const pg = await importOptional<{
+      Client?: new (config: object) => PgClient;
+      default?: { Client: new (config: object) => PgClient };
+    }>('pg', 'Postgres');
+    const Client = pg.Client ?? pg.default!.Client;
+    const client = new Client(this.url ? { connectionString: this.url } : {});
+    await client.connect();
+    return {
+      query: async (sql, parameters) =>
+        (await client.query(sql, parameters)).rows,
+      close: () => client.end(),
+    };
+  }
+
+  describe(collection: StorageCollection): string {
+    return `${collection} of the Postgres database`;
+  }
+}
+
+/**
+ * The parts of @aws-sdk/client-s3 the S3 backend uses
+ */
+interface S3Module {
+  S3Client: new (config: object) => {
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    send(command: object): Promise<any>;
+    destroy(): void;
+  };
+  GetObjectCommand: new (input: object) => object;
+  PutObjectCommand: new (input: object) => object;
+  DeleteObjectCommand: new (input: object) => object;
+  ListObjectsV2Command: new (input: object) => object;
+}
+
+export interface S3StorageOptions {
+  bucket: string;
+  /** Prefix of the keys of objects, such as "mcps/" */
+  prefix?: string;
+  region?: string;
+  /** Endpoint of an S3-compatible service, such as MinIO */
+  endpoint?: string;
+}
+
+/**
+ * Keeps each record as an object of an S3 bucket, using @aws-sdk/client-s3
+ * and its usual credentials
+ * S3 cannot append to objects, so each line of a journal is an object of
+ * its own under the journal's key, named by when it was appended, and
+ * reading the journal joins them in order. Instances sharing the bucket
+ * append to a journal without overwriting each other's lines; the lines
+ * of different instances are ordered by their clocks.
+ */
+export class S3Storage implements Storage {
+  private client?: Promise<{
+    module: S3Module;
+    client: InstanceType<S3Module['S3Client']>;
+  }>;
+  private readonly appending = new Set<Promise<void>>();
+  /** Names lines appended by this instance apart from other instances' */
+  private readonly instance = randomUUID();
+  private lastAppend = 0;
+  private appended = 0;
+
+  constructor(private readonly options: S3StorageOptions) {}
+
+  private objectKey(collection: StorageCollection, key: string): string {
+    checkKey(collection, key);
+    return `${this.options.prefix ?? ''}${collection}/${key}${EXTENSIONS[collection]}`;
+  }
+
+  /**
+   * Name of the object of a line appended now, which sorts after the
+   * lines this instance appended before
+   */
+  private lineName(): string {
+    this.lastAppend = Math.max(this.lastAppend, Date.now());
+    const time = new Date(this.lastAppend).toISOString();
+    const count = String(this.appended++).padStart(10, '0');
+    return `${time}-${count}-${this.instance}`;
+  }
+
+  protected async send(command: keyof S3Module, input: object) {
+    this.client ??= importOptional<S3Module>('@aws-sdk/client-s3', 'S3').then(
+      module => ({
+        module,
+        client: new module.S3Client({
+          region: this.options.region,
+          ...(this.options.endpoint && {
+            endpoint: this.options.endpoint,
+            forcePathStyle: true,
+          }),
+        }),
+      })
+    );
+    const { module, client } = await this.client;
+    const Command = module[command] as new (input: object) => object;
+    return client.send(new Command({ Bucket: this.options.bucket, ...input }));
+  }
+
+  private async get(objectKey: string): Promise<string | undefined> {
+    try {
+      const object = await this.send('GetObjectCommand', { Key: objectKey });
+      return await object.Body.transformToString('utf-8');
+    } catch (error) {
+      if ((error as Error).name === 'NoSuchKey') {
+        return undefined;
+      }
+      throw error;
+    }
+  }
+
+  /** Objects whose keys start with a prefix, sorted by key */
+  private async objects(
+    prefix: string
+  ): Promise<{ Key: string; LastModified: Date }[]> {
+    const objects: { Key: string; LastModified: Date }[] = [];
+    let token: string | undefined;
+    do {
+      const page = await this.send('ListObjectsV2Command', {
+        Prefix: prefix,
+        ContinuationToken: token,
+      });
+      objects.push(...(page.Contents ?? []));
+      token = page.IsTruncated ? page.NextContinuationToken : undefined;
+    } while (token);
+    return objects.sort((a, b) => (a.Key < b.Key ? -1 : a.Key > b.Key ? 1 : 0));
+  }
+
+  async read(
+    collection: StorageCollection,
+    key: string
+  ): Promise<string | undefined> {
+    const objectKey = this.objectKey(collection, key);
+    if (!JOURNALS.has(collection)) {
+      return this.get(objectKey);
+    }
+    // A journal written whole, followed by the lines appended to it
+    const [whole, lines] = await Promise.all([
+      this.get(objectKey),
+      this.objects(`${objectKey}/`),
+    ]);
+    if (whole === undefined && lines.length === 0) {
+      return undefined;
+    }
+    const texts = await Promise.all(lines.map(line => this.get(line.Key)));
+    return `${whole ?? ''}${texts.join('')}`;
+  }
+
+  async write(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<void> {
+    const objectKey = this.objectKey(collection, key);
+    if (JOURNALS.has(collection)) {
+      await this.deleteLines(objectKey);
+    }
+    await this.send('PutObjectCommand', { Key: objectKey, Body: data });
+  }
+
+  async create(
+    collection: StorageCollection,
+    key: string,
+    data: string
+  ): Promise<boolean> {
+    // A conditional write, which S3 refuses once the object exists
+    try {
+      await this.send('PutObjectCommand', {
+        Key: this.objectKey(collection, key),
+        Body: data,
+        IfNoneMatch: '*',
+      });
+      return true;
+    } catch (error) {
+      const { name } = error as Error;
+      if (
+        name === 'PreconditionFailed' ||
+        name === 'ConditionalRequestConflict'
+      ) {
+        return false;
+      }
+      throw error;
+    }
+  }
+
+  append(
+    collection: StorageCollection,
+    key: string,
+    line: string
+  ): Promise<void> {
+    const objectKey = `${this.objectKey(collection, key)}/${this.lineName()}`;
+    const appended = this.send('PutObjectCommand', {
+      Key: objectKey,
+      Body: line.endsWith('\n') ? line : `${line}\n`,
+    }).then(() => {});
+    this.appending.add(appended);
+    appended.catch(() => {}).finally(() => this.appending.delete(appended));
+    return appended;
+  }
+
+  private async deleteLines(objectKey: string): Promise<void> {
+    for (const line of await this.objects(`${objectKey}/`)) {
+      await this.send('DeleteObjectCommand', { Key: line.Key });
+    }
+  }
+
+  async delete(collection: StorageCollection, key: string): Promise<void> {
+    const objectKey = this.objectKey(collection, key);
+    if (JOURNALS.has(collection)) {
+      await this.deleteLines(objectKey);
+    }
+    await this.send('DeleteObjectCommand', { Key: objectKey });
+  }
+
+  async list(collection: StorageCollection): Promise<StoredRecord[]> {
+    const prefix = `${this.options.prefix ?? ''}${collection}/`;
+    const extension = EXTENSIONS[collection];
+    // Journals are modified when their last line is appended
+    const modified = new Map<string, Date>();
+    for (const object of await this.objects(prefix)) {
+      const [name, ...line] = object.Key.slice(prefix.length).split('/');
+      if (
+        !name.endsWith(extension) ||
+        line.length > (JOURNALS.has(collection) ? 1 : 0)
+      ) {
+        continue;
+      }
+      const key = name.slice(0, -extension.length);
+      const time = new Date(object.LastModified);
+      const known = modified.get(key);
+      if (!known || known < time) {
+        modified.set(key, time);
+      }
+    }
+    return [...modified]
+      .map(([key, time]) => ({ key, modified: time }))
+      .sort(newestFirst);
+  }
+
+  describe(collection: StorageCollection): string {
+    const { bucket, prefix = '' } = this.options;
+    return `s3://${bucket}/${prefix}${collection}`;
+  }
+
+  async close(): Promise<void> {
+    await Promise.allSettled(this.appending);
+    if (this.client) {
+      (await this.client).client.destroy();
+      this.client = undefined;
+    }
+  }
+}
+
+/**
+ * Import a package a backend needs, which is not a dependency of mcps
+ */
+async function importOptional<T>(name: string, backend: string): Promise<T> {
+  try {
+    return (await import(name)) as T;
+  } catch {
+    throw new StorageError(
+      `${backend} storage needs the ${name} package; install it with npm install ${name}`
+    );
+  }
+}
+
+/**
+ * The storage of a backend, with paths resolved against a directory
+ */
+export function createStorage(config: StorageConfig, base: string): Storage {
+  switch (config.backend) {
+    case 'file':
+      return new FileStorage(
+        config.path
+          ? Object.fromEntries(
+              STORAGE_COLLECTIONS.map(collection => [
+                collection,
+                resolve(base, config.path!, collection),
+              ])
+            )
+          : {}
+      );
+    case 'sqlite':
+      return new SqliteStorage(
+        config.path
+          ? resolve(base, config.path)
+          : join(stateDir(), 'mcps.db')
+      );
+    case 's3':
+      return new S3Storage({
+        bucket: config.bucket!,
+        prefix: config.prefix,
+        region: config.region,
+        endpoint: config.endpoint,
+      });
+    case 'postgres':
+      return new PostgresStorage(config.url ?? process.env.MCPS_STORAGE_URL);
+  }
+}
+
+/**
+ * The storage of a project: the backend its config names, or files in the
+ * user's state directory
+ */
+export function projectStorage(loaded: LoadedConfig): Storage {
+  const { storage } = loaded.config;
+  if (!storage) {
+    return new FileStorage();
+  }
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return createStorage(storage, base);
+}
@@ -0,0 +1,208 @@
+// Tool schema lockfile: MCP tool schemas vendored for offline checks, and
+// the package versions servers run at
+import { existsSync, readFileSync } from 'fs';
+import { readFile, writeFile } from 'fs/promises';
+import { dirname, join, resolve } from 'path';
+import type { PinnedPackage, ToolSchema } from '@mcpscript/runtime';
+
+export const TOOLS_LOCK_FILE = 'mcps-tools.lock.json';
+
+/**
+ * Tools of MCP servers by declared server name, written by `mcps lock`
+ * so `mcps check` can validate tool calls without starting the servers
+ */
+export interface ToolsLock {
+  version: 1;
+  servers: Record<string, ToolSchema[]>;
+  /**
+   * Package versions stdio servers run from npm or PyPI are pinned to, by
+   * declared server name
+   */
+  packages?: Record<string, PinnedPackage>;
+}
+
+/**
+ * Find the lockfile for a directory, searching its parents like .mcpsrc
+ */
+export function findToolsLock(startDir: string): string | undefined {
+  let dir = resolve(startDir);
+  for (;;) {
+    const path = join(dir, TOOLS_LOCK_FILE);
+    if (existsSync(path)) {
+      return path;
+    }
+    const parent = dirname(dir);
+    if (parent === dir) {
+      return undefined;
+    }
+    dir = parent;
+  }
+}
+
+/**
+ * Check the shape of a parsed lockfile
+ */
+export function validateToolsLock(value: unknown): ToolsLock {
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('lockfile must be a JSON object');
+  }
+  const { version, servers, packages } = value as Record<string, unknown>;
+  if (version !== 1) {
+    throw new Error(`unsupported lockfile version ${String(version)}`);
+  }
+  if (typeof servers !== 'object' || servers === null) {
+    throw new Error('"servers" must be an object');
+  }
+  for (const [name, tools] of Object.entries(servers)) {
+    if (
+      !Array.isArray(tools) ||
+      !tools.every(
+        tool =>
+          typeof tool?.name === 'string' &&
+          typeof tool.inputSchema === 'object'
+      )
+    ) {
+      throw new Error(
+        `"servers.${name}" must be an array of tools with a name and inputSchema`
+      );
+    }
+  }
+  if (packages !== undefined) {
+    if (typeof packages !== 'object' || packages === null) {
+      throw new Error('"packages" must be an object');
+    }
+    for (const [name, pin] of Object.entries(packages)) {
+      if (
+        !(pin?.ecosystem === 'npm' || pin?.ecosystem === 'PyPI') ||
+        typeof pin.name !== 'string' ||
+        typeof pin.version !== 'string' ||
+        !['string', 'undefined'].includes(typeof pin.integrity)
+      ) {
+        throw new Error(
+          `"packages.${name}" must have an ecosystem (npm or PyPI), a name and a version`
+        );
+      }
+    }
+  }
+  return value as ToolsLock;
+}
+
+export async function readToolsLock(path: string): Promise<ToolsLock> {
+  try {
+    return validateToolsLock(JSON.parse(await readFile(path, 'utf-8')));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new Error(`Invalid ${path}: ${reason}`);
+  }
+}
+
+/**
+ * Read a lockfile without waiting, for the language server, which checks
+ * documents as they change
+ */
+export function readToolsLockSync(path: string): ToolsLock {
+  try {
+    return validateToolsLock(JSON.parse(readFileSync(path, 'utf-8')));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new Error(`Invalid ${path}: ${reason}`);
+  }
+}
+
+/**
+ * The package pins of the lockfile for a script, if any
+ */
+export async function lockedPackages(
+  file: string
+): Promise<Record<string, PinnedPackage> | undefined> {
+  const path = findToolsLock(dirname(resolve(file)));
+  return path ? (await readToolsLock(path)).packages : undefined;
+}
+
+/**
+ * Write a lockfile with servers and tools in name order, so it diffs well
+ */
+export async function writeToolsLock(
+  path: string,
+  lock: ToolsLock
+): Promise<void> {
+  const servers = Object.fromEntries(
+    Object.entries(lock.servers)
+      .sort(([a], [b]) => a.localeCompare(b))
+      .map(([name, tools]) => [
+        name,
+        [...tools].sort((a, b) => a.name.localeCompare(b.name)),
+      ])
+  );
+  const packages =
+    lock.packages &&
+    Object.fromEntries(
+      Object.entries(lock.packages).sort(([a], [b]) => a.localeCompare(b))
+    );
+  const content =
+    JSON.stringify({ version: 1, servers, packages }, null, 2) + '\n';
+  await writeFile(path, content, 'utf-8');
+}
+
+/**
+ * A difference between the locked tools of a server and the tools it
+ * lists now
+ */
+export interface ToolChange {
+  server: string;
+  tool: string;
+  change: 'added' | 'removed' | 'changed';
+}
+
+/**
+ * JSON of a value with object keys in order, so schemas compare equal
+ * however a server orders their properties
+ */
+function sortedJson(value: unknown): string {
+  return JSON.stringify(value, (_, item: unknown) =>
+    item && typeof item === 'object' && !Array.isArray(item)
+      ? Object.fromEntries(
+          Object.entries(item).sort(([a], [b]) => a.localeCompare(b))
+        )
+      : item
+  );
+}
+
+/**
+ * How the tools servers list now differ from the locked ones, by server
+ * and then tool name; servers only in the lockfile are left out, as they
+ * may belong to other scripts
+ */
+export function diffToolSchemas(
+  locked: Record<string, ToolSchema[]>,
+  current: Record<string, ToolSchema[]>
+): ToolChange[] {
+  const changes: ToolChange[] = [];
+  for (const server of Object.keys(current).sort()) {
+    const before = new Map((locked[server] ?? []).map(t => [t.name, t]));
+    const after = new Map(current[server].map(t => [t.name, t]));
+    const names = [...new Set([...before.keys(), ...after.keys()])].sort();
+    for (const tool of names) {
+      const old = before.get(tool);
+      const now = after.get(tool);
+      if (!old) {
+        changes.push({ server, tool, change: 'added' });
+      } else if (!now) {
+        changes.push({ server, tool, change: 'removed' });
+      } else if (sortedJson(old) !== sortedJson(now)) {
+        changes.push({ server, tool, change: 'changed' });
+      }
+    }
+  }
+  return changes;
+}
+
+/**
+ * Tool changes one per line: + added, - removed, ~ changed
+ */
+export function formatToolChanges(changes: ToolChange[]): string {
+  const marks = { added: '+', removed: '-', changed: '~' };
+  return changes
+    .map(({ server, tool, change }) => `${marks[change]} ${server}.${tool}`)
+    .join('\n');
+}
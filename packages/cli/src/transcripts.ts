@@ -0,0 +1,379 @@
+// Transcripts of the agent runs and tool calls of mcps run
+//
+// Each run that starts an agent or calls an MCP tool leaves a transcript in
+// the project's storage, by default the user's state directory, named
+// after the time and the script, e.g. 20261016-142530-report-3fa2. The
+// transcript is only created once the first agent starts or tool is
+// called, so other runs leave nothing behind.
+import { randomBytes } from 'crypto';
+import { existsSync } from 'fs';
+import { readFile } from 'fs/promises';
+import { basename } from 'path';
+import {
+  parseTranscript,
+  TranscriptRecorder,
+  type AgentRunRecord,
+  type RecordedToolCall,
+  type ReplayPoint,
+  type Transcript,
+  type TranscriptEvent,
+} from '@mcpscript/runtime';
+import type { Statement } from '@mcpscript/transpiler';
+import { FileStorage, type Storage } from './storage.js';
+
+const TRANSCRIPT_EXTENSION = '.jsonl';
+
+/**
+ * Id of a new transcript of a script
+ */
+export function transcriptId(file: string, date: Date = new Date()): string {
+  const time = date
+    .toISOString()
+    .replace(/[-:]/g, '')
+    .replace('T', '-')
+    .slice(0, 15);
+  const name = basename(file, '.mcps').replace(/[^A-Za-z0-9_-]/g, '_');
+  return `${time}-${name}-${randomBytes(2).toString('hex')}`;
+}
+
+/**
+ * A transcript being recorded to storage
+ */
+export interface TranscriptFile {
+  id: string;
+  recorder: TranscriptRecorder;
+  /** Whether anything has been recorded, and so the transcript exists */
+  readonly written: boolean;
+  /** Wait until every event recorded so far is stored */
+  flushed(): Promise<void>;
+}
+
+/**
+ * Start the transcript of a run of a script
+ */
+export function createTranscript(
+  file: string,
+  replayOf?: ReplayPoint,
+  storage: Storage = new FileStorage()
+): TranscriptFile {
+  const id = transcriptId(file);
+  let written = false;
+  let pending: Promise<unknown> = Promise.resolve();
+  let failure: unknown;
+  // Events are stored in the order they are recorded, which the storage
+  // keeps
+  const append = (event: TranscriptEvent) => {
+    const stored = storage
+      .append('transcripts', id, JSON.stringify(event))
+      .catch(error => {
+        failure ??= error;
+      });
+    pending = Promise.all([pending, stored]);
+  };
+  const recorder = new TranscriptRecorder(event => {
+    if (!written) {
+      append({
+        type: 'script',
+        file,
+        time: Date.now(),
+        ...(replayOf && { replayOf }),
+      });
+      written = true;
+    }
+    append(event);
+  });
+  return {
+    id,
+    recorder,
+    get written() {
+      return written;
+    },
+    async flushed() {
+      await pending;
+      if (failure !== undefined) {
+        throw failure;
+      }
+    },
+  };
+}
+
+/**
+ * A transcript given as a path, or the id of one in storage, with "last"
+ * for the newest
+ */
+export async function findTranscript(
+  reference: string,
+  storage: Storage = new FileStorage()
+): Promise<string> {
+  if (reference.endsWith(TRANSCRIPT_EXTENSION) && existsSync(reference)) {
+    return reference;
+  }
+  const where = storage.describe('transcripts');
+  if (reference === 'last') {
+    const [newest] = await listTranscripts(storage);
+    if (!newest) {
+      throw new Error(`There are no transcripts in ${where}`);
+    }
+    return newest.id;
+  }
+  if ((await storage.read('transcripts', reference)) === undefined) {
+    throw new Error(`No transcript ${reference} in ${where}`);
+  }
+  return reference;
+}
+
+export interface TranscriptSummary {
+  id: string;
+  modified: Date;
+}
+
+/**
+ * The transcripts in storage, newest first
+ */
+export async function listTranscripts(
+  storage: Storage = new FileStorage()
+): Promise<TranscriptSummary[]> {
+  return (await storage.list('transcripts')).map(({ key, modified }) => ({
+    id: key,
+    modified,
+  }));
+}
+
+/**
+ * Read a transcript found by findTranscript()
+ */
+export async function readTranscript(
+  found: string,
+  storage: Storage = new FileStorage()
+): Promise<Transcript> {
+  const text = found.endsWith(TRANSCRIPT_EXTENSION)
+    ? await readFile(found, 'utf-8')
+    : await storage.read('transcripts', found);
+  return parseTranscript(text ?? '');
+}
+
+/**
+ * Id of a transcript found by findTranscript()
+ */
+export function foundTranscriptId(found: string): string {
+  return basename(found, TRANSCRIPT_EXTENSION);
+}
+
+type Message = AgentRunRecord['messages'][number];
+
+interface ToolCallPart {
+  name?: string;
+  input?: unknown;
+}
+
+interface ToolResultPart {
+  result?: unknown;
+  isError?: boolean;
+}
+
+function messageText(content: Message['content']): string {
+  if (typeof content === 'string') {
+    return content;
+  }
+  return content
+    .map(part => (part.type === 'text' ? part.text : `[${part.type}]`))
+    .join(' ');
+}
+
+function stringify(value: unknown): string {
+  return typeof value === 'string' ? value : JSON.stringify(value);
+}
+
+/**
+ * The lines showing one message, with the tool calls of a reply and the
+ * result of a tool
+ */
+function formatMessage(message: Message, number: number): string[] {
+  const options = (message.options ?? {}) as {
+    toolCall?: ToolCallPart[];
+    toolResult?: ToolResultPart;
+  };
+  const text = messageText(message.content).trim();
+  const lines: string[] = [];
+  if (options.toolResult) {
+    const { result, isError } = options.toolResult;
+    const label = isError ? 'tool error' : 'tool';
+    lines.push(`${number} ${label}: ${stringify(result)}`);
+  } else {
+    lines.push(`${number} ${message.role}:${text && ` ${text}`}`);
+  }
+  for (const call of options.toolCall ?? []) {
+    lines.push(`  -> ${call.name}(${JSON.stringify(call.input ?? {})})`);
+  }
+  return lines;
+}
+
+/**
+ * A transcript as text: each agent run with its numbered messages, sub-agent
+ * runs indented below the runs that delegated to them
+ */
+export function formatTranscript(id: string, transcript: Transcript): string {
+  const lines = [`Transcript ${id}`];
+  if (transcript.file) {
+    lines.push(`Script: ${transcript.file}`);
+  }
+  if (transcript.startedAt !== undefined) {
+    lines.push(`Started: ${new Date(transcript.startedAt).toISOString()}`);
+  }
+  const { replayOf } = transcript;
+  if (replayOf) {
+    lines.push(
+      `Replay of: ${replayOf.transcript} from ${replayOf.run}.${replayOf.message}`
+    );
+  }
+
+  const runs = new Map(transcript.runs.map(record => [record.run, record]));
+  const depth = (record: AgentRunRecord): number => {
+    const parent = record.parent && runs.get(record.parent);
+    return parent ? depth(parent) + 1 : 0;
+  };
+  for (const record of transcript.runs) {
+    const indent = '  '.repeat(depth(record));
+    const parent = record.parent && runs.get(record.parent);
+    const delegated = parent
+      ? ` (delegated by #${parent.run} ${parent.agent})`
+      : '';
+    lines.push('', `${indent}#${record.run} ${record.agent}${delegated}`);
+    if (record.systemPrompt) {
+      lines.push(`${indent}  system: ${record.systemPrompt.trim()}`);
+    }
+    record.messages.forEach((message, index) => {
+      for (const line of formatMessage(message, index + 1)) {
+        lines.push(`${indent}  ${line}`);
+      }
+    });
+    if (record.error !== undefined) {
+      lines.push(`${indent}  failed: ${record.error}`);
+    } else if (record.finishedAt === undefined) {
+      lines.push(`${indent}  (did not finish)`);
+    }
+  }
+  return `${lines.join('\n')}\n`;
+}
+
+/**
+ * The declarations of a program followed by a replay of one of its agents,
+ * which continues the conversation given to the run
+ */
+export function replayProgram(
+  statements: Statement[],
+  agent: string,
+  file: string
+): Statement[] {
+  const declarations = statements.filter(stmt =>
+    [
+      'mcp_declaration',
+      'model_declaration',
+      'agent_declaration',
+      'prompt_declaration',
+      'tool_declaration',
+      'pipeline_declaration',
+    ].includes(stmt.type)
+  );
+  if (
+    !declarations.some(
+      stmt => stmt.type === 'agent_declaration' && stmt.name === agent
+    )
+  ) {
+    throw new Error(`Agent ${agent} is no longer declared in ${file}`);
+  }
+  return [
+    ...declarations,
+    {
+      type: 'expression_statement',
+      expression: {
+        type: 'call',
+        callee: { type: 'identifier', name: '__replayAgent' },
+        arguments: [{ type: 'identifier', name: agent }],
+      },
+    },
+  ];
+}
+
+/**
+ * A transcript's tool calls as read by mock.recorded()
+ */
+export interface Recording {
+  /** Id of the transcript the calls were recorded in */
+  transcript: string;
+  script?: string;
+  calls: RecordedToolCall[];
+}
+
+export function transcriptRecording(
+  id: string,
+  transcript: Transcript
+): Recording {
+  return {
+    transcript: id,
+    ...(transcript.file && { script: transcript.file }),
+    calls: transcript.toolCalls.map(({ time: _time, ...call }) => call),
+  };
+}
+
+/**
+ * Tools of the given servers a recording never called, as "server.tool"
+ */
+export function uncoveredTools(
+  recording: Recording,
+  servers: Record<string, { name: string }[]>
+): string[] {
+  const called = new Set(recording.calls.map(call => call.tool));
+  return Object.entries(servers).flatMap(([server, tools]) =>
+    tools
+      .map(tool => `${server}.${tool.name}`)
+      .filter(tool => !called.has(tool))
+  );
+}
+
+/**
+ * A test file of a script answering its tool calls from a recording, with
+ * a test case checking the recorded calls are made; the case only passes
+ * once it runs the workflow
+ * @param script Path of the script to import, relative to the test
+ * @param recordingFile Path of the recording, relative to the test
+ * @param uncovered Tools the recording never called, listed at the end
+ */
+export function recordedTestFile(
+  recording: Recording,
+  script: string,
+  recordingFile: string,
+  uncovered: string[] = []
+): string {
+  const counts = new Map<string, number>();
+  for (const call of recording.calls) {
+    counts.set(call.tool, (counts.get(call.tool) ?? 0) + 1);
+  }
+  const lines = [
+    `// Tests bootstrapped from transcript ${recording.transcript}, answering`,
+    '// tool calls as they were answered in the recorded run',
+    `import ${JSON.stringify(script)}`,
+    '',
+    `fixture recording = fixtures.load(${JSON.stringify(recordingFile)})`,
+    '',
+    'beforeEach {',
+    '  mock.recorded(recording)',
+    '}',
+    '',
+    'test "makes the tool calls of the recorded run" {',
+    '  // Call the workflow as the recorded run did, then check its result',
+    ...[...counts].map(
+      ([tool, count]) =>
+        `  assertEqual(mock.calls(${JSON.stringify(tool)}).length, ${count})`
+    ),
+    '}',
+  ];
+  if (uncovered.length > 0) {
+    lines.push(
+      '',
+      '// Tools the recorded run never called, which no test covers yet:',
+      ...uncovered.map(tool => `//   ${tool}`)
+    );
+  }
+  return `${lines.join('\n')}\n`;
+}
@@ -0,0 +1,232 @@
+// Authentication of the requests to `mcps serve --http`
+//
+// A service checks each request with a chain of authenticators: the token
+// in MCPS_SERVE_TOKEN, and those the project's .mcpsrc configures under
+// serve.auth, which are API keys, the tokens of an OIDC issuer and client
+// certificates. Each looks for credentials of its kind and names the
+// caller they belong to, or refuses them when they are invalid; the first
+// to recognize a request's credentials decides. Runs the request starts
+// work for that caller, which their MCP tool calls pass on and scripts
+// read with caller(). A service with no authenticators accepts every
+// request.
+import type { IncomingMessage } from 'http';
+import type { ServerOptions } from 'https';
+import type { TLSSocket } from 'tls';
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import type { CallerIdentity } from '@mcpscript/runtime';
+import { HttpError } from '../http.js';
+import type { LoadedConfig } from '../config.js';
+import { isJwt, OidcVerifier } from '../remote/oidc.js';
+import { authenticate } from '../remote/policy.js';
+
+/**
+ * A request's credentials, as an authenticator recognized them
+ */
+export interface Authentication {
+  /** Caller the request's runs work for; none for a shared token */
+  caller?: CallerIdentity;
+}
+
+/**
+ * One kind of credentials a service accepts
+ */
+export interface Authenticator {
+  /**
+   * Who sent a request, or undefined when it has no credentials of this
+   * kind; throws an HttpError when they are invalid
+   */
+  authenticate(request: IncomingMessage): Promise<Authentication | undefined>;
+}
+
+/**
+ * The bearer token of a request's Authorization header
+ */
+function bearerToken(request: IncomingMessage): string | undefined {
+  return /^Bearer (.+)$/.exec(request.headers.authorization ?? '')?.[1];
+}
+
+/**
+ * Accepts a token all clients share, whose runs work for no caller
+ */
+export function sharedTokenAuthenticator(token: string): Authenticator {
+  return {
+    async authenticate(request) {
+      const { authorization } = request.headers;
+      return authenticate(authorization, [{ name: 'serve', token }])
+        ? {}
+        : undefined;
+    },
+  };
+}
+
+/**
+ * An API key and the caller it belongs to
+ */
+export interface ApiKey {
+  subject: string;
+  key: string;
+}
+
+/**
+ * Accepts API keys, as bearer tokens or in a header of their own
+ * @param header Header holding the key, such as "x-api-key"
+ */
+export function apiKeyAuthenticator(
+  keys: ApiKey[],
+  header?: string
+): Authenticator {
+  const tenants = keys.map(({ subject, key }) => ({
+    name: subject,
+    token: key,
+  }));
+  return {
+    async authenticate(request) {
+      const value = header
+        ? request.headers[header.toLowerCase()]
+        : request.headers.authorization;
+      if (typeof value !== 'string') {
+        return undefined;
+      }
+      const owner = authenticate(header ? `Bearer ${value}` : value, tenants);
+      return owner && { caller: { subject: owner.name } };
+    },
+  };
+}
+
+/**
+ * Accepts the JWTs of an OIDC issuer
+ */
+export function oidcAuthenticator(verifier: OidcVerifier): Authenticator {
+  return {
+    async authenticate(request) {
+      const token = bearerToken(request);
+      if (!token || !isJwt(token)) {
+        return undefined;
+      }
+      try {
+        return { caller: await verifier.verify(token) };
+      } catch (error) {
+        throw new HttpError(401, `Invalid token: ${(error as Error).message}`);
+      }
+    },
+  };
+}
+
+/**
+ * Accepts client certificates signed by the service's certificate
+ * authorities, naming the caller by the certificate's common name or its
+ * first subject alternative name
+ */
+export function mtlsAuthenticator(
+  subject: 'cn' | 'san' = 'cn'
+): Authenticator {
+  return {
+    async authenticate(request) {
+      const socket = request.socket as Partial<TLSSocket>;
+      const certificate = socket.getPeerCertificate?.();
+      if (!certificate || Object.keys(certificate).length === 0) {
+        return undefined;
+      }
+      if (!socket.authorized) {
+        throw new HttpError(
+          401,
+          `Client certificate not trusted: ${socket.authorizationError}`
+        );
+      }
+      const common = certificate.subject?.CN;
+      const name =
+        subject === 'cn'
+          ? Array.isArray(common)
+            ? common[0]
+            : common
+          : certificate.subjectaltname?.split(', ')[0].replace(/^\w+:/, '');
+      if (!name) {
+        const missing =
+          subject === 'cn' ? 'common name' : 'subject alternative name';
+        throw new HttpError(401, `Client certificate has no ${missing}`);
+      }
+      return {
+        caller: {
+          subject: name,
+          claims: { fingerprint256: certificate.fingerprint256 },
+        },
+      };
+    },
+  };
+}
+
+/**
+ * The caller of a request, from the first authenticator recognizing its
+ * credentials; throws a 401 HttpError when none does
+ */
+export async function authenticateRequest(
+  request: IncomingMessage,
+  authenticators: Authenticator[]
+): Promise<CallerIdentity | undefined> {
+  if (authenticators.length === 0) {
+    return undefined;
+  }
+  for (const authenticator of authenticators) {
+    const authentication = await authenticator.authenticate(request);
+    if (authentication) {
+      return authentication.caller;
+    }
+  }
+  throw new HttpError(401, 'Missing or invalid credentials');
+}
+
+/**
+ * The authenticators a project's .mcpsrc configures for its service, with
+ * API keys read from the environment
+ */
+export function configuredAuthenticators(
+  loaded: LoadedConfig,
+  env: NodeJS.ProcessEnv = process.env
+): Authenticator[] {
+  return (loaded.config.serve?.auth ?? []).map((auth, index) => {
+    switch (auth.type) {
+      case 'apiKey':
+        return apiKeyAuthenticator(
+          auth.keys.map(({ subject, env: name }) => {
+            const key = env[name];
+            if (!key) {
+              throw new Error(
+                `serve.auth[${index}] reads the key of ${subject} from $${name}, which is not set`
+              );
+            }
+            return { subject, key };
+          }),
+          auth.header
+        );
+      case 'oidc':
+        return oidcAuthenticator(new OidcVerifier(auth));
+      case 'mtls':
+        return mtlsAuthenticator(auth.subject);
+    }
+  });
+}
+
+/**
+ * The HTTPS options of a project's service, with its PEM files read
+ * relative to the config file; undefined when it serves HTTP
+ */
+export async function configuredTls(
+  loaded: LoadedConfig
+): Promise<ServerOptions | undefined> {
+  const { tls, auth = [] } = loaded.config.serve ?? {};
+  if (!tls) {
+    return undefined;
+  }
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  const read = (file: string) => readFile(resolve(base, file), 'utf-8');
+  return {
+    cert: await read(tls.cert),
+    key: await read(tls.key),
+    ...(tls.ca !== undefined && { ca: await read(tls.ca) }),
+    // Client certificates are asked for when configured, and those that
+    // are not trusted refused with the other invalid credentials
+    requestCert: auth.some(entry => entry.type === 'mtls'),
+    rejectUnauthorized: false,
+  };
+}
@@ -0,0 +1,142 @@
+// Results of the read-only triggers of `mcps serve --http`
+//
+// A trigger the project's .mcpsrc marks read-only only reads what it
+// reports, so running it again soon after gives the same result. Its
+// result is served at TRIGGERS_PATH/<name>/result: the first request runs
+// it, and its finished run is kept for maxAge seconds for the requests
+// after, as the result of the trigger's inputs. These are the trigger and
+// the caller the run works for, since runs for different callers may see
+// different things. Requests arriving while it runs wait for the same
+// run, and failed runs are not kept. Results carry an ETag that only
+// depends on what the run reported, so clients polling a result are
+// answered 304 Not Modified while it stays the same, across runs.
+import { createHash } from 'crypto';
+import type { CallerIdentity } from '@mcpscript/runtime';
+import type { TriggerRunStatus } from './protocol.js';
+
+/** How long the result of a read-only trigger is kept, in seconds */
+export const DEFAULT_MAX_AGE = 60;
+
+export interface CachedResult {
+  /** The finished run whose result it is */
+  status: TriggerRunStatus;
+  etag: string;
+  /** When the result stops being fresh, in ms since the epoch */
+  expires: number;
+}
+
+/**
+ * A value with the keys of its objects sorted, so that equal values have
+ * the same JSON
+ */
+function normalize(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map(normalize);
+  }
+  if (typeof value === 'object' && value !== null) {
+    return Object.fromEntries(
+      Object.keys(value)
+        .sort()
+        .map(key => [key, normalize((value as Record<string, unknown>)[key])])
+    );
+  }
+  return value;
+}
+
+/**
+ * Key of the result of a trigger run for a caller
+ */
+export function cacheKey(trigger: string, caller?: CallerIdentity): string {
+  return JSON.stringify(normalize({ trigger, caller: caller ?? null }));
+}
+
+/**
+ * ETag of a run's result: what it printed and how it ended, without the
+ * run's id and times
+ */
+export function resultEtag(status: TriggerRunStatus): string {
+  const { state, output, error } = status;
+  const hash = createHash('sha256')
+    .update(JSON.stringify([state, output, error ?? null]))
+    .digest('base64url');
+  return `"${hash.slice(0, 27)}"`;
+}
+
+/**
+ * Whether an If-None-Match header names an ETag
+ */
+export function matchesEtag(
+  header: string | undefined,
+  etag: string
+): boolean {
+  if (header === undefined) {
+    return false;
+  }
+  return (
+    header.trim() === '*' ||
+    header.split(',').some(tag => tag.trim().replace(/^W\//, '') === etag)
+  );
+}
+
+interface Entry {
+  result?: Promise<CachedResult>;
+  /** Infinity while the run is in progress */
+  expires: number;
+}
+
+/**
+ * The fresh results of read-only triggers, by key
+ */
+export class ResultCache {
+  private readonly entries = new Map<string, Entry>();
+
+  /**
+   * @param now Current time; Date.now unless testing
+   */
+  constructor(private readonly now: () => number = Date.now) {}
+
+  /**
+   * The fresh result for a key, or the result of a new run, which is kept
+   * for maxAge seconds when it succeeds
+   */
+  result(
+    key: string,
+    maxAge: number,
+    run: () => Promise<TriggerRunStatus>
+  ): Promise<CachedResult> {
+    const now = this.now();
+    for (const [stale, entry] of this.entries) {
+      if (entry.expires <= now) {
+        this.entries.delete(stale);
+      }
+    }
+    const cached = this.entries.get(key)?.result;
+    if (cached) {
+      return cached;
+    }
+
+    const entry: Entry = { expires: Infinity };
+    const forget = () => {
+      if (this.entries.get(key) === entry) {
+        this.entries.delete(key);
+      }
+    };
+    entry.result = run().then(
+      status => {
+        const expires = this.now() + maxAge * 1000;
+        if (status.state === 'succeeded') {
+          entry.expires = expires;
+        } else {
+          forget();
+        }
+        return { status, etag: resultEtag(status), expires };
+      },
+      error => {
+        forget();
+        throw error;
+      }
+    );
+    this.entries.set(key, entry);
+    return entry.result;
+  }
+}
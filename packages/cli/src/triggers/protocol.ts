@@ -0,0 +1,91 @@
+// Wire protocol of the trigger service started by `mcps serve --http`
+//
+// Served scripts are loaded once and keep their MCP servers running. Their
+// triggers fire on schedule, or when a client POSTs to TRIGGERS_PATH/<name>,
+// which answers with the new run's status. Events are delivered to the
+// scripts' handlers when a client POSTs them to EVENTS_PATH/<name>, with
+// the payload as JSON body, which answers with the runs of the handlers.
+// Triggers are listed at TRIGGERS_PATH and event handlers at EVENTS_PATH,
+// and the runs of the last while are listed at RUNS_PATH and can be looked
+// up at RUNS_PATH/<id>. Their output and structured log are streamed from
+// RUNS_PATH/<id>/logs as the newline-delimited JSON events of mcpsd's logs
+// stream (see remote/protocol.ts). Read-only triggers also answer GET
+// TRIGGERS_PATH/<name>/result with a finished run, which the service and
+// clients keep for a while (see cache.ts). When the service has a token,
+// or configures credentials, every request must present one of them (see
+// auth.ts).
+
+export const TRIGGERS_PATH = '/v1/triggers';
+export const EVENTS_PATH = '/v1/events';
+export const RUNS_PATH = '/v1/runs';
+export const HEALTH_PATH = '/v1/health';
+export const DEFAULT_SERVE_PORT = 7339;
+
+/**
+ * What fired a run: its trigger's schedule, a webhook, or for the runs of
+ * event handlers, an event taken from the queue or emitted by a script
+ */
+export type TriggerCause = 'schedule' | 'webhook' | 'queue' | 'emit';
+
+/** Runs of one script take turns, so a fired run may wait in the queue */
+export type TriggerRunState = 'queued' | 'running' | 'succeeded' | 'failed';
+
+/**
+ * A run of a trigger's action or an event handler as reported by the
+ * status endpoints
+ */
+export interface TriggerRunStatus {
+  id: string;
+  /** Trigger fired, for the runs of triggers */
+  trigger?: string;
+  /** Event handled, for the runs of event handlers */
+  event?: string;
+  /** Script that declares the trigger or handler */
+  file: string;
+  cause: TriggerCause;
+  /** Subject of the caller the run works for */
+  caller?: string;
+  state: TriggerRunState;
+  /** ISO timestamps */
+  firedAt: string;
+  startedAt?: string;
+  finishedAt?: string;
+  /** What the run printed, and the messages of its agents, in order */
+  output: string[];
+  /** Why a failed run failed */
+  error?: string;
+}
+
+/**
+ * A trigger as reported by TRIGGERS_PATH
+ */
+export interface TriggerStatus {
+  name: string;
+  file: string;
+  /** Cron expression; triggers without one only fire through webhooks */
+  schedule?: string;
+  /** Whether its result is served at TRIGGERS_PATH/<name>/result */
+  readOnly?: boolean;
+  /** ISO timestamp of the next scheduled run */
+  nextRun?: string;
+  /** The latest run, which may still be in progress */
+  lastRun?: TriggerRunStatus;
+}
+
+/**
+ * An event handler as reported by EVENTS_PATH
+ */
+export interface EventHandlerStatus {
+  event: string;
+  /** Script that declares the handler */
+  file: string;
+}
+
+/**
+ * Answer to an event posted to EVENTS_PATH/<name>
+ */
+export interface EventDelivery {
+  event: string;
+  /** Runs of the handlers of the event, one for each */
+  runs: TriggerRunStatus[];
+}
@@ -0,0 +1,128 @@
+// Queues events are taken from by `mcps serve --http`
+//
+// Other systems can hand events to the served scripts through a queue
+// rather than the service's webhook. The queue built in is a directory:
+// each event is a JSON file of the form { "event": "<name>", "payload":
+// ... } put in it, which the service takes in name order and deletes once
+// the event is delivered. Producers should write the file under another
+// name, such as one ending in .tmp, and rename it, so a half-written file
+// is never taken.
+import { readdir, readFile, rename, unlink } from 'fs/promises';
+import { join } from 'path';
+
+/** How often the directory queue looks for new events */
+export const QUEUE_POLL_INTERVAL = 1000;
+
+/**
+ * An event taken from a queue
+ */
+export interface QueuedEvent {
+  event: string;
+  payload: unknown;
+}
+
+/**
+ * A queue backend the service takes events from
+ */
+export interface EventQueue {
+  /**
+   * Start taking events, handing each one to deliver; an event leaves the
+   * queue once it is delivered
+   */
+  start(deliver: (event: QueuedEvent) => void): void;
+  /** Stop taking events, waiting for the one being taken */
+  stop(): Promise<void>;
+}
+
+/**
+ * Read a queued event, as put in the queue by a producer
+ */
+export function parseQueuedEvent(content: string): QueuedEvent {
+  const value: unknown = JSON.parse(content);
+  if (!value || typeof value !== 'object' || Array.isArray(value)) {
+    throw new Error('an event must be an object');
+  }
+  const { event, payload } = value as Record<string, unknown>;
+  if (typeof event !== 'string' || event === '') {
+    throw new Error('an event needs a name');
+  }
+  return { event, payload: payload ?? null };
+}
+
+/**
+ * Takes events from the JSON files put in a directory
+ * Files that are not events are renamed with a .failed suffix, so they are
+ * kept for inspection but not taken again
+ */
+export class DirectoryEventQueue implements EventQueue {
+  private timer?: NodeJS.Timeout;
+  private polling: Promise<void> = Promise.resolve();
+  private stopped = false;
+
+  constructor(
+    private readonly dir: string,
+    private readonly options: {
+      interval?: number;
+      /** Receives why a file was not an event or could not be read */
+      warn?: (message: string) => void;
+    } = {}
+  ) {}
+
+  start(deliver: (event: QueuedEvent) => void): void {
+    const poll = () => {
+      this.polling = this.take(deliver)
+        .catch(error =>
+          this.options.warn?.(
+            `${this.dir}: ${error instanceof Error ? error.message : String(error)}`
+          )
+        )
+        .finally(() => {
+          if (!this.stopped) {
+            this.timer = setTimeout(
+              poll,
+              this.options.interval ?? QUEUE_POLL_INTERVAL
+            );
+          }
+        });
+    };
+    poll();
+  }
+
+  async stop(): Promise<void> {
+    this.stopped = true;
+    clearTimeout(this.timer);
+    await this.polling;
+  }
+
+  /**
+   * Take the events in the directory, oldest name first
+   */
+  async take(deliver: (event: QueuedEvent) => void): Promise<void> {
+    let names: string[];
+    try {
+      names = await readdir(this.dir);
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return;
+      }
+      throw error;
+    }
+    for (const name of names.filter(n => n.endsWith('.json')).sort()) {
+      if (this.stopped) {
+        return;
+      }
+      const path = join(this.dir, name);
+      let event: QueuedEvent;
+      try {
+        event = parseQueuedEvent(await readFile(path, 'utf-8'));
+      } catch (error) {
+        const message = error instanceof Error ? error.message : String(error);
+        this.options.warn?.(`${path}: ${message}`);
+        await rename(path, `${path}.failed`);
+        continue;
+      }
+      deliver(event);
+      await unlink(path);
+    }
+  }
+}
@@ -0,0 +1,994 @@
+// Trigger service of `mcps serve --http`
+//
+// Each served script is run once when the service starts, in this process,
+// which registers its triggers and event handlers and leaves its MCP
+// servers running. The triggers then fire on their schedules or through
+// webhooks, evaluating their actions in the script's context, and the
+// handlers run for the events posted to the service, taken from its queue
+// or emitted by the other scripts. Runs of one script take turns, so what
+// a run prints, and its log, is not mixed up with that of another. With
+// storage, finished runs are kept as the service's history, and what
+// started each run, for which caller, and how it ended is written to an
+// audit log by day.
+// Services sharing storage claim each scheduled run there before firing
+// it, so a trigger fires once at each time however many instances serve it.
+import { EventEmitter } from 'events';
+import {
+  createServer,
+  type IncomingMessage,
+  type Server,
+  type ServerResponse,
+} from 'http';
+import {
+  createServer as createHttpsServer,
+  type ServerOptions as HttpsServerOptions,
+} from 'https';
+import { randomUUID } from 'crypto';
+import { hostname } from 'os';
+import { readFile } from 'fs/promises';
+import { dirname, resolve } from 'path';
+import {
+  loadProgram,
+  createFileLoader,
+  generateCode,
+  checkTypes,
+  nextScheduledTime,
+  parseSchedule,
+  type Schedule,
+} from '@mcpscript/transpiler';
+import {
+  currentCaller,
+  executeInVM,
+  isSpilled,
+  MCPServerManager,
+  noRedaction,
+  Redactor,
+  runAsCaller,
+  RunLog,
+  RunOutcome,
+  type AppMessage,
+  type CallerIdentity,
+  type LogEntry,
+  type ScriptEventHandler,
+  type ScriptTrigger,
+  type Tracer,
+} from '@mcpscript/runtime';
+import {
+  loadProjectConfig,
+  moduleSearchPaths,
+  secretProviders,
+  spillOptions,
+  type ServeTriggerConfig,
+} from '../config.js';
+import { enforceSigningPolicy } from '../signing.js';
+import { headlessQuestions } from '../approvals.js';
+import { outcomeFailure, type ExitCodesConfig } from '../exit-codes.js';
+import { HttpError, readBody, sendError, sendJson } from '../http.js';
+import { offlineMode } from '../offline.js';
+import { lockedPackages } from '../tools-lock.js';
+import { streamLog, type StreamedRun } from '../remote/daemon.js';
+import type { RunEvent } from '../remote/protocol.js';
+import { MAX_RUN_EVENTS } from '../remote/runs.js';
+import { formatScriptError } from '../ui/script-error.js';
+import type { Storage } from '../storage.js';
+import { authenticateRequest, type Authenticator } from './auth.js';
+import {
+  cacheKey,
+  DEFAULT_MAX_AGE,
+  matchesEtag,
+  ResultCache,
+  type CachedResult,
+} from './cache.js';
+import {
+  EVENTS_PATH,
+  HEALTH_PATH,
+  RUNS_PATH,
+  TRIGGERS_PATH,
+  type EventHandlerStatus,
+  type TriggerCause,
+  type TriggerRunState,
+  type TriggerRunStatus,
+  type TriggerStatus,
+} from './protocol.js';
+
+/** Finished runs kept for status queries before the oldest are forgotten */
+export const MAX_TRIGGER_RUNS = 100;
+/** Stored runs listed as the history of the service */
+export const MAX_HISTORY_RUNS = 1000;
+/** Lines of output kept per run */
+export const MAX_RUN_OUTPUT = 1000;
+/** Largest payload of an event posted to the service */
+export const MAX_EVENT_SIZE = 1024 * 1024;
+/** How long claims of scheduled runs are kept in storage */
+export const SCHEDULE_CLAIM_RETENTION = 24 * 60 * 60 * 1000;
+/** How often a service removes claims older than that */
+const SCHEDULE_SWEEP_INTERVAL = 60 * 60 * 1000;
+/** Longest delay setTimeout accepts; later runs are waited for in steps */
+const MAX_TIMER_DELAY = 2 ** 31 - 1;
+
+/**
+ * A script whose triggers and event handlers are served
+ */
+export interface TriggerScript {
+  /** Absolute path of the script */
+  file: string;
+  triggers: ScriptTrigger[];
+  handlers: ScriptEventHandler[];
+  /**
+   * Receives what the script prints while one of its triggers or handlers
+   * runs
+   */
+  output?: (line: string) => void;
+  /** Receives the events the script emits, once its own handlers ran */
+  emitted?: (event: string, payload: unknown) => void;
+  /**
+   * Receives the log entries of the script's current run: the statements
+   * it executes, its tool calls and agent steps
+   */
+  log?: (entry: LogEntry) => void;
+  /** Traces the script's runs, which logs their tool calls and agent steps */
+  tracer?: Tracer;
+  /** Issues of the script's current run, such as tool error results */
+  outcome?: RunOutcome;
+  /** Exit codes of the script's project, which fail runs with issues */
+  exitCodes?: ExitCodesConfig;
+  /** Masks sensitive data in the stored runs and audit entries */
+  redactor?: Redactor;
+  /** Stop the script's MCP servers */
+  close(): Promise<void>;
+}
+
+/**
+ * Whether a script declares triggers or event handlers, without running it
+ */
+async function declaresTriggers(file: string): Promise<boolean> {
+  const source = await readFile(file, 'utf-8');
+  const loaded = await loadProjectConfig(dirname(file));
+  const loader = createFileLoader({ paths: moduleSearchPaths(loaded) });
+  return loadProgram(file, source, loader).some(
+    stmt =>
+      stmt.type === 'trigger_declaration' || stmt.type === 'event_handler'
+  );
+}
+
+/**
+ * Run a script to register its triggers and event handlers, leaving its
+ * servers running
+ */
+export async function loadTriggerScript(
+  path: string,
+  options: { timeout?: number } = {}
+): Promise<TriggerScript> {
+  const file = resolve(path);
+  const source = await readFile(file, 'utf-8');
+  const loaded = await loadProjectConfig(dirname(file));
+  const { config } = loaded;
+  const loader = enforceSigningPolicy(
+    loaded,
+    file,
+    source,
+    createFileLoader({ paths: moduleSearchPaths(loaded) })
+  );
+  const ast = loadProgram(file, source, loader);
+  checkTypes(ast);
+  // Debug hooks report the statements of runs to their log
+  const jsCode = generateCode(ast, {
+    sourcePositions: true,
+    debugHooks: true,
+  });
+
+  const serverManager = new MCPServerManager();
+  const runLog = new RunLog(entry => script.log?.(entry), { source });
+  const script: TriggerScript = {
+    file,
+    triggers: [],
+    handlers: [],
+    outcome: new RunOutcome(),
+    exitCodes: config.exitCodes,
+    redactor: new Redactor(config.redaction),
+    tracer: runLog.tracer,
+    close: () => serverManager.closeAll(),
+  };
+  // Output of the initial run goes to stderr, like the service's own
+  const print = ({ title, body }: AppMessage) => {
+    const line = title ? `${title}: ${body}` : body;
+    if (script.output) {
+      script.output(line);
+    } else {
+      console.error(line);
+    }
+  };
+
+  await executeInVM(jsCode, {
+    timeout: options.timeout,
+    addMessage: print,
+    userInput: () =>
+      Promise.reject(new Error('input() is not available in triggered runs')),
+    ask: headlessQuestions(config.approvals, file, 'triggered runs'),
+    serverManager,
+    debugger: runLog.debugger,
+    tracer: runLog.tracer,
+    sourceFile: file,
+    source,
+    redaction: config.redaction,
+    secretProviders: secretProviders(loaded),
+    project: config.project,
+    triggers: script.triggers,
+    eventHandlers: script.handlers,
+    publish: (event, payload) => script.emitted?.(event, payload),
+    outcome: script.outcome,
+    locale: config.locale,
+    spill: spillOptions(loaded),
+    offline: offlineMode(),
+    packages: await lockedPackages(file),
+  });
+  return script;
+}
+
+/**
+ * Load the scripts of the given files that declare triggers or event
+ * handlers. Trigger names must be distinct across scripts, since webhooks
+ * address triggers by name
+ */
+export async function loadTriggerScripts(
+  files: string[],
+  options: { timeout?: number } = {}
+): Promise<TriggerScript[]> {
+  const scripts: TriggerScript[] = [];
+  const owners = new Map<string, string>();
+  try {
+    for (const path of files) {
+      const file = resolve(path);
+      if (!(await declaresTriggers(file))) {
+        continue;
+      }
+      const script = await loadTriggerScript(file, options);
+      scripts.push(script);
+      for (const { name } of script.triggers) {
+        const owner = owners.get(name);
+        if (owner) {
+          throw new Error(`${owner} and ${file} both declare trigger ${name}`);
+        }
+        owners.set(name, file);
+      }
+    }
+  } catch (error) {
+    await Promise.all(scripts.map(script => script.close()));
+    throw error;
+  }
+  return scripts;
+}
+
+/**
+ * One run of a trigger's action or an event handler
+ * Emits "event" for each recorded event and "finish" once it has finished,
+ * so its log can be followed like the runs of mcpsd
+ */
+class TriggerRun extends EventEmitter {
+  readonly id = randomUUID();
+  readonly firedAt = new Date();
+  startedAt?: Date;
+  finishedAt?: Date;
+  state: TriggerRunState = 'queued';
+  readonly output: string[] = [];
+  error?: string;
+  /** Output and log of the run, oldest first (trimmed to MAX_RUN_EVENTS) */
+  readonly events: RunEvent[] = [];
+
+  constructor(
+    readonly script: TriggerScript,
+    readonly cause: TriggerCause,
+    /** Trigger fired or event handled */
+    readonly source: { trigger: string } | { event: string },
+    /** Caller the run works for */
+    readonly caller?: CallerIdentity
+  ) {
+    super();
+  }
+
+  get finished(): boolean {
+    return this.finishedAt !== undefined;
+  }
+
+  write(line: string): void {
+    this.output.push(line);
+    if (this.output.length > MAX_RUN_OUTPUT) {
+      this.output.shift();
+    }
+    this.record({ type: 'output', stream: 'stdout', text: `${line}\n` });
+  }
+
+  record(event: RunEvent): void {
+    this.events.push(event);
+    if (this.events.length > MAX_RUN_EVENTS) {
+      this.events.shift();
+    }
+    this.emit('event', event);
+  }
+
+  finish(state: 'succeeded' | 'failed', error?: string): void {
+    this.state = state;
+    this.error = error;
+    this.finishedAt = new Date();
+    if (error) {
+      this.record({ type: 'error', message: error });
+    }
+    this.record({ type: 'exit', code: state === 'succeeded' ? 0 : 1 });
+    this.emit('finish');
+  }
+
+  status(): TriggerRunStatus {
+    return {
+      id: this.id,
+      ...this.source,
+      file: this.script.file,
+      cause: this.cause,
+      caller: this.caller?.subject,
+      state: this.state,
+      firedAt: this.firedAt.toISOString(),
+      startedAt: this.startedAt?.toISOString(),
+      finishedAt: this.finishedAt?.toISOString(),
+      output: [...this.output],
+      error: this.error,
+    };
+  }
+}
+
+interface ServedTrigger {
+  trigger: ScriptTrigger;
+  script: TriggerScript;
+  schedule?: Schedule;
+  nextRun?: Date;
+  timer?: NodeJS.Timeout;
+  lastRun?: TriggerRun;
+}
+
+export interface TriggerServiceOptions {
+  /** Execution timeout of each run in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /**
+   * Where finished runs and the audit log are kept, and scheduled runs are
+   * claimed by the instances sharing it
+   */
+  storage?: Storage;
+  /** Told about runs and audit entries that could not be stored */
+  warn?: (message: string) => void;
+  /** Settings of the triggers, such as which are read-only, by name */
+  triggers?: Record<string, ServeTriggerConfig>;
+}
+
+/**
+ * Fires the triggers of scripts, delivers events to their handlers and
+ * keeps the history of their runs
+ */
+export class TriggerService {
+  private readonly triggers = new Map<string, ServedTrigger>();
+  private readonly runs = new Map<string, TriggerRun>();
+  /** The last run of each script, which later runs wait for */
+  private readonly turns = new Map<TriggerScript, Promise<void>>();
+  /** Runs, audit entries and claims of scheduled runs being stored */
+  private readonly storing = new Set<Promise<void>>();
+  /** Names this instance in its claims of scheduled runs */
+  private readonly instance = `${hostname()}:${process.pid}`;
+  private sweptAt = 0;
+  private stopped = false;
+  /** Fresh results of the read-only triggers */
+  private readonly results = new ResultCache();
+
+  constructor(
+    private readonly scripts: TriggerScript[],
+    private readonly options: TriggerServiceOptions = {}
+  ) {
+    for (const script of scripts) {
+      // Events a script emits go on to the handlers of the others, for
+      // the caller of the run emitting them
+      script.emitted = (event, payload) => {
+        this.deliver(event, payload, 'emit', script, currentCaller());
+      };
+      for (const trigger of script.triggers) {
+        this.triggers.set(trigger.name, {
+          trigger,
+          script,
+          schedule: trigger.schedule
+            ? parseSchedule(trigger.schedule)
+            : undefined,
+        });
+      }
+    }
+    for (const name of Object.keys(options.triggers ?? {})) {
+      if (!this.triggers.has(name)) {
+        throw new Error(
+          `.mcpsrc has settings for the trigger ${name}, which no served script declares`
+        );
+      }
+    }
+  }
+
+  /**
+   * Start firing scheduled triggers
+   */
+  start(): void {
+    for (const served of this.triggers.values()) {
+      this.schedule(served);
+    }
+  }
+
+  /**
+   * Stop scheduling, wait for runs in progress and stop the scripts' servers
+   */
+  async stop(): Promise<void> {
+    this.stopped = true;
+    for (const served of this.triggers.values()) {
+      clearTimeout(served.timer);
+    }
+    await Promise.all(this.turns.values());
+    await Promise.all(this.storing);
+    await Promise.all(this.scripts.map(script => script.close()));
+  }
+
+  /**
+   * Fire a trigger now; the run is returned without waiting for it
+   * @param caller Caller the run works for
+   */
+  fire(
+    name: string,
+    cause: TriggerCause,
+    caller?: CallerIdentity
+  ): TriggerRunStatus {
+    const served = this.triggers.get(name);
+    if (!served) {
+      throw new HttpError(404, `Unknown trigger: ${name}`);
+    }
+    return this.submit(served, cause, caller).status();
+  }
+
+  /**
+   * The result of a read-only trigger: its last run for the caller while
+   * that is fresh, or a new run, which is waited for
+   */
+  result(name: string, caller?: CallerIdentity): Promise<CachedResult> {
+    const served = this.triggers.get(name);
+    if (!served) {
+      throw new HttpError(404, `Unknown trigger: ${name}`);
+    }
+    const { readOnly, maxAge = DEFAULT_MAX_AGE } =
+      this.options.triggers?.[name] ?? {};
+    if (!readOnly) {
+      throw new HttpError(
+        404,
+        `Trigger ${name} is not read-only, so its result is not served`
+      );
+    }
+    return this.results.result(cacheKey(name, caller), maxAge, async () => {
+      const run = this.submit(served, 'webhook', caller);
+      await this.turns.get(run.script);
+      return run.status();
+    });
+  }
+
+  /**
+   * Run the handlers of an event in the scripts declaring them; the runs
+   * are returned without waiting for them
+   * @param from Script that emitted the event, whose handlers already ran
+   * @param caller Caller the runs work for
+   */
+  deliver(
+    event: string,
+    payload: unknown,
+    cause: TriggerCause,
+    from?: TriggerScript,
+    caller?: CallerIdentity
+  ): TriggerRunStatus[] {
+    if (this.stopped) {
+      return [];
+    }
+    const runs: TriggerRun[] = [];
+    for (const script of this.scripts) {
+      if (script === from) {
+        continue;
+      }
+      for (const handler of script.handlers) {
+        if (handler.event === event) {
+          const run = new TriggerRun(script, cause, { event }, caller);
+          runs.push(this.enqueue(run, () => handler.handle(payload)));
+        }
+      }
+    }
+    return runs.map(run => run.status());
+  }
+
+  /**
+   * Wait until a run and the runs of its script before it have finished
+   */
+  async settled(id: string): Promise<TriggerRunStatus | undefined> {
+    const run = this.runs.get(id);
+    if (run) {
+      await this.turns.get(run.script);
+    }
+    return run?.status();
+  }
+
+  listTriggers(): TriggerStatus[] {
+    return [...this.triggers.keys()].map(name => this.triggerStatus(name)!);
+  }
+
+  triggerStatus(name: string): TriggerStatus | undefined {
+    const served = this.triggers.get(name);
+    if (!served) {
+      return undefined;
+    }
+    return {
+      name,
+      file: served.script.file,
+      schedule: served.trigger.schedule ?? undefined,
+      ...(this.options.triggers?.[name]?.readOnly && { readOnly: true }),
+      nextRun: served.nextRun?.toISOString(),
+      lastRun: served.lastRun?.status(),
+    };
+  }
+
+  /**
+   * The event handlers of the scripts, in order
+   */
+  listEvents(): EventHandlerStatus[] {
+    return this.scripts.flatMap(script =>
+      script.handlers.map(({ event }) => ({ event, file: script.file }))
+    );
+  }
+
+  /**
+   * Known runs, oldest first
+   */
+  listRuns(): TriggerRunStatus[] {
+    return [...this.runs.values()].map(run => run.status());
+  }
+
+  getRun(id: string): TriggerRunStatus | undefined {
+    return this.runs.get(id)?.status();
+  }
+
+  /**
+   * Known and stored runs, oldest first
+   */
+  async runHistory(): Promise<TriggerRunStatus[]> {
+    const { storage } = this.options;
+    const known = this.listRuns();
+    if (!storage) {
+      return known;
+    }
+    const ids = new Set(known.map(run => run.id));
+    const records = (await storage.list('runs'))
+      .filter(record => !ids.has(record.key))
+      .slice(0, MAX_HISTORY_RUNS - known.length);
+    const stored = await Promise.all(
+      records.map(record => this.storedRun(record.key))
+    );
+    return [
+      ...stored.filter(run => run !== undefined).reverse(),
+      ...known,
+    ];
+  }
+
+  /**
+   * A known run, or a finished one of the stored history
+   */
+  async findRun(id: string): Promise<TriggerRunStatus | undefined> {
+    return this.getRun(id) ?? (await this.storedRun(id));
+  }
+
+  private async storedRun(id: string): Promise<TriggerRunStatus | undefined> {
+    if (!this.options.storage || !/^[A-Za-z0-9-]+$/.test(id)) {
+      return undefined;
+    }
+    const data = await this.options.storage.read('runs', id);
+    return data === undefined ? undefined : JSON.parse(data);
+  }
+
+  /**
+   * A run whose events can be followed as it goes on
+   */
+  followRun(id: string): StreamedRun | undefined {
+    return this.runs.get(id);
+  }
+
+  /**
+   * Wait for the next scheduled time of a trigger and fire it
+   */
+  private schedule(served: ServedTrigger): void {
+    if (!served.schedule || this.stopped) {
+      return;
+    }
+    const nextRun = nextScheduledTime(served.schedule, new Date());
+    served.nextRun = nextRun;
+    if (!nextRun) {
+      return;
+    }
+    const wait = () => {
+      const delay = nextRun.getTime() - Date.now();
+      if (delay > MAX_TIMER_DELAY) {
+        served.timer = setTimeout(wait, MAX_TIMER_DELAY);
+        return;
+      }
+      served.timer = setTimeout(
+        () => {
+          this.fireScheduled(served, nextRun);
+          this.schedule(served);
+        },
+        Math.max(delay, 0)
+      );
+    };
+    wait();
+  }
+
+  /**
+   * Fire a trigger at its scheduled time, unless another instance sharing
+   * the storage claimed that time first
+   */
+  private fireScheduled(served: ServedTrigger, scheduledFor: Date): void {
+    const { storage, warn } = this.options;
+    if (!storage) {
+      this.submit(served, 'schedule');
+      return;
+    }
+    const name = served.trigger.name;
+    // Every instance waits for the same times, so the time names the run
+    const time = scheduledFor.toISOString().replace(/[-:]|\.\d+/g, '');
+    const claim = JSON.stringify({
+      trigger: name,
+      scheduledFor: scheduledFor.toISOString(),
+      instance: this.instance,
+      claimedAt: new Date().toISOString(),
+    });
+    const done = storage
+      .create('schedules', `${name}.${time}`, claim)
+      .then(
+        async claimed => {
+          if (claimed && !this.stopped) {
+            this.submit(served, 'schedule');
+            await this.sweepClaims(storage);
+          }
+        },
+        error => {
+          // Without a claim the run is skipped rather than risk running it
+          // on every instance
+          const message =
+            error instanceof Error ? error.message : String(error);
+          warn?.(
+            `Could not claim the ${scheduledFor.toISOString()} run of ${name}, so it was skipped: ${message}`
+          );
+        }
+      )
+      .catch(error => {
+        const message = error instanceof Error ? error.message : String(error);
+        warn?.(`Could not remove old claims of scheduled runs: ${message}`);
+      })
+      .finally(() => this.storing.delete(done));
+    this.storing.add(done);
+  }
+
+  /**
+   * Remove claims of scheduled runs past keeping, at most once an interval
+   */
+  private async sweepClaims(storage: Storage): Promise<void> {
+    const now = Date.now();
+    if (now - this.sweptAt < SCHEDULE_SWEEP_INTERVAL) {
+      return;
+    }
+    this.sweptAt = now;
+    for (const record of await storage.list('schedules')) {
+      if (now - record.modified.getTime() > SCHEDULE_CLAIM_RETENTION) {
+        await storage.delete('schedules', record.key);
+      }
+    }
+  }
+
+  private submit(
+    served: ServedTrigger,
+    cause: TriggerCause,
+    caller?: CallerIdentity
+  ): TriggerRun {
+    const { trigger, script } = served;
+    const run = new TriggerRun(
+      script,
+      cause,
+      { trigger: trigger.name },
+      caller
+    );
+    served.lastRun = run;
+    return this.enqueue(run, () => trigger.fire());
+  }
+
+  /**
+   * Run after the runs of its script before it
+   */
+  private enqueue(run: TriggerRun, body: () => Promise<unknown>): TriggerRun {
+    const { script } = run;
+    this.runs.set(run.id, run);
+    this.audit(run, 'queued');
+    const previous = this.turns.get(script) ?? Promise.resolve();
+    const turn = previous.then(() => this.execute(run, body));
+    this.turns.set(script, turn);
+    turn.then(() => {
+      if (this.turns.get(script) === turn) {
+        this.turns.delete(script);
+      }
+      this.prune();
+    });
+    return run;
+  }
+
+  private async execute(
+    run: TriggerRun,
+    body: () => Promise<unknown>
+  ): Promise<void> {
+    const { script } = run;
+    run.state = 'running';
+    run.startedAt = new Date();
+    script.output = line => run.write(line);
+    script.log = entry => run.record({ type: 'log', entry });
+    script.outcome?.clear();
+    let timer: NodeJS.Timeout | undefined;
+    let state: 'succeeded' | 'failed' = 'succeeded';
+    let failure: string | undefined;
+    try {
+      const { timeout } = this.options;
+      const timedOut = new Promise<never>((_, reject) => {
+        if (timeout) {
+          timer = setTimeout(
+            () => reject(new Error(`Run timed out after ${timeout}ms`)),
+            timeout
+          );
+        }
+      });
+      // Tool calls and agent steps are logged from the spans of the run,
+      // and its MCP tool calls pass on the caller it works for
+      const forCaller = () =>
+        run.caller ? runAsCaller(run.caller, body) : body();
+      const traced = script.tracer
+        ? script.tracer.trace(
+            'mcps.run',
+            { 'mcps.script': script.file },
+            forCaller
+          )
+        : forCaller();
+      const result = await Promise.race([traced, timedOut]);
+      if (result !== undefined) {
+        run.write(
+          typeof result === 'string' || isSpilled(result)
+            ? String(result)
+            : JSON.stringify(result)
+        );
+      }
+      // Tool error results and failed map items fail the run if the
+      // project gives them an exit code
+      const issue =
+        script.outcome && outcomeFailure(script.outcome, script.exitCodes);
+      if (issue) {
+        state = 'failed';
+        failure = issue.message;
+      }
+    } catch (error) {
+      state = 'failed';
+      failure =
+        error instanceof Error
+          ? formatScriptError(error, false)
+          : String(error);
+    } finally {
+      clearTimeout(timer);
+      script.output = undefined;
+      script.log = undefined;
+      run.finish(state, failure);
+      this.audit(run, state, failure);
+      this.store('runs', run.id, run.status(), script.redactor);
+    }
+  }
+
+  /**
+   * Note in the audit log what a run is of, what started it and how far
+   * it has got
+   */
+  private audit(run: TriggerRun, state: TriggerRunState, error?: string) {
+    const time = new Date();
+    const entry = {
+      time: time.toISOString(),
+      run: run.id,
+      ...run.source,
+      file: run.script.file,
+      cause: run.cause,
+      ...(run.caller && { caller: run.caller }),
+      state,
+      ...(error !== undefined && { error }),
+    };
+    const day = time.toISOString().slice(0, 10);
+    this.store('audit', day, entry, run.script.redactor);
+  }
+
+  /**
+   * Store a run or audit entry, with the project's redaction rules applied
+   * like they are to its logs
+   */
+  private store(
+    collection: 'runs' | 'audit',
+    key: string,
+    record: unknown,
+    redactor: Redactor = noRedaction
+  ) {
+    const { storage, warn } = this.options;
+    if (!storage) {
+      return;
+    }
+    const data = JSON.stringify(redactor.redact(record));
+    const stored =
+      collection === 'audit'
+        ? storage.append(collection, key, data)
+        : storage.write(collection, key, data);
+    const done = stored
+      .catch(error => {
+        const message = error instanceof Error ? error.message : String(error);
+        warn?.(`Could not store ${collection} ${key}: ${message}`);
+      })
+      .finally(() => this.storing.delete(done));
+    this.storing.add(done);
+  }
+
+  private prune(): void {
+    const finished = [...this.runs.values()].filter(run => run.finished);
+    for (const run of finished.slice(0, -MAX_TRIGGER_RUNS)) {
+      this.runs.delete(run.id);
+    }
+  }
+}
+
+/**
+ * Payload of a posted event: its JSON body, or null without one
+ */
+function parsePayload(body: string): unknown {
+  if (body.trim() === '') {
+    return null;
+  }
+  try {
+    return JSON.parse(body);
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new HttpError(400, `Invalid event payload: ${message}`);
+  }
+}
+
+export interface TriggerServerOptions {
+  /**
+   * Credentials requests must present, tried in turn (any request is
+   * accepted without)
+   */
+  auth?: Authenticator[];
+  /** Certificate and keys to serve HTTPS with, instead of HTTP */
+  tls?: HttpsServerOptions;
+}
+
+/**
+ * Create the HTTP server of a trigger service
+ */
+export function createTriggerServer(
+  service: TriggerService,
+  options: TriggerServerOptions = {}
+): Server {
+  const { auth = [], tls } = options;
+
+  async function route(
+    request: IncomingMessage,
+    response: ServerResponse
+  ): Promise<void> {
+    const { pathname, searchParams } = new URL(
+      request.url ?? '/',
+      'http://localhost'
+    );
+    const method = request.method ?? 'GET';
+    const child = (path: string) =>
+      pathname.startsWith(`${path}/`)
+        ? decodeURIComponent(pathname.slice(path.length + 1))
+        : undefined;
+    // Trigger paths are TRIGGERS_PATH/<name> and TRIGGERS_PATH/<name>/result
+    const [trigger, aspect, ...extra] = child(TRIGGERS_PATH)?.split('/') ?? [];
+    const event = child(EVENTS_PATH);
+    // Run paths are RUNS_PATH/<id> and RUNS_PATH/<id>/logs
+    const [run, resource, ...rest] = child(RUNS_PATH)?.split('/') ?? [];
+
+    let methods: string[];
+    if (
+      pathname === HEALTH_PATH ||
+      pathname === TRIGGERS_PATH ||
+      pathname === EVENTS_PATH ||
+      pathname === RUNS_PATH ||
+      (run && resource === undefined) ||
+      (run && resource === 'logs' && rest.length === 0) ||
+      (trigger && aspect === 'result' && extra.length === 0)
+    ) {
+      methods = ['GET'];
+    } else if (trigger && aspect === undefined) {
+      methods = ['GET', 'POST'];
+    } else if (event) {
+      methods = ['POST'];
+    } else {
+      throw new HttpError(404, `Unknown path: ${request.url}`);
+    }
+    if (!methods.includes(method)) {
+      throw new HttpError(405, `Use ${methods.join(' or ')} for ${pathname}`);
+    }
+
+    const caller = await authenticateRequest(request, auth);
+
+    if (pathname === HEALTH_PATH) {
+      return sendJson(response, 200, { status: 'ok' });
+    }
+    if (pathname === TRIGGERS_PATH) {
+      return sendJson(response, 200, { triggers: service.listTriggers() });
+    }
+    if (pathname === EVENTS_PATH) {
+      return sendJson(response, 200, { events: service.listEvents() });
+    }
+    if (pathname === RUNS_PATH) {
+      return sendJson(response, 200, { runs: await service.runHistory() });
+    }
+    if (event) {
+      const payload = parsePayload(await readBody(request, MAX_EVENT_SIZE));
+      const runs = service.deliver(
+        event,
+        payload,
+        'webhook',
+        undefined,
+        caller
+      );
+      if (runs.length === 0) {
+        throw new HttpError(404, `No handler for event: ${event}`);
+      }
+      return sendJson(response, 202, { event, runs });
+    }
+    if (run && resource === 'logs') {
+      const followed = service.followRun(run);
+      if (!followed) {
+        throw new HttpError(404, `Unknown run: ${run}`);
+      }
+      return streamLog(response, followed, searchParams);
+    }
+    if (run) {
+      const status = await service.findRun(run);
+      if (!status) {
+        throw new HttpError(404, `Unknown run: ${run}`);
+      }
+      return sendJson(response, 200, status);
+    }
+
+    if (aspect === 'result') {
+      // Clients may keep the result as long as the service does, and
+      // another caller's result is not theirs
+      const { status, etag, expires } = await service.result(trigger!, caller);
+      const fresh = status.state === 'succeeded';
+      const maxAge = Math.max(0, Math.floor((expires - Date.now()) / 1000));
+      response.setHeader(
+        'Cache-Control',
+        fresh ? `private, max-age=${maxAge}` : 'no-store'
+      );
+      response.setHeader('ETag', etag);
+      if (fresh && matchesEtag(request.headers['if-none-match'], etag)) {
+        response.writeHead(304);
+        response.end();
+        return;
+      }
+      return sendJson(response, 200, status);
+    }
+    if (method === 'POST') {
+      const status = service.fire(trigger!, 'webhook', caller);
+      response.setHeader('Location', `${RUNS_PATH}/${status.id}`);
+      return sendJson(response, 202, status);
+    }
+    const status = service.triggerStatus(trigger!);
+    if (!status) {
+      throw new HttpError(404, `Unknown trigger: ${trigger}`);
+    }
+    sendJson(response, 200, status);
+  }
+
+  const handle = (request: IncomingMessage, response: ServerResponse) => {
+    route(request, response).catch(error => sendError(response, error));
+  };
+  return tls ? createHttpsServer(tls, handle) : createServer(handle);
+}
@@ -1,10 +1,310 @@
 // CLI command types and interfaces
+import type {
+  AgentRunRecord,
+  LogSeverity,
+  ReplayPoint,
+  TraceLevel,
+} from '@mcpscript/runtime';
+import type { Severity } from './audit.js';
 
 export interface RunOptions {
   file: string;
   timeout?: number;
+  /** URL of an mcpsd executor to run the script on instead of locally */
+  remote?: string;
+  /**
+   * Where to export traces and metrics of the run: to an OTLP collector
+   * configured by the OTEL_* environment variables, or to stderr
+   */
+  trace?: 'otlp' | 'console';
+  /** Which @trace points record their values (default: info) */
+  traceLevel?: TraceLevel;
+  /**
+   * File to write a timeline of the run's tool calls, retries and model
+   * requests to: an HTML page for .html files, a Chrome trace otherwise
+   */
+  timeline?: string;
+  /** Role of the project's .mcpsrc to run in, limiting its tool calls */
+  role?: string;
+  /**
+   * Print the servers and calls of the script as text or JSON instead of
+   * running it
+   */
+  dryRun?: 'text' | 'json';
+  /** Id of a failed run to continue from its last checkpoint */
+  resume?: string;
+  /** JSON file of a sandbox policy limiting what the script may do */
+  policy?: string;
+  /** JSON file of a fault plan making the tool calls of servers fail */
+  faults?: string;
+  /** Flags after `--`, read as the arguments of the script's main tool */
+  args?: string[];
+  /**
+   * JSON file of the values to try for the parameters of main; the script
+   * is run once per combination of them
+   */
+  matrix?: string;
+  /** Most matrix runs at once (default: 4) */
+  parallel?: number;
+  /** Reuse the run prepared for the unchanged script (default: true) */
+  cache?: boolean;
+  /**
+   * Run only one agent of the script, continuing a conversation from a
+   * transcript
+   */
+  replay?: {
+    from: ReplayPoint;
+    agent: string;
+    messages: AgentRunRecord['messages'];
+  };
 }
 
 export interface CompileOptions {
   file: string;
 }
+
+export interface FmtOptions {
+  files: string[];
+  /** Write formatted output back to the files */
+  write?: boolean;
+  /** Print a diff instead of the formatted source */
+  diff?: boolean;
+  /** Sort the imports and remove duplicate and unused ones */
+  organizeImports?: boolean;
+}
+
+export interface CheckOptions {
+  files: string[];
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+  /** Reuse results of unchanged files (default: true) */
+  cache?: boolean;
+}
+
+export interface ParseOptions {
+  file: string;
+  /**
+   * "sexp" (default) for the syntax tree as an S-expression, "json" for it
+   * as JSON, or "ast" for the AST as JSON
+   */
+  format?: 'sexp' | 'json' | 'ast';
+}
+
+export interface HighlightOptions {
+  file: string;
+  /** "ansi" (default) for terminals or "html" */
+  format?: 'ansi' | 'html';
+  /** Name of a theme in HIGHLIGHT_THEMES (default: "dark") */
+  theme?: string;
+  /** Print an HTML page with the theme's stylesheet instead of a fragment */
+  standalone?: boolean;
+}
+
+export interface TestOptions {
+  /** Test files, or directories searched for *_test.mcps files */
+  paths: string[];
+  /** Timeout of each test file in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** JSON file of a fault plan making the calls of mock servers fail */
+  faults?: string;
+}
+
+export interface EvalOptions {
+  /** Suite files, or directories searched for *.eval.json files */
+  paths: string[];
+  /** Timeout of each run in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+  /** File to write the results to as JSON, e.g. for CI artifacts */
+  output?: string;
+  /** Fraction of runs that must pass, from 0 to 1 */
+  minPassRate: number;
+}
+
+export interface DiffOptions {
+  /** Old version of the script */
+  before: string;
+  /** New version of the script */
+  after: string;
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+}
+
+export interface SignOptions {
+  /** Scripts to sign; directories are searched for .mcps files */
+  files: string[];
+  /** PEM file of the Ed25519 private key (default: $MCPS_SIGNING_KEY) */
+  key?: string;
+  /** Write a new key pair as <name>.key and <name>.pub first */
+  generateKey?: string;
+}
+
+export interface VerifyOptions {
+  files: string[];
+  /**
+   * PEM files of the public keys to trust (default: the trustedKeys of
+   * each file's .mcpsrc)
+   */
+  keys?: string[];
+}
+
+export interface LockOptions {
+  file: string;
+  /** Pin server packages without a version in the script to their latest */
+  update?: boolean;
+}
+
+export interface ToolsSyncOptions {
+  file: string;
+  /** Fail when the lockfile is out of date instead of updating it */
+  check?: boolean;
+}
+
+export interface AuditDepsOptions {
+  paths: string[];
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+  /** Least severity of the findings that fail the audit (default: high) */
+  failOn?: Severity;
+}
+
+export interface ExportCapabilitiesOptions {
+  file: string;
+  /** "json" (default) or "text" */
+  format?: 'text' | 'json';
+}
+
+export interface GenerateGoOptions {
+  file: string;
+  /** Go package name of the generated file */
+  packageName?: string;
+  /** File to write instead of stdout */
+  output?: string;
+  /** Read tool schemas from the lockfile instead of starting the servers */
+  offline?: boolean;
+}
+
+/**
+ * A refactoring of mcps refactor, with positions 1-based as editors show
+ * them
+ */
+export type RefactorOperation =
+  | { kind: 'inline-variable'; line: number; column: number }
+  | { kind: 'extract-workflow'; from: number; to: number; name?: string }
+  | { kind: 'move-declaration'; name: string; to: string };
+
+export interface RefactorOptions {
+  file: string;
+  operation: RefactorOperation;
+  /** Change the files instead of printing a diff */
+  write?: boolean;
+}
+
+export interface ExtractPromptsOptions {
+  /** Scripts, or directories searched for them */
+  paths: string[];
+  /** Prompts module the prompts are moved to */
+  to: string;
+  /** Change the files instead of printing a diff */
+  write?: boolean;
+}
+
+export interface BenchOptions {
+  /** Script to benchmark; omitted with internal */
+  file?: string;
+  /** Benchmark a generated corpus instead of a file */
+  internal?: boolean;
+  /** Lines of the generated corpus */
+  lines?: number;
+  /** Minimum time to run each benchmark for, in milliseconds */
+  timeMs?: number;
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+  /** File to save the results to, as a baseline for later runs */
+  save?: string;
+  /** Baseline file to compare the results against */
+  baseline?: string;
+  /** Slowdown that counts as a regression, 0.2 for 20% (default: 0.2) */
+  threshold?: number;
+}
+
+export interface DaemonCommandOptions {
+  host: string;
+  port: number;
+  /** JSON file with tenants, projects and concurrency limits */
+  config?: string;
+}
+
+export interface LogsOptions {
+  /** Run to read the log of */
+  id: string;
+  /** Base URL of the mcpsd executor or `mcps serve --http` service */
+  url: string;
+  /** Keep streaming events until the run exits */
+  follow?: boolean;
+  /** Only the log entries of these servers */
+  servers?: string[];
+  /** Least severity of the events shown (default: debug) */
+  level?: LogSeverity;
+  /** Print the events as newline-delimited JSON */
+  json?: boolean;
+}
+
+export interface ApiCommandOptions {
+  host: string;
+  port: number;
+}
+
+export interface ServeOptions {
+  /** Workflows to serve, or directories searched for .mcps files */
+  paths: string[];
+  /** Execution timeout of each call in milliseconds (0 = no timeout) */
+  timeout?: number;
+  /** Serve the scripts' triggers over HTTP instead of MCP on stdio */
+  http?: { host: string; port: number };
+  /** Directory events are taken from, when serving over HTTP */
+  queue?: string;
+}
+
+export interface DocOptions {
+  /** Scripts to document, or directories searched for .mcps files */
+  paths: string[];
+  /** "markdown" (default) or "html" */
+  format?: 'markdown' | 'html';
+  /** Directory to write a page per script and an index to */
+  output?: string;
+}
+
+export interface TranscriptShowOptions {
+  /** Transcript id, path or "last" */
+  transcript: string;
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+}
+
+export interface TranscriptMocksOptions {
+  /** Transcript id, path or "last" */
+  transcript: string;
+  /** Directory to write the test file to, by default the script's */
+  out?: string;
+}
+
+export interface TranscriptReplayOptions {
+  /** Transcript id, path or "last" */
+  transcript: string;
+  /** Agent run and message to replay from, as "<run>.<message>" */
+  from: string;
+  timeout?: number;
+}
+
+export interface RunsDiffOptions {
+  /** Transcript id, path or "last" of the run to compare against */
+  before: string;
+  /** Transcript id, path or "last" of the run to compare */
+  after: string;
+  /** "text" (default) or "json" */
+  format?: 'text' | 'json';
+  /** Milliseconds a timing must change by to be reported */
+  timingThreshold?: number;
+}
@@ -28,6 +28,26 @@ export const App: React.FC<AppProps> = ({ state }) => {
             <Text key={index}>{msg.body}</Text>
           )
         )}
+      {state.streaming && (
+        <TitledBox
+          borderStyle="round"
+          titles={[state.streaming.title]}
+          marginBottom={1}
+          paddingX={1}
+        >
+          <Text>{state.streaming.body}</Text>
+        </TitledBox>
+      )}
+      {state.progress && (
+        <TitledBox
+          borderStyle="round"
+          titles={[state.progress.title]}
+          marginBottom={1}
+          paddingX={1}
+        >
+          <Text>{state.progress.body}</Text>
+        </TitledBox>
+      )}
       {state.userInput && (
         <UserInput
           message={state.userInput.message}
@@ -0,0 +1,137 @@
+// Unified diffs between two versions of a file
+
+type Edit =
+  | { kind: 'equal'; line: string }
+  | { kind: 'delete'; line: string }
+  | { kind: 'insert'; line: string };
+
+/**
+ * Line edits turning one list into the other (Myers' algorithm)
+ */
+function diffLines(before: string[], after: string[]): Edit[] {
+  const max = before.length + after.length;
+  const offset = max + 1;
+  const frontier = new Array<number>(2 * max + 3).fill(0);
+  const trace: number[][] = [];
+
+  search: for (let d = 0; d <= max; d++) {
+    trace.push([...frontier]);
+    for (let k = -d; k <= d; k += 2) {
+      const down =
+        k === -d ||
+        (k !== d && frontier[offset + k - 1] < frontier[offset + k + 1]);
+      let x = down ? frontier[offset + k + 1] : frontier[offset + k - 1] + 1;
+      let y = x - k;
+      while (x < before.length && y < after.length && before[x] === after[y]) {
+        x++;
+        y++;
+      }
+      frontier[offset + k] = x;
+      if (x >= before.length && y >= after.length) {
+        break search;
+      }
+    }
+  }
+
+  // Walk the trace backwards to recover the edits
+  const edits: Edit[] = [];
+  let x = before.length;
+  let y = after.length;
+  for (let d = trace.length - 1; d >= 0; d--) {
+    const previous = trace[d];
+    const k = x - y;
+    const down =
+      k === -d ||
+      (k !== d && previous[offset + k - 1] < previous[offset + k + 1]);
+    const previousK = down ? k + 1 : k - 1;
+    const previousX = previous[offset + previousK];
+    const previousY = previousX - previousK;
+    while (x > previousX && y > previousY) {
+      edits.push({ kind: 'equal', line: before[--x] });
+      y--;
+    }
+    if (d > 0) {
+      if (down) {
+        edits.push({ kind: 'insert', line: after[--y] });
+      } else {
+        edits.push({ kind: 'delete', line: before[--x] });
+      }
+    }
+  }
+  return edits.reverse();
+}
+
+function splitLines(text: string): string[] {
+  const lines = text.split('\n');
+  if (lines[lines.length - 1] === '') {
+    lines.pop();
+  }
+  return lines;
+}
+
+/**
+ * Render the changes between two texts as a unified diff with the given
+ * number of context lines; returns an empty string if they are equal
+ */
+export function createUnifiedDiff(
+  fileName: string,
+  before: string,
+  after: string,
+  context = 3
+): string {
+  if (before === after) {
+    return '';
+  }
+
+  const edits = diffLines(splitLines(before), splitLines(after));
+  const output = [`--- ${fileName}`, `+++ ${fileName}`];
+
+  let i = 0;
+  while (i < edits.length) {
+    if (edits[i].kind === 'equal') {
+      i++;
+      continue;
+    }
+
+    // Extend the hunk while changes are within two contexts of each other
+    const start = Math.max(0, i - context);
+    let end = i;
+    while (end < edits.length) {
+      let next = end;
+      while (next < edits.length && edits[next].kind === 'equal') {
+        next++;
+      }
+      if (next === edits.length || next - end > 2 * context) {
+        end = Math.min(edits.length, end + context);
+        break;
+      }
+      while (next < edits.length && edits[next].kind !== 'equal') {
+        next++;
+      }
+      end = next;
+    }
+
+    // Line numbers of the hunk in each file
+    let beforeLine = 1;
+    let afterLine = 1;
+    for (const edit of edits.slice(0, start)) {
+      beforeLine += edit.kind === 'insert' ? 0 : 1;
+      afterLine += edit.kind === 'delete' ? 0 : 1;
+    }
+    const hunk = edits.slice(start, end);
+    const beforeCount = hunk.filter(e => e.kind !== 'insert').length;
+    const afterCount = hunk.filter(e => e.kind !== 'delete').length;
+    output.push(
+      `@@ -${beforeCount === 0 ? beforeLine - 1 : beforeLine},${beforeCount} ` +
+        `+${afterCount === 0 ? afterLine - 1 : afterLine},${afterCount} @@`
+    );
+    for (const edit of hunk) {
+      const prefix =
+        edit.kind === 'equal' ? ' ' : edit.kind === 'delete' ? '-' : '+';
+      output.push(`${prefix}${edit.line}`);
+    }
+    i = end;
+  }
+
+  return output.join('\n') + '\n';
+}
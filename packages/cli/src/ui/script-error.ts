@@ -0,0 +1,60 @@
+// Rendering of script errors for the terminal
+import type { ScriptStackFrame } from '@mcpscript/runtime';
+import { highlightAnsi, parseTree } from '@mcpscript/transpiler';
+
+const ANSI = {
+  reset: '\x1b[0m',
+  bold: '\x1b[1m',
+  dim: '\x1b[2m',
+  red: '\x1b[31m',
+  cyan: '\x1b[36m',
+};
+
+type Style = keyof typeof ANSI;
+
+/**
+ * Whether stderr output should use ANSI colors
+ */
+export function supportsColor(): boolean {
+  return Boolean(process.stderr.isTTY) && !process.env.NO_COLOR;
+}
+
+/**
+ * Render an error with its script-level stack trace and source excerpts,
+ * highlighted like `mcps highlight` when colored
+ * Errors without a script stack are rendered as "Name: message", with the
+ * message translated by `translate` when given
+ */
+export function formatScriptError(
+  error: Error & { scriptStack?: ScriptStackFrame[] },
+  color: boolean = supportsColor(),
+  translate: (message: string) => string = message => message
+): string {
+  const paint = (text: string, ...styles: Style[]) =>
+    color && styles.length > 0
+      ? `${styles.map(s => ANSI[s]).join('')}${text}${ANSI.reset}`
+      : text;
+
+  const lines = [
+    paint(`${error.name}: ${translate(error.message)}`, 'bold', 'red'),
+  ];
+  const frames = error.scriptStack ?? [];
+  const gutterWidth = Math.max(0, ...frames.map(f => String(f.line).length));
+
+  for (const frame of frames) {
+    const where = `${frame.file}:${frame.line}:${frame.column}`;
+    lines.push(
+      `  ${paint('at', 'dim')} ${paint(frame.tool ?? '<script>', 'bold')} ` +
+        `(${paint(where, 'cyan')})`
+    );
+    if (frame.excerpt !== undefined) {
+      const gutter = String(frame.line).padStart(gutterWidth);
+      const excerpt = color
+        ? highlightAnsi(parseTree(frame.excerpt), frame.excerpt)
+        : frame.excerpt;
+      lines.push(`    ${paint(`${gutter} │`, 'dim')} ${excerpt}`);
+    }
+  }
+
+  return lines.join('\n');
+}
@@ -0,0 +1,63 @@
+// Vector index collections of the runs of mcps run
+//
+// Scripts using the `vectors` builtin build an index that later runs query
+// again, so its collections are kept in the project rather than the
+// user's state directory: one JSON file per collection, in .mcps/vectors
+// next to the project's .mcpsrc unless the config names another directory.
+import { readFile } from 'fs/promises';
+import { dirname, join, resolve } from 'path';
+import type {
+  ModelConfig,
+  VectorRecord,
+  VectorStore,
+} from '@mcpscript/runtime';
+import type { LoadedConfig } from './config.js';
+import { writeFileAtomic } from './storage.js';
+
+/** Directory of the collections, relative to the project */
+export const DEFAULT_VECTORS_DIR = join('.mcps', 'vectors');
+
+/**
+ * Keeps each collection of the vector index in a JSON file named after it
+ */
+export class FileVectorStore implements VectorStore {
+  constructor(private readonly dir: string) {}
+
+  private path(collection: string): string {
+    // The index only gives out collections with names of files
+    return join(this.dir, `${collection}.json`);
+  }
+
+  async load(collection: string): Promise<VectorRecord[] | undefined> {
+    try {
+      return JSON.parse(await readFile(this.path(collection), 'utf-8'));
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return undefined;
+      }
+      throw error;
+    }
+  }
+
+  async save(collection: string, records: VectorRecord[]): Promise<void> {
+    await writeFileAtomic(this.path(collection), JSON.stringify(records));
+  }
+}
+
+/**
+ * The vector store of a project, with its path resolved against the
+ * directory of the config file
+ */
+export function vectorStore(loaded: LoadedConfig): VectorStore {
+  const base = loaded.path ? dirname(loaded.path) : process.cwd();
+  return new FileVectorStore(
+    resolve(base, loaded.config.vectors?.path ?? DEFAULT_VECTORS_DIR)
+  );
+}
+
+/**
+ * The embedding model of a project, if it configures one
+ */
+export function embeddingModel(loaded: LoadedConfig): ModelConfig | undefined {
+  return loaded.config.embeddings && { ...loaded.config.embeddings };
+}
@@ -1,7 +1,9 @@
 // Tests for Agent runtime class
 import { describe, it, expect, vi, beforeEach } from 'vitest';
-import { Agent } from '../agent.js';
+import { Agent, AgentBudgetError } from '../agent.js';
 import { Conversation } from '../conversation.js';
+import { Tracer, type SpanData } from '../tracing.js';
+import { TranscriptRecorder, type TranscriptEvent } from '../transcript.js';
 import type { BaseLLM, ToolCall } from '@llamaindex/core/llms';
 import type { BaseTool } from '@llamaindex/core/llms';
 import { createUserTool } from '../mcp.js';
@@ -336,4 +338,208 @@ describe('Agent', () => {
     // 'active' should not be in required list since it's optional
     expect(params.required).not.toContain('active');
   });
+
+  it('should stream replies to the stream handler', async () => {
+    async function* chunks() {
+      yield { delta: 'Hel' };
+      yield { delta: '' };
+      yield { delta: 'lo' };
+    }
+    vi.mocked(mockLLM.exec).mockResolvedValue({
+      stream: chunks(),
+      newMessages: () => [{ role: 'assistant', content: 'Hello' }],
+      toolCalls: [],
+    } as never);
+
+    const printed: string[] = [];
+    const deltas: string[] = [];
+    const agent = new Agent(
+      { name: 'Streamer', llm: mockLLM },
+      (_name, msg) => printed.push(String(msg.content)),
+      (name, delta) => deltas.push(`${name}:${delta}`)
+    );
+
+    const conversation = await agent.run('Hi');
+
+    expect(mockLLM.exec).toHaveBeenCalledWith(
+      expect.objectContaining({ stream: true })
+    );
+    expect(deltas).toEqual(['Streamer:Hel', 'Streamer:lo']);
+    // The complete reply is still printed once it is finished
+    expect(printed).toEqual(['Hi', 'Hello']);
+    expect(conversation.result()).toBe('Hello');
+  });
+});
+
+describe('Agent delegation', () => {
+  type Exec = BaseLLM['exec'];
+  type ExecParams = Parameters<Exec>[0];
+
+  /**
+   * An LLM replying with the results of each step in turn; the last step
+   * is repeated
+   */
+  function scriptedLLM(
+    ...steps: ((params: ExecParams) => Promise<string | null>)[]
+  ): BaseLLM {
+    let turn = 0;
+    return {
+      exec: vi.fn(async (params: ExecParams) => {
+        const step = steps[Math.min(turn++, steps.length - 1)];
+        const content = await step(params);
+        return {
+          newMessages: [{ role: 'assistant', content: content ?? 'working' }],
+          // A step without a reply stands for one that called tools
+          toolCalls: content === null ? [{ id: 'call' } as ToolCall] : [],
+        };
+      }),
+    } as unknown as BaseLLM;
+  }
+
+  const delegate = (params: ExecParams, task: string) =>
+    params.tools![0].call!({ task }) as Promise<string>;
+
+  it('should offer sub-agents as tools taking a task', async () => {
+    const writer = new Agent({
+      name: 'writer',
+      description: 'Writes short poems',
+      llm: scriptedLLM(async ({ messages }) => `Poem: ${messages[0].content}`),
+    });
+    let answer: string | undefined;
+    const llm = scriptedLLM(
+      async params => {
+        answer = await delegate(params, 'a haiku about rain');
+        return null;
+      },
+      async () => 'Done'
+    );
+    const lead = new Agent({ name: 'lead', llm, tools: [writer] });
+
+    await expect(lead.run('Get me a poem')).resolves.toBeInstanceOf(
+      Conversation
+    );
+    const [{ tools }] = vi.mocked(llm.exec).mock.calls[0];
+    expect(tools![0].metadata).toMatchObject({
+      name: 'writer',
+      description: 'Writes short poems',
+      parameters: { required: ['task'] },
+    });
+    expect(answer).toBe('Poem: a haiku about rain');
+  });
+
+  it('should count the turns of sub-agents against the agents above them', async () => {
+    const writer = new Agent({
+      name: 'writer',
+      llm: scriptedLLM(async () => null),
+    });
+    const errors: string[] = [];
+    const lead = new Agent({
+      name: 'lead',
+      maxTurns: 2,
+      llm: scriptedLLM(async params => {
+        await delegate(params, 'write').catch(error =>
+          errors.push(error.message)
+        );
+        return null;
+      }),
+      tools: [writer],
+    });
+
+    const run = lead.run('Go');
+    await expect(run).rejects.toThrow(AgentBudgetError);
+    await expect(run).rejects.toThrow('Agent lead used all of its 2 turns');
+    expect(errors).toEqual([
+      'Agent writer ran out of turns: lead allows 2 for itself and the agents it delegates to',
+    ]);
+  });
+
+  it('should trace sub-agent runs under the turn that delegated', async () => {
+    const spans: SpanData[] = [];
+    const tracer = new Tracer({
+      export: async batch => {
+        spans.push(...batch);
+      },
+    });
+    const writer = new Agent({
+      name: 'writer',
+      llm: scriptedLLM(async () => 'Poem'),
+    });
+    const lead = new Agent({
+      name: 'lead',
+      llm: scriptedLLM(
+        async params => {
+          await delegate(params, 'write');
+          return null;
+        },
+        async () => 'Done'
+      ),
+      tools: [writer],
+    });
+
+    await tracer.trace('root', {}, () => lead.run('Go'));
+    await tracer.flush();
+
+    const span = (agent: string) =>
+      spans.find(
+        s => s.name === 'mcps.agent' && s.attributes['mcps.agent'] === agent
+      )!;
+    const turn = spans.find(s => s.parentSpanId === span('lead').spanId)!;
+    expect(span('writer').parentSpanId).toBe(turn.spanId);
+    expect(span('writer').attributes).toMatchObject({
+      'mcps.parent_agent': 'lead',
+      'mcps.turns': 1,
+    });
+    expect(span('lead').attributes['mcps.turns']).toBe(2);
+  });
+
+  it('should record sub-agent runs in the transcript under the delegating run', async () => {
+    const events: TranscriptEvent[] = [];
+    const transcript = new TranscriptRecorder(event => events.push(event));
+    const noPrint = () => {};
+    const writer = new Agent(
+      { name: 'writer', llm: scriptedLLM(async () => 'Poem') },
+      noPrint,
+      undefined,
+      transcript
+    );
+    const lead = new Agent(
+      {
+        name: 'lead',
+        systemPrompt: 'Delegate writing',
+        llm: scriptedLLM(
+          async params => {
+            await delegate(params, 'write');
+            return null;
+          },
+          async () => 'Done'
+        ),
+        tools: [writer],
+      },
+      noPrint,
+      undefined,
+      transcript
+    );
+
+    await lead.run('Go');
+
+    expect(
+      events.map(event =>
+        event.type === 'message'
+          ? [event.run, event.message.content]
+          : [event.run, event.type]
+      )
+    ).toEqual([
+      [1, 'run_start'],
+      [1, 'Go'],
+      [2, 'run_start'],
+      [2, 'write'],
+      [2, 'Poem'],
+      [2, 'run_end'],
+      [1, 'working'],
+      [1, 'Done'],
+      [1, 'run_end'],
+    ]);
+    expect(events[0]).toMatchObject({ systemPrompt: 'Delegate writing' });
+    expect(events[2]).toMatchObject({ agent: 'writer', parent: 1 });
+  });
 });
@@ -0,0 +1,52 @@
+// Tests for the arguments of a script's main tool
+import { describe, it, expect, vi } from 'vitest';
+import { executeInVM } from '../vm-executor.js';
+
+const SCRIPT = `
+const main = __createUserTool("main", ["service", "replicas"], async (service, replicas = 3) => {
+  print(service + " x" + replicas);
+}, __buildZodSchema({ type: "object", properties: { service: { type: "string" }, replicas: { type: "optional", schema: { type: "number" } } } }));
+await __main(main);
+`;
+
+describe('runMain', () => {
+  it('should call main with the arguments by name', async () => {
+    const addMessage = vi.fn();
+    await executeInVM(SCRIPT, { addMessage, args: { service: 'api' } });
+    await executeInVM(SCRIPT, {
+      addMessage,
+      args: { service: 'web', replicas: 5 },
+    });
+
+    expect(addMessage.mock.calls.map(([message]) => message.body)).toEqual([
+      'api x3',
+      'web x5',
+    ]);
+  });
+
+  it('should refuse arguments main does not take', async () => {
+    await expect(
+      executeInVM(SCRIPT, { args: { service: 'api', replica: 2 } })
+    ).rejects.toThrow('main has no parameter named replica');
+    await expect(
+      executeInVM(SCRIPT, { args: { replicas: 'two' } })
+    ).rejects.toThrow(
+      'Invalid arguments of main: service: Required; replicas: Expected number, received string'
+    );
+  });
+
+  it('should pass base64 arguments of bytes parameters on as bytes', async () => {
+    const addMessage = vi.fn();
+    await executeInVM(
+      `
+const main = __createUserTool("main", ["image"], async (image) => {
+  print(bytes.isBytes(image) + " " + image.toString("hex"));
+}, __buildZodSchema({ type: "object", properties: { image: { type: "bytes" } } }));
+await __main(main);
+`,
+      { addMessage, args: { image: 'iVBORw==' } }
+    );
+
+    expect(addMessage.mock.calls[0][0].body).toBe('true 89504e47');
+  });
+});
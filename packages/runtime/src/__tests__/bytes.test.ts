@@ -0,0 +1,103 @@
+// Tests for binary values
+import { describe, it, expect, vi } from 'vitest';
+import vm from 'vm';
+import { bytes, decodeContent, encodeBytes, isBytes } from '../bytes.js';
+import { createToolProxy } from '../mcp.js';
+import { formatInspect } from '../inspect.js';
+
+const PNG = Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]);
+
+describe('bytes', () => {
+  it('should convert text in each encoding', () => {
+    expect(bytes.from('hi')).toEqual(Buffer.from([0x68, 0x69]));
+    expect(bytes.from('aGk=', 'base64').toString()).toBe('hi');
+    expect(bytes.from('6869', 'hex').toString('base64url')).toBe('aGk');
+    expect(bytes.from([1, 2, 255])[2]).toBe(255);
+    expect(() => bytes.from('hi', 'utf-16')).toThrow(
+      'Unknown encoding utf-16, expected one of utf-8, utf8, base64, ' +
+        'base64url, hex, latin1'
+    );
+    expect(() => bytes.from([1, 256])).toThrow('256 is not a byte');
+  });
+
+  it('should slice and join bytes', () => {
+    const header = PNG.subarray(1, 4);
+    expect(header.toString('latin1')).toBe('PNG');
+    expect(bytes.concat([header, 'x', [0]])).toEqual(
+      Buffer.from('PNGx\0', 'latin1')
+    );
+    // Copies leave the bytes they were made from as is
+    const copy = bytes.from(PNG);
+    copy[0] = 0;
+    expect(PNG[0]).toBe(0x89);
+  });
+
+  it('should gather chunks written to a writer', () => {
+    const writer = bytes.writer();
+    const chunk = Buffer.alloc(700, 1);
+    writer.write(chunk).write(chunk).write('ff', 'hex');
+    expect(writer.length).toBe(1401);
+
+    const written = writer.bytes();
+    expect(written.length).toBe(1401);
+    expect(written[1400]).toBe(255);
+    writer.write([7]);
+    expect(written.length).toBe(1401);
+    expect(writer.bytes()[1401]).toBe(7);
+  });
+
+  it('should know bytes of the script', () => {
+    const made = vm.runInNewContext('new Uint8Array([1, 2])');
+    expect(isBytes(made)).toBe(true);
+    expect(isBytes(PNG)).toBe(true);
+    expect(isBytes([1, 2])).toBe(false);
+    const data = vm.runInNewContext('({ data: new Uint8Array([1]) })');
+    expect(encodeBytes(data)).toEqual({ data: 'AQ==' });
+  });
+});
+
+describe('bytes in tool calls', () => {
+  it('should send bytes as base64 and decode binary results', async () => {
+    const tool = {
+      metadata: {
+        name: 'resize',
+        parameters: {
+          type: 'object',
+          properties: { image: { type: 'string' } },
+        },
+      },
+      call: vi.fn(async ({ image }: { image: string }) => ({
+        content: [
+          { type: 'image', mimeType: 'image/png', data: image },
+          {
+            type: 'resource',
+            resource: { uri: 'file:///out.png', blob: image },
+          },
+        ],
+      })),
+    };
+    const images = createToolProxy([tool], 'images');
+
+    const content = (await images.resize(PNG)) as {
+      data?: Buffer;
+      resource?: { blob: Buffer };
+    }[];
+    expect(tool.call).toHaveBeenCalledWith({ image: PNG.toString('base64') });
+    expect(content[0].data).toEqual(PNG);
+    expect(content[1].resource?.blob).toEqual(PNG);
+  });
+
+  it('should leave text content as is', () => {
+    const content = [{ type: 'text', text: 'aGk=' }];
+    expect(decodeContent(content)).toEqual(content);
+  });
+});
+
+describe('inspect of bytes', () => {
+  it('should show their size and first bytes in hex', () => {
+    expect(formatInspect(PNG.subarray(0, 4))).toBe('bytes(4 B) <89 50 4e 47>');
+    expect(formatInspect(Buffer.alloc(2048), { maxItems: 2 })).toBe(
+      'bytes(2.0 KB) <00 00>… (2046 more bytes)'
+    );
+  });
+});
@@ -0,0 +1,208 @@
+import { describe, it, expect } from 'vitest';
+import {
+  callWithPolicy,
+  CallTimeoutError,
+  checkCallPolicy,
+  isTransientError,
+  retryDelay,
+  RETRY_MAX_DELAY,
+} from '../call-policy.js';
+import { createToolProxy } from '../mcp.js';
+import { currentBranchSignal, runParallel } from '../parallel.js';
+
+function delay(ms: number): Promise<void> {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+function networkError(code: string): Error {
+  return Object.assign(new Error(`connect ${code}`), { code });
+}
+
+describe('isTransientError', () => {
+  it('should retry timeouts, network errors and overloaded servers', () => {
+    expect(isTransientError(new CallTimeoutError('a.b', 10))).toBe(true);
+    expect(isTransientError(networkError('ECONNRESET'))).toBe(true);
+    expect(isTransientError(Object.assign(new Error(), { code: 503 }))).toBe(
+      true
+    );
+    expect(isTransientError(Object.assign(new Error(), { code: -32001 }))).toBe(
+      true
+    );
+    expect(
+      isTransientError(
+        new TypeError('fetch failed', { cause: networkError('ECONNREFUSED') })
+      )
+    ).toBe(true);
+  });
+
+  it('should not retry errors the tool reports', () => {
+    expect(isTransientError(new Error('File not found'))).toBe(false);
+    expect(isTransientError(Object.assign(new Error(), { code: 404 }))).toBe(
+      false
+    );
+    expect(isTransientError(Object.assign(new Error(), { code: -32602 }))).toBe(
+      false
+    );
+    expect(isTransientError(networkError('ENOENT'))).toBe(false);
+    expect(isTransientError('failed')).toBe(false);
+  });
+});
+
+describe('retryDelay', () => {
+  it('should grow the wait according to the backoff', () => {
+    expect([1, 2, 3].map(n => retryDelay('exponential', n))).toEqual([
+      200, 400, 800,
+    ]);
+    expect([1, 2, 3].map(n => retryDelay('linear', n))).toEqual([
+      200, 400, 600,
+    ]);
+    expect([1, 2, 3].map(n => retryDelay('constant', n))).toEqual([
+      200, 200, 200,
+    ]);
+    expect(retryDelay(undefined, 20)).toBe(RETRY_MAX_DELAY);
+  });
+});
+
+describe('checkCallPolicy', () => {
+  it('should reject invalid fields', () => {
+    expect(() => checkCallPolicy({ timeout: 0 }, 'fs.read')).toThrow(
+      'fs.read: timeout must be a positive number of milliseconds, got 0'
+    );
+    expect(() => checkCallPolicy({ retries: 1.5 }, 'fs.read')).toThrow(
+      'fs.read: retries must be a non-negative integer, got 1.5'
+    );
+    expect(() =>
+      checkCallPolicy({ backoff: 'random' as 'linear' }, 'fs.read')
+    ).toThrow(
+      'fs.read: backoff must be one of exponential, linear, constant, got random'
+    );
+  });
+});
+
+describe('callWithPolicy', () => {
+  it('should fail an attempt that runs past the timeout', async () => {
+    let signal: AbortSignal | undefined;
+    const result = callWithPolicy('fs.read', { timeout: 10 }, async () => {
+      signal = currentBranchSignal();
+      await delay(100);
+      return 'late';
+    });
+    await expect(result).rejects.toThrow('Tool fs.read timed out after 10 ms');
+    expect(signal?.aborted).toBe(true);
+  });
+
+  it('should retry transient errors up to the limit', async () => {
+    let attempts = 0;
+    const result = await callWithPolicy(
+      'fs.read',
+      { retries: 2, backoff: 'constant' },
+      async () => {
+        attempts++;
+        if (attempts < 3) {
+          throw networkError('ECONNRESET');
+        }
+        return 'ok';
+      }
+    );
+    expect(result).toBe('ok');
+    expect(attempts).toBe(3);
+  });
+
+  it('should give up once the retries are used', async () => {
+    let attempts = 0;
+    const result = callWithPolicy(
+      'fs.read',
+      { timeout: 5, retries: 1, backoff: 'constant' },
+      async () => {
+        attempts++;
+        await delay(50);
+      }
+    );
+    await expect(result).rejects.toBeInstanceOf(CallTimeoutError);
+    expect(attempts).toBe(2);
+  });
+
+  it('should not retry errors that are not transient', async () => {
+    let attempts = 0;
+    const result = callWithPolicy('fs.read', { retries: 3 }, async () => {
+      attempts++;
+      throw new Error('File not found');
+    });
+    await expect(result).rejects.toThrow('File not found');
+    expect(attempts).toBe(1);
+  });
+
+  it('should stop retrying when the branch is cancelled', async () => {
+    let attempts = 0;
+    const result = runParallel([
+      () =>
+        callWithPolicy('fs.read', { retries: 5 }, async () => {
+          attempts++;
+          throw networkError('ECONNRESET');
+        }),
+      async () => {
+        await delay(50);
+        throw new Error('other branch failed');
+      },
+    ]);
+    await expect(result).rejects.toThrow('other branch failed');
+    await delay(300);
+    expect(attempts).toBe(1);
+  });
+});
+
+describe('createToolProxy policies', () => {
+  function flakyTool(failures: number) {
+    let calls = 0;
+    return {
+      get calls() {
+        return calls;
+      },
+      metadata: {
+        name: 'read',
+        parameters: {
+          type: 'object',
+          properties: { path: { type: 'string' } },
+        },
+      },
+      call: async (input: { path: string }) => {
+        calls++;
+        if (calls <= failures) {
+          throw networkError('ECONNRESET');
+        }
+        return { content: [{ type: 'text', text: input.path }] };
+      },
+    };
+  }
+
+  it('should apply the server defaults to every call', async () => {
+    const tool = flakyTool(1);
+    const fs = createToolProxy([tool], 'fs', {
+      retries: 1,
+      backoff: 'constant',
+    });
+    await expect(fs.read('a.txt')).resolves.toBe('a.txt');
+    expect(tool.calls).toBe(2);
+  });
+
+  it('should let a single call override the defaults', async () => {
+    const tool = flakyTool(1);
+    const fs = createToolProxy([tool], 'fs', { retries: 1 });
+    const call = (fs as Record<string, unknown>).__mcp_call as (
+      name: string,
+      args: unknown[],
+      policy: object
+    ) => Promise<unknown>;
+    await expect(call('read', ['a.txt'], { retries: 0 })).rejects.toThrow(
+      'connect ECONNRESET'
+    );
+    expect(tool.calls).toBe(1);
+  });
+
+  it('should call tools directly without a policy', async () => {
+    const tool = flakyTool(1);
+    const fs = createToolProxy([tool], 'fs');
+    await expect(fs.read('a.txt')).rejects.toThrow('connect ECONNRESET');
+    expect(tool.calls).toBe(1);
+  });
+});
@@ -0,0 +1,138 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  CheckpointError,
+  decodeValue,
+  encodeValue,
+  MemoryCheckpointStore,
+  ScriptCheckpoints,
+} from '../checkpoint.js';
+import { Conversation } from '../conversation.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('checkpoint values', () => {
+  it('should restore the values scripts work with', () => {
+    const conversation = new Conversation('Summarize this');
+    conversation.addMessage({ role: 'assistant', content: 'Done' });
+    const value = {
+      list: [1, 'two', null, undefined],
+      tags: new Set(['a', 'b']),
+      counts: new Map([['x', 1]]),
+      at: new Date('2026-10-16T12:00:00Z'),
+      conversation,
+      tricky: { $mcps: 'set' },
+    };
+
+    const restored = decodeValue(
+      JSON.parse(JSON.stringify(encodeValue(value)))
+    ) as typeof value;
+    expect(restored).toEqual(value);
+    expect(restored.conversation).toBeInstanceOf(Conversation);
+    expect(restored.conversation.result()).toBe('Done');
+  });
+
+  it('should refuse values it cannot restore', () => {
+    expect(() => encodeValue({ run: () => 1 }, 'job')).toThrow(
+      new CheckpointError('job.run is a function')
+    );
+    expect(() => encodeValue([new URL('https://example.com')], 'urls')).toThrow(
+      'urls[0] is a URL'
+    );
+  });
+});
+
+describe('ScriptCheckpoints', () => {
+  it('should continue from the last saved statement', async () => {
+    const store = new MemoryCheckpointStore();
+    const options = { store, run: 'run-1' };
+    const first = await ScriptCheckpoints.open(options, 'hash');
+    expect(first.pending(1)).toBe(true);
+    await first.save(1, { x: 1 });
+    await first.save(2, { x: 1, y: ['a'] });
+
+    const resumed = await ScriptCheckpoints.open(
+      { ...options, resume: true },
+      'hash'
+    );
+    expect(resumed.pending(2)).toBe(false);
+    expect(resumed.pending(3)).toBe(true);
+    expect(resumed.restore('y')).toEqual(['a']);
+    expect(resumed.restore('z')).toBeUndefined();
+
+    await resumed.finish();
+    expect(await store.load('run-1')).toBeUndefined();
+  });
+
+  it('should not resume runs of other scripts or without checkpoints', async () => {
+    const store = new MemoryCheckpointStore();
+    await expect(
+      ScriptCheckpoints.open({ store, run: 'gone', resume: true }, 'hash')
+    ).rejects.toThrow('Run gone has no checkpoint');
+
+    const run = await ScriptCheckpoints.open({ store, run: 'r' }, 'old');
+    await run.save(1, {});
+    await expect(
+      ScriptCheckpoints.open({ store, run: 'r', resume: true }, 'new')
+    ).rejects.toThrow('was checkpointed with another version of the script');
+  });
+
+  it('should keep the previous checkpoint when a value cannot be saved', async () => {
+    const store = new MemoryCheckpointStore();
+    const warn = vi.fn();
+    const run = await ScriptCheckpoints.open({ store, run: 'r' }, 'h', warn);
+    await run.save(1, { x: 1 });
+    await run.save(2, { x: 1, f: () => 2 });
+    await run.save(3, { x: 2, f: () => 2 });
+
+    expect((await store.load('r'))?.statement).toBe(1);
+    expect(warn).toHaveBeenCalledTimes(1);
+    expect(warn.mock.calls[0][0]).toContain('f is a function');
+  });
+});
+
+describe('executeInVM with checkpoints', () => {
+  const code = `
+let total = __checkpoint.restore("total");
+if (__checkpoint.pending(1)) {
+  total = await input("Total?");
+  await __checkpoint.save(1, { total });
+}
+if (__checkpoint.pending(2)) {
+  print("Total:", total);
+  await __checkpoint.save(2, { total });
+}`;
+
+  it('should skip the statements a failed run had finished', async () => {
+    const store = new MemoryCheckpointStore();
+    const userInput = vi.fn(async () => '42');
+    const addMessage = vi
+      .fn()
+      .mockImplementationOnce(() => {
+        throw new Error('Terminal closed');
+      })
+      .mockImplementation(() => {});
+
+    await expect(
+      executeInVM(code, {
+        userInput,
+        addMessage,
+        checkpoints: { store, run: 'nightly' },
+      })
+    ).rejects.toThrow('Terminal closed');
+    expect(await store.load('nightly')).toMatchObject({
+      statement: 1,
+      variables: { total: '42' },
+    });
+
+    await executeInVM(code, {
+      userInput,
+      addMessage,
+      checkpoints: { store, run: 'nightly', resume: true },
+    });
+    expect(userInput).toHaveBeenCalledTimes(1);
+    expect(addMessage).toHaveBeenLastCalledWith({
+      title: '',
+      body: 'Total: 42',
+    });
+    expect(await store.load('nightly')).toBeUndefined();
+  });
+});
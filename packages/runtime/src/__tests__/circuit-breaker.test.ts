@@ -0,0 +1,142 @@
+import { describe, it, expect } from 'vitest';
+import {
+  CircuitBreaker,
+  CircuitOpenError,
+  checkCircuitBreaker,
+} from '../circuit-breaker.js';
+import { createToolProxy } from '../mcp.js';
+
+function delay(ms: number): Promise<void> {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+function networkError(code: string): Error {
+  return Object.assign(new Error(`connect ${code}`), { code });
+}
+
+describe('checkCircuitBreaker', () => {
+  it('should reject invalid failures and cooldowns', () => {
+    expect(() =>
+      checkCircuitBreaker({ failures: 3, cooldown: 10 }, 'fs')
+    ).not.toThrow();
+    expect(() => checkCircuitBreaker({ failures: 0 }, 'fs')).toThrow(
+      'fs: circuitBreaker.failures must be a positive integer, got 0'
+    );
+    expect(() => checkCircuitBreaker({ cooldown: -1 }, 'fs')).toThrow(
+      'fs: circuitBreaker.cooldown must be a positive number of milliseconds, got -1'
+    );
+  });
+});
+
+describe('CircuitBreaker', () => {
+  const failing = () => Promise.reject(networkError('ECONNREFUSED'));
+
+  it('should open after repeated transient failures', async () => {
+    const breaker = new CircuitBreaker({ failures: 2, cooldown: 1000 });
+    await expect(breaker.call('fs.read', failing)).rejects.toThrow('connect');
+    expect(breaker.state('fs.read')).toBe('closed');
+    await expect(breaker.call('fs.read', failing)).rejects.toThrow('connect');
+    expect(breaker.state('fs.read')).toBe('open');
+
+    let calls = 0;
+    const error = await breaker
+      .call('fs.read', async () => calls++)
+      .catch(error => error);
+    expect(calls).toBe(0);
+    expect(error).toBeInstanceOf(CircuitOpenError);
+    expect(error).toMatchObject({ tool: 'fs.read', failures: 2 });
+    expect(error.retryAfter).toBeGreaterThan(0);
+    expect(error.message).toBe(
+      'Tool fs.read is unavailable after 2 failed calls in a row; calls resume in 1 s'
+    );
+
+    // Circuits are kept per tool
+    await expect(breaker.call('fs.write', async () => 'ok')).resolves.toBe(
+      'ok'
+    );
+  });
+
+  it('should not count errors the tool reports', async () => {
+    const breaker = new CircuitBreaker({ failures: 1 });
+    const notFound = () => Promise.reject(new Error('File not found'));
+    await expect(breaker.call('fs.read', notFound)).rejects.toThrow();
+    await expect(breaker.call('fs.read', notFound)).rejects.toThrow();
+    expect(breaker.state('fs.read')).toBe('closed');
+  });
+
+  it('should let one trial call through after the cooldown', async () => {
+    const breaker = new CircuitBreaker({ failures: 1, cooldown: 20 });
+    await expect(breaker.call('fs.read', failing)).rejects.toThrow();
+    await delay(30);
+    expect(breaker.state('fs.read')).toBe('half-open');
+
+    // A failed trial opens the circuit again
+    await expect(breaker.call('fs.read', failing)).rejects.toThrow('connect');
+    expect(breaker.state('fs.read')).toBe('open');
+    await delay(30);
+
+    let finish!: (value: string) => void;
+    const trial = breaker.call(
+      'fs.read',
+      () => new Promise<string>(resolve => (finish = resolve))
+    );
+    await expect(
+      breaker.call('fs.read', async () => 'second')
+    ).rejects.toMatchObject({
+      retryAfter: 0,
+      message: expect.stringContaining('a trial call is in progress'),
+    });
+    finish('first');
+    await expect(trial).resolves.toBe('first');
+    expect(breaker.state('fs.read')).toBe('closed');
+  });
+});
+
+describe('createToolProxy circuit breaker', () => {
+  it('should stop retrying once the circuit opens', async () => {
+    let calls = 0;
+    const tool = {
+      metadata: { name: 'read' },
+      call: async () => {
+        calls++;
+        throw networkError('ECONNRESET');
+      },
+    };
+    const fs = createToolProxy(
+      [tool],
+      'fs',
+      { retries: 5, backoff: 'constant' },
+      undefined,
+      undefined,
+      new CircuitBreaker({ failures: 2 })
+    );
+
+    await expect(fs.read()).rejects.toBeInstanceOf(CircuitOpenError);
+    expect(calls).toBe(2);
+  });
+
+  it('should guard the calls of agents', async () => {
+    let calls = 0;
+    const tool = {
+      metadata: { name: 'read' },
+      call: async () => {
+        calls++;
+        throw networkError('ECONNRESET');
+      },
+    };
+    const fs = createToolProxy(
+      [tool],
+      'fs',
+      {},
+      undefined,
+      undefined,
+      new CircuitBreaker({ failures: 1 })
+    );
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const [agentTool] = (fs as any).__mcp_tools;
+
+    await expect(agentTool.call({})).rejects.toThrow('connect');
+    await expect(agentTool.call({})).rejects.toBeInstanceOf(CircuitOpenError);
+    expect(calls).toBe(1);
+  });
+});
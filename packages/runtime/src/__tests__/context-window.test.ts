@@ -0,0 +1,144 @@
+// Tests for keeping agent conversations within the context window
+import { describe, it, expect, vi } from 'vitest';
+import type { BaseLLM, ChatMessage } from '@llamaindex/core/llms';
+import { Agent } from '../agent.js';
+import {
+  checkContextOptions,
+  compactMessages,
+  SUMMARY_HEADING,
+} from '../context-window.js';
+import { rememberModelConfig } from '../routing.js';
+
+const text = (n: number) => 'x'.repeat(n);
+
+function toolResult(id: string, content: string): ChatMessage {
+  return {
+    role: 'user',
+    content,
+    options: { toolResult: { id, result: content, isError: false } },
+  };
+}
+
+function toolCall(id: string): ChatMessage {
+  return {
+    role: 'assistant',
+    content: '',
+    options: { toolCall: [{ id, name: 'read', input: {} }] },
+  };
+}
+
+describe('compactMessages', () => {
+  it('should leave conversations within the limit alone', async () => {
+    const messages: ChatMessage[] = [{ role: 'user', content: text(40) }];
+    await expect(compactMessages(messages, 100)).resolves.toBeUndefined();
+  });
+
+  it('should cut older tool results short first', async () => {
+    const messages: ChatMessage[] = [
+      { role: 'system', content: 'Be brief' },
+      { role: 'user', content: 'Read the files' },
+      toolCall('1'),
+      toolResult('1', text(2000)),
+      { role: 'assistant', content: 'Done' },
+    ];
+    const compacted = await compactMessages(messages, 200, {
+      keep: 1,
+      toolResultLimit: 100,
+    });
+
+    expect(compacted).toHaveLength(5);
+    expect(compacted![3].content).toBe(
+      `${text(100)}\n[1900 characters left out]`
+    );
+    expect(compacted![3].options).toEqual({
+      toolResult: { id: '1', result: compacted![3].content, isError: false },
+    });
+  });
+
+  it('should summarize older messages without splitting tool calls', async () => {
+    const summarize = vi.fn(async () => 'They asked for a report');
+    const messages: ChatMessage[] = [
+      { role: 'system', content: 'Be brief' },
+      { role: 'user', content: text(800) },
+      { role: 'assistant', content: text(800) },
+      toolCall('1'),
+      toolResult('1', 'short'),
+      { role: 'assistant', content: 'Here it is' },
+    ];
+    const compacted = await compactMessages(
+      messages,
+      300,
+      { keep: 2 },
+      summarize
+    );
+
+    // The kept messages start with the call whose result is kept
+    expect(summarize).toHaveBeenCalledWith(messages.slice(1, 3));
+    expect(compacted).toEqual([
+      messages[0],
+      {
+        role: 'user',
+        content: `${SUMMARY_HEADING}\nThey asked for a report`,
+      },
+      ...messages.slice(3),
+    ]);
+  });
+
+  it('should drop older messages when not summarizing', async () => {
+    const messages: ChatMessage[] = [
+      { role: 'user', content: text(800) },
+      { role: 'assistant', content: 'Latest' },
+    ];
+    await expect(compactMessages(messages, 100, { keep: 1 })).resolves.toEqual(
+      [messages[1]]
+    );
+  });
+});
+
+describe('checkContextOptions', () => {
+  it('should reject malformed options', () => {
+    expect(() => checkContextOptions({ limit: -1 }, 'Agent A')).toThrow(
+      'Agent A: context.limit must be a non-negative integer, got -1'
+    );
+    expect(() => checkContextOptions({ threshold: 2 }, 'Agent A')).toThrow(
+      'context.threshold must be a number above 0 and at most 1'
+    );
+    expect(() => checkContextOptions({ window: 10 }, 'Agent A')).toThrow(
+      'Agent A: unknown context option window'
+    );
+  });
+});
+
+describe('Agent context window', () => {
+  it('should compact the conversation before a turn that would not fit', async () => {
+    const sent: ChatMessage[][] = [];
+    const llm = {
+      exec: vi.fn(async ({ messages }: { messages: ChatMessage[] }) => {
+        sent.push(messages.map(message => ({ ...message })));
+        return {
+          newMessages: [
+            {
+              role: 'assistant',
+              content: messages[0].role === 'system' ? 'Summary' : 'Answer',
+            },
+          ],
+          toolCalls: [],
+        };
+      }),
+    } as unknown as BaseLLM;
+    rememberModelConfig(llm, { provider: 'fake', contextWindow: 250 });
+    const agent = new Agent({ name: 'A', llm, context: { keep: 1 } });
+
+    const conversation = await agent.run(text(1000));
+    // Nothing could be compacted with a single message to keep
+    expect(sent).toHaveLength(1);
+
+    conversation.addMessage({ role: 'user', content: 'And now?' });
+    const next = await agent.run(conversation);
+    expect(next.result()).toBe('Answer');
+    // The summary was asked for, and the turn sent it instead
+    expect(sent).toHaveLength(3);
+    expect(sent[2][0].content).toBe(`${SUMMARY_HEADING}\nSummary`);
+    expect(sent[2][1].content).toBe('And now?');
+  });
+});
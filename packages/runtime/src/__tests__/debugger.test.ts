@@ -0,0 +1,143 @@
+import { describe, it, expect } from 'vitest';
+import { ScriptDebugger, type StopReason } from '../debugger.js';
+import { createToolProxy } from '../mcp.js';
+
+/**
+ * A debugger recording where the script pauses, as [reason, line, depth]
+ */
+function recordingDebugger(stopOnEntry = false) {
+  const stops: [StopReason, number, number][] = [];
+  let onStop: () => void = () => {};
+  const debug: ScriptDebugger = new ScriptDebugger({
+    stopOnEntry,
+    onStop: reason => {
+      const frames = debug.frames();
+      stops.push([reason, frames[0].line, frames.length]);
+      onStop();
+    },
+  });
+  return {
+    debug,
+    stops,
+    /** Answer every pause with the given command */
+    answer(command: (debug: ScriptDebugger) => void) {
+      onStop = () => command(debug);
+    },
+  };
+}
+
+/**
+ * A script of two top-level statements calling a tool of two statements:
+ *
+ *   1  x = 1
+ *   2  y = double(x)
+ *   3  (in double) a = n * 2
+ *   4  (in double) return a
+ *   5  print(y)
+ */
+async function script(debug: ScriptDebugger): Promise<number> {
+  const double = (n: number) =>
+    debug.call('double', async () => {
+      await debug.before(3, 3, { n: () => n });
+      const a = n * 2;
+      await debug.before(4, 3, { n: () => n, a: () => a });
+      return a;
+    });
+  await debug.before(1, 1, {});
+  const x = 1;
+  await debug.before(2, 1, { x: () => x });
+  const y = await double(x);
+  await debug.before(5, 1, { x: () => x, y: () => y });
+  return y;
+}
+
+describe('ScriptDebugger', () => {
+  it('should run without pausing when nothing asks it to', async () => {
+    const { debug, stops } = recordingDebugger();
+    await expect(script(debug)).resolves.toBe(2);
+    expect(stops).toEqual([]);
+  });
+
+  it('should pause at breakpoints', async () => {
+    const { debug, stops, answer } = recordingDebugger();
+    debug.setBreakpoints([2, 4]);
+    answer(d => d.continue());
+    await script(debug);
+    expect(stops).toEqual([
+      ['breakpoint', 2, 1],
+      ['breakpoint', 4, 2],
+    ]);
+  });
+
+  it('should step into tools', async () => {
+    const { debug, stops, answer } = recordingDebugger(true);
+    answer(d => d.stepIn());
+    await script(debug);
+    expect(stops.map(([, line]) => line)).toEqual([1, 2, 3, 4, 5]);
+    expect(stops[0][0]).toBe('entry');
+  });
+
+  it('should step over tools', async () => {
+    const { debug, stops, answer } = recordingDebugger(true);
+    answer(d => d.next());
+    await script(debug);
+    expect(stops.map(([, line]) => line)).toEqual([1, 2, 5]);
+  });
+
+  it('should step out of tools', async () => {
+    const { debug, stops, answer } = recordingDebugger();
+    debug.setBreakpoints([3]);
+    answer(d => d.stepOut());
+    await script(debug);
+    expect(stops).toEqual([
+      ['breakpoint', 3, 2],
+      ['step', 5, 1],
+    ]);
+  });
+
+  it('should show variables in scope and values tools returned', async () => {
+    const debug = new ScriptDebugger({
+      onStop: () => {
+        const [frame] = debug.frames();
+        expect(frame.variables()).toEqual({ x: 1, y: 2 });
+        expect(frame.returned).toEqual({ tool: 'double', value: 2 });
+        debug.continue();
+      },
+    });
+    debug.setBreakpoints([5]);
+    await script(debug);
+  });
+
+  it('should leave out variables that cannot be read', async () => {
+    const debug = new ScriptDebugger({ stopOnEntry: true });
+    const paused = debug.before(1, 1, {
+      ready: () => 1,
+      later: () => {
+        throw new ReferenceError('later is not defined');
+      },
+    });
+    expect(debug.isPaused).toBe(true);
+    expect(debug.frames()[0].variables()).toEqual({ ready: 1 });
+    debug.continue();
+    await paused;
+    expect(debug.frames()).toEqual([]);
+  });
+
+  it('should list MCP tool calls waiting for an answer', async () => {
+    const debug = new ScriptDebugger();
+    let answer!: (value: unknown) => void;
+    const tool = {
+      metadata: { name: 'read' },
+      call: () => new Promise(resolve => (answer = resolve)),
+    };
+    const fs = createToolProxy([tool], 'fs', {}, debug);
+
+    const result = fs.read({ path: 'a.txt' });
+    expect(debug.pendingCalls()).toMatchObject([
+      { id: 1, tool: 'fs.read', input: { path: 'a.txt' } },
+    ]);
+    answer('contents');
+    await expect(result).resolves.toBe('contents');
+    expect(debug.pendingCalls()).toEqual([]);
+  });
+});
@@ -0,0 +1,93 @@
+// Tests for event handlers and emit()
+import { describe, it, expect, vi } from 'vitest';
+import { z } from 'zod';
+import { ScriptEvents } from '../events.js';
+import type { ScriptEventHandler } from '../types.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('ScriptEvents', () => {
+  it("should run an event's handlers in order, then publish it", async () => {
+    const order: string[] = [];
+    const publish = vi.fn(() => {
+      order.push('published');
+    });
+    const events = new ScriptEvents(undefined, publish);
+    events.on('deploy.finished', async payload => order.push(`a ${payload}`));
+    events.on('deploy.started', async () => order.push('other'));
+    events.on('deploy.finished', async payload => order.push(`b ${payload}`));
+
+    await expect(events.emit('deploy.finished', '1.2')).resolves.toBe(2);
+    expect(order).toEqual(['a 1.2', 'b 1.2', 'published']);
+    expect(publish).toHaveBeenCalledWith('deploy.finished', '1.2');
+    await expect(events.emit('unhandled')).resolves.toBe(0);
+    expect(publish).toHaveBeenLastCalledWith('unhandled', null);
+  });
+
+  it('should copy payloads as JSON', async () => {
+    const events = new ScriptEvents();
+    const received: unknown[] = [];
+    events.on('e', async payload => received.push(payload));
+
+    const payload = { at: new Date(0), items: [1] };
+    await events.emit('e', payload);
+    expect(received).toEqual([{ at: '1970-01-01T00:00:00.000Z', items: [1] }]);
+    expect(received[0]).not.toBe(payload);
+    await expect(events.emit('e', { n: 1n })).rejects.toThrow(
+      'emit: payload of event e is not JSON'
+    );
+    await expect(events.emit('', {})).rejects.toThrow(
+      'emit: event must be a non-empty string, got string'
+    );
+  });
+
+  it('should check payloads against the type of typed handlers', async () => {
+    const collected: ScriptEventHandler[] = [];
+    const events = new ScriptEvents(collected);
+    events.on(
+      'deploy.finished',
+      async payload => payload,
+      z.object({ version: z.string() })
+    );
+
+    expect(collected.map(handler => handler.event)).toEqual([
+      'deploy.finished',
+    ]);
+    await expect(collected[0].handle({ version: '1.2' })).resolves.toEqual({
+      version: '1.2',
+    });
+    await expect(collected[0].handle({ version: 12 })).rejects.toThrow(
+      "Event deploy.finished: payload does not match the handler's type: version: Expected string, received number"
+    );
+  });
+
+  it('should let scripts handle the events they emit', async () => {
+    const handlers: ScriptEventHandler[] = [];
+    const publish = vi.fn();
+    const addMessage = vi.fn();
+    const context = await executeInVM(
+      `
+      __on("deploy.finished", async (deploy) => {
+        print("deployed " + deploy.version);
+      }, __buildZodSchema({ type: "object", properties: { version: { type: "string" } } }));
+      let handled = await emit("deploy.finished", { version: "1.2" });
+      `,
+      { addMessage, eventHandlers: handlers, publish }
+    );
+
+    expect(context.handled).toBe(1);
+    expect(addMessage).toHaveBeenCalledWith({
+      title: '',
+      body: 'deployed 1.2',
+    });
+    expect(publish).toHaveBeenCalledWith('deploy.finished', {
+      version: '1.2',
+    });
+
+    // The host can deliver events to the handlers once the script has run
+    await handlers[0].handle({ version: '1.3' });
+    expect(addMessage).toHaveBeenLastCalledWith({
+      title: '',
+      body: 'deployed 1.3',
+    });
+  });
+});
@@ -0,0 +1,187 @@
+import { describe, it, expect } from 'vitest';
+import type { Transport } from '@modelcontextprotocol/sdk/shared/transport.js';
+import type { JSONRPCMessage } from '@modelcontextprotocol/sdk/types.js';
+import {
+  checkFaultPlan,
+  FaultInjector,
+  InjectedFaultError,
+  type FaultPlan,
+} from '../faults.js';
+import { createToolProxy } from '../mcp.js';
+import { MockServers } from '../testing.js';
+
+const READ_SCHEMA = {
+  name: 'read',
+  inputSchema: {
+    type: 'object',
+    properties: { path: { type: 'string' } },
+  },
+};
+
+/**
+ * A transport answering every request with its method as the result
+ */
+function echoTransport(): Transport & { sent: JSONRPCMessage[] } {
+  const transport: Transport & { sent: JSONRPCMessage[] } = {
+    sent: [],
+    start: async () => {},
+    close: async () => {},
+    send: async message => {
+      transport.sent.push(message);
+      if ('method' in message && 'id' in message) {
+        const { id, method } = message;
+        queueMicrotask(() =>
+          transport.onmessage?.({ jsonrpc: '2.0', id, result: { method } })
+        );
+      }
+    },
+  };
+  return transport;
+}
+
+/** Messages the wrapping transport delivers, in order */
+async function received(transport: Transport): Promise<JSONRPCMessage[]> {
+  const messages: JSONRPCMessage[] = [];
+  transport.onmessage = message => messages.push(message);
+  await transport.start();
+  return messages;
+}
+
+function callTool(id: number, name: string): JSONRPCMessage {
+  return {
+    jsonrpc: '2.0',
+    id,
+    method: 'tools/call',
+    params: { name, arguments: {} },
+  };
+}
+
+describe('checkFaultPlan', () => {
+  it('should accept plans of chances, latencies and tools', () => {
+    const plan = {
+      seed: 7,
+      servers: {
+        fs: { latency: [10, 50], drop: 0.1, tools: ['read'] },
+        '*': { disconnect: 0.5, latency: 20 },
+      },
+    };
+    expect(checkFaultPlan(plan)).toBe(plan);
+  });
+
+  it('should refuse unknown fields and values out of range', () => {
+    expect(() => checkFaultPlan({ servers: {}, retries: 2 })).toThrow(
+      'Unknown fault plan field "retries"'
+    );
+    expect(() => checkFaultPlan({ servers: { fs: { drop: 2 } } })).toThrow(
+      '"servers.fs.drop" must be a chance from 0 to 1'
+    );
+    expect(() =>
+      checkFaultPlan({ servers: { fs: { latency: [50, 10] } } })
+    ).toThrow('"servers.fs.latency" must be milliseconds or a [min, max]');
+    expect(() => checkFaultPlan({ servers: { fs: { slow: true } } })).toThrow(
+      'Unknown fault "servers.fs.slow"'
+    );
+  });
+});
+
+describe('FaultInjector', () => {
+  it('should draw the same faults for the same seed', () => {
+    const plan: FaultPlan = {
+      seed: 42,
+      servers: { fs: { drop: 0.5, latency: [0, 100] } },
+    };
+    const draws = () => {
+      const injector = new FaultInjector(plan);
+      return Array.from({ length: 20 }, () => injector.draw('fs', 'read'));
+    };
+    expect(draws()).toEqual(draws());
+    expect(draws().some(fault => fault?.kind === 'drop')).toBe(true);
+  });
+
+  it('should fault only the servers and tools the plan names', () => {
+    const injector = new FaultInjector({
+      servers: { fs: { disconnect: 1, tools: ['write'] } },
+    });
+    expect(injector.draw('fs', 'read')).toBe(undefined);
+    expect(injector.draw('search', 'query')).toBe(undefined);
+    expect(injector.draw('fs', 'write')).toEqual({
+      delay: 0,
+      kind: 'disconnect',
+    });
+    expect(injector.summary()).toBe('1 disconnect');
+  });
+
+  it('should fail the calls of mock servers', async () => {
+    const faults = new FaultInjector({ servers: { fs: { malformed: 1 } } });
+    const servers = new MockServers({ fs: [READ_SCHEMA] });
+    servers.respond('fs.read', 'contents');
+    const client = servers.connect({
+      serverName: 'fs',
+      command: 'fs-server',
+      faults,
+    });
+    const fs = createToolProxy(await client.tools(), 'fs');
+
+    const error = await fs.read('a.txt').catch((e: unknown) => e);
+    expect(error).toBeInstanceOf(InjectedFaultError);
+    expect(error).toMatchObject({ code: -32700 });
+    expect(servers.calls('fs.read')).toHaveLength(1);
+  });
+});
+
+describe('FaultInjector.wrapTransport', () => {
+  it('should pass requests other than tool calls through', async () => {
+    const injector = new FaultInjector({ servers: { '*': { drop: 1 } } });
+    const inner = echoTransport();
+    const transport = injector.wrapTransport(inner, 'fs');
+    const messages = await received(transport);
+
+    await transport.send({ jsonrpc: '2.0', id: 1, method: 'tools/list' });
+    await new Promise(resolve => setTimeout(resolve, 5));
+    expect(messages).toEqual([
+      { jsonrpc: '2.0', id: 1, result: { method: 'tools/list' } },
+    ]);
+  });
+
+  it('should cut off tool calls and drop their answers', async () => {
+    const injector = new FaultInjector({ servers: { fs: { disconnect: 1 } } });
+    const inner = echoTransport();
+    const transport = injector.wrapTransport(inner, 'fs');
+    const messages = await received(transport);
+
+    await transport.send(callTool(1, 'read'));
+    await new Promise(resolve => setTimeout(resolve, 5));
+    expect(inner.sent).toHaveLength(1);
+    expect(messages).toEqual([
+      {
+        jsonrpc: '2.0',
+        id: 1,
+        error: {
+          code: -32000,
+          message:
+            'Connection to MCP server "fs" closed during a call of read (injected fault)',
+        },
+      },
+    ]);
+  });
+
+  it('should delay and drop tool call answers', async () => {
+    const injector = new FaultInjector({
+      servers: { fs: { latency: 20, tools: ['read'] }, '*': { drop: 1 } },
+    });
+    const inner = echoTransport();
+    const fs = injector.wrapTransport(inner, 'fs');
+    const fsMessages = await received(fs);
+    const search = injector.wrapTransport(echoTransport(), 'search');
+    const searchMessages = await received(search);
+
+    await fs.send(callTool(1, 'read'));
+    await search.send(callTool(2, 'query'));
+    await new Promise(resolve => setTimeout(resolve, 5));
+    expect(fsMessages).toEqual([]);
+    await new Promise(resolve => setTimeout(resolve, 30));
+    expect(fsMessages).toHaveLength(1);
+    expect(searchMessages).toEqual([]);
+    expect(injector.summary()).toBe('1 latency, 1 drop');
+  });
+});
@@ -0,0 +1,62 @@
+// Tests for the locale-aware format builtin
+import { describe, it, expect } from 'vitest';
+import { createFormat } from '../format.js';
+import { executeInVM } from '../vm-executor.js';
+
+const DATE = new Date('2026-10-16T14:30:00Z');
+
+describe('format', () => {
+  const format = createFormat('en-US');
+
+  it('should format numbers in the run locale or the one given', () => {
+    expect(format.number(1234.5)).toBe('1,234.5');
+    expect(format.number(1234.5, { locale: 'de-DE' })).toBe('1.234,5');
+    expect(format.number(2, { decimals: 2 })).toBe('2.00');
+    expect(format.number(0.256, { style: 'percent' })).toBe('26%');
+  });
+
+  it('should format amounts of a currency', () => {
+    expect(format.currency(9.99, 'EUR')).toBe('€9.99');
+    // Amounts are kept together with non-breaking spaces
+    expect(format.currency(9.99, 'EUR', { locale: 'de-DE' })).toBe(
+      '9,99\u00a0€'
+    );
+    expect(format.currency(1500, 'JPY', { locale: 'ja-JP' })).toBe('￥1,500');
+  });
+
+  it('should format dates by style or by field', () => {
+    const utc = { timeZone: 'UTC' };
+    expect(format.date(DATE, utc)).toBe('Oct 16, 2026');
+    expect(
+      format.date('2026-10-16T14:30:00Z', {
+        ...utc,
+        locale: 'de-DE',
+        style: 'long',
+        time: 'short',
+      })
+    ).toBe('16. Oktober 2026 um 14:30');
+    expect(
+      format.date(DATE.getTime(), { ...utc, month: 'long', year: 'numeric' })
+    ).toBe('October 2026');
+  });
+
+  it('should refuse values and options it cannot format', () => {
+    expect(() => format.number('12' as unknown as number)).toThrow(
+      'format.number needs a number, got "12"'
+    );
+    expect(() => format.currency(1, 'EURO')).toThrow(
+      'format.currency: Invalid currency code : EURO'
+    );
+    expect(() => format.date('yesterday')).toThrow(
+      'format.date needs a date, an ISO date string or a timestamp, got "yesterday"'
+    );
+  });
+
+  it('should be available to scripts in the locale of the run', async () => {
+    const context = await executeInVM(
+      'let total = format.currency(1234.5, "EUR");',
+      { locale: 'fr-FR' }
+    );
+    expect(context.total).toBe('1\u202f234,50\u00a0€');
+  });
+});
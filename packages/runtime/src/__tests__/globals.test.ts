@@ -7,7 +7,8 @@ import {
   afterEach,
   type MockInstance,
 } from 'vitest';
-import { createPrint, log, env } from '../globals.js';
+import { createPrint, createLog, log, env } from '../globals.js';
+import { Redactor } from '../redaction.js';
 
 describe('globals', () => {
   describe('print function', () => {
@@ -360,6 +361,23 @@ describe('globals', () => {
         expect(consoleErrorSpy).toHaveBeenCalledTimes(1);
       });
     });
+
+    describe('redaction', () => {
+      it('should redact logged values with the given redactor', () => {
+        const redactedLog = createLog(
+          new Redactor({ patterns: ['sk-\\w+'], keys: ['password'] })
+        );
+        const user = { name: 'ada', password: 'hunter2' };
+        redactedLog.info('Using key sk-abc123', user);
+
+        expect(consoleInfoSpy).toHaveBeenCalledWith(
+          '[INFO]',
+          'Using key [REDACTED]',
+          { name: 'ada', password: '[REDACTED]' }
+        );
+        expect(user.password).toBe('hunter2');
+      });
+    });
   });
 
   describe('env object', () => {
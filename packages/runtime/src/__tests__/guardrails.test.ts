@@ -0,0 +1,257 @@
+// Tests for guardrails on model output
+import { describe, it, expect, vi } from 'vitest';
+import type { BaseLLM, BaseTool, ChatMessage } from '@llamaindex/core/llms';
+import { Agent } from '../agent.js';
+import {
+  checkGuardrails,
+  checkSchema,
+  GuardrailError,
+  GuardrailRun,
+} from '../guardrails.js';
+
+/**
+ * An LLM that answers each turn with the next reply; a reply with calls
+ * calls the given tools the way the LLM's exec does
+ */
+function scriptedLLM(
+  turns: Array<{ content: string; calls?: Array<[string, unknown]> }>
+): BaseLLM {
+  let turn = 0;
+  return {
+    exec: vi.fn(
+      async ({ tools }: { messages: ChatMessage[]; tools: BaseTool[] }) => {
+        const { content, calls = [] } = turns[turn++];
+        const results: ChatMessage[] = [];
+        for (const [name, input] of calls) {
+          const tool = tools.find(t => t.metadata.name === name)!;
+          let output: unknown;
+          try {
+            output = await tool.call!(input);
+          } catch (error) {
+            output = `Error: ${(error as Error).message}`;
+          }
+          results.push({ role: 'user', content: String(output) });
+        }
+        return {
+          newMessages: [{ role: 'assistant', content }, ...results],
+          toolCalls: calls.map(([name], i) => ({
+            id: `call${i}`,
+            name,
+            input: {},
+          })),
+        };
+      }
+    ),
+  } as unknown as BaseLLM;
+}
+
+function fakeTool(name: string) {
+  return {
+    metadata: { name, description: name },
+    call: vi.fn(async () => 'done'),
+  } as unknown as BaseTool;
+}
+
+describe('GuardrailRun', () => {
+  it('should accept output no guardrail rejects', async () => {
+    const run = new GuardrailRun('A', [{ deny: ['password'] }]);
+    await expect(run.review({ text: 'All good' })).resolves.toBeUndefined();
+  });
+
+  it('should block output matching a denied pattern', async () => {
+    const run = new GuardrailRun('A', [
+      { name: 'secrets', deny: ['pass\\w+'] },
+    ]);
+    await expect(run.review({ text: 'the password is 1234' })).rejects.toThrow(
+      "Agent A: secrets blocked the model's output: reply matches pass\\w+"
+    );
+  });
+
+  it('should let the model retry until its tries are used up', async () => {
+    const run = new GuardrailRun('A', [
+      { deny: ['rm -rf'], action: 'retry', retries: 1 },
+    ]);
+    const output = { text: '{"command":"rm -rf /"}', tool: 'shell.run' };
+    await expect(run.review(output)).resolves.toEqual({
+      guardrail: 'guardrail 1',
+      action: 'retry',
+      problem: 'call of shell.run matches rm -rf',
+    });
+    await expect(run.review(output)).rejects.toBeInstanceOf(GuardrailError);
+  });
+
+  it('should check final replies against a schema', async () => {
+    const run = new GuardrailRun('A', [
+      {
+        schema: {
+          type: 'object',
+          required: ['summary'],
+          properties: { score: { type: 'integer' } },
+        },
+      },
+    ]);
+    await expect(run.review({ text: 'not json' })).resolves.toBeUndefined();
+    await expect(
+      run.review({ text: '{"summary":"ok","score":3}', final: true })
+    ).resolves.toBeUndefined();
+    await expect(
+      run.review({ text: '{"score":2.5}', final: true })
+    ).rejects.toThrow(
+      'reply is missing "summary"; reply.score must be integer'
+    );
+    await expect(run.review({ text: 'plain', final: true })).rejects.toThrow(
+      'reply is not JSON'
+    );
+  });
+
+  it('should ask registered and script validators', async () => {
+    const pii = vi.fn(({ text }: { text: string }) =>
+      /\d{3}-\d{2}-\d{4}/.test(text) ? 'contains an SSN' : true
+    );
+    const noShouting = Object.assign(
+      async (text: string) => text !== text.toUpperCase(),
+      { __mcps_name: 'noShouting' }
+    );
+    const run = new GuardrailRun(
+      'A',
+      [{ check: 'pii' }, { check: noShouting }],
+      { validators: { pii } }
+    );
+    await expect(run.review({ text: 'fine' })).resolves.toBeUndefined();
+    expect(pii).toHaveBeenCalledWith({ agent: 'A', text: 'fine' });
+    await expect(run.review({ text: 'SSN 123-45-6789' })).rejects.toThrow(
+      'contains an SSN'
+    );
+    await expect(run.review({ text: 'HELLO' })).rejects.toThrow(
+      'rejected by noShouting'
+    );
+  });
+
+  it('should let a person decide on escalated output', async () => {
+    const escalate = vi
+      .fn()
+      .mockResolvedValueOnce(true)
+      .mockResolvedValueOnce(false);
+    const run = new GuardrailRun(
+      'A',
+      [{ deny: ['delete'], action: 'escalate' }],
+      { escalate }
+    );
+    await expect(run.review({ text: 'delete it' })).resolves.toBeUndefined();
+    expect(escalate).toHaveBeenCalledWith(
+      {
+        guardrail: 'guardrail 1',
+        action: 'escalate',
+        problem: 'reply matches delete',
+      },
+      { agent: 'A', text: 'delete it' }
+    );
+    await expect(run.review({ text: 'delete it' })).rejects.toThrow(
+      GuardrailError
+    );
+
+    // Nobody to ask means the output is blocked
+    await expect(
+      new GuardrailRun('A', [{ deny: ['delete'], action: 'escalate' }]).review(
+        { text: 'delete it' }
+      )
+    ).rejects.toThrow(GuardrailError);
+  });
+});
+
+describe('checkGuardrails', () => {
+  it('should reject malformed guardrails', () => {
+    expect(() => checkGuardrails({}, 'Agent A')).toThrow(
+      'Agent A: guardrails must be a list'
+    );
+    expect(() => checkGuardrails([{ action: 'block' }], 'Agent A')).toThrow(
+      'Agent A: guardrail 1 needs deny, schema or check'
+    );
+    expect(() => checkGuardrails([{ deny: ['('] }], 'Agent A')).toThrow(
+      'Agent A: guardrail 1: invalid deny pattern ('
+    );
+    expect(() => checkGuardrails([{ check: 'pii' }], 'Agent A')).toThrow(
+      'Agent A: guardrail 1: unknown output validator pii'
+    );
+    expect(() =>
+      checkGuardrails([{ deny: ['x'], action: 'warn' }], 'Agent A')
+    ).toThrow('action must be block, retry, escalate, got warn');
+  });
+
+  it('should check nested values against schemas', () => {
+    expect(
+      checkSchema(
+        {
+          type: 'array',
+          items: { type: 'string', enum: ['low', 'high'] },
+        },
+        ['low', 'medium', 3]
+      )
+    ).toEqual([
+      'reply[1] must be one of "low", "high"',
+      'reply[2] must be string, got number',
+    ]);
+  });
+});
+
+describe('Agent guardrails', () => {
+  it('should ask again instead of keeping a rejected reply', async () => {
+    const llm = scriptedLLM([
+      { content: 'The password is hunter2' },
+      { content: 'I cannot share that' },
+    ]);
+    const printed: string[] = [];
+    const agent = new Agent(
+      {
+        name: 'A',
+        llm,
+        guardrails: [{ deny: ['password'], action: 'retry' }],
+      },
+      (_, message) => printed.push(String(message.content))
+    );
+
+    const conversation = await agent.run('What is the password?');
+    expect(conversation.result()).toBe('I cannot share that');
+    expect(printed).not.toContain('The password is hunter2');
+    expect(printed).toContain(
+      'Your output was rejected by guardrail 1: reply matches password. Try again without this problem.'
+    );
+    expect(llm.exec).toHaveBeenCalledTimes(2);
+  });
+
+  it('should check tool calls before they run', async () => {
+    const shell = fakeTool('shell_run');
+    const agent = new Agent({
+      name: 'A',
+      llm: scriptedLLM([
+        { content: '', calls: [['shell_run', { command: 'rm -rf /' }]] },
+        { content: '', calls: [['shell_run', { command: 'ls' }]] },
+        { content: 'Listed' },
+      ]),
+      tools: [shell],
+      guardrails: [{ deny: ['rm -rf'], action: 'retry' }],
+    });
+
+    await agent.run('Clean up');
+    expect(shell.call).toHaveBeenCalledTimes(1);
+    expect(shell.call).toHaveBeenCalledWith({ command: 'ls' });
+  });
+
+  it('should fail the run when a blocked tool call is attempted', async () => {
+    const shell = fakeTool('shell_run');
+    const agent = new Agent({
+      name: 'A',
+      llm: scriptedLLM([
+        { content: '', calls: [['shell_run', { command: 'rm -rf /' }]] },
+        { content: 'Done' },
+      ]),
+      tools: [shell],
+      guardrails: [{ deny: ['rm -rf'] }],
+    });
+
+    await expect(agent.run('Clean up')).rejects.toThrow(
+      "Agent A: guardrail 1 blocked the model's output: call of shell_run matches rm -rf"
+    );
+    expect(shell.call).not.toHaveBeenCalled();
+  });
+});
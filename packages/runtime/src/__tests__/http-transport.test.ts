@@ -0,0 +1,63 @@
+// Tests for remote MCP server connection options
+import { describe, it, expect } from 'vitest';
+import {
+  DEFAULT_RECONNECT,
+  reconnectionOptions,
+  remoteTransportOptions,
+  usesSSETransport,
+} from '../http-transport.js';
+
+describe('remote MCP server options', () => {
+  it('should pick the SSE transport from either option', () => {
+    expect(usesSSETransport({ url: 'http://x' })).toBe(false);
+    expect(usesSSETransport({ url: 'http://x', transport: 'http' })).toBe(
+      false
+    );
+    expect(usesSSETransport({ url: 'http://x', transport: 'sse' })).toBe(true);
+    expect(usesSSETransport({ url: 'http://x', useSSETransport: true })).toBe(
+      true
+    );
+  });
+
+  it('should fill in reconnection defaults', () => {
+    expect(reconnectionOptions()).toEqual({
+      maxRetries: DEFAULT_RECONNECT.maxRetries,
+      initialReconnectionDelay: DEFAULT_RECONNECT.initialDelay,
+      maxReconnectionDelay: DEFAULT_RECONNECT.maxDelay,
+      reconnectionDelayGrowFactor: 1.5,
+    });
+    expect(reconnectionOptions({ maxRetries: 5 })).toMatchObject({
+      maxRetries: 5,
+      initialReconnectionDelay: 1000,
+    });
+  });
+
+  it('should send headers with every request', () => {
+    const options = remoteTransportOptions({
+      url: 'https://mcp.example.com/mcp',
+      headers: { Authorization: 'Bearer abc' },
+    });
+
+    expect(options.requestInit).toEqual({
+      headers: { Authorization: 'Bearer abc' },
+    });
+    expect(options.fetch).toBeUndefined();
+  });
+
+  it('should only accept TLS options for https URLs', () => {
+    expect(() =>
+      remoteTransportOptions({
+        url: 'http://localhost:8000/mcp',
+        tls: { rejectUnauthorized: false },
+      })
+    ).toThrow(
+      'TLS options need an https:// URL, got http://localhost:8000/mcp'
+    );
+
+    const options = remoteTransportOptions({
+      url: 'https://localhost:8443/mcp',
+      tls: { rejectUnauthorized: false },
+    });
+    expect(options.fetch).toBeTypeOf('function');
+  });
+});
@@ -0,0 +1,94 @@
+// Tests for @idempotent calls
+import { describe, it, expect, vi } from 'vitest';
+import { IdempotentCalls, MemoryIdempotencyStore } from '../idempotency.js';
+import { executeInVM } from '../vm-executor.js';
+
+const now = () => new Date('2026-01-01T00:00:00Z');
+
+describe('IdempotentCalls', () => {
+  it('should skip calls whose key completed before', async () => {
+    const store = new MemoryIdempotencyStore();
+    const post = vi.fn(async () => ({ id: 42 }));
+    const log = vi.fn();
+
+    const first = new IdempotentCalls(store, log, now);
+    await expect(first.run('post-1', 'slack.post', post)).resolves.toEqual({
+      id: 42,
+    });
+
+    // A later run finds the record
+    const again = new IdempotentCalls(store, log, now);
+    await expect(again.run('post-1', 'slack.post', post)).resolves.toEqual({
+      id: 42,
+    });
+    await again.run(2, 'slack.post', post);
+    expect(post).toHaveBeenCalledTimes(2);
+    expect(log).toHaveBeenCalledWith(
+      'slack.post: skipped, already done with key "post-1" at 2026-01-01T00:00:00.000Z'
+    );
+  });
+
+  it('should make failed calls again', async () => {
+    const calls = new IdempotentCalls();
+    const post = vi
+      .fn()
+      .mockRejectedValueOnce(new Error('down'))
+      .mockResolvedValueOnce('sent');
+
+    await expect(calls.run('k', 'slack.post', post)).rejects.toThrow('down');
+    await expect(calls.run('k', 'slack.post', post)).resolves.toBe('sent');
+    await expect(calls.run('k', 'slack.post', post)).resolves.toBe('sent');
+    expect(post).toHaveBeenCalledTimes(2);
+  });
+
+  it('should make concurrent calls with one key once', async () => {
+    const calls = new IdempotentCalls();
+    const post = vi.fn(async () => 'sent');
+    const results = await Promise.all([
+      calls.run('k', 'slack.post', post),
+      calls.run('k', 'slack.post', post),
+    ]);
+    expect(results).toEqual(['sent', 'sent']);
+    expect(post).toHaveBeenCalledTimes(1);
+  });
+
+  it('should record calls whose result cannot be saved', async () => {
+    const store = new MemoryIdempotencyStore();
+    const log = vi.fn();
+    const calls = new IdempotentCalls(store, log, now);
+    await calls.run('k', 'make', async () => () => 1);
+
+    expect(await store.get('k')).toEqual({ at: '2026-01-01T00:00:00.000Z' });
+    expect(log).toHaveBeenCalledWith(
+      'make: result not recorded, result is a function'
+    );
+  });
+
+  it('should reject keys that are not strings or numbers', async () => {
+    const calls = new IdempotentCalls();
+    await expect(calls.run(null, 'slack.post', async () => 1)).rejects.toThrow(
+      '@idempotent slack.post: key must be a non-empty string or a number, got null'
+    );
+    await expect(calls.run('', 'slack.post', async () => 1)).rejects.toThrow(
+      'got string'
+    );
+  });
+
+  it('should be available to generated code', async () => {
+    const store = new MemoryIdempotencyStore();
+    const code = `
+      let count = 0;
+      const post = async () => ++count;
+      let first = await __idempotent("a", "post", async () => await post());
+      let second = await __idempotent("a", "post", async () => await post());
+    `;
+    const context = await executeInVM(code, { idempotency: store });
+    expect(context.first).toBe(1);
+    expect(context.second).toBe(1);
+
+    // Running the script again skips the call
+    const rerun = await executeInVM(code, { idempotency: store });
+    expect(rerun.count).toBe(0);
+    expect(rerun.first).toBe(1);
+  });
+});
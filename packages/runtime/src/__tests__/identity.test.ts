@@ -0,0 +1,46 @@
+// Tests for the caller a run works for
+import { describe, it, expect, vi } from 'vitest';
+import { executeInVM } from '../vm-executor.js';
+import { currentCaller, runAsCaller } from '../identity.js';
+
+const SCRIPT = 'print(JSON.stringify(caller()));';
+
+describe('caller identity', () => {
+  it('should tell scripts the caller their run works for', async () => {
+    const addMessage = vi.fn();
+    await executeInVM(SCRIPT, {
+      addMessage,
+      caller: { subject: 'alice', tenant: 'ci' },
+    });
+    await executeInVM(SCRIPT, { addMessage });
+
+    expect(addMessage.mock.calls.map(([message]) => message.body)).toEqual([
+      '{"subject":"alice","tenant":"ci"}',
+      'null',
+    ]);
+  });
+
+  it('should refuse callers the role does not allow', async () => {
+    await expect(
+      executeInVM(SCRIPT, {
+        profile: { role: 'operator', callers: ['*@ops.example'] },
+        caller: { subject: 'mallory@example.com' },
+      })
+    ).rejects.toThrow(
+      'Caller mallory@example.com may not run in role operator'
+    );
+  });
+
+  it('should keep the caller of concurrent code apart', async () => {
+    const seen = await Promise.all(
+      ['alice', 'bob'].map(subject =>
+        runAsCaller({ subject }, async () => {
+          await new Promise(resolve => setTimeout(resolve, 1));
+          return currentCaller()?.subject;
+        })
+      )
+    );
+    expect(seen).toEqual(['alice', 'bob']);
+    expect(currentCaller()).toBeUndefined();
+  });
+});
@@ -0,0 +1,123 @@
+import {
+  describe,
+  it,
+  expect,
+  vi,
+  beforeEach,
+  afterEach,
+  type MockInstance,
+} from 'vitest';
+import { createInspect, formatInspect } from '../inspect.js';
+
+describe('inspect', () => {
+  describe('formatInspect', () => {
+    it('should annotate primitive values with their type', () => {
+      expect(formatInspect('hi')).toBe('string "hi"');
+      expect(formatInspect(42)).toBe('number 42');
+      expect(formatInspect(true)).toBe('boolean true');
+      expect(formatInspect(null)).toBe('null');
+      expect(formatInspect(undefined)).toBe('undefined');
+    });
+
+    it('should render nested structures as an indented tree', () => {
+      const value = { name: 'Bob', tags: ['a', 'b'], meta: {} };
+      expect(formatInspect(value)).toBe(
+        [
+          'object {',
+          '  name: string "Bob"',
+          '  tags: array(2) [',
+          '    [0] string "a"',
+          '    [1] string "b"',
+          '  ]',
+          '  meta: object {}',
+          '}',
+        ].join('\n')
+      );
+    });
+
+    it('should render Maps and Sets', () => {
+      const map = new Map([['key', new Set([1])]]);
+      expect(formatInspect(map)).toBe(
+        [
+          'Map(1) {',
+          '  "key" => Set(1) [',
+          '    number 1',
+          '  ]',
+          '}',
+        ].join('\n')
+      );
+    });
+
+    it('should truncate long strings', () => {
+      expect(formatInspect('abcdef', { maxStringLength: 3 })).toBe(
+        'string "abc"… (3 more chars)'
+      );
+    });
+
+    it('should truncate long collections', () => {
+      expect(formatInspect([1, 2, 3], { maxItems: 2 })).toBe(
+        [
+          'array(3) [',
+          '  [0] number 1',
+          '  [1] number 2',
+          '  … 1 more',
+          ']',
+        ].join('\n')
+      );
+    });
+
+    it('should collapse values nested beyond the depth limit', () => {
+      expect(formatInspect({ a: { b: [1] } }, { maxDepth: 1 })).toBe(
+        ['object {', '  a: object {…}', '}'].join('\n')
+      );
+    });
+
+    it('should mark circular references', () => {
+      const value: Record<string, unknown> = {};
+      value.self = value;
+      expect(formatInspect(value)).toBe(
+        ['object {', '  self: [Circular]', '}'].join('\n')
+      );
+    });
+
+    it('should render dates and errors', () => {
+      expect(formatInspect(new Date(0))).toBe('Date 1970-01-01T00:00:00.000Z');
+      expect(formatInspect(new TypeError('bad'))).toBe('TypeError "bad"');
+    });
+  });
+
+  describe('createInspect', () => {
+    let consoleLogSpy: MockInstance;
+
+    beforeEach(() => {
+      consoleLogSpy = vi.spyOn(console, 'log').mockImplementation(() => {});
+    });
+
+    afterEach(() => {
+      consoleLogSpy.mockRestore();
+    });
+
+    it('should return the inspected value', () => {
+      const inspect = createInspect();
+      const value = { a: 1 };
+      expect(inspect(value)).toBe(value);
+    });
+
+    it('should print to the console without a handler', () => {
+      const inspect = createInspect();
+      inspect(1, 'count');
+      expect(consoleLogSpy).toHaveBeenCalledWith('count: number 1');
+    });
+
+    it('should send output to the run log handler', () => {
+      const addMessage = vi.fn();
+      const inspect = createInspect(addMessage);
+      inspect([1]);
+      expect(addMessage).toHaveBeenCalledWith({
+        title: 'inspect',
+        body: 'array(1) [\n  [0] number 1\n]',
+      });
+      expect(consoleLogSpy).not.toHaveBeenCalled();
+    });
+  });
+});
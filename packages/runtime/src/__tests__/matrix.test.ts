@@ -0,0 +1,106 @@
+import { describe, it, expect } from 'vitest';
+import {
+  aggregateMatrix,
+  matrixCombinations,
+  MatrixError,
+  runMatrix,
+  type MatrixRun,
+} from '../matrix.js';
+import { executeInVM } from '../vm-executor.js';
+import { RunOutcome } from '../outcome.js';
+
+function delay(ms: number): Promise<void> {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+describe('matrixCombinations', () => {
+  it('should vary the last parameter fastest', () => {
+    expect(
+      matrixCombinations({
+        model: ['gpt-4o', 'claude'],
+        temperature: [0, 0.7],
+        prompt: 'v2',
+      })
+    ).toEqual([
+      { model: 'gpt-4o', temperature: 0, prompt: 'v2' },
+      { model: 'gpt-4o', temperature: 0.7, prompt: 'v2' },
+      { model: 'claude', temperature: 0, prompt: 'v2' },
+      { model: 'claude', temperature: 0.7, prompt: 'v2' },
+    ]);
+  });
+
+  it('should reject matrices without runs', () => {
+    expect(() => matrixCombinations({ model: [] })).toThrow(
+      'Parameter model of the matrix has no values'
+    );
+    expect(() => matrixCombinations([1, 2] as never)).toThrow(MatrixError);
+  });
+});
+
+describe('runMatrix', () => {
+  it('should run at most parallel combinations at once', async () => {
+    let running = 0;
+    let most = 0;
+    const finished: number[] = [];
+    const runs = await runMatrix(
+      { n: [1, 2, 3, 4, 5] },
+      async ({ n }) => {
+        running++;
+        most = Math.max(most, running);
+        await delay(5);
+        running--;
+        return (n as number) * 10;
+      },
+      { parallel: 2, onRun: (_, index) => finished.push(index) }
+    );
+    expect(most).toBe(2);
+    expect(runs.map(run => run.result)).toEqual([10, 20, 30, 40, 50]);
+    expect(finished.sort()).toEqual([0, 1, 2, 3, 4]);
+  });
+
+  it('should record failed runs and keep going', async () => {
+    const runs = await runMatrix({ n: [1, 2, 3] }, async ({ n }) => {
+      if (n === 2) {
+        throw new Error('Rate limited');
+      }
+      return n;
+    });
+    expect(runs.map(({ status, error }) => [status, error])).toEqual([
+      ['ok', undefined],
+      ['failed', 'Rate limited'],
+      ['ok', undefined],
+    ]);
+  });
+});
+
+describe('aggregateMatrix', () => {
+  it('should sum up the runs of each value of the parameters varied', () => {
+    const run = (
+      model: string,
+      durationMs: number,
+      status: MatrixRun['status'] = 'ok'
+    ): MatrixRun => ({ args: { model, prompt: 'v2' }, status, durationMs });
+    expect(
+      aggregateMatrix([
+        run('gpt-4o', 100),
+        run('gpt-4o', 300, 'failed'),
+        run('claude', 50),
+      ])
+    ).toEqual([
+      { parameter: 'model', value: 'gpt-4o', runs: 2, failed: 1, meanMs: 200 },
+      { parameter: 'model', value: 'claude', runs: 1, failed: 0, meanMs: 50 },
+    ]);
+  });
+});
+
+describe('main results', () => {
+  it("should keep what main returned in the run's outcome", async () => {
+    const outcome = new RunOutcome();
+    await executeInVM(
+      `const main = __createUserTool("main", ["n"], async (n) => n * 2);
+await __main(main);`,
+      { args: { n: 21 }, outcome }
+    );
+    expect(outcome.result).toBe(42);
+  });
+});
@@ -1,6 +1,8 @@
 // Test for MCP client with roots support
 import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
 import { MCPClient, mcp } from '../mcp-client.js';
+import { MCPServerManager } from '../mcp.js';
+import { CALLER_META_KEY, runAsCaller } from '../identity.js';
 import { Client } from '@modelcontextprotocol/sdk/client/index.js';
 import { StdioClientTransport } from '@modelcontextprotocol/sdk/client/stdio.js';
 import { SSEClientTransport } from '@modelcontextprotocol/sdk/client/sse.js';
@@ -117,6 +119,54 @@ describe('MCPClient', () => {
       );
     });
 
+    it('should pass headers and reconnection options to remote transports', () => {
+      new MCPClient({
+        url: 'https://mcp.example.com/mcp',
+        headers: { Authorization: 'Bearer abc' },
+        reconnect: { maxRetries: 5 },
+      });
+      new MCPClient({ url: 'https://mcp.example.com/sse', transport: 'sse' });
+
+      expect(StreamableHTTPClientTransport).toHaveBeenCalledWith(
+        new URL('https://mcp.example.com/mcp'),
+        expect.objectContaining({
+          requestInit: { headers: { Authorization: 'Bearer abc' } },
+          reconnectionOptions: expect.objectContaining({ maxRetries: 5 }),
+        })
+      );
+      expect(SSEClientTransport).toHaveBeenCalledWith(
+        new URL('https://mcp.example.com/sse'),
+        expect.objectContaining({ transport: 'sse' })
+      );
+    });
+
+    it('should use the isolated transport when isolation options are set', () => {
+      const client = new MCPClient({
+        command: 'test-command',
+        args: [],
+        inheritEnv: false,
+        stderr: 'capture',
+      });
+
+      expect(StdioClientTransport).not.toHaveBeenCalled();
+      expect(client.stderrOutput).toBeDefined();
+    });
+
+    it('should run npx through cmd.exe on Windows', () => {
+      const platform = Object.getOwnPropertyDescriptor(process, 'platform');
+      Object.defineProperty(process, 'platform', { value: 'win32' });
+      try {
+        new MCPClient({ command: 'npx', args: ['-y', 'server'] });
+      } finally {
+        Object.defineProperty(process, 'platform', platform!);
+      }
+
+      expect(StdioClientTransport).toHaveBeenCalledWith({
+        command: expect.stringMatching(/cmd(\.exe)?$/i),
+        args: ['/d', '/c', 'npx', '-y', 'server'],
+      });
+    });
+
     it('should use custom client name when provided', () => {
       new MCPClient({
         command: 'test',
@@ -330,12 +380,34 @@ describe('MCPClient', () => {
 
       const result = await tool.call({ input: 'test input' });
 
-      expect(mockClient.callTool).toHaveBeenCalledWith({
-        name: 'test_tool',
-        arguments: { input: 'test input' },
-      });
+      expect(mockClient.callTool).toHaveBeenCalledWith(
+        { name: 'test_tool', arguments: { input: 'test input' } },
+        undefined,
+        { signal: undefined }
+      );
       expect(result).toEqual(mockResult);
     });
+
+    it('should pass the caller a call is made for in its metadata', async () => {
+      mockClient.listTools.mockResolvedValue({
+        tools: [{ name: 'search', inputSchema: { type: 'object' } }],
+      });
+      const client = new MCPClient({ command: 'test', args: [] });
+      const [tool] = await client.tools();
+      const caller = { subject: 'alice', tenant: 'acme' };
+
+      await runAsCaller(caller, async () => tool.call({ q: 'x' }));
+
+      expect(mockClient.callTool).toHaveBeenCalledWith(
+        {
+          name: 'search',
+          arguments: { q: 'x' },
+          _meta: { [CALLER_META_KEY]: caller },
+        },
+        undefined,
+        { signal: undefined }
+      );
+    });
   });
 
   describe('Cleanup', () => {
@@ -362,6 +434,61 @@ describe('MCPClient', () => {
       expect(mockClient.close).toHaveBeenCalledTimes(1);
       expect(mockTransport.close).toHaveBeenCalledTimes(1);
     });
+
+    it('should only shut down once when cleanup is called repeatedly', async () => {
+      const client = new MCPClient({
+        command: 'test',
+        args: [],
+      });
+
+      await client.cleanup();
+      await client.cleanup();
+
+      expect(mockClient.close).toHaveBeenCalledTimes(1);
+      expect(client.isClosed).toBe(true);
+    });
+
+    it('should refuse to connect after cleanup', async () => {
+      const client = new MCPClient({
+        command: 'test',
+        args: [],
+        serverName: 'filesystem',
+      });
+
+      await client.cleanup();
+
+      await expect(client.connectToServer()).rejects.toThrow(
+        'MCP server "filesystem" has been shut down'
+      );
+      expect(mockClient.connect).not.toHaveBeenCalled();
+    });
+  });
+
+  describe('Connection', () => {
+    it('should include the server name in connection errors', async () => {
+      mockClient.connect.mockRejectedValue(new Error('spawn test ENOENT'));
+
+      const client = new MCPClient({
+        command: 'test',
+        args: [],
+        serverName: 'filesystem',
+      });
+
+      await expect(client.connectToServer()).rejects.toThrow(
+        'Failed to connect to MCP server "filesystem": spawn test ENOENT'
+      );
+    });
+
+    it('should share a single connection attempt between callers', async () => {
+      const client = new MCPClient({
+        command: 'test',
+        args: [],
+      });
+
+      await Promise.all([client.connectToServer(), client.connectToServer()]);
+
+      expect(mockClient.connect).toHaveBeenCalledTimes(1);
+    });
   });
 
   describe('mcp() factory function', () => {
@@ -374,4 +501,46 @@ describe('MCPClient', () => {
       expect(client).toBeInstanceOf(MCPClient);
     });
   });
+
+  describe('MCPServerManager', () => {
+    it('should track clients by server name', () => {
+      const manager = new MCPServerManager();
+      const client = manager.connect({
+        command: 'test',
+        args: [],
+        serverName: 'filesystem',
+      });
+
+      expect(client).toBeInstanceOf(MCPClient);
+      expect(manager.getServer('filesystem')).toBe(client);
+      expect(manager.size).toBe(1);
+    });
+
+    it('should shut down all tracked clients', async () => {
+      const manager = new MCPServerManager();
+      const first = manager.connect({ command: 'a', args: [] });
+      const second = manager.connect({ command: 'b', args: [] });
+
+      await manager.closeAll();
+
+      expect(first.isClosed).toBe(true);
+      expect(second.isClosed).toBe(true);
+      expect(manager.size).toBe(0);
+    });
+
+    it('should stop remaining servers when one fails to shut down', async () => {
+      mockClient.close
+        .mockRejectedValueOnce(new Error('broken pipe'))
+        .mockResolvedValue(undefined);
+
+      const manager = new MCPServerManager();
+      manager.connect({ command: 'a', args: [] });
+      const second = manager.connect({ command: 'b', args: [] });
+
+      await expect(manager.closeAll()).rejects.toThrow(
+        'Failed to shut down 1 MCP server(s): broken pipe'
+      );
+      expect(second.isClosed).toBe(true);
+    });
+  });
 });
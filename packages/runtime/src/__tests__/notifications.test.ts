@@ -0,0 +1,117 @@
+// Tests for notification handlers and listen()
+import { describe, it, expect, vi } from 'vitest';
+import { z } from 'zod';
+import {
+  ServerNotifications,
+  type NotificationSource,
+} from '../notifications.js';
+import type { ServerNotification } from '../mcp-client.js';
+
+/**
+ * A server whose notifications the test sends
+ */
+function fakeServer() {
+  const handlers = new Map<
+    ServerNotification,
+    (params: Record<string, unknown>) => void
+  >();
+  const server: NotificationSource & {
+    notify(notification: ServerNotification, params?: object): void;
+  } = {
+    onNotification: (notification, handler) => {
+      handlers.set(notification, handler);
+    },
+    subscribeResource: vi.fn(async () => {}),
+    notify: (notification, params = {}) =>
+      handlers.get(notification)?.({ ...params }),
+  };
+  return server;
+}
+
+describe('ServerNotifications', () => {
+  it('should listen until the condition holds', async () => {
+    const server = fakeServer();
+    const notifications = new ServerNotifications();
+    let count = 0;
+    await notifications.on(server, 'fs', 'tools/list_changed', async () => {
+      count++;
+    });
+
+    const listening = notifications.listen(() => count >= 2);
+    server.notify('tools/list_changed');
+    server.notify('tools/list_changed');
+    await listening;
+    expect(count).toBe(2);
+    // A condition that already holds ends listening at once
+    await notifications.listen(() => count >= 2);
+  });
+
+  it('should stop listening when the timeout passes', async () => {
+    vi.useFakeTimers();
+    try {
+      const notifications = new ServerNotifications();
+      const listening = notifications.listen(() => false, 1000);
+      await vi.advanceTimersByTimeAsync(1000);
+      await expect(listening).resolves.toBeUndefined();
+      await expect(notifications.listen(undefined, -1)).rejects.toThrow(
+        'listen: timeout must be a non-negative number of milliseconds, got number'
+      );
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+
+  it('should subscribe to resources and only handle their updates', async () => {
+    const server = fakeServer();
+    const notifications = new ServerNotifications();
+    const updated: unknown[] = [];
+    await notifications.on(
+      server,
+      'fs',
+      'resources/updated',
+      async params => updated.push(params),
+      z.object({ uri: z.string() }),
+      'file:///a.csv'
+    );
+
+    expect(server.subscribeResource).toHaveBeenCalledWith('file:///a.csv');
+    const listening = notifications.listen(() => updated.length > 0);
+    server.notify('resources/updated', { uri: 'file:///b.csv' });
+    server.notify('resources/updated', { uri: 'file:///a.csv' });
+    await listening;
+    expect(updated).toEqual([{ uri: 'file:///a.csv' }]);
+
+    await expect(
+      notifications.on(server, 'fs', 'message', async () => {}, undefined, 'x')
+    ).rejects.toThrow(
+      'Only resources/updated notifications take a resource URI, got one for message'
+    );
+    await expect(
+      notifications.on(server, 'fs', 'tools/changed', async () => {})
+    ).rejects.toThrow('Unknown notification: tools/changed');
+  });
+
+  it('should end listening with the error of a failed handler', async () => {
+    const server = fakeServer();
+    const notifications = new ServerNotifications();
+    await notifications.on(
+      server,
+      'fs',
+      'message',
+      async () => {},
+      z.object({ level: z.string() })
+    );
+
+    const listening = notifications.listen();
+    server.notify('message', { level: 3 });
+    await expect(listening).rejects.toThrow(
+      "Notification message of fs: parameters do not match the handler's type: level: Expected string, received number"
+    );
+
+    // Failures while nothing listens are reported by the next listen
+    server.notify('message', {});
+    await expect(notifications.listen(() => true)).rejects.toThrow(
+      'level: Required'
+    );
+  });
+});
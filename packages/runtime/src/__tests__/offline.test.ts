@@ -0,0 +1,85 @@
+// Tests for offline mode
+import { describe, it, expect } from 'vitest';
+import {
+  OfflineError,
+  checkOffline,
+  isLoopbackURL,
+  modelEndpoint,
+} from '../offline.js';
+import { MockServers } from '../testing.js';
+import { createTracer } from '../tracing.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('offline checks', () => {
+  it('should only allow urls on this machine', () => {
+    expect(isLoopbackURL('http://localhost:8080/mcp')).toBe(true);
+    expect(isLoopbackURL('http://127.0.0.1:11434/v1')).toBe(true);
+    expect(isLoopbackURL('http://[::1]:3000')).toBe(true);
+    expect(isLoopbackURL('https://api.openai.com/v1')).toBe(false);
+    expect(isLoopbackURL('http://localhost.example.com')).toBe(false);
+    expect(isLoopbackURL('not a url')).toBe(false);
+  });
+
+  it('should take models without a baseURL to be hosted', () => {
+    expect(modelEndpoint({ provider: 'openai' })).toBeUndefined();
+    expect(modelEndpoint({ provider: 'Local' })).toBe(
+      'http://localhost:11434/v1'
+    );
+    expect(
+      modelEndpoint({ provider: 'openai', baseURL: 'http://127.0.0.1:8000' })
+    ).toBe('http://127.0.0.1:8000');
+    expect(() => checkOffline('model provider openai')).toThrow(
+      'Offline mode does not allow model provider openai, which needs the network'
+    );
+  });
+
+  it('should refuse collectors elsewhere', () => {
+    expect(() =>
+      createTracer({
+        exporter: 'otlp',
+        endpoint: 'https://otel.example.com',
+        offline: true,
+      })
+    ).toThrow(OfflineError);
+    expect(() =>
+      createTracer({ exporter: 'console', offline: true })
+    ).not.toThrow();
+  });
+});
+
+describe('executeInVM offline', () => {
+  it('should refuse servers declared with a url', async () => {
+    await expect(
+      executeInVM(
+        `
+        const __search_server = __llamaindex_mcp({ serverName: "search", url: "https://search.example.com/mcp" });
+        `,
+        { mocks: new MockServers({ search: [] }), offline: true }
+      )
+    ).rejects.toThrow(
+      'Offline mode does not allow server search, which needs the network (https://search.example.com/mcp)'
+    );
+  });
+
+  it('should refuse hosted models but not local ones', async () => {
+    await expect(
+      executeInVM(`const gpt = __createModel({ provider: "openai" });`, {
+        offline: true,
+      })
+    ).rejects.toThrow('Offline mode does not allow model provider openai');
+    await executeInVM(`const llama = __createModel({ provider: "local" });`, {
+      offline: true,
+    });
+  });
+
+  it('should refuse a hosted embedding model before the script starts', async () => {
+    await expect(
+      executeInVM(`print("never")`, {
+        offline: true,
+        embeddings: { provider: 'openai', model: 'text-embedding-3-small' },
+      })
+    ).rejects.toThrow(
+      'Offline mode does not allow embedding provider openai, which needs the network'
+    );
+  });
+});
@@ -0,0 +1,60 @@
+// Tests for the issues recorded in a run's outcome
+import { describe, it, expect, vi } from 'vitest';
+import { createToolProxy } from '../mcp.js';
+import { RunOutcome } from '../outcome.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('RunOutcome', () => {
+  it('should note error results of tool calls', async () => {
+    const outcome = new RunOutcome();
+    const tool = {
+      metadata: { name: 'deploy', parameters: { type: 'object' } },
+      call: vi.fn(async () => ({
+        isError: true,
+        content: [{ type: 'text', text: 'quota exceeded' }],
+      })),
+    };
+    const k8s = createToolProxy(
+      [tool],
+      'k8s',
+      {},
+      undefined,
+      undefined,
+      undefined,
+      outcome
+    );
+
+    await expect(k8s.deploy({ service: 'api' })).resolves.toBe(
+      'quota exceeded'
+    );
+    expect(outcome.of('errorResult')).toEqual([
+      {
+        kind: 'errorResult',
+        message: 'k8s.deploy answered with an error: quota exceeded',
+      },
+    ]);
+  });
+
+  it('should note parallel maps whose items failed', async () => {
+    const outcome = new RunOutcome();
+    await executeInVM(
+      `
+let results = await __parallelMap([1, 2], async n => {
+  if (n === 2) throw new Error("bad item");
+  return n;
+}, undefined, "parallelMap(items)");
+`,
+      { outcome }
+    );
+
+    expect(outcome.issues).toEqual([
+      {
+        kind: 'partialFailure',
+        message:
+          'parallelMap(items): 1 of 2 items failed: items[1]: bad item',
+      },
+    ]);
+    outcome.clear();
+    expect(outcome.issues).toEqual([]);
+  });
+});
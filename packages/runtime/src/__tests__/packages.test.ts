@@ -0,0 +1,105 @@
+// Tests for the registry packages of stdio servers
+import { describe, it, expect } from 'vitest';
+import {
+  findServerPackage,
+  PackagePinError,
+  parsePackageSpecifier,
+  pinServerArgs,
+} from '../packages.js';
+import { MockServers } from '../testing.js';
+import { executeInVM } from '../vm-executor.js';
+
+const FILESYSTEM = {
+  ecosystem: 'npm' as const,
+  name: '@modelcontextprotocol/server-filesystem',
+  version: '0.6.2',
+  integrity: 'sha512-abc',
+};
+
+describe('parsePackageSpecifier', () => {
+  it('should only take exact versions as pinned', () => {
+    expect(parsePackageSpecifier('npm', '@scope/server')).toEqual({
+      name: '@scope/server',
+    });
+    expect(parsePackageSpecifier('npm', 'server@^1.2.0')).toEqual({
+      name: 'server',
+    });
+    expect(parsePackageSpecifier('npm', 'server@latest')).toEqual({
+      name: 'server',
+    });
+    expect(parsePackageSpecifier('PyPI', 'server[cli]>=1.0')).toEqual({
+      name: 'server',
+    });
+    expect(parsePackageSpecifier('PyPI', 'server@1.0.1')).toEqual({
+      name: 'server',
+      version: '1.0.1',
+    });
+  });
+});
+
+describe('findServerPackage', () => {
+  it('should find the package argument of runners', () => {
+    expect(
+      findServerPackage('npx', ['-y', '@scope/server@1.0.0', '/tmp'])
+    ).toEqual({
+      ecosystem: 'npm',
+      name: '@scope/server',
+      version: '1.0.0',
+      specifier: '@scope/server@1.0.0',
+      index: 1,
+    });
+    expect(
+      findServerPackage('uv', ['tool', 'run', '--from=mcp-git', 'git-mcp'])
+    ).toMatchObject({ name: 'mcp-git', index: 2, option: '--from=' });
+    expect(findServerPackage('npm', ['install', 'x'])).toBeUndefined();
+    expect(findServerPackage('node', ['server.js'])).toBeUndefined();
+  });
+});
+
+describe('pinServerArgs', () => {
+  it('should run the locked version', () => {
+    expect(
+      pinServerArgs(
+        'fs',
+        'npx',
+        ['-y', '@modelcontextprotocol/server-filesystem', '/tmp'],
+        FILESYSTEM
+      )
+    ).toEqual(['-y', '@modelcontextprotocol/server-filesystem@0.6.2', '/tmp']);
+    expect(
+      pinServerArgs('fetch', 'uvx', ['--from=mcp-server-fetch', 'fetch'], {
+        ecosystem: 'PyPI',
+        name: 'mcp-server-fetch',
+        version: '2025.4.7',
+      })
+    ).toEqual(['--from=mcp-server-fetch==2025.4.7', 'fetch']);
+  });
+
+  it('should refuse other packages and versions than the locked ones', () => {
+    expect(() =>
+      pinServerArgs(
+        'fs',
+        'npx',
+        ['-y', '@modelcontextprotocol/server-filesystem@0.7.0'],
+        FILESYSTEM
+      )
+    ).toThrow(
+      'Server fs runs @modelcontextprotocol/server-filesystem 0.7.0, but the lockfile pins 0.6.2; lock it again'
+    );
+    expect(() =>
+      pinServerArgs('fs', 'npx', ['-y', 'other-server'], FILESYSTEM)
+    ).toThrow(PackagePinError);
+  });
+
+  it('should check the servers a script starts', async () => {
+    await expect(
+      executeInVM(
+        `const __fs_server = __llamaindex_mcp({ serverName: "fs", command: "npx", args: ["-y", "@modelcontextprotocol/server-filesystem@0.7.0"] });`,
+        {
+          mocks: new MockServers({ fs: [] }),
+          packages: { fs: FILESYSTEM },
+        }
+      )
+    ).rejects.toThrow('but the lockfile pins 0.6.2');
+  });
+});
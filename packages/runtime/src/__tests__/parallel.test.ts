@@ -0,0 +1,176 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  createParallelMap,
+  currentBranchSignal,
+  parallelMap,
+  runParallel,
+  type MapProgress,
+} from '../parallel.js';
+
+function delay(ms: number): Promise<void> {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+describe('runParallel', () => {
+  it('should return results in branch order', async () => {
+    const results = await runParallel([
+      async () => {
+        await delay(20);
+        return 'slow';
+      },
+      async () => 'fast',
+    ]);
+    expect(results).toEqual(['slow', 'fast']);
+  });
+
+  it('should run at most limit branches at once', async () => {
+    let running = 0;
+    let most = 0;
+    const branch = async () => {
+      running++;
+      most = Math.max(most, running);
+      await delay(5);
+      running--;
+      return most;
+    };
+    await runParallel([branch, branch, branch, branch, branch], 2);
+    expect(most).toBe(2);
+  });
+
+  it('should reject limits that are not positive integers', async () => {
+    await expect(runParallel([async () => 1], 0)).rejects.toThrow(
+      'Parallel limit must be a positive integer, got 0'
+    );
+  });
+
+  it('should cancel the other branches when one fails', async () => {
+    const started: number[] = [];
+    let signal: AbortSignal | undefined;
+    const result = runParallel(
+      [
+        async () => {
+          started.push(0);
+          signal = currentBranchSignal();
+          await delay(20);
+        },
+        async () => {
+          started.push(1);
+          throw new Error('branch failed');
+        },
+        async () => {
+          started.push(2);
+        },
+      ],
+      2
+    );
+
+    await expect(result).rejects.toThrow('branch failed');
+    expect(started).toEqual([0, 1]);
+    expect(signal?.aborted).toBe(true);
+  });
+
+  it('should cancel nested blocks with the enclosing one', async () => {
+    let inner: AbortSignal | undefined;
+    const result = runParallel([
+      () =>
+        runParallel([
+          async () => {
+            inner = currentBranchSignal();
+            await delay(20);
+          },
+        ]),
+      async () => {
+        await delay(1);
+        throw new Error('outer failed');
+      },
+    ]);
+
+    await expect(result).rejects.toThrow('outer failed');
+    expect(inner?.aborted).toBe(true);
+  });
+
+  it('should have no branch signal outside parallel blocks', () => {
+    expect(currentBranchSignal()).toBeUndefined();
+  });
+});
+
+describe('parallelMap', () => {
+  it('should return results in item order', async () => {
+    const results = await parallelMap([20, 1, 5], async ms => {
+      await delay(ms);
+      return ms * 2;
+    });
+    expect([...results]).toEqual([40, 2, 10]);
+    expect(results.failures).toEqual([]);
+  });
+
+  it('should run at most limit items at once', async () => {
+    let running = 0;
+    let most = 0;
+    await parallelMap(
+      [1, 2, 3, 4, 5],
+      async () => {
+        running++;
+        most = Math.max(most, running);
+        await delay(5);
+        running--;
+      },
+      2
+    );
+    expect(most).toBe(2);
+  });
+
+  it('should keep going when items fail, and report them', async () => {
+    const progress: MapProgress[] = [];
+    const results = await parallelMap(
+      ['a', 'b', 'c'],
+      async item => {
+        if (item === 'b') {
+          throw new Error('no b');
+        }
+        return item.toUpperCase();
+      },
+      1,
+      p => progress.push(p)
+    );
+
+    expect([...results]).toEqual(['A', null, 'C']);
+    expect(results.failures).toEqual([
+      { index: 1, item: 'b', error: new Error('no b') },
+    ]);
+    expect(progress).toEqual([
+      { done: 1, failed: 0, total: 3 },
+      { done: 2, failed: 1, total: 3 },
+      { done: 3, failed: 1, total: 3 },
+    ]);
+  });
+
+  it('should warn of failed items under the label', async () => {
+    const warn = vi.fn();
+    const onProgress = vi.fn();
+    const map = createParallelMap(onProgress, warn);
+    await map(
+      [1, 2],
+      async n => {
+        throw new Error(`failed ${n}`);
+      },
+      undefined,
+      'parallelMap(ids)'
+    );
+
+    expect(warn).toHaveBeenCalledWith(
+      'parallelMap(ids): 2 of 2 items failed: items[0]: failed 1; items[1]: failed 2'
+    );
+    expect(onProgress).toHaveBeenLastCalledWith('parallelMap(ids)', {
+      done: 2,
+      failed: 2,
+      total: 2,
+    });
+  });
+
+  it('should reject items that are not a list', async () => {
+    await expect(
+      parallelMap('abc' as never, async item => item)
+    ).rejects.toThrow('parallelMap needs a list of items, got string');
+  });
+});
@@ -0,0 +1,125 @@
+import { describe, it, expect } from 'vitest';
+import { fanOut, fanOutEach, PipelineError, runPipeline } from '../pipeline.js';
+import { currentBranchSignal } from '../parallel.js';
+
+function delay(ms: number): Promise<void> {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+describe('runPipeline', () => {
+  it('should run steps once the steps they use have finished', async () => {
+    const started: string[] = [];
+    const step = (name: string, uses: string[], ms: number) => ({
+      name,
+      uses,
+      run: async (results: Record<string, unknown>) => {
+        started.push(name);
+        await delay(ms);
+        return [name, ...uses.map(use => results[use])].join('+');
+      },
+    });
+
+    const result = await runPipeline(
+      'report',
+      [
+        step('issues', [], 20),
+        step('owners', [], 5),
+        step('summary', ['issues', 'owners'], 1),
+      ],
+      async ({ summary }) => summary
+    );
+    expect(result).toBe('summary+issues+owners');
+    // Independent steps start together
+    expect(started).toEqual(['issues', 'owners', 'summary']);
+  });
+
+  it('should fail with the step that failed and skip its dependents', async () => {
+    const ran: string[] = [];
+    let cancelled = false;
+    const run = runPipeline(
+      'report',
+      [
+        {
+          name: 'issues',
+          uses: [],
+          run: async () => {
+            await delay(5);
+            throw new Error('Rate limited');
+          },
+        },
+        {
+          name: 'owners',
+          uses: [],
+          run: async () => {
+            await delay(50);
+            cancelled = currentBranchSignal()?.aborted ?? false;
+            return [];
+          },
+        },
+        {
+          name: 'summary',
+          uses: ['issues'],
+          run: async () => {
+            ran.push('summary');
+          },
+        },
+      ],
+      async () => null
+    );
+
+    const error = await run.catch(e => e);
+    expect(error).toBeInstanceOf(PipelineError);
+    expect(error.message).toBe(
+      'Pipeline report failed at step issues: Rate limited'
+    );
+    expect(error.step).toBe('issues');
+    expect(ran).toEqual([]);
+    expect(cancelled).toBe(true);
+  });
+
+  it('should reject steps using steps after them', async () => {
+    await expect(
+      runPipeline(
+        'report',
+        [{ name: 'summary', uses: ['issues'], run: async () => null }],
+        async () => null
+      )
+    ).rejects.toThrow(
+      'Step summary of pipeline report uses issues, which is not an earlier step'
+    );
+  });
+});
+
+describe('fanOut', () => {
+  it('should collect the results of the branches by name', async () => {
+    await expect(
+      fanOut({
+        chat: async () => {
+          await delay(10);
+          return 'posted';
+        },
+        mail: async () => 'sent',
+      })
+    ).resolves.toEqual({ chat: 'posted', mail: 'sent' });
+  });
+});
+
+describe('fanOutEach', () => {
+  it('should collect the results in item order', async () => {
+    const results = await fanOutEach(
+      [30, 10, 20],
+      async ms => {
+        await delay(ms);
+        return ms * 2;
+      },
+      2
+    );
+    expect(results).toEqual([60, 20, 40]);
+  });
+
+  it('should reject items that are not a list', async () => {
+    await expect(
+      fanOutEach('issues' as unknown as string[], async item => item)
+    ).rejects.toThrow('each needs a list of items, got string');
+  });
+});
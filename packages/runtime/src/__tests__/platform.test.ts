@@ -0,0 +1,71 @@
+import { describe, it, expect } from 'vitest';
+import {
+  killProcessTree,
+  resolveSpawnCommand,
+  rootUri,
+} from '../platform.js';
+
+describe('resolveSpawnCommand', () => {
+  const env = { ComSpec: 'C:\\Windows\\system32\\cmd.exe' };
+
+  it('should leave commands unchanged outside Windows', () => {
+    expect(resolveSpawnCommand('npx', ['-y', 'server'], 'linux', env)).toEqual({
+      command: 'npx',
+      args: ['-y', 'server'],
+    });
+  });
+
+  it('should run npx through cmd.exe on Windows', () => {
+    expect(resolveSpawnCommand('npx', ['-y', 'server'], 'win32', env)).toEqual({
+      command: 'C:\\Windows\\system32\\cmd.exe',
+      args: ['/d', '/c', 'npx', '-y', 'server'],
+    });
+  });
+
+  it('should run .cmd and .bat scripts through cmd.exe', () => {
+    expect(
+      resolveSpawnCommand('C:\\tools\\server.CMD', [], 'win32', env).args
+    ).toEqual(['/d', '/c', 'C:\\tools\\server.CMD']);
+    expect(resolveSpawnCommand('start.bat', [], 'win32', {})).toEqual({
+      command: 'cmd.exe',
+      args: ['/d', '/c', 'start.bat'],
+    });
+  });
+
+  it('should spawn executables directly', () => {
+    expect(resolveSpawnCommand('node', ['server.js'], 'win32', env)).toEqual({
+      command: 'node',
+      args: ['server.js'],
+    });
+    expect(resolveSpawnCommand('uvx.exe', ['mcp'], 'win32', env)).toEqual({
+      command: 'uvx.exe',
+      args: ['mcp'],
+    });
+  });
+});
+
+describe('rootUri', () => {
+  it('should convert POSIX paths', () => {
+    expect(rootUri('/home/ada/my project', 'linux')).toBe(
+      'file:///home/ada/my%20project'
+    );
+  });
+
+  it('should convert Windows drive paths', () => {
+    expect(rootUri('C:\\Users\\Ada\\my project', 'win32')).toBe(
+      'file:///C:/Users/Ada/my%20project'
+    );
+  });
+
+  it('should convert Windows UNC paths', () => {
+    expect(rootUri('\\\\server\\share\\docs#1', 'win32')).toBe(
+      'file://server/share/docs%231'
+    );
+  });
+});
+
+describe('killProcessTree', () => {
+  it('should do nothing outside Windows', async () => {
+    await expect(killProcessTree(123456, 'linux')).resolves.toBeUndefined();
+  });
+});
@@ -0,0 +1,183 @@
+import { describe, it, expect } from 'vitest';
+import {
+  checkSandboxPolicy,
+  policyViolations,
+  PolicyViolationError,
+  SandboxGate,
+  serverViolation,
+} from '../policy.js';
+import { ToolCallGate } from '../profile.js';
+import { MockServers } from '../testing.js';
+import { executeInVM } from '../vm-executor.js';
+
+const READ_SCHEMA = {
+  name: 'read',
+  inputSchema: {
+    type: 'object',
+    properties: { path: { type: 'string' } },
+  },
+};
+
+describe('serverViolation', () => {
+  it('should check names, commands and hosts', () => {
+    const policy = {
+      allowServers: ['fs', 'search'],
+      allowCommands: ['npx'],
+      allowHosts: ['*.example.com'],
+    };
+
+    expect(serverViolation(policy, { name: 'fs', command: 'npx' })).toBe(
+      undefined
+    );
+    expect(serverViolation(policy, { name: 'shell', command: 'npx' })).toEqual(
+      {
+        rule: 'allowServers',
+        subject: 'shell',
+        message: 'Policy does not allow server shell',
+      }
+    );
+    expect(serverViolation(policy, { name: 'fs', command: 'bash' })).toEqual({
+      rule: 'allowCommands',
+      subject: 'bash',
+      message: 'Policy does not allow server fs to run bash',
+    });
+    expect(
+      serverViolation(policy, {
+        name: 'search',
+        url: 'https://mcp.example.com/sse',
+      })
+    ).toBe(undefined);
+    expect(
+      serverViolation(policy, { name: 'search', url: 'https://evil.test/' })
+    ).toMatchObject({ rule: 'allowHosts', subject: 'evil.test' });
+  });
+
+  it('should refuse remote servers without network access', () => {
+    expect(
+      serverViolation(
+        { allowNetwork: false },
+        { name: 'search', url: 'https://mcp.example.com' }
+      )
+    ).toMatchObject({ rule: 'allowNetwork' });
+    expect(
+      serverViolation({ allowNetwork: false }, { name: 'fs', command: 'npx' })
+    ).toBe(undefined);
+  });
+});
+
+describe('SandboxGate', () => {
+  it('should limit the server processes of a run', () => {
+    const gate = new SandboxGate({ maxProcesses: 1 });
+
+    gate.admitServer({ name: 'remote', url: 'https://mcp.example.com' });
+    gate.admitServer({ name: 'fs', command: 'npx' });
+    expect(() => gate.admitServer({ name: 'git', command: 'npx' })).toThrow(
+      'Policy allows at most 1 server processes per run'
+    );
+  });
+
+  it('should refuse tools before the profile sees them', async () => {
+    const profile = new ToolCallGate({ role: 'reader', maxToolCalls: 1 });
+    const gate = new SandboxGate({ allowTools: ['fs.read*'] }, profile);
+
+    const error = await gate.admit('fs.writeFile', {}).catch(e => e);
+    expect(error).toBeInstanceOf(PolicyViolationError);
+    expect(JSON.parse(JSON.stringify(error))).toEqual({
+      error: 'PolicyViolationError',
+      rule: 'allowTools',
+      subject: 'fs.writeFile',
+      message: 'Policy does not allow calling fs.writeFile',
+    });
+    // The refused call did not use up the profile's budget
+    await gate.admit('fs.readFile', {});
+    await expect(gate.admit('fs.readFile', {})).rejects.toThrow(
+      'Role reader may make at most 1 tool calls per run'
+    );
+  });
+});
+
+describe('policyViolations', () => {
+  it('should report what a script declares against the policy', () => {
+    const violations = policyViolations(
+      { allowCommands: ['npx'], allowTools: ['fs.*'], maxProcesses: 1 },
+      {
+        servers: [
+          { name: 'fs', command: 'npx' },
+          { name: 'shell', command: 'bash' },
+          { name: 'custom', command: '${SERVER}' },
+        ],
+        tools: [
+          { server: 'fs', tool: 'read' },
+          { server: 'shell', tool: 'run' },
+          { server: 'shell', tool: '*' },
+        ],
+      }
+    );
+    expect(violations.map(v => [v.rule, v.subject])).toEqual([
+      ['allowCommands', 'bash'],
+      ['maxProcesses', '1'],
+      ['allowTools', 'shell.run'],
+    ]);
+  });
+});
+
+describe('checkSandboxPolicy', () => {
+  it('should reject malformed policies', () => {
+    expect(() => checkSandboxPolicy([])).toThrow(
+      'Sandbox policy must be an object'
+    );
+    expect(() => checkSandboxPolicy({ allowTools: 'fs.*' })).toThrow(
+      '"allowTools" must be a list of strings'
+    );
+    expect(() => checkSandboxPolicy({ maxRunTime: -1 })).toThrow(
+      '"maxRunTime" must be a non-negative integer'
+    );
+    expect(() => checkSandboxPolicy({ allowNetwork: 'no' })).toThrow(
+      '"allowNetwork" must be true or false'
+    );
+    expect(() => checkSandboxPolicy({ maxTools: 1 })).toThrow(
+      'Unknown sandbox policy field "maxTools"'
+    );
+  });
+});
+
+describe('executeInVM with a policy', () => {
+  it('should refuse servers the policy does not allow', async () => {
+    await expect(
+      executeInVM(
+        `
+        const __shell_server = __llamaindex_mcp({ serverName: "shell", command: "bash" });
+        `,
+        {
+          mocks: new MockServers({ shell: [READ_SCHEMA] }),
+          policy: { allowCommands: ['npx'] },
+        }
+      )
+    ).rejects.toThrow('Policy does not allow server shell to run bash');
+  });
+
+  it('should refuse tool calls the policy does not allow', async () => {
+    await expect(
+      executeInVM(
+        `
+        const __fs_server = __llamaindex_mcp({ serverName: "fs", command: "npx" });
+        const fs = __createToolProxy(await __fs_server.tools(), "fs");
+        mock("fs.read", "hello");
+        await fs.read("a.txt");
+        `,
+        {
+          mocks: new MockServers({ fs: [READ_SCHEMA] }),
+          policy: { allowTools: ['fs.list'] },
+        }
+      )
+    ).rejects.toThrow('Policy does not allow calling fs.read');
+  });
+
+  it('should end runs that take longer than allowed', async () => {
+    await expect(
+      executeInVM(`await new Promise(resolve => setTimeout(resolve, 1000));`, {
+        policy: { maxRunTime: 20 },
+      })
+    ).rejects.toThrow('Policy allows runs of at most 20ms');
+  });
+});
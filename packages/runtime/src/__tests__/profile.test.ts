@@ -0,0 +1,184 @@
+import { describe, it, expect } from 'vitest';
+import {
+  admitCaller,
+  CallerPermissionError,
+  matchesApprovedCall,
+  matchesToolPattern,
+  ToolCallGate,
+  ToolPermissionError,
+} from '../profile.js';
+import { createToolProxy } from '../mcp.js';
+
+function tool(name: string, calls: unknown[]) {
+  return {
+    metadata: {
+      name,
+      parameters: {
+        type: 'object',
+        properties: { path: { type: 'string' } },
+      },
+    },
+    call: async (input: unknown) => {
+      calls.push(input);
+      return { content: [{ type: 'text', text: 'ok' }] };
+    },
+  };
+}
+
+describe('matchesToolPattern', () => {
+  it('should match names with wildcards', () => {
+    expect(matchesToolPattern('fs.readFile', 'fs.readFile')).toBe(true);
+    expect(matchesToolPattern('fs.readFile', 'fs.*')).toBe(true);
+    expect(matchesToolPattern('fs.readFile', '*.read*')).toBe(true);
+    expect(matchesToolPattern('fs.readFile', '*')).toBe(true);
+    expect(matchesToolPattern('fs.writeFile', 'fs.read*')).toBe(false);
+    expect(matchesToolPattern('fsx.readFile', 'fs.*')).toBe(false);
+  });
+});
+
+describe('admitCaller', () => {
+  it('should keep a role to the callers it names', () => {
+    const profile = { role: 'operator', callers: ['alice', '*@ops.example'] };
+    expect(() => admitCaller(profile, { subject: 'alice' })).not.toThrow();
+    expect(() =>
+      admitCaller(profile, { subject: 'bob@ops.example' })
+    ).not.toThrow();
+    expect(() => admitCaller(profile, { subject: 'mallory' })).toThrow(
+      new CallerPermissionError('mallory', 'operator')
+    );
+    expect(() => admitCaller(profile, { subject: 'mallory' })).toThrow(
+      'Caller mallory may not run in role operator'
+    );
+  });
+
+  it('should not check runs for no caller or roles without callers', () => {
+    expect(() =>
+      admitCaller({ role: 'operator', callers: ['alice'] }, undefined)
+    ).not.toThrow();
+    expect(() =>
+      admitCaller({ role: 'reader' }, { subject: 'mallory' })
+    ).not.toThrow();
+  });
+});
+
+describe('ToolCallGate', () => {
+  it('should refuse tools the role is not allowed', async () => {
+    const gate = new ToolCallGate({ role: 'reader', allowTools: ['fs.read*'] });
+
+    await gate.admit('fs.readFile', {});
+    await expect(gate.admit('fs.writeFile', {})).rejects.toThrow(
+      'Role reader may not call fs.writeFile'
+    );
+  });
+
+  it('should refuse calls over the budget', async () => {
+    const gate = new ToolCallGate({ role: 'reader', maxToolCalls: 2 });
+
+    await gate.admit('fs.readFile', {});
+    await gate.admit('fs.readFile', {});
+    await expect(gate.admit('fs.readFile', {})).rejects.toThrow(
+      'Role reader may make at most 2 tool calls per run'
+    );
+  });
+
+  it('should ask for approval of the calls that need it', async () => {
+    const asked: unknown[] = [];
+    const gate = new ToolCallGate(
+      { role: 'operator', requireApproval: ['fs.write*'], maxToolCalls: 1 },
+      async (tool, input) => {
+        asked.push([tool, input]);
+        return (input as { path: string }).path === 'ok.txt';
+      }
+    );
+
+    await expect(
+      gate.admit('fs.writeFile', { path: 'no.txt' })
+    ).rejects.toBeInstanceOf(ToolPermissionError);
+    // The refused call did not use up the budget
+    await gate.admit('fs.writeFile', { path: 'ok.txt' });
+    expect(asked).toEqual([
+      ['fs.writeFile', { path: 'no.txt' }],
+      ['fs.writeFile', { path: 'ok.txt' }],
+    ]);
+  });
+
+  it('should not ask about calls approved in advance', async () => {
+    const asked: unknown[] = [];
+    const gate = new ToolCallGate(
+      {
+        role: 'operator',
+        requireApproval: ['github.create*'],
+        approvedCalls: [
+          {
+            tool: 'github.createIssue',
+            input: { repo: 'acme/*', draft: true },
+          },
+        ],
+      },
+      async (tool, input) => {
+        asked.push([tool, input]);
+        return true;
+      }
+    );
+
+    await gate.admit('github.createIssue', { repo: 'acme/web', draft: true });
+    await gate.admit('github.createIssue', { repo: 'other/web', draft: true });
+    expect(asked).toEqual([
+      ['github.createIssue', { repo: 'other/web', draft: true }],
+    ]);
+  });
+
+  it('should match the arguments of approved calls', () => {
+    const rule = { tool: 'fs.write*', input: { path: 'out/*', mode: [1, 2] } };
+    expect(
+      matchesApprovedCall(rule, 'fs.writeFile', {
+        path: 'out/a\nb.txt',
+        mode: [1, 2],
+        extra: 1,
+      })
+    ).toBe(true);
+    expect(
+      matchesApprovedCall(rule, 'fs.writeFile', { path: 'out/a', mode: [1] })
+    ).toBe(false);
+    expect(matchesApprovedCall(rule, 'fs.readFile', {})).toBe(false);
+    expect(matchesApprovedCall({ tool: 'fs.*' }, 'fs.readFile', 'x')).toBe(
+      true
+    );
+  });
+
+  it('should refuse calls needing approval when nobody can approve', async () => {
+    const gate = new ToolCallGate({
+      role: 'operator',
+      requireApproval: ['fs.write*'],
+    });
+
+    await expect(gate.admit('fs.writeFile', {})).rejects.toThrow(
+      'Role operator needs approval to call fs.writeFile, which this run cannot ask for'
+    );
+  });
+});
+
+describe('createToolProxy with a gate', () => {
+  it('should check the calls of the script and of agents', async () => {
+    const calls: unknown[] = [];
+    const gate = new ToolCallGate({ role: 'reader', allowTools: ['fs.read'] });
+    const fs = createToolProxy(
+      [tool('read', calls), tool('write', calls)],
+      'fs',
+      {},
+      undefined,
+      gate
+    );
+
+    expect(await fs.read('a.txt')).toBe('ok');
+    await expect(fs.write('b.txt')).rejects.toThrow(
+      'Role reader may not call fs.write'
+    );
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const agentTools = (fs as any).__mcp_tools;
+    await expect(agentTools[1].call({ path: 'c.txt' })).rejects.toThrow(
+      'Role reader may not call fs.write'
+    );
+    expect(calls).toEqual([{ path: 'a.txt' }]);
+  });
+});
@@ -0,0 +1,46 @@
+import { describe, it, expect } from 'vitest';
+import { PromptError, resolvePrompt } from '../prompts.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('resolvePrompt', () => {
+  const project = { name: 'triage-bot', version: '1.4.0' };
+
+  it('should resolve project metadata and environment variables', () => {
+    expect(
+      resolvePrompt(
+        'Reviewer',
+        ['You review ', { project: 'name' }, ' for ', { env: 'TEAM' }],
+        project,
+        { TEAM: 'platform' }
+      )
+    ).toBe('You review triage-bot for platform');
+  });
+
+  it('should name the prompt when a value is missing', () => {
+    expect(() =>
+      resolvePrompt('Reviewer', [{ project: 'owner' }], project, {})
+    ).toThrow(
+      'Prompt Reviewer references ${project.owner}, which the project does not define'
+    );
+    expect(() =>
+      resolvePrompt('Reviewer', [{ project: 'toString' }], project, {})
+    ).toThrow(PromptError);
+    expect(() =>
+      resolvePrompt('Reviewer', [{ env: 'TEAM' }], project, {})
+    ).toThrow('Prompt Reviewer references ${TEAM}, which is not set');
+  });
+
+  it('should never resolve secrets', () => {
+    expect(() =>
+      resolvePrompt('Leaky', [{ secret: 'TOKEN' }], project, { TOKEN: 'x' })
+    ).toThrow('Prompt Leaky cannot reference ${secret:TOKEN}');
+  });
+
+  it('should resolve prompts of scripts with the project of the run', async () => {
+    const context = await executeInVM(
+      'let text = __prompt("Reviewer", ["Release ", { project: "version" }])',
+      { project }
+    );
+    expect(context.text).toBe('Release 1.4.0');
+  });
+});
@@ -0,0 +1,50 @@
+// Tests for model provider lookup
+import { describe, it, expect, vi } from 'vitest';
+import type { BaseLLM } from '@llamaindex/core/llms';
+import { OpenAI } from '@llamaindex/openai';
+import {
+  DEFAULT_LOCAL_MODEL_URL,
+  ModelProviderRegistry,
+  modelProviders,
+} from '../providers.js';
+
+describe('ModelProviderRegistry', () => {
+  it('should include the built-in providers', () => {
+    expect(modelProviders.names()).toEqual([
+      'openai',
+      'anthropic',
+      'gemini',
+      'local',
+    ]);
+  });
+
+  it('should create models with registered providers', () => {
+    const llm = { exec: vi.fn() } as unknown as BaseLLM;
+    const provider = vi.fn(() => llm);
+    const registry = new ModelProviderRegistry({});
+    registry.register('Custom', provider);
+
+    const config = { provider: 'custom', model: 'm1', region: 'eu' };
+    expect(registry.has('CUSTOM')).toBe(true);
+    expect(registry.create(config)).toBe(llm);
+    expect(provider).toHaveBeenCalledWith(config);
+  });
+
+  it('should list the available providers for unknown ones', () => {
+    const registry = new ModelProviderRegistry();
+
+    expect(() => registry.create({ provider: 'nope' })).toThrow(
+      'Unknown model provider "nope" (available: openai, anthropic, gemini, local)'
+    );
+  });
+
+  it('should point local models at an OpenAI-compatible server', () => {
+    const llm = modelProviders.create({ provider: 'local', model: 'llama3' });
+
+    expect(llm).toBeInstanceOf(OpenAI);
+    expect((llm as OpenAI).model).toBe('llama3');
+    expect((llm as OpenAI).additionalSessionOptions?.baseURL).toBe(
+      DEFAULT_LOCAL_MODEL_URL
+    );
+  });
+});
@@ -0,0 +1,115 @@
+// Tests for the prompt builtins
+import { describe, it, expect, vi } from 'vitest';
+import { askThroughInput, createPrompt } from '../questions.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('createPrompt', () => {
+  it('should hand questions to the host and check its answers', async () => {
+    const ask = vi
+      .fn()
+      .mockResolvedValueOnce('PROJ-12')
+      .mockResolvedValueOnce(1)
+      .mockResolvedValueOnce(true);
+    const prompt = createPrompt(ask);
+
+    await expect(prompt.text('Ticket?', 'none')).resolves.toBe('PROJ-12');
+    const production = { label: 'Production', url: 'https://example.com' };
+    await expect(
+      prompt.select(['staging', production], 'Deploy to?')
+    ).resolves.toBe(production);
+    await expect(prompt.confirm()).resolves.toBe(true);
+
+    expect(ask.mock.calls.map(([question]) => question)).toEqual([
+      { kind: 'text', message: 'Ticket?', default: 'none' },
+      {
+        kind: 'select',
+        message: 'Deploy to?',
+        options: ['staging', 'Production'],
+      },
+      { kind: 'confirm', message: 'Continue?', default: false },
+    ]);
+  });
+
+  it('should refuse answers the question does not accept', async () => {
+    const prompt = createPrompt(vi.fn().mockResolvedValue(2));
+
+    await expect(prompt.select(['a', 'b'])).rejects.toThrow(
+      'prompt.select: answer 2 is not one of the 2 options'
+    );
+    await expect(prompt.text('Name?')).rejects.toThrow(
+      'prompt.text: expected a string answer, got number'
+    );
+    await expect(prompt.confirm('Sure?')).rejects.toThrow(
+      'prompt.confirm: expected a yes or no answer, got number'
+    );
+    await expect(prompt.select([])).rejects.toThrow(
+      'prompt.select: options must be a non-empty array, got an empty array'
+    );
+  });
+
+  it('should fail when nobody can be asked', async () => {
+    await expect(createPrompt().confirm('Deploy?')).rejects.toThrow(
+      'prompt.confirm: nobody can be asked in this run. Cannot ask: Deploy?'
+    );
+  });
+});
+
+describe('askThroughInput', () => {
+  it('should ask again until a select answer is an option', async () => {
+    const readLine = vi
+      .fn()
+      .mockResolvedValueOnce('3')
+      .mockResolvedValueOnce('production');
+    const ask = askThroughInput(readLine);
+
+    await expect(
+      ask({
+        kind: 'select',
+        message: 'Deploy to?',
+        options: ['staging', 'production'],
+      })
+    ).resolves.toBe(1);
+    expect(readLine.mock.calls).toEqual([
+      ['Deploy to?\n  1) staging\n  2) production\nChoose 1-2:'],
+      ['Not an option: 3\n  1) staging\n  2) production\nChoose 1-2:'],
+    ]);
+  });
+
+  it('should read yes, no and defaults', async () => {
+    const readLine = vi
+      .fn()
+      .mockResolvedValueOnce('maybe')
+      .mockResolvedValueOnce('Yes')
+      .mockResolvedValueOnce('')
+      .mockResolvedValueOnce('');
+    const ask = askThroughInput(readLine);
+
+    await expect(
+      ask({ kind: 'confirm', message: 'Deploy?', default: false })
+    ).resolves.toBe(true);
+    await expect(
+      ask({ kind: 'confirm', message: 'Deploy?', default: true })
+    ).resolves.toBe(true);
+    await expect(
+      ask({ kind: 'text', message: 'Branch?', default: 'main' })
+    ).resolves.toBe('main');
+    expect(readLine).toHaveBeenNthCalledWith(1, 'Deploy? (y/N)');
+    expect(readLine).toHaveBeenNthCalledWith(3, 'Deploy? (Y/n)');
+    expect(readLine).toHaveBeenLastCalledWith('Branch? [main]');
+  });
+
+  it('should let scripts ask through input when the host has no ask', async () => {
+    const userInput = vi.fn().mockResolvedValueOnce('2').mockResolvedValue('y');
+    const context = await executeInVM(
+      `
+      let target = await prompt.select(["staging", "production"]);
+      let sure = await prompt.confirm("Deploy to " + target + "?");
+      `,
+      { userInput }
+    );
+
+    expect(context.target).toBe('production');
+    expect(context.sure).toBe(true);
+    expect(userInput).toHaveBeenLastCalledWith('Deploy to production? (y/N)');
+  });
+});
@@ -0,0 +1,158 @@
+import { describe, it, expect } from 'vitest';
+import vm from 'vm';
+import { Redactor, REDACTED } from '../redaction.js';
+
+describe('Redactor', () => {
+  describe('patterns', () => {
+    it('should mask every match of a pattern', () => {
+      const redactor = new Redactor({ patterns: ['sk-[a-z0-9]+'] });
+      expect(redactor.redactText('keys sk-abc1 and sk-def2')).toBe(
+        `keys ${REDACTED} and ${REDACTED}`
+      );
+    });
+
+    it('should accept patterns with flags', () => {
+      const redactor = new Redactor({ patterns: ['/bearer \\S+/i'] });
+      expect(redactor.redactText('Authorization: Bearer abc.def')).toBe(
+        `Authorization: ${REDACTED}`
+      );
+    });
+
+    it('should reject invalid patterns', () => {
+      expect(() => new Redactor({ patterns: ['(unclosed'] })).toThrow(
+        'Invalid redaction pattern "(unclosed"'
+      );
+    });
+
+    it('should use a custom replacement', () => {
+      const redactor = new Redactor({ patterns: ['\\d{4}'], replacement: '*' });
+      expect(redactor.redactText('pin 1234')).toBe('pin *');
+    });
+  });
+
+  describe('keys', () => {
+    it('should mask values of secret keys at any depth', () => {
+      const redactor = new Redactor({ keys: ['apiKey', 'password'] });
+      const value = {
+        name: 'service',
+        API_KEY: 'abc',
+        nested: [{ password: { value: 'hunter2' } }],
+      };
+
+      expect(redactor.redact(value)).toEqual({
+        name: 'service',
+        API_KEY: REDACTED,
+        nested: [{ password: REDACTED }],
+      });
+      expect(value.API_KEY).toBe('abc');
+    });
+
+    it('should mask secret keys of maps', () => {
+      const redactor = new Redactor({ keys: ['token'] });
+      const value = new Map([
+        ['token', 'abc'],
+        ['user', 'ada'],
+      ]);
+
+      expect(redactor.redact(value)).toEqual(
+        new Map([
+          ['token', REDACTED],
+          ['user', 'ada'],
+        ])
+      );
+    });
+
+    it('should handle values created in another realm', () => {
+      const redactor = new Redactor({ keys: ['token'] });
+      const value = vm.runInNewContext('({ token: "abc", list: [1] })');
+
+      expect(redactor.redact(value)).toEqual({ token: REDACTED, list: [1] });
+    });
+
+    it('should handle circular references', () => {
+      const redactor = new Redactor({ keys: ['secret'] });
+      const value: Record<string, unknown> = { secret: 'abc' };
+      value.self = value;
+
+      const redacted = redactor.redact(value);
+      expect(redacted.secret).toBe(REDACTED);
+      expect(redacted.self).toBe(redacted);
+    });
+  });
+
+  describe('errors', () => {
+    it('should mask error messages and stacks', () => {
+      const redactor = new Redactor({ patterns: ['sk-\\w+'] });
+      const error = new TypeError('bad key sk-abc');
+
+      const redacted = redactor.redact(error);
+      expect(redacted.name).toBe('TypeError');
+      expect(redacted.message).toBe(`bad key ${REDACTED}`);
+      expect(redacted.stack).not.toContain('sk-abc');
+    });
+  });
+
+  describe('environment', () => {
+    it('should mask the values of listed environment variables', () => {
+      const redactor = new Redactor(
+        { environment: ['API_TOKEN', 'SHORT', 'MISSING'] },
+        { API_TOKEN: 'tok-12345', SHORT: 'ab' }
+      );
+
+      expect(redactor.redactText('sent tok-12345 (ab)')).toBe(
+        `sent ${REDACTED} (ab)`
+      );
+    });
+
+    it('should mask secrets added later', () => {
+      const redactor = new Redactor({}, {});
+      expect(redactor.enabled).toBe(false);
+
+      redactor.addSecret('pass');
+      redactor.addSecret('password-1');
+      redactor.addSecret('ab');
+      expect(redactor.enabled).toBe(true);
+      expect(redactor.redactText('password-1, pass, ab')).toBe(
+        `${REDACTED}, ${REDACTED}, ab`
+      );
+    });
+  });
+
+  describe('tool arguments', () => {
+    const redactor = new Redactor({
+      toolArguments: ['filesystem.write_file.content', '*.*.auth.password'],
+    });
+
+    it('should mask configured argument paths', () => {
+      expect(
+        redactor.redactToolArguments('filesystem', 'write_file', {
+          path: 'notes.txt',
+          content: 'private notes',
+        })
+      ).toEqual({ path: 'notes.txt', content: REDACTED });
+    });
+
+    it('should only mask arguments of the matching tool', () => {
+      const args = { path: 'notes.txt', content: 'hello' };
+      expect(
+        redactor.redactToolArguments('filesystem', 'read_file', args)
+      ).toEqual(args);
+    });
+
+    it('should match wildcards and nested paths', () => {
+      expect(
+        redactor.redactToolArguments('database', 'connect', {
+          auth: { user: 'ada', password: 'hunter2' },
+        })
+      ).toEqual({ auth: { user: 'ada', password: REDACTED } });
+    });
+  });
+
+  it('should return values unchanged without rules', () => {
+    const redactor = new Redactor();
+    const value = { password: 'hunter2' };
+
+    expect(redactor.enabled).toBe(false);
+    expect(redactor.redact(value)).toBe(value);
+  });
+});
@@ -0,0 +1,159 @@
+import { describe, it, expect, vi } from 'vitest';
+import type { BaseLLM } from '@llamaindex/core/llms';
+import {
+  estimateTokens,
+  isProviderError,
+  ModelRouter,
+  ModelRoutingError,
+  rememberModelConfig,
+} from '../routing.js';
+
+const REPLY = { newMessages: [{ role: 'assistant', content: 'ok' }] };
+
+/**
+ * An LLM answering each turn, or failing with the given errors first
+ */
+function fakeLLM(
+  config: { cost?: number; contextWindow?: number } = {},
+  ...errors: unknown[]
+): BaseLLM & { exec: ReturnType<typeof vi.fn> } {
+  const exec = vi.fn(async () => {
+    if (errors.length > 0) {
+      throw errors.shift();
+    }
+    return REPLY;
+  });
+  const llm = { exec } as unknown as BaseLLM & {
+    exec: ReturnType<typeof vi.fn>;
+  };
+  rememberModelConfig(llm, { provider: 'fake', ...config });
+  return llm;
+}
+
+function httpError(status: number) {
+  return Object.assign(new Error(`${status} status code`), { status });
+}
+
+const messages = [{ role: 'user' as const, content: 'Hello' }];
+
+describe('isProviderError', () => {
+  it('should tell outages from bad requests', () => {
+    expect(isProviderError(httpError(503))).toBe(true);
+    expect(isProviderError(httpError(429))).toBe(true);
+    expect(isProviderError(httpError(529))).toBe(true);
+    expect(isProviderError(new TypeError('fetch failed'))).toBe(true);
+    expect(isProviderError(httpError(400))).toBe(false);
+    expect(isProviderError(new Error('Agent A used all of its turns'))).toBe(
+      false
+    );
+  });
+});
+
+describe('ModelRouter', () => {
+  it('should fall back to the next model on provider errors', async () => {
+    const primary = fakeLLM({}, httpError(503));
+    const backup = fakeLLM();
+    const router = new ModelRouter({ primary, backup });
+
+    await expect(router.exec({ messages })).resolves.toBe(REPLY);
+    expect(primary.exec).toHaveBeenCalledTimes(1);
+    expect(backup.exec).toHaveBeenCalledTimes(1);
+  });
+
+  it('should not fall back on errors any model would have', async () => {
+    const primary = fakeLLM({}, httpError(400));
+    const backup = fakeLLM();
+    const router = new ModelRouter({ primary, backup });
+
+    await expect(router.exec({ messages })).rejects.toThrow('400 status code');
+    expect(backup.exec).not.toHaveBeenCalled();
+  });
+
+  it('should try models that failed recently last', async () => {
+    let now = 0;
+    const primary = fakeLLM({}, httpError(503));
+    const backup = fakeLLM();
+    const router = new ModelRouter(
+      { primary, backup },
+      { cooldown: 1000 },
+      'Agent A',
+      () => now
+    );
+
+    await router.exec({ messages });
+    expect(router.candidates(messages).map(([name]) => name)).toEqual([
+      'backup',
+      'primary',
+    ]);
+    now = 1000;
+    expect(router.candidates(messages).map(([name]) => name)).toEqual([
+      'primary',
+      'backup',
+    ]);
+  });
+
+  it('should pass over models over the cost cap or context window', () => {
+    const router = new ModelRouter(
+      {
+        large: fakeLLM({ cost: 15 }),
+        small: fakeLLM({ cost: 1, contextWindow: 10 }),
+        local: fakeLLM(),
+      },
+      { maxCost: 5 }
+    );
+
+    expect(router.candidates(messages).map(([name]) => name)).toEqual([
+      'small',
+      'local',
+    ]);
+    const long = [{ role: 'user' as const, content: 'x'.repeat(100) }];
+    expect(router.candidates(long).map(([name]) => name)).toEqual(['local']);
+  });
+
+  it('should fail turns no model can take', async () => {
+    const router = new ModelRouter(
+      { a: fakeLLM({}, httpError(503)), b: fakeLLM({}, httpError(502)) },
+      {},
+      'Agent A'
+    );
+    const error = await router.exec({ messages }).catch(e => e);
+    expect(error).toBeInstanceOf(ModelRoutingError);
+    expect(error.message).toBe(
+      'Agent A: every model failed: a: 503 status code; b: 502 status code'
+    );
+    expect(error.failures.map((f: { model: string }) => f.model)).toEqual([
+      'a',
+      'b',
+    ]);
+
+    expect(() =>
+      new ModelRouter({ a: fakeLLM({ cost: 9 }) }, { maxCost: 1 }, 'Agent A')
+        .candidates(messages)
+    ).toThrow('Agent A: no model costs at most 1');
+  });
+
+  it('should reject malformed routing rules', () => {
+    expect(
+      () => new ModelRouter({ a: fakeLLM() }, { maxCost: -1 }, 'Agent A')
+    ).toThrow('Agent A: maxCost must be a non-negative number, got -1');
+    expect(
+      () =>
+        new ModelRouter(
+          { a: fakeLLM() },
+          { fallback: true } as never,
+          'Agent A'
+        )
+    ).toThrow('Agent A: unknown routing rule fallback');
+  });
+});
+
+describe('estimateTokens', () => {
+  it('should count four characters a token', () => {
+    expect(
+      estimateTokens([
+        { role: 'user', content: 'abcdefgh' },
+        { role: 'assistant', content: 'a' },
+      ])
+    ).toBe(3);
+  });
+});
@@ -0,0 +1,65 @@
+import { describe, it, expect } from 'vitest';
+import { atLeast, RunLog, type LogEntry } from '../run-log.js';
+import { recordRetry, traced } from '../tracing.js';
+
+const SOURCE = `x = 1
+y = fs.readFile("a.txt")
+print(y)`;
+
+describe('RunLog', () => {
+  it('should log statements, tool calls and agent steps as they happen', async () => {
+    const entries: LogEntry[] = [];
+    const log = new RunLog(entry => entries.push(entry), { source: SOURCE });
+    const debug = log.debugger;
+
+    await log.tracer.trace('mcps.workflow', {}, async () => {
+      await debug.before(1, 1, {});
+      await debug.before(2, 1, {});
+      await traced(
+        'mcps.tool_call',
+        { 'mcp.server': 'fs', 'mcp.tool': 'readFile' },
+        async () => recordRetry(1, new Error('connect ECONNRESET'))
+      );
+      await traced('mcps.agent', { 'mcps.agent': 'Helper' }, async span => {
+        await traced(
+          'mcps.agent_turn',
+          { 'mcps.agent': 'Helper', 'mcps.turn': 1 },
+          async turn =>
+            turn?.setAttributes({ 'mcps.tool_calls': 2, 'mcps.tokens': 120 })
+        );
+        span?.setAttributes({ 'mcps.turns': 1 });
+      });
+      await debug.call('summarize', () => debug.before(3, 3, {}));
+      await traced(
+        'mcps.tool_call',
+        { 'mcp.server': 'fs', 'mcp.tool': 'writeFile' },
+        async () => {
+          throw new Error('Permission denied');
+        }
+      ).catch(() => {});
+    });
+
+    expect(
+      entries.map(({ severity, kind, message }) => [severity, kind, message])
+    ).toEqual([
+      ['debug', 'statement', 'x = 1'],
+      ['debug', 'statement', 'y = fs.readFile("a.txt")'],
+      ['warn', 'retry', 'Retrying fs.readFile (1) after: connect ECONNRESET'],
+      ['info', 'tool_call', 'Called fs.readFile'],
+      ['info', 'agent_turn', 'Agent Helper turn 1 called 2 tools'],
+      ['info', 'agent', 'Agent Helper finished after 1 turn'],
+      ['debug', 'statement', 'print(y)'],
+      ['error', 'tool_call', 'fs.writeFile failed: Permission denied'],
+    ]);
+    expect(entries[3]).toMatchObject({ server: 'fs', tool: 'readFile' });
+    expect(entries[3].durationMs).toBeGreaterThanOrEqual(0);
+    expect(entries[4]).toMatchObject({ agent: 'Helper', tokens: 120 });
+    expect(entries[6]).toMatchObject({ line: 3, tool: 'summarize' });
+  });
+
+  it('should compare severities', () => {
+    expect(atLeast('error', 'warn')).toBe(true);
+    expect(atLeast('debug', 'info')).toBe(false);
+    expect(atLeast('info', 'info')).toBe(true);
+  });
+});
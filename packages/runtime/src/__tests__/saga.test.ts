@@ -0,0 +1,84 @@
+// Tests for compensate blocks
+import { describe, it, expect, vi } from 'vitest';
+import { CompensationError, Sagas } from '../saga.js';
+import { MemoryCheckpointStore } from '../checkpoint.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('Sagas', () => {
+  it('should undo the steps of a failed tool in reverse order', async () => {
+    const undone: string[] = [];
+    const sagas = new Sagas();
+    const failure = new Error('db down');
+
+    await expect(
+      sagas.run(async () => {
+        sagas.compensate(async () => undone.push('ticket'), 'jira.create');
+        sagas.compensate(async () => undone.push('message'), 'slack.post');
+        throw failure;
+      })
+    ).rejects.toBe(failure);
+    expect(undone).toEqual(['message', 'ticket']);
+    expect(sagas.root.size).toBe(0);
+  });
+
+  it('should hand the steps of finished tools to their caller', async () => {
+    const undo = vi.fn(async () => {});
+    const sagas = new Sagas();
+    await expect(
+      sagas.run(async () => {
+        await sagas.run(async () => sagas.compensate(undo, 'inner'));
+        throw new Error('later step');
+      })
+    ).rejects.toThrow('later step');
+    expect(undo).toHaveBeenCalledOnce();
+
+    await sagas.run(async () => sagas.compensate(undo, 'kept'));
+    expect(sagas.root.size).toBe(1);
+  });
+
+  it('should run every compensation and report those that fail', async () => {
+    const undo = vi.fn(async () => {});
+    const sagas = new Sagas();
+    const error = await sagas
+      .run(async () => {
+        sagas.compensate(undo, 'jira.create');
+        sagas.compensate(async () => {
+          throw new Error('gone');
+        }, 'slack.post');
+        throw new Error('db down');
+      })
+      .catch(e => e);
+
+    expect(undo).toHaveBeenCalledOnce();
+    expect(error).toBeInstanceOf(CompensationError);
+    expect(error.message).toBe(
+      'db down; compensating failed for slack.post: gone'
+    );
+    expect(error.cause).toEqual(new Error('db down'));
+  });
+
+  it('should undo the top-level steps of a failed script', async () => {
+    const store = new MemoryCheckpointStore();
+    const addMessage = vi.fn();
+    const info = vi.spyOn(console, 'info').mockImplementation(() => {});
+    const code = `
+      __compensate(async () => print("ticket deleted"), "jira.create");
+      await __checkpoint.save(1, {});
+      throw new Error("db down");
+    `;
+
+    await expect(
+      executeInVM(code, {
+        addMessage,
+        checkpoints: { store, run: 'r1' },
+      })
+    ).rejects.toThrow('db down');
+    expect(addMessage).toHaveBeenCalledWith(
+      expect.objectContaining({ body: 'ticket deleted' })
+    );
+    expect(info).toHaveBeenCalledWith('[INFO]', 'Compensating jira.create');
+    // The run was undone, so it is not left to resume
+    expect(await store.load('r1')).toBeUndefined();
+    info.mockRestore();
+  });
+});
@@ -0,0 +1,102 @@
+import { describe, it, expect } from 'vitest';
+import {
+  GENERATED_FILENAME,
+  ScriptError,
+  formatScriptStack,
+  resolveScriptStack,
+} from '../script-stack.js';
+
+// Generated code as the transpiler emits it with source positions enabled
+const CODE = [
+  '(async () => {',
+  '/*@mcps-tool:fail:1:1:3:2*/const fail = __createUserTool("fail", [], async () => {',
+  '/*@mcps:2:3*/throw new Error("boom");',
+  '});',
+  '/*@mcps:4:1*/let x = 1;',
+  '/*@mcps:5:1*/await fail();',
+  '})()',
+].join('\n');
+
+const SOURCE = `tool fail() {
+  throw("boom")
+}
+x = 1
+fail()`;
+
+const frame = (line: number, column: number) =>
+  `    at file:///${GENERATED_FILENAME}:${line}:${column}`;
+
+describe('resolveScriptStack', () => {
+  it('should map generated frames to script statements', () => {
+    const stack = ['Error: boom', frame(3, 20), frame(6, 14)].join('\n');
+    const source = { fileName: 'fail.mcps', content: SOURCE };
+
+    expect(resolveScriptStack(stack, CODE, source)).toEqual([
+      {
+        tool: 'fail',
+        file: 'fail.mcps',
+        line: 2,
+        column: 3,
+        excerpt: '  throw("boom")',
+      },
+      {
+        tool: undefined,
+        file: 'fail.mcps',
+        line: 5,
+        column: 1,
+        excerpt: 'fail()',
+      },
+    ]);
+  });
+
+  it('should ignore frames outside the generated code', () => {
+    const stack = [
+      'Error: boom',
+      '    at helper (/app/node_modules/runtime/index.js:10:5)',
+      frame(5, 20),
+    ].join('\n');
+
+    const frames = resolveScriptStack(stack, CODE);
+    expect(frames).toHaveLength(1);
+    expect(frames[0]).toMatchObject({ file: '<script>', line: 4, column: 1 });
+  });
+
+  it('should merge consecutive frames for the same statement', () => {
+    const stack = ['Error: boom', frame(6, 14), frame(6, 20)].join('\n');
+
+    expect(resolveScriptStack(stack, CODE)).toHaveLength(1);
+  });
+
+  it('should return no frames for code without markers', () => {
+    const stack = ['Error: boom', frame(1, 1)].join('\n');
+
+    expect(resolveScriptStack(stack, 'throw new Error("boom")')).toEqual([]);
+  });
+});
+
+describe('ScriptError', () => {
+  it('should keep the original error and replace the stack', () => {
+    const original = new TypeError('x is not a function');
+    const error = new ScriptError(original, [
+      { tool: 'fail', file: 'a.mcps', line: 2, column: 3 },
+      { file: 'a.mcps', line: 5, column: 1 },
+    ]);
+
+    expect(error.name).toBe('TypeError');
+    expect(error.message).toBe('x is not a function');
+    expect(error.cause).toBe(original);
+    expect(error.stack).toBe(
+      [
+        'TypeError: x is not a function',
+        '    at fail (a.mcps:2:3)',
+        '    at <script> (a.mcps:5:1)',
+      ].join('\n')
+    );
+  });
+});
+
+describe('formatScriptStack', () => {
+  it('should render nothing for an empty stack', () => {
+    expect(formatScriptStack([])).toBe('');
+  });
+});
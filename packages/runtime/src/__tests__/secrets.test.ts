@@ -0,0 +1,115 @@
+import { describe, it, expect, beforeAll, afterAll } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import {
+  commandSecretProvider,
+  envSecretProvider,
+  fileSecretProvider,
+  formatInterpolation,
+  interpolate,
+  SecretNotFoundError,
+  SecretStore,
+  type SecretProvider,
+} from '../secrets.js';
+
+describe('secret providers', () => {
+  let dir: string;
+
+  beforeAll(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-secrets-'));
+    await writeFile(join(dir, 'API_TOKEN'), 'tok-12345\n');
+  });
+
+  afterAll(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should read secrets from the environment', async () => {
+    const provider = envSecretProvider({ API_TOKEN: 'tok-12345' });
+    await expect(provider.get('API_TOKEN')).resolves.toBe('tok-12345');
+    await expect(provider.get('MISSING')).resolves.toBeUndefined();
+  });
+
+  it('should read secrets from files without the trailing newline', async () => {
+    const provider = fileSecretProvider(dir);
+    await expect(provider.get('API_TOKEN')).resolves.toBe('tok-12345');
+    await expect(provider.get('MISSING')).resolves.toBeUndefined();
+  });
+
+  it('should run commands with the name of the secret', async () => {
+    const provider = commandSecretProvider([
+      process.execPath,
+      '-e',
+      'const n = process.argv[1]; n === "API_TOKEN" ? console.log("tok-" + n) : process.exit(1)',
+      '{name}',
+    ]);
+    await expect(provider.get('API_TOKEN')).resolves.toBe('tok-API_TOKEN');
+    await expect(provider.get('MISSING')).resolves.toBeUndefined();
+  });
+
+  it('should fail when the command cannot be started', async () => {
+    const provider = commandSecretProvider([join(dir, 'no-such-program')]);
+    await expect(provider.get('API_TOKEN')).rejects.toThrow('ENOENT');
+  });
+});
+
+describe('SecretStore', () => {
+  it('should ask providers in order and once per name', async () => {
+    const asked: string[] = [];
+    const provider = (name: string, values: Record<string, string>) =>
+      ({
+        name,
+        get: async secret => {
+          asked.push(`${name}:${secret}`);
+          return values[secret];
+        },
+      }) satisfies SecretProvider;
+    const found: string[] = [];
+    const store = new SecretStore(
+      [provider('a', { X: 'from-a' }), provider('b', { X: 'b', Y: 'from-b' })],
+      value => found.push(value)
+    );
+
+    await expect(store.get('X')).resolves.toBe('from-a');
+    await expect(store.get('Y')).resolves.toBe('from-b');
+    await expect(store.get('Y')).resolves.toBe('from-b');
+    expect(asked).toEqual(['a:X', 'a:Y', 'b:Y']);
+    expect(found).toEqual(['from-a', 'from-b']);
+  });
+
+  it('should name the providers searched for missing secrets', async () => {
+    const store = new SecretStore([envSecretProvider({})]);
+    const lookup = store.get('API_TOKEN');
+    await expect(lookup).rejects.toThrow(SecretNotFoundError);
+    await expect(lookup).rejects.toThrow(
+      'Secret API_TOKEN was not found in env'
+    );
+  });
+});
+
+describe('interpolate', () => {
+  const store = new SecretStore([envSecretProvider({ TOKEN: 'tok-12345' })]);
+
+  it('should resolve variables and secrets', async () => {
+    await expect(
+      interpolate(
+        ['Bearer ', { secret: 'TOKEN' }, ' for ', { env: 'USER' }],
+        store,
+        { USER: 'ada' }
+      )
+    ).resolves.toBe('Bearer tok-12345 for ada');
+  });
+
+  it('should fail for unset variables', async () => {
+    await expect(interpolate([{ env: 'HOME_DIR' }], store, {})).rejects.toThrow(
+      'Environment variable HOME_DIR is not set'
+    );
+  });
+
+  it('should format parts as written', () => {
+    expect(
+      formatInterpolation([{ env: 'A' }, ' ${x ', { secret: 'B' }])
+    ).toBe('${A} $${x ${secret:B}');
+  });
+});
@@ -0,0 +1,109 @@
+// Tests for keeping large tool results on disk
+import { describe, it, expect, vi, afterEach } from 'vitest';
+import { existsSync, mkdtempSync, readdirSync, rmSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { createToolProxy } from '../mcp.js';
+import { formatInspect } from '../inspect.js';
+import { Redactor } from '../redaction.js';
+import { isSpilled, SpillStore, unspill, type SpilledText } from '../spill.js';
+
+const LARGE = `${'x'.repeat(150)}-${'y'.repeat(150)}`;
+
+describe('SpillStore', () => {
+  let dir: string | undefined;
+
+  afterEach(() => {
+    if (dir) {
+      rmSync(dir, { recursive: true, force: true });
+      dir = undefined;
+    }
+  });
+
+  it('should stand in for large texts with their files', () => {
+    dir = mkdtempSync(join(tmpdir(), 'mcps-spill-test-'));
+    const store = new SpillStore({ threshold: 100, dir });
+
+    expect(store.keep('small')).toBe('small');
+    expect(store.keep({ large: LARGE })).toEqual({ large: LARGE });
+    const spilled = store.keep(LARGE) as SpilledText & string;
+    expect(isSpilled(spilled)).toBe(true);
+    expect(spilled.size).toBe(301);
+    expect(readdirSync(dir)).toHaveLength(1);
+
+    // The text is read back wherever it is used
+    expect(spilled.length).toBe(301);
+    expect(spilled.split('-')).toEqual(['x'.repeat(150), 'y'.repeat(150)]);
+    expect(spilled[150]).toBe('-');
+    expect(`${spilled}`).toBe(LARGE);
+    expect(JSON.parse(JSON.stringify({ spilled }))).toEqual({ spilled: LARGE });
+    expect(unspill([{ text: spilled }, 1])).toEqual([{ text: LARGE }, 1]);
+
+    store.close();
+    expect(readdirSync(dir)).toEqual([]);
+  });
+
+  it('should not read the file when the result is awaited', async () => {
+    const store = new SpillStore({ threshold: 100 });
+    const spilled = store.keep(LARGE) as SpilledText;
+    const text = vi.spyOn(spilled, 'text');
+
+    expect(await Promise.resolve(spilled)).toBe(spilled);
+    expect(text).not.toHaveBeenCalled();
+    const { file } = spilled;
+    store.close();
+    expect(existsSync(file)).toBe(false);
+  });
+
+  it('should spill the text results of tool calls', async () => {
+    const store = new SpillStore({ threshold: 100 });
+    const tool = {
+      metadata: {
+        name: 'readFile',
+        parameters: {
+          type: 'object',
+          properties: { path: { type: 'string' } },
+        },
+      },
+      call: vi.fn(async ({ path }: { path: string }) => ({
+        content: [{ type: 'text', text: path === 'big' ? LARGE : path }],
+      })),
+    };
+    const fs = createToolProxy(
+      [tool],
+      'fs',
+      {},
+      undefined,
+      undefined,
+      undefined,
+      undefined,
+      store
+    );
+
+    const big = await fs.readFile('big');
+    expect(isSpilled(big)).toBe(true);
+    // Passed on to a tool, it is sent as its text
+    expect(await fs.readFile(big)).toSatisfy(isSpilled);
+    expect(tool.call).toHaveBeenLastCalledWith({ path: LARGE });
+    expect(await fs.readFile('small')).toBe('small');
+    store.close();
+  });
+});
+
+describe('inspect of spilled results', () => {
+  it('should show their size and start without reading them', () => {
+    const store = new SpillStore({ threshold: 100 });
+    const spilled = store.keep(`secret ${LARGE}`) as SpilledText;
+    const text = vi.spyOn(spilled, 'text');
+
+    const shown = formatInspect(
+      new Redactor({ patterns: ['secret'] }).redact(spilled),
+      { maxStringLength: 20 }
+    );
+    expect(shown).toBe(
+      `spilled string(308 B, 308 chars) "[REDACTED] xxxxxxxxx"… at ${spilled.file}`
+    );
+    expect(text).not.toHaveBeenCalled();
+    store.close();
+  });
+});
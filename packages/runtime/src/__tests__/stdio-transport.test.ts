@@ -0,0 +1,154 @@
+import { describe, it, expect } from 'vitest';
+import type { JSONRPCMessage } from '@modelcontextprotocol/sdk/types.js';
+import {
+  IsolatedStdioTransport,
+  OutputCapture,
+  buildServerEnvironment,
+  usesStdioIsolation,
+} from '../stdio-transport.js';
+
+// A server stand-in that reports its environment as a notification and
+// writes some noise to stderr
+const ENV_SERVER = `
+process.stderr.write('starting up\\n');
+process.stdout.write(JSON.stringify({
+  jsonrpc: '2.0',
+  method: 'env',
+  params: process.env,
+}) + '\\n');
+process.stdin.resume();
+`;
+
+function receiveMessage(
+  transport: IsolatedStdioTransport
+): Promise<JSONRPCMessage> {
+  return new Promise(resolve => {
+    transport.onmessage = resolve;
+  });
+}
+
+describe('buildServerEnvironment', () => {
+  const parentEnv = { PATH: '/usr/bin', SECRET: 'abc', LANG: 'C' };
+
+  it('should start empty when inheritEnv is false', () => {
+    expect(buildServerEnvironment({ inheritEnv: false }, parentEnv)).toEqual(
+      {}
+    );
+  });
+
+  it('should copy allowed variables that are set', () => {
+    expect(
+      buildServerEnvironment(
+        { inheritEnv: false, allowEnv: ['LANG', 'MISSING'] },
+        parentEnv
+      )
+    ).toEqual({ LANG: 'C' });
+  });
+
+  it('should let explicit variables override allowed ones', () => {
+    expect(
+      buildServerEnvironment(
+        { inheritEnv: false, allowEnv: ['LANG'], env: { LANG: 'en_US' } },
+        parentEnv
+      )
+    ).toEqual({ LANG: 'en_US' });
+  });
+});
+
+describe('OutputCapture', () => {
+  it('should keep only the most recent bytes', () => {
+    const capture = new OutputCapture(8);
+    capture.append(Buffer.from('hello '));
+    capture.append(Buffer.from('world'));
+
+    expect(capture.toString()).toBe('lo world');
+    expect(capture.truncated).toBe(true);
+  });
+
+  it('should keep everything below the limit', () => {
+    const capture = new OutputCapture(64);
+    capture.append(Buffer.from('ready'));
+
+    expect(capture.toString()).toBe('ready');
+    expect(capture.truncated).toBe(false);
+  });
+
+  it('should reject invalid limits', () => {
+    expect(() => new OutputCapture(-1)).toThrow('Invalid stderr limit: -1');
+  });
+});
+
+describe('usesStdioIsolation', () => {
+  it('should detect isolation options', () => {
+    expect(usesStdioIsolation({ args: [] })).toBe(false);
+    expect(usesStdioIsolation({ stderr: 'ignore' })).toBe(false);
+    expect(usesStdioIsolation({ stderr: 'capture' })).toBe(true);
+    expect(usesStdioIsolation({ inheritEnv: false })).toBe(true);
+    expect(usesStdioIsolation({ priority: 5 })).toBe(true);
+  });
+});
+
+describe('IsolatedStdioTransport', () => {
+  it('should run the server with only the configured environment', async () => {
+    process.env.MCPS_TEST_SECRET = 'should-not-leak';
+    process.env.MCPS_TEST_ALLOWED = 'visible';
+    const transport = new IsolatedStdioTransport({
+      command: process.execPath,
+      args: ['-e', ENV_SERVER],
+      inheritEnv: false,
+      allowEnv: ['MCPS_TEST_ALLOWED'],
+      env: { MODE: 'test' },
+      stderr: 'capture',
+    });
+
+    try {
+      const received = receiveMessage(transport);
+      await transport.start();
+      const message = (await received) as { params: Record<string, string> };
+
+      expect(message.params.MCPS_TEST_ALLOWED).toBe('visible');
+      expect(message.params.MODE).toBe('test');
+      expect(message.params.MCPS_TEST_SECRET).toBeUndefined();
+      expect(message.params.PATH).toBeUndefined();
+      expect(transport.stderrOutput?.toString()).toBe('starting up\n');
+    } finally {
+      delete process.env.MCPS_TEST_SECRET;
+      delete process.env.MCPS_TEST_ALLOWED;
+      await transport.close();
+    }
+  });
+
+  it('should stop the server on close', async () => {
+    const transport = new IsolatedStdioTransport({
+      command: process.execPath,
+      args: ['-e', 'process.stdin.resume()'],
+      inheritEnv: false,
+    });
+    let closed = false;
+    transport.onclose = () => {
+      closed = true;
+    };
+
+    await transport.start();
+    await transport.close();
+
+    expect(closed).toBe(true);
+    expect(transport.pid).toBeNull();
+  });
+
+  it('should reject out of range priorities', () => {
+    expect(
+      () => new IsolatedStdioTransport({ command: 'node', priority: 40 })
+    ).toThrow('Invalid priority 40: must be an integer from -20 to 19');
+  });
+
+  it('should report spawn failures', async () => {
+    const transport = new IsolatedStdioTransport({
+      command: 'mcps-test-command-that-does-not-exist',
+      inheritEnv: false,
+    });
+    transport.onerror = () => {};
+
+    await expect(transport.start()).rejects.toThrow('ENOENT');
+  });
+});
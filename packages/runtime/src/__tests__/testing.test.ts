@@ -0,0 +1,311 @@
+import { describe, it, expect } from 'vitest';
+import {
+  AssertionError,
+  MockServers,
+  assert,
+  assertEqual,
+  assertThrows,
+  createFixtures,
+  createMock,
+  createTests,
+  deepEqual,
+  TestSuite,
+  expect as expectScript,
+  jsonPath,
+} from '../testing.js';
+import { existsSync } from 'fs';
+import { createToolProxy } from '../mcp.js';
+import { executeInVM } from '../vm-executor.js';
+
+const READ_SCHEMA = {
+  name: 'read',
+  inputSchema: {
+    type: 'object',
+    properties: { path: { type: 'string' } },
+  },
+};
+
+describe('MockServers', () => {
+  async function connect(servers: MockServers) {
+    const client = servers.connect({ serverName: 'fs', command: 'fs-server' });
+    return createToolProxy(await client.tools(), 'fs');
+  }
+
+  it('should answer calls with registered responses', async () => {
+    const servers = new MockServers({ fs: [READ_SCHEMA] });
+    const fs = await connect(servers);
+
+    servers.respond('fs.read', 'contents');
+    await expect(fs.read('a.txt')).resolves.toBe('contents');
+    servers.respond('fs.read', (input: { path: string }) => `<${input.path}>`);
+    await expect(fs.read('b.txt')).resolves.toBe('<b.txt>');
+    servers.fail('fs.read', 'File not found');
+    await expect(fs.read('c.txt')).rejects.toThrow('File not found');
+
+    expect(servers.calls('fs.read')).toEqual([
+      { tool: 'fs.read', input: { path: 'a.txt' } },
+      { tool: 'fs.read', input: { path: 'b.txt' } },
+      { tool: 'fs.read', input: { path: 'c.txt' } },
+    ]);
+  });
+
+  it('should fail calls without a response', async () => {
+    const fs = await connect(new MockServers({ fs: [READ_SCHEMA] }));
+    await expect(fs.read('a.txt')).rejects.toThrow(
+      'No mock response for fs.read; register one with mock("fs.read", ...)'
+    );
+  });
+
+  it('should answer calls as a recorded run did', async () => {
+    const servers = new MockServers({ fs: [READ_SCHEMA] });
+    const fs = await connect(servers);
+
+    servers.replay([
+      { tool: 'fs.read', input: { path: 'a.txt' }, result: 'first' },
+      { tool: 'fs.read', input: { path: 'b.txt' }, error: 'File not found' },
+      { tool: 'fs.read', input: { path: 'a.txt' }, result: 'second' },
+    ]);
+    await expect(fs.read('a.txt')).resolves.toBe('first');
+    await expect(fs.read('a.txt')).resolves.toBe('second');
+    await expect(fs.read('a.txt')).resolves.toBe('second');
+    await expect(fs.read('b.txt')).rejects.toThrow('File not found');
+    await expect(fs.read('c.txt')).rejects.toThrow(
+      'fs.read was not called with {"path":"c.txt"} in the recorded run'
+    );
+  });
+
+  it('should replace the servers of a script', async () => {
+    const context = await executeInVM(
+      `
+      const __fs_server = __llamaindex_mcp({ serverName: "fs", command: "fs-server" });
+      const fs = __createToolProxy(await __fs_server.tools(), "fs");
+      mock("fs.read", "hello");
+      let text = await fs.read("a.txt");
+      let calls = mock.calls("fs.read");
+      assertEqual(calls, [{ path: "a.txt" }]);
+      await __fs_server.cleanup();
+      `,
+      { mocks: new MockServers({ fs: [READ_SCHEMA] }) }
+    );
+    expect(context.text).toBe('hello');
+  });
+});
+
+describe('createMock', () => {
+  it('should only work with mock servers', () => {
+    const mock = createMock();
+    expect(() => mock('fs.read', 'x')).toThrow(
+      'mock() is only available in tests run by mcps test'
+    );
+    expect(() => mock.calls('fs.read')).toThrow('mock.calls()');
+  });
+
+  it('should only replay recordings', () => {
+    const mock = createMock(new MockServers());
+    expect(() => mock.recorded({ calls: 'none' })).toThrow(
+      'mock.recorded() takes a recording written by mcps transcript mocks'
+    );
+  });
+});
+
+describe('assertions', () => {
+  it('should compare contents', () => {
+    expect(deepEqual({ a: [1, { b: 'c' }] }, { a: [1, { b: 'c' }] })).toBe(
+      true
+    );
+    expect(deepEqual([1, 2], [1, 2, 3])).toBe(false);
+    expect(deepEqual({ a: 1 }, { b: 1 })).toBe(false);
+    expect(deepEqual(NaN, NaN)).toBe(true);
+    expect(deepEqual(null, {})).toBe(false);
+  });
+
+  it('should throw AssertionError with the values', () => {
+    expect(() => assert(true)).not.toThrow();
+    expect(() => assert(0, 'must be set')).toThrow(
+      new AssertionError('must be set')
+    );
+    expect(() => assertEqual({ a: 1 }, { a: 1 })).not.toThrow();
+    expect(() => assertEqual([1], [2], 'ids')).toThrow(
+      'ids: expected [2], got [1]'
+    );
+  });
+
+  it('should return the message of the error a tool throws', async () => {
+    const fail = async (path: string) => {
+      throw new Error(`cannot read ${path}`);
+    };
+    await expect(assertThrows(fail, 'a.txt')).resolves.toBe(
+      'cannot read a.txt'
+    );
+    await expect(assertThrows(async function read() {})).rejects.toThrow(
+      'expected read to throw'
+    );
+  });
+});
+
+describe('matchers', () => {
+  const result = { items: [{ id: 42 }, { id: 7 }], 'user name': 'ada' };
+
+  it('should follow JSON paths', () => {
+    expect(jsonPath(result, '$.items[0].id')).toEqual({
+      found: true,
+      value: 42,
+    });
+    expect(jsonPath(result, '$.items[-1]')).toEqual({
+      found: true,
+      value: { id: 7 },
+    });
+    expect(jsonPath(result, "$['user name']")).toEqual({
+      found: true,
+      value: 'ada',
+    });
+    expect(jsonPath(result, '$.items[2].id')).toEqual({
+      found: false,
+      missing: '$.items[2]',
+    });
+    expect(() => jsonPath(result, 'items')).toThrow(AssertionError);
+  });
+
+  it('should check values at JSON paths', () => {
+    expect(() =>
+      expectScript(result).toHaveJsonPath('$.items[0].id', 42)
+    ).not.toThrow();
+    expect(() => expectScript(result).toHaveJsonPath('$.items')).not.toThrow();
+    expect(() =>
+      expectScript(result).toHaveJsonPath('$.items[1].id', 42)
+    ).toThrow('expected 42 at $.items[1].id, got 7');
+    expect(() => expectScript(result).toHaveJsonPath('$.total')).toThrow(
+      'but $.total does not exist'
+    );
+  });
+
+  it('should compare times with a tolerance', () => {
+    const sent = '2026-10-16T12:00:00Z';
+    expect(() =>
+      expectScript('2026-10-16T12:00:04Z').toBeCloseInTime(sent, 5000)
+    ).not.toThrow();
+    expect(() =>
+      expectScript(new Date('2026-10-16T12:00:06Z')).toBeCloseInTime(
+        sent,
+        5000
+      )
+    ).toThrow('but it is 6000 ms off');
+    expect(() => expectScript('soon').toBeCloseInTime(sent, 5000)).toThrow(
+      'expected the value to be a date'
+    );
+    expect(() => expectScript(sent).toBeBefore(Date.now())).not.toThrow();
+    expect(() => expectScript(sent).toBeAfter(Date.now())).toThrow(
+      AssertionError
+    );
+  });
+
+  it('should be available to scripts', async () => {
+    await expect(
+      executeInVM(`expect({ a: [1] }).toHaveJsonPath("$.a[0]", 2);`, {})
+    ).rejects.toThrow('expected 2 at $.a[0], got 1');
+  });
+});
+
+describe('TestSuite', () => {
+  it('should run hooks and fixtures around each test case', async () => {
+    const suite = new TestSuite();
+    const steps: string[] = [];
+    let fixture = 0;
+    suite.beforeAll(async () => void steps.push('beforeAll'));
+    suite.fixture(async () => void (fixture += 1));
+    suite.beforeEach(async () => void steps.push(`beforeEach ${fixture}`));
+    suite.afterEach(async () => void steps.push('afterEach'));
+    suite.afterAll(async () => void steps.push('afterAll'));
+    suite.test('a', async () => void steps.push('a'));
+    suite.test('b', async () => {
+      throw new Error('b failed');
+    });
+    await suite.run();
+
+    expect(steps).toEqual([
+      'beforeAll',
+      'beforeEach 1',
+      'a',
+      'afterEach',
+      'beforeEach 2',
+      'afterEach',
+      'afterAll',
+    ]);
+    expect(suite.results.map(({ name, error }) => [name, error])).toEqual([
+      ['a', undefined],
+      ['b', new Error('b failed')],
+    ]);
+  });
+
+  it('should fail every test case when beforeAll fails', async () => {
+    const suite = new TestSuite();
+    suite.beforeAll(async () => {
+      throw new Error('no database');
+    });
+    suite.test('a', async () => {});
+    await suite.run();
+    expect(suite.results[0].error).toEqual(new Error('no database'));
+  });
+
+  it("should undo a test case's mock responses and calls", async () => {
+    const servers = new MockServers({ fs: [READ_SCHEMA] });
+    const client = servers.connect({ serverName: 'fs', command: 'fs-server' });
+    const fs = createToolProxy(await client.tools(), 'fs');
+    servers.respond('fs.read', 'seeded');
+
+    const suite = new TestSuite(servers);
+    suite.test('overrides', async () => {
+      servers.respond('fs.read', 'changed');
+      await fs.read('a.txt');
+    });
+    suite.test('sees the seeded response', async () => {
+      assertEqual(servers.calls(), []);
+      assertEqual(await fs.read('b.txt'), 'seeded');
+    });
+    await suite.run();
+    expect(suite.results.map(result => result.error)).toEqual([
+      undefined,
+      undefined,
+    ]);
+  });
+
+  it('should remove temporary directories after each test case', async () => {
+    const suite = new TestSuite();
+    const fixtures = createFixtures(suite);
+    let dir = '';
+    suite.fixture(async () => {
+      dir = await fixtures.tempDir();
+    });
+    suite.test('uses it', async () => assert(existsSync(dir)));
+    await suite.run();
+    expect(suite.results[0].error).toBeUndefined();
+    expect(existsSync(dir)).toBe(false);
+  });
+
+  it('should only run test cases under mcps test', async () => {
+    await expect(createTests().run()).rejects.toThrow(
+      'Test cases only run in tests run by mcps test'
+    );
+    await expect(createFixtures().tempDir()).rejects.toThrow(
+      'fixtures.tempDir() is only available in tests run by mcps test'
+    );
+  });
+
+  it('should seed several responses of a server at once', async () => {
+    const schema = (name: string) => ({
+      name,
+      inputSchema: { type: 'object' },
+    });
+    const servers = new MockServers({
+      github: [schema('listIssues'), schema('closeIssue')],
+    });
+    createMock(servers).server('github', {
+      listIssues: [],
+      closeIssue: { closed: true },
+    });
+    const client = servers.connect({ serverName: 'github', command: 'gh' });
+    const github = createToolProxy(await client.tools(), 'github');
+    await expect(github.listIssues()).resolves.toEqual([]);
+    await expect(github.closeIssue()).resolves.toEqual({ closed: true });
+  });
+});
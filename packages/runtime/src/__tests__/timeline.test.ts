@@ -0,0 +1,196 @@
+import { describe, it, expect } from 'vitest';
+import {
+  encodeChromeTrace,
+  layoutTimeline,
+  renderTimelineHtml,
+  spanLabel,
+  TimelineExporter,
+} from '../timeline.js';
+import {
+  traceModelCalls,
+  Tracer,
+  type SpanData,
+  type TraceExporter,
+} from '../tracing.js';
+
+function span(
+  spanId: string,
+  name: string,
+  startTime: number,
+  endTime: number,
+  options: Partial<SpanData> = {}
+): SpanData {
+  return {
+    traceId: 't',
+    spanId,
+    name,
+    startTime,
+    endTime,
+    attributes: {},
+    events: [],
+    status: { code: 'ok' },
+    ...options,
+  };
+}
+
+// A workflow running two tool calls in parallel, then asking a model
+const SPANS = [
+  span('w', 'mcps.workflow', 1000, 1500, {
+    attributes: { 'mcps.script': 'report.mcps' },
+  }),
+  span('a', 'mcps.tool_call', 1010, 1200, {
+    parentSpanId: 'w',
+    attributes: { 'mcp.server': 'github', 'mcp.tool': 'listIssues' },
+    events: [
+      {
+        name: 'retry',
+        time: 1100,
+        attributes: { 'mcps.retry': 1, 'exception.message': 'ECONNRESET' },
+      },
+    ],
+  }),
+  span('b', 'mcps.tool_call', 1020, 1100, {
+    parentSpanId: 'w',
+    attributes: { 'mcp.server': 'slack', 'mcp.tool': 'post<Message>' },
+    status: { code: 'error', message: 'Channel not found' },
+  }),
+  span('c', 'mcps.llm_call', 1300, 1450, {
+    parentSpanId: 'w',
+    attributes: { 'mcps.provider': 'openai', 'mcps.model': 'gpt-4o' },
+  }),
+];
+
+describe('layoutTimeline', () => {
+  it('should give overlapping siblings lanes of their own', () => {
+    const lanes = Object.fromEntries(
+      layoutTimeline(SPANS).map(({ span, lane, depth }) => [
+        span.spanId,
+        [lane, depth],
+      ])
+    );
+    expect(lanes).toEqual({
+      w: [0, 0],
+      a: [0, 1],
+      b: [1, 0],
+      c: [0, 1],
+    });
+  });
+
+  it('should label spans by what they call', () => {
+    expect(SPANS.map(spanLabel)).toEqual([
+      'report.mcps',
+      'github.listIssues',
+      'slack.post<Message>',
+      'gpt-4o',
+    ]);
+  });
+});
+
+describe('encodeChromeTrace', () => {
+  it('should encode spans as complete events in microseconds', () => {
+    const trace = encodeChromeTrace(SPANS, 'report.mcps') as {
+      traceEvents: Record<string, unknown>[];
+    };
+    const complete = trace.traceEvents.filter(event => event.ph === 'X');
+    const bars = complete.map(({ name, ts, dur, tid }) => [name, ts, dur, tid]);
+    expect(bars).toEqual([
+      ['report.mcps', 0, 500_000, 0],
+      ['github.listIssues', 10_000, 190_000, 0],
+      ['slack.post<Message>', 20_000, 80_000, 1],
+      ['gpt-4o', 300_000, 150_000, 0],
+    ]);
+    expect(complete[2].args).toMatchObject({ error: 'Channel not found' });
+    expect(trace.traceEvents).toContainEqual(
+      expect.objectContaining({ name: 'retry 1', ph: 'i', ts: 100_000 })
+    );
+    expect(trace.traceEvents[0]).toMatchObject({
+      ph: 'M',
+      args: { name: 'report.mcps' },
+    });
+  });
+});
+
+describe('renderTimelineHtml', () => {
+  it('should render bars, retries and where the time went', () => {
+    const html = renderTimelineHtml(SPANS, 'report.mcps');
+    expect(html).toContain('<title>Timeline of report.mcps</title>');
+    expect(html).toContain('500 ms, 4 spans in 2 lanes');
+    expect(html).toContain('left:2.000%;width:38.000%');
+    expect(html).toContain('slack.post&lt;Message&gt;');
+    expect(html).toContain('failed: Channel not found');
+    expect(html).toContain('retry 1: ECONNRESET');
+    expect(html).not.toContain('post<Message>');
+    // Slowest first
+    expect(html.indexOf('<td>github.listIssues</td>')).toBeLessThan(
+      html.indexOf('<td>gpt-4o</td>')
+    );
+  });
+});
+
+describe('TimelineExporter', () => {
+  it('should rewrite the timeline with every span so far', async () => {
+    const written: string[] = [];
+    const exporter = new TimelineExporter('chrome', async content => {
+      written.push(content);
+    });
+    await exporter.export(SPANS.slice(0, 2), []);
+    await exporter.export([], []);
+    await exporter.export(SPANS.slice(2), []);
+
+    expect(written).toHaveLength(2);
+    const { traceEvents } = JSON.parse(written[1]);
+    expect(
+      traceEvents.filter((event: { ph: string }) => event.ph === 'X')
+    ).toHaveLength(4);
+  });
+});
+
+describe('traceModelCalls', () => {
+  it('should trace model requests under the running span', async () => {
+    const spans: SpanData[] = [];
+    const exporter: TraceExporter = {
+      async export(batch) {
+        spans.push(...batch);
+      },
+    };
+    const tracer = new Tracer(exporter);
+    const llm = {
+      async chat(params: { stream?: boolean; fail?: boolean }) {
+        if (params.fail) {
+          throw new Error('Rate limited');
+        }
+        return params.stream
+          ? (async function* () {
+              yield 'a';
+              yield 'b';
+            })()
+          : 'reply';
+      },
+    };
+    traceModelCalls(llm, { 'mcps.provider': 'openai', 'mcps.model': 'gpt-4o' });
+
+    // Outside a trace nothing is recorded
+    await expect(llm.chat({})).resolves.toBe('reply');
+    await tracer.trace('mcps.workflow', {}, async () => {
+      await llm.chat({});
+      const chunks: string[] = [];
+      for await (const chunk of (await llm.chat({
+        stream: true,
+      })) as AsyncIterable<string>) {
+        chunks.push(chunk);
+      }
+      expect(chunks).toEqual(['a', 'b']);
+      await expect(llm.chat({ fail: true })).rejects.toThrow('Rate limited');
+    });
+    await tracer.flush();
+
+    const [workflow] = spans.filter(s => s.name === 'mcps.workflow');
+    const calls = spans.filter(s => s.name === 'mcps.llm_call');
+    expect(calls).toHaveLength(3);
+    for (const call of calls) {
+      expect(call.parentSpanId).toBe(workflow.spanId);
+      expect(call.attributes).toMatchObject({ 'mcps.model': 'gpt-4o' });
+    }
+    expect(calls.map(call => call.status.code)).toEqual(['ok', 'ok', 'error']);
+  });
+});
@@ -0,0 +1,82 @@
+import { describe, it, expect, vi } from 'vitest';
+import { checkToolInput, ToolInputError } from '../tool-schemas.js';
+import { createToolProxy } from '../mcp.js';
+
+const readFileSchema = {
+  type: 'object',
+  properties: {
+    path: { type: 'string' },
+    limit: { type: 'integer' },
+  },
+  required: ['path'],
+  additionalProperties: false,
+};
+
+describe('checkToolInput', () => {
+  it('should accept input matching the schema', () => {
+    const input = { path: 'a.txt', limit: 10 };
+    expect(checkToolInput(readFileSchema, input)).toEqual([]);
+  });
+
+  it('should report missing required parameters', () => {
+    expect(checkToolInput(readFileSchema, { limit: 10 })).toEqual([
+      'missing required parameter "path"',
+    ]);
+  });
+
+  it('should report parameters the schema does not declare', () => {
+    expect(checkToolInput(readFileSchema, { path: 'a', pth: 'b' })).toEqual([
+      'unknown parameter "pth"',
+    ]);
+  });
+
+  it('should allow other parameters when the schema does', () => {
+    const schema = { ...readFileSchema, additionalProperties: true };
+    expect(checkToolInput(schema, { path: 'a', encoding: 'utf-8' })).toEqual(
+      []
+    );
+    expect(checkToolInput({ type: 'object' }, { anything: 1 })).toEqual([]);
+  });
+
+  it('should report values of the wrong type', () => {
+    expect(checkToolInput(readFileSchema, { path: 1, limit: 1.5 })).toEqual([
+      'parameter "path" must be string, got number',
+      'parameter "limit" must be integer, got number',
+    ]);
+  });
+});
+
+describe('createToolProxy', () => {
+  function readFileTool() {
+    return {
+      metadata: { name: 'readFile', parameters: readFileSchema },
+      call: vi.fn(async () => 'contents'),
+    };
+  }
+
+  it('should map positional arguments to parameters', async () => {
+    const tool = readFileTool();
+    const filesystem = createToolProxy([tool], 'filesystem');
+    await expect(filesystem.readFile('a.txt', 5)).resolves.toBe('contents');
+    expect(tool.call).toHaveBeenCalledWith({ path: 'a.txt', limit: 5 });
+  });
+
+  it('should reject invalid input before calling the tool', async () => {
+    const tool = readFileTool();
+    const filesystem = createToolProxy([tool], 'filesystem');
+    await expect(filesystem.readFile({ pth: 'a.txt' })).rejects.toThrow(
+      'Invalid call to filesystem.readFile: missing required parameter "path"; unknown parameter "pth"'
+    );
+    await expect(filesystem.readFile(42)).rejects.toBeInstanceOf(
+      ToolInputError
+    );
+    expect(tool.call).not.toHaveBeenCalled();
+  });
+
+  it('should reject too many positional arguments', async () => {
+    const filesystem = createToolProxy([readFileTool()], 'filesystem');
+    await expect(filesystem.readFile('a', 1, true)).rejects.toThrow(
+      'expected at most 2 arguments, got 3'
+    );
+  });
+});
@@ -0,0 +1,76 @@
+import { describe, it, expect } from 'vitest';
+import { createTracePoints, type TracePoint } from '../trace-points.js';
+import { Redactor, REDACTED } from '../redaction.js';
+import { Tracer, type SpanData } from '../tracing.js';
+import { executeInVM } from '../vm-executor.js';
+
+const POINT: TracePoint = {
+  label: 'issues',
+  level: 'info',
+  line: 3,
+  column: 1,
+};
+
+describe('createTracePoints', () => {
+  it('should log values and hand them on unchanged', () => {
+    const lines: string[] = [];
+    const trace = createTracePoints({
+      sourceFile: 'triage.mcps',
+      write: line => lines.push(line),
+    });
+    const value = [{ id: 1 }];
+
+    expect(trace(value, POINT)).toBe(value);
+    expect(lines).toEqual(['[TRACE] triage.mcps:3:1 issues = [{"id":1}]']);
+  });
+
+  it('should only record the points the trace level shows', () => {
+    const record = (level: 'off' | 'info' | 'debug') => {
+      const lines: string[] = [];
+      const trace = createTracePoints({
+        level,
+        write: line => lines.push(line),
+      });
+      trace(1, POINT);
+      trace(2, { ...POINT, level: 'debug' });
+      return lines.length;
+    };
+    expect(record('off')).toBe(0);
+    expect(record('info')).toBe(1);
+    expect(record('debug')).toBe(2);
+  });
+
+  it('should mask secrets in recorded values', () => {
+    const lines: string[] = [];
+    const trace = createTracePoints({
+      redactor: new Redactor({ keys: ['token'] }),
+      write: line => lines.push(line),
+    });
+    trace({ token: 'abc' }, POINT);
+    expect(lines[0]).toContain(`{"token":"${REDACTED}"}`);
+  });
+
+  it('should add trace points to the span running them', async () => {
+    const spans: SpanData[] = [];
+    const tracer = new Tracer({
+      async export(batch) {
+        spans.push(...batch);
+      },
+    });
+    await executeInVM(
+      'let total = __trace(1 + 2, { label: "total", level: "info", line: 1, column: 1 });',
+      { tracer, sourceFile: 'sum.mcps', traceLevel: 'info' }
+    );
+    expect(spans[0].events).toMatchObject([
+      {
+        name: 'mcps.trace_point',
+        attributes: {
+          'mcps.trace_point.label': 'total',
+          'mcps.trace_point.value': '3',
+          'code.filepath': 'sum.mcps',
+          'code.lineno': 1,
+        },
+      },
+    ]);
+  });
+});
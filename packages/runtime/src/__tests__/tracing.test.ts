@@ -0,0 +1,268 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  currentSpan,
+  encodeOtlpTraces,
+  OtlpHttpExporter,
+  parseOtlpHeaders,
+  traced,
+  Tracer,
+  type CounterData,
+  type SpanData,
+} from '../tracing.js';
+import { createToolProxy } from '../mcp.js';
+import { runParallel } from '../parallel.js';
+import { Redactor, REDACTED } from '../redaction.js';
+import { executeInVM } from '../vm-executor.js';
+
+/**
+ * A tracer keeping what it exports, with the last counter totals
+ */
+function recordingTracer() {
+  const spans: SpanData[] = [];
+  let counters: CounterData[] = [];
+  const tracer = new Tracer({
+    async export(batch, totals) {
+      spans.push(...batch);
+      counters = totals;
+    },
+  });
+  return { tracer, spans, counters: () => counters };
+}
+
+function networkError(code: string): Error {
+  return Object.assign(new Error(`connect ${code}`), { code });
+}
+
+describe('Tracer', () => {
+  it('should run untraced code without recording anything', async () => {
+    await expect(traced('work', {}, async span => span)).resolves.toBe(
+      undefined
+    );
+    expect(currentSpan()).toBeUndefined();
+  });
+
+  it('should nest spans under the one running', async () => {
+    const { tracer, spans } = recordingTracer();
+    await tracer.trace('root', { a: 1 }, () =>
+      traced('child', { b: 'x' }, () =>
+        traced('grandchild', {}, async () => {})
+      )
+    );
+    await tracer.flush();
+
+    const [grandchild, child, root] = spans;
+    expect(spans.map(span => span.name)).toEqual([
+      'grandchild',
+      'child',
+      'root',
+    ]);
+    expect(root.parentSpanId).toBeUndefined();
+    expect(child.parentSpanId).toBe(root.spanId);
+    expect(grandchild.parentSpanId).toBe(child.spanId);
+    expect(new Set(spans.map(span => span.traceId)).size).toBe(1);
+    expect(child.attributes).toMatchObject({ b: 'x' });
+    expect(child.attributes['mcps.duration_ms']).toBeTypeOf('number');
+    expect(root.endTime).toBeGreaterThanOrEqual(child.endTime);
+  });
+
+  it('should parent the spans of parallel branches', async () => {
+    const { tracer, spans } = recordingTracer();
+    await tracer.trace('root', {}, () =>
+      runParallel([
+        () => traced('a', {}, async () => {}),
+        () => traced('b', {}, async () => {}),
+      ])
+    );
+    await tracer.flush();
+    const root = spans.find(span => span.name === 'root')!;
+    expect(
+      spans.filter(span => span.parentSpanId === root.spanId).length
+    ).toBe(2);
+  });
+
+  it('should record failures and count them', async () => {
+    const { tracer, spans, counters } = recordingTracer();
+    const run = tracer.trace('root', {}, () =>
+      traced('mcps.tool_call', { 'mcp.tool': 'read' }, async () => {
+        throw new TypeError('bad input');
+      })
+    );
+    await expect(run).rejects.toThrow('bad input');
+    await tracer.flush();
+
+    expect(spans[0]).toMatchObject({
+      status: { code: 'error', message: 'bad input' },
+      attributes: { 'error.type': 'TypeError' },
+    });
+    expect(counters()).toMatchObject([
+      {
+        name: 'mcps.failures',
+        attributes: { 'mcps.span': 'mcps.tool_call', 'mcp.tool': 'read' },
+        value: 1,
+      },
+      { name: 'mcps.failures', attributes: { 'mcps.span': 'root' }, value: 1 },
+    ]);
+  });
+
+  it('should mask secrets in spans', async () => {
+    const { tracer, spans } = recordingTracer();
+    const redactor = new Redactor({}, {});
+    redactor.addSecret('tok-12345');
+    tracer.redactWith(redactor);
+    const attributes = { url: 'https://x/?t=tok-12345' };
+    const run = tracer.trace('root', attributes, async span => {
+      span.addEvent('retry', { reason: 'tok-12345 expired' });
+      throw new Error('rejected tok-12345');
+    });
+    await expect(run).rejects.toThrow('rejected tok-12345');
+    await tracer.flush();
+
+    expect(spans[0].attributes.url).toBe(`https://x/?t=${REDACTED}`);
+    expect(spans[0].events[0].attributes.reason).toBe(`${REDACTED} expired`);
+    expect(spans[0].status.message).toBe(`rejected ${REDACTED}`);
+  });
+
+  it('should report export failures without throwing', async () => {
+    const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+    const tracer = new Tracer({
+      export: async () => {
+        throw new Error('collector down');
+      },
+    });
+    await tracer.trace('root', {}, async () => {});
+    await expect(tracer.flush()).resolves.toBeUndefined();
+    expect(warn).toHaveBeenCalledWith(
+      '[WARN] Failed to export traces: collector down'
+    );
+    warn.mockRestore();
+  });
+});
+
+describe('tool call tracing', () => {
+  function flakyTool(failures: number) {
+    let calls = 0;
+    return {
+      metadata: { name: 'read' },
+      call: async () => {
+        if (++calls <= failures) {
+          throw networkError('ECONNRESET');
+        }
+        return 'ok';
+      },
+    };
+  }
+
+  it('should trace MCP tool calls with their retries', async () => {
+    const { tracer, spans, counters } = recordingTracer();
+    const fs = createToolProxy([flakyTool(2)], 'fs', {
+      retries: 2,
+      backoff: 'constant',
+    });
+    await tracer.trace('root', {}, () => fs.read({}));
+    await tracer.flush();
+
+    const [call] = spans;
+    expect(call).toMatchObject({
+      name: 'mcps.tool_call',
+      attributes: { 'mcp.server': 'fs', 'mcp.tool': 'read' },
+      status: { code: 'ok' },
+    });
+    expect(call.events.map(event => event.attributes['mcps.retry'])).toEqual([
+      1, 2,
+    ]);
+    expect(counters()).toMatchObject([
+      {
+        name: 'mcps.retries',
+        attributes: { 'mcp.server': 'fs', 'mcp.tool': 'read' },
+        value: 2,
+      },
+    ]);
+  });
+
+  it('should trace calls agents make to MCP tools', async () => {
+    const { tracer, spans } = recordingTracer();
+    const fs = createToolProxy([flakyTool(0)], 'fs');
+    const [tool] = (fs as Record<string, unknown>).__mcp_tools as {
+      call(input: unknown): Promise<unknown>;
+    }[];
+    await tracer.trace('root', {}, () => tool.call({}));
+    await tracer.flush();
+    expect(spans.map(span => span.name)).toEqual(['mcps.tool_call', 'root']);
+  });
+
+  it('should trace a script run as a workflow', async () => {
+    const { tracer, spans } = recordingTracer();
+    await executeInVM('let x = 1', { tracer, sourceFile: 'hello.mcps' });
+    expect(spans).toMatchObject([
+      { name: 'mcps.workflow', attributes: { 'mcps.script': 'hello.mcps' } },
+    ]);
+  });
+});
+
+describe('OTLP export', () => {
+  it('should parse headers from the environment variable form', () => {
+    expect(
+      parseOtlpHeaders('api-key=abc%3D, x-team = core,invalid')
+    ).toEqual({ 'api-key': 'abc=', 'x-team': 'core' });
+  });
+
+  it('should encode spans as OTLP JSON', () => {
+    const span: SpanData = {
+      traceId: 'a'.repeat(32),
+      spanId: 'b'.repeat(16),
+      parentSpanId: 'c'.repeat(16),
+      name: 'mcps.tool_call',
+      startTime: 1000.5,
+      endTime: 1002,
+      attributes: { 'mcp.tool': 'read', attempts: 2, ratio: 0.5, ok: true },
+      events: [],
+      status: { code: 'error', message: 'timed out' },
+    };
+    const encoded = encodeOtlpTraces([span], 'mcps') as {
+      resourceSpans: { scopeSpans: { spans: object[] }[] }[];
+    };
+    expect(encoded.resourceSpans[0].scopeSpans[0].spans[0]).toEqual({
+      traceId: span.traceId,
+      spanId: span.spanId,
+      parentSpanId: span.parentSpanId,
+      name: 'mcps.tool_call',
+      kind: 1,
+      startTimeUnixNano: '1000500000',
+      endTimeUnixNano: '1002000000',
+      attributes: [
+        { key: 'mcp.tool', value: { stringValue: 'read' } },
+        { key: 'attempts', value: { intValue: '2' } },
+        { key: 'ratio', value: { doubleValue: 0.5 } },
+        { key: 'ok', value: { boolValue: true } },
+      ],
+      events: [],
+      status: { code: 2, message: 'timed out' },
+    });
+  });
+
+  it('should post traces and metrics to the collector', async () => {
+    const fetch = vi.fn(async () => new Response('{}'));
+    const tracer = new Tracer(
+      new OtlpHttpExporter({
+        endpoint: 'http://collector:4318/',
+        headers: { 'api-key': 'abc' },
+        serviceName: 'mcps',
+        fetch,
+      })
+    );
+    await tracer.trace('root', {}, async () => {
+      tracer.count('mcps.retries');
+    });
+    await tracer.flush();
+
+    expect(fetch.mock.calls.map(([url]) => url)).toEqual([
+      'http://collector:4318/v1/traces',
+      'http://collector:4318/v1/metrics',
+    ]);
+    const [, init] = fetch.mock.calls[0] as unknown as [string, RequestInit];
+    expect(init.headers).toEqual({
+      'Content-Type': 'application/json',
+      'api-key': 'abc',
+    });
+  });
+});
@@ -0,0 +1,201 @@
+import { describe, it, expect } from 'vitest';
+import {
+  parseTranscript,
+  recordToolCalls,
+  replayMessages,
+  TranscriptError,
+  TranscriptRecorder,
+  type TranscriptEvent,
+} from '../transcript.js';
+import { Redactor, REDACTED } from '../redaction.js';
+
+function record(
+  build: (recorder: TranscriptRecorder) => void
+): TranscriptEvent[] {
+  const events: TranscriptEvent[] = [];
+  build(new TranscriptRecorder(event => events.push(event)));
+  return events;
+}
+
+const jsonl = (events: unknown[]) =>
+  events.map(event => JSON.stringify(event)).join('\n') + '\n';
+
+describe('TranscriptRecorder', () => {
+  it('should number agent runs in the order they start', () => {
+    const events = record(recorder => {
+      const lead = recorder.start('lead', undefined, 'Be brief');
+      const writer = recorder.start('writer', lead);
+      recorder.message(writer, { role: 'user', content: 'Write' });
+      recorder.end(writer);
+      recorder.end(lead, new Error('Out of turns'));
+    });
+
+    expect(events).toMatchObject([
+      { type: 'run_start', run: 1, agent: 'lead', systemPrompt: 'Be brief' },
+      { type: 'run_start', run: 2, agent: 'writer', parent: 1 },
+      { type: 'message', run: 2, message: { content: 'Write' } },
+      { type: 'run_end', run: 2 },
+      { type: 'run_end', run: 1, error: 'Out of turns' },
+    ]);
+    expect(events[0]).not.toHaveProperty('parent');
+    expect(events[3]).not.toHaveProperty('error');
+  });
+
+  it('should mask messages and errors with its redactor', () => {
+    const events = record(recorder => {
+      recorder.redactWith(new Redactor({ patterns: ['sk-[a-z0-9]+'] }));
+      const run = recorder.start('lead');
+      recorder.message(run, { role: 'user', content: 'Use sk-abc123' });
+      recorder.end(run, 'Rejected sk-abc123');
+    });
+
+    expect(events[1]).toMatchObject({
+      message: { content: `Use ${REDACTED}` },
+    });
+    expect(events[2]).toMatchObject({ error: `Rejected ${REDACTED}` });
+  });
+});
+
+describe('recordToolCalls', () => {
+  it('should record the answer or error of each call', async () => {
+    const events: TranscriptEvent[] = [];
+    const recorder = new TranscriptRecorder(event => events.push(event));
+    recorder.redactWith(new Redactor({ patterns: ['sk-[a-z0-9]+'] }));
+    const [read] = recordToolCalls(
+      [
+        {
+          metadata: { name: 'read' },
+          call: async (input: { path: string }) => {
+            if (input.path === 'missing') {
+              throw new Error('File not found');
+            }
+            return { content: [{ type: 'text', text: 'key sk-abc123' }] };
+          },
+        },
+      ],
+      'fs',
+      recorder
+    );
+
+    await read.call({ path: 'a.txt' });
+    await expect(read.call({ path: 'missing' })).rejects.toThrow();
+    expect(events).toMatchObject([
+      {
+        type: 'tool_call',
+        tool: 'fs.read',
+        input: { path: 'a.txt' },
+        result: { content: [{ text: `key ${REDACTED}` }] },
+      },
+      {
+        type: 'tool_call',
+        tool: 'fs.read',
+        input: { path: 'missing' },
+        error: 'File not found',
+      },
+    ]);
+    expect(events[0]).not.toHaveProperty('error');
+  });
+});
+
+describe('parseTranscript', () => {
+  const events = [
+    { type: 'script', file: '/work/report.mcps', time: 1000 },
+    ...record(recorder => {
+      const lead = recorder.start('lead');
+      recorder.message(lead, { role: 'user', content: 'Go' });
+      const writer = recorder.start('writer', lead);
+      recorder.message(writer, { role: 'user', content: 'Write' });
+      recorder.message(writer, { role: 'assistant', content: 'Poem' });
+      recorder.end(writer);
+      recorder.message(lead, { role: 'assistant', content: 'Done' });
+    }),
+  ];
+
+  it('should put the events of each agent run together', () => {
+    const transcript = parseTranscript(jsonl(events));
+
+    expect(transcript).toMatchObject({
+      file: '/work/report.mcps',
+      startedAt: 1000,
+    });
+    expect(transcript.runs).toMatchObject([
+      {
+        run: 1,
+        agent: 'lead',
+        messages: [{ content: 'Go' }, { content: 'Done' }],
+      },
+      {
+        run: 2,
+        agent: 'writer',
+        parent: 1,
+        messages: [{ content: 'Write' }, { content: 'Poem' }],
+      },
+    ]);
+    expect(transcript.toolCalls).toEqual([]);
+    // The script stopped before the lead finished
+    expect(transcript.runs[0].finishedAt).toBeUndefined();
+    expect(transcript.runs[1].finishedAt).toBeDefined();
+  });
+
+  it('should keep the tool calls apart from the agent runs', () => {
+    const call = {
+      type: 'tool_call',
+      tool: 'fs.read',
+      input: { path: 'a.txt' },
+      result: 'text',
+      time: 2000,
+    };
+    const transcript = parseTranscript(jsonl([...events, call]));
+    expect(transcript.toolCalls).toEqual([
+      { tool: 'fs.read', input: { path: 'a.txt' }, result: 'text', time: 2000 },
+    ]);
+  });
+
+  it('should ignore a last line cut short by a crash', () => {
+    const text = jsonl(events) + '{"type":"message","run":1,"mess';
+    expect(parseTranscript(text).runs[0].messages).toHaveLength(2);
+  });
+
+  it('should reject lines that are not JSON before the last', () => {
+    const text = `${jsonl(events.slice(0, 2))}not json\n${jsonl(events.slice(2))}`;
+    expect(() => parseTranscript(text)).toThrow(TranscriptError);
+    expect(() => parseTranscript(text)).toThrow('Line 3 is not valid JSON');
+  });
+
+  it('should reject events of runs that never started', () => {
+    const text = jsonl([{ type: 'run_end', run: 4, time: 1 }]);
+    expect(() => parseTranscript(text)).toThrow(
+      'Line 1 belongs to agent run 4, which never started'
+    );
+  });
+});
+
+describe('replayMessages', () => {
+  const transcript = parseTranscript(
+    jsonl(
+      record(recorder => {
+        const run = recorder.start('lead');
+        recorder.message(run, { role: 'user', content: 'Go' });
+        recorder.message(run, { role: 'assistant', content: 'Working' });
+        recorder.message(run, { role: 'user', content: 'Faster' });
+        recorder.end(run);
+      })
+    )
+  );
+
+  it('should give the messages up to and including the chosen one', () => {
+    const { agent, messages } = replayMessages(transcript, 1, 2);
+    expect(agent).toBe('lead');
+    expect(messages.map(m => m.content)).toEqual(['Go', 'Working']);
+  });
+
+  it('should reject runs and messages the transcript does not have', () => {
+    expect(() => replayMessages(transcript, 2, 1)).toThrow(
+      'The transcript has no agent run 2'
+    );
+    expect(() => replayMessages(transcript, 1, 4)).toThrow(
+      'Agent run 1 (lead) has messages 1 to 3, not 4'
+    );
+    expect(() => replayMessages(transcript, 1, 0)).toThrow(TranscriptError);
+  });
+});
@@ -0,0 +1,54 @@
+import { describe, it, expect, vi } from 'vitest';
+import { MCPServerManager } from '../mcp.js';
+import type { ScriptTrigger } from '../types.js';
+import { executeInVM } from '../vm-executor.js';
+
+describe('triggers', () => {
+  const script = `
+let count = 0;
+__trigger("tick", "*/5 * * * *", async () => { count = count + 1; return count; });
+__trigger("deploy", null, async () => "deployed");`;
+
+  it('should collect triggers that fire in the script context', async () => {
+    const triggers: ScriptTrigger[] = [];
+    await executeInVM(script, { triggers });
+
+    const declared = triggers.map(({ name, schedule }) => ({ name, schedule }));
+    expect(declared).toEqual([
+      { name: 'tick', schedule: '*/5 * * * *' },
+      { name: 'deploy', schedule: null },
+    ]);
+    expect(await triggers[0].fire()).toBe(1);
+    expect(await triggers[0].fire()).toBe(2);
+    expect(await triggers[1].fire()).toBe('deployed');
+  });
+
+  it('should ignore triggers nobody collects', async () => {
+    await expect(executeInVM(script)).resolves.toBeDefined();
+  });
+
+  it('should keep servers running for the triggers of a script', async () => {
+    const serverManager = new MCPServerManager();
+    const closeAll = vi.spyOn(serverManager, 'closeAll');
+
+    await executeInVM(script, { serverManager, triggers: [] });
+    expect(closeAll).not.toHaveBeenCalled();
+
+    await executeInVM('let done = true', { serverManager, triggers: [] });
+    expect(closeAll).toHaveBeenCalledTimes(1);
+  });
+
+  it('should shut servers down when a script with triggers fails', async () => {
+    const serverManager = new MCPServerManager();
+    const closeAll = vi.spyOn(serverManager, 'closeAll');
+    const triggers: ScriptTrigger[] = [];
+
+    await expect(
+      executeInVM(`${script}\nthrow new Error("broken")`, {
+        serverManager,
+        triggers,
+      })
+    ).rejects.toThrow('broken');
+    expect(closeAll).toHaveBeenCalledTimes(1);
+  });
+});
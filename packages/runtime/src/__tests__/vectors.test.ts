@@ -0,0 +1,152 @@
+// Tests for embed() and the vector index
+import { describe, it, expect, vi } from 'vitest';
+import type { BaseLLM } from '@llamaindex/core/llms';
+import { ModelProviderRegistry } from '../providers.js';
+import { rememberModelConfig } from '../routing.js';
+import {
+  cosineSimilarity,
+  createEmbed,
+  embeddersFor,
+  MemoryVectorStore,
+  VectorIndex,
+} from '../vectors.js';
+import { executeInVM } from '../vm-executor.js';
+
+/**
+ * Embeds texts by counting the letters a, b and c in them
+ */
+const embed = vi.fn(async (texts: string[]) =>
+  texts.map(text => ['a', 'b', 'c'].map(l => text.split(l).length - 1))
+);
+
+describe('cosineSimilarity', () => {
+  it('should compare the directions of vectors', () => {
+    expect(cosineSimilarity([1, 0], [2, 0])).toBeCloseTo(1);
+    expect(cosineSimilarity([1, 0], [0, 3])).toBeCloseTo(0);
+    expect(cosineSimilarity([1, 1], [-1, -1])).toBeCloseTo(-1);
+    expect(cosineSimilarity([0, 0], [1, 0])).toBe(0);
+  });
+});
+
+describe('VectorIndex', () => {
+  it('should find the records closest to a text', async () => {
+    const vectors = new VectorIndex(embed);
+    await vectors.upsert([
+      { id: 'a', text: 'aaa', metadata: { lang: 'en' } },
+      { id: 'b', text: 'bbb', metadata: { lang: 'fr' } },
+      { id: 'ab', text: 'aab' },
+    ]);
+
+    const matches = await vectors.query('a', 2);
+    expect(matches.map(m => m.id)).toEqual(['a', 'ab']);
+    expect(matches[0]).toEqual({
+      id: 'a',
+      score: expect.closeTo(1),
+      text: 'aaa',
+      metadata: { lang: 'en' },
+    });
+    const french = await vectors.query('a', { filter: { lang: 'fr' } });
+    expect(french.map(m => m.id)).toEqual(['b']);
+    const close = await vectors.query([1, 0, 0], { minScore: 0.5 });
+    expect(close.map(m => m.id)).toEqual(['a', 'ab']);
+  });
+
+  it('should replace and delete records by id', async () => {
+    const vectors = new VectorIndex(embed);
+    await vectors.upsert({ id: 'x', vector: [1, 0, 0] });
+    await vectors.upsert({ id: 'x', vector: [0, 1, 0], text: 'moved' });
+    expect(await vectors.count()).toBe(1);
+    expect((await vectors.query([0, 1, 0]))[0].text).toBe('moved');
+
+    expect(await vectors.delete(['x', 'y'])).toBe(1);
+    expect(await vectors.count()).toBe(0);
+  });
+
+  it('should keep collections in its store', async () => {
+    const store = new MemoryVectorStore();
+    await new VectorIndex(embed, store)
+      .collection('docs')
+      .upsert({ id: 'a', vector: [1, 2, 3] });
+
+    // A later run finds the collection again
+    const docs = new VectorIndex(embed, store).collection('docs');
+    expect(await docs.count()).toBe(1);
+    expect(await store.load('docs')).toEqual([{ id: 'a', vector: [1, 2, 3] }]);
+    expect(await new VectorIndex(embed, store).count()).toBe(0);
+  });
+
+  it('should reject malformed records and queries', async () => {
+    const vectors = new VectorIndex(embed);
+    await expect(vectors.upsert([{ text: 'a' } as never])).rejects.toThrow(
+      'vectors.upsert: item 1 needs an id'
+    );
+    await expect(vectors.upsert({ id: 'a' })).rejects.toThrow(
+      'vectors.upsert: item 1 needs a text or a vector'
+    );
+    await vectors.upsert({ id: 'a', vector: [1, 2, 3] });
+    await expect(vectors.query([1, 2])).rejects.toThrow(
+      'vectors: collection default holds vectors of 3 dimensions, got 2'
+    );
+    await expect(vectors.query('a', 0)).rejects.toThrow(
+      'vectors.query: k must be a positive integer, got 0'
+    );
+    expect(() => vectors.collection('../etc')).toThrow(
+      'vectors.collection: invalid collection name "../etc"'
+    );
+  });
+});
+
+describe('embed', () => {
+  const providers = new ModelProviderRegistry({}, { fake: () => ({ embed }) });
+
+  it('should embed a text or a list of texts', async () => {
+    const fn = createEmbed(embeddersFor(providers, { provider: 'fake' }));
+    await expect(fn('abc')).resolves.toEqual([1, 1, 1]);
+    await expect(fn(['a', 'cc'])).resolves.toEqual([
+      [1, 0, 0],
+      [0, 0, 2],
+    ]);
+    await expect(fn(42 as never)).rejects.toThrow(
+      'embed: texts must be a text or a list of texts'
+    );
+  });
+
+  it('should use the model it is given', async () => {
+    const fn = createEmbed(embeddersFor(providers));
+    await expect(fn('a')).rejects.toThrow(
+      'embed: no embedding model is configured'
+    );
+
+    const llm = {} as BaseLLM;
+    rememberModelConfig(llm, { provider: 'fake' });
+    await expect(fn('a', llm)).resolves.toEqual([1, 0, 0]);
+    await expect(fn('a', {})).rejects.toThrow(
+      'embed: the model must be one the script declares'
+    );
+  });
+
+  it('should tell providers without embedding models', () => {
+    expect(() =>
+      new ModelProviderRegistry().createEmbedder({ provider: 'anthropic' })
+    ).toThrow('Model provider "anthropic" has no embedding models');
+  });
+
+  it('should be available to scripts with the vector index', async () => {
+    const store = new MemoryVectorStore();
+    const context = await executeInVM(
+      `
+      await vectors.upsert([{ id: "1", text: "aa" }, { id: "2", text: "bc" }]);
+      let best = (await vectors.query("a", 1))[0].id;
+      let vector = await embed("cab");
+      `,
+      {
+        modelProviders: providers,
+        embeddings: { provider: 'fake' },
+        vectors: store,
+      }
+    );
+    expect(context.best).toBe('1');
+    expect(context.vector).toEqual([1, 1, 1]);
+    expect(await store.load('default')).toHaveLength(2);
+  });
+});
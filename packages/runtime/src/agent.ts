@@ -1,9 +1,37 @@
 // Agent runtime class for MCP Script
-import type { BaseLLM } from '@llamaindex/core/llms';
-import type { BaseTool } from '@llamaindex/core/llms';
+import { AsyncLocalStorage } from 'async_hooks';
+import type {
+  BaseLLM,
+  BaseTool,
+  ChatMessage,
+  ToolCall,
+} from '@llamaindex/core/llms';
+import { FunctionTool } from '@llamaindex/core/tools';
+import { z } from 'zod';
 import { Conversation } from './conversation.js';
+import {
+  checkGuardrails,
+  GuardrailError,
+  GuardrailRun,
+  retryMessage,
+  type GuardrailConfig,
+  type GuardrailHandlers,
+  type GuardrailViolation,
+} from './guardrails.js';
+import {
+  checkContextOptions,
+  compactMessages,
+  summarizeMessages,
+  type ContextOptions,
+} from './context-window.js';
 import { wrapToolForAgent } from './mcp.js';
-import type { PrintChatMessageFn } from './globals.js';
+import { contextWindowOf, estimateTokens } from './routing.js';
+import { traced } from './tracing.js';
+import type { TranscriptRecorder } from './transcript.js';
+import type {
+  PrintChatMessageFn,
+  StreamChatMessageFn,
+} from './globals.js';
 
 /**
  * Configuration options for creating an agent
@@ -17,14 +45,96 @@ export interface AgentConfig {
   systemPrompt?: string;
   /** The LLM to use for this agent */
   llm: BaseLLM;
-  /** Tools available to this agent (can be BaseTool, user-defined functions, or arrays of tools) */
-  tools?: (
-    | BaseTool
-    | ((...args: unknown[]) => Promise<unknown>)
-    | BaseTool[]
-  )[];
+  /** Tools available to this agent (can be BaseTool, user-defined functions, agents, or arrays of tools) */
+  tools?: AgentTool[];
+  /**
+   * Turns this agent may take per run, including the turns of the agents
+   * it delegates to
+   */
+  maxTurns?: number;
+  /** Checks on the replies and tool calls of the agent's model */
+  guardrails?: GuardrailConfig[];
+  /** How the conversation is kept within the model's context window */
+  context?: ContextOptions;
+}
+
+type AgentTool =
+  | BaseTool
+  | Agent
+  | ((...args: unknown[]) => Promise<unknown>)
+  | BaseTool[];
+
+/**
+ * Error thrown when an agent has used up its turns or those of an agent
+ * that delegated to it
+ */
+export class AgentBudgetError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'AgentBudgetError';
+  }
+}
+
+/**
+ * Turns taken in one run of an agent; the turns of a sub-agent also count
+ * against each agent above it, so a delegation tree shares the budget of
+ * the agent at its root
+ */
+class TurnBudget {
+  /** Turns of this agent and the agents it delegated to */
+  private used = 0;
+  /** Turns of this agent alone */
+  private own = 0;
+
+  constructor(
+    readonly agent: string,
+    private readonly limit: number | undefined,
+    readonly parent?: TurnBudget
+  ) {}
+
+  take(): void {
+    const chain = this.chain();
+    const spent = chain.find(
+      budget => budget.limit !== undefined && budget.used >= budget.limit
+    );
+    if (spent) {
+      throw new AgentBudgetError(
+        spent === this
+          ? `Agent ${this.agent} used all of its ${spent.limit} turns`
+          : `Agent ${this.agent} ran out of turns: ${spent.agent} allows ` +
+              `${spent.limit} for itself and the agents it delegates to`
+      );
+    }
+    for (const budget of chain) {
+      budget.used++;
+    }
+    this.own++;
+  }
+
+  /** This budget and those of the agents above it */
+  private chain(): TurnBudget[] {
+    return this.parent ? [this, ...this.parent.chain()] : [this];
+  }
+
+  /** Turns this agent took itself */
+  get turns(): number {
+    return this.own;
+  }
 }
 
+/**
+ * The agent run in progress, for the agents it delegates to
+ */
+interface AgentRun {
+  budget: TurnBudget;
+  /** Number of the run in the transcript, when one is recorded */
+  transcriptRun?: number;
+  /** The agent's guardrails, when it has any */
+  guardrails?: GuardrailRun;
+}
+
+const agentRuns = new AsyncLocalStorage<AgentRun>();
+
 /**
  * Agent class that encapsulates agent configuration and execution
  * Provides a clean interface for agent delegation without exposing LlamaIndex internals
@@ -36,15 +146,34 @@ export class Agent {
   private config: AgentConfig;
   private wrappedTools: BaseTool[];
   private printChatMessage: PrintChatMessageFn;
+  private streamChatMessage?: StreamChatMessageFn;
+  private transcript?: TranscriptRecorder;
+  private guardrailHandlers: GuardrailHandlers;
 
   constructor(
     config: AgentConfig,
     printChatMessage: PrintChatMessageFn = () => {
       /* no-op by default */
-    }
+    },
+    streamChatMessage?: StreamChatMessageFn,
+    transcript?: TranscriptRecorder,
+    guardrailHandlers: GuardrailHandlers = {}
   ) {
+    if (config.guardrails !== undefined) {
+      checkGuardrails(
+        config.guardrails,
+        `Agent ${config.name}`,
+        guardrailHandlers.validators
+      );
+    }
+    if (config.context !== undefined) {
+      checkContextOptions(config.context, `Agent ${config.name}`);
+    }
     this.config = config;
     this.printChatMessage = printChatMessage;
+    this.streamChatMessage = streamChatMessage;
+    this.transcript = transcript;
+    this.guardrailHandlers = guardrailHandlers;
     // Wrap user-defined tools at runtime
     this.wrappedTools = this.wrapTools(config.tools || []);
   }
@@ -66,15 +195,14 @@ export class Agent {
    * Wrap user-defined tools with metadata attached via Proxy
    * Detects tool type at runtime and wraps accordingly
    */
-  private wrapTools(
-    tools: (
-      | BaseTool
-      | ((...args: unknown[]) => Promise<unknown>)
-      | BaseTool[]
-    )[]
-  ): BaseTool[] {
+  private wrapTools(tools: AgentTool[]): BaseTool[] {
     // Use flatMap to handle arrays (MCP server tool arrays)
     return tools.flatMap(tool => {
+      // Another agent is called with a task, which it runs to completion
+      if (tool instanceof Agent) {
+        return delegationTool(tool);
+      }
+
       // If it's an array, recursively validate each element
       if (Array.isArray(tool)) {
         // Validate all elements are BaseTools
@@ -132,6 +260,42 @@ export class Agent {
    * @returns A Conversation object containing the full interaction history
    */
   async run(conversation: Conversation | string): Promise<Conversation> {
+    const { name, maxTurns, systemPrompt, guardrails } = this.config;
+    const parent = agentRuns.getStore();
+    const run: AgentRun = {
+      budget: new TurnBudget(name, maxTurns, parent?.budget),
+      transcriptRun: this.transcript?.start(
+        name,
+        parent?.transcriptRun,
+        systemPrompt
+      ),
+      guardrails: guardrails?.length
+        ? new GuardrailRun(name, guardrails, this.guardrailHandlers)
+        : undefined,
+    };
+    const attributes = parent
+      ? { 'mcps.agent': name, 'mcps.parent_agent': parent.budget.agent }
+      : { 'mcps.agent': name };
+    return agentRuns.run(run, () =>
+      traced('mcps.agent', attributes, async span => {
+        try {
+          const result = await this.loop(conversation, run);
+          this.endTranscriptRun(run);
+          return result;
+        } catch (error) {
+          this.endTranscriptRun(run, error);
+          throw error;
+        } finally {
+          span?.setAttributes({ 'mcps.turns': run.budget.turns });
+        }
+      })
+    );
+  }
+
+  private async loop(
+    conversation: Conversation | string,
+    run: AgentRun
+  ): Promise<Conversation> {
     // If string is passed, create a new conversation
     const conv =
       typeof conversation === 'string'
@@ -150,21 +314,54 @@ export class Agent {
     for (const msg of messages) {
       this.printChatMessage(this.config.name, msg);
     }
+    this.recordMessages(run, messages.filter(msg => msg.role !== 'system'));
 
     // Agent loop: repeatedly call llm.exec until no more tool calls
     let exit = false;
+    let turn = 0;
     do {
-      const { newMessages, toolCalls } = await this.config.llm.exec({
-        messages,
-        tools: this.wrappedTools,
-      });
-      messages.push(...newMessages);
+      run.budget.take();
+      await this.fitContext(messages);
+      // Each turn is traced, with the number of tools the reply called and
+      // the tokens it took, estimated since the LLMs do not report them
+      const attributes = {
+        'mcps.agent': this.config.name,
+        'mcps.turn': ++turn,
+      };
+      const { newMessages, toolCalls } = await traced(
+        'mcps.agent_turn',
+        attributes,
+        async span => {
+          const result = await this.step(messages, run.guardrails);
+          span?.setAttributes({
+            'mcps.tool_calls': result.toolCalls.length,
+            'mcps.tokens':
+              estimateTokens(messages) + estimateTokens(result.newMessages),
+          });
+          return result;
+        }
+      );
+
+      // Rejected replies are neither shown nor kept; the model is told what
+      // was wrong with them instead
+      const violation =
+        run.guardrails &&
+        (await this.reviewReplies(
+          run.guardrails,
+          newMessages,
+          toolCalls.length === 0
+        ));
+      const accepted: ChatMessage[] = violation
+        ? [{ role: 'user', content: retryMessage(violation) }]
+        : newMessages;
+      messages.push(...accepted);
 
-      for (const msg of newMessages) {
+      for (const msg of accepted) {
         this.printChatMessage(this.config.name, msg);
       }
+      this.recordMessages(run, accepted);
 
-      exit = toolCalls.length === 0;
+      exit = !violation && toolCalls.length === 0;
     } while (!exit);
 
     // Reconstruct conversation from all messages (excluding system prompt)
@@ -178,6 +375,112 @@ export class Agent {
     return finalConv;
   }
 
+  /**
+   * Compact the conversation in place when it nears the limit of the
+   * model's context window; the run goes on from the compacted messages,
+   * while its transcript keeps every message
+   */
+  private async fitContext(messages: ChatMessage[]): Promise<void> {
+    const { llm, context = {} } = this.config;
+    const limit = context.limit ?? contextWindowOf(llm);
+    if (limit === undefined) {
+      return;
+    }
+    const compacted = await compactMessages(
+      messages,
+      limit,
+      context,
+      context.summarize === false
+        ? undefined
+        : older => summarizeMessages(llm, older)
+    );
+    if (compacted) {
+      messages.splice(0, messages.length, ...compacted);
+    }
+  }
+
+  /**
+   * Check the text of the model's replies, resolving with the first
+   * violation to retry for
+   */
+  private async reviewReplies(
+    guardrails: GuardrailRun,
+    newMessages: ChatMessage[],
+    final: boolean
+  ): Promise<GuardrailViolation | undefined> {
+    for (const message of newMessages) {
+      if (message.role !== 'assistant') {
+        continue;
+      }
+      const text =
+        typeof message.content === 'string'
+          ? message.content
+          : JSON.stringify(message.content);
+      if (text === '') {
+        continue;
+      }
+      const violation = await guardrails.review({ text, final });
+      if (violation) {
+        return violation;
+      }
+    }
+    return undefined;
+  }
+
+  private recordMessages(run: AgentRun, messages: ChatMessage[]): void {
+    if (this.transcript && run.transcriptRun !== undefined) {
+      for (const message of messages) {
+        this.transcript.message(run.transcriptRun, message);
+      }
+    }
+  }
+
+  private endTranscriptRun(run: AgentRun, error?: unknown): void {
+    if (this.transcript && run.transcriptRun !== undefined) {
+      this.transcript.end(run.transcriptRun, error);
+    }
+  }
+
+  /**
+   * Send the conversation to the LLM once, streaming its reply when a
+   * stream handler is set
+   * Tool calls in the reply have been executed when this resolves. Replies
+   * of agents with guardrails are not streamed, since they are only shown
+   * once the guardrails let them through
+   */
+  private async step(
+    messages: ChatMessage[],
+    guardrails?: GuardrailRun
+  ): Promise<{ newMessages: ChatMessage[]; toolCalls: ToolCall[] }> {
+    const { llm } = this.config;
+    if (guardrails) {
+      const { tools, blocked } = guardTools(this.wrappedTools, guardrails);
+      const result = await llm.exec({ messages, tools });
+      // The LLM reports errors of tool calls back to the model, so a
+      // blocked call fails the run once the turn is over
+      if (blocked.error) {
+        throw blocked.error;
+      }
+      return result;
+    }
+
+    const tools = this.wrappedTools;
+    if (!this.streamChatMessage) {
+      return llm.exec({ messages, tools });
+    }
+
+    const response = await llm.exec({ messages, tools, stream: true });
+    for await (const chunk of response.stream) {
+      if (chunk.delta) {
+        this.streamChatMessage(this.config.name, chunk.delta);
+      }
+    }
+    return {
+      newMessages: response.newMessages(),
+      toolCalls: response.toolCalls,
+    };
+  }
+
   /**
    * Get the agent's name
    */
@@ -194,12 +497,79 @@ export class Agent {
 }
 
 /**
- * Create an Agent constructor that injects the printChatMessage handler
+ * Tools whose calls are checked by guardrails before they run
+ * A call to retry is answered with what was wrong with it instead of
+ * running; a blocked call is recorded, so the turn can fail with it
+ */
+function guardTools(
+  tools: BaseTool[],
+  guardrails: GuardrailRun
+): { tools: BaseTool[]; blocked: { error?: GuardrailError } } {
+  const blocked: { error?: GuardrailError } = {};
+  const guarded = tools.map(
+    tool =>
+      ({
+        metadata: tool.metadata,
+        call: async (input: unknown) => {
+          let violation: GuardrailViolation | undefined;
+          try {
+            violation = await guardrails.review({
+              text: JSON.stringify(input ?? {}),
+              tool: tool.metadata.name,
+            });
+          } catch (error) {
+            if (error instanceof GuardrailError) {
+              blocked.error ??= error;
+            }
+            throw error;
+          }
+          return violation ? retryMessage(violation) : tool.call!(input);
+        },
+      }) as BaseTool
+  );
+  return { tools: guarded, blocked };
+}
+
+/**
+ * A tool running an agent on a task and returning its final reply
+ */
+function delegationTool(agent: Agent): BaseTool {
+  return FunctionTool.from(
+    async ({ task }: { task: string }) => (await agent.run(task)).result(),
+    {
+      name: agent.name,
+      description:
+        agent.description ?? `Delegate a task to the agent ${agent.name}`,
+      parameters: z.object({
+        task: z
+          .string()
+          .describe('The task, with everything the agent needs to know'),
+      }),
+    }
+  ) as unknown as BaseTool;
+}
+
+/**
+ * Create an Agent constructor that injects the chat message handlers
  * @param printChatMessage The handler to use for printing chat messages
- * @returns A constructor function that creates Agent instances with the handler injected
+ * @param streamChatMessage Optional handler receiving replies as they stream in
+ * @param transcript Optional recorder of the agents' runs
+ * @param guardrailHandlers Validators and escalation for agents' guardrails
+ * @returns A constructor function that creates Agent instances with the handlers injected
  */
-export function createAgent(printChatMessage: PrintChatMessageFn) {
+export function createAgent(
+  printChatMessage: PrintChatMessageFn,
+  streamChatMessage?: StreamChatMessageFn,
+  transcript?: TranscriptRecorder,
+  guardrailHandlers?: GuardrailHandlers
+) {
   return function (config: AgentConfig): Agent {
-    return new Agent(config, printChatMessage);
+    return new Agent(
+      config,
+      printChatMessage,
+      streamChatMessage,
+      transcript,
+      guardrailHandlers
+    );
   };
 }
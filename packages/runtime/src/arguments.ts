@@ -0,0 +1,65 @@
+// Arguments of a script's main tool
+//
+// A script declaring `tool main(service: string, replicas: number = 3)`
+// is run by calling main once its top-level code has run, with the
+// arguments the host was given by name, such as the flags of `mcps run
+// deploy.mcps -- --service api`. The arguments are checked against main's
+// parameter types here, so every host refuses the same ones, and bytes
+// parameters take base64, which is how JSON hosts give them.
+import type { z } from 'zod';
+import { isBytes } from './bytes.js';
+
+/**
+ * Arguments of a run, by parameter name of the script's main tool
+ */
+export type ScriptArguments = Record<string, unknown>;
+
+/**
+ * A tool as created by __createUserTool
+ */
+interface UserTool {
+  (...args: unknown[]): Promise<unknown>;
+  __mcps_params?: string[];
+  __mcps_schema?: z.ZodObject<z.ZodRawShape>;
+}
+
+/**
+ * Describe why arguments do not match main's parameters
+ */
+function describeIssues(error: z.ZodError): string {
+  return error.issues
+    .map(issue =>
+      issue.path.length > 0
+        ? `${issue.path.join('.')}: ${issue.message}`
+        : issue.message
+    )
+    .join('; ');
+}
+
+/**
+ * Call a script's main tool with the arguments of the run
+ */
+export async function runMain(
+  main: UserTool,
+  args: ScriptArguments = {}
+): Promise<unknown> {
+  const parameters = main.__mcps_params ?? [];
+  const extra = Object.keys(args).filter(name => !parameters.includes(name));
+  if (extra.length > 0) {
+    throw new TypeError(`main has no parameter named ${extra.join(', ')}`);
+  }
+  const checked = main.__mcps_schema?.safeParse(args);
+  if (checked && !checked.success) {
+    throw new TypeError(
+      `Invalid arguments of main: ${describeIssues(checked.error)}`
+    );
+  }
+  // Bytes given as base64 are passed on as bytes
+  const parsed = checked?.data as ScriptArguments | undefined;
+  return main(
+    ...parameters.map(name => {
+      const value = parsed?.[name];
+      return isBytes(value) ? value : args[name];
+    })
+  );
+}
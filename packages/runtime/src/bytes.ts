@@ -0,0 +1,182 @@
+// Binary values of scripts
+//
+// A bytes value is a Buffer: it is indexed and sliced like an array of
+// bytes, with data[0] and data.subarray(4, 8), and converted to text with
+// data.toString("base64") or any other of ENCODINGS. The bytes builtin
+// makes them from text in one of those encodings, from arrays of numbers
+// or from other bytes, joins them, and gives writers that gather bytes
+// written a chunk at a time, growing as needed. Tool calls given bytes send
+// them as base64, which is how MCP carries them, and the images, audio and
+// blob resources tools answer with are given to the script as bytes, so a
+// workflow moves binary artifacts from tool to tool without converting
+// them through strings.
+
+/** Encodings bytes are converted from and to */
+export const ENCODINGS = [
+  'utf-8',
+  'utf8',
+  'base64',
+  'base64url',
+  'hex',
+  'latin1',
+] as const;
+
+export type BytesEncoding = (typeof ENCODINGS)[number];
+
+/**
+ * Whether a value is bytes, as made here or by the script's Buffer
+ */
+export function isBytes(value: unknown): value is Uint8Array {
+  // Values created inside the VM come from another realm, so check the
+  // built-in tag rather than using instanceof
+  return Object.prototype.toString.call(value) === '[object Uint8Array]';
+}
+
+function checkEncoding(encoding: string): BytesEncoding {
+  if (!(ENCODINGS as readonly string[]).includes(encoding)) {
+    throw new TypeError(
+      `Unknown encoding ${encoding}, expected one of ${ENCODINGS.join(', ')}`
+    );
+  }
+  return encoding as BytesEncoding;
+}
+
+/**
+ * Bytes of text in an encoding, of an array of numbers from 0 to 255, or
+ * a copy of other bytes
+ */
+export function bytesFrom(value: unknown, encoding = 'utf-8'): Buffer {
+  if (typeof value === 'string') {
+    return Buffer.from(value, checkEncoding(encoding));
+  }
+  if (isBytes(value)) {
+    return Buffer.from(value);
+  }
+  if (Array.isArray(value)) {
+    const invalid = value.find(
+      byte => !Number.isInteger(byte) || byte < 0 || byte > 255
+    );
+    if (invalid !== undefined) {
+      throw new RangeError(`${String(invalid)} is not a byte`);
+    }
+    return Buffer.from(value as number[]);
+  }
+  throw new TypeError(
+    'bytes.from takes a string, an array of numbers or bytes'
+  );
+}
+
+/**
+ * Gathers bytes written a chunk at a time, such as the pages of a download
+ */
+export class BytesWriter {
+  private buffer = Buffer.alloc(1024);
+  private used = 0;
+
+  /** Number of bytes written so far */
+  get length(): number {
+    return this.used;
+  }
+
+  /**
+   * Append bytes, or text in an encoding; returns the writer, so writes
+   * can be chained
+   */
+  write(chunk: unknown, encoding = 'utf-8'): this {
+    const data = bytesFrom(chunk, encoding);
+    if (this.used + data.length > this.buffer.length) {
+      let size = this.buffer.length * 2;
+      while (size < this.used + data.length) {
+        size *= 2;
+      }
+      const grown = Buffer.alloc(size);
+      this.buffer.copy(grown, 0, 0, this.used);
+      this.buffer = grown;
+    }
+    data.copy(this.buffer, this.used);
+    this.used += data.length;
+    return this;
+  }
+
+  /**
+   * The bytes written so far, as a copy that later writes leave as is
+   */
+  bytes(): Buffer {
+    return Buffer.from(this.buffer.subarray(0, this.used));
+  }
+}
+
+/**
+ * The bytes builtin of scripts
+ */
+export const bytes = Object.freeze({
+  from: bytesFrom,
+  /** Zero-filled bytes of a size */
+  alloc(size: number): Buffer {
+    return Buffer.alloc(size);
+  },
+  /** Bytes joined in order */
+  concat(parts: unknown[]): Buffer {
+    return Buffer.concat(parts.map(part => bytesFrom(part)));
+  },
+  isBytes,
+  writer(): BytesWriter {
+    return new BytesWriter();
+  },
+});
+
+/**
+ * A value with the bytes in it replaced by their base64, as MCP sends them
+ */
+export function encodeBytes(value: unknown): unknown {
+  if (isBytes(value)) {
+    return Buffer.from(value).toString('base64');
+  }
+  if (Array.isArray(value)) {
+    return value.map(encodeBytes);
+  }
+  // Plain objects of any realm, such as those of the script
+  const proto =
+    typeof value === 'object' && value !== null
+      ? Object.getPrototypeOf(value)
+      : undefined;
+  if (proto === null || (proto && Object.getPrototypeOf(proto) === null)) {
+    return Object.fromEntries(
+      Object.entries(value as object).map(([key, item]) => [
+        key,
+        encodeBytes(item),
+      ])
+    );
+  }
+  return value;
+}
+
+/**
+ * The content of a tool result with its base64 images, audio and blob
+ * resources decoded to bytes
+ */
+export function decodeContent(content: unknown[]): unknown[] {
+  return content.map(item => {
+    const part = item as {
+      type?: string;
+      data?: unknown;
+      resource?: { blob?: unknown };
+    };
+    if (
+      (part.type === 'image' || part.type === 'audio') &&
+      typeof part.data === 'string'
+    ) {
+      return { ...part, data: Buffer.from(part.data, 'base64') };
+    }
+    if (part.type === 'resource' && typeof part.resource?.blob === 'string') {
+      return {
+        ...part,
+        resource: {
+          ...part.resource,
+          blob: Buffer.from(part.resource.blob, 'base64'),
+        },
+      };
+    }
+    return item;
+  });
+}
@@ -0,0 +1,221 @@
+// Timeouts and retries of MCP tool calls
+import { currentBranchSignal, runWithSignal } from './parallel.js';
+import { recordRetry } from './tracing.js';
+
+export type BackoffStrategy = 'exponential' | 'linear' | 'constant';
+
+export const BACKOFF_STRATEGIES: readonly BackoffStrategy[] = [
+  'exponential',
+  'linear',
+  'constant',
+];
+
+/**
+ * How a tool call is bounded and retried, set per server in the mcp block
+ * and per call with `with { ... }`
+ */
+export interface CallPolicy {
+  /** Milliseconds each attempt may take */
+  timeout?: number;
+  /** Attempts made after the first one fails with a transient error */
+  retries?: number;
+  /** How the wait between attempts grows (default: exponential) */
+  backoff?: BackoffStrategy;
+}
+
+/** Wait before the first retry; later waits grow from it */
+export const RETRY_BASE_DELAY = 200;
+/** Longest wait between attempts */
+export const RETRY_MAX_DELAY = 10_000;
+
+/**
+ * Error thrown when a call attempt runs past its timeout
+ */
+export class CallTimeoutError extends Error {
+  constructor(
+    public readonly tool: string,
+    public readonly timeout: number
+  ) {
+    super(`Tool ${tool} timed out after ${timeout} ms`);
+    this.name = 'CallTimeoutError';
+  }
+}
+
+/**
+ * Check the fields of a call policy, whose values may only be known when
+ * the script runs
+ */
+export function checkCallPolicy(policy: CallPolicy, label: string): void {
+  const { timeout, retries, backoff } = policy;
+  if (timeout !== undefined && !(typeof timeout === 'number' && timeout > 0)) {
+    throw new TypeError(
+      `${label}: timeout must be a positive number of milliseconds, got ${timeout}`
+    );
+  }
+  if (
+    retries !== undefined &&
+    !(Number.isInteger(retries) && retries >= 0)
+  ) {
+    throw new TypeError(
+      `${label}: retries must be a non-negative integer, got ${retries}`
+    );
+  }
+  if (backoff !== undefined && !BACKOFF_STRATEGIES.includes(backoff)) {
+    throw new TypeError(
+      `${label}: backoff must be one of ${BACKOFF_STRATEGIES.join(', ')}, got ${backoff}`
+    );
+  }
+}
+
+/** Codes of MCP errors for timeouts and dropped connections */
+const TRANSIENT_MCP_CODES = new Set([-32000, -32001]);
+/** HTTP statuses of remote servers worth retrying */
+const TRANSIENT_HTTP_STATUSES = new Set([408, 425, 429, 500, 502, 503, 504]);
+/** Network errors from Node.js */
+const TRANSIENT_SYSTEM_CODES = new Set([
+  'ECONNRESET',
+  'ECONNREFUSED',
+  'ECONNABORTED',
+  'EPIPE',
+  'ETIMEDOUT',
+  'EAI_AGAIN',
+  'UND_ERR_SOCKET',
+  'UND_ERR_CONNECT_TIMEOUT',
+]);
+
+/**
+ * Whether a failed call may succeed if made again: timeouts, dropped
+ * connections, network errors and overloaded servers
+ * Errors the tool reports, and invalid input, are not retried
+ */
+export function isTransientError(error: unknown): boolean {
+  if (error instanceof CallTimeoutError) {
+    return true;
+  }
+  if (typeof error !== 'object' || error === null) {
+    return false;
+  }
+  const { code, cause, message } = error as {
+    code?: unknown;
+    cause?: unknown;
+    message?: unknown;
+  };
+  if (typeof code === 'number') {
+    return TRANSIENT_MCP_CODES.has(code) || TRANSIENT_HTTP_STATUSES.has(code);
+  }
+  if (typeof code === 'string') {
+    return TRANSIENT_SYSTEM_CODES.has(code);
+  }
+  // fetch() reports network errors as "fetch failed" with the cause attached
+  if (message === 'fetch failed') {
+    return true;
+  }
+  return cause !== undefined && cause !== error && isTransientError(cause);
+}
+
+/**
+ * Milliseconds to wait before retry number `retry` (1 for the first)
+ */
+export function retryDelay(
+  backoff: BackoffStrategy = 'exponential',
+  retry: number
+): number {
+  const delay =
+    backoff === 'exponential'
+      ? RETRY_BASE_DELAY * 2 ** (retry - 1)
+      : backoff === 'linear'
+        ? RETRY_BASE_DELAY * retry
+        : RETRY_BASE_DELAY;
+  return Math.min(delay, RETRY_MAX_DELAY);
+}
+
+function sleep(ms: number, signal: AbortSignal | undefined): Promise<void> {
+  return new Promise((resolve, reject) => {
+    if (signal?.aborted) {
+      reject(signal.reason);
+      return;
+    }
+    const onAbort = () => {
+      clearTimeout(timer);
+      reject(signal!.reason);
+    };
+    const timer = setTimeout(() => {
+      signal?.removeEventListener('abort', onAbort);
+      resolve();
+    }, ms);
+    signal?.addEventListener('abort', onAbort, { once: true });
+  });
+}
+
+/**
+ * One attempt, cut off after `timeout` milliseconds
+ * The attempt runs with an abort signal that fires on the timeout or when
+ * the enclosing parallel branch is cancelled, so the server is told to
+ * stop; the attempt is abandoned even if it ignores the signal
+ */
+async function attemptWithTimeout<T>(
+  tool: string,
+  timeout: number | undefined,
+  attempt: () => Promise<T>
+): Promise<T> {
+  const outer = currentBranchSignal();
+  if (timeout === undefined) {
+    return attempt();
+  }
+
+  const controller = new AbortController();
+  const cancel = () => controller.abort(outer?.reason);
+  if (outer?.aborted) {
+    cancel();
+  }
+  outer?.addEventListener('abort', cancel, { once: true });
+  let timer: NodeJS.Timeout | undefined;
+  const deadline = new Promise<never>((_, reject) => {
+    const fail = () => reject(controller.signal.reason);
+    timer = setTimeout(
+      () => controller.abort(new CallTimeoutError(tool, timeout)),
+      timeout
+    );
+    controller.signal.addEventListener('abort', fail, { once: true });
+  });
+  // The deadline may reject after the attempt has settled
+  deadline.catch(() => {});
+
+  try {
+    return await Promise.race([
+      runWithSignal(controller.signal, attempt),
+      deadline,
+    ]);
+  } finally {
+    clearTimeout(timer);
+    outer?.removeEventListener('abort', cancel);
+  }
+}
+
+/**
+ * Call a tool under a policy: each attempt is bounded by the timeout, and
+ * attempts failing with a transient error are repeated up to `retries`
+ * times, waiting longer before each according to the backoff
+ * Cancelling the enclosing parallel branch stops the retries, and each
+ * retry is recorded on the span tracing the call
+ */
+export async function callWithPolicy<T>(
+  tool: string,
+  policy: CallPolicy,
+  attempt: () => Promise<T>
+): Promise<T> {
+  checkCallPolicy(policy, tool);
+  const { timeout, retries = 0, backoff } = policy;
+  for (let retry = 0; ; retry++) {
+    try {
+      return await attemptWithTimeout(tool, timeout, attempt);
+    } catch (error) {
+      const signal = currentBranchSignal();
+      if (retry >= retries || signal?.aborted || !isTransientError(error)) {
+        throw error;
+      }
+      recordRetry(retry + 1, error);
+      await sleep(retryDelay(backoff, retry + 1), signal);
+    }
+  }
+}
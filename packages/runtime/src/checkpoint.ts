@@ -0,0 +1,288 @@
+// Checkpoints of script runs
+//
+// Code generated with checkpoints saves the script's variables after each
+// of its top-level statements finishes. A run that fails or crashes can
+// then be resumed: its variables are restored from the last checkpoint and
+// the statements that had finished are skipped, so their tool calls and
+// agent runs are not made again. A statement is the unit of work, so one
+// that was interrupted runs again from its start.
+import type { ChatMessage } from '@llamaindex/core/llms';
+import { Conversation } from './conversation.js';
+
+/**
+ * State of a run after one of its top-level statements
+ */
+export interface Checkpoint {
+  /** Hash of the generated code, so a changed script is not resumed */
+  script: string;
+  /** Number of the last statement that finished, from 1 */
+  statement: number;
+  /** Values of the script's variables, as encoded by encodeValue */
+  variables: Record<string, unknown>;
+  time: number;
+}
+
+/**
+ * Where the checkpoints of runs are kept, by run id; each run keeps only
+ * its latest checkpoint
+ */
+export interface CheckpointStore {
+  load(run: string): Promise<Checkpoint | undefined>;
+  save(run: string, checkpoint: Checkpoint): Promise<void>;
+  /** Forget a run, e.g. once it has finished */
+  delete(run: string): Promise<void>;
+}
+
+/**
+ * Keeps checkpoints in memory, for tests and embedders that resume runs
+ * within one process
+ */
+export class MemoryCheckpointStore implements CheckpointStore {
+  private readonly checkpoints = new Map<string, Checkpoint>();
+
+  async load(run: string): Promise<Checkpoint | undefined> {
+    return this.checkpoints.get(run);
+  }
+
+  async save(run: string, checkpoint: Checkpoint): Promise<void> {
+    this.checkpoints.set(run, checkpoint);
+  }
+
+  async delete(run: string): Promise<void> {
+    this.checkpoints.delete(run);
+  }
+}
+
+/**
+ * Error thrown for runs that cannot be checkpointed or resumed
+ */
+export class CheckpointError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'CheckpointError';
+  }
+}
+
+/** Key marking values JSON has no form of */
+const TAG = '$mcps';
+
+/**
+ * JSON form of a variable's value; besides JSON values, sets, maps, dates
+ * and conversations can be saved
+ */
+export function encodeValue(value: unknown, path = 'value'): unknown {
+  if (value === undefined) {
+    return { [TAG]: 'undefined' };
+  }
+  if (value === null || typeof value !== 'object') {
+    if (typeof value === 'function' || typeof value === 'symbol') {
+      throw new CheckpointError(`${path} is a ${typeof value}`);
+    }
+    if (typeof value === 'bigint') {
+      return { [TAG]: 'bigint', value: String(value) };
+    }
+    return value;
+  }
+  if (Array.isArray(value)) {
+    return value.map((item, index) => encodeValue(item, `${path}[${index}]`));
+  }
+  if (value instanceof Set) {
+    return {
+      [TAG]: 'set',
+      values: [...value].map(item => encodeValue(item, path)),
+    };
+  }
+  if (value instanceof Map) {
+    return {
+      [TAG]: 'map',
+      entries: [...value].map(([key, item]) => [
+        encodeValue(key, path),
+        encodeValue(item, path),
+      ]),
+    };
+  }
+  if (value instanceof Date) {
+    return { [TAG]: 'date', value: value.toISOString() };
+  }
+  if (value instanceof Conversation) {
+    return { [TAG]: 'conversation', messages: value.getMessages() };
+  }
+  // Objects made by the script belong to the realm of its context, so
+  // plain objects are told apart by their prototype having none
+  const prototype = Object.getPrototypeOf(value);
+  if (prototype !== null && Object.getPrototypeOf(prototype) !== null) {
+    const name = prototype.constructor?.name ?? 'object';
+    throw new CheckpointError(`${path} is a ${name}`);
+  }
+  const fields = Object.entries(value).map(([key, item]) => [
+    key,
+    encodeValue(item, `${path}.${key}`),
+  ]);
+  // Objects that look like encoded values are wrapped so they decode as
+  // they were
+  return TAG in value
+    ? { [TAG]: 'object', fields }
+    : Object.fromEntries(fields);
+}
+
+/**
+ * Value a variable had when it was encoded by encodeValue
+ */
+export function decodeValue(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map(decodeValue);
+  }
+  if (value === null || typeof value !== 'object') {
+    return value;
+  }
+  const object = value as Record<string, unknown>;
+  switch (object[TAG]) {
+    case undefined:
+      return Object.fromEntries(
+        Object.entries(object).map(([key, item]) => [key, decodeValue(item)])
+      );
+    case 'undefined':
+      return undefined;
+    case 'bigint':
+      return BigInt(object.value as string);
+    case 'set':
+      return new Set((object.values as unknown[]).map(decodeValue));
+    case 'map':
+      return new Map(
+        (object.entries as Array<[unknown, unknown]>).map(([key, item]) => [
+          decodeValue(key),
+          decodeValue(item),
+        ])
+      );
+    case 'date':
+      return new Date(object.value as string);
+    case 'conversation': {
+      const conversation = new Conversation();
+      for (const message of object.messages as ChatMessage[]) {
+        conversation.addMessage(message);
+      }
+      return conversation;
+    }
+    case 'object':
+      return Object.fromEntries(
+        (object.fields as Array<[string, unknown]>).map(([key, item]) => [
+          key,
+          decodeValue(item),
+        ])
+      );
+    default:
+      throw new CheckpointError(`Unknown saved value ${String(object[TAG])}`);
+  }
+}
+
+/**
+ * Options of a run that saves checkpoints
+ */
+export interface CheckpointOptions {
+  store: CheckpointStore;
+  /** Id of the run, which its checkpoints are saved under */
+  run: string;
+  /** Continue the run from its last checkpoint instead of starting it */
+  resume?: boolean;
+}
+
+/**
+ * The checkpoints of one run, as used by generated code through
+ * `__checkpoint`
+ */
+export class ScriptCheckpoints {
+  /** Variables whose values could not be saved, reported once each */
+  private readonly unsaved = new Set<string>();
+
+  private constructor(
+    private readonly options: CheckpointOptions,
+    private readonly script: string,
+    private readonly resumed: Checkpoint | undefined,
+    private readonly warn: (message: string) => void
+  ) {}
+
+  /**
+   * Start the checkpoints of a run of the given code, loading the
+   * checkpoint to continue from when the run is resumed
+   * @param script Hash of the generated code
+   */
+  static async open(
+    options: CheckpointOptions,
+    script: string,
+    warn: (message: string) => void = () => {}
+  ): Promise<ScriptCheckpoints> {
+    let resumed: Checkpoint | undefined;
+    if (options.resume) {
+      resumed = await options.store.load(options.run);
+      if (!resumed) {
+        throw new CheckpointError(`Run ${options.run} has no checkpoint`);
+      }
+      if (resumed.script !== script) {
+        throw new CheckpointError(
+          `Run ${options.run} was checkpointed with another version of the script`
+        );
+      }
+    }
+    return new ScriptCheckpoints(options, script, resumed, warn);
+  }
+
+  /** Number of the last statement the resumed run had finished */
+  get resumedAfter(): number {
+    return this.resumed?.statement ?? 0;
+  }
+
+  /**
+   * Whether a statement still needs to run
+   */
+  pending(statement: number): boolean {
+    return statement > this.resumedAfter;
+  }
+
+  /**
+   * Value of a variable when the resumed run was checkpointed
+   */
+  restore(name: string): unknown {
+    const saved = this.resumed?.variables[name];
+    return saved === undefined ? undefined : decodeValue(saved);
+  }
+
+  /**
+   * Save the variables after a statement has finished. When one of them
+   * cannot be saved, the run keeps its previous checkpoint
+   */
+  async save(
+    statement: number,
+    variables: Record<string, unknown>
+  ): Promise<void> {
+    const encoded: Record<string, unknown> = {};
+    for (const [name, value] of Object.entries(variables)) {
+      try {
+        encoded[name] = encodeValue(value, name);
+      } catch (error) {
+        if (!(error instanceof CheckpointError)) {
+          throw error;
+        }
+        if (!this.unsaved.has(name)) {
+          this.unsaved.add(name);
+          this.warn(
+            `Cannot checkpoint the run while ${error.message}; it will resume from an earlier statement`
+          );
+        }
+        return;
+      }
+    }
+    await this.options.store.save(this.options.run, {
+      script: this.script,
+      statement,
+      variables: encoded,
+      time: Date.now(),
+    });
+  }
+
+  /**
+   * Forget the run's checkpoint once it has finished
+   */
+  async finish(): Promise<void> {
+    await this.options.store.delete(this.options.run);
+  }
+}
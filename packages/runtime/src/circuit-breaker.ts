@@ -0,0 +1,162 @@
+// Circuit breakers of MCP tool calls
+//
+// A server that keeps failing is given a rest: once calls of one of its
+// tools fail with transient errors a number of times in a row, the tool's
+// circuit opens and further calls fail at once, without reaching the
+// server and without being retried. After a cooldown, a single trial call
+// is let through; its success closes the circuit, its failure opens it
+// again for another cooldown.
+import { isTransientError } from './call-policy.js';
+
+/**
+ * When the circuits of a server's tools open, set in the mcp block
+ */
+export interface CircuitBreakerOptions {
+  /** Transient failures in a row that open a tool's circuit */
+  failures?: number;
+  /** Milliseconds an open circuit waits before a trial call */
+  cooldown?: number;
+}
+
+export const DEFAULT_CIRCUIT_FAILURES = 5;
+export const DEFAULT_CIRCUIT_COOLDOWN = 30_000;
+
+/**
+ * Error thrown for calls of a tool whose circuit is open
+ */
+export class CircuitOpenError extends Error {
+  constructor(
+    public readonly tool: string,
+    /** Failures in a row that opened the circuit */
+    public readonly failures: number,
+    /** Milliseconds until a trial call is let through (0 while one runs) */
+    public readonly retryAfter: number
+  ) {
+    super(
+      `Tool ${tool} is unavailable after ${failures} failed calls in a row; ` +
+        (retryAfter > 0
+          ? `calls resume in ${Math.ceil(retryAfter / 1000)} s`
+          : 'a trial call is in progress')
+    );
+    this.name = 'CircuitOpenError';
+  }
+}
+
+/**
+ * Check the fields of circuit breaker options, whose values may only be
+ * known when the script runs
+ */
+export function checkCircuitBreaker(
+  options: CircuitBreakerOptions,
+  label: string
+): void {
+  const { failures, cooldown } = options;
+  if (
+    failures !== undefined &&
+    !(Number.isInteger(failures) && failures > 0)
+  ) {
+    throw new TypeError(
+      `${label}: circuitBreaker.failures must be a positive integer, got ${failures}`
+    );
+  }
+  if (
+    cooldown !== undefined &&
+    !(typeof cooldown === 'number' && cooldown > 0)
+  ) {
+    throw new TypeError(
+      `${label}: circuitBreaker.cooldown must be a positive number of milliseconds, got ${cooldown}`
+    );
+  }
+}
+
+interface Circuit {
+  /** Transient failures since the last call that reached the server */
+  failures: number;
+  /** When the circuit opened, if it is open */
+  openedAt?: number;
+  /** Whether the trial call of a half-open circuit is running */
+  probing: boolean;
+}
+
+/**
+ * The circuits of the tools of one server
+ */
+export class CircuitBreaker {
+  private readonly circuits = new Map<string, Circuit>();
+  private readonly failures: number;
+  private readonly cooldown: number;
+
+  constructor(options: CircuitBreakerOptions = {}, label = 'circuitBreaker') {
+    checkCircuitBreaker(options, label);
+    this.failures = options.failures ?? DEFAULT_CIRCUIT_FAILURES;
+    this.cooldown = options.cooldown ?? DEFAULT_CIRCUIT_COOLDOWN;
+  }
+
+  /**
+   * State of a tool's circuit: "half-open" once the cooldown of an open
+   * circuit has passed
+   */
+  state(tool: string): 'closed' | 'open' | 'half-open' {
+    const circuit = this.circuits.get(tool);
+    if (circuit?.openedAt === undefined) {
+      return 'closed';
+    }
+    return Date.now() - circuit.openedAt >= this.cooldown
+      ? 'half-open'
+      : 'open';
+  }
+
+  /**
+   * Make one attempt of a call to a tool, unless its circuit is open
+   * Errors that are not transient show the server answered, so they count
+   * as successes here
+   */
+  async call<T>(tool: string, attempt: () => Promise<T>): Promise<T> {
+    let circuit = this.circuits.get(tool);
+    if (!circuit) {
+      circuit = { failures: 0, probing: false };
+      this.circuits.set(tool, circuit);
+    }
+
+    const state = this.state(tool);
+    if (state === 'open' || (state === 'half-open' && circuit.probing)) {
+      const waited = Date.now() - circuit.openedAt!;
+      throw new CircuitOpenError(
+        tool,
+        circuit.failures,
+        Math.max(this.cooldown - waited, 0)
+      );
+    }
+    const probe = state === 'half-open';
+    circuit.probing = probe;
+
+    try {
+      const result = await attempt();
+      this.succeeded(circuit);
+      return result;
+    } catch (error) {
+      if (isTransientError(error)) {
+        this.failed(circuit, probe);
+      } else {
+        this.succeeded(circuit);
+      }
+      throw error;
+    } finally {
+      if (probe) {
+        circuit.probing = false;
+      }
+    }
+  }
+
+  private succeeded(circuit: Circuit): void {
+    circuit.failures = 0;
+    circuit.openedAt = undefined;
+  }
+
+  private failed(circuit: Circuit, probe: boolean): void {
+    circuit.failures++;
+    if (probe || circuit.failures >= this.failures) {
+      circuit.openedAt = Date.now();
+    }
+  }
+}
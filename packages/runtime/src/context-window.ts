@@ -0,0 +1,193 @@
+// Keeping long agent runs within their model's context window
+//
+// An agent that calls many tools grows its conversation with every turn,
+// until the model's provider refuses it as too long. Before each turn, a
+// conversation nearing the limit is compacted instead: the results of
+// older tool calls are cut short first, and if that is not enough, the
+// older messages are replaced by a summary the agent's model writes (or
+// dropped, when the agent does not want summaries). The latest messages
+// and the system prompt are always kept as they are.
+import type { BaseLLM, ChatMessage } from '@llamaindex/core/llms';
+import { estimateTokens } from './routing.js';
+
+/** Share of the limit a conversation may fill before it is compacted */
+export const DEFAULT_CONTEXT_THRESHOLD = 0.8;
+/** Latest messages kept as they are */
+export const DEFAULT_KEPT_MESSAGES = 6;
+/** Characters of older tool results kept */
+export const DEFAULT_TOOL_RESULT_LIMIT = 2000;
+
+/** Instructions of the model summarizing older messages */
+const SUMMARY_PROMPT =
+  'Summarize the conversation below for the assistant that will continue ' +
+  'it. Keep the task, facts, decisions, open questions and the tool ' +
+  'results still needed; leave out everything else.';
+
+/** Start of the message holding the summary of older messages */
+export const SUMMARY_HEADING = '[Summary of the earlier conversation]';
+
+/**
+ * How an agent keeps its conversation within its model's context window
+ */
+export interface ContextOptions {
+  /**
+   * Tokens the conversation may have (default: the contextWindow of the
+   * agent's model, or the smallest of its models'); nothing is compacted
+   * without one
+   */
+  limit?: number;
+  /** Share of the limit filled before compacting (default: 0.8) */
+  threshold?: number;
+  /** Latest messages kept as they are (default: 6) */
+  keep?: number;
+  /** Characters of older tool results kept (default: 2000) */
+  toolResultLimit?: number;
+  /** Summarize older messages rather than drop them (default: true) */
+  summarize?: boolean;
+}
+
+/**
+ * Check the fields of context options, whose values may only be known
+ * when the script runs
+ */
+export function checkContextOptions(options: unknown, label: string): void {
+  if (typeof options !== 'object' || options === null) {
+    throw new TypeError(`${label}: context must be an object`);
+  }
+  for (const [field, value] of Object.entries(options)) {
+    switch (field) {
+      case 'limit':
+      case 'keep':
+      case 'toolResultLimit':
+        if (!(Number.isInteger(value) && value >= 0)) {
+          throw new TypeError(
+            `${label}: context.${field} must be a non-negative integer, got ${value}`
+          );
+        }
+        break;
+      case 'threshold':
+        if (!(typeof value === 'number' && value > 0 && value <= 1)) {
+          throw new TypeError(
+            `${label}: context.threshold must be a number above 0 and at most 1, got ${value}`
+          );
+        }
+        break;
+      case 'summarize':
+        if (typeof value !== 'boolean') {
+          throw new TypeError(
+            `${label}: context.summarize must be true or false, got ${value}`
+          );
+        }
+        break;
+      default:
+        throw new TypeError(`${label}: unknown context option ${field}`);
+    }
+  }
+}
+
+function isToolResult(message: ChatMessage): boolean {
+  return (
+    typeof message.options === 'object' &&
+    message.options !== null &&
+    'toolResult' in message.options
+  );
+}
+
+function messageText(message: ChatMessage): string {
+  return typeof message.content === 'string'
+    ? message.content
+    : JSON.stringify(message.content);
+}
+
+/**
+ * Cut a tool result's text short, noting how much was left out
+ */
+function truncateToolResult(message: ChatMessage, limit: number): ChatMessage {
+  const text = messageText(message);
+  if (text.length <= limit) {
+    return message;
+  }
+  const { toolResult } = message.options as {
+    toolResult: { id: string; result: unknown; isError: boolean };
+  };
+  const cut = `${text.slice(0, limit)}\n[${text.length - limit} characters left out]`;
+  return {
+    ...message,
+    content: cut,
+    options: { toolResult: { ...toolResult, result: cut } },
+  } as ChatMessage;
+}
+
+/**
+ * Ask a model for a summary of messages
+ */
+export async function summarizeMessages(
+  llm: BaseLLM,
+  messages: ChatMessage[]
+): Promise<string> {
+  const transcript = messages
+    .map(message => `${message.role}: ${messageText(message)}`)
+    .join('\n\n');
+  const { newMessages } = await llm.exec({
+    messages: [
+      { role: 'system', content: SUMMARY_PROMPT },
+      { role: 'user', content: transcript },
+    ],
+  });
+  const reply = newMessages.filter(message => message.role === 'assistant');
+  return reply.map(messageText).join('\n');
+}
+
+/**
+ * The messages of a conversation fitted within a limit of tokens, or
+ * undefined when they fit already
+ * @param summarize Writes the summary of older messages; without it they
+ * are dropped
+ */
+export async function compactMessages(
+  messages: ChatMessage[],
+  limit: number,
+  options: ContextOptions = {},
+  summarize?: (messages: ChatMessage[]) => Promise<string>
+): Promise<ChatMessage[] | undefined> {
+  const budget = limit * (options.threshold ?? DEFAULT_CONTEXT_THRESHOLD);
+  if (estimateTokens(messages) <= budget) {
+    return undefined;
+  }
+
+  // The system prompt stays first; of the rest, the latest are kept
+  const start = messages.findIndex(message => message.role !== 'system');
+  const head = start === -1 ? messages : messages.slice(0, start);
+  const body = start === -1 ? [] : messages.slice(start);
+  let cut = body.length - (options.keep ?? DEFAULT_KEPT_MESSAGES);
+
+  // Results of older tool calls are cut short first
+  const toolResultLimit = options.toolResultLimit ?? DEFAULT_TOOL_RESULT_LIMIT;
+  const truncated = body.map((message, index) =>
+    index < cut && isToolResult(message)
+      ? truncateToolResult(message, toolResultLimit)
+      : message
+  );
+  if (estimateTokens([...head, ...truncated]) <= budget) {
+    return [...head, ...truncated];
+  }
+
+  // A tool call and its results go together, so the kept messages may not
+  // start with a result
+  while (cut > 0 && isToolResult(truncated[cut])) {
+    cut--;
+  }
+  if (cut <= 0) {
+    return [...head, ...truncated];
+  }
+  const older = truncated.slice(0, cut);
+  const kept = truncated.slice(cut);
+  if (!summarize) {
+    return [...head, ...kept];
+  }
+  const summary: ChatMessage = {
+    role: 'user',
+    content: `${SUMMARY_HEADING}\n${await summarize(older)}`,
+  };
+  return [...head, summary, ...kept];
+}
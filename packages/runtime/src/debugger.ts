@@ -0,0 +1,262 @@
+// Pausing and stepping through scripts compiled with debug hooks
+//
+// Code generated with the debugHooks option calls __debug.before() ahead of
+// every statement and runs each tool body through __debug.call(). Those
+// hooks keep a stack of frames per chain of async calls and, when a
+// breakpoint or step lands on a statement, hold the script there until the
+// debugger is told to go on.
+import { AsyncLocalStorage } from 'async_hooks';
+import type { ToolCallObserver } from './mcp.js';
+
+/**
+ * Getters of the variables in scope at a statement, by name
+ */
+export type ScopeGetters = Record<string, () => unknown>;
+
+/**
+ * One frame of a paused script (line and column are 1-based)
+ */
+export interface DebugFrame {
+  /** Tool the frame is in, or undefined for top-level script code */
+  tool?: string;
+  line: number;
+  column: number;
+  /** Values of the variables in scope; unreadable ones are left out */
+  variables(): Record<string, unknown>;
+  /** The last tool called from this frame that returned, and its value */
+  returned?: { tool: string; value: unknown };
+}
+
+/**
+ * An MCP tool call that has been sent and not yet answered
+ */
+export interface PendingToolCall {
+  id: number;
+  /** The tool, as server.tool */
+  tool: string;
+  input: unknown;
+  /** When the call was sent, in milliseconds since the epoch */
+  startedAt: number;
+}
+
+export type StopReason = 'entry' | 'breakpoint' | 'step' | 'pause';
+
+export interface ScriptDebuggerOptions {
+  /** Pause before the first statement (default: false) */
+  stopOnEntry?: boolean;
+  /** Called each time the script pauses */
+  onStop?: (reason: StopReason) => void;
+  /**
+   * Called before each statement, with the tool it is in, if any, so the
+   * statements a run executes can be followed without pausing it
+   */
+  onStatement?: (line: number, column: number, tool?: string) => void;
+}
+
+interface Frame {
+  tool?: string;
+  line: number;
+  column: number;
+  scope: ScopeGetters;
+  returned?: { tool: string; value: unknown };
+}
+
+/** How far the script runs before pausing again */
+type RunMode = 'run' | 'pause' | 'in' | 'over' | 'out';
+
+/**
+ * The __debug object of a debugged script, and the controls of a debugger
+ * client: breakpoints, stepping and inspection of the paused script
+ * Parallel branches share the stack of the statement that started them,
+ * and while one of them is paused the others pause at their next statement
+ */
+export class ScriptDebugger implements ToolCallObserver {
+  private breakpoints = new Set<number>();
+  private mode: RunMode;
+  private pauseReason: StopReason = 'entry';
+  /** Depth of the stack a step started from */
+  private stepDepth = 0;
+  private readonly stacks = new AsyncLocalStorage<Frame[]>();
+  private readonly root: Frame[] = [{ line: 0, column: 0, scope: {} }];
+  private paused?: {
+    frames: Frame[];
+    resume: () => void;
+    until: Promise<void>;
+  };
+  private readonly calls = new Map<number, PendingToolCall>();
+  private nextCallId = 1;
+
+  constructor(private readonly options: ScriptDebuggerOptions = {}) {
+    this.mode = options.stopOnEntry ? 'pause' : 'run';
+  }
+
+  /**
+   * Replace the lines execution pauses at
+   */
+  setBreakpoints(lines: number[]): void {
+    this.breakpoints = new Set(lines);
+  }
+
+  /**
+   * Whether the script is paused
+   */
+  get isPaused(): boolean {
+    return this.paused !== undefined;
+  }
+
+  /**
+   * Frames of the paused script, innermost first; empty while it runs
+   */
+  frames(): DebugFrame[] {
+    if (!this.paused) {
+      return [];
+    }
+    return [...this.paused.frames].reverse().map(frame => ({
+      tool: frame.tool,
+      line: frame.line,
+      column: frame.column,
+      returned: frame.returned,
+      variables: () => readScope(frame.scope),
+    }));
+  }
+
+  /**
+   * MCP tool calls waiting for an answer, oldest first
+   */
+  pendingCalls(): PendingToolCall[] {
+    return [...this.calls.values()];
+  }
+
+  /** Run until the next breakpoint */
+  continue(): void {
+    this.resume('run');
+  }
+
+  /** Run to the next statement of the current frame or one it returns to */
+  next(): void {
+    this.resume('over');
+  }
+
+  /** Run to the next statement, in a tool the current one calls if any */
+  stepIn(): void {
+    this.resume('in');
+  }
+
+  /** Run until the current tool returns */
+  stepOut(): void {
+    this.resume('out');
+  }
+
+  /** Pause at the next statement */
+  pause(): void {
+    if (!this.paused) {
+      this.mode = 'pause';
+      this.pauseReason = 'pause';
+    }
+  }
+
+  /**
+   * Hook run before each statement
+   */
+  async before(
+    line: number,
+    column: number,
+    scope: ScopeGetters
+  ): Promise<void> {
+    const stack = this.stack();
+    const frame = stack[stack.length - 1];
+    frame.line = line;
+    frame.column = column;
+    frame.scope = scope;
+    this.options.onStatement?.(line, column, frame.tool);
+
+    // Other branches wait while one of them is paused
+    while (this.paused) {
+      await this.paused.until;
+    }
+
+    const reason = this.stopReason(stack.length, line);
+    if (reason) {
+      await this.stop(stack, reason);
+    }
+  }
+
+  /**
+   * Hook running a tool body in a frame of its own
+   */
+  async call<T>(tool: string, body: () => Promise<T>): Promise<T> {
+    const caller = this.stack();
+    const frame: Frame = { tool, line: 0, column: 0, scope: {} };
+    const value = await this.stacks.run([...caller, frame], body);
+    caller[caller.length - 1].returned = { tool, value };
+    return value;
+  }
+
+  /**
+   * Track an MCP tool call until it is answered
+   */
+  toolCallStarted(tool: string, input: unknown): () => void {
+    const id = this.nextCallId++;
+    this.calls.set(id, { id, tool, input, startedAt: Date.now() });
+    return () => this.calls.delete(id);
+  }
+
+  private stack(): Frame[] {
+    return this.stacks.getStore() ?? this.root;
+  }
+
+  private stopReason(depth: number, line: number): StopReason | undefined {
+    switch (this.mode) {
+      case 'pause':
+        return this.pauseReason;
+      case 'in':
+        return 'step';
+      case 'over':
+        if (depth <= this.stepDepth) {
+          return 'step';
+        }
+        break;
+      case 'out':
+        if (depth < this.stepDepth) {
+          return 'step';
+        }
+        break;
+    }
+    return this.breakpoints.has(line) ? 'breakpoint' : undefined;
+  }
+
+  private stop(stack: Frame[], reason: StopReason): Promise<void> {
+    let resume!: () => void;
+    const until = new Promise<void>(resolve => (resume = resolve));
+    this.paused = {
+      frames: stack.map(frame => ({ ...frame })),
+      resume,
+      until,
+    };
+    this.options.onStop?.(reason);
+    return until;
+  }
+
+  private resume(mode: RunMode): void {
+    const paused = this.paused;
+    if (!paused) {
+      return;
+    }
+    this.mode = mode;
+    this.stepDepth = paused.frames.length;
+    this.paused = undefined;
+    paused.resume();
+  }
+}
+
+function readScope(scope: ScopeGetters): Record<string, unknown> {
+  const values: Record<string, unknown> = {};
+  for (const [name, get] of Object.entries(scope)) {
+    try {
+      values[name] = get();
+    } catch {
+      // Not assigned yet on this path
+    }
+  }
+  return values;
+}
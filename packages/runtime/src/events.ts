@@ -0,0 +1,111 @@
+// Events of event-driven scripts
+//
+// A script handles events with `on event("deploy.finished") (deploy) {
+// ... }` and sends them with `emit("deploy.finished", payload)`. An emitted
+// event is handled by the script's own handlers, in the order they were
+// declared, before emit returns, and is then handed to the host, which may
+// deliver it further: `mcps serve --http` runs the handlers of the other
+// scripts it serves, as it does for events posted to its webhook or taken
+// from its queue. Payloads travel between scripts, so they are copied as
+// JSON, and a handler whose parameter is typed refuses payloads of another
+// type.
+import type { z } from 'zod';
+import type { ScriptEventHandler } from './types.js';
+
+/**
+ * Receives the events a script emits, after its own handlers ran
+ */
+export type EventPublisher = (
+  event: string,
+  payload: unknown
+) => void | Promise<void>;
+
+/**
+ * Copy a payload as JSON, so handlers see what other scripts would
+ */
+function toJson(event: string, payload: unknown): unknown {
+  if (payload === undefined) {
+    return null;
+  }
+  try {
+    return JSON.parse(JSON.stringify(payload));
+  } catch (error) {
+    throw new TypeError(
+      `emit: payload of event ${event} is not JSON: ${(error as Error).message}`
+    );
+  }
+}
+
+/**
+ * Describe why a payload does not match a handler's type
+ */
+function describeIssues(error: z.ZodError): string {
+  return error.issues
+    .map(issue =>
+      issue.path.length > 0
+        ? `${issue.path.join('.')}: ${issue.message}`
+        : issue.message
+    )
+    .join('; ');
+}
+
+/**
+ * The event handlers of a run, as used by generated code through `__on`,
+ * and the `emit` builtin
+ */
+export class ScriptEvents {
+  private readonly handlers: ScriptEventHandler[] = [];
+
+  /**
+   * @param collected Collects the script's handlers for the host
+   * @param publish Hands the events the script emits to the host
+   */
+  constructor(
+    private readonly collected?: ScriptEventHandler[],
+    private readonly publish?: EventPublisher
+  ) {}
+
+  /**
+   * Register a handler of the events with the given name
+   * @param schema Type of the handler's parameter, checked on each payload
+   */
+  on(
+    event: string,
+    body: (payload: unknown) => Promise<unknown>,
+    schema?: z.ZodTypeAny
+  ): void {
+    const handler: ScriptEventHandler = {
+      event,
+      handle: async payload => {
+        const checked = schema?.safeParse(payload);
+        if (checked && !checked.success) {
+          throw new TypeError(
+            `Event ${event}: payload does not match the handler's type: ${describeIssues(checked.error)}`
+          );
+        }
+        return body(payload);
+      },
+    };
+    this.handlers.push(handler);
+    this.collected?.push(handler);
+  }
+
+  /**
+   * Handle an event with the script's handlers, then hand it to the host
+   * @returns How many of the script's handlers handled it
+   */
+  async emit(event: unknown, payload?: unknown): Promise<number> {
+    if (typeof event !== 'string' || event === '') {
+      throw new TypeError(
+        `emit: event must be a non-empty string, got ${event === null ? 'null' : typeof event}`
+      );
+    }
+    const data = toJson(event, payload);
+    const handlers = this.handlers.filter(handler => handler.event === event);
+    for (const handler of handlers) {
+      await handler.handle(data);
+    }
+    await this.publish?.(event, data);
+    return handlers.length;
+  }
+}
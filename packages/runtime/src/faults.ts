@@ -0,0 +1,376 @@
+// Fault injection for MCP server transports
+//
+// Retry policies and compensate blocks only prove themselves when calls
+// fail, which servers rarely do on demand. A fault plan, given to a test
+// or staging run, makes the tool calls of chosen servers misbehave: their
+// responses arrive late, never arrive, arrive as JSON that does not parse,
+// or the connection drops while they are in flight. Faults are drawn from
+// a seeded random generator, so a run can be repeated with the same
+// faults. Only tool calls are affected, so servers still start and list
+// their tools. Real servers get a transport wrapping their own, and the
+// mock servers of tests the same faults around their answers.
+import type {
+  Transport,
+  TransportSendOptions,
+} from '@modelcontextprotocol/sdk/shared/transport.js';
+import type {
+  JSONRPCMessage,
+  MessageExtraInfo,
+  RequestId,
+} from '@modelcontextprotocol/sdk/types.js';
+
+/**
+ * Faults of the tool calls of a server
+ */
+export interface TransportFaults {
+  /** Milliseconds responses are delayed by, or a range to draw from */
+  latency?: number | [number, number];
+  /** Chance from 0 to 1 that a response never arrives */
+  drop?: number;
+  /** Chance that a response is JSON that does not parse */
+  malformed?: number;
+  /** Chance that the connection drops while a call is in flight */
+  disconnect?: number;
+  /** Tools whose calls are faulted (default: all of the server's) */
+  tools?: string[];
+}
+
+/**
+ * Faults to inject into a run
+ */
+export interface FaultPlan {
+  /** Faults by declared server name; "*" applies to servers not named */
+  servers: Record<string, TransportFaults>;
+  /** Seed of the random draws (default: 1) */
+  seed?: number;
+}
+
+export type FaultKind = 'latency' | 'drop' | 'malformed' | 'disconnect';
+
+/**
+ * The fault drawn for one call
+ */
+export interface Fault {
+  /** Milliseconds the response is delayed by */
+  delay: number;
+  /** What happens to the response instead of arriving, if anything */
+  kind?: Exclude<FaultKind, 'latency'>;
+}
+
+/** JSON-RPC code of responses that do not parse */
+const PARSE_ERROR = -32700;
+/** MCP code of requests cut off by a closed connection */
+const CONNECTION_CLOSED = -32000;
+
+/**
+ * Error a faulted call fails with, coded as the real failure would be, so
+ * a dropped connection counts as transient for retries and a malformed
+ * response does not
+ */
+export class InjectedFaultError extends Error {
+  constructor(
+    public readonly code: number,
+    message: string
+  ) {
+    super(`${message} (injected fault)`);
+    this.name = 'InjectedFaultError';
+  }
+}
+
+/**
+ * Check the fields of a fault plan read from a file
+ */
+export function checkFaultPlan(plan: unknown): FaultPlan {
+  if (typeof plan !== 'object' || plan === null || Array.isArray(plan)) {
+    throw new TypeError('Fault plan must be an object');
+  }
+  const { servers, seed, ...rest } = plan as Record<string, unknown>;
+  const unknown = Object.keys(rest)[0];
+  if (unknown !== undefined) {
+    throw new TypeError(`Unknown fault plan field "${unknown}"`);
+  }
+  if (seed !== undefined && !Number.isInteger(seed)) {
+    throw new TypeError('"seed" must be an integer');
+  }
+  if (typeof servers !== 'object' || servers === null) {
+    throw new TypeError('"servers" must map server names to their faults');
+  }
+  for (const [server, faults] of Object.entries(servers)) {
+    if (typeof faults !== 'object' || faults === null) {
+      throw new TypeError(`"servers.${server}" must be an object`);
+    }
+    for (const [field, value] of Object.entries(faults)) {
+      const label = `"servers.${server}.${field}"`;
+      switch (field) {
+        case 'latency':
+          if (
+            !(typeof value === 'number' && value >= 0) &&
+            !(
+              Array.isArray(value) &&
+              value.length === 2 &&
+              value.every(n => typeof n === 'number' && n >= 0) &&
+              value[0] <= value[1]
+            )
+          ) {
+            throw new TypeError(
+              `${label} must be milliseconds or a [min, max] range`
+            );
+          }
+          break;
+        case 'drop':
+        case 'malformed':
+        case 'disconnect':
+          if (!(typeof value === 'number' && value >= 0 && value <= 1)) {
+            throw new TypeError(`${label} must be a chance from 0 to 1`);
+          }
+          break;
+        case 'tools':
+          if (
+            !Array.isArray(value) ||
+            !value.every(item => typeof item === 'string')
+          ) {
+            throw new TypeError(`${label} must be a list of strings`);
+          }
+          break;
+        default:
+          throw new TypeError(`Unknown fault ${label}`);
+      }
+    }
+  }
+  return plan as FaultPlan;
+}
+
+/**
+ * A random generator repeating its numbers for the same seed (mulberry32)
+ */
+function seededRandom(seed: number): () => number {
+  let state = seed >>> 0;
+  return () => {
+    state = (state + 0x6d2b79f5) >>> 0;
+    let t = state;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+  };
+}
+
+/**
+ * Draws the faults of a plan for the calls of a run, and counts them
+ */
+export class FaultInjector {
+  private readonly random: () => number;
+  /** Faults injected so far, by kind */
+  readonly injected: Record<FaultKind, number> = {
+    latency: 0,
+    drop: 0,
+    malformed: 0,
+    disconnect: 0,
+  };
+
+  constructor(private readonly plan: FaultPlan) {
+    this.random = seededRandom(plan.seed ?? 1);
+  }
+
+  /**
+   * The faults of a server's calls of a tool, if the plan has any
+   */
+  private faultsFor(server: string, tool: string): TransportFaults | undefined {
+    const faults = this.plan.servers[server] ?? this.plan.servers['*'];
+    return faults && (!faults.tools || faults.tools.includes(tool))
+      ? faults
+      : undefined;
+  }
+
+  /**
+   * Draw the fault of a call, or undefined when it goes through untouched
+   */
+  draw(server: string, tool: string): Fault | undefined {
+    const faults = this.faultsFor(server, tool);
+    if (!faults) {
+      return undefined;
+    }
+    const { latency = 0 } = faults;
+    const delay = Array.isArray(latency)
+      ? Math.round(latency[0] + this.random() * (latency[1] - latency[0]))
+      : latency;
+    if (delay > 0) {
+      this.injected.latency++;
+    }
+    for (const kind of ['disconnect', 'drop', 'malformed'] as const) {
+      const chance = faults[kind] ?? 0;
+      if (chance > 0 && this.random() < chance) {
+        this.injected[kind]++;
+        return { delay, kind };
+      }
+    }
+    return delay > 0 ? { delay } : undefined;
+  }
+
+  /**
+   * A transport to a server whose tool calls are faulted
+   */
+  wrapTransport(transport: Transport, server: string): Transport {
+    return new FaultyTransport(transport, server, this);
+  }
+
+  /**
+   * Make a call to an in-process server, such as a test's mock server,
+   * with the fault drawn for it
+   */
+  async call<T>(
+    server: string,
+    tool: string,
+    attempt: () => Promise<T>
+  ): Promise<T> {
+    const fault = this.draw(server, tool);
+    if (!fault) {
+      return attempt();
+    }
+    if (fault.kind === 'disconnect') {
+      // The call is sent, and the connection drops before it is answered
+      void attempt().catch(() => {});
+      throw disconnectError(server, tool);
+    }
+    const result = await attempt();
+    await new Promise(resolve => setTimeout(resolve, fault.delay));
+    if (fault.kind === 'drop') {
+      return new Promise<T>(() => {});
+    }
+    if (fault.kind === 'malformed') {
+      throw malformedError(server, tool);
+    }
+    return result;
+  }
+
+  /**
+   * The faults injected so far, such as "2 drop, 1 disconnect", or an
+   * empty string when there were none
+   */
+  summary(): string {
+    return (Object.entries(this.injected) as [FaultKind, number][])
+      .filter(([, count]) => count > 0)
+      .map(([kind, count]) => `${count} ${kind}`)
+      .join(', ');
+  }
+}
+
+function disconnectError(server: string, tool: string): InjectedFaultError {
+  return new InjectedFaultError(
+    CONNECTION_CLOSED,
+    `Connection to MCP server "${server}" closed during a call of ${tool}`
+  );
+}
+
+function malformedError(server: string, tool: string): InjectedFaultError {
+  return new InjectedFaultError(
+    PARSE_ERROR,
+    `MCP server "${server}" answered a call of ${tool} with malformed JSON`
+  );
+}
+
+/**
+ * A transport whose tool call responses are delayed, dropped, made
+ * malformed or cut off as drawn
+ */
+class FaultyTransport implements Transport {
+  onclose?: () => void;
+  onerror?: (error: Error) => void;
+  onmessage?: (message: JSONRPCMessage, extra?: MessageExtraInfo) => void;
+  /** Faults of the tool calls in flight, by request id */
+  private readonly calls = new Map<RequestId, Fault & { tool: string }>();
+  private readonly timers = new Set<ReturnType<typeof setTimeout>>();
+
+  constructor(
+    private readonly inner: Transport,
+    private readonly server: string,
+    private readonly injector: FaultInjector
+  ) {}
+
+  get sessionId(): string | undefined {
+    return this.inner.sessionId;
+  }
+
+  setProtocolVersion(version: string): void {
+    this.inner.setProtocolVersion?.(version);
+  }
+
+  async start(): Promise<void> {
+    this.inner.onclose = () => this.onclose?.();
+    this.inner.onerror = error => this.onerror?.(error);
+    this.inner.onmessage = (message, extra) => this.receive(message, extra);
+    await this.inner.start();
+  }
+
+  async send(
+    message: JSONRPCMessage,
+    options?: TransportSendOptions
+  ): Promise<void> {
+    let fault: Fault | undefined;
+    let tool = '';
+    if ('method' in message && 'id' in message) {
+      if (message.method === 'tools/call') {
+        tool = String(message.params?.name);
+        fault = this.injector.draw(this.server, tool);
+      }
+      if (fault) {
+        this.calls.set(message.id, { ...fault, tool });
+      }
+    }
+    await this.inner.send(message, options);
+
+    if (fault?.kind === 'disconnect' && 'id' in message) {
+      const { id } = message;
+      this.later(0, () =>
+        this.deliverError(id, disconnectError(this.server, tool))
+      );
+    }
+  }
+
+  private receive(message: JSONRPCMessage, extra?: MessageExtraInfo): void {
+    const id =
+      ('result' in message || 'error' in message) && 'id' in message
+        ? message.id
+        : undefined;
+    const fault = id !== undefined ? this.calls.get(id) : undefined;
+    if (id === undefined || !fault) {
+      this.onmessage?.(message, extra);
+      return;
+    }
+    this.calls.delete(id);
+    // The answer to a call cut off by the disconnect is lost
+    if (fault.kind === 'disconnect') {
+      return;
+    }
+    this.later(fault.delay, () => {
+      if (fault.kind === 'malformed') {
+        this.deliverError(id, malformedError(this.server, fault.tool));
+      } else if (fault.kind !== 'drop') {
+        this.onmessage?.(message, extra);
+      }
+    });
+  }
+
+  private deliverError(id: RequestId, error: InjectedFaultError): void {
+    this.onmessage?.({
+      jsonrpc: '2.0',
+      id,
+      error: { code: error.code, message: error.message },
+    });
+  }
+
+  private later(delay: number, deliver: () => void): void {
+    const timer = setTimeout(() => {
+      this.timers.delete(timer);
+      deliver();
+    }, delay);
+    this.timers.add(timer);
+  }
+
+  async close(): Promise<void> {
+    for (const timer of this.timers) {
+      clearTimeout(timer);
+    }
+    this.timers.clear();
+    await this.inner.close();
+  }
+}
@@ -0,0 +1,148 @@
+// Locale-aware formatting for MCP Script
+//
+// format.number(1234.5) gives "1,234.5" in English and "1.234,5" in
+// German, format.currency(9.99, "EUR") gives "€9.99" or "9,99 €", and
+// format.date(date) writes a date the way readers of the locale expect.
+// Values are formatted in the run's locale unless a call names another:
+//
+//   format.number(0.256, { locale: "fr-FR", style: "percent" })
+//   format.date(issue.closedAt, { style: "long", time: "short" })
+//
+// Other options are those of Intl.NumberFormat and Intl.DateTimeFormat.
+
+/**
+ * Options of format.number() and format.currency()
+ */
+export interface NumberFormatOptions extends Intl.NumberFormatOptions {
+  /** Locale such as "de-DE" (default: the run's locale) */
+  locale?: string;
+  /** Digits after the decimal point, exactly */
+  decimals?: number;
+}
+
+type DateStyle = 'full' | 'long' | 'medium' | 'short';
+
+/**
+ * Options of format.date()
+ */
+export interface DateFormatOptions extends Intl.DateTimeFormatOptions {
+  /** Locale such as "de-DE" (default: the run's locale) */
+  locale?: string;
+  /** How much of the date to write (default: "medium") */
+  style?: DateStyle;
+  /** How much of the time to write (default: none) */
+  time?: DateStyle;
+}
+
+/**
+ * Build an Intl formatter, naming the builtin in errors about its options
+ */
+function formatter<T>(name: string, create: () => T): T {
+  try {
+    return create();
+  } catch (error) {
+    if (error instanceof RangeError) {
+      throw new RangeError(`${name}: ${error.message}`);
+    }
+    throw error;
+  }
+}
+
+/**
+ * The number formatter of format.number() and format.currency()
+ */
+function numberFormat(
+  name: string,
+  runLocale: string | undefined,
+  { locale, decimals, ...options }: NumberFormatOptions,
+  fixed: Intl.NumberFormatOptions = {}
+): Intl.NumberFormat {
+  return formatter(name, () =>
+    Intl.NumberFormat(locale ?? runLocale, {
+      ...options,
+      ...(decimals !== undefined && {
+        minimumFractionDigits: decimals,
+        maximumFractionDigits: decimals,
+      }),
+      ...fixed,
+    })
+  );
+}
+
+function checkNumber(name: string, value: unknown): void {
+  if (typeof value !== 'number' || Number.isNaN(value)) {
+    throw new TypeError(`${name} needs a number, got ${describe(value)}`);
+  }
+}
+
+function describe(value: unknown): string {
+  return typeof value === 'string' ? JSON.stringify(value) : String(value);
+}
+
+/**
+ * A date of a Date, an ISO string or milliseconds since the epoch
+ */
+function toDate(value: unknown): Date {
+  const date =
+    value instanceof Date
+      ? value
+      : typeof value === 'string' || typeof value === 'number'
+        ? new Date(value)
+        : undefined;
+  if (!date || Number.isNaN(date.getTime())) {
+    throw new TypeError(
+      `format.date needs a date, an ISO date string or a timestamp, got ${describe(value)}`
+    );
+  }
+  return date;
+}
+
+/**
+ * Create the format builtin of scripts
+ * @param runLocale Locale of the run (default: the locale of the process)
+ */
+export function createFormat(runLocale?: string) {
+  return {
+    number(value: number, options: NumberFormatOptions = {}): string {
+      checkNumber('format.number', value);
+      return numberFormat('format.number', runLocale, options).format(value);
+    },
+
+    currency(
+      value: number,
+      currency: string,
+      options: NumberFormatOptions = {}
+    ): string {
+      checkNumber('format.currency', value);
+      if (typeof currency !== 'string') {
+        throw new TypeError(
+          'format.currency needs a currency code such as "EUR"'
+        );
+      }
+      return numberFormat('format.currency', runLocale, options, {
+        style: 'currency',
+        currency,
+      }).format(value);
+    },
+
+    date(
+      value: Date | string | number,
+      { locale, style, time, ...options }: DateFormatOptions = {}
+    ): string {
+      const date = toDate(value);
+      // Single fields such as month are used as given; styles cannot be
+      // combined with them
+      const fields = Object.keys(options).some(
+        key => key !== 'timeZone' && key !== 'hour12'
+      );
+      return formatter('format.date', () =>
+        Intl.DateTimeFormat(
+          locale ?? runLocale,
+          fields && !style && !time
+            ? options
+            : { ...options, dateStyle: style ?? 'medium', timeStyle: time }
+        )
+      ).format(date);
+    },
+  };
+}
@@ -1,7 +1,25 @@
 // Global functions available in MCP Script
 
 import { ChatMessage } from 'llamaindex';
-import { AppMessage } from './types';
+import { AppMessage, ScriptEventHandler, ScriptTrigger } from './types';
+import { noRedaction, type Redactor } from './redaction.js';
+import type { ScriptDebugger } from './debugger.js';
+import type { ToolAdmission } from './profile.js';
+import type { SandboxGate } from './policy.js';
+import type { TranscriptRecorder } from './transcript.js';
+import type { GuardrailHandlers } from './guardrails.js';
+import type { ModelConfig } from './providers.js';
+import type { VectorStore } from './vectors.js';
+import type { MapProgress } from './parallel.js';
+import type { IdempotencyStore } from './idempotency.js';
+import type { EventPublisher } from './events.js';
+import type { QuestionHandler } from './questions.js';
+import type { ScriptArguments } from './arguments.js';
+import type { RunOutcome } from './outcome.js';
+import type { PinnedPackage } from './packages.js';
+import type { FaultInjector } from './faults.js';
+import type { TestSuite } from './testing.js';
+import type { SpillStore } from './spill.js';
 
 /**
  * Add message callback type for UI integration
@@ -18,12 +36,79 @@ export type UserInputHandler = (message: string) => Promise<string>;
  */
 export type PrintChatMessageFn = (agentName: string, msg: ChatMessage) => void;
 
+/**
+ * Stream callback type for UI integration
+ * Receives the text of a titled message as it is generated; the complete
+ * message is passed to the add message handler once it is finished
+ */
+export type StreamMessageHandler = (title: string, delta: string) => void;
+
+/**
+ * Progress callback type, called each time an item of a parallel map
+ * finishes
+ */
+export type ProgressHandler = (title: string, progress: MapProgress) => void;
+
+/**
+ * Stream chat message callback type for agent integration
+ */
+export type StreamChatMessageFn = (agentName: string, delta: string) => void;
+
 /**
  * Runtime handlers that can be injected into the VM context
  */
 export interface RuntimeHandlers {
   addMessage?: AddMessageHandler;
   userInput?: UserInputHandler;
+  /**
+   * Answers the questions of prompt.text(), prompt.select() and
+   * prompt.confirm(); without it they are asked through userInput
+   */
+  ask?: QuestionHandler;
+  /** Receives agent replies as they stream in; without it they don't stream */
+  streamMessage?: StreamMessageHandler;
+  /** Receives the progress of parallel maps */
+  progress?: ProgressHandler;
+  /** Masks sensitive data in logs (nothing is masked by default) */
+  redactor?: Redactor;
+  /** Debugger of a script compiled with debug hooks */
+  debugger?: ScriptDebugger;
+  /** Admits MCP tool calls under the run's profile and sandbox policy */
+  toolGate?: ToolAdmission;
+  /** Admits the MCP servers the script starts under its sandbox policy */
+  sandbox?: SandboxGate;
+  /** Records the runs of the script's agents */
+  transcript?: TranscriptRecorder;
+  /** Collects the script's triggers; without it they are ignored */
+  triggers?: ScriptTrigger[];
+  /** Collects the script's event handlers, for the host to deliver to */
+  eventHandlers?: ScriptEventHandler[];
+  /** Receives the events the script emits */
+  publish?: EventPublisher;
+  /** Validators and escalation for the guardrails of the script's agents */
+  guardrails?: GuardrailHandlers;
+  /** Model embed() and the vector index use when not given one */
+  embeddings?: ModelConfig;
+  /** Where the vector index keeps its collections (in memory by default) */
+  vectors?: VectorStore;
+  /** Where completed @idempotent calls are recorded (in memory by default) */
+  idempotency?: IdempotencyStore;
+  /** Arguments the script's main tool is called with */
+  args?: ScriptArguments;
+  /** Notes the error results and failed map items of the run */
+  outcome?: RunOutcome;
+  /** Locale of the format builtins (default: the process's locale) */
+  locale?: string;
+  /** Whether servers and models that need the network are refused */
+  offline?: boolean;
+  /** Locked package versions of stdio servers, by declared server name */
+  packages?: Record<string, PinnedPackage>;
+  /** Faults injected into the tool calls of the script's servers */
+  faults?: FaultInjector;
+  /** Runs the test cases of a test file, under mcps test */
+  tests?: TestSuite;
+  /** Keeps large tool results on disk; without it they stay in memory */
+  spill?: SpillStore;
 }
 
 /**
@@ -40,6 +125,10 @@ export function createPrint(addMessage?: AddMessageHandler) {
   };
 }
 
+function agentTitle(agentName: string): string {
+  return `Agent[${agentName}]`;
+}
+
 /**
  * Create a printChatMessage function with the given handler
  */
@@ -49,43 +138,67 @@ export function createPrintChatMessage(addMessage?: AddMessageHandler) {
       typeof msg.content === 'string'
         ? msg.content
         : JSON.stringify(msg.content);
-    const author = msg.role === 'user' ? 'User' : `Agent[${agentName}]`;
+    const author = msg.role === 'user' ? 'User' : agentTitle(agentName);
     addMessage?.({ title: author, body: content });
   };
 }
 
 /**
- * Structured logging system for MCP Script
+ * Create a streamChatMessage function with the given handler
+ * Returns undefined without a handler, so agents don't stream at all
  */
-export const log = {
-  /**
-   * Log debug-level messages (typically for detailed diagnostic information)
-   */
-  debug(...values: unknown[]): void {
-    console.debug('[DEBUG]', ...values);
-  },
+export function createStreamChatMessage(
+  streamMessage?: StreamMessageHandler
+): StreamChatMessageFn | undefined {
+  return (
+    streamMessage &&
+    ((agentName, delta) => streamMessage(agentTitle(agentName), delta))
+  );
+}
 
-  /**
-   * Log info-level messages (general informational messages)
-   */
-  info(...values: unknown[]): void {
-    console.info('[INFO]', ...values);
-  },
+/**
+ * Create the structured logging system for MCP Script
+ * Logged values pass through the redactor before they are written
+ */
+export function createLog(redactor: Redactor = noRedaction) {
+  const redactAll = (values: unknown[]) =>
+    values.map(value => redactor.redact(value));
 
-  /**
-   * Log warning-level messages (warnings about potential issues)
-   */
-  warn(...values: unknown[]): void {
-    console.warn('[WARN]', ...values);
-  },
+  return {
+    /**
+     * Log debug-level messages (typically for detailed diagnostic information)
+     */
+    debug(...values: unknown[]): void {
+      console.debug('[DEBUG]', ...redactAll(values));
+    },
 
-  /**
-   * Log error-level messages (error conditions)
-   */
-  error(...values: unknown[]): void {
-    console.error('[ERROR]', ...values);
-  },
-};
+    /**
+     * Log info-level messages (general informational messages)
+     */
+    info(...values: unknown[]): void {
+      console.info('[INFO]', ...redactAll(values));
+    },
+
+    /**
+     * Log warning-level messages (warnings about potential issues)
+     */
+    warn(...values: unknown[]): void {
+      console.warn('[WARN]', ...redactAll(values));
+    },
+
+    /**
+     * Log error-level messages (error conditions)
+     */
+    error(...values: unknown[]): void {
+      console.error('[ERROR]', ...redactAll(values));
+    },
+  };
+}
+
+/**
+ * Structured logging system for MCP Script
+ */
+export const log = createLog();
 
 /**
  * Environment variable access for MCP Script
@@ -0,0 +1,316 @@
+// Guardrails on the output of agents' models
+//
+// An agent's guardrails look at the text of each reply of its model and at
+// the input of each tool call the model asks for, before the call runs. A
+// guardrail rejects output matching any of its denied patterns, a final
+// reply that is not JSON matching its schema, or output its validator
+// finds a problem with; validators are tools of the script or functions
+// the host registers by name. What happens to rejected output depends on
+// the guardrail's action: "block" fails the agent run, "retry" tells the
+// model what was wrong and lets it try again a few times, and "escalate"
+// asks a person whether to let the output through.
+import { acceptsType, jsonType, type JSONSchema } from './tool-schemas.js';
+
+export type GuardrailAction = 'block' | 'retry' | 'escalate';
+
+export const GUARDRAIL_ACTIONS: GuardrailAction[] = [
+  'block',
+  'retry',
+  'escalate',
+];
+
+/** Tries a model gets after its output is rejected by a retry guardrail */
+export const DEFAULT_GUARDRAIL_RETRIES = 2;
+
+/**
+ * Output of a model, as checked by guardrails
+ */
+export interface ModelOutput {
+  agent: string;
+  /** A reply's text, or a tool call's input as JSON */
+  text: string;
+  /** Tool the model asks to call; absent for replies */
+  tool?: string;
+  /** Whether a reply ends the agent's run, so its schema applies */
+  final?: boolean;
+}
+
+/**
+ * Checks model output: a string is the problem with it, anything false
+ * rejects it without a reason, and anything else accepts it
+ */
+export type OutputValidator = (output: ModelOutput) => unknown;
+
+/**
+ * A guardrail as declared in an agent's configuration
+ */
+export interface GuardrailConfig {
+  /** Name used when reporting rejected output (default: guardrail <n>) */
+  name?: string;
+  /** Regular expressions no reply or tool call may match */
+  deny?: string[];
+  /** JSON schema the agent's final reply must parse to */
+  schema?: JSONSchema;
+  /**
+   * Validator called with the output: a tool of the script, called with
+   * the text, or the name of a validator registered by the host
+   */
+  check?: string | ((text: string) => unknown);
+  /** What to do with rejected output (default: block) */
+  action?: GuardrailAction;
+  /** Tries the model gets before a retry guardrail blocks */
+  retries?: number;
+}
+
+/**
+ * Output rejected by a guardrail
+ */
+export interface GuardrailViolation {
+  guardrail: string;
+  action: GuardrailAction;
+  problem: string;
+}
+
+/**
+ * Asks a person whether output a guardrail rejected may be used anyway
+ */
+export type EscalationHandler = (
+  violation: GuardrailViolation,
+  output: ModelOutput
+) => Promise<boolean>;
+
+/**
+ * What the host provides to guardrails
+ */
+export interface GuardrailHandlers {
+  /** Validators that guardrails name in `check` */
+  validators?: Record<string, OutputValidator>;
+  /** Without one, escalated output is blocked */
+  escalate?: EscalationHandler;
+}
+
+/**
+ * Error failing an agent run whose model produced output a guardrail
+ * blocked
+ */
+export class GuardrailError extends Error {
+  constructor(
+    public readonly agent: string,
+    public readonly violation: GuardrailViolation
+  ) {
+    super(
+      `Agent ${agent}: ${violation.guardrail} blocked the model's output: ${violation.problem}`
+    );
+    this.name = 'GuardrailError';
+  }
+}
+
+/**
+ * Check the fields of guardrails, whose values may only be known when the
+ * script runs
+ */
+export function checkGuardrails(
+  guardrails: unknown,
+  label: string,
+  validators: Record<string, OutputValidator> = {}
+): void {
+  if (!Array.isArray(guardrails)) {
+    throw new TypeError(`${label}: guardrails must be a list`);
+  }
+  guardrails.forEach((guardrail: GuardrailConfig, index) => {
+    const where = `${label}: ${guardrailName(guardrail, index)}`;
+    if (typeof guardrail !== 'object' || guardrail === null) {
+      throw new TypeError(`${where} must be an object`);
+    }
+    const { deny, schema, check, action, retries } = guardrail;
+    if (deny === undefined && schema === undefined && check === undefined) {
+      throw new TypeError(`${where} needs deny, schema or check`);
+    }
+    if (typeof check === 'string' && !(check in validators)) {
+      throw new TypeError(`${where}: unknown output validator ${check}`);
+    }
+    for (const pattern of deny ?? []) {
+      try {
+        new RegExp(pattern);
+      } catch {
+        throw new TypeError(`${where}: invalid deny pattern ${pattern}`);
+      }
+    }
+    if (action !== undefined && !GUARDRAIL_ACTIONS.includes(action)) {
+      throw new TypeError(
+        `${where}: action must be ${GUARDRAIL_ACTIONS.join(', ')}, got ${action}`
+      );
+    }
+    if (
+      retries !== undefined &&
+      !(Number.isInteger(retries) && retries >= 0)
+    ) {
+      throw new TypeError(
+        `${where}: retries must be a non-negative integer, got ${retries}`
+      );
+    }
+  });
+}
+
+function guardrailName(guardrail: GuardrailConfig, index: number): string {
+  return guardrail?.name ?? `guardrail ${index + 1}`;
+}
+
+/**
+ * Name of a registered validator, or of the script tool used as one
+ */
+function validatorName(
+  check: NonNullable<GuardrailConfig['check']>
+): string {
+  if (typeof check === 'string') {
+    return check;
+  }
+  return (check as { __mcps_name?: string }).__mcps_name ?? 'check';
+}
+
+/**
+ * Problems with a value under the parts of JSON Schema guardrails check:
+ * types, required properties, properties, items and enums
+ */
+export function checkSchema(
+  schema: JSONSchema,
+  value: unknown,
+  path = 'reply'
+): string[] {
+  const type = jsonType(value);
+  const isInteger = typeof value === 'number' && Number.isInteger(value);
+  if (!acceptsType(schema, type, isInteger)) {
+    const types = Array.isArray(schema.type) ? schema.type : [schema.type];
+    return [`${path} must be ${types.join(' or ')}, got ${type}`];
+  }
+  const problems: string[] = [];
+  const options = schema.enum;
+  if (
+    Array.isArray(options) &&
+    !options.some(option => JSON.stringify(option) === JSON.stringify(value))
+  ) {
+    problems.push(
+      `${path} must be one of ${options.map(o => JSON.stringify(o)).join(', ')}`
+    );
+  }
+  if (type === 'object') {
+    const object = value as Record<string, unknown>;
+    for (const name of schema.required ?? []) {
+      if (object[name] === undefined) {
+        problems.push(`${path} is missing "${name}"`);
+      }
+    }
+    for (const [name, property] of Object.entries(schema.properties ?? {})) {
+      if (object[name] !== undefined) {
+        problems.push(
+          ...checkSchema(property, object[name], `${path}.${name}`)
+        );
+      }
+    }
+  }
+  if (type === 'array' && schema.items) {
+    (value as unknown[]).forEach((item, index) => {
+      problems.push(...checkSchema(schema.items!, item, `${path}[${index}]`));
+    });
+  }
+  return problems;
+}
+
+/**
+ * The guardrails of one agent run, with the tries each has left
+ */
+export class GuardrailRun {
+  private readonly retriesLeft: number[];
+
+  constructor(
+    private readonly agent: string,
+    private readonly guardrails: GuardrailConfig[],
+    private readonly handlers: GuardrailHandlers = {}
+  ) {
+    this.retriesLeft = guardrails.map(
+      guardrail => guardrail.retries ?? DEFAULT_GUARDRAIL_RETRIES
+    );
+  }
+
+  /**
+   * Decide what becomes of model output
+   * Resolves with undefined when the output may be used, or with the
+   * violation to report to the model when it should try again; throws a
+   * GuardrailError when the output is blocked
+   */
+  async review(
+    output: Omit<ModelOutput, 'agent'>
+  ): Promise<GuardrailViolation | undefined> {
+    const checked: ModelOutput = { agent: this.agent, ...output };
+    for (const [index, guardrail] of this.guardrails.entries()) {
+      const problem = await this.problem(guardrail, checked);
+      if (problem === undefined) {
+        continue;
+      }
+      const violation: GuardrailViolation = {
+        guardrail: guardrailName(guardrail, index),
+        action: guardrail.action ?? 'block',
+        problem,
+      };
+      if (violation.action === 'retry' && this.retriesLeft[index] > 0) {
+        this.retriesLeft[index]--;
+        return violation;
+      }
+      if (
+        violation.action === 'escalate' &&
+        this.handlers.escalate &&
+        (await this.handlers.escalate(violation, checked))
+      ) {
+        continue;
+      }
+      throw new GuardrailError(this.agent, violation);
+    }
+    return undefined;
+  }
+
+  private async problem(
+    guardrail: GuardrailConfig,
+    output: ModelOutput
+  ): Promise<string | undefined> {
+    for (const pattern of guardrail.deny ?? []) {
+      if (new RegExp(pattern).test(output.text)) {
+        return `${output.tool ? `call of ${output.tool}` : 'reply'} matches ${pattern}`;
+      }
+    }
+
+    if (guardrail.schema && output.final) {
+      let value: unknown;
+      try {
+        value = JSON.parse(output.text);
+      } catch {
+        return 'reply is not JSON';
+      }
+      const problems = checkSchema(guardrail.schema, value);
+      if (problems.length > 0) {
+        return problems.join('; ');
+      }
+    }
+
+    const { check } = guardrail;
+    if (check !== undefined) {
+      const result =
+        typeof check === 'string'
+          ? await this.handlers.validators![check](output)
+          : await check(output.text);
+      if (typeof result === 'string' && result !== '') {
+        return result;
+      }
+      if (result === false) {
+        return `rejected by ${validatorName(check)}`;
+      }
+    }
+    return undefined;
+  }
+}
+
+/**
+ * What the model is told when its output is rejected for a retry
+ */
+export function retryMessage(violation: GuardrailViolation): string {
+  return `Your output was rejected by ${violation.guardrail}: ${violation.problem}. Try again without this problem.`;
+}
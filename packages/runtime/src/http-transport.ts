@@ -0,0 +1,145 @@
+// Connection options for MCP servers reached over HTTP (streamable HTTP/SSE)
+import { readFileSync } from 'fs';
+import { request as httpsRequest, Agent } from 'https';
+import type { IncomingHttpHeaders } from 'http';
+import { Readable } from 'stream';
+import type { FetchLike } from '@modelcontextprotocol/sdk/shared/transport.js';
+import type { StreamableHTTPReconnectionOptions } from '@modelcontextprotocol/sdk/client/streamableHttp.js';
+
+/**
+ * TLS settings for servers with private certificate authorities or that
+ * require client certificates
+ * Certificates and keys are paths to PEM files
+ */
+export interface TLSOptions {
+  ca?: string;
+  cert?: string;
+  key?: string;
+  /** Set to false to accept any certificate (for local testing only) */
+  rejectUnauthorized?: boolean;
+}
+
+/**
+ * How a dropped event stream is resumed
+ * Delays are in milliseconds and grow with each attempt
+ */
+export interface ReconnectOptions {
+  maxRetries?: number;
+  initialDelay?: number;
+  maxDelay?: number;
+}
+
+/**
+ * Options of an MCP server declared with `url`
+ */
+export interface RemoteServerOptions {
+  url: string;
+  /** "http" for streamable HTTP (default) or "sse" for the older protocol */
+  transport?: 'http' | 'sse';
+  /** Use SSE transport (deprecated, use transport: "sse" instead) */
+  useSSETransport?: boolean;
+  /** Headers sent with every request, e.g. Authorization */
+  headers?: Record<string, string>;
+  tls?: TLSOptions;
+  reconnect?: ReconnectOptions;
+}
+
+export const DEFAULT_RECONNECT: Required<ReconnectOptions> = {
+  maxRetries: 2,
+  initialDelay: 1000,
+  maxDelay: 30000,
+};
+
+/**
+ * Whether a remote server uses the SSE transport
+ */
+export function usesSSETransport(options: RemoteServerOptions): boolean {
+  return options.transport === 'sse' || options.useSSETransport === true;
+}
+
+/**
+ * Reconnection settings of the streamable HTTP transport
+ */
+export function reconnectionOptions(
+  reconnect: ReconnectOptions = {}
+): StreamableHTTPReconnectionOptions {
+  const { maxRetries, initialDelay, maxDelay } = {
+    ...DEFAULT_RECONNECT,
+    ...reconnect,
+  };
+  return {
+    maxRetries,
+    initialReconnectionDelay: initialDelay,
+    maxReconnectionDelay: maxDelay,
+    reconnectionDelayGrowFactor: 1.5,
+  };
+}
+
+function responseHeaders(headers: IncomingHttpHeaders): Headers {
+  const result = new Headers();
+  for (const [name, value] of Object.entries(headers)) {
+    for (const item of Array.isArray(value) ? value : [value ?? '']) {
+      result.append(name, item);
+    }
+  }
+  return result;
+}
+
+/**
+ * A fetch that connects with the given TLS settings
+ * The global fetch cannot be given a certificate authority or client
+ * certificate per request, so requests go through node's https module
+ */
+export function createTLSFetch(tls: TLSOptions): FetchLike {
+  const agent = new Agent({
+    ca: tls.ca ? readFileSync(tls.ca) : undefined,
+    cert: tls.cert ? readFileSync(tls.cert) : undefined,
+    key: tls.key ? readFileSync(tls.key) : undefined,
+    rejectUnauthorized: tls.rejectUnauthorized ?? true,
+  });
+
+  return (url, init = {}) =>
+    new Promise<Response>((resolve, reject) => {
+      const request = httpsRequest(
+        url,
+        {
+          method: init.method ?? 'GET',
+          headers: Object.fromEntries(new Headers(init.headers)),
+          agent,
+          signal: init.signal ?? undefined,
+        },
+        response => {
+          const status = response.statusCode ?? 500;
+          // Responses to these statuses cannot have a body
+          const body = [204, 205, 304].includes(status)
+            ? null
+            : (Readable.toWeb(response) as ReadableStream<Uint8Array>);
+          resolve(
+            new Response(body, {
+              status,
+              statusText: response.statusMessage,
+              headers: responseHeaders(response.headers),
+            })
+          );
+        }
+      );
+      request.on('error', reject);
+      request.end(typeof init.body === 'string' ? init.body : undefined);
+    });
+}
+
+/**
+ * Request options shared by both HTTP transports
+ */
+export function remoteTransportOptions(options: RemoteServerOptions): {
+  requestInit?: RequestInit;
+  fetch?: FetchLike;
+} {
+  if (options.tls && new URL(options.url).protocol !== 'https:') {
+    throw new Error(`TLS options need an https:// URL, got ${options.url}`);
+  }
+  return {
+    requestInit: options.headers ? { headers: options.headers } : undefined,
+    fetch: options.tls ? createTLSFetch(options.tls) : undefined,
+  };
+}
@@ -0,0 +1,123 @@
+// Idempotent tool calls
+//
+// A workflow that is re-run after failing, or resumed from a checkpoint,
+// makes again the calls it made before it stopped. For calls that change
+// something, such as posting a message or creating an order, that means
+// doing it twice. A call annotated with @idempotent(key: ...) is recorded
+// under its key once it completes, and later calls with the same key are
+// skipped and give the recorded result instead. The host keeps the records
+// between runs (the CLI keeps them per script in the user's state
+// directory).
+import { CheckpointError, decodeValue, encodeValue } from './checkpoint.js';
+
+/**
+ * A completed idempotent call
+ */
+export interface IdempotencyRecord {
+  /** What the call returned, in the JSON form checkpoints use */
+  result?: unknown;
+  /** When the call completed, as an ISO date */
+  at: string;
+}
+
+/**
+ * Where the completed idempotent calls of a script are recorded, by key
+ */
+export interface IdempotencyStore {
+  get(key: string): Promise<IdempotencyRecord | undefined>;
+  set(key: string, record: IdempotencyRecord): Promise<void>;
+}
+
+/**
+ * Keeps records in memory, for tests and hosts that only skip the
+ * duplicate calls of a single run
+ */
+export class MemoryIdempotencyStore implements IdempotencyStore {
+  private readonly records = new Map<string, IdempotencyRecord>();
+
+  async get(key: string): Promise<IdempotencyRecord | undefined> {
+    return this.records.get(key);
+  }
+
+  async set(key: string, record: IdempotencyRecord): Promise<void> {
+    this.records.set(key, record);
+  }
+}
+
+/**
+ * Runs the idempotent calls of a run, as used by generated code through
+ * `__idempotent`
+ */
+export class IdempotentCalls {
+  /** Calls in progress, so concurrent calls with one key run it once */
+  private readonly running = new Map<string, Promise<unknown>>();
+
+  constructor(
+    private readonly store: IdempotencyStore = new MemoryIdempotencyStore(),
+    private readonly log?: (message: string) => void,
+    private readonly now: () => Date = () => new Date()
+  ) {}
+
+  /**
+   * Make a call unless one with the same key completed before, in which
+   * case its recorded result is returned. Failed calls are not recorded,
+   * so they are made again
+   * @param label Name of the tool called, for messages
+   */
+  async run<T>(
+    key: unknown,
+    label: string,
+    call: () => Promise<T>
+  ): Promise<T> {
+    if (
+      !(typeof key === 'string' && key !== '') &&
+      !(typeof key === 'number' && Number.isFinite(key))
+    ) {
+      throw new TypeError(
+        `@idempotent ${label}: key must be a non-empty string or a number, got ${key === null ? 'null' : typeof key}`
+      );
+    }
+    const name = String(key);
+    const running = this.running.get(name);
+    if (running) {
+      return running as Promise<T>;
+    }
+
+    const result = this.call(name, label, call);
+    this.running.set(name, result);
+    try {
+      return await result;
+    } finally {
+      this.running.delete(name);
+    }
+  }
+
+  private async call<T>(
+    key: string,
+    label: string,
+    call: () => Promise<T>
+  ): Promise<T> {
+    const record = await this.store.get(key);
+    if (record) {
+      this.log?.(
+        `${label}: skipped, already done with key ${JSON.stringify(key)} at ${record.at}`
+      );
+      return decodeValue(record.result) as T;
+    }
+
+    const result = await call();
+    const done: IdempotencyRecord = { at: this.now().toISOString() };
+    try {
+      done.result = encodeValue(result, 'result');
+    } catch (error) {
+      // The call is still recorded, so it is not made again, but later
+      // runs cannot have its result
+      if (!(error instanceof CheckpointError)) {
+        throw error;
+      }
+      this.log?.(`${label}: result not recorded, ${error.message}`);
+    }
+    await this.store.set(key, done);
+    return result;
+  }
+}
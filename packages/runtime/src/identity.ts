@@ -0,0 +1,50 @@
+// Identity of the caller a run works for
+//
+// A run started by mcpsd or `mcps serve --http` works for whoever asked
+// for it: the subject of an API key, or of the OIDC token the request
+// carried. Its MCP tool calls pass that identity on in their request
+// metadata, under CALLER_META_KEY, so that servers downstream can check
+// what each user may do rather than trusting the executor for everyone.
+import { AsyncLocalStorage } from 'async_hooks';
+
+/** Key of the caller identity in the _meta of MCP requests */
+export const CALLER_META_KEY = 'io.mcpscript/caller';
+
+export interface CallerIdentity {
+  /** Who the caller is, such as a user id or an API key's subject */
+  subject: string;
+  /** Tenant of the executor the caller came through */
+  tenant?: string;
+  /** Issuer of the caller's token, for OIDC callers */
+  issuer?: string;
+  /** Claims of the caller's token that are passed on, such as email */
+  claims?: Record<string, unknown>;
+}
+
+/** Caller the current code runs for */
+const callers = new AsyncLocalStorage<CallerIdentity>();
+
+/**
+ * The caller the current code runs for, if it runs for one
+ */
+export function currentCaller(): CallerIdentity | undefined {
+  return callers.getStore();
+}
+
+/**
+ * Run code for a caller, whose identity the MCP tool calls it makes pass on
+ */
+export function runAsCaller<T>(
+  caller: CallerIdentity,
+  run: () => Promise<T>
+): Promise<T> {
+  return callers.run(caller, run);
+}
+
+/**
+ * Request metadata naming the current caller, if there is one
+ */
+export function callerMeta(): Record<string, unknown> | undefined {
+  const caller = currentCaller();
+  return caller && { [CALLER_META_KEY]: caller };
+}
@@ -1,11 +1,52 @@
 // @mcpscript/runtime - Core runtime library
 export * from './mcp.js';
 export * from './mcp-client.js';
+export * from './http-transport.js';
 export * from './globals.js';
+export * from './inspect.js';
+export * from './redaction.js';
+export * from './script-stack.js';
+export * from './stdio-transport.js';
 export * from './types.js';
 export * from './vm-executor.js';
 export * from './conversation.js';
 export * from './agent.js';
+export * from './providers.js';
+export * from './routing.js';
+export * from './context-window.js';
+export * from './vectors.js';
+export * from './tool-schemas.js';
+export * from './parallel.js';
+export * from './pipeline.js';
+export * from './call-policy.js';
+export * from './faults.js';
+export * from './trace-points.js';
+export * from './circuit-breaker.js';
+export * from './profile.js';
+export * from './debugger.js';
+export * from './tracing.js';
+export * from './timeline.js';
+export * from './run-log.js';
+export * from './testing.js';
+export * from './secrets.js';
+export * from './prompts.js';
+export * from './transcript.js';
+export * from './guardrails.js';
+export * from './checkpoint.js';
+export * from './idempotency.js';
+export * from './events.js';
+export * from './notifications.js';
+export * from './questions.js';
+export * from './arguments.js';
+export * from './outcome.js';
+export * from './matrix.js';
+export * from './format.js';
+export * from './offline.js';
+export * from './packages.js';
+export * from './policy.js';
+export * from './identity.js';
+export * from './spill.js';
+export * from './bytes.js';
 
 // Explicitly re-export commonly used functions and types for clarity
 export { executeInVM, createVMContext } from './vm-executor.js';
@@ -15,4 +56,5 @@ export type {
   RuntimeHandlers,
   AddMessageHandler,
   UserInputHandler,
+  StreamMessageHandler,
 } from './globals.js';
@@ -0,0 +1,211 @@
+// Value inspection for MCP Script
+import type { AddMessageHandler } from './globals.js';
+import { noRedaction, type Redactor } from './redaction.js';
+import { isSpilled } from './spill.js';
+import { isBytes } from './bytes.js';
+
+/**
+ * Limits applied when rendering a value with inspect()
+ */
+export interface InspectOptions {
+  /** Nesting depth after which objects and arrays are collapsed */
+  maxDepth?: number;
+  /** Maximum number of array items, object keys or collection entries */
+  maxItems?: number;
+  /** Maximum number of characters shown for a string */
+  maxStringLength?: number;
+}
+
+const DEFAULT_OPTIONS: Required<InspectOptions> = {
+  maxDepth: 4,
+  maxItems: 20,
+  maxStringLength: 200,
+};
+
+const INDENT = '  ';
+
+/**
+ * Render a value as an indented, type-annotated tree
+ */
+export function formatInspect(
+  value: unknown,
+  options: InspectOptions = {}
+): string {
+  const limits = { ...DEFAULT_OPTIONS, ...options };
+  return formatValue(value, 0, limits, new Set());
+}
+
+function formatValue(
+  value: unknown,
+  depth: number,
+  limits: Required<InspectOptions>,
+  seen: Set<object>
+): string {
+  if (value === null) {
+    return 'null';
+  }
+  if (value === undefined) {
+    return 'undefined';
+  }
+
+  switch (typeof value) {
+    case 'string':
+      return `string ${formatString(value, limits.maxStringLength)}`;
+    case 'number':
+    case 'boolean':
+    case 'bigint':
+      return `${typeof value} ${String(value)}`;
+    case 'symbol':
+      return `symbol ${String(value)}`;
+    case 'function':
+      return `function ${value.name || '(anonymous)'}`;
+  }
+
+  // Spilled results are shown without reading them back
+  if (isSpilled(value)) {
+    const { size, length, preview } = value;
+    const start = formatString(
+      preview.slice(0, limits.maxStringLength),
+      limits.maxStringLength
+    );
+    const header = `spilled string(${formatSize(size)}, ${length} chars)`;
+    return `${header} ${start}… at ${value.file}`;
+  }
+
+  // Bytes are shown as hex rather than byte by byte
+  if (isBytes(value)) {
+    const shown = Array.from(value.subarray(0, limits.maxItems), byte =>
+      byte.toString(16).padStart(2, '0')
+    ).join(' ');
+    const more = value.length - limits.maxItems;
+    const rest = more > 0 ? `… (${more} more bytes)` : '';
+    return `bytes(${formatSize(value.length)}) <${shown}>${rest}`;
+  }
+
+  const obj = value as object;
+  if (seen.has(obj)) {
+    return '[Circular]';
+  }
+
+  // Values created inside the VM come from another realm, so check the
+  // built-in tag rather than using instanceof
+  const tag = Object.prototype.toString.call(obj);
+  if (tag === '[object Date]') {
+    const date = obj as Date;
+    const time = date.getTime();
+    return `Date ${isNaN(time) ? 'Invalid Date' : date.toISOString()}`;
+  }
+  if (tag === '[object Error]') {
+    const error = obj as Error;
+    const message = formatString(error.message, limits.maxStringLength);
+    return `${error.name} ${message}`;
+  }
+
+  let header: string;
+  let entries: [string, unknown][];
+  let open: string;
+  let close: string;
+  let total: number;
+
+  if (Array.isArray(obj)) {
+    header = `array(${obj.length})`;
+    [open, close] = ['[', ']'];
+    total = obj.length;
+    entries = obj
+      .slice(0, limits.maxItems)
+      .map((item, i) => [`[${i}]`, item] as [string, unknown]);
+  } else if (tag === '[object Map]') {
+    const map = obj as Map<unknown, unknown>;
+    header = `Map(${map.size})`;
+    [open, close] = ['{', '}'];
+    total = map.size;
+    entries = Array.from(map.entries())
+      .slice(0, limits.maxItems)
+      .map(([k, v]) => [`${formatKey(k)} =>`, v] as [string, unknown]);
+  } else if (tag === '[object Set]') {
+    const set = obj as Set<unknown>;
+    header = `Set(${set.size})`;
+    [open, close] = ['[', ']'];
+    total = set.size;
+    entries = Array.from(set.values())
+      .slice(0, limits.maxItems)
+      .map(item => ['', item] as [string, unknown]);
+  } else {
+    const keys = Object.keys(obj);
+    const name = obj.constructor?.name;
+    header = name && name !== 'Object' ? name : 'object';
+    [open, close] = ['{', '}'];
+    total = keys.length;
+    const record = obj as Record<string, unknown>;
+    entries = keys
+      .slice(0, limits.maxItems)
+      .map(key => [`${key}:`, record[key]] as [string, unknown]);
+  }
+
+  if (total === 0) {
+    return `${header} ${open}${close}`;
+  }
+  if (depth >= limits.maxDepth) {
+    return `${header} ${open}…${close}`;
+  }
+
+  seen.add(obj);
+  const indent = INDENT.repeat(depth + 1);
+  const lines = entries.map(([label, item]) => {
+    const rendered = formatValue(item, depth + 1, limits, seen);
+    return `${indent}${label ? `${label} ` : ''}${rendered}`;
+  });
+  seen.delete(obj);
+
+  if (total > entries.length) {
+    lines.push(`${indent}… ${total - entries.length} more`);
+  }
+  const closing = `${INDENT.repeat(depth)}${close}`;
+  return `${header} ${open}\n${lines.join('\n')}\n${closing}`;
+}
+
+function formatString(value: string, maxLength: number): string {
+  if (value.length <= maxLength) {
+    return JSON.stringify(value);
+  }
+  const truncated = JSON.stringify(value.slice(0, maxLength));
+  return `${truncated}… (${value.length - maxLength} more chars)`;
+}
+
+/**
+ * A size in bytes, in the largest unit it has one of
+ */
+export function formatSize(bytes: number): string {
+  const units = ['B', 'KB', 'MB', 'GB'];
+  let size = bytes;
+  let unit = 0;
+  while (size >= 1024 && unit < units.length - 1) {
+    size /= 1024;
+    unit++;
+  }
+  return unit === 0 ? `${bytes} B` : `${size.toFixed(1)} ${units[unit]}`;
+}
+
+function formatKey(key: unknown): string {
+  return typeof key === 'string' ? JSON.stringify(key) : String(key);
+}
+
+/**
+ * Create an inspect function with the given handler
+ * The inspected value is returned so calls can wrap expressions inline
+ */
+export function createInspect(
+  addMessage?: AddMessageHandler,
+  options: InspectOptions = {},
+  redactor: Redactor = noRedaction
+) {
+  return function inspect<T>(value: T, label?: string): T {
+    const body = formatInspect(redactor.redact(value), options);
+    if (addMessage) {
+      addMessage({ title: label ?? 'inspect', body });
+    } else {
+      console.log(label ? `${label}: ${body}` : body);
+    }
+    return value;
+  };
+}
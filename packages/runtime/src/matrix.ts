@@ -0,0 +1,155 @@
+// Parameter sweeps: a workflow run once per combination of arguments
+//
+// A matrix gives the values to try for each parameter of a script's main
+// tool, such as { "model": ["gpt-4o", "claude"], "temperature": [0, 0.7] }.
+// Every combination of them is run, a few at a time, and each run's
+// result, error and duration are collected, so prompts or configurations
+// can be compared side by side.
+import { types } from 'util';
+import type { ScriptArguments } from './arguments.js';
+import { runParallel } from './parallel.js';
+
+/**
+ * Values to try by parameter name; a value that is not a list is used in
+ * every run
+ */
+export type ParameterMatrix = Record<string, unknown>;
+
+/**
+ * One run of a matrix
+ */
+export interface MatrixRun {
+  /** Arguments of the run, by parameter name */
+  args: ScriptArguments;
+  status: 'ok' | 'failed';
+  /** What the run returned, when it succeeded */
+  result?: unknown;
+  /** Why the run failed */
+  error?: string;
+  durationMs: number;
+}
+
+/**
+ * How the runs with one value of a parameter went
+ */
+export interface MatrixAggregate {
+  parameter: string;
+  value: unknown;
+  runs: number;
+  failed: number;
+  meanMs: number;
+}
+
+export interface MatrixOptions {
+  /** Most runs at once (default: 1) */
+  parallel?: number;
+  /** Called as each run finishes, with its index among the combinations */
+  onRun?: (run: MatrixRun, index: number) => void;
+}
+
+/**
+ * Error thrown for a matrix that does not describe any runs
+ */
+export class MatrixError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'MatrixError';
+  }
+}
+
+/**
+ * Every combination of the values of a matrix, varying the last parameter
+ * fastest
+ */
+export function matrixCombinations(
+  matrix: ParameterMatrix
+): ScriptArguments[] {
+  if (typeof matrix !== 'object' || matrix === null || Array.isArray(matrix)) {
+    throw new MatrixError(
+      'A matrix must be an object of the values to try by parameter name'
+    );
+  }
+  let combinations: ScriptArguments[] = [{}];
+  for (const [name, values] of Object.entries(matrix)) {
+    const options = Array.isArray(values) ? values : [values];
+    if (options.length === 0) {
+      throw new MatrixError(`Parameter ${name} of the matrix has no values`);
+    }
+    combinations = combinations.flatMap(args =>
+      options.map(value => ({ ...args, [name]: value }))
+    );
+  }
+  return combinations;
+}
+
+/**
+ * Run a workflow for every combination of the values of a matrix, at most
+ * `parallel` at once. Failed runs are recorded rather than thrown, so one
+ * bad combination does not stop the others
+ */
+export async function runMatrix(
+  matrix: ParameterMatrix,
+  run: (args: ScriptArguments, index: number) => Promise<unknown>,
+  options: MatrixOptions = {}
+): Promise<MatrixRun[]> {
+  const combinations = matrixCombinations(matrix);
+  return runParallel(
+    combinations.map((args, index) => async () => {
+      const start = performance.now();
+      const finished = (outcome: Partial<MatrixRun>): MatrixRun => {
+        const record = {
+          args,
+          status: 'ok' as const,
+          ...outcome,
+          durationMs: performance.now() - start,
+        };
+        options.onRun?.(record, index);
+        return record;
+      };
+      try {
+        return finished({ result: await run(args, index) });
+      } catch (error) {
+        return finished({
+          status: 'failed',
+          error: types.isNativeError(error) ? error.message : String(error),
+        });
+      }
+    }),
+    options.parallel ?? 1
+  );
+}
+
+/**
+ * For each value of each parameter the matrix varies, how many of the runs
+ * with it failed and how long they took on average
+ */
+export function aggregateMatrix(runs: MatrixRun[]): MatrixAggregate[] {
+  const parameters = [...new Set(runs.flatMap(run => Object.keys(run.args)))];
+  const aggregates: MatrixAggregate[] = [];
+  for (const parameter of parameters) {
+    const values = new Map<string, { value: unknown; runs: MatrixRun[] }>();
+    for (const run of runs) {
+      const value = run.args[parameter];
+      const key = JSON.stringify(value) ?? 'undefined';
+      const group = values.get(key) ?? { value, runs: [] };
+      group.runs.push(run);
+      values.set(key, group);
+    }
+    // Parameters with a single value do not tell runs apart
+    if (values.size < 2) {
+      continue;
+    }
+    for (const { value, runs: group } of values.values()) {
+      aggregates.push({
+        parameter,
+        value,
+        runs: group.length,
+        failed: group.filter(run => run.status === 'failed').length,
+        meanMs:
+          group.reduce((total, run) => total + run.durationMs, 0) /
+          group.length,
+      });
+    }
+  }
+  return aggregates;
+}
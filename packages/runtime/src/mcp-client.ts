@@ -7,11 +7,35 @@ import type { SSEClientTransportOptions } from '@modelcontextprotocol/sdk/client
 import { StreamableHTTPClientTransport } from '@modelcontextprotocol/sdk/client/streamableHttp.js';
 import type { StreamableHTTPClientTransportOptions } from '@modelcontextprotocol/sdk/client/streamableHttp.js';
 import type { Transport } from '@modelcontextprotocol/sdk/shared/transport.js';
-import { ListRootsRequestSchema } from '@modelcontextprotocol/sdk/types.js';
+import {
+  ListRootsRequestSchema,
+  LoggingMessageNotificationSchema,
+  PromptListChangedNotificationSchema,
+  ResourceListChangedNotificationSchema,
+  ResourceUpdatedNotificationSchema,
+  ToolListChangedNotificationSchema,
+  type Tool,
+} from '@modelcontextprotocol/sdk/types.js';
 import { FunctionTool } from '@llamaindex/core/tools';
 import type { BaseToolWithCall } from '@llamaindex/core/llms';
-import { pathToFileURL } from 'url';
 import { createRequire } from 'module';
+import { killProcessTree, resolveSpawnCommand, rootUri } from './platform.js';
+import {
+  reconnectionOptions,
+  remoteTransportOptions,
+  usesSSETransport,
+  type RemoteServerOptions,
+} from './http-transport.js';
+import {
+  IsolatedStdioTransport,
+  usesStdioIsolation,
+  type IsolatedStdioParameters,
+  type OutputCapture,
+} from './stdio-transport.js';
+import type { JSONSchema, ToolSchema } from './tool-schemas.js';
+import { currentBranchSignal } from './parallel.js';
+import type { FaultInjector } from './faults.js';
+import { callerMeta } from './identity.js';
 
 // Import package.json to get version
 const require = createRequire(import.meta.url);
@@ -25,21 +49,27 @@ type MCPCommonOptions = {
   toolNamePrefix?: string;
   /** The name of the client */
   clientName?: string;
+  /** The name of the server as declared in the script (used in errors) */
+  serverName?: string;
+  /** Faults injected into the server's tool calls, in test runs */
+  faults?: FaultInjector;
 };
 
 /**
  * Options for URL-based MCP clients (HTTP/SSE)
  */
-type URLMCPOptions = MCPCommonOptions & {
-  url: string;
-  /** Use SSE transport (deprecated, use StreamableHTTP instead) */
-  useSSETransport?: boolean;
-};
+type URLMCPOptions = MCPCommonOptions & RemoteServerOptions;
 
 /**
  * Options for stdio-based MCP clients
+ * The isolation options (inheritEnv, allowEnv, stderr: "capture",
+ * stderrLimit, priority) switch to a transport that controls the
+ * server process environment
  */
-type StdioMCPClientOptions = StdioServerParameters & MCPCommonOptions;
+type StdioMCPClientOptions = Omit<StdioServerParameters, 'stderr'> &
+  Pick<IsolatedStdioParameters, 'stderr'> &
+  Omit<IsolatedStdioParameters, 'command' | 'stderr'> &
+  MCPCommonOptions;
 
 /**
  * Options for SSE-based MCP clients (deprecated)
@@ -60,6 +90,32 @@ export type MCPClientOptions =
   | SSEMCPClientOptions
   | StreamableHTTPMCPClientOptions;
 
+/**
+ * Schemas of the notifications scripts can handle, by the name scripts give
+ * them: the method without its notifications/ prefix
+ */
+const NOTIFICATION_SCHEMAS = {
+  'resources/updated': ResourceUpdatedNotificationSchema,
+  'resources/list_changed': ResourceListChangedNotificationSchema,
+  'tools/list_changed': ToolListChangedNotificationSchema,
+  'prompts/list_changed': PromptListChangedNotificationSchema,
+  message: LoggingMessageNotificationSchema,
+};
+
+/**
+ * A notification scripts can handle
+ */
+export type ServerNotification = keyof typeof NOTIFICATION_SCHEMAS;
+
+/**
+ * Whether scripts can handle notifications of the given name
+ */
+export function isServerNotification(
+  name: string
+): name is ServerNotification {
+  return Object.hasOwn(NOTIFICATION_SCHEMAS, name);
+}
+
 /**
  * Custom MCP Client with roots support
  * This replaces the LlamaIndex mcp() function to add support for the roots protocol
@@ -67,8 +123,15 @@ export type MCPClientOptions =
 export class MCPClient {
   private client: Client;
   private transport: Transport | null = null;
+  /** The transport the client talks through, faulted when asked to */
+  private connection: Transport | null = null;
   private toolNamePrefix?: string;
+  private serverName: string;
   private connected = false;
+  private connecting: Promise<void> | null = null;
+  private closed = false;
+  /** Tools the server listed, kept so they are only requested once */
+  private toolList?: Tool[];
 
   constructor(options: MCPClientOptions) {
     // Initialize MCP SDK client with capabilities
@@ -88,29 +151,67 @@ export class MCPClient {
     );
 
     this.toolNamePrefix = options.toolNamePrefix;
+    this.serverName =
+      options.serverName ?? ('url' in options ? options.url : options.command);
 
     // Set up request handlers
     this.setupRequestHandlers();
 
     // Create appropriate transport
     if ('url' in options) {
-      const useSSETransport = options.useSSETransport ?? false;
-      if (useSSETransport) {
+      const transportOptions = {
+        ...options,
+        ...remoteTransportOptions(options),
+      };
+      if (usesSSETransport(options)) {
         this.transport = new SSEClientTransport(
           new URL(options.url),
-          options as SSEClientTransportOptions
+          transportOptions as SSEClientTransportOptions
         );
       } else {
+        // Dropped event streams are resumed; SSE reconnects by itself
         this.transport = new StreamableHTTPClientTransport(
           new URL(options.url),
-          options as StreamableHTTPClientTransportOptions
+          {
+            ...transportOptions,
+            reconnectionOptions: reconnectionOptions(options.reconnect),
+          } as StreamableHTTPClientTransportOptions
         );
       }
     } else {
-      this.transport = new StdioClientTransport(
-        options as StdioServerParameters
-      );
+      const spawnOptions = {
+        ...options,
+        ...resolveSpawnCommand(options.command, options.args),
+      };
+      this.transport = usesStdioIsolation(options)
+        ? new IsolatedStdioTransport(spawnOptions)
+        : new StdioClientTransport(spawnOptions as StdioServerParameters);
+    }
+    this.connection = options.faults
+      ? options.faults.wrapTransport(this.transport, this.serverName)
+      : this.transport;
+  }
+
+  /**
+   * Process id of a stdio server, once it has been spawned
+   */
+  private get serverPid(): number | null {
+    if (
+      this.transport instanceof StdioClientTransport ||
+      this.transport instanceof IsolatedStdioTransport
+    ) {
+      return this.transport.pid ?? null;
     }
+    return null;
+  }
+
+  /**
+   * Recent stderr output of the server, when it is captured
+   */
+  get stderrOutput(): OutputCapture | undefined {
+    return this.transport instanceof IsolatedStdioTransport
+      ? this.transport.stderrOutput
+      : undefined;
   }
 
   /**
@@ -120,13 +221,10 @@ export class MCPClient {
   private setupRequestHandlers(): void {
     // Handle roots/list requests from the server
     this.client.setRequestHandler(ListRootsRequestSchema, async () => {
-      const cwd = process.cwd();
-      const cwdUri = pathToFileURL(cwd).href;
-
       return {
         roots: [
           {
-            uri: cwdUri,
+            uri: rootUri(process.cwd()),
             name: 'Current Working Directory',
           },
         ],
@@ -136,34 +234,128 @@ export class MCPClient {
 
   /**
    * Connect to the MCP server
+   * Spawns the server process (for stdio) and performs the initialize
+   * handshake. Concurrent callers share a single connection attempt.
    */
   async connectToServer(): Promise<void> {
-    if (!this.transport) {
+    if (!this.connection) {
       throw new Error('Initialized with invalid options');
     }
+    if (this.closed) {
+      throw new Error(`MCP server "${this.serverName}" has been shut down`);
+    }
+    if (this.connected) {
+      return;
+    }
 
-    await this.client.connect(this.transport);
-    this.connected = true;
+    if (!this.connecting) {
+      this.connecting = this.client
+        .connect(this.connection)
+        .then(() => {
+          this.connected = true;
+        })
+        .catch((error: unknown) => {
+          this.connecting = null;
+          const reason =
+            error instanceof Error ? error.message : String(error);
+          const stderr = this.stderrOutput?.toString().trim();
+          throw new Error(
+            `Failed to connect to MCP server "${this.serverName}": ${reason}` +
+              (stderr ? `\nServer stderr:\n${stderr}` : '')
+          );
+        });
+    }
+
+    await this.connecting;
   }
 
   /**
    * List tools available from the MCP server
    */
-  private async listTools() {
+  private async listTools(): Promise<Tool[]> {
+    if (this.toolList) {
+      return this.toolList;
+    }
     if (!this.connected) {
       await this.connectToServer();
     }
 
     const result = await this.client.listTools();
-    return result.tools;
+    this.toolList = result.tools;
+    return this.toolList;
+  }
+
+  /**
+   * Schemas of the server's tools, once they have been listed
+   */
+  get listedTools(): ToolSchema[] | undefined {
+    return this.toolList?.map(tool => ({
+      name: tool.name,
+      description: tool.description,
+      inputSchema: tool.inputSchema as JSONSchema,
+    }));
+  }
+
+  /**
+   * Handle the notifications of a kind the server sends, receiving their
+   * parameters; a later handler of the same kind replaces the earlier one
+   */
+  onNotification(
+    notification: ServerNotification,
+    handler: (params: Record<string, unknown>) => void
+  ): void {
+    // The schemas differ only in their method and parameters
+    const schema = NOTIFICATION_SCHEMAS[
+      notification
+    ] as typeof ResourceUpdatedNotificationSchema;
+    this.client.setNotificationHandler(schema, async ({ params }) => {
+      const received: Record<string, unknown> = { ...params };
+      delete received._meta;
+      handler(received);
+    });
+  }
+
+  /**
+   * Ask the server to send resources/updated notifications for a resource
+   */
+  async subscribeResource(uri: string): Promise<void> {
+    if (!this.connected) {
+      await this.connectToServer();
+    }
+    await this.client.subscribeResource({ uri });
+  }
+
+  /**
+   * Whether the client has been shut down
+   */
+  get isClosed(): boolean {
+    return this.closed;
   }
 
   /**
    * Clean up resources
+   * Closes the session and terminates the server process. Safe to call
+   * more than once; subsequent calls are no-ops.
    */
   async cleanup(): Promise<void> {
-    await this.client.close();
-    await this.transport?.close();
+    if (this.closed) {
+      return;
+    }
+    this.closed = true;
+    this.connected = false;
+
+    // Stop the whole process tree while the server is still running, since
+    // its children cannot be found once it has exited
+    const pid = this.serverPid;
+    if (pid !== null) {
+      await killProcessTree(pid);
+    }
+
+    try {
+      await this.client.close();
+    } finally {
+      await this.connection?.close();
+    }
   }
 
   /**
@@ -177,10 +369,18 @@ export class MCPClient {
 
       const functionTool = FunctionTool.from(
         async (input: Record<string, unknown>) => {
-          const result = await this.client.callTool({
-            name: tool.name,
-            arguments: input,
-          });
+          if (this.closed) {
+            throw new Error(
+              `Cannot call tool "${tool.name}": MCP server "${this.serverName}" has been shut down`
+            );
+          }
+          // Calls in a cancelled parallel branch are cancelled too, and
+          // calls made for a caller tell the server who it is
+          const result = await this.client.callTool(
+            { name: tool.name, arguments: input, _meta: callerMeta() },
+            undefined,
+            { signal: currentBranchSignal() }
+          );
 
           // eslint-disable-next-line @typescript-eslint/no-explicit-any
           return result as any;
@@ -1,33 +1,161 @@
 // MCP server management
-import { MCPServerConfig } from './types.js';
 import { FunctionTool } from '@llamaindex/core/tools';
 import { z } from 'zod';
+import { MCPClient, type MCPClientOptions } from './mcp-client.js';
+import {
+  checkToolInput,
+  parameterNames,
+  ToolInputError,
+  type JSONSchema,
+  type ToolSchema,
+} from './tool-schemas.js';
+import { callWithPolicy, type CallPolicy } from './call-policy.js';
+import type { CircuitBreaker } from './circuit-breaker.js';
+import type { RunOutcome } from './outcome.js';
+import { isSpilled, unspill, type SpillStore } from './spill.js';
+import { decodeContent, encodeBytes, isBytes } from './bytes.js';
+import type { ToolAdmission } from './profile.js';
+import { traced, type Attributes } from './tracing.js';
 
+/**
+ * Tracks the MCP server clients started during a script execution
+ * so that they can be shut down together, even when the script fails
+ */
 export class MCPServerManager {
-  private servers = new Map<string, MCPServerConfig>();
+  private clients: MCPClient[] = [];
+  private named = new Map<string, MCPClient>();
+  /** Tools listed by servers that have been shut down */
+  private schemas = new Map<string, ToolSchema[]>();
+
+  /**
+   * Create a client for the given server options and start tracking it
+   * The underlying server process is spawned lazily on first use
+   */
+  connect(options: MCPClientOptions): MCPClient {
+    const client = new MCPClient(options);
+    this.clients.push(client);
+    if (options.serverName) {
+      this.named.set(options.serverName, client);
+    }
+    return client;
+  }
 
-  registerServer(name: string, config: MCPServerConfig): void {
-    this.servers.set(name, config);
+  /**
+   * Get the client for a declared server by name
+   */
+  getServer(name: string): MCPClient | undefined {
+    return this.named.get(name);
+  }
+
+  /**
+   * Tools listed by each declared server so far, including servers that
+   * have since been shut down
+   */
+  toolSchemas(): Record<string, ToolSchema[]> {
+    const schemas = Object.fromEntries(this.schemas);
+    for (const [name, client] of this.named) {
+      if (client.listedTools) {
+        schemas[name] = client.listedTools;
+      }
+    }
+    return schemas;
   }
 
-  getServer(name: string): MCPServerConfig | undefined {
-    return this.servers.get(name);
+  /**
+   * Number of servers currently tracked
+   */
+  get size(): number {
+    return this.clients.length;
   }
+
+  /**
+   * Shut down all tracked servers
+   * Failures of individual servers are collected so that one misbehaving
+   * server does not prevent the others from being stopped
+   */
+  async closeAll(): Promise<void> {
+    const clients = this.clients;
+    this.clients = [];
+    for (const [name, client] of this.named) {
+      if (client.listedTools) {
+        this.schemas.set(name, client.listedTools);
+      }
+    }
+    this.named.clear();
+
+    const results = await Promise.allSettled(
+      clients.map(client => client.cleanup())
+    );
+    const failures = results.filter(
+      (r): r is PromiseRejectedResult => r.status === 'rejected'
+    );
+    if (failures.length > 0) {
+      const reasons = failures.map(f =>
+        f.reason instanceof Error ? f.reason.message : String(f.reason)
+      );
+      throw new Error(
+        `Failed to shut down ${failures.length} MCP server(s): ${reasons.join('; ')}`
+      );
+    }
+  }
+}
+
+/**
+ * Told about each MCP tool call as it is sent; the returned function is
+ * called once it is answered or fails
+ */
+export interface ToolCallObserver {
+  toolCallStarted(tool: string, input: unknown): () => void;
 }
 
 /**
  * Create a tool proxy object for an MCP server
  * This wraps the tools array with convenient method-style access
  * Uses Proxy to expose __mcp_tools metadata (similar to user-defined tools)
+ * Inputs are checked against each tool's schema before the call is sent
+ * Calls run under the server's default policy, which `__mcp_call` lets a
+ * single call override, and each is traced as a span when tracing is on
+ * With a gate, calls of the script and of agents are only sent once the
+ * run's execution profile and sandbox policy admit them, and with a circuit breaker, every
+ * attempt fails at once while the tool's circuit is open. Error results the
+ * script's calls are answered with are recorded in the run's outcome, and
+ * with a spill store, text results over its threshold are kept on disk
+ * Bytes in inputs are sent as base64, and the binary content of results is
+ * decoded to bytes
  */
 export function createToolProxy(
   // eslint-disable-next-line @typescript-eslint/no-explicit-any
-  tools: Array<any>
+  tools: Array<any>,
+  serverName?: string,
+  defaults: CallPolicy = {},
+  observer?: ToolCallObserver,
+  gate?: ToolAdmission,
+  breaker?: CircuitBreaker,
+  outcome?: RunOutcome,
+  spill?: SpillStore
 ): Record<string, (...args: unknown[]) => Promise<unknown>> {
+  const calls = new Map<
+    string,
+    (args: unknown[], policy?: CallPolicy) => Promise<unknown>
+  >();
   const target: Record<string, (...args: unknown[]) => Promise<unknown>> = {};
+  // The tools as handed to agents, whose calls are traced too
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  const agentTools: Array<any> = [];
 
   for (const tool of tools) {
-    target[tool.metadata.name] = async (...args: unknown[]) => {
+    const label = serverName
+      ? `${serverName}.${tool.metadata.name}`
+      : tool.metadata.name;
+    const schema: JSONSchema | undefined = tool.metadata.parameters;
+    const attributes: Attributes = { 'mcp.tool': tool.metadata.name };
+    if (serverName) {
+      attributes['mcp.server'] = serverName;
+    }
+    const send = (input: unknown) =>
+      breaker ? breaker.call(label, () => tool.call(input)) : tool.call(input);
+
+    const invoke = async (args: unknown[], policy: CallPolicy) => {
       // eslint-disable-next-line @typescript-eslint/no-explicit-any
       let toolInput: any;
 
@@ -35,16 +163,22 @@ export function createToolProxy(
       if (
         args.length === 1 &&
         typeof args[0] === 'object' &&
-        !Array.isArray(args[0])
+        !Array.isArray(args[0]) &&
+        !isSpilled(args[0]) &&
+        !isBytes(args[0])
       ) {
         toolInput = args[0];
       } else {
         // Map positional arguments to schema parameter names
-        const params = tool.metadata.parameters;
-
-        if (params && params.properties) {
+        if (schema && schema.properties) {
           // Get parameter names from the schema
-          const paramNames = Object.keys(params.properties);
+          const paramNames = parameterNames(schema);
+          if (args.length > paramNames.length) {
+            throw new ToolInputError(label, [
+              `expected at most ${paramNames.length} arguments, ` +
+                `got ${args.length}`,
+            ]);
+          }
           toolInput = {};
           paramNames.forEach((paramName: string, index: number) => {
             if (index < args.length) {
@@ -59,28 +193,89 @@ export function createToolProxy(
         }
       }
 
-      // Call the tool with the mapped input
-      const result = await tool.call(toolInput);
+      // Spilled results passed on are sent as their text, and bytes as
+      // base64
+      if (spill) {
+        toolInput = unspill(toolInput);
+      }
+      toolInput = encodeBytes(toolInput);
 
-      // Extract text content from the result if it's in MCP format
+      if (schema) {
+        const problems = checkToolInput(schema, toolInput);
+        if (problems.length > 0) {
+          throw new ToolInputError(label, problems);
+        }
+      }
+
+      await gate?.admit(label, toolInput);
+
+      // Call the tool with the mapped input, under the merged policy
+      const merged = { ...defaults, ...policy };
+      const done = observer?.toolCallStarted(label, toolInput);
+      let result;
+      try {
+        result = Object.values(merged).some(value => value !== undefined)
+          ? await callWithPolicy(label, merged, () => send(toolInput))
+          : await send(toolInput);
+      } finally {
+        done?.();
+      }
+
+      // The script gets error results like any other, but the run notes them
+      if (result?.isError) {
+        const text =
+          result.content?.[0]?.type === 'text'
+            ? result.content[0].text
+            : JSON.stringify(result.content);
+        outcome?.record(
+          'errorResult',
+          `${label} answered with an error: ${text}`
+        );
+      }
+
+      // Extract text content from the result if it's in MCP format, and
+      // spill text over the run's threshold to disk; other content has its
+      // binary data decoded to bytes
       if (result && result.content && Array.isArray(result.content)) {
         return result.content[0]?.type === 'text'
-          ? result.content[0].text
-          : result.content;
+          ? (spill?.keep(result.content[0].text) ?? result.content[0].text)
+          : decodeContent(result.content);
       }
 
       return result;
     };
+    const call = (args: unknown[], policy: CallPolicy = {}) =>
+      traced('mcps.tool_call', attributes, () => invoke(args, policy));
+    calls.set(tool.metadata.name, call);
+    target[tool.metadata.name] = (...args: unknown[]) => call(args);
+    agentTools.push({
+      metadata: tool.metadata,
+      call: async (input: unknown) => {
+        await gate?.admit(label, input);
+        return traced('mcps.tool_call', attributes, () => send(input));
+      },
+    });
   }
 
-  // Wrap in Proxy to expose __mcp_tools metadata
+  // Call a tool by name with a policy for this call only
+  const callWith = (name: string, args: unknown[], policy?: CallPolicy) => {
+    const call = calls.get(name);
+    if (!call) {
+      const server = serverName ?? 'MCP server';
+      throw new TypeError(`${server}.${name} is not a tool`);
+    }
+    return call(args, policy);
+  };
+
+  // Wrap in Proxy to expose __mcp_tools metadata and __mcp_call
   return new Proxy(target, {
     get(target, prop) {
-      if (prop === '__mcp_tools') return tools;
+      if (prop === '__mcp_tools') return agentTools;
+      if (prop === '__mcp_call') return callWith;
       return target[prop as string];
     },
     has(target, prop) {
-      if (prop === '__mcp_tools') return true;
+      if (prop === '__mcp_tools' || prop === '__mcp_call') return true;
       return prop in target;
     },
   });
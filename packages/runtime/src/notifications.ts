@@ -0,0 +1,232 @@
+// Notifications of MCP servers
+//
+// A script handles what its servers tell it, such as a resource that was
+// updated or a tool list that changed, with `on notification(fs,
+// "resources/updated", "file:///data/report.csv") (update) { ... }`, which
+// registers the handler where it is declared and, for resources/updated,
+// subscribes to the resource. `listen(until: count >= 3, timeout: 10m)`
+// then keeps the run going while notifications come in: handlers run one
+// at a time, in the order their notifications arrived, and the condition
+// is checked after each one. A handler that fails ends the listening with
+// its error, so the script fails as it would had the handler's code run
+// inline.
+import { types } from 'util';
+import type { z } from 'zod';
+import {
+  isServerNotification,
+  type ServerNotification,
+} from './mcp-client.js';
+
+/**
+ * The part of an MCP client notifications are taken from; mock servers
+ * have one that never notifies
+ */
+export interface NotificationSource {
+  onNotification(
+    notification: ServerNotification,
+    handler: (params: Record<string, unknown>) => void
+  ): void;
+  subscribeResource(uri: string): Promise<void>;
+}
+
+/**
+ * Describe why parameters do not match a handler's type
+ */
+function describeIssues(error: z.ZodError): string {
+  return error.issues
+    .map(issue =>
+      issue.path.length > 0
+        ? `${issue.path.join('.')}: ${issue.message}`
+        : issue.message
+    )
+    .join('; ');
+}
+
+/**
+ * A listen statement waiting for its condition
+ */
+interface Listener {
+  until?: () => unknown;
+  finish(error?: unknown): void;
+}
+
+/**
+ * The notification handlers of a run, as used by generated code through
+ * `__onNotification` and `__listen`
+ */
+export class ServerNotifications {
+  /** Handlers by server, then by notification */
+  private readonly handlers = new Map<
+    NotificationSource,
+    Map<string, Array<(params: Record<string, unknown>) => Promise<unknown>>>
+  >();
+  /** Notifications are handled one at a time, in the order they came */
+  private handling: Promise<void> = Promise.resolve();
+  private readonly listeners = new Set<Listener>();
+  /** Error of a handler that failed while nothing was listening */
+  private failure?: { error: unknown };
+
+  /**
+   * Register a handler of a server's notifications
+   * @param label Name of the server, for messages
+   * @param schema Type of the handler's parameter, checked on each
+   *   notification
+   * @param resource URI of the resource to subscribe to, for
+   *   resources/updated; the handler only runs for updates of it
+   */
+  async on(
+    server: NotificationSource,
+    label: string,
+    notification: string,
+    body: (params: Record<string, unknown>) => Promise<unknown>,
+    schema?: z.ZodTypeAny,
+    resource?: unknown
+  ): Promise<void> {
+    if (!isServerNotification(notification)) {
+      throw new TypeError(`Unknown notification: ${notification}`);
+    }
+    if (resource !== undefined) {
+      if (notification !== 'resources/updated') {
+        throw new TypeError(
+          `Only resources/updated notifications take a resource URI, got one for ${notification}`
+        );
+      }
+      if (typeof resource !== 'string' || resource === '') {
+        throw new TypeError(
+          `Resource URI must be a non-empty string, got ${resource === null ? 'null' : typeof resource}`
+        );
+      }
+    }
+
+    let byNotification = this.handlers.get(server);
+    if (!byNotification) {
+      byNotification = new Map();
+      this.handlers.set(server, byNotification);
+    }
+    let bodies = byNotification.get(notification);
+    if (!bodies) {
+      const registered: typeof bodies = [];
+      bodies = registered;
+      byNotification.set(notification, registered);
+      server.onNotification(notification, params =>
+        this.dispatch(registered, params)
+      );
+    }
+    bodies.push(async params => {
+      if (resource !== undefined && params.uri !== resource) {
+        return;
+      }
+      const checked = schema?.safeParse(params);
+      if (checked && !checked.success) {
+        throw new TypeError(
+          `Notification ${notification} of ${label}: parameters do not match the handler's type: ${describeIssues(checked.error)}`
+        );
+      }
+      return body(params);
+    });
+
+    if (resource !== undefined) {
+      await server.subscribeResource(resource);
+    }
+  }
+
+  /**
+   * Wait for notifications until the condition holds or the timeout
+   * passes, checking the condition now and after each notification handled
+   * @param until Condition, checked while nothing else runs
+   * @param timeout Milliseconds to wait at most; without one, listening
+   *   lasts until the condition holds
+   */
+  async listen(until?: () => unknown, timeout?: unknown): Promise<void> {
+    if (
+      timeout !== undefined &&
+      (typeof timeout !== 'number' || !(timeout >= 0))
+    ) {
+      throw new TypeError(
+        `listen: timeout must be a non-negative number of milliseconds, got ${timeout === null ? 'null' : typeof timeout}`
+      );
+    }
+    if (this.failure) {
+      const { error } = this.failure;
+      this.failure = undefined;
+      throw error;
+    }
+
+    await new Promise<void>((resolve, reject) => {
+      const timer =
+        timeout === undefined
+          ? undefined
+          : setTimeout(() => listener.finish(), timeout);
+      const listener: Listener = {
+        until,
+        finish: error => {
+          clearTimeout(timer);
+          this.listeners.delete(listener);
+          if (error === undefined) {
+            resolve();
+          } else {
+            reject(error);
+          }
+        },
+      };
+      this.listeners.add(listener);
+      // Notifications that arrived before are handled first
+      this.handling = this.handling.then(() => this.check(listener));
+    });
+  }
+
+  /**
+   * Queue the handlers of a notification, then check what is listening
+   */
+  private dispatch(
+    bodies: Array<(params: Record<string, unknown>) => Promise<unknown>>,
+    params: Record<string, unknown>
+  ): void {
+    this.handling = this.handling.then(async () => {
+      try {
+        for (const body of bodies) {
+          await body(params);
+        }
+      } catch (error) {
+        this.fail(error);
+        return;
+      }
+      for (const listener of [...this.listeners]) {
+        await this.check(listener);
+      }
+    });
+  }
+
+  /**
+   * End a listener's listening once its condition holds
+   */
+  private async check(listener: Listener): Promise<void> {
+    if (!this.listeners.has(listener) || !listener.until) {
+      return;
+    }
+    try {
+      if (await listener.until()) {
+        listener.finish();
+      }
+    } catch (error) {
+      listener.finish(error);
+    }
+  }
+
+  /**
+   * End the listening with a handler's error, or keep it for the next
+   * listen statement when nothing is listening
+   */
+  private fail(error: unknown): void {
+    const failure = types.isNativeError(error)
+      ? error
+      : new Error(String(error));
+    if (this.listeners.size === 0) {
+      this.failure ??= { error: failure };
+      return;
+    }
+    for (const listener of [...this.listeners]) {
+      listener.finish(failure);
+    }
+  }
+}
@@ -0,0 +1,69 @@
+// Offline mode: runs that must not reach the network
+//
+// Regulated and air-gapped environments run scripts that keep everything
+// on the machine. A run in offline mode refuses whatever would connect to
+// another host: servers declared with a url, models and embedders of
+// hosted providers, and trace exporters sending elsewhere. It fails with an
+// OfflineError naming what needed the network before any connection is
+// attempted, instead of waiting for one to time out. Loopback addresses
+// are not the network, so stdio servers, servers on localhost and local
+// models such as Ollama keep working.
+import { DEFAULT_LOCAL_MODEL_URL, type ModelConfig } from './providers.js';
+
+/**
+ * Error thrown instead of reaching the network in offline mode
+ */
+export class OfflineError extends Error {
+  constructor(
+    /** What needed the network, such as "server github" */
+    public readonly subject: string,
+    public readonly url?: string
+  ) {
+    super(
+      `Offline mode does not allow ${subject}, which needs the network` +
+        (url ? ` (${url})` : '')
+    );
+    this.name = 'OfflineError';
+  }
+}
+
+/**
+ * Whether a url is on this machine: localhost, 127.0.0.0/8 or ::1
+ */
+export function isLoopbackURL(url: string): boolean {
+  let host: string;
+  try {
+    host = new URL(url).hostname;
+  } catch {
+    return false;
+  }
+  return (
+    host === 'localhost' ||
+    host.endsWith('.localhost') ||
+    /^127(\.\d{1,3}){3}$/.test(host) ||
+    host === '[::1]'
+  );
+}
+
+/**
+ * Throw an OfflineError unless what is at a url can be reached without the
+ * network; no url stands for a hosted service
+ */
+export function checkOffline(subject: string, url?: string): void {
+  if (url === undefined || !isLoopbackURL(url)) {
+    throw new OfflineError(subject, url);
+  }
+}
+
+/**
+ * The endpoint a model configuration connects to, or undefined for the
+ * hosted API of its provider
+ */
+export function modelEndpoint(config: ModelConfig): string | undefined {
+  if (config.baseURL !== undefined) {
+    return config.baseURL;
+  }
+  return String(config.provider).toLowerCase() === 'local'
+    ? DEFAULT_LOCAL_MODEL_URL
+    : undefined;
+}
@@ -0,0 +1,46 @@
+// What went wrong in a run without failing it
+//
+// An MCP tool can answer a call with an error result instead of failing
+// it, and a parallel map reports the items that failed with its results
+// rather than throwing; the script carries on in both cases. The run's
+// outcome records them, so hosts can decide by their failure policy
+// whether the run failed after all. It also keeps what the script's main
+// tool returned, for hosts comparing runs.
+
+/**
+ * Kinds of trouble a run can get into without failing
+ */
+export type RunIssueKind = 'errorResult' | 'partialFailure';
+
+export interface RunIssue {
+  kind: RunIssueKind;
+  message: string;
+}
+
+/**
+ * The issues of a run, in the order they happened
+ */
+export class RunOutcome {
+  readonly issues: RunIssue[] = [];
+  /** What the script's main tool returned, once it has */
+  result?: unknown;
+
+  record(kind: RunIssueKind, message: string): void {
+    this.issues.push({ kind, message });
+  }
+
+  /**
+   * The issues of one kind
+   */
+  of(kind: RunIssueKind): RunIssue[] {
+    return this.issues.filter(issue => issue.kind === kind);
+  }
+
+  /**
+   * Forget the issues recorded so far, before another run of the same
+   * script's triggers or handlers
+   */
+  clear(): void {
+    this.issues.length = 0;
+  }
+}
@@ -0,0 +1,196 @@
+// Registry packages of stdio servers, and pinning them to locked versions
+//
+// Stdio servers are mostly packages run straight from a registry, such as
+// `npx -y @modelcontextprotocol/server-filesystem` or `uvx mcp-server-fetch`.
+// Without a version, such a server runs whatever was published last, so a
+// script can change behavior without changing itself. A host pins them to
+// the versions in a lockfile: the package argument of the server is
+// rewritten to the locked version before the server starts, and a script
+// asking for another version than the locked one fails with a
+// PackagePinError until it is locked again.
+
+export type PackageEcosystem = 'npm' | 'PyPI';
+
+/**
+ * The registry package a server command runs
+ */
+export interface ServerPackageArgument {
+  ecosystem: PackageEcosystem;
+  name: string;
+  /** Exact version, when the specifier pins one */
+  version?: string;
+  /** The specifier as written, such as "mcp-server-fetch==2025.4.7" */
+  specifier: string;
+  /** Index of the specifier in the server's args */
+  index: number;
+  /** Option the specifier is the value of, such as "--package=" */
+  option?: string;
+}
+
+/**
+ * A package version a lockfile pins a server to
+ */
+export interface PinnedPackage {
+  ecosystem: PackageEcosystem;
+  name: string;
+  version: string;
+  /** Integrity of the published package, such as "sha512-…" for npm */
+  integrity?: string;
+}
+
+/** Runners of registry packages, with the arguments before the package */
+const RUNNERS: Array<{
+  program: string;
+  prefix: string[];
+  ecosystem: PackageEcosystem;
+}> = [
+  { program: 'npx', prefix: [], ecosystem: 'npm' },
+  { program: 'bunx', prefix: [], ecosystem: 'npm' },
+  { program: 'npm', prefix: ['exec'], ecosystem: 'npm' },
+  { program: 'pnpm', prefix: ['dlx'], ecosystem: 'npm' },
+  { program: 'yarn', prefix: ['dlx'], ecosystem: 'npm' },
+  { program: 'uvx', prefix: [], ecosystem: 'PyPI' },
+  { program: 'uv', prefix: ['tool', 'run'], ecosystem: 'PyPI' },
+  { program: 'pipx', prefix: ['run'], ecosystem: 'PyPI' },
+];
+
+/** Runner options naming the package apart from the command run */
+const PACKAGE_OPTIONS: Record<PackageEcosystem, string[]> = {
+  npm: ['-p', '--package'],
+  PyPI: ['--from', '--spec'],
+};
+
+const EXACT_VERSION = /^\d+(\.\d+)*([-+.][\w.]+)?$/;
+
+/**
+ * Split a package specifier into name and exact version, if it pins one
+ */
+export function parsePackageSpecifier(
+  ecosystem: PackageEcosystem,
+  specifier: string
+): { name: string; version?: string } {
+  let name = specifier;
+  let version: string | undefined;
+  if (ecosystem === 'npm') {
+    // The @ of a scope is not a version separator
+    const at = specifier.indexOf('@', 1);
+    if (at > 0) {
+      name = specifier.slice(0, at);
+      version = specifier.slice(at + 1);
+    }
+  } else {
+    const match = /^([^=@<>~!\s]+)\s*(?:==|@)\s*(\S+)$/.exec(specifier);
+    if (match) {
+      [, name, version] = match;
+    }
+    // Ranges and extras such as "mcp-server[cli]>=1.0" do not change the
+    // package
+    name = name.split(/[=<>~!;\s]/)[0].replace(/\[.*\]$/, '');
+  }
+  return {
+    name,
+    ...(version && EXACT_VERSION.test(version) && { version }),
+  };
+}
+
+/**
+ * The specifier of a package at a version, as its runner takes it
+ */
+export function packageSpecifier(
+  ecosystem: PackageEcosystem,
+  name: string,
+  version: string
+): string {
+  return ecosystem === 'npm' ? `${name}@${version}` : `${name}==${version}`;
+}
+
+/**
+ * The registry package a server command runs, if it runs one through a
+ * known runner such as npx or uvx
+ */
+export function findServerPackage(
+  command: string,
+  args: string[] = []
+): ServerPackageArgument | undefined {
+  const program = command.split(/[\\/]/).pop()!.replace(/\.cmd$/, '');
+  const runner = RUNNERS.find(
+    ({ program: name, prefix }) =>
+      name === program && prefix.every((arg, index) => args[index] === arg)
+  );
+  if (!runner) {
+    return undefined;
+  }
+  const { ecosystem, prefix } = runner;
+  for (let index = prefix.length; index < args.length; index++) {
+    const arg = args[index];
+    const [option, value] = arg.split(/=(.*)/s);
+    let found: Pick<ServerPackageArgument, 'index' | 'option'> | undefined;
+    if (PACKAGE_OPTIONS[ecosystem].includes(option)) {
+      found =
+        value === undefined
+          ? { index: index + 1 }
+          : { index, option: `${option}=` };
+    } else if (arg === '--') {
+      found = { index: index + 1 };
+    } else if (!arg.startsWith('-')) {
+      found = { index };
+    }
+    if (found) {
+      const specifier = found.option
+        ? args[found.index].slice(found.option.length)
+        : args[found.index];
+      return specifier
+        ? {
+            ecosystem,
+            ...parsePackageSpecifier(ecosystem, specifier),
+            specifier,
+            ...found,
+          }
+        : undefined;
+    }
+  }
+  return undefined;
+}
+
+/**
+ * Error thrown when a server asks for another package than the one the
+ * lockfile pins it to
+ */
+export class PackagePinError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'PackagePinError';
+  }
+}
+
+/**
+ * The args of a server with its package pinned to the locked version
+ * Servers that do not run the locked package through a runner are left as
+ * they are
+ */
+export function pinServerArgs(
+  server: string,
+  command: string,
+  args: string[] = [],
+  pin: PinnedPackage
+): string[] {
+  const found = findServerPackage(command, args);
+  if (!found || found.ecosystem !== pin.ecosystem) {
+    return args;
+  }
+  if (found.name !== pin.name) {
+    throw new PackagePinError(
+      `Server ${server} runs ${found.name}, but the lockfile pins it to ${pin.name}; lock it again`
+    );
+  }
+  if (found.version !== undefined && found.version !== pin.version) {
+    throw new PackagePinError(
+      `Server ${server} runs ${found.name} ${found.version}, but the lockfile pins ${pin.version}; lock it again`
+    );
+  }
+  const pinned = [...args];
+  pinned[found.index] =
+    (found.option ?? '') +
+    packageSpecifier(pin.ecosystem, pin.name, pin.version);
+  return pinned;
+}
@@ -0,0 +1,185 @@
+// Concurrent execution of parallel blocks
+import { AsyncLocalStorage } from 'async_hooks';
+
+/** Abort signal of the parallel branch the current code runs in */
+const branchSignals = new AsyncLocalStorage<AbortSignal>();
+
+/**
+ * The abort signal of the parallel branch running the caller, if any
+ * MCP tool calls pass it on, so that the server can stop work whose
+ * result is no longer wanted
+ */
+export function currentBranchSignal(): AbortSignal | undefined {
+  return branchSignals.getStore();
+}
+
+/**
+ * Run code with an abort signal that MCP tool calls made by it pass on,
+ * in place of the branch's own
+ */
+export function runWithSignal<T>(
+  signal: AbortSignal,
+  run: () => Promise<T>
+): Promise<T> {
+  return branchSignals.run(signal, run);
+}
+
+/**
+ * Run the branches of a parallel block, at most `limit` at once
+ * Results are returned in branch order, whichever branch finishes first.
+ * When a branch fails, branches that have not started are skipped and the
+ * running ones are signalled to stop; the failure is thrown once they have
+ * all settled, so no branch keeps running after the block
+ */
+export async function runParallel<T>(
+  branches: Array<() => Promise<T>>,
+  limit?: number
+): Promise<T[]> {
+  if (limit !== undefined && (!Number.isInteger(limit) || limit < 1)) {
+    throw new TypeError(
+      `Parallel limit must be a positive integer, got ${limit}`
+    );
+  }
+
+  // Cancelling an enclosing parallel block cancels this one too
+  const controller = new AbortController();
+  const outer = branchSignals.getStore();
+  const cancel = () => controller.abort(outer?.reason);
+  if (outer?.aborted) {
+    cancel();
+  }
+  outer?.addEventListener('abort', cancel, { once: true });
+
+  const results = new Array<T>(branches.length);
+  let failure: { error: unknown } | undefined;
+  let next = 0;
+  const worker = async () => {
+    while (next < branches.length && !controller.signal.aborted) {
+      const index = next++;
+      try {
+        results[index] = await branchSignals.run(
+          controller.signal,
+          branches[index]
+        );
+      } catch (error) {
+        if (!failure) {
+          failure = { error };
+          controller.abort(error);
+        }
+      }
+    }
+  };
+
+  try {
+    const workers = Math.min(limit ?? branches.length, branches.length);
+    await Promise.all(Array.from({ length: workers }, worker));
+  } finally {
+    outer?.removeEventListener('abort', cancel);
+  }
+
+  if (failure) {
+    throw failure.error;
+  }
+  if (controller.signal.aborted) {
+    throw controller.signal.reason;
+  }
+  return results;
+}
+
+/**
+ * How far a parallel map has got
+ */
+export interface MapProgress {
+  /** Items that finished, including those that failed */
+  done: number;
+  failed: number;
+  total: number;
+}
+
+/**
+ * An item of a parallel map whose body threw
+ */
+export interface MapFailure {
+  index: number;
+  item: unknown;
+  error: unknown;
+}
+
+/**
+ * Results of a parallel map in item order, with null for the items that
+ * failed, which `failures` lists
+ */
+export type MapResults<T> = Array<T | null> & { failures: MapFailure[] };
+
+/**
+ * Run a body for each item of a list, at most `limit` at once
+ * Unlike the branches of a parallel block, items are independent: one that
+ * fails does not stop the others, and its error is reported with the
+ * results rather than thrown. Cancelling an enclosing parallel block still
+ * cancels the items that are running and skips the rest
+ */
+export async function parallelMap<T, R>(
+  items: T[],
+  body: (item: T, index: number) => Promise<R>,
+  limit?: number,
+  onProgress?: (progress: MapProgress) => void
+): Promise<MapResults<R>> {
+  if (!Array.isArray(items)) {
+    throw new TypeError(
+      `parallelMap needs a list of items, got ${items === null ? 'null' : typeof items}`
+    );
+  }
+
+  const failures: MapFailure[] = [];
+  const progress: MapProgress = { done: 0, failed: 0, total: items.length };
+  const branches = items.map((item, index) => async () => {
+    try {
+      return await body(item, index);
+    } catch (error) {
+      failures.push({ index, item, error });
+      progress.failed++;
+      return null;
+    } finally {
+      progress.done++;
+      onProgress?.({ ...progress });
+    }
+  });
+
+  const results = (await runParallel(branches, limit)) as MapResults<R>;
+  results.failures = failures.sort((a, b) => a.index - b.index);
+  return results;
+}
+
+/**
+ * Create the parallel map of generated code, which reports the progress of
+ * each map under its label and warns of the items that failed
+ */
+export function createParallelMap(
+  onProgress?: (label: string, progress: MapProgress) => void,
+  warn?: (message: string) => void
+) {
+  return async function <T, R>(
+    items: T[],
+    body: (item: T, index: number) => Promise<R>,
+    limit?: number,
+    label = 'parallelMap'
+  ): Promise<MapResults<R>> {
+    const results = await parallelMap(
+      items,
+      body,
+      limit,
+      onProgress && (progress => onProgress(label, progress))
+    );
+    const { failures } = results;
+    if (failures.length > 0 && warn) {
+      const errors = failures.map(
+        ({ index, error }) =>
+          `items[${index}]: ${error instanceof Error ? error.message : String(error)}`
+      );
+      warn(
+        `${label}: ${failures.length} of ${results.length} items failed: ${errors.join('; ')}`
+      );
+    }
+    return results;
+  };
+}
@@ -0,0 +1,156 @@
+// Pipelines: workflows composed from other workflows
+//
+// A pipeline declaration is a list of named steps, each calling a
+// workflow with arguments taken from the pipeline's parameters and the
+// results of earlier steps. Steps run as soon as the steps they use have
+// finished, so independent steps run concurrently, and a step can fan out
+// to several workflows or to one workflow per item of a list, collecting
+// their results. When a step fails, steps that have not started are
+// skipped, running ones are signalled to stop like the branches of a
+// parallel block, and the pipeline fails with the step's error.
+import { types } from 'util';
+import {
+  currentBranchSignal,
+  runParallel,
+  runWithSignal,
+} from './parallel.js';
+import { traced } from './tracing.js';
+
+/**
+ * A step of a pipeline, as generated code hands it over
+ */
+export interface PipelineStep {
+  name: string;
+  /** Names of the earlier steps whose results the step uses */
+  uses: string[];
+  /** Runs the step with the results of the steps it uses, by name */
+  run: (results: Record<string, unknown>) => Promise<unknown>;
+}
+
+function messageOf(error: unknown): string {
+  return types.isNativeError(error) ? error.message : String(error);
+}
+
+/**
+ * Error thrown when a step of a pipeline fails; its cause is the step's
+ * error
+ */
+export class PipelineError extends Error {
+  constructor(
+    public readonly pipeline: string,
+    public readonly step: string,
+    cause: unknown
+  ) {
+    super(`Pipeline ${pipeline} failed at step ${step}: ${messageOf(cause)}`, {
+      cause,
+    });
+    this.name = 'PipelineError';
+  }
+}
+
+/**
+ * Run the steps of a pipeline, each once the steps it uses have finished,
+ * and return what `output` makes of their results
+ */
+export async function runPipeline(
+  name: string,
+  steps: PipelineStep[],
+  output: (results: Record<string, unknown>) => Promise<unknown>
+): Promise<unknown> {
+  return traced('mcps.pipeline', { 'mcps.pipeline': name }, async () => {
+    // Cancelling an enclosing parallel block cancels the pipeline too
+    const controller = new AbortController();
+    const outer = currentBranchSignal();
+    const cancel = () => controller.abort(outer?.reason);
+    if (outer?.aborted) {
+      cancel();
+    }
+    outer?.addEventListener('abort', cancel, { once: true });
+
+    const results = new Map<string, unknown>();
+    let failure: { step: string; error: unknown } | undefined;
+    // Whether each step finished, once it has run or been skipped
+    const finished = new Map<string, Promise<boolean>>();
+    for (const step of steps) {
+      const unknown = step.uses.find(use => !finished.has(use));
+      if (unknown !== undefined) {
+        throw new Error(
+          `Step ${step.name} of pipeline ${name} uses ${unknown}, which is not an earlier step`
+        );
+      }
+      const ready = step.uses.map(use => finished.get(use)!);
+      finished.set(
+        step.name,
+        Promise.all(ready).then(async done => {
+          if (!done.every(Boolean) || controller.signal.aborted) {
+            return false;
+          }
+          const inputs = Object.fromEntries(
+            step.uses.map(use => [use, results.get(use)])
+          );
+          try {
+            const result = await runWithSignal(controller.signal, () =>
+              traced(
+                'mcps.pipeline_step',
+                { 'mcps.pipeline': name, 'mcps.step': step.name },
+                () => step.run(inputs)
+              )
+            );
+            results.set(step.name, result);
+            return true;
+          } catch (error) {
+            if (!failure) {
+              failure = { step: step.name, error };
+              controller.abort(error);
+            }
+            return false;
+          }
+        })
+      );
+    }
+
+    try {
+      await Promise.all(finished.values());
+    } finally {
+      outer?.removeEventListener('abort', cancel);
+    }
+
+    if (failure) {
+      throw new PipelineError(name, failure.step, failure.error);
+    }
+    if (controller.signal.aborted) {
+      throw controller.signal.reason;
+    }
+    return output(Object.fromEntries(results));
+  });
+}
+
+/**
+ * Run the branches of a fan-out step concurrently, collecting their
+ * results by name; the first branch to fail cancels the others
+ */
+export async function fanOut(
+  branches: Record<string, () => Promise<unknown>>
+): Promise<Record<string, unknown>> {
+  const names = Object.keys(branches);
+  const results = await runParallel(names.map(branch => branches[branch]));
+  return Object.fromEntries(names.map((branch, i) => [branch, results[i]]));
+}
+
+/**
+ * Run a workflow for each item of a list, at most `limit` at once,
+ * collecting the results in item order. Unlike a parallel map, the step
+ * fails with the first item that fails
+ */
+export async function fanOutEach<T, R>(
+  items: T[],
+  body: (item: T) => Promise<R>,
+  limit?: number
+): Promise<R[]> {
+  if (!Array.isArray(items)) {
+    throw new TypeError(
+      `each needs a list of items, got ${items === null ? 'null' : typeof items}`
+    );
+  }
+  return runParallel(items.map(item => () => body(item)), limit);
+}
@@ -0,0 +1,98 @@
+// Platform differences in spawning and stopping MCP server processes
+import { spawn } from 'child_process';
+import { win32 } from 'path';
+import { pathToFileURL } from 'url';
+
+/**
+ * Package manager launchers that are installed as .cmd scripts on
+ * Windows and cannot be spawned directly without a shell
+ */
+const WINDOWS_SCRIPT_LAUNCHERS = new Set([
+  'npx',
+  'npm',
+  'pnpm',
+  'pnpx',
+  'yarn',
+  'corepack',
+  'bunx',
+]);
+
+export interface SpawnCommand {
+  command: string;
+  args: string[];
+}
+
+/**
+ * Adapt a server command to the platform it runs on
+ * On Windows, npx and other script launchers are run through cmd.exe,
+ * the same "cmd /c npx ..." form MCP clients document for Windows
+ */
+export function resolveSpawnCommand(
+  command: string,
+  args: string[] = [],
+  platform: NodeJS.Platform = process.platform,
+  env: NodeJS.ProcessEnv = process.env
+): SpawnCommand {
+  if (platform !== 'win32') {
+    return { command, args };
+  }
+
+  const base = win32.basename(command).toLowerCase();
+  const extension = win32.extname(base);
+  const isScript =
+    extension === '.cmd' ||
+    extension === '.bat' ||
+    (extension === '' && WINDOWS_SCRIPT_LAUNCHERS.has(base));
+  if (!isScript) {
+    return { command, args };
+  }
+
+  return {
+    command: env.ComSpec ?? 'cmd.exe',
+    args: ['/d', '/c', command, ...args],
+  };
+}
+
+/**
+ * Stop a process and every process it started
+ * Windows does not stop child processes with their parent, so servers
+ * started through npx or cmd.exe would otherwise keep running; taskkill
+ * walks the process tree. Elsewhere closing the transport is enough, so
+ * this does nothing.
+ */
+export function killProcessTree(
+  pid: number,
+  platform: NodeJS.Platform = process.platform
+): Promise<void> {
+  if (platform !== 'win32') {
+    return Promise.resolve();
+  }
+
+  return new Promise(resolve => {
+    const taskkill = spawn('taskkill', ['/pid', String(pid), '/T', '/F'], {
+      stdio: 'ignore',
+      windowsHide: true,
+    });
+    // The process may already have exited; there is nothing to report
+    taskkill.on('error', () => resolve());
+    taskkill.on('exit', () => resolve());
+  });
+}
+
+/**
+ * File URI for a directory shared as an MCP root
+ * Windows paths become file:///C:/dir (or file://server/share for UNC
+ * paths) whatever platform the conversion runs on
+ */
+export function rootUri(
+  path: string,
+  platform: NodeJS.Platform = process.platform
+): string {
+  if (platform !== 'win32') {
+    return pathToFileURL(path).href;
+  }
+
+  const normalized = win32.normalize(path).replace(/\\/g, '/');
+  const encoded = encodeURI(normalized).replace(/[?#]/g, encodeURIComponent);
+  return normalized.startsWith('//') ? `file:${encoded}` : `file:///${encoded}`;
+}
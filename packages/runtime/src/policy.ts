@@ -0,0 +1,304 @@
+// Sandbox policies: what a host lets a script do
+//
+// A host running scripts it does not trust, such as third-party
+// automations, gives them a policy limiting the MCP servers they may start
+// or connect to, the programs those servers may run, the tools they may
+// call, how many server processes they may have and how long they may run.
+// Servers declared with a url reach the network, so they can be refused
+// altogether or limited to some hosts. A script breaking the policy fails
+// with a PolicyViolationError naming the rule before the server is started
+// or the call is sent, and hosts can check what a script declares against
+// the policy before running it at all.
+import { matchesToolPattern, type ToolAdmission } from './profile.js';
+
+/**
+ * Limits on what a script may do; anything a field is omitted for is
+ * allowed
+ */
+export interface SandboxPolicy {
+  /** Servers that may be started or connected to, by declared name */
+  allowServers?: string[];
+  /**
+   * Programs stdio servers may run, as patterns in which * matches any
+   * text, matched against the command as declared
+   */
+  allowCommands?: string[];
+  /** Tools that may be called, as "server.tool" patterns */
+  allowTools?: string[];
+  /** Whether servers declared with a url may be connected to */
+  allowNetwork?: boolean;
+  /** Hosts servers declared with a url may be on, as patterns */
+  allowHosts?: string[];
+  /** Most stdio server processes one run may start */
+  maxProcesses?: number;
+  /** Longest a run may take, in milliseconds */
+  maxRunTime?: number;
+}
+
+/** Names of the rules of a policy, as reported with violations */
+export type PolicyRule =
+  | 'allowServers'
+  | 'allowCommands'
+  | 'allowTools'
+  | 'allowNetwork'
+  | 'allowHosts'
+  | 'maxProcesses'
+  | 'maxRunTime';
+
+/**
+ * Something a script did or declared that its policy does not allow
+ */
+export interface PolicyViolation {
+  rule: PolicyRule;
+  /** The server, command, tool or host that broke the rule */
+  subject: string;
+  message: string;
+}
+
+/**
+ * Error thrown instead of doing what the run's policy does not allow
+ */
+export class PolicyViolationError extends Error {
+  constructor(public readonly violation: PolicyViolation) {
+    super(violation.message);
+    this.name = 'PolicyViolationError';
+  }
+
+  get rule(): PolicyRule {
+    return this.violation.rule;
+  }
+
+  toJSON(): PolicyViolation & { error: string } {
+    return { error: this.name, ...this.violation };
+  }
+}
+
+/**
+ * A server as far as policies are concerned: the command of stdio servers
+ * or the url of remote ones
+ */
+export interface PolicyServer {
+  name?: string;
+  command?: string;
+  url?: string;
+}
+
+const matchesAny = (subject: string, patterns: string[]) =>
+  patterns.some(pattern => matchesToolPattern(subject, pattern));
+
+/**
+ * Why a policy does not allow a server to be started or connected to, or
+ * undefined when it does
+ */
+export function serverViolation(
+  policy: SandboxPolicy,
+  server: PolicyServer
+): PolicyViolation | undefined {
+  const label = server.name ?? server.command ?? server.url ?? 'server';
+  if (
+    policy.allowServers &&
+    !(server.name && policy.allowServers.includes(server.name))
+  ) {
+    return {
+      rule: 'allowServers',
+      subject: label,
+      message: `Policy does not allow server ${label}`,
+    };
+  }
+  if (
+    server.command !== undefined &&
+    policy.allowCommands &&
+    !matchesAny(server.command, policy.allowCommands)
+  ) {
+    return {
+      rule: 'allowCommands',
+      subject: server.command,
+      message: `Policy does not allow server ${label} to run ${server.command}`,
+    };
+  }
+  if (server.url !== undefined) {
+    if (policy.allowNetwork === false) {
+      return {
+        rule: 'allowNetwork',
+        subject: server.url,
+        message: `Policy does not allow network access, which server ${label} needs`,
+      };
+    }
+    if (policy.allowHosts) {
+      let host: string;
+      try {
+        host = new URL(server.url).hostname;
+      } catch {
+        host = server.url;
+      }
+      if (!matchesAny(host, policy.allowHosts)) {
+        return {
+          rule: 'allowHosts',
+          subject: host,
+          message: `Policy does not allow server ${label} on host ${host}`,
+        };
+      }
+    }
+  }
+  return undefined;
+}
+
+/**
+ * Why a policy does not allow a tool to be called, or undefined when it
+ * does
+ */
+export function toolViolation(
+  policy: SandboxPolicy,
+  tool: string
+): PolicyViolation | undefined {
+  if (policy.allowTools && !matchesAny(tool, policy.allowTools)) {
+    return {
+      rule: 'allowTools',
+      subject: tool,
+      message: `Policy does not allow calling ${tool}`,
+    };
+  }
+  return undefined;
+}
+
+/**
+ * Everything a script declares that its policy does not allow, for
+ * refusing to run it at all. Servers and tools only known when the script
+ * runs, such as commands built from variables or the tools an agent picks,
+ * are left to be checked then
+ */
+export function policyViolations(
+  policy: SandboxPolicy,
+  declared: {
+    servers: PolicyServer[];
+    tools: Array<{ server: string; tool: string }>;
+  }
+): PolicyViolation[] {
+  const violations: PolicyViolation[] = [];
+  for (const server of declared.servers) {
+    // Commands and urls are written with ${NAME} for environment
+    // variables and <dynamic> for computed values
+    const known = [server.command, server.url].every(
+      value =>
+        value === undefined ||
+        !(value.includes('${') || value.includes('<dynamic>'))
+    );
+    const violation = known
+      ? serverViolation(policy, server)
+      : serverViolation(policy, { name: server.name });
+    if (violation) {
+      violations.push(violation);
+    }
+  }
+  const processes = declared.servers.filter(
+    server => server.command !== undefined
+  ).length;
+  if (policy.maxProcesses !== undefined && processes > policy.maxProcesses) {
+    violations.push(processesViolation(policy.maxProcesses));
+  }
+  for (const { server, tool } of declared.tools) {
+    const violation =
+      tool !== '*' && toolViolation(policy, `${server}.${tool}`);
+    if (violation) {
+      violations.push(violation);
+    }
+  }
+  return violations;
+}
+
+function processesViolation(maxProcesses: number): PolicyViolation {
+  return {
+    rule: 'maxProcesses',
+    subject: String(maxProcesses),
+    message: `Policy allows at most ${maxProcesses} server processes per run`,
+  };
+}
+
+/**
+ * Error failing a run that took longer than its policy allows
+ */
+export function runTimeViolation(maxRunTime: number): PolicyViolationError {
+  return new PolicyViolationError({
+    rule: 'maxRunTime',
+    subject: String(maxRunTime),
+    message: `Policy allows runs of at most ${maxRunTime}ms`,
+  });
+}
+
+/**
+ * Admits the servers and tool calls of one run under a sandbox policy,
+ * passing admitted calls on to the run's other gate, if any
+ */
+export class SandboxGate implements ToolAdmission {
+  private processes = 0;
+
+  constructor(
+    private readonly policy: SandboxPolicy,
+    private readonly next?: ToolAdmission
+  ) {}
+
+  /**
+   * Throw a PolicyViolationError unless a server may be started or
+   * connected to; stdio servers count towards the process limit
+   */
+  admitServer(server: PolicyServer): void {
+    const violation = serverViolation(this.policy, server);
+    if (violation) {
+      throw new PolicyViolationError(violation);
+    }
+    if (server.command !== undefined) {
+      const { maxProcesses } = this.policy;
+      if (maxProcesses !== undefined && this.processes >= maxProcesses) {
+        throw new PolicyViolationError(processesViolation(maxProcesses));
+      }
+      this.processes++;
+    }
+  }
+
+  async admit(tool: string, input: unknown): Promise<void> {
+    const violation = toolViolation(this.policy, tool);
+    if (violation) {
+      throw new PolicyViolationError(violation);
+    }
+    await this.next?.admit(tool, input);
+  }
+}
+
+/**
+ * Check the fields of a policy read from a file
+ */
+export function checkSandboxPolicy(policy: unknown): SandboxPolicy {
+  if (typeof policy !== 'object' || policy === null || Array.isArray(policy)) {
+    throw new TypeError('Sandbox policy must be an object');
+  }
+  const fields = policy as Record<string, unknown>;
+  for (const [field, value] of Object.entries(fields)) {
+    switch (field) {
+      case 'allowServers':
+      case 'allowCommands':
+      case 'allowTools':
+      case 'allowHosts':
+        if (
+          !Array.isArray(value) ||
+          !value.every(item => typeof item === 'string')
+        ) {
+          throw new TypeError(`"${field}" must be a list of strings`);
+        }
+        break;
+      case 'allowNetwork':
+        if (typeof value !== 'boolean') {
+          throw new TypeError(`"${field}" must be true or false`);
+        }
+        break;
+      case 'maxProcesses':
+      case 'maxRunTime':
+        if (!(Number.isInteger(value) && (value as number) >= 0)) {
+          throw new TypeError(`"${field}" must be a non-negative integer`);
+        }
+        break;
+      default:
+        throw new TypeError(`Unknown sandbox policy field "${field}"`);
+    }
+  }
+  return fields as SandboxPolicy;
+}
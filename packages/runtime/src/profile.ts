@@ -0,0 +1,202 @@
+// Execution profiles: what one run may do with MCP tools
+//
+// A project defines roles, such as a "reader" that may only look things up
+// or an "operator" whose changes need approval, and each run is started in
+// one of them. The role's profile is checked before every MCP tool call,
+// whether the script or one of its agents makes it. A role can be kept to
+// some callers, for runs started for a caller by mcpsd or a served script.
+import type { CallerIdentity } from './identity.js';
+import { deepEqual } from './testing.js';
+
+export interface ExecutionProfile {
+  /** Name of the role, for error messages */
+  role: string;
+  /**
+   * Tools that may be called, as "server.tool" patterns in which * matches
+   * any text (all tools when omitted)
+   */
+  allowTools?: string[];
+  /** Tools each call of which must be approved first, as patterns */
+  requireApproval?: string[];
+  /** Calls of such tools approved in advance, which are not asked about */
+  approvedCalls?: ApprovedCall[];
+  /** Most MCP tool calls one run may make */
+  maxToolCalls?: number;
+  /**
+   * Subjects of the callers runs may be started in the role for, as
+   * patterns (any caller when omitted); runs for no caller are not checked
+   */
+  callers?: string[];
+}
+
+/**
+ * Calls of a tool approved for good: those of tools matching `tool` whose
+ * arguments match `input`. A string matches string arguments, with *
+ * matching any text, other values must equal the argument, and arguments
+ * left out may have any value
+ */
+export interface ApprovedCall {
+  tool: string;
+  input?: Record<string, unknown>;
+}
+
+/**
+ * Asks whether a tool call may be made, resolving with the answer
+ */
+export type ApprovalHandler = (
+  tool: string,
+  input: unknown
+) => Promise<boolean>;
+
+/**
+ * Decides whether a tool call may be sent, throwing when it may not
+ */
+export interface ToolAdmission {
+  admit(tool: string, input: unknown): Promise<void>;
+}
+
+/**
+ * Error thrown for a tool call the run's profile does not allow
+ */
+export class ToolPermissionError extends Error {
+  constructor(
+    public readonly tool: string,
+    public readonly role: string,
+    message: string
+  ) {
+    super(message);
+    this.name = 'ToolPermissionError';
+  }
+}
+
+/**
+ * Error thrown for a run started for a caller its role is not allowed
+ */
+export class CallerPermissionError extends Error {
+  constructor(
+    public readonly subject: string,
+    public readonly role: string
+  ) {
+    super(`Caller ${subject} may not run in role ${role}`);
+    this.name = 'CallerPermissionError';
+  }
+}
+
+/**
+ * Check that a run for a caller may be started in a role, throwing a
+ * CallerPermissionError when it may not
+ */
+export function admitCaller(
+  profile: ExecutionProfile,
+  caller: CallerIdentity | undefined
+): void {
+  const { role, callers } = profile;
+  if (
+    caller &&
+    callers &&
+    !callers.some(pattern => matchesPattern(caller.subject, pattern))
+  ) {
+    throw new CallerPermissionError(caller.subject, role);
+  }
+}
+
+/**
+ * Whether a tool name such as "fs.readFile" matches a pattern such as
+ * "fs.*" or "*.read*"
+ */
+export function matchesToolPattern(tool: string, pattern: string): boolean {
+  return matchesPattern(tool, pattern);
+}
+
+/**
+ * Whether a text matches a pattern in which * matches any text, including
+ * line breaks
+ */
+function matchesPattern(text: string, pattern: string): boolean {
+  const source = pattern
+    .split('*')
+    .map(part => part.replace(/[.+?^${}()|[\]\\]/g, '\\$&'))
+    .join('.*');
+  return new RegExp(`^${source}$`, 's').test(text);
+}
+
+/**
+ * Whether a tool call is one an approved call rule covers
+ */
+export function matchesApprovedCall(
+  rule: ApprovedCall,
+  tool: string,
+  input: unknown
+): boolean {
+  if (!matchesToolPattern(tool, rule.tool)) {
+    return false;
+  }
+  const args = (
+    typeof input === 'object' && input !== null ? input : {}
+  ) as Record<string, unknown>;
+  return Object.entries(rule.input ?? {}).every(([name, pattern]) =>
+    typeof pattern === 'string'
+      ? typeof args[name] === 'string' &&
+        matchesPattern(args[name] as string, pattern)
+      : deepEqual(args[name], pattern)
+  );
+}
+
+/**
+ * Admits the tool calls of one run under an execution profile
+ * Calls waiting for approval hold a place in the call budget, which is
+ * given back when they are refused.
+ */
+export class ToolCallGate implements ToolAdmission {
+  private calls = 0;
+
+  constructor(
+    private readonly profile: ExecutionProfile,
+    private readonly approve?: ApprovalHandler
+  ) {}
+
+  /**
+   * Wait until a call may be sent, throwing a ToolPermissionError when it
+   * may not
+   */
+  async admit(tool: string, input: unknown): Promise<void> {
+    const { role, allowTools, requireApproval, approvedCalls, maxToolCalls } =
+      this.profile;
+    const matches = (patterns: string[]) =>
+      patterns.some(pattern => matchesToolPattern(tool, pattern));
+
+    if (allowTools && !matches(allowTools)) {
+      throw new ToolPermissionError(
+        tool,
+        role,
+        `Role ${role} may not call ${tool}`
+      );
+    }
+    if (maxToolCalls !== undefined && this.calls >= maxToolCalls) {
+      throw new ToolPermissionError(
+        tool,
+        role,
+        `Role ${role} may make at most ${maxToolCalls} tool calls per run`
+      );
+    }
+    this.calls++;
+
+    if (
+      requireApproval &&
+      matches(requireApproval) &&
+      !approvedCalls?.some(rule => matchesApprovedCall(rule, tool, input))
+    ) {
+      const approved = this.approve ? await this.approve(tool, input) : false;
+      if (!approved) {
+        this.calls--;
+        throw new ToolPermissionError(
+          tool,
+          role,
+          this.approve
+            ? `Call to ${tool} was not approved`
+            : `Role ${role} needs approval to call ${tool}, which this run cannot ask for`
+        );
+      }
+    }
+  }
+}
@@ -0,0 +1,64 @@
+// Prompts declared by scripts
+//
+// The text of a prompt may reference environment variables as ${NAME} and
+// the metadata of the project the script belongs to, such as its name or
+// version, as ${project.KEY}. The transpiler splits the text into parts,
+// which are resolved here when the script starts. Prompts are sent to the
+// model, so they never reference secrets.
+import type { InterpolationPart } from './secrets.js';
+
+/**
+ * Metadata of a project that prompts may reference, by key
+ */
+export type ProjectMetadata = Record<string, string>;
+
+/**
+ * Error thrown when a prompt references a value that is not defined
+ */
+export class PromptError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'PromptError';
+  }
+}
+
+/**
+ * Resolve the references of a prompt's text
+ */
+export function resolvePrompt(
+  name: string,
+  parts: InterpolationPart[],
+  project: ProjectMetadata = {},
+  environment: NodeJS.ProcessEnv = process.env
+): string {
+  return parts
+    .map(part => {
+      if (typeof part === 'string') {
+        return part;
+      }
+      if ('project' in part) {
+        const value = Object.hasOwn(project, part.project)
+          ? project[part.project]
+          : undefined;
+        if (value === undefined) {
+          throw new PromptError(
+            `Prompt ${name} references \${project.${part.project}}, which the project does not define`
+          );
+        }
+        return value;
+      }
+      if ('secret' in part) {
+        throw new PromptError(
+          `Prompt ${name} cannot reference \${secret:${part.secret}}`
+        );
+      }
+      const value = environment[part.env];
+      if (value === undefined) {
+        throw new PromptError(
+          `Prompt ${name} references \${${part.env}}, which is not set`
+        );
+      }
+      return value;
+    })
+    .join('');
+}
@@ -0,0 +1,195 @@
+// Model providers: turn `model` declarations into LLMs agents can use
+import type { BaseEmbedding } from '@llamaindex/core/embeddings';
+import type { BaseLLM } from '@llamaindex/core/llms';
+import { OpenAI, OpenAIEmbedding } from '@llamaindex/openai';
+import { Anthropic } from '@llamaindex/anthropic';
+import { Gemini, GeminiEmbedding } from '@llamaindex/google';
+
+/** Endpoint of the "local" provider when no baseURL is given (Ollama) */
+export const DEFAULT_LOCAL_MODEL_URL = 'http://localhost:11434/v1';
+
+/**
+ * Configuration of a `model` declaration
+ * Providers registered by hosts may accept options of their own
+ */
+export interface ModelConfig {
+  provider: string;
+  model?: string;
+  apiKey?: string;
+  temperature?: number;
+  maxTokens?: number;
+  baseURL?: string;
+  /** Tokens the model can take, for agents routing across models */
+  contextWindow?: number;
+  /** Price of the model, compared with the maxCost of agents' routing */
+  cost?: number;
+  [option: string]: unknown;
+}
+
+/**
+ * Creates the LLM for a model configuration
+ */
+export type ModelProvider = (config: ModelConfig) => BaseLLM;
+
+/**
+ * Turns texts into vectors, one per text, for embed() and the vector index
+ */
+export interface TextEmbedder {
+  embed(texts: string[]): Promise<number[][]>;
+}
+
+/**
+ * Creates the embedder for a model configuration naming an embedding model
+ */
+export type EmbeddingProvider = (config: ModelConfig) => TextEmbedder;
+
+function openAICompatible(config: ModelConfig, baseURL?: string): BaseLLM {
+  return new OpenAI({
+    apiKey: config.apiKey,
+    model: config.model,
+    temperature: config.temperature,
+    maxTokens: config.maxTokens,
+    additionalSessionOptions: baseURL ? { baseURL } : undefined,
+  });
+}
+
+const BUILTIN_PROVIDERS: Record<string, ModelProvider> = {
+  openai: config => openAICompatible(config, config.baseURL),
+  anthropic: config =>
+    new Anthropic({
+      apiKey: config.apiKey,
+      // Model names are checked by the provider's API rather than here
+      model: config.model as Anthropic['model'],
+      temperature: config.temperature,
+      maxTokens: config.maxTokens,
+    }),
+  gemini: config =>
+    new Gemini({
+      apiKey: config.apiKey,
+      model: config.model as Gemini['model'],
+      temperature: config.temperature,
+      maxOutputTokens: config.maxTokens,
+    }),
+  // Any server with an OpenAI-compatible API (Ollama, llama.cpp, vLLM...);
+  // such servers usually ignore the API key, but the client requires one
+  local: config =>
+    openAICompatible(
+      { ...config, apiKey: config.apiKey ?? 'local' },
+      config.baseURL ?? DEFAULT_LOCAL_MODEL_URL
+    ),
+};
+
+function embedderOf(embedding: BaseEmbedding): TextEmbedder {
+  return { embed: texts => embedding.getTextEmbeddingsBatch(texts) };
+}
+
+function openAICompatibleEmbedding(
+  config: ModelConfig,
+  baseURL?: string
+): TextEmbedder {
+  return embedderOf(
+    new OpenAIEmbedding({
+      apiKey: config.apiKey,
+      model: config.model,
+      dimensions: config.dimensions as number | undefined,
+      additionalSessionOptions: baseURL ? { baseURL } : undefined,
+    })
+  );
+}
+
+const BUILTIN_EMBEDDING_PROVIDERS: Record<string, EmbeddingProvider> = {
+  openai: config => openAICompatibleEmbedding(config, config.baseURL),
+  gemini: config =>
+    embedderOf(
+      new GeminiEmbedding({
+        apiKey: config.apiKey,
+        model: config.model as GeminiEmbedding['model'],
+      })
+    ),
+  local: config =>
+    openAICompatibleEmbedding(
+      { ...config, apiKey: config.apiKey ?? 'local' },
+      config.baseURL ?? DEFAULT_LOCAL_MODEL_URL
+    ),
+};
+
+/**
+ * Model providers by name
+ * Hosts can register their own providers, or replace the built-in ones,
+ * before running scripts
+ */
+export class ModelProviderRegistry {
+  private readonly providers = new Map<string, ModelProvider>();
+  private readonly embeddingProviders = new Map<string, EmbeddingProvider>();
+
+  constructor(
+    providers: Record<string, ModelProvider> = BUILTIN_PROVIDERS,
+    embeddingProviders: Record<
+      string,
+      EmbeddingProvider
+    > = BUILTIN_EMBEDDING_PROVIDERS
+  ) {
+    for (const [name, provider] of Object.entries(providers)) {
+      this.register(name, provider);
+    }
+    for (const [name, provider] of Object.entries(embeddingProviders)) {
+      this.registerEmbeddings(name, provider);
+    }
+  }
+
+  /**
+   * Add a provider; names are case-insensitive
+   */
+  register(name: string, provider: ModelProvider): void {
+    this.providers.set(name.toLowerCase(), provider);
+  }
+
+  /**
+   * Add the embedding models of a provider; names are case-insensitive
+   */
+  registerEmbeddings(name: string, provider: EmbeddingProvider): void {
+    this.embeddingProviders.set(name.toLowerCase(), provider);
+  }
+
+  has(name: string): boolean {
+    return this.providers.has(name.toLowerCase());
+  }
+
+  names(): string[] {
+    return [...this.providers.keys()];
+  }
+
+  /**
+   * Create the LLM for a model configuration
+   */
+  create(config: ModelConfig): BaseLLM {
+    const provider = this.providers.get(String(config.provider).toLowerCase());
+    if (!provider) {
+      throw new Error(
+        `Unknown model provider "${config.provider}" ` +
+          `(available: ${this.names().join(', ')})`
+      );
+    }
+    return provider(config);
+  }
+
+  /**
+   * Create the embedder for a model configuration
+   */
+  createEmbedder(config: ModelConfig): TextEmbedder {
+    const name = String(config.provider).toLowerCase();
+    const provider = this.embeddingProviders.get(name);
+    if (!provider) {
+      throw new Error(
+        `Model provider "${config.provider}" has no embedding models ` +
+          `(available: ${[...this.embeddingProviders.keys()].join(', ')})`
+      );
+    }
+    return provider(config);
+  }
+}
+
+/**
+ * Registry used by scripts unless the host passes its own
+ */
+export const modelProviders = new ModelProviderRegistry();
@@ -0,0 +1,217 @@
+// Questions scripts ask the operator
+//
+// Semi-automated workflows ask the person running them for what they are
+// missing with `prompt.text("Ticket number?")`, `prompt.select(["staging",
+// "production"], "Deploy to?")` and `prompt.confirm("Deploy now?")`. The
+// host answers them: in a terminal the CLI asks through the same input as
+// input(), and runs nobody watches, such as triggered ones, send them to
+// the project's approval webhook. Answers are checked here, so a host can
+// never hand a script an option it did not offer.
+
+/**
+ * A question of a script, as handed to the host
+ */
+export type OperatorQuestion =
+  | { kind: 'text'; message: string; default?: string }
+  | { kind: 'select'; message: string; options: string[] }
+  | { kind: 'confirm'; message: string; default: boolean };
+
+/**
+ * Answers a script's questions: with a string for text questions, the
+ * index of the chosen option for select questions and a boolean for
+ * confirm questions
+ */
+export type QuestionHandler = (question: OperatorQuestion) => Promise<unknown>;
+
+/**
+ * Label of an option, as shown to the operator
+ */
+function describeOption(option: unknown): string {
+  if (typeof option === 'string') {
+    return option;
+  }
+  if (option !== null && typeof option === 'object') {
+    const { label } = option as Record<string, unknown>;
+    return typeof label === 'string' ? label : JSON.stringify(option);
+  }
+  return String(option);
+}
+
+function typeName(value: unknown): string {
+  return value === null
+    ? 'null'
+    : Array.isArray(value)
+      ? 'array'
+      : typeof value;
+}
+
+/**
+ * Ask questions through a handler reading one line of input, as input()
+ * does, asking again until the answer is one the question accepts
+ */
+export function askThroughInput(
+  readLine: (message: string) => Promise<string>
+): QuestionHandler {
+  return async question => {
+    switch (question.kind) {
+      case 'text': {
+        const suffix =
+          question.default === undefined ? '' : ` [${question.default}]`;
+        const answer = await readLine(`${question.message}${suffix}`);
+        return answer === '' && question.default !== undefined
+          ? question.default
+          : answer;
+      }
+      case 'select': {
+        const choices = question.options
+          .map((option, index) => `  ${index + 1}) ${option}`)
+          .concat(`Choose 1-${question.options.length}:`)
+          .join('\n');
+        let message = `${question.message}\n${choices}`;
+        for (;;) {
+          const answer = (await readLine(message)).trim();
+          const byLabel = question.options.indexOf(answer);
+          if (byLabel >= 0) {
+            return byLabel;
+          }
+          const number = Number(answer);
+          if (
+            Number.isInteger(number) &&
+            number >= 1 &&
+            number <= question.options.length
+          ) {
+            return number - 1;
+          }
+          message = `Not an option: ${answer}\n${choices}`;
+        }
+      }
+      case 'confirm': {
+        const hint = question.default ? '(Y/n)' : '(y/N)';
+        for (;;) {
+          const answer = (await readLine(`${question.message} ${hint}`))
+            .trim()
+            .toLowerCase();
+          if (answer === '') {
+            return question.default;
+          }
+          if (answer === 'y' || answer === 'yes') {
+            return true;
+          }
+          if (answer === 'n' || answer === 'no') {
+            return false;
+          }
+        }
+      }
+    }
+  };
+}
+
+/**
+ * Create the `prompt` builtin, asking its questions through the handler
+ */
+export function createPrompt(ask?: QuestionHandler) {
+  const question = async (asked: OperatorQuestion): Promise<unknown> => {
+    if (!ask) {
+      throw new Error(
+        `prompt.${asked.kind}: nobody can be asked in this run. Cannot ask: ${asked.message}`
+      );
+    }
+    return ask(asked);
+  };
+
+  return {
+    /**
+     * Ask for a line of text, answered with the default when left empty
+     */
+    async text(message: unknown, defaultValue?: unknown): Promise<string> {
+      if (typeof message !== 'string') {
+        throw new TypeError(
+          `prompt.text: question must be a string, got ${typeName(message)}`
+        );
+      }
+      if (defaultValue !== undefined && typeof defaultValue !== 'string') {
+        throw new TypeError(
+          `prompt.text: default must be a string, got ${typeName(defaultValue)}`
+        );
+      }
+      const answer = await question({
+        kind: 'text',
+        message,
+        default: defaultValue as string | undefined,
+      });
+      if (typeof answer !== 'string') {
+        throw new TypeError(
+          `prompt.text: expected a string answer, got ${typeName(answer)}`
+        );
+      }
+      return answer;
+    },
+
+    /**
+     * Ask for one of the options, which is returned as given; objects are
+     * shown by their label field
+     */
+    async select(
+      options: unknown,
+      message: unknown = 'Choose one'
+    ): Promise<unknown> {
+      if (!Array.isArray(options) || options.length === 0) {
+        throw new TypeError(
+          `prompt.select: options must be a non-empty array, got ${Array.isArray(options) ? 'an empty array' : typeName(options)}`
+        );
+      }
+      if (typeof message !== 'string') {
+        throw new TypeError(
+          `prompt.select: question must be a string, got ${typeName(message)}`
+        );
+      }
+      const answer = await question({
+        kind: 'select',
+        message,
+        options: options.map(describeOption),
+      });
+      if (
+        typeof answer !== 'number' ||
+        !Number.isInteger(answer) ||
+        answer < 0 ||
+        answer >= options.length
+      ) {
+        throw new RangeError(
+          `prompt.select: answer ${JSON.stringify(answer)} is not one of the ${options.length} options`
+        );
+      }
+      return options[answer];
+    },
+
+    /**
+     * Ask a yes or no question, answered with the default (no) when left
+     * empty
+     */
+    async confirm(
+      message: unknown = 'Continue?',
+      defaultValue: unknown = false
+    ): Promise<boolean> {
+      if (typeof message !== 'string') {
+        throw new TypeError(
+          `prompt.confirm: question must be a string, got ${typeName(message)}`
+        );
+      }
+      if (typeof defaultValue !== 'boolean') {
+        throw new TypeError(
+          `prompt.confirm: default must be a boolean, got ${typeName(defaultValue)}`
+        );
+      }
+      const answer = await question({
+        kind: 'confirm',
+        message,
+        default: defaultValue,
+      });
+      if (typeof answer !== 'boolean') {
+        throw new TypeError(
+          `prompt.confirm: expected a yes or no answer, got ${typeName(answer)}`
+        );
+      }
+      return answer;
+    },
+  };
+}
@@ -0,0 +1,270 @@
+// Redaction of sensitive data before it reaches logs and other records
+import { isSpilled, SpilledText } from './spill.js';
+
+/**
+ * Redaction rules declared by a project
+ */
+export interface RedactionRules {
+  /**
+   * Regular expressions whose matches are masked in any text, written as
+   * a pattern ("sk-[a-z0-9]+") or with flags ("/bearer \\S+/i")
+   */
+  patterns?: string[];
+  /**
+   * Object keys whose values are always masked, matched ignoring case,
+   * "-" and "_" (so "apiKey" also matches "API_KEY")
+   */
+  keys?: string[];
+  /**
+   * Tool arguments to mask, as "server.tool.argument" paths that may
+   * continue into nested values; "*" matches any single segment
+   */
+  toolArguments?: string[];
+  /**
+   * Environment variables whose values are masked wherever they appear
+   */
+  environment?: string[];
+  /** Text that replaces redacted data (default: "[REDACTED]") */
+  replacement?: string;
+}
+
+export const REDACTED = '[REDACTED]';
+
+// Secrets shorter than this are too likely to match ordinary text
+const MIN_SECRET_LENGTH = 4;
+
+function normalizeKey(key: string): string {
+  return key.toLowerCase().replace(/[-_]/g, '');
+}
+
+function escapeRegExp(text: string): string {
+  return text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+}
+
+/**
+ * Compile a pattern, accepting an optional "/source/flags" form
+ */
+function compilePattern(pattern: string): RegExp {
+  const literal = /^\/(.+)\/([a-z]*)$/s.exec(pattern);
+  const [source, flags] = literal ? [literal[1], literal[2]] : [pattern, ''];
+  try {
+    return new RegExp(source, flags.includes('g') ? flags : `${flags}g`);
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new Error(`Invalid redaction pattern "${pattern}": ${reason}`);
+  }
+}
+
+/**
+ * Applies a project's redaction rules to text and structured values
+ * One instance is shared by everything that records script activity, so
+ * the same data is masked everywhere
+ */
+export class Redactor {
+  private readonly patterns: RegExp[];
+  private readonly keys: Set<string>;
+  private readonly toolArguments: string[][];
+  private readonly replacement: string;
+  /** Values masked wherever they appear, longest first */
+  private secrets: string[] = [];
+  private secretPattern?: RegExp;
+
+  constructor(
+    rules: RedactionRules = {},
+    environment: NodeJS.ProcessEnv = process.env
+  ) {
+    this.replacement = rules.replacement ?? REDACTED;
+    this.patterns = (rules.patterns ?? []).map(compilePattern);
+    this.keys = new Set((rules.keys ?? []).map(normalizeKey));
+    this.toolArguments = (rules.toolArguments ?? []).map(path =>
+      path.split('.')
+    );
+
+    for (const name of rules.environment ?? []) {
+      const value = environment[name];
+      if (value) {
+        this.addSecret(value);
+      }
+    }
+  }
+
+  /**
+   * Mask a value wherever it appears from now on, such as a secret that
+   * was just resolved
+   */
+  addSecret(value: string): void {
+    if (value.length < MIN_SECRET_LENGTH || this.secrets.includes(value)) {
+      return;
+    }
+    // Mask the longest values first so overlapping secrets are fully hidden
+    this.secrets = [...this.secrets, value].sort((a, b) => b.length - a.length);
+    this.secretPattern = new RegExp(
+      this.secrets.map(escapeRegExp).join('|'),
+      'g'
+    );
+  }
+
+  /**
+   * Whether any rule is configured
+   */
+  get enabled(): boolean {
+    return (
+      this.patterns.length > 0 ||
+      this.secretPattern !== undefined ||
+      this.keys.size > 0 ||
+      this.toolArguments.length > 0
+    );
+  }
+
+  /**
+   * Mask every pattern match in a string
+   */
+  redactText(text: string): string {
+    let result = text;
+    for (const pattern of this.patterns) {
+      result = result.replace(pattern, this.replacement);
+    }
+    if (this.secretPattern) {
+      result = result.replace(this.secretPattern, this.replacement);
+    }
+    return result;
+  }
+
+  /**
+   * Return a copy of a value with secret keys and pattern matches masked
+   * The value itself is never modified
+   */
+  redact<T>(value: T): T {
+    if (!this.enabled) {
+      return value;
+    }
+    return this.redactValue(value, new WeakMap()) as T;
+  }
+
+  /**
+   * Redact the arguments of a tool call, including the argument paths
+   * configured for that tool
+   */
+  redactToolArguments(server: string, tool: string, args: unknown): unknown {
+    let result = this.redact(args);
+    for (const path of this.toolArguments) {
+      const [serverPattern, toolPattern, ...argumentPath] = path;
+      if (
+        matchesSegment(serverPattern, server) &&
+        matchesSegment(toolPattern, tool)
+      ) {
+        result = this.maskPath(result, argumentPath);
+      }
+    }
+    return result;
+  }
+
+  private redactValue(
+    value: unknown,
+    seen: WeakMap<object, unknown>
+  ): unknown {
+    if (typeof value === 'string') {
+      return this.redactText(value);
+    }
+    if (typeof value !== 'object' || value === null) {
+      return value;
+    }
+    if (seen.has(value)) {
+      return seen.get(value);
+    }
+
+    // Values created inside the VM come from another realm, so check the
+    // built-in tag rather than using instanceof
+    const tag = Object.prototype.toString.call(value);
+    if (tag === '[object Error]') {
+      const error = value as Error;
+      const copy = new Error(this.redactText(error.message));
+      copy.name = error.name;
+      copy.stack = error.stack && this.redactText(error.stack);
+      seen.set(value, copy);
+      return copy;
+    }
+
+    if (Array.isArray(value)) {
+      const copy: unknown[] = [];
+      seen.set(value, copy);
+      for (const item of value) {
+        copy.push(this.redactValue(item, seen));
+      }
+      return copy;
+    }
+
+    if (tag === '[object Map]') {
+      const copy = new Map();
+      seen.set(value, copy);
+      for (const [key, item] of value as Map<unknown, unknown>) {
+        const secret = typeof key === 'string' && this.isSecretKey(key);
+        copy.set(key, secret ? this.replacement : this.redactValue(item, seen));
+      }
+      return copy;
+    }
+
+    // Spilled results are shown by their start, which is masked too
+    if (isSpilled(value)) {
+      const { file, size, length, preview } = value;
+      return new SpilledText(file, size, length, this.redactText(preview));
+    }
+
+    // Only plain objects are copied; class instances (dates, sets,
+    // clients...) are left as they are
+    const prototype = Object.getPrototypeOf(value);
+    if (prototype !== null && Object.getPrototypeOf(prototype) !== null) {
+      return value;
+    }
+
+    const copy: Record<string, unknown> = {};
+    seen.set(value, copy);
+    for (const [key, item] of Object.entries(value)) {
+      copy[key] = this.isSecretKey(key)
+        ? this.replacement
+        : this.redactValue(item, seen);
+    }
+    return copy;
+  }
+
+  private isSecretKey(key: string): boolean {
+    return this.keys.has(normalizeKey(key));
+  }
+
+  /**
+   * Mask the values at a path, copying containers along the way
+   */
+  private maskPath(value: unknown, path: string[]): unknown {
+    if (path.length === 0) {
+      return value === undefined ? value : this.replacement;
+    }
+    if (typeof value !== 'object' || value === null) {
+      return value;
+    }
+
+    const [segment, ...rest] = path;
+    if (Array.isArray(value)) {
+      return value.map((item, index) =>
+        matchesSegment(segment, String(index))
+          ? this.maskPath(item, rest)
+          : item
+      );
+    }
+    const copy: Record<string, unknown> = { ...value };
+    for (const key of Object.keys(copy)) {
+      if (matchesSegment(segment, key)) {
+        copy[key] = this.maskPath(copy[key], rest);
+      }
+    }
+    return copy;
+  }
+}
+
+function matchesSegment(pattern: string, segment: string): boolean {
+  return pattern === '*' || pattern === segment;
+}
+
+/**
+ * A redactor that leaves everything unchanged
+ */
+export const noRedaction = new Redactor();
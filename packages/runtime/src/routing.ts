@@ -0,0 +1,237 @@
+// Routing the turns of agents across several models
+//
+// An agent can list several models, in order of preference. Each turn goes
+// to the first model the routing rules allow: a model costing more than the
+// agent's cost cap is never used, one whose context window the
+// conversation no longer fits in is passed over, and one that recently
+// failed with a provider error, such as an outage, overload or rate limit,
+// is tried only after the others. When a model fails that way, the turn
+// falls back to the next one, so workflows survive a provider being down.
+import type { BaseLLM, ChatMessage } from '@llamaindex/core/llms';
+import { isTransientError } from './call-policy.js';
+import type { ModelConfig } from './providers.js';
+
+/** Milliseconds a model that failed is tried after the others */
+export const DEFAULT_MODEL_COOLDOWN = 60_000;
+
+/**
+ * How an agent picks among its models
+ */
+export interface RoutingRules {
+  /**
+   * Highest `cost` a model may have to be used; models declaring no cost
+   * are not limited
+   */
+  maxCost?: number;
+  /** Milliseconds a model that failed is tried after the others */
+  cooldown?: number;
+}
+
+/**
+ * Error failing a turn that no model could take
+ */
+export class ModelRoutingError extends Error {
+  constructor(
+    message: string,
+    /** Errors of the models that were tried, by model name */
+    public readonly failures: Array<{ model: string; error: unknown }> = []
+  ) {
+    super(message);
+    this.name = 'ModelRoutingError';
+  }
+}
+
+/** Configurations of the models scripts declared, for their routing */
+const modelConfigs = new WeakMap<object, ModelConfig>();
+
+/** When models that failed become preferred again */
+const unavailableUntil = new WeakMap<object, number>();
+
+/**
+ * Remember the configuration a model was created from, whose `cost` and
+ * `contextWindow` routing rules look at
+ */
+export function rememberModelConfig(llm: BaseLLM, config: ModelConfig): void {
+  modelConfigs.set(llm, config);
+}
+
+/**
+ * The configuration a model was created from, unless it is not one a
+ * script declared
+ */
+export function modelConfigOf(llm: unknown): ModelConfig | undefined {
+  return typeof llm === 'object' && llm !== null
+    ? modelConfigs.get(llm)
+    : undefined;
+}
+
+/**
+ * Tokens a model can take, as its declaration states; for a router, the
+ * smallest window of its models, since a turn may go to any of them
+ */
+export function contextWindowOf(llm: BaseLLM): number | undefined {
+  if (llm instanceof ModelRouter) {
+    return llm.contextWindow;
+  }
+  return modelConfigs.get(llm)?.contextWindow;
+}
+
+/** HTTP statuses of model providers that another provider may not have */
+const PROVIDER_OUTAGE_STATUSES = new Set([
+  408, 425, 429, 500, 502, 503, 504, 529,
+]);
+
+/**
+ * Whether a model call failed because of its provider rather than the
+ * request: network errors, timeouts, rate limits and overloaded or failing
+ * servers. Other errors would fail with any model, so they are not retried
+ * with the next one
+ */
+export function isProviderError(error: unknown): boolean {
+  const status = (error as { status?: unknown } | null)?.status;
+  if (typeof status === 'number') {
+    return PROVIDER_OUTAGE_STATUSES.has(status);
+  }
+  return isTransientError(error);
+}
+
+/**
+ * Rough number of tokens of a conversation, at four characters a token
+ */
+export function estimateTokens(messages: ChatMessage[]): number {
+  const characters = messages.reduce(
+    (total, { content }) =>
+      total +
+      (typeof content === 'string' ? content : JSON.stringify(content)).length,
+    0
+  );
+  return Math.ceil(characters / 4);
+}
+
+/**
+ * Check the fields of routing rules, whose values may only be known when
+ * the script runs
+ */
+export function checkRoutingRules(rules: unknown, label: string): void {
+  if (typeof rules !== 'object' || rules === null || Array.isArray(rules)) {
+    throw new TypeError(`${label}: routing must be an object`);
+  }
+  for (const [field, value] of Object.entries(rules)) {
+    if (field !== 'maxCost' && field !== 'cooldown') {
+      throw new TypeError(`${label}: unknown routing rule ${field}`);
+    }
+    if (!(typeof value === 'number' && value >= 0)) {
+      throw new TypeError(
+        `${label}: ${field} must be a non-negative number, got ${value}`
+      );
+    }
+  }
+}
+
+/**
+ * An LLM taking each turn with the first of several models the routing
+ * rules allow, falling back to the next on provider errors
+ * Agents only call exec, which is all a router implements
+ */
+export class ModelRouter {
+  private readonly models: Array<[string, BaseLLM]>;
+
+  constructor(
+    models: Record<string, BaseLLM>,
+    private readonly rules: RoutingRules = {},
+    private readonly label = 'Agent',
+    private readonly now: () => number = Date.now
+  ) {
+    checkRoutingRules(rules, label);
+    this.models = Object.entries(models);
+    if (this.models.length === 0) {
+      throw new TypeError(`${label}: needs at least one model`);
+    }
+  }
+
+  /** The smallest context window of the models, when any declares one */
+  get contextWindow(): number | undefined {
+    const windows = this.models
+      .map(([, llm]) => modelConfigs.get(llm)?.contextWindow)
+      .filter((window): window is number => window !== undefined);
+    return windows.length > 0 ? Math.min(...windows) : undefined;
+  }
+
+  /**
+   * The models that may take a turn of a conversation, in the order they
+   * are tried: the available ones first, then those that failed recently
+   */
+  candidates(messages: ChatMessage[]): Array<[string, BaseLLM]> {
+    const { maxCost } = this.rules;
+    const affordable = this.models.filter(([, llm]) => {
+      const cost = modelConfigs.get(llm)?.cost;
+      return maxCost === undefined || cost === undefined || cost <= maxCost;
+    });
+    if (affordable.length === 0) {
+      throw new ModelRoutingError(
+        `${this.label}: no model costs at most ${maxCost}`
+      );
+    }
+
+    const tokens = estimateTokens(messages);
+    const fitting = affordable.filter(([, llm]) => {
+      const window = modelConfigs.get(llm)?.contextWindow;
+      return window === undefined || tokens <= window;
+    });
+    if (fitting.length === 0) {
+      throw new ModelRoutingError(
+        `${this.label}: the conversation, about ${tokens} tokens, fits in no model's context window`
+      );
+    }
+
+    const now = this.now();
+    const available = fitting.filter(
+      ([, llm]) => (unavailableUntil.get(llm) ?? 0) <= now
+    );
+    return [
+      ...available,
+      ...fitting.filter(candidate => !available.includes(candidate)),
+    ];
+  }
+
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  async exec(params: any): Promise<any> {
+    const failures: Array<{ model: string; error: unknown }> = [];
+    for (const [name, llm] of this.candidates(params.messages)) {
+      try {
+        const result = await llm.exec(params);
+        unavailableUntil.delete(llm);
+        return result;
+      } catch (error) {
+        if (!isProviderError(error)) {
+          throw error;
+        }
+        unavailableUntil.set(
+          llm,
+          this.now() + (this.rules.cooldown ?? DEFAULT_MODEL_COOLDOWN)
+        );
+        failures.push({ model: name, error });
+      }
+    }
+    throw new ModelRoutingError(
+      `${this.label}: every model failed: ${failures
+        .map(
+          ({ model, error }) =>
+            `${model}: ${error instanceof Error ? error.message : String(error)}`
+        )
+        .join('; ')}`,
+      failures
+    );
+  }
+}
+
+/**
+ * The LLM of an agent listing several models, or given routing rules
+ */
+export function routeModels(
+  models: Record<string, BaseLLM>,
+  rules: RoutingRules = {},
+  label?: string
+): BaseLLM {
+  return new ModelRouter(models, rules, label) as unknown as BaseLLM;
+}
@@ -0,0 +1,171 @@
+// Structured log of a run: the statements it executes, its tool calls and
+// the steps of its agents
+//
+// A host following runs as they happen gives a run the tracer and debugger
+// of a RunLog, and compiles the script with debug hooks. Statements are
+// logged by the debugger's hook as they start, without ever pausing the
+// script, and tool calls and agent steps as their spans end, so the log
+// holds what a trace of the run would, one entry at a time.
+import { ScriptDebugger } from './debugger.js';
+import { Tracer, type SpanData } from './tracing.js';
+
+export type LogSeverity = 'debug' | 'info' | 'warn' | 'error';
+
+/** Severities from the least to the most severe */
+export const LOG_SEVERITIES: LogSeverity[] = ['debug', 'info', 'warn', 'error'];
+
+export type LogEntryKind =
+  | 'statement'
+  | 'tool_call'
+  | 'retry'
+  | 'agent_turn'
+  | 'agent';
+
+/**
+ * One entry of a run's log
+ */
+export interface LogEntry {
+  /** ISO timestamp */
+  time: string;
+  severity: LogSeverity;
+  kind: LogEntryKind;
+  message: string;
+  /** Line of a statement in the script */
+  line?: number;
+  /** Tool called, or for statements, the tool they are in */
+  tool?: string;
+  /** Server of a tool call */
+  server?: string;
+  /** Agent of a step */
+  agent?: string;
+  /** How long a call or step took, in milliseconds */
+  durationMs?: number;
+  /** Tokens an agent turn sent and received, estimated */
+  tokens?: number;
+}
+
+/** Longest quote of a statement's source */
+const MAX_STATEMENT_LENGTH = 120;
+
+/**
+ * Whether a severity is at least as severe as another
+ */
+export function atLeast(severity: LogSeverity, minimum: LogSeverity): boolean {
+  return LOG_SEVERITIES.indexOf(severity) >= LOG_SEVERITIES.indexOf(minimum);
+}
+
+export interface RunLogOptions {
+  /** Source of the script, to quote the statements it executes */
+  source?: string;
+}
+
+/**
+ * Turns what a run does into log entries, handed to `write` as they happen
+ */
+export class RunLog {
+  readonly tracer: Tracer;
+  readonly debugger: ScriptDebugger;
+  private readonly lines?: string[];
+
+  constructor(
+    private readonly write: (entry: LogEntry) => void,
+    options: RunLogOptions = {}
+  ) {
+    this.lines = options.source?.split(/\r?\n/);
+    // Spans are logged as they end; the tracer itself exports nothing
+    this.tracer = new Tracer(
+      { export: async () => {} },
+      { onSpan: span => this.logSpan(span) }
+    );
+    this.debugger = new ScriptDebugger({
+      onStatement: (line, _column, tool) => this.logStatement(line, tool),
+    });
+  }
+
+  private logStatement(line: number, tool?: string): void {
+    let text = this.lines?.[line - 1]?.trim();
+    if (text && text.length > MAX_STATEMENT_LENGTH) {
+      text = `${text.slice(0, MAX_STATEMENT_LENGTH - 1)}…`;
+    }
+    this.write({
+      time: new Date().toISOString(),
+      severity: 'debug',
+      kind: 'statement',
+      message: text || `Statement at line ${line}`,
+      line,
+      ...(tool && { tool }),
+    });
+  }
+
+  private logSpan(span: SpanData): void {
+    const { attributes, status } = span;
+    const durationMs = attributes['mcps.duration_ms'] as number;
+    const failed = status.code === 'error';
+    const entry = {
+      time: new Date(span.endTime).toISOString(),
+      severity: failed ? ('error' as const) : ('info' as const),
+      durationMs,
+    };
+
+    switch (span.name) {
+      case 'mcps.tool_call': {
+        const server = attributes['mcp.server'] as string | undefined;
+        const tool = attributes['mcp.tool'] as string;
+        const label = server ? `${server}.${tool}` : tool;
+        for (const event of span.events) {
+          if (event.name === 'retry') {
+            this.write({
+              time: new Date(event.time).toISOString(),
+              severity: 'warn',
+              kind: 'retry',
+              message: `Retrying ${label} (${event.attributes['mcps.retry']}) after: ${event.attributes['exception.message']}`,
+              tool,
+              ...(server && { server }),
+            });
+          }
+        }
+        this.write({
+          ...entry,
+          kind: 'tool_call',
+          message: failed
+            ? `${label} failed: ${status.message}`
+            : `Called ${label}`,
+          tool,
+          ...(server && { server }),
+        });
+        break;
+      }
+      case 'mcps.agent_turn': {
+        const agent = attributes['mcps.agent'] as string;
+        const turn = attributes['mcps.turn'];
+        const calls = attributes['mcps.tool_calls'] as number | undefined;
+        const tokens = attributes['mcps.tokens'] as number | undefined;
+        this.write({
+          ...entry,
+          kind: 'agent_turn',
+          message: failed
+            ? `Agent ${agent} turn ${turn} failed: ${status.message}`
+            : calls
+              ? `Agent ${agent} turn ${turn} called ${calls} ${calls === 1 ? 'tool' : 'tools'}`
+              : `Agent ${agent} turn ${turn} answered`,
+          agent,
+          ...(tokens !== undefined && { tokens }),
+        });
+        break;
+      }
+      case 'mcps.agent': {
+        const agent = attributes['mcps.agent'] as string;
+        const turns = attributes['mcps.turns'] as number;
+        this.write({
+          ...entry,
+          kind: 'agent',
+          message: failed
+            ? `Agent ${agent} failed: ${status.message}`
+            : `Agent ${agent} finished after ${turns} ${turns === 1 ? 'turn' : 'turns'}`,
+          agent,
+        });
+        break;
+      }
+    }
+  }
+}
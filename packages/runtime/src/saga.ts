@@ -0,0 +1,132 @@
+// Compensation of the steps of failed workflows
+//
+// Automations spanning several systems, such as creating a ticket, posting
+// a message and updating a database, cannot roll back like a transaction
+// when a later step fails. A statement can instead carry a `compensate`
+// block undoing it: once the statement has run, the block is registered
+// with the saga of the tool or script running it, and when that tool or
+// script fails, the blocks of its completed steps run in reverse order
+// before the error goes on. A tool that finishes hands its steps on to its
+// caller, so a later failure there undoes them too.
+import { AsyncLocalStorage } from 'async_hooks';
+import { types } from 'util';
+
+/**
+ * A completed step and the code undoing it
+ */
+export interface CompensationStep {
+  /** Name of the tool the step called, for messages */
+  label: string;
+  undo: () => Promise<unknown>;
+}
+
+/**
+ * Message of an error, which may come from the realm of a script's context
+ */
+function messageOf(error: unknown): string {
+  return types.isNativeError(error) ? error.message : String(error);
+}
+
+/**
+ * Error thrown when some compensations of a failed workflow failed too;
+ * its cause is the workflow's error
+ */
+export class CompensationError extends Error {
+  constructor(
+    cause: unknown,
+    /** Errors of the compensations that failed, by step */
+    public readonly failures: Array<{ label: string; error: unknown }>
+  ) {
+    super(
+      `${messageOf(cause)}; compensating failed for ${failures
+        .map(({ label, error }) => `${label}: ${messageOf(error)}`)
+        .join('; ')}`,
+      { cause }
+    );
+    this.name = 'CompensationError';
+  }
+}
+
+/**
+ * The completed steps of a running tool or script
+ */
+export class Saga {
+  private steps: CompensationStep[] = [];
+
+  get size(): number {
+    return this.steps.length;
+  }
+
+  add(step: CompensationStep): void {
+    this.steps.push(step);
+  }
+
+  /** Take on the steps of a tool that finished */
+  adopt(saga: Saga): void {
+    this.steps.push(...saga.steps);
+    saga.steps = [];
+  }
+
+  /**
+   * Undo the completed steps, last first, after the workflow failed with
+   * the given error. Every compensation runs even when one fails; the
+   * error to throw is returned, which names the compensations that failed
+   */
+  async compensate(
+    error: unknown,
+    log?: (message: string) => void
+  ): Promise<unknown> {
+    const steps = this.steps.reverse();
+    this.steps = [];
+    const failures: Array<{ label: string; error: unknown }> = [];
+    for (const step of steps) {
+      log?.(`Compensating ${step.label}`);
+      try {
+        await step.undo();
+      } catch (undoError) {
+        failures.push({ label: step.label, error: undoError });
+      }
+    }
+    return failures.length > 0 ? new CompensationError(error, failures) : error;
+  }
+}
+
+/**
+ * The sagas of a run, as used by generated code through `__compensate`
+ * and `__saga`
+ */
+export class Sagas {
+  /** Saga of the tool or parallel map item the current code runs in */
+  private readonly current = new AsyncLocalStorage<Saga>();
+  /** Saga of the script's top-level statements */
+  readonly root = new Saga();
+
+  constructor(private readonly log?: (message: string) => void) {}
+
+  private active(): Saga {
+    return this.current.getStore() ?? this.root;
+  }
+
+  /**
+   * Register the compensation of a step that just completed
+   */
+  compensate(undo: () => Promise<unknown>, label: string): void {
+    this.active().add({ label, undo });
+  }
+
+  /**
+   * Run a tool's body with a saga of its own; when it fails, its steps
+   * are undone, and when it finishes, the caller takes them on
+   */
+  async run<T>(body: () => Promise<T>): Promise<T> {
+    const saga = new Saga();
+    let result: T;
+    try {
+      result = await this.current.run(saga, body);
+    } catch (error) {
+      throw await saga.compensate(error, this.log);
+    }
+    this.active().adopt(saga);
+    return result;
+  }
+}
@@ -0,0 +1,195 @@
+// Script-level stack traces for runtime errors
+
+/**
+ * File name given to generated code when it runs in the VM
+ */
+export const GENERATED_FILENAME = 'mcps-generated.js';
+
+/**
+ * One frame of a script-level stack trace (line and column are 1-based)
+ */
+export interface ScriptStackFrame {
+  /** Tool the frame is in, or undefined for top-level script code */
+  tool?: string;
+  file: string;
+  line: number;
+  column: number;
+  /** The source line the frame points at, when the source is known */
+  excerpt?: string;
+}
+
+/**
+ * Where the generated code came from
+ */
+export interface ScriptSource {
+  fileName?: string;
+  content?: string;
+}
+
+interface Position {
+  line: number;
+  column: number;
+}
+
+interface StatementMarker {
+  /** Position of the marker in the generated code */
+  generated: Position;
+  source: Position;
+}
+
+interface ToolMarker {
+  name: string;
+  start: Position;
+  end: Position;
+}
+
+// Markers emitted by the transpiler when source positions are enabled
+const STATEMENT_MARKER = /\/\*@mcps:(\d+):(\d+)\*\//g;
+const TOOL_MARKER = /\/\*@mcps-tool:(\w+):(\d+):(\d+):(\d+):(\d+)\*\//g;
+
+/**
+ * An error raised by a script, with a stack trace in terms of the script
+ * rather than the generated JavaScript
+ */
+export class ScriptError extends Error {
+  constructor(
+    original: Error,
+    public readonly scriptStack: ScriptStackFrame[]
+  ) {
+    super(original.message, { cause: original });
+    this.name = original.name;
+    const frames = formatScriptStack(scriptStack);
+    this.stack = `${this.name}: ${this.message}\n${frames}`;
+  }
+}
+
+/**
+ * Render stack frames as plain text, one "at" line per frame
+ */
+export function formatScriptStack(frames: ScriptStackFrame[]): string {
+  return frames
+    .map(frame => {
+      const where = `${frame.file}:${frame.line}:${frame.column}`;
+      return `    at ${frame.tool ?? '<script>'} (${where})`;
+    })
+    .join('\n');
+}
+
+function comparePositions(a: Position, b: Position): number {
+  return a.line - b.line || a.column - b.column;
+}
+
+/**
+ * Convert string offsets to 1-based line/column positions
+ */
+function createPositionLookup(code: string) {
+  const lineStarts = [0];
+  for (let i = 0; i < code.length; i++) {
+    if (code[i] === '\n') {
+      lineStarts.push(i + 1);
+    }
+  }
+  return (index: number): Position => {
+    let low = 0;
+    let high = lineStarts.length - 1;
+    while (low < high) {
+      const mid = Math.ceil((low + high) / 2);
+      if (lineStarts[mid] <= index) {
+        low = mid;
+      } else {
+        high = mid - 1;
+      }
+    }
+    return { line: low + 1, column: index - lineStarts[low] + 1 };
+  };
+}
+
+/**
+ * Map the generated-code frames of a JavaScript stack trace back to the
+ * script statements they belong to
+ * Returns an empty list if the code carries no position markers
+ */
+export function resolveScriptStack(
+  stack: string,
+  code: string,
+  source: ScriptSource = {}
+): ScriptStackFrame[] {
+  const positionOf = createPositionLookup(code);
+  const statements: StatementMarker[] = [];
+  for (const match of code.matchAll(STATEMENT_MARKER)) {
+    statements.push({
+      generated: positionOf(match.index ?? 0),
+      source: { line: parseInt(match[1], 10), column: parseInt(match[2], 10) },
+    });
+  }
+  if (statements.length === 0) {
+    return [];
+  }
+
+  const tools: ToolMarker[] = [];
+  for (const match of code.matchAll(TOOL_MARKER)) {
+    const [startLine, startColumn, endLine, endColumn] = match
+      .slice(2)
+      .map(n => parseInt(n, 10));
+    tools.push({
+      name: match[1],
+      start: { line: startLine, column: startColumn },
+      end: { line: endLine, column: endColumn },
+    });
+  }
+
+  const file = source.fileName ?? '<script>';
+  const sourceLines = source.content?.split('\n');
+  const framePattern = new RegExp(
+    `${GENERATED_FILENAME.replace(/\./g, '\\.')}:(\\d+):(\\d+)`
+  );
+
+  const frames: ScriptStackFrame[] = [];
+  for (const stackLine of stack.split('\n')) {
+    const match = framePattern.exec(stackLine);
+    if (!match) {
+      continue;
+    }
+    const position = {
+      line: parseInt(match[1], 10),
+      column: parseInt(match[2], 10),
+    };
+
+    // The statement is the last marker at or before the frame position
+    let statement: StatementMarker | undefined;
+    for (const marker of statements) {
+      if (comparePositions(marker.generated, position) > 0) {
+        break;
+      }
+      statement = marker;
+    }
+    if (!statement) {
+      continue;
+    }
+
+    const { source: location } = statement;
+    const previous = frames[frames.length - 1];
+    if (
+      previous &&
+      previous.line === location.line &&
+      previous.column === location.column
+    ) {
+      continue;
+    }
+
+    const tool = tools.find(
+      t =>
+        comparePositions(t.start, location) < 0 &&
+        comparePositions(location, t.end) < 0
+    );
+    frames.push({
+      tool: tool?.name,
+      file,
+      line: location.line,
+      column: location.column,
+      excerpt: sourceLines?.[location.line - 1]?.trimEnd(),
+    });
+  }
+
+  return frames;
+}
@@ -0,0 +1,193 @@
+// Secrets and environment variables referenced by server options
+//
+// Options of an mcp declaration may reference ${NAME} and ${secret:NAME};
+// the transpiler splits such strings into parts, which are resolved here
+// when the server starts. Secrets come from providers asked in order: the
+// environment, files in a directory (as mounted by Docker or Kubernetes)
+// or an external command such as a password manager's CLI. Hosts can add
+// providers of their own. Every resolved secret is reported so it can be
+// masked in logs and traces.
+import { execFile } from 'child_process';
+import { readFile } from 'fs/promises';
+import { join } from 'path';
+
+/**
+ * Text of an interpolated string, or a reference to resolve into it
+ * (project metadata is only referenced by prompts, see prompts.ts)
+ */
+export type InterpolationPart =
+  | string
+  | { env: string }
+  | { secret: string }
+  | { project: string };
+
+/**
+ * A source of secrets
+ */
+export interface SecretProvider {
+  /** Shown in errors about secrets no provider has, e.g. "env" */
+  readonly name: string;
+  /** The secret's value, or undefined if this provider does not have it */
+  get(name: string): Promise<string | undefined>;
+}
+
+/**
+ * Secrets from environment variables of the same name
+ */
+export function envSecretProvider(
+  environment: NodeJS.ProcessEnv = process.env
+): SecretProvider {
+  return {
+    name: 'env',
+    get: async name => environment[name],
+  };
+}
+
+/**
+ * Secrets from the files of a directory, named after the secret; a
+ * trailing newline is not part of the value
+ */
+export function fileSecretProvider(directory: string): SecretProvider {
+  return {
+    name: `file ${directory}`,
+    get: async name => {
+      try {
+        const value = await readFile(join(directory, name), 'utf-8');
+        return value.replace(/\r?\n$/, '');
+      } catch (error) {
+        if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+          return undefined;
+        }
+        throw error;
+      }
+    },
+  };
+}
+
+/**
+ * Secrets printed by a command, run with {name} in its arguments replaced
+ * by the secret's name; a failing command does not have the secret
+ */
+export function commandSecretProvider(command: string[]): SecretProvider {
+  const [program, ...args] = command;
+  return {
+    name: `command ${program}`,
+    get: name =>
+      new Promise((resolve, reject) => {
+        execFile(
+          program,
+          args.map(arg => arg.replaceAll('{name}', name)),
+          (error, stdout) => {
+            if (!error) {
+              resolve(stdout.replace(/\r?\n$/, ''));
+            } else if (typeof error.code === 'number') {
+              resolve(undefined);
+            } else {
+              // The command could not be started at all
+              reject(error);
+            }
+          }
+        );
+      }),
+  };
+}
+
+/**
+ * Error thrown when a referenced secret or variable has no value
+ */
+export class SecretNotFoundError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'SecretNotFoundError';
+  }
+}
+
+/**
+ * Looks secrets up in its providers, in order, once per name
+ */
+export class SecretStore {
+  private readonly resolved = new Map<string, Promise<string>>();
+
+  /**
+   * @param onSecret Called with the value of each secret found, e.g. to
+   *   mask it in logs
+   */
+  constructor(
+    private readonly providers: SecretProvider[] = [envSecretProvider()],
+    private readonly onSecret: (value: string) => void = () => {}
+  ) {}
+
+  get(name: string): Promise<string> {
+    let value = this.resolved.get(name);
+    if (!value) {
+      value = this.lookup(name);
+      this.resolved.set(name, value);
+    }
+    return value;
+  }
+
+  private async lookup(name: string): Promise<string> {
+    for (const provider of this.providers) {
+      const value = await provider.get(name);
+      if (value !== undefined) {
+        this.onSecret(value);
+        return value;
+      }
+    }
+    const searched =
+      this.providers.map(provider => provider.name).join(', ') || 'nowhere';
+    throw new SecretNotFoundError(
+      `Secret ${name} was not found in ${searched}`
+    );
+  }
+}
+
+/**
+ * Resolve the references of an interpolated string
+ */
+export async function interpolate(
+  parts: InterpolationPart[],
+  secrets: SecretStore,
+  environment: NodeJS.ProcessEnv = process.env
+): Promise<string> {
+  const values = await Promise.all(
+    parts.map(async part => {
+      if (typeof part === 'string') {
+        return part;
+      }
+      if ('secret' in part) {
+        return secrets.get(part.secret);
+      }
+      if ('project' in part) {
+        throw new Error(
+          `\${project.${part.project}} can only be referenced by prompts`
+        );
+      }
+      const value = environment[part.env];
+      if (value === undefined) {
+        throw new SecretNotFoundError(
+          `Environment variable ${part.env} is not set`
+        );
+      }
+      return value;
+    })
+  );
+  return values.join('');
+}
+
+/**
+ * An interpolated string as written, with its references unresolved
+ */
+export function formatInterpolation(parts: InterpolationPart[]): string {
+  return parts
+    .map(part =>
+      typeof part === 'string'
+        ? part.replaceAll('${', () => '$${')
+        : 'secret' in part
+          ? `\${secret:${part.secret}}`
+          : 'project' in part
+            ? `\${project.${part.project}}`
+            : `\${${part.env}}`
+    )
+    .join('');
+}
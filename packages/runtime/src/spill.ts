@@ -0,0 +1,182 @@
+// Spilling of large tool results to disk
+//
+// A tool result whose text is larger than the run's spill threshold is
+// written to a temporary file, and the script gets a SpilledText in its
+// place. That reads the file back whenever the text is used: converted to
+// a string, as by interpolation, concatenation, print() or JSON.parse(),
+// or through a string property or method such as split(). The text is not
+// kept in memory between uses, so a run can hold many large results and
+// pass them from tool to tool without holding them all at once. Tool calls
+// given a spilled result send its text, and inspect() shows its size and
+// the start of it. A file is removed once the script no longer holds its
+// result, and the rest once the run is over.
+import { mkdtempSync, readFileSync, rmSync, writeFileSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+
+/**
+ * When tool results are spilled, and where to
+ */
+export interface SpillOptions {
+  /** Size in bytes above which a result's text is spilled */
+  threshold: number;
+  /** Directory the files are created under (default: the temp directory) */
+  dir?: string;
+}
+
+/** Characters of a spilled text kept in memory to show it by */
+const PREVIEW_LENGTH = 200;
+
+/**
+ * A tool result kept in a file, which stands in for its text
+ */
+export class SpilledText {
+  constructor(
+    /** File holding the text */
+    readonly file: string,
+    /** Size of the text in bytes */
+    readonly size: number,
+    /** Length of the text in characters, as string length counts them */
+    readonly length: number,
+    /** Start of the text */
+    readonly preview: string
+  ) {}
+
+  /**
+   * The text, read from its file
+   */
+  text(): string {
+    return readFileSync(this.file, 'utf-8');
+  }
+
+  toString(): string {
+    return this.text();
+  }
+
+  toJSON(): string {
+    return this.text();
+  }
+
+  [Symbol.toPrimitive](): string {
+    return this.text();
+  }
+}
+
+/**
+ * Whether a value is a spilled result
+ */
+export function isSpilled(value: unknown): value is SpilledText {
+  return value instanceof SpilledText;
+}
+
+/**
+ * A value with the spilled results in it replaced by their text, for
+ * sending it on or keeping it beyond the run
+ */
+export function unspill(value: unknown): unknown {
+  if (isSpilled(value)) {
+    return value.text();
+  }
+  if (Array.isArray(value)) {
+    return value.map(unspill);
+  }
+  // Plain objects of any realm, such as those of the script
+  const proto =
+    typeof value === 'object' && value !== null
+      ? Object.getPrototypeOf(value)
+      : undefined;
+  if (proto === null || (proto && Object.getPrototypeOf(proto) === null)) {
+    return Object.fromEntries(
+      Object.entries(value as object).map(([key, item]) => [
+        key,
+        unspill(item),
+      ])
+    );
+  }
+  return value;
+}
+
+/**
+ * Gives the script's use of a spilled text the string's properties and
+ * methods, reading the text for each
+ */
+const STRING_VIEW: ProxyHandler<SpilledText> = {
+  get(target, prop, receiver) {
+    if (prop in target) {
+      return Reflect.get(target, prop, receiver);
+    }
+    // Other lookups, such as that of then when the result is awaited, do
+    // not read the file
+    if (
+      !(prop in String.prototype) &&
+      !(typeof prop === 'string' && /^\d+$/.test(prop))
+    ) {
+      return undefined;
+    }
+    const text = target.text();
+    const value = (Object(text) as Record<PropertyKey, unknown>)[prop];
+    return typeof value === 'function' ? value.bind(text) : value;
+  },
+};
+
+/**
+ * The files a run spills its large tool results to
+ */
+export class SpillStore {
+  private dir?: string;
+  private count = 0;
+  private readonly removeOnExit = () => this.close();
+  /** Removes the file of each result the script no longer holds */
+  private readonly collected = new FinalizationRegistry<string>(file =>
+    rmSync(file, { force: true })
+  );
+
+  constructor(private readonly options: SpillOptions) {}
+
+  /**
+   * A tool result as the script gets it: spilled when its text is over
+   * the threshold, as is otherwise
+   */
+  keep(result: unknown): unknown {
+    if (
+      typeof result !== 'string' ||
+      // Strings have at most 3 bytes of UTF-8 per character
+      result.length * 3 <= this.options.threshold
+    ) {
+      return result;
+    }
+    const size = Buffer.byteLength(result, 'utf-8');
+    if (size <= this.options.threshold) {
+      return result;
+    }
+    if (!this.dir) {
+      const parent = this.options.dir ?? tmpdir();
+      this.dir = mkdtempSync(join(parent, 'mcps-spill-'));
+      process.once('exit', this.removeOnExit);
+    }
+    const file = join(this.dir, `${++this.count}.txt`);
+    writeFileSync(file, result, 'utf-8');
+    const spilled = new Proxy(
+      new SpilledText(
+        file,
+        size,
+        result.length,
+        result.slice(0, PREVIEW_LENGTH)
+      ),
+      STRING_VIEW
+    );
+    this.collected.register(spilled, file);
+    return spilled;
+  }
+
+  /**
+   * Remove the spilled files; results spilled before can no longer be read
+   */
+  close(): void {
+    if (this.dir) {
+      rmSync(this.dir, { recursive: true, force: true });
+      process.removeListener('exit', this.removeOnExit);
+      this.dir = undefined;
+    }
+  }
+}
@@ -0,0 +1,300 @@
+// Stdio transport with control over the server process environment
+import { spawn, type ChildProcess, type IOType } from 'child_process';
+import { setPriority } from 'os';
+import type { Stream } from 'stream';
+import { getDefaultEnvironment } from '@modelcontextprotocol/sdk/client/stdio.js';
+import {
+  ReadBuffer,
+  serializeMessage,
+} from '@modelcontextprotocol/sdk/shared/stdio.js';
+import type { Transport } from '@modelcontextprotocol/sdk/shared/transport.js';
+import type { JSONRPCMessage } from '@modelcontextprotocol/sdk/types.js';
+
+/**
+ * Default number of bytes of captured stderr kept per server
+ */
+export const DEFAULT_STDERR_LIMIT = 64 * 1024;
+
+// How long a server may take to exit before it is signalled
+const EXIT_GRACE_PERIOD_MS = 2000;
+
+/**
+ * Options that isolate a stdio server process from the script's own
+ * environment and output
+ */
+export interface StdioIsolationOptions {
+  /**
+   * Inherit the standard variables (PATH, HOME, USER...) from the script's
+   * environment (default: true); set to false to start from an empty
+   * environment
+   */
+  inheritEnv?: boolean;
+  /** Further variables copied from the script's environment when set */
+  allowEnv?: string[];
+  /**
+   * Maximum bytes of stderr kept when stderr is "capture"; only the most
+   * recent output is retained
+   */
+  stderrLimit?: number;
+  /** Scheduling priority (nice value) from -20 (highest) to 19 (lowest) */
+  priority?: number;
+}
+
+/**
+ * Parameters for spawning an isolated stdio server
+ */
+export interface IsolatedStdioParameters extends StdioIsolationOptions {
+  command: string;
+  args?: string[];
+  /** Variables set explicitly for the server */
+  env?: Record<string, string>;
+  /**
+   * Where server stderr goes: a standard stdio setting, or "capture" to
+   * keep it in memory for error reports
+   */
+  stderr?: IOType | Stream | number | 'capture';
+  cwd?: string;
+}
+
+/**
+ * Whether a server declaration uses any isolation option
+ */
+export function usesStdioIsolation(
+  params: Omit<IsolatedStdioParameters, 'command'>
+): boolean {
+  return (
+    params.inheritEnv !== undefined ||
+    params.allowEnv !== undefined ||
+    params.stderrLimit !== undefined ||
+    params.priority !== undefined ||
+    params.stderr === 'capture'
+  );
+}
+
+/**
+ * Build the environment a server process starts with
+ * Explicit variables take precedence over inherited and allowed ones
+ */
+export function buildServerEnvironment(
+  params: Pick<IsolatedStdioParameters, 'inheritEnv' | 'allowEnv' | 'env'>,
+  parentEnv: NodeJS.ProcessEnv = process.env
+): Record<string, string> {
+  const result: Record<string, string> =
+    params.inheritEnv === false ? {} : getDefaultEnvironment();
+
+  for (const name of params.allowEnv ?? []) {
+    const value = parentEnv[name];
+    if (value !== undefined) {
+      result[name] = value;
+    }
+  }
+
+  return { ...result, ...params.env };
+}
+
+/**
+ * Keeps the most recent output of a stream up to a byte limit
+ */
+export class OutputCapture {
+  private chunks: Buffer[] = [];
+  private size = 0;
+  private dropped = false;
+
+  constructor(private readonly limit: number = DEFAULT_STDERR_LIMIT) {
+    if (!Number.isInteger(limit) || limit < 0) {
+      throw new Error(`Invalid stderr limit: ${limit}`);
+    }
+  }
+
+  append(chunk: Buffer): void {
+    this.chunks.push(chunk);
+    this.size += chunk.length;
+
+    while (this.size > this.limit && this.chunks.length > 0) {
+      const excess = this.size - this.limit;
+      const first = this.chunks[0];
+      if (first.length <= excess) {
+        this.chunks.shift();
+        this.size -= first.length;
+      } else {
+        this.chunks[0] = first.subarray(excess);
+        this.size -= excess;
+      }
+      this.dropped = true;
+    }
+  }
+
+  /**
+   * Whether earlier output was discarded to stay within the limit
+   */
+  get truncated(): boolean {
+    return this.dropped;
+  }
+
+  toString(): string {
+    return Buffer.concat(this.chunks).toString('utf-8');
+  }
+}
+
+/**
+ * Client transport that spawns a server with a controlled environment,
+ * optional stderr capture and scheduling priority
+ * The protocol handling matches the SDK's StdioClientTransport
+ */
+export class IsolatedStdioTransport implements Transport {
+  onclose?: () => void;
+  onerror?: (error: Error) => void;
+  onmessage?: (message: JSONRPCMessage) => void;
+
+  private process?: ChildProcess;
+  private readBuffer = new ReadBuffer();
+  private readonly capture?: OutputCapture;
+
+  constructor(private readonly params: IsolatedStdioParameters) {
+    const { priority } = params;
+    if (
+      priority !== undefined &&
+      (!Number.isInteger(priority) || priority < -20 || priority > 19)
+    ) {
+      throw new Error(
+        `Invalid priority ${priority}: must be an integer from -20 to 19`
+      );
+    }
+    if (params.stderr === 'capture') {
+      this.capture = new OutputCapture(
+        params.stderrLimit ?? DEFAULT_STDERR_LIMIT
+      );
+    }
+  }
+
+  /**
+   * Captured stderr output, when stderr is "capture"
+   */
+  get stderrOutput(): OutputCapture | undefined {
+    return this.capture;
+  }
+
+  get pid(): number | null {
+    return this.process?.pid ?? null;
+  }
+
+  async start(): Promise<void> {
+    if (this.process) {
+      throw new Error('IsolatedStdioTransport already started');
+    }
+
+    const { params } = this;
+    const stderr =
+      params.stderr === 'capture' ? 'pipe' : (params.stderr ?? 'inherit');
+
+    await new Promise<void>((resolve, reject) => {
+      const child = spawn(params.command, params.args ?? [], {
+        env: buildServerEnvironment(params),
+        stdio: ['pipe', 'pipe', stderr],
+        cwd: params.cwd,
+        shell: false,
+        windowsHide: true,
+      });
+      this.process = child;
+
+      child.on('error', error => {
+        reject(error);
+        this.onerror?.(error);
+      });
+
+      child.on('spawn', () => {
+        if (params.priority !== undefined && child.pid !== undefined) {
+          try {
+            setPriority(child.pid, params.priority);
+          } catch (error) {
+            child.kill();
+            const reason =
+              error instanceof Error ? error.message : String(error);
+            reject(
+              new Error(`Cannot set priority ${params.priority}: ${reason}`)
+            );
+            return;
+          }
+        }
+        resolve();
+      });
+
+      child.on('close', () => {
+        this.process = undefined;
+        this.onclose?.();
+      });
+
+      child.stdin?.on('error', error => this.onerror?.(error));
+      child.stdout?.on('error', error => this.onerror?.(error));
+      child.stdout?.on('data', (chunk: Buffer) => {
+        this.readBuffer.append(chunk);
+        this.processReadBuffer();
+      });
+      if (this.capture) {
+        child.stderr?.on('data', (chunk: Buffer) =>
+          this.capture?.append(chunk)
+        );
+      }
+    });
+  }
+
+  private processReadBuffer(): void {
+    for (;;) {
+      try {
+        const message = this.readBuffer.readMessage();
+        if (message === null) {
+          break;
+        }
+        this.onmessage?.(message);
+      } catch (error) {
+        this.onerror?.(error as Error);
+      }
+    }
+  }
+
+  send(message: JSONRPCMessage): Promise<void> {
+    return new Promise(resolve => {
+      const stdin = this.process?.stdin;
+      if (!stdin) {
+        throw new Error('Not connected');
+      }
+      if (stdin.write(serializeMessage(message))) {
+        resolve();
+      } else {
+        stdin.once('drain', resolve);
+      }
+    });
+  }
+
+  /**
+   * Stop the server, closing its input first and signalling it only if
+   * it does not exit on its own
+   */
+  async close(): Promise<void> {
+    const child = this.process;
+    this.readBuffer.clear();
+    if (!child || child.exitCode !== null || child.signalCode !== null) {
+      return;
+    }
+
+    const exited = new Promise<void>(resolve => child.once('close', resolve));
+    child.stdin?.end();
+    for (const signal of ['SIGTERM', 'SIGKILL'] as const) {
+      if (await settlesWithin(exited, EXIT_GRACE_PERIOD_MS)) {
+        return;
+      }
+      child.kill(signal);
+    }
+    await exited;
+  }
+}
+
+function settlesWithin(promise: Promise<void>, ms: number): Promise<boolean> {
+  return new Promise(resolve => {
+    const timer = setTimeout(() => resolve(false), ms);
+    promise.then(() => {
+      clearTimeout(timer);
+      resolve(true);
+    });
+  });
+}
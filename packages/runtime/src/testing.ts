@@ -0,0 +1,646 @@
+// Testing support: mock MCP servers and assertions for `mcps test`
+//
+// Tests run the script with its servers replaced by MockServers, which
+// answer tool calls with responses the script registers through mock()
+// and record every call so tests can check what was sent. assert(),
+// assertEqual(), assertThrows() and the matchers of expect() are available
+// to every script. Test files may also declare test cases with hooks and
+// fixtures around them, which a TestSuite runs one at a time.
+import { mkdtemp, readFile, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join, resolve } from 'path';
+import { types } from 'util';
+import type { MCPClientOptions } from './mcp-client.js';
+import type { ToolSchema } from './tool-schemas.js';
+import type { ToolCallRecord } from './transcript.js';
+
+/**
+ * A tool call received by a mock server
+ */
+export interface MockToolCall {
+  /** Tool as "server.tool" */
+  tool: string;
+  input: Record<string, unknown>;
+}
+
+/**
+ * A tool call of a recorded run, answered as it was then
+ */
+export type RecordedToolCall = Omit<ToolCallRecord, 'time'>;
+
+type MockResponse =
+  | { value: unknown }
+  | { error: string }
+  | { handler: (input: Record<string, unknown>) => unknown };
+
+/**
+ * Stand-ins for the MCP servers of a script under test
+ * Servers list the tools they are given schemas for, since a script lists
+ * the tools of its servers before registering responses.
+ */
+export class MockServers {
+  private readonly responses = new Map<string, MockResponse>();
+  private readonly received: MockToolCall[] = [];
+
+  /**
+   * @param schemas Tools of each server by declared name
+   */
+  constructor(private readonly schemas: Record<string, ToolSchema[]> = {}) {}
+
+  /**
+   * A client answering from the registered responses, in place of the one
+   * __llamaindex_mcp would start
+   */
+  connect(options: MCPClientOptions) {
+    const server = options.serverName ?? 'mcp';
+    return {
+      tools: async () =>
+        (this.schemas[server] ?? []).map(schema => ({
+          metadata: {
+            name: schema.name,
+            description: schema.description ?? '',
+            parameters: schema.inputSchema,
+          },
+          call: (input: Record<string, unknown>) =>
+            options.faults
+              ? options.faults.call(server, schema.name, () =>
+                  this.call(`${server}.${schema.name}`, input)
+                )
+              : this.call(`${server}.${schema.name}`, input),
+        })),
+      // Mock servers send no notifications
+      onNotification: () => {},
+      subscribeResource: async () => {},
+      cleanup: async () => {},
+    };
+  }
+
+  /**
+   * Answer calls of a tool with a value, or with what a function returns
+   * for their input
+   */
+  respond(tool: string, response: unknown): void {
+    this.responses.set(
+      tool,
+      typeof response === 'function'
+        ? { handler: response as (input: Record<string, unknown>) => unknown }
+        : { value: response }
+    );
+  }
+
+  /**
+   * Answer calls of tools as they were answered in a recorded run: a call
+   * gets the answer of the next recorded call of its tool with the same
+   * input, or of the last one once all have been used
+   */
+  replay(calls: RecordedToolCall[]): void {
+    const byTool = new Map<string, RecordedToolCall[]>();
+    for (const call of calls) {
+      byTool.set(call.tool, [...(byTool.get(call.tool) ?? []), call]);
+    }
+    for (const [tool, recorded] of byTool) {
+      const used = new Set<RecordedToolCall>();
+      this.respond(tool, (input: Record<string, unknown>) => {
+        // Inputs were recorded as JSON, which drops undefined properties
+        const sent = JSON.parse(JSON.stringify(input));
+        const matching = recorded.filter(call => deepEqual(call.input, sent));
+        if (matching.length === 0) {
+          throw new Error(
+            `${tool} was not called with ${JSON.stringify(sent)} in the recorded run`
+          );
+        }
+        const call =
+          matching.find(call => !used.has(call)) ??
+          matching[matching.length - 1];
+        used.add(call);
+        if (call.error !== undefined) {
+          throw new Error(call.error);
+        }
+        return call.result;
+      });
+    }
+  }
+
+  /**
+   * Make calls of a tool fail with an error
+   */
+  fail(tool: string, message: string): void {
+    this.responses.set(tool, { error: message });
+  }
+
+  /**
+   * Remember the registered responses, so a test case's own can be undone
+   * @returns Function restoring them and forgetting the calls since
+   */
+  save(): () => void {
+    const responses = new Map(this.responses);
+    this.received.length = 0;
+    return () => {
+      this.responses.clear();
+      responses.forEach((response, tool) =>
+        this.responses.set(tool, response)
+      );
+      this.received.length = 0;
+    };
+  }
+
+  /**
+   * Inputs of the calls a tool received, oldest first, or all calls
+   */
+  calls(tool?: string): MockToolCall[] {
+    return this.received.filter(
+      call => tool === undefined || call.tool === tool
+    );
+  }
+
+  private async call(
+    tool: string,
+    input: Record<string, unknown>
+  ): Promise<unknown> {
+    this.received.push({ tool, input });
+    const response = this.responses.get(tool);
+    if (!response) {
+      throw new Error(
+        `No mock response for ${tool}; register one with mock("${tool}", ...)`
+      );
+    }
+    if ('error' in response) {
+      throw new Error(response.error);
+    }
+    return 'handler' in response
+      ? await response.handler(input)
+      : response.value;
+  }
+}
+
+/**
+ * Create the mock() function of scripts, with mock.fail() and mock.calls()
+ * Outside of tests, where there are no mock servers, they throw
+ */
+export function createMock(servers?: MockServers) {
+  const using = (name: string): MockServers => {
+    if (!servers) {
+      throw new Error(`${name}() is only available in tests run by mcps test`);
+    }
+    return servers;
+  };
+  return Object.assign(
+    function mock(tool: string, response: unknown): void {
+      using('mock').respond(tool, response);
+    },
+    {
+      fail(tool: string, message: string): void {
+        using('mock.fail').fail(tool, message);
+      },
+      calls(tool: string): Record<string, unknown>[] {
+        return using('mock.calls')
+          .calls(tool)
+          .map(call => call.input);
+      },
+      /**
+       * Answer tool calls from a recording written by mcps transcript mocks
+       */
+      recorded(recording: { calls?: unknown }): void {
+        const mocks = using('mock.recorded');
+        if (!Array.isArray(recording?.calls)) {
+          throw new Error(
+            'mock.recorded() takes a recording written by mcps transcript mocks'
+          );
+        }
+        mocks.replay(recording.calls);
+      },
+      /** Register the responses of several tools of a server at once */
+      server(name: string, responses: Record<string, unknown>): void {
+        const mocks = using('mock.server');
+        for (const [tool, response] of Object.entries(responses)) {
+          mocks.respond(`${name}.${tool}`, response);
+        }
+      },
+    }
+  );
+}
+
+/**
+ * Error thrown when an assertion of a script fails
+ */
+export class AssertionError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'AssertionError';
+  }
+}
+
+/**
+ * Whether two values have the same contents
+ * Arrays and objects are compared by their elements and properties, so
+ * values created by the script compare equal to those of the host.
+ */
+export function deepEqual(a: unknown, b: unknown): boolean {
+  if (Object.is(a, b)) {
+    return true;
+  }
+  if (Array.isArray(a) || Array.isArray(b)) {
+    return (
+      Array.isArray(a) &&
+      Array.isArray(b) &&
+      a.length === b.length &&
+      a.every((item, index) => deepEqual(item, b[index]))
+    );
+  }
+  if (
+    typeof a !== 'object' ||
+    typeof b !== 'object' ||
+    a === null ||
+    b === null
+  ) {
+    return false;
+  }
+  const keys = Object.keys(a);
+  return (
+    keys.length === Object.keys(b).length &&
+    keys.every(
+      key =>
+        Object.hasOwn(b, key) &&
+        deepEqual(
+          (a as Record<string, unknown>)[key],
+          (b as Record<string, unknown>)[key]
+        )
+    )
+  );
+}
+
+function show(value: unknown): string {
+  return JSON.stringify(value) ?? String(value);
+}
+
+/**
+ * Fail unless the condition holds
+ */
+export function assert(condition: unknown, message?: string): void {
+  if (!condition) {
+    throw new AssertionError(message ?? 'Assertion failed');
+  }
+}
+
+/**
+ * Fail unless two values have the same contents (see deepEqual)
+ */
+export function assertEqual(
+  actual: unknown,
+  expected: unknown,
+  message?: string
+): void {
+  if (!deepEqual(actual, expected)) {
+    const detail = `expected ${show(expected)}, got ${show(actual)}`;
+    throw new AssertionError(message ? `${message}: ${detail}` : detail);
+  }
+}
+
+/**
+ * Fail unless calling a tool with the arguments throws, and return the
+ * error's message so tests can check it
+ */
+export async function assertThrows(
+  tool: unknown,
+  ...args: unknown[]
+): Promise<string> {
+  if (typeof tool !== 'function') {
+    throw new AssertionError('assertThrows() needs a tool to call');
+  }
+  try {
+    await tool(...args);
+  } catch (error) {
+    // Errors of the script come from another realm, so check their shape
+    const message = (error as { message?: unknown } | null)?.message;
+    return typeof message === 'string' ? message : String(error);
+  }
+  throw new AssertionError(`expected ${tool.name || 'the tool'} to throw`);
+}
+
+/**
+ * Follow a JSON path such as $.items[0].id or $['user name'] into a value
+ * Only property names and array indexes are supported; negative indexes
+ * count from the end.
+ */
+export function jsonPath(
+  value: unknown,
+  path: string
+): { found: true; value: unknown } | { found: false; missing: string } {
+  if (!path.startsWith('$')) {
+    throw new AssertionError(`JSON path ${path} must start with $`);
+  }
+  const segment = /^(?:\.([A-Za-z_$][\w$]*)|\[(-?\d+)\]|\[(['"])(.*?)\3\])/;
+  let current = value;
+  let rest = path.slice(1);
+  let walked = '$';
+  while (rest) {
+    const match = segment.exec(rest);
+    if (!match) {
+      throw new AssertionError(`Invalid JSON path ${path} at ${rest}`);
+    }
+    const [text, name, index, , quoted] = match;
+    if (index !== undefined) {
+      const position = Number(index);
+      const at =
+        Array.isArray(current) && position < 0
+          ? current.length + position
+          : position;
+      if (!Array.isArray(current) || at < 0 || at >= current.length) {
+        return { found: false, missing: `${walked}${text}` };
+      }
+      current = current[at];
+    } else {
+      const key = name ?? quoted;
+      if (
+        typeof current !== 'object' ||
+        current === null ||
+        Array.isArray(current) ||
+        !Object.hasOwn(current, key)
+      ) {
+        return { found: false, missing: `${walked}${text}` };
+      }
+      current = (current as Record<string, unknown>)[key];
+    }
+    walked += text;
+    rest = rest.slice(text.length);
+  }
+  return { found: true, value: current };
+}
+
+/**
+ * Milliseconds since the epoch of a date, an ISO 8601 string or a number
+ * of milliseconds, or undefined for other values
+ */
+function timeOf(value: unknown): number | undefined {
+  const time = types.isDate(value)
+    ? value.getTime()
+    : typeof value === 'string'
+      ? Date.parse(value)
+      : typeof value === 'number'
+        ? value
+        : NaN;
+  return isNaN(time) ? undefined : time;
+}
+
+function requireTime(value: unknown, what: string): number {
+  const time = timeOf(value);
+  if (time === undefined) {
+    throw new AssertionError(
+      `expected ${what} to be a date, ISO 8601 string or number of milliseconds, got ${show(value)}`
+    );
+  }
+  return time;
+}
+
+/**
+ * The matchers of expect(actual): each fails the test with an
+ * AssertionError unless actual matches
+ */
+export function expect(actual: unknown) {
+  return {
+    /** Same contents as expected (see deepEqual) */
+    toEqual(expected: unknown): void {
+      assertEqual(actual, expected);
+    },
+    /** The path exists in actual, with the expected value if one is given */
+    toHaveJsonPath(path: string, ...expected: unknown[]): void {
+      const result = jsonPath(actual, path);
+      if (!result.found) {
+        throw new AssertionError(
+          `expected ${path} in ${show(actual)}, but ${result.missing} does not exist`
+        );
+      }
+      if (expected.length > 0 && !deepEqual(result.value, expected[0])) {
+        throw new AssertionError(
+          `expected ${show(expected[0])} at ${path}, got ${show(result.value)}`
+        );
+      }
+    },
+    /** Actual is a time at most tolerance milliseconds from expected */
+    toBeCloseInTime(expected: unknown, tolerance: number): void {
+      const difference = Math.abs(
+        requireTime(actual, 'the value') - requireTime(expected, 'the time')
+      );
+      if (difference > tolerance) {
+        throw new AssertionError(
+          `expected ${show(actual)} within ${tolerance} ms of ${show(expected)}, but it is ${difference} ms off`
+        );
+      }
+    },
+    /** Actual is a time before expected */
+    toBeBefore(expected: unknown): void {
+      if (
+        requireTime(actual, 'the value') >= requireTime(expected, 'the time')
+      ) {
+        throw new AssertionError(
+          `expected ${show(actual)} to be before ${show(expected)}`
+        );
+      }
+    },
+    /** Actual is a time after expected */
+    toBeAfter(expected: unknown): void {
+      if (
+        requireTime(actual, 'the value') <= requireTime(expected, 'the time')
+      ) {
+        throw new AssertionError(
+          `expected ${show(actual)} to be after ${show(expected)}`
+        );
+      }
+    },
+  };
+}
+
+/**
+ * Hooks of a test file, by when they run
+ */
+export type TestHookKind =
+  | 'beforeAll'
+  | 'beforeEach'
+  | 'afterEach'
+  | 'afterAll';
+
+/**
+ * How a test case went
+ */
+export interface TestCaseResult {
+  name: string;
+  /** Milliseconds the test case took, with its hooks and fixtures */
+  duration: number;
+  error?: unknown;
+}
+
+type TestBody = () => Promise<void>;
+
+/**
+ * The test cases of a test file, with its hooks and fixtures
+ * Before each test case, the fixtures are set in order and the beforeEach
+ * hooks run; after it, the afterEach hooks run, whatever the fixtures
+ * created is cleaned up and the mock responses registered since are
+ * undone, so test cases do not see each other's state.
+ */
+export class TestSuite {
+  readonly results: TestCaseResult[] = [];
+  private readonly tests: { name: string; body: TestBody }[] = [];
+  private readonly hooks: Record<TestHookKind, TestBody[]> = {
+    beforeAll: [],
+    beforeEach: [],
+    afterEach: [],
+    afterAll: [],
+  };
+  private readonly fixtures: TestBody[] = [];
+  private cleanups: (() => Promise<void>)[] = [];
+
+  /**
+   * @param mocks Mock servers of the file, reset between test cases
+   * @param directory Directory fixture files are read from
+   */
+  constructor(
+    private readonly mocks?: MockServers,
+    readonly directory: string = '.'
+  ) {}
+
+  test(name: string, body: TestBody): void {
+    this.tests.push({ name, body });
+  }
+
+  fixture(setup: TestBody): void {
+    this.fixtures.push(setup);
+  }
+
+  beforeAll(body: TestBody): void {
+    this.hooks.beforeAll.push(body);
+  }
+
+  beforeEach(body: TestBody): void {
+    this.hooks.beforeEach.push(body);
+  }
+
+  afterEach(body: TestBody): void {
+    this.hooks.afterEach.push(body);
+  }
+
+  afterAll(body: TestBody): void {
+    this.hooks.afterAll.push(body);
+  }
+
+  /**
+   * Clean something up once the current test case has finished
+   */
+  onCleanup(cleanup: () => Promise<void>): void {
+    this.cleanups.push(cleanup);
+  }
+
+  /**
+   * Run the test cases in order, recording how each went
+   * A failing beforeAll hook fails every test case, or the file when it
+   * has none, and a failing afterAll hook fails the file.
+   */
+  async run(): Promise<void> {
+    let setupError: unknown;
+    try {
+      await this.runHooks('beforeAll');
+    } catch (error) {
+      setupError = error;
+    }
+    for (const { name, body } of this.tests) {
+      const started = Date.now();
+      const error = setupError ?? (await this.runTest(body));
+      this.results.push({
+        name,
+        duration: Date.now() - started,
+        ...(error !== undefined && { error }),
+      });
+    }
+    await this.runHooks('afterAll');
+    if (setupError !== undefined && this.tests.length === 0) {
+      throw setupError;
+    }
+  }
+
+  private async runHooks(kind: TestHookKind): Promise<void> {
+    for (const hook of this.hooks[kind]) {
+      await hook();
+    }
+  }
+
+  /**
+   * Run one test case, returning its error if it failed
+   */
+  private async runTest(body: TestBody): Promise<unknown> {
+    const restore = this.mocks?.save();
+    let failure: unknown;
+    try {
+      for (const setup of this.fixtures) {
+        await setup();
+      }
+      await this.runHooks('beforeEach');
+      await body();
+    } catch (error) {
+      failure = error;
+    }
+    try {
+      await this.runHooks('afterEach');
+    } catch (error) {
+      failure ??= error;
+    }
+    const cleanups = this.cleanups.reverse();
+    this.cleanups = [];
+    for (const cleanup of cleanups) {
+      await cleanup().catch(() => {});
+    }
+    restore?.();
+    return failure;
+  }
+}
+
+/**
+ * Test cases of a script run outside of mcps test, which fail the script
+ * once its top-level code has run
+ */
+class UnavailableTests extends TestSuite {
+  async run(): Promise<void> {
+    throw new Error('Test cases only run in tests run by mcps test');
+  }
+}
+
+/**
+ * Create the object test files register their test cases, hooks and
+ * fixtures with
+ */
+export function createTests(suite?: TestSuite): TestSuite {
+  return suite ?? new UnavailableTests();
+}
+
+/**
+ * Create the fixtures object of scripts, whose functions make values for
+ * fixture declarations; outside of tests they throw
+ */
+export function createFixtures(suite?: TestSuite) {
+  const using = (name: string): TestSuite => {
+    if (!suite) {
+      throw new Error(
+        `fixtures.${name}() is only available in tests run by mcps test`
+      );
+    }
+    return suite;
+  };
+  return {
+    /**
+     * An empty temporary directory, removed once the test case finishes
+     */
+    async tempDir(): Promise<string> {
+      const tests = using('tempDir');
+      const path = await mkdtemp(join(tmpdir(), 'mcps-test-'));
+      tests.onCleanup(() => rm(path, { recursive: true, force: true }));
+      return path;
+    },
+    /**
+     * Contents of a file next to the test, parsed when it is JSON
+     */
+    async load(path: string): Promise<unknown> {
+      const file = resolve(using('load').directory, path);
+      const text = await readFile(file, 'utf-8');
+      return file.endsWith('.json') ? JSON.parse(text) : text;
+    },
+  };
+}
@@ -0,0 +1,339 @@
+// Timelines of runs, from the spans of their traces
+//
+// A timeline shows when each tool call, model request and agent turn of a
+// run started and how long it took, so the time parallel workflows spend
+// waiting on a slow server or model can be seen at a glance. Spans that
+// overlap without one containing the other, such as the calls of parallel
+// branches, are laid out in lanes of their own; spans nest in the lane of
+// the span containing them. Timelines are written in the Chrome trace
+// event format, which chrome://tracing and Perfetto open, or as a
+// self-contained HTML page.
+import type { SpanData, TraceExporter } from './tracing.js';
+
+export type TimelineFormat = 'chrome' | 'html';
+
+/**
+ * A span placed on a timeline: its lane, and how deep it nests in the
+ * spans of that lane
+ */
+export interface TimelineBar {
+  span: SpanData;
+  lane: number;
+  depth: number;
+}
+
+/**
+ * The kind of a span, such as "tool_call" or "llm_call"
+ */
+function category(span: SpanData): string {
+  return span.name.replace(/^mcps\./, '');
+}
+
+/**
+ * What a span is shown as: the tool called, the model asked, and so on
+ */
+export function spanLabel(span: SpanData): string {
+  const attributes = span.attributes;
+  switch (span.name) {
+    case 'mcps.workflow':
+      return String(attributes['mcps.script'] ?? 'workflow');
+    case 'mcps.tool_call':
+      return attributes['mcp.server'] !== undefined
+        ? `${attributes['mcp.server']}.${attributes['mcp.tool']}`
+        : String(attributes['mcp.tool']);
+    case 'mcps.llm_call':
+      return String(attributes['mcps.model'] ?? attributes['mcps.provider']);
+    case 'mcps.agent':
+      return `Agent ${attributes['mcps.agent']}`;
+    case 'mcps.agent_turn':
+      return `${attributes['mcps.agent']} turn ${attributes['mcps.turn']}`;
+    case 'mcps.pipeline':
+      return `Pipeline ${attributes['mcps.pipeline']}`;
+    case 'mcps.pipeline_step':
+      return `${attributes['mcps.pipeline']} step ${attributes['mcps.step']}`;
+    default:
+      return category(span);
+  }
+}
+
+/**
+ * Place spans in lanes: a span goes into the first lane whose innermost
+ * open span contains it, or that has no open span, and into a new lane
+ * otherwise
+ */
+export function layoutTimeline(spans: SpanData[]): TimelineBar[] {
+  const byId = new Map(spans.map(span => [span.spanId, span]));
+  const contains = (outer: SpanData, span: SpanData): boolean => {
+    for (let id = span.parentSpanId; id; id = byId.get(id)?.parentSpanId) {
+      if (id === outer.spanId) {
+        return true;
+      }
+    }
+    return false;
+  };
+
+  const sorted = [...spans].sort(
+    (a, b) => a.startTime - b.startTime || b.endTime - a.endTime
+  );
+  // The open spans of each lane, innermost last
+  const lanes: SpanData[][] = [];
+  const bars: TimelineBar[] = [];
+  for (const span of sorted) {
+    let lane = lanes.findIndex(open => {
+      while (
+        open.length > 0 &&
+        open[open.length - 1].endTime <= span.startTime
+      ) {
+        open.pop();
+      }
+      return open.length === 0 || contains(open[open.length - 1], span);
+    });
+    if (lane < 0) {
+      lane = lanes.push([]) - 1;
+    }
+    bars.push({ span, lane, depth: lanes[lane].length });
+    lanes[lane].push(span);
+  }
+  return bars;
+}
+
+/**
+ * Encode spans in the Chrome trace event format, with times in
+ * microseconds since the first span started and one thread per lane
+ */
+export function encodeChromeTrace(spans: SpanData[], title = 'mcps'): object {
+  const start = Math.min(...spans.map(span => span.startTime));
+  const micros = (ms: number) => Math.round(ms * 1000);
+  const bars = layoutTimeline(spans);
+  const lanes = Math.max(0, ...bars.map(bar => bar.lane + 1));
+
+  const traceEvents: object[] = [
+    { name: 'process_name', ph: 'M', pid: 1, tid: 0, args: { name: title } },
+  ];
+  for (let lane = 0; lane < lanes; lane++) {
+    traceEvents.push({
+      name: 'thread_name',
+      ph: 'M',
+      pid: 1,
+      tid: lane,
+      args: { name: `lane ${lane + 1}` },
+    });
+  }
+  for (const { span, lane } of bars) {
+    traceEvents.push({
+      name: spanLabel(span),
+      cat: category(span),
+      ph: 'X',
+      ts: micros(span.startTime - start),
+      dur: micros(span.endTime - span.startTime),
+      pid: 1,
+      tid: lane,
+      args: {
+        ...span.attributes,
+        ...(span.status.code === 'error' && { error: span.status.message }),
+      },
+    });
+    for (const event of span.events) {
+      traceEvents.push({
+        name:
+          event.name === 'retry'
+            ? `retry ${event.attributes['mcps.retry']}`
+            : event.name,
+        cat: category(span),
+        ph: 'i',
+        s: 't',
+        ts: micros(event.time - start),
+        pid: 1,
+        tid: lane,
+        args: event.attributes,
+      });
+    }
+  }
+  return { traceEvents, displayTimeUnit: 'ms' };
+}
+
+function formatDuration(ms: number): string {
+  return ms < 1000 ? `${Math.round(ms)} ms` : `${(ms / 1000).toFixed(2)} s`;
+}
+
+function escapeHtml(text: string): string {
+  return text
+    .replace(/&/g, '&amp;')
+    .replace(/</g, '&lt;')
+    .replace(/>/g, '&gt;')
+    .replace(/"/g, '&quot;');
+}
+
+/** Height of a bar in the HTML timeline, in pixels */
+const BAR_HEIGHT = 22;
+
+const TIMELINE_STYLE = `
+body { font: 13px system-ui, sans-serif; margin: 24px; color: #1e293b; }
+h1 { font-size: 18px; margin: 0 0 4px; }
+.timeline { position: relative; margin: 16px 0; border-left: 1px solid #cbd5e1; }
+.axis { position: relative; height: 18px; color: #64748b; }
+.axis span { position: absolute; transform: translateX(-50%); }
+.lane { position: relative; border-bottom: 1px solid #f1f5f9; }
+.bar { position: absolute; height: ${BAR_HEIGHT - 4}px; margin-top: 2px; border-radius: 3px; overflow: hidden; white-space: nowrap; font-size: 11px; line-height: ${BAR_HEIGHT - 4}px; padding: 0 4px; box-sizing: border-box; min-width: 2px; color: #fff; }
+.workflow { background: #94a3b8; }
+.tool_call { background: #3b82f6; }
+.llm_call { background: #a855f7; }
+.agent { background: #d97706; }
+.agent_turn { background: #f59e0b; }
+.other { background: #64748b; }
+.error { outline: 2px solid #dc2626; }
+.retry { position: absolute; width: 2px; height: ${BAR_HEIGHT}px; background: #dc2626; }
+.legend span { display: inline-block; padding: 2px 6px; margin-right: 6px; border-radius: 3px; color: #fff; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px 2px 0; text-align: left; }
+td.number { text-align: right; }
+`;
+
+const KNOWN_CATEGORIES = [
+  'workflow',
+  'tool_call',
+  'llm_call',
+  'agent',
+  'agent_turn',
+];
+
+/**
+ * Render spans as a self-contained HTML page: a Gantt chart of the run,
+ * and the tools and models it spent the most time on
+ */
+export function renderTimelineHtml(spans: SpanData[], title = 'mcps'): string {
+  const start = Math.min(...spans.map(span => span.startTime));
+  const end = Math.max(...spans.map(span => span.endTime));
+  const total = Math.max(end - start, 1);
+  const percent = (ms: number) => `${((ms / total) * 100).toFixed(3)}%`;
+  const bars = layoutTimeline(spans);
+
+  const rows: string[] = [];
+  const lanes = Math.max(0, ...bars.map(bar => bar.lane + 1));
+  for (let lane = 0; lane < lanes; lane++) {
+    const inLane = bars.filter(bar => bar.lane === lane);
+    const depth = Math.max(...inLane.map(bar => bar.depth)) + 1;
+    const items = inLane.flatMap(({ span, depth }) => {
+      const kind = KNOWN_CATEGORIES.includes(category(span))
+        ? category(span)
+        : 'other';
+      const failed = span.status.code === 'error';
+      const label = spanLabel(span);
+      const duration = formatDuration(span.endTime - span.startTime);
+      const tooltip = [
+        `${label}: ${duration}`,
+        ...(failed ? [`failed: ${span.status.message}`] : []),
+      ].join('\n');
+      const top = depth * BAR_HEIGHT;
+      const bar =
+        `<div class="bar ${kind}${failed ? ' error' : ''}" ` +
+        `style="left:${percent(span.startTime - start)};` +
+        `width:${percent(span.endTime - span.startTime)};top:${top}px" ` +
+        `title="${escapeHtml(tooltip)}">${escapeHtml(label)}</div>`;
+      const retries = span.events
+        .filter(event => event.name === 'retry')
+        .map(
+          event =>
+            `<div class="retry" style="left:${percent(event.time - start)};top:${top}px" ` +
+            `title="${escapeHtml(`retry ${event.attributes['mcps.retry']}: ${event.attributes['exception.message']}`)}"></div>`
+        );
+      return [bar, ...retries];
+    });
+    rows.push(
+      `<div class="lane" style="height:${depth * BAR_HEIGHT}px">${items.join('')}</div>`
+    );
+  }
+
+  const ticks = [0, 0.25, 0.5, 0.75, 1].map(
+    fraction =>
+      `<span style="left:${fraction * 100}%">${formatDuration(fraction * total)}</span>`
+  );
+
+  // Where the time went: calls of each tool and requests to each model
+  const totals = new Map<
+    string,
+    { kind: string; calls: number; time: number; longest: number }
+  >();
+  for (const span of spans) {
+    if (span.name !== 'mcps.tool_call' && span.name !== 'mcps.llm_call') {
+      continue;
+    }
+    const label = spanLabel(span);
+    const duration = span.endTime - span.startTime;
+    const entry = totals.get(label) ?? {
+      kind: span.name === 'mcps.tool_call' ? 'tool' : 'model',
+      calls: 0,
+      time: 0,
+      longest: 0,
+    };
+    entry.calls++;
+    entry.time += duration;
+    entry.longest = Math.max(entry.longest, duration);
+    totals.set(label, entry);
+  }
+  const summary = [...totals]
+    .sort(([, a], [, b]) => b.time - a.time)
+    .map(
+      ([label, { kind, calls, time, longest }]) =>
+        `<tr><td>${escapeHtml(label)}</td><td>${kind}</td>` +
+        `<td class="number">${calls}</td>` +
+        `<td class="number">${formatDuration(time)}</td>` +
+        `<td class="number">${formatDuration(longest)}</td></tr>`
+    );
+
+  const legend = KNOWN_CATEGORIES.map(
+    kind => `<span class="${kind}">${kind.replace('_', ' ')}</span>`
+  );
+  return `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Timeline of ${escapeHtml(title)}</title>
+<style>${TIMELINE_STYLE}</style>
+</head>
+<body>
+<h1>Timeline of ${escapeHtml(title)}</h1>
+<p>${formatDuration(total)}, ${spans.length} spans in ${lanes} lanes</p>
+<div class="legend">${legend.join('')}</div>
+<div class="timeline">
+<div class="axis">${ticks.join('')}</div>
+${rows.join('\n')}
+</div>
+${
+  summary.length > 0
+    ? `<table>
+<tr><th>Tool or model</th><th>Kind</th><th>Calls</th><th>Total</th><th>Longest</th></tr>
+${summary.join('\n')}
+</table>`
+    : ''
+}
+</body>
+</html>
+`;
+}
+
+/**
+ * Writes the timeline of a run, each time spans are exported, with every
+ * span exported so far
+ */
+export class TimelineExporter implements TraceExporter {
+  private readonly spans: SpanData[] = [];
+
+  constructor(
+    private readonly format: TimelineFormat,
+    private readonly write: (content: string) => Promise<void>,
+    private readonly title = 'mcps'
+  ) {}
+
+  async export(spans: SpanData[]): Promise<void> {
+    if (spans.length === 0) {
+      return;
+    }
+    this.spans.push(...spans);
+    await this.write(
+      this.format === 'html'
+        ? renderTimelineHtml(this.spans, this.title)
+        : JSON.stringify(encodeChromeTrace(this.spans, this.title))
+    );
+  }
+}
@@ -0,0 +1,126 @@
+// JSON schemas of MCP tools, and checks of tool inputs against them
+
+/**
+ * The parts of JSON Schema that tool inputs are checked against
+ */
+export interface JSONSchema {
+  type?: string | string[];
+  properties?: Record<string, JSONSchema>;
+  required?: string[];
+  additionalProperties?: boolean | JSONSchema;
+  items?: JSONSchema;
+  description?: string;
+  [keyword: string]: unknown;
+}
+
+/**
+ * A tool as listed by an MCP server
+ */
+export interface ToolSchema {
+  name: string;
+  description?: string;
+  inputSchema: JSONSchema;
+}
+
+/**
+ * Error thrown when a script calls a tool with input its schema rejects
+ */
+export class ToolInputError extends Error {
+  constructor(
+    public readonly tool: string,
+    public readonly problems: string[]
+  ) {
+    super(`Invalid call to ${tool}: ${problems.join('; ')}`);
+    this.name = 'ToolInputError';
+  }
+}
+
+/**
+ * JSON Schema type of a value
+ */
+export function jsonType(value: unknown): string {
+  if (value === null) {
+    return 'null';
+  }
+  if (Array.isArray(value)) {
+    return 'array';
+  }
+  return typeof value;
+}
+
+/**
+ * Whether a value of a JSON type is accepted where the schema types are
+ */
+export function acceptsType(
+  schema: JSONSchema,
+  type: string,
+  isInteger = false
+): boolean {
+  if (schema.type === undefined) {
+    return true;
+  }
+  const types = Array.isArray(schema.type) ? schema.type : [schema.type];
+  return types.some(
+    expected =>
+      expected === type ||
+      (expected === 'integer' && type === 'number' && isInteger)
+  );
+}
+
+/**
+ * Names of a tool's parameters, in the order positional arguments fill them
+ */
+export function parameterNames(schema: JSONSchema): string[] {
+  return Object.keys(schema.properties ?? {});
+}
+
+function describeTypes(schema: JSONSchema): string {
+  const types = Array.isArray(schema.type) ? schema.type : [schema.type];
+  return types.join(' or ');
+}
+
+/**
+ * Problems with a tool input: missing required parameters, parameters
+ * the schema does not declare, and values of the wrong type
+ * Nested values are not checked; the server validates them
+ */
+export function checkToolInput(
+  schema: JSONSchema,
+  input: Record<string, unknown>
+): string[] {
+  const problems: string[] = [];
+  const properties = schema.properties;
+
+  for (const name of schema.required ?? []) {
+    if (input[name] === undefined) {
+      problems.push(`missing required parameter "${name}"`);
+    }
+  }
+
+  // Undeclared names are most likely typos, unless the schema allows them
+  const allowsOthers =
+    !properties ||
+    schema.additionalProperties === true ||
+    typeof schema.additionalProperties === 'object';
+  for (const [name, value] of Object.entries(input)) {
+    const property = properties?.[name];
+    if (!property) {
+      if (!allowsOthers) {
+        problems.push(`unknown parameter "${name}"`);
+      }
+      continue;
+    }
+    const isInteger = typeof value === 'number' && Number.isInteger(value);
+    if (
+      value !== undefined &&
+      !acceptsType(property, jsonType(value), isInteger)
+    ) {
+      problems.push(
+        `parameter "${name}" must be ${describeTypes(property)}, ` +
+          `got ${jsonType(value)}`
+      );
+    }
+  }
+
+  return problems;
+}
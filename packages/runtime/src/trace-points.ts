@@ -0,0 +1,86 @@
+// Trace points: the values of statements annotated with @trace
+//
+// A trace point records the value of its statement with its label and
+// place in the script, and hands the value on unchanged, so probing a
+// workflow never changes what it does. The value is added as an event to
+// the span running the statement when the run is traced, and logged. The
+// run's trace level decides which points record anything: "info" (the
+// default) the points at info level, "debug" every point and "off" none,
+// so trace points can stay in scripts that run in production.
+import { currentSpan } from './tracing.js';
+import { noRedaction, type Redactor } from './redaction.js';
+
+export type TraceLevel = 'off' | 'info' | 'debug';
+
+export const TRACE_LEVELS: readonly TraceLevel[] = ['off', 'info', 'debug'];
+
+/**
+ * A trace point, as the code generated for @trace passes it
+ */
+export interface TracePoint {
+  label: string;
+  level: Exclude<TraceLevel, 'off'>;
+  /** Place of the traced statement in the script (1-based) */
+  line: number;
+  column: number;
+}
+
+export interface TracePointOptions {
+  /** Trace level of the run (default: info) */
+  level?: TraceLevel;
+  /** Script file named in the recorded places */
+  sourceFile?: string;
+  /** Masks sensitive data in the recorded values */
+  redactor?: Redactor;
+  /** Writes a log line (default: console.info) */
+  write?: (line: string) => void;
+}
+
+// Values longer than this are cut short in events and logs
+const MAX_VALUE_LENGTH = 1000;
+
+function formatValue(value: unknown): string {
+  let text: string;
+  try {
+    text =
+      typeof value === 'string'
+        ? JSON.stringify(value)
+        : (JSON.stringify(value) ?? String(value));
+  } catch {
+    text = String(value);
+  }
+  return text.length > MAX_VALUE_LENGTH
+    ? `${text.slice(0, MAX_VALUE_LENGTH - 3)}...`
+    : text;
+}
+
+/**
+ * Create the __trace() function of a run, which records the value of a
+ * trace point its level shows and returns the value
+ */
+export function createTracePoints(options: TracePointOptions = {}) {
+  const {
+    level = 'info',
+    sourceFile,
+    redactor = noRedaction,
+    write = (line: string) => console.info(line),
+  } = options;
+  const shown = TRACE_LEVELS.slice(1, TRACE_LEVELS.indexOf(level) + 1);
+
+  return function trace<T>(value: T, point: TracePoint): T {
+    if (!shown.includes(point.level)) {
+      return value;
+    }
+    const text = formatValue(redactor.redact(value));
+    const place = `${sourceFile ?? '<script>'}:${point.line}:${point.column}`;
+    currentSpan()?.addEvent('mcps.trace_point', {
+      'mcps.trace_point.label': point.label,
+      'mcps.trace_point.value': text,
+      'code.filepath': sourceFile ?? '<script>',
+      'code.lineno': point.line,
+      'code.column': point.column,
+    });
+    write(`[TRACE] ${place} ${point.label} = ${text}`);
+    return value;
+  };
+}
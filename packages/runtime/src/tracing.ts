@@ -0,0 +1,633 @@
+// Tracing and metrics of workflow execution, in the OpenTelemetry model
+//
+// A run of a script is one trace: a span for the workflow, with child
+// spans for each MCP tool call, agent turn and model request. The active
+// span follows the chain of async calls, so calls made from parallel
+// branches are parented correctly. Without a tracer nothing is recorded
+// and the hooks cost one lookup. Finished spans and counters are sent to
+// an exporter: as OTLP/HTTP JSON to a collector, as JSON lines for reading
+// locally, or as a timeline of the run (see timeline.ts).
+import { AsyncLocalStorage } from 'async_hooks';
+import { randomBytes } from 'crypto';
+import { performance } from 'perf_hooks';
+import { checkOffline } from './offline.js';
+import { noRedaction, type Redactor } from './redaction.js';
+
+export type AttributeValue = string | number | boolean;
+export type Attributes = Record<string, AttributeValue>;
+
+/**
+ * A finished span (times are milliseconds since the epoch)
+ */
+export interface SpanData {
+  traceId: string;
+  spanId: string;
+  parentSpanId?: string;
+  name: string;
+  startTime: number;
+  endTime: number;
+  attributes: Attributes;
+  events: { name: string; time: number; attributes: Attributes }[];
+  status: { code: 'ok' | 'error'; message?: string };
+}
+
+/**
+ * The total of a counter for one set of attributes
+ */
+export interface CounterData {
+  name: string;
+  attributes: Attributes;
+  value: number;
+  /** When the counter started counting, in milliseconds since the epoch */
+  startTime: number;
+}
+
+/**
+ * Receives finished spans, and the totals of all counters so far
+ */
+export interface TraceExporter {
+  export(spans: SpanData[], counters: CounterData[]): Promise<void>;
+}
+
+export interface TracerOptions {
+  /** service.name of the resource spans are reported for (default: mcps) */
+  serviceName?: string;
+  /** Finished spans kept before they are exported (default: 512) */
+  batchSize?: number;
+  /** Called with each span as it ends, ahead of its export */
+  onSpan?: (span: SpanData) => void;
+}
+
+const activeSpans = new AsyncLocalStorage<Span>();
+
+/** Span attributes that counters are broken down by */
+const COUNTER_ATTRIBUTES = [
+  'mcp.server',
+  'mcp.tool',
+  'mcps.agent',
+  'mcps.model',
+];
+
+function counterAttributes(name: string, attributes: Attributes): Attributes {
+  const picked: Attributes = { 'mcps.span': name };
+  for (const key of COUNTER_ATTRIBUTES) {
+    if (key in attributes) {
+      picked[key] = attributes[key];
+    }
+  }
+  return picked;
+}
+
+function now(): number {
+  return performance.timeOrigin + performance.now();
+}
+
+function randomId(bytes: number): string {
+  return randomBytes(bytes).toString('hex');
+}
+
+/**
+ * A span in progress
+ */
+export class Span {
+  readonly spanId = randomId(8);
+  private readonly startTime = now();
+  private readonly events: SpanData['events'] = [];
+  private status: SpanData['status'] = { code: 'ok' };
+  private ended = false;
+
+  constructor(
+    readonly tracer: Tracer,
+    readonly traceId: string,
+    readonly name: string,
+    readonly attributes: Attributes,
+    private readonly parentSpanId?: string
+  ) {}
+
+  setAttributes(attributes: Attributes): void {
+    Object.assign(this.attributes, attributes);
+  }
+
+  addEvent(name: string, attributes: Attributes = {}): void {
+    this.events.push({ name, time: now(), attributes });
+  }
+
+  /**
+   * Mark the span as failed with an error
+   */
+  recordError(error: unknown): void {
+    const message = error instanceof Error ? error.message : String(error);
+    this.status = { code: 'error', message };
+    this.attributes['error.type'] =
+      error instanceof Error ? error.name : typeof error;
+  }
+
+  end(): void {
+    if (this.ended) {
+      return;
+    }
+    this.ended = true;
+    const endTime = now();
+    this.attributes['mcps.duration_ms'] =
+      Math.round((endTime - this.startTime) * 1000) / 1000;
+    this.tracer.finish({
+      traceId: this.traceId,
+      spanId: this.spanId,
+      parentSpanId: this.parentSpanId,
+      name: this.name,
+      startTime: this.startTime,
+      endTime,
+      attributes: this.attributes,
+      events: this.events,
+      status: this.status,
+    });
+  }
+}
+
+/**
+ * Records spans and counters and hands them to an exporter in batches
+ */
+export class Tracer {
+  readonly serviceName: string;
+  private readonly batchSize: number;
+  private readonly onSpan?: (span: SpanData) => void;
+  private spans: SpanData[] = [];
+  private readonly counters = new Map<string, CounterData>();
+  private exporting: Promise<void> = Promise.resolve();
+  private redactor: Redactor = noRedaction;
+
+  constructor(
+    private readonly exporter: TraceExporter,
+    options: TracerOptions = {}
+  ) {
+    this.serviceName = options.serviceName ?? 'mcps';
+    this.batchSize = options.batchSize ?? 512;
+    this.onSpan = options.onSpan;
+  }
+
+  /**
+   * Run a function in a new trace, as its root span
+   */
+  trace<T>(
+    name: string,
+    attributes: Attributes,
+    run: (span: Span) => Promise<T>
+  ): Promise<T> {
+    const span = new Span(this, randomId(16), name, { ...attributes });
+    return runInSpan(span, run);
+  }
+
+  /**
+   * Add to a counter
+   */
+  count(name: string, attributes: Attributes = {}, value = 1): void {
+    const key = JSON.stringify([name, attributes]);
+    const counter = this.counters.get(key);
+    if (counter) {
+      counter.value += value;
+    } else {
+      this.counters.set(key, { name, attributes, value, startTime: now() });
+    }
+  }
+
+  /**
+   * Mask sensitive data in the attributes, events and errors of spans that
+   * end from now on; executeInVM passes the redactor of its run
+   */
+  redactWith(redactor: Redactor): void {
+    this.redactor = redactor;
+  }
+
+  /** @internal Called by spans as they end */
+  finish(span: SpanData): void {
+    if (this.redactor.enabled) {
+      span = this.redactSpan(span);
+    }
+    this.onSpan?.(span);
+    this.spans.push(span);
+    if (span.status.code === 'error') {
+      const attributes = counterAttributes(span.name, span.attributes);
+      this.count('mcps.failures', attributes);
+    }
+    if (this.spans.length >= this.batchSize) {
+      void this.flush();
+    }
+  }
+
+  private redactSpan(span: SpanData): SpanData {
+    const { redactor } = this;
+    const { message } = span.status;
+    const attributes = (values: Attributes): Attributes =>
+      Object.fromEntries(
+        Object.entries(values).map(([key, value]) => [
+          key,
+          typeof value === 'string' ? redactor.redactText(value) : value,
+        ])
+      );
+    return {
+      ...span,
+      attributes: attributes(span.attributes),
+      events: span.events.map(event => ({
+        ...event,
+        attributes: attributes(event.attributes),
+      })),
+      status:
+        message === undefined
+          ? span.status
+          : { ...span.status, message: redactor.redactText(message) },
+    };
+  }
+
+  /**
+   * Export the spans finished so far and the counter totals
+   * Export failures are reported as warnings and never fail the script
+   */
+  flush(): Promise<void> {
+    const spans = this.spans;
+    this.spans = [];
+    const counters = [...this.counters.values()].map(c => ({ ...c }));
+    this.exporting = this.exporting.then(() =>
+      this.exporter.export(spans, counters).catch((error: unknown) => {
+        const message = error instanceof Error ? error.message : String(error);
+        console.warn(`[WARN] Failed to export traces: ${message}`);
+      })
+    );
+    return this.exporting;
+  }
+}
+
+async function runInSpan<T>(
+  span: Span,
+  run: (span: Span) => Promise<T>
+): Promise<T> {
+  try {
+    return await activeSpans.run(span, () => run(span));
+  } catch (error) {
+    span.recordError(error);
+    throw error;
+  } finally {
+    span.end();
+  }
+}
+
+/**
+ * The span of the code running now, if it is being traced
+ */
+export function currentSpan(): Span | undefined {
+  return activeSpans.getStore();
+}
+
+/**
+ * Run a function in a child span of the current one, or just run it when
+ * nothing is being traced
+ */
+export function traced<T>(
+  name: string,
+  attributes: Attributes,
+  run: (span?: Span) => Promise<T>
+): Promise<T> {
+  const parent = currentSpan();
+  if (!parent) {
+    return run();
+  }
+  const { tracer, traceId, spanId } = parent;
+  return runInSpan(
+    new Span(tracer, traceId, name, { ...attributes }, spanId),
+    run
+  );
+}
+
+/**
+ * Record a retry of the call traced by the current span
+ */
+export function recordRetry(retry: number, error: unknown): void {
+  const span = currentSpan();
+  if (!span) {
+    return;
+  }
+  span.addEvent('retry', {
+    'mcps.retry': retry,
+    'exception.message':
+      error instanceof Error ? error.message : String(error),
+  });
+  const attributes = counterAttributes(span.name, span.attributes);
+  span.tracer.count('mcps.retries', attributes);
+}
+
+/**
+ * Trace the requests a model sends to its provider as mcps.llm_call spans
+ * of the span current when they are sent; streamed replies are traced
+ * until their stream ends
+ */
+export function traceModelCalls(
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  llm: { chat(params: any): Promise<any> },
+  attributes: Attributes
+): void {
+  if (typeof llm.chat !== 'function') {
+    return;
+  }
+  const chat = llm.chat.bind(llm);
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  llm.chat = async (params: any) => {
+    const parent = currentSpan();
+    if (!parent) {
+      return chat(params);
+    }
+    const { tracer, traceId, spanId } = parent;
+    const span = new Span(
+      tracer,
+      traceId,
+      'mcps.llm_call',
+      { ...attributes },
+      spanId
+    );
+    let reply;
+    try {
+      reply = await chat(params);
+    } catch (error) {
+      span.recordError(error);
+      span.end();
+      throw error;
+    }
+    if (!params?.stream) {
+      span.end();
+      return reply;
+    }
+    return (async function* () {
+      try {
+        yield* reply;
+      } catch (error) {
+        span.recordError(error);
+        throw error;
+      } finally {
+        span.end();
+      }
+    })();
+  };
+}
+
+/**
+ * Exporter configuration of `mcps run --trace` and other runtime users
+ */
+export interface TracingConfig {
+  /**
+   * "otlp" sends OTLP/HTTP JSON to a collector; "console" writes one JSON
+   * line per span and counter to stderr
+   */
+  exporter?: 'otlp' | 'console';
+  /** More exporters sent the same spans, such as a TimelineExporter */
+  exporters?: TraceExporter[];
+  /**
+   * Base URL of the collector (default: OTEL_EXPORTER_OTLP_ENDPOINT, or
+   * http://localhost:4318); /v1/traces and /v1/metrics are appended
+   */
+  endpoint?: string;
+  /**
+   * HTTP headers sent to the collector (default:
+   * OTEL_EXPORTER_OTLP_HEADERS)
+   */
+  headers?: Record<string, string>;
+  /** Service name (default: OTEL_SERVICE_NAME, or mcps) */
+  serviceName?: string;
+  /**
+   * Refuse collectors that are not on this machine, with an OfflineError
+   */
+  offline?: boolean;
+}
+
+/**
+ * Parse headers in the OTEL_EXPORTER_OTLP_HEADERS form: key=value pairs
+ * separated by commas, with URL-encoded values
+ */
+export function parseOtlpHeaders(value: string): Record<string, string> {
+  const headers: Record<string, string> = {};
+  for (const pair of value.split(',')) {
+    const separator = pair.indexOf('=');
+    if (separator > 0) {
+      headers[pair.slice(0, separator).trim()] = decodeURIComponent(
+        pair.slice(separator + 1).trim()
+      );
+    }
+  }
+  return headers;
+}
+
+/**
+ * Create a tracer exporting as configured, filling in what the
+ * configuration leaves out from the standard OTEL_* environment variables
+ */
+export function createTracer(config: TracingConfig): Tracer {
+  const serviceName =
+    config.serviceName ?? process.env.OTEL_SERVICE_NAME ?? 'mcps';
+  const exporters = [...(config.exporters ?? [])];
+  if (config.exporter === 'console') {
+    exporters.unshift(new ConsoleTraceExporter());
+  } else if (config.exporter === 'otlp') {
+    const envHeaders = process.env.OTEL_EXPORTER_OTLP_HEADERS;
+    const endpoint =
+      config.endpoint ??
+      process.env.OTEL_EXPORTER_OTLP_ENDPOINT ??
+      'http://localhost:4318';
+    if (config.offline) {
+      checkOffline('exporting traces', endpoint);
+    }
+    exporters.unshift(
+      new OtlpHttpExporter({
+        endpoint,
+        headers:
+          config.headers ?? (envHeaders ? parseOtlpHeaders(envHeaders) : {}),
+        serviceName,
+      })
+    );
+  }
+  const exporter: TraceExporter =
+    exporters.length === 1
+      ? exporters[0]
+      : {
+          async export(spans, counters) {
+            await Promise.all(exporters.map(e => e.export(spans, counters)));
+          },
+        };
+  return new Tracer(exporter, { serviceName });
+}
+
+/**
+ * Writes spans and counters as JSON lines
+ */
+export class ConsoleTraceExporter implements TraceExporter {
+  constructor(
+    private readonly write: (line: string) => void = line =>
+      process.stderr.write(`${line}\n`)
+  ) {}
+
+  async export(spans: SpanData[], counters: CounterData[]): Promise<void> {
+    for (const span of spans) {
+      this.write(JSON.stringify({ type: 'span', ...span }));
+    }
+    for (const counter of counters) {
+      this.write(JSON.stringify({ type: 'counter', ...counter }));
+    }
+  }
+}
+
+type OtlpValue =
+  | { stringValue: string }
+  | { intValue: string }
+  | { doubleValue: number }
+  | { boolValue: boolean };
+
+function otlpAttributes(
+  attributes: Attributes
+): { key: string; value: OtlpValue }[] {
+  return Object.entries(attributes).map(([key, value]) => ({
+    key,
+    value:
+      typeof value === 'string'
+        ? { stringValue: value }
+        : typeof value === 'boolean'
+          ? { boolValue: value }
+          : Number.isInteger(value)
+            ? { intValue: String(value) }
+            : { doubleValue: value },
+  }));
+}
+
+/** Milliseconds since the epoch as the decimal nanoseconds OTLP uses */
+function unixNano(ms: number): string {
+  return (BigInt(Math.round(ms * 1000)) * 1000n).toString();
+}
+
+const SPAN_KIND_INTERNAL = 1;
+const STATUS_CODE_OK = 1;
+const STATUS_CODE_ERROR = 2;
+const AGGREGATION_TEMPORALITY_CUMULATIVE = 2;
+
+/**
+ * Encode spans as an OTLP/JSON ExportTraceServiceRequest
+ */
+export function encodeOtlpTraces(
+  spans: SpanData[],
+  serviceName: string
+): object {
+  return {
+    resourceSpans: [
+      {
+        resource: otlpResource(serviceName),
+        scopeSpans: [
+          {
+            scope: { name: '@mcpscript/runtime' },
+            spans: spans.map(span => ({
+              traceId: span.traceId,
+              spanId: span.spanId,
+              ...(span.parentSpanId && { parentSpanId: span.parentSpanId }),
+              name: span.name,
+              kind: SPAN_KIND_INTERNAL,
+              startTimeUnixNano: unixNano(span.startTime),
+              endTimeUnixNano: unixNano(span.endTime),
+              attributes: otlpAttributes(span.attributes),
+              events: span.events.map(event => ({
+                name: event.name,
+                timeUnixNano: unixNano(event.time),
+                attributes: otlpAttributes(event.attributes),
+              })),
+              status:
+                span.status.code === 'error'
+                  ? { code: STATUS_CODE_ERROR, message: span.status.message }
+                  : { code: STATUS_CODE_OK },
+            })),
+          },
+        ],
+      },
+    ],
+  };
+}
+
+/**
+ * Encode counters as an OTLP/JSON ExportMetricsServiceRequest of
+ * cumulative, monotonic sums
+ */
+export function encodeOtlpMetrics(
+  counters: CounterData[],
+  serviceName: string
+): object {
+  const byName = new Map<string, CounterData[]>();
+  for (const counter of counters) {
+    byName.set(counter.name, [...(byName.get(counter.name) ?? []), counter]);
+  }
+  const time = unixNano(now());
+  return {
+    resourceMetrics: [
+      {
+        resource: otlpResource(serviceName),
+        scopeMetrics: [
+          {
+            scope: { name: '@mcpscript/runtime' },
+            metrics: [...byName].map(([name, points]) => ({
+              name,
+              sum: {
+                dataPoints: points.map(point => ({
+                  attributes: otlpAttributes(point.attributes),
+                  startTimeUnixNano: unixNano(point.startTime),
+                  timeUnixNano: time,
+                  asInt: String(point.value),
+                })),
+                aggregationTemporality: AGGREGATION_TEMPORALITY_CUMULATIVE,
+                isMonotonic: true,
+              },
+            })),
+          },
+        ],
+      },
+    ],
+  };
+}
+
+function otlpResource(serviceName: string): object {
+  return { attributes: otlpAttributes({ 'service.name': serviceName }) };
+}
+
+/**
+ * Sends spans and counters to an OpenTelemetry collector over OTLP/HTTP
+ * with JSON encoding
+ */
+export class OtlpHttpExporter implements TraceExporter {
+  private readonly endpoint: string;
+
+  constructor(
+    private readonly options: {
+      endpoint: string;
+      headers?: Record<string, string>;
+      serviceName: string;
+      /** fetch implementation to send with (default: the global one) */
+      fetch?: typeof fetch;
+    }
+  ) {
+    this.endpoint = options.endpoint.replace(/\/+$/, '');
+  }
+
+  async export(spans: SpanData[], counters: CounterData[]): Promise<void> {
+    const { serviceName } = this.options;
+    if (spans.length > 0) {
+      await this.post('/v1/traces', encodeOtlpTraces(spans, serviceName));
+    }
+    if (counters.length > 0) {
+      const metrics = encodeOtlpMetrics(counters, serviceName);
+      await this.post('/v1/metrics', metrics);
+    }
+  }
+
+  private async post(path: string, body: object): Promise<void> {
+    const send = this.options.fetch ?? fetch;
+    const response = await send(`${this.endpoint}${path}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', ...this.options.headers },
+      body: JSON.stringify(body),
+    });
+    if (!response.ok) {
+      throw new Error(
+        `${this.endpoint}${path} answered ${response.status} ${response.statusText}`
+      );
+    }
+  }
+}
@@ -0,0 +1,308 @@
+// Transcripts of agent runs
+//
+// Every message of an agent run, including the tool calls in its replies
+// and their results, is recorded as it happens, and so is every call sent to
+// an MCP server by the script or its agents. A transcript is a sequence
+// of events, one JSON object per line, so a run that crashes still leaves
+// everything up to the crash behind; parseTranscript() puts the events back
+// together into the runs of each agent, which can be shown or replayed.
+import type { ChatMessage } from '@llamaindex/core/llms';
+import { noRedaction, type Redactor } from './redaction.js';
+
+/**
+ * An event of a transcript
+ */
+export type TranscriptEvent =
+  | {
+      type: 'script';
+      /** Path of the script the transcript is of */
+      file: string;
+      time: number;
+      /** Agent run and message the run was replayed from */
+      replayOf?: ReplayPoint;
+    }
+  | {
+      type: 'run_start';
+      /** Number of the agent run within the transcript, from 1 */
+      run: number;
+      agent: string;
+      /** Run of the agent that delegated to this one */
+      parent?: number;
+      systemPrompt?: string;
+      time: number;
+    }
+  | { type: 'message'; run: number; message: ChatMessage }
+  | { type: 'run_end'; run: number; time: number; error?: string }
+  | ({ type: 'tool_call' } & ToolCallRecord);
+
+/**
+ * A call sent to an MCP server, with the server's answer or the error the
+ * call failed with
+ */
+export interface ToolCallRecord {
+  /** Tool as "server.tool" */
+  tool: string;
+  input: unknown;
+  /** The result as the server sent it */
+  result?: unknown;
+  error?: string;
+  time: number;
+}
+
+/**
+ * A message of a transcript to replay an agent from: the agent run is
+ * given the messages up to and including it
+ */
+export interface ReplayPoint {
+  /** Transcript id or path */
+  transcript: string;
+  run: number;
+  /** Number of the message within the run, from 1 */
+  message: number;
+}
+
+/**
+ * Records the agent runs of a script as transcript events
+ */
+export class TranscriptRecorder {
+  private nextRun = 1;
+  private redactor: Redactor = noRedaction;
+
+  /**
+   * @param write Called with each event as it happens, e.g. to append it
+   *   to a file
+   */
+  constructor(private readonly write: (event: TranscriptEvent) => void) {}
+
+  /**
+   * Mask sensitive data in the messages recorded from now on;
+   * executeInVM passes the redactor of its run
+   */
+  redactWith(redactor: Redactor): void {
+    this.redactor = redactor;
+  }
+
+  /**
+   * Record the start of an agent run and return its number
+   */
+  start(agent: string, parent?: number, systemPrompt?: string): number {
+    const run = this.nextRun++;
+    this.write({
+      type: 'run_start',
+      run,
+      agent,
+      ...(parent !== undefined && { parent }),
+      ...(systemPrompt !== undefined && { systemPrompt }),
+      time: Date.now(),
+    });
+    return run;
+  }
+
+  message(run: number, message: ChatMessage): void {
+    this.write({
+      type: 'message',
+      run,
+      message: this.redactor.enabled
+        ? (this.redactor.redact(message) as ChatMessage)
+        : message,
+    });
+  }
+
+  /**
+   * Record a call of an MCP tool once it is answered or fails
+   */
+  toolCall(
+    tool: string,
+    input: unknown,
+    answer: { result: unknown } | { error: unknown }
+  ): void {
+    const { redactor } = this;
+    const [server, name] = tool.includes('.') ? tool.split('.', 2) : ['', tool];
+    this.write({
+      type: 'tool_call',
+      tool,
+      input: redactor.redactToolArguments(server, name, input),
+      ...('error' in answer
+        ? {
+            error: redactor.redactText(
+              answer.error instanceof Error
+                ? answer.error.message
+                : String(answer.error)
+            ),
+          }
+        : { result: redactor.redact(answer.result) }),
+      time: Date.now(),
+    });
+  }
+
+  end(run: number, error?: unknown): void {
+    this.write({
+      type: 'run_end',
+      run,
+      time: Date.now(),
+      ...(error !== undefined && {
+        error: this.redactor.redactText(
+          error instanceof Error ? error.message : String(error)
+        ),
+      }),
+    });
+  }
+}
+
+/**
+ * The tools of an MCP server with each call recorded, for the server's tool
+ * proxy; calls of the script and of agents go through these alike
+ */
+export function recordToolCalls(
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  tools: Array<any>,
+  serverName: string | undefined,
+  recorder: TranscriptRecorder
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+): Array<any> {
+  return tools.map(tool => {
+    const label = serverName
+      ? `${serverName}.${tool.metadata.name}`
+      : tool.metadata.name;
+    return {
+      metadata: tool.metadata,
+      call: async (input: unknown) => {
+        let result;
+        try {
+          result = await tool.call(input);
+        } catch (error) {
+          recorder.toolCall(label, input, { error });
+          throw error;
+        }
+        recorder.toolCall(label, input, { result });
+        return result;
+      },
+    };
+  });
+}
+
+/**
+ * One run of an agent, put together from transcript events
+ */
+export interface AgentRunRecord {
+  run: number;
+  agent: string;
+  parent?: number;
+  systemPrompt?: string;
+  /** Messages of the conversation, without the system prompt */
+  messages: ChatMessage[];
+  startedAt: number;
+  /** Missing when the run did not finish, e.g. because the script crashed */
+  finishedAt?: number;
+  error?: string;
+}
+
+export interface Transcript {
+  file?: string;
+  startedAt?: number;
+  replayOf?: ReplayPoint;
+  /** Agent runs in the order they started */
+  runs: AgentRunRecord[];
+  /** MCP tool calls in the order they were answered */
+  toolCalls: ToolCallRecord[];
+}
+
+/**
+ * Error thrown for transcripts that cannot be read
+ */
+export class TranscriptError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'TranscriptError';
+  }
+}
+
+/**
+ * Put the events of a transcript, one JSON object per line, together
+ */
+export function parseTranscript(text: string): Transcript {
+  const transcript: Transcript = { runs: [], toolCalls: [] };
+  const runs = new Map<number, AgentRunRecord>();
+  const lines = text.split('\n');
+  lines.forEach((line, index) => {
+    if (!line.trim()) {
+      return;
+    }
+    let event: TranscriptEvent;
+    try {
+      event = JSON.parse(line) as TranscriptEvent;
+    } catch {
+      // Only the last line can be cut short, by a crash while writing it
+      if (index >= lines.length - 2) {
+        return;
+      }
+      throw new TranscriptError(`Line ${index + 1} is not valid JSON`);
+    }
+
+    if (event.type === 'script') {
+      transcript.file = event.file;
+      transcript.startedAt = event.time;
+      transcript.replayOf = event.replayOf;
+      return;
+    }
+    if (event.type === 'run_start') {
+      const record: AgentRunRecord = {
+        run: event.run,
+        agent: event.agent,
+        parent: event.parent,
+        systemPrompt: event.systemPrompt,
+        messages: [],
+        startedAt: event.time,
+      };
+      runs.set(event.run, record);
+      transcript.runs.push(record);
+      return;
+    }
+    if (event.type === 'tool_call') {
+      const { type: _type, ...call } = event;
+      transcript.toolCalls.push(call);
+      return;
+    }
+    const record = runs.get(event.run);
+    if (!record) {
+      throw new TranscriptError(
+        `Line ${index + 1} belongs to agent run ${event.run}, which never started`
+      );
+    }
+    if (event.type === 'message') {
+      record.messages.push(event.message);
+    } else {
+      record.finishedAt = event.time;
+      record.error = event.error;
+    }
+  });
+  return transcript;
+}
+
+/**
+ * The messages an agent run is replayed with from a point of its
+ * transcript
+ */
+export function replayMessages(
+  transcript: Transcript,
+  run: number,
+  message: number
+): { agent: string; messages: ChatMessage[] } {
+  const record = transcript.runs.find(r => r.run === run);
+  if (!record) {
+    throw new TranscriptError(`The transcript has no agent run ${run}`);
+  }
+  if (
+    !Number.isInteger(message) ||
+    message < 1 ||
+    message > record.messages.length
+  ) {
+    throw new TranscriptError(
+      `Agent run ${run} (${record.agent}) has messages 1 to ${record.messages.length}, not ${message}`
+    );
+  }
+  return {
+    agent: record.agent,
+    messages: record.messages.slice(0, message),
+  };
+}
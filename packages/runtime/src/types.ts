@@ -22,5 +22,30 @@ export interface UserInputRequest {
 
 export interface AppState {
   messages: AppMessage[];
+  /** Message still being streamed, shown after the finished ones */
+  streaming?: AppMessage;
+  /** How far a parallel map that is running has got */
+  progress?: AppMessage;
   userInput?: UserInputRequest;
 }
+
+/**
+ * A trigger declared by a script, registered once the script has run
+ */
+export interface ScriptTrigger {
+  name: string;
+  /** Cron expression, or null for a trigger that only fires on request */
+  schedule: string | null;
+  /** Evaluate the trigger's action in the script's context */
+  fire: () => Promise<unknown>;
+}
+
+/**
+ * An event handler declared by a script with `on event("<name>")`
+ */
+export interface ScriptEventHandler {
+  /** Name of the events it handles */
+  event: string;
+  /** Run the handler's body in the script's context with a payload */
+  handle: (payload: unknown) => Promise<unknown>;
+}
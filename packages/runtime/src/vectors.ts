@@ -0,0 +1,377 @@
+// Embeddings and an in-process vector index
+//
+// Retrieval-augmented workflows embed their documents, keep the vectors
+// and look up those closest to a question. For small datasets, embed() and
+// the `vectors` index do this within the run, with no vector database
+// server: a collection is searched by comparing the query with each of its
+// vectors, and the host saves it as a whole after each change, so the
+// index outlives the run (the CLI keeps collections as files of the
+// project).
+import type {
+  ModelConfig,
+  ModelProviderRegistry,
+  TextEmbedder,
+} from './providers.js';
+import { modelConfigOf } from './routing.js';
+
+/** Collection the methods of `vectors` itself use */
+export const DEFAULT_COLLECTION = 'default';
+/** Matches a query returns unless asked for another number */
+export const DEFAULT_QUERY_LIMIT = 5;
+
+/**
+ * A vector of a collection, with the text it was embedded from and the
+ * metadata it was stored with
+ */
+export interface VectorRecord {
+  id: string;
+  vector: number[];
+  text?: string;
+  metadata?: Record<string, unknown>;
+}
+
+/**
+ * What scripts upsert: a text to embed, or a vector they embedded
+ * themselves, e.g. with another model
+ */
+export interface VectorItem {
+  id: string;
+  text?: string;
+  vector?: number[];
+  metadata?: Record<string, unknown>;
+}
+
+/**
+ * A record found by a query, with the cosine similarity of its vector to
+ * the query's, from -1 to 1
+ */
+export interface VectorMatch {
+  id: string;
+  score: number;
+  text?: string;
+  metadata?: Record<string, unknown>;
+}
+
+export interface VectorQueryOptions {
+  /** Number of matches (default: 5) */
+  k?: number;
+  /** Metadata records must have, field by field, to match */
+  filter?: Record<string, unknown>;
+  /** Lowest score of matches */
+  minScore?: number;
+}
+
+/**
+ * Where the collections of the vector index are kept, by name
+ */
+export interface VectorStore {
+  load(collection: string): Promise<VectorRecord[] | undefined>;
+  save(collection: string, records: VectorRecord[]): Promise<void>;
+}
+
+/**
+ * Keeps collections in memory, for tests and hosts that keep no index
+ * between runs
+ */
+export class MemoryVectorStore implements VectorStore {
+  private readonly collections = new Map<string, VectorRecord[]>();
+
+  async load(collection: string): Promise<VectorRecord[] | undefined> {
+    return this.collections.get(collection);
+  }
+
+  async save(collection: string, records: VectorRecord[]): Promise<void> {
+    this.collections.set(collection, records);
+  }
+}
+
+export type Embed = (texts: string[]) => Promise<number[][]>;
+
+/**
+ * Cosine similarity of two vectors of the same length; 0 when either is
+ * all zeros
+ */
+export function cosineSimilarity(a: number[], b: number[]): number {
+  let dot = 0;
+  let normA = 0;
+  let normB = 0;
+  for (let i = 0; i < a.length; i++) {
+    dot += a[i] * b[i];
+    normA += a[i] * a[i];
+    normB += b[i] * b[i];
+  }
+  return normA === 0 || normB === 0 ? 0 : dot / Math.sqrt(normA * normB);
+}
+
+function isVector(value: unknown): value is number[] {
+  return (
+    Array.isArray(value) &&
+    value.length > 0 &&
+    value.every(n => typeof n === 'number' && Number.isFinite(n))
+  );
+}
+
+function isPlainObject(value: unknown): value is Record<string, unknown> {
+  return typeof value === 'object' && value !== null && !Array.isArray(value);
+}
+
+/**
+ * A named set of vectors, loaded from the store when first used
+ */
+export class VectorCollection {
+  private records?: Map<string, VectorRecord>;
+
+  constructor(
+    readonly name: string,
+    private readonly embed: Embed,
+    private readonly store: VectorStore
+  ) {}
+
+  private async load(): Promise<Map<string, VectorRecord>> {
+    if (!this.records) {
+      const records = (await this.store.load(this.name)) ?? [];
+      this.records = new Map(records.map(record => [record.id, record]));
+    }
+    return this.records;
+  }
+
+  private async save(): Promise<void> {
+    await this.store.save(this.name, [...this.records!.values()]);
+  }
+
+  /** Length of the collection's vectors, once it has any */
+  private async dimensions(): Promise<number | undefined> {
+    const [first] = (await this.load()).values();
+    return first?.vector.length;
+  }
+
+  private checkDimensions(vector: number[], expected?: number): void {
+    if (expected !== undefined && vector.length !== expected) {
+      throw new TypeError(
+        `vectors: collection ${this.name} holds vectors of ${expected} dimensions, got ${vector.length}`
+      );
+    }
+  }
+
+  /**
+   * Add records, or replace those with the same ids; texts without a
+   * vector are embedded together. Returns the number of records upserted
+   */
+  async upsert(items: VectorItem | VectorItem[]): Promise<number> {
+    const list = Array.isArray(items) ? items : [items];
+    list.forEach((item, index) => {
+      const label = `vectors.upsert: item ${index + 1}`;
+      if (!isPlainObject(item) || typeof item.id !== 'string' || !item.id) {
+        throw new TypeError(`${label} needs an id`);
+      }
+      if (item.vector !== undefined && !isVector(item.vector)) {
+        throw new TypeError(`${label}: vector must be a list of numbers`);
+      }
+      if (item.vector === undefined && typeof item.text !== 'string') {
+        throw new TypeError(`${label} needs a text or a vector`);
+      }
+      if (item.metadata !== undefined && !isPlainObject(item.metadata)) {
+        throw new TypeError(`${label}: metadata must be an object`);
+      }
+    });
+
+    const toEmbed = list.filter(item => item.vector === undefined);
+    const embedded =
+      toEmbed.length > 0
+        ? await this.embed(toEmbed.map(item => item.text!))
+        : [];
+    const vectors = new Map(toEmbed.map((item, i) => [item, embedded[i]]));
+
+    const records = await this.load();
+    let dimensions = await this.dimensions();
+    for (const item of list) {
+      const vector = item.vector ?? vectors.get(item)!;
+      this.checkDimensions(vector, dimensions);
+      dimensions = vector.length;
+      const record: VectorRecord = { id: item.id, vector: [...vector] };
+      if (item.text !== undefined) {
+        record.text = item.text;
+      }
+      if (item.metadata !== undefined) {
+        record.metadata = item.metadata;
+      }
+      records.set(item.id, record);
+    }
+    await this.save();
+    return list.length;
+  }
+
+  /**
+   * The records most similar to a text or vector, best first
+   * @param options The number of matches, or query options
+   */
+  async query(
+    query: string | number[],
+    options: number | VectorQueryOptions = {}
+  ): Promise<VectorMatch[]> {
+    const {
+      k = DEFAULT_QUERY_LIMIT,
+      filter,
+      minScore,
+    }: VectorQueryOptions =
+      typeof options === 'number' ? { k: options } : options;
+    if (!(Number.isInteger(k) && k > 0)) {
+      throw new TypeError(
+        `vectors.query: k must be a positive integer, got ${k}`
+      );
+    }
+    if (filter !== undefined && !isPlainObject(filter)) {
+      throw new TypeError('vectors.query: filter must be an object');
+    }
+    let vector: number[];
+    if (typeof query === 'string') {
+      [vector] = await this.embed([query]);
+    } else if (isVector(query)) {
+      vector = query;
+    } else {
+      throw new TypeError(
+        'vectors.query: query must be a text or a list of numbers'
+      );
+    }
+
+    const records = await this.load();
+    this.checkDimensions(vector, await this.dimensions());
+    const matches: VectorMatch[] = [];
+    for (const record of records.values()) {
+      const metadata = record.metadata ?? {};
+      const matchesFilter = Object.entries(filter ?? {}).every(
+        ([key, value]) => metadata[key] === value
+      );
+      if (!matchesFilter) {
+        continue;
+      }
+      const score = cosineSimilarity(vector, record.vector);
+      if (minScore !== undefined && score < minScore) {
+        continue;
+      }
+      const match: VectorMatch = { id: record.id, score };
+      if (record.text !== undefined) {
+        match.text = record.text;
+      }
+      if (record.metadata !== undefined) {
+        match.metadata = record.metadata;
+      }
+      matches.push(match);
+    }
+    return matches.sort((a, b) => b.score - a.score).slice(0, k);
+  }
+
+  /**
+   * Remove records by id; returns the number that were there
+   */
+  async delete(ids: string | string[]): Promise<number> {
+    const records = await this.load();
+    const removed = (Array.isArray(ids) ? ids : [ids]).filter(id =>
+      records.delete(id)
+    ).length;
+    if (removed > 0) {
+      await this.save();
+    }
+    return removed;
+  }
+
+  async count(): Promise<number> {
+    return (await this.load()).size;
+  }
+}
+
+/**
+ * The `vectors` builtin: its methods use the default collection, and
+ * collection(name) any other
+ */
+export class VectorIndex {
+  private readonly collections = new Map<string, VectorCollection>();
+
+  constructor(
+    private readonly embed: Embed,
+    private readonly store: VectorStore = new MemoryVectorStore()
+  ) {}
+
+  collection(name: string): VectorCollection {
+    // Names become file names in stores keeping collections as files
+    if (typeof name !== 'string' || !/^[A-Za-z0-9_-]+$/.test(name)) {
+      throw new TypeError(
+        `vectors.collection: invalid collection name ${JSON.stringify(name)}`
+      );
+    }
+    let collection = this.collections.get(name);
+    if (!collection) {
+      collection = new VectorCollection(name, this.embed, this.store);
+      this.collections.set(name, collection);
+    }
+    return collection;
+  }
+
+  upsert(items: VectorItem | VectorItem[]): Promise<number> {
+    return this.collection(DEFAULT_COLLECTION).upsert(items);
+  }
+
+  query(
+    query: string | number[],
+    options?: number | VectorQueryOptions
+  ): Promise<VectorMatch[]> {
+    return this.collection(DEFAULT_COLLECTION).query(query, options);
+  }
+
+  delete(ids: string | string[]): Promise<number> {
+    return this.collection(DEFAULT_COLLECTION).delete(ids);
+  }
+
+  count(): Promise<number> {
+    return this.collection(DEFAULT_COLLECTION).count();
+  }
+}
+
+/**
+ * Embedders of a run by model: those of the models the script declares,
+ * or of the host's embedding model when none is given
+ */
+export function embeddersFor(
+  providers: ModelProviderRegistry,
+  defaultModel?: ModelConfig
+): (model?: unknown) => TextEmbedder {
+  const embedders = new Map<ModelConfig, TextEmbedder>();
+  return model => {
+    const config = model === undefined ? defaultModel : modelConfigOf(model);
+    if (!config) {
+      throw new TypeError(
+        model === undefined
+          ? 'embed: no embedding model is configured; pass a model the script declares'
+          : 'embed: the model must be one the script declares'
+      );
+    }
+    let embedder = embedders.get(config);
+    if (!embedder) {
+      embedder = providers.createEmbedder(config);
+      embedders.set(config, embedder);
+    }
+    return embedder;
+  };
+}
+
+/**
+ * Create the embed function: a text gives a vector, a list of texts a
+ * list of vectors
+ */
+export function createEmbed(embedderFor: (model?: unknown) => TextEmbedder) {
+  return async function embed(
+    texts: string | string[],
+    model?: unknown
+  ): Promise<number[] | number[][]> {
+    const single = typeof texts === 'string';
+    const list = single ? [texts] : texts;
+    if (!Array.isArray(list) || !list.every(t => typeof t === 'string')) {
+      throw new TypeError('embed: texts must be a text or a list of texts');
+    }
+    if (list.length === 0) {
+      return [];
+    }
+    const vectors = await embedderFor(model).embed(list);
+    return single ? vectors[0] : vectors;
+  };
+}
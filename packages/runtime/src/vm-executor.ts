@@ -1,23 +1,121 @@
 // VM-based script execution with dependency injection
 import vm from 'vm';
-import { mcp } from './mcp-client.js';
+import { createHash } from 'crypto';
+import { types } from 'util';
+import type { ChatMessage } from '@llamaindex/core/llms';
+import type { MCPClientOptions } from './mcp-client.js';
 import {
   createPrint,
   createPrintChatMessage,
+  createStreamChatMessage,
   createInput,
-  log,
+  createLog,
   env,
   createSet,
   createMap,
+  type ProgressHandler,
   type RuntimeHandlers,
+  type StreamMessageHandler,
 } from './globals.js';
-import { OpenAI } from '@llamaindex/openai';
-import { Anthropic } from '@llamaindex/anthropic';
-import { Gemini } from '@llamaindex/google';
+import { createInspect } from './inspect.js';
+import { Redactor, type RedactionRules } from './redaction.js';
 import { Conversation, pipe } from './conversation.js';
-import { createAgent } from './agent.js';
-import { createToolProxy, createUserTool } from './mcp.js';
-import type { AppMessage } from './types.js';
+import { createAgent, type Agent } from './agent.js';
+import {
+  modelProviders as defaultModelProviders,
+  type ModelConfig,
+  type ModelProviderRegistry,
+} from './providers.js';
+import {
+  createToolProxy,
+  createUserTool,
+  MCPServerManager,
+} from './mcp.js';
+import { createParallelMap, runParallel } from './parallel.js';
+import { fanOut, fanOutEach, runPipeline } from './pipeline.js';
+import type { RunOutcome } from './outcome.js';
+import { rememberModelConfig, routeModels } from './routing.js';
+import type { CallPolicy } from './call-policy.js';
+import {
+  CircuitBreaker,
+  type CircuitBreakerOptions,
+} from './circuit-breaker.js';
+import {
+  admitCaller,
+  ToolCallGate,
+  type ApprovalHandler,
+  type ExecutionProfile,
+} from './profile.js';
+import {
+  currentCaller,
+  runAsCaller,
+  type CallerIdentity,
+} from './identity.js';
+import type { ScriptDebugger } from './debugger.js';
+import {
+  assert,
+  assertEqual,
+  assertThrows,
+  createFixtures,
+  createMock,
+  createTests,
+  expect,
+  type MockServers,
+  type TestSuite,
+} from './testing.js';
+import { traceModelCalls, type Tracer } from './tracing.js';
+import { recordToolCalls, type TranscriptRecorder } from './transcript.js';
+import type { EscalationHandler, OutputValidator } from './guardrails.js';
+import { ScriptCheckpoints, type CheckpointOptions } from './checkpoint.js';
+import {
+  runTimeViolation,
+  SandboxGate,
+  type SandboxPolicy,
+} from './policy.js';
+import {
+  formatInterpolation,
+  interpolate,
+  SecretStore,
+  type InterpolationPart,
+  type SecretProvider,
+} from './secrets.js';
+import { resolvePrompt, type ProjectMetadata } from './prompts.js';
+import {
+  createEmbed,
+  embeddersFor,
+  VectorIndex,
+  type VectorStore,
+} from './vectors.js';
+import { IdempotentCalls, type IdempotencyStore } from './idempotency.js';
+import { Sagas } from './saga.js';
+import { ScriptEvents, type EventPublisher } from './events.js';
+import {
+  ServerNotifications,
+  type NotificationSource,
+} from './notifications.js';
+import { runMain, type ScriptArguments } from './arguments.js';
+import { SpillStore, unspill, type SpillOptions } from './spill.js';
+import { bytes, isBytes } from './bytes.js';
+import { createFormat } from './format.js';
+import { checkOffline, modelEndpoint } from './offline.js';
+import { pinServerArgs, type PinnedPackage } from './packages.js';
+import type { FaultInjector } from './faults.js';
+import { createTracePoints, type TraceLevel } from './trace-points.js';
+import {
+  askThroughInput,
+  createPrompt,
+  type QuestionHandler,
+} from './questions.js';
+import type {
+  AppMessage,
+  ScriptEventHandler,
+  ScriptTrigger,
+} from './types.js';
+import {
+  GENERATED_FILENAME,
+  ScriptError,
+  resolveScriptStack,
+} from './script-stack.js';
 import { z } from 'zod';
 
 /**
@@ -27,6 +125,7 @@ type SchemaDefinition =
   | { type: 'string' }
   | { type: 'number' }
   | { type: 'boolean' }
+  | { type: 'bytes' }
   | { type: 'any' }
   | { type: 'null' }
   | { type: 'array'; elementType: SchemaDefinition }
@@ -46,6 +145,13 @@ function buildZodSchema(def: SchemaDefinition): z.ZodTypeAny {
       return z.number();
     case 'boolean':
       return z.boolean();
+    case 'bytes':
+      // Hosts and agents give bytes as base64
+      return z.preprocess(
+        value =>
+          typeof value === 'string' ? Buffer.from(value, 'base64') : value,
+        z.custom<Uint8Array>(isBytes, { message: 'Expected bytes' })
+      );
     case 'any':
       return z.any();
     case 'null':
@@ -84,8 +190,20 @@ function buildZodSchema(def: SchemaDefinition): z.ZodTypeAny {
 
 /**
  * Create a VM context with all required dependencies injected
+ * MCP servers started by the script are tracked by the given server manager,
+ * or replaced by mock servers in tests, and its models are created by the
+ * given providers. Secrets referenced by server options are looked up in
+ * the given store, and project metadata referenced by prompts in the given
+ * record
  */
-function createVMContext(handlers: RuntimeHandlers): vm.Context {
+function createVMContext(
+  handlers: RuntimeHandlers,
+  serverManager: MCPServerManager = new MCPServerManager(),
+  modelProviders: ModelProviderRegistry = defaultModelProviders,
+  mocks?: MockServers,
+  secrets: SecretStore = new SecretStore(),
+  project: ProjectMetadata = {}
+): vm.Context {
   // Create a safe subset of process object
   const safeProcess = {
     env: process.env,
@@ -95,37 +213,218 @@ function createVMContext(handlers: RuntimeHandlers): vm.Context {
     arch: process.arch,
   };
 
+  // Embedders of the script's models, or of the host's embedding model
+  const embedderFor = embeddersFor(modelProviders, handlers.embeddings);
+
+  // Event handlers, which the host may deliver events to as well
+  const events = new ScriptEvents(handlers.eventHandlers, handlers.publish);
+
+  // Handlers of the notifications of the script's servers
+  const notifications = new ServerNotifications();
+
+  // Calls the script marks @idempotent, skipped when done before
+  const idempotent = new IdempotentCalls(handlers.idempotency, message =>
+    createLog(handlers.redactor).info(message)
+  );
+
   const context = {
-    // LlamaIndex MCP adapter
-    __llamaindex_mcp: mcp,
+    // MCP client factory (servers are tracked for shutdown, refused when
+    // the run's sandbox policy or offline mode does not allow them, and
+    // run at their locked package versions, with the faults of the run)
+    __llamaindex_mcp: (options: MCPClientOptions) => {
+      if (handlers.offline && 'url' in options) {
+        checkOffline(`server ${options.serverName}`, String(options.url));
+      }
+      const pin = options.serverName && handlers.packages?.[options.serverName];
+      if (pin && 'command' in options) {
+        options = {
+          ...options,
+          args: pinServerArgs(
+            options.serverName!,
+            options.command,
+            options.args,
+            pin
+          ),
+        };
+      }
+      handlers.sandbox?.admitServer({
+        name: options.serverName,
+        command: 'command' in options ? options.command : undefined,
+        url: 'url' in options ? String(options.url) : undefined,
+      });
+      if (handlers.faults) {
+        options = { ...options, faults: handlers.faults };
+      }
+      return mocks ? mocks.connect(options) : serverManager.connect(options);
+    },
+    // ${NAME} and ${secret:NAME} in server options (mock servers are not
+    // started, so tests need neither)
+    __interpolate: (parts: InterpolationPart[]) =>
+      mocks
+        ? Promise.resolve(formatInterpolation(parts))
+        : interpolate(parts, secrets),
+    // ${NAME} and ${project.KEY} in prompts
+    __prompt: (name: string, parts: InterpolationPart[]) =>
+      resolvePrompt(name, parts, project),
 
     // Runtime functions (created with injected handlers)
     print: createPrint(handlers.addMessage),
     printChatMessage: createPrintChatMessage(handlers.addMessage),
-    log: log,
+    log: createLog(handlers.redactor),
     env: env,
+    // The caller the current run works for, or null
+    caller: () => currentCaller() ?? null,
     input: createInput(handlers.userInput),
-
-    // LlamaIndex model classes
-    __llamaindex_OpenAI: OpenAI,
-    __llamaindex_Anthropic: Anthropic,
-    __llamaindex_Gemini: Gemini,
+    prompt: createPrompt(
+      handlers.ask ??
+        (handlers.userInput && askThroughInput(handlers.userInput))
+    ),
+    format: createFormat(handlers.locale),
+    // Binary values, which tool calls send as base64
+    bytes,
+    inspect: createInspect(handlers.addMessage, {}, handlers.redactor),
+    debug: createInspect(handlers.addMessage, {}, handlers.redactor),
+
+    // Testing (mock() only works when the servers are mocked, and fixtures
+    // and test cases under mcps test)
+    assert,
+    assertEqual,
+    assertThrows,
+    expect,
+    mock: createMock(mocks),
+    fixtures: createFixtures(handlers.tests),
+    // Test cases, hooks and fixtures of test files register here
+    __tests: createTests(handlers.tests),
+
+    // Model factory (LLMs come from the registered providers, and hosted
+    // ones are refused offline), and the router of agents listing several
+    // models
+    __createModel: (config: ModelConfig) => {
+      if (handlers.offline) {
+        checkOffline(
+          `model provider ${config.provider}`,
+          modelEndpoint(config)
+        );
+      }
+      const llm = modelProviders.create(config);
+      rememberModelConfig(llm, config);
+      traceModelCalls(llm, {
+        'mcps.provider': config.provider,
+        ...(config.model && { 'mcps.model': config.model }),
+      });
+      return llm;
+    },
+    __routeModels: routeModels,
+
+    // Embeddings, and the vector index embedding texts with the host's
+    // embedding model
+    embed: createEmbed(embedderFor),
+    vectors: new VectorIndex(
+      texts => embedderFor().embed(texts),
+      handlers.vectors
+    ),
 
     // Runtime classes
     __Conversation: Conversation,
-    __Agent: createAgent(createPrintChatMessage(handlers.addMessage)),
-
-    // MCP utility functions
-    __createToolProxy: createToolProxy,
+    __Agent: createAgent(
+      createPrintChatMessage(handlers.addMessage),
+      createStreamChatMessage(handlers.streamMessage),
+      handlers.transcript,
+      handlers.guardrails
+    ),
+
+    // MCP utility functions (tool calls are shown by the debugger,
+    // admitted by the execution profile and recorded in the transcript)
+    __createToolProxy: (
+      // eslint-disable-next-line @typescript-eslint/no-explicit-any
+      tools: Array<any>,
+      serverName?: string,
+      defaults?: CallPolicy,
+      circuitBreaker?: CircuitBreakerOptions
+    ) =>
+      createToolProxy(
+        handlers.transcript
+          ? recordToolCalls(tools, serverName, handlers.transcript)
+          : tools,
+        serverName,
+        defaults,
+        handlers.debugger,
+        handlers.toolGate,
+        circuitBreaker && new CircuitBreaker(circuitBreaker, serverName),
+        handlers.outcome,
+        handlers.spill
+      ),
     __createUserTool: createUserTool,
 
     // Zod for runtime type validation
     __zod: z,
     __buildZodSchema: buildZodSchema,
 
+    // Triggers, which only fire when the caller collects them
+    __trigger: (
+      name: string,
+      schedule: string | null,
+      fire: () => Promise<unknown>
+    ) => {
+      handlers.triggers?.push({ name, schedule, fire });
+    },
+
+    // Events, handled by the script's handlers and published to the host
+    __on: (
+      event: string,
+      body: (payload: unknown) => Promise<unknown>,
+      schema?: z.ZodTypeAny
+    ) => events.on(event, body, schema),
+    emit: (event: unknown, payload?: unknown) => events.emit(event, payload),
+
+    // Notifications of the script's servers, handled while it listens
+    __onNotification: (
+      server: NotificationSource,
+      label: string,
+      notification: string,
+      body: (params: Record<string, unknown>) => Promise<unknown>,
+      schema?: z.ZodTypeAny,
+      resource?: unknown
+    ) => notifications.on(server, label, notification, body, schema, resource),
+    __listen: (until?: () => unknown, timeout?: unknown) =>
+      notifications.listen(until, timeout),
+
+    // The script's main tool, called with the arguments of the run
+    __main: async (main: Parameters<typeof runMain>[0]) => {
+      const result = await runMain(main, handlers.args);
+      if (handlers.outcome) {
+        // The result outlives the run's spilled files
+        handlers.outcome.result = handlers.spill ? unspill(result) : result;
+      }
+      return result;
+    },
+
     // Pipe operator function
     __pipe: pipe,
 
+    // Parallel blocks, and parallel maps (whose progress goes to the host,
+    // and whose failed items are logged and noted in the run's outcome)
+    __parallel: runParallel,
+    __parallelMap: createParallelMap(handlers.progress, message => {
+      createLog(handlers.redactor).warn(message);
+      handlers.outcome?.record('partialFailure', message);
+    }),
+
+    // Pipelines, whose steps run once the steps they use have finished
+    __pipeline: runPipeline,
+    __fanOut: fanOut,
+    __fanOutEach: fanOutEach,
+
+    // Calls annotated with @idempotent(key: ...)
+    __idempotent: (
+      key: unknown,
+      label: string,
+      call: () => Promise<unknown>
+    ) => idempotent.run(key, label, call),
+
+    // Statement and tool call hooks of code generated with debugHooks
+    __debug: handlers.debugger,
+
     // App message function for UI integration
     __addAppMessage: handlers.addMessage,
 
@@ -198,6 +497,183 @@ export interface VMExecutionOptions {
   addMessage?: (msg: AppMessage) => void;
   /** Callback to request user input from within the VM */
   userInput?: (message: string) => Promise<string>;
+  /**
+   * Callback answering the questions of the prompt builtins; without it they
+   * are asked through userInput
+   */
+  ask?: QuestionHandler;
+  /** Callback receiving agent replies as they stream in */
+  streamMessage?: StreamMessageHandler;
+  /** Callback receiving the progress of parallel maps */
+  progress?: ProgressHandler;
+  /** Providers creating the script's models (defaults to the built-in ones) */
+  modelProviders?: ModelProviderRegistry;
+  /**
+   * Manager tracking the MCP servers started by the script
+   * All tracked servers are shut down when execution finishes or fails.
+   * Pass one in to be able to stop servers early (e.g. on SIGINT).
+   */
+  serverManager?: MCPServerManager;
+  /**
+   * Script file name and contents, used to report errors with script-level
+   * stack traces when the code was generated with source positions
+   */
+  sourceFile?: string;
+  source?: string;
+  /**
+   * Rules for masking sensitive data in logs
+   */
+  redaction?: RedactionRules;
+  /**
+   * Debugger to pause the script at, for code generated with debugHooks
+   */
+  debugger?: ScriptDebugger;
+  /**
+   * Tracer recording the run as a trace, with spans for its MCP tool calls
+   * and agent turns; it is flushed when execution finishes
+   */
+  tracer?: Tracer;
+  /**
+   * Tools the run may call, how many calls it may make and which need
+   * approval; MCP tool calls are unrestricted without one
+   */
+  profile?: ExecutionProfile;
+  /**
+   * Asks for approval of the calls the profile requires it for; without
+   * one, those calls are refused
+   */
+  approve?: ApprovalHandler;
+  /**
+   * Caller the run works for, whose identity its MCP tool calls pass on;
+   * the profile's role must allow it
+   */
+  caller?: CallerIdentity;
+  /**
+   * Mock servers answering the script's tool calls in place of the servers
+   * it declares, which are then not started
+   */
+  mocks?: MockServers;
+  /**
+   * Runs the test cases, hooks and fixtures a test file declares once its
+   * top-level code has run; without it, scripts declaring them fail
+   */
+  tests?: TestSuite;
+  /**
+   * Where ${secret:NAME} references of server options are looked up, in
+   * order (default: the environment); secrets found are masked like the
+   * redaction rules' environment variables
+   */
+  secretProviders?: SecretProvider[];
+  /**
+   * Metadata of the script's project, which prompts reference as
+   * ${project.KEY}
+   */
+  project?: ProjectMetadata;
+  /**
+   * Recorder of the run's agent runs; recorded messages are masked like
+   * logs
+   */
+  transcript?: TranscriptRecorder;
+  /**
+   * Messages to continue a conversation from, for code that replays an
+   * agent with __replayAgent(agent)
+   */
+  replay?: ChatMessage[];
+  /**
+   * Collects the triggers the script declares. When the script runs to the
+   * end and declared any, its servers are left running for them, and the
+   * caller shuts them down through the server manager
+   */
+  triggers?: ScriptTrigger[];
+  /**
+   * Collects the event handlers the script declares, which the caller can
+   * deliver events to once the script has run; its servers are then left
+   * running like for triggers
+   */
+  eventHandlers?: ScriptEventHandler[];
+  /**
+   * Receives the events the script emits, once its own handlers handled
+   * them
+   */
+  publish?: EventPublisher;
+  /**
+   * Validators the guardrails of the script's agents can name in `check`
+   */
+  outputValidators?: Record<string, OutputValidator>;
+  /**
+   * Asks whether output an "escalate" guardrail rejected may be used
+   * anyway; without one, that output is blocked
+   */
+  escalate?: EscalationHandler;
+  /**
+   * Where code generated with checkpoints saves them, and whether the run
+   * continues from its last one; the run's checkpoint is deleted once it
+   * finishes
+   */
+  checkpoints?: CheckpointOptions;
+  /**
+   * Limits on the servers the script may start or connect to, the tools
+   * it may call and how long it may run; what the policy does not allow
+   * fails with a PolicyViolationError instead of being done
+   */
+  policy?: SandboxPolicy;
+  /**
+   * Model embed() and the vector index use unless the script passes one
+   * of its models; without it, texts can only be embedded that way
+   */
+  embeddings?: ModelConfig;
+  /**
+   * Where the vector index keeps its collections; without one, they only
+   * last for the run
+   */
+  vectors?: VectorStore;
+  /**
+   * Where calls the script marks @idempotent are recorded once they
+   * complete; a call whose key is recorded is skipped. Without one, only
+   * repeated calls within the run are skipped
+   */
+  idempotency?: IdempotencyStore;
+  /**
+   * Arguments of the script's main tool, by parameter name; a script
+   * declaring a tool named main calls it once its top-level code has run
+   */
+  args?: ScriptArguments;
+  /**
+   * Records the error results of the script's tool calls and the failed
+   * items of its parallel maps, which do not fail the run themselves
+   */
+  outcome?: RunOutcome;
+  /**
+   * Locale format.number(), format.currency() and format.date() use unless
+   * a call names one, such as "de-DE" (default: the process's locale)
+   */
+  locale?: string;
+  /**
+   * Refuse the servers, models and embedding model that need the network
+   * with an OfflineError, before connecting; those on this machine are
+   * allowed
+   */
+  offline?: boolean;
+  /**
+   * Package versions to run stdio servers at, by declared server name, as
+   * locked by the host; a server asking for another version fails with a
+   * PackagePinError
+   */
+  packages?: Record<string, PinnedPackage>;
+  /**
+   * Which @trace points record their values: info (default), debug or off
+   */
+  traceLevel?: TraceLevel;
+  /**
+   * Faults to inject into the tool calls of the script's servers, mock or
+   * real, so test and staging runs exercise its retries and compensations
+   */
+  faults?: FaultInjector;
+  /**
+   * Tool results larger than the threshold are kept in temporary files
+   * while the run holds them, instead of in memory
+   */
+  spill?: SpillOptions;
 }
 
 /**
@@ -207,10 +683,107 @@ export async function executeInVM(
   code: string,
   options: VMExecutionOptions = {}
 ): Promise<Record<string, unknown>> {
+  const serverManager = options.serverManager ?? new MCPServerManager();
+  const redactor = new Redactor(options.redaction);
+  options.tracer?.redactWith(redactor);
+  options.transcript?.redactWith(redactor);
+  const secrets = new SecretStore(options.secretProviders, value =>
+    redactor.addSecret(value)
+  );
+
+  // The host's embedding model is refused offline before the script starts;
+  // the script's own models are checked as they are declared
+  if (options.offline && options.embeddings) {
+    checkOffline(
+      `embedding provider ${options.embeddings.provider}`,
+      modelEndpoint(options.embeddings)
+    );
+  }
+
+  if (options.profile) {
+    admitCaller(options.profile, options.caller);
+  }
+
+  const spill = options.spill && new SpillStore(options.spill);
+
+  // The sandbox policy is checked before the execution profile, so calls
+  // it refuses are neither counted nor put up for approval
+  const profileGate =
+    options.profile && new ToolCallGate(options.profile, options.approve);
+  const sandbox =
+    options.policy && new SandboxGate(options.policy, profileGate);
+
   // Create VM context with injected handlers
-  const context = createVMContext({
-    addMessage: options.addMessage,
-    userInput: options.userInput,
+  const context = createVMContext(
+    {
+      addMessage: options.addMessage,
+      userInput: options.userInput,
+      ask: options.ask,
+      streamMessage: options.streamMessage,
+      progress: options.progress,
+      redactor,
+      debugger: options.debugger,
+      transcript: options.transcript,
+      triggers: options.triggers,
+      eventHandlers: options.eventHandlers,
+      publish: options.publish,
+      guardrails: {
+        validators: options.outputValidators,
+        escalate: options.escalate,
+      },
+      toolGate: sandbox ?? profileGate,
+      sandbox,
+      embeddings: options.embeddings,
+      vectors: options.vectors,
+      idempotency: options.idempotency,
+      args: options.args,
+      outcome: options.outcome,
+      locale: options.locale,
+      offline: options.offline,
+      packages: options.packages,
+      faults: options.faults,
+      tests: options.tests,
+      spill,
+    },
+    serverManager,
+    options.modelProviders,
+    options.mocks,
+    secrets,
+    options.project
+  );
+
+  const { replay } = options;
+  if (replay) {
+    context.__replayAgent = (agent: Agent) => {
+      const conversation = new Conversation();
+      replay.forEach(message => conversation.addMessage(message));
+      return agent.run(conversation);
+    };
+  }
+
+  // Code generated with checkpoints saves them after each statement
+  const checkpoints =
+    options.checkpoints &&
+    (await ScriptCheckpoints.open(
+      options.checkpoints,
+      createHash('sha256').update(code).digest('hex'),
+      message => createLog(redactor).warn(message)
+    ));
+  context.__checkpoint = checkpoints;
+
+  // Steps with compensate blocks are undone when their tool, parallel map
+  // item or the script fails
+  const sagas = new Sagas(message => createLog(redactor).info(message));
+  context.__compensate = (undo: () => Promise<unknown>, label: string) =>
+    sagas.compensate(undo, label);
+  context.__saga = <T>(body: () => Promise<T>) => sagas.run(body);
+
+  // Statements annotated with @trace record their values at the run's
+  // trace level
+  context.__trace = createTracePoints({
+    level: options.traceLevel,
+    sourceFile: options.sourceFile,
+    redactor,
   });
 
   // Wrap code to assign variables to the context for test access
@@ -222,7 +795,7 @@ ${code.replace(/\blet\s+(\w+)/g, 'this.$1')}
 }).call(this)`;
 
   // Execute the code in the VM context
-  const script = new vm.Script(wrappedCode);
+  const script = new vm.Script(wrappedCode, { filename: GENERATED_FILENAME });
   const vmOptions: vm.RunningScriptOptions = {
     displayErrors: true,
   };
@@ -232,18 +805,78 @@ ${code.replace(/\blet\s+(\w+)/g, 'this.$1')}
     vmOptions.timeout = options.timeout ?? 30000;
   }
 
-  try {
-    const result = script.runInContext(context, vmOptions);
+  const run = async () => {
+    const result = options.caller
+      ? runAsCaller(options.caller, async () =>
+          script.runInContext(context, vmOptions)
+        )
+      : script.runInContext(context, vmOptions);
 
     // Wait for the async function to complete
     if (result && typeof result.then === 'function') {
       await result;
     }
+  };
+
+  // The VM timeout only bounds code that runs without waiting, so the
+  // policy's run time is enforced on the whole run. Servers are shut down
+  // when the run fails, which ends the calls it was waiting for
+  const maxRunTime = options.policy?.maxRunTime;
+  const limited =
+    maxRunTime === undefined
+      ? run
+      : () => {
+          let timer: ReturnType<typeof setTimeout> | undefined;
+          const expired = new Promise<never>((_, reject) => {
+            timer = setTimeout(
+              () => reject(runTimeViolation(maxRunTime)),
+              maxRunTime
+            );
+          });
+          return Promise.race([run(), expired]).finally(() =>
+            clearTimeout(timer)
+          );
+        };
+
+  let keepServers = false;
+  try {
+    const { tracer, sourceFile } = options;
+    await (tracer
+      ? tracer.trace(
+          'mcps.workflow',
+          { 'mcps.script': sourceFile ?? '<script>' },
+          limited
+        )
+      : limited());
+    keepServers =
+      (options.triggers?.length ?? 0) > 0 ||
+      (options.eventHandlers?.length ?? 0) > 0;
+    await checkpoints?.finish();
 
     // Return the context so tests can access variables
     return context as Record<string, unknown>;
-  } catch (error) {
-    if (error instanceof Error) {
+  } catch (failure) {
+    // A run whose steps were undone cannot be resumed
+    const undone = sagas.root.size > 0;
+    const error = await sagas.root.compensate(failure, message =>
+      createLog(redactor).info(message)
+    );
+    if (undone) {
+      await checkpoints?.finish();
+    }
+
+    // Errors raised by the engine inside the context belong to its realm,
+    // so they are not instances of this realm's Error
+    if (types.isNativeError(error)) {
+      // Report the error in terms of the script when positions are available
+      const scriptStack = resolveScriptStack(error.stack ?? '', wrappedCode, {
+        fileName: options.sourceFile,
+        content: options.source,
+      });
+      if (scriptStack.length > 0) {
+        throw new ScriptError(error, scriptStack);
+      }
+
       // Clean up the stack trace to remove VM internals
       const cleanStack = error.stack
         ?.split('\n')
@@ -258,6 +891,19 @@ ${code.replace(/\blet\s+(\w+)/g, 'this.$1')}
     } else {
       throw error;
     }
+  } finally {
+    // Make sure no server process or spilled result outlives the script
+    // (unless its triggers or event handlers need them), without masking
+    // the script's own error if shutdown fails as well
+    if (!keepServers) {
+      await serverManager.closeAll().catch((error: unknown) => {
+        createLog(redactor).warn(
+          error instanceof Error ? error.message : String(error)
+        );
+      });
+      spill?.close();
+    }
+    await options.tracer?.flush();
   }
 }
 
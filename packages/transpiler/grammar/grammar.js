@@ -7,6 +7,21 @@ module.exports = grammar({
     $.comment,
   ],
 
+  // After "prompt", "trigger", "pipeline", "on", "listen", "test", "fixture"
+  // or a test hook's name at the start of a statement, only the next tokens
+  // tell a declaration or statement from a variable of that name
+  conflicts: $ => [
+    [$._identifier, $.prompt_declaration],
+    [$._identifier, $.trigger_declaration],
+    [$._identifier, $.pipeline_declaration],
+    [$._identifier, $.event_handler],
+    [$._identifier, $.notification_handler],
+    [$._identifier, $.listen_statement],
+    [$._identifier, $.test_declaration],
+    [$._identifier, $.test_hook],
+    [$._identifier, $.fixture_declaration],
+  ],
+
   rules: {
     source_file: $ => repeat($.statement),
 
@@ -15,21 +30,37 @@ module.exports = grammar({
     statement: $ =>
       choice(
         $.comment,
+        $.import_statement,
         $.mcp_declaration,
         $.model_declaration,
         $.agent_declaration,
+        $.prompt_declaration,
+        $.trigger_declaration,
+        $.event_handler,
+        $.notification_handler,
         $.tool_declaration,
+        $.pipeline_declaration,
+        $.test_declaration,
+        $.test_hook,
+        $.fixture_declaration,
         $.assignment,
         $.expression_statement,
         $.block_statement,
         $.if_statement,
         $.while_statement,
         $.for_statement,
+        $.parallel_statement,
+        $.parallel_map_statement,
+        $.annotated_statement,
+        $.compensated_statement,
+        $.listen_statement,
         $.break_statement,
         $.continue_statement,
         $.return_statement
       ),
 
+    import_statement: $ => seq('import', $.string),
+
     block_statement: $ => prec(1, seq('{', repeat($.statement), '}')),
 
     if_statement: $ =>
@@ -59,6 +90,97 @@ module.exports = grammar({
         $.statement
       ),
 
+    // Each statement in the body is a branch; branches run concurrently,
+    // at most as many at once as the optional limit
+    parallel_statement: $ =>
+      seq(
+        'parallel',
+        optional(seq('(', $.expression, ')')),
+        '{',
+        repeat($.statement),
+        '}'
+      ),
+
+    // results = parallelMap(items, limit: 5) { item => process(item) }: the
+    // body runs for each item, at most as many at once as the limit, and
+    // the results are assigned in item order
+    parallel_map_statement: $ =>
+      seq(
+        optional(seq($.assignment_target, '=')),
+        'parallelMap',
+        '(',
+        $.expression,
+        optional(seq(',', 'limit', ':', $.expression)),
+        ')',
+        $.map_body
+      ),
+
+    map_body: $ => seq('{', $.map_item, '=>', repeat($.statement), '}'),
+
+    // Name the body of a parallel map gives each item
+    map_item: $ => $.identifier,
+
+    // @idempotent(key: "post-" + id) slack.post(...): an annotation changes
+    // how the call the statement makes is run, or with @trace, records the
+    // value of the statement
+    annotated_statement: $ =>
+      seq($.annotation, choice($.assignment, $.expression_statement)),
+
+    // ticket = jira.create(issue) compensate { jira.delete(ticket) }: the
+    // block undoes the statement when a later step of the tool or script
+    // fails
+    compensated_statement: $ =>
+      seq(
+        choice($.assignment, $.expression_statement, $.annotated_statement),
+        'compensate',
+        $.block_statement
+      ),
+
+    // listen(until: done, timeout: 10m): wait for notifications until the
+    // condition holds or the timeout passes
+    listen_statement: $ =>
+      seq(
+        'listen',
+        '(',
+        optional(
+          choice(
+            seq(
+              'until',
+              ':',
+              $.expression,
+              optional(seq(',', 'timeout', ':', $.expression))
+            ),
+            seq('timeout', ':', $.expression)
+          )
+        ),
+        ')'
+      ),
+
+    // @idempotent(key: id) or @trace; a "(" after the name always opens
+    // the arguments
+    annotation: $ =>
+      prec.right(
+        seq(
+          '@',
+          $.identifier,
+          optional(
+            seq(
+              '(',
+              optional(
+                seq(
+                  $.annotation_argument,
+                  repeat(seq(',', $.annotation_argument)),
+                  optional(',')
+                )
+              ),
+              ')'
+            )
+          )
+        )
+      ),
+
+    annotation_argument: $ => seq($.identifier, ':', $.expression),
+
     break_statement: _$ => 'break',
 
     continue_statement: _$ => 'continue',
@@ -76,11 +198,93 @@ module.exports = grammar({
         $.block_statement
       ),
 
+    // pipeline report(repo: string): string { issues = fetch(repo) ... }:
+    // named steps calling workflows, each running once the steps it uses
+    // have finished, and the pipeline's result
+    pipeline_declaration: $ =>
+      seq(
+        'pipeline',
+        $.identifier,
+        '(',
+        optional($.parameter_list),
+        ')',
+        optional($.return_type_annotation),
+        $.pipeline_body
+      ),
+
+    pipeline_body: $ =>
+      seq('{', repeat($.pipeline_step), optional($.pipeline_output), '}'),
+
+    pipeline_step: $ => seq($.identifier, '=', $.pipeline_stage),
+
+    pipeline_stage: $ =>
+      choice(
+        $.call_expression,
+        $.conditional_stage,
+        $.fan_out_stage,
+        $.each_stage
+      ),
+
+    // if (issues.length > 0) summarize(issues) else nothingToReport(repo)
+    conditional_stage: $ =>
+      prec.right(
+        seq(
+          'if',
+          '(',
+          $.expression,
+          ')',
+          $.pipeline_stage,
+          optional(seq('else', $.pipeline_stage))
+        )
+      ),
+
+    // parallel { chat: postToSlack(summary), mail: sendEmail(summary) }:
+    // runs the branches concurrently and collects their results by name
+    fan_out_stage: $ =>
+      seq(
+        'parallel',
+        '{',
+        optional(
+          seq(
+            $.fan_out_branch,
+            repeat(seq(',', $.fan_out_branch)),
+            optional(',')
+          )
+        ),
+        '}'
+      ),
+
+    fan_out_branch: $ => seq($.identifier, ':', $.pipeline_stage),
+
+    // each(issues, limit: 5) { issue => triage(issue) }: runs the stage for
+    // each item and collects the results in item order
+    each_stage: $ =>
+      seq(
+        'each',
+        '(',
+        $.expression,
+        optional(seq(',', 'limit', ':', $.expression)),
+        ')',
+        '{',
+        $.map_item,
+        '=>',
+        $.pipeline_stage,
+        '}'
+      ),
+
+    pipeline_output: $ => seq('return', $.expression),
+
     parameter_list: $ =>
       seq($.parameter, repeat(seq(',', $.parameter)), optional(',')),
 
+    // A default value makes a tool's parameter optional for its callers
     parameter: $ =>
-      seq($.identifier, optional('?'), optional($.type_annotation)),
+      seq(
+        $.identifier,
+        optional('?'),
+        optional($.type_annotation),
+        optional(seq('=', $.expression))
+      ),
 
     type_annotation: $ => seq(':', $.type_expression),
 
@@ -89,7 +293,8 @@ module.exports = grammar({
     type_expression: $ =>
       choice($.primitive_type, $.array_type, $.object_type, $.union_type),
 
-    primitive_type: _$ => choice('string', 'number', 'boolean', 'any', 'null'),
+    primitive_type: _$ =>
+      choice('string', 'number', 'boolean', 'bytes', 'any', 'null'),
 
     array_type: $ => seq($.type_expression, '[', ']'),
 
@@ -109,15 +314,16 @@ module.exports = grammar({
     assignment: $ => seq($.assignment_target, '=', $.expression),
 
     assignment_target: $ =>
-      choice($.identifier, $.member_expression, $.bracket_expression),
+      choice($._identifier, $.member_expression, $.bracket_expression),
 
     expression: $ =>
       choice(
         $.binary_expression,
         $.unary_expression,
         $.literal,
-        $.identifier,
+        $._identifier,
         $.call_expression,
+        $.with_expression,
         $.member_expression,
         $.bracket_expression,
         $.parenthesized_expression
@@ -146,6 +352,10 @@ module.exports = grammar({
     call_expression: $ =>
       prec.left(8, seq($.expression, '(', optional($.argument_list), ')')),
 
+    // Options of a tool call: fs.read(path) with { timeout: 5s }
+    with_expression: $ =>
+      prec.left(9, seq($.call_expression, 'with', $.object_literal)),
+
     member_expression: $ => prec.left(8, seq($.expression, '.', $.identifier)),
 
     bracket_expression: _$ =>
@@ -157,7 +367,14 @@ module.exports = grammar({
       seq($.expression, repeat(seq(',', $.expression)), optional(',')),
 
     literal: $ =>
-      choice($.string, $.number, $.boolean, $.array_literal, $.object_literal),
+      choice(
+        $.string,
+        $.duration,
+        $.number,
+        $.boolean,
+        $.array_literal,
+        $.object_literal
+      ),
 
     array_literal: $ =>
       seq(
@@ -174,6 +391,62 @@ module.exports = grammar({
 
     agent_declaration: $ => seq('agent', $.identifier, $.object_literal),
 
+    // Preferred over reading `prompt Name {}` as three statements
+    prompt_declaration: $ =>
+      prec.dynamic(1, seq('prompt', $.identifier, $.object_literal)),
+
+    // trigger nightly: schedule("0 2 * * *") -> processData("/data")
+    trigger_declaration: $ =>
+      seq('trigger', $.identifier, ':', $.call_expression, '->', $.expression),
+
+    // test "closes stale issues" { ... }: a test case of a *_test.mcps
+    // file, preferred over reading it as three statements, like the hooks
+    // and fixtures below
+    test_declaration: $ =>
+      prec.dynamic(1, seq('test', $.string, $.block_statement)),
+
+    // beforeEach { ... }: runs before or after each test case, or all of them
+    test_hook: $ =>
+      prec.dynamic(
+        1,
+        seq(
+          choice('beforeAll', 'beforeEach', 'afterEach', 'afterAll'),
+          $.block_statement
+        )
+      ),
+
+    // fixture workdir = fixtures.tempDir(): set up afresh for each test case
+    fixture_declaration: $ =>
+      prec.dynamic(1, seq('fixture', $.identifier, '=', $.expression)),
+
+    // on event("deploy.finished") (deploy: { version: string }) { ... }
+    event_handler: $ =>
+      seq(
+        'on',
+        'event',
+        '(',
+        $.string,
+        ')',
+        optional(seq('(', $.parameter, ')')),
+        $.block_statement
+      ),
+
+    // on notification(fs, "resources/updated", "file:///data/a.csv")
+    // (update) { ... }: the optional URI is the resource subscribed to
+    notification_handler: $ =>
+      seq(
+        'on',
+        'notification',
+        '(',
+        $.identifier,
+        ',',
+        $.string,
+        optional(seq(',', $.expression)),
+        ')',
+        optional(seq('(', $.parameter, ')')),
+        $.block_statement
+      ),
+
     object_literal: $ => seq('{', optional($.property_list), '}'),
 
     property_list: $ =>
@@ -247,7 +520,27 @@ module.exports = grammar({
           /\d+/
         )
       ),
+    // Durations in milliseconds, seconds, minutes or hours: 500ms, 1.5s
+    duration: _$ => token(/\d+(\.\d+)?(ms|s|m|h)/),
     boolean: _$ => choice('true', 'false'),
     identifier: _$ => /[a-zA-Z_][a-zA-Z0-9_]*/,
+    // "prompt", "trigger", "pipeline", "on" and "listen" are keywords only
+    // where a declaration or statement can start, so scripts can still name
+    // variables after them
+    _identifier: $ =>
+      choice(
+        $.identifier,
+        alias('prompt', $.identifier),
+        alias('trigger', $.identifier),
+        alias('pipeline', $.identifier),
+        alias('on', $.identifier),
+        alias('listen', $.identifier),
+        alias('test', $.identifier),
+        alias('fixture', $.identifier),
+        alias('beforeAll', $.identifier),
+        alias('beforeEach', $.identifier),
+        alias('afterEach', $.identifier),
+        alias('afterAll', $.identifier)
+      ),
   },
 });
@@ -0,0 +1,5 @@
+; Syntax errors in MCP Script
+
+(ERROR) @error
+
+(MISSING) @missing
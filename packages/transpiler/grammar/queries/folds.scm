@@ -0,0 +1,11 @@
+; Foldable regions for MCP Script
+
+[
+  (block_statement)
+  (parallel_statement)
+  (parallel_map_statement)
+  (object_literal)
+  (array_literal)
+  (object_type)
+  (argument_list)
+] @fold
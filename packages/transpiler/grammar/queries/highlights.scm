@@ -0,0 +1,170 @@
+; Syntax highlighting for MCP Script
+; Patterns are listed from most to least specific; the first capture that
+; matches a node wins.
+
+(comment) @comment
+
+; Declarations
+
+(tool_declaration
+  (identifier) @function)
+
+(mcp_declaration
+  (identifier) @namespace)
+
+(notification_handler
+  (identifier) @namespace)
+
+(model_declaration
+  (identifier) @variable)
+
+(agent_declaration
+  (identifier) @variable)
+
+(prompt_declaration
+  (identifier) @variable)
+
+(trigger_declaration
+  (identifier) @function)
+
+(pipeline_declaration
+  (identifier) @function)
+
+(pipeline_step
+  (identifier) @variable)
+
+(fixture_declaration
+  (identifier) @variable)
+
+(fan_out_branch
+  (identifier) @property)
+
+(parameter
+  (identifier) @variable.parameter)
+
+; Annotations
+
+(annotation
+  "@" @attribute
+  (identifier) @attribute)
+
+(annotation_argument
+  (identifier) @property)
+
+; Properties
+
+(property
+  (identifier) @property)
+
+(type_property
+  (identifier) @property)
+
+(member_expression
+  (identifier) @property)
+
+; Calls
+
+(call_expression
+  (expression
+    (identifier) @function.call))
+
+(call_expression
+  (expression
+    (member_expression
+      (identifier) @function.method)))
+
+; Literals
+
+(string) @string
+
+(number) @number
+
+(duration) @number
+
+(boolean) @boolean
+
+(primitive_type) @type.builtin
+
+(identifier) @variable
+
+; Keywords
+
+[
+  "import"
+  "mcp"
+  "model"
+  "agent"
+  "prompt"
+  "trigger"
+  "pipeline"
+  "on"
+  "event"
+  "notification"
+  "tool"
+  "with"
+  "compensate"
+  "test"
+  "fixture"
+  "beforeAll"
+  "beforeEach"
+  "afterEach"
+  "afterAll"
+] @keyword
+
+[
+  "if"
+  "else"
+  "while"
+  "for"
+  "parallel"
+  "parallelMap"
+  "each"
+  "listen"
+  "until"
+  "timeout"
+  "return"
+] @keyword.control
+
+(break_statement) @keyword.control
+
+(continue_statement) @keyword.control
+
+; Operators and punctuation
+
+[
+  "="
+  "+"
+  "-"
+  "*"
+  "/"
+  "%"
+  "=="
+  "!="
+  "<"
+  ">"
+  "<="
+  ">="
+  "&&"
+  "||"
+  "??"
+  "!"
+  "|"
+  "?"
+  "->"
+] @operator
+
+[
+  "("
+  ")"
+  "["
+  "]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+[
+  ","
+  "."
+  ":"
+  ";"
+] @punctuation.delimiter
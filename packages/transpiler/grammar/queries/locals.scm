@@ -0,0 +1,73 @@
+; Scopes and bindings for MCP Script
+; A definition that names its enclosing scope node (such as a tool's name)
+; belongs to the scope outside it.
+
+(source_file) @local.scope
+
+(tool_declaration) @local.scope
+
+(pipeline_declaration) @local.scope
+
+(event_handler) @local.scope
+
+(notification_handler) @local.scope
+
+(test_declaration) @local.scope
+
+(test_hook) @local.scope
+
+(block_statement) @local.scope
+
+(for_statement) @local.scope
+
+(map_body) @local.scope
+
+(each_stage) @local.scope
+
+; Definitions
+
+(tool_declaration
+  (identifier) @local.definition.function)
+
+(pipeline_declaration
+  (identifier) @local.definition.function)
+
+(pipeline_step
+  (identifier) @local.definition.var)
+
+(mcp_declaration
+  (identifier) @local.definition.namespace)
+
+(model_declaration
+  (identifier) @local.definition.var)
+
+(agent_declaration
+  (identifier) @local.definition.var)
+
+(prompt_declaration
+  (identifier) @local.definition.var)
+
+(fixture_declaration
+  (identifier) @local.definition.var)
+
+(parameter
+  (identifier) @local.definition.parameter)
+
+(assignment
+  (assignment_target
+    (identifier) @local.definition.var))
+
+(parallel_map_statement
+  (assignment_target
+    (identifier) @local.definition.var))
+
+(map_item
+  (identifier) @local.definition.parameter)
+
+; References
+
+(expression
+  (identifier) @local.reference)
+
+(notification_handler
+  (identifier) @local.reference)
@@ -0,0 +1,43 @@
+; Tags for MCP Script: the definitions and calls code navigation indexes
+; Each pattern captures the tag's name as @name and the whole tagged node
+; as @definition.<kind> or @reference.<kind>.
+
+(tool_declaration
+  (identifier) @name) @definition.function
+
+(mcp_declaration
+  (identifier) @name) @definition.module
+
+(model_declaration
+  (identifier) @name) @definition.model
+
+(agent_declaration
+  (identifier) @name) @definition.agent
+
+(prompt_declaration
+  (identifier) @name) @definition.prompt
+
+(trigger_declaration
+  (identifier) @name) @definition.trigger
+
+(pipeline_declaration
+  (identifier) @name) @definition.function
+
+; Variables assigned at the top level of a file
+
+(source_file
+  (statement
+    (assignment
+      (assignment_target
+        (identifier) @name)) @definition.variable))
+
+; Calls to tools, and to tools of MCP servers
+
+(call_expression
+  (expression
+    (identifier) @name)) @reference.call
+
+(call_expression
+  (expression
+    (member_expression
+      (identifier) @name))) @reference.call
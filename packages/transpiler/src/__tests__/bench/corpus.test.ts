@@ -0,0 +1,65 @@
+import { describe, it, expect } from 'vitest';
+import {
+  generateCorpus,
+  internalBenchmarks,
+  compareBenchmarks,
+  runBenchmark,
+  type BenchmarkResult,
+} from '../../bench.js';
+import { parseSource } from '../../parser.js';
+import { typecheck } from '../../typecheck.js';
+import { generateCode } from '../../codegen.js';
+
+describe('Benchmark corpus', () => {
+  const corpus = generateCorpus({ lines: 3000 });
+
+  it('should generate at least the requested number of lines', () => {
+    expect(corpus.split('\n').length).toBeGreaterThanOrEqual(3000);
+  });
+
+  it('should generate the same script for the same seed', () => {
+    expect(generateCorpus({ lines: 500, seed: 7 })).toBe(
+      generateCorpus({ lines: 500, seed: 7 })
+    );
+    expect(generateCorpus({ lines: 500, seed: 7 })).not.toBe(
+      generateCorpus({ lines: 500, seed: 8 })
+    );
+  });
+
+  it('should parse, typecheck and compile without errors', () => {
+    const statements = parseSource(corpus);
+    expect(typecheck(statements)).toEqual([]);
+    expect(() => generateCode(statements)).not.toThrow();
+  });
+
+  it('should run every internal benchmark', () => {
+    const benchmarks = internalBenchmarks(generateCorpus({ lines: 200 }));
+    expect(benchmarks.map(b => b.name)).toContain('BenchmarkParseLarge');
+    for (const benchmark of benchmarks) {
+      const result = runBenchmark(benchmark, { timeMs: 0, iterations: 2 });
+      expect(result.iterations).toBe(2);
+      expect(result.minMs).toBeLessThanOrEqual(result.p95Ms);
+    }
+  });
+});
+
+describe('compareBenchmarks', () => {
+  const result = (name: string, meanMs: number): BenchmarkResult => ({
+    name,
+    iterations: 10,
+    meanMs,
+    minMs: meanMs,
+    p95Ms: meanMs,
+  });
+
+  it('should report benchmarks slower than the threshold', () => {
+    const regressions = compareBenchmarks(
+      [result('parse', 13), result('query', 11), result('new', 50)],
+      [result('parse', 10), result('query', 10), result('gone', 1)],
+      0.2
+    );
+    expect(regressions).toEqual([
+      { name: 'parse', baselineMs: 10, meanMs: 13, ratio: 1.3 },
+    ]);
+  });
+});
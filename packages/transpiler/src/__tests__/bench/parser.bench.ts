@@ -0,0 +1,10 @@
+import { bench, describe } from 'vitest';
+import { generateCorpus, internalBenchmarks } from '../../bench.js';
+
+const benchmarks = internalBenchmarks(generateCorpus());
+
+describe('Parser', () => {
+  for (const benchmark of benchmarks) {
+    bench(benchmark.name, benchmark.run);
+  }
+});
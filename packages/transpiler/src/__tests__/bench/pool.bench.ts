@@ -0,0 +1,32 @@
+import { afterAll, bench, describe } from 'vitest';
+import { fileURLToPath } from 'url';
+import { availableParallelism } from 'os';
+import { generateCorpus } from '../../bench.js';
+import { parseSource } from '../../parser.js';
+import { ParserPool } from '../../pool.js';
+
+// Workers run the built pool-worker.js, so `npm run build` first
+const pool = new ParserPool({
+  workerPath: fileURLToPath(
+    new URL('../../../dist/pool-worker.js', import.meta.url)
+  ),
+});
+
+// As many documents as threads, as a server busy with requests sees
+const sources = Array.from({ length: availableParallelism() * 2 }, (_, i) =>
+  generateCorpus({ lines: 1000, seed: i + 1 })
+);
+
+afterAll(() => pool.close());
+
+describe('Parsing many documents', () => {
+  bench('BenchmarkParseSerial', () => {
+    for (const source of sources) {
+      parseSource(source);
+    }
+  });
+
+  bench('BenchmarkParsePool', async () => {
+    await pool.parseAll(sources);
+  });
+});
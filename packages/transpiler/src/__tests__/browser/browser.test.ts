@@ -0,0 +1,42 @@
+import { describe, it, expect, afterAll } from 'vitest';
+import {
+  formatSource,
+  getQuery,
+  initBrowserSyntax,
+  parseSource,
+  syntaxDiagnostics,
+  parseTree,
+} from '../../browser.js';
+import { nativeSyntaxBackend } from '../../native.js';
+import { setSyntaxBackend } from '../../syntax.js';
+import { fakeWebTreeSitter } from '../test-helpers.js';
+
+describe('browser entry', () => {
+  afterAll(() => {
+    setSyntaxBackend(nativeSyntaxBackend);
+  });
+
+  it('should parse, check and format with the loaded grammar', async () => {
+    const loaded: unknown[] = [];
+    await initBrowserSyntax({
+      Parser: fakeWebTreeSitter(loaded),
+      wasm: '/tree-sitter-mcpscript.wasm',
+      queries: {
+        highlights: '(number) @number',
+        locals: '',
+        folds: '',
+        errors: '',
+        tags: '',
+      },
+    });
+    expect(loaded).toEqual(['/tree-sitter-mcpscript.wasm']);
+
+    expect(parseSource('x = 1')).toHaveLength(1);
+    expect(formatSource('x   =  1')).toBe('x = 1\n');
+    expect(syntaxDiagnostics(parseTree('x = ('), 'x = (')).not.toEqual([]);
+
+    const { rootNode } = parseTree('x = 1');
+    const captures = getQuery('highlights').captures(rootNode);
+    expect(captures.map(capture => capture.name)).toEqual(['number']);
+  });
+});
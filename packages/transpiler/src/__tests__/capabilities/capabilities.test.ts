@@ -0,0 +1,135 @@
+// Tests for the capability manifest of a script
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { collectCapabilities } from '../../capabilities.js';
+
+function capabilities(source: string) {
+  return collectCapabilities(parseSource(source));
+}
+
+describe('collectCapabilities', () => {
+  it('should list stdio and remote servers', () => {
+    const manifest = capabilities(`
+      mcp fs {
+        command: "npx",
+        args: ["-y", "@modelcontextprotocol/server-filesystem", "./data"],
+        env: { DEBUG: "1" },
+        allowEnv: ["HOME"]
+      }
+      mcp search { url: "https://search.example.com/mcp", transport: "sse" }
+      mcp api { url: env.API_URL }
+    `);
+    expect(manifest.servers).toEqual([
+      {
+        name: 'fs',
+        transport: 'stdio',
+        command: 'npx',
+        args: ['-y', '@modelcontextprotocol/server-filesystem', './data'],
+        env: ['DEBUG'],
+        allowEnv: ['HOME'],
+      },
+      {
+        name: 'search',
+        transport: 'sse',
+        url: 'https://search.example.com/mcp',
+      },
+      { name: 'api', transport: 'http', url: '${API_URL}' },
+    ]);
+  });
+
+  it('should list filesystem roots given to servers', () => {
+    const manifest = capabilities(`
+      mcp fs { command: "server", args: ["--root", "/srv/data", "../shared", "~", "-v"] }
+    `);
+    expect(manifest.filesystemRoots).toEqual(['../shared', '/srv/data', '~']);
+  });
+
+  it('should list tools called from the script, tools and agents', () => {
+    const manifest = capabilities(`
+      mcp fs { command: "npx" }
+      mcp web { command: "npx" }
+      model claude { provider: "anthropic" }
+      agent Reader { model: claude, tools: [fs.readFile, web] }
+      tool summarize(path: string) {
+        text = fs.readFile(path)
+        return text
+      }
+      x = fs.readFile("a.txt")
+      fs.writeFile("b.txt", x)
+    `);
+    expect(manifest.tools).toEqual([
+      {
+        server: 'fs',
+        tool: 'readFile',
+        callers: ['agent Reader', 'tool summarize', 'script'],
+      },
+      { server: 'fs', tool: 'writeFile', callers: ['script'] },
+      { server: 'web', tool: '*', callers: ['agent Reader'] },
+    ]);
+  });
+
+  it('should list models and their endpoints', () => {
+    const manifest = capabilities(`
+      model local { provider: "openai", model: "llama3", baseURL: "http://localhost:11434/v1" }
+      model claude { provider: "anthropic", apiKey: env.ANTHROPIC_API_KEY }
+    `);
+    expect(manifest.models).toEqual([
+      {
+        name: 'local',
+        provider: 'openai',
+        model: 'llama3',
+        baseURL: 'http://localhost:11434/v1',
+      },
+      { name: 'claude', provider: 'anthropic' },
+    ]);
+    expect(manifest.networkEndpoints).toEqual(['http://localhost:11434/v1']);
+  });
+
+  it('should list URLs the script uses', () => {
+    const manifest = capabilities(`
+      mcp web { url: "https://mcp.example.com" }
+      page = web.fetch("https://news.example.com/feed")
+      print("done")
+    `);
+    expect(manifest.networkEndpoints).toEqual([
+      'https://mcp.example.com',
+      'https://news.example.com/feed',
+    ]);
+  });
+
+  it('should list secrets read from the environment and by whom', () => {
+    const manifest = capabilities(`
+      mcp gh { command: "gh-mcp", env: { TOKEN: env.GITHUB_TOKEN }, allowEnv: ["PATH"] }
+      model claude { provider: "anthropic", apiKey: env.ANTHROPIC_API_KEY }
+      print(env["GITHUB_TOKEN"])
+    `);
+    expect(manifest.secrets).toEqual([
+      { name: 'ANTHROPIC_API_KEY', usedBy: ['model claude'] },
+      { name: 'GITHUB_TOKEN', usedBy: ['mcp gh', 'script'] },
+      { name: 'PATH', usedBy: ['mcp gh'] },
+    ]);
+  });
+
+  it('should list variables and secrets referenced in server options', () => {
+    const manifest = capabilities(`
+      mcp gh { command: "\${HOME}/gh-mcp", env: { TOKEN: "\${secret:GH_TOKEN}" } }
+      mcp db { url: "https://\${DB_HOST}/mcp" }
+    `);
+    expect(manifest.secrets).toEqual([
+      { name: 'DB_HOST', usedBy: ['mcp db'] },
+      { name: 'HOME', usedBy: ['mcp gh'] },
+      { name: 'secret:GH_TOKEN', usedBy: ['mcp gh'] },
+    ]);
+  });
+
+  it('should return an empty manifest for a script without capabilities', () => {
+    expect(capabilities('x = 1 + 2\nprint(x)')).toEqual({
+      servers: [],
+      tools: [],
+      models: [],
+      filesystemRoots: [],
+      networkEndpoints: [],
+      secrets: [],
+    });
+  });
+});
@@ -275,4 +275,99 @@ describe('Codegen - Agent Declarations', () => {
     expect(code).toContain('__createUserTool("getTimestamp"');
     expect(code).toContain('tools: [getTimestamp]');
   });
+
+  it('should create sub-agents before the agents delegating to them', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      agent Lead { model: claude, tools: [Researcher, Writer], maxTurns: 20 }
+      agent Writer { model: claude, tools: [Researcher] }
+      agent Researcher { model: claude }
+    `);
+    const code = generateCodeForTest(statements);
+
+    const position = (name: string) => code.indexOf(`const ${name} = `);
+    expect(position('Researcher')).toBeLessThan(position('Writer'));
+    expect(position('Writer')).toBeLessThan(position('Lead'));
+    expect(code).toContain('tools: [Researcher, Writer],\n  maxTurns: 20');
+  });
+
+  it('should reject agents that delegate in a cycle', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      agent A { model: claude, tools: [B] }
+      agent B { model: claude, tools: [C] }
+      agent C { model: claude, tools: [A] }
+    `);
+
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Agents cannot delegate in a cycle: A -> B -> C -> A'
+    );
+  });
+
+  it('should pass guardrails with tools as validators', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      tool noSecrets(text) { return true }
+      agent A {
+        model: claude,
+        guardrails: [{ deny: ["password"], action: "retry" }, { check: noSecrets }]
+      }
+    `);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'guardrails: [{ deny: ["password"], action: "retry" }, { check: noSecrets }],\n  llm: claude'
+    );
+  });
+
+  it('should reject turn limits that are not positive whole numbers', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      agent A { model: claude, maxTurns: 0 }
+    `);
+
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Agent "A": maxTurns must be a positive whole number'
+    );
+  });
+
+  it('should route agents listing several models', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229", cost: 15 }
+      model llama { provider: "local", model: "llama3", contextWindow: 8000 }
+      agent A { model: [claude, llama], routing: { maxCost: 20 } }
+      agent B { model: llama, routing: { cooldown: 5000 } }
+    `);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'llm: __routeModels({ claude, llama }, { maxCost: 20 }, "Agent A")'
+    );
+    expect(code).toContain(
+      'llm: __routeModels({ llama }, { cooldown: 5000 }, "Agent B")'
+    );
+  });
+
+  it('should reject models that are not declared models', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      agent A { model: [claude, "gpt-4"] }
+    `);
+
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Agent "A": model must be a declared model or a list of them'
+    );
+  });
+
+  it('should pass context options', () => {
+    const statements = parseSource(`
+      model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+      agent A { model: claude, context: { limit: 50000, keep: 4 } }
+    `);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'context: { limit: 50000, keep: 4 },\n  llm: claude'
+    );
+  });
 });
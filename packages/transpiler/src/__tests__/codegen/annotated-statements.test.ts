@@ -0,0 +1,41 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Annotated Statement Code Generation', () => {
+  it('runs idempotent calls through the runtime', () => {
+    const source = '@idempotent(key: "post-" + id) slack.post(message)';
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'await __idempotent("post-" + id, "slack.post", async () => await slack.post(message));'
+    );
+  });
+
+  it('assigns the result of idempotent calls', () => {
+    const source = `@idempotent(key: orderId)
+order = shop.createOrder(cart)`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'let order = await __idempotent(orderId, "shop.createOrder", async () => await shop.createOrder(cart));'
+    );
+  });
+
+  it('passes the values of trace points through the runtime', () => {
+    const source = `@trace
+issues = github.search(query)
+@trace(label: "open", level: "debug") count`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'let issues = __trace(await github.search(query), { label: "issues", level: "info", line: 2, column: 1 });'
+    );
+    expect(code).toContain(
+      '__trace(count, { label: "open", level: "debug", line: 3, column: 39 });'
+    );
+  });
+});
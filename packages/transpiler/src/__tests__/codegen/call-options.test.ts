@@ -0,0 +1,53 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Call Options Code Generation', () => {
+  it('passes call options to the tool proxy', () => {
+    const source = `mcp fs { command: "fs-server" }
+text = fs.readFile("a.txt") with { timeout: 5s, retries: 2 }`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'let text = await fs.__mcp_call("readFile", ["a.txt"], { timeout: 5000, retries: 2 });'
+    );
+  });
+
+  it('passes server defaults to the tool proxy', () => {
+    const source =
+      'mcp fs { command: "fs-server", timeout: 30s, backoff: "linear" }';
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'const fs = __createToolProxy(__fs_tools, "fs", { timeout: 30000, backoff: "linear" });'
+    );
+  });
+
+  it('passes the circuit breaker of a server to the tool proxy', () => {
+    const source =
+      'mcp fs { command: "fs-server", circuitBreaker: { failures: 3, cooldown: 1m } }';
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'const fs = __createToolProxy(__fs_tools, "fs", undefined, { failures: 3, cooldown: 60000 });'
+    );
+  });
+
+  it('creates the tool proxy without defaults when none are set', () => {
+    const statements = parseSource('mcp fs { command: "fs-server" }');
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('const fs = __createToolProxy(__fs_tools, "fs");');
+  });
+
+  it('rejects options on calls that are not tool calls', () => {
+    const statements = parseSource('print("a") with { retries: 1 }');
+
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Call options are only supported on MCP tool calls'
+    );
+  });
+});
@@ -0,0 +1,49 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeUnsafe } from '../../codegen.js';
+
+describe('Codegen - Checkpoints', () => {
+  it('should not checkpoint by default', () => {
+    const statements = parseSource('x = 1\nprint(x)');
+    const code = generateCodeUnsafe(statements);
+
+    expect(code).not.toContain('__checkpoint');
+  });
+
+  it('should declare the variables with their restored values', () => {
+    const statements = parseSource('x = 1\nif (x > 0) {\n  y = 2\n}\nz = x');
+    const code = generateCodeUnsafe(statements, { checkpoints: true });
+
+    expect(code).toContain('let x = __checkpoint.restore("x");');
+    expect(code).toContain('let z = __checkpoint.restore("z");');
+    expect(code).not.toContain('restore("y")');
+  });
+
+  it('should skip finished statements and save after each one', () => {
+    const statements = parseSource('// Load\nx = 1\nprint(x)');
+    const code = generateCodeUnsafe(statements, { checkpoints: true });
+
+    expect(code).toContain(`// Load
+if (__checkpoint.pending(1)) {
+  x = 1;
+  await __checkpoint.save(1, { x });
+}
+if (__checkpoint.pending(2)) {
+  await print(x);
+  await __checkpoint.save(2, { x });
+}`);
+  });
+
+  it('should keep source positions inside the checkpointed statement', () => {
+    const statements = parseSource('print("hi")');
+    const code = generateCodeUnsafe(statements, {
+      checkpoints: true,
+      sourcePositions: true,
+    });
+
+    expect(code).toContain(`if (__checkpoint.pending(1)) {
+  /*@mcps:1:1*/await print("hi");
+  await __checkpoint.save(1, {});
+}`);
+  });
+});
@@ -0,0 +1,37 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Compensated Statement Code Generation', () => {
+  it('registers the block with the variables it uses as they are', () => {
+    const source = `issue = { title: "Outage" }
+ticket = jira.create(issue) compensate {
+  jira.delete(ticket.id)
+}
+ticket = null`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('let ticket = await jira.create(issue);');
+    expect(code).toContain(
+      '__compensate(((ticket) => async () => {\n  await jira.delete(ticket.id);\n})(ticket), "jira.create");'
+    );
+  });
+
+  it('gives tools with compensations a saga of their own', () => {
+    const source = `tool open(title) {
+  ticket = jira.create(title) compensate { jira.delete(ticket) }
+  slack.post(ticket)
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('async (title) => __saga(async () => {');
+    expect(code).not.toContain('__saga(async () => {}');
+  });
+
+  it('leaves tools without compensations as they are', () => {
+    const statements = parseSource('tool f(x) { return x }');
+    expect(generateCodeForTest(statements)).not.toContain('__saga');
+  });
+});
@@ -0,0 +1,59 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeUnsafe } from '../../codegen.js';
+
+describe('Codegen - Debug Hooks', () => {
+  it('should not call the debugger by default', () => {
+    const statements = parseSource('x = 1\nprint(x)');
+    const code = generateCodeUnsafe(statements);
+
+    expect(code).not.toContain('__debug');
+  });
+
+  it('should call the debugger before each statement', () => {
+    const statements = parseSource('x = 1\ny = x + 1\nprint(y)');
+    const code = generateCodeUnsafe(statements, { debugHooks: true });
+
+    expect(code).toContain('await __debug.before(1, 1, {}); let x = 1;');
+    expect(code).toContain(
+      'await __debug.before(2, 1, { x: () => x }); let y = x + 1;'
+    );
+    expect(code).toContain(
+      'await __debug.before(3, 1, { x: () => x, y: () => y }); await print(y);'
+    );
+  });
+
+  it('should place the hook after the position marker', () => {
+    const statements = parseSource('x = 1');
+    const code = generateCodeUnsafe(statements, {
+      sourcePositions: true,
+      debugHooks: true,
+    });
+
+    expect(code).toContain('/*@mcps:1:1*/await __debug.before(1, 1, {});');
+  });
+
+  it('should run tool bodies in a debugger frame', () => {
+    const source = `tool double(n) {
+  result = n * 2
+  return result
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeUnsafe(statements, { debugHooks: true });
+
+    expect(code).toContain('async (n) => __debug.call("double", async () => {');
+    expect(code).toContain('await __debug.before(2, 3, { n: () => n });');
+    expect(code).toContain(
+      'await __debug.before(3, 3, { n: () => n, result: () => result });'
+    );
+  });
+
+  it('should not stop at blocks, only at their statements', () => {
+    const statements = parseSource('if (true) {\n  x = 1\n}');
+    const code = generateCodeUnsafe(statements, { debugHooks: true });
+
+    expect(code.match(/__debug\.before/g)).toHaveLength(2);
+    expect(code).toContain('await __debug.before(1, 1, {}); if (true)');
+    expect(code).toContain('await __debug.before(2, 3, {}); let x = 1;');
+  });
+});
@@ -0,0 +1,70 @@
+// Codegen tests for event handlers
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Codegen - Event Handlers', () => {
+  it('should register handlers before the script runs', () => {
+    const statements = parseSource(`
+      emit("deploy.finished", { version: "1.2" })
+      on event("deploy.finished") (deploy: { version: string }) {
+        print(deploy.version)
+      }
+    `);
+    const code = generateCodeForTest(statements);
+    expect(code).toContain('// Register event handlers');
+    expect(code).toContain('__on("deploy.finished", async (deploy) => {');
+    expect(code).toContain(
+      '}, __buildZodSchema({ type: "object", properties: { version: { type: "string" } } }));'
+    );
+    expect(code.indexOf('// Register event handlers')).toBeLessThan(
+      code.indexOf('emit(')
+    );
+  });
+
+  it('should only check the payloads of typed parameters', () => {
+    const code = generateCodeForTest(
+      parseSource(`
+        on event("a") { print("a") }
+        on event("b") (payload) { print(payload) }
+        on event("c") (payload?: string) { print(payload) }
+      `)
+    );
+    expect(code).toContain('__on("a", async () => {');
+    expect(code).toContain(
+      '__on("b", async (payload) => {\n  await print(payload);\n});'
+    );
+    expect(code).toContain(
+      '__buildZodSchema({ type: "optional", schema: { type: "string" } })'
+    );
+  });
+
+  it('should keep the servers of a script with handlers running', () => {
+    const code = generateCodeForTest(
+      parseSource(`mcp fs { command: "npx", args: [] }
+on event("changed") { fs.list() }`)
+    );
+    expect(code).not.toContain('// Cleanup MCP servers');
+  });
+
+  it('should let handlers use variables assigned after them', () => {
+    const statements = parseSource(`
+      on event("done") { print(message) }
+      message = "done"
+    `);
+    expect(() => generateCode(statements)).not.toThrow();
+    expect(() =>
+      generateCode(parseSource('on event("x") (p) { print(p, missing) }'))
+    ).toThrow("Undefined variable: 'missing'");
+  });
+
+  it('should give handlers with compensations a saga of their own', () => {
+    const code = generateCodeForTest(
+      parseSource(`on event("opened") (title) {
+  ticket = jira.create(title) compensate { jira.delete(ticket) }
+}`)
+    );
+    expect(code).toContain('async (title) => __saga(async () => {');
+  });
+});
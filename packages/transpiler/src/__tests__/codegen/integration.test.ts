@@ -26,7 +26,7 @@ print(content)
 
     // Check MCP setup
     expect(code).toContain(
-      'const filesystem = __createToolProxy(__filesystem_tools)'
+      'const filesystem = __createToolProxy(__filesystem_tools, "filesystem")'
     );
     expect(code).toContain('await __filesystem_server.tools()');
 
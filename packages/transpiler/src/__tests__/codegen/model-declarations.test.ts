@@ -4,7 +4,7 @@ import { parseSource } from '../../parser.js';
 import { generateCodeForTest } from '../test-helpers.js';
 
 describe('Codegen - Model Declarations', () => {
-  it('should generate LlamaIndex-compatible OpenAI model', () => {
+  it('should generate an OpenAI model', () => {
     const statements = parseSource(
       'model gpt4 { provider: "openai", model: "gpt-4o", apiKey: env.OPENAI_API_KEY }'
     );
@@ -12,31 +12,31 @@ describe('Codegen - Model Declarations', () => {
     expect(code).toContain('// Initialize model configurations');
     expect(code).toContain('const __models = {};');
     expect(code).toContain('// Model configuration for gpt4');
-    expect(code).toContain('new __llamaindex_OpenAI');
+    expect(code).toContain('__createModel({ provider: "openai"');
     expect(code).toContain('apiKey: env.OPENAI_API_KEY');
     expect(code).toContain('model: "gpt-4o"');
     expect(code).toContain('__models.gpt4 = gpt4;');
   });
 
-  it('should generate LlamaIndex-compatible Anthropic model', () => {
+  it('should generate an Anthropic model', () => {
     const statements = parseSource(
       'model claude { provider: "anthropic", model: "claude-3-opus-20240229", apiKey: env.ANTHROPIC_API_KEY }'
     );
     const code = generateCodeForTest(statements);
     expect(code).toContain('// Model configuration for claude');
-    expect(code).toContain('new __llamaindex_Anthropic');
+    expect(code).toContain('__createModel({ provider: "anthropic"');
     expect(code).toContain('apiKey: env.ANTHROPIC_API_KEY');
     expect(code).toContain('model: "claude-3-opus-20240229"');
     expect(code).toContain('__models.claude = claude;');
   });
 
-  it('should generate LlamaIndex-compatible Gemini model', () => {
+  it('should generate a Gemini model', () => {
     const statements = parseSource(
       'model gemini { provider: "gemini", model: "gemini-2.0-flash", apiKey: env.GOOGLE_API_KEY }'
     );
     const code = generateCodeForTest(statements);
     expect(code).toContain('// Model configuration for gemini');
-    expect(code).toContain('new __llamaindex_Gemini');
+    expect(code).toContain('__createModel({ provider: "gemini"');
     expect(code).toContain('apiKey: env.GOOGLE_API_KEY');
     expect(code).toContain('model: "gemini-2.0-flash"');
     expect(code).toContain('__models.gemini = gemini;');
@@ -58,12 +58,13 @@ describe('Codegen - Model Declarations', () => {
     );
   });
 
-  it('should throw error for unsupported provider', () => {
+  it('should leave provider lookup to the runtime', () => {
     const statements = parseSource(
-      'model invalid { provider: "unsupported", model: "test" }'
+      'model mine { provider: "custom", model: "test", region: "eu" }'
     );
-    expect(() => generateCodeForTest(statements)).toThrow(
-      'Unsupported model provider: unsupported'
+    const code = generateCodeForTest(statements);
+    expect(code).toContain(
+      'const mine = __createModel({ provider: "custom", model: "test", region: "eu" });'
     );
   });
 
@@ -74,9 +75,9 @@ describe('Codegen - Model Declarations', () => {
       model gemini { provider: "gemini", model: "gemini-2.0-flash" }
     `);
     const code = generateCodeForTest(statements);
-    expect(code).toContain('new __llamaindex_Anthropic');
-    expect(code).toContain('new __llamaindex_OpenAI');
-    expect(code).toContain('new __llamaindex_Gemini');
+    expect(code).toContain('__createModel({ provider: "anthropic"');
+    expect(code).toContain('__createModel({ provider: "openai"');
+    expect(code).toContain('__createModel({ provider: "gemini"');
   });
 
   it('should not include model declarations in main code section', () => {
@@ -0,0 +1,59 @@
+// Codegen tests for notification handlers and listen statements
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Codegen - Notifications', () => {
+  it('should register handlers where they are declared', () => {
+    const code = generateCodeForTest(
+      parseSource(`mcp fs { command: "npx", args: [] }
+report = "file:///data/report.csv"
+on notification(fs, "resources/updated", report) (update: { uri: string }) {
+  print(update.uri)
+}`)
+    );
+    expect(code).toContain(
+      'await __onNotification(__fs_server, "fs", "resources/updated", async (update) => {'
+    );
+    expect(code).toContain(
+      '}, __buildZodSchema({ type: "object", properties: { uri: { type: "string" } } }), report);'
+    );
+    expect(code.indexOf('let report')).toBeLessThan(
+      code.indexOf('__onNotification(')
+    );
+  });
+
+  it("should let handlers assign the script's variables", () => {
+    const code = generateCodeForTest(
+      parseSource(`mcp fs { command: "npx", args: [] }
+count = 0
+on notification(fs, "tools/list_changed") {
+  count = count + 1
+  changed = true
+}
+listen(until: count >= 3, timeout: 1m)`)
+    );
+    expect(code).toContain(
+      'await __onNotification(__fs_server, "fs", "tools/list_changed", async () => {\n  count = count + 1;\n  let changed = true;\n}, undefined);'
+    );
+    expect(code).toContain('await __listen(async () => (count >= 3), 60000);');
+  });
+
+  it('should listen without a condition or a timeout', () => {
+    const code = generateCodeForTest(
+      parseSource('listen(timeout: 30s)\nlisten(until: done)\nlisten()')
+    );
+    expect(code).toContain('await __listen(undefined, 30000);');
+    expect(code).toContain('await __listen(async () => (done));');
+    expect(code).toContain('await __listen();');
+  });
+
+  it('should reject handlers of undeclared servers', () => {
+    expect(() =>
+      generateCode(
+        parseSource('on notification(github, "tools/list_changed") {}')
+      )
+    ).toThrow("Undefined variable: 'github' in notification handler");
+  });
+});
@@ -0,0 +1,45 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Parallel Map Statement Code Generation', () => {
+  it('returns the last expression of the body for each item', () => {
+    const source =
+      'results = parallelMap(items, limit: 5) { item => process(item) }';
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain(
+      'let results = await __parallelMap(items, async item => {'
+    );
+    expect(code).toContain('return await process(item);');
+    expect(code).toContain('}, 5, "parallelMap(items)");');
+  });
+
+  it('keeps variables of the body local to each item', () => {
+    const source = `parallelMap(urls) { url =>
+  page = fetch(url)
+  print(page)
+}
+page = 1`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('let page = await fetch(url);');
+    expect(code).toContain('return await print(page);');
+    expect(code).toContain('}, undefined, "parallelMap(urls)");');
+    expect(code).toContain('let page = 1;');
+  });
+
+  it('leaves bodies ending in other statements to return themselves', () => {
+    const source = `done = parallelMap([1, 2]) { n =>
+  if (n > 1) { return n }
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('if (n > 1) {');
+    expect(code).toContain('return n;');
+    expect(code).toContain('"parallelMap");');
+  });
+});
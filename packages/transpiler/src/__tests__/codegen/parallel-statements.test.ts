@@ -0,0 +1,68 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Parallel Statement Code Generation', () => {
+  it('generates a branch function per statement', () => {
+    const source = `parallel {
+  a = search("cats")
+  b = search("dogs")
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('const __parallel0 = await __parallel([');
+    expect(code).toContain('async () => search("cats"),');
+    expect(code).toContain('async () => search("dogs"),');
+    expect(code).toContain('let a = __parallel0[0];');
+    expect(code).toContain('let b = __parallel0[1];');
+  });
+
+  it('passes the limit', () => {
+    const source = 'parallel (2) { a = 1 }';
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('], 2);');
+  });
+
+  it('reassigns variables declared before the block', () => {
+    const source = `a = 0
+parallel { a = 1 }`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('a = __parallel0[0];');
+    expect(code).not.toContain('let a = __parallel0[0];');
+  });
+
+  it('keeps variables of block branches local to the branch', () => {
+    const source = `parallel {
+  {
+    page = fetch("a")
+    print(page)
+  }
+}
+page = 1`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('async () => {');
+    expect(code).toContain('let page = fetch("a");');
+    expect(code).toContain('let page = 1;');
+  });
+
+  it('numbers nested parallel blocks apart', () => {
+    const source = `parallel {
+  {
+    parallel { b = 2 }
+  }
+  a = 1
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeForTest(statements);
+
+    expect(code).toContain('const __parallel0 = await __parallel([');
+    expect(code).toContain('const __parallel1 = await __parallel([');
+  });
+});
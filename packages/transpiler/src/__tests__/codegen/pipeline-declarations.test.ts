@@ -0,0 +1,83 @@
+// Codegen tests for pipeline declarations
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+const TOOLS = `
+  tool fetchIssues(repo) { return [] }
+  tool triage(issue) { return issue }
+  tool summarize(issues) { return "" }
+  tool post(text) { return text }
+`;
+
+describe('Codegen - Pipeline Declarations', () => {
+  it('should hand the steps and what they use to __pipeline', () => {
+    const code = generateCodeForTest(
+      parseSource(`${TOOLS}
+        pipeline report(repo: string) {
+          issues = fetchIssues(repo)
+          summary = summarize(issues)
+          return summary
+        }
+      `)
+    );
+    expect(code).toContain(
+      'const report = __createUserTool("report", ["repo"], async (repo) => __pipeline("report", [' +
+        '{ name: "issues", uses: [], run: async () => await fetchIssues(repo) }, ' +
+        '{ name: "summary", uses: ["issues"], run: async ({ issues }) => await summarize(issues) }' +
+        '], async ({ summary }) => summary), __buildZodSchema('
+    );
+  });
+
+  it('should generate conditional, fan-out and each stages', () => {
+    const code = generateCodeForTest(
+      parseSource(`${TOOLS}
+        pipeline triageAll(repo, urgent: boolean) {
+          issues = fetchIssues(repo)
+          triaged = each(issues, limit: 5) { issue => triage(issue) }
+          summary = if (urgent) summarize(triaged)
+          sent = parallel { chat: post(summary), mail: post(repo) }
+        }
+      `)
+    );
+    expect(code).toContain(
+      'run: async ({ issues }) => await __fanOutEach(issues, async (issue) => await triage(issue), 5) }'
+    );
+    expect(code).toContain(
+      'run: async ({ triaged }) => ((urgent) ? await summarize(triaged) : null) }'
+    );
+    expect(code).toContain(
+      'run: async ({ summary }) => await __fanOut({ "chat": async () => await post(summary), "mail": async () => await post(repo) }) }'
+    );
+    // Without a return the pipeline's result is null
+    expect(code).toContain('], async () => null)');
+  });
+
+  it('should let scripts call pipelines declared after them', () => {
+    const code = generateCode(
+      parseSource(`${TOOLS}
+        result = report("mcpscript")
+        pipeline report(repo) {
+          issues = fetchIssues(repo)
+        }
+      `)
+    );
+    expect(code.indexOf('const report = ')).toBeLessThan(
+      code.indexOf('await report("mcpscript")')
+    );
+  });
+
+  it('should reject steps using later steps', () => {
+    expect(() =>
+      generateCode(
+        parseSource(`${TOOLS}
+          pipeline report(repo) {
+            summary = summarize(issues)
+            issues = fetchIssues(repo)
+          }
+        `)
+      )
+    ).toThrow("Undefined variable: 'issues'");
+  });
+});
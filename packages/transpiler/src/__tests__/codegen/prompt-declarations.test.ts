@@ -0,0 +1,90 @@
+// Codegen tests for prompt declarations
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Codegen - Prompt Declarations', () => {
+  it('should generate a prompt as a string', () => {
+    const statements = parseSource(
+      'prompt Reviewer { text: "You review code." }'
+    );
+    const code = generateCodeForTest(statements);
+    expect(code).toContain('// Initialize prompts');
+    expect(code).toContain('const Reviewer = "You review code.";');
+  });
+
+  it('should resolve references to the project and environment at run time', () => {
+    const statements = parseSource(
+      'prompt Reviewer { text: "Reviews for ${project.name} by ${USER}, $${literal}" }'
+    );
+    const code = generateCodeForTest(statements);
+    expect(code).toContain(
+      'const Reviewer = __prompt("Reviewer", ["Reviews for ",{"project":"name"}," by ",{"env":"USER"},", ${literal}"]);'
+    );
+  });
+
+  it('should use the text of a prompt file read with the module', () => {
+    const [prompt] = parseSource('prompt Triage { file: "./triage.md" }');
+    expect(() => generateCodeForTest([prompt])).toThrow(
+      'Prompt "Triage": its file is only read when the script is loaded with its imports'
+    );
+
+    const code = generateCodeForTest([
+      { ...prompt, fileText: 'Sort the issues.\n' },
+    ]);
+    expect(code).toContain('const Triage = "Sort the issues.\\n";');
+  });
+
+  it('should reject prompts without exactly one of text and file', () => {
+    expect(() =>
+      generateCodeForTest(parseSource('prompt Empty { }'))
+    ).toThrow('Prompt "Empty" must specify either text or file');
+    expect(() =>
+      generateCodeForTest(
+        parseSource('prompt Both { text: "a", file: "./a.md" }')
+      )
+    ).toThrow('Prompt "Both" must specify either text or file');
+  });
+
+  it('should not put secrets in prompts', () => {
+    const statements = parseSource(
+      'prompt Leaky { text: "Use ${secret:TOKEN}" }'
+    );
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Prompt "Leaky" cannot reference ${secret:TOKEN}, since prompts are sent to the model'
+    );
+  });
+
+  it('should give agents a declared prompt as their system prompt', () => {
+    const statements = parseSource(`
+      model gpt4 { provider: "openai", model: "gpt-4o" }
+      prompt Reviewer { text: "You review code." }
+      agent CodeReviewer { model: gpt4, systemPrompt: Reviewer }
+    `);
+    const code = generateCodeForTest(statements);
+    expect(code).toContain('systemPrompt: Reviewer');
+    // Prompts exist before the agents using them
+    expect(code.indexOf('const Reviewer')).toBeLessThan(
+      code.indexOf('const CodeReviewer')
+    );
+  });
+
+  it('should reject system prompts that are not prompts', () => {
+    const statements = parseSource(`
+      model gpt4 { provider: "openai", model: "gpt-4o" }
+      agent CodeReviewer { model: gpt4, systemPrompt: gpt4 }
+    `);
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Agent "CodeReviewer": systemPrompt gpt4 is not a declared prompt'
+    );
+  });
+
+  it('should still allow variables named prompt', () => {
+    const statements = parseSource(`
+      prompt = "Summarize"
+      print(prompt)
+    `);
+    const code = generateCodeForTest(statements);
+    expect(code).toContain('let prompt = "Summarize"');
+  });
+});
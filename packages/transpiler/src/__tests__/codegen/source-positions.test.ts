@@ -0,0 +1,31 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCodeUnsafe } from '../../codegen.js';
+
+describe('Codegen - Source Positions', () => {
+  it('should not emit position markers by default', () => {
+    const statements = parseSource('x = 1\nprint(x)');
+    const code = generateCodeUnsafe(statements);
+
+    expect(code).not.toContain('/*@mcps');
+  });
+
+  it('should mark each statement with its source position', () => {
+    const statements = parseSource('x = 1\nprint(x)');
+    const code = generateCodeUnsafe(statements, { sourcePositions: true });
+
+    expect(code).toContain('/*@mcps:1:1*/let x = 1;');
+    expect(code).toContain('/*@mcps:2:1*/');
+  });
+
+  it('should mark tool declarations with their source range', () => {
+    const source = `tool double(n) {
+  return n * 2
+}`;
+    const statements = parseSource(source);
+    const code = generateCodeUnsafe(statements, { sourcePositions: true });
+
+    expect(code).toContain('/*@mcps-tool:double:1:1:3:2*/const double =');
+    expect(code).toContain('/*@mcps:2:3*/return n * 2;');
+  });
+});
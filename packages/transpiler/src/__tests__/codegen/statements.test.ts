@@ -30,12 +30,97 @@ mcp filesystem {
 
     expect(code).toContain('__llamaindex_mcp');
     expect(code).toContain('command: "npx"');
+    expect(code).toContain('serverName: "filesystem"');
     expect(code).toContain(
-      'const filesystem = __createToolProxy(__filesystem_tools)'
+      'const filesystem = __createToolProxy(__filesystem_tools, "filesystem")'
     );
     expect(code).toContain('await __filesystem_server.tools()');
   });
 
+  it('should generate environment and isolation options for MCP servers', () => {
+    const source = `
+mcp github {
+  command: "github-mcp",
+  env: { GITHUB_TOKEN: env.GITHUB_TOKEN },
+  inheritEnv: false,
+  allowEnv: ["LANG"],
+  stderr: "capture",
+  stderrLimit: 4096,
+  priority: 10
+}
+    `.trim();
+
+    const ast = parseSource(source);
+    const code = generateCodeForTest(ast);
+
+    expect(code).toContain('env: { GITHUB_TOKEN: env.GITHUB_TOKEN }');
+    expect(code).toContain('inheritEnv: false');
+    expect(code).toContain('allowEnv: ["LANG"]');
+    expect(code).toContain('stderr: "capture"');
+    expect(code).toContain('stderrLimit: 4096');
+    expect(code).toContain('priority: 10');
+  });
+
+  it('should generate connection options for remote MCP servers', () => {
+    const source = `
+mcp linear {
+  url: env.LINEAR_MCP_URL,
+  transport: "http",
+  headers: { Authorization: "Bearer " + env.LINEAR_TOKEN },
+  tls: { ca: "certs/ca.pem" },
+  reconnect: { maxRetries: 5, initialDelay: 500 }
+}
+    `.trim();
+
+    const code = generateCodeForTest(parseSource(source));
+
+    expect(code).toContain(
+      '__llamaindex_mcp({ url: env.LINEAR_MCP_URL, serverName: "linear", ' +
+        'transport: "http", reconnect: {maxRetries: 5, initialDelay: 500}, ' +
+        'headers: { Authorization: "Bearer " + env.LINEAR_TOKEN }, ' +
+        'tls: { ca: "certs/ca.pem" } })'
+    );
+  });
+
+  it('should resolve interpolated server options when the server starts', () => {
+    const source = `
+mcp github {
+  command: "\${HOME}/bin/github-mcp",
+  args: ["--org", "acme"],
+  env: { GITHUB_TOKEN: "\${secret:GITHUB_TOKEN}", PRICE: "$\${5}" }
+}
+mcp linear {
+  url: "https://\${LINEAR_HOST}/mcp",
+  headers: { Authorization: "Bearer \${secret:LINEAR_TOKEN}" }
+}
+    `.trim();
+
+    const code = generateCodeForTest(parseSource(source));
+
+    expect(code).toContain(
+      'command: await __interpolate([{"env":"HOME"},"/bin/github-mcp"])'
+    );
+    expect(code).toContain('args: ["--org", "acme"]');
+    expect(code).toContain(
+      'env: { "GITHUB_TOKEN": await __interpolate([{"secret":"GITHUB_TOKEN"}]), ' +
+        '"PRICE": "${5}" }'
+    );
+    expect(code).toContain(
+      'url: await __interpolate(["https://",{"env":"LINEAR_HOST"},"/mcp"])'
+    );
+    expect(code).toContain(
+      'headers: { "Authorization": await __interpolate(["Bearer ",{"secret":"LINEAR_TOKEN"}]) }'
+    );
+  });
+
+  it('should reject unknown transports for remote MCP servers', () => {
+    const ast = parseSource('mcp remote { url: "http://x", transport: "ws" }');
+
+    expect(() => generateCodeForTest(ast)).toThrow(
+      'MCP server "remote": transport must be "http" or "sse"'
+    );
+  });
+
   it('should generate code for MCP tool calls', () => {
     const source = `
 mcp server { command: "cmd", args: [] }
@@ -45,7 +130,9 @@ result = server.getTool("arg1", "arg2")
     const ast = parseSource(source);
     const code = generateCodeForTest(ast);
 
-    expect(code).toContain('const server = __createToolProxy(__server_tools)');
+    expect(code).toContain(
+      'const server = __createToolProxy(__server_tools, "server")'
+    );
     expect(code).toContain(
       'let result = await server.getTool("arg1", "arg2");'
     );
@@ -0,0 +1,76 @@
+// Codegen tests for test cases, hooks and fixtures
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Codegen - Test Declarations', () => {
+  it('should register test cases and run them after the script', () => {
+    const code = generateCodeForTest(
+      parseSource(`
+        test "prints" {
+          print(greeting)
+        }
+        greeting = "hello"
+      `)
+    );
+    expect(code).toContain(
+      '__tests.test("prints", async () => {\n  await print(greeting);\n});'
+    );
+    expect(code).toContain('await __tests.run();');
+    expect(code.indexOf('let greeting = "hello";')).toBeLessThan(
+      code.indexOf('// Register and run test cases')
+    );
+  });
+
+  it('should register hooks by when they run', () => {
+    const code = generateCodeForTest(
+      parseSource(`
+        beforeEach { mock("fs.read", "text") }
+        afterAll { print("done") }
+        test "reads" { print(1) }
+      `)
+    );
+    expect(code).toContain('__tests.beforeEach(async () => {');
+    expect(code).toContain('__tests.afterAll(async () => {');
+  });
+
+  it('should set fixtures as variables of the script', () => {
+    const code = generateCodeForTest(
+      parseSource(`
+        fixture workdir = fixtures.tempDir()
+        test "uses the directory" { print(workdir) }
+      `)
+    );
+    expect(code).toContain('let workdir;');
+    expect(code).toContain(
+      '__tests.fixture(async () => { workdir = await fixtures.tempDir(); });'
+    );
+  });
+
+  it('should not run test cases in scripts without any', () => {
+    expect(generateCodeForTest(parseSource('print(1)'))).not.toContain(
+      '__tests'
+    );
+  });
+
+  it('should only let test cases and hooks use fixtures', () => {
+    expect(() =>
+      generateCode(
+        parseSource(`
+          fixture issues = [1, 2]
+          beforeEach { print(issues) }
+          test "counts" { assertEqual(issues, [1, 2]) }
+        `)
+      )
+    ).not.toThrow();
+    expect(() =>
+      generateCode(
+        parseSource(`
+          fixture issues = [1, 2]
+          print(issues)
+        `)
+      )
+    ).toThrow("Undefined variable: 'issues'");
+  });
+});
@@ -218,4 +218,33 @@ describe('Codegen - Tool Declarations', () => {
     expect(parts[1]).toContain('let x = 5;');
     expect(parts[1]).not.toContain('const myTool');
   });
+
+  it('should give parameters their default values', () => {
+    const source = `
+      tool deploy(service: string, replicas: number = 3) {
+        return replicas
+      }
+    `;
+    const code = generateCodeUnsafe(parseSource(source));
+
+    expect(code).toContain('async (service, replicas = 3) =>');
+    expect(code).toContain(
+      'replicas: { type: "optional", schema: { type: "number" } }'
+    );
+  });
+
+  it('should run the main tool after the top-level code', () => {
+    const source = `
+      greeting = "Hello"
+      tool main(name: string) {
+        print(greeting + " " + name)
+      }
+    `;
+    const code = generateCodeUnsafe(parseSource(source));
+
+    const [, mainCode] = code.split('// Generated code');
+    expect(mainCode.trim()).toBe(
+      'let greeting = "Hello";\n\nawait __main(main);'
+    );
+  });
 });
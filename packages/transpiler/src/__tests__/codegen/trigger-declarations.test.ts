@@ -0,0 +1,74 @@
+// Codegen tests for trigger declarations
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { generateCodeForTest } from '../test-helpers.js';
+
+describe('Codegen - Trigger Declarations', () => {
+  it('should register scheduled and webhook triggers after the script', () => {
+    const statements = parseSource(`
+      tool processData(dir) { return dir }
+      trigger nightly: schedule("0 2 * * *") -> processData(dataDir)
+      trigger deploy: webhook() -> processData("/deploy")
+      dataDir = "/data"
+    `);
+    const code = generateCodeForTest(statements);
+    expect(code).toContain('// Register triggers');
+    expect(code).toContain(
+      '__trigger("nightly", "0 2 * * *", async () => await processData(dataDir));'
+    );
+    expect(code).toContain(
+      '__trigger("deploy", null, async () => await processData("/deploy"));'
+    );
+    expect(code.indexOf('let dataDir')).toBeLessThan(
+      code.indexOf('// Register triggers')
+    );
+  });
+
+  it('should keep the servers of a script with triggers running', () => {
+    const servers = 'mcp fs { command: "npx", args: [] }\n';
+    expect(generateCodeForTest(parseSource(servers))).toContain(
+      '// Cleanup MCP servers'
+    );
+    const code = generateCodeForTest(
+      parseSource(`${servers}trigger ping: webhook() -> fs.list()`)
+    );
+    expect(code).not.toContain('// Cleanup MCP servers');
+  });
+
+  it('should let actions use variables assigned after the trigger', () => {
+    const statements = parseSource(`
+      trigger nightly: schedule("@daily") -> print(message)
+      message = "done"
+    `);
+    expect(() => generateCode(statements)).not.toThrow();
+    expect(() =>
+      generateCode(parseSource('trigger t: webhook() -> print(missing)'))
+    ).toThrow("Undefined variable: 'missing'");
+  });
+
+  it('should reject invalid sources and schedules', () => {
+    expect(() =>
+      generateCodeForTest(parseSource('trigger t: every("1h") -> print(1)'))
+    ).toThrow(
+      'Trigger "t": expected schedule("<cron expression>") or webhook() before ->'
+    );
+    expect(() =>
+      generateCodeForTest(
+        parseSource('trigger t: schedule("0 25 * * *") -> print(1)')
+      )
+    ).toThrow(
+      'Trigger "t": Invalid schedule "0 25 * * *": hour 25 is out of range 0-23'
+    );
+  });
+
+  it('should reject triggers declared twice', () => {
+    const statements = parseSource(`
+      trigger t: webhook() -> print(1)
+      trigger t: webhook() -> print(2)
+    `);
+    expect(() => generateCodeForTest(statements)).toThrow(
+      'Trigger "t" is declared more than once'
+    );
+  });
+});
@@ -0,0 +1,128 @@
+// Tests for the structural diff of two versions of a script
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { diffPrograms, formatChange } from '../../diff.js';
+
+function diff(before: string, after: string): string[] {
+  return diffPrograms(parseSource(before), parseSource(after)).map(
+    formatChange
+  );
+}
+
+describe('diffPrograms', () => {
+  it('should report nothing for changes of layout and comments', () => {
+    expect(
+      diff(
+        'mcp fs { command: "npx" }\nx = fs.read("a")\n',
+        '// Files\nmcp fs {\n  command: "npx",\n}\n\nx = fs.read( "a" )\n'
+      )
+    ).toEqual([]);
+  });
+
+  it('should match declarations by name wherever they are', () => {
+    expect(
+      diff(
+        'mcp fs { command: "npx" }\nmodel claude { provider: "anthropic" }\n',
+        'model claude { provider: "anthropic" }\nmcp web { command: "npx" }\n'
+      )
+    ).toEqual(['+ Added mcp web', '- Removed mcp fs']);
+  });
+
+  it('should report configuration properties that changed', () => {
+    expect(
+      diff(
+        'mcp fs { command: "npx", args: ["a"] }',
+        'mcp fs { command: "uvx", env: { DEBUG: "1" } }'
+      )
+    ).toEqual([
+      '~ mcp fs: Changed command from "npx" to "uvx"',
+      '+ mcp fs: Added env: { DEBUG: "1" }',
+      '- mcp fs: Removed args: ["a"]',
+    ]);
+  });
+
+  it('should report what fires a trigger and what it does', () => {
+    expect(
+      diff(
+        'trigger nightly: schedule("0 2 * * *") -> run("/data")',
+        'trigger nightly: schedule("0 3 * * *") -> run("/archive")'
+      )
+    ).toEqual([
+      '~ trigger nightly: Changed source from schedule("0 2 * * *") to schedule("0 3 * * *")',
+      '~ trigger nightly: Changed action from run("/data") to run("/archive")',
+    ]);
+  });
+
+  it('should report the steps of pipelines by name', () => {
+    expect(
+      diff(
+        'pipeline report(repo) {\n  issues = fetch(repo)\n  sent = post(issues)\n}',
+        'pipeline report(repo) {\n  issues = fetch(repo, 5)\n  summary = summarize(issues)\n  return summary\n}'
+      )
+    ).toEqual([
+      '~ pipeline report: Changed step issues from fetch(repo) to fetch(repo, 5)',
+      '+ pipeline report: Added step summary = summarize(issues)',
+      '- pipeline report: Removed step sent = post(issues)',
+      '~ pipeline report: Changed result from null to summary',
+    ]);
+  });
+
+  it('should report changed tool signatures and bodies', () => {
+    expect(
+      diff(
+        'tool greet(name: string, loud: boolean) {\n  return fs.read(name)\n}',
+        'tool greet(loud: boolean, name?: string): string {\n  return db.read(name)\n}'
+      )
+    ).toEqual([
+      '~ tool greet: Changed parameter name: string to name?: string',
+      '~ tool greet: Reordered parameters',
+      '~ tool greet: Changed return type from any to string',
+      '> tool greet: Retargeted fs.read(...) to db.read(...)',
+    ]);
+  });
+
+  it('should tell retargeted calls from changed arguments', () => {
+    expect(
+      diff(
+        'x = fs.read("a")\ny = fs.list(x)\n',
+        'x = db.read("a")\ny = fs.list(x, 2) with { retries: 3 }\n'
+      )
+    ).toEqual([
+      '> Retargeted fs.read(...) to db.read(...)',
+      '~ Changed arguments of fs.list from (x) to (x, 2)',
+      '~ Changed options of fs.list from none to { retries: 3 }',
+    ]);
+  });
+
+  it('should diff the bodies of compound statements', () => {
+    expect(
+      diff(
+        'while (i < 3) {\n  print(i)\n  i = i + 1\n}',
+        'while (i < n) {\n  i = i + 1\n  log(i)\n}'
+      )
+    ).toEqual([
+      '~ Changed while (i < 3) to while (i < n)',
+      '- Removed print(i)',
+      '+ Added log(i)',
+    ]);
+  });
+
+  it('should report statements added and removed in order', () => {
+    expect(diff('a = 1\nb = 2\nc = 3\n', 'a = 1\nc = 3\nd = 4\n')).toEqual([
+      '- Removed b = 2',
+      '+ Added d = 4',
+    ]);
+  });
+
+  it('should locate changes in both versions', () => {
+    const [change] = diffPrograms(
+      parseSource('x = fs.read("a")'),
+      parseSource('\nx = db.read("a")')
+    );
+    expect(change).toMatchObject({
+      kind: 'retargeted',
+      before: { start: { line: 1, column: 5 } },
+      after: { start: { line: 2, column: 5 } },
+    });
+  });
+});
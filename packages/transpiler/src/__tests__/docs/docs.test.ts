@@ -0,0 +1,214 @@
+// Tests for the reference documentation of a script
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { collectDocs, docComment } from '../../docs.js';
+
+function docs(source: string, imported = '') {
+  return collectDocs(parseSource(source), source, parseSource(imported));
+}
+
+describe('docComment', () => {
+  it('should take the comment lines directly above a line', () => {
+    const lines = ['x = 1', '', '// First', '//', '//  Indented', 'y = 2'];
+    expect(docComment(lines, 6)).toBe('First\n\n Indented');
+  });
+
+  it('should stop at a blank line', () => {
+    expect(docComment(['// Unrelated', '', 'y = 2'], 3)).toBeUndefined();
+  });
+});
+
+describe('collectDocs', () => {
+  it('should document declarations with the comments above them', () => {
+    const result = docs(`// Report
+//
+// Summarizes the issues of the day.
+
+// Issues and pull requests
+mcp gh { url: "https://gh.example.com/mcp" }
+
+// Reads files under ./data
+mcp fs { command: "npx", args: ["-y", "server-filesystem", "./data"] }
+
+// Fast and cheap
+model gpt { provider: "openai", model: "gpt-4o-mini" }
+
+agent Writer { model: gpt, description: "Writes summaries" }
+`);
+
+    expect(result.doc).toBe('Report\n\nSummarizes the issues of the day.');
+    expect(result.servers).toEqual([
+      {
+        name: 'gh',
+        doc: 'Issues and pull requests',
+        target: 'https://gh.example.com/mcp',
+        tools: [],
+      },
+      {
+        name: 'fs',
+        doc: 'Reads files under ./data',
+        target: 'npx -y server-filesystem ./data',
+        tools: [],
+      },
+    ]);
+    expect(result.models).toEqual([
+      {
+        name: 'gpt',
+        doc: 'Fast and cheap',
+        provider: 'openai',
+        model: 'gpt-4o-mini',
+      },
+    ]);
+    // An agent without a comment is described by its description
+    expect(result.agents[0].doc).toBe('Writes summaries');
+  });
+
+  it('should not take the comment of the first declaration for the script', () => {
+    const result = docs(`// Files
+mcp fs { command: "fs-server" }
+`);
+    expect(result.doc).toBeUndefined();
+    expect(result.servers[0].doc).toBe('Files');
+  });
+
+  it('should give tool signatures with their parameter types', () => {
+    const result = docs(`
+// Fetch the open issues of a repository
+tool fetchIssues(repo: string, limit?: number): { title: string }[] {
+  return []
+}
+
+tool untyped(value) {
+  return value
+}
+`);
+
+    expect(result.tools).toEqual([
+      {
+        name: 'fetchIssues',
+        doc: 'Fetch the open issues of a repository',
+        signature:
+          'fetchIssues(repo: string, limit?: number): { title: string }[]',
+        parameters: [
+          { name: 'repo', optional: false, type: 'string' },
+          { name: 'limit', optional: true, type: 'number' },
+        ],
+        returnType: '{ title: string }[]',
+        mcpTools: [],
+      },
+      {
+        name: 'untyped',
+        doc: undefined,
+        signature: 'untyped(value)',
+        parameters: [{ name: 'value', optional: false }],
+        mcpTools: [],
+      },
+    ]);
+  });
+
+  it('should find the MCP tools used through tools and agents', () => {
+    const result = docs(`
+mcp fs { command: "fs-server" }
+mcp gh { command: "gh-server" }
+model gpt { provider: "openai", model: "gpt-4o" }
+
+tool fetch(repo) {
+  return gh.listIssues(repo)
+}
+
+tool report(repo) {
+  issues = fetch(repo)
+  fs.writeFile("report.md", issues)
+  return "Summarize these" | Lead
+}
+
+agent Researcher { model: gpt, tools: [fs.readFile] }
+agent Lead { model: gpt, tools: [Researcher, fetch] }
+
+report("mcpscript/mcpscript")
+`);
+
+    const tools = Object.fromEntries(
+      result.tools.map(tool => [tool.name, tool.mcpTools])
+    );
+    expect(tools).toEqual({
+      fetch: ['gh.listIssues'],
+      report: ['fs.readFile', 'fs.writeFile', 'gh.listIssues'],
+    });
+    expect(result.agents[1]).toMatchObject({
+      name: 'Lead',
+      delegates: ['Researcher'],
+      tools: ['fetch'],
+      mcpTools: ['fs.readFile', 'gh.listIssues'],
+    });
+    expect(result.mcpTools).toEqual([
+      'fs.readFile',
+      'fs.writeFile',
+      'gh.listIssues',
+    ]);
+    expect(result.servers.map(server => server.tools)).toEqual([
+      ['readFile', 'writeFile'],
+      ['listIssues'],
+    ]);
+  });
+
+  it('should let a whole server stand for its tools', () => {
+    const result = docs(`
+mcp fs { command: "fs-server" }
+model gpt { provider: "openai", model: "gpt-4o" }
+agent Editor { model: gpt, tools: [fs] }
+fs.readFile("notes.txt")
+x = "Tidy up" | Editor
+`);
+    expect(result.mcpTools).toEqual(['fs.*']);
+    expect(result.servers[0].tools).toEqual(['*']);
+  });
+
+  it('should follow calls into imported declarations without documenting them', () => {
+    const result = docs(
+      `
+tool triage() {
+  return gh.listIssues("mcpscript/mcpscript")
+}
+`,
+      `mcp gh { command: "gh-server" }`
+    );
+    expect(result.servers).toEqual([]);
+    expect(result.tools[0].mcpTools).toEqual(['gh.listIssues']);
+  });
+
+  it('should handle tools that call each other', () => {
+    const result = docs(`
+mcp fs { command: "fs-server" }
+tool walk(path) {
+  fs.listDirectory(path)
+  return walk(path)
+}
+`);
+    expect(result.tools[0].mcpTools).toEqual(['fs.listDirectory']);
+  });
+
+  it('should split @param lines out of the doc of a tool', () => {
+    const result = docs(`
+// Deploy a service
+//
+// @param service Service to deploy
+tool main(service: string, replicas: number = 3) {
+  print(service)
+}
+`);
+    expect(result.tools[0]).toMatchObject({
+      doc: 'Deploy a service',
+      signature: 'main(service: string, replicas: number = 3)',
+      parameters: [
+        {
+          name: 'service',
+          type: 'string',
+          optional: false,
+          doc: 'Service to deploy',
+        },
+        { name: 'replicas', type: 'number', optional: false, default: '3' },
+      ],
+    });
+  });
+});
@@ -0,0 +1,55 @@
+import { describe, it, expect } from 'vitest';
+import {
+  group,
+  hardline,
+  ifBreak,
+  indent,
+  join,
+  line,
+  print,
+  softline,
+  type Doc,
+} from '../../formatter/doc.js';
+
+const list = (items: string[]): Doc =>
+  group([
+    '[',
+    indent([softline, join([',', line], items)]),
+    ifBreak(','),
+    softline,
+    ']',
+  ]);
+
+const layout = (doc: Doc, width = 20) => print(doc, { width, indentWidth: 2 });
+
+describe('Formatter - Doc printer', () => {
+  it('should keep a group flat when it fits', () => {
+    expect(layout(['x = ', list(['1', '2', '3'])])).toBe('x = [1, 2, 3]');
+  });
+
+  it('should break a group that does not fit', () => {
+    expect(layout(['x = ', list(['"alpha"', '"beta"', '"gamma"'])])).toBe(
+      'x = [\n  "alpha",\n  "beta",\n  "gamma",\n]'
+    );
+  });
+
+  it('should count the text after a group against the width', () => {
+    const doc = ['call', list(['aaaa', 'bbbb']), ' + something'];
+    expect(layout(doc)).toBe('call[\n  aaaa,\n  bbbb,\n] + something');
+  });
+
+  it('should break groups that contain a hard line', () => {
+    const doc = group(['{', indent([line, 'a', hardline, 'b']), line, '}']);
+    expect(layout(doc, 80)).toBe('{\n  a\n  b\n}');
+  });
+
+  it('should break only the outer group when that is enough', () => {
+    const doc = list(['1', list(['2', '3']), '"a long string"']);
+    expect(layout(doc)).toBe('[\n  1,\n  [2, 3],\n  "a long string",\n]');
+  });
+
+  it('should not indent blank lines', () => {
+    const doc = ['{', indent([hardline, 'a', hardline, hardline, 'b']), '}'];
+    expect(layout(doc)).toBe('{\n  a\n\n  b}');
+  });
+});
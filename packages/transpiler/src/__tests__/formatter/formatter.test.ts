@@ -0,0 +1,330 @@
+import { describe, it, expect } from 'vitest';
+import { formatSource } from '../../formatter.js';
+
+describe('Formatter', () => {
+  it('should normalize spacing in statements', () => {
+    expect(formatSource('x=1+2*3\nprint( x,"done" )')).toBe(
+      'x = 1 + 2 * 3\nprint(x, "done")\n'
+    );
+  });
+
+  it('should keep parentheses and unary operators as written', () => {
+    expect(formatSource('y = ( a+b ) * -c\nz = !done')).toBe(
+      'y = (a + b) * -c\nz = !done\n'
+    );
+  });
+
+  it('should format member and bracket access', () => {
+    expect(formatSource('v = items [ 0 ] . name')).toBe('v = items[0].name\n');
+  });
+
+  it('should return an empty string for an empty file', () => {
+    expect(formatSource('')).toBe('');
+    expect(formatSource('\n\n')).toBe('');
+  });
+
+  it('should format import statements', () => {
+    expect(formatSource("import   './servers.mcps'\nx = 1")).toBe(
+      "import './servers.mcps'\nx = 1\n"
+    );
+  });
+
+  it('should organize imports when asked to', () => {
+    const source = 'import "./b"\nimport "./c"\nimport "./a"\nx=1\n';
+    expect(formatSource(source)).toBe(source.replace('x=1', 'x = 1'));
+    expect(
+      formatSource(source, { organizeImports: true, unusedImports: ['./c'] })
+    ).toBe('import "./a"\nimport "./b"\nx = 1\n');
+  });
+
+  it('should expand mcp configuration blocks', () => {
+    const source = 'mcp filesystem { command: "npx", args: ["-y", "pkg"] }';
+    expect(formatSource(source)).toBe(`mcp filesystem {
+  command: "npx",
+  args: ["-y", "pkg"],
+}
+`);
+  });
+
+  it('should expand model and agent configuration blocks', () => {
+    const source = `model gpt {provider:"openai",model:"gpt-4o"}
+agent helper {model:gpt,tools:[filesystem.read_file]}`;
+    expect(formatSource(source)).toBe(`model gpt {
+  provider: "openai",
+  model: "gpt-4o",
+}
+agent helper {
+  model: gpt,
+  tools: [filesystem.read_file],
+}
+`);
+  });
+
+  it('should format trigger declarations', () => {
+    expect(
+      formatSource('trigger  nightly :schedule( "0 2 * * *" )->run("/data")')
+    ).toBe('trigger nightly: schedule("0 2 * * *") -> run("/data")\n');
+  });
+
+  it('should format pipeline declarations one step per line', () => {
+    const source = `pipeline report(repo:string):string{
+issues=fetchIssues(repo)
+// Triage concurrently
+triaged=each(issues,limit:5){issue=>triage(issue)}
+summary=if(urgent)escalate(triaged)else summarize(triaged)
+sent=parallel{chat:post(summary),mail:mail(summary)}
+return summary
+}`;
+    expect(formatSource(source)).toBe(`pipeline report(repo: string): string {
+  issues = fetchIssues(repo)
+  // Triage concurrently
+  triaged = each(issues, limit: 5) { issue => triage(issue) }
+  summary = if (urgent) escalate(triaged) else summarize(triaged)
+  sent = parallel { chat: post(summary), mail: mail(summary) }
+  return summary
+}
+`);
+  });
+
+  it('should format tool declarations with types', () => {
+    const source = `tool add(a:number,b?:number):number{
+return a+b
+}`;
+    expect(formatSource(source)).toBe(`tool add(a: number, b?: number): number {
+  return a + b
+}
+`);
+  });
+
+  it('should format object, array and union types', () => {
+    const source = 'tool f(p:{name:string,tags?:string[]},q:string|null){}';
+    expect(formatSource(source)).toBe(
+      'tool f(p: { name: string, tags?: string[] }, q: string | null) {}\n'
+    );
+  });
+
+  it('should indent nested blocks', () => {
+    const source = `if(x>1){print("big")}else if(x>0){
+print("small")
+}else{while(true){break}}`;
+    expect(formatSource(source)).toBe(`if (x > 1) {
+  print("big")
+} else if (x > 0) {
+  print("small")
+} else {
+  while (true) {
+    break
+  }
+}
+`);
+  });
+
+  it('should start else on a new line after a statement body', () => {
+    expect(formatSource('if (x) y = 1 else y = 2')).toBe(
+      'if (x) y = 1\nelse y = 2\n'
+    );
+  });
+
+  it('should format parallel blocks', () => {
+    expect(formatSource('parallel(2){a=f(1)\nb=f(2)}')).toBe(
+      'parallel (2) {\n  a = f(1)\n  b = f(2)\n}\n'
+    );
+    expect(formatSource('parallel{}')).toBe('parallel {}\n');
+  });
+
+  it('should format parallel maps', () => {
+    expect(formatSource('r=parallelMap( xs,limit:5 ){x=>f(x)}')).toBe(
+      'r = parallelMap(xs, limit: 5) { x => f(x) }\n'
+    );
+    expect(formatSource('parallelMap(xs){x=>a=f(x)\nprint(a)}')).toBe(
+      'parallelMap(xs) { x =>\n  a = f(x)\n  print(a)\n}\n'
+    );
+  });
+
+  it('should put annotations above their statement', () => {
+    expect(formatSource('@idempotent( key:id )o=shop.order(cart)')).toBe(
+      '@idempotent(key: id)\no = shop.order(cart)\n'
+    );
+    expect(formatSource('@trace  total=a+b')).toBe('@trace\ntotal = a + b\n');
+  });
+
+  it('should format compensate blocks', () => {
+    expect(formatSource('t=jira.create(i)compensate{jira.delete(t)}')).toBe(
+      't = jira.create(i) compensate {\n  jira.delete(t)\n}\n'
+    );
+  });
+
+  it('should format event handlers', () => {
+    expect(formatSource('on event( "a.b" )(p:{v:string}){print(p.v)}')).toBe(
+      'on event("a.b") (p: { v: string }) {\n  print(p.v)\n}\n'
+    );
+  });
+
+  it('should format test cases, hooks and fixtures', () => {
+    expect(
+      formatSource(
+        'fixture dir=fixtures.tempDir( )\nbeforeEach{mock("fs.read","a")}\ntest "reads"{print(dir)}'
+      )
+    ).toBe(
+      'fixture dir = fixtures.tempDir()\nbeforeEach {\n  mock("fs.read", "a")\n}\ntest "reads" {\n  print(dir)\n}\n'
+    );
+  });
+
+  it('should format notification handlers and listen statements', () => {
+    expect(
+      formatSource('on notification( fs,"resources/updated",uri )(u){n=n+1}')
+    ).toBe(
+      'on notification(fs, "resources/updated", uri) (u) {\n  n = n + 1\n}\n'
+    );
+    expect(formatSource('listen( until:n>=3,timeout:10m )\nlisten( )')).toBe(
+      'listen(until: n >= 3, timeout: 10m)\nlisten()\n'
+    );
+  });
+
+  it('should format default values of parameters', () => {
+    expect(formatSource('tool f(a:number=1,b=a+1){return b}')).toBe(
+      'tool f(a: number = 1, b = a + 1) {\n  return b\n}\n'
+    );
+  });
+
+  it('should format call options and keep durations as written', () => {
+    expect(formatSource('t=fs.read( p )with{timeout:1.5s,retries:2}')).toBe(
+      't = fs.read(p) with { timeout: 1.5s, retries: 2 }\n'
+    );
+  });
+
+  it('should format for loop clauses', () => {
+    expect(formatSource('for(i=0;i<3;i=i+1){print(i)}')).toBe(
+      'for (i = 0; i < 3; i = i + 1) {\n  print(i)\n}\n'
+    );
+    expect(formatSource('for(;;){break}')).toBe('for (;;) {\n  break\n}\n');
+  });
+
+  it('should collapse blank lines between statements', () => {
+    expect(formatSource('x = 1\n\n\n\ny = 2\nz = 3\n\n')).toBe(
+      'x = 1\n\ny = 2\nz = 3\n'
+    );
+  });
+
+  it('should break long argument lists with trailing commas', () => {
+    const source =
+      'result = summarize("the first argument to summarize", "the second argument", 12345)';
+    expect(formatSource(source)).toBe(`result = summarize(
+  "the first argument to summarize",
+  "the second argument",
+  12345,
+)
+`);
+  });
+
+  it('should let a lone object argument hug the parentheses', () => {
+    const source =
+      'filesystem.write_file({ path: "notes/summary.txt", content: summary })';
+    expect(formatSource(source, { printWidth: 40 })).toBe(
+      `filesystem.write_file({
+  path: "notes/summary.txt",
+  content: summary,
+})
+`
+    );
+  });
+
+  it('should keep objects expanded when written across lines', () => {
+    expect(formatSource('config = {\n  a: 1, b: 2 }')).toBe(
+      'config = {\n  a: 1,\n  b: 2,\n}\n'
+    );
+    expect(formatSource('config = { a: 1,\n  b: 2 }')).toBe(
+      'config = { a: 1, b: 2 }\n'
+    );
+  });
+
+  it('should keep strings exactly as written', () => {
+    const source = "a = 'single'\nb = \"dou\\\"ble\"\nc = `multi\n  line`";
+    expect(formatSource(source)).toBe(`${source}\n`);
+  });
+
+  it('should honor the indent width option', () => {
+    expect(formatSource('tool f() {\nreturn 1\n}', { indentWidth: 4 })).toBe(
+      'tool f() {\n    return 1\n}\n'
+    );
+  });
+
+  it('should reject source with syntax errors', () => {
+    expect(() => formatSource('x = (1 + 2')).toThrow(/Parse error/);
+  });
+
+  describe('comments', () => {
+    it('should keep comments on their own lines and after statements', () => {
+      const source = `// Header comment
+x = 1   // the answer
+
+
+// About y
+y = 2`;
+      expect(formatSource(source)).toBe(`// Header comment
+x = 1 // the answer
+
+// About y
+y = 2
+`);
+    });
+
+    it('should keep comments inside blocks', () => {
+      const source = `tool f() {
+// leading
+x = 1
+    // trailing
+}`;
+      expect(formatSource(source)).toBe(`tool f() {
+  // leading
+  x = 1
+  // trailing
+}
+`);
+    });
+
+    it('should keep comments inside configuration blocks', () => {
+      const source = `mcp filesystem {
+    // How to start the server
+    command: "npx",   // from npm
+    args: ["-y", "@modelcontextprotocol/server-filesystem"]
+}`;
+      expect(formatSource(source)).toBe(`mcp filesystem {
+  // How to start the server
+  command: "npx", // from npm
+  args: ["-y", "@modelcontextprotocol/server-filesystem"],
+}
+`);
+    });
+
+    it('should expand lists that contain comments', () => {
+      const source = `items = [1, // one
+2]`;
+      expect(formatSource(source)).toBe(`items = [
+  1, // one
+  2,
+]
+`);
+    });
+  });
+
+  it('should be idempotent', () => {
+    const source = `// Summarize files
+mcp filesystem {command:"npx",args:["-y","@modelcontextprotocol/server-filesystem","."]}
+model claude {provider:"anthropic",model:"claude-sonnet-4-5",temperature:0.2}
+
+tool summarize(path:string,maxWords?:number):string{
+  content=filesystem.read_file({path:path})
+  if(!maxWords){maxWords=100}
+  // Keep it short
+  return content|summarizer
+}
+
+files=["a.txt","b.txt"]
+for(i=0;i<files.length;i=i+1){
+  print(summarize(files[i],50))
+}`;
+    const once = formatSource(source);
+    expect(formatSource(once)).toBe(once);
+  });
+});
@@ -0,0 +1,108 @@
+// Tests for the inspection helpers
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import {
+  callGraph,
+  listMCPServers,
+  listWorkflows,
+  toolCallsIn,
+} from '../../inspect.js';
+
+const SOURCE = `mcp fs { command: "npx", args: ["-y", "server-filesystem", "/tmp"] }
+mcp gh { url: "https://gh.example.com/mcp" }
+model gpt { provider: "openai", model: "gpt-4o" }
+
+tool readNotes(path) {
+  return fs.readFile(path)
+}
+
+agent Researcher { model: gpt, tools: [gh.search_issues, readNotes] }
+agent Editor { model: gpt, tools: [Researcher, fs] }
+
+trigger nightly: schedule("0 2 * * *") -> readNotes("/tmp/today.md")
+
+notes = readNotes("/tmp/notes.md")
+summary = notes | Editor
+fs.writeFile("/tmp/summary.md", summary)
+`;
+
+describe('listMCPServers', () => {
+  it('should list servers with how they are reached', () => {
+    const servers = listMCPServers(parseSource(SOURCE));
+
+    expect(servers.map(({ location, ...server }) => server)).toEqual([
+      {
+        name: 'fs',
+        transport: 'stdio',
+        command: 'npx',
+        args: ['-y', 'server-filesystem', '/tmp'],
+      },
+      { name: 'gh', transport: 'http', url: 'https://gh.example.com/mcp' },
+    ]);
+    expect(servers[1].location?.start).toMatchObject({ line: 2, column: 1 });
+  });
+});
+
+describe('listWorkflows', () => {
+  it('should list the top-level code, tools, agents and triggers', () => {
+    const workflows = listWorkflows(parseSource(SOURCE));
+
+    expect(
+      workflows.map(w => [w.kind, w.name, w.location?.start.line])
+    ).toEqual([
+      ['script', 'script', 14],
+      ['tool', 'readNotes', 5],
+      ['agent', 'Researcher', 9],
+      ['agent', 'Editor', 10],
+      ['trigger', 'nightly', 12],
+    ]);
+  });
+});
+
+describe('toolCallsIn', () => {
+  it('should find the MCP tool calls of code and the tools of agents', () => {
+    const workflows = listWorkflows(parseSource(SOURCE));
+    const calls = (name: string) =>
+      toolCallsIn(workflows.find(w => w.name === name)!).map(
+        ({ server, tool, via, location }) => [
+          `${server}.${tool}`,
+          via,
+          location?.start.line,
+        ]
+      );
+
+    expect(calls('script')).toEqual([['fs.writeFile', 'call', 16]]);
+    expect(calls('readNotes')).toEqual([['fs.readFile', 'call', 6]]);
+    expect(calls('Researcher')).toEqual([['gh.search_issues', 'agent', 9]]);
+    expect(calls('Editor')).toEqual([['fs.*', 'agent', 10]]);
+  });
+});
+
+describe('callGraph', () => {
+  it('should connect workflows to what they call', () => {
+    const graph = callGraph(parseSource(SOURCE));
+
+    expect(graph.nodes.map(node => node.id)).toEqual([
+      'script',
+      'tool:readNotes',
+      'agent:Researcher',
+      'agent:Editor',
+      'trigger:nightly',
+      'mcp:fs.writeFile',
+      'mcp:fs.readFile',
+      'mcp:gh.search_issues',
+      'mcp:fs.*',
+    ]);
+    expect(graph.edges.map(({ from, to }) => `${from} -> ${to}`)).toEqual([
+      'script -> mcp:fs.writeFile',
+      'script -> tool:readNotes',
+      'script -> agent:Editor',
+      'tool:readNotes -> mcp:fs.readFile',
+      'agent:Researcher -> mcp:gh.search_issues',
+      'agent:Researcher -> tool:readNotes',
+      'agent:Editor -> mcp:fs.*',
+      'agent:Editor -> agent:Researcher',
+      'trigger:nightly -> tool:readNotes',
+    ]);
+  });
+});
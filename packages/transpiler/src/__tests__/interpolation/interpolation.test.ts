@@ -0,0 +1,85 @@
+// Tests for ${NAME} and ${secret:NAME} references in server options
+import { describe, it, expect } from 'vitest';
+import {
+  interpolatedStrings,
+  interpolationReferences,
+  parseInterpolation,
+} from '../../interpolation.js';
+import type { Expression } from '../../ast.js';
+
+describe('parseInterpolation', () => {
+  it('should split text and references', () => {
+    expect(parseInterpolation('Bearer ${secret:TOKEN} for ${USER}')).toEqual([
+      'Bearer ',
+      { secret: 'TOKEN' },
+      ' for ',
+      { env: 'USER' },
+    ]);
+    expect(parseInterpolation('${HOME}')).toEqual([{ env: 'HOME' }]);
+    expect(parseInterpolation('')).toEqual(['']);
+  });
+
+  it('should read $${ as a literal ${', () => {
+    expect(parseInterpolation('$${HOME} is ${HOME}')).toEqual([
+      '${HOME} is ',
+      { env: 'HOME' },
+    ]);
+  });
+
+  it('should reject malformed references', () => {
+    expect(() => parseInterpolation('${HOME')).toThrow(
+      'Unterminated reference in "${HOME"; write $${ for a literal ${'
+    );
+    expect(() => parseInterpolation('${vault:TOKEN}')).toThrow(
+      'Invalid reference ${vault:TOKEN}; expected ${NAME} or ${secret:NAME}'
+    );
+    expect(() => parseInterpolation('${}')).toThrow('Invalid reference ${}');
+  });
+
+  it('should split project metadata references only where allowed', () => {
+    expect(
+      parseInterpolation('Reviews for ${project.name}', { project: true })
+    ).toEqual(['Reviews for ', { project: 'name' }]);
+    expect(() => parseInterpolation('${project.name}')).toThrow(
+      'Invalid reference ${project.name}; expected ${NAME} or ${secret:NAME}'
+    );
+  });
+});
+
+describe('interpolationReferences', () => {
+  it('should list the references of parts', () => {
+    expect(
+      interpolationReferences(['a', { env: 'HOME' }, { secret: 'TOKEN' }])
+    ).toEqual([
+      { kind: 'env', name: 'HOME' },
+      { kind: 'secret', name: 'TOKEN' },
+    ]);
+  });
+});
+
+describe('interpolatedStrings', () => {
+  it('should find interpolated strings in arrays and objects', () => {
+    const home: Expression = { type: 'string', value: '${HOME}' };
+    const token: Expression = { type: 'string', value: '${secret:TOKEN}' };
+    const value: Expression = {
+      type: 'object',
+      properties: [
+        {
+          type: 'property',
+          key: 'args',
+          value: {
+            type: 'array',
+            elements: [{ type: 'string', value: '-v' }, home],
+          },
+        },
+        { type: 'property', key: 'token', value: token },
+        {
+          type: 'property',
+          key: 'user',
+          value: { type: 'identifier', name: 'user' },
+        },
+      ],
+    };
+    expect(interpolatedStrings(value)).toEqual([home, token]);
+  });
+});
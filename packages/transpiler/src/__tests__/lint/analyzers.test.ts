@@ -0,0 +1,145 @@
+import { describe, it, expect } from 'vitest';
+import { parseTree } from '../../syntax.js';
+import { lint, LINT_API_VERSION } from '../../lint.js';
+import {
+  analyzerRules,
+  loadAnalyzer,
+  type AnalyzerExports,
+} from '../../lint/analyzers.js';
+
+const RULES = [
+  {
+    name: 'agent-model',
+    description: 'Agents without a model',
+    severity: 'error',
+  },
+];
+
+/**
+ * An analyzer working on the memory of a WebAssembly module the way a
+ * compiled one does, answering each script with what answer returns
+ */
+function fakeAnalyzer(
+  answer: (input: any) => unknown,
+  version = LINT_API_VERSION
+): AnalyzerExports {
+  const memory = new WebAssembly.Memory({ initial: 1 });
+  let free = 0;
+  const alloc = (size: number) => {
+    const pointer = free;
+    free += size;
+    return pointer;
+  };
+  const write = (value: unknown): bigint => {
+    const bytes = new TextEncoder().encode(JSON.stringify(value));
+    const pointer = alloc(bytes.length);
+    new Uint8Array(memory.buffer, pointer, bytes.length).set(bytes);
+    return (BigInt(pointer) << 32n) | BigInt(bytes.length);
+  };
+  return {
+    memory,
+    mcps_api_version: () => version,
+    mcps_alloc: alloc,
+    mcps_rules: () => write(RULES),
+    mcps_analyze: (pointer, length) =>
+      write(
+        answer(
+          JSON.parse(
+            new TextDecoder().decode(
+              new Uint8Array(memory.buffer, pointer, length)
+            )
+          )
+        )
+      ),
+  };
+}
+
+describe('analyzerRules', () => {
+  it('should report what an analyzer finds in the AST', () => {
+    const inputs: any[] = [];
+    const rules = analyzerRules(
+      fakeAnalyzer(input => {
+        inputs.push(input);
+        return {
+          diagnostics: input.statements
+            .filter(
+              (statement: any) =>
+                statement.type === 'agent_declaration' &&
+                !statement.config.properties.some(
+                  (property: any) => property.key === 'model'
+                )
+            )
+            .map((statement: any) => ({
+              rule: 'agent-model',
+              message: `Agent ${statement.name} has no model`,
+              start: statement.location.start,
+              end: statement.location.end,
+            })),
+        };
+      }),
+      'conventions.wasm'
+    );
+    expect(rules).toMatchObject(RULES);
+
+    const source = 'agent Triager { systemPrompt: "Triage" }\n';
+    const diagnostics = lint(parseTree(source), source, {
+      rules,
+      path: '/project/triage.mcps',
+    });
+    expect(diagnostics).toEqual([
+      {
+        rule: 'agent-model',
+        message: 'Agent Triager has no model',
+        severity: 'error',
+        location: {
+          start: { line: 1, column: 1, offset: 0 },
+          end: { line: 1, column: 41, offset: 40 },
+        },
+      },
+    ]);
+    expect(inputs).toMatchObject([
+      {
+        apiVersion: LINT_API_VERSION,
+        path: '/project/triage.mcps',
+        symbols: [{ name: 'Triager', kind: 'agent', references: [] }],
+      },
+    ]);
+  });
+
+  it('should refuse analyzers written for a later API version', () => {
+    expect(() =>
+      analyzerRules(
+        fakeAnalyzer(() => ({}), LINT_API_VERSION + 1),
+        'next.wasm'
+      )
+    ).toThrow(
+      `Analyzer next.wasm needs lint API version ${LINT_API_VERSION + 1}`
+    );
+  });
+
+  it('should refuse problems of rules an analyzer did not describe', () => {
+    const rules = analyzerRules(
+      fakeAnalyzer(() => ({
+        diagnostics: [{ rule: 'other', message: '', start: {} }],
+      })),
+      'conventions.wasm'
+    );
+    const source = 'x = 1\n';
+    expect(() => lint(parseTree(source), source, { rules })).toThrow(
+      'Analyzer conventions.wasm reported a problem of undescribed rule "other"'
+    );
+  });
+});
+
+describe('loadAnalyzer', () => {
+  it('should refuse modules that are not analyzers', async () => {
+    // An empty module: the magic number and version only
+    const empty = new Uint8Array([0, 97, 115, 109, 1, 0, 0, 0]);
+    await expect(loadAnalyzer(empty, 'empty.wasm')).rejects.toThrow(
+      'Analyzer empty.wasm does not export mcps_api_version'
+    );
+    await expect(
+      loadAnalyzer(new Uint8Array([1, 2, 3]), 'broken.wasm')
+    ).rejects.toThrow('Analyzer broken.wasm could not be loaded');
+  });
+});
@@ -0,0 +1,113 @@
+import { describe, it, expect } from 'vitest';
+import { parseTree } from '../../syntax.js';
+import { lint, LINT_PACKS, PROMPT_LINT_RULES } from '../../lint.js';
+
+function problems(source: string) {
+  return lint(parseTree(source), source, { rules: PROMPT_LINT_RULES }).map(
+    d => ({ rule: d.rule, message: d.message, line: d.location.start.line })
+  );
+}
+
+describe('prompt lint pack', () => {
+  it('should be a lint pack off by default', () => {
+    expect(LINT_PACKS.prompts).toBe(PROMPT_LINT_RULES);
+    const source = 'prompt Draft { text: "Summarize {{issue}}" }\n';
+    expect(lint(parseTree(source), source)).toEqual([]);
+  });
+
+  describe('prompt-placeholder', () => {
+    it('should report placeholders left in prompts', () => {
+      const source =
+        'prompt Triage { text: "Sort [insert labels here] by {{priority}}. TODO" }\n';
+      expect(problems(source).map(p => p.message)).toEqual([
+        "Prompt has an unfilled placeholder '{{priority}}'",
+        "Prompt has an unfilled placeholder '[insert labels here]'",
+        "Prompt has an unfilled placeholder 'TODO'",
+      ]);
+    });
+
+    it('should report references agents do not resolve', () => {
+      const source =
+        'prompt Review { text: "Reviews for ${project.name}" }\n' +
+        'agent Bot { model: m, systemPrompt: "Work for ${USER}" }\n';
+      expect(problems(source)).toEqual([
+        {
+          rule: 'prompt-placeholder',
+          message: "Prompt has an unfilled placeholder '${USER}'",
+          line: 2,
+        },
+      ]);
+    });
+  });
+
+  describe('prompt-conflict', () => {
+    it('should report instructions asking for opposite things', () => {
+      const source =
+        'prompt Writer {\n' +
+        '  text: "Be concise. Explain each change in detail. Reply in JSON."\n' +
+        '}\n';
+      expect(problems(source)).toEqual([
+        {
+          rule: 'prompt-conflict',
+          message: "Prompt asks for both 'Be concise' and 'in detail'",
+          line: 2,
+        },
+      ]);
+    });
+
+    it('should report actions both required and forbidden', () => {
+      const source =
+        'agent Bot {\n' +
+        '  model: m,\n' +
+        '  systemPrompt: "Always cite sources. Never cite sources for code."\n' +
+        '}\n';
+      expect(problems(source).map(p => p.message)).toEqual([
+        "Prompt asks for both 'Always cite sources' and 'Never cite sources for'",
+      ]);
+    });
+
+    it('should accept consistent instructions', () => {
+      const source =
+        'prompt Writer { text: "Always be polite. Never reveal secrets." }\n';
+      expect(problems(source)).toEqual([]);
+    });
+  });
+
+  describe('prompt-length', () => {
+    const model =
+      'model small { provider: "local", model: "tiny", contextWindow: 100 }\n';
+
+    it("should report system prompts taking much of the model's window", () => {
+      const text = 'Review the code. '.repeat(20);
+      const source =
+        model +
+        `prompt Review { text: "${text}" }\n` +
+        'agent Reviewer { model: small, systemPrompt: Review }\n' +
+        `agent Inline { model: small, systemPrompt: "${text}" }\n`;
+      expect(problems(source)).toEqual([
+        {
+          rule: 'prompt-length',
+          message:
+            "System prompt of agent 'Reviewer' takes about 85 of the 100 tokens of model 'small'",
+          line: 3,
+        },
+        {
+          rule: 'prompt-length',
+          message:
+            "System prompt of agent 'Inline' takes about 85 of the 100 tokens of model 'small'",
+          line: 4,
+        },
+      ]);
+    });
+
+    it('should not check models without a context window', () => {
+      const source =
+        'model big { provider: "openai", model: "gpt-4o" }\n' +
+        `agent Bot { model: big, systemPrompt: "${'x'.repeat(1000)}" }\n`;
+      expect(problems(source)).toEqual([]);
+      expect(
+        problems(model + 'agent Bot { model: small, systemPrompt: "Hi" }\n')
+      ).toEqual([]);
+    });
+  });
+});
@@ -0,0 +1,161 @@
+import { describe, it, expect } from 'vitest';
+import { parseTree } from '../../syntax.js';
+import { lint, type LintRule } from '../../lint.js';
+
+function problems(source: string) {
+  return lint(parseTree(source), source).map(d => ({
+    rule: d.rule,
+    message: d.message,
+    line: d.location.start.line,
+  }));
+}
+
+describe('lint', () => {
+  describe('unused-variable', () => {
+    it('should report variables that are never read', () => {
+      expect(problems('x = 1\ny = 2\nprint(y)\n')).toEqual([
+        {
+          rule: 'unused-variable',
+          message: "'x' is assigned but never used",
+          line: 1,
+        },
+      ]);
+    });
+
+    it('should ignore variables starting with an underscore', () => {
+      expect(problems('_x = 1\n')).toEqual([]);
+    });
+
+    it('should count reads inside tools and loops', () => {
+      const source =
+        'total = 0\nfor (i = 0; i < 3; i = i + 1) {\n' +
+        '  total = total + i\n}\nprint(total)\n';
+      expect(problems(source)).toEqual([]);
+    });
+  });
+
+  describe('unreachable-code', () => {
+    it('should report statements after return', () => {
+      const source =
+        'tool f(x) {\n  return x\n  print(x)\n  print(x)\n}\nprint(f(1))\n';
+      const [problem] = lint(parseTree(source), source);
+      expect(problem).toMatchObject({
+        rule: 'unreachable-code',
+        message: "Unreachable code after 'return'",
+        severity: 'warning',
+      });
+      expect(problem.location.start.line).toBe(3);
+      expect(problem.location.end.line).toBe(4);
+    });
+
+    it('should report statements after break and continue', () => {
+      const source =
+        'while (true) {\n  break\n  print(1)\n}\n' +
+        'while (true) {\n  continue\n  // note\n}\n';
+      expect(problems(source)).toEqual([
+        {
+          rule: 'unreachable-code',
+          message: "Unreachable code after 'break'",
+          line: 3,
+        },
+      ]);
+    });
+  });
+
+  describe('duplicate-declaration', () => {
+    it('should report declarations of the same name', () => {
+      const source =
+        'tool f() {\n  return 1\n}\ntool f() {\n  return 2\n}\nprint(f())\n';
+      expect(problems(source)).toEqual([
+        {
+          rule: 'duplicate-declaration',
+          message: "'f' is already declared",
+          line: 4,
+        },
+      ]);
+    });
+
+    it('should report duplicate parameters', () => {
+      const source = 'tool f(a, a) {\n  return a\n}\nprint(f(1, 2))\n';
+      expect(problems(source)).toMatchObject([
+        { rule: 'duplicate-declaration', line: 1 },
+      ]);
+    });
+  });
+
+  describe('unknown-mcp-server', () => {
+    it('should report agent tools that are not tools, agents or servers', () => {
+      const source = `model gpt {
+  provider: "openai"
+}
+mcp files {
+  command: "npx"
+}
+tool twice(x) {
+  return x * 2
+}
+agent reviewer {
+  model: gpt
+}
+agent helper {
+  model: gpt,
+  tools: [files, files.readFile, twice, reviewer, gpt, twice.name]
+}
+`;
+      expect(problems(source)).toEqual([
+        {
+          rule: 'unknown-mcp-server',
+          message: "'gpt' is a model, not a tool, agent or MCP server",
+          line: 15,
+        },
+        {
+          rule: 'unknown-mcp-server',
+          message: "'twice' is a tool, not an MCP server",
+          line: 15,
+        },
+      ]);
+    });
+  });
+
+  describe('configuration', () => {
+    it('should apply severities and turn rules off', () => {
+      const source = 'x = 1\n';
+      const tree = parseTree(source);
+      expect(
+        lint(tree, source, { severities: { 'unused-variable': 'error' } })
+      ).toMatchObject([{ severity: 'error' }]);
+      expect(
+        lint(tree, source, { severities: { 'unused-variable': 'off' } })
+      ).toEqual([]);
+    });
+
+    it('should run custom rules', () => {
+      const noPrint: LintRule = {
+        name: 'no-print',
+        description: 'Calls to print',
+        severity: 'warning',
+        check({ tree, report }) {
+          for (const call of tree.rootNode.descendantsOfType(
+            'call_expression'
+          )) {
+            if (call.firstNamedChild?.text === 'print') {
+              report(call, 'Use log instead of print');
+            }
+          }
+        },
+      };
+      const source = 'print(1)\n';
+      expect(lint(parseTree(source), source, { rules: [noPrint] })).toEqual([
+        {
+          rule: 'no-print',
+          message: 'Use log instead of print',
+          severity: 'warning',
+          location: {
+            start: { line: 1, column: 1, offset: 0 },
+            end: { line: 1, column: 9, offset: 8 },
+          },
+        },
+      ]);
+    });
+  });
+});
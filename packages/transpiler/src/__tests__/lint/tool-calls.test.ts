@@ -0,0 +1,146 @@
+import { describe, it, expect } from 'vitest';
+import { parseTree } from '../../syntax.js';
+import { lint, toolCallRule } from '../../lint.js';
+
+const servers = {
+  filesystem: [
+    {
+      name: 'readFile',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          path: { type: 'string' },
+          limit: { type: 'integer' },
+        },
+        required: ['path'],
+        additionalProperties: false,
+      },
+    },
+    {
+      name: 'search',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          query: { type: 'string' },
+          options: {
+            type: 'object',
+            properties: {
+              order: { type: 'string', enum: ['name', 'date'] },
+              limit: { type: 'integer' },
+            },
+            required: ['order'],
+            additionalProperties: false,
+          },
+          paths: { type: 'array', items: { type: 'string' } },
+        },
+        required: ['query'],
+      },
+    },
+  ],
+};
+
+const declaration = 'mcp filesystem {\n  command: "fs-server"\n}\n';
+
+function messages(body: string): string[] {
+  const source = declaration + body;
+  return lint(parseTree(source), source, {
+    rules: [toolCallRule(servers)],
+  }).map(d => d.message);
+}
+
+describe('tool-call', () => {
+  it('should accept calls matching the schema', () => {
+    expect(messages('filesystem.readFile("a.txt", 10)\n')).toEqual([]);
+    expect(messages('filesystem.readFile({ path: "a.txt" })\n')).toEqual([]);
+  });
+
+  it('should report tools the server does not have', () => {
+    expect(messages('filesystem.writeFile("a.txt")\n')).toEqual([
+      "MCP server 'filesystem' has no tool 'writeFile'",
+    ]);
+  });
+
+  it('should report too many arguments', () => {
+    expect(messages('filesystem.readFile("a", 1, 2)\n')).toEqual([
+      'filesystem.readFile takes at most 2 arguments, got 3',
+    ]);
+  });
+
+  it('should report unknown and missing named parameters', () => {
+    expect(messages('filesystem.readFile({ pth: "a.txt" })\n')).toEqual([
+      "Missing required parameter 'path' of filesystem.readFile",
+      "filesystem.readFile has no parameter 'pth'",
+    ]);
+  });
+
+  it('should report literal arguments of the wrong type', () => {
+    expect(messages('filesystem.readFile(1, "ten")\n')).toEqual([
+      "Parameter 'path' of filesystem.readFile must be string, got number",
+      "Parameter 'limit' of filesystem.readFile must be integer, got string",
+    ]);
+  });
+
+  it('should not check computed arguments or servers without schemas', () => {
+    const computed = 'input = { path: "a" }\nfilesystem.readFile(input)\n';
+    expect(messages(computed)).toEqual([]);
+    const source = 'mcp other {\n  command: "x"\n}\nother.anything(1)\n';
+    expect(
+      lint(parseTree(source), source, { rules: [toolCallRule(servers)] })
+    ).toEqual([]);
+  });
+
+  it('should check object and array literals all the way down', () => {
+    expect(
+      messages(
+        'filesystem.search({ query: "x", options: { order: "size", ' +
+          'limt: 5 }, paths: ["a", 2] })\n'
+      )
+    ).toEqual([
+      "Parameter 'options.order' of filesystem.search must be one of " +
+        '"name", "date", got "size"',
+      "filesystem.search has no parameter 'options.limt'",
+      "Parameter 'paths[1]' of filesystem.search must be string, got number",
+    ]);
+    expect(
+      messages('filesystem.search("x", { limit: "ten" })\n')
+    ).toEqual([
+      "Missing required parameter 'options.order' of filesystem.search",
+      "Parameter 'options.limit' of filesystem.search must be integer, " +
+        'got string',
+    ]);
+  });
+
+  it('should fix missing required properties', () => {
+    const fixes = (body: string) => {
+      const source = declaration + body;
+      return lint(parseTree(source), source, {
+        rules: [toolCallRule(servers)],
+      }).map(({ fix }) => {
+        const [edit] = fix!.edits;
+        const { offset } = edit.location.start;
+        return {
+          title: fix!.title,
+          fixed:
+            source.slice(declaration.length, offset) +
+            edit.newText +
+            source.slice(offset),
+        };
+      });
+    };
+
+    expect(fixes('filesystem.readFile({})')).toEqual([
+      {
+        title: "Add required property 'path'",
+        fixed: 'filesystem.readFile({ path: "" })',
+      },
+    ]);
+    const search = 'filesystem.search({ query: "x", options: { limit: 1, } })';
+    expect(fixes(search)).toEqual([
+      {
+        title: "Add required property 'order'",
+        fixed:
+          'filesystem.search({ query: "x", options: { limit: 1, order: "name" } })',
+      },
+    ]);
+  });
+});
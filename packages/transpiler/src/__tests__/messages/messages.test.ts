@@ -0,0 +1,72 @@
+// Tests for the translation of diagnostic messages
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { typecheck } from '../../typecheck.js';
+import { MessageTranslator, messageCatalog } from '../../messages.js';
+
+const german = new MessageTranslator(messageCatalog('de-AT')!);
+
+describe('MessageTranslator', () => {
+  it('should translate the messages of the checker', () => {
+    const diagnostics = typecheck(
+      parseSource(`
+tool deploy(service: string, replicas: number) {
+  print(service)
+}
+deploy(3)
+`)
+    );
+    const translated = diagnostics.map(d => [
+      d.code,
+      german.translate(d.message),
+    ]);
+    expect(translated).toEqual([
+      [
+        'argument-type',
+        "Ein Argument vom Typ 'number' passt nicht zum Parameter 'service' vom Typ 'string'",
+      ],
+      [
+        'missing-argument',
+        "Fehlendes Argument 'replicas' im Aufruf von Tool 'deploy'",
+      ],
+    ]);
+  });
+
+  it('should translate the messages quoted in a message', () => {
+    expect(
+      german.translate(
+        'Parse error at line 2, column 7: Incomplete expression: expected value after "+" operator'
+      )
+    ).toBe(
+      'Syntaxfehler in Zeile 2, Spalte 7: Unvollständiger Ausdruck: Wert nach dem Operator "+" erwartet'
+    );
+    expect(
+      german.translate(
+        'Invalid call to fs.readFile: missing required parameter "path"'
+      )
+    ).toBe('Ungültiger Aufruf von fs.readFile: Pflichtparameter "path" fehlt');
+  });
+
+  it('should prefer earlier catalogs and entries', () => {
+    const translator = new MessageTranslator(
+      { "Undefined variable: '{variable}'": "Variable '{variable}' inconnue" },
+      messageCatalog('de')!
+    );
+    expect(translator.translate("Undefined variable: 'x'")).toBe(
+      "Variable 'x' inconnue"
+    );
+    expect(german.translate("Undefined variable: 'x' in tool 'y'")).toBe(
+      "Undefinierte Variable: 'x' in tool 'y'"
+    );
+  });
+
+  it('should leave messages without a translation in English', () => {
+    expect(german.translate('Something new went wrong')).toBe(
+      'Something new went wrong'
+    );
+    expect(messageCatalog('fr')).toBeUndefined();
+    expect(() => new MessageTranslator({ '{message}': '{message}!' })).toThrow(
+      'Message "{message}" has no text to recognize it by'
+    );
+  });
+});
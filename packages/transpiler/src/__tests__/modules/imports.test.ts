@@ -0,0 +1,74 @@
+// Tests for organizing the imports of a script
+import { describe, it, expect } from 'vitest';
+import { organizeImports, unusedImports } from '../../imports.js';
+import { createMemoryLoader, loadModuleGraph } from '../../modules.js';
+
+const FILES = {
+  '/project/servers.mcps': 'mcp filesystem {\n  command: "npx",\n}\n',
+  '/project/tools.mcps':
+    'import "./servers"\n\ntool readNotes(path: string) {\n' +
+    '  return filesystem.readFile({ path: path })\n}\n',
+  '/project/other.mcps': 'tool other() {}\n',
+};
+
+function organized(content: string, unused: string[] = []): string {
+  const [change] = organizeImports({ path: 'main.mcps', content }, unused)
+    .edits['main.mcps'];
+  return change
+    ? content.slice(0, change.location.start.offset) +
+        change.newText +
+        content.slice(change.location.end.offset)
+    : content;
+}
+
+function unused(main: string): string[] {
+  const loader = createMemoryLoader(FILES);
+  return unusedImports(loadModuleGraph('/project/main.mcps', loader, main));
+}
+
+describe('organizeImports', () => {
+  it('should sort imports and import each module once', () => {
+    expect(
+      organized(
+        '// Tools\nimport "./b"\nimport "./a" // servers\nimport "./b.mcps"\n' +
+          '\nx = 1\n'
+      )
+    ).toBe('import "./a" // servers\n// Tools\nimport "./b"\n\nx = 1\n');
+  });
+
+  it('should remove unused imports and gather the rest', () => {
+    expect(organized('import "./b"\nx = 1\nimport "./a"\n', ['./b'])).toBe(
+      'import "./a"\n\nx = 1\n'
+    );
+    expect(organized('import "./b"\n\nx = 1\n', ['./b'])).toBe('x = 1\n');
+  });
+
+  it('should leave organized imports alone', () => {
+    const { edits } = organizeImports({
+      path: 'main.mcps',
+      content: 'import "./a"\nimport "./b"\n\nx = 1\n',
+    });
+    expect(edits['main.mcps']).toEqual([]);
+  });
+});
+
+describe('unusedImports', () => {
+  it('should find imports the script uses nothing from', () => {
+    expect(
+      unused(
+        'import "./tools"\nimport "./servers"\nimport "./other"\n\n' +
+          'print(readNotes("a"))\n'
+      )
+    ).toEqual(['./servers', './other']);
+  });
+
+  it('should count declarations used through other modules', () => {
+    expect(
+      unused('import "./tools"\n\nfilesystem.readFile({ path: "a" })\n')
+    ).toEqual([]);
+  });
+
+  it('should count names used in template strings', () => {
+    expect(unused('import "./other"\n\nprint(`${other()}`)\n')).toEqual([]);
+  });
+});
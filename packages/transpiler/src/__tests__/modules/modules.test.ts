@@ -0,0 +1,254 @@
+// Tests for loading and linking scripts split across several files
+import { describe, it, expect } from 'vitest';
+import {
+  createMemoryLoader,
+  linkModules,
+  loadModuleGraph,
+  loadProgram,
+  ModuleError,
+} from '../../modules.js';
+import { ParseError } from '../../parser.js';
+import { generateCode } from '../../codegen.js';
+import { buildSymbolTable, importedDeclarations } from '../../semantics.js';
+
+const SERVERS = `mcp filesystem {
+  command: "npx",
+}
+`;
+
+const TOOLS = `import "./servers.mcps"
+
+tool readNotes(path: string) {
+  return filesystem.readFile({ path: path })
+}
+`;
+
+const MAIN = `import "./lib/tools"
+import "./lib/servers.mcps"
+
+print(readNotes("notes.txt"))
+`;
+
+const FILES = {
+  '/project/lib/servers.mcps': SERVERS,
+  '/project/lib/tools.mcps': TOOLS,
+  '/project/main.mcps': MAIN,
+};
+
+describe('Modules', () => {
+  describe('createMemoryLoader', () => {
+    const loader = createMemoryLoader(FILES, { paths: ['/project/lib'] });
+
+    it('should resolve relative imports against the importer', () => {
+      expect(loader.resolve('./lib/tools', '/project/main.mcps')).toBe(
+        '/project/lib/tools.mcps'
+      );
+      expect(loader.resolve('../main.mcps', '/project/lib/x.mcps')).toBe(
+        '/project/main.mcps'
+      );
+    });
+
+    it('should look up module paths in the search paths', () => {
+      expect(loader.resolve('servers', '/project/main.mcps')).toBe(
+        '/project/lib/servers.mcps'
+      );
+      expect(() => loader.resolve('missing', '/project/main.mcps')).toThrow(
+        'Cannot find module "missing" in /project/lib'
+      );
+    });
+
+    it('should pick the newest version a versioned import admits', () => {
+      const versioned = createMemoryLoader(
+        {
+          '/lib/prompts/review@1.4.0.mcps': '',
+          '/lib/prompts/review@2.0.1.mcps': '',
+          '/lib/prompts/review@2.10.0.mcps': '',
+          '/lib/prompts/review@2.9.3.mcps': '',
+          '/project/review@3.0.0.mcps': '',
+        },
+        { paths: ['/lib'] }
+      );
+      const resolve = (specifier: string) =>
+        versioned.resolve(specifier, '/project/main.mcps');
+
+      expect(resolve('prompts/review@2')).toBe(
+        '/lib/prompts/review@2.10.0.mcps'
+      );
+      expect(resolve('prompts/review@2.9')).toBe(
+        '/lib/prompts/review@2.9.3.mcps'
+      );
+      expect(resolve('prompts/review@1.4.0')).toBe(
+        '/lib/prompts/review@1.4.0.mcps'
+      );
+      // Without a version, the newest one
+      expect(resolve('prompts/review')).toBe('/lib/prompts/review@2.10.0.mcps');
+      expect(resolve('./review@3')).toBe('/project/review@3.0.0.mcps');
+      expect(() => resolve('prompts/review@3')).toThrow(
+        'Cannot find version 3 of module "prompts/review" in /lib'
+      );
+    });
+
+    it('should prefer a module without a version to its versions', () => {
+      const loader = createMemoryLoader(
+        { '/lib/review.mcps': '', '/lib/review@1.0.0.mcps': '' },
+        { paths: ['/lib'] }
+      );
+      expect(loader.resolve('review', '/main.mcps')).toBe('/lib/review.mcps');
+    });
+  });
+
+  describe('loadModuleGraph', () => {
+    it('should load each module once, dependencies first', () => {
+      const graph = loadModuleGraph(
+        '/project/main.mcps',
+        createMemoryLoader(FILES)
+      );
+
+      expect(graph.modules.map(m => m.path)).toEqual([
+        '/project/lib/servers.mcps',
+        '/project/lib/tools.mcps',
+        '/project/main.mcps',
+      ]);
+      expect(graph.entry.imports).toEqual([
+        '/project/lib/tools.mcps',
+        '/project/lib/servers.mcps',
+      ]);
+    });
+
+    it('should use the given source for the entry module', () => {
+      const graph = loadModuleGraph(
+        '/project/main.mcps',
+        createMemoryLoader(FILES),
+        'import "./lib/servers"\nx = 1'
+      );
+
+      expect(graph.modules).toHaveLength(2);
+    });
+
+    it('should detect import cycles', () => {
+      const loader = createMemoryLoader({
+        '/a.mcps': 'import "./b"',
+        '/b.mcps': 'import "./c"',
+        '/c.mcps': 'import "./a"',
+      });
+
+      expect(() => loadModuleGraph('/a.mcps', loader)).toThrow(
+        'Import cycle: /a.mcps -> /b.mcps -> /c.mcps -> /a.mcps'
+      );
+    });
+
+    it('should report the import that could not be loaded', () => {
+      const loader = createMemoryLoader({ '/main.mcps': 'import "./gone"' });
+
+      let error: unknown;
+      try {
+        loadModuleGraph('/main.mcps', loader);
+      } catch (e) {
+        error = e;
+      }
+      expect(error).toBeInstanceOf(ModuleError);
+      expect((error as ModuleError).message).toBe(
+        'Cannot read module /gone.mcps: No such module: /gone.mcps'
+      );
+      expect((error as ModuleError).modulePath).toBe('/main.mcps');
+      expect((error as ModuleError).statement?.path).toBe('./gone');
+    });
+
+    it('should only allow declarations in imported modules', () => {
+      const loader = createMemoryLoader({
+        '/main.mcps': 'import "./side"',
+        '/side.mcps': '// setup\nmcp fs { command: "x" }\nprint("hi")',
+      });
+
+      expect(() => loadModuleGraph('/main.mcps', loader)).toThrow(
+        '/side.mcps:3:1: imported modules may only contain declarations and imports'
+      );
+    });
+
+    it('should read the files of prompts relative to their module', () => {
+      const loader = createMemoryLoader({
+        '/project/main.mcps': 'import "./lib/prompts"',
+        '/project/lib/prompts.mcps':
+          'prompt Reviewer { file: "../text/review.md" }',
+        '/project/text/review.md': 'You review code.\n',
+      });
+
+      const graph = loadModuleGraph('/project/main.mcps', loader);
+      const [prompt] = graph.modules[0].statements;
+      expect(prompt).toMatchObject({
+        type: 'prompt_declaration',
+        name: 'Reviewer',
+        fileText: 'You review code.\n',
+      });
+      expect(linkModules(graph)[0]).toMatchObject({
+        fileText: 'You review code.\n',
+      });
+    });
+
+    it('should report prompt files that cannot be read', () => {
+      const loader = createMemoryLoader({
+        '/main.mcps': 'prompt Reviewer { file: "./review.md" }',
+      });
+
+      expect(() => loadModuleGraph('/main.mcps', loader)).toThrow(
+        '/main.mcps:1:1: cannot read the file of prompt Reviewer: ' +
+          'No such module: /review.md'
+      );
+    });
+
+    it('should keep syntax errors in the entry module as parse errors', () => {
+      const loader = createMemoryLoader({ '/main.mcps': 'x = 1 $ 2' });
+
+      expect(() => loadModuleGraph('/main.mcps', loader)).toThrow(ParseError);
+    });
+  });
+
+  describe('linkModules', () => {
+    it('should put imported declarations before the entry statements', () => {
+      const graph = loadModuleGraph(
+        '/project/main.mcps',
+        createMemoryLoader(FILES)
+      );
+      const program = linkModules(graph);
+
+      expect(program.map(s => s.type)).toEqual([
+        'mcp_declaration',
+        'tool_declaration',
+        'expression_statement',
+      ]);
+      expect(() => generateCode(program)).not.toThrow();
+    });
+
+    it('should reject a name declared in two modules', () => {
+      const loader = createMemoryLoader({
+        '/main.mcps': 'import "./a"\nmcp fs { command: "y" }',
+        '/a.mcps': 'mcp fs { command: "x" }',
+      });
+
+      expect(() =>
+        loadProgram('/main.mcps', loader.read('/main.mcps'), loader)
+      ).toThrow('fs is declared in both /a.mcps and /main.mcps');
+    });
+  });
+
+  describe('merged symbol tables', () => {
+    it('should resolve references to imported declarations', () => {
+      const graph = loadModuleGraph(
+        '/project/main.mcps',
+        createMemoryLoader(FILES)
+      );
+      const imports = importedDeclarations(graph);
+      const table = buildSymbolTable(graph.entry.tree, imports);
+
+      // Imports are followed transitively
+      expect(imports.map(d => [d.name, d.kind, d.path])).toEqual([
+        ['readNotes', 'tool', '/project/lib/tools.mcps'],
+        ['filesystem', 'mcp', '/project/lib/servers.mcps'],
+      ]);
+      expect(table.unresolved()).toEqual([]);
+      const call = table.references.find(r => r.node.text === 'readNotes');
+      expect(call?.symbol?.path).toBe('/project/lib/tools.mcps');
+      expect(table.occurrences(call!.symbol!)).toEqual([call!.node]);
+    });
+  });
+});
@@ -0,0 +1,43 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { AnnotatedStatement } from '../../ast.js';
+
+describe('Annotated Statement Parser', () => {
+  it('parses an idempotent tool call', () => {
+    const source = '@idempotent(key: "post-" + id) slack.post(message)';
+    const statements = parseSource(source);
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as AnnotatedStatement;
+    expect(stmt.type).toBe('annotated_statement');
+    expect(stmt.annotation.name).toBe('idempotent');
+    expect(stmt.annotation.arguments).toHaveLength(1);
+    expect(stmt.annotation.arguments[0]).toMatchObject({
+      type: 'property',
+      key: 'key',
+      value: { type: 'binary', operator: '+' },
+    });
+    expect(stmt.statement.type).toBe('expression_statement');
+  });
+
+  it('parses an annotation above an assignment', () => {
+    const source = `@idempotent(key: orderId)
+order = shop.createOrder(cart)`;
+    const statements = parseSource(source);
+
+    const stmt = statements[0] as AnnotatedStatement;
+    expect(stmt.statement).toMatchObject({
+      type: 'assignment',
+      target: { type: 'identifier', name: 'order' },
+      value: { type: 'call' },
+    });
+  });
+
+  it('parses an annotation without arguments', () => {
+    const statements = parseSource('@trace\nissues = github.search(query)');
+
+    const stmt = statements[0] as AnnotatedStatement;
+    expect(stmt.annotation).toMatchObject({ name: 'trace', arguments: [] });
+    expect(stmt.statement.type).toBe('assignment');
+  });
+});
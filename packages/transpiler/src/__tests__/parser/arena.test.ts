@@ -0,0 +1,59 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { getLocation } from '../../locations.js';
+import { AstArena, AstPool } from '../../arena.js';
+
+describe('AST arenas', () => {
+  const source = 'x = 1\nif (x > 0) {\n  print("positive")\n}\n';
+
+  it('should build the same AST as without an arena', () => {
+    const arena = new AstArena(new AstPool());
+    expect(parseSource(source, arena)).toEqual(parseSource(source));
+    expect(arena.size).toBeGreaterThan(0);
+  });
+
+  it('should reuse released nodes and locations', () => {
+    const pool = new AstPool();
+    const first = new AstArena(pool);
+    const [assignment] = parseSource('x = 1\n', first);
+    first.release();
+    expect(pool.size).toBe(3);
+    expect(getLocation(assignment)).toBeUndefined();
+
+    const second = new AstArena(pool);
+    const [reused] = parseSource('name = "Ada"\n', second);
+    expect(reused).toBe(assignment);
+    expect(reused).toEqual(parseSource('name = "Ada"\n')[0]);
+    expect(getLocation(reused)).toEqual(
+      getLocation(parseSource('name = "Ada"\n')[0])
+    );
+  });
+
+  it("should drop optional fields from a node's previous AST", () => {
+    const pool = new AstPool();
+    const first = new AstArena(pool);
+    parseSource('if (a) {\n  b\n} else {\n  c\n}\n', first);
+    first.release();
+
+    const [statement] = parseSource('if (a) {\n  b\n}\n', new AstArena(pool));
+    expect(statement).not.toHaveProperty('else');
+    expect(statement).toEqual(parseSource('if (a) {\n  b\n}\n')[0]);
+  });
+
+  it('should keep at most maxSize nodes', () => {
+    const pool = new AstPool({ maxSize: 2 });
+    const arena = new AstArena(pool);
+    parseSource(source, arena);
+    arena.release();
+    expect(pool.size).toBe(2);
+  });
+
+  it('should not allocate after release', () => {
+    const arena = new AstArena(new AstPool());
+    arena.release();
+    arena.release();
+    expect(() => parseSource(source, arena)).toThrow(
+      'AST arena used after release'
+    );
+  });
+});
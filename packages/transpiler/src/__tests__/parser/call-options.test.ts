@@ -0,0 +1,42 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import {
+  Assignment,
+  CallExpression,
+  MCPDeclaration,
+  NumberLiteral,
+} from '../../ast.js';
+
+describe('Call Options Parser', () => {
+  it('parses options of a tool call', () => {
+    const source =
+      'text = fs.readFile(path) with { timeout: 5s, retries: 3, backoff: "exponential" }';
+    const statements = parseSource(source);
+
+    const call = (statements[0] as Assignment).value as CallExpression;
+    expect(call.type).toBe('call');
+    expect(call.callee.type).toBe('member');
+    expect(call.arguments).toHaveLength(1);
+    expect(call.options?.properties.map(p => [p.key, p.value])).toEqual([
+      ['timeout', { type: 'number', value: 5000 }],
+      ['retries', { type: 'number', value: 3 }],
+      ['backoff', { type: 'string', value: 'exponential' }],
+    ]);
+  });
+
+  it('leaves options unset on plain calls', () => {
+    const statements = parseSource('fs.readFile(path)');
+    const call = (statements[0] as { expression: CallExpression }).expression;
+    expect(call.options).toBeUndefined();
+  });
+
+  it('parses durations in each unit as milliseconds', () => {
+    const source = 'mcp fs { a: 250ms, b: 1.5s, c: 2m, d: 1h, e: 5 }';
+    const statements = parseSource(source);
+
+    const config = (statements[0] as MCPDeclaration).config;
+    expect(
+      config.properties.map(p => (p.value as NumberLiteral).value)
+    ).toEqual([250, 1500, 120_000, 3_600_000, 5]);
+  });
+});
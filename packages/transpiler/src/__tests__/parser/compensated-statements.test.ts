@@ -0,0 +1,31 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { CompensatedStatement } from '../../ast.js';
+
+describe('Compensated Statement Parser', () => {
+  it('parses a statement with a compensate block', () => {
+    const source = `ticket = jira.create(issue) compensate {
+  jira.delete(ticket)
+}`;
+    const statements = parseSource(source);
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as CompensatedStatement;
+    expect(stmt.type).toBe('compensated_statement');
+    expect(stmt.statement).toMatchObject({
+      type: 'assignment',
+      target: { type: 'identifier', name: 'ticket' },
+    });
+    expect(stmt.compensation.statements.map(s => s.type)).toEqual([
+      'expression_statement',
+    ]);
+  });
+
+  it('parses a compensated idempotent call', () => {
+    const source = '@idempotent(key: id) post(id) compensate { unpost(id) }';
+    const statements = parseSource(source);
+
+    const stmt = statements[0] as CompensatedStatement;
+    expect(stmt.statement.type).toBe('annotated_statement');
+  });
+});
@@ -0,0 +1,127 @@
+// Tests for the grammar test corpus runner
+import { describe, it, expect, afterEach } from 'vitest';
+import { mkdtempSync, rmSync, writeFileSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { fileURLToPath } from 'url';
+import {
+  formatCorpusFailures,
+  normalizeSExpression,
+  parseCorpus,
+  runCorpus,
+} from '../../corpus.js';
+import { parseTree } from '../../syntax.js';
+
+const CORPUS_DIR = fileURLToPath(
+  new URL('../../../grammar/test/corpus', import.meta.url)
+);
+
+const EXAMPLES = `==================
+Assignment
+==================
+
+x = 1
+
+---
+
+; The value is an expression
+(source_file
+  (statement
+    (assignment
+      (assignment_target (identifier))
+      (expression (literal (number))))))
+
+==================|||
+Lines of dashes
+:skip
+==================|||
+
+x = "---"
+
+---|||
+
+(source_file)
+
+======
+Unclosed call
+:error
+======
+
+print(
+
+---
+`;
+
+describe('parseCorpus', () => {
+  it('should read examples with their attributes', () => {
+    const [assignment, dashes, unclosed] = parseCorpus(EXAMPLES, 'a.txt');
+    expect(assignment).toEqual({
+      name: 'Assignment',
+      file: 'a.txt',
+      line: 1,
+      input: 'x = 1',
+      expected:
+        '(source_file (statement (assignment (assignment_target ' +
+        '(identifier)) (expression (literal (number))))))',
+    });
+    expect(dashes).toMatchObject({
+      name: 'Lines of dashes',
+      input: 'x = "---"',
+      skip: true,
+    });
+    expect(unclosed).toMatchObject({ input: 'print(', error: true });
+  });
+
+  it('should reject examples without a tree', () => {
+    expect(() => parseCorpus('===\nBroken\n===\n\nx = 1\n', 'b.txt')).toThrow(
+      'b.txt:1: example has no --- line'
+    );
+  });
+
+  it('should normalize whitespace of trees', () => {
+    expect(normalizeSExpression('(a\n  (b)\n  (c ))\n')).toBe('(a (b) (c))');
+  });
+});
+
+describe('runCorpus', () => {
+  let dir: string | undefined;
+
+  afterEach(() => {
+    if (dir) {
+      rmSync(dir, { recursive: true, force: true });
+      dir = undefined;
+    }
+  });
+
+  it("should pass the grammar's own corpus", () => {
+    const report = runCorpus(CORPUS_DIR);
+    expect(report.examples.length).toBeGreaterThan(0);
+    expect(formatCorpusFailures(report)).toBe('');
+  });
+
+  it('should report examples that parse to another tree', () => {
+    dir = mkdtempSync(join(tmpdir(), 'mcps-corpus-'));
+    writeFileSync(
+      join(dir, 'numbers.txt'),
+      '===\nNumber\n===\n\n1\n\n---\n\n(source_file (string))\n'
+    );
+    writeFileSync(join(dir, 'notes.md'), 'Not a corpus file');
+
+    const parsed: string[] = [];
+    const report = runCorpus(dir, {
+      parse: input => {
+        parsed.push(input);
+        return parseTree(input);
+      },
+    });
+    expect(parsed).toEqual(['1']);
+    expect(report.failures).toHaveLength(1);
+    const [failure] = report.failures;
+    expect(failure.actual).toMatch(/^\(source_file \(statement/);
+    expect(formatCorpusFailures(report)).toContain(
+      `${join(dir, 'numbers.txt')}:1: Number\n  expected: (source_file (string))`
+    );
+
+    expect(runCorpus(dir, { filter: 'Other' }).examples).toEqual([]);
+  });
+});
@@ -1,5 +1,10 @@
 import { describe, it, expect } from 'vitest';
-import { parseSource } from '../../parser.js';
+import {
+  parseSource,
+  syntaxDiagnostics,
+  ParseError,
+} from '../../parser.js';
+import { parseTree } from '../../syntax.js';
 
 describe('Parser Error Messages', () => {
   describe('Incomplete expressions', () => {
@@ -544,4 +549,53 @@ z = 15 +`;
       expect(() => parseSource(source)).not.toThrow();
     });
   });
+
+  describe('Structured diagnostics', () => {
+    it('should return no diagnostics for valid source', () => {
+      const source = 'x = 1\nprint(x)';
+      expect(syntaxDiagnostics(parseTree(source), source)).toEqual([]);
+    });
+
+    it('should report each error with its range and text', () => {
+      const source = 'x = 1 $ 2\nprint(x)\ny = 3 $ 4';
+      const diagnostics = syntaxDiagnostics(parseTree(source), source);
+
+      expect(diagnostics.map(d => d.location.start.line)).toEqual([1, 3]);
+      for (const diagnostic of diagnostics) {
+        const { start, end } = diagnostic.location;
+        expect(diagnostic.kind).toBe('error');
+        expect(diagnostic.text).toContain('$');
+        expect(source.slice(start.offset, end.offset)).toBe(diagnostic.text);
+      }
+    });
+
+    it('should report missing tokens with the expected token', () => {
+      const source = 'print(1, 2';
+      const diagnostics = syntaxDiagnostics(parseTree(source), source);
+      const missing = diagnostics.find(d => d.kind === 'missing');
+
+      expect(missing).toMatchObject({
+        message: 'Missing ")"',
+        text: '',
+        expected: [')'],
+      });
+    });
+
+    it('should attach all diagnostics to the thrown parse error', () => {
+      const source = 'x = 1 $ 2\ny = 3 $ 4';
+
+      try {
+        parseSource(source);
+        expect.fail('Should have thrown an error');
+      } catch (error) {
+        expect(error).toBeInstanceOf(ParseError);
+        const { diagnostics, message } = error as ParseError;
+        expect(message).toMatch(/^Parse error at line 1/);
+        expect(diagnostics).toHaveLength(2);
+        expect(diagnostics).toEqual(
+          syntaxDiagnostics(parseTree(source), source)
+        );
+      }
+    });
+  });
 });
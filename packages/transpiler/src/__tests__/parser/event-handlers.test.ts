@@ -0,0 +1,42 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { EventHandler } from '../../ast.js';
+
+describe('Event Handler Parser', () => {
+  it('parses a handler of an event', () => {
+    const statements = parseSource('on event("deploy.finished") { notify() }');
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as EventHandler;
+    expect(stmt.type).toBe('event_handler');
+    expect(stmt.event).toBe('deploy.finished');
+    expect(stmt.parameter).toBeUndefined();
+    expect(stmt.body.statements.map(s => s.type)).toEqual([
+      'expression_statement',
+    ]);
+  });
+
+  it('parses the typed parameter receiving the payload', () => {
+    const source = `on event("deploy.finished") (deploy: { version: string }) {
+  print(deploy.version)
+}`;
+    const stmt = parseSource(source)[0] as EventHandler;
+
+    expect(stmt.parameter).toMatchObject({
+      name: 'deploy',
+      optional: false,
+      typeAnnotation: {
+        type: 'object_type',
+        properties: [{ name: 'version' }],
+      },
+    });
+  });
+
+  it('still parses variables named on', () => {
+    const statements = parseSource('on = true\nprint(on)');
+    expect(statements.map(s => s.type)).toEqual([
+      'assignment',
+      'expression_statement',
+    ]);
+  });
+});
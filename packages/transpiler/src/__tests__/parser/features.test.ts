@@ -0,0 +1,65 @@
+import { describe, it, expect, afterEach } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { parseTree } from '../../syntax.js';
+import {
+  featureDiagnostics,
+  languageFeatures,
+  setLanguageFeatures,
+  type ExperimentalFeature,
+} from '../../features.js';
+
+// Loops stand in for an experimental construct
+const LOOPS: ExperimentalFeature[] = [
+  {
+    name: 'loops',
+    description: 'A while loop',
+    nodeTypes: ['while_statement'],
+  },
+];
+
+const SOURCE = `x = 1
+while (x < 3) {
+  while (x < 2) {
+    x = x + 1
+  }
+  x = x + 1
+}
+`;
+
+describe('Language features', () => {
+  afterEach(() => {
+    setLanguageFeatures([]);
+  });
+
+  it('should report constructs of features not enabled', () => {
+    const diagnostics = featureDiagnostics(parseTree(SOURCE), LOOPS);
+    // The nested loop is part of the reported one
+    expect(diagnostics).toHaveLength(1);
+    expect(diagnostics[0]).toMatchObject({
+      kind: 'error',
+      message:
+        'A while loop is experimental; enable it with "features": ["loops"] in .mcpsrc',
+      location: { start: { line: 2, column: 1 } },
+    });
+  });
+
+  it('should allow constructs of enabled features', () => {
+    setLanguageFeatures(['loops'], LOOPS);
+    expect(languageFeatures()).toEqual(['loops']);
+    expect(featureDiagnostics(parseTree(SOURCE), LOOPS)).toEqual([]);
+  });
+
+  it('should refuse unknown features', () => {
+    expect(() => setLanguageFeatures(['macros'], LOOPS)).toThrow(
+      'Unknown language feature macros, expected one of loops'
+    );
+    expect(() => setLanguageFeatures(['macros'])).toThrow(
+      'Unknown language feature macros, no features are experimental'
+    );
+    expect(languageFeatures()).toEqual([]);
+  });
+
+  it('should leave the stable language as is', () => {
+    expect(parseSource(SOURCE)).toHaveLength(2);
+  });
+});
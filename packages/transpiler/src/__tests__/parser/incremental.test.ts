@@ -0,0 +1,120 @@
+import { describe, it, expect } from 'vitest';
+import {
+  applyTextEdit,
+  offsetAt,
+  parseTree,
+  reparseTree,
+} from '../../syntax.js';
+
+describe('Incremental parsing', () => {
+  describe('offsetAt', () => {
+    const content = 'x = 1\nname = "Ada"\n';
+
+    it('should convert points to offsets', () => {
+      expect(offsetAt(content, { row: 0, column: 0 })).toBe(0);
+      expect(offsetAt(content, { row: 1, column: 4 })).toBe(10);
+      expect(offsetAt(content, { row: 2, column: 0 })).toBe(content.length);
+    });
+
+    it('should clamp columns past the end of a line', () => {
+      expect(offsetAt(content, { row: 0, column: 40 })).toBe(5);
+      expect(offsetAt(content, { row: 9, column: 0 })).toBe(content.length);
+    });
+  });
+
+  describe('applyTextEdit', () => {
+    it('should describe a replacement within a line', () => {
+      const { content, edit } = applyTextEdit(
+        'x = 1\ny = 2',
+        { row: 1, column: 4 },
+        { row: 1, column: 5 },
+        '42'
+      );
+
+      expect(content).toBe('x = 1\ny = 42');
+      expect(edit).toEqual({
+        startIndex: 10,
+        oldEndIndex: 11,
+        newEndIndex: 12,
+        startPosition: { row: 1, column: 4 },
+        oldEndPosition: { row: 1, column: 5 },
+        newEndPosition: { row: 1, column: 6 },
+      });
+    });
+
+    it('should describe inserted lines', () => {
+      const { content, edit } = applyTextEdit(
+        'x = 1',
+        { row: 0, column: 0 },
+        { row: 0, column: 0 },
+        'a = 0\nb = 0\n'
+      );
+
+      expect(content).toBe('a = 0\nb = 0\nx = 1');
+      expect(edit.newEndIndex).toBe(12);
+      expect(edit.newEndPosition).toEqual({ row: 2, column: 0 });
+    });
+
+    it('should describe deleted lines', () => {
+      const { content, edit } = applyTextEdit(
+        'a = 0\nb = 0\nx = 1',
+        { row: 0, column: 5 },
+        { row: 2, column: 0 },
+        ''
+      );
+
+      expect(content).toBe('a = 0x = 1');
+      expect(edit.oldEndPosition).toEqual({ row: 2, column: 0 });
+      expect(edit.newEndPosition).toEqual({ row: 0, column: 5 });
+    });
+  });
+
+  describe('reparseTree', () => {
+    it('should match a full parse of the edited text', () => {
+      let content = 'x = 1\nprint(x)\n';
+      let tree = parseTree(content);
+
+      for (const [start, end, text] of [
+        [{ row: 0, column: 4 }, { row: 0, column: 5 }, '(1 + 2'],
+        [{ row: 0, column: 10 }, { row: 0, column: 10 }, ')'],
+        [{ row: 2, column: 0 }, { row: 2, column: 0 }, 'y = x * 2\n'],
+      ] as const) {
+        const result = applyTextEdit(content, start, end, text);
+        content = result.content;
+        tree = reparseTree(tree, content, [result.edit]);
+      }
+
+      expect(content).toBe('x = (1 + 2)\nprint(x)\ny = x * 2\n');
+      expect(tree.rootNode.hasError).toBe(false);
+      expect(tree.rootNode.toString()).toBe(
+        parseTree(content).rootNode.toString()
+      );
+    });
+
+    it('should apply several edits before reparsing', () => {
+      const original = 'a = 1\nb = 2';
+      const first = applyTextEdit(
+        original,
+        { row: 0, column: 4 },
+        { row: 0, column: 5 },
+        '10'
+      );
+      const second = applyTextEdit(
+        first.content,
+        { row: 1, column: 4 },
+        { row: 1, column: 5 },
+        '"two"'
+      );
+
+      const tree = reparseTree(parseTree(original), second.content, [
+        first.edit,
+        second.edit,
+      ]);
+
+      expect(tree.rootNode.text).toBe('a = 10\nb = "two"');
+      expect(tree.rootNode.toString()).toBe(
+        parseTree(second.content).rootNode.toString()
+      );
+    });
+  });
+});
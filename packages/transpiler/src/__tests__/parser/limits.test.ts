@@ -0,0 +1,69 @@
+import { describe, it, expect, afterEach } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { parseTree } from '../../syntax.js';
+import {
+  DEFAULT_PARSE_LIMITS,
+  ParseLimitError,
+  parseLimits,
+  setParseLimits,
+} from '../../limits.js';
+
+function limitError(source: string): ParseLimitError {
+  try {
+    parseSource(source);
+  } catch (error) {
+    if (error instanceof ParseLimitError) {
+      return error;
+    }
+    throw error;
+  }
+  throw new Error('expected a ParseLimitError');
+}
+
+describe('Parse limits', () => {
+  afterEach(() => {
+    setParseLimits({});
+  });
+
+  it('should reject source longer than the limit before parsing', () => {
+    setParseLimits({ maxSourceLength: 10 });
+
+    const error = limitError('message = "hello world"');
+    expect(error.limit).toBe('maxSourceLength');
+    expect(error.max).toBe(10);
+    expect(error.message).toBe(
+      'Source is 23 characters long, more than the limit of 10'
+    );
+    expect(error.location).toBeUndefined();
+  });
+
+  it('should reject deeply nested syntax with its location', () => {
+    setParseLimits({ maxDepth: 50 });
+
+    const error = limitError(`x = ${'('.repeat(40)}1${')'.repeat(40)}`);
+    expect(error.limit).toBe('maxDepth');
+    expect(error.message).toBe('Syntax is nested more than 50 levels deep');
+    expect(error.location?.start.line).toBe(1);
+  });
+
+  it('should reject trees with too many nodes', () => {
+    setParseLimits({ maxNodes: 100 });
+
+    const error = limitError('x = [' + '1, '.repeat(100) + ']');
+    expect(error.limit).toBe('maxNodes');
+    expect(error.max).toBe(100);
+  });
+
+  it('should accept source within the limits', () => {
+    setParseLimits({ maxDepth: 50, maxNodes: 100 });
+
+    expect(parseSource('x = (1 + 2) * 3')).toHaveLength(1);
+    expect(() => parseTree('print(x)')).not.toThrow();
+  });
+
+  it('should keep defaults for limits left out', () => {
+    setParseLimits({ maxDepth: 50 });
+
+    expect(parseLimits()).toEqual({ ...DEFAULT_PARSE_LIMITS, maxDepth: 50 });
+  });
+});
@@ -0,0 +1,70 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { ListenStatement, NotificationHandler } from '../../ast.js';
+
+describe('Notification Parser', () => {
+  it('parses a handler of a server notification', () => {
+    const statements = parseSource(
+      'on notification(fs, "tools/list_changed") { refresh() }'
+    );
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as NotificationHandler;
+    expect(stmt.type).toBe('notification_handler');
+    expect(stmt.server).toBe('fs');
+    expect(stmt.notification).toBe('tools/list_changed');
+    expect(stmt.resource).toBeUndefined();
+    expect(stmt.parameter).toBeUndefined();
+    expect(stmt.body.statements.map(s => s.type)).toEqual([
+      'expression_statement',
+    ]);
+  });
+
+  it('parses the resource subscribed to and the parameter', () => {
+    const source = `on notification(fs, "resources/updated", "file:///a.csv") (update: { uri: string }) {
+  print(update.uri)
+}`;
+    const stmt = parseSource(source)[0] as NotificationHandler;
+
+    expect(stmt.resource).toMatchObject({
+      type: 'string',
+      value: 'file:///a.csv',
+    });
+    expect(stmt.parameter).toMatchObject({
+      name: 'update',
+      typeAnnotation: { type: 'object_type' },
+    });
+  });
+
+  it('parses listen statements with their options', () => {
+    const statements = parseSource(`listen(until: count >= 3, timeout: 10m)
+listen(timeout: 500)
+listen()`) as ListenStatement[];
+
+    expect(statements.map(s => s.type)).toEqual([
+      'listen_statement',
+      'listen_statement',
+      'listen_statement',
+    ]);
+    expect(statements[0].until).toMatchObject({
+      type: 'binary',
+      operator: '>=',
+    });
+    expect(statements[0].timeout).toMatchObject({
+      type: 'number',
+      value: 600000,
+    });
+    expect(statements[1].until).toBeUndefined();
+    expect(statements[1].timeout).toMatchObject({ value: 500 });
+    expect(statements[2]).not.toHaveProperty('until');
+    expect(statements[2]).not.toHaveProperty('timeout');
+  });
+
+  it('still parses variables named listen', () => {
+    const statements = parseSource('listen = 1\nprint(listen)');
+    expect(statements.map(s => s.type)).toEqual([
+      'assignment',
+      'expression_statement',
+    ]);
+  });
+});
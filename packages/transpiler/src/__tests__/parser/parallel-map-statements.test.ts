@@ -0,0 +1,38 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { ParallelMapStatement } from '../../ast.js';
+
+describe('Parallel Map Statement Parser', () => {
+  it('parses a parallel map assigned to a variable', () => {
+    const source =
+      'results = parallelMap(items, limit: 5) { item => process(item) }';
+    const statements = parseSource(source);
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as ParallelMapStatement;
+    expect(stmt.type).toBe('parallel_map_statement');
+    expect(stmt.target).toMatchObject({ type: 'identifier', name: 'results' });
+    expect(stmt.items).toMatchObject({ type: 'identifier', name: 'items' });
+    expect(stmt.limit).toMatchObject({ type: 'number', value: 5 });
+    expect(stmt.item).toBe('item');
+    expect(stmt.body.map(s => s.type)).toEqual(['expression_statement']);
+  });
+
+  it('parses a parallel map without a result or limit', () => {
+    const source = `parallelMap(urls) { url =>
+  // fetched one page at a time per url
+  page = web.fetch({ url: url })
+  print(page)
+}`;
+    const statements = parseSource(source);
+
+    const stmt = statements[0] as ParallelMapStatement;
+    expect(stmt.target).toBeUndefined();
+    expect(stmt.limit).toBeUndefined();
+    expect(stmt.item).toBe('url');
+    expect(stmt.body.map(s => s.type)).toEqual([
+      'assignment',
+      'expression_statement',
+    ]);
+  });
+});
@@ -0,0 +1,59 @@
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { ParallelStatement } from '../../ast.js';
+
+describe('Parallel Statement Parser', () => {
+  it('parses parallel statement with a branch per statement', () => {
+    const source = `parallel {
+  a = search("cats")
+  b = search("dogs")
+  print("searching")
+}`;
+    const statements = parseSource(source);
+
+    expect(statements).toHaveLength(1);
+    const stmt = statements[0] as ParallelStatement;
+    expect(stmt.type).toBe('parallel_statement');
+    expect(stmt.limit).toBeUndefined();
+    expect(stmt.branches.map(branch => branch.type)).toEqual([
+      'assignment',
+      'assignment',
+      'expression_statement',
+    ]);
+  });
+
+  it('parses parallel statement with a limit', () => {
+    const source = 'parallel (max + 1) { a = 1 }';
+    const statements = parseSource(source);
+
+    const stmt = statements[0] as ParallelStatement;
+    expect(stmt.limit?.type).toBe('binary');
+    expect(stmt.branches).toHaveLength(1);
+  });
+
+  it('parses block branches', () => {
+    const source = `parallel (2) {
+  {
+    page = fetch(url)
+    print(page)
+  }
+  // logged while the page loads
+  log("fetching")
+}`;
+    const statements = parseSource(source);
+
+    const stmt = statements[0] as ParallelStatement;
+    expect(stmt.branches.map(branch => branch.type)).toEqual([
+      'block_statement',
+      'expression_statement',
+    ]);
+  });
+
+  it('parses empty parallel statement', () => {
+    const statements = parseSource('parallel {}');
+
+    const stmt = statements[0] as ParallelStatement;
+    expect(stmt.type).toBe('parallel_statement');
+    expect(stmt.branches).toEqual([]);
+  });
+});
@@ -0,0 +1,140 @@
+// Tests for parsing on worker threads
+import { describe, it, expect, beforeAll, afterAll, afterEach } from 'vitest';
+import { mkdtemp, rm, writeFile } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { getLocation } from '../../locations.js';
+import { ParseError } from '../../parser.js';
+import { ParserPool, type ParserPoolOptions } from '../../pool.js';
+
+// Stands in for pool-worker.js, which only exists once built: the AST of
+// a source is a string naming the source and the thread that parsed it
+const FAKE_WORKER = `
+import { parentPort, threadId, workerData } from 'worker_threads';
+
+const LOCATION = {
+  start: { line: 1, column: 1, offset: 0 },
+  end: { line: 1, column: 4, offset: 3 },
+};
+
+parentPort.on('message', ({ id, source }) => {
+  if (source === 'crash') {
+    process.exit(2);
+  }
+  if (source === 'bad') {
+    const diagnostics = [{ kind: 'error', message: 'Unexpected "("', text: '(' }];
+    parentPort.postMessage({
+      id,
+      error: { name: 'ParseError', message: 'Syntax error', diagnostics },
+    });
+    return;
+  }
+  const value =
+    source === 'limits'
+      ? workerData.parseLimits.maxDepth
+      : source + '@' + threadId;
+  const statement = {
+    type: 'expression_statement',
+    expression: { type: 'string', value },
+  };
+  setTimeout(
+    () =>
+      parentPort.postMessage({
+        id,
+        statements: [statement],
+        locations: [LOCATION, null],
+      }),
+    10
+  );
+});
+`;
+
+describe('ParserPool', () => {
+  let dir: string;
+  let workerPath: string;
+  let pool: ParserPool | undefined;
+
+  function createPool(options: ParserPoolOptions = {}): ParserPool {
+    pool = new ParserPool({ workerPath, ...options });
+    return pool;
+  }
+
+  function valueOf(statements: unknown[]): unknown {
+    const [statement] = statements as { expression: { value: unknown } }[];
+    return statement.expression.value;
+  }
+
+  beforeAll(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'mcps-pool-'));
+    workerPath = join(dir, 'worker.mjs');
+    await writeFile(workerPath, FAKE_WORKER, 'utf-8');
+  });
+
+  afterEach(async () => {
+    await pool?.close();
+    pool = undefined;
+  });
+
+  afterAll(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should parse on up to size threads, keeping the source order', async () => {
+    const results = await createPool({ size: 2 }).parseAll([
+      'a',
+      'b',
+      'c',
+      'd',
+    ]);
+    const values = results.map(valueOf) as string[];
+    expect(values.map(value => value.split('@')[0])).toEqual([
+      'a',
+      'b',
+      'c',
+      'd',
+    ]);
+    expect(new Set(values.map(value => value.split('@')[1])).size).toBe(2);
+  });
+
+  it('should restore the locations of parsed nodes', async () => {
+    const [statement] = await createPool().parse('a');
+    expect(getLocation(statement)).toEqual({
+      start: { line: 1, column: 1, offset: 0 },
+      end: { line: 1, column: 4, offset: 3 },
+    });
+  });
+
+  it('should fail with the error parsing failed with', async () => {
+    const result = createPool().parse('bad');
+    await expect(result).rejects.toBeInstanceOf(ParseError);
+    await expect(result).rejects.toMatchObject({
+      message: 'Syntax error',
+      diagnostics: [{ message: 'Unexpected "("' }],
+    });
+  });
+
+  it('should replace a worker that crashed', async () => {
+    const parser = createPool({ size: 1 });
+    await expect(parser.parse('crash')).rejects.toThrow(
+      'Parser worker stopped with exit code 2'
+    );
+    expect(valueOf(await parser.parse('a'))).toMatch(/^a@/);
+  });
+
+  it('should pass parse limits to the workers', async () => {
+    const parser = createPool({ parseLimits: { maxDepth: 5 } });
+    expect(valueOf(await parser.parse('limits'))).toBe(5);
+  });
+
+  it('should fail sources given after closing', async () => {
+    const parser = createPool();
+    await parser.close();
+    await expect(parser.parse('a')).rejects.toThrow('Parser pool is closed');
+  });
+
+  it('should reject an invalid size', () => {
+    expect(() => new ParserPool({ size: 0 })).toThrow(
+      'Parser pool size must be a positive integer, got 0'
+    );
+  });
+});
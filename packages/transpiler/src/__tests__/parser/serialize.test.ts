@@ -0,0 +1,111 @@
+// Tests for syntax trees and ASTs as JSON and S-expressions
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import { parseTree } from '../../syntax.js';
+import {
+  programToJSON,
+  syntaxTreeToJSON,
+  syntaxTreeToSExpression,
+} from '../../serialize.js';
+
+describe('syntaxTreeToJSON', () => {
+  it('should serialize nodes with their ranges', () => {
+    const source = 'x = 1';
+    const json = syntaxTreeToJSON(parseTree(source), source);
+    expect(json).toMatchObject({
+      type: 'source_file',
+      named: true,
+      startByte: 0,
+      endByte: 5,
+      startPosition: { row: 0, column: 0 },
+      endPosition: { row: 0, column: 5 },
+    });
+    const [statement] = json.children!;
+    const [assignment] = statement.children!;
+    expect(
+      assignment.children!.map(child => [child.type, child.named])
+    ).toEqual([
+      ['assignment_target', true],
+      ['=', false],
+      ['expression', true],
+    ]);
+    expect(assignment.children![1]).toMatchObject({ text: '=', startByte: 2 });
+  });
+
+  it('should count byte offsets in UTF-8', () => {
+    const source = 'x = "é😀"\ny = 2';
+    const json = syntaxTreeToJSON(parseTree(source), source);
+    const second = json.children![1];
+    expect(second).toMatchObject({
+      startByte: 13,
+      endByte: 18,
+      startPosition: { row: 1, column: 0 },
+    });
+  });
+
+  it('should leave out anonymous nodes when asked to', () => {
+    const source = 'x = 1';
+    const json = syntaxTreeToJSON(parseTree(source), source, {
+      namedOnly: true,
+    });
+    const [statement] = json.children!;
+    const [assignment] = statement.children!;
+    expect(assignment.children!.map(child => child.type)).toEqual([
+      'assignment_target',
+      'expression',
+    ]);
+  });
+
+  it('should mark errors', () => {
+    const source = 'x = (';
+    const json = JSON.stringify(syntaxTreeToJSON(parseTree(source), source));
+    expect(json).toMatch(/"error":true|"missing":true/);
+  });
+});
+
+describe('syntaxTreeToSExpression', () => {
+  it('should print named nodes with their positions', () => {
+    expect(syntaxTreeToSExpression(parseTree('count = 42'))).toBe(
+      [
+        '(source_file [0, 0] - [0, 10]',
+        '  (statement [0, 0] - [0, 10]',
+        '    (assignment [0, 0] - [0, 10]',
+        '      (assignment_target [0, 0] - [0, 5]',
+        '        (identifier [0, 0] - [0, 5]))',
+        '      (expression [0, 8] - [0, 10]',
+        '        (literal [0, 8] - [0, 10]',
+        '          (number [0, 8] - [0, 10]))))))',
+      ].join('\n')
+    );
+  });
+
+  it('should match the compact form without positions', () => {
+    const tree = parseTree('print(x)\n');
+    expect(
+      syntaxTreeToSExpression(tree, { positions: false }).replace(/\s+/g, ' ')
+    ).toBe(tree.rootNode.toString());
+  });
+});
+
+describe('programToJSON', () => {
+  it('should copy the AST with the location of each node', () => {
+    const [statement] = programToJSON(parseSource('x = 1'));
+    expect(statement).toMatchObject({
+      type: 'assignment',
+      target: { type: 'identifier', name: 'x' },
+      value: {
+        type: 'number',
+        value: 1,
+        location: {
+          start: { line: 1, column: 5, offset: 4 },
+          end: { line: 1, column: 6, offset: 5 },
+        },
+      },
+      location: {
+        start: { line: 1, column: 1, offset: 0 },
+        end: { line: 1, column: 6, offset: 5 },
+      },
+    });
+    expect(JSON.parse(JSON.stringify(statement))).toEqual(statement);
+  });
+});
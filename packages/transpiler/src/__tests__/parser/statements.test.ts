@@ -3,6 +3,7 @@ import { describe, it, expect } from 'vitest';
 import { parseSource } from '../../parser.js';
 import {
   MCPDeclaration,
+  ImportStatement,
   Assignment,
   ExpressionStatement,
   CallExpression,
@@ -49,6 +50,19 @@ describe('Parser - Statements', () => {
     });
   });
 
+  describe('Imports', () => {
+    it('should parse import statements', () => {
+      const statements = parseSource(
+        'import "./servers.mcps"\nimport \'shared/models\''
+      );
+      expect(statements).toEqual([
+        { type: 'import_statement', path: './servers.mcps' },
+        { type: 'import_statement', path: 'shared/models' },
+      ]);
+      expect((statements[0] as ImportStatement).path).toBe('./servers.mcps');
+    });
+  });
+
   describe('MCP Declarations', () => {
     it('should parse simple MCP declaration', () => {
       const statements = parseSource(
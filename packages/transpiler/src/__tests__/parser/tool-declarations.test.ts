@@ -172,4 +172,23 @@ describe('Parser - Tool Declarations', () => {
     const tool = statements[0] as ToolDeclaration;
     expect(tool.body.statements).toHaveLength(3);
   });
+
+  it('should parse default values of parameters', () => {
+    const source = `
+      tool deploy(service: string, replicas: number = 3, tag = service) {
+        return replicas
+      }
+    `;
+    const tool = parseSource(source)[0] as ToolDeclaration;
+    expect(tool.parameters[0].defaultValue).toBeUndefined();
+    expect(tool.parameters[1]).toMatchObject({
+      name: 'replicas',
+      optional: false,
+      defaultValue: { type: 'number', value: 3 },
+    });
+    expect(tool.parameters[2].defaultValue).toMatchObject({
+      type: 'identifier',
+      name: 'service',
+    });
+  });
 });
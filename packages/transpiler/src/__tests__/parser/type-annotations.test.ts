@@ -40,6 +40,17 @@ describe('Type Annotation Parser', () => {
       });
     });
 
+    it('should parse bytes type annotation', () => {
+      const source = 'tool test(image: bytes) { return image }';
+      const ast = parseSource(source);
+      const tool = ast[0] as ToolDeclaration;
+
+      expect(tool.parameters[0].typeAnnotation).toEqual({
+        type: 'primitive_type',
+        value: 'bytes',
+      });
+    });
+
     it('should parse any type annotation', () => {
       const source = 'tool test(data: any) { return data }';
       const ast = parseSource(source);
@@ -0,0 +1,245 @@
+// Tests for refactorings of scripts
+import { describe, it, expect } from 'vitest';
+import {
+  applyRefactorEdits,
+  extractPrompts,
+  extractWorkflow,
+  inlineVariable,
+  moveDeclaration,
+  unusedName,
+  type Refactoring,
+} from '../../refactor.js';
+
+const MAIN = '/project/main.mcps';
+const LIB = '/project/lib.mcps';
+
+/** The content of a file after a refactoring */
+function applied(
+  refactoring: Refactoring,
+  path: string,
+  content: string
+): string {
+  return applyRefactorEdits(content, refactoring.edits[path]);
+}
+
+describe('inlineVariable', () => {
+  it('should replace the uses of a variable with its value', () => {
+    const content = 'x = 1 + 2\ny = x * 3\nprint(x, y)\n';
+    const refactoring = inlineVariable({ path: MAIN, content }, 0);
+    expect(refactoring.title).toBe('Inline variable x');
+    expect(applied(refactoring, MAIN, content)).toBe(
+      'y = (1 + 2) * 3\nprint(1 + 2, y)\n'
+    );
+  });
+
+  it('should refuse variables assigned more than once', () => {
+    const content = 'x = 1\nx = 2\nprint(x)\n';
+    expect(() => inlineVariable({ path: MAIN, content }, 0)).toThrow(
+      'x is assigned more than once'
+    );
+  });
+
+  it('should refuse to call a tool more often', () => {
+    const content =
+      'mcp fs { command: "fs" }\nx = fs.read("a")\nprint(x)\nprint(x)\n';
+    expect(() =>
+      inlineVariable({ path: MAIN, content }, content.indexOf('x ='))
+    ).toThrow('The value of x calls a tool, which inlining would call 2 times');
+  });
+
+  describe('with a value calling a tool', () => {
+    const FS = 'mcp fs { command: "fs" }\nx = fs.read("a")\n';
+    const inline = (rest: string) => {
+      const content = FS + rest;
+      return () =>
+        inlineVariable({ path: MAIN, content }, content.indexOf('x ='));
+    };
+    const MOVED =
+      'The value of x calls a tool, which inlining would move where it may not run exactly once';
+
+    it('should inline it into a use in the same block', () => {
+      const content = `${FS}print(x)\n`;
+      const refactoring = inlineVariable(
+        { path: MAIN, content },
+        content.indexOf('x =')
+      );
+      expect(applied(refactoring, MAIN, content)).toBe(
+        'mcp fs { command: "fs" }\nprint(fs.read("a"))\n'
+      );
+    });
+
+    it('should refuse a use in a loop', () => {
+      expect(inline('while (true) {\n  print(x)\n}\n')).toThrow(MOVED);
+      expect(inline('while (x) {\n  break\n}\n')).toThrow(MOVED);
+    });
+
+    it('should refuse a use in a branch', () => {
+      expect(inline('if (true) {\n  print(x)\n}\n')).toThrow(MOVED);
+      expect(inline('print(true || x)\n')).toThrow(MOVED);
+    });
+
+    it('should refuse a use in a tool body', () => {
+      expect(inline('tool show() {\n  print(x)\n}\n')).toThrow(MOVED);
+    });
+
+    it('should refuse moving the call past other calls', () => {
+      expect(inline('fs.write("b")\nprint(x)\n')).toThrow(
+        'The value of x calls a tool, which inlining would move past the call fs.write("b")'
+      );
+    });
+
+    it('should refuse moving the call past a return', () => {
+      const content =
+        'mcp fs { command: "fs" }\n' +
+        'tool f(c) {\n  x = fs.read("a")\n  if (c) {\n    return 0\n  }\n  return x\n}\n';
+      expect(() =>
+        inlineVariable({ path: MAIN, content }, content.indexOf('x ='))
+      ).toThrow(
+        'The value of x calls a tool, which inlining would move past return 0'
+      );
+    });
+
+    it('should refuse moving the call past a break or continue', () => {
+      for (const jump of ['break', 'continue']) {
+        const content =
+          'mcp fs { command: "fs" }\n' +
+          `while (true) {\n  x = fs.read("a")\n  if (done) {\n    ${jump}\n  }\n  print(x)\n}\n`;
+        expect(() =>
+          inlineVariable({ path: MAIN, content }, content.indexOf('x ='))
+        ).toThrow(
+          `The value of x calls a tool, which inlining would move past ${jump}`
+        );
+      }
+    });
+
+    it('should inline it past loops that break out of themselves', () => {
+      const content = `${FS}while (true) {\n  break\n}\nprint(x)\n`;
+      const refactoring = inlineVariable(
+        { path: MAIN, content },
+        content.indexOf('x =')
+      );
+      expect(applied(refactoring, MAIN, content)).toBe(
+        'mcp fs { command: "fs" }\nwhile (true) {\n  break\n}\nprint(fs.read("a"))\n'
+      );
+    });
+  });
+
+  it('should refuse values whose variables a loop assigns again', () => {
+    const content =
+      'a = 1\nx = a + 1\nwhile (a < 3) {\n  print(x)\n  a = a + 1\n}\n';
+    expect(() =>
+      inlineVariable({ path: MAIN, content }, content.indexOf('x ='))
+    ).toThrow('a is assigned again before x is used');
+  });
+
+  it('should refuse variables used in template strings', () => {
+    const content = 'x = 1\nprint(x)\nprint(`${x}`)\n';
+    expect(() => inlineVariable({ path: MAIN, content }, 0)).toThrow(
+      'x is used in a template string'
+    );
+  });
+});
+
+describe('extractWorkflow', () => {
+  it('should move statements into a tool taking what they read', () => {
+    const content = 'a = 1\nb = a + 1\nc = b * 2\nprint(c)\n';
+    const refactoring = extractWorkflow(
+      { path: MAIN, content },
+      { start: content.indexOf('b ='), end: content.indexOf('\nprint') },
+      'double'
+    );
+    expect(refactoring.title).toBe('Extract workflow double');
+    expect(applied(refactoring, MAIN, content)).toBe(
+      'a = 1\n' +
+        'tool double(a) {\n  b = a + 1\n  c = b * 2\n  return c\n}\n\n' +
+        'c = double(a)\nprint(c)\n'
+    );
+  });
+
+  it('should declare the tool before the top-level statement', () => {
+    const content = 'n = 2\nif (n > 1) {\n  print(n)\n}\n';
+    const start = content.indexOf('print');
+    const refactoring = extractWorkflow(
+      { path: MAIN, content },
+      { start, end: start + 'print(n)'.length },
+      'report'
+    );
+    expect(applied(refactoring, MAIN, content)).toBe(
+      'n = 2\ntool report(n) {\n  print(n)\n}\n\nif (n > 1) {\n  report(n)\n}\n'
+    );
+  });
+
+  it('should refuse statements assigning several later values', () => {
+    const content = 'a = 1\nb = 2\nprint(a, b)\n';
+    expect(() =>
+      extractWorkflow(
+        { path: MAIN, content },
+        { start: 0, end: content.indexOf('\nprint') },
+        'both'
+      )
+    ).toThrow('The statements assign a, b, which later statements read');
+  });
+
+  it('should pick names no declaration uses', () => {
+    const source = { path: MAIN, content: 'extracted = 1\n' };
+    expect(unusedName(source, 'extracted')).toBe('extracted2');
+  });
+});
+
+describe('moveDeclaration', () => {
+  it('should move a declaration and import it where it is used', () => {
+    const content =
+      '// Greets someone\ntool greet(name: string) {\n  print(name)\n}\n\n' +
+      'greet("Ada")\n';
+    const refactoring = moveDeclaration({ path: MAIN, content }, 'greet', {
+      path: LIB,
+    });
+    expect(refactoring.title).toBe('Move tool greet to lib.mcps');
+    expect(applied(refactoring, MAIN, content)).toBe(
+      'import "./lib"\n\ngreet("Ada")\n'
+    );
+    expect(applied(refactoring, LIB, '')).toBe(
+      '// Greets someone\ntool greet(name: string) {\n  print(name)\n}\n'
+    );
+  });
+
+  it('should refuse declarations using others of the file', () => {
+    const content = 'tool a() {}\ntool b() {\n  a()\n}\n';
+    expect(() =>
+      moveDeclaration({ path: MAIN, content }, 'b', { path: LIB })
+    ).toThrow('tool b uses a, which is declared in main.mcps; move a first');
+  });
+});
+
+describe('extractPrompts', () => {
+  const PROMPTS = '/project/prompts.mcps';
+
+  it('should move inline system prompts into a prompts module', () => {
+    const content =
+      'model claude { provider: "anthropic", model: "claude-sonnet" }\n\n' +
+      'agent Triager { model: claude, systemPrompt: "You triage issues." }\n';
+    const refactoring = extractPrompts(
+      { path: MAIN, content },
+      { path: PROMPTS, content: 'prompt TriagerPrompt { text: "Old" }\n' }
+    );
+    expect(refactoring.title).toBe('Extract prompt to prompts.mcps');
+    expect(applied(refactoring, MAIN, content)).toBe(
+      'import "./prompts"\n\n' +
+        'model claude { provider: "anthropic", model: "claude-sonnet" }\n\n' +
+        'agent Triager { model: claude, systemPrompt: TriagerPrompt2 }\n'
+    );
+    expect(
+      applied(refactoring, PROMPTS, 'prompt TriagerPrompt { text: "Old" }\n')
+    ).toBe(
+      'prompt TriagerPrompt { text: "Old" }\n\n' +
+        'prompt TriagerPrompt2 { text: "You triage issues." }\n'
+    );
+  });
+
+  it('should leave prompts that prompt text would interpolate', () => {
+    const content = 'agent A { systemPrompt: "Costs ${price}" }\n';
+    expect(() =>
+      extractPrompts({ path: MAIN, content }, { path: PROMPTS })
+    ).toThrow('No agent in main.mcps has a system prompt written as a string');
+  });
+});
@@ -0,0 +1,98 @@
+// Tests for the cron expressions of trigger schedules
+import { describe, it, expect } from 'vitest';
+import {
+  nextScheduledTime,
+  parseSchedule,
+  ScheduleError,
+} from '../../schedule.js';
+
+describe('parseSchedule', () => {
+  it('should expand lists, ranges and steps', () => {
+    const schedule = parseSchedule('*/15 9-17 1,15 * MON-FRI');
+    expect([...schedule.minutes]).toEqual([0, 15, 30, 45]);
+    expect([...schedule.hours]).toEqual([9, 10, 11, 12, 13, 14, 15, 16, 17]);
+    expect([...schedule.days]).toEqual([1, 15]);
+    expect(schedule.months.size).toBe(12);
+    expect([...schedule.weekdays]).toEqual([1, 2, 3, 4, 5]);
+    expect(schedule.everyDay).toBe(false);
+    expect(schedule.everyWeekday).toBe(false);
+  });
+
+  it('should step from a start to the end of the field', () => {
+    expect([...parseSchedule('50/5 * * * *').minutes]).toEqual([50, 55]);
+  });
+
+  it('should treat 7 as Sunday', () => {
+    expect([...parseSchedule('0 0 * * 7').weekdays]).toEqual([0]);
+  });
+
+  it('should expand macros', () => {
+    const schedule = parseSchedule('@daily');
+    expect([...schedule.minutes]).toEqual([0]);
+    expect([...schedule.hours]).toEqual([0]);
+    expect(schedule.expression).toBe('@daily');
+  });
+
+  it('should reject malformed expressions', () => {
+    expect(() => parseSchedule('0 2 * *')).toThrow(
+      'Invalid schedule "0 2 * *": expected 5 fields (minute hour day month weekday)'
+    );
+    expect(() => parseSchedule('60 * * * *')).toThrow(
+      'minute 60 is out of range 0-59'
+    );
+    expect(() => parseSchedule('*/0 * * * *')).toThrow(
+      '0 is not a valid step'
+    );
+    expect(() => parseSchedule('0 5-1 * * *')).toThrow(
+      '5-1 is an empty range'
+    );
+    expect(() => parseSchedule('0 0 * * FUN')).toThrow(
+      'FUN is not a valid day of the week'
+    );
+    expect(() => parseSchedule('@often')).toThrow('unknown macro @often');
+    expect(() => parseSchedule('@often')).toThrow(ScheduleError);
+  });
+
+  it('should reject schedules that never fire', () => {
+    expect(() => parseSchedule('0 0 30 2 *')).toThrow(
+      'none of its months have any of its days'
+    );
+    expect(() => parseSchedule('0 0 29 2 *')).not.toThrow();
+  });
+});
+
+describe('nextScheduledTime', () => {
+  const next = (expression: string, after: Date) =>
+    nextScheduledTime(parseSchedule(expression), after);
+
+  it('should find the next matching minute', () => {
+    expect(next('0 2 * * *', new Date(2026, 0, 10, 1, 30, 45))).toEqual(
+      new Date(2026, 0, 10, 2, 0)
+    );
+    expect(next('0 2 * * *', new Date(2026, 0, 10, 2, 0))).toEqual(
+      new Date(2026, 0, 11, 2, 0)
+    );
+    expect(next('*/15 * * * *', new Date(2026, 0, 10, 23, 50))).toEqual(
+      new Date(2026, 0, 11, 0, 0)
+    );
+  });
+
+  it('should roll over months and years', () => {
+    expect(next('0 0 1 * *', new Date(2026, 11, 15))).toEqual(
+      new Date(2027, 0, 1)
+    );
+    expect(next('0 0 29 2 *', new Date(2026, 2, 1))).toEqual(
+      new Date(2028, 1, 29)
+    );
+  });
+
+  it('should match either day field when both are restricted', () => {
+    // 2026-01-10 is a Saturday; the 13th is the next day of the month
+    expect(next('0 0 13 * MON', new Date(2026, 0, 10))).toEqual(
+      new Date(2026, 0, 12)
+    );
+    expect(next('0 0 13 * *', new Date(2026, 0, 10))).toEqual(
+      new Date(2026, 0, 13)
+    );
+  });
+});
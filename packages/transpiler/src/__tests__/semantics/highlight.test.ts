@@ -0,0 +1,105 @@
+// Tests for rendering highlighted scripts as HTML and ANSI-colored text
+import { describe, it, expect } from 'vitest';
+import { parseTree } from '../../syntax.js';
+import {
+  HIGHLIGHT_THEMES,
+  escapeHtml,
+  highlightAnsi,
+  highlightCss,
+  highlightHtml,
+  highlightSegments,
+  themeStyle,
+  tokenClass,
+} from '../../highlight.js';
+
+describe('highlight', () => {
+  describe('tokenClass', () => {
+    it('should include the classes of parent captures', () => {
+      expect(tokenClass('string')).toBe('tok-string');
+      expect(tokenClass('keyword.control')).toBe(
+        'tok-keyword tok-keyword-control'
+      );
+      expect(tokenClass('function.call', 'hl-')).toBe(
+        'hl-function hl-function-call'
+      );
+    });
+  });
+
+  describe('themeStyle', () => {
+    it('should fall back to the style of parent captures', () => {
+      const { dark } = HIGHLIGHT_THEMES;
+      expect(themeStyle(dark, 'keyword.control')).toBe(
+        dark.styles['keyword.control']
+      );
+      expect(themeStyle(dark, 'function.method.call')).toBe(
+        dark.styles.function
+      );
+      expect(themeStyle(dark, 'unknown')).toBeUndefined();
+    });
+  });
+
+  describe('highlightSegments', () => {
+    it('should cover the source in order', () => {
+      const source = 'if (x > 1) {\n  print("big")\n}\n';
+      const segments = highlightSegments(parseTree(source), source);
+      expect(segments.map(({ text }) => text).join('')).toBe(source);
+      expect(segments[0]).toEqual({ text: 'if', name: 'keyword.control' });
+    });
+  });
+
+  describe('highlightHtml', () => {
+    it('should wrap tokens in spans and escape the text', () => {
+      const source = 'x = "<b>" // note\n';
+      expect(highlightHtml(parseTree(source), source)).toBe(
+        '<span class="tok-variable">x</span> ' +
+          '<span class="tok-operator">=</span> ' +
+          '<span class="tok-string">&quot;&lt;b&gt;&quot;</span> ' +
+          '<span class="tok-comment">// note</span>\n'
+      );
+    });
+
+    it('should keep the source text when tags are removed', () => {
+      const source = 'while (true) {\n  break\n}\n';
+      const html = highlightHtml(parseTree(source), source);
+      expect(html).toContain(
+        '<span class="tok-keyword tok-keyword-control">while</span>'
+      );
+      expect(html.replace(/<[^>]+>/g, '')).toBe(escapeHtml(source));
+    });
+  });
+
+  describe('highlightCss', () => {
+    it('should style the container and each capture of the theme', () => {
+      const css = highlightCss(HIGHLIGHT_THEMES.light, {
+        container: 'code',
+      });
+      expect(css).toContain('.code { background: #ffffff; color: #24292f; }');
+      expect(css).toContain(
+        '.code .tok-keyword-control { color: #cf222e; font-weight: bold; }'
+      );
+      expect(css).toContain(
+        '.code .tok-comment { color: #6e7781; font-style: italic; }'
+      );
+    });
+  });
+
+  describe('highlightAnsi', () => {
+    it('should color tokens and reset at line breaks', () => {
+      const source = 'x = 1 // a\n';
+      const text = highlightAnsi(parseTree(source), source);
+      expect(text.replace(/\x1b\[[0-9;]*m/g, '')).toBe(source);
+      expect(text).toContain('\x1b[38;2;209;154;102m1\x1b[0m');
+      expect(text).toContain('\x1b[3;38;2;127;132;142m// a\x1b[0m\n');
+    });
+
+    it('should use the given theme', () => {
+      const source = 'x = 1\n';
+      const text = highlightAnsi(
+        parseTree(source),
+        source,
+        HIGHLIGHT_THEMES.light
+      );
+      expect(text).toContain('\x1b[38;2;5;80;174m1\x1b[0m');
+    });
+  });
+});
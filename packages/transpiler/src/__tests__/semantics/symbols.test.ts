@@ -0,0 +1,132 @@
+// Tests for the symbol table built on the locals query
+import { describe, it, expect } from 'vitest';
+import { parseTree, type SyntaxNode } from '../../syntax.js';
+import { buildSymbolTable } from '../../semantics.js';
+
+const SOURCE = `model claude { provider: "anthropic" }
+
+agent Helper { model: claude }
+
+tool greet(name: string): string {
+  message = "Hello " + name
+  return message
+}
+
+total = 0
+for (i = 0; i < 3; i = i + 1) {
+  total = total + i
+}
+print(greet("Ada"), total)
+`;
+
+function identifiers(node: SyntaxNode, text: string): SyntaxNode[] {
+  const found: SyntaxNode[] = [];
+  const visit = (n: SyntaxNode) => {
+    if (n.type === 'identifier' && n.text === text) {
+      found.push(n);
+    }
+    n.children.forEach(visit);
+  };
+  visit(node);
+  return found;
+}
+
+describe('buildSymbolTable', () => {
+  it('should collect declarations with their kinds', () => {
+    const table = buildSymbolTable(parseTree(SOURCE));
+    const kinds = table.symbols.map(s => [s.name, s.kind]);
+
+    expect(kinds).toEqual([
+      ['claude', 'model'],
+      ['Helper', 'agent'],
+      ['greet', 'tool'],
+      ['name', 'parameter'],
+      ['message', 'variable'],
+      ['total', 'variable'],
+      ['i', 'variable'],
+    ]);
+  });
+
+  it('should nest scopes', () => {
+    const table = buildSymbolTable(parseTree(SOURCE));
+
+    expect(table.root.node.type).toBe('source_file');
+    expect(table.root.children.map(s => s.node.type)).toEqual([
+      'tool_declaration',
+      'for_statement',
+    ]);
+    const tool = table.root.children[0];
+    expect(tool.symbols.map(s => s.name)).toEqual(['name']);
+    expect(tool.children[0].symbols.map(s => s.name)).toEqual(['message']);
+    expect(table.root.symbols.map(s => s.name)).toContain('greet');
+  });
+
+  it('should resolve references and rebinding assignments', () => {
+    const tree = parseTree(SOURCE);
+    const table = buildSymbolTable(tree);
+    const total = table.symbols.find(s => s.name === 'total')!;
+    const [declaration, write, read, printed] = identifiers(
+      tree.rootNode,
+      'total'
+    );
+
+    expect(total.node.id).toBe(declaration.id);
+    expect(total.writes.map(n => n.id)).toEqual([write.id]);
+    expect(total.references.map(n => n.id)).toEqual([read.id, printed.id]);
+    expect(table.lookup(write)).toBe(total);
+    expect(table.lookup(printed)).toBe(total);
+    expect(table.occurrences(total)).toHaveLength(4);
+  });
+
+  it('should resolve hoisted declarations used before they appear', () => {
+    const tree = parseTree('print(f(1))\ntool f(x) { return x }');
+    const table = buildSymbolTable(tree);
+    const [call] = identifiers(tree.rootNode, 'f');
+
+    expect(table.lookup(call)?.kind).toBe('tool');
+    expect(table.unresolved()).toEqual([]);
+  });
+
+  it('should report unresolved references but not runtime globals', () => {
+    const table = buildSymbolTable(parseTree('x = y + 1\nprint(x, z)'));
+
+    expect(table.unresolved().map(r => r.node.text)).toEqual(['y', 'z']);
+  });
+
+  it('should not resolve a variable within its first assignment', () => {
+    const table = buildSymbolTable(parseTree('count = count + 1'));
+
+    expect(table.unresolved().map(r => r.node.text)).toEqual(['count']);
+  });
+
+  it('should give tool bodies their own variables', () => {
+    const tree = parseTree('x = 1\ntool f() {\n  x = 2\n  return x\n}');
+    const table = buildSymbolTable(tree);
+    const [outer, inner, returned] = identifiers(tree.rootNode, 'x');
+
+    expect(table.lookup(inner)).not.toBe(table.lookup(outer));
+    expect(table.lookup(returned)).toBe(table.lookup(inner));
+    expect(table.lookup(inner)?.scope.node.type).toBe('block_statement');
+  });
+
+  it('should let blocks rebind variables from outer scopes', () => {
+    const tree = parseTree('x = 1\nif (true) {\n  x = 2\n}\nprint(x)');
+    const table = buildSymbolTable(tree);
+    const occurrences = identifiers(tree.rootNode, 'x');
+
+    const symbol = table.lookup(occurrences[0])!;
+    expect(occurrences.map(node => table.lookup(node))).toEqual([
+      symbol,
+      symbol,
+      symbol,
+    ]);
+  });
+
+  it('should find the innermost scope of a node', () => {
+    const tree = parseTree(SOURCE);
+    const table = buildSymbolTable(tree);
+    const [, message] = identifiers(tree.rootNode, 'message');
+
+    expect(table.scopeAt(message).node.type).toBe('block_statement');
+  });
+});
@@ -0,0 +1,160 @@
+// Tests for querying many files at once and the tags index built on it
+import { describe, it, expect } from 'vitest';
+import { queryFiles } from '../../batch.js';
+import { getQuery, parseTree } from '../../syntax.js';
+import { indexTags } from '../../tags.js';
+
+const FILES: Record<string, string> = {
+  'main.mcps': `mcp fs { command: "npx" }
+
+total = 0
+total = total + 1
+print(greet("Ada"))
+`,
+  'tools.mcps': `model claude { provider: "anthropic" }
+
+agent Helper { model: claude }
+
+tool greet(name: string): string {
+  return fs.read(name)
+}
+`,
+};
+
+/**
+ * Load files from FILES, resolving in reverse order of request so that
+ * later files finish first
+ */
+function reversedLoader() {
+  let pending = 0;
+  return async (file: string) => {
+    pending++;
+    await new Promise(resolve => setTimeout(resolve, 10 * (10 - pending)));
+    if (!(file in FILES)) {
+      throw new Error(`ENOENT: ${file}`);
+    }
+    return FILES[file];
+  };
+}
+
+describe('queryFiles', () => {
+  it('should merge captures in file order, whichever loads first', async () => {
+    const load = reversedLoader();
+    const { captures, failed } = await queryFiles(
+      getQuery('tags'),
+      ['tools.mcps', 'main.mcps'],
+      load
+    );
+    expect(failed).toEqual([]);
+    const names = captures
+      .filter(capture => capture.name === 'name')
+      .map(capture => [capture.file, capture.node.text]);
+    expect(names).toEqual([
+      ['tools.mcps', 'claude'],
+      ['tools.mcps', 'Helper'],
+      ['tools.mcps', 'greet'],
+      ['tools.mcps', 'read'],
+      ['main.mcps', 'fs'],
+      ['main.mcps', 'total'],
+      ['main.mcps', 'total'],
+      ['main.mcps', 'print'],
+      ['main.mcps', 'greet'],
+    ]);
+  });
+
+  it('should report files that fail without stopping the others', async () => {
+    const load = reversedLoader();
+    const { captures, failed } = await queryFiles(
+      getQuery('folds'),
+      ['missing.mcps', 'tools.mcps'],
+      load
+    );
+    expect(failed.map(({ file, error }) => [file, error.message])).toEqual([
+      ['missing.mcps', 'ENOENT: missing.mcps'],
+    ]);
+    expect(captures.every(capture => capture.file === 'tools.mcps')).toBe(
+      true
+    );
+    expect(captures).not.toHaveLength(0);
+  });
+
+  it('should load at most the given number of files at once', async () => {
+    let loading = 0;
+    let most = 0;
+    const files = Array.from({ length: 10 }, (_, i) => `${i}.mcps`);
+    const load = async () => {
+      most = Math.max(most, ++loading);
+      await new Promise(resolve => setTimeout(resolve, 5));
+      loading--;
+      return 'x = [1, 2]';
+    };
+    await queryFiles(getQuery('folds'), files, load, { concurrency: 3 });
+    expect(most).toBe(3);
+  });
+
+  it('should use trees that are already parsed', async () => {
+    const tree = parseTree('tool open() {}');
+    const { captures } = await queryFiles(
+      getQuery('tags'),
+      ['open.mcps'],
+      async () => tree
+    );
+    expect(captures.map(capture => capture.node.text)).toEqual([
+      'tool open() {}',
+      'open',
+    ]);
+  });
+
+  it('should stop when cancelled', async () => {
+    const controller = new AbortController();
+    const loaded: string[] = [];
+    const result = queryFiles(
+      getQuery('folds'),
+      ['a.mcps', 'b.mcps', 'c.mcps'],
+      async file => {
+        loaded.push(file);
+        controller.abort(new Error('cancelled'));
+        return '';
+      },
+      { concurrency: 1, signal: controller.signal }
+    );
+    await expect(result).rejects.toThrow('cancelled');
+    expect(loaded).toEqual(['a.mcps']);
+  });
+
+  it('should reject an invalid concurrency', async () => {
+    await expect(
+      queryFiles(getQuery('folds'), [], async () => '', { concurrency: 0 })
+    ).rejects.toThrow('Batch concurrency must be a positive integer, got 0');
+  });
+});
+
+describe('indexTags', () => {
+  it('should index definitions and calls across files', async () => {
+    const load = reversedLoader();
+    const { tags } = await indexTags(['main.mcps', 'tools.mcps'], load);
+    expect(
+      tags.map(tag => [tag.file, tag.name, tag.kind, tag.definition])
+    ).toEqual([
+      ['main.mcps', 'fs', 'module', true],
+      ['main.mcps', 'total', 'variable', true],
+      ['main.mcps', 'print', 'call', false],
+      ['main.mcps', 'greet', 'call', false],
+      ['tools.mcps', 'claude', 'model', true],
+      ['tools.mcps', 'Helper', 'agent', true],
+      ['tools.mcps', 'greet', 'function', true],
+      ['tools.mcps', 'read', 'call', false],
+    ]);
+  });
+
+  it('should locate the tagged node and its name', async () => {
+    const { tags } = await indexTags(['a.mcps'], async () => 'x = 1\n');
+    expect(tags[0]).toMatchObject({
+      range: { start: { row: 0, column: 0 }, end: { row: 0, column: 5 } },
+      nameRange: {
+        start: { row: 0, column: 0 },
+        end: { row: 0, column: 1 },
+      },
+    });
+  });
+});
@@ -0,0 +1,96 @@
+// Tests for highlighted tokens and the differences between two trees
+import { describe, it, expect } from 'vitest';
+import {
+  applyTextEdit,
+  parseTree,
+  reparseTree,
+  type SyntaxPoint,
+} from '../../syntax.js';
+import { diffTokens, highlightTokens } from '../../tokens.js';
+
+/**
+ * Parse a document, edit it, and diff the trees before and after
+ */
+function edit(
+  source: string,
+  start: SyntaxPoint,
+  end: SyntaxPoint,
+  text: string
+) {
+  const oldTree = parseTree(source);
+  const { content, edit } = applyTextEdit(source, start, end, text);
+  const newTree = reparseTree(oldTree, content, [edit]);
+  return { content, diff: diffTokens(oldTree, newTree) };
+}
+
+describe('highlightTokens', () => {
+  it('should list highlighted nodes in order', () => {
+    const tokens = highlightTokens(parseTree('x = 1'));
+    expect(tokens.map(token => [token.name, token.startIndex])).toEqual([
+      ['variable', 0],
+      ['operator', 2],
+      ['number', 4],
+    ]);
+  });
+
+  it('should only list tokens overlapping a range', () => {
+    const tree = parseTree('x = 1\ny = "a"\n');
+    const [, second] = tree.rootNode.namedChildren;
+    const tokens = highlightTokens(tree, second);
+    expect(tokens.map(token => token.name)).toEqual([
+      'variable',
+      'operator',
+      'string',
+    ]);
+  });
+});
+
+describe('diffTokens', () => {
+  it('should report the tokens of the changed range only', () => {
+    const { diff } = edit(
+      'x = 1\nprint(x)\n',
+      { row: 0, column: 4 },
+      { row: 0, column: 5 },
+      '"one"'
+    );
+    expect(diff.ranges.length).toBeGreaterThan(0);
+    expect(diff.ranges.every(range => range.startPosition.row === 0)).toBe(
+      true
+    );
+    expect(diff.removed.map(token => token.name)).toContain('number');
+    expect(diff.added).toContainEqual(
+      expect.objectContaining({
+        name: 'string',
+        startPosition: { row: 0, column: 4 },
+        endPosition: { row: 0, column: 9 },
+      })
+    );
+    expect(diff.added.every(token => token.startPosition.row === 0)).toBe(
+      true
+    );
+  });
+
+  it('should cover edits that keep the structure of the tree', () => {
+    const { diff } = edit(
+      'name = 1\ny = 2\n',
+      { row: 0, column: 4 },
+      { row: 0, column: 4 },
+      'space'
+    );
+    expect(
+      diff.ranges.some(range => range.startIndex <= 4 && range.endIndex >= 9)
+    ).toBe(true);
+    expect(diff.added).toContainEqual(
+      expect.objectContaining({ name: 'variable', endIndex: 9 })
+    );
+  });
+
+  it('should report nothing for trees that did not change', () => {
+    const tree = parseTree('x = 1\n');
+    expect(diffTokens(tree, parseTree('x = 1\n'))).toEqual({
+      ranges: [],
+      removed: [],
+      added: [],
+    });
+  });
+});
@@ -1,4 +1,32 @@
-// Test helpers for codegen tests
+// Test helpers for codegen tests and the WebAssembly entries
+import Parser from 'tree-sitter';
+import type { WebTreeSitter } from '../browser.js';
 import { generateCodeUnsafe } from '../codegen.js';
+import { MCPScriptLanguage } from '../native.js';
 
 export { generateCodeUnsafe as generateCodeForTest };
+
+/**
+ * Stands in for web-tree-sitter, which has the same tree and query API,
+ * running the native grammar and recording what it is asked to load
+ */
+export function fakeWebTreeSitter(loaded: unknown[]): WebTreeSitter {
+  class WebParser extends Parser {
+    static async init() {}
+    static Language = {
+      async load(input: string | Uint8Array) {
+        loaded.push(input);
+        return {
+          query: (source: string) =>
+            new Parser.Query(MCPScriptLanguage!, source),
+          lookaheadIterator: (state: number) =>
+            new Parser.LookaheadIterator(MCPScriptLanguage!, state),
+        };
+      },
+    };
+    setLanguage() {
+      return super.setLanguage(MCPScriptLanguage!);
+    }
+  }
+  return WebParser as unknown as WebTreeSitter;
+}
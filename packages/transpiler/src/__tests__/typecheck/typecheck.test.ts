@@ -0,0 +1,702 @@
+// Tests for static type checking of tool signatures
+import { describe, it, expect } from 'vitest';
+import { parseSource } from '../../parser.js';
+import {
+  typecheck,
+  checkTypes,
+  isAssignable,
+  typeToJsonSchema,
+  typeToString,
+  TypeCheckError,
+} from '../../typecheck.js';
+import { ToolDeclaration, TypeExpression } from '../../ast.js';
+
+const string: TypeExpression = { type: 'primitive_type', value: 'string' };
+const number: TypeExpression = { type: 'primitive_type', value: 'number' };
+const nul: TypeExpression = { type: 'primitive_type', value: 'null' };
+
+describe('Type Checker', () => {
+  describe('isAssignable', () => {
+    it('should compare primitive types', () => {
+      expect(isAssignable(string, string)).toBe(true);
+      expect(isAssignable(number, string)).toBe(false);
+    });
+
+    it('should accept union members', () => {
+      const union: TypeExpression = {
+        type: 'union_type',
+        types: [string, nul],
+      };
+      expect(isAssignable(string, union)).toBe(true);
+      expect(isAssignable(nul, union)).toBe(true);
+      expect(isAssignable(union, string)).toBe(false);
+    });
+
+    it('should compare object types structurally', () => {
+      const target: TypeExpression = {
+        type: 'object_type',
+        properties: [
+          { name: 'name', optional: false, typeAnnotation: string },
+          { name: 'age', optional: true, typeAnnotation: number },
+        ],
+      };
+      const source: TypeExpression = {
+        type: 'object_type',
+        properties: [
+          { name: 'name', optional: false, typeAnnotation: string },
+          { name: 'extra', optional: false, typeAnnotation: number },
+        ],
+      };
+      expect(isAssignable(source, target)).toBe(true);
+      expect(
+        isAssignable({ type: 'object_type', properties: [] }, target)
+      ).toBe(false);
+    });
+
+    it('should render types as source', () => {
+      expect(
+        typeToString({
+          type: 'array_type',
+          elementType: { type: 'union_type', types: [string, number] },
+        })
+      ).toBe('(string | number)[]');
+    });
+
+    it('should describe types as JSON Schema', () => {
+      const [tool] = parseSource(
+        'tool f(a: string | null, b: { name: string, tags?: any[] }) { }'
+      );
+      const [a, b] = (tool as ToolDeclaration).parameters.map(p =>
+        typeToJsonSchema(p.typeAnnotation!)
+      );
+      expect(a).toEqual({ type: ['string', 'null'] });
+      expect(
+        typeToJsonSchema({ type: 'primitive_type', value: 'bytes' })
+      ).toEqual({ type: 'string', contentEncoding: 'base64' });
+      expect(b).toEqual({
+        type: 'object',
+        properties: {
+          name: { type: 'string' },
+          tags: { type: 'array', items: {} },
+        },
+        required: ['name'],
+      });
+    });
+  });
+
+  describe('Valid Programs', () => {
+    it('should accept calls matching the tool signature', () => {
+      const statements = parseSource(`
+        tool greet(name: string, age?: number): string {
+          return "Hello " + name
+        }
+        a = greet("Alice", 30)
+        b = greet("Bob")
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should accept values of unknown type', () => {
+      const statements = parseSource(`
+        tool double(n: number): number {
+          return n * 2
+        }
+        x = double(env.COUNT)
+        y = double(JSON.parse("1"))
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should treat reassigned variables as any', () => {
+      const statements = parseSource(`
+        tool shout(text: string): string {
+          return text
+        }
+        value = null
+        value = "hi"
+        shout(value)
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should use declared return types at call sites', () => {
+      const statements = parseSource(`
+        tool count(): number {
+          return 3
+        }
+        tool double(n: number): number {
+          return n * 2
+        }
+        result = double(count())
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should type bytes values', () => {
+      const statements = parseSource(`
+        tool upload(data: bytes, size: number): number {
+          return data[0] + size
+        }
+        data = bytes.from("aGk=", "base64")
+        upload(data, data.length)
+        upload(bytes.concat([data, data]), 2)
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+  });
+
+  describe('Type Errors', () => {
+    it('should report argument type mismatches with locations', () => {
+      const statements = parseSource(`tool greet(name: string): string {
+  return name
+}
+greet(42)`);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics).toHaveLength(1);
+      expect(diagnostics[0].code).toBe('argument-type');
+      expect(diagnostics[0].message).toBe(
+        "Argument of type 'number' is not assignable to parameter 'name' of type 'string'"
+      );
+      expect(diagnostics[0].location?.start).toEqual({
+        line: 4,
+        column: 7,
+        offset: 57,
+      });
+    });
+
+    it('should report wrong argument counts', () => {
+      const statements = parseSource(`
+        tool add(a: number, b: number): number {
+          return a + b
+        }
+        add(1)
+        add(1, 2, 3)
+      `);
+      const codes = typecheck(statements).map(d => d.code);
+      expect(codes).toEqual(['missing-argument', 'too-many-arguments']);
+    });
+
+    it('should report strings passed as bytes', () => {
+      const statements = parseSource(`
+        tool upload(data: bytes) { }
+        upload("aGk=")
+      `);
+      expect(typecheck(statements).map(d => d.message)).toEqual([
+        "Argument of type 'string' is not assignable to parameter 'data' of type 'bytes'",
+      ]);
+    });
+
+    it('should check default values of parameters', () => {
+      const statements = parseSource(`
+        tool deploy(service: string, replicas: number = "three"): number {
+          return replicas + 1
+        }
+        deploy("api")
+        on event("deploy.finished") (deploy = {}) { print(deploy) }
+      `);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics.map(d => d.code)).toEqual(['default-value', 'event']);
+      expect(diagnostics[0].message).toBe(
+        "Default value of type 'string' is not assignable to parameter " +
+          "'replicas' of type 'number'"
+      );
+      expect(diagnostics[1].message).toBe(
+        'Handler parameters cannot have default values'
+      );
+    });
+
+    it('should report object argument property mismatches', () => {
+      const statements = parseSource(`
+        tool createUser(data: { name: string, age: number }): string {
+          return data.name
+        }
+        createUser({ name: "Bob", age: "old" })
+      `);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics.map(d => d.code)).toEqual(['argument-type']);
+      expect(diagnostics[0].message).toContain(
+        "'{ name: string, age: string }'"
+      );
+    });
+
+    it('should report return type mismatches', () => {
+      const statements = parseSource(`
+        tool label(n: number): string {
+          return n + 1
+        }
+      `);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics.map(d => d.code)).toEqual(['return-type']);
+    });
+
+    it('should report missing return values', () => {
+      const statements = parseSource(`
+        tool early(flag: boolean): string {
+          if (flag) {
+            return
+          }
+          return "done"
+        }
+        tool never(): number {
+          print("no return")
+        }
+      `);
+      const codes = typecheck(statements).map(d => d.code);
+      expect(codes).toEqual(['missing-return-value', 'missing-return']);
+    });
+
+    it('should report duplicate properties in object types', () => {
+      const statements = parseSource(`
+        tool f(data: { a: string, a: number }) {
+          return data
+        }
+      `);
+      const codes = typecheck(statements).map(d => d.code);
+      expect(codes).toEqual(['duplicate-property']);
+    });
+
+    it('should report parallel branches that share assigned variables', () => {
+      const statements = parseSource(`
+        total = 0
+        parallel {
+          a = "a"
+          total = total + 1
+          print(a)
+          { total = 2 }
+        }
+      `);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics.map(d => d.message)).toEqual([
+        "'a' is assigned in one parallel branch and used in another",
+        "'total' is assigned in one parallel branch and used in another",
+      ]);
+      expect(diagnostics.map(d => d.location?.start.line)).toEqual([4, 7]);
+    });
+
+    it('should allow variables local to a parallel branch', () => {
+      const statements = parseSource(`
+        parallel (2) {
+          { tmp = 1
+            print(tmp) }
+          { tmp = 2
+            print(tmp) }
+        }
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should report jumps and declarations in parallel branches', () => {
+      const statements = parseSource(`
+        tool f() {
+          parallel ("two") {
+            return 1
+            while (true) { break }
+            tool g() {}
+          }
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['parallel-limit', "Parallel limit must be a number, got 'string'"],
+        ['parallel-branch', "'return' cannot leave a parallel branch"],
+        ['parallel-branch', 'Declarations are not allowed in a parallel block'],
+      ]);
+    });
+
+    it('should check the body of parallel maps', () => {
+      const statements = parseSource(`
+        tool f() {
+          total = 0
+          results = parallelMap([1, 2], limit: "two") { n =>
+            total = total + n
+            if (n > 1) { return n }
+            while (true) { break }
+            continue
+          }
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['parallel-limit', "parallelMap limit must be a number, got 'string'"],
+        [
+          'parallel-conflict',
+          "'total' is assigned in a parallelMap body, which runs for several items at once",
+        ],
+        ['parallel-branch', "'continue' cannot leave a parallelMap body"],
+      ]);
+    });
+
+    it('should check idempotency annotations', () => {
+      const statements = parseSource(`
+        mcp shop { command: "shop-server" }
+        @idempotent(key: "order-" + 1) order = shop.createOrder([])
+        @idempotent(key: [1]) shop.createOrder([])
+        @idempotent(id: 1) total = 2
+        @retry(times: 2) shop.createOrder([])
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'annotation',
+          "@idempotent key must be a string or a number, got 'number[]'",
+        ],
+        ['annotation', "Unknown argument 'id' of @idempotent; expected key"],
+        ['annotation', '@idempotent needs a key'],
+        [
+          'annotation',
+          '@idempotent only applies to a statement making a call',
+        ],
+        [
+          'annotation',
+          "Unknown annotation '@retry'; expected @idempotent or @trace",
+        ],
+      ]);
+    });
+
+    it('should check trace points', () => {
+      const statements = parseSource(`
+        @trace total = 2
+        @trace(label: "sum", level: "debug") total + 1
+        @trace(label: 3) total
+        @trace(level: "verbose", every: 2) total
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['annotation', "@trace label must be a string, got 'number'"],
+        ['annotation', '@trace level must be "info" or "debug"'],
+        [
+          'annotation',
+          "Unknown argument 'every' of @trace; expected label or level",
+        ],
+      ]);
+    });
+
+    it('should check compensate blocks', () => {
+      const statements = parseSource(`
+        tool f() {
+          ticket = create() compensate {
+            remove(ticket)
+            return 1
+          }
+        }
+        done = create() compensate {
+          model m { provider: "openai" }
+          while (true) { break }
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['compensation', "'return' cannot leave a compensate block"],
+        ['compensation', 'Declarations are not allowed in a compensate block'],
+      ]);
+    });
+
+    it('should check event handlers', () => {
+      const statements = parseSource(`
+        tool double(n: number): number {
+          return n * 2
+        }
+        on event("deploy.finished") (deploy: { version: string }) {
+          double(deploy.version)
+        }
+        on event("") { print("never") }
+        tool f() {
+          on event("inner") { print("inner") }
+        }
+      `);
+      const diagnostics = typecheck(statements);
+      expect(diagnostics.map(d => d.code)).toEqual([
+        'event',
+        'argument-type',
+        'event',
+      ]);
+      expect(diagnostics[0].message).toBe(
+        'Event handlers must be declared at the top level of a script'
+      );
+      expect(diagnostics[2].message).toBe('Event handlers need an event name');
+    });
+
+    it('should check test cases and fixtures', () => {
+      const statements = parseSource(`
+        tool double(n: number): number {
+          return n * 2
+        }
+        issues = []
+        fixture issues = [1]
+        fixture count = 2
+        test "doubles" { double(count) }
+        test "doubles" { double("two") }
+        tool f() {
+          test "inner" {}
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'test',
+          'Test cases, hooks and fixtures must be declared at the top level of a script',
+        ],
+        ['test', 'Fixture issues is already a variable of the script'],
+        ['test', 'Test "doubles" is declared more than once'],
+        [
+          'argument-type',
+          "Argument of type 'string' is not assignable to parameter 'n' of type 'number'",
+        ],
+      ]);
+    });
+
+    it('should check notification handlers and listen statements', () => {
+      const statements = parseSource(`
+        mcp fs { command: "fs-server" }
+        on notification(fs, "resources/updated", 42) (update: { uri: string }) {
+          listen(timeout: 1s)
+        }
+        on notification(github, "tools/changed") {}
+        on notification(fs, "message", "file:///a.csv") {}
+        listen(until: true, timeout: "soon")
+        tool f() {
+          on notification(fs, "message") {}
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['notification', "Resource URI must be a string, got 'number'"],
+        ['listen', 'Cannot listen in a notification handler'],
+        ['notification', 'github is not an MCP server'],
+        [
+          'notification',
+          "Unknown notification 'tools/changed'; expected one of resources/updated, resources/list_changed, tools/list_changed, prompts/list_changed, message",
+        ],
+        [
+          'notification',
+          'Only resources/updated notifications take a resource URI',
+        ],
+        ['listen', "Listen timeout must be a number, got 'string'"],
+        [
+          'notification',
+          'Notification handlers must be declared at the top level of a script',
+        ],
+      ]);
+    });
+
+    it('should allow call options on MCP tool calls', () => {
+      const statements = parseSource(`
+        mcp fs { command: "fs-server", timeout: 30s, retries: 2 }
+        text = fs.readFile("a.txt") with { timeout: 5s, backoff: "linear" }
+      `);
+      expect(typecheck(statements)).toEqual([]);
+    });
+
+    it('should report invalid call options', () => {
+      const statements = parseSource(`
+        mcp fs { command: "fs-server", backoff: "random" }
+        fs.readFile("a.txt") with { timeout: "5s", retries: 1.5, delay: 1 }
+        print("a") with { timeout: 5s }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'call-options',
+          "Unknown backoff 'random'; expected exponential, linear, constant",
+        ],
+        [
+          'call-options',
+          "Call option 'timeout' must be a number, got 'string'",
+        ],
+        ['call-options', "Call option 'retries' must be a whole number"],
+        [
+          'call-options',
+          "Unknown call option 'delay'; expected timeout, retries or backoff",
+        ],
+        ['call-options', 'Call options are only supported on MCP tool calls'],
+      ]);
+    });
+
+    it('should report invalid circuit breakers', () => {
+      const statements = parseSource(`
+        mcp a { command: "a", circuitBreaker: { failures: 3, cooldown: 30s } }
+        mcp b { command: "b", circuitBreaker: 5 }
+        mcp c { command: "c", circuitBreaker: { failures: 0, window: 1m } }
+        a.read("x") with { circuitBreaker: { failures: 1 } }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'call-options',
+          "Server option 'circuitBreaker' must be an object, got 'number'",
+        ],
+        [
+          'call-options',
+          "circuitBreaker option 'failures' must be a positive whole number",
+        ],
+        [
+          'call-options',
+          "Unknown circuitBreaker option 'window'; expected failures or cooldown",
+        ],
+        [
+          'call-options',
+          "Unknown call option 'circuitBreaker'; expected timeout, retries or backoff",
+        ],
+      ]);
+    });
+
+    it('should report malformed references in server options', () => {
+      const statements = parseSource(`
+        mcp a { command: "a", env: { TOKEN: "\${secret:TOKEN}" } }
+        mcp b { command: "b", args: ["\${HOME"] }
+        mcp c { url: "https://\${vault:HOST}/mcp" }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'interpolation',
+          'Unterminated reference in "${HOME"; write $${ for a literal ${',
+        ],
+        [
+          'interpolation',
+          'Invalid reference ${vault:HOST}; expected ${NAME} or ${secret:NAME}',
+        ],
+      ]);
+    });
+
+    it('should report prompts that reference secrets', () => {
+      const statements = parseSource(`
+        prompt Reviewer { text: "Reviews for \${project.name} by \${USER}" }
+        prompt Leaky { text: "Use the token \${secret:TOKEN}" }
+        x = Reviewer + "!"
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'interpolation',
+          'Prompt Leaky cannot reference ${secret:TOKEN}, since prompts are sent to the model',
+        ],
+      ]);
+    });
+
+    it('should report triggers with invalid sources or names', () => {
+      const statements = parseSource(`
+        trigger nightly: schedule("0 2 * * *") -> print("ok")
+        trigger hourly: schedule("0 * * *") -> print("ok")
+        trigger manual: run() -> print("ok")
+        trigger nightly: webhook() -> print("again")
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        [
+          'trigger',
+          'Trigger hourly: Invalid schedule "0 * * *": expected 5 fields (minute hour day month weekday)',
+        ],
+        [
+          'trigger',
+          'Trigger manual: expected schedule("<cron expression>") or webhook() before ->',
+        ],
+        ['trigger', 'Trigger nightly is declared more than once'],
+      ]);
+    });
+
+    it('should check pipelines step by step', () => {
+      const tools = `
+        tool fetchIssues(repo: string): string[] { return [] }
+        tool triage(issue: string): number { return 1 }
+        tool summarize(scores: number[]): string { return "" }
+      `;
+      const valid = parseSource(`${tools}
+        pipeline report(repo: string): string {
+          issues = fetchIssues(repo)
+          scores = each(issues, limit: 5) { issue => triage(issue) }
+          sent = parallel { text: summarize(scores), count: triage(repo) }
+          return sent.text
+        }
+        summary = report("mcpscript")
+        triage(summary)
+      `);
+      expect(typecheck(valid)).toEqual([]);
+
+      const statements = parseSource(`${tools}
+        pipeline report(repo: string, max: string): number {
+          issues = fetchIssues(repo)
+          issues = fetchIssues(repo)
+          repo = fetchIssues("mcpscript")
+          summary = summarize(issues)
+          counts = each(summary, limit: max) { s => missing(s) }
+          both = parallel { a: triage("x"), a: triage("y") }
+          return summary
+        }
+        pipeline early(repo: string): string {
+          summary = summarize(total)
+          total = fetchIssues(repo)
+          again = triage(again)
+        }
+        pipeline maybe(scores: number[]): string {
+          summary = if (scores.length > 0) summarize(scores)
+          return summary
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['pipeline', "Pipeline 'report' has more than one step 'issues'"],
+        [
+          'pipeline',
+          "Step 'repo' has the name of a parameter of pipeline 'report'",
+        ],
+        [
+          'argument-type',
+          "Argument of type 'string[]' is not assignable to parameter 'scores' of type 'number[]'",
+        ],
+        ['pipeline', "each needs a list of items, got 'string'"],
+        ['pipeline', "each limit must be a number, got 'string'"],
+        ['pipeline', "'missing' is not a tool or pipeline"],
+        ['pipeline', "Fan-out has more than one branch 'a'"],
+        [
+          'return-type',
+          "Type 'string' is not assignable to return type 'number' of pipeline 'report'",
+        ],
+        [
+          'pipeline',
+          "Step 'summary' uses 'total', which is not an earlier step",
+        ],
+        ['pipeline', "Step 'again' uses 'again', which is not an earlier step"],
+        [
+          'missing-return',
+          "Pipeline 'early' declares return type 'string' but never returns a value",
+        ],
+        [
+          'return-type',
+          "Type 'string | null' is not assignable to return type 'string' of pipeline 'maybe'",
+        ],
+      ]);
+    });
+
+    it('should report invalid guardrails', () => {
+      const statements = parseSource(`
+        model claude { provider: "anthropic", model: "claude-3-opus-20240229" }
+        tool noSecrets(text) { return true }
+        agent ok {
+          model: claude,
+          guardrails: [{ deny: ["password"], action: "retry", retries: 1 }, { check: noSecrets }, { check: "pii" }]
+        }
+        agent broken {
+          model: claude,
+          guardrails: [{ deny: ["(unclosed"], action: "ignore" }, { check: helper, retries: 1.5 }, { limit: 3 }]
+        }
+      `);
+      expect(typecheck(statements).map(d => [d.code, d.message])).toEqual([
+        ['guardrail', 'Invalid deny pattern "(unclosed"'],
+        [
+          'guardrail',
+          "Unknown guardrail action 'ignore'; expected block, retry, escalate",
+        ],
+        [
+          'guardrail',
+          "Guardrail check 'helper' must be a tool, or the name of a validator as a string",
+        ],
+        [
+          'guardrail',
+          "Guardrail option 'retries' must be a non-negative whole number",
+        ],
+        ['guardrail', 'A guardrail needs deny, schema or check'],
+        [
+          'guardrail',
+          "Unknown guardrail option 'limit'; expected name, deny, schema, check, action or retries",
+        ],
+      ]);
+    });
+
+    it('should throw TypeCheckError from checkTypes', () => {
+      const statements = parseSource(`tool f(n: number) {
+  return n
+}
+f("one")`);
+      expect(() => checkTypes(statements)).toThrow(TypeCheckError);
+      expect(() => checkTypes(statements)).toThrow(/^4:3: Argument of type/);
+    });
+  });
+});
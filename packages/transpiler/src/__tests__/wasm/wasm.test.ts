@@ -0,0 +1,50 @@
+import { describe, it, expect, afterAll } from 'vitest';
+import { mkdtempSync, rmSync, writeFileSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { getQuery, initWasmSyntax, parseFile, parseTree } from '../../wasm.js';
+import { nativeSyntaxBackend } from '../../native.js';
+import { setSyntaxBackend } from '../../syntax.js';
+import { fakeWebTreeSitter } from '../test-helpers.js';
+
+describe('wasm entry', () => {
+  const dir = mkdtempSync(join(tmpdir(), 'mcps-wasm-'));
+
+  afterAll(() => {
+    setSyntaxBackend(nativeSyntaxBackend);
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should read the grammar from a path and the shipped queries', async () => {
+    const wasmPath = join(dir, 'grammar.wasm');
+    writeFileSync(wasmPath, new Uint8Array([0, 97, 115, 109]));
+    const loaded: unknown[] = [];
+
+    await initWasmSyntax({ Parser: fakeWebTreeSitter(loaded), wasm: wasmPath });
+
+    expect(loaded).toHaveLength(1);
+    expect([...(loaded[0] as Uint8Array)]).toEqual([0, 97, 115, 109]);
+    const { rootNode } = parseTree('mcp fs { command: "npx" }');
+    expect(getQuery('highlights').captures(rootNode)).not.toEqual([]);
+  });
+
+  it('should pass grammar contents through', async () => {
+    const wasm = new Uint8Array([1, 2, 3]);
+    const loaded: unknown[] = [];
+
+    await initWasmSyntax({ Parser: fakeWebTreeSitter(loaded), wasm });
+
+    expect(loaded).toEqual([wasm]);
+  });
+
+  it('should parse files once loaded', async () => {
+    await initWasmSyntax({
+      Parser: fakeWebTreeSitter([]),
+      wasm: new Uint8Array(),
+    });
+    const scriptPath = join(dir, 'main.mcps');
+    writeFileSync(scriptPath, 'x = 1\nprint(x)\n');
+
+    expect(parseFile(scriptPath)).toHaveLength(2);
+  });
+});
@@ -0,0 +1,203 @@
+// Arenas and pools for AST nodes
+//
+// Services that parse thousands of documents a minute spend much of their
+// time collecting short-lived ASTs. Building an AST in an arena and
+// releasing it once it is no longer needed returns its nodes and their
+// source locations to a pool, and later parses reuse those objects instead
+// of allocating new ones. ASTs built without an arena are ordinary objects
+// left to the garbage collector.
+import type Parser from 'tree-sitter';
+import {
+  forgetLocation,
+  locationOf,
+  type SourceLocation,
+} from './locations.js';
+
+type PooledNode = { type: string } & Record<string, unknown>;
+
+export interface AstPoolOptions {
+  /** Most nodes kept for reuse; released nodes past it are dropped */
+  maxSize?: number;
+}
+
+/**
+ * Released AST nodes by node type, and released source locations
+ * A pool can be shared by any number of arenas, one at a time or not
+ */
+export class AstPool {
+  readonly maxSize: number;
+  private readonly nodes = new Map<string, PooledNode[]>();
+  private readonly locations: SourceLocation[] = [];
+  private nodeCount = 0;
+
+  constructor(options: AstPoolOptions = {}) {
+    this.maxSize = options.maxSize ?? 100_000;
+  }
+
+  /**
+   * Number of nodes available for reuse
+   */
+  get size(): number {
+    return this.nodeCount;
+  }
+
+  take(type: string): PooledNode | undefined {
+    const node = this.nodes.get(type)?.pop();
+    if (node) {
+      this.nodeCount--;
+    }
+    return node;
+  }
+
+  takeLocation(): SourceLocation | undefined {
+    return this.locations.pop();
+  }
+
+  give(node: PooledNode): void {
+    if (this.nodeCount >= this.maxSize) {
+      return;
+    }
+    let nodes = this.nodes.get(node.type);
+    if (!nodes) {
+      nodes = [];
+      this.nodes.set(node.type, nodes);
+    }
+    nodes.push(node);
+    this.nodeCount++;
+  }
+
+  giveLocation(location: SourceLocation): void {
+    if (this.locations.length < this.maxSize) {
+      this.locations.push(location);
+    }
+  }
+
+  /**
+   * Drop every pooled object, such as after a burst of parsing
+   */
+  clear(): void {
+    this.nodes.clear();
+    this.locations.length = 0;
+    this.nodeCount = 0;
+  }
+}
+
+/**
+ * The pool arenas use unless given another one
+ */
+export const sharedAstPool = new AstPool();
+
+/**
+ * The nodes of one AST, taken from a pool and given back by release()
+ * Nothing may use the AST, or locations looked up from it, once the arena
+ * is released: its objects will be part of another AST
+ */
+export class AstArena {
+  private nodes: PooledNode[] = [];
+  private released = false;
+
+  constructor(private readonly pool: AstPool = sharedAstPool) {}
+
+  /**
+   * Number of nodes allocated in the arena
+   */
+  get size(): number {
+    return this.nodes.length;
+  }
+
+  /**
+   * A node with the given fields, reusing a pooled node of the same type
+   */
+  node<T extends { type: string }>(fields: T): T {
+    if (this.released) {
+      throw new Error('AST arena used after release');
+    }
+    const node = this.pool.take(fields.type);
+    if (!node) {
+      this.nodes.push(fields as unknown as PooledNode);
+      return fields;
+    }
+    Object.assign(node, fields);
+    // Optional fields the node had in its previous AST
+    for (const key of Object.keys(node)) {
+      if (node[key] === undefined && !(key in fields)) {
+        delete node[key];
+      }
+    }
+    this.nodes.push(node);
+    return node as unknown as T;
+  }
+
+  /**
+   * The source location of a syntax node, in a pooled location object
+   */
+  location(syntaxNode: Parser.SyntaxNode): SourceLocation {
+    const location = this.pool.takeLocation();
+    if (!location) {
+      return locationOf(syntaxNode);
+    }
+    location.start.line = syntaxNode.startPosition.row + 1;
+    location.start.column = syntaxNode.startPosition.column + 1;
+    location.start.offset = syntaxNode.startIndex;
+    location.end.line = syntaxNode.endPosition.row + 1;
+    location.end.column = syntaxNode.endPosition.column + 1;
+    location.end.offset = syntaxNode.endIndex;
+    return location;
+  }
+
+  /**
+   * Give every node and location of the arena back to the pool
+   * Releasing twice does nothing
+   */
+  release(): void {
+    if (this.released) {
+      return;
+    }
+    this.released = true;
+    for (const node of this.nodes) {
+      const location = forgetLocation(node);
+      if (location) {
+        this.pool.giveLocation(location);
+      }
+      // Clear references so pooled nodes do not keep arrays and strings
+      // alive, keeping each node's shape for when it is reused
+      for (const key of Object.keys(node)) {
+        if (key !== 'type') {
+          node[key] = undefined;
+        }
+      }
+      this.pool.give(node);
+    }
+    this.nodes = [];
+  }
+}
+
+let activeArena: AstArena | undefined;
+
+/**
+ * The arena nodes are currently built in, if any
+ */
+export function currentArena(): AstArena | undefined {
+  return activeArena;
+}
+
+/**
+ * Build nodes in an arena: createNode() and setLocation() calls made by
+ * build() allocate from it
+ */
+export function withArena<T>(arena: AstArena | undefined, build: () => T): T {
+  const previous = activeArena;
+  activeArena = arena;
+  try {
+    return build();
+  } finally {
+    activeArena = previous;
+  }
+}
+
+/**
+ * Create an AST node, in the current arena if there is one
+ */
+export function createNode<T extends { type: string }>(fields: T): T {
+  return activeArena ? activeArena.node(fields) : fields;
+}
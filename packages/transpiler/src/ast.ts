@@ -45,6 +45,8 @@ export interface CallExpression extends ASTNode {
   type: 'call';
   callee: Expression;
   arguments: Expression[];
+  /** Timeout, retries and backoff of an MCP tool call, from `with { ... }` */
+  options?: ObjectLiteral;
 }
 
 export interface MemberExpression extends ASTNode {
@@ -101,6 +103,12 @@ export type Expression =
   | UnaryExpression;
 
 // Statement types
+export interface ImportStatement extends ASTNode {
+  type: 'import_statement';
+  /** Module specifier as written, e.g. "./servers.mcps" */
+  path: string;
+}
+
 export interface MCPDeclaration extends ASTNode {
   type: 'mcp_declaration';
   name: string;
@@ -119,6 +127,48 @@ export interface AgentDeclaration extends ASTNode {
   config: ObjectLiteral;
 }
 
+export interface PromptDeclaration extends ASTNode {
+  type: 'prompt_declaration';
+  name: string;
+  config: ObjectLiteral;
+  /**
+   * Text of the file the prompt's file property names, read when the
+   * module declaring it is loaded
+   */
+  fileText?: string;
+}
+
+export interface TriggerDeclaration extends ASTNode {
+  type: 'trigger_declaration';
+  name: string;
+  /** What fires the trigger: schedule("<cron expression>") or webhook() */
+  source: CallExpression;
+  /** Evaluated each time the trigger fires */
+  action: Expression;
+}
+
+export interface EventHandler extends ASTNode {
+  type: 'event_handler';
+  /** Name of the events handled, from event("<name>") */
+  event: string;
+  /** Receives the payload of each event, checked against its type */
+  parameter?: ToolParameter;
+  body: BlockStatement;
+}
+
+export interface NotificationHandler extends ASTNode {
+  type: 'notification_handler';
+  /** Name of the MCP server whose notifications are handled */
+  server: string;
+  /** Kind of notification, such as resources/updated */
+  notification: string;
+  /** URI of the resource subscribed to, for resources/updated */
+  resource?: Expression;
+  /** Receives the parameters of each notification */
+  parameter?: ToolParameter;
+  body: BlockStatement;
+}
+
 export interface Assignment extends ASTNode {
   type: 'assignment';
   target: AssignmentTarget;
@@ -161,6 +211,74 @@ export interface ForStatement extends ASTNode {
   body: Statement;
 }
 
+/**
+ * Branches run concurrently; assignment branches assign their results in
+ * branch order once every branch has finished
+ */
+export interface ParallelStatement extends ASTNode {
+  type: 'parallel_statement';
+  /** Most branches running at once (default: all of them) */
+  limit?: Expression;
+  branches: Statement[];
+}
+
+/**
+ * The body runs for each item of a list, concurrently; the results are
+ * assigned in item order once every item has finished
+ */
+export interface ParallelMapStatement extends ASTNode {
+  type: 'parallel_map_statement';
+  /** Variable or member the results are assigned to, if any */
+  target?: AssignmentTarget;
+  items: Expression;
+  /** Most items processed at once (default: all of them) */
+  limit?: Expression;
+  /** Name of the item in the body */
+  item: string;
+  /** The result of an item is what the body returns, or its last expression */
+  body: Statement[];
+}
+
+/**
+ * Waits for notifications until the condition holds or the timeout passes
+ */
+export interface ListenStatement extends ASTNode {
+  type: 'listen_statement';
+  /** Checked when listening starts and after each notification handled */
+  until?: Expression;
+  /** Milliseconds to listen at most (default: until the condition holds) */
+  timeout?: Expression;
+}
+
+/**
+ * An annotation such as `@idempotent(key: id)`, with its named arguments
+ */
+export interface Annotation extends ASTNode {
+  type: 'annotation';
+  name: string;
+  arguments: Property[];
+}
+
+/**
+ * An assignment or expression statement whose call runs as its annotation
+ * says
+ */
+export interface AnnotatedStatement extends ASTNode {
+  type: 'annotated_statement';
+  annotation: Annotation;
+  statement: Assignment | ExpressionStatement;
+}
+
+/**
+ * A statement with a block undoing it, which runs when a later step of the
+ * enclosing tool or script fails
+ */
+export interface CompensatedStatement extends ASTNode {
+  type: 'compensated_statement';
+  statement: Assignment | ExpressionStatement | AnnotatedStatement;
+  compensation: BlockStatement;
+}
+
 export interface BreakStatement extends ASTNode {
   type: 'break_statement';
 }
@@ -182,17 +300,113 @@ export interface ToolDeclaration extends ASTNode {
   body: BlockStatement;
 }
 
+/**
+ * A workflow composed of named steps calling other workflows; each step
+ * runs once the steps it uses have finished
+ */
+export interface PipelineDeclaration extends ASTNode {
+  type: 'pipeline_declaration';
+  name: string;
+  parameters: ToolParameter[];
+  returnType?: TypeExpression;
+  steps: PipelineStep[];
+  /** The pipeline's result, from its `return` (default: null) */
+  output?: Expression;
+}
+
+export interface PipelineStep extends ASTNode {
+  type: 'pipeline_step';
+  name: string;
+  stage: PipelineStage;
+}
+
+/**
+ * What a step runs: a call of a workflow, a choice between stages, or
+ * stages fanned out over names or the items of a list
+ */
+export type PipelineStage =
+  | CallExpression
+  | ConditionalStage
+  | FanOutStage
+  | EachStage;
+
+export interface ConditionalStage extends ASTNode {
+  type: 'conditional_stage';
+  condition: Expression;
+  then: PipelineStage;
+  /** Stage run when the condition is false (default: the result is null) */
+  else?: PipelineStage;
+}
+
+/**
+ * Branches run concurrently; the result is an object of their results
+ */
+export interface FanOutStage extends ASTNode {
+  type: 'fan_out_stage';
+  branches: FanOutBranch[];
+}
+
+export interface FanOutBranch extends ASTNode {
+  type: 'fan_out_branch';
+  name: string;
+  stage: PipelineStage;
+}
+
+/**
+ * The stage runs for each item of a list, concurrently; the result is the
+ * list of their results in item order
+ */
+export interface EachStage extends ASTNode {
+  type: 'each_stage';
+  items: Expression;
+  /** Most items processed at once (default: all of them) */
+  limit?: Expression;
+  /** Name of the item in the stage */
+  item: string;
+  stage: PipelineStage;
+}
+
+/**
+ * A test case of a test file, run by mcps test with the file's hooks and
+ * fixtures around it
+ */
+export interface TestDeclaration extends ASTNode {
+  type: 'test_declaration';
+  name: string;
+  body: BlockStatement;
+}
+
+/**
+ * A block run before or after each test case of a file, or all of them
+ */
+export interface TestHook extends ASTNode {
+  type: 'test_hook';
+  hook: 'beforeAll' | 'beforeEach' | 'afterEach' | 'afterAll';
+  body: BlockStatement;
+}
+
+/**
+ * A variable set afresh for each test case, before its beforeEach hooks
+ */
+export interface FixtureDeclaration extends ASTNode {
+  type: 'fixture_declaration';
+  name: string;
+  value: Expression;
+}
+
 export interface ToolParameter {
   name: string;
   optional: boolean;
   typeAnnotation?: TypeExpression;
+  /** Value of the parameter when a call leaves it out */
+  defaultValue?: Expression;
 }
 
 export type TypeExpression = PrimitiveType | ArrayType | ObjectType | UnionType;
 
 export interface PrimitiveType {
   type: 'primitive_type';
-  value: 'string' | 'number' | 'boolean' | 'any' | 'null';
+  value: 'string' | 'number' | 'boolean' | 'bytes' | 'any' | 'null';
 }
 
 export interface ArrayType {
@@ -222,16 +436,30 @@ export interface Comment extends ASTNode {
 }
 
 export type Statement =
+  | ImportStatement
   | MCPDeclaration
   | ModelDeclaration
   | AgentDeclaration
+  | PromptDeclaration
+  | TriggerDeclaration
+  | EventHandler
+  | NotificationHandler
   | ToolDeclaration
+  | PipelineDeclaration
+  | TestDeclaration
+  | TestHook
+  | FixtureDeclaration
   | Assignment
   | ExpressionStatement
   | BlockStatement
   | IfStatement
   | WhileStatement
   | ForStatement
+  | ParallelStatement
+  | ParallelMapStatement
+  | AnnotatedStatement
+  | CompensatedStatement
+  | ListenStatement
   | BreakStatement
   | ContinueStatement
   | ReturnStatement
@@ -0,0 +1,111 @@
+// Running a query over many files at once
+//
+// Indexing a workspace one file after another leaves the parser idle while
+// each file is read. queryFiles keeps a bounded number of files loading at
+// a time, parses each one as soon as it arrives, and merges the captures
+// back into the order the files were given, so results do not depend on
+// which file happened to load first.
+import {
+  parseTree,
+  type QueryCapture,
+  type SyntaxQuery,
+  type SyntaxTree,
+} from './syntax.js';
+
+/**
+ * Load a file: its source text, or a tree already parsed from it (such as
+ * an open editor document)
+ */
+export type FileSource = (file: string) => Promise<string | SyntaxTree>;
+
+export interface BatchQueryOptions {
+  /** Files loaded and parsed at once (default: 16) */
+  concurrency?: number;
+  /** Cancels the batch: files not yet started are skipped */
+  signal?: AbortSignal;
+}
+
+/**
+ * A capture, with the file it was found in
+ */
+export interface FileCapture extends QueryCapture {
+  file: string;
+  /** The match the capture is part of, numbered across the whole batch */
+  match: number;
+}
+
+export interface BatchQueryResult {
+  /** Captures in the order the files were given, by position within one */
+  captures: FileCapture[];
+  /** Files that could not be loaded or parsed, and why */
+  failed: { file: string; error: Error }[];
+}
+
+export const DEFAULT_BATCH_CONCURRENCY = 16;
+
+/**
+ * Run a query over many files, at most `concurrency` of them at once
+ * A file that fails to load or parse is reported in `failed` and does not
+ * stop the others. Captures hold on to their trees, so keep only what is
+ * needed from them when querying a large number of files.
+ */
+export async function queryFiles(
+  query: SyntaxQuery,
+  files: string[],
+  load: FileSource,
+  options: BatchQueryOptions = {}
+): Promise<BatchQueryResult> {
+  const { concurrency = DEFAULT_BATCH_CONCURRENCY, signal } = options;
+  if (!Number.isInteger(concurrency) || concurrency < 1) {
+    throw new TypeError(
+      `Batch concurrency must be a positive integer, got ${concurrency}`
+    );
+  }
+
+  const results = new Array<QueryCapture[][] | Error>(files.length);
+  let next = 0;
+  const worker = async () => {
+    while (next < files.length && !signal?.aborted) {
+      const index = next++;
+      try {
+        const source = await load(files[index]);
+        const tree = typeof source === 'string' ? parseTree(source) : source;
+        results[index] = query
+          .matches(tree.rootNode)
+          .map(match => match.captures);
+      } catch (error) {
+        results[index] =
+          error instanceof Error ? error : new Error(String(error));
+      }
+    }
+  };
+  const workers = Math.min(concurrency, files.length);
+  await Promise.all(Array.from({ length: workers }, worker));
+  signal?.throwIfAborted();
+
+  const result: BatchQueryResult = { captures: [], failed: [] };
+  let match = 0;
+  files.forEach((file, index) => {
+    const matches = results[index];
+    if (matches instanceof Error) {
+      result.failed.push({ file, error: matches });
+      return;
+    }
+    const captures: FileCapture[] = [];
+    for (const matchCaptures of matches) {
+      for (const capture of matchCaptures) {
+        captures.push({ ...capture, file, match });
+      }
+      match++;
+    }
+    captures.sort(
+      (a, b) =>
+        a.node.startIndex - b.node.startIndex ||
+        b.node.endIndex - a.node.endIndex
+    );
+    for (const capture of captures) {
+      result.captures.push(capture);
+    }
+  });
+  return result;
+}
@@ -0,0 +1,287 @@
+// Parser benchmarks over a generated corpus of large scripts
+//
+// The same benchmarks run under `vitest bench` and `mcps bench --internal`,
+// whose results can be saved as a baseline and compared against later runs
+// to catch performance regressions in the grammar or the bindings.
+import { performance } from 'perf_hooks';
+import { parseSource } from './parser.js';
+import { getQuery, parseTree, reparseTree, type SyntaxEdit } from './syntax.js';
+import { typecheck } from './typecheck.js';
+import { generateCode } from './codegen.js';
+import { lint } from './lint.js';
+import { AstArena } from './arena.js';
+
+export interface CorpusOptions {
+  /** Lines to generate at least (default: 5000) */
+  lines?: number;
+  /** Seed for the generator; equal seeds give equal scripts (default: 1) */
+  seed?: number;
+}
+
+/**
+ * A pseudo-random number generator (mulberry32), so that a seed always
+ * gives the same corpus
+ */
+function random(seed: number): () => number {
+  let state = seed >>> 0;
+  return () => {
+    state = (state + 0x6d2b79f5) >>> 0;
+    let t = state;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+  };
+}
+
+/**
+ * A valid script of at least the given number of lines, mixing
+ * declarations, control flow, parallel blocks and comments the way real
+ * scripts do
+ */
+export function generateCorpus(options: CorpusOptions = {}): string {
+  const { lines: target = 5000, seed = 1 } = options;
+  const next = random(seed);
+  const pick = (n: number) => Math.floor(next() * n);
+
+  const lines = [
+    '// Generated benchmark corpus',
+    'model gpt {',
+    '  provider: "openai",',
+    '  model: "gpt-4o",',
+    '  temperature: 0.2',
+    '}',
+    '',
+    'agent Reviewer {',
+    '  model: gpt,',
+    '  systemPrompt: "You review generated reports."',
+    '}',
+    '',
+  ];
+  let tools = 0;
+  let section = 0;
+
+  while (lines.length < target) {
+    const i = section++;
+    // Every section after the first may call a tool declared before it
+    const kind = tools === 0 ? 0 : pick(4);
+    const tool = `score${pick(Math.max(tools, 1))}`;
+
+    if (kind === 0) {
+      const n = tools++;
+      lines.push(
+        `// score${n} weighs an item over ${(n % 7) + 2} rounds`,
+        `tool score${n}(name: string, weight: number): number {`,
+        '  total = 0',
+        '  for (round = 0; round < weight; round = round + 1) {',
+        `    if (round % 2 == 0 && weight > ${pick(10)}) {`,
+        `      total = total + round * ${pick(100)}`,
+        '    } else {',
+        '      total = total - 1',
+        '    }',
+        '  }',
+        '  return total',
+        '}',
+        ''
+      );
+    } else if (kind === 1) {
+      lines.push(
+        `items${i} = ["alpha${i}", 'beta${i}', "gamma${i}"]`,
+        `config${i} = {`,
+        `  name: "job${i}",`,
+        `  retries: ${pick(5) + 1},`,
+        `  enabled: ${pick(2) === 0 ? 'true' : 'false'},`,
+        '}',
+        `for (index${i} = 0; index${i} < 3; index${i} = index${i} + 1) {`,
+        `  print(items${i}[index${i}] + ": " + ${tool}(items${i}[index${i}], config${i}.retries))`,
+        '}',
+        ''
+      );
+    } else if (kind === 2) {
+      lines.push(
+        `count${i} = 0`,
+        `while (count${i} < ${pick(20) + 1}) {`,
+        `  count${i} = count${i} + 1`,
+        `  if (count${i} > ${pick(10)} || !(count${i} != 3)) {`,
+        '    continue',
+        '  }',
+        `  label${i} = null ?? "round " + count${i}`,
+        `  log(label${i})`,
+        '}',
+        ''
+      );
+    } else {
+      lines.push(
+        `parallel (${pick(3) + 1}) {`,
+        `  first${i} = ${tool}("first", ${pick(9) + 1})`,
+        `  second${i} = ${tool}("second", ${pick(9) + 1})`,
+        '  {',
+        `    third${i} = ${tool}("third", -${pick(9) + 1})`,
+        `    print(third${i})`,
+        '  }',
+        '}',
+        `print(first${i} + second${i})`,
+        ''
+      );
+    }
+  }
+
+  return lines.join('\n');
+}
+
+/**
+ * A named piece of work to time
+ */
+export interface Benchmark {
+  name: string;
+  run(): void;
+}
+
+/**
+ * The internal benchmarks, over one corpus script
+ */
+export function internalBenchmarks(corpus: string): Benchmark[] {
+  const tree = parseTree(corpus);
+  const statements = parseSource(corpus);
+  // Typing one character halfway through, as an editor does
+  const offset = corpus.indexOf('\n', corpus.length >> 1) + 1;
+  const edited = `${corpus.slice(0, offset)}x${corpus.slice(offset)}`;
+  const before = corpus.slice(0, offset).split('\n');
+  const position = { row: before.length - 1, column: 0 };
+  const edit: SyntaxEdit = {
+    startIndex: offset,
+    oldEndIndex: offset,
+    newEndIndex: offset + 1,
+    startPosition: position,
+    oldEndPosition: position,
+    newEndPosition: { row: position.row, column: 1 },
+  };
+
+  return [
+    {
+      name: 'BenchmarkParseLarge',
+      run: () => void parseSource(corpus),
+    },
+    {
+      name: 'BenchmarkParseLargeArena',
+      run: () => {
+        const arena = new AstArena();
+        parseSource(corpus, arena);
+        arena.release();
+      },
+    },
+    {
+      name: 'BenchmarkParseTreeLarge',
+      run: () => void parseTree(corpus),
+    },
+    {
+      name: 'BenchmarkReparseLarge',
+      run: () => void reparseTree(parseTree(corpus), edited, [edit]),
+    },
+    {
+      name: 'BenchmarkQueryHighlights',
+      run: () => void getQuery('highlights').matches(tree.rootNode),
+    },
+    {
+      name: 'BenchmarkQueryLocals',
+      run: () => void getQuery('locals').captures(tree.rootNode),
+    },
+    {
+      name: 'BenchmarkQueryFolds',
+      run: () => void getQuery('folds').captures(tree.rootNode),
+    },
+    {
+      name: 'BenchmarkTypecheckLarge',
+      run: () => void typecheck(statements),
+    },
+    {
+      name: 'BenchmarkLintLarge',
+      run: () => void lint(tree, corpus),
+    },
+    {
+      name: 'BenchmarkCompileLarge',
+      run: () => void generateCode(statements),
+    },
+  ];
+}
+
+export interface BenchmarkOptions {
+  /** Keep running each benchmark for at least this long (default: 1000) */
+  timeMs?: number;
+  /** Run each benchmark at least this many times (default: 5) */
+  iterations?: number;
+}
+
+export interface BenchmarkResult {
+  name: string;
+  iterations: number;
+  meanMs: number;
+  minMs: number;
+  p95Ms: number;
+}
+
+/**
+ * Time a benchmark, after one untimed run to warm up
+ */
+export function runBenchmark(
+  benchmark: Benchmark,
+  options: BenchmarkOptions = {}
+): BenchmarkResult {
+  const { timeMs = 1000, iterations = 5 } = options;
+  benchmark.run();
+
+  const samples: number[] = [];
+  const started = performance.now();
+  while (samples.length < iterations || performance.now() - started < timeMs) {
+    const start = performance.now();
+    benchmark.run();
+    samples.push(performance.now() - start);
+  }
+
+  samples.sort((a, b) => a - b);
+  return {
+    name: benchmark.name,
+    iterations: samples.length,
+    meanMs: samples.reduce((sum, ms) => sum + ms, 0) / samples.length,
+    minMs: samples[0],
+    p95Ms:
+      samples[Math.min(samples.length - 1, Math.floor(samples.length * 0.95))],
+  };
+}
+
+export interface BenchmarkRegression {
+  name: string;
+  baselineMs: number;
+  meanMs: number;
+  /** meanMs relative to baselineMs, 1.5 for 50% slower */
+  ratio: number;
+}
+
+/**
+ * Benchmarks whose mean time grew by more than the threshold, 0.2 for
+ * 20%, compared to a baseline run
+ * Benchmarks missing from either run are not compared
+ */
+export function compareBenchmarks(
+  results: BenchmarkResult[],
+  baseline: BenchmarkResult[],
+  threshold = 0.2
+): BenchmarkRegression[] {
+  const baselineMs = new Map(baseline.map(r => [r.name, r.meanMs]));
+  const regressions: BenchmarkRegression[] = [];
+  for (const result of results) {
+    const before = baselineMs.get(result.name);
+    if (before === undefined || before <= 0) {
+      continue;
+    }
+    const ratio = result.meanMs / before;
+    if (ratio > 1 + threshold) {
+      regressions.push({
+        name: result.name,
+        baselineMs: before,
+        meanMs: result.meanMs,
+        ratio,
+      });
+    }
+  }
+  return regressions;
+}
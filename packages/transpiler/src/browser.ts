@@ -0,0 +1,80 @@
+// @mcpscript/transpiler/browser - Parser, formatter and checker for browsers
+//
+// The grammar runs as WebAssembly through web-tree-sitter, so playgrounds
+// and web editors can analyze scripts without a server. Nothing here reads
+// files or loads native code: imports between files are not resolved, and
+// scripts are not compiled to JavaScript.
+import type Parser from 'tree-sitter';
+import { setSyntaxBackend, type QueryName } from './syntax.js';
+
+interface WebLanguage {
+  query(source: string): Parser.Query;
+  lookaheadIterator(state: number): Iterable<string> | null;
+}
+
+/**
+ * The parts of web-tree-sitter (0.24 or later) that are used
+ */
+export interface WebTreeSitter {
+  init(options?: object): Promise<void>;
+  Language: { load(input: string | Uint8Array): Promise<WebLanguage> };
+  new (): {
+    setLanguage(language: WebLanguage): void;
+    parse(content: string, oldTree?: Parser.Tree): Parser.Tree;
+  };
+}
+
+export interface BrowserSyntaxOptions {
+  /** The web-tree-sitter module's default export */
+  Parser: WebTreeSitter;
+  /**
+   * URL or contents of tree-sitter-mcpscript.wasm, which
+   * `npm run build:wasm` writes to dist/
+   */
+  wasm: string | Uint8Array;
+  /** Contents of the grammar's queries, from grammar/queries/<name>.scm */
+  queries: Record<QueryName, string>;
+}
+
+/**
+ * Load the grammar into web-tree-sitter
+ * Must be awaited once before anything is parsed
+ */
+export async function initBrowserSyntax(
+  options: BrowserSyntaxOptions
+): Promise<void> {
+  const { Parser: WebParser, queries } = options;
+  await WebParser.init();
+  const language = await WebParser.Language.load(options.wasm);
+  setSyntaxBackend({
+    createParser() {
+      const parser = new WebParser();
+      parser.setLanguage(language);
+      return parser;
+    },
+    createQuery: name => language.query(queries[name]),
+    lookahead: state => language.lookaheadIterator(state) ?? [],
+  });
+}
+
+export * from './ast.js';
+export * from './syntax.js';
+export * from './parser.js';
+export * from './locations.js';
+export * from './arena.js';
+export * from './limits.js';
+export * from './features.js';
+export * from './validator.js';
+export * from './typecheck.js';
+export * from './formatter.js';
+export * from './imports.js';
+export * from './semantics.js';
+export * from './lint.js';
+export * from './batch.js';
+export * from './tags.js';
+export * from './tokens.js';
+export * from './highlight.js';
+export * from './diff.js';
+export * from './serialize.js';
+export * from './capabilities.js';
+export * from './interpolation.js';
@@ -0,0 +1,390 @@
+// Capability manifest: what a script can reach, for security review
+//
+// Reviewers approving an automation care about what it may touch rather
+// than how it is written: the processes and services it connects to, the
+// tools it may call through them, the files and endpoints those are given,
+// and the secrets it reads. The manifest is found statically, so it lists
+// what the script could do, not what one run did.
+import type {
+  AgentDeclaration,
+  Expression,
+  MCPDeclaration,
+  ModelDeclaration,
+  ObjectLiteral,
+  PromptDeclaration,
+  Statement,
+} from './ast.js';
+import {
+  interpolatedStrings,
+  interpolationReferences,
+  parseInterpolation,
+} from './interpolation.js';
+
+/**
+ * An MCP server the script starts or connects to
+ */
+export interface ServerCapability {
+  name: string;
+  transport: 'stdio' | 'http' | 'sse';
+  /** Program started for stdio servers */
+  command?: string;
+  args?: string[];
+  /** Endpoint of HTTP and SSE servers */
+  url?: string;
+  /** Environment variables set for the server process */
+  env?: string[];
+  /** Whether the server process inherits the whole environment */
+  inheritEnv?: boolean;
+  /** Variables of the script's environment passed on to the server */
+  allowEnv?: string[];
+}
+
+/**
+ * A tool of an MCP server the script may call
+ */
+export interface ToolCapability {
+  server: string;
+  /** Tool name, or "*" when an agent may call any tool of the server */
+  tool: string;
+  /** Where it is called from: "script", "tool <name>" or "agent <name>" */
+  callers: string[];
+}
+
+export interface ModelCapability {
+  name: string;
+  provider?: string;
+  model?: string;
+  /** Endpoint of the provider, when not its default */
+  baseURL?: string;
+}
+
+/**
+ * A secret or setting read from the environment
+ */
+export interface SecretCapability {
+  /**
+   * Environment variable name, or "secret:NAME" for a secret of the
+   * project's secret providers
+   */
+  name: string;
+  /** Declarations and code that read it, e.g. "mcp github" or "script" */
+  usedBy: string[];
+}
+
+export interface CapabilityManifest {
+  servers: ServerCapability[];
+  tools: ToolCapability[];
+  models: ModelCapability[];
+  /** Paths given to stdio servers as arguments, as written */
+  filesystemRoots: string[];
+  /** URLs of servers and model providers, and URLs in the script */
+  networkEndpoints: string[];
+  secrets: SecretCapability[];
+}
+
+/**
+ * Text a value will have at runtime, with environment variables as
+ * ${NAME} and anything computed as <dynamic>
+ */
+function describeValue(expr: Expression | undefined): string | undefined {
+  if (!expr) {
+    return undefined;
+  }
+  switch (expr.type) {
+    case 'string':
+      return expr.value;
+    case 'number':
+    case 'boolean':
+      return String(expr.value);
+    case 'member':
+      return expr.object.type === 'identifier' && expr.object.name === 'env'
+        ? `\${${expr.property}}`
+        : '<dynamic>';
+    case 'binary':
+      return expr.operator === '+'
+        ? `${describeValue(expr.left)}${describeValue(expr.right)}`
+        : '<dynamic>';
+    default:
+      return '<dynamic>';
+  }
+}
+
+function property(config: ObjectLiteral, key: string): Expression | undefined {
+  return config.properties.find(prop => prop.key === key)?.value;
+}
+
+function stringList(expr: Expression | undefined): string[] | undefined {
+  if (expr?.type !== 'array') {
+    return undefined;
+  }
+  return expr.elements.map(element => describeValue(element)!);
+}
+
+const PATH_PATTERN = /^(\/|\.{1,2}(\/|\\|$)|~(\/|$)|[A-Za-z]:[\\/])/;
+const URL_PATTERN = /^(https?|wss?):\/\//;
+
+/**
+ * How a server declaration starts or reaches its server, leaving out what
+ * it does not configure
+ */
+export function describeServer(decl: MCPDeclaration): ServerCapability {
+  const { config } = decl;
+  const url = describeValue(property(config, 'url'));
+  const env = property(config, 'env');
+  const inheritEnv = property(config, 'inheritEnv');
+  const server: ServerCapability = {
+    name: decl.name,
+    transport: url
+      ? describeValue(property(config, 'transport')) === 'sse'
+        ? 'sse'
+        : 'http'
+      : 'stdio',
+    command: describeValue(property(config, 'command')),
+    args: stringList(property(config, 'args')),
+    url,
+    env: env?.type === 'object' ? env.properties.map(p => p.key) : undefined,
+    inheritEnv: inheritEnv?.type === 'boolean' ? inheritEnv.value : undefined,
+    allowEnv: stringList(property(config, 'allowEnv')),
+  };
+  // Leave out what was not configured
+  return Object.fromEntries(
+    Object.entries(server).filter(([, value]) => value !== undefined)
+  ) as unknown as ServerCapability;
+}
+
+function describeModel(decl: ModelDeclaration): ModelCapability {
+  const model: ModelCapability = { name: decl.name };
+  for (const key of ['provider', 'model', 'baseURL'] as const) {
+    const value = describeValue(property(decl.config, key));
+    if (value !== undefined) {
+      model[key] = value;
+    }
+  }
+  return model;
+}
+
+class CapabilityCollector {
+  private readonly servers = new Set<string>();
+  private readonly tools = new Map<string, ToolCapability>();
+  private readonly secrets = new Map<string, Set<string>>();
+  private readonly endpoints = new Set<string>();
+
+  constructor(servers: MCPDeclaration[]) {
+    for (const server of servers) {
+      this.servers.add(server.name);
+    }
+  }
+
+  addTool(server: string, tool: string, caller: string) {
+    const key = `${server}.${tool}`;
+    const capability = this.tools.get(key) ?? { server, tool, callers: [] };
+    if (!capability.callers.includes(caller)) {
+      capability.callers.push(caller);
+    }
+    this.tools.set(key, capability);
+  }
+
+  addSecret(name: string, user: string) {
+    const users = this.secrets.get(name) ?? new Set();
+    users.add(user);
+    this.secrets.set(name, users);
+  }
+
+  addEndpoint(url: string | undefined) {
+    if (url && URL_PATTERN.test(url)) {
+      this.endpoints.add(url);
+    }
+  }
+
+  /**
+   * Record the tool calls, environment reads and URLs in any part of the
+   * AST, on behalf of a caller
+   */
+  visit(node: unknown, caller: string): void {
+    if (Array.isArray(node)) {
+      node.forEach(child => this.visit(child, caller));
+      return;
+    }
+    if (!node || typeof node !== 'object') {
+      return;
+    }
+    const expr = node as Expression;
+    if (expr.type === 'call' && expr.callee.type === 'member') {
+      const { object, property } = expr.callee;
+      if (object.type === 'identifier' && this.servers.has(object.name)) {
+        this.addTool(object.name, property, caller);
+      }
+    } else if (
+      expr.type === 'member' &&
+      expr.object.type === 'identifier' &&
+      expr.object.name === 'env'
+    ) {
+      this.addSecret(expr.property, caller);
+    } else if (
+      expr.type === 'bracket' &&
+      expr.object.type === 'identifier' &&
+      expr.object.name === 'env' &&
+      expr.index.type === 'string'
+    ) {
+      this.addSecret(expr.index.value, caller);
+    } else if (expr.type === 'string') {
+      this.addEndpoint(expr.value);
+    }
+    Object.values(node).forEach(child => this.visit(child, caller));
+  }
+
+  /**
+   * Record the ${NAME} and ${secret:NAME} references of a server's options
+   */
+  interpolations(config: ObjectLiteral, caller: string): void {
+    for (const literal of interpolatedStrings(config)) {
+      let references;
+      try {
+        references = interpolationReferences(parseInterpolation(literal.value));
+      } catch {
+        // Invalid references are reported by the type checker
+        continue;
+      }
+      for (const { kind, name } of references) {
+        this.addSecret(kind === 'secret' ? `secret:${name}` : name, caller);
+      }
+    }
+  }
+
+  /**
+   * Record the environment variables a prompt's text references
+   */
+  prompt(decl: PromptDeclaration): void {
+    const text = property(decl.config, 'text');
+    const source = text?.type === 'string' ? text.value : decl.fileText;
+    if (source === undefined) {
+      return;
+    }
+    let references;
+    try {
+      references = interpolationReferences(
+        parseInterpolation(source, { project: true })
+      );
+    } catch {
+      // Invalid references are reported by the type checker
+      return;
+    }
+    for (const { kind, name } of references) {
+      if (kind === 'env') {
+        this.addSecret(name, `prompt ${decl.name}`);
+      }
+    }
+  }
+
+  agent(decl: AgentDeclaration) {
+    const caller = `agent ${decl.name}`;
+    const tools = property(decl.config, 'tools');
+    if (tools?.type === 'array') {
+      for (const element of tools.elements) {
+        if (element.type === 'identifier' && this.servers.has(element.name)) {
+          this.addTool(element.name, '*', caller);
+        } else if (
+          element.type === 'member' &&
+          element.object.type === 'identifier' &&
+          this.servers.has(element.object.name)
+        ) {
+          this.addTool(element.object.name, element.property, caller);
+        }
+      }
+    }
+    this.visit(decl.config, caller);
+  }
+
+  toolList(): ToolCapability[] {
+    return [...this.tools.values()].sort(
+      (a, b) =>
+        a.server.localeCompare(b.server) || a.tool.localeCompare(b.tool)
+    );
+  }
+
+  secretList(): SecretCapability[] {
+    return [...this.secrets]
+      .map(([name, users]) => ({ name, usedBy: [...users] }))
+      .sort((a, b) => a.name.localeCompare(b.name));
+  }
+
+  endpointList(): string[] {
+    return [...this.endpoints].sort();
+  }
+}
+
+/**
+ * Collect the capability manifest of a program, including the modules it
+ * imports when given linked statements (see loadProgram)
+ * Tools are found where they are called on a server by name, as
+ * `server.tool(...)`, and in the tools of agents; an agent given a whole
+ * server may call any of its tools.
+ */
+export function collectCapabilities(
+  statements: Statement[]
+): CapabilityManifest {
+  const servers = statements.filter(
+    (s): s is MCPDeclaration => s.type === 'mcp_declaration'
+  );
+  const collector = new CapabilityCollector(servers);
+
+  const manifest: CapabilityManifest = {
+    servers: servers.map(describeServer),
+    tools: [],
+    models: [],
+    filesystemRoots: [],
+    networkEndpoints: [],
+    secrets: [],
+  };
+
+  const roots = new Set<string>();
+  for (const server of manifest.servers) {
+    for (const arg of server.args ?? []) {
+      if (PATH_PATTERN.test(arg)) {
+        roots.add(arg);
+      }
+    }
+    for (const name of server.allowEnv ?? []) {
+      collector.addSecret(name, `mcp ${server.name}`);
+    }
+  }
+
+  for (const statement of statements) {
+    switch (statement.type) {
+      case 'mcp_declaration':
+        collector.visit(statement.config, `mcp ${statement.name}`);
+        collector.interpolations(statement.config, `mcp ${statement.name}`);
+        break;
+      case 'model_declaration':
+        manifest.models.push(describeModel(statement));
+        collector.visit(statement.config, `model ${statement.name}`);
+        break;
+      case 'agent_declaration':
+        collector.agent(statement);
+        break;
+      case 'prompt_declaration':
+        collector.prompt(statement);
+        break;
+      case 'trigger_declaration':
+        collector.visit(statement.action, `trigger ${statement.name}`);
+        break;
+      case 'event_handler':
+        collector.visit(statement.body, `on ${statement.event}`);
+        break;
+      case 'tool_declaration':
+        collector.visit(statement.body, `tool ${statement.name}`);
+        break;
+      case 'pipeline_declaration':
+        collector.visit(statement.steps, `pipeline ${statement.name}`);
+        break;
+      default:
+        collector.visit(statement, 'script');
+    }
+  }
+
+  manifest.tools = collector.toolList();
+  manifest.filesystemRoots = [...roots].sort();
+  manifest.networkEndpoints = collector.endpointList();
+  manifest.secrets = collector.secretList();
+  return manifest;
+}
@@ -4,39 +4,106 @@ import {
   MCPDeclaration,
   ModelDeclaration,
   AgentDeclaration,
+  PromptDeclaration,
+  TriggerDeclaration,
+  EventHandler,
+  TestDeclaration,
+  TestHook,
+  FixtureDeclaration,
   ToolDeclaration,
+  PipelineDeclaration,
 } from './ast.js';
 import {
   generateMCPInitialization,
   generateModelInitialization,
   generateAgentInitialization,
+  generatePromptInitialization,
+  generateTriggerRegistration,
+  generateEventHandlers,
   generateToolDeclaration,
+  generatePipelineDeclaration,
+  generateTestSuite,
   generateCleanup,
 } from './codegen/declarations.js';
 import { ScopeStack, dispatchStatement } from './codegen/statements.js';
+import { generateCheckpointedStatements } from './codegen/checkpoints.js';
 import { validateStatements } from './validator.js';
 
+/**
+ * Options for code generation
+ */
+export interface CodegenOptions {
+  /**
+   * Prefix statements with source position markers so runtime errors can be
+   * mapped back to the script
+   */
+  sourcePositions?: boolean;
+  /**
+   * Call the debugger (`__debug`) before each statement and around each
+   * tool call, so the script can be paused and stepped through
+   */
+  debugHooks?: boolean;
+  /**
+   * Save the script's variables through `__checkpoint` after each
+   * top-level statement, and skip the statements a resumed run had
+   * already finished
+   */
+  checkpoints?: boolean;
+  /**
+   * Call the script's main tool, if it declares one, once its top-level
+   * code has run (default: true)
+   */
+  main?: boolean;
+}
+
 /**
  * Generate JavaScript code from an array of AST statements (without validation)
  * This is exposed for testing purposes only. Production code should use generateCode().
  */
-export function generateCodeUnsafe(statements: Statement[]): string {
-  // Track MCP servers, models, agents, and tools to initialize
+export function generateCodeUnsafe(
+  statements: Statement[],
+  options: CodegenOptions = {}
+): string {
+  const emitPositions = options.sourcePositions ?? false;
+  const debugHooks = options.debugHooks ?? false;
+  const checkpoints = options.checkpoints ?? false;
+
+  // Track MCP servers, models, prompts, agents, tools, pipelines, triggers,
+  // event handlers and the test cases, hooks and fixtures of test files
   const mcpServers = new Map<string, MCPDeclaration>();
   const models = new Map<string, ModelDeclaration>();
+  const prompts = new Map<string, PromptDeclaration>();
   const agents = new Map<string, AgentDeclaration>();
   const tools = new Map<string, ToolDeclaration>();
+  const pipelines = new Map<string, PipelineDeclaration>();
+  const triggers: TriggerDeclaration[] = [];
+  const handlers: EventHandler[] = [];
+  const tests: (TestDeclaration | TestHook | FixtureDeclaration)[] = [];
 
-  // First pass: collect all MCP, model, agent, and tool declarations
+  // First pass: collect all declarations
   for (const stmt of statements) {
     if (stmt.type === 'mcp_declaration') {
       mcpServers.set(stmt.name, stmt);
     } else if (stmt.type === 'model_declaration') {
       models.set(stmt.name, stmt);
+    } else if (stmt.type === 'prompt_declaration') {
+      prompts.set(stmt.name, stmt);
     } else if (stmt.type === 'agent_declaration') {
       agents.set(stmt.name, stmt);
     } else if (stmt.type === 'tool_declaration') {
       tools.set(stmt.name, stmt);
+    } else if (stmt.type === 'pipeline_declaration') {
+      pipelines.set(stmt.name, stmt);
+    } else if (stmt.type === 'trigger_declaration') {
+      triggers.push(stmt);
+    } else if (stmt.type === 'event_handler') {
+      handlers.push(stmt);
+    } else if (
+      stmt.type === 'test_declaration' ||
+      stmt.type === 'test_hook' ||
+      stmt.type === 'fixture_declaration'
+    ) {
+      tests.push(stmt);
     }
   }
 
@@ -47,22 +114,78 @@ export function generateCodeUnsafe(statements: Statement[]): string {
   // Generate model configurations
   const modelInit = models.size > 0 ? generateModelInitialization(models) : '';
 
-  // Generate tool declarations (with metadata attached via Proxy)
+  // Generate prompts, which agents take their system prompts from
+  const promptInit =
+    prompts.size > 0 ? generatePromptInitialization(prompts) : '';
+
+  // Generate tool and pipeline declarations (with metadata attached via
+  // Proxy)
   const toolDecls = Array.from(tools.values())
-    .map(tool => generateToolDeclaration(tool))
+    .map(tool => generateToolDeclaration(tool, emitPositions, debugHooks))
+    .concat(
+      Array.from(pipelines.values()).map(pipeline =>
+        generatePipelineDeclaration(pipeline, emitPositions)
+      )
+    )
     .join('\n\n');
 
   // Generate agent configurations
-  const agentInit = agents.size > 0 ? generateAgentInitialization(agents) : '';
+  const agentInit =
+    agents.size > 0 ? generateAgentInitialization(agents, prompts) : '';
+
+  // Register event handlers before the script runs, so the events it emits
+  // reach them
+  const handlerInit =
+    handlers.length > 0
+      ? generateEventHandlers(handlers, emitPositions, debugHooks)
+      : '';
 
   // Generate main code with variable tracking
-  const mainCode = generateStatements(statements);
+  const mainCode = generateStatements(
+    statements,
+    emitPositions,
+    debugHooks,
+    checkpoints
+  );
+
+  // A script's main tool runs once its top-level code has, with the
+  // arguments the run was given
+  const mainCall =
+    tools.has('main') && options.main !== false ? 'await __main(main);' : '';
+
+  // Test cases run once the test file's top-level code has set up its mocks
+  const testInit =
+    tests.length > 0
+      ? generateTestSuite(tests, emitPositions, debugHooks)
+      : '';
 
-  // Generate cleanup
-  const cleanup = mcpServers.size > 0 ? generateCleanup() : '';
+  // Register triggers once the script's variables are set
+  const triggerInit =
+    triggers.length > 0
+      ? generateTriggerRegistration(triggers, emitPositions)
+      : '';
+
+  // Generate cleanup; servers of a script with triggers or event handlers
+  // stay up for them
+  const cleanup =
+    mcpServers.size > 0 && triggers.length === 0 && handlers.length === 0
+      ? generateCleanup()
+      : '';
 
   // Combine all parts
-  return [mcpInit, modelInit, toolDecls, agentInit, mainCode, cleanup]
+  return [
+    mcpInit,
+    modelInit,
+    promptInit,
+    toolDecls,
+    agentInit,
+    handlerInit,
+    mainCode,
+    mainCall,
+    testInit,
+    triggerInit,
+    cleanup,
+  ]
     .filter(Boolean)
     .join('\n\n');
 }
@@ -71,31 +194,48 @@ export function generateCodeUnsafe(statements: Statement[]): string {
  * Generate JavaScript code from an array of AST statements
  * Validates variable references before code generation.
  */
-export function generateCode(statements: Statement[]): string {
+export function generateCode(
+  statements: Statement[],
+  options: CodegenOptions = {}
+): string {
   // Validate all variable references before code generation
   validateStatements(statements);
 
   // Generate code
-  return generateCodeUnsafe(statements);
+  return generateCodeUnsafe(statements, options);
 }
 
 /**
  * Generate code for all statements (excluding MCP declarations)
  */
-function generateStatements(statements: Statement[]): string {
+function generateStatements(
+  statements: Statement[],
+  emitPositions: boolean,
+  debugHooks: boolean,
+  checkpoints: boolean
+): string {
   // Initialize scope stack with global scope
-  const scopeStack = new ScopeStack();
-
-  const codeLines = statements
-    .filter(
-      stmt =>
-        stmt.type !== 'mcp_declaration' &&
-        stmt.type !== 'model_declaration' &&
-        stmt.type !== 'agent_declaration' &&
-        stmt.type !== 'tool_declaration'
-    )
-    .map(stmt => dispatchStatement(stmt, scopeStack))
-    .filter(Boolean);
+  const scopeStack = new ScopeStack(emitPositions, debugHooks);
+
+  const mainStatements = statements.filter(
+    stmt =>
+      stmt.type !== 'mcp_declaration' &&
+      stmt.type !== 'model_declaration' &&
+      stmt.type !== 'agent_declaration' &&
+      stmt.type !== 'prompt_declaration' &&
+      stmt.type !== 'trigger_declaration' &&
+      stmt.type !== 'event_handler' &&
+      stmt.type !== 'tool_declaration' &&
+      stmt.type !== 'pipeline_declaration' &&
+      stmt.type !== 'test_declaration' &&
+      stmt.type !== 'test_hook' &&
+      stmt.type !== 'fixture_declaration'
+  );
+  const codeLines = checkpoints
+    ? generateCheckpointedStatements(mainStatements, scopeStack)
+    : mainStatements
+        .map(stmt => dispatchStatement(stmt, scopeStack))
+        .filter(Boolean);
 
   if (codeLines.length === 0) {
     return '';
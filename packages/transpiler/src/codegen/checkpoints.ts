@@ -0,0 +1,48 @@
+// Checkpoint code generation
+//
+// With checkpoints, each top-level statement of the script only runs when
+// the run has not finished it yet, and saves the script's variables once it
+// has. The variables are declared before the first statement, with the
+// values a resumed run saved, so skipped statements leave them set.
+import { Statement } from '../ast.js';
+import { ScopeStack, dispatchStatement, indentCode } from './statements.js';
+
+/**
+ * Generate the top-level statements of a script with checkpoints
+ */
+export function generateCheckpointedStatements(
+  statements: Statement[],
+  scopeStack: ScopeStack
+): string[] {
+  // Find the variables the statements declare at the top level
+  const declared = new ScopeStack(
+    scopeStack.emitPositions,
+    scopeStack.debugHooks
+  );
+  statements.forEach(stmt => dispatchStatement(stmt, declared));
+  const variables = declared.variables();
+  variables.forEach(name => scopeStack.declare(name));
+
+  const lines = variables.map(
+    name => `let ${name} = __checkpoint.restore(${JSON.stringify(name)});`
+  );
+  const saved = variables.length > 0 ? `{ ${variables.join(', ')} }` : '{}';
+  let statement = 0;
+  for (const stmt of statements) {
+    const code = dispatchStatement(stmt, scopeStack);
+    if (!code || stmt.type === 'comment') {
+      lines.push(code);
+      continue;
+    }
+    statement++;
+    lines.push(
+      [
+        `if (__checkpoint.pending(${statement})) {`,
+        indentCode(code, '  '),
+        `  await __checkpoint.save(${statement}, ${saved});`,
+        '}',
+      ].join('\n')
+    );
+  }
+  return lines.filter(Boolean);
+}
@@ -3,7 +3,15 @@ import {
   MCPDeclaration,
   ModelDeclaration,
   AgentDeclaration,
+  PromptDeclaration,
+  TriggerDeclaration,
+  EventHandler,
+  TestDeclaration,
+  TestHook,
+  FixtureDeclaration,
   ToolDeclaration,
+  PipelineDeclaration,
+  PipelineStage,
   ObjectLiteral,
   Expression,
   StringLiteral,
@@ -11,10 +19,22 @@ import {
   BooleanLiteral,
   ArrayLiteral,
   Identifier,
-  TypeExpression,
 } from '../ast.js';
 import { generateExpression } from './expressions.js';
-import { ScopeStack, generateBlockStatement } from './statements.js';
+import {
+  ScopeStack,
+  collectIdentifiers,
+  containsCompensation,
+  generateBlockStatement,
+} from './statements.js';
+import { statementMarker, toolMarker } from './positions.js';
+import {
+  generateParameterSchema,
+  generateSchemaDefinition,
+} from './schemas.js';
+import { getLocation } from '../locations.js';
+import { interpolatedStrings, parseInterpolation } from '../interpolation.js';
+import { triggerSchedule } from '../schedule.js';
 
 /**
  * Generate MCP client initialization code
@@ -38,7 +58,18 @@ ${serverInits.join('\n\n')}`;
  */
 function generateMCPServerInit(name: string, decl: MCPDeclaration): string {
   const config = extractObjectValues(decl.config);
-  const serverConfig = generateMCPServerConfig(name, config);
+  const serverConfig = generateMCPServerConfig(name, config, decl.config);
+  const policy = generateCallPolicy(decl.config);
+  const breaker = decl.config.properties.find(
+    prop => prop.key === 'circuitBreaker'
+  );
+  const proxyArgs = [`__${name}_tools`, JSON.stringify(name)];
+  if (policy || breaker) {
+    proxyArgs.push(policy ?? 'undefined');
+  }
+  if (breaker) {
+    proxyArgs.push(generateExpression(breaker.value));
+  }
 
   return `// Connect to ${name} MCP server using LlamaIndex
 const __${name}_server = __llamaindex_mcp(${serverConfig});
@@ -50,34 +81,77 @@ __mcpServers.push(__${name}_server);
 const __${name}_tools = await __${name}_server.tools();
 
 // Create tool proxy for ${name}
-const ${name} = __createToolProxy(__${name}_tools);`;
+const ${name} = __createToolProxy(${proxyArgs.join(', ')});`;
+}
+
+/** Server properties that set the default policy of its tool calls */
+const CALL_POLICY_KEYS = ['timeout', 'retries', 'backoff'];
+
+/**
+ * Generate the default call policy of a server from its timeout, retries
+ * and backoff, which may reference env.*, or undefined if none is set
+ */
+function generateCallPolicy(configNode: ObjectLiteral): string | undefined {
+  const fields = configNode.properties
+    .filter(prop => CALL_POLICY_KEYS.includes(prop.key))
+    .map(prop => `${prop.key}: ${generateExpression(prop.value)}`);
+  return fields.length > 0 ? `{ ${fields.join(', ')} }` : undefined;
 }
 
 /**
  * Generate MCP server configuration for LlamaIndex mcp() function
  */
 function generateMCPServerConfig(
-  _name: string,
-  config: Record<string, unknown>
+  name: string,
+  config: Record<string, unknown>,
+  configNode: ObjectLiteral
 ): string {
-  if (config.url) {
-    // URL-based connection (HTTP/WebSocket/SSE)
-    const url = JSON.stringify(config.url);
-    const params: string[] = [`url: ${url}`];
-
-    // Add useSSETransport flag if specified
-    if (config.useSSETransport !== undefined) {
-      params.push(`useSSETransport: ${config.useSSETransport}`);
+  const serverName = `serverName: ${JSON.stringify(name)}`;
+
+  const property = (key: string) =>
+    configNode.properties.find(prop => prop.key === key);
+
+  const url = property('url');
+  if (url) {
+    // URL-based connection (streamable HTTP or SSE); the URL, headers and
+    // TLS settings may reference env.*, so they are generated as code
+    const params = [`url: ${generateConfigValue(url.value)}`, serverName];
+
+    if (
+      config.transport !== undefined &&
+      config.transport !== 'http' &&
+      config.transport !== 'sse'
+    ) {
+      throw new Error(
+        `MCP server "${name}": transport must be "http" or "sse"`
+      );
+    }
+    for (const key of ['transport', 'useSSETransport', 'reconnect']) {
+      if (config[key] !== undefined) {
+        params.push(`${key}: ${serializeConfigObject(config[key])}`);
+      }
+    }
+    for (const key of ['headers', 'tls']) {
+      const prop = property(key);
+      if (prop) {
+        params.push(`${key}: ${generateConfigValue(prop.value)}`);
+      }
     }
 
     return `{ ${params.join(', ')} }`;
   } else if (config.command) {
     // Command-based connection (stdio)
-    const command = JSON.stringify(config.command);
-    const params: string[] = [`command: ${command}`];
+    const command = property('command')!.value;
+    const params: string[] = [
+      `command: ${isInterpolated(command) ? generateConfigValue(command) : JSON.stringify(config.command)}`,
+      serverName,
+    ];
 
     // Add args if specified
-    if (config.args && Array.isArray(config.args)) {
+    const args = property('args');
+    if (args && isInterpolated(args.value)) {
+      params.push(`args: ${generateConfigValue(args.value)}`);
+    } else if (config.args && Array.isArray(config.args)) {
       params.push(`args: ${serializeConfigObject(config.args)}`);
     }
 
@@ -86,6 +160,19 @@ function generateMCPServerConfig(
       params.push(`stderr: ${serializeConfigValue(config.stderr)}`);
     }
 
+    // Environment values may reference env.*, so generate them as code
+    const env = property('env');
+    if (env) {
+      params.push(`env: ${generateConfigValue(env.value)}`);
+    }
+
+    // Process isolation options
+    for (const key of ['inheritEnv', 'allowEnv', 'stderrLimit', 'priority']) {
+      if (config[key] !== undefined) {
+        params.push(`${key}: ${serializeConfigObject(config[key])}`);
+      }
+    }
+
     return `{ ${params.join(', ')} }`;
   } else {
     throw new Error(
@@ -94,6 +181,40 @@ function generateMCPServerConfig(
   }
 }
 
+function isInterpolated(expr: Expression): boolean {
+  return interpolatedStrings(expr).length > 0;
+}
+
+/**
+ * Generate a server option whose strings may reference ${NAME} and
+ * ${secret:NAME}, which are resolved when the server starts
+ */
+function generateConfigValue(expr: Expression): string {
+  if (!isInterpolated(expr)) {
+    return generateExpression(expr);
+  }
+  switch (expr.type) {
+    case 'string': {
+      const parts = parseInterpolation(expr.value);
+      // Only escaped $${ in the string
+      if (parts.length === 1 && typeof parts[0] === 'string') {
+        return JSON.stringify(parts[0]);
+      }
+      return `await __interpolate(${JSON.stringify(parts)})`;
+    }
+    case 'array':
+      return `[${expr.elements.map(generateConfigValue).join(', ')}]`;
+    case 'object': {
+      const props = expr.properties.map(
+        prop => `${JSON.stringify(prop.key)}: ${generateConfigValue(prop.value)}`
+      );
+      return `{ ${props.join(', ')} }`;
+    }
+    default:
+      return generateExpression(expr);
+  }
+}
+
 /**
  * Generate model configuration initialization code
  */
@@ -112,6 +233,8 @@ ${modelInits.join('\n\n')}`;
 
 /**
  * Generate configuration object for a single model
+ * The runtime looks up the provider when the script starts, so hosts can
+ * register providers of their own
  */
 function generateModelConfig(name: string, decl: ModelDeclaration): string {
   const config = extractObjectValues(decl.config);
@@ -119,104 +242,172 @@ function generateModelConfig(name: string, decl: ModelDeclaration): string {
 
   if (!provider) {
     throw new Error(
-      `Model "${name}" must specify a provider (openai, anthropic, or gemini)`
+      `Model "${name}" must specify a provider (openai, anthropic, gemini, or local)`
     );
   }
 
   return `// Model configuration for ${name}
-const ${name} = ${generateLlamaIndexModelInit(provider, decl.config)};
+const ${name} = __createModel(${generateExpression(decl.config)});
 __models.${name} = ${name};`;
 }
 
 /**
- * Generate LlamaIndex-compatible model initialization code
+ * Generate prompt initialization code
+ * A prompt is the string of its text, or of the file it names as read when
+ * the script was loaded; references to the environment and the project's
+ * metadata are resolved when the script starts
  */
-function generateLlamaIndexModelInit(
-  provider: string,
-  config: ObjectLiteral
+export function generatePromptInitialization(
+  prompts: Map<string, PromptDeclaration>
 ): string {
-  switch (provider.toLowerCase()) {
-    case 'openai':
-      return generateOpenAIInit(config);
-    case 'anthropic':
-      return generateAnthropicInit(config);
-    case 'gemini':
-      return generateGeminiInit(config);
-    default:
-      throw new Error(`Unsupported model provider: ${provider}`);
+  const promptInits = Array.from(prompts.entries()).map(([name, decl]) =>
+    generatePromptText(name, decl)
+  );
+
+  return `// Initialize prompts
+${promptInits.join('\n')}`;
+}
+
+function generatePromptText(name: string, decl: PromptDeclaration): string {
+  const config = extractObjectValues(decl.config);
+  if ((config.text === undefined) === (config.file === undefined)) {
+    throw new Error(`Prompt "${name}" must specify either text or file`);
   }
+
+  let text: string;
+  if (config.file !== undefined) {
+    if (decl.fileText === undefined) {
+      throw new Error(
+        `Prompt "${name}": its file is only read when the script is loaded with its imports`
+      );
+    }
+    text = decl.fileText;
+  } else if (typeof config.text === 'string') {
+    text = config.text;
+  } else {
+    throw new Error(`Prompt "${name}": text must be a string`);
+  }
+
+  const parts = parseInterpolation(text, { project: true });
+  const secret = parts.find(
+    (part): part is { secret: string } =>
+      typeof part !== 'string' && 'secret' in part
+  );
+  if (secret) {
+    throw new Error(
+      `Prompt "${name}" cannot reference \${secret:${secret.secret}}, since prompts are sent to the model`
+    );
+  }
+  if (parts.length === 1 && typeof parts[0] === 'string') {
+    return `const ${name} = ${JSON.stringify(parts[0])};`;
+  }
+  return `const ${name} = __prompt(${JSON.stringify(name)}, ${JSON.stringify(parts)});`;
 }
 
 /**
- * Generate OpenAI model initialization
+ * Generate trigger registration code
+ * Each trigger hands the runtime its schedule and a function evaluating its
+ * action; they are registered once everything else has run, so actions
+ * can use the script's variables. They only fire under mcps serve --http
  */
-function generateOpenAIInit(config: ObjectLiteral): string {
-  const params: string[] = [];
-
-  // Map common config keys to OpenAI parameters
-  for (const prop of config.properties) {
-    if (prop.key === 'provider') continue; // Skip provider field
-
-    if (prop.key === 'apiKey') {
-      params.push(`apiKey: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'model') {
-      params.push(`model: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'temperature') {
-      params.push(`temperature: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'maxTokens') {
-      params.push(`maxTokens: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'baseURL') {
-      params.push(`baseURL: ${generateExpression(prop.value)}`);
+export function generateTriggerRegistration(
+  triggers: TriggerDeclaration[],
+  emitPositions: boolean = false
+): string {
+  const names = new Set<string>();
+  const registrations = triggers.map(decl => {
+    if (names.has(decl.name)) {
+      throw new Error(`Trigger "${decl.name}" is declared more than once`);
     }
-  }
-
-  return `new __llamaindex_OpenAI({ ${params.join(', ')} })`;
+    names.add(decl.name);
+    let schedule: string | null;
+    try {
+      schedule = triggerSchedule(decl.source)?.expression ?? null;
+    } catch (error) {
+      throw new Error(`Trigger "${decl.name}": ${(error as Error).message}`);
+    }
+    const location = emitPositions ? getLocation(decl) : undefined;
+    const marker = location ? statementMarker(location) : '';
+    const action = generateExpression(decl.action);
+    return `${marker}__trigger(${JSON.stringify(decl.name)}, ${JSON.stringify(schedule)}, async () => ${action});`;
+  });
+
+  return `// Register triggers
+${registrations.join('\n')}`;
 }
 
 /**
- * Generate Anthropic model initialization
+ * Generate event handler registration code
+ * Each handler hands the runtime the name of its events, a function running
+ * its body with an event's payload and, when its parameter is typed, the
+ * schema payloads are checked against
  */
-function generateAnthropicInit(config: ObjectLiteral): string {
-  const params: string[] = [];
-
-  for (const prop of config.properties) {
-    if (prop.key === 'provider') continue;
-
-    if (prop.key === 'apiKey') {
-      params.push(`apiKey: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'model') {
-      params.push(`model: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'temperature') {
-      params.push(`temperature: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'maxTokens') {
-      params.push(`maxTokens: ${generateExpression(prop.value)}`);
+export function generateEventHandlers(
+  handlers: EventHandler[],
+  emitPositions: boolean = false,
+  debugHooks: boolean = false
+): string {
+  const registrations = handlers.map(handler => {
+    const { parameter } = handler;
+    const scopeStack = new ScopeStack(emitPositions, debugHooks);
+    if (parameter) {
+      scopeStack.declare(parameter.name);
     }
-  }
 
-  return `new __llamaindex_Anthropic({ ${params.join(', ')} })`;
+    let bodyCode = generateBlockStatement(handler.body, scopeStack, false);
+    if (containsCompensation(handler.body)) {
+      // A handler that fails undoes the steps it completed
+      bodyCode = `__saga(async () => ${bodyCode})`;
+    }
+    const schema = generateParameterSchema(parameter);
+
+    const location = emitPositions ? getLocation(handler) : undefined;
+    const marker = location ? statementMarker(location) : '';
+    return `${marker}__on(${JSON.stringify(handler.event)}, async (${parameter?.name ?? ''}) => ${bodyCode}${schema ? `, ${schema}` : ''});`;
+  });
+
+  return `// Register event handlers
+${registrations.join('\n')}`;
 }
 
 /**
- * Generate Gemini model initialization
+ * Generate the registration of a test file's fixtures, hooks and test
+ * cases, followed by running them
+ * Fixtures are variables of the script that the runtime sets afresh before
+ * each test case; the test cases run once the file's top-level code has,
+ * so they may use any variable it sets.
  */
-function generateGeminiInit(config: ObjectLiteral): string {
-  const params: string[] = [];
-
-  for (const prop of config.properties) {
-    if (prop.key === 'provider') continue;
-
-    if (prop.key === 'apiKey') {
-      params.push(`apiKey: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'model') {
-      params.push(`model: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'temperature') {
-      params.push(`temperature: ${generateExpression(prop.value)}`);
-    } else if (prop.key === 'maxTokens') {
-      params.push(`maxOutputTokens: ${generateExpression(prop.value)}`);
+export function generateTestSuite(
+  statements: (TestDeclaration | TestHook | FixtureDeclaration)[],
+  emitPositions: boolean = false,
+  debugHooks: boolean = false
+): string {
+  const registrations = statements.flatMap(stmt => {
+    const location = emitPositions ? getLocation(stmt) : undefined;
+    const marker = location ? statementMarker(location) : '';
+    if (stmt.type === 'fixture_declaration') {
+      const value = generateExpression(stmt.value);
+      return [
+        `let ${stmt.name};`,
+        `${marker}__tests.fixture(async () => { ${stmt.name} = ${value}; });`,
+      ];
     }
-  }
 
-  return `new __llamaindex_Gemini({ ${params.join(', ')} })`;
+    const scopeStack = new ScopeStack(emitPositions, debugHooks);
+    let bodyCode = generateBlockStatement(stmt.body, scopeStack, false);
+    if (containsCompensation(stmt.body)) {
+      bodyCode = `__saga(async () => ${bodyCode})`;
+    }
+    const register =
+      stmt.type === 'test_declaration'
+        ? `__tests.test(${JSON.stringify(stmt.name)}, `
+        : `__tests.${stmt.hook}(`;
+    return [`${marker}${register}async () => ${bodyCode});`];
+  });
+
+  return `// Register and run test cases
+${registrations.join('\n')}
+await __tests.run();`;
 }
 
 /**
@@ -233,20 +424,73 @@ function serializeConfigValue(value: unknown): string {
  * Generate agent configuration initialization code
  */
 export function generateAgentInitialization(
-  agents: Map<string, AgentDeclaration>
+  agents: Map<string, AgentDeclaration>,
+  prompts: Map<string, PromptDeclaration> = new Map()
 ): string {
-  const agentInits = Array.from(agents.entries()).map(([name, decl]) =>
-    generateAgentConfig(name, decl)
+  const agentInits = delegationOrder(agents).map(([name, decl]) =>
+    generateAgentConfig(name, decl, prompts)
   );
 
   return `// Initialize agent configurations
 ${agentInits.join('\n\n')}`;
 }
 
+/**
+ * Names of the agents an agent lists among its tools
+ */
+function delegatesTo(
+  decl: AgentDeclaration,
+  agents: Map<string, AgentDeclaration>
+): string[] {
+  const tools = decl.config.properties.find(p => p.key === 'tools')?.value;
+  if (tools?.type !== 'array') {
+    return [];
+  }
+  return tools.elements.flatMap(elem =>
+    elem.type === 'identifier' && agents.has(elem.name) ? [elem.name] : []
+  );
+}
+
+/**
+ * Agents in declaration order, except that an agent comes after the agents
+ * it delegates to, which must exist when it is created
+ */
+function delegationOrder(
+  agents: Map<string, AgentDeclaration>
+): [string, AgentDeclaration][] {
+  const ordered: [string, AgentDeclaration][] = [];
+  const done = new Set<string>();
+  const visit = (name: string, path: string[]) => {
+    if (done.has(name)) {
+      return;
+    }
+    if (path.includes(name)) {
+      const cycle = [...path.slice(path.indexOf(name)), name];
+      throw new Error(
+        `Agents cannot delegate in a cycle: ${cycle.join(' -> ')}`
+      );
+    }
+    const decl = agents.get(name)!;
+    for (const delegate of delegatesTo(decl, agents)) {
+      visit(delegate, [...path, name]);
+    }
+    done.add(name);
+    ordered.push([name, decl]);
+  };
+  for (const name of agents.keys()) {
+    visit(name, []);
+  }
+  return ordered;
+}
+
 /**
  * Generate configuration object for a single agent
  */
-function generateAgentConfig(name: string, decl: AgentDeclaration): string {
+function generateAgentConfig(
+  name: string,
+  decl: AgentDeclaration,
+  prompts: Map<string, PromptDeclaration>
+): string {
   const config = extractObjectValues(decl.config);
   const model = config.model as string;
 
@@ -264,8 +508,22 @@ function generateAgentConfig(name: string, decl: AgentDeclaration): string {
     agentParams.push(`description: ${JSON.stringify(config.description)}`);
   }
 
-  // Add system prompt if provided
-  if (config.systemPrompt) {
+  // Add system prompt if provided, as text or a declared prompt
+  const systemPrompt = decl.config.properties.find(
+    p => p.key === 'systemPrompt'
+  )?.value;
+  if (systemPrompt?.type === 'identifier') {
+    if (!prompts.has(systemPrompt.name)) {
+      throw new Error(
+        `Agent "${name}": systemPrompt ${systemPrompt.name} is not a declared prompt`
+      );
+    }
+    agentParams.push(`systemPrompt: ${systemPrompt.name}`);
+  } else if (systemPrompt && typeof config.systemPrompt !== 'string') {
+    throw new Error(
+      `Agent "${name}": systemPrompt must be a string or the name of a prompt`
+    );
+  } else if (config.systemPrompt) {
     agentParams.push(`systemPrompt: ${JSON.stringify(config.systemPrompt)}`);
   }
 
@@ -281,8 +539,43 @@ function generateAgentConfig(name: string, decl: AgentDeclaration): string {
     }
   }
 
-  // Add LLM reference
-  agentParams.push(`llm: ${model}`);
+  // Turns shared with the agents it delegates to
+  if (config.maxTurns !== undefined) {
+    const { maxTurns } = config;
+    if (
+      typeof maxTurns !== 'number' ||
+      !Number.isInteger(maxTurns) ||
+      maxTurns < 1
+    ) {
+      throw new Error(
+        `Agent "${name}": maxTurns must be a positive whole number`
+      );
+    }
+    agentParams.push(`maxTurns: ${maxTurns}`);
+  }
+
+  // Checks on the model's output, whose validators may be tools
+  const guardrails = decl.config.properties.find(
+    p => p.key === 'guardrails'
+  )?.value;
+  if (guardrails) {
+    if (guardrails.type !== 'array') {
+      throw new Error(`Agent "${name}": guardrails must be a list`);
+    }
+    agentParams.push(`guardrails: ${generateExpression(guardrails)}`);
+  }
+
+  // How the conversation is kept within the model's context window
+  const context = decl.config.properties.find(p => p.key === 'context')?.value;
+  if (context) {
+    if (context.type !== 'object') {
+      throw new Error(`Agent "${name}": context must be an object`);
+    }
+    agentParams.push(`context: ${generateExpression(context)}`);
+  }
+
+  // Add LLM reference, or a router over the agent's models
+  agentParams.push(`llm: ${generateAgentModel(name, decl)}`);
 
   return `// Agent configuration for ${name}
 const ${name} = new __Agent({
@@ -290,6 +583,39 @@ const ${name} = new __Agent({
 });`;
 }
 
+/**
+ * The LLM of an agent: its model, or a router trying a list of models in
+ * order under the agent's routing rules
+ */
+function generateAgentModel(name: string, decl: AgentDeclaration): string {
+  const property = (key: string) =>
+    decl.config.properties.find(p => p.key === key)?.value;
+  const model = property('model')!;
+  const routing = property('routing');
+  const models = model.type === 'array' ? model.elements : [model];
+  if (
+    models.length === 0 ||
+    models.some(element => element.type !== 'identifier')
+  ) {
+    throw new Error(
+      `Agent "${name}": model must be a declared model or a list of them`
+    );
+  }
+  if (model.type === 'identifier' && !routing) {
+    return model.name;
+  }
+  if (routing && routing.type !== 'object') {
+    throw new Error(`Agent "${name}": routing must be an object`);
+  }
+  const names = models.map(element => (element as Identifier).name);
+  const args = [
+    `{ ${names.join(', ')} }`,
+    routing ? generateExpression(routing) : '{}',
+    JSON.stringify(`Agent ${name}`),
+  ];
+  return `__routeModels(${args.join(', ')})`;
+}
+
 /**
  * Serialize a config object as JavaScript code (not JSON)
  */
@@ -356,9 +682,13 @@ function extractValue(expr: Expression): unknown {
  * Generate tool declaration code using __createUserTool helper
  * This wraps the tool function with metadata via Proxy
  */
-export function generateToolDeclaration(decl: ToolDeclaration): string {
+export function generateToolDeclaration(
+  decl: ToolDeclaration,
+  emitPositions: boolean = false,
+  debugHooks: boolean = false
+): string {
   // Create a new scope stack for the tool body
-  const scopeStack = new ScopeStack();
+  const scopeStack = new ScopeStack(emitPositions, debugHooks);
 
   // Declare all parameters in the tool's scope
   for (const param of decl.parameters) {
@@ -366,9 +696,91 @@ export function generateToolDeclaration(decl: ToolDeclaration): string {
   }
 
   // Generate the tool body
-  const bodyCode = generateBlockStatement(decl.body, scopeStack, false);
+  let bodyCode = generateBlockStatement(decl.body, scopeStack, false);
+  if (containsCompensation(decl.body)) {
+    // A tool that fails undoes the steps it completed
+    bodyCode = `__saga(async () => ${bodyCode})`;
+  }
+  if (debugHooks) {
+    // The debugger keeps a stack frame for each running tool
+    bodyCode = `__debug.call(${JSON.stringify(decl.name)}, async () => ${bodyCode})`;
+  }
+  const toolCode = generateValidatedTool(decl, bodyCode);
+
+  const location = emitPositions ? getLocation(decl) : undefined;
+  return location ? `${toolMarker(decl.name, location)}${toolCode}` : toolCode;
+}
+
+/**
+ * Generate pipeline declaration code
+ * The pipeline is a tool handing its steps to __pipeline, each with the
+ * earlier steps it uses, so the runtime can run it once they have finished
+ */
+export function generatePipelineDeclaration(
+  decl: PipelineDeclaration,
+  emitPositions: boolean = false
+): string {
+  const earlier = new Set<string>();
+  const steps = decl.steps.map(step => {
+    const uses = usedSteps(step.stage, earlier);
+    earlier.add(step.name);
+    return `{ name: ${JSON.stringify(step.name)}, uses: ${JSON.stringify(uses)}, run: async (${destructure(uses)}) => ${generatePipelineStage(step.stage)} }`;
+  });
+  const output = decl.output ? generateExpression(decl.output) : 'null';
+  const outputUses = decl.output ? usedSteps(decl.output, earlier) : [];
+  const bodyCode = `__pipeline(${JSON.stringify(decl.name)}, [${steps.join(', ')}], async (${destructure(outputUses)}) => ${output})`;
+  const toolCode = generateValidatedTool(decl, bodyCode);
+
+  const location = emitPositions ? getLocation(decl) : undefined;
+  return location ? `${toolMarker(decl.name, location)}${toolCode}` : toolCode;
+}
 
-  return generateValidatedTool(decl, bodyCode);
+/**
+ * Names of the steps a stage or the pipeline's output refers to
+ */
+function usedSteps(node: unknown, steps: Set<string>): string[] {
+  const names = new Set<string>();
+  collectIdentifiers(node, names);
+  return [...names].filter(name => steps.has(name));
+}
+
+/**
+ * Parameter list receiving the results of the steps used, by name
+ */
+function destructure(names: string[]): string {
+  return names.length > 0 ? `{ ${names.join(', ')} }` : '';
+}
+
+/**
+ * Generate the expression a pipeline stage evaluates to
+ */
+function generatePipelineStage(stage: PipelineStage): string {
+  switch (stage.type) {
+    case 'call':
+      return generateExpression(stage);
+    case 'conditional_stage': {
+      // Without an else stage the result is null when the condition is false
+      const otherwise = stage.else ? generatePipelineStage(stage.else) : 'null';
+      return `((${generateExpression(stage.condition)}) ? ${generatePipelineStage(stage.then)} : ${otherwise})`;
+    }
+    case 'fan_out_stage': {
+      const branches = stage.branches
+        .map(
+          branch =>
+            `${JSON.stringify(branch.name)}: async () => ${generatePipelineStage(branch.stage)}`
+        )
+        .join(', ');
+      return `await __fanOut({ ${branches} })`;
+    }
+    case 'each_stage': {
+      const args = [
+        generateExpression(stage.items),
+        `async (${stage.item}) => ${generatePipelineStage(stage.stage)}`,
+        ...(stage.limit ? [generateExpression(stage.limit)] : []),
+      ];
+      return `await __fanOutEach(${args.join(', ')})`;
+    }
+  }
 }
 
 /**
@@ -376,12 +788,18 @@ export function generateToolDeclaration(decl: ToolDeclaration): string {
  * The schema is passed to __createUserTool and attached via Proxy for agent registration
  */
 function generateValidatedTool(
-  decl: ToolDeclaration,
+  decl: ToolDeclaration | PipelineDeclaration,
   bodyCode: string
 ): string {
   const nameJson = JSON.stringify(decl.name);
   const paramsJson = JSON.stringify(decl.parameters.map(p => p.name));
-  const params = decl.parameters.map(p => p.name).join(', ');
+  const params = decl.parameters
+    .map(p =>
+      p.defaultValue
+        ? `${p.name} = ${generateExpression(p.defaultValue)}`
+        : p.name
+    )
+    .join(', ');
 
   // Generate schema definition object for all parameters
   // Unannotated parameters are treated as 'any' type
@@ -390,9 +808,11 @@ function generateValidatedTool(
     const schemaDef = param.typeAnnotation
       ? generateSchemaDefinition(param.typeAnnotation)
       : '{ type: "any" }';
-    const optionalDef = param.optional
-      ? `{ type: "optional", schema: ${schemaDef} }`
-      : schemaDef;
+    // Callers may leave out parameters with a default value
+    const optionalDef =
+      param.optional || param.defaultValue
+        ? `{ type: "optional", schema: ${schemaDef} }`
+        : schemaDef;
     paramSchemas[param.name] = optionalDef;
   }
 
@@ -405,43 +825,6 @@ function generateValidatedTool(
   return `const ${decl.name} = __createUserTool(${nameJson}, ${paramsJson}, async (${params}) => ${bodyCode}, __buildZodSchema({ type: "object", properties: { ${schemaProps} } }));`;
 }
 
-/**
- * Generate schema definition object from type expression
- * This generates a simple object that __buildZodSchema can convert to a Zod schema at runtime
- */
-function generateSchemaDefinition(typeExpr: TypeExpression): string {
-  switch (typeExpr.type) {
-    case 'primitive_type':
-      return `{ type: "${typeExpr.value}" }`;
-    case 'array_type':
-      return `{ type: "array", elementType: ${generateSchemaDefinition(typeExpr.elementType)} }`;
-    case 'object_type': {
-      const props = typeExpr.properties.map(prop => {
-        const propDef = generateSchemaDefinition(prop.typeAnnotation);
-        const finalDef = prop.optional
-          ? `{ type: "optional", schema: ${propDef} }`
-          : propDef;
-        return `${prop.name}: ${finalDef}`;
-      });
-      return `{ type: "object", properties: { ${props.join(', ')} } }`;
-    }
-    case 'union_type': {
-      if (typeExpr.types.length === 0) {
-        throw new Error('Union type must have at least one type');
-      }
-      if (typeExpr.types.length === 1) {
-        return generateSchemaDefinition(typeExpr.types[0]);
-      }
-      const types = typeExpr.types.map(t => generateSchemaDefinition(t));
-      return `{ type: "union", types: [${types.join(', ')}] }`;
-    }
-    default:
-      throw new Error(
-        `Unknown type expression: ${(typeExpr as { type: string }).type}`
-      );
-  }
-}
-
 /**
  * Generate cleanup code for MCP servers
  */
@@ -85,6 +85,17 @@ function generateObjectLiteral(expr: ObjectLiteral): string {
 function generateCallExpression(expr: CallExpression): string {
   const args = expr.arguments.map(generateExpression);
 
+  // Options go to the server's tool proxy along with the tool name
+  if (expr.options) {
+    if (expr.callee.type !== 'member') {
+      throw new Error('Call options are only supported on MCP tool calls');
+    }
+    const callee = expr.callee as MemberExpression;
+    const object = generateExpression(callee.object);
+    const options = generateObjectLiteral(expr.options);
+    return `await ${object}.__mcp_call(${JSON.stringify(callee.property)}, [${args.join(', ')}], ${options})`;
+  }
+
   // Check if this is a member call that needs await
   if (expr.callee.type === 'member') {
     // For member calls, generate the callee without await in the member expression
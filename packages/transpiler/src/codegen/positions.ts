@@ -0,0 +1,20 @@
+// Source position markers for generated code
+import { SourceLocation } from '../locations.js';
+
+/**
+ * Marker placed before the code of each statement
+ * The runtime reads these back to map errors to script locations
+ * (see resolveScriptStack in @mcpscript/runtime)
+ */
+export function statementMarker(location: SourceLocation): string {
+  return `/*@mcps:${location.start.line}:${location.start.column}*/`;
+}
+
+/**
+ * Marker placed before a tool declaration, recording the source range of
+ * the tool so frames inside its body can be attributed to it
+ */
+export function toolMarker(name: string, location: SourceLocation): string {
+  const { start, end } = location;
+  return `/*@mcps-tool:${name}:${start.line}:${start.column}:${end.line}:${end.column}*/`;
+}
@@ -0,0 +1,55 @@
+// Schema definition code generators for MCP Script
+import { ToolParameter, TypeExpression } from '../ast.js';
+
+/**
+ * Generate schema definition object from type expression
+ * This generates a simple object that __buildZodSchema can convert to a Zod schema at runtime
+ */
+export function generateSchemaDefinition(typeExpr: TypeExpression): string {
+  switch (typeExpr.type) {
+    case 'primitive_type':
+      return `{ type: "${typeExpr.value}" }`;
+    case 'array_type':
+      return `{ type: "array", elementType: ${generateSchemaDefinition(typeExpr.elementType)} }`;
+    case 'object_type': {
+      const props = typeExpr.properties.map(prop => {
+        const propDef = generateSchemaDefinition(prop.typeAnnotation);
+        const finalDef = prop.optional
+          ? `{ type: "optional", schema: ${propDef} }`
+          : propDef;
+        return `${prop.name}: ${finalDef}`;
+      });
+      return `{ type: "object", properties: { ${props.join(', ')} } }`;
+    }
+    case 'union_type': {
+      if (typeExpr.types.length === 0) {
+        throw new Error('Union type must have at least one type');
+      }
+      if (typeExpr.types.length === 1) {
+        return generateSchemaDefinition(typeExpr.types[0]);
+      }
+      const types = typeExpr.types.map(t => generateSchemaDefinition(t));
+      return `{ type: "union", types: [${types.join(', ')}] }`;
+    }
+    default:
+      throw new Error(
+        `Unknown type expression: ${(typeExpr as { type: string }).type}`
+      );
+  }
+}
+
+/**
+ * Generate the schema a handler's typed parameter is checked against, or
+ * undefined when the parameter is untyped
+ */
+export function generateParameterSchema(
+  parameter?: ToolParameter
+): string | undefined {
+  if (!parameter?.typeAnnotation) {
+    return undefined;
+  }
+  const definition = generateSchemaDefinition(parameter.typeAnnotation);
+  return parameter.optional
+    ? `__buildZodSchema({ type: "optional", schema: ${definition} })`
+    : `__buildZodSchema(${definition})`;
+}
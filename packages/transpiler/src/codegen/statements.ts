@@ -8,6 +8,13 @@ import {
   IfStatement,
   WhileStatement,
   ForStatement,
+  ParallelStatement,
+  ParallelMapStatement,
+  AnnotatedStatement,
+  CompensatedStatement,
+  NotificationHandler,
+  ListenStatement,
+  Expression,
   BreakStatement,
   ContinueStatement,
   ReturnStatement,
@@ -21,6 +28,11 @@ import {
   generateMemberExpression,
   generateBracketExpression,
 } from './expressions.js';
+import { statementMarker } from './positions.js';
+import { generateParameterSchema } from './schemas.js';
+import { copyLocation, getLocation, SourceLocation } from '../locations.js';
+import { createNode } from '../arena.js';
+import { printExpression } from '../diff.js';
 
 /**
  * Scope stack for tracking variable declarations across nested scopes
@@ -28,6 +40,16 @@ import {
  */
 export class ScopeStack {
   private scopes: Set<string>[] = [new Set()]; // Start with global scope
+  private temporaries = 0;
+
+  /**
+   * @param emitPositions Prefix each statement with a source position marker
+   * @param debugHooks Call the debugger before each statement
+   */
+  constructor(
+    readonly emitPositions: boolean = false,
+    readonly debugHooks: boolean = false
+  ) {}
 
   /**
    * Push a new scope (for blocks, functions, etc.)
@@ -66,6 +88,20 @@ export class ScopeStack {
     currentScope.add(variable);
   }
 
+  /**
+   * Names of the variables visible in the current scope
+   */
+  variables(): string[] {
+    return [...this.scopes[this.scopes.length - 1]];
+  }
+
+  /**
+   * A fresh name for a generated variable, such as __parallel0
+   */
+  temporary(prefix: string): string {
+    return `${prefix}${this.temporaries++}`;
+  }
+
   /**
    * Get current scope depth (for debugging)
    */
@@ -81,6 +117,40 @@ export function dispatchStatement(
   stmt: Statement,
   scopeStack: ScopeStack
 ): string {
+  // Variables the statement declares are not readable before it runs
+  const variables = scopeStack.debugHooks ? scopeStack.variables() : [];
+  let code = generateStatement(stmt, scopeStack);
+  if (!code || stmt.type === 'comment') {
+    return code;
+  }
+  const location = getLocation(stmt);
+  if (!location) {
+    return code;
+  }
+  // Blocks have no line of their own to stop at; their statements do
+  if (scopeStack.debugHooks && stmt.type !== 'block_statement') {
+    code = `${debugHook(location, variables)} ${code}`;
+  }
+  return scopeStack.emitPositions
+    ? `${statementMarker(location)}${code}`
+    : code;
+}
+
+/**
+ * Call to the debugger before a statement, passing getters of the
+ * variables in scope so they can be inspected while it is paused
+ */
+function debugHook(location: SourceLocation, variables: string[]): string {
+  const { line, column } = location.start;
+  const getters = variables.map(name => `${name}: () => ${name}`);
+  const scope = getters.length > 0 ? `{ ${getters.join(', ')} }` : '{}';
+  return `await __debug.before(${line}, ${column}, ${scope});`;
+}
+
+/**
+ * Generate code for a single statement
+ */
+function generateStatement(stmt: Statement, scopeStack: ScopeStack): string {
   switch (stmt.type) {
     case 'comment':
       return generateComment(stmt);
@@ -96,6 +166,18 @@ export function dispatchStatement(
       return generateWhileStatement(stmt, scopeStack);
     case 'for_statement':
       return generateForStatement(stmt, scopeStack);
+    case 'parallel_statement':
+      return generateParallelStatement(stmt, scopeStack);
+    case 'parallel_map_statement':
+      return generateParallelMapStatement(stmt, scopeStack);
+    case 'annotated_statement':
+      return generateAnnotatedStatement(stmt, scopeStack);
+    case 'compensated_statement':
+      return generateCompensatedStatement(stmt, scopeStack);
+    case 'notification_handler':
+      return generateNotificationHandler(stmt, scopeStack);
+    case 'listen_statement':
+      return generateListenStatement(stmt);
     case 'break_statement':
       return generateBreakStatement(stmt);
     case 'continue_statement':
@@ -111,11 +193,20 @@ export function dispatchStatement(
  * Generate code for an assignment statement
  */
 function generateAssignment(stmt: Assignment, scopeStack: ScopeStack): string {
-  const value = generateExpression(stmt.value);
+  return assignValue(stmt.target, generateExpression(stmt.value), scopeStack);
+}
 
+/**
+ * Generate code assigning already generated value code to a target
+ */
+function assignValue(
+  assignmentTarget: AssignmentTarget,
+  value: string,
+  scopeStack: ScopeStack
+): string {
   // Handle different assignment target types
-  if (stmt.target.type === 'identifier') {
-    const identifier = stmt.target as Identifier;
+  if (assignmentTarget.type === 'identifier') {
+    const identifier = assignmentTarget as Identifier;
     const variable = identifier.name;
 
     // Check if this is the first time we're seeing this variable
@@ -129,7 +220,7 @@ function generateAssignment(stmt: Assignment, scopeStack: ScopeStack): string {
     }
   } else {
     // For member and bracket expressions, generate direct assignment
-    const target = generateAssignmentTarget(stmt.target);
+    const target = generateAssignmentTarget(assignmentTarget);
     return `${target} = ${value};`;
   }
 }
@@ -268,6 +359,289 @@ function generateForStatement(
   return `for (${init}; ${condition}; ${update}) ${bodyCode}`;
 }
 
+/**
+ * Generate code for a parallel statement
+ * Each branch becomes an async function run by the runtime's __parallel();
+ * assignment branches only compute their value, and the values are
+ * assigned in branch order once every branch has finished
+ */
+function generateParallelStatement(
+  stmt: ParallelStatement,
+  scopeStack: ScopeStack
+): string {
+  const branches = stmt.branches.map(branch => {
+    if (branch.type === 'assignment') {
+      return `async () => ${generateExpression(branch.value)}`;
+    }
+    // Variables first assigned in a branch stay local to it
+    scopeStack.pushScope();
+    try {
+      return `async () => ${generateStatementAsBlock(branch, scopeStack)}`;
+    } finally {
+      scopeStack.popScope();
+    }
+  });
+
+  const results = scopeStack.temporary('__parallel');
+  const limit = stmt.limit ? `, ${generateExpression(stmt.limit)}` : '';
+  const branchList = branches
+    .map(branch => `\n${indentCode(branch, '  ')},`)
+    .join('');
+  const lines = [
+    `const ${results} = await __parallel([${branchList}${branchList && '\n'}]${limit});`,
+  ];
+  stmt.branches.forEach((branch, index) => {
+    if (branch.type === 'assignment') {
+      lines.push(
+        assignValue(branch.target, `${results}[${index}]`, scopeStack)
+      );
+    }
+  });
+  return lines.join('\n');
+}
+
+/**
+ * Generate code for a parallel map statement
+ * The body becomes an async function of the item run by the runtime's
+ * __parallelMap(), returning its last expression when it does not return
+ * before; the results are assigned once every item has finished
+ */
+function generateParallelMapStatement(
+  stmt: ParallelMapStatement,
+  scopeStack: ScopeStack
+): string {
+  const last = stmt.body[stmt.body.length - 1];
+  const body: Statement[] =
+    last?.type === 'expression_statement'
+      ? [
+          ...stmt.body.slice(0, -1),
+          copyLocation(
+            createNode({ type: 'return_statement', value: last.expression }),
+            last
+          ),
+        ]
+      : stmt.body;
+
+  // Variables first assigned in the body are local to each item
+  scopeStack.pushScope();
+  let bodyCode: string;
+  try {
+    scopeStack.declare(stmt.item);
+    bodyCode = generateBlockStatement(
+      createNode({ type: 'block_statement', statements: body }),
+      scopeStack,
+      false
+    );
+  } finally {
+    scopeStack.popScope();
+  }
+
+  const limit = stmt.limit ? generateExpression(stmt.limit) : 'undefined';
+  const label = JSON.stringify(
+    stmt.items.type === 'identifier'
+      ? `parallelMap(${stmt.items.name})`
+      : 'parallelMap'
+  );
+  // Each item undoes its own steps when it fails
+  if (stmt.body.some(containsCompensation)) {
+    bodyCode = `__saga(async () => ${bodyCode})`;
+  }
+  const call = `await __parallelMap(${generateExpression(stmt.items)}, async ${stmt.item} => ${bodyCode}, ${limit}, ${label})`;
+  return stmt.target
+    ? assignValue(stmt.target, call, scopeStack)
+    : `${call};`;
+}
+
+/**
+ * Generate code for an annotated statement
+ * With @idempotent, the call runs through the runtime's __idempotent(),
+ * which skips it and gives the recorded result when a call with the same
+ * key has already completed
+ */
+function generateAnnotatedStatement(
+  stmt: AnnotatedStatement,
+  scopeStack: ScopeStack
+): string {
+  const { annotation, statement } = stmt;
+  if (annotation.name === 'trace') {
+    return generateTracePoint(stmt, scopeStack);
+  }
+  const key = annotation.arguments.find(arg => arg.key === 'key');
+  if (annotation.name !== 'idempotent' || !key) {
+    throw new Error(`Unsupported annotation @${annotation.name}`);
+  }
+
+  const value =
+    statement.type === 'assignment' ? statement.value : statement.expression;
+  const label = JSON.stringify(callLabel(value));
+  const call = `await __idempotent(${generateExpression(key.value)}, ${label}, async () => ${generateExpression(value)})`;
+  return statement.type === 'assignment'
+    ? assignValue(statement.target, call, scopeStack)
+    : `${call};`;
+}
+
+/**
+ * Generate code for a statement annotated with @trace
+ * The statement's value passes through the runtime's __trace(), which
+ * records it with the label and place of the trace point when the run's
+ * trace level shows it, and gives it back unchanged
+ */
+function generateTracePoint(
+  stmt: AnnotatedStatement,
+  scopeStack: ScopeStack
+): string {
+  const { annotation, statement } = stmt;
+  const value =
+    statement.type === 'assignment' ? statement.value : statement.expression;
+  const label = annotation.arguments.find(arg => arg.key === 'label');
+  const level = annotation.arguments.find(arg => arg.key === 'level');
+  // Without a label, a trace point is named after what it traces
+  const defaultLabel =
+    statement.type === 'assignment' && statement.target.type === 'identifier'
+      ? statement.target.name
+      : printExpression(value);
+  const start = getLocation(statement)?.start;
+  const point = [
+    `label: ${label ? generateExpression(label.value) : JSON.stringify(defaultLabel)}`,
+    `level: ${JSON.stringify(level?.value.type === 'string' ? level.value.value : 'info')}`,
+    `line: ${start?.line ?? 0}`,
+    `column: ${start?.column ?? 0}`,
+  ];
+  const traced = `__trace(${generateExpression(value)}, { ${point.join(', ')} })`;
+  return statement.type === 'assignment'
+    ? assignValue(statement.target, traced, scopeStack)
+    : `${traced};`;
+}
+
+/**
+ * Generate code for a compensated statement
+ * Once the statement has run, its block is registered with the runtime's
+ * __compensate() as a function of the variables it uses, taken as they are
+ * then, so that later assignments don't change what it undoes
+ */
+function generateCompensatedStatement(
+  stmt: CompensatedStatement,
+  scopeStack: ScopeStack
+): string {
+  const code = generateStatement(stmt.statement, scopeStack);
+  const used = new Set<string>();
+  collectIdentifiers(stmt.compensation, used);
+  const params = [...used].filter(name => scopeStack.isDeclared(name));
+  const block = generateBlockStatement(stmt.compensation, scopeStack);
+
+  const inner =
+    stmt.statement.type === 'annotated_statement'
+      ? stmt.statement.statement
+      : stmt.statement;
+  const label = JSON.stringify(
+    callLabel(inner.type === 'assignment' ? inner.value : inner.expression)
+  );
+  const undo = `((${params.join(', ')}) => async () => ${block})(${params.join(', ')})`;
+  return `${code}\n__compensate(${undo}, ${label});`;
+}
+
+/**
+ * Generate code for a notification handler
+ * The handler is registered with the runtime's __onNotification() where it
+ * is declared; its body shares the script's variables, so what it sets is
+ * seen by the condition of a later listen statement
+ */
+function generateNotificationHandler(
+  stmt: NotificationHandler,
+  scopeStack: ScopeStack
+): string {
+  const { parameter } = stmt;
+  scopeStack.pushScope();
+  let bodyCode: string;
+  try {
+    if (parameter) {
+      scopeStack.declare(parameter.name);
+    }
+    bodyCode = generateBlockStatement(stmt.body, scopeStack, false);
+  } finally {
+    scopeStack.popScope();
+  }
+  if (containsCompensation(stmt.body)) {
+    // A handler that fails undoes the steps it completed
+    bodyCode = `__saga(async () => ${bodyCode})`;
+  }
+
+  const args = [
+    `__${stmt.server}_server`,
+    JSON.stringify(stmt.server),
+    JSON.stringify(stmt.notification),
+    `async (${parameter?.name ?? ''}) => ${bodyCode}`,
+    generateParameterSchema(parameter) ?? 'undefined',
+  ];
+  if (stmt.resource) {
+    args.push(generateExpression(stmt.resource));
+  }
+  return `await __onNotification(${args.join(', ')});`;
+}
+
+/**
+ * Generate code for a listen statement
+ * The runtime's __listen() checks the condition, a function so it can be
+ * checked again after each notification, until it holds or the timeout
+ * passes
+ */
+function generateListenStatement(stmt: ListenStatement): string {
+  const until = stmt.until
+    ? `async () => (${generateExpression(stmt.until)})`
+    : 'undefined';
+  const args = stmt.timeout
+    ? [until, generateExpression(stmt.timeout)]
+    : stmt.until
+      ? [until]
+      : [];
+  return `await __listen(${args.join(', ')});`;
+}
+
+/**
+ * Names of the variables a node refers to
+ */
+export function collectIdentifiers(node: unknown, names: Set<string>): void {
+  if (typeof node !== 'object' || node === null) {
+    return;
+  }
+  const { type, name } = node as { type?: unknown; name?: unknown };
+  if (type === 'identifier' && typeof name === 'string') {
+    names.add(name);
+  }
+  Object.values(node).forEach(child => collectIdentifiers(child, names));
+}
+
+/**
+ * Whether a statement registers compensations, so the tool or parallel map
+ * item running it undoes them when it fails
+ */
+export function containsCompensation(node: unknown): boolean {
+  if (typeof node !== 'object' || node === null) {
+    return false;
+  }
+  if ((node as { type?: unknown }).type === 'compensated_statement') {
+    return true;
+  }
+  return Object.values(node).some(containsCompensation);
+}
+
+/**
+ * Name of the tool an expression calls, such as "slack.post", for messages
+ */
+function callLabel(expr: Expression): string {
+  if (expr.type !== 'call') {
+    return 'call';
+  }
+  const { callee } = expr;
+  if (callee.type === 'identifier') {
+    return callee.name;
+  }
+  if (callee.type === 'member' && callee.object.type === 'identifier') {
+    return `${callee.object.name}.${callee.property}`;
+  }
+  return 'call';
+}
+
 /**
  * Generate code for a break statement
  */
@@ -346,7 +720,7 @@ function generateStatementAsBlock(
 /**
  * Indent code by adding the given prefix to each line
  */
-function indentCode(code: string, indent: string): string {
+export function indentCode(code: string, indent: string): string {
   return code
     .split('\n')
     .map(line => (line.trim() ? `${indent}${line}` : line))
@@ -0,0 +1,219 @@
+// Grammar test corpus runner
+//
+// Corpus files are the ones `tree-sitter test` reads from test/corpus:
+// examples of a title between lines of `===`, the input, a line of `---`
+// and the syntax tree the input should parse to, as an S-expression of its
+// named nodes. runCorpus() checks every example of a directory against a
+// parser, so the grammar's tests run with the unit tests, and a fork of the
+// grammar checks its changes against the same examples from its own code,
+// with its own parser. As with tree-sitter, whitespace of the trees does
+// not matter, field names are only compared when the expected tree has
+// them, and examples with the :error attribute pass when the input has a
+// syntax error; :skip leaves an example out.
+import { readdirSync, readFileSync } from 'fs';
+import { join } from 'path';
+import { syntaxTreeToSExpression } from './serialize.js';
+import { parseTree, type SyntaxTree } from './syntax.js';
+
+/**
+ * An example of a corpus file
+ */
+export interface CorpusExample {
+  /** Title of the example */
+  name: string;
+  /** File the example is in */
+  file: string;
+  /** 1-based line of the example's header */
+  line: number;
+  input: string;
+  /** Expected syntax tree, with its whitespace normalized */
+  expected: string;
+  /** The input is expected to have a syntax error (:error) */
+  error?: boolean;
+  /** The example is not run (:skip) */
+  skip?: boolean;
+}
+
+/**
+ * An example whose input did not parse to the expected tree
+ */
+export interface CorpusFailure {
+  example: CorpusExample;
+  /** The tree the input parsed to, normalized as the expected one */
+  actual: string;
+}
+
+export interface CorpusReport {
+  /** Examples run, in file and then source order */
+  examples: CorpusExample[];
+  failures: CorpusFailure[];
+  skipped: CorpusExample[];
+}
+
+export interface CorpusOptions {
+  /**
+   * Parse an input into a syntax tree (default: this grammar's parser);
+   * forks of the grammar pass their own
+   */
+  parse?: (input: string) => SyntaxTree;
+  /** Only run the examples whose title includes this text */
+  filter?: string;
+}
+
+const HEADER = /^(={3,})(\S*)\s*$/;
+const ATTRIBUTE = /^:(\w+)\s*$/;
+
+/**
+ * A syntax tree S-expression with its whitespace and comments normalized,
+ * so trees written over several lines compare as equal
+ */
+export function normalizeSExpression(text: string): string {
+  return text
+    .split('\n')
+    .map(line => line.replace(/^\s*;.*$/, ''))
+    .join(' ')
+    .replace(/\s+/g, ' ')
+    .replace(/\(\s+/g, '(')
+    .replace(/\s+\)/g, ')')
+    .trim();
+}
+
+/**
+ * Read the examples of a corpus file
+ */
+export function parseCorpus(content: string, file = ''): CorpusExample[] {
+  const lines = content.split(/\r?\n/);
+  const examples: CorpusExample[] = [];
+  let index = 0;
+  while (index < lines.length) {
+    const header = HEADER.exec(lines[index]);
+    if (!header) {
+      index++;
+      continue;
+    }
+    const line = index + 1;
+    // A suffix after the ='s, as in ===|||, marks the example's own
+    // delimiters, so its input may hold lines of = and - itself
+    const suffix = header[2];
+    const isClosing = (text: string) => HEADER.exec(text)?.[2] === suffix;
+
+    const title: string[] = [];
+    const attributes = new Set<string>();
+    index++;
+    while (index < lines.length && !isClosing(lines[index])) {
+      const attribute = ATTRIBUTE.exec(lines[index]);
+      if (attribute) {
+        attributes.add(attribute[1]);
+      } else if (lines[index].trim()) {
+        title.push(lines[index].trim());
+      }
+      index++;
+    }
+    if (index >= lines.length) {
+      throw new Error(`${file}:${line}: unterminated example header`);
+    }
+    index++;
+
+    const divider = new RegExp(`^-{3,}${escapeRegExp(suffix)}\\s*$`);
+    const start = index;
+    while (index < lines.length && !divider.test(lines[index])) {
+      index++;
+    }
+    if (index >= lines.length) {
+      throw new Error(`${file}:${line}: example has no --- line`);
+    }
+    const input = lines.slice(start, index).join('\n').replace(/\n+$/, '');
+    index++;
+
+    // Trees hold no lines of ='s, so the next one starts the next example
+    const expectedStart = index;
+    while (index < lines.length && !HEADER.test(lines[index])) {
+      index++;
+    }
+    examples.push({
+      name: title.join(' '),
+      file,
+      line,
+      input: input.replace(/^\n+/, ''),
+      expected: normalizeSExpression(
+        lines.slice(expectedStart, index).join('\n')
+      ),
+      ...(attributes.has('error') && { error: true }),
+      ...(attributes.has('skip') && { skip: true }),
+    });
+  }
+  return examples;
+}
+
+function escapeRegExp(text: string): string {
+  return text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+}
+
+/**
+ * Run one example, returning the tree its input parsed to when that is
+ * not the expected one
+ */
+function runExample(
+  example: CorpusExample,
+  parse: (input: string) => SyntaxTree
+): string | undefined {
+  const tree = parse(example.input);
+  if (example.error) {
+    return tree.rootNode.hasError ? undefined : '(no syntax error)';
+  }
+  let actual = normalizeSExpression(
+    syntaxTreeToSExpression(tree, { positions: false })
+  );
+  // Trees written without field names match trees with any
+  if (!/\w+: \(/.test(example.expected)) {
+    actual = actual.replace(/\w+: \(/g, '(');
+  }
+  return actual === example.expected ? undefined : actual;
+}
+
+/**
+ * Parse the examples of every corpus file of a directory (.txt files, as
+ * tree-sitter reads them) and compare the trees with the expected ones
+ */
+export function runCorpus(
+  dir: string,
+  options: CorpusOptions = {}
+): CorpusReport {
+  const parse = options.parse ?? (input => parseTree(input));
+  const report: CorpusReport = { examples: [], failures: [], skipped: [] };
+  const files = readdirSync(dir)
+    .filter(name => name.endsWith('.txt'))
+    .sort();
+  for (const name of files) {
+    const file = join(dir, name);
+    for (const example of parseCorpus(readFileSync(file, 'utf-8'), file)) {
+      if (options.filter && !example.name.includes(options.filter)) {
+        continue;
+      }
+      if (example.skip) {
+        report.skipped.push(example);
+        continue;
+      }
+      report.examples.push(example);
+      const actual = runExample(example, parse);
+      if (actual !== undefined) {
+        report.failures.push({ example, actual });
+      }
+    }
+  }
+  return report;
+}
+
+/**
+ * The failures of a corpus run, with the expected and actual tree of each
+ */
+export function formatCorpusFailures(report: CorpusReport): string {
+  return report.failures
+    .map(
+      ({ example, actual }) =>
+        `${example.file}:${example.line}: ${example.name}\n` +
+        `  expected: ${example.expected}\n` +
+        `  actual:   ${actual}`
+    )
+    .join('\n\n');
+}
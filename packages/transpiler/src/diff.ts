@@ -0,0 +1,811 @@
+// Structural diff of two versions of a script, for reviewing changes
+import type {
+  CallExpression,
+  Expression,
+  ObjectLiteral,
+  PipelineDeclaration,
+  PipelineStage,
+  Statement,
+  ToolDeclaration,
+  ToolParameter,
+  TriggerDeclaration,
+  TypeExpression,
+} from './ast.js';
+import { getLocation, type SourceLocation } from './locations.js';
+
+/**
+ * - added/removed: a declaration, parameter, property or statement
+ * - changed: a value, argument list, condition or type
+ * - retargeted: a call now goes to another tool
+ */
+export type ScriptChangeKind = 'added' | 'removed' | 'changed' | 'retargeted';
+
+export interface ScriptChange {
+  kind: ScriptChangeKind;
+  /** Declaration the change is in, e.g. "tool greet" or "mcp fs" */
+  scope?: string;
+  message: string;
+  /** Where the changed node was in the old script */
+  before?: SourceLocation;
+  /** Where the changed node is in the new script */
+  after?: SourceLocation;
+}
+
+type Declaration = Extract<Statement, { name: string }>;
+
+const DECLARATION_KEYWORDS: Record<Declaration['type'], string> = {
+  mcp_declaration: 'mcp',
+  model_declaration: 'model',
+  agent_declaration: 'agent',
+  prompt_declaration: 'prompt',
+  trigger_declaration: 'trigger',
+  tool_declaration: 'tool',
+  pipeline_declaration: 'pipeline',
+};
+
+const PRECEDENCE: Record<string, number> = {
+  '|': 1,
+  '??': 2,
+  '||': 3,
+  '&&': 4,
+  '==': 5,
+  '!=': 5,
+  '<': 6,
+  '>': 6,
+  '<=': 6,
+  '>=': 6,
+  '+': 7,
+  '-': 7,
+  '*': 8,
+  '/': 8,
+  '%': 8,
+};
+
+/**
+ * Print an expression back as script source, on one line
+ */
+export function printExpression(expr: Expression, parent = 0): string {
+  switch (expr.type) {
+    case 'identifier':
+      return expr.name;
+    case 'string':
+      return JSON.stringify(expr.value);
+    case 'number':
+    case 'boolean':
+      return String(expr.value);
+    case 'array':
+      return `[${expr.elements.map(e => printExpression(e)).join(', ')}]`;
+    case 'object':
+      return printObject(expr);
+    case 'call': {
+      const options = expr.options ? ` with ${printObject(expr.options)}` : '';
+      return `${printExpression(expr.callee, 9)}(${printArguments(expr)})${options}`;
+    }
+    case 'member':
+      return `${printExpression(expr.object, 9)}.${expr.property}`;
+    case 'bracket':
+      return `${printExpression(expr.object, 9)}[${printExpression(expr.index)}]`;
+    case 'unary':
+      return `${expr.operator}${printExpression(expr.operand, 9)}`;
+    case 'binary': {
+      const precedence = PRECEDENCE[expr.operator];
+      const text = `${printExpression(expr.left, precedence)} ${expr.operator} ${printExpression(expr.right, precedence + 1)}`;
+      return precedence < parent ? `(${text})` : text;
+    }
+  }
+}
+
+function printObject(object: ObjectLiteral): string {
+  if (object.properties.length === 0) {
+    return '{}';
+  }
+  const properties = object.properties.map(
+    property => `${property.key}: ${printExpression(property.value)}`
+  );
+  return `{ ${properties.join(', ')} }`;
+}
+
+function printArguments(call: CallExpression): string {
+  return call.arguments.map(arg => printExpression(arg)).join(', ');
+}
+
+function printType(type: TypeExpression | undefined): string {
+  switch (type?.type) {
+    case undefined:
+      return 'any';
+    case 'primitive_type':
+      return type.value;
+    case 'array_type': {
+      const element = printType(type.elementType);
+      return type.elementType.type === 'union_type'
+        ? `(${element})[]`
+        : `${element}[]`;
+    }
+    case 'object_type': {
+      const properties = type.properties.map(
+        property =>
+          `${property.name}${property.optional ? '?' : ''}: ${printType(property.typeAnnotation)}`
+      );
+      return `{ ${properties.join(', ')} }`;
+    }
+    case 'union_type':
+      return type.types.map(printType).join(' | ');
+  }
+}
+
+function printParameter(parameter: ToolParameter): string {
+  const optional = parameter.optional ? '?' : '';
+  const value = parameter.defaultValue
+    ? ` = ${printExpression(parameter.defaultValue)}`
+    : '';
+  return `${parameter.name}${optional}: ${printType(parameter.typeAnnotation)}${value}`;
+}
+
+function printStage(stage: PipelineStage): string {
+  switch (stage.type) {
+    case 'call':
+      return printExpression(stage);
+    case 'conditional_stage': {
+      const otherwise = stage.else ? ` else ${printStage(stage.else)}` : '';
+      return `if (${printExpression(stage.condition)}) ${printStage(stage.then)}${otherwise}`;
+    }
+    case 'fan_out_stage': {
+      const branches = stage.branches
+        .map(branch => `${branch.name}: ${printStage(branch.stage)}`)
+        .join(', ');
+      return `parallel { ${branches} }`;
+    }
+    case 'each_stage': {
+      const limit = stage.limit
+        ? `, limit: ${printExpression(stage.limit)}`
+        : '';
+      return `each(${printExpression(stage.items)}${limit}) { ${stage.item} => ${printStage(stage.stage)} }`;
+    }
+  }
+}
+
+/**
+ * The first line of a statement, enough to recognize it in a review
+ */
+function describeStatement(statement: Statement): string {
+  switch (statement.type) {
+    case 'import_statement':
+      return `import ${JSON.stringify(statement.path)}`;
+    case 'mcp_declaration':
+    case 'model_declaration':
+    case 'agent_declaration':
+    case 'prompt_declaration':
+    case 'trigger_declaration':
+    case 'tool_declaration':
+    case 'pipeline_declaration':
+      return `${DECLARATION_KEYWORDS[statement.type]} ${statement.name}`;
+    case 'event_handler': {
+      const handler = `on event(${JSON.stringify(statement.event)})`;
+      return statement.parameter
+        ? `${handler} (${printParameter(statement.parameter)})`
+        : handler;
+    }
+    case 'notification_handler': {
+      const resource = statement.resource
+        ? `, ${printExpression(statement.resource)}`
+        : '';
+      const handler = `on notification(${statement.server}, ${JSON.stringify(statement.notification)}${resource})`;
+      return statement.parameter
+        ? `${handler} (${printParameter(statement.parameter)})`
+        : handler;
+    }
+    case 'test_declaration':
+      return `test ${JSON.stringify(statement.name)}`;
+    case 'test_hook':
+      return statement.hook;
+    case 'fixture_declaration':
+      return `fixture ${statement.name} = ${printExpression(statement.value)}`;
+    case 'assignment':
+      return `${printExpression(statement.target)} = ${printExpression(statement.value)}`;
+    case 'expression_statement':
+      return printExpression(statement.expression);
+    case 'block_statement':
+      return '{ ... }';
+    case 'if_statement':
+      return `if (${printExpression(statement.condition)})`;
+    case 'while_statement':
+      return `while (${printExpression(statement.condition)})`;
+    case 'for_statement': {
+      const parts = [
+        statement.init && describeStatement(statement.init),
+        statement.condition && printExpression(statement.condition),
+        statement.update && describeStatement(statement.update),
+      ];
+      return `for (${parts.map(part => part ?? '').join('; ')})`;
+    }
+    case 'parallel_statement':
+      return statement.limit
+        ? `parallel (${printExpression(statement.limit)})`
+        : 'parallel';
+    case 'parallel_map_statement': {
+      const limit = statement.limit
+        ? `, limit: ${printExpression(statement.limit)}`
+        : '';
+      const map = `parallelMap(${printExpression(statement.items)}${limit}) { ${statement.item} => ... }`;
+      return statement.target
+        ? `${printExpression(statement.target)} = ${map}`
+        : map;
+    }
+    case 'annotated_statement': {
+      const { annotation, statement: annotated } = statement;
+      const args = annotation.arguments
+        .map(arg => `${arg.key}: ${printExpression(arg.value)}`)
+        .join(', ');
+      const list = annotation.arguments.length > 0 ? `(${args})` : '';
+      return `@${annotation.name}${list} ${describeStatement(annotated)}`;
+    }
+    case 'compensated_statement':
+      return `${describeStatement(statement.statement)} compensate { ... }`;
+    case 'listen_statement': {
+      const options = [
+        statement.until && `until: ${printExpression(statement.until)}`,
+        statement.timeout && `timeout: ${printExpression(statement.timeout)}`,
+      ];
+      return `listen(${options.filter(Boolean).join(', ')})`;
+    }
+    case 'break_statement':
+      return 'break';
+    case 'continue_statement':
+      return 'continue';
+    case 'return_statement':
+      return statement.value
+        ? `return ${printExpression(statement.value)}`
+        : 'return';
+    case 'comment':
+      return statement.text;
+  }
+}
+
+/**
+ * Locations live outside the nodes, so equal structure means equal JSON
+ */
+function same(a: unknown, b: unknown): boolean {
+  return JSON.stringify(a) === JSON.stringify(b);
+}
+
+function isDeclaration(statement: Statement): statement is Declaration {
+  return statement.type in DECLARATION_KEYWORDS;
+}
+
+function declarationKey(declaration: Declaration): string {
+  return `${DECLARATION_KEYWORDS[declaration.type]} ${declaration.name}`;
+}
+
+/**
+ * Calls in an expression or statement, outermost and leftmost first
+ */
+function collectCalls(node: unknown, calls: CallExpression[] = []) {
+  if (Array.isArray(node)) {
+    node.forEach(child => collectCalls(child, calls));
+  } else if (node && typeof node === 'object') {
+    if ((node as Expression).type === 'call') {
+      calls.push(node as CallExpression);
+    }
+    Object.values(node).forEach(child => collectCalls(child, calls));
+  }
+  return calls;
+}
+
+/**
+ * A statement with its calls reduced to placeholders, to compare what
+ * surrounds them
+ */
+function withoutCalls(node: unknown): unknown {
+  if (Array.isArray(node)) {
+    return node.map(withoutCalls);
+  }
+  if (node && typeof node === 'object') {
+    if ((node as Expression).type === 'call') {
+      return { type: 'call' };
+    }
+    return Object.fromEntries(
+      Object.entries(node).map(([key, value]) => [key, withoutCalls(value)])
+    );
+  }
+  return node;
+}
+
+/**
+ * Bodies of compound statements, which are diffed statement by statement
+ */
+function bodyOf(statement: Statement): Statement[] | undefined {
+  switch (statement.type) {
+    case 'block_statement':
+      return statement.statements;
+    case 'while_statement':
+    case 'for_statement':
+      return bodyOf(statement.body) ?? [statement.body];
+    case 'parallel_statement':
+      return statement.branches;
+    case 'parallel_map_statement':
+      return statement.body;
+    case 'compensated_statement':
+      return statement.compensation.statements;
+    case 'event_handler':
+    case 'notification_handler':
+    case 'test_declaration':
+    case 'test_hook':
+      return statement.body.statements;
+    default:
+      return undefined;
+  }
+}
+
+/**
+ * Pairs of indexes of equal statements, by longest common subsequence
+ */
+function matchStatements(
+  before: Statement[],
+  after: Statement[]
+): [number, number][] {
+  const keys = (statements: Statement[]) =>
+    statements.map(statement => JSON.stringify(statement));
+  const [a, b] = [keys(before), keys(after)];
+  const lengths = Array.from({ length: a.length + 1 }, () =>
+    new Array<number>(b.length + 1).fill(0)
+  );
+  for (let i = a.length - 1; i >= 0; i--) {
+    for (let j = b.length - 1; j >= 0; j--) {
+      lengths[i][j] =
+        a[i] === b[j]
+          ? lengths[i + 1][j + 1] + 1
+          : Math.max(lengths[i + 1][j], lengths[i][j + 1]);
+    }
+  }
+  const pairs: [number, number][] = [];
+  let [i, j] = [0, 0];
+  while (i < a.length && j < b.length) {
+    if (a[i] === b[j]) {
+      pairs.push([i++, j++]);
+    } else if (lengths[i + 1][j] >= lengths[i][j + 1]) {
+      i++;
+    } else {
+      j++;
+    }
+  }
+  return pairs;
+}
+
+class ScriptDiff {
+  readonly changes: ScriptChange[] = [];
+
+  private add(
+    kind: ScriptChangeKind,
+    scope: string | undefined,
+    message: string,
+    before?: object,
+    after?: object
+  ) {
+    this.changes.push({
+      kind,
+      ...(scope && { scope }),
+      message,
+      ...(before && { before: getLocation(before) }),
+      ...(after && { after: getLocation(after) }),
+    });
+  }
+
+  program(before: Statement[], after: Statement[]) {
+    const declarations = new Map<string, Declaration>();
+    for (const statement of before) {
+      if (isDeclaration(statement)) {
+        declarations.set(declarationKey(statement), statement);
+      }
+    }
+    const kept = new Set<string>();
+    for (const statement of after) {
+      if (!isDeclaration(statement)) {
+        continue;
+      }
+      const key = declarationKey(statement);
+      const old = declarations.get(key);
+      if (old) {
+        kept.add(key);
+        this.declaration(key, old, statement);
+      } else {
+        this.add('added', undefined, `Added ${key}`, undefined, statement);
+      }
+    }
+    for (const [key, declaration] of declarations) {
+      if (!kept.has(key)) {
+        this.add('removed', undefined, `Removed ${key}`, declaration);
+      }
+    }
+
+    // Declarations are hoisted, so only the order of the rest matters
+    const script = (statements: Statement[]) =>
+      statements.filter(
+        statement => !isDeclaration(statement) && statement.type !== 'comment'
+      );
+    this.statements(undefined, script(before), script(after));
+  }
+
+  private declaration(key: string, before: Declaration, after: Declaration) {
+    if (same(before, after)) {
+      return;
+    }
+    if (
+      before.type === 'tool_declaration' &&
+      after.type === 'tool_declaration'
+    ) {
+      this.tool(key, before, after);
+    } else if (
+      before.type === 'pipeline_declaration' &&
+      after.type === 'pipeline_declaration'
+    ) {
+      this.pipeline(key, before, after);
+    } else if (
+      before.type === 'trigger_declaration' &&
+      after.type === 'trigger_declaration'
+    ) {
+      this.trigger(key, before, after);
+    } else if ('config' in before && 'config' in after) {
+      this.object(key, before.config, after.config);
+    }
+  }
+
+  private tool(key: string, before: ToolDeclaration, after: ToolDeclaration) {
+    this.signature(key, before, after);
+    const body = (tool: ToolDeclaration) =>
+      tool.body.statements.filter(statement => statement.type !== 'comment');
+    this.statements(key, body(before), body(after));
+  }
+
+  /**
+   * Steps of a pipeline, matched by name, and its result
+   */
+  private pipeline(
+    key: string,
+    before: PipelineDeclaration,
+    after: PipelineDeclaration
+  ) {
+    this.signature(key, before, after);
+    const steps = new Map(before.steps.map(step => [step.name, step]));
+    for (const step of after.steps) {
+      const old = steps.get(step.name);
+      steps.delete(step.name);
+      if (!old) {
+        this.add(
+          'added',
+          key,
+          `Added step ${step.name} = ${printStage(step.stage)}`,
+          undefined,
+          step
+        );
+      } else if (!same(old, step)) {
+        this.add(
+          'changed',
+          key,
+          `Changed step ${step.name} from ${printStage(old.stage)} to ${printStage(step.stage)}`,
+          old,
+          step
+        );
+      }
+    }
+    for (const step of steps.values()) {
+      this.add(
+        'removed',
+        key,
+        `Removed step ${step.name} = ${printStage(step.stage)}`,
+        step
+      );
+    }
+    if (!same(before.output, after.output)) {
+      const result = (pipeline: PipelineDeclaration) =>
+        pipeline.output ? printExpression(pipeline.output) : 'null';
+      this.add(
+        'changed',
+        key,
+        `Changed result from ${result(before)} to ${result(after)}`,
+        before,
+        after
+      );
+    }
+  }
+
+  /**
+   * Parameters and return type of a tool or pipeline
+   */
+  private signature(
+    key: string,
+    before: ToolDeclaration | PipelineDeclaration,
+    after: ToolDeclaration | PipelineDeclaration
+  ) {
+    const parameters = new Map(
+      before.parameters.map(parameter => [parameter.name, parameter])
+    );
+    for (const parameter of after.parameters) {
+      const old = parameters.get(parameter.name);
+      parameters.delete(parameter.name);
+      if (!old) {
+        this.add(
+          'added',
+          key,
+          `Added parameter ${printParameter(parameter)}`,
+          undefined,
+          after
+        );
+      } else if (!same(old, parameter)) {
+        this.add(
+          'changed',
+          key,
+          `Changed parameter ${printParameter(old)} to ${printParameter(parameter)}`,
+          before,
+          after
+        );
+      }
+    }
+    for (const parameter of parameters.values()) {
+      this.add(
+        'removed',
+        key,
+        `Removed parameter ${printParameter(parameter)}`,
+        before
+      );
+    }
+    // Parameters are positional, so kept ones moving is a change too
+    const kept = (
+      tool: ToolDeclaration | PipelineDeclaration,
+      other: ToolDeclaration | PipelineDeclaration
+    ) =>
+      tool.parameters
+        .map(parameter => parameter.name)
+        .filter(name => other.parameters.some(p => p.name === name));
+    if (!same(kept(before, after), kept(after, before))) {
+      this.add('changed', key, 'Reordered parameters', before, after);
+    }
+    if (!same(before.returnType, after.returnType)) {
+      this.add(
+        'changed',
+        key,
+        `Changed return type from ${printType(before.returnType)} to ${printType(after.returnType)}`,
+        before,
+        after
+      );
+    }
+  }
+
+  /**
+   * What fires a trigger and what it does
+   */
+  private trigger(
+    key: string,
+    before: TriggerDeclaration,
+    after: TriggerDeclaration
+  ) {
+    if (!same(before.source, after.source)) {
+      this.add(
+        'changed',
+        key,
+        `Changed source from ${printExpression(before.source)} to ${printExpression(after.source)}`,
+        before.source,
+        after.source
+      );
+    }
+    if (!same(before.action, after.action)) {
+      this.add(
+        'changed',
+        key,
+        `Changed action from ${printExpression(before.action)} to ${printExpression(after.action)}`,
+        before.action,
+        after.action
+      );
+    }
+  }
+
+  /**
+   * Properties of an mcp, model or agent configuration
+   */
+  private object(key: string, before: ObjectLiteral, after: ObjectLiteral) {
+    const properties = new Map(
+      before.properties.map(property => [property.key, property])
+    );
+    for (const property of after.properties) {
+      const old = properties.get(property.key);
+      properties.delete(property.key);
+      const value = printExpression(property.value);
+      if (!old) {
+        this.add(
+          'added',
+          key,
+          `Added ${property.key}: ${value}`,
+          undefined,
+          property
+        );
+      } else if (!same(old.value, property.value)) {
+        this.add(
+          'changed',
+          key,
+          `Changed ${property.key} from ${printExpression(old.value)} to ${value}`,
+          old,
+          property
+        );
+      }
+    }
+    for (const property of properties.values()) {
+      this.add(
+        'removed',
+        key,
+        `Removed ${property.key}: ${printExpression(property.value)}`,
+        property
+      );
+    }
+  }
+
+  /**
+   * Diff two lists of statements, pairing up statements of the same type
+   * that were edited in place
+   */
+  private statements(
+    scope: string | undefined,
+    before: Statement[],
+    after: Statement[]
+  ) {
+    const pairs = matchStatements(before, after);
+    let [i, j] = [0, 0];
+    for (const [nextI, nextJ] of [
+      ...pairs,
+      [before.length, after.length] as const,
+    ]) {
+      while (i < nextI && j < nextJ && before[i].type === after[j].type) {
+        this.statement(scope, before[i++], after[j++]);
+      }
+      for (; i < nextI; i++) {
+        this.add(
+          'removed',
+          scope,
+          `Removed ${describeStatement(before[i])}`,
+          before[i]
+        );
+      }
+      for (; j < nextJ; j++) {
+        this.add(
+          'added',
+          scope,
+          `Added ${describeStatement(after[j])}`,
+          undefined,
+          after[j]
+        );
+      }
+      [i, j] = [nextI + 1, nextJ + 1];
+    }
+  }
+
+  /**
+   * Two different statements of the same type in the same place
+   */
+  private statement(
+    scope: string | undefined,
+    before: Statement,
+    after: Statement
+  ) {
+    const beforeBody = bodyOf(before);
+    const afterBody = bodyOf(after);
+    if (beforeBody && afterBody) {
+      const header = (statement: Statement) =>
+        withoutCalls({ ...statement, body: [], branches: [], statements: [] });
+      if (!same(header(before), header(after))) {
+        this.add(
+          'changed',
+          scope,
+          `Changed ${describeStatement(before)} to ${describeStatement(after)}`,
+          before,
+          after
+        );
+      }
+      this.statements(scope, beforeBody, afterBody);
+      return;
+    }
+    if (before.type === 'if_statement' && after.type === 'if_statement') {
+      if (!same(before.condition, after.condition)) {
+        this.add(
+          'changed',
+          scope,
+          `Changed ${describeStatement(before)} to ${describeStatement(after)}`,
+          before,
+          after
+        );
+      }
+      const branch = (statement?: Statement) =>
+        statement ? (bodyOf(statement) ?? [statement]) : [];
+      this.statements(scope, branch(before.then), branch(after.then));
+      this.statements(scope, branch(before.else), branch(after.else));
+      return;
+    }
+
+    const beforeCalls = collectCalls(before);
+    const afterCalls = collectCalls(after);
+    if (
+      beforeCalls.length === 0 ||
+      beforeCalls.length !== afterCalls.length ||
+      !same(withoutCalls(before), withoutCalls(after))
+    ) {
+      this.add(
+        'changed',
+        scope,
+        `Changed ${describeStatement(before)} to ${describeStatement(after)}`,
+        before,
+        after
+      );
+      return;
+    }
+    // Only calls changed: report what changed about each of them
+    beforeCalls.forEach((call, index) =>
+      this.call(scope, call, afterCalls[index])
+    );
+  }
+
+  private call(
+    scope: string | undefined,
+    before: CallExpression,
+    after: CallExpression
+  ) {
+    const callee = printExpression(after.callee);
+    if (!same(before.callee, after.callee)) {
+      this.add(
+        'retargeted',
+        scope,
+        `Retargeted ${printExpression(before.callee)}(...) to ${callee}(...)`,
+        before,
+        after
+      );
+    }
+    // Calls nested in arguments are compared on their own
+    if (!same(withoutCalls(before.arguments), withoutCalls(after.arguments))) {
+      this.add(
+        'changed',
+        scope,
+        `Changed arguments of ${callee} from (${printArguments(before)}) to (${printArguments(after)})`,
+        before,
+        after
+      );
+    }
+    if (!same(before.options, after.options)) {
+      const options = (call: CallExpression) =>
+        call.options ? printObject(call.options) : 'none';
+      this.add(
+        'changed',
+        scope,
+        `Changed options of ${callee} from ${options(before)} to ${options(after)}`,
+        before,
+        after
+      );
+    }
+  }
+}
+
+/**
+ * Compare two versions of a script by structure rather than by text
+ * Declarations are matched by name wherever they are, other statements by
+ * position. Formatting and comments never show up as changes; a changed
+ * call reports whether it goes to another tool or only got other arguments.
+ */
+export function diffPrograms(
+  before: Statement[],
+  after: Statement[]
+): ScriptChange[] {
+  const diff = new ScriptDiff();
+  diff.program(before, after);
+  return diff.changes;
+}
+
+const CHANGE_SIGNS: Record<ScriptChangeKind, string> = {
+  added: '+',
+  removed: '-',
+  changed: '~',
+  retargeted: '>',
+};
+
+/**
+ * Format a change on one line as "<sign> <scope>: <message>"
+ * The sign is +, -, ~ or > for added, removed, changed and retargeted.
+ */
+export function formatChange(change: ScriptChange): string {
+  const scope = change.scope ? `${change.scope}: ` : '';
+  return `${CHANGE_SIGNS[change.kind]} ${scope}${change.message}`;
+}
@@ -0,0 +1,424 @@
+// Reference documentation of a script, for mcps doc
+//
+// A declaration is documented by the // comment lines directly above it;
+// a blank line ends the comment. The comment a script starts with, when it
+// is not the comment of its first declaration, documents the script itself,
+// which mcps serve offers as a workflow. Which MCP tools each tool, agent
+// and the script use is found by following the calls between them, so a
+// tool that runs an agent uses the tools the agent may call. A tool's
+// comment describes its parameters with lines of the form `@param name
+// description`.
+import type {
+  AgentDeclaration,
+  Expression,
+  MCPDeclaration,
+  ModelDeclaration,
+  ObjectLiteral,
+  Statement,
+  ToolDeclaration,
+} from './ast.js';
+import { getLocation } from './locations.js';
+import { typeToString } from './typecheck.js';
+import { printExpression } from './diff.js';
+
+export interface ServerDoc {
+  name: string;
+  doc?: string;
+  /** Command and arguments of a stdio server, or the URL of a remote one */
+  target?: string;
+  /** Tools of the server used anywhere in the script, "*" for any */
+  tools: string[];
+}
+
+export interface ModelDoc {
+  name: string;
+  doc?: string;
+  provider?: string;
+  model?: string;
+}
+
+export interface AgentDoc {
+  name: string;
+  /** Doc comment, or the agent's description when it has none */
+  doc?: string;
+  model?: string;
+  /** Agents it may delegate to */
+  delegates: string[];
+  /** Tools declared in the script that it may call */
+  tools: string[];
+  /** MCP tools it may call, itself or through its tools and sub-agents */
+  mcpTools: string[];
+}
+
+export interface ParameterDoc {
+  name: string;
+  optional: boolean;
+  type?: string;
+  /** Default value, as written in the signature */
+  default?: string;
+  /** Description from the tool's @param line for the parameter */
+  doc?: string;
+}
+
+export interface ToolDoc {
+  name: string;
+  doc?: string;
+  /** Signature as written, e.g. summarize(path: string, limit?: number) */
+  signature: string;
+  parameters: ParameterDoc[];
+  returnType?: string;
+  /** MCP tools it calls, itself or through the tools and agents it uses */
+  mcpTools: string[];
+}
+
+export interface ScriptDocs {
+  /** Comment the script starts with */
+  doc?: string;
+  servers: ServerDoc[];
+  models: ModelDoc[];
+  agents: AgentDoc[];
+  tools: ToolDoc[];
+  /** MCP tools a run of the script calls, as "server.tool" or "server.*" */
+  mcpTools: string[];
+}
+
+const COMMENT_LINE = /^\s*\/\/ ?(.*)$/;
+
+const PARAM_LINE = /^@param\s+(\w+)\s*(.*)$/;
+
+/**
+ * The comment lines directly above a line of the source (1-based), or
+ * undefined when there are none
+ */
+export function docComment(lines: string[], line: number): string | undefined {
+  const comment: string[] = [];
+  for (let index = line - 2; index >= 0; index--) {
+    const match = COMMENT_LINE.exec(lines[index]);
+    if (!match) {
+      break;
+    }
+    comment.unshift(match[1].trimEnd());
+  }
+  return trimComment(comment);
+}
+
+/**
+ * Split a tool's doc comment into its text and the descriptions of its
+ * parameters, by name
+ */
+export function parameterDocs(comment: string | undefined): {
+  doc?: string;
+  parameters: Map<string, string>;
+} {
+  const parameters = new Map<string, string>();
+  const lines = (comment ?? '').split('\n').filter(line => {
+    const match = PARAM_LINE.exec(line.trim());
+    if (match) {
+      parameters.set(match[1], match[2].trim());
+    }
+    return !match;
+  });
+  return { doc: trimComment(lines), parameters };
+}
+
+/**
+ * A comment's text without the blank lines around it
+ */
+function trimComment(lines: string[]): string | undefined {
+  const text = lines.join('\n').trim();
+  return text || undefined;
+}
+
+/**
+ * The comment a script starts with, unless it belongs to the declaration
+ * right below it
+ */
+function scriptComment(
+  lines: string[],
+  declarationLines: Set<number>
+): string | undefined {
+  let start = 0;
+  while (start < lines.length && !lines[start].trim()) {
+    start++;
+  }
+  let end = start;
+  while (end < lines.length && COMMENT_LINE.test(lines[end])) {
+    end++;
+  }
+  // lines[end] is on line end + 1
+  if (end === start || declarationLines.has(end + 1)) {
+    return undefined;
+  }
+  return trimComment(
+    lines.slice(start, end).map(line => COMMENT_LINE.exec(line)![1].trimEnd())
+  );
+}
+
+function property(config: ObjectLiteral, key: string): Expression | undefined {
+  return config.properties.find(prop => prop.key === key)?.value;
+}
+
+function text(expr: Expression | undefined): string | undefined {
+  if (expr?.type === 'string') {
+    return expr.value;
+  }
+  if (expr?.type === 'identifier') {
+    return expr.name;
+  }
+  return undefined;
+}
+
+/**
+ * The model of an agent, or the models it routes across in order
+ */
+function modelNames(expr: Expression | undefined): string | undefined {
+  return expr?.type === 'array'
+    ? expr.elements.map(element => text(element) ?? '<dynamic>').join(', ')
+    : text(expr);
+}
+
+function serverTarget(decl: MCPDeclaration): string | undefined {
+  const url = property(decl.config, 'url');
+  if (url) {
+    return text(url);
+  }
+  const command = text(property(decl.config, 'command'));
+  const args = property(decl.config, 'args');
+  const argList =
+    args?.type === 'array'
+      ? args.elements.map(arg => text(arg) ?? '<dynamic>')
+      : [];
+  return command && [command, ...argList].join(' ');
+}
+
+function toolSignature(decl: ToolDeclaration): string {
+  const parameters = decl.parameters.map(
+    p =>
+      `${p.name}${p.optional ? '?' : ''}` +
+      (p.typeAnnotation ? `: ${typeToString(p.typeAnnotation)}` : '') +
+      (p.defaultValue ? ` = ${printExpression(p.defaultValue)}` : '')
+  );
+  const returns = decl.returnType ? `: ${typeToString(decl.returnType)}` : '';
+  return `${decl.name}(${parameters.join(', ')})${returns}`;
+}
+
+/**
+ * What a declaration or the script's own code uses directly
+ */
+interface Uses {
+  /** MCP tools as "server.tool", or "server.*" for a whole server */
+  mcpTools: Set<string>;
+  /** Tools and agents declared in the script */
+  declarations: Set<string>;
+}
+
+/**
+ * Finds the MCP tools and the script's tools and agents that code refers
+ * to, by name
+ */
+class UseCollector {
+  constructor(
+    private readonly servers: Set<string>,
+    private readonly declared: Set<string>
+  ) {}
+
+  collect(node: unknown): Uses {
+    const uses: Uses = { mcpTools: new Set(), declarations: new Set() };
+    this.visit(node, uses);
+    return uses;
+  }
+
+  private visit(node: unknown, uses: Uses): void {
+    if (Array.isArray(node)) {
+      node.forEach(child => this.visit(child, uses));
+      return;
+    }
+    if (!node || typeof node !== 'object') {
+      return;
+    }
+    const expr = node as Expression;
+    if (
+      expr.type === 'member' &&
+      expr.object.type === 'identifier' &&
+      this.servers.has(expr.object.name)
+    ) {
+      // A call such as fs.readFile(...), or a tool given to an agent
+      uses.mcpTools.add(`${expr.object.name}.${expr.property}`);
+      return;
+    }
+    if (expr.type === 'identifier') {
+      if (this.servers.has(expr.name)) {
+        uses.mcpTools.add(`${expr.name}.*`);
+      } else if (this.declared.has(expr.name)) {
+        uses.declarations.add(expr.name);
+      }
+      return;
+    }
+    Object.values(node).forEach(child => this.visit(child, uses));
+  }
+}
+
+/**
+ * MCP tools sorted by name, without the tools of servers that may be used
+ * as a whole
+ */
+function sortTools(tools: Iterable<string>): string[] {
+  const all = new Set(tools);
+  return [...all]
+    .filter(tool => {
+      const server = tool.slice(0, tool.indexOf('.'));
+      return tool.endsWith('.*') || !all.has(`${server}.*`);
+    })
+    .sort();
+}
+
+function declaredNames(
+  statements: Statement[],
+  ...types: Statement['type'][]
+): Set<string> {
+  const names = new Set<string>();
+  for (const statement of statements) {
+    if (types.includes(statement.type) && 'name' in statement) {
+      names.add(statement.name);
+    }
+  }
+  return names;
+}
+
+/**
+ * Collect the reference documentation of a script from its statements
+ * (as parsed, with locations) and source. The statements of the modules it
+ * imports are only used to find the MCP tools it calls through them
+ */
+export function collectDocs(
+  statements: Statement[],
+  source: string,
+  imported: Statement[] = []
+): ScriptDocs {
+  const lines = source.split('\n');
+  const servers: MCPDeclaration[] = [];
+  const models: ModelDeclaration[] = [];
+  const agents: AgentDeclaration[] = [];
+  const tools: ToolDeclaration[] = [];
+  const code: Statement[] = [];
+  const declarationLines = new Set<number>();
+  for (const statement of statements) {
+    switch (statement.type) {
+      case 'mcp_declaration':
+        servers.push(statement);
+        break;
+      case 'model_declaration':
+        models.push(statement);
+        break;
+      case 'agent_declaration':
+        agents.push(statement);
+        break;
+      case 'tool_declaration':
+        tools.push(statement);
+        break;
+      case 'comment':
+      case 'import_statement':
+        continue;
+      default:
+        code.push(statement);
+        continue;
+    }
+    const location = getLocation(statement);
+    if (location) {
+      declarationLines.add(location.start.line);
+    }
+  }
+  const doc = (statement: Statement) => {
+    const location = getLocation(statement);
+    return location && docComment(lines, location.start.line);
+  };
+
+  const visible = [...statements, ...imported];
+  const collector = new UseCollector(
+    declaredNames(visible, 'mcp_declaration'),
+    declaredNames(visible, 'agent_declaration', 'tool_declaration')
+  );
+  const uses = new Map<string, Uses>();
+  for (const statement of visible) {
+    if (statement.type === 'agent_declaration') {
+      const config = property(statement.config, 'tools');
+      uses.set(statement.name, collector.collect(config));
+    } else if (statement.type === 'tool_declaration') {
+      uses.set(statement.name, collector.collect(statement.body));
+    }
+  }
+
+  // MCP tools used through the tools and agents a declaration uses
+  const reachable = (direct: Uses): string[] => {
+    const found = new Set(direct.mcpTools);
+    const seen = new Set<string>();
+    const pending = [...direct.declarations];
+    while (pending.length > 0) {
+      const name = pending.pop()!;
+      if (seen.has(name)) {
+        continue;
+      }
+      seen.add(name);
+      const used = uses.get(name)!;
+      used.mcpTools.forEach(tool => found.add(tool));
+      pending.push(...used.declarations);
+    }
+    return sortTools(found);
+  };
+
+  const scriptTools = reachable(collector.collect(code));
+  const agentNames = declaredNames(visible, 'agent_declaration');
+  const allTools = sortTools([
+    ...[...uses.values()].flatMap(used => [...used.mcpTools]),
+    ...scriptTools,
+  ]);
+
+  return {
+    doc: scriptComment(lines, declarationLines),
+    servers: servers.map(server => ({
+      name: server.name,
+      doc: doc(server),
+      target: serverTarget(server),
+      tools: allTools
+        .filter(tool => tool.startsWith(`${server.name}.`))
+        .map(tool => tool.slice(server.name.length + 1)),
+    })),
+    models: models.map(model => ({
+      name: model.name,
+      doc: doc(model),
+      provider: text(property(model.config, 'provider')),
+      model: text(property(model.config, 'model')),
+    })),
+    agents: agents.map(agent => {
+      const used = [...uses.get(agent.name)!.declarations].sort();
+      return {
+        name: agent.name,
+        doc: doc(agent) ?? text(property(agent.config, 'description')),
+        model: modelNames(property(agent.config, 'model')),
+        delegates: used.filter(name => agentNames.has(name)),
+        tools: used.filter(name => !agentNames.has(name)),
+        mcpTools: reachable(uses.get(agent.name)!),
+      };
+    }),
+    tools: tools.map(tool => {
+      const described = parameterDocs(doc(tool));
+      return {
+        name: tool.name,
+        doc: described.doc,
+        signature: toolSignature(tool),
+        parameters: tool.parameters.map(p => ({
+          name: p.name,
+          optional: p.optional,
+          ...(p.typeAnnotation && { type: typeToString(p.typeAnnotation) }),
+          ...(p.defaultValue && { default: printExpression(p.defaultValue) }),
+          ...(described.parameters.has(p.name) && {
+            doc: described.parameters.get(p.name),
+          }),
+        })),
+        ...(tool.returnType && { returnType: typeToString(tool.returnType) }),
+        mcpTools: reachable(uses.get(tool.name)!),
+      };
+    }),
+    mcpTools: scriptTools,
+  };
+}
@@ -0,0 +1,99 @@
+// Experimental language features
+//
+// New constructs can ship in the grammar before they are stable, behind a
+// feature flag. The grammar always parses them, and once a tree is parsed
+// its nodes of a construct whose feature is not enabled are reported as
+// syntax errors naming the feature, so scripts of projects that did not
+// opt in keep to the stable language, and the construct can still change
+// or go away. Projects enable features with "features" in .mcpsrc. A
+// construct graduates by leaving EXPERIMENTAL_FEATURES.
+import type Parser from 'tree-sitter';
+import { locationOf } from './locations.js';
+import type { SyntaxDiagnostic } from './parser.js';
+
+/**
+ * A construct of the grammar that scripts only use once it is enabled
+ */
+export interface ExperimentalFeature {
+  /** Name projects enable it by */
+  name: string;
+  /** What it adds, as shown in errors */
+  description: string;
+  /** Syntax node types of the construct */
+  nodeTypes: string[];
+}
+
+/**
+ * The experimental features of the grammar; none at present
+ */
+export const EXPERIMENTAL_FEATURES: readonly ExperimentalFeature[] = [];
+
+let enabled = new Set<string>();
+
+/**
+ * Change the features every parse allows; names not in the known features
+ * are refused
+ */
+export function setLanguageFeatures(
+  names: string[],
+  known: readonly ExperimentalFeature[] = EXPERIMENTAL_FEATURES
+): void {
+  const unknown = names.find(name => !known.some(f => f.name === name));
+  if (unknown !== undefined) {
+    throw new Error(
+      known.length > 0
+        ? `Unknown language feature ${unknown}, expected one of ${known.map(f => f.name).join(', ')}`
+        : `Unknown language feature ${unknown}, no features are experimental`
+    );
+  }
+  enabled = new Set(names);
+}
+
+export function languageFeatures(): string[] {
+  return [...enabled];
+}
+
+/**
+ * Errors for the nodes of a tree that belong to features not enabled, in
+ * source order
+ */
+export function featureDiagnostics(
+  tree: Parser.Tree,
+  features: readonly ExperimentalFeature[] = EXPERIMENTAL_FEATURES
+): SyntaxDiagnostic[] {
+  const disabled = new Map<string, ExperimentalFeature>();
+  for (const feature of features) {
+    if (!enabled.has(feature.name)) {
+      for (const type of feature.nodeTypes) {
+        disabled.set(type, feature);
+      }
+    }
+  }
+  if (disabled.size === 0) {
+    return [];
+  }
+
+  const diagnostics: SyntaxDiagnostic[] = [];
+  const cursor = tree.walk();
+  for (;;) {
+    const node = cursor.currentNode;
+    const feature = disabled.get(node.type);
+    if (feature) {
+      diagnostics.push({
+        kind: 'error',
+        message: `${feature.description} is experimental; enable it with "features": ["${feature.name}"] in .mcpsrc`,
+        location: locationOf(node),
+        text: node.text,
+      });
+    }
+    // The nodes of a reported construct are not reported again
+    if (!feature && cursor.gotoFirstChild()) {
+      continue;
+    }
+    while (!cursor.gotoNextSibling()) {
+      if (!cursor.gotoParent()) {
+        return diagnostics;
+      }
+    }
+  }
+}
@@ -0,0 +1,701 @@
+// Canonical source formatter for MCP Script
+import { parseTree, type SyntaxNode, type SyntaxTree } from './syntax.js';
+import { checkSyntax } from './parser.js';
+import { organizeImports } from './imports.js';
+import {
+  type Doc,
+  group,
+  hardline,
+  ifBreak,
+  indent,
+  join,
+  line,
+  print,
+  softline,
+} from './formatter/doc.js';
+
+export interface FormatOptions {
+  /** Line width that lists are kept within when possible (default: 80) */
+  printWidth?: number;
+  /** Spaces per indentation level (default: 2) */
+  indentWidth?: number;
+  /** Sort the imports and import each module once (see organizeImports) */
+  organizeImports?: boolean;
+  /** Specifiers of imports to remove with organizeImports */
+  unusedImports?: readonly string[];
+}
+
+/**
+ * Format MCP Script source code
+ * Throws if the source has syntax errors
+ */
+export function formatSource(
+  content: string,
+  options: FormatOptions = {}
+): string {
+  return formatTree(parseTree(content), content, options);
+}
+
+/**
+ * Format an already parsed syntax tree of the given source
+ * The output only depends on the tree, so formatting is idempotent
+ */
+export function formatTree(
+  tree: SyntaxTree,
+  content: string,
+  options: FormatOptions = {}
+): string {
+  checkSyntax(tree, content);
+  if (options.organizeImports) {
+    const [change] = organizeImports(
+      { path: '', content, tree },
+      options.unusedImports
+    ).edits[''];
+    if (change) {
+      content =
+        content.slice(0, change.location.start.offset) +
+        change.newText +
+        content.slice(change.location.end.offset);
+      tree = parseTree(content);
+    }
+  }
+
+  const doc = formatStatementList(tree.rootNode.namedChildren);
+  const output = print(doc, {
+    width: options.printWidth ?? 80,
+    indentWidth: options.indentWidth ?? 2,
+  });
+  return output.length > 0 ? `${output}\n` : '';
+}
+
+// Nodes that place the comments among their children themselves; any other
+// node containing a comment is kept exactly as written
+const COMMENT_AWARE_NODES = new Set([
+  'source_file',
+  'block_statement',
+  'parallel_statement',
+  'parallel_map_statement',
+  'map_body',
+  'tool_declaration',
+  'pipeline_declaration',
+  'pipeline_body',
+  'fan_out_stage',
+  'call_expression',
+  'array_literal',
+  'object_literal',
+  'object_type',
+]);
+
+// Comma-separated lists whose items are flattened into the enclosing brackets
+const LIST_NODES = new Set([
+  'parameter_list',
+  'argument_list',
+  'property_list',
+  'type_property_list',
+]);
+
+function isComment(node: SyntaxNode): boolean {
+  return node.type === 'comment';
+}
+
+function isCommentStatement(node: SyntaxNode): boolean {
+  return (
+    isComment(node) ||
+    (node.type === 'statement' &&
+      node.namedChildCount === 1 &&
+      isComment(node.firstNamedChild!))
+  );
+}
+
+function childOfType(node: SyntaxNode, type: string): SyntaxNode | undefined {
+  return node.children.find(child => child.type === type);
+}
+
+/**
+ * Format a node, keeping it verbatim if it holds comments it cannot place
+ */
+function format(node: SyntaxNode): Doc {
+  if (!COMMENT_AWARE_NODES.has(node.type) && node.children.some(isComment)) {
+    return verbatim(node);
+  }
+  return formatNode(node);
+}
+
+function verbatim(node: SyntaxNode): Doc {
+  return node.text.trimEnd();
+}
+
+function formatNode(node: SyntaxNode): Doc {
+  switch (node.type) {
+    case 'comment':
+      return node.text.trimEnd();
+
+    // Wrapper nodes with a single child
+    case 'statement':
+    case 'expression_statement':
+    case 'expression':
+    case 'literal':
+    case 'string':
+    case 'assignment_target':
+    case 'type_expression':
+    case 'pipeline_stage':
+      return format(node.firstNamedChild!);
+
+    case 'import_statement':
+      return ['import ', childOfType(node, 'string')!.text];
+
+    case 'mcp_declaration':
+    case 'model_declaration':
+    case 'agent_declaration':
+    case 'prompt_declaration': {
+      const keyword = node.type.replace('_declaration', '');
+      const name = childOfType(node, 'identifier')!;
+      // Configuration blocks always have one property per line
+      const config = formatObject(childOfType(node, 'object_literal')!, true);
+      return [keyword, ' ', name.text, ' ', config];
+    }
+
+    case 'trigger_declaration':
+      return [
+        'trigger ',
+        childOfType(node, 'identifier')!.text,
+        ': ',
+        format(childOfType(node, 'call_expression')!),
+        ' -> ',
+        format(childOfType(node, 'expression')!),
+      ];
+
+    case 'event_handler': {
+      const parameter = childOfType(node, 'parameter');
+      return [
+        'on event(',
+        childOfType(node, 'string')!.text,
+        ')',
+        parameter ? [' (', format(parameter), ')'] : '',
+        ' ',
+        format(childOfType(node, 'block_statement')!),
+      ];
+    }
+
+    case 'test_declaration':
+      return [
+        'test ',
+        childOfType(node, 'string')!.text,
+        ' ',
+        format(childOfType(node, 'block_statement')!),
+      ];
+
+    case 'test_hook':
+      return [
+        node.firstChild!.type,
+        ' ',
+        format(childOfType(node, 'block_statement')!),
+      ];
+
+    case 'fixture_declaration':
+      return [
+        'fixture ',
+        childOfType(node, 'identifier')!.text,
+        ' = ',
+        format(childOfType(node, 'expression')!),
+      ];
+
+    case 'notification_handler': {
+      const resource = childOfType(node, 'expression');
+      const parameter = childOfType(node, 'parameter');
+      return [
+        'on notification(',
+        childOfType(node, 'identifier')!.text,
+        ', ',
+        childOfType(node, 'string')!.text,
+        resource ? [', ', format(resource)] : '',
+        ')',
+        parameter ? [' (', format(parameter), ')'] : '',
+        ' ',
+        format(childOfType(node, 'block_statement')!),
+      ];
+    }
+
+    case 'tool_declaration': {
+      const parameters = listEntries(node, '(', ')');
+      if (!parameters) {
+        return verbatim(node);
+      }
+      const name = childOfType(node, 'identifier')!;
+      const returnType = childOfType(node, 'return_type_annotation');
+      return [
+        'tool ',
+        name.text,
+        formatList('(', ')', parameters),
+        returnType ? format(returnType) : '',
+        ' ',
+        format(childOfType(node, 'block_statement')!),
+      ];
+    }
+
+    case 'pipeline_declaration': {
+      const parameters = listEntries(node, '(', ')');
+      if (!parameters) {
+        return verbatim(node);
+      }
+      const name = childOfType(node, 'identifier')!;
+      const returnType = childOfType(node, 'return_type_annotation');
+      return [
+        'pipeline ',
+        name.text,
+        formatList('(', ')', parameters),
+        returnType ? format(returnType) : '',
+        ' ',
+        format(childOfType(node, 'pipeline_body')!),
+      ];
+    }
+
+    case 'pipeline_body': {
+      // One step per line, then the pipeline's result
+      const steps = node.namedChildren;
+      if (steps.length === 0) {
+        return '{}';
+      }
+      return [
+        '{',
+        indent([hardline, formatStatementList(steps)]),
+        hardline,
+        '}',
+      ];
+    }
+
+    case 'pipeline_step': {
+      const name = childOfType(node, 'identifier')!;
+      return [name.text, ' = ', format(childOfType(node, 'pipeline_stage')!)];
+    }
+
+    case 'pipeline_output':
+      return ['return ', format(childOfType(node, 'expression')!)];
+
+    case 'conditional_stage': {
+      const [condition, consequence, alternative] = node.namedChildren;
+      // The else stage moves to its own line when the stage does not fit
+      return group([
+        'if (',
+        format(condition),
+        ') ',
+        format(consequence),
+        alternative ? indent([line, 'else ', format(alternative)]) : '',
+      ]);
+    }
+
+    case 'fan_out_stage': {
+      const branches = listEntries(node, '{', '}');
+      if (!branches) {
+        return verbatim(node);
+      }
+      return ['parallel ', formatList('{', '}', branches, { spaced: true })];
+    }
+
+    case 'fan_out_branch': {
+      const name = childOfType(node, 'identifier')!;
+      return [name.text, ': ', format(childOfType(node, 'pipeline_stage')!)];
+    }
+
+    case 'each_stage': {
+      const [items, limit] = node.namedChildren.filter(
+        child => child.type === 'expression'
+      );
+      // The stage stays on the line when it fits
+      return [
+        'each(',
+        format(items),
+        limit ? [', limit: ', format(limit)] : '',
+        ') { ',
+        childOfType(node, 'map_item')!.text,
+        ' =>',
+        group([
+          indent([line, format(childOfType(node, 'pipeline_stage')!)]),
+          line,
+          '}',
+        ]),
+      ];
+    }
+
+    case 'parameter':
+    case 'type_property': {
+      const name = childOfType(node, 'identifier')!;
+      const optional = childOfType(node, '?') ? '?' : '';
+      const annotation =
+        childOfType(node, 'type_annotation') ??
+        childOfType(node, 'type_expression');
+      const defaultValue = childOfType(node, 'expression');
+      const value = defaultValue ? [' = ', format(defaultValue)] : '';
+      if (!annotation) {
+        return [name.text, optional, value];
+      }
+      const type =
+        annotation.type === 'type_annotation'
+          ? format(annotation)
+          : [': ', format(annotation)];
+      return [name.text, optional, type, value];
+    }
+
+    case 'type_annotation':
+    case 'return_type_annotation':
+      return [': ', format(childOfType(node, 'type_expression')!)];
+
+    case 'array_type':
+      return [format(node.firstNamedChild!), '[]'];
+
+    case 'union_type':
+      return join(' | ', node.namedChildren.map(format));
+
+    case 'object_type':
+      return formatList('{', '}', listEntries(node, '{', '}')!, {
+        spaced: true,
+      });
+
+    case 'block_statement': {
+      const statements = node.namedChildren;
+      if (statements.length === 0) {
+        return '{}';
+      }
+      return [
+        '{',
+        indent([hardline, formatStatementList(statements)]),
+        hardline,
+        '}',
+      ];
+    }
+
+    case 'if_statement': {
+      const condition = childOfType(node, 'expression')!;
+      const [consequence, alternative] = node.namedChildren.filter(
+        child => child.type === 'statement'
+      );
+      const parts: Doc[] = [
+        'if (',
+        format(condition),
+        ') ',
+        format(consequence),
+      ];
+      if (alternative) {
+        // "else" follows a closing brace, otherwise it starts a new line
+        const isBlock = consequence.firstNamedChild?.type === 'block_statement';
+        parts.push(isBlock ? ' ' : hardline, 'else ', format(alternative));
+      }
+      return parts;
+    }
+
+    case 'while_statement':
+      return [
+        'while (',
+        format(childOfType(node, 'expression')!),
+        ') ',
+        format(childOfType(node, 'statement')!),
+      ];
+
+    case 'for_statement': {
+      // The clauses are told apart by the semicolons between them
+      const clauses: (SyntaxNode | undefined)[] = [];
+      let section = 0;
+      for (const child of node.children) {
+        if (child.type === ';') {
+          section++;
+        } else if (child.type === 'assignment' || child.type === 'expression') {
+          clauses[section] = child;
+        }
+      }
+      const [init, condition, update] = clauses;
+      return [
+        'for (',
+        init ? format(init) : '',
+        ';',
+        condition ? [' ', format(condition)] : '',
+        ';',
+        update ? [' ', format(update)] : '',
+        ') ',
+        format(childOfType(node, 'statement')!),
+      ];
+    }
+
+    case 'parallel_statement': {
+      const limit = childOfType(node, 'expression');
+      const statements = node.namedChildren.filter(
+        child => child.type !== 'expression'
+      );
+      const body: Doc =
+        statements.length === 0
+          ? '{}'
+          : [
+              '{',
+              indent([hardline, formatStatementList(statements)]),
+              hardline,
+              '}',
+            ];
+      return ['parallel', limit ? [' (', format(limit), ')'] : '', ' ', body];
+    }
+
+    case 'parallel_map_statement': {
+      const target = childOfType(node, 'assignment_target');
+      const [items, limit] = node.namedChildren.filter(
+        child => child.type === 'expression'
+      );
+      const body = childOfType(node, 'map_body')!;
+      const item = childOfType(body, 'map_item')!;
+      const statements = body.namedChildren.filter(
+        child => child.type === 'statement' || child.type === 'comment'
+      );
+      const head = [
+        target ? [format(target), ' = '] : '',
+        'parallelMap(',
+        format(items),
+        limit ? [', limit: ', format(limit)] : '',
+        ') { ',
+        item.text,
+        ' =>',
+      ];
+      // A body of one statement stays on the line when it fits
+      const rest: Doc =
+        statements.length === 0
+          ? ' }'
+          : statements.length === 1 && statements[0].type === 'statement'
+            ? group([indent([line, format(statements[0])]), line, '}'])
+            : [
+                indent([hardline, formatStatementList(statements)]),
+                hardline,
+                '}',
+              ];
+      return [head, rest];
+    }
+
+    case 'annotated_statement': {
+      // The annotation goes on its own line above the statement
+      const [annotation, statement] = node.namedChildren;
+      return [format(annotation), hardline, format(statement)];
+    }
+
+    case 'compensated_statement': {
+      const [statement, block] = node.namedChildren;
+      return [format(statement), ' compensate ', format(block)];
+    }
+
+    case 'listen_statement': {
+      // The option an expression sets is two tokens before it
+      const options = node.children.flatMap((child, index) =>
+        child.type === 'expression'
+          ? [[node.children[index - 2].type, ': ', format(child)]]
+          : []
+      );
+      return ['listen(', join(', ', options), ')'];
+    }
+
+    case 'annotation': {
+      const name = childOfType(node, 'identifier')!;
+      if (!node.children.some(child => child.type === '(')) {
+        return ['@', name.text];
+      }
+      const args = listEntries(node, '(', ')');
+      if (!args) {
+        return verbatim(node);
+      }
+      return ['@', name.text, formatList('(', ')', args)];
+    }
+
+    case 'return_statement': {
+      const value = childOfType(node, 'expression');
+      return value ? ['return ', format(value)] : 'return';
+    }
+
+    case 'assignment': {
+      const [target, value] = node.namedChildren;
+      return [format(target), ' = ', format(value)];
+    }
+
+    case 'binary_expression': {
+      const [left, operator, right] = node.children;
+      return [format(left), ' ', operator.type, ' ', format(right)];
+    }
+
+    case 'unary_expression': {
+      const [operator, operand] = node.children;
+      return [operator.type, format(operand)];
+    }
+
+    case 'call_expression': {
+      const args = listEntries(node, '(', ')');
+      if (!args) {
+        return verbatim(node);
+      }
+      const callee = format(node.firstNamedChild!);
+      // A lone object or array argument hugs the parentheses
+      const only = args.length === 1 ? unwrap(args[0]) : undefined;
+      if (only?.type === 'object_literal' || only?.type === 'array_literal') {
+        return [callee, '(', format(only), ')'];
+      }
+      return [callee, formatList('(', ')', args)];
+    }
+
+    case 'with_expression': {
+      const [call, options] = node.namedChildren;
+      return [format(call), ' with ', format(options)];
+    }
+
+    case 'member_expression': {
+      const [object, property] = node.namedChildren;
+      return [format(object), '.', property.text];
+    }
+
+    case 'bracket_expression': {
+      const [object, index] = node.namedChildren;
+      return [format(object), '[', format(index), ']'];
+    }
+
+    case 'parenthesized_expression':
+      return ['(', format(node.firstNamedChild!), ')'];
+
+    case 'array_literal':
+      return formatList('[', ']', listEntries(node, '[', ']')!);
+
+    case 'object_literal':
+      return formatObject(node, false);
+
+    case 'property':
+    case 'annotation_argument': {
+      const [key, value] = node.namedChildren;
+      return [key.text, ': ', format(value)];
+    }
+
+    default:
+      // Tokens such as identifiers, numbers, strings and keywords
+      return node.text;
+  }
+}
+
+/**
+ * Statements one per line, keeping single blank lines between them and
+ * comments that trail a statement on its line
+ */
+function formatStatementList(items: SyntaxNode[]): Doc[] {
+  const parts: Doc[] = [];
+  let previous: SyntaxNode | undefined;
+  for (const item of items) {
+    if (previous) {
+      const gap = item.startPosition.row - previous.endPosition.row;
+      if (gap === 0 && isCommentStatement(item)) {
+        parts.push(' ');
+      } else {
+        parts.push(gap > 1 ? [hardline, hardline] : hardline);
+      }
+    }
+    parts.push(format(item));
+    previous = item;
+  }
+  return parts;
+}
+
+/**
+ * Items and comments between a node's brackets, or null if the node also
+ * has comments outside them
+ */
+function listEntries(
+  node: SyntaxNode,
+  open: string,
+  close: string
+): SyntaxNode[] | null {
+  const children = node.children;
+  const types = children.map(child => child.type);
+  const start = types.indexOf(open);
+  const end = types.lastIndexOf(close);
+  const entries: SyntaxNode[] = [];
+  for (let i = 0; i < children.length; i++) {
+    const child = children[i];
+    if (i <= start || i >= end) {
+      if (isComment(child)) {
+        return null;
+      }
+    } else if (LIST_NODES.has(child.type)) {
+      entries.push(...child.namedChildren);
+    } else if (child.isNamed) {
+      entries.push(child);
+    }
+  }
+  return entries;
+}
+
+interface ListOptions {
+  /** Pad the items with spaces inside the brackets when on one line */
+  spaced?: boolean;
+  /** Put every item on its own line */
+  expanded?: boolean;
+}
+
+/**
+ * A bracketed, comma-separated list, on one line if it fits and one item
+ * per line (with a trailing comma) otherwise
+ */
+function formatList(
+  open: string,
+  close: string,
+  entries: SyntaxNode[],
+  options: ListOptions = {}
+): Doc {
+  if (entries.length === 0) {
+    return open + close;
+  }
+
+  if (!entries.some(isComment)) {
+    const padding = options.spaced ? line : softline;
+    return group(
+      [
+        open,
+        indent([padding, join([',', line], entries.map(format))]),
+        ifBreak(','),
+        padding,
+        close,
+      ],
+      options.expanded
+    );
+  }
+
+  // Comments need the list broken, one item or comment per line
+  const body: Doc[] = [];
+  let previous: SyntaxNode | undefined;
+  for (const entry of entries) {
+    if (
+      isComment(entry) &&
+      previous &&
+      entry.startPosition.row === previous.endPosition.row
+    ) {
+      body.push(' ', format(entry));
+    } else {
+      body.push(hardline, format(entry), isComment(entry) ? '' : ',');
+    }
+    previous = entry;
+  }
+  return [open, indent(body), hardline, close];
+}
+
+/**
+ * Objects stay expanded when written with the first property on a new line
+ */
+function formatObject(node: SyntaxNode, expanded: boolean): Doc {
+  const entries = listEntries(node, '{', '}')!;
+  const first = entries[0];
+  return formatList('{', '}', entries, {
+    spaced: true,
+    expanded:
+      expanded ||
+      (first !== undefined && first.startPosition.row > node.startPosition.row),
+  });
+}
+
+/**
+ * Look through single-child wrapper nodes
+ */
+function unwrap(node: SyntaxNode): SyntaxNode {
+  let current = node;
+  while (
+    (current.type === 'expression' || current.type === 'literal') &&
+    current.namedChildCount === 1
+  ) {
+    current = current.firstNamedChild!;
+  }
+  return current;
+}
@@ -0,0 +1,256 @@
+// Document model and line-fitting printer used by the formatter
+
+/**
+ * A document to be laid out
+ * Strings are printed as-is and arrays are concatenated
+ */
+export type Doc = string | Doc[] | Group | Indent | Line | IfBreak;
+
+interface Group {
+  kind: 'group';
+  contents: Doc;
+  /** Set when the group must break (e.g. it contains a hard line) */
+  shouldBreak: boolean;
+}
+
+interface Indent {
+  kind: 'indent';
+  contents: Doc;
+}
+
+interface Line {
+  kind: 'line';
+  /** Printed as a space when flat, unless soft (printed as nothing) */
+  soft: boolean;
+  /** Always breaks, and breaks every enclosing group */
+  hard: boolean;
+}
+
+interface IfBreak {
+  kind: 'if-break';
+  breakContents: Doc;
+  flatContents: Doc;
+}
+
+/**
+ * Contents laid out flat if they fit on the line, broken otherwise
+ */
+export function group(contents: Doc, shouldBreak = false): Doc {
+  return { kind: 'group', contents, shouldBreak };
+}
+
+/**
+ * Indent the lines that break inside the contents
+ */
+export function indent(contents: Doc): Doc {
+  return { kind: 'indent', contents };
+}
+
+/** A space, or a newline when the enclosing group breaks */
+export const line: Doc = { kind: 'line', soft: false, hard: false };
+
+/** Nothing, or a newline when the enclosing group breaks */
+export const softline: Doc = { kind: 'line', soft: true, hard: false };
+
+/** Always a newline */
+export const hardline: Doc = { kind: 'line', soft: false, hard: true };
+
+/**
+ * Different contents depending on whether the enclosing group breaks
+ */
+export function ifBreak(breakContents: Doc, flatContents: Doc = ''): Doc {
+  return { kind: 'if-break', breakContents, flatContents };
+}
+
+/**
+ * Join documents with a separator
+ */
+export function join(separator: Doc, docs: Doc[]): Doc[] {
+  const result: Doc[] = [];
+  docs.forEach((doc, i) => {
+    if (i > 0) {
+      result.push(separator);
+    }
+    result.push(doc);
+  });
+  return result;
+}
+
+/**
+ * Mark groups containing hard lines as broken; returns whether the
+ * document contains a hard line
+ */
+function propagateBreaks(doc: Doc): boolean {
+  if (typeof doc === 'string') {
+    return false;
+  }
+  if (Array.isArray(doc)) {
+    // Visit every element so nested groups are marked too
+    return doc.map(propagateBreaks).some(Boolean);
+  }
+  switch (doc.kind) {
+    case 'group': {
+      const hasHardLine = propagateBreaks(doc.contents);
+      doc.shouldBreak = doc.shouldBreak || hasHardLine;
+      return doc.shouldBreak;
+    }
+    case 'indent':
+      return propagateBreaks(doc.contents);
+    case 'line':
+      return doc.hard;
+    case 'if-break':
+      return [doc.breakContents, doc.flatContents]
+        .map(propagateBreaks)
+        .some(Boolean);
+  }
+}
+
+type Mode = 'flat' | 'break';
+
+interface Command {
+  indentation: number;
+  mode: Mode;
+  doc: Doc;
+}
+
+/**
+ * Whether a command fits in the remaining width, together with whatever
+ * follows it (the rest commands) up to the next line break
+ */
+function fits(next: Command, rest: Command[], width: number): boolean {
+  const stack = [next];
+  let restIndex = rest.length;
+  let remaining = width;
+  while (remaining >= 0) {
+    const command = stack.pop() ?? rest[--restIndex];
+    if (!command) {
+      return true;
+    }
+    const { indentation, mode, doc } = command;
+    if (typeof doc === 'string') {
+      const newline = doc.indexOf('\n');
+      if (newline !== -1) {
+        return remaining - newline >= 0;
+      }
+      remaining -= doc.length;
+    } else if (Array.isArray(doc)) {
+      for (let i = doc.length - 1; i >= 0; i--) {
+        stack.push({ indentation, mode, doc: doc[i] });
+      }
+    } else {
+      switch (doc.kind) {
+        case 'group':
+          if (doc.shouldBreak && mode === 'flat') {
+            return false;
+          }
+          stack.push({
+            indentation,
+            mode: doc.shouldBreak ? 'break' : mode,
+            doc: doc.contents,
+          });
+          break;
+        case 'indent':
+          stack.push({ indentation, mode, doc: doc.contents });
+          break;
+        case 'line':
+          if (mode === 'break' || doc.hard) {
+            return true;
+          }
+          remaining -= doc.soft ? 0 : 1;
+          break;
+        case 'if-break':
+          stack.push({
+            indentation,
+            mode,
+            doc: mode === 'break' ? doc.breakContents : doc.flatContents,
+          });
+          break;
+      }
+    }
+  }
+  return false;
+}
+
+export interface PrintOptions {
+  /** Maximum line width */
+  width: number;
+  /** Spaces per indentation level */
+  indentWidth: number;
+}
+
+/**
+ * Lay out a document as text
+ */
+export function print(doc: Doc, options: PrintOptions): string {
+  propagateBreaks(doc);
+
+  const output: string[] = [];
+  let column = 0;
+  // Indentation is written lazily so blank lines carry no trailing spaces
+  let pendingIndentation: number | null = null;
+  const write = (text: string) => {
+    if (text.length === 0) {
+      return;
+    }
+    if (pendingIndentation !== null) {
+      output.push(' '.repeat(pendingIndentation));
+      pendingIndentation = null;
+    }
+    output.push(text);
+    // Verbatim text (such as a template string) may span lines
+    const lastNewline = text.lastIndexOf('\n');
+    column =
+      lastNewline === -1 ? column + text.length : text.length - lastNewline - 1;
+  };
+
+  const stack: Command[] = [{ indentation: 0, mode: 'break', doc }];
+  while (stack.length > 0) {
+    const { indentation, mode, doc } = stack.pop()!;
+    if (typeof doc === 'string') {
+      write(doc);
+    } else if (Array.isArray(doc)) {
+      for (let i = doc.length - 1; i >= 0; i--) {
+        stack.push({ indentation, mode, doc: doc[i] });
+      }
+    } else {
+      switch (doc.kind) {
+        case 'group': {
+          const flat: Command = {
+            indentation,
+            mode: 'flat',
+            doc: doc.contents,
+          };
+          const useFlat =
+            !doc.shouldBreak && fits(flat, stack, options.width - column);
+          stack.push(useFlat ? flat : { ...flat, mode: 'break' });
+          break;
+        }
+        case 'indent':
+          stack.push({
+            indentation: indentation + options.indentWidth,
+            mode,
+            doc: doc.contents,
+          });
+          break;
+        case 'line':
+          if (mode === 'flat' && !doc.hard) {
+            write(doc.soft ? '' : ' ');
+          } else {
+            output.push('\n');
+            pendingIndentation = indentation;
+            column = indentation;
+          }
+          break;
+        case 'if-break':
+          stack.push({
+            indentation,
+            mode,
+            doc: mode === 'break' ? doc.breakContents : doc.flatContents,
+          });
+          break;
+      }
+    }
+  }
+
+  return output.join('');
+}
@@ -0,0 +1,251 @@
+// Syntax highlighting of scripts for terminals and web pages
+//
+// Applies the grammar's highlights query (see highlightTokens) and renders
+// the source as HTML, with a CSS class per capture, or as text colored with
+// ANSI escapes. Themes color the captures, either directly in the terminal
+// or through the stylesheet highlightCss() writes for the HTML.
+import { highlightTokens } from './tokens.js';
+import type { SyntaxTree } from './syntax.js';
+
+/**
+ * How the text of one capture is shown
+ */
+export interface HighlightStyle {
+  /** Color as #rrggbb */
+  color?: string;
+  bold?: boolean;
+  italic?: boolean;
+}
+
+export interface HighlightTheme {
+  name: string;
+  /** Colors of the code block, as #rrggbb (HTML only) */
+  background: string;
+  foreground: string;
+  /**
+   * Styles by capture name; a capture without a style of its own, such as
+   * "keyword.control", takes that of its parent ("keyword")
+   */
+  styles: Record<string, HighlightStyle>;
+}
+
+export const HIGHLIGHT_THEMES: Record<string, HighlightTheme> = {
+  dark: {
+    name: 'dark',
+    background: '#282c34',
+    foreground: '#abb2bf',
+    styles: {
+      comment: { color: '#7f848e', italic: true },
+      keyword: { color: '#c678dd' },
+      'keyword.control': { color: '#c678dd', bold: true },
+      function: { color: '#61afef' },
+      namespace: { color: '#e5c07b' },
+      variable: { color: '#e06c75' },
+      'variable.parameter': { color: '#d19a66', italic: true },
+      property: { color: '#56b6c2' },
+      string: { color: '#98c379' },
+      number: { color: '#d19a66' },
+      boolean: { color: '#d19a66' },
+      type: { color: '#e5c07b' },
+      operator: { color: '#56b6c2' },
+      punctuation: { color: '#abb2bf' },
+    },
+  },
+  light: {
+    name: 'light',
+    background: '#ffffff',
+    foreground: '#24292f',
+    styles: {
+      comment: { color: '#6e7781', italic: true },
+      keyword: { color: '#cf222e' },
+      'keyword.control': { color: '#cf222e', bold: true },
+      function: { color: '#8250df' },
+      namespace: { color: '#953800' },
+      variable: { color: '#24292f' },
+      'variable.parameter': { color: '#953800', italic: true },
+      property: { color: '#0550ae' },
+      string: { color: '#0a3069' },
+      number: { color: '#0550ae' },
+      boolean: { color: '#0550ae' },
+      type: { color: '#953800' },
+      operator: { color: '#cf222e' },
+      punctuation: { color: '#24292f' },
+    },
+  },
+};
+
+/**
+ * A run of source text, highlighted with a capture name or plain
+ */
+export interface HighlightSegment {
+  text: string;
+  name?: string;
+}
+
+export interface HighlightHtmlOptions {
+  /** Prefix of the CSS classes of captures (default: "tok-") */
+  classPrefix?: string;
+}
+
+const HTML_ESCAPES: Record<string, string> = {
+  '&': '&amp;',
+  '<': '&lt;',
+  '>': '&gt;',
+  '"': '&quot;',
+  "'": '&#39;',
+};
+
+export function escapeHtml(text: string): string {
+  return text.replace(/[&<>"']/g, char => HTML_ESCAPES[char]);
+}
+
+/**
+ * CSS classes of a highlights query capture and the captures it refines,
+ * e.g. "tok-keyword tok-keyword-control" for @keyword.control
+ */
+export function tokenClass(capture: string, prefix = 'tok-'): string {
+  const parts = capture.split('.');
+  return parts
+    .map((_, index) => `${prefix}${parts.slice(0, index + 1).join('-')}`)
+    .join(' ');
+}
+
+/**
+ * Split the source into highlighted and plain runs that cover it in order
+ * Nodes nested inside one that is highlighted keep its highlight.
+ */
+export function highlightSegments(
+  tree: SyntaxTree,
+  content: string
+): HighlightSegment[] {
+  const segments: HighlightSegment[] = [];
+  let offset = 0;
+  for (const { name, startIndex, endIndex } of highlightTokens(tree)) {
+    if (startIndex < offset || endIndex <= startIndex) {
+      continue;
+    }
+    if (startIndex > offset) {
+      segments.push({ text: content.slice(offset, startIndex) });
+    }
+    segments.push({ text: content.slice(startIndex, endIndex), name });
+    offset = endIndex;
+  }
+  if (offset < content.length) {
+    segments.push({ text: content.slice(offset) });
+  }
+  return segments;
+}
+
+/**
+ * The source as HTML, with each highlighted node wrapped in a span
+ * Text outside highlighted nodes is escaped and kept as is, so the result
+ * shows the source unchanged inside a <pre> element
+ */
+export function highlightHtml(
+  tree: SyntaxTree,
+  content: string,
+  options: HighlightHtmlOptions = {}
+): string {
+  return highlightSegments(tree, content)
+    .map(({ text, name }) =>
+      name
+        ? `<span class="${tokenClass(name, options.classPrefix)}">${escapeHtml(text)}</span>`
+        : escapeHtml(text)
+    )
+    .join('');
+}
+
+/**
+ * Style of a capture in a theme, falling back to its parent captures
+ */
+export function themeStyle(
+  theme: HighlightTheme,
+  capture: string
+): HighlightStyle | undefined {
+  for (let name = capture; name; name = name.replace(/\.?[^.]*$/, '')) {
+    if (theme.styles[name]) {
+      return theme.styles[name];
+    }
+  }
+  return undefined;
+}
+
+function cssDeclarations(style: HighlightStyle): string {
+  const declarations: string[] = [];
+  if (style.color) {
+    declarations.push(`color: ${style.color};`);
+  }
+  if (style.bold) {
+    declarations.push('font-weight: bold;');
+  }
+  if (style.italic) {
+    declarations.push('font-style: italic;');
+  }
+  return declarations.join(' ');
+}
+
+/**
+ * A stylesheet coloring the HTML of highlightHtml() inside an element with
+ * the given class (default: "mcps-highlight")
+ */
+export function highlightCss(
+  theme: HighlightTheme,
+  options: HighlightHtmlOptions & { container?: string } = {}
+): string {
+  const { classPrefix = 'tok-', container = 'mcps-highlight' } = options;
+  const rules = [
+    `.${container} { background: ${theme.background}; color: ${theme.foreground}; }`,
+  ];
+  // Parents come first in the theme, so rules of the captures refining
+  // them win
+  for (const [capture, style] of Object.entries(theme.styles)) {
+    const className = `${classPrefix}${capture.replace(/\./g, '-')}`;
+    rules.push(`.${container} .${className} { ${cssDeclarations(style)} }`);
+  }
+  return `${rules.join('\n')}\n`;
+}
+
+const ANSI_RESET = '\x1b[0m';
+
+function ansiCodes(style: HighlightStyle): string {
+  const codes: string[] = [];
+  if (style.bold) {
+    codes.push('1');
+  }
+  if (style.italic) {
+    codes.push('3');
+  }
+  const rgb = /^#([0-9a-f]{2})([0-9a-f]{2})([0-9a-f]{2})$/i.exec(
+    style.color ?? ''
+  );
+  if (rgb) {
+    const [r, g, b] = rgb.slice(1).map(hex => parseInt(hex, 16));
+    codes.push(`38;2;${r};${g};${b}`);
+  }
+  return codes.length > 0 ? `\x1b[${codes.join(';')}m` : '';
+}
+
+/**
+ * The source colored with 24-bit ANSI escapes for terminals
+ * Styles end at every line break, so lines can be printed or paged one at
+ * a time; plain text keeps the terminal's own colors.
+ */
+export function highlightAnsi(
+  tree: SyntaxTree,
+  content: string,
+  theme: HighlightTheme = HIGHLIGHT_THEMES.dark
+): string {
+  return highlightSegments(tree, content)
+    .map(({ text, name }) => {
+      const style = name && themeStyle(theme, name);
+      const codes = style ? ansiCodes(style) : '';
+      if (!codes) {
+        return text;
+      }
+      return text
+        .split('\n')
+        .map(line => (line ? `${codes}${line}${ANSI_RESET}` : line))
+        .join('\n');
+    })
+    .join('');
+}
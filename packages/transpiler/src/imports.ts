@@ -0,0 +1,195 @@
+// Organizing the imports of a script
+//
+// organizeImports() gathers a script's top-level imports where the first
+// one is, sorted by specifier, with each module imported once. Imports are
+// only declarations, so moving them changes nothing; other statements
+// found between them follow the imports. Comments directly above an import
+// or after it on its line go with it. Imports of modules the script uses
+// nothing from, which unusedImports() finds from its module graph, are
+// removed as well. The formatter does this with organizeImports set, and
+// the language server offers it as a source action editors run on save.
+import { positionAt } from './locations.js';
+import type { ModuleGraph } from './modules.js';
+import type { Refactoring, RefactorSource } from './refactor.js';
+import {
+  buildSymbolTable,
+  importedDeclarations,
+  templateNames,
+} from './semantics.js';
+import { parseTree, type SyntaxNode, type SyntaxTree } from './syntax.js';
+
+/**
+ * An import with the comments that go with it
+ */
+interface ImportBlock {
+  /** Specifier as written */
+  specifier: string;
+  /** Start of the first line and end of the last line, after its newline */
+  start: number;
+  end: number;
+}
+
+function isImport(statement: SyntaxNode): boolean {
+  return statement.firstNamedChild?.type === 'import_statement';
+}
+
+function isComment(statement: SyntaxNode): boolean {
+  return statement.firstNamedChild?.type === 'comment';
+}
+
+function specifierOf(statement: SyntaxNode): string {
+  return statement.firstNamedChild!.lastNamedChild!.text.slice(1, -1);
+}
+
+/**
+ * Specifiers naming the same file count as one: "./lib" and "./lib.mcps"
+ */
+function importKey(specifier: string): string {
+  return specifier.replace(/\.mcps$/, '');
+}
+
+function importBlocks(tree: SyntaxTree, content: string): ImportBlock[] {
+  const statements = tree.rootNode.namedChildren;
+  const blocks: ImportBlock[] = [];
+  statements.forEach((statement, index) => {
+    if (!isImport(statement)) {
+      return;
+    }
+    // Comments above it, but not one trailing the statement before them
+    let first = statement;
+    for (
+      let i = index - 1;
+      i >= 0 &&
+      isComment(statements[i]) &&
+      statements[i].endPosition.row === first.startPosition.row - 1 &&
+      statements[i - 1]?.endPosition.row !== statements[i].startPosition.row;
+      i--
+    ) {
+      first = statements[i];
+    }
+    let last = statement;
+    const next = statements[index + 1];
+    if (
+      next &&
+      isComment(next) &&
+      next.startPosition.row === statement.endPosition.row
+    ) {
+      last = next;
+    }
+    const newline = content.indexOf('\n', last.endIndex);
+    blocks.push({
+      specifier: specifierOf(statement),
+      start: content.lastIndexOf('\n', first.startIndex - 1) + 1,
+      end: newline === -1 ? content.length : newline + 1,
+    });
+  });
+  return blocks;
+}
+
+/**
+ * Sort the imports of a script, import each module once and remove the
+ * imports whose specifiers are listed as unused
+ * The refactoring has no edits when the imports are organized already.
+ */
+export function organizeImports(
+  source: RefactorSource,
+  unused: readonly string[] = []
+): Refactoring {
+  const { content } = source;
+  const tree = source.tree ?? parseTree(content);
+  const refactoring: Refactoring = {
+    title: 'Organize imports',
+    edits: { [source.path]: [] },
+  };
+  const blocks = importBlocks(tree, content);
+  if (blocks.length === 0) {
+    return refactoring;
+  }
+
+  const removed = new Set(unused.map(importKey));
+  const seen = new Set<string>();
+  const kept: { key: string; text: string }[] = [];
+  for (const block of blocks) {
+    const key = importKey(block.specifier);
+    if (!removed.has(key) && !seen.has(key)) {
+      seen.add(key);
+      kept.push({
+        key,
+        text: content.slice(block.start, block.end).replace(/\n?$/, '\n'),
+      });
+    }
+  }
+  kept.sort((a, b) => (a.key < b.key ? -1 : a.key > b.key ? 1 : 0));
+
+  // The statements between the imports follow them
+  const start = blocks[0].start;
+  let end = blocks[blocks.length - 1].end;
+  const between = blocks
+    .slice(1)
+    .map((block, index) => content.slice(blocks[index].end, block.start))
+    .filter(text => text.trim())
+    .map(text => text.replace(/^\n+|\n+$/g, '') + '\n');
+  let newText = kept.map(entry => entry.text).join('');
+  if (between.length > 0) {
+    newText += (newText ? '\n' : '') + between.join('\n');
+  } else if (!newText && content.slice(end).startsWith('\n')) {
+    // No blank line is left where the imports were
+    end++;
+  }
+  if (content.slice(start, end) === newText) {
+    return refactoring;
+  }
+  refactoring.edits[source.path].push({
+    location: {
+      start: positionAt(content, start),
+      end: positionAt(content, end),
+    },
+    newText,
+  });
+  return refactoring;
+}
+
+/**
+ * Specifiers of the entry module's imports that give it no declaration it
+ * uses, directly or through the modules they import
+ */
+export function unusedImports(graph: ModuleGraph): string[] {
+  const { entry } = graph;
+  const declarations = importedDeclarations(graph);
+  const table = buildSymbolTable(entry.tree, declarations);
+  const used = new Set<string>();
+  for (const { symbol } of table.references) {
+    if (symbol?.path !== undefined) {
+      used.add(symbol.path);
+    }
+  }
+  const names = templateNames([entry.tree.rootNode]);
+  for (const declaration of declarations) {
+    if (names.has(declaration.name)) {
+      used.add(declaration.path);
+    }
+  }
+
+  const byPath = new Map(graph.modules.map(m => [m.path, m]));
+  const provides = (path: string): boolean => {
+    const seen = new Set<string>();
+    const pending = [path];
+    while (pending.length > 0) {
+      const next = pending.shift()!;
+      if (used.has(next)) {
+        return true;
+      }
+      if (!seen.has(next)) {
+        seen.add(next);
+        pending.push(...(byPath.get(next)?.imports ?? []));
+      }
+    }
+    return false;
+  };
+
+  // The graph keeps the resolved path of every import, in source order
+  const imports = entry.tree.rootNode.namedChildren.filter(isImport);
+  return imports
+    .filter((_, index) => !provides(entry.imports[index]))
+    .map(specifierOf);
+}
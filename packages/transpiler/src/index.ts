@@ -1,9 +1,39 @@
 // @mcpscript/transpiler - Parser & code generator
+// Loads the native grammar binding; browsers use ./browser.js instead
+export * from './native.js';
 export * from './parser.js';
+export * from './syntax.js';
 export * from './codegen.js';
 export * from './ast.js';
 export * from './validator.js';
+export * from './locations.js';
+export * from './arena.js';
+export * from './limits.js';
+export * from './features.js';
+export * from './typecheck.js';
+export * from './formatter.js';
+export * from './imports.js';
+export * from './semantics.js';
+export * from './modules.js';
+export * from './lint.js';
+export * from './bench.js';
+export * from './batch.js';
+export * from './tags.js';
+export * from './tokens.js';
+export * from './highlight.js';
+export * from './diff.js';
+export * from './refactor.js';
+export * from './serialize.js';
+export * from './corpus.js';
+export * from './capabilities.js';
+export * from './docs.js';
+export * from './messages.js';
+export * from './inspect.js';
+export * from './interpolation.js';
+export * from './schedule.js';
+export * from './pool.js';
 
 // Explicitly re-export commonly used functions for clarity
-export { parseFile, parseSource } from './parser.js';
+export { parseSource } from './parser.js';
+export { parseFile } from './native.js';
 export { generateCode, generateCodeUnsafe } from './codegen.js';
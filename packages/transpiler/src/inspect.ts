@@ -0,0 +1,282 @@
+// Inspection helpers: common questions about a script, answered from its AST
+//
+// CI checks and dashboards keep asking the same things of scripts: which
+// servers they declare, what runs when, which MCP tools each part calls
+// and who calls whom. These helpers answer them from parsed statements,
+// with the source location of everything they report, so such tools need
+// neither their own syntax tree queries nor knowledge of the AST's shape.
+import type { Expression, MCPDeclaration, Statement } from './ast.js';
+import { describeServer, type ServerCapability } from './capabilities.js';
+import { getLocation, type SourceLocation } from './locations.js';
+
+/**
+ * An MCP server a script declares
+ */
+export interface InspectedServer extends ServerCapability {
+  location?: SourceLocation;
+}
+
+export type WorkflowKind =
+  | 'script'
+  | 'tool'
+  | 'pipeline'
+  | 'agent'
+  | 'trigger'
+  | 'handler';
+
+/**
+ * Something of a script that runs: its top-level code, or one of its
+ * tools, pipelines, agents, triggers or event handlers
+ */
+export interface Workflow {
+  kind: WorkflowKind;
+  /**
+   * Name of the tool, pipeline, agent or trigger, or the event a handler
+   * handles; "script" for top-level code
+   */
+  name: string;
+  location?: SourceLocation;
+  /**
+   * What runs: the top-level statements, a tool's body, a pipeline's
+   * declaration, an agent's configuration, a trigger's action or a
+   * handler's body
+   */
+  node: Statement[] | Statement | Expression;
+  /** Servers of the script, whose members are MCP tools */
+  servers: string[];
+}
+
+/**
+ * A call of an MCP tool, or a tool an agent is given to call
+ */
+export interface InspectedToolCall {
+  server: string;
+  /** Tool name, or "*" when an agent is given the whole server */
+  tool: string;
+  /** "call" for calls in code, "agent" for tools given to an agent */
+  via: 'call' | 'agent';
+  location?: SourceLocation;
+}
+
+/**
+ * A workflow or MCP tool in a call graph
+ */
+export interface CallGraphNode {
+  /**
+   * "script", "tool:<name>", "pipeline:<name>", "agent:<name>",
+   * "trigger:<name>", "handler:<event>" or "mcp:<server>.<tool>"
+   */
+  id: string;
+  kind: WorkflowKind | 'mcp_tool';
+  name: string;
+  location?: SourceLocation;
+}
+
+/**
+ * A workflow calling, delegating to or being given a tool, agent or MCP
+ * tool, where it does so
+ */
+export interface CallGraphEdge {
+  from: string;
+  to: string;
+  location?: SourceLocation;
+}
+
+export interface CallGraph {
+  nodes: CallGraphNode[];
+  edges: CallGraphEdge[];
+}
+
+/** Statements that declare rather than run */
+const DECLARATIONS = new Set<Statement['type']>([
+  'mcp_declaration',
+  'model_declaration',
+  'agent_declaration',
+  'prompt_declaration',
+  'trigger_declaration',
+  'event_handler',
+  'tool_declaration',
+  'pipeline_declaration',
+  'test_declaration',
+  'test_hook',
+  'fixture_declaration',
+  'import_statement',
+  'comment',
+]);
+
+/**
+ * The MCP servers a script declares, in order
+ */
+export function listMCPServers(statements: Statement[]): InspectedServer[] {
+  return statements
+    .filter((s): s is MCPDeclaration => s.type === 'mcp_declaration')
+    .map(decl => {
+      const location = getLocation(decl);
+      return location
+        ? { ...describeServer(decl), location }
+        : describeServer(decl);
+    });
+}
+
+/**
+ * The workflows of a script: its top-level code, when it has any, then its
+ * tools, pipelines, agents, triggers and event handlers in order
+ */
+export function listWorkflows(statements: Statement[]): Workflow[] {
+  const servers = listMCPServers(statements).map(server => server.name);
+  const workflows: Workflow[] = [];
+  const code = statements.filter(s => !DECLARATIONS.has(s.type));
+  if (code.length > 0) {
+    workflows.push({
+      kind: 'script',
+      name: 'script',
+      location: getLocation(code[0]),
+      node: code,
+      servers,
+    });
+  }
+  for (const statement of statements) {
+    const location = getLocation(statement);
+    const workflow = (
+      kind: WorkflowKind,
+      name: string,
+      node: Workflow['node']
+    ) =>
+      workflows.push(
+        location
+          ? { kind, name, location, node, servers }
+          : { kind, name, node, servers }
+      );
+    switch (statement.type) {
+      case 'tool_declaration':
+        workflow('tool', statement.name, statement.body);
+        break;
+      case 'pipeline_declaration':
+        workflow('pipeline', statement.name, statement);
+        break;
+      case 'agent_declaration':
+        workflow('agent', statement.name, statement.config);
+        break;
+      case 'trigger_declaration':
+        workflow('trigger', statement.name, statement.action);
+        break;
+      case 'event_handler':
+        workflow('handler', statement.event, statement.body);
+        break;
+    }
+  }
+  return workflows;
+}
+
+/**
+ * Visit the nodes of an AST, outer nodes first
+ */
+function walk(node: unknown, visit: (expr: Expression) => void) {
+  if (Array.isArray(node)) {
+    node.forEach(child => walk(child, visit));
+    return;
+  }
+  if (!node || typeof node !== 'object') {
+    return;
+  }
+  visit(node as Expression);
+  Object.values(node).forEach(child => walk(child, visit));
+}
+
+/**
+ * The MCP tools a workflow calls, in source order. For agents, these are
+ * the tools they are given, which their model may call
+ */
+export function toolCallsIn(workflow: Workflow): InspectedToolCall[] {
+  const servers = new Set(workflow.servers);
+  const calls: InspectedToolCall[] = [];
+  const add = (
+    server: string,
+    tool: string,
+    via: InspectedToolCall['via'],
+    node: object
+  ) => {
+    const location = getLocation(node);
+    calls.push(
+      location ? { server, tool, via, location } : { server, tool, via }
+    );
+  };
+
+  if (workflow.kind === 'agent') {
+    const config = workflow.node as Expression;
+    const tools =
+      config.type === 'object'
+        ? config.properties.find(p => p.key === 'tools')?.value
+        : undefined;
+    for (const element of tools?.type === 'array' ? tools.elements : []) {
+      if (element.type === 'identifier' && servers.has(element.name)) {
+        add(element.name, '*', 'agent', element);
+      } else if (
+        element.type === 'member' &&
+        element.object.type === 'identifier' &&
+        servers.has(element.object.name)
+      ) {
+        add(element.object.name, element.property, 'agent', element);
+      }
+    }
+  }
+
+  walk(workflow.node, expr => {
+    if (
+      expr.type === 'call' &&
+      expr.callee.type === 'member' &&
+      expr.callee.object.type === 'identifier' &&
+      servers.has(expr.callee.object.name)
+    ) {
+      add(expr.callee.object.name, expr.callee.property, 'call', expr);
+    }
+  });
+  return calls;
+}
+
+/**
+ * Which workflows call which tools, pipelines, agents and MCP tools. A
+ * workflow refers to a tool, pipeline or agent by name, whether it calls
+ * it, runs it or gives it to an agent; an agent given another agent
+ * delegates to it
+ */
+export function callGraph(statements: Statement[]): CallGraph {
+  const workflows = listWorkflows(statements);
+  const nodes: CallGraphNode[] = [];
+  const edges: CallGraphEdge[] = [];
+  const ids = new Map<string, string>();
+  for (const { kind, name, location } of workflows) {
+    const id = kind === 'script' ? 'script' : `${kind}:${name}`;
+    if (kind === 'tool' || kind === 'pipeline' || kind === 'agent') {
+      ids.set(name, id);
+    }
+    nodes.push(location ? { id, kind, name, location } : { id, kind, name });
+  }
+
+  const mcpTools = new Set<string>();
+  const edge = (from: string, to: string, node: object) => {
+    const location = getLocation(node);
+    edges.push(location ? { from, to, location } : { from, to });
+  };
+  workflows.forEach((workflow, index) => {
+    const from = nodes[index].id;
+    for (const call of toolCallsIn(workflow)) {
+      const name = `${call.server}.${call.tool}`;
+      if (!mcpTools.has(name)) {
+        mcpTools.add(name);
+        nodes.push({ id: `mcp:${name}`, kind: 'mcp_tool', name });
+      }
+      edges.push(
+        call.location
+          ? { from, to: `mcp:${name}`, location: call.location }
+          : { from, to: `mcp:${name}` }
+      );
+    }
+    walk(workflow.node, expr => {
+      if (expr.type === 'identifier' && ids.has(expr.name)) {
+        edge(from, ids.get(expr.name)!, expr);
+      }
+    });
+  });
+  return { nodes, edges };
+}
@@ -0,0 +1,134 @@
+// ${NAME} and ${secret:NAME} references in the values of mcp declarations
+//
+// Server configs need tokens and paths that differ per machine. Strings in
+// an mcp declaration may reference an environment variable as ${NAME} or a
+// secret of the project's secret providers as ${secret:NAME}; they are
+// resolved when the server starts. The text of a prompt may also reference
+// the project's metadata as ${project.KEY}. $${ is a literal ${.
+import type { Expression, StringLiteral } from './ast.js';
+
+/**
+ * Text of an interpolated string, or a reference to resolve into it
+ */
+export type InterpolationPart =
+  | string
+  | { env: string }
+  | { secret: string }
+  | { project: string };
+
+/**
+ * An environment variable, secret or project metadata key referenced by a
+ * string
+ */
+export interface InterpolationReference {
+  kind: 'env' | 'secret' | 'project';
+  name: string;
+}
+
+export class InterpolationError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'InterpolationError';
+  }
+}
+
+const REFERENCE_PATTERN = /^(secret:|project\.)?([A-Za-z_][A-Za-z0-9_]*)$/;
+
+export interface InterpolationOptions {
+  /** Whether ${project.KEY} references are allowed, as in prompts */
+  project?: boolean;
+}
+
+/**
+ * Split a string into its text and the references between it
+ */
+export function parseInterpolation(
+  text: string,
+  options: InterpolationOptions = {}
+): InterpolationPart[] {
+  const parts: InterpolationPart[] = [];
+  let literal = '';
+  let index = 0;
+  while (index < text.length) {
+    if (text.startsWith('$${', index)) {
+      literal += '${';
+      index += 3;
+      continue;
+    }
+    if (!text.startsWith('${', index)) {
+      literal += text[index++];
+      continue;
+    }
+
+    const end = text.indexOf('}', index + 2);
+    if (end === -1) {
+      throw new InterpolationError(
+        `Unterminated reference in "${text}"; write $\${ for a literal \${`
+      );
+    }
+    const reference = text.slice(index + 2, end);
+    const match = REFERENCE_PATTERN.exec(reference);
+    if (!match || (match[1] === 'project.' && !options.project)) {
+      throw new InterpolationError(
+        `Invalid reference \${${reference}}; expected \${NAME}` +
+          (options.project
+            ? ', ${secret:NAME} or ${project.KEY}'
+            : ' or ${secret:NAME}')
+      );
+    }
+    if (literal) {
+      parts.push(literal);
+      literal = '';
+    }
+    parts.push(
+      match[1] === 'secret:'
+        ? { secret: match[2] }
+        : match[1] === 'project.'
+          ? { project: match[2] }
+          : { env: match[2] }
+    );
+    index = end + 1;
+  }
+  if (literal || parts.length === 0) {
+    parts.push(literal);
+  }
+  return parts;
+}
+
+/**
+ * The references among the parts of an interpolated string
+ */
+export function interpolationReferences(
+  parts: InterpolationPart[]
+): InterpolationReference[] {
+  const references: InterpolationReference[] = [];
+  for (const part of parts) {
+    if (typeof part === 'string') {
+      continue;
+    }
+    if ('secret' in part) {
+      references.push({ kind: 'secret', name: part.secret });
+    } else if ('project' in part) {
+      references.push({ kind: 'project', name: part.project });
+    } else {
+      references.push({ kind: 'env', name: part.env });
+    }
+  }
+  return references;
+}
+
+/**
+ * The string literals of a value that are interpolated
+ */
+export function interpolatedStrings(expr: Expression): StringLiteral[] {
+  switch (expr.type) {
+    case 'string':
+      return expr.value.includes('${') ? [expr] : [];
+    case 'array':
+      return expr.elements.flatMap(interpolatedStrings);
+    case 'object':
+      return expr.properties.flatMap(prop => interpolatedStrings(prop.value));
+    default:
+      return [];
+  }
+}
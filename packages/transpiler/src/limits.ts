@@ -0,0 +1,114 @@
+// Size limits for parsing untrusted source
+//
+// Services that parse source from many clients, such as the daemon and the
+// analysis service, cap the input so that a pathological document cannot
+// exhaust memory in tree-sitter or the stack in the recursive passes that
+// walk its tree. The source length is checked before parsing; tree depth
+// and node count right after, before anything else sees the tree.
+import type Parser from 'tree-sitter';
+import { locationOf, type SourceLocation } from './locations.js';
+
+export interface ParseLimits {
+  /** Longest source, in UTF-16 code units */
+  maxSourceLength?: number;
+  /** Deepest nesting of syntax nodes */
+  maxDepth?: number;
+  /** Most syntax nodes in a tree */
+  maxNodes?: number;
+}
+
+/**
+ * Limits in effect unless setParseLimits() changes them, far above what
+ * hand-written scripts reach
+ */
+export const DEFAULT_PARSE_LIMITS: Required<ParseLimits> = {
+  maxSourceLength: 8 * 1024 * 1024,
+  maxDepth: 1000,
+  maxNodes: 2_000_000,
+};
+
+let limits: Required<ParseLimits> = { ...DEFAULT_PARSE_LIMITS };
+
+/**
+ * Change the limits every parse is checked against; fields left out keep
+ * their defaults
+ */
+export function setParseLimits(next: ParseLimits): void {
+  limits = { ...DEFAULT_PARSE_LIMITS, ...next };
+}
+
+export function parseLimits(): Required<ParseLimits> {
+  return limits;
+}
+
+/**
+ * Error thrown for source that exceeds a parse limit
+ */
+export class ParseLimitError extends Error {
+  constructor(
+    message: string,
+    /** The limit that was exceeded */
+    public readonly limit: keyof ParseLimits,
+    /** Its value */
+    public readonly max: number,
+    /** Where in the source it was exceeded, for tree limits */
+    public readonly location?: SourceLocation
+  ) {
+    super(message);
+    this.name = 'ParseLimitError';
+  }
+}
+
+/**
+ * Throw if source is too long to parse
+ */
+export function checkSourceLimits(content: string): void {
+  const { maxSourceLength } = limits;
+  if (content.length > maxSourceLength) {
+    throw new ParseLimitError(
+      `Source is ${content.length} characters long, more than the limit of ${maxSourceLength}`,
+      'maxSourceLength',
+      maxSourceLength
+    );
+  }
+}
+
+/**
+ * Throw if a syntax tree is nested too deeply or has too many nodes
+ * The tree is walked with a cursor, so checking it needs no recursion
+ */
+export function checkTreeLimits(tree: Parser.Tree): void {
+  const { maxDepth, maxNodes } = limits;
+  const cursor = tree.walk();
+  let depth = 1;
+  let nodes = 1;
+  for (;;) {
+    if (cursor.gotoFirstChild()) {
+      depth++;
+      if (depth > maxDepth) {
+        throw new ParseLimitError(
+          `Syntax is nested more than ${maxDepth} levels deep`,
+          'maxDepth',
+          maxDepth,
+          locationOf(cursor.currentNode)
+        );
+      }
+    } else {
+      while (!cursor.gotoNextSibling()) {
+        if (!cursor.gotoParent()) {
+          return;
+        }
+        depth--;
+      }
+    }
+    nodes++;
+    if (nodes > maxNodes) {
+      throw new ParseLimitError(
+        `Syntax tree has more than ${maxNodes} nodes`,
+        'maxNodes',
+        maxNodes,
+        locationOf(cursor.currentNode)
+      );
+    }
+  }
+}
@@ -0,0 +1,176 @@
+// Lint rules: checks for likely mistakes in scripts that parse and type check
+//
+// Rules see the syntax tree, the AST and the symbol table of a script.
+// Projects add their own as JavaScript plugins or WebAssembly analyzers
+// (see lint/analyzers.ts); both are written against LINT_API_VERSION,
+// which only changes when existing rules would break.
+import type { Statement } from './ast.js';
+import { statementsFromTree } from './parser.js';
+import type { SyntaxNode, SyntaxTree } from './syntax.js';
+import {
+  buildSymbolTable,
+  type ImportedDeclaration,
+  type SymbolTable,
+} from './semantics.js';
+import { locationOf, type SourceLocation } from './locations.js';
+import { BUILTIN_LINT_RULES } from './lint/rules.js';
+import { PROMPT_LINT_RULES } from './lint/prompts.js';
+
+export { BUILTIN_LINT_RULES } from './lint/rules.js';
+export { PROMPT_LINT_RULES } from './lint/prompts.js';
+export { toolCallRule } from './lint/tool-calls.js';
+export {
+  analyzerRules,
+  loadAnalyzer,
+  type AnalyzerExports,
+} from './lint/analyzers.js';
+
+/**
+ * Version of what rules are given and may do; plugins and analyzers
+ * needing a later one are refused
+ */
+export const LINT_API_VERSION = 1;
+
+export type LintSeverity = 'error' | 'warning';
+
+/**
+ * A change of the source: the text of a range replaced, or inserted where
+ * the range is empty
+ */
+export interface LintEdit {
+  location: SourceLocation;
+  newText: string;
+}
+
+/**
+ * A change that fixes a problem, which editors offer as a quick fix
+ */
+export interface LintFix {
+  /** What the fix does, such as "Add required property 'path'" */
+  title: string;
+  edits: LintEdit[];
+}
+
+/**
+ * A problem found by a lint rule
+ */
+export interface LintDiagnostic {
+  /** Name of the rule that reported it */
+  rule: string;
+  message: string;
+  severity: LintSeverity;
+  location: SourceLocation;
+  fix?: LintFix;
+}
+
+/**
+ * What a rule can inspect, and how it reports problems
+ */
+export interface LintContext {
+  tree: SyntaxTree;
+  content: string;
+  /** AST of the script, built when a rule first reads it */
+  readonly statements: Statement[];
+  symbols: SymbolTable;
+  /** Path of the script, when it is a file */
+  path?: string;
+  /**
+   * Report a problem spanning a node, or from a node to another one, with
+   * the fix for it if there is one
+   */
+  report(
+    node: SyntaxNode,
+    message: string,
+    until?: SyntaxNode,
+    fix?: LintFix
+  ): void;
+  /** Report a problem spanning a range, such as an AST node's location */
+  reportAt(location: SourceLocation, message: string, fix?: LintFix): void;
+}
+
+/**
+ * A lint rule
+ * Projects can add their own rules next to the built-in ones
+ */
+export interface LintRule {
+  /** Kebab-case name, used to configure the rule and shown with problems */
+  name: string;
+  description: string;
+  /** Severity of the rule's problems unless configured otherwise */
+  severity: LintSeverity;
+  check(context: LintContext): void;
+}
+
+/**
+ * Optional sets of rules by name, which projects enable next to the
+ * built-in ones
+ */
+export const LINT_PACKS: Record<string, LintRule[]> = {
+  prompts: PROMPT_LINT_RULES,
+};
+
+export interface LintOptions {
+  /** Rules to run (default: BUILTIN_LINT_RULES) */
+  rules?: LintRule[];
+  /** Severities by rule name; "off" disables a rule */
+  severities?: Record<string, LintSeverity | 'off'>;
+  /** Declarations visible through the script's imports */
+  imports?: ImportedDeclaration[];
+  /** Path of the script, which rules are told */
+  path?: string;
+}
+
+/**
+ * Run lint rules over a syntax tree
+ * The tree should be free of syntax errors; problems are returned in
+ * document order
+ */
+export function lint(
+  tree: SyntaxTree,
+  content: string,
+  options: LintOptions = {}
+): LintDiagnostic[] {
+  const { rules = BUILTIN_LINT_RULES, severities = {} } = options;
+  const symbols = buildSymbolTable(tree, options.imports);
+  const diagnostics: LintDiagnostic[] = [];
+  let statements: Statement[] | undefined;
+
+  for (const rule of rules) {
+    const severity = severities[rule.name] ?? rule.severity;
+    if (severity === 'off') {
+      continue;
+    }
+    const reportAt = (
+      location: SourceLocation,
+      message: string,
+      fix?: LintFix
+    ) => {
+      diagnostics.push({
+        rule: rule.name,
+        message,
+        severity,
+        location,
+        ...(fix && { fix }),
+      });
+    };
+    rule.check({
+      tree,
+      content,
+      get statements() {
+        return (statements ??= statementsFromTree(tree, content));
+      },
+      symbols,
+      path: options.path,
+      report(node, message, until = node, fix) {
+        const { start } = locationOf(node);
+        const { end } = locationOf(until);
+        reportAt({ start, end }, message, fix);
+      },
+      reportAt,
+    });
+  }
+
+  return diagnostics.sort(
+    (a, b) => a.location.start.offset - b.location.start.offset
+  );
+}
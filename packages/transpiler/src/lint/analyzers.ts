@@ -0,0 +1,229 @@
+// Analyzers: lint rules shipped as WebAssembly modules
+//
+// Organizations encode their conventions as analyzers written in any
+// language compiling to WebAssembly, such as Rust, Go (TinyGo) or C, and
+// projects list them with their JavaScript lint plugins. An analyzer gets
+// a script as JSON, with its AST and symbol table, and answers with the
+// problems it found, so it never depends on the transpiler's own code.
+// Values cross the boundary as UTF-8 JSON in the module's memory, passed
+// as a pointer and length, or returned packed into one i64 with the
+// pointer in the high 32 bits. An analyzer exports:
+// - memory
+// - mcps_api_version() -> i32: the LINT_API_VERSION it was written for
+// - mcps_alloc(size: i32) -> i32: memory for the host to write input to
+// - mcps_rules() -> i64: its rules, [{ name, description, severity }]
+// - mcps_analyze(input: i32, length: i32) -> i64: the problems in a
+//   script, { diagnostics: [{ rule, message, start, end? }] } with 1-based
+//   { line, column } positions
+import type { LintContext, LintRule, LintSeverity } from '../lint.js';
+import { LINT_API_VERSION } from '../lint.js';
+import { getLocation, locationOf, positionAt } from '../locations.js';
+import { offsetAt } from '../syntax.js';
+
+/**
+ * The functions of an instantiated analyzer
+ */
+export interface AnalyzerExports {
+  memory: WebAssembly.Memory;
+  mcps_api_version(): number;
+  mcps_alloc(size: number): number;
+  mcps_rules(): bigint;
+  mcps_analyze(input: number, length: number): bigint;
+}
+
+interface AnalyzerPosition {
+  line: number;
+  column: number;
+}
+
+interface AnalyzerDiagnostic {
+  rule: string;
+  message: string;
+  start: AnalyzerPosition;
+  end?: AnalyzerPosition;
+}
+
+const FUNCTIONS = [
+  'mcps_api_version',
+  'mcps_alloc',
+  'mcps_rules',
+  'mcps_analyze',
+] as const;
+
+const SEVERITIES: LintSeverity[] = ['error', 'warning'];
+
+/**
+ * A copy of AST nodes with their locations, which JSON leaves out
+ */
+function withLocations(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map(withLocations);
+  }
+  if (typeof value !== 'object' || value === null) {
+    return value;
+  }
+  const location = getLocation(value);
+  return {
+    ...Object.fromEntries(
+      Object.entries(value).map(([key, item]) => [key, withLocations(item)])
+    ),
+    ...(location && { location }),
+  };
+}
+
+/**
+ * What an analyzer is given of a script
+ */
+function analyzerInput(context: LintContext): string {
+  const { symbols } = context;
+  return JSON.stringify({
+    apiVersion: LINT_API_VERSION,
+    path: context.path,
+    content: context.content,
+    statements: withLocations(context.statements),
+    symbols: symbols.symbols.map(symbol => ({
+      name: symbol.name,
+      kind: symbol.kind,
+      // Imported symbols are located in the module at path
+      ...(symbol.path !== undefined && { path: symbol.path }),
+      location: locationOf(symbol.node),
+      references: symbol.references.map(locationOf),
+    })),
+  });
+}
+
+/**
+ * Rules running an instantiated analyzer, named by where it came from in
+ * errors
+ * The analyzer looks at each script once, for all of its rules.
+ */
+export function analyzerRules(
+  exports: AnalyzerExports,
+  name: string
+): LintRule[] {
+  for (const fn of FUNCTIONS) {
+    if (typeof exports[fn] !== 'function') {
+      throw new Error(`Analyzer ${name} does not export ${fn}`);
+    }
+  }
+  if (!(exports.memory instanceof WebAssembly.Memory)) {
+    throw new Error(`Analyzer ${name} does not export its memory`);
+  }
+  const version = exports.mcps_api_version();
+  if (version > LINT_API_VERSION) {
+    throw new Error(
+      `Analyzer ${name} needs lint API version ${version}; this version of mcps has ${LINT_API_VERSION}`
+    );
+  }
+
+  const encoder = new TextEncoder();
+  const decoder = new TextDecoder();
+  const call = <T>(fn: () => bigint): T => {
+    let text: string;
+    try {
+      const packed = BigInt.asUintN(64, fn());
+      text = decoder.decode(
+        new Uint8Array(
+          exports.memory.buffer,
+          Number(packed >> 32n),
+          Number(packed & 0xffffffffn)
+        )
+      );
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      throw new Error(`Analyzer ${name} failed: ${message}`);
+    }
+    try {
+      return JSON.parse(text) as T;
+    } catch {
+      throw new Error(`Analyzer ${name} answered with invalid JSON`);
+    }
+  };
+
+  const described = call<unknown>(() => exports.mcps_rules());
+  if (
+    !Array.isArray(described) ||
+    !described.every(
+      rule =>
+        typeof rule?.name === 'string' &&
+        typeof rule.description === 'string' &&
+        SEVERITIES.includes(rule.severity)
+    )
+  ) {
+    throw new Error(
+      `Analyzer ${name} must describe its rules as [{ name, description, severity }]`
+    );
+  }
+
+  const results = new WeakMap<object, AnalyzerDiagnostic[]>();
+  const analyze = (context: LintContext): AnalyzerDiagnostic[] => {
+    const cached = results.get(context.tree);
+    if (cached) {
+      return cached;
+    }
+    const input = encoder.encode(analyzerInput(context));
+    const run = () => {
+      const pointer = exports.mcps_alloc(input.length);
+      new Uint8Array(exports.memory.buffer, pointer, input.length).set(input);
+      return exports.mcps_analyze(pointer, input.length);
+    };
+    const { diagnostics = [] } = call<{
+      diagnostics?: AnalyzerDiagnostic[];
+    }>(run);
+    for (const diagnostic of diagnostics) {
+      if (!described.some(rule => rule.name === diagnostic.rule)) {
+        throw new Error(
+          `Analyzer ${name} reported a problem of undescribed rule "${diagnostic.rule}"`
+        );
+      }
+    }
+    results.set(context.tree, diagnostics);
+    return diagnostics;
+  };
+
+  return described.map(
+    ({ name: rule, description, severity }): LintRule => ({
+      name: rule,
+      description,
+      severity,
+      check(context) {
+        const { content } = context;
+        const position = ({ line, column }: AnalyzerPosition) =>
+          positionAt(
+            content,
+            offsetAt(content, { row: line - 1, column: column - 1 })
+          );
+        for (const diagnostic of analyze(context)) {
+          if (diagnostic.rule === rule) {
+            context.reportAt(
+              {
+                start: position(diagnostic.start),
+                end: position(diagnostic.end ?? diagnostic.start),
+              },
+              diagnostic.message
+            );
+          }
+        }
+      },
+    })
+  );
+}
+
+/**
+ * Compile and instantiate an analyzer, returning its rules
+ * Analyzers are given no imports, so they cannot reach files, the network
+ * or anything else outside their memory.
+ */
+export async function loadAnalyzer(
+  wasm: Uint8Array,
+  name: string
+): Promise<LintRule[]> {
+  let instance: WebAssembly.Instance;
+  try {
+    ({ instance } = await WebAssembly.instantiate(wasm, {}));
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`Analyzer ${name} could not be loaded: ${message}`);
+  }
+  return analyzerRules(instance.exports as unknown as AnalyzerExports, name);
+}
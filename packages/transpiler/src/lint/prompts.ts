@@ -0,0 +1,244 @@
+// Prompt lint pack: checks of the text scripts send to models
+//
+// The rules look at the text of prompt declarations and the systemPrompt
+// strings of agents, using heuristics rather than a model: placeholders a
+// template left unfilled, instructions that contradict each other, and
+// system prompts taking much of their model's context window. Prompts read
+// from files are not checked, since lint only sees the script. The pack is
+// off unless a project enables it.
+import type { LintRule } from '../lint.js';
+import { parseStringLiteral } from '../parser/expressions.js';
+import type { SymbolTable } from '../semantics.js';
+import type { SyntaxNode, SyntaxTree } from '../syntax.js';
+import { unwrap } from './rules.js';
+
+/** Share of a model's context window a system prompt may take */
+export const PROMPT_WINDOW_SHARE = 0.5;
+
+/** Template placeholders and markers of text still to be written */
+const PLACEHOLDER_PATTERNS = [
+  /\{\{[^{}\n]*\}\}/g,
+  /\[(?:insert|your|add|fill in)\b[^\]\n]*\]/gi,
+  /<(?:insert|your|add|fill in)\b[^>\n]*>/gi,
+  /\b(?:TODO|TBD|FIXME)\b/g,
+];
+
+/** Instructions that ask for opposite things */
+const OPPOSITES: [RegExp, RegExp][] = [
+  [
+    /\b(?:be (?:brief|concise)|keep (?:it|answers|responses) (?:short|brief))\b/i,
+    /\b(?:be (?:detailed|thorough|exhaustive)|in (?:great |full )?detail)\b/i,
+  ],
+  [
+    /\b(?:respond|answer|reply) (?:only )?(?:in|with|as) json\b/i,
+    /\b(?:respond|answer|reply) (?:only )?(?:in|with|as) (?:markdown|plain text|prose)\b/i,
+  ],
+];
+
+/** Words after "always" or "never" compared to find contradictions */
+const ACTION_WORDS = 3;
+const ALWAYS = /\balways ((?:[a-z']+ ?){1,3})/gi;
+const NEVER = /\b(?:never|do not|don't) ((?:[a-z']+ ?){1,3})/gi;
+
+/**
+ * Whether the words after "always" and after "never" name the same action:
+ * the same words, or the words of a clause that ends early starting the
+ * other
+ */
+function sameAction(first: string, second: string): boolean {
+  const [shorter, longer] = [words(first), words(second)].sort(
+    (a, b) => a.length - b.length
+  );
+  return (
+    (shorter.length < ACTION_WORDS || shorter.length === longer.length) &&
+    shorter.every((word, index) => word === longer[index])
+  );
+}
+
+function words(text: string): string[] {
+  return text.trim().toLowerCase().split(/\s+/);
+}
+
+/**
+ * A prompt text and the string node it is written in
+ */
+interface PromptText {
+  node: SyntaxNode;
+  text: string;
+  /** Whether ${...} references are resolved in it, as in prompts */
+  interpolated: boolean;
+}
+
+/**
+ * Properties of a declaration's object literal, by name
+ */
+function declarationProperties(
+  declaration: SyntaxNode
+): Map<string, SyntaxNode> {
+  const properties =
+    declaration.namedChildren
+      .find(child => child.type === 'object_literal')
+      ?.namedChildren.find(child => child.type === 'property_list')
+      ?.namedChildren.filter(property => property.type === 'property') ?? [];
+  return new Map(
+    properties.map(property => [
+      property.firstNamedChild!.text,
+      unwrap(property.lastNamedChild!),
+    ])
+  );
+}
+
+function stringValue(node: SyntaxNode | undefined): string | undefined {
+  return node?.type === 'string' ? parseStringLiteral(node).value : undefined;
+}
+
+/**
+ * Texts of the script's prompt declarations and literal system prompts
+ */
+function promptTexts(tree: SyntaxTree): PromptText[] {
+  const texts: PromptText[] = [];
+  for (const prompt of tree.rootNode.descendantsOfType('prompt_declaration')) {
+    const node = declarationProperties(prompt).get('text');
+    const text = stringValue(node);
+    if (text !== undefined) {
+      texts.push({ node: node!, text, interpolated: true });
+    }
+  }
+  for (const agent of tree.rootNode.descendantsOfType('agent_declaration')) {
+    const node = declarationProperties(agent).get('systemPrompt');
+    const text = stringValue(node);
+    if (text !== undefined) {
+      texts.push({ node: node!, text, interpolated: false });
+    }
+  }
+  return texts;
+}
+
+/**
+ * The declaration of a symbol an identifier names, if it is of a type
+ */
+function declarationOf(
+  symbols: SymbolTable,
+  node: SyntaxNode | undefined,
+  type: string
+): SyntaxNode | undefined {
+  const symbol = node?.type === 'identifier' ? symbols.lookup(node) : undefined;
+  const declaration = symbol?.node.parent;
+  return declaration?.type === type ? declaration : undefined;
+}
+
+/**
+ * Tokens a text takes, estimated like the runtime does for conversations
+ */
+function estimateTokens(text: string): number {
+  return Math.ceil(text.length / 4);
+}
+
+export const promptPlaceholder: LintRule = {
+  name: 'prompt-placeholder',
+  description: 'Prompts with template placeholders left unfilled',
+  severity: 'warning',
+  check({ tree, report }) {
+    for (const { node, text, interpolated } of promptTexts(tree)) {
+      const found = new Set<string>();
+      for (const pattern of PLACEHOLDER_PATTERNS) {
+        for (const [match] of text.matchAll(pattern)) {
+          found.add(match);
+        }
+      }
+      // Only prompt declarations resolve references; agents send them as is
+      if (!interpolated) {
+        for (const [match] of text.matchAll(/\$\{[^}\n]*\}/g)) {
+          found.add(match);
+        }
+      }
+      for (const placeholder of found) {
+        report(node, `Prompt has an unfilled placeholder '${placeholder}'`);
+      }
+    }
+  },
+};
+
+export const promptConflict: LintRule = {
+  name: 'prompt-conflict',
+  description: 'Prompts with instructions that contradict each other',
+  severity: 'warning',
+  check({ tree, report }) {
+    for (const { node, text } of promptTexts(tree)) {
+      for (const [first, second] of OPPOSITES) {
+        const asked = first.exec(text);
+        const contrary = second.exec(text);
+        if (asked && contrary) {
+          report(
+            node,
+            `Prompt asks for both '${asked[0]}' and '${contrary[0]}'`
+          );
+        }
+      }
+
+      const required = [...text.matchAll(ALWAYS)];
+      for (const forbidden of text.matchAll(NEVER)) {
+        const match = required.find(always =>
+          sameAction(always[1], forbidden[1])
+        );
+        if (match) {
+          report(
+            node,
+            `Prompt asks for both '${match[0].trim()}' and ` +
+              `'${forbidden[0].trim()}'`
+          );
+        }
+      }
+    }
+  },
+};
+
+export const promptLength: LintRule = {
+  name: 'prompt-length',
+  description: "System prompts taking much of their model's context window",
+  severity: 'warning',
+  check({ tree, symbols, report }) {
+    for (const agent of tree.rootNode.descendantsOfType('agent_declaration')) {
+      const properties = declarationProperties(agent);
+      const modelName = properties.get('model');
+      const model = declarationOf(symbols, modelName, 'model_declaration');
+      const windowNode =
+        model && declarationProperties(model).get('contextWindow');
+      const window =
+        windowNode?.type === 'number' ? Number(windowNode.text) : undefined;
+      const systemPrompt = properties.get('systemPrompt');
+      if (!window || !systemPrompt) {
+        continue;
+      }
+
+      // A declared prompt is measured by its text
+      const prompt = declarationOf(symbols, systemPrompt, 'prompt_declaration');
+      const text = stringValue(
+        prompt ? declarationProperties(prompt).get('text') : systemPrompt
+      );
+      if (text === undefined) {
+        continue;
+      }
+      const tokens = estimateTokens(text);
+      if (tokens > window * PROMPT_WINDOW_SHARE) {
+        const name = agent.namedChildren.find(
+          child => child.type === 'identifier'
+        )!.text;
+        report(
+          systemPrompt,
+          `System prompt of agent '${name}' takes about ${tokens} of the ` +
+            `${window} tokens of model '${modelName!.text}'`
+        );
+      }
+    }
+  },
+};
+
+/**
+ * Rules of the prompt lint pack, enabled with `lint.packs` in `.mcpsrc`
+ */
+export const PROMPT_LINT_RULES: LintRule[] = [
+  promptPlaceholder,
+  promptConflict,
+  promptLength,
+];
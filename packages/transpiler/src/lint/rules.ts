@@ -0,0 +1,184 @@
+// Built-in lint rules
+import type { LintRule } from '../lint.js';
+import type {
+  Scope,
+  ScriptSymbol,
+  ScriptSymbolKind,
+} from '../semantics.js';
+import type { SyntaxNode } from '../syntax.js';
+
+/** Statements that leave their block, by the keyword that starts them */
+const EXITS: Record<string, string> = {
+  return_statement: 'return',
+  break_statement: 'break',
+  continue_statement: 'continue',
+};
+
+/** Kinds of symbols that a scope may only declare once */
+const DECLARED_ONCE = new Set<ScriptSymbolKind>([
+  'tool',
+  'mcp',
+  'model',
+  'agent',
+  'prompt',
+  'parameter',
+]);
+
+/**
+ * The node an expression, literal or parenthesized expression wraps
+ */
+export function unwrap(node: SyntaxNode): SyntaxNode {
+  let current = node;
+  while (
+    ['expression', 'literal', 'parenthesized_expression'].includes(
+      current.type
+    ) &&
+    current.firstNamedChild
+  ) {
+    current = current.firstNamedChild;
+  }
+  return current;
+}
+
+/**
+ * Article and name of a symbol kind, for messages
+ */
+function describeKind(kind: ScriptSymbolKind): string {
+  switch (kind) {
+    case 'mcp':
+      return 'an MCP server';
+    case 'agent':
+      return 'an agent';
+    default:
+      return `a ${kind}`;
+  }
+}
+
+export const unusedVariable: LintRule = {
+  name: 'unused-variable',
+  description: 'Variables that are assigned but never read',
+  severity: 'warning',
+  check({ symbols, report }) {
+    for (const symbol of symbols.symbols) {
+      if (
+        symbol.kind === 'variable' &&
+        symbol.references.length === 0 &&
+        !symbol.name.startsWith('_')
+      ) {
+        report(symbol.node, `'${symbol.name}' is assigned but never used`);
+      }
+    }
+  },
+};
+
+export const unreachableCode: LintRule = {
+  name: 'unreachable-code',
+  description: 'Statements after a return, break or continue',
+  severity: 'warning',
+  check({ tree, report }) {
+    const containers = [
+      tree.rootNode,
+      ...tree.rootNode.descendantsOfType('block_statement'),
+    ];
+    for (const container of containers) {
+      const statements = container.namedChildren.filter(
+        child =>
+          child.type === 'statement' &&
+          child.firstNamedChild?.type !== 'comment'
+      );
+      const exit = statements.findIndex(
+        statement => EXITS[statement.firstNamedChild?.type ?? '']
+      );
+      if (exit !== -1 && exit < statements.length - 1) {
+        const keyword = EXITS[statements[exit].firstNamedChild!.type];
+        report(
+          statements[exit + 1],
+          `Unreachable code after '${keyword}'`,
+          statements[statements.length - 1]
+        );
+      }
+    }
+  },
+};
+
+export const duplicateDeclaration: LintRule = {
+  name: 'duplicate-declaration',
+  description: 'Tools, servers, models, agents or parameters declared twice',
+  severity: 'error',
+  check({ symbols, report }) {
+    const visit = (scope: Scope) => {
+      const declared = new Map<string, ScriptSymbol>();
+      for (const symbol of scope.symbols) {
+        if (!DECLARED_ONCE.has(symbol.kind)) {
+          continue;
+        }
+        const previous = declared.get(symbol.name);
+        if (!previous) {
+          declared.set(symbol.name, symbol);
+        } else if (symbol.path === undefined) {
+          const where = previous.path ? ` in ${previous.path}` : '';
+          report(symbol.node, `'${symbol.name}' is already declared${where}`);
+        }
+      }
+      scope.children.forEach(visit);
+    };
+    visit(symbols.root);
+  },
+};
+
+export const unknownMcpServer: LintRule = {
+  name: 'unknown-mcp-server',
+  description: "Agent tools that are not tools, agents or MCP servers' tools",
+  severity: 'error',
+  check({ tree, symbols, report }) {
+    for (const agent of tree.rootNode.descendantsOfType('agent_declaration')) {
+      const tools = agent.namedChildren
+        .find(child => child.type === 'object_literal')
+        ?.namedChildren.find(child => child.type === 'property_list')
+        ?.namedChildren.find(
+          property =>
+            property.type === 'property' &&
+            property.firstNamedChild?.text === 'tools'
+        )?.lastNamedChild;
+      const list = tools ? unwrap(tools) : undefined;
+      if (list?.type !== 'array_literal') {
+        continue;
+      }
+
+      for (const element of list.namedChildren) {
+        const tool = unwrap(element);
+        // server.tool names a tool of the server; a bare name is a tool,
+        // an agent to delegate to or a whole server
+        const isMember = tool.type === 'member_expression';
+        const name = isMember ? unwrap(tool.firstNamedChild!) : tool;
+        const symbol =
+          name.type === 'identifier' ? symbols.lookup(name) : undefined;
+        if (!symbol || ['variable', 'parameter'].includes(symbol.kind)) {
+          // Undefined names are reported by the checker, and variables
+          // may hold anything
+          continue;
+        }
+        const allowed = isMember ? ['mcp'] : ['mcp', 'tool', 'agent'];
+        if (!allowed.includes(symbol.kind)) {
+          const expected = isMember
+            ? 'an MCP server'
+            : 'a tool, agent or MCP server';
+          report(
+            name,
+            `'${symbol.name}' is ${describeKind(symbol.kind)}, not ${expected}`
+          );
+        }
+      }
+    }
+  },
+};
+
+/**
+ * Rules run by `lint` unless others are given
+ */
+export const BUILTIN_LINT_RULES: LintRule[] = [
+  unusedVariable,
+  unreachableCode,
+  duplicateDeclaration,
+  unknownMcpServer,
+];
@@ -0,0 +1,311 @@
+// Checks of MCP tool calls against the tools' schemas
+import type { JSONSchema, ToolSchema } from '@mcpscript/runtime';
+import type { LintFix, LintRule } from '../lint.js';
+import { locationOf } from '../locations.js';
+import { parseStringLiteral } from '../parser/expressions.js';
+import type { SyntaxNode } from '../syntax.js';
+import { unwrap } from './rules.js';
+
+/**
+ * JSON type of a literal argument, or undefined when it is computed
+ */
+function literalType(node: SyntaxNode): string | undefined {
+  switch (unwrap(node).type) {
+    case 'string':
+      return 'string';
+    case 'number':
+      return 'number';
+    case 'boolean':
+      return 'boolean';
+    case 'array_literal':
+      return 'array';
+    case 'object_literal':
+      return 'object';
+    default:
+      return undefined;
+  }
+}
+
+function accepts(schema: JSONSchema, type: string, text: string): boolean {
+  if (schema.type === undefined) {
+    return true;
+  }
+  const types = Array.isArray(schema.type) ? schema.type : [schema.type];
+  return types.some(
+    expected =>
+      expected === type ||
+      (expected === 'integer' && type === 'number' && /^-?\d+$/.test(text))
+  );
+}
+
+function describeTypes(schema: JSONSchema): string {
+  const types = Array.isArray(schema.type) ? schema.type : [schema.type];
+  return types.join(' or ');
+}
+
+/**
+ * Value of a string or number literal, or undefined for other nodes and
+ * interpolated strings
+ */
+function literalValue(node: SyntaxNode): string | number | undefined {
+  const literal = unwrap(node);
+  if (literal.type === 'number') {
+    return Number(literal.text);
+  }
+  if (literal.type === 'string' && !literal.text.includes('${')) {
+    return parseStringLiteral(literal).value;
+  }
+  return undefined;
+}
+
+/**
+ * The property nodes of an object literal
+ */
+function literalProperties(object: SyntaxNode): SyntaxNode[] {
+  return (
+    object.namedChildren
+      .find(child => child.type === 'property_list')
+      ?.namedChildren.filter(property => property.type === 'property') ?? []
+  );
+}
+
+/**
+ * Source of a value a fix fills a new property with, by its schema
+ */
+function placeholder(schema: JSONSchema): string {
+  if (Array.isArray(schema.enum) && schema.enum.length > 0) {
+    return JSON.stringify(schema.enum[0]);
+  }
+  const [type] = Array.isArray(schema.type) ? schema.type : [schema.type];
+  switch (type) {
+    case 'string':
+      return '""';
+    case 'number':
+    case 'integer':
+      return '0';
+    case 'boolean':
+      return 'false';
+    case 'array':
+      return '[]';
+    case 'object':
+      return '{}';
+    default:
+      return 'null';
+  }
+}
+
+/**
+ * A fix adding a property to an object literal, after its last one
+ */
+function addPropertyFix(
+  object: SyntaxNode,
+  name: string,
+  schema: JSONSchema
+): LintFix {
+  const properties = literalProperties(object);
+  const last = properties[properties.length - 1];
+  const property = `${name}: ${placeholder(schema)}`;
+  let after: SyntaxNode;
+  let newText: string;
+  if (!last) {
+    after = object.firstChild!;
+    newText = ` ${property} `;
+  } else if (last.nextSibling?.type === ',') {
+    after = last.nextSibling;
+    newText = ` ${property}`;
+  } else {
+    after = last;
+    newText = `, ${property}`;
+  }
+  const { end } = locationOf(after);
+  return {
+    title: `Add required property '${name}'`,
+    edits: [{ location: { start: end, end }, newText }],
+  };
+}
+
+function allowsOtherProperties(schema: JSONSchema): boolean {
+  return (
+    !schema.properties ||
+    schema.additionalProperties === true ||
+    typeof schema.additionalProperties === 'object'
+  );
+}
+
+/**
+ * A rule checking calls such as `filesystem.readFile(path)` against the
+ * tool schemas of the servers they go to: the tool must exist, and the
+ * arguments must match its parameters in number, name and literal type
+ * Object and array literals are checked against the schemas of their
+ * properties and items all the way down, with a fix adding each missing
+ * required property; servers without schemas are not checked
+ */
+export function toolCallRule(servers: Record<string, ToolSchema[]>): LintRule {
+  return {
+    name: 'tool-call',
+    description: 'MCP tool calls that do not match the tool schema',
+    severity: 'error',
+    check({ tree, symbols, report }) {
+      /**
+       * Report the required properties an object literal is missing, at
+       * a node
+       */
+      const checkRequired = (
+        object: SyntaxNode,
+        schema: JSONSchema,
+        path: string,
+        label: string,
+        at: SyntaxNode
+      ) => {
+        const names = new Set(
+          literalProperties(object).map(p => p.firstNamedChild!.text)
+        );
+        for (const name of schema.required ?? []) {
+          if (!names.has(name)) {
+            report(
+              at,
+              `Missing required parameter '${path}${name}' of ${label}`,
+              at,
+              addPropertyFix(object, name, schema.properties?.[name] ?? {})
+            );
+          }
+        }
+      };
+
+      /**
+       * Check the properties of an object literal, whose names are
+       * reported under a path such as options.
+       */
+      const checkProperties = (
+        object: SyntaxNode,
+        schema: JSONSchema,
+        path: string,
+        label: string
+      ) => {
+        const properties = schema.properties ?? {};
+        for (const property of literalProperties(object)) {
+          const name = property.firstNamedChild!.text;
+          if (!properties[name] && !allowsOtherProperties(schema)) {
+            report(property, `${label} has no parameter '${path}${name}'`);
+          } else if (properties[name]) {
+            checkLiteral(
+              property.lastNamedChild!,
+              properties[name],
+              `${path}${name}`,
+              label
+            );
+          }
+        }
+      };
+
+      /**
+       * Check a literal against a schema: its type and allowed values,
+       * and the properties and items of object and array literals
+       */
+      const checkLiteral = (
+        node: SyntaxNode,
+        schema: JSONSchema,
+        path: string,
+        label: string
+      ) => {
+        const type = literalType(node);
+        if (!type) {
+          return;
+        }
+        if (!accepts(schema, type, node.text)) {
+          report(
+            node,
+            `Parameter '${path}' of ${label} must be ` +
+              `${describeTypes(schema)}, got ${type}`
+          );
+          return;
+        }
+        const value = literalValue(node);
+        if (
+          Array.isArray(schema.enum) &&
+          value !== undefined &&
+          !schema.enum.includes(value)
+        ) {
+          report(
+            node,
+            `Parameter '${path}' of ${label} must be one of ` +
+              `${schema.enum.map(v => JSON.stringify(v)).join(', ')}, ` +
+              `got ${JSON.stringify(value)}`
+          );
+          return;
+        }
+        const literal = unwrap(node);
+        if (type === 'object' && schema.properties) {
+          checkProperties(literal, schema, `${path}.`, label);
+          checkRequired(literal, schema, `${path}.`, label, literal);
+        } else if (type === 'array' && schema.items) {
+          const items = schema.items;
+          literal.namedChildren.forEach((item, index) =>
+            checkLiteral(item, items, `${path}[${index}]`, label)
+          );
+        }
+      };
+
+      for (const call of tree.rootNode.descendantsOfType('call_expression')) {
+        const callee = call.firstNamedChild && unwrap(call.firstNamedChild);
+        if (callee?.type !== 'member_expression') {
+          continue;
+        }
+        const object = unwrap(callee.firstNamedChild!);
+        const symbol =
+          object.type === 'identifier' ? symbols.lookup(object) : undefined;
+        const tools = symbol?.kind === 'mcp' ? servers[symbol.name] : undefined;
+        if (!tools) {
+          continue;
+        }
+
+        const toolName = callee.lastNamedChild!;
+        const tool = tools.find(t => t.name === toolName.text);
+        if (!tool) {
+          report(
+            toolName,
+            `MCP server '${symbol!.name}' has no tool '${toolName.text}'`
+          );
+          continue;
+        }
+
+        const label = `${symbol!.name}.${tool.name}`;
+        const schema = tool.inputSchema;
+        const properties = schema.properties ?? {};
+        const args =
+          call.namedChildren.find(child => child.type === 'argument_list')
+            ?.namedChildren ?? [];
+
+        // A single object literal names the arguments
+        const named = args.length === 1 ? unwrap(args[0]) : undefined;
+        if (named?.type === 'object_literal') {
+          checkProperties(named, schema, '', label);
+          checkRequired(named, schema, '', label, call);
+          continue;
+        }
+
+        const names = Object.keys(properties);
+        if (schema.properties && args.length > names.length) {
+          report(
+            call,
+            `${label} takes at most ${names.length} arguments, ` +
+              `got ${args.length}`
+          );
+        }
+        args.forEach((arg, index) => {
+          if (index < names.length) {
+            checkLiteral(arg, properties[names[index]], names[index], label);
+          }
+        });
+        // A single computed argument may be an object of named arguments
+        const computed = args.length === 1 && !literalType(args[0]);
+        const required = computed ? [] : (schema.required ?? []);
+        for (const name of names.slice(args.length)) {
+          if (required.includes(name)) {
+            report(call, `Missing required parameter '${name}' of ${label}`);
+          }
+        }
+      }
+    },
+  };
+}
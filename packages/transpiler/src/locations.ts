@@ -0,0 +1,147 @@
+// Source locations for AST nodes
+import type Parser from 'tree-sitter';
+import { currentArena } from './arena.js';
+
+/**
+ * A position in the source text (line and column are 1-based)
+ */
+export interface SourcePosition {
+  line: number;
+  column: number;
+  /** Byte offset from the start of the source */
+  offset: number;
+}
+
+/**
+ * The source range an AST node was parsed from
+ */
+export interface SourceLocation {
+  start: SourcePosition;
+  end: SourcePosition;
+}
+
+/**
+ * Locations are kept in a side table rather than on the nodes themselves,
+ * so AST objects keep their plain shape for codegen and structural comparison
+ */
+const locations = new WeakMap<object, SourceLocation>();
+
+/**
+ * Build a source location from a tree-sitter syntax node
+ */
+export function locationOf(node: Parser.SyntaxNode): SourceLocation {
+  return {
+    start: {
+      line: node.startPosition.row + 1,
+      column: node.startPosition.column + 1,
+      offset: node.startIndex,
+    },
+    end: {
+      line: node.endPosition.row + 1,
+      column: node.endPosition.column + 1,
+      offset: node.endIndex,
+    },
+  };
+}
+
+/**
+ * The position of an offset of source text
+ */
+export function positionAt(content: string, offset: number): SourcePosition {
+  const before = content.slice(0, offset);
+  const line = before.split('\n').length;
+  return { line, column: offset - before.lastIndexOf('\n'), offset };
+}
+
+/**
+ * Record the location of an AST node (the first recorded location wins,
+ * so wrapper nodes never override the more precise inner node)
+ */
+export function setLocation<T extends object>(
+  astNode: T,
+  syntaxNode: Parser.SyntaxNode
+): T {
+  if (!locations.has(astNode)) {
+    const arena = currentArena();
+    locations.set(
+      astNode,
+      arena ? arena.location(syntaxNode) : locationOf(syntaxNode)
+    );
+  }
+  return astNode;
+}
+
+/**
+ * Remove the location of an AST node, returning it
+ * Used when arenas release nodes for reuse
+ */
+export function forgetLocation(astNode: object): SourceLocation | undefined {
+  const location = locations.get(astNode);
+  locations.delete(astNode);
+  return location;
+}
+
+/**
+ * Copy the location of one AST node to another (used for synthesized nodes)
+ */
+export function copyLocation<T extends object>(target: T, source: object): T {
+  const location = locations.get(source);
+  if (location && !locations.has(target)) {
+    locations.set(target, location);
+  }
+  return target;
+}
+
+/**
+ * Get the source location of an AST node, if it came from the parser
+ */
+export function getLocation(astNode: object): SourceLocation | undefined {
+  return locations.get(astNode);
+}
+
+/**
+ * Format a location as "line:column"
+ */
+export function formatLocation(location: SourceLocation | undefined): string {
+  return location ? `${location.start.line}:${location.start.column}` : '?:?';
+}
+
+/**
+ * Visit every object of an AST in a fixed order: depth first, fields and
+ * array elements in order
+ */
+function walkNodes(node: unknown, visit: (node: object) => void): void {
+  if (Array.isArray(node)) {
+    node.forEach(child => walkNodes(child, visit));
+  } else if (node && typeof node === 'object') {
+    visit(node);
+    Object.values(node).forEach(child => walkNodes(child, visit));
+  }
+}
+
+/**
+ * The locations of every node of an AST, in walk order
+ * Copies of an AST made by structured clone, such as ones posted from a
+ * worker thread, lose their locations; restoreLocations() puts these back.
+ */
+export function collectLocations(ast: object): (SourceLocation | null)[] {
+  const collected: (SourceLocation | null)[] = [];
+  walkNodes(ast, node => collected.push(locations.get(node) ?? null));
+  return collected;
+}
+
+/**
+ * Give the nodes of a copied AST the locations collected from the original
+ */
+export function restoreLocations(
+  ast: object,
+  collected: (SourceLocation | null)[]
+): void {
+  let index = 0;
+  walkNodes(ast, node => {
+    const location = collected[index++];
+    if (location && !locations.has(node)) {
+      locations.set(node, location);
+    }
+  });
+}
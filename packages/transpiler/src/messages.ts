@@ -0,0 +1,93 @@
+// Translated diagnostic messages
+//
+// Diagnostics are written in English where they are found. A message
+// catalog translates their text for readers of another language, while
+// their codes and rule names stay as they are, so tools can keep matching
+// on those. A catalog maps English messages to their translations, with
+// {name} standing for the parts that vary:
+//
+//   { "Missing argument '{parameter}' in call to tool '{tool}'":
+//       "Fehlendes Argument '{parameter}' im Aufruf von Tool '{tool}'" }
+//
+// The parts are translated in turn, so a message quoting another one is
+// translated as a whole. Messages no entry matches stay in English.
+import { GERMAN_MESSAGES } from './messages/de.js';
+
+/**
+ * Translations of English messages, tried in order; put an entry before
+ * the shorter ones that would match the same messages
+ */
+export type MessageCatalog = Record<string, string>;
+
+/**
+ * The built-in catalogs by language
+ */
+export const MESSAGE_CATALOGS: Record<string, MessageCatalog> = {
+  de: GERMAN_MESSAGES,
+};
+
+/**
+ * The built-in catalog of a language such as "de" or "de-AT", if any
+ */
+export function messageCatalog(language: string): MessageCatalog | undefined {
+  const [base] = language.toLowerCase().split(/[-_.]/);
+  return MESSAGE_CATALOGS[language] ?? MESSAGE_CATALOGS[base];
+}
+
+interface CompiledMessage {
+  pattern: RegExp;
+  parts: string[];
+  translation: string;
+}
+
+const PLACEHOLDER = /\{(\w+)\}/g;
+
+function compile(message: string, translation: string): CompiledMessage {
+  const parts: string[] = [];
+  let source = '';
+  message.split(PLACEHOLDER).forEach((text, index) => {
+    if (index % 2 === 1) {
+      parts.push(text);
+      source += '(.+?)';
+    } else {
+      source += text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+    }
+  });
+  if (parts.length > 0 && message.replace(PLACEHOLDER, '') === '') {
+    throw new Error(`Message "${message}" has no text to recognize it by`);
+  }
+  return { pattern: new RegExp(`^${source}$`, 's'), parts, translation };
+}
+
+/**
+ * Translates messages with one or more catalogs, the earlier catalogs
+ * taking precedence
+ */
+export class MessageTranslator {
+  private readonly messages: CompiledMessage[];
+
+  constructor(...catalogs: MessageCatalog[]) {
+    this.messages = catalogs.flatMap(catalog =>
+      Object.entries(catalog).map(([message, translation]) =>
+        compile(message, translation)
+      )
+    );
+  }
+
+  translate(message: string): string {
+    for (const { pattern, parts, translation } of this.messages) {
+      const match = pattern.exec(message);
+      if (!match) {
+        continue;
+      }
+      const values = new Map(
+        parts.map((part, index) => [part, this.translate(match[index + 1])])
+      );
+      return translation.replace(
+        PLACEHOLDER,
+        (text, part: string) => values.get(part) ?? text
+      );
+    }
+    return message;
+  }
+}
@@ -0,0 +1,93 @@
+// German diagnostic messages
+import type { MessageCatalog } from '../messages.js';
+
+export const GERMAN_MESSAGES: MessageCatalog = {
+  // Syntax errors
+  'Parse error at line {line}, column {column}: {message}':
+    'Syntaxfehler in Zeile {line}, Spalte {column}: {message}',
+  'Parse error: The source code contains syntax errors':
+    'Syntaxfehler: Der Quelltext enthält Syntaxfehler',
+  'Missing "{token}"': '"{token}" fehlt',
+  'Unexpected syntax near "{text}"': 'Unerwartete Syntax bei "{text}"',
+  'Unexpected syntax': 'Unerwartete Syntax',
+  'Unterminated string literal': 'Nicht abgeschlossene Zeichenkette',
+  'Missing closing brace "}"': 'Schließende geschweifte Klammer "}" fehlt',
+  'Missing closing parenthesis ")"': 'Schließende runde Klammer ")" fehlt',
+  'Missing closing bracket "]"': 'Schließende eckige Klammer "]" fehlt',
+  'Missing type in type annotation': 'Typ in der Typannotation fehlt',
+  'Incomplete expression: expected value after "{operator}" operator':
+    'Unvollständiger Ausdruck: Wert nach dem Operator "{operator}" erwartet',
+  'Incomplete union type: expected type after "|"':
+    'Unvollständiger Union-Typ: Typ nach "|" erwartet',
+  'Incomplete agent delegation: expected agent name after "->"':
+    'Unvollständige Delegation: Agentenname nach "->" erwartet',
+  'Incomplete member access: expected property name after "."':
+    'Unvollständiger Zugriff: Eigenschaftsname nach "." erwartet',
+  'Invalid tool declaration: expected tool name before parameter list':
+    'Ungültige Tool-Deklaration: Toolname vor der Parameterliste erwartet',
+  'Missing name in {kind} declaration': 'Name in der {kind}-Deklaration fehlt',
+  'Expected "{" to start {kind} declaration body':
+    '"{" am Anfang der {kind}-Deklaration erwartet',
+  'Missing condition in if statement: expected "(...)"':
+    'Bedingung der if-Anweisung fehlt: "(...)" erwartet',
+  'Missing condition in while statement: expected "(...)"':
+    'Bedingung der while-Anweisung fehlt: "(...)" erwartet',
+
+  // Names and types
+  "Undefined variable: '{variable}' in {context}":
+    "Undefinierte Variable: '{variable}' in {context}",
+  "Undefined variable: '{variable}'": "Undefinierte Variable: '{variable}'",
+  "Tool '{tool}' expects at most {count} argument(s), but got {given}":
+    "Tool '{tool}' erwartet höchstens {count} Argument(e), erhielt aber {given}",
+  "Missing argument '{parameter}' in call to tool '{tool}'":
+    "Fehlendes Argument '{parameter}' im Aufruf von Tool '{tool}'",
+  "Argument of type '{type}' is not assignable to parameter '{parameter}' of type '{expected}'":
+    "Ein Argument vom Typ '{type}' passt nicht zum Parameter '{parameter}' vom Typ '{expected}'",
+  "Default value of type '{type}' is not assignable to parameter '{parameter}' of type '{expected}'":
+    "Ein Standardwert vom Typ '{type}' passt nicht zum Parameter '{parameter}' vom Typ '{expected}'",
+  "Type '{type}' is not assignable to return type '{expected}' of tool '{tool}'":
+    "Typ '{type}' passt nicht zum Rückgabetyp '{expected}' von Tool '{tool}'",
+  "Tool '{tool}' must return a value of type '{type}'":
+    "Tool '{tool}' muss einen Wert vom Typ '{type}' zurückgeben",
+  "Tool '{tool}' declares return type '{type}' but never returns a value":
+    "Tool '{tool}' deklariert den Rückgabetyp '{type}', gibt aber nie einen Wert zurück",
+  "Duplicate property '{name}' in object type":
+    "Doppelte Eigenschaft '{name}' im Objekttyp",
+  "Parallel limit must be a number, got '{type}'":
+    "Das Limit von parallel muss eine Zahl sein, nicht '{type}'",
+  "parallelMap limit must be a number, got '{type}'":
+    "Das Limit von parallelMap muss eine Zahl sein, nicht '{type}'",
+
+  // Lint rules
+  "'{name}' is assigned but never used":
+    "'{name}' wird zugewiesen, aber nie verwendet",
+  "Unreachable code after '{keyword}'": "Unerreichbarer Code nach '{keyword}'",
+  "'{name}' is already declared in {path}":
+    "'{name}' ist bereits in {path} deklariert",
+  "'{name}' is already declared": "'{name}' ist bereits deklariert",
+  "MCP server '{server}' has no tool '{tool}'":
+    "MCP-Server '{server}' hat kein Tool '{tool}'",
+  "Missing required parameter '{name}' of {tool}":
+    "Pflichtparameter '{name}' von {tool} fehlt",
+  "{tool} has no parameter '{name}'": "{tool} hat keinen Parameter '{name}'",
+  '{tool} takes at most {count} arguments, got {given}':
+    '{tool} nimmt höchstens {count} Argumente, erhielt aber {given}',
+  "Prompt has an unfilled placeholder '{placeholder}'":
+    "Prompt enthält einen nicht ausgefüllten Platzhalter '{placeholder}'",
+  "Prompt asks for both '{first}' and '{second}'":
+    "Prompt verlangt sowohl '{first}' als auch '{second}'",
+  "System prompt of agent '{agent}' takes about {tokens} of the {window} tokens of model '{model}'":
+    "Systemprompt von Agent '{agent}' belegt etwa {tokens} der {window} Tokens von Modell '{model}'",
+  'Environment variable {name} is not set':
+    'Umgebungsvariable {name} ist nicht gesetzt',
+
+  // Runtime errors
+  'Invalid call to {tool}: {problems}':
+    'Ungültiger Aufruf von {tool}: {problems}',
+  'missing required parameter "{name}"': 'Pflichtparameter "{name}" fehlt',
+  'unknown parameter "{name}"': 'unbekannter Parameter "{name}"',
+  'main has no parameter named {name}':
+    'main hat keinen Parameter namens {name}',
+  'Invalid arguments of main: {problems}':
+    'Ungültige Argumente von main: {problems}',
+};
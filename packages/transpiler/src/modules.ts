@@ -0,0 +1,451 @@
+// Scripts split across several files: module loading and linking
+import { existsSync, readdirSync, readFileSync } from 'fs';
+import path, { type PlatformPath } from 'path';
+import type { ImportStatement, Statement } from './ast.js';
+import { ParseError, statementsFromTree } from './parser.js';
+import { parseTree, type SyntaxTree } from './syntax.js';
+import { ParseLimitError } from './limits.js';
+import { formatLocation, getLocation } from './locations.js';
+
+export const MODULE_EXTENSION = '.mcps';
+
+/**
+ * Finds and reads the modules named by import statements
+ */
+export interface ModuleLoader {
+  /**
+   * Identify the module a specifier refers to
+   * Throws if no such module can be found
+   */
+  resolve(specifier: string, importer: string): string;
+  /** Source text of a resolved module */
+  read(modulePath: string): string;
+  /**
+   * Path of a file a module names, such as the file of a prompt, relative
+   * to the module's directory (by default resolved with the host's paths)
+   */
+  resolveFile?(file: string, importer: string): string;
+}
+
+export interface ModuleLoaderOptions {
+  /** Directories searched for module paths that are not relative */
+  paths?: string[];
+}
+
+/**
+ * An import that could not be resolved, loaded or linked
+ */
+export class ModuleError extends Error {
+  constructor(
+    message: string,
+    /** Module the problem was found in */
+    public readonly modulePath: string,
+    /** Import statement that led to the problem, if any */
+    public readonly statement?: ImportStatement
+  ) {
+    super(message);
+    this.name = 'ModuleError';
+  }
+}
+
+/**
+ * What resolving a specifier needs to know about a loader's files
+ */
+interface ModuleHost {
+  exists(modulePath: string): boolean;
+  /** Names of the files in a directory, none if it does not exist */
+  list(dir: string): string[];
+  path: PlatformPath;
+}
+
+/** A specifier naming a range of versions of a module: prompts/review@2 */
+const VERSIONED_SPECIFIER = /^(.+)@(\d+(?:\.\d+){0,2})$/;
+
+/** The file of one version of a module: review@2.1.0.mcps */
+const VERSIONED_FILE = /^(.+)@(\d+)\.(\d+)\.(\d+)\.mcps$/;
+
+function compareVersions(a: number[], b: number[]): number {
+  const i = a.findIndex((part, index) => part !== b[index]);
+  return i === -1 ? 0 : a[i] - b[i];
+}
+
+/**
+ * The newest version of a module next to where its unversioned file would
+ * be, among versions starting with the numbers of a range: 2 admits 2.1.0
+ * and 2.3.1, 2.1 only 2.1.x, and an empty range any version
+ */
+function newestVersion(
+  file: string,
+  range: number[],
+  host: ModuleHost
+): string | undefined {
+  const dir = host.path.dirname(file);
+  const name = host.path.basename(file, MODULE_EXTENSION);
+  let newest: { entry: string; version: number[] } | undefined;
+  for (const entry of host.list(dir)) {
+    const match = VERSIONED_FILE.exec(entry);
+    if (!match || match[1] !== name) {
+      continue;
+    }
+    const version = match.slice(2).map(Number);
+    if (range.some((part, index) => version[index] !== part)) {
+      continue;
+    }
+    if (!newest || compareVersions(version, newest.version) > 0) {
+      newest = { entry, version };
+    }
+  }
+  return newest && host.path.join(dir, newest.entry);
+}
+
+function resolveSpecifier(
+  specifier: string,
+  importer: string,
+  paths: string[],
+  host: ModuleHost
+): string {
+  const versioned = VERSIONED_SPECIFIER.exec(specifier);
+  const name = versioned ? versioned[1] : specifier;
+  const range = versioned ? versioned[2].split('.').map(Number) : [];
+  const file = name.endsWith(MODULE_EXTENSION)
+    ? name
+    : name + MODULE_EXTENSION;
+  const version = versioned ? ` version ${versioned[2]} of` : '';
+
+  if (
+    name.startsWith('./') ||
+    name.startsWith('../') ||
+    host.path.isAbsolute(name)
+  ) {
+    const resolved = host.path.resolve(host.path.dirname(importer), file);
+    if (!versioned) {
+      return resolved;
+    }
+    const found = newestVersion(resolved, range, host);
+    if (!found) {
+      throw new Error(`Cannot find${version} module "${name}"`);
+    }
+    return found;
+  }
+
+  // Module paths are looked up in each search directory in turn; without
+  // a version, a module's own file is preferred over its versioned ones
+  for (const dir of paths) {
+    const candidate = host.path.resolve(dir, file);
+    if (!versioned && host.exists(candidate)) {
+      return candidate;
+    }
+    const found = newestVersion(candidate, range, host);
+    if (found) {
+      return found;
+    }
+  }
+  throw new Error(
+    paths.length > 0
+      ? `Cannot find${version} module "${name}" in ${paths.join(', ')}`
+      : `Cannot find module "${specifier}" (use "./" for relative imports)`
+  );
+}
+
+/**
+ * Load modules from the file system
+ */
+export function createFileLoader(
+  options: ModuleLoaderOptions = {}
+): ModuleLoader {
+  const paths = options.paths ?? [];
+  const host: ModuleHost = {
+    exists: existsSync,
+    list: dir => {
+      try {
+        return readdirSync(dir);
+      } catch {
+        return [];
+      }
+    },
+    path,
+  };
+  return {
+    resolve: (specifier, importer) =>
+      resolveSpecifier(specifier, importer, paths, host),
+    read: modulePath => readFileSync(modulePath, 'utf-8'),
+    resolveFile: (file, importer) =>
+      path.resolve(path.dirname(importer), file),
+  };
+}
+
+/**
+ * Load modules from an in-memory map of POSIX paths to sources
+ * (for tests, editors with unsaved buffers and sandboxed hosts)
+ */
+export function createMemoryLoader(
+  files: Record<string, string>,
+  options: ModuleLoaderOptions = {}
+): ModuleLoader {
+  const paths = options.paths ?? [];
+  const host: ModuleHost = {
+    exists: modulePath => modulePath in files,
+    list: dir =>
+      Object.keys(files)
+        .filter(file => path.posix.dirname(file) === dir)
+        .map(file => path.posix.basename(file)),
+    path: path.posix,
+  };
+  return {
+    resolve: (specifier, importer) =>
+      resolveSpecifier(specifier, importer, paths, host),
+    read: modulePath => {
+      if (!(modulePath in files)) {
+        throw new Error(`No such module: ${modulePath}`);
+      }
+      return files[modulePath];
+    },
+    resolveFile: (file, importer) =>
+      path.posix.resolve(path.posix.dirname(importer), file),
+  };
+}
+
+/**
+ * A parsed module
+ */
+export interface ScriptModule {
+  path: string;
+  source: string;
+  tree: SyntaxTree;
+  statements: Statement[];
+  /** Resolved paths of the modules it imports, in source order */
+  imports: string[];
+}
+
+export interface ModuleGraph {
+  entry: ScriptModule;
+  /** Every module once, dependencies before the modules importing them */
+  modules: ScriptModule[];
+}
+
+const DECLARATION_TYPES = new Set([
+  'mcp_declaration',
+  'model_declaration',
+  'agent_declaration',
+  'prompt_declaration',
+  'tool_declaration',
+  'pipeline_declaration',
+]);
+
+type Declaration = Extract<Statement, { name: string }>;
+
+function isDeclaration(statement: Statement): statement is Declaration {
+  return DECLARATION_TYPES.has(statement.type);
+}
+
+function messageOf(error: unknown): string {
+  return error instanceof Error ? error.message : String(error);
+}
+
+/**
+ * Read the files of a module's prompts into their declarations, so the
+ * compiled script does not need them when it runs
+ */
+function readPromptFiles(
+  statements: Statement[],
+  modulePath: string,
+  loader: ModuleLoader
+): void {
+  for (const statement of statements) {
+    if (statement.type !== 'prompt_declaration') {
+      continue;
+    }
+    const file = statement.config.properties.find(p => p.key === 'file');
+    if (!file) {
+      continue;
+    }
+    const where = `${modulePath}:${formatLocation(getLocation(statement))}`;
+    if (file.value.type !== 'string') {
+      throw new ModuleError(
+        `${where}: the file of prompt ${statement.name} must be a string`,
+        modulePath
+      );
+    }
+    const filePath = loader.resolveFile
+      ? loader.resolveFile(file.value.value, modulePath)
+      : path.resolve(path.dirname(modulePath), file.value.value);
+    try {
+      statement.fileText = loader.read(filePath);
+    } catch (error) {
+      throw new ModuleError(
+        `${where}: cannot read the file of prompt ${statement.name}: ` +
+          messageOf(error),
+        modulePath
+      );
+    }
+  }
+}
+
+/**
+ * Parse a module and everything it imports
+ * Syntax errors in the entry module are thrown as a ParseError; all other
+ * problems, including import cycles, are thrown as a ModuleError
+ */
+export function loadModuleGraph(
+  entryPath: string,
+  loader: ModuleLoader,
+  entrySource?: string
+): ModuleGraph {
+  const loaded = new Map<string, ScriptModule>();
+  const modules: ScriptModule[] = [];
+  // Modules whose imports are being loaded, outermost first
+  const stack: string[] = [];
+
+  const visit = (
+    modulePath: string,
+    importer?: { path: string; statement: ImportStatement }
+  ): ScriptModule => {
+    const cycleStart = stack.indexOf(modulePath);
+    if (cycleStart !== -1 && importer) {
+      const cycle = [...stack.slice(cycleStart), modulePath].join(' -> ');
+      throw new ModuleError(
+        `Import cycle: ${cycle}`,
+        importer.path,
+        importer.statement
+      );
+    }
+    const existing = loaded.get(modulePath);
+    if (existing) {
+      return existing;
+    }
+
+    let source = entrySource;
+    if (importer) {
+      try {
+        source = loader.read(modulePath);
+      } catch (error) {
+        throw new ModuleError(
+          `Cannot read module ${modulePath}: ${messageOf(error)}`,
+          importer.path,
+          importer.statement
+        );
+      }
+    } else if (source === undefined) {
+      source = loader.read(modulePath);
+    }
+
+    let tree: SyntaxTree;
+    try {
+      tree = parseTree(source);
+    } catch (error) {
+      if (!importer || !(error instanceof ParseLimitError)) {
+        throw error;
+      }
+      throw new ModuleError(
+        `${modulePath}: ${error.message}`,
+        importer.path,
+        importer.statement
+      );
+    }
+    let statements: Statement[];
+    try {
+      statements = statementsFromTree(tree, source);
+    } catch (error) {
+      if (!importer && error instanceof ParseError) {
+        throw error;
+      }
+      throw new ModuleError(`${modulePath}: ${messageOf(error)}`, modulePath);
+    }
+
+    // Imported modules only share declarations, so loading one never runs
+    // code of its own
+    if (importer) {
+      const statement = statements.find(
+        s =>
+          !isDeclaration(s) &&
+          s.type !== 'import_statement' &&
+          s.type !== 'comment'
+      );
+      if (statement) {
+        throw new ModuleError(
+          `${modulePath}:${formatLocation(getLocation(statement))}: ` +
+            'imported modules may only contain declarations and imports',
+          modulePath
+        );
+      }
+    }
+
+    readPromptFiles(statements, modulePath, loader);
+
+    const module: ScriptModule = {
+      path: modulePath,
+      source,
+      tree,
+      statements,
+      imports: [],
+    };
+    stack.push(modulePath);
+    for (const statement of statements) {
+      if (statement.type !== 'import_statement') {
+        continue;
+      }
+      let resolved: string;
+      try {
+        resolved = loader.resolve(statement.path, modulePath);
+      } catch (error) {
+        throw new ModuleError(messageOf(error), modulePath, statement);
+      }
+      module.imports.push(resolved);
+      visit(resolved, { path: modulePath, statement });
+    }
+    stack.pop();
+
+    loaded.set(modulePath, module);
+    modules.push(module);
+    return module;
+  };
+
+  const entry = visit(entryPath);
+  return { entry, modules };
+}
+
+/**
+ * Merge a module graph into one program: the declarations of imported
+ * modules followed by the entry module's statements
+ * Imported declarations are copied without source locations, since those
+ * refer to other files
+ */
+export function linkModules(graph: ModuleGraph): Statement[] {
+  const declaredIn = new Map<string, string>();
+  const statements: Statement[] = [];
+
+  for (const module of graph.modules) {
+    const isEntry = module === graph.entry;
+    for (const statement of module.statements) {
+      if (statement.type === 'import_statement') {
+        continue;
+      }
+      if (isDeclaration(statement)) {
+        const other = declaredIn.get(statement.name);
+        if (other !== undefined && other !== module.path) {
+          throw new ModuleError(
+            `${statement.name} is declared in both ${other} and ${module.path}`,
+            module.path
+          );
+        }
+        declaredIn.set(statement.name, module.path);
+        statements.push(isEntry ? statement : structuredClone(statement));
+      } else if (isEntry) {
+        statements.push(statement);
+      }
+    }
+  }
+
+  return statements;
+}
+
+/**
+ * Load a script with its imports and link them into one program
+ */
+export function loadProgram(
+  entryPath: string,
+  source: string,
+  loader: ModuleLoader = createFileLoader()
+): Statement[] {
+  return linkModules(loadModuleGraph(entryPath, loader, source));
+}
@@ -0,0 +1,91 @@
+// Node.js side of the transpiler: the native grammar binding and file access
+import type Parser from 'tree-sitter';
+import { readFileSync } from 'fs';
+
+// Import tree-sitter and the generated parser
+// Note: This uses createRequire to load the native binding in ESM context,
+// and to load nothing until a binding that failed to build is needed
+import { createRequire } from 'module';
+import { fileURLToPath } from 'url';
+import { dirname, join } from 'path';
+import type { Statement } from './ast.js';
+import { parseSource } from './parser.js';
+import { setSyntaxBackend, type SyntaxBackend } from './syntax.js';
+
+const __filename = fileURLToPath(import.meta.url);
+const __dirname = dirname(__filename);
+const require = createRequire(import.meta.url);
+
+// Resolve paths relative to the transpiler package root
+const grammarDir = join(__dirname, '..', 'grammar');
+
+interface NativeBinding {
+  Parser: typeof Parser;
+  language: Parser.Language;
+}
+
+/**
+ * tree-sitter and the grammar, or why they could not be loaded: tree-sitter
+ * is an optional dependency, and installing goes on when node-gyp cannot
+ * build the grammar
+ */
+const native = ((): NativeBinding | Error => {
+  try {
+    return {
+      Parser: require('tree-sitter'),
+      language: require(join(grammarDir, 'bindings', 'node')),
+    };
+  } catch (error) {
+    return error instanceof Error ? error : new Error(String(error));
+  }
+})();
+
+function binding(): NativeBinding {
+  if (native instanceof Error) {
+    throw new Error(
+      `The native tree-sitter grammar could not be loaded (${native.message}); ` +
+        'import @mcpscript/transpiler/wasm instead'
+    );
+  }
+  return native;
+}
+
+/**
+ * The tree-sitter language for MCP Script
+ * (the binding exports the Language object directly), or undefined when
+ * the native binding could not be loaded
+ */
+export const MCPScriptLanguage: Parser.Language | undefined =
+  native instanceof Error ? undefined : native.language;
+
+/**
+ * Parsing with the native binding, and queries read from grammar/queries
+ * A missing binding is reported when something is first parsed or queried.
+ */
+export const nativeSyntaxBackend: SyntaxBackend = {
+  createParser() {
+    const { Parser, language } = binding();
+    const parser = new Parser();
+    parser.setLanguage(language);
+    return parser;
+  },
+  createQuery(name) {
+    const { Parser, language } = binding();
+    const source = readFileSync(
+      join(grammarDir, 'queries', `${name}.scm`),
+      'utf-8'
+    );
+    return new Parser.Query(language, source);
+  },
+  lookahead(state) {
+    const { Parser, language } = binding();
+    return new Parser.LookaheadIterator(language, state);
+  },
+};
+
+setSyntaxBackend(nativeSyntaxBackend);
+
+export function parseFile(filePath: string): Statement[] {
+  const content = readFileSync(filePath, 'utf-8');
+  return parseSource(content);
+}
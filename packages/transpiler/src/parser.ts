@@ -1,80 +1,203 @@
 // Tree-sitter wrapper for parsing .mcps files
-import Parser from 'tree-sitter';
-import { readFileSync } from 'fs';
+import type Parser from 'tree-sitter';
 import { Statement } from './ast.js';
 import { parseStatement } from './parser/statements.js';
+import { parseTree, syntaxBackend } from './syntax.js';
+import { locationOf, type SourceLocation } from './locations.js';
+import { withArena, type AstArena } from './arena.js';
+import { featureDiagnostics } from './features.js';
 
-// Import the generated parser
-// Note: This uses createRequire to load the native binding in ESM context
-import { createRequire } from 'module';
-import { fileURLToPath } from 'url';
-import { dirname, join } from 'path';
-
-const __filename = fileURLToPath(import.meta.url);
-const __dirname = dirname(__filename);
-const require = createRequire(import.meta.url);
+export function parseSource(content: string, arena?: AstArena): Statement[] {
+  return statementsFromTree(parseTree(content), content, arena);
+}
 
-// The binding exports the Language object directly
-// Resolve path relative to the transpiler package root
-const grammarPath = join(__dirname, '..', 'grammar', 'bindings', 'node');
-const MCPScriptLanguage: Parser.Language = require(grammarPath);
+/**
+ * Build AST statements from an already parsed syntax tree
+ * Nodes are allocated in the arena when one is given
+ * Throws if the tree contains syntax errors or experimental constructs
+ * whose feature is not enabled
+ */
+export function statementsFromTree(
+  tree: Parser.Tree,
+  content: string,
+  arena?: AstArena
+): Statement[] {
+  checkSyntax(tree, content);
+  checkFeatures(tree);
+
+  return withArena(arena, () => {
+    const statements: Statement[] = [];
+
+    for (const child of tree.rootNode.children) {
+      if (child.type === 'statement') {
+        const statement = parseStatement(child);
+        if (statement) {
+          statements.push(statement);
+        }
+      }
+    }
 
-export function parseFile(filePath: string): Statement[] {
-  const content = readFileSync(filePath, 'utf-8');
-  return parseSource(content);
+    return statements;
+  });
 }
 
-export function parseSource(content: string): Statement[] {
-  const parser = new Parser();
-  parser.setLanguage(MCPScriptLanguage);
+/**
+ * A syntax error found in a parse tree
+ */
+export interface SyntaxDiagnostic {
+  /** "error" for unparseable text, "missing" for an absent token */
+  kind: 'error' | 'missing';
+  message: string;
+  location: SourceLocation;
+  /** The offending source text (empty for missing tokens) */
+  text: string;
+  /** Tokens the parser would have accepted at this point, when known */
+  expected?: string[];
+}
 
-  const tree = parser.parse(content);
+/**
+ * Error thrown for source code with syntax errors
+ * The message describes the first error; every error is in diagnostics
+ */
+export class ParseError extends Error {
+  constructor(
+    message: string,
+    public readonly diagnostics: SyntaxDiagnostic[]
+  ) {
+    super(message);
+    this.name = 'ParseError';
+  }
+}
 
-  // Check for parse errors
+/**
+ * Throw a descriptive error for the first syntax error in a tree, if any
+ */
+export function checkSyntax(tree: Parser.Tree, content: string): void {
   if (tree.rootNode.hasError) {
+    const diagnostics = syntaxDiagnostics(tree, content);
     // Find the first error node to provide a helpful error message
     const errorNode = findFirstError(tree.rootNode);
     if (errorNode) {
       const line = errorNode.startPosition.row + 1;
       const column = errorNode.startPosition.column + 1;
       const errorMessage = generateErrorMessage(errorNode, content);
-      throw new Error(
-        `Parse error at line ${line}, column ${column}: ${errorMessage}`
+      throw new ParseError(
+        `Parse error at line ${line}, column ${column}: ${errorMessage}`,
+        diagnostics
       );
     }
-    throw new Error('Parse error: The source code contains syntax errors');
+    throw new ParseError(
+      'Parse error: The source code contains syntax errors',
+      diagnostics
+    );
   }
+}
 
-  const statements: Statement[] = [];
+/**
+ * Throw for the first construct of an experimental feature that is not
+ * enabled, if any; every such construct is in the error's diagnostics
+ */
+export function checkFeatures(tree: Parser.Tree): void {
+  const diagnostics = featureDiagnostics(tree);
+  if (diagnostics.length > 0) {
+    const { message, location } = diagnostics[0];
+    throw new ParseError(
+      `Parse error at line ${location.start.line}, column ${location.start.column}: ${message}`,
+      diagnostics
+    );
+  }
+}
 
-  for (const child of tree.rootNode.children) {
-    if (child.type === 'statement') {
-      const statement = parseStatement(child);
-      if (statement) {
-        statements.push(statement);
+/**
+ * Collect every syntax error in a tree, in source order
+ * Nested errors inside an ERROR node are reported as part of it
+ */
+export function syntaxDiagnostics(
+  tree: Parser.Tree,
+  content: string
+): SyntaxDiagnostic[] {
+  const diagnostics: SyntaxDiagnostic[] = [];
+
+  const visit = (node: Parser.SyntaxNode): void => {
+    if (node.isMissing) {
+      diagnostics.push({
+        kind: 'missing',
+        message: `Missing "${node.type}"`,
+        location: locationOf(node),
+        text: '',
+        expected: [node.type],
+      });
+      return;
+    }
+    if (node.type === 'ERROR') {
+      diagnostics.push({
+        kind: 'error',
+        message: generateErrorMessage(node, content),
+        location: locationOf(node),
+        text: node.text,
+        expected: expectedTokens(node),
+      });
+      return;
+    }
+    if (node.hasError) {
+      for (const child of node.children) {
+        visit(child);
       }
     }
-  }
+  };
 
-  return statements;
+  visit(tree.rootNode);
+  return diagnostics;
 }
 
 /**
- * Find the first error node in the syntax tree
+ * Tokens that were valid just before an error node, read from the parse
+ * state after the preceding token
  */
-function findFirstError(node: Parser.SyntaxNode): Parser.SyntaxNode | null {
-  if (node.type === 'ERROR' || node.isMissing) {
-    return node;
+function expectedTokens(node: Parser.SyntaxNode): string[] | undefined {
+  const previous = precedingToken(node);
+  if (!previous) {
+    return undefined;
   }
 
-  for (const child of node.children) {
-    const errorNode = findFirstError(child);
-    if (errorNode) {
-      return errorNode;
+  const expected = new Set<string>();
+  const lookahead = syntaxBackend().lookahead(previous.nextParseState);
+  for (const name of lookahead) {
+    if (name !== 'ERROR' && name !== 'end' && !name.startsWith('_')) {
+      expected.add(name);
+    }
+  }
+  return expected.size > 0 ? [...expected] : undefined;
+}
+
+/**
+ * The last non-extra leaf before a node, skipping comments
+ */
+function precedingToken(node: Parser.SyntaxNode): Parser.SyntaxNode | null {
+  let current: Parser.SyntaxNode | null = node;
+  for (;;) {
+    while (current && !current.previousSibling) {
+      current = current.parent;
+    }
+    let leaf: Parser.SyntaxNode | null = current?.previousSibling ?? null;
+    while (leaf && leaf.childCount > 0) {
+      leaf = leaf.lastChild;
     }
+    if (!leaf || !leaf.isExtra) {
+      return leaf;
+    }
+    current = leaf;
   }
+}
 
-  return null;
+/**
+ * Describe a syntax error node with the message parseSource would report
+ */
+export function describeSyntaxError(
+  errorNode: Parser.SyntaxNode,
+  content: string
+): string {
+  return generateErrorMessage(errorNode, content);
 }
 
 /**
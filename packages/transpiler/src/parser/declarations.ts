@@ -1,10 +1,23 @@
 // Declaration parsers for MCP Script
-import Parser from 'tree-sitter';
+import type Parser from 'tree-sitter';
 import {
   MCPDeclaration,
   ModelDeclaration,
   AgentDeclaration,
+  PromptDeclaration,
+  TriggerDeclaration,
+  EventHandler,
+  NotificationHandler,
+  TestDeclaration,
+  TestHook,
+  FixtureDeclaration,
   ToolDeclaration,
+  PipelineDeclaration,
+  PipelineStep,
+  PipelineStage,
+  FanOutBranch,
+  FanOutStage,
+  CallExpression,
   ObjectLiteral,
   ToolParameter,
   TypeExpression,
@@ -14,8 +27,10 @@ import {
   TypeProperty,
   UnionType,
 } from '../ast.js';
-import { parseExpression } from './expressions.js';
+import { parseExpression, parseStringLiteral } from './expressions.js';
 import { parseBlockStatement } from './statements.js';
+import { setLocation } from '../locations.js';
+import { createNode } from '../arena.js';
 
 /**
  * Parse an MCP server declaration
@@ -33,11 +48,11 @@ export function parseMCPDeclaration(node: Parser.SyntaxNode): MCPDeclaration {
   const name = nameNode.text;
   const config = parseExpression(objectNode) as ObjectLiteral;
 
-  return {
+  return createNode({
     type: 'mcp_declaration',
     name,
     config,
-  };
+  });
 }
 
 /**
@@ -58,11 +73,11 @@ export function parseModelDeclaration(
   const name = nameNode.text;
   const config = parseExpression(objectNode) as ObjectLiteral;
 
-  return {
+  return createNode({
     type: 'model_declaration',
     name,
     config,
-  };
+  });
 }
 
 /**
@@ -83,11 +98,173 @@ export function parseAgentDeclaration(
   const name = nameNode.text;
   const config = parseExpression(objectNode) as ObjectLiteral;
 
-  return {
+  return createNode({
     type: 'agent_declaration',
     name,
     config,
-  };
+  });
+}
+
+/**
+ * Parse a prompt declaration
+ */
+export function parsePromptDeclaration(
+  node: Parser.SyntaxNode
+): PromptDeclaration {
+  // prompt <identifier> <object_literal>
+  const children = node.children.filter(c => c.type !== 'prompt');
+  const nameNode = children.find(c => c.type === 'identifier');
+  const objectNode = children.find(c => c.type === 'object_literal');
+
+  if (!nameNode || !objectNode) {
+    throw new Error('Invalid prompt_declaration: missing name or config');
+  }
+
+  const name = nameNode.text;
+  const config = parseExpression(objectNode) as ObjectLiteral;
+
+  return createNode({
+    type: 'prompt_declaration',
+    name,
+    config,
+  });
+}
+
+/**
+ * Parse a trigger declaration
+ */
+export function parseTriggerDeclaration(
+  node: Parser.SyntaxNode
+): TriggerDeclaration {
+  // trigger <identifier> ':' <call_expression> '->' <expression>
+  const nameNode = node.children.find(c => c.type === 'identifier');
+  const sourceNode = node.children.find(c => c.type === 'call_expression');
+  const actionNode = node.children.find(c => c.type === 'expression');
+
+  if (!nameNode || !sourceNode || !actionNode) {
+    throw new Error(
+      'Invalid trigger_declaration: missing name, source or action'
+    );
+  }
+
+  return createNode({
+    type: 'trigger_declaration',
+    name: nameNode.text,
+    source: parseExpression(sourceNode) as CallExpression,
+    action: parseExpression(actionNode),
+  });
+}
+
+/**
+ * Parse an event handler
+ */
+export function parseEventHandler(node: Parser.SyntaxNode): EventHandler {
+  // on event '(' <string> ')' ('(' <parameter> ')')? <block_statement>
+  const eventNode = node.children.find(c => c.type === 'string');
+  const parameterNode = node.children.find(c => c.type === 'parameter');
+  const blockNode = node.children.find(c => c.type === 'block_statement');
+
+  if (!eventNode || !blockNode) {
+    throw new Error('Invalid event_handler: missing event or body');
+  }
+
+  return createNode({
+    type: 'event_handler',
+    event: parseStringLiteral(eventNode).value,
+    parameter: parameterNode ? parseParameter(parameterNode) : undefined,
+    body: parseBlockStatement(blockNode),
+  });
+}
+
+/**
+ * Parse a notification handler
+ */
+export function parseNotificationHandler(
+  node: Parser.SyntaxNode
+): NotificationHandler {
+  // on notification '(' <identifier> ',' <string> (',' <expression>)? ')'
+  // ('(' <parameter> ')')? <block_statement>
+  const serverNode = node.children.find(c => c.type === 'identifier');
+  const notificationNode = node.children.find(c => c.type === 'string');
+  const resourceNode = node.children.find(c => c.type === 'expression');
+  const parameterNode = node.children.find(c => c.type === 'parameter');
+  const blockNode = node.children.find(c => c.type === 'block_statement');
+
+  if (!serverNode || !notificationNode || !blockNode) {
+    throw new Error(
+      'Invalid notification_handler: missing server, notification or body'
+    );
+  }
+
+  return createNode({
+    type: 'notification_handler',
+    server: serverNode.text,
+    notification: parseStringLiteral(notificationNode).value,
+    resource: resourceNode ? parseExpression(resourceNode) : undefined,
+    parameter: parameterNode ? parseParameter(parameterNode) : undefined,
+    body: parseBlockStatement(blockNode),
+  });
+}
+
+/**
+ * Parse a test case
+ */
+export function parseTestDeclaration(
+  node: Parser.SyntaxNode
+): TestDeclaration {
+  // test <string> <block_statement>
+  const nameNode = node.children.find(c => c.type === 'string');
+  const blockNode = node.children.find(c => c.type === 'block_statement');
+
+  if (!nameNode || !blockNode) {
+    throw new Error('Invalid test_declaration: missing name or body');
+  }
+
+  return createNode({
+    type: 'test_declaration',
+    name: parseStringLiteral(nameNode).value,
+    body: parseBlockStatement(blockNode),
+  });
+}
+
+/**
+ * Parse a test hook
+ */
+export function parseTestHook(node: Parser.SyntaxNode): TestHook {
+  // (beforeAll | beforeEach | afterEach | afterAll) <block_statement>
+  const hookNode = node.firstChild;
+  const blockNode = node.children.find(c => c.type === 'block_statement');
+
+  if (!hookNode || !blockNode) {
+    throw new Error('Invalid test_hook: missing hook or body');
+  }
+
+  return createNode({
+    type: 'test_hook',
+    hook: hookNode.type as TestHook['hook'],
+    body: parseBlockStatement(blockNode),
+  });
+}
+
+/**
+ * Parse a fixture declaration
+ */
+export function parseFixtureDeclaration(
+  node: Parser.SyntaxNode
+): FixtureDeclaration {
+  // fixture <identifier> '=' <expression>
+  const nameNode = node.children.find(c => c.type === 'identifier');
+  const valueNode = node.children.find(c => c.type === 'expression');
+
+  if (!nameNode || !valueNode) {
+    throw new Error('Invalid fixture_declaration: missing name or value');
+  }
+
+  return createNode({
+    type: 'fixture_declaration',
+    name: nameNode.text,
+    value: parseExpression(valueNode),
+  });
 }
 
 /**
@@ -114,13 +291,151 @@ export function parseToolDeclaration(node: Parser.SyntaxNode): ToolDeclaration {
     : undefined;
   const body = parseBlockStatement(blockNode);
 
-  return {
+  return createNode({
     type: 'tool_declaration',
     name,
     parameters,
     returnType,
     body,
-  };
+  });
+}
+
+/**
+ * Parse a pipeline declaration
+ */
+export function parsePipelineDeclaration(
+  node: Parser.SyntaxNode
+): PipelineDeclaration {
+  // pipeline <identifier> '(' <parameter_list>? ')' <return_type_annotation>?
+  //   '{' <pipeline_step>* <pipeline_output>? '}'
+  const nameNode = node.children.find(c => c.type === 'identifier');
+  const paramListNode = node.children.find(c => c.type === 'parameter_list');
+  const returnTypeNode = node.children.find(
+    c => c.type === 'return_type_annotation'
+  );
+  const bodyNode = node.children.find(c => c.type === 'pipeline_body');
+
+  if (!nameNode || !bodyNode) {
+    throw new Error('Invalid pipeline_declaration: missing name or body');
+  }
+
+  const steps = bodyNode.children
+    .filter(c => c.type === 'pipeline_step')
+    .map(parsePipelineStep);
+  const outputNode = bodyNode.children
+    .find(c => c.type === 'pipeline_output')
+    ?.children.find(c => c.type === 'expression');
+
+  return createNode({
+    type: 'pipeline_declaration',
+    name: nameNode.text,
+    parameters: paramListNode ? parseParameterList(paramListNode) : [],
+    returnType: returnTypeNode
+      ? parseReturnTypeAnnotation(returnTypeNode)
+      : undefined,
+    steps,
+    ...(outputNode && { output: parseExpression(outputNode) }),
+  });
+}
+
+function parsePipelineStep(node: Parser.SyntaxNode): PipelineStep {
+  // <identifier> '=' <pipeline_stage>
+  const nameNode = node.children.find(c => c.type === 'identifier');
+  const stageNode = node.children.find(c => c.type === 'pipeline_stage');
+  if (!nameNode || !stageNode) {
+    throw new Error('Invalid pipeline_step: missing name or stage');
+  }
+  return setLocation(
+    createNode({
+      type: 'pipeline_step',
+      name: nameNode.text,
+      stage: parsePipelineStage(stageNode),
+    }),
+    node
+  );
+}
+
+/**
+ * Parse what a pipeline step runs
+ */
+function parsePipelineStage(node: Parser.SyntaxNode): PipelineStage {
+  const stage = node.firstNamedChild;
+  if (!stage) {
+    throw new Error('Invalid pipeline_stage: missing stage');
+  }
+  const stages = (parent: Parser.SyntaxNode) =>
+    parent.children.filter(c => c.type === 'pipeline_stage');
+  const expressions = stage.children.filter(c => c.type === 'expression');
+
+  switch (stage.type) {
+    case 'call_expression':
+      return parseExpression(stage) as CallExpression;
+
+    case 'conditional_stage': {
+      // if '(' <expression> ')' <pipeline_stage> [else <pipeline_stage>]
+      const [thenNode, elseNode] = stages(stage);
+      if (!expressions[0] || !thenNode) {
+        throw new Error('Invalid conditional_stage: missing condition');
+      }
+      return setLocation(
+        createNode({
+          type: 'conditional_stage',
+          condition: parseExpression(expressions[0]),
+          then: parsePipelineStage(thenNode),
+          ...(elseNode && { else: parsePipelineStage(elseNode) }),
+        }),
+        stage
+      );
+    }
+
+    case 'fan_out_stage': {
+      // parallel '{' <identifier> ':' <pipeline_stage>, ... '}'
+      const branches = stage.children
+        .filter(c => c.type === 'fan_out_branch')
+        .map(branch => {
+          const nameNode = branch.children.find(c => c.type === 'identifier');
+          const [stageNode] = stages(branch);
+          if (!nameNode || !stageNode) {
+            throw new Error('Invalid fan_out_branch: missing name or stage');
+          }
+          const result: FanOutBranch = createNode({
+            type: 'fan_out_branch',
+            name: nameNode.text,
+            stage: parsePipelineStage(stageNode),
+          });
+          return setLocation(result, branch);
+        });
+      const result: FanOutStage = createNode({
+        type: 'fan_out_stage',
+        branches,
+      });
+      return setLocation(result, stage);
+    }
+
+    case 'each_stage': {
+      // each '(' <expression> [, limit: <expression>] ')'
+      //   '{' <map_item> '=>' <pipeline_stage> '}'
+      const [itemsNode, limitNode] = expressions;
+      const itemNode = stage.children.find(c => c.type === 'map_item');
+      const [stageNode] = stages(stage);
+      if (!itemsNode || !itemNode || !stageNode) {
+        throw new Error('Invalid each_stage: missing items or stage');
+      }
+      return setLocation(
+        createNode({
+          type: 'each_stage',
+          items: parseExpression(itemsNode),
+          ...(limitNode && { limit: parseExpression(limitNode) }),
+          item: itemNode.text,
+          stage: parsePipelineStage(stageNode),
+        }),
+        stage
+      );
+    }
+
+    default:
+      throw new Error(`Unknown pipeline stage: ${stage.type}`);
+  }
 }
 
 /**
@@ -141,23 +456,29 @@ function parseParameterList(node: Parser.SyntaxNode): ToolParameter[] {
  */
 function parseParameter(node: Parser.SyntaxNode): ToolParameter {
   // parameter: identifier optional('?') optional(type_annotation)
+  //   optional('=' expression)
   const identifierNode = node.children.find(c => c.type === 'identifier');
   const hasOptional = node.children.some(c => c.type === '?');
   const typeAnnotationNode = node.children.find(
     c => c.type === 'type_annotation'
   );
+  const defaultNode = node.children.find(c => c.type === 'expression');
 
   if (!identifierNode) {
     throw new Error('Invalid parameter: missing identifier');
   }
 
-  return {
-    name: identifierNode.text,
-    optional: hasOptional,
-    typeAnnotation: typeAnnotationNode
-      ? parseTypeAnnotation(typeAnnotationNode)
-      : undefined,
-  };
+  return setLocation(
+    {
+      name: identifierNode.text,
+      optional: hasOptional,
+      typeAnnotation: typeAnnotationNode
+        ? parseTypeAnnotation(typeAnnotationNode)
+        : undefined,
+      ...(defaultNode && { defaultValue: parseExpression(defaultNode) }),
+    },
+    node
+  );
 }
 
 /**
@@ -188,6 +509,13 @@ function parseReturnTypeAnnotation(node: Parser.SyntaxNode): TypeExpression {
  * Parse a type expression
  */
 function parseTypeExpression(node: Parser.SyntaxNode): TypeExpression {
+  return setLocation(parseTypeExpressionNode(node), node);
+}
+
+/**
+ * Dispatch a type expression node to its parser
+ */
+function parseTypeExpressionNode(node: Parser.SyntaxNode): TypeExpression {
   const child = node.children[0];
 
   if (!child) {
@@ -212,11 +540,11 @@ function parseTypeExpression(node: Parser.SyntaxNode): TypeExpression {
  * Parse a primitive type
  */
 function parsePrimitiveType(node: Parser.SyntaxNode): PrimitiveType {
-  const value = node.text as 'string' | 'number' | 'boolean' | 'any' | 'null';
-  return {
+  const value = node.text as PrimitiveType['value'];
+  return createNode({
     type: 'primitive_type',
     value,
-  };
+  });
 }
 
 /**
@@ -229,10 +557,10 @@ function parseArrayType(node: Parser.SyntaxNode): ArrayType {
     throw new Error('Invalid array_type: missing type_expression');
   }
 
-  return {
+  return createNode({
     type: 'array_type',
     elementType: parseTypeExpression(typeExprNode),
-  };
+  });
 }
 
 /**
@@ -247,10 +575,10 @@ function parseObjectType(node: Parser.SyntaxNode): ObjectType {
     ? parseTypePropertyList(typePropertyListNode)
     : [];
 
-  return {
+  return createNode({
     type: 'object_type',
     properties,
-  };
+  });
 }
 
 /**
@@ -312,8 +640,8 @@ function parseUnionType(node: Parser.SyntaxNode): UnionType {
 
   collectTypes(node);
 
-  return {
+  return createNode({
     type: 'union_type',
     types,
-  };
+  });
 }
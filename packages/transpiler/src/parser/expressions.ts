@@ -1,5 +1,5 @@
 // Expression parsers for MCP Script
-import Parser from 'tree-sitter';
+import type Parser from 'tree-sitter';
 import {
   Expression,
   Identifier,
@@ -15,11 +15,20 @@ import {
   BinaryExpression,
   UnaryExpression,
 } from '../ast.js';
+import { setLocation } from '../locations.js';
+import { createNode } from '../arena.js';
 
 /**
  * Parse an expression node
  */
 export function parseExpression(node: Parser.SyntaxNode): Expression {
+  return setLocation(parseExpressionNode(node), node);
+}
+
+/**
+ * Dispatch an expression node to its parser
+ */
+function parseExpressionNode(node: Parser.SyntaxNode): Expression {
   // Handle wrapper nodes
   if (node.type === 'expression') {
     const child = node.firstChild;
@@ -35,6 +44,8 @@ export function parseExpression(node: Parser.SyntaxNode): Expression {
       return parseLiteral(node);
     case 'call_expression':
       return parseCallExpression(node);
+    case 'with_expression':
+      return parseWithExpression(node);
     case 'member_expression':
       return parseMemberExpression(node);
     case 'bracket_expression':
@@ -49,6 +60,8 @@ export function parseExpression(node: Parser.SyntaxNode): Expression {
       return parseStringLiteral(node);
     case 'number':
       return parseNumber(node);
+    case 'duration':
+      return parseDuration(node);
     case 'boolean':
       return parseBoolean(node);
     case 'array_literal':
@@ -64,10 +77,10 @@ export function parseExpression(node: Parser.SyntaxNode): Expression {
  * Parse an identifier
  */
 export function parseIdentifier(node: Parser.SyntaxNode): Identifier {
-  return {
+  return createNode({
     type: 'identifier',
     name: node.text,
-  };
+  });
 }
 
 /**
@@ -84,7 +97,7 @@ function parseLiteral(node: Parser.SyntaxNode): Expression {
 /**
  * Parse a string literal
  */
-function parseStringLiteral(node: Parser.SyntaxNode): StringLiteral {
+export function parseStringLiteral(node: Parser.SyntaxNode): StringLiteral {
   // Parse string by removing quotes and processing escape sequences
   let text = node.text;
 
@@ -109,30 +122,49 @@ function parseStringLiteral(node: Parser.SyntaxNode): StringLiteral {
     .replace(/\\'/g, "'")
     .replace(/\\`/g, '`');
 
-  return {
+  return createNode({
     type: 'string',
     value: text,
-  };
+  });
 }
 
 /**
  * Parse a number literal
  */
 function parseNumber(node: Parser.SyntaxNode): NumberLiteral {
-  return {
+  return createNode({
     type: 'number',
     value: parseFloat(node.text),
-  };
+  });
+}
+
+/** Milliseconds in each unit of a duration */
+const DURATION_UNITS: Record<string, number> = {
+  ms: 1,
+  s: 1000,
+  m: 60_000,
+  h: 3_600_000,
+};
+
+/**
+ * Parse a duration such as 500ms or 1.5s into a number of milliseconds
+ */
+function parseDuration(node: Parser.SyntaxNode): NumberLiteral {
+  const [, amount, unit] = /^([\d.]+)(ms|s|m|h)$/.exec(node.text)!;
+  return createNode({
+    type: 'number',
+    value: parseFloat(amount) * DURATION_UNITS[unit],
+  });
 }
 
 /**
  * Parse a boolean literal
  */
 function parseBoolean(node: Parser.SyntaxNode): BooleanLiteral {
-  return {
+  return createNode({
     type: 'boolean',
     value: node.text === 'true',
-  };
+  });
 }
 
 /**
@@ -147,10 +179,10 @@ function parseArrayLiteral(node: Parser.SyntaxNode): ArrayLiteral {
     }
   }
 
-  return {
+  return createNode({
     type: 'array',
     elements,
-  };
+  });
 }
 
 /**
@@ -169,10 +201,10 @@ function parseObjectLiteral(node: Parser.SyntaxNode): ObjectLiteral {
     }
   }
 
-  return {
+  return createNode({
     type: 'object',
     properties,
-  };
+  });
 }
 
 /**
@@ -186,17 +218,23 @@ function parseProperty(node: Parser.SyntaxNode): Property {
     throw new Error('Invalid property: missing key or value');
   }
 
-  return {
-    type: 'property',
-    key: keyNode.text,
-    value: parseExpression(valueNode),
-  };
+  return setLocation(
+    createNode({
+      type: 'property',
+      key: keyNode.text,
+      value: parseExpression(valueNode),
+    }),
+    node
+  );
 }
 
 /**
  * Parse a call expression
  */
-function parseCallExpression(node: Parser.SyntaxNode): CallExpression {
+function parseCallExpression(
+  node: Parser.SyntaxNode,
+  options?: ObjectLiteral
+): CallExpression {
   // <expression> ( <argument_list> )
   const calleeNode = node.children.find(c => c.type === 'expression');
   const argumentListNode = node.children.find(c => c.type === 'argument_list');
@@ -216,11 +254,30 @@ function parseCallExpression(node: Parser.SyntaxNode): CallExpression {
     }
   }
 
-  return {
+  return createNode({
     type: 'call',
     callee,
     arguments: args,
-  };
+    ...(options && { options }),
+  });
+}
+
+/**
+ * Parse a call with options, such as `fs.read(path) with { retries: 3 }`
+ */
+function parseWithExpression(node: Parser.SyntaxNode): CallExpression {
+  // <call_expression> with <object_literal>
+  const callNode = node.children.find(c => c.type === 'call_expression');
+  const optionsNode = node.children.find(c => c.type === 'object_literal');
+
+  if (!callNode || !optionsNode) {
+    throw new Error('Invalid with_expression: missing call or options');
+  }
+
+  return parseCallExpression(
+    callNode,
+    setLocation(parseObjectLiteral(optionsNode), optionsNode)
+  );
 }
 
 /**
@@ -240,11 +297,11 @@ export function parseMemberExpression(
   const object = parseExpression(objectNode);
   const property = propertyNode.text;
 
-  return {
+  return createNode({
     type: 'member',
     object,
     property,
-  };
+  });
 }
 
 /**
@@ -263,11 +320,11 @@ export function parseBracketExpression(
   const object = parseExpression(expressionNodes[0]);
   const index = parseExpression(expressionNodes[1]);
 
-  return {
+  return createNode({
     type: 'bracket',
     object,
     index,
-  };
+  });
 }
 
 /**
@@ -329,12 +386,12 @@ function parseBinaryExpression(node: Parser.SyntaxNode): BinaryExpression {
     | '??'
     | '|';
 
-  return {
+  return createNode({
     type: 'binary',
     left,
     operator,
     right,
-  };
+  });
 }
 
 /**
@@ -352,9 +409,9 @@ function parseUnaryExpression(node: Parser.SyntaxNode): UnaryExpression {
   const operator = operatorNode.type as '!' | '-';
   const operand = parseExpression(expressionNode);
 
-  return {
+  return createNode({
     type: 'unary',
     operator,
     operand,
-  };
+  });
 }
@@ -1,5 +1,5 @@
 // Statement parsers for MCP Script
-import Parser from 'tree-sitter';
+import type Parser from 'tree-sitter';
 import {
   Statement,
   Assignment,
@@ -8,10 +8,18 @@ import {
   IfStatement,
   WhileStatement,
   ForStatement,
+  ParallelStatement,
+  ParallelMapStatement,
+  AnnotatedStatement,
+  CompensatedStatement,
+  ListenStatement,
+  Annotation,
+  Property,
   BreakStatement,
   ContinueStatement,
   ReturnStatement,
   Comment,
+  ImportStatement,
   Identifier,
   MemberExpression,
   BracketExpression,
@@ -21,32 +29,69 @@ import {
   parseIdentifier,
   parseMemberExpression,
   parseBracketExpression,
+  parseStringLiteral,
 } from './expressions.js';
 import {
   parseMCPDeclaration,
   parseModelDeclaration,
   parseAgentDeclaration,
+  parsePromptDeclaration,
+  parseTriggerDeclaration,
+  parseEventHandler,
+  parseNotificationHandler,
   parseToolDeclaration,
+  parsePipelineDeclaration,
+  parseTestDeclaration,
+  parseTestHook,
+  parseFixtureDeclaration,
 } from './declarations.js';
+import { setLocation } from '../locations.js';
+import { createNode } from '../arena.js';
 
 /**
  * Parse a statement node
  */
 export function parseStatement(node: Parser.SyntaxNode): Statement | null {
+  const statement = parseStatementNode(node);
+  return statement ? setLocation(statement, node) : null;
+}
+
+/**
+ * Dispatch a statement node to its parser
+ */
+function parseStatementNode(node: Parser.SyntaxNode): Statement | null {
   const firstChild = node.firstChild;
   if (!firstChild) return null;
 
   switch (firstChild.type) {
     case 'comment':
       return parseComment(firstChild);
+    case 'import_statement':
+      return parseImportStatement(firstChild);
     case 'mcp_declaration':
       return parseMCPDeclaration(firstChild);
     case 'model_declaration':
       return parseModelDeclaration(firstChild);
     case 'agent_declaration':
       return parseAgentDeclaration(firstChild);
+    case 'prompt_declaration':
+      return parsePromptDeclaration(firstChild);
+    case 'trigger_declaration':
+      return parseTriggerDeclaration(firstChild);
+    case 'event_handler':
+      return parseEventHandler(firstChild);
+    case 'notification_handler':
+      return parseNotificationHandler(firstChild);
     case 'tool_declaration':
       return parseToolDeclaration(firstChild);
+    case 'pipeline_declaration':
+      return parsePipelineDeclaration(firstChild);
+    case 'test_declaration':
+      return parseTestDeclaration(firstChild);
+    case 'test_hook':
+      return parseTestHook(firstChild);
+    case 'fixture_declaration':
+      return parseFixtureDeclaration(firstChild);
     case 'assignment':
       return parseAssignment(firstChild);
     case 'expression_statement':
@@ -59,6 +104,16 @@ export function parseStatement(node: Parser.SyntaxNode): Statement | null {
       return parseWhileStatement(firstChild);
     case 'for_statement':
       return parseForStatement(firstChild);
+    case 'parallel_statement':
+      return parseParallelStatement(firstChild);
+    case 'parallel_map_statement':
+      return parseParallelMapStatement(firstChild);
+    case 'annotated_statement':
+      return parseAnnotatedStatement(firstChild);
+    case 'compensated_statement':
+      return parseCompensatedStatement(firstChild);
+    case 'listen_statement':
+      return parseListenStatement(firstChild);
     case 'break_statement':
       return parseBreakStatement(firstChild);
     case 'continue_statement':
@@ -70,6 +125,22 @@ export function parseStatement(node: Parser.SyntaxNode): Statement | null {
   }
 }
 
+/**
+ * Parse an import statement
+ */
+function parseImportStatement(node: Parser.SyntaxNode): ImportStatement {
+  // import "<path>"
+  const pathNode = node.children.find(c => c.type === 'string');
+  if (!pathNode) {
+    throw new Error('Invalid import: missing module path');
+  }
+
+  return createNode({
+    type: 'import_statement',
+    path: parseStringLiteral(pathNode).value,
+  });
+}
+
 /**
  * Parse an assignment statement
  */
@@ -86,11 +157,11 @@ function parseAssignment(node: Parser.SyntaxNode): Assignment {
   const target = parseAssignmentTarget(targetNode);
   const value = parseExpression(expressionNode);
 
-  return {
+  return createNode({
     type: 'assignment',
     target,
     value,
-  };
+  });
 }
 
 /**
@@ -129,10 +200,10 @@ function parseExpressionStatement(
 
   const expression = parseExpression(expressionNode);
 
-  return {
+  return createNode({
     type: 'expression_statement',
     expression,
-  };
+  });
 }
 
 /**
@@ -150,10 +221,13 @@ export function parseBlockStatement(node: Parser.SyntaxNode): BlockStatement {
     }
   }
 
-  return {
-    type: 'block_statement',
-    statements,
-  };
+  return setLocation(
+    createNode({
+      type: 'block_statement',
+      statements,
+    }),
+    node
+  );
 }
 
 /**
@@ -175,11 +249,11 @@ function parseIfStatement(node: Parser.SyntaxNode): IfStatement {
     throw new Error('Invalid if_statement: could not parse then statement');
   }
 
-  const result: IfStatement = {
+  const result: IfStatement = createNode({
     type: 'if_statement',
     condition,
     then,
-  };
+  });
 
   // Check if there's an else statement
   if (statementNodes.length > 1) {
@@ -211,11 +285,11 @@ function parseWhileStatement(node: Parser.SyntaxNode): WhileStatement {
     throw new Error('Invalid while_statement: could not parse body statement');
   }
 
-  return {
+  return createNode({
     type: 'while_statement',
     condition,
     body,
-  };
+  });
 }
 
 /**
@@ -235,10 +309,10 @@ function parseForStatement(node: Parser.SyntaxNode): ForStatement {
     throw new Error('Invalid for_statement: could not parse body statement');
   }
 
-  const result: ForStatement = {
+  const result: ForStatement = createNode({
     type: 'for_statement',
     body,
-  };
+  });
 
   // Find semicolon positions to determine which parts exist
   const children = node.children;
@@ -270,7 +344,7 @@ function parseForStatement(node: Parser.SyntaxNode): ForStatement {
   // Parse init part (between ( and first ;)
   for (let i = openParenIndex + 1; i < semicolonIndices[0]; i++) {
     if (children[i].type === 'assignment') {
-      result.init = parseAssignment(children[i]);
+      result.init = setLocation(parseAssignment(children[i]), children[i]);
       break;
     }
   }
@@ -289,7 +363,7 @@ function parseForStatement(node: Parser.SyntaxNode): ForStatement {
       break;
     }
     if (children[i].type === 'assignment') {
-      result.update = parseAssignment(children[i]);
+      result.update = setLocation(parseAssignment(children[i]), children[i]);
       break;
     }
   }
@@ -297,22 +371,210 @@ function parseForStatement(node: Parser.SyntaxNode): ForStatement {
   return result;
 }
 
+/**
+ * Parse a parallel statement
+ */
+function parseParallelStatement(node: Parser.SyntaxNode): ParallelStatement {
+  // parallel [( expression )] { statement* }
+  const limitNode = node.children.find(c => c.type === 'expression');
+  const branches: Statement[] = [];
+
+  for (const child of node.children) {
+    if (child.type === 'statement') {
+      const statement = parseStatement(child);
+      if (statement && statement.type !== 'comment') {
+        branches.push(statement);
+      }
+    }
+  }
+
+  const result: ParallelStatement = createNode({
+    type: 'parallel_statement',
+    branches,
+  });
+  if (limitNode) {
+    result.limit = parseExpression(limitNode);
+  }
+  return result;
+}
+
+/**
+ * Parse a parallel map statement
+ */
+function parseParallelMapStatement(
+  node: Parser.SyntaxNode
+): ParallelMapStatement {
+  // [target =] parallelMap( expression [, limit: expression] ) map_body
+  // map_body: { map_item => statement* }
+  const targetNode = node.children.find(c => c.type === 'assignment_target');
+  const [itemsNode, limitNode] = node.children.filter(
+    c => c.type === 'expression'
+  );
+  const bodyNode = node.children.find(c => c.type === 'map_body');
+  const itemNode = bodyNode?.children.find(c => c.type === 'map_item');
+  if (!itemsNode || !bodyNode || !itemNode) {
+    throw new Error('Invalid parallelMap: missing items or body');
+  }
+  const body: Statement[] = [];
+  for (const child of bodyNode.children) {
+    if (child.type === 'statement') {
+      const statement = parseStatement(child);
+      if (statement && statement.type !== 'comment') {
+        body.push(statement);
+      }
+    }
+  }
+
+  const result: ParallelMapStatement = createNode({
+    type: 'parallel_map_statement',
+    items: parseExpression(itemsNode),
+    item: itemNode.text,
+    body,
+  });
+  if (targetNode) {
+    result.target = parseAssignmentTarget(targetNode);
+  }
+  if (limitNode) {
+    result.limit = parseExpression(limitNode);
+  }
+  return result;
+}
+
+/**
+ * Parse an annotated statement
+ */
+function parseAnnotatedStatement(
+  node: Parser.SyntaxNode
+): AnnotatedStatement {
+  // annotation (assignment | expression_statement)
+  const annotationNode = node.children.find(c => c.type === 'annotation');
+  const statementNode = node.children.find(
+    c => c.type === 'assignment' || c.type === 'expression_statement'
+  );
+  if (!annotationNode || !statementNode) {
+    throw new Error('Invalid annotated statement: missing statement');
+  }
+
+  const statement =
+    statementNode.type === 'assignment'
+      ? parseAssignment(statementNode)
+      : parseExpressionStatement(statementNode);
+  return createNode({
+    type: 'annotated_statement',
+    annotation: setLocation(parseAnnotation(annotationNode), annotationNode),
+    statement: setLocation(statement, statementNode),
+  });
+}
+
+/**
+ * Parse a statement with a compensation block
+ */
+function parseCompensatedStatement(
+  node: Parser.SyntaxNode
+): CompensatedStatement {
+  // (assignment | expression_statement | annotated_statement)
+  // compensate block_statement
+  const statementNode = node.children.find(
+    c =>
+      c.type === 'assignment' ||
+      c.type === 'expression_statement' ||
+      c.type === 'annotated_statement'
+  );
+  const blockNode = node.children.find(c => c.type === 'block_statement');
+  if (!statementNode || !blockNode) {
+    throw new Error('Invalid compensate: missing statement or block');
+  }
+
+  let statement: CompensatedStatement['statement'];
+  switch (statementNode.type) {
+    case 'assignment':
+      statement = parseAssignment(statementNode);
+      break;
+    case 'expression_statement':
+      statement = parseExpressionStatement(statementNode);
+      break;
+    default:
+      statement = parseAnnotatedStatement(statementNode);
+  }
+  return createNode({
+    type: 'compensated_statement',
+    statement: setLocation(statement, statementNode),
+    compensation: parseBlockStatement(blockNode),
+  });
+}
+
+/**
+ * Parse a listen statement
+ */
+function parseListenStatement(node: Parser.SyntaxNode): ListenStatement {
+  // listen '(' [until ':' expression] [,] [timeout ':' expression] ')'
+  const result: ListenStatement = createNode({ type: 'listen_statement' });
+  node.children.forEach((child, index) => {
+    if (child.type !== 'expression') {
+      return;
+    }
+    // The option an expression sets is two tokens before it
+    const option = node.children[index - 2]?.type;
+    if (option === 'until') {
+      result.until = parseExpression(child);
+    } else if (option === 'timeout') {
+      result.timeout = parseExpression(child);
+    }
+  });
+  return result;
+}
+
+/**
+ * Parse an annotation
+ */
+function parseAnnotation(node: Parser.SyntaxNode): Annotation {
+  // @ identifier ( [identifier : expression, ...] )
+  const nameNode = node.children.find(c => c.type === 'identifier');
+  if (!nameNode) {
+    throw new Error('Invalid annotation: missing name');
+  }
+
+  const args: Property[] = [];
+  for (const child of node.children) {
+    if (child.type !== 'annotation_argument') {
+      continue;
+    }
+    const keyNode = child.children.find(c => c.type === 'identifier');
+    const valueNode = child.children.find(c => c.type === 'expression');
+    if (!keyNode || !valueNode) {
+      throw new Error('Invalid annotation: missing argument name or value');
+    }
+    const argument: Property = createNode({
+      type: 'property',
+      key: keyNode.text,
+      value: parseExpression(valueNode),
+    });
+    args.push(setLocation(argument, child));
+  }
+
+  return createNode({
+    type: 'annotation',
+    name: nameNode.text,
+    arguments: args,
+  });
+}
+
 /**
  * Parse a break statement
  */
 function parseBreakStatement(_node: Parser.SyntaxNode): BreakStatement {
-  return {
+  return createNode({
     type: 'break_statement',
-  };
+  });
 }
 
 /**
  * Parse a continue statement
  */
 function parseContinueStatement(_node: Parser.SyntaxNode): ContinueStatement {
-  return {
+  return createNode({
     type: 'continue_statement',
-  };
+  });
 }
 
 /**
@@ -321,18 +583,18 @@ function parseContinueStatement(_node: Parser.SyntaxNode): ContinueStatement {
 function parseReturnStatement(node: Parser.SyntaxNode): ReturnStatement {
   const expressionNode = node.children.find(c => c.type === 'expression');
 
-  return {
+  return createNode({
     type: 'return_statement',
     value: expressionNode ? parseExpression(expressionNode) : undefined,
-  };
+  });
 }
 
 /**
  * Parse a comment
  */
 function parseComment(node: Parser.SyntaxNode): Comment {
-  return {
+  return createNode({
     type: 'comment',
     text: node.text,
-  };
+  });
 }
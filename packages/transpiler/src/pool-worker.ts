@@ -0,0 +1,43 @@
+// Worker thread of a ParserPool
+//
+// Loads its own native parser, then parses each source the pool sends and
+// posts back the AST with its locations, or why parsing failed.
+import { parentPort, workerData } from 'worker_threads';
+import './native.js';
+import { ParseLimitError, setParseLimits } from './limits.js';
+import { collectLocations } from './locations.js';
+import { ParseError, parseSource } from './parser.js';
+import type {
+  PoolError,
+  PoolRequest,
+  PoolResponse,
+  PoolWorkerData,
+} from './pool.js';
+
+function serializeError(error: unknown): PoolError {
+  if (error instanceof ParseError) {
+    const { name, message, diagnostics } = error;
+    return { name, message, diagnostics };
+  }
+  if (error instanceof ParseLimitError) {
+    const { name, message, limit, max, location } = error;
+    return { name, message, limit, max, location };
+  }
+  return {
+    name: 'Error',
+    message: error instanceof Error ? error.message : String(error),
+  };
+}
+
+setParseLimits((workerData as PoolWorkerData).parseLimits);
+
+parentPort!.on('message', ({ id, source }: PoolRequest) => {
+  let response: PoolResponse;
+  try {
+    const statements = parseSource(source);
+    response = { id, statements, locations: collectLocations(statements) };
+  } catch (error) {
+    response = { id, error: serializeError(error) };
+  }
+  parentPort!.postMessage(response);
+});
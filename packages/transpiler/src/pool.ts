@@ -0,0 +1,219 @@
+// A pool of worker threads for parsing many scripts in parallel
+//
+// Parsing runs synchronously on the calling thread, so a language server or
+// web service handling many documents parses them one after another. A
+// ParserPool spreads the work over worker threads, each with a parser of
+// its own, and hands back ASTs with their source locations as if they had
+// been parsed here. Syntax trees cannot leave the thread that parsed them,
+// so the pool only produces ASTs; editor features that need trees keep
+// calling parseTree().
+import { availableParallelism } from 'os';
+import { fileURLToPath } from 'url';
+import { Worker } from 'worker_threads';
+import type { Statement } from './ast.js';
+import { ParseLimitError, parseLimits, type ParseLimits } from './limits.js';
+import { restoreLocations, type SourceLocation } from './locations.js';
+import { ParseError, type SyntaxDiagnostic } from './parser.js';
+
+/**
+ * Sent to a worker with each source to parse
+ */
+export interface PoolRequest {
+  id: number;
+  source: string;
+}
+
+/**
+ * Sent back by a worker: the AST and the locations of its nodes (see
+ * collectLocations()), or the error parsing failed with
+ */
+export type PoolResponse =
+  | {
+      id: number;
+      statements: Statement[];
+      locations: (SourceLocation | null)[];
+    }
+  | { id: number; error: PoolError };
+
+export interface PoolError {
+  name: string;
+  message: string;
+  /** Of a ParseError */
+  diagnostics?: SyntaxDiagnostic[];
+  /** Of a ParseLimitError */
+  limit?: keyof ParseLimits;
+  max?: number;
+  location?: SourceLocation;
+}
+
+/**
+ * Passed to every worker when it starts
+ */
+export interface PoolWorkerData {
+  parseLimits: ParseLimits;
+}
+
+export interface ParserPoolOptions {
+  /** Most worker threads to start (default: available parallelism) */
+  size?: number;
+  /** Limits the workers check every parse against (default: this thread's) */
+  parseLimits?: ParseLimits;
+  /** Module the workers run (default: the pool-worker.js next to this) */
+  workerPath?: string;
+}
+
+interface Task {
+  id: number;
+  source: string;
+  resolve(statements: Statement[]): void;
+  reject(error: Error): void;
+}
+
+interface PoolThread {
+  worker: Worker;
+  task?: Task;
+}
+
+function toError(error: PoolError): Error {
+  if (error.name === 'ParseError') {
+    return new ParseError(error.message, error.diagnostics ?? []);
+  }
+  if (error.name === 'ParseLimitError' && error.limit) {
+    return new ParseLimitError(
+      error.message,
+      error.limit,
+      error.max ?? 0,
+      error.location
+    );
+  }
+  return new Error(error.message);
+}
+
+/**
+ * Parses scripts on worker threads
+ * Guarantees:
+ * - Each worker owns one parser and parses one source at a time, so no
+ *   parser is ever shared
+ * - parse() may be called from any number of concurrent tasks; sources are
+ *   started in the order they were given and finish in any order
+ * - Results equal parseSource() on this thread, source locations and
+ *   ParseError or ParseLimitError failures included
+ * - A worker that crashes fails only the source it was parsing and is
+ *   replaced for the next one
+ * - Idle workers do not keep the process alive; close() stops them
+ */
+export class ParserPool {
+  readonly size: number;
+  private readonly workerPath: string;
+  private readonly workerData: PoolWorkerData;
+  private readonly threads: PoolThread[] = [];
+  private readonly queue: Task[] = [];
+  private nextId = 0;
+  private closed = false;
+
+  constructor(options: ParserPoolOptions = {}) {
+    const { size = availableParallelism() } = options;
+    if (!Number.isInteger(size) || size < 1) {
+      throw new TypeError(
+        `Parser pool size must be a positive integer, got ${size}`
+      );
+    }
+    this.size = size;
+    this.workerPath =
+      options.workerPath ??
+      fileURLToPath(new URL('./pool-worker.js', import.meta.url));
+    this.workerData = { parseLimits: options.parseLimits ?? parseLimits() };
+  }
+
+  /**
+   * Parse a script on the next free worker
+   */
+  parse(source: string): Promise<Statement[]> {
+    if (this.closed) {
+      return Promise.reject(new Error('Parser pool is closed'));
+    }
+    return new Promise((resolve, reject) => {
+      this.queue.push({ id: this.nextId++, source, resolve, reject });
+      this.dispatch();
+    });
+  }
+
+  /**
+   * Parse many scripts in parallel; fails with the first error, in the
+   * order of the sources
+   */
+  parseAll(sources: string[]): Promise<Statement[][]> {
+    return Promise.all(sources.map(source => this.parse(source)));
+  }
+
+  /**
+   * Stop the workers, failing sources that have not been parsed yet
+   */
+  async close(): Promise<void> {
+    this.closed = true;
+    const error = new Error('Parser pool is closed');
+    for (const task of this.queue.splice(0)) {
+      task.reject(error);
+    }
+    const threads = this.threads.splice(0);
+    for (const thread of threads) {
+      thread.task?.reject(error);
+      thread.task = undefined;
+    }
+    await Promise.all(threads.map(thread => thread.worker.terminate()));
+  }
+
+  private dispatch(): void {
+    while (this.queue.length > 0) {
+      let thread = this.threads.find(thread => !thread.task);
+      if (!thread) {
+        if (this.threads.length >= this.size) {
+          return;
+        }
+        thread = this.startThread();
+      }
+      const task = this.queue.shift()!;
+      thread.task = task;
+      thread.worker.ref();
+      const request: PoolRequest = { id: task.id, source: task.source };
+      thread.worker.postMessage(request);
+    }
+  }
+
+  private startThread(): PoolThread {
+    const worker = new Worker(this.workerPath, { workerData: this.workerData });
+    const thread: PoolThread = { worker };
+    this.threads.push(thread);
+
+    worker.on('message', (response: PoolResponse) => {
+      const task = thread.task;
+      if (!task || task.id !== response.id) {
+        return;
+      }
+      thread.task = undefined;
+      worker.unref();
+      if ('error' in response) {
+        task.reject(toError(response.error));
+      } else {
+        restoreLocations(response.statements, response.locations);
+        task.resolve(response.statements);
+      }
+      this.dispatch();
+    });
+    const fail = (error: Error) => {
+      const index = this.threads.indexOf(thread);
+      if (index === -1) {
+        return;
+      }
+      this.threads.splice(index, 1);
+      thread.task?.reject(error);
+      thread.task = undefined;
+      this.dispatch();
+    };
+    worker.on('error', fail);
+    worker.on('exit', code =>
+      fail(new Error(`Parser worker stopped with exit code ${code}`))
+    );
+    return thread;
+  }
+}
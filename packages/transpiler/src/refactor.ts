@@ -0,0 +1,834 @@
+// Refactorings of scripts, as edits of their files
+//
+// Each refactoring checks that it keeps the script's meaning, throwing a
+// RefactorError naming what stands in the way when it would not, and
+// otherwise returns the edits that carry it out, by file. Editors apply
+// them as workspace edits through the language server's code actions, and
+// `mcps refactor` applies them to files for scripted cleanups.
+// - Inline variable: replace the uses of a variable assigned once with its
+//   value, and remove the assignment
+// - Extract workflow: move statements into a new tool, called where they
+//   were, with the variables they read as parameters and the one they
+//   assign for later statements as its result
+// - Move declaration: move a top-level declaration to another module,
+//   importing that module where the declaration is still used
+// - Extract prompts: move the system prompts agents write as strings into
+//   prompt declarations of a prompts module
+import path from 'path';
+import { positionAt, type SourceLocation } from './locations.js';
+import {
+  buildSymbolTable,
+  templateNames,
+  type ImportedDeclaration,
+} from './semantics.js';
+import { parseTree, type SyntaxNode, type SyntaxTree } from './syntax.js';
+import { ALLOWED_GLOBALS } from './validator.js';
+
+/**
+ * A change of a file: the text of a range replaced, or inserted where the
+ * range is empty
+ */
+export interface RefactorEdit {
+  location: SourceLocation;
+  newText: string;
+}
+
+/**
+ * The edits of a refactoring
+ */
+export interface Refactoring {
+  /** What the refactoring does, as editors offer it */
+  title: string;
+  /** Edits by file path; edits of a file that does not exist create it */
+  edits: Record<string, RefactorEdit[]>;
+}
+
+/**
+ * A script file to refactor
+ */
+export interface RefactorSource {
+  path: string;
+  content: string;
+  /** Syntax tree of the content, parsed from it when left out */
+  tree?: SyntaxTree;
+  /** Declarations of the modules the file imports */
+  imports?: ImportedDeclaration[];
+}
+
+/**
+ * Error thrown for a refactoring that cannot be done
+ */
+export class RefactorError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'RefactorError';
+  }
+}
+
+const IDENTIFIER = /^[A-Za-z_][A-Za-z0-9_]*$/;
+
+/** Statements that can be moved into a workflow */
+const EXTRACTABLE = new Set([
+  'comment',
+  'assignment',
+  'expression_statement',
+  'block_statement',
+  'if_statement',
+  'while_statement',
+  'for_statement',
+  'parallel_statement',
+  'parallel_map_statement',
+  'annotated_statement',
+  'compensated_statement',
+  'listen_statement',
+]);
+
+/** Declarations that can be moved to another module */
+const MOVABLE = new Set([
+  'tool_declaration',
+  'pipeline_declaration',
+  'mcp_declaration',
+  'model_declaration',
+  'agent_declaration',
+  'prompt_declaration',
+]);
+
+/** Expressions that need parentheses to be used inside another one */
+const COMPOUND = new Set([
+  'binary_expression',
+  'unary_expression',
+  'with_expression',
+]);
+
+/** Expressions whose operands are bound more tightly than a compound one */
+const OPERATORS = new Set([
+  'binary_expression',
+  'unary_expression',
+  'member_expression',
+  'bracket_expression',
+  'call_expression',
+  'with_expression',
+]);
+
+const LOOPS = new Set(['while_statement', 'for_statement']);
+
+function edit(
+  content: string,
+  start: number,
+  end: number,
+  newText: string
+): RefactorEdit {
+  return {
+    location: {
+      start: positionAt(content, start),
+      end: positionAt(content, end),
+    },
+    newText,
+  };
+}
+
+function inside(node: SyntaxNode, start: number, end: number): boolean {
+  return start <= node.startIndex && node.endIndex <= end;
+}
+
+function lineStart(content: string, offset: number): number {
+  return content.lastIndexOf('\n', offset - 1) + 1;
+}
+
+/**
+ * The range of whole lines holding a range, when nothing else is on them,
+ * so removing it leaves no blank line behind
+ */
+function lineRange(
+  content: string,
+  start: number,
+  end: number
+): [number, number] {
+  const from = lineStart(content, start);
+  const newline = content.indexOf('\n', end);
+  const to = newline === -1 ? content.length : newline + 1;
+  if (
+    content.slice(from, start).trim() ||
+    content.slice(end, newline === -1 ? content.length : newline).trim()
+  ) {
+    return [start, end];
+  }
+  return [from, to];
+}
+
+function identifierAt(tree: SyntaxTree, offset: number): SyntaxNode {
+  const node = tree.rootNode.descendantForIndex(offset);
+  if (node.type === 'identifier') {
+    return node;
+  }
+  // The cursor may be just after the name
+  const before = tree.rootNode.descendantForIndex(Math.max(0, offset - 1));
+  if (before.type === 'identifier') {
+    return before;
+  }
+  throw new RefactorError('No variable at the cursor');
+}
+
+/**
+ * Apply edits to the content of a file
+ */
+export function applyRefactorEdits(
+  content: string,
+  edits: RefactorEdit[]
+): string {
+  // From the end, so earlier offsets stay valid; inserts at the same
+  // offset keep their order
+  const sorted = edits
+    .map((change, index) => ({ change, index }))
+    .sort(
+      (a, b) =>
+        b.change.location.start.offset - a.change.location.start.offset ||
+        b.index - a.index
+    );
+  for (const { change } of sorted) {
+    content =
+      content.slice(0, change.location.start.offset) +
+      change.newText +
+      content.slice(change.location.end.offset);
+  }
+  return content;
+}
+
+/** Statements whose parts may run many times, or later than they appear */
+const REPEATED = new Set([
+  'while_statement',
+  'for_statement',
+  'parallel_map_statement',
+  'listen_statement',
+  'tool_declaration',
+  'pipeline_declaration',
+  'trigger_declaration',
+  'event_handler',
+  'notification_handler',
+  'test_declaration',
+  'test_hook',
+]);
+
+/** Operators that may leave their right operand unevaluated */
+const SHORT_CIRCUIT = new Set(['&&', '||', '??']);
+
+function ancestors(node: SyntaxNode): SyntaxNode[] {
+  const nodes: SyntaxNode[] = [];
+  for (let parent = node.parent; parent; parent = parent.parent) {
+    nodes.push(parent);
+  }
+  return nodes;
+}
+
+function sameNode(a: SyntaxNode, b: SyntaxNode): boolean {
+  return (
+    a.type === b.type &&
+    a.startIndex === b.startIndex &&
+    a.endIndex === b.endIndex
+  );
+}
+
+/**
+ * Whether an expression is evaluated exactly once each time a statement
+ * after it in the same block runs: the statement holding it is a sibling,
+ * and the expression is in neither a loop's header nor a branch of a
+ * short-circuit operator
+ */
+function evaluatedOnceAfter(statement: SyntaxNode, node: SyntaxNode): boolean {
+  let child = node;
+  for (const parent of ancestors(node)) {
+    if (
+      parent.type === 'binary_expression' &&
+      SHORT_CIRCUIT.has(parent.child(1)?.type ?? '') &&
+      !sameNode(child, parent.firstNamedChild!)
+    ) {
+      return false;
+    }
+    if (parent.type === 'statement') {
+      return (
+        !!parent.parent &&
+        sameNode(parent.parent, statement.parent!) &&
+        !REPEATED.has(parent.firstNamedChild?.type ?? '')
+      );
+    }
+    child = parent;
+  }
+  return false;
+}
+
+/**
+ * A return, break or continue after a statement and before a use in the
+ * same block, which may skip the use whenever the statement runs
+ * Breaks and continues of loops between the two are left out.
+ */
+function exitBetween(
+  statement: SyntaxNode,
+  use: SyntaxNode
+): SyntaxNode | undefined {
+  const block = statement.parent!;
+  return block
+    .descendantsOfType([
+      'return_statement',
+      'break_statement',
+      'continue_statement',
+    ])
+    .find(jump => {
+      if (
+        jump.startIndex < statement.endIndex ||
+        jump.startIndex >= use.startIndex ||
+        inside(use, jump.startIndex, jump.endIndex)
+      ) {
+        return false;
+      }
+      if (jump.type === 'return_statement') {
+        return true;
+      }
+      let loop = jump.parent;
+      while (loop && !LOOPS.has(loop.type)) {
+        loop = loop.parent;
+      }
+      return !loop || !inside(loop, statement.endIndex, use.startIndex);
+    });
+}
+
+/**
+ * Replace the uses of the variable at an offset with its value, and remove
+ * its assignment
+ * The variable must be assigned once. A value that calls a tool is only
+ * inlined into a single use that runs once whenever the assignment did,
+ * in the same block, with no other call or early exit in between, so the
+ * call still happens once and in the same order.
+ */
+export function inlineVariable(
+  source: RefactorSource,
+  offset: number
+): Refactoring {
+  const { content } = source;
+  const tree = source.tree ?? parseTree(content);
+  const table = buildSymbolTable(tree, source.imports);
+  const symbol = table.lookup(identifierAt(tree, offset));
+  if (!symbol || symbol.kind !== 'variable' || symbol.path !== undefined) {
+    throw new RefactorError('No variable at the cursor');
+  }
+  const { name } = symbol;
+  const assignment = symbol.node.parent?.parent;
+  const value = assignment?.lastNamedChild;
+  if (assignment?.type !== 'assignment' || !value) {
+    throw new RefactorError(`${name} is not assigned a value to inline`);
+  }
+  if (symbol.writes.length > 0) {
+    throw new RefactorError(`${name} is assigned more than once`);
+  }
+  if (symbol.references.length === 0) {
+    throw new RefactorError(`${name} is never used`);
+  }
+  if (templateNames([symbol.scope.node]).has(name)) {
+    throw new RefactorError(`${name} is used in a template string`);
+  }
+  const statement = assignment.parent!;
+  if (value.descendantsOfType('call_expression').length > 0) {
+    if (symbol.references.length > 1) {
+      throw new RefactorError(
+        `The value of ${name} calls a tool, which inlining would call ${symbol.references.length} times`
+      );
+    }
+    const [use] = symbol.references;
+    if (!evaluatedOnceAfter(statement, use)) {
+      throw new RefactorError(
+        `The value of ${name} calls a tool, which inlining would move where it may not run exactly once`
+      );
+    }
+    const between = tree.rootNode
+      .descendantsOfType('call_expression')
+      .find(
+        call =>
+          call.startIndex >= statement.endIndex &&
+          call.startIndex < use.startIndex &&
+          !inside(use, call.startIndex, call.endIndex)
+      );
+    if (between) {
+      throw new RefactorError(
+        `The value of ${name} calls a tool, which inlining would move past the call ${between.text}`
+      );
+    }
+    const exit = exitBetween(statement, use);
+    if (exit) {
+      throw new RefactorError(
+        `The value of ${name} calls a tool, which inlining would move past ${exit.text}`
+      );
+    }
+  }
+  // Uses in a loop or tool not holding the assignment may run after any
+  // later assignment of the variables the value reads
+  const repeated = symbol.references.some(use =>
+    ancestors(use).some(
+      node =>
+        REPEATED.has(node.type) &&
+        !inside(statement, node.startIndex, node.endIndex)
+    )
+  );
+  // The variables of the value must still hold the same at every use
+  for (const reference of table.references) {
+    const read = reference.symbol;
+    if (!read || !inside(reference.node, value.startIndex, value.endIndex)) {
+      continue;
+    }
+    const rebound = read.writes.find(
+      write =>
+        write.startIndex > assignment.endIndex &&
+        (repeated ||
+          symbol.references.some(use => write.startIndex < use.startIndex))
+    );
+    if (rebound) {
+      throw new RefactorError(
+        `${read.name} is assigned again before ${name} is used`
+      );
+    }
+  }
+
+  const compound = COMPOUND.has(value.firstNamedChild?.type ?? '');
+  const edits = symbol.references.map(use => {
+    const context = use.parent?.parent?.type ?? '';
+    const text =
+      compound && OPERATORS.has(context) ? `(${value.text})` : value.text;
+    return edit(content, use.startIndex, use.endIndex, text);
+  });
+  const [start, end] = lineRange(
+    content,
+    statement.startIndex,
+    statement.endIndex
+  );
+  edits.unshift(edit(content, start, end, ''));
+  return { title: `Inline variable ${name}`, edits: { [source.path]: edits } };
+}
+
+/**
+ * A name for a new top-level declaration that no other one uses
+ */
+export function unusedName(source: RefactorSource, base: string): string {
+  const tree = source.tree ?? parseTree(source.content);
+  const taken = new Set(
+    buildSymbolTable(tree, source.imports).symbols.map(symbol => symbol.name)
+  );
+  let name = base;
+  for (let n = 2; taken.has(name) || ALLOWED_GLOBALS.has(name); n++) {
+    name = `${base}${n}`;
+  }
+  return name;
+}
+
+/**
+ * Move the statements in a range into a new tool, called where they were
+ * Statements only partly in the range are moved whole. The tool takes the
+ * variables the statements read from before them as parameters, and
+ * returns the one variable they assign that later statements read.
+ */
+export function extractWorkflow(
+  source: RefactorSource,
+  range: { start: number; end: number },
+  name: string
+): Refactoring {
+  const { content } = source;
+  const tree = source.tree ?? parseTree(content);
+  if (!IDENTIFIER.test(name)) {
+    throw new RefactorError(`Invalid identifier: ${name}`);
+  }
+  const table = buildSymbolTable(tree, source.imports);
+  if (
+    ALLOWED_GLOBALS.has(name) ||
+    table.root.symbols.some(symbol => symbol.name === name)
+  ) {
+    throw new RefactorError(`${name} is already declared`);
+  }
+
+  let container: SyntaxNode | null = tree.rootNode.descendantForIndex(
+    range.start,
+    Math.max(range.start, range.end - 1)
+  );
+  while (
+    container &&
+    container.type !== 'source_file' &&
+    container.type !== 'block_statement'
+  ) {
+    container = container.parent;
+  }
+  const statements = (container?.namedChildren ?? []).filter(
+    child =>
+      child.type === 'statement' &&
+      child.startIndex < Math.max(range.end, range.start + 1) &&
+      child.endIndex > range.start
+  );
+  if (!container || statements.length === 0) {
+    throw new RefactorError('No statements selected');
+  }
+  const first = statements[0];
+  const last = statements[statements.length - 1];
+  const start = first.startIndex;
+  const end = last.endIndex;
+  for (const statement of statements) {
+    const kind = statement.firstNamedChild?.type ?? '';
+    if (!EXTRACTABLE.has(kind)) {
+      throw new RefactorError(
+        `${kind.replace(/_/g, ' ')}s cannot be moved into a workflow`
+      );
+    }
+    if (statement.descendantsOfType('return_statement').length > 0) {
+      throw new RefactorError('The statements return from the tool');
+    }
+    for (const jump of statement.descendantsOfType([
+      'break_statement',
+      'continue_statement',
+    ])) {
+      let loop = jump.parent;
+      while (loop && !LOOPS.has(loop.type)) {
+        loop = loop.parent;
+      }
+      if (!loop || !inside(loop, start, end)) {
+        throw new RefactorError('The statements leave a loop around them');
+      }
+    }
+  }
+
+  // Parameters: variables of the enclosing scopes the statements read
+  const parameters: string[] = [];
+  for (const { node, symbol } of table.references) {
+    if (
+      inside(node, start, end) &&
+      symbol &&
+      symbol.path === undefined &&
+      (symbol.kind === 'variable' || symbol.kind === 'parameter') &&
+      symbol.node.startIndex < start &&
+      !parameters.includes(symbol.name)
+    ) {
+      parameters.push(symbol.name);
+    }
+  }
+  const visible = table.symbols.filter(
+    symbol =>
+      (symbol.kind === 'variable' || symbol.kind === 'parameter') &&
+      symbol.node.startIndex < start &&
+      symbol.scope.node.startIndex <= start &&
+      end <= symbol.scope.node.endIndex
+  );
+  for (const used of templateNames(statements)) {
+    if (
+      visible.some(symbol => symbol.name === used) &&
+      !parameters.includes(used)
+    ) {
+      parameters.push(used);
+    }
+  }
+
+  // Result: the variable assigned here that is read after the statements
+  const after = container.namedChildren.filter(
+    child => child.startIndex >= end
+  );
+  const laterNames = templateNames(after);
+  const results = table.symbols.filter(
+    symbol =>
+      symbol.kind === 'variable' &&
+      symbol.path === undefined &&
+      (inside(symbol.node, start, end) ||
+        symbol.writes.some(write => inside(write, start, end))) &&
+      (symbol.references.some(use => use.startIndex >= end) ||
+        laterNames.has(symbol.name))
+  );
+  if (results.length > 1) {
+    throw new RefactorError(
+      `The statements assign ${results.map(s => s.name).join(', ')}, which later statements read; a workflow returns one value`
+    );
+  }
+  const result = results[0]?.name;
+
+  // The statements as the tool's body, indented one level
+  const indent = content.slice(lineStart(content, start), start);
+  const baseIndent = /^\s*$/.test(indent) ? indent : '';
+  const lines = content
+    .slice(start, end)
+    .split('\n')
+    .map((line, index) =>
+      index > 0 && line.startsWith(baseIndent)
+        ? line.slice(baseIndent.length)
+        : line
+    );
+  if (result) {
+    lines.push(`return ${result}`);
+  }
+  const body = lines.map(line => (line.trim() ? `  ${line}` : '')).join('\n');
+  const declaration = `tool ${name}(${parameters.join(', ')}) {\n${body}\n}`;
+  const call = `${result ? `${result} = ` : ''}${name}(${parameters.join(', ')})`;
+
+  // The tool goes before the top-level statement the statements are in
+  let top = first;
+  while (top.parent && top.parent.type !== 'source_file') {
+    top = top.parent;
+  }
+  const edits =
+    top === first
+      ? [edit(content, start, end, `${declaration}\n\n${call}`)]
+      : [
+          edit(
+            content,
+            lineStart(content, top.startIndex),
+            lineStart(content, top.startIndex),
+            `${declaration}\n\n`
+          ),
+          edit(content, start, end, call),
+        ];
+  return {
+    title: `Extract workflow ${name}`,
+    edits: { [source.path]: edits },
+  };
+}
+
+/**
+ * The top-level statement declaring a name, and the identifier naming it
+ */
+function findDeclaration(
+  tree: SyntaxTree,
+  name: string
+): { statement: SyntaxNode; declaration: SyntaxNode } | undefined {
+  for (const statement of tree.rootNode.namedChildren) {
+    const declaration = statement.firstNamedChild;
+    if (
+      declaration &&
+      MOVABLE.has(declaration.type) &&
+      declaration.namedChildren.find(child => child.type === 'identifier')
+        ?.text === name
+    ) {
+      return { statement, declaration };
+    }
+  }
+  return undefined;
+}
+
+/**
+ * The specifier a module imports another by: relative, without the .mcps
+ * extension
+ */
+function importSpecifier(from: string, to: string): string {
+  const relative = path
+    .relative(path.dirname(from), to)
+    .split(path.sep)
+    .join('/')
+    .replace(/\.mcps$/, '');
+  return relative.startsWith('.') ? relative : `./${relative}`;
+}
+
+/**
+ * The edit importing a module into a file after its last import, or at the
+ * top; undefined when the file imports the module already
+ */
+function importEdit(
+  source: RefactorSource & { tree: SyntaxTree },
+  module: string
+): RefactorEdit | undefined {
+  const { content, tree } = source;
+  const imports = tree.rootNode.namedChildren.filter(
+    child => child.firstNamedChild?.type === 'import_statement'
+  );
+  const imported = imports.some(child => {
+    const written = child.firstNamedChild!.lastNamedChild!.text.slice(1, -1);
+    const resolved = path.resolve(path.dirname(source.path), written);
+    return [resolved, `${resolved}.mcps`].includes(path.resolve(module));
+  });
+  if (imported) {
+    return undefined;
+  }
+  const specifier = importSpecifier(source.path, module);
+  const lastImport = imports[imports.length - 1];
+  return lastImport
+    ? edit(
+        content,
+        lastImport.endIndex,
+        lastImport.endIndex,
+        `\nimport "${specifier}"`
+      )
+    : edit(content, 0, 0, `import "${specifier}"\n\n`);
+}
+
+/**
+ * The edit appending declarations to the end of a module, a blank line
+ * after what it has
+ */
+function appendEdit(content: string, text: string): RefactorEdit {
+  const separator =
+    content === '' || content.endsWith('\n\n')
+      ? ''
+      : content.endsWith('\n')
+        ? '\n'
+        : '\n\n';
+  return edit(
+    content,
+    content.length,
+    content.length,
+    `${separator}${text}\n`
+  );
+}
+
+/**
+ * Move a top-level declaration, with the comments above it, to the end of
+ * another module, which is created if it does not exist yet
+ * The declaration may not use other declarations of the file, which the
+ * other module would not see. The file imports the other module if it
+ * still uses the declaration; scripts importing the file for it need to
+ * import the other module themselves.
+ */
+export function moveDeclaration(
+  source: RefactorSource,
+  name: string,
+  target: { path: string; content?: string }
+): Refactoring {
+  const { content } = source;
+  const tree = source.tree ?? parseTree(content);
+  if (path.resolve(target.path) === path.resolve(source.path)) {
+    throw new RefactorError(`${name} is already in ${target.path}`);
+  }
+  const found = findDeclaration(tree, name);
+  if (!found) {
+    throw new RefactorError(`No top-level declaration named ${name}`);
+  }
+  const { statement, declaration } = found;
+  const kind = declaration.type.replace(/_declaration$/, '');
+  const targetContent = target.content ?? '';
+  if (targetContent && findDeclaration(parseTree(targetContent), name)) {
+    throw new RefactorError(`${target.path} already declares ${name}`);
+  }
+
+  const table = buildSymbolTable(tree, source.imports);
+  for (const { node, symbol } of table.references) {
+    if (
+      symbol &&
+      symbol.scope === table.root &&
+      symbol.kind !== 'variable' &&
+      inside(node, statement.startIndex, statement.endIndex) &&
+      !inside(symbol.node, statement.startIndex, statement.endIndex)
+    ) {
+      throw new RefactorError(
+        symbol.path !== undefined
+          ? `${kind} ${name} uses ${symbol.name}, which ${path.basename(source.path)} imports from ${path.basename(symbol.path)}`
+          : `${kind} ${name} uses ${symbol.name}, which is declared in ${path.basename(source.path)}; move ${symbol.name} first`
+      );
+    }
+  }
+
+  // The doc comments directly above the declaration go with it
+  let from = statement;
+  for (
+    let previous = statement.previousNamedSibling;
+    previous?.firstNamedChild?.type === 'comment' &&
+    previous.endPosition.row === from.startPosition.row - 1;
+    previous = previous.previousNamedSibling
+  ) {
+    from = previous;
+  }
+  const text = content.slice(from.startIndex, statement.endIndex);
+  let [start, end] = lineRange(content, from.startIndex, statement.endIndex);
+  // Take a blank line along, so no two are left next to each other
+  if (content.slice(end).startsWith('\n')) {
+    end++;
+  } else if (start > 1 && content.slice(start - 2, start) === '\n\n') {
+    start--;
+  }
+  const edits: RefactorEdit[] = [edit(content, start, end, '')];
+
+  // The file imports the other module while it still uses the declaration
+  const symbol = table.lookup(
+    declaration.namedChildren.find(child => child.type === 'identifier')!
+  );
+  const stillUsed = symbol?.references.some(
+    use => !inside(use, statement.startIndex, statement.endIndex)
+  );
+  const importing = importEdit({ ...source, tree }, target.path);
+  if (stillUsed && importing) {
+    edits.unshift(importing);
+  }
+
+  return {
+    title: `Move ${kind} ${name} to ${path.basename(target.path)}`,
+    edits: {
+      [source.path]: edits,
+      [target.path]: [appendEdit(targetContent, text)],
+    },
+  };
+}
+
+/**
+ * Move the system prompts agents give as string literals into prompt
+ * declarations at the end of a prompts module, which is created if it does
+ * not exist yet, so prompts can be reviewed and changed apart from the
+ * workflows using them. Each prompt is named after its agent, the agent
+ * refers to it by name and the file imports the prompts module. Literals
+ * with "${" stay where they are: prompt text would interpolate them.
+ */
+export function extractPrompts(
+  source: RefactorSource,
+  target: { path: string; content?: string }
+): Refactoring {
+  const { content } = source;
+  const tree = source.tree ?? parseTree(content);
+  if (path.resolve(target.path) === path.resolve(source.path)) {
+    throw new RefactorError(`The prompts are already in ${target.path}`);
+  }
+  const targetContent = target.content ?? '';
+  const taken = new Set([
+    ...buildSymbolTable(tree, source.imports).symbols.map(
+      symbol => symbol.name
+    ),
+    ...parseTree(targetContent).rootNode.namedChildren.map(
+      statement =>
+        statement.firstNamedChild?.namedChildren.find(
+          child => child.type === 'identifier'
+        )?.text
+    ),
+  ]);
+
+  const edits: RefactorEdit[] = [];
+  const prompts: string[] = [];
+  for (const statement of tree.rootNode.namedChildren) {
+    const declaration = statement.firstNamedChild;
+    if (declaration?.type !== 'agent_declaration') {
+      continue;
+    }
+    const properties =
+      declaration.namedChildren.find(child => child.type === 'object_literal')
+        ?.firstNamedChild?.namedChildren ?? [];
+    const value = properties.find(
+      property => property.firstNamedChild?.text === 'systemPrompt'
+    )?.lastNamedChild;
+    if (
+      value?.firstNamedChild?.type !== 'literal' ||
+      value.firstNamedChild.firstNamedChild?.type !== 'string' ||
+      value.text.includes('${')
+    ) {
+      continue;
+    }
+    const agent = declaration.namedChildren.find(
+      child => child.type === 'identifier'
+    )!.text;
+    let name = `${agent}Prompt`;
+    for (let n = 2; taken.has(name) || ALLOWED_GLOBALS.has(name); n++) {
+      name = `${agent}Prompt${n}`;
+    }
+    taken.add(name);
+    edits.push(edit(content, value.startIndex, value.endIndex, name));
+    prompts.push(`prompt ${name} { text: ${value.text} }`);
+  }
+  if (prompts.length === 0) {
+    throw new RefactorError(
+      `No agent in ${path.basename(source.path)} has a system prompt written as a string`
+    );
+  }
+
+  const importing = importEdit({ ...source, tree }, target.path);
+  if (importing) {
+    edits.unshift(importing);
+  }
+  return {
+    title: `Extract ${prompts.length === 1 ? 'prompt' : `${prompts.length} prompts`} to ${path.basename(target.path)}`,
+    edits: {
+      [source.path]: edits,
+      [target.path]: [appendEdit(targetContent, prompts.join('\n\n'))],
+    },
+  };
+}
@@ -0,0 +1,258 @@
+// Cron expressions of trigger declarations
+//
+// A trigger such as `trigger nightly: schedule("0 2 * * *") -> ...` fires
+// at the times its five fields allow: minute, hour, day of month, month
+// and day of week, in the local time zone of the process that schedules
+// it. Fields are *, numbers, ranges (1-5), steps (*/15, 0-30/10) and
+// comma-separated lists of those; months and days of the week may also be
+// written as names (JAN, MON). As in cron, a time matches when either of
+// the day fields does if both are restricted. @hourly, @daily, @weekly,
+// @monthly and @yearly stand for their usual expressions.
+import type { CallExpression } from './ast.js';
+
+/**
+ * The times a cron expression allows
+ */
+export interface Schedule {
+  /** The expression as written */
+  expression: string;
+  minutes: Set<number>;
+  hours: Set<number>;
+  /** Days of the month, from 1 */
+  days: Set<number>;
+  /** Months, from 1 for January */
+  months: Set<number>;
+  /** Days of the week, from 0 for Sunday */
+  weekdays: Set<number>;
+  /** Whether the day of the month field is * (or a step over it) */
+  everyDay: boolean;
+  /** Whether the day of the week field is * (or a step over it) */
+  everyWeekday: boolean;
+}
+
+export class ScheduleError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'ScheduleError';
+  }
+}
+
+interface Field {
+  name: string;
+  min: number;
+  max: number;
+  names?: string[];
+}
+
+const FIELDS: Field[] = [
+  { name: 'minute', min: 0, max: 59 },
+  { name: 'hour', min: 0, max: 23 },
+  { name: 'day of the month', min: 1, max: 31 },
+  {
+    name: 'month',
+    min: 1,
+    max: 12,
+    names: [
+      'JAN',
+      'FEB',
+      'MAR',
+      'APR',
+      'MAY',
+      'JUN',
+      'JUL',
+      'AUG',
+      'SEP',
+      'OCT',
+      'NOV',
+      'DEC',
+    ],
+  },
+  // 7 is Sunday as well, as in most crons
+  {
+    name: 'day of the week',
+    min: 0,
+    max: 7,
+    names: ['SUN', 'MON', 'TUE', 'WED', 'THU', 'FRI', 'SAT'],
+  },
+];
+
+const MACROS: Record<string, string> = {
+  '@hourly': '0 * * * *',
+  '@daily': '0 0 * * *',
+  '@midnight': '0 0 * * *',
+  '@weekly': '0 0 * * 0',
+  '@monthly': '0 0 1 * *',
+  '@yearly': '0 0 1 1 *',
+  '@annually': '0 0 1 1 *',
+};
+
+/** Days in each month of a leap year */
+const MONTH_LENGTHS = [31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31];
+
+/** Years searched for the next time before a schedule is given up on */
+const MAX_SEARCH_YEARS = 8;
+
+/**
+ * Parse a cron expression
+ */
+export function parseSchedule(expression: string): Schedule {
+  const invalid = (problem: string) =>
+    new ScheduleError(`Invalid schedule "${expression}": ${problem}`);
+
+  const trimmed = expression.trim();
+  const fields = (MACROS[trimmed.toLowerCase()] ?? trimmed).split(/\s+/);
+  if (fields.length !== FIELDS.length) {
+    throw invalid(
+      trimmed.startsWith('@')
+        ? `unknown macro ${trimmed}`
+        : 'expected 5 fields (minute hour day month weekday)'
+    );
+  }
+
+  const values = fields.map((text, index) => {
+    const field = FIELDS[index];
+    const value = (part: string): number => {
+      const named = field.names?.indexOf(part.toUpperCase()) ?? -1;
+      if (named !== -1) {
+        return named + field.min;
+      }
+      if (!/^\d+$/.test(part)) {
+        throw invalid(`${part} is not a valid ${field.name}`);
+      }
+      const number = parseInt(part, 10);
+      if (number < field.min || number > field.max) {
+        throw invalid(
+          `${field.name} ${number} is out of range ${field.min}-${field.max}`
+        );
+      }
+      return number;
+    };
+
+    const set = new Set<number>();
+    for (const item of text.split(',')) {
+      const [range, stepText, ...rest] = item.split('/');
+      if (rest.length > 0 || stepText === '') {
+        throw invalid(`${item} is not a valid ${field.name}`);
+      }
+      const step = stepText === undefined ? 1 : parseInt(stepText, 10);
+      if (stepText !== undefined && (!/^\d+$/.test(stepText) || step === 0)) {
+        throw invalid(`${stepText} is not a valid step`);
+      }
+      let first: number;
+      let last: number;
+      if (range === '*') {
+        first = field.min;
+        last = field.max;
+      } else if (range.includes('-')) {
+        const [from, to] = range.split('-', 2);
+        first = value(from);
+        last = value(to);
+        if (first > last) {
+          throw invalid(`${range} is an empty range`);
+        }
+      } else {
+        first = value(range);
+        // 5/15 starts at 5 and steps to the end of the field
+        last = stepText === undefined ? first : field.max;
+      }
+      for (let n = first; n <= last; n += step) {
+        set.add(n);
+      }
+    }
+    return set;
+  });
+
+  const [minutes, hours, days, months, weekdays] = values;
+  if (weekdays.delete(7)) {
+    weekdays.add(0);
+  }
+  const schedule: Schedule = {
+    expression,
+    minutes,
+    hours,
+    days,
+    months,
+    weekdays,
+    everyDay: fields[2].startsWith('*'),
+    everyWeekday: fields[4].startsWith('*'),
+  };
+
+  // 30 February never comes, and a schedule waiting for it never fires
+  if (
+    !schedule.everyDay &&
+    schedule.everyWeekday &&
+    ![...months].some(month =>
+      [...days].some(day => day <= MONTH_LENGTHS[month - 1])
+    )
+  ) {
+    throw invalid('none of its months have any of its days');
+  }
+  return schedule;
+}
+
+/**
+ * Whether a schedule allows the day of a time
+ */
+function matchesDay(schedule: Schedule, time: Date): boolean {
+  const day = schedule.days.has(time.getDate());
+  const weekday = schedule.weekdays.has(time.getDay());
+  if (schedule.everyDay || schedule.everyWeekday) {
+    return day && weekday;
+  }
+  return day || weekday;
+}
+
+/**
+ * The first time after the given one that a schedule fires, to the minute
+ */
+export function nextScheduledTime(
+  schedule: Schedule,
+  after: Date
+): Date | undefined {
+  const time = new Date(after.getTime());
+  time.setSeconds(0, 0);
+  time.setMinutes(time.getMinutes() + 1);
+  const limit = new Date(after.getTime());
+  limit.setFullYear(limit.getFullYear() + MAX_SEARCH_YEARS);
+
+  // Skip whole months, days and hours that do not match before minutes
+  while (time <= limit) {
+    if (!schedule.months.has(time.getMonth() + 1)) {
+      time.setMonth(time.getMonth() + 1, 1);
+      time.setHours(0, 0, 0, 0);
+    } else if (!matchesDay(schedule, time)) {
+      time.setDate(time.getDate() + 1);
+      time.setHours(0, 0, 0, 0);
+    } else if (!schedule.hours.has(time.getHours())) {
+      time.setHours(time.getHours() + 1, 0, 0, 0);
+    } else if (!schedule.minutes.has(time.getMinutes())) {
+      time.setMinutes(time.getMinutes() + 1, 0, 0);
+    } else {
+      return time;
+    }
+  }
+  return undefined;
+}
+
+/**
+ * The schedule of a trigger's source, or null for a trigger that only
+ * fires through webhook()
+ */
+export function triggerSchedule(source: CallExpression): Schedule | null {
+  const { callee, arguments: args, options } = source;
+  const name = callee.type === 'identifier' ? callee.name : undefined;
+  if (!options && name === 'webhook' && args.length === 0) {
+    return null;
+  }
+  if (
+    !options &&
+    name === 'schedule' &&
+    args.length === 1 &&
+    args[0].type === 'string'
+  ) {
+    return parseSchedule(args[0].value);
+  }
+  throw new ScheduleError(
+    'expected schedule("<cron expression>") or webhook() before ->'
+  );
+}
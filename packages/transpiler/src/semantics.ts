@@ -0,0 +1,300 @@
+// Scopes and symbol resolution built on the grammar's locals query
+import { getQuery, type SyntaxNode, type SyntaxTree } from './syntax.js';
+import { ALLOWED_GLOBALS } from './validator.js';
+import type { ModuleGraph, ScriptModule } from './modules.js';
+
+export type ScriptSymbolKind =
+  | 'tool'
+  | 'mcp'
+  | 'model'
+  | 'agent'
+  | 'prompt'
+  | 'parameter'
+  | 'variable';
+
+export interface Scope {
+  node: SyntaxNode;
+  parent?: Scope;
+  children: Scope[];
+  symbols: ScriptSymbol[];
+}
+
+export interface ScriptSymbol {
+  name: string;
+  kind: ScriptSymbolKind;
+  /** Identifier that declares the symbol */
+  node: SyntaxNode;
+  scope: Scope;
+  /** Module the symbol is declared in, when it was imported */
+  path?: string;
+  /** Later assignments that rebind the symbol */
+  writes: SyntaxNode[];
+  references: SyntaxNode[];
+}
+
+export interface SymbolReference {
+  node: SyntaxNode;
+  symbol?: ScriptSymbol;
+}
+
+export interface SymbolTable {
+  root: Scope;
+  symbols: ScriptSymbol[];
+  references: SymbolReference[];
+  /** Find the symbol an identifier declares, rebinds or refers to */
+  lookup(node: SyntaxNode): ScriptSymbol | undefined;
+  /** Innermost scope containing a node */
+  scopeAt(node: SyntaxNode): Scope;
+  /** References that resolve to neither a symbol nor a runtime global */
+  unresolved(): SymbolReference[];
+  /** Identifiers in this tree naming a symbol, in document order */
+  occurrences(symbol: ScriptSymbol): SyntaxNode[];
+}
+
+/**
+ * A top-level declaration of another module
+ */
+export interface ImportedDeclaration {
+  name: string;
+  kind: ScriptSymbolKind;
+  /** Identifier that declares it, in the other module's tree */
+  node: SyntaxNode;
+  path: string;
+}
+
+const DECLARATION_KINDS: Record<string, ScriptSymbolKind> = {
+  tool_declaration: 'tool',
+  pipeline_declaration: 'tool',
+  pipeline_step: 'variable',
+  mcp_declaration: 'mcp',
+  model_declaration: 'model',
+  agent_declaration: 'agent',
+  prompt_declaration: 'prompt',
+  parameter: 'parameter',
+  map_item: 'parameter',
+};
+
+function contains(outer: SyntaxNode, inner: SyntaxNode): boolean {
+  return (
+    outer.startIndex <= inner.startIndex && inner.endIndex <= outer.endIndex
+  );
+}
+
+/**
+ * Build the symbol table for a syntax tree
+ * Declarations are hoisted to the start of their scope. A variable exists
+ * from the end of its first assignment; assigning to a visible variable
+ * rebinds it, except that a tool body starts a fresh set of variables as
+ * in the generated code
+ */
+export function buildSymbolTable(
+  tree: SyntaxTree,
+  imports: ImportedDeclaration[] = []
+): SymbolTable {
+  const captures = getQuery('locals').captures(tree.rootNode);
+  const visibleFrom = new Map<ScriptSymbol, number>();
+  const byNode = new Map<number, ScriptSymbol>();
+
+  // Captures come in document order, so parents precede their children
+  const scopes: Scope[] = [];
+  for (const { name, node } of captures) {
+    if (name !== 'local.scope') {
+      continue;
+    }
+    let parent: Scope | undefined;
+    for (const scope of scopes) {
+      if (contains(scope.node, node)) {
+        parent = scope;
+      }
+    }
+    const scope: Scope = { node, parent, children: [], symbols: [] };
+    parent?.children.push(scope);
+    scopes.push(scope);
+  }
+  const root = scopes[0] ?? {
+    node: tree.rootNode,
+    children: [],
+    symbols: [],
+  };
+
+  const scopeAt = (node: SyntaxNode): Scope => {
+    let scope = root;
+    for (;;) {
+      const child = scope.children.find(c => contains(c.node, node));
+      if (!child) {
+        return scope;
+      }
+      scope = child;
+    }
+  };
+
+  const resolve = (
+    name: string,
+    at: number,
+    from: Scope,
+    until?: Scope
+  ): ScriptSymbol | undefined => {
+    for (let scope: Scope | undefined = from; scope; scope = scope.parent) {
+      const symbol = scope.symbols.find(
+        s => s.name === name && visibleFrom.get(s)! <= at
+      );
+      if (symbol || scope === until) {
+        return symbol;
+      }
+    }
+    return undefined;
+  };
+
+  const symbols: ScriptSymbol[] = [];
+  const declare = (
+    node: SyntaxNode,
+    kind: ScriptSymbolKind,
+    scope: Scope,
+    from: number,
+    path?: string
+  ) => {
+    const symbol: ScriptSymbol = {
+      name: node.text,
+      kind,
+      node,
+      scope,
+      path,
+      writes: [],
+      references: [],
+    };
+    scope.symbols.push(symbol);
+    symbols.push(symbol);
+    visibleFrom.set(symbol, from);
+    // Node ids are only unique within one tree
+    if (path === undefined) {
+      byNode.set(node.id, symbol);
+    }
+  };
+
+  for (const declaration of imports) {
+    declare(declaration.node, declaration.kind, root, 0, declaration.path);
+  }
+
+  // A definition that names its enclosing scope belongs to the one outside
+  const definingScope = (node: SyntaxNode): Scope => {
+    const scope = scopeAt(node);
+    return scope.node.id === node.parent?.id && scope.parent
+      ? scope.parent
+      : scope;
+  };
+
+  const definitions = captures.filter(c =>
+    c.name.startsWith('local.definition')
+  );
+  for (const { node } of definitions) {
+    const kind = DECLARATION_KINDS[node.parent?.type ?? ''];
+    if (kind && kind !== 'parameter') {
+      const scope = definingScope(node);
+      declare(node, kind, scope, scope.node.startIndex);
+    }
+  }
+
+  const references: SymbolReference[] = [];
+  for (const { name, node } of captures) {
+    if (name === 'local.reference') {
+      const symbol = resolve(node.text, node.startIndex, scopeAt(node));
+      symbol?.references.push(node);
+      references.push({ node, symbol });
+    } else if (DECLARATION_KINDS[node.parent?.type ?? ''] === 'parameter') {
+      declare(node, 'parameter', definingScope(node), node.startIndex);
+    } else if (name.startsWith('local.definition') && !byNode.has(node.id)) {
+      const scope = scopeAt(node);
+      let boundary: Scope | undefined = scope;
+      while (boundary && boundary.node.type !== 'tool_declaration') {
+        boundary = boundary.parent;
+      }
+      const existing = resolve(node.text, node.startIndex, scope, boundary);
+      if (existing) {
+        existing.writes.push(node);
+        byNode.set(node.id, existing);
+      } else {
+        const assignment = node.parent?.parent ?? node;
+        declare(node, 'variable', scope, assignment.endIndex);
+      }
+    }
+  }
+
+  const referenced = new Map(references.map(r => [r.node.id, r.symbol]));
+
+  return {
+    root,
+    symbols,
+    references,
+    lookup: node => byNode.get(node.id) ?? referenced.get(node.id),
+    scopeAt,
+    unresolved: () =>
+      references.filter(r => !r.symbol && !ALLOWED_GLOBALS.has(r.node.text)),
+    occurrences: symbol =>
+      [
+        ...(symbol.path === undefined ? [symbol.node] : []),
+        ...symbol.writes,
+        ...symbol.references,
+      ].sort((a, b) => a.startIndex - b.startIndex),
+  };
+}
+
+/**
+ * Names used inside the ${...} of the template strings of nodes, which
+ * the symbol table does not see
+ */
+export function templateNames(nodes: SyntaxNode[]): Set<string> {
+  const names = new Set<string>();
+  for (const node of nodes) {
+    for (const template of node.descendantsOfType('template_string')) {
+      for (const [, code] of template.text.matchAll(/\$\{([^}]*)\}/g)) {
+        for (const [name] of code.matchAll(/[A-Za-z_][A-Za-z0-9_]*/g)) {
+          names.add(name);
+        }
+      }
+    }
+  }
+  return names;
+}
+
+/**
+ * Top-level declarations of a module
+ */
+export function moduleDeclarations(
+  module: ScriptModule
+): ImportedDeclaration[] {
+  const declarations: ImportedDeclaration[] = [];
+  for (const statement of module.tree.rootNode.namedChildren) {
+    const declaration = statement.firstNamedChild;
+    const kind = DECLARATION_KINDS[declaration?.type ?? ''];
+    const name = declaration?.namedChildren.find(c => c.type === 'identifier');
+    if (kind && kind !== 'parameter' && name) {
+      const path = module.path;
+      declarations.push({ name: name.text, kind, node: name, path });
+    }
+  }
+  return declarations;
+}
+
+/**
+ * Declarations a module can see through its imports, direct or not,
+ * ready to merge into its symbol table
+ */
+export function importedDeclarations(
+  graph: ModuleGraph,
+  module: ScriptModule = graph.entry
+): ImportedDeclaration[] {
+  const byPath = new Map(graph.modules.map(m => [m.path, m]));
+  const seen = new Set<string>([module.path]);
+  const pending = [...module.imports];
+  const declarations: ImportedDeclaration[] = [];
+  while (pending.length > 0) {
+    const imported = byPath.get(pending.shift()!);
+    if (!imported || seen.has(imported.path)) {
+      continue;
+    }
+    seen.add(imported.path);
+    declarations.push(...moduleDeclarations(imported));
+    pending.push(...imported.imports);
+  }
+  return declarations;
+}
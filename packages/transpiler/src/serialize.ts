@@ -0,0 +1,179 @@
+// Syntax trees and ASTs as JSON and S-expressions, for tools in other
+// languages that cannot load the grammar themselves
+import type { Statement } from './ast.js';
+import { getLocation, type SourceLocation } from './locations.js';
+import type { SyntaxNode, SyntaxPoint, SyntaxTree } from './syntax.js';
+
+/**
+ * A syntax node as JSON
+ * Byte offsets count UTF-8 bytes, as tree-sitter does outside JavaScript;
+ * columns in positions count UTF-16 code units, as in SyntaxPoint.
+ */
+export interface SyntaxNodeJSON {
+  type: string;
+  /** Field of the parent the node is in, when the grammar names one */
+  field?: string;
+  /** False for anonymous nodes: keywords, operators and punctuation */
+  named: boolean;
+  /** Set on ERROR nodes, which hold text that could not be parsed */
+  error?: true;
+  /** Set on tokens the parser inserted to recover from an error */
+  missing?: true;
+  startByte: number;
+  endByte: number;
+  startPosition: SyntaxPoint;
+  endPosition: SyntaxPoint;
+  /** Source text of nodes without children (or named ones, with namedOnly) */
+  text?: string;
+  children?: SyntaxNodeJSON[];
+}
+
+export interface SyntaxJSONOptions {
+  /** Leave out anonymous nodes (default: false) */
+  namedOnly?: boolean;
+}
+
+/**
+ * An AST node as JSON, with the location it was parsed from
+ */
+export type ASTNodeJSON = {
+  [key: string]: unknown;
+  location?: SourceLocation;
+};
+
+/**
+ * UTF-8 byte offset of every UTF-16 offset of the content
+ */
+function byteOffsets(content: string): Uint32Array {
+  const offsets = new Uint32Array(content.length + 1);
+  let bytes = 0;
+  for (let i = 0; i < content.length; i++) {
+    offsets[i] = bytes;
+    const code = content.charCodeAt(i);
+    if (code < 0x80) {
+      bytes += 1;
+    } else if (code < 0x800) {
+      bytes += 2;
+    } else if (code >= 0xd800 && code <= 0xdbff && i + 1 < content.length) {
+      // A surrogate pair is one 4-byte character
+      offsets[++i] = bytes;
+      bytes += 4;
+    } else {
+      bytes += 3;
+    }
+  }
+  offsets[content.length] = bytes;
+  return offsets;
+}
+
+/**
+ * Serialize a syntax tree, or the subtree of a node, to JSON
+ * The content must be the source the tree was parsed from.
+ */
+export function syntaxTreeToJSON(
+  tree: SyntaxTree | SyntaxNode,
+  content: string,
+  options: SyntaxJSONOptions = {}
+): SyntaxNodeJSON {
+  const root = 'rootNode' in tree ? tree.rootNode : tree;
+  const offsets = byteOffsets(content);
+  const cursor = root.walk();
+
+  const visit = (): SyntaxNodeJSON => {
+    const node = cursor.currentNode;
+    const json: SyntaxNodeJSON = {
+      type: node.type,
+      ...(cursor.currentFieldName && { field: cursor.currentFieldName }),
+      named: node.isNamed,
+      ...(node.type === 'ERROR' && { error: true as const }),
+      ...(node.isMissing && { missing: true as const }),
+      startByte: offsets[node.startIndex],
+      endByte: offsets[node.endIndex],
+      startPosition: node.startPosition,
+      endPosition: node.endPosition,
+    };
+    const children: SyntaxNodeJSON[] = [];
+    if (cursor.gotoFirstChild()) {
+      do {
+        if (!options.namedOnly || cursor.currentNode.isNamed) {
+          children.push(visit());
+        }
+      } while (cursor.gotoNextSibling());
+      cursor.gotoParent();
+    }
+    if (children.length > 0) {
+      json.children = children;
+    } else {
+      json.text = content.slice(node.startIndex, node.endIndex);
+    }
+    return json;
+  };
+  return visit();
+}
+
+function formatPoint({ row, column }: SyntaxPoint): string {
+  return `[${row}, ${column}]`;
+}
+
+/**
+ * Format a syntax tree as an S-expression of its named nodes, one per
+ * line, as `tree-sitter parse` prints them
+ * Positions are 0-based rows and columns; field names prefix the nodes in
+ * a field, and inserted tokens show as (MISSING type).
+ */
+export function syntaxTreeToSExpression(
+  tree: SyntaxTree | SyntaxNode,
+  options: { positions?: boolean } = {}
+): string {
+  const { positions = true } = options;
+  const root = 'rootNode' in tree ? tree.rootNode : tree;
+  const cursor = root.walk();
+  const lines: string[] = [];
+
+  const visit = (depth: number): void => {
+    const node = cursor.currentNode;
+    const field = cursor.currentFieldName;
+    let line = `${'  '.repeat(depth)}${field ? `${field}: ` : ''}(`;
+    line += node.isMissing ? `MISSING ${node.type}` : node.type;
+    if (positions) {
+      line += ` ${formatPoint(node.startPosition)} - ${formatPoint(node.endPosition)}`;
+    }
+    lines.push(line);
+    if (cursor.gotoFirstChild()) {
+      do {
+        if (cursor.currentNode.isNamed || cursor.currentNode.isMissing) {
+          visit(depth + 1);
+        }
+      } while (cursor.gotoNextSibling());
+      cursor.gotoParent();
+    }
+    lines[lines.length - 1] += ')';
+  };
+  visit(0);
+  return lines.join('\n');
+}
+
+function nodeToJSON(node: unknown): unknown {
+  if (Array.isArray(node)) {
+    return node.map(nodeToJSON);
+  }
+  if (!node || typeof node !== 'object') {
+    return node;
+  }
+  const json: ASTNodeJSON = Object.fromEntries(
+    Object.entries(node).map(([key, value]) => [key, nodeToJSON(value)])
+  );
+  const location = getLocation(node);
+  if (location) {
+    json.location = location;
+  }
+  return json;
+}
+
+/**
+ * Copy AST statements into plain JSON, giving every node that came from
+ * the parser its location
+ */
+export function programToJSON(statements: Statement[]): ASTNodeJSON[] {
+  return statements.map(nodeToJSON) as ASTNodeJSON[];
+}
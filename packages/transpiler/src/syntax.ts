@@ -0,0 +1,158 @@
+// Syntax tree and query access for editor tooling
+import type Parser from 'tree-sitter';
+import { checkSourceLimits, checkTreeLimits } from './limits.js';
+
+export type SyntaxTree = Parser.Tree;
+export type SyntaxNode = Parser.SyntaxNode;
+export type SyntaxQuery = Parser.Query;
+export type QueryCapture = Parser.QueryCapture;
+/** A 0-based row and column (columns count UTF-16 code units) */
+export type SyntaxPoint = Parser.Point;
+export type SyntaxEdit = Parser.Edit;
+/** A span of text, as offsets and points */
+export type SyntaxRange = Parser.Range;
+
+/**
+ * Queries shipped in grammar/queries
+ */
+export type QueryName = 'highlights' | 'locals' | 'folds' | 'errors' | 'tags';
+
+/**
+ * The tree-sitter binding the grammar is loaded into: the native one
+ * under Node.js (native.ts) or web-tree-sitter in browsers (browser.ts)
+ * and Node.js installs without native code (wasm.ts)
+ */
+export interface SyntaxBackend {
+  createParser(): {
+    parse(content: string, oldTree?: Parser.Tree): Parser.Tree;
+  };
+  createQuery(name: QueryName): Parser.Query;
+  /** Names of the symbols that are valid in a parse state */
+  lookahead(state: number): Iterable<string>;
+}
+
+let backend: SyntaxBackend | undefined;
+const queries = new Map<QueryName, Parser.Query>();
+
+/**
+ * Use a tree-sitter binding for parsing and queries
+ */
+export function setSyntaxBackend(next: SyntaxBackend): void {
+  backend = next;
+  queries.clear();
+}
+
+export function syntaxBackend(): SyntaxBackend {
+  if (!backend) {
+    throw new Error(
+      'No tree-sitter binding is loaded; import @mcpscript/transpiler, ' +
+        'or call initWasmSyntax() from @mcpscript/transpiler/wasm or ' +
+        'initBrowserSyntax() from @mcpscript/transpiler/browser'
+    );
+  }
+  return backend;
+}
+
+/**
+ * Parse source text into a concrete syntax tree
+ * Pass the previous tree (after editing it) to reparse incrementally
+ * Throws a ParseLimitError for source exceeding the parse limits
+ */
+export function parseTree(content: string, oldTree?: Parser.Tree): Parser.Tree {
+  checkSourceLimits(content);
+  const tree = syntaxBackend().createParser().parse(content, oldTree);
+  checkTreeLimits(tree);
+  return tree;
+}
+
+/**
+ * Reparse a document after edits, reusing the unchanged parts of the
+ * previous tree
+ * The edits are applied to oldTree, which should afterwards only be
+ * compared with the new tree (see diffTokens)
+ */
+export function reparseTree(
+  oldTree: Parser.Tree,
+  content: string,
+  edits: Parser.Edit[]
+): Parser.Tree {
+  for (const edit of edits) {
+    oldTree.edit(edit);
+  }
+  return parseTree(content, oldTree);
+}
+
+/**
+ * Offset of a point in the text, clamped to the end of its line
+ */
+export function offsetAt(content: string, point: Parser.Point): number {
+  let offset = 0;
+  for (let row = 0; row < point.row; row++) {
+    const newline = content.indexOf('\n', offset);
+    if (newline === -1) {
+      return content.length;
+    }
+    offset = newline + 1;
+  }
+  const lineEnd = content.indexOf('\n', offset);
+  const lineLength = (lineEnd === -1 ? content.length : lineEnd) - offset;
+  return offset + Math.min(point.column, lineLength);
+}
+
+/**
+ * Point just after a piece of text inserted at a given point
+ */
+function pointAfter(start: Parser.Point, text: string): Parser.Point {
+  const lines = text.split('\n');
+  if (lines.length === 1) {
+    return { row: start.row, column: start.column + text.length };
+  }
+  return {
+    row: start.row + lines.length - 1,
+    column: lines[lines.length - 1].length,
+  };
+}
+
+/**
+ * Replace the text between two points, returning the new content and
+ * the tree-sitter edit that describes the change
+ */
+export function applyTextEdit(
+  content: string,
+  start: Parser.Point,
+  end: Parser.Point,
+  text: string
+): { content: string; edit: Parser.Edit } {
+  const startIndex = offsetAt(content, start);
+  const oldEndIndex = offsetAt(content, end);
+  const startPosition = pointFromOffset(content, startIndex);
+  return {
+    content: content.slice(0, startIndex) + text + content.slice(oldEndIndex),
+    edit: {
+      startIndex,
+      oldEndIndex,
+      newEndIndex: startIndex + text.length,
+      startPosition,
+      oldEndPosition: pointFromOffset(content, oldEndIndex),
+      newEndPosition: pointAfter(startPosition, text),
+    },
+  };
+}
+
+function pointFromOffset(content: string, offset: number): Parser.Point {
+  const before = content.slice(0, offset);
+  const row = before.split('\n').length - 1;
+  return { row, column: offset - (before.lastIndexOf('\n') + 1) };
+}
+
+/**
+ * Load one of the grammar's queries (compiled once and cached)
+ */
+export function getQuery(name: QueryName): Parser.Query {
+  let query = queries.get(name);
+  if (!query) {
+    query = syntaxBackend().createQuery(name);
+    queries.set(name, query);
+  }
+  return query;
+}
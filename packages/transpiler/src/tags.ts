@@ -0,0 +1,93 @@
+// Tags: the definitions and calls in a set of files, for code navigation
+import {
+  queryFiles,
+  type BatchQueryOptions,
+  type FileCapture,
+  type FileSource,
+} from './batch.js';
+import { getQuery, type SyntaxNode, type SyntaxPoint } from './syntax.js';
+
+/**
+ * A definition or call found by the tags query
+ */
+export interface Tag {
+  file: string;
+  name: string;
+  /**
+   * function, module, model, agent, prompt or variable for definitions,
+   * call for references
+   */
+  kind: string;
+  /** Whether the tag defines the name rather than uses it */
+  definition: boolean;
+  /** Where the whole declaration, assignment or call is */
+  range: { start: SyntaxPoint; end: SyntaxPoint };
+  /** Where the name is */
+  nameRange: { start: SyntaxPoint; end: SyntaxPoint };
+}
+
+export interface TagIndex {
+  /** Tags in the order the files were given, by position within one */
+  tags: Tag[];
+  /** Files that could not be loaded or parsed, and why */
+  failed: { file: string; error: Error }[];
+}
+
+function range(node: SyntaxNode): Tag['range'] {
+  return { start: node.startPosition, end: node.endPosition };
+}
+
+/**
+ * Index the tags of many files at once with the grammar's tags query
+ * Only the first assignment of a top-level variable in a file is a
+ * definition; later ones rebind it.
+ */
+export async function indexTags(
+  files: string[],
+  load: FileSource,
+  options: BatchQueryOptions = {}
+): Promise<TagIndex> {
+  const { captures, failed } = await queryFiles(
+    getQuery('tags'),
+    files,
+    load,
+    options
+  );
+
+  // Pair each tag's node with its name, which come from the same match
+  const matches = new Map<number, { tag?: FileCapture; name?: SyntaxNode }>();
+  for (const capture of captures) {
+    const match = matches.get(capture.match) ?? {};
+    if (capture.name === 'name') {
+      match.name = capture.node;
+    } else {
+      match.tag = capture;
+    }
+    matches.set(capture.match, match);
+  }
+
+  const tags: Tag[] = [];
+  const variables = new Set<string>();
+  for (const { tag, name } of matches.values()) {
+    if (!tag || !name) {
+      continue;
+    }
+    const [role, kind] = tag.name.split('.');
+    if (kind === 'variable') {
+      const key = `${tag.file}\0${name.text}`;
+      if (variables.has(key)) {
+        continue;
+      }
+      variables.add(key);
+    }
+    tags.push({
+      file: tag.file,
+      name: name.text,
+      kind,
+      definition: role === 'definition',
+      range: range(tag.node),
+      nameRange: range(name),
+    });
+  }
+  return { tags, failed };
+}
@@ -0,0 +1,144 @@
+// Highlighted tokens of a syntax tree, and what changed between two trees
+//
+// Editors re-highlight a buffer after each edit. Rather than redo the
+// whole buffer, diffTokens compares the tree before an edit with the one
+// after it and reports only the ranges that changed, with the tokens
+// highlighted there before and after.
+import {
+  getQuery,
+  type SyntaxNode,
+  type SyntaxRange,
+  type SyntaxTree,
+} from './syntax.js';
+
+/**
+ * A node captured by the highlights query, with its capture name (such as
+ * "keyword" or "function.call")
+ */
+export interface HighlightToken extends SyntaxRange {
+  name: string;
+}
+
+/**
+ * Tokens highlighted by the highlights query, ordered by position with
+ * enclosing tokens first, optionally only those overlapping a range
+ * The earliest pattern that captures a node decides its highlight
+ */
+export function highlightTokens(
+  tree: SyntaxTree,
+  range?: SyntaxRange
+): HighlightToken[] {
+  const tokens = new Map<string, HighlightToken>();
+  const patterns = new Map<string, number>();
+  const { startPosition, endPosition } = range ?? tree.rootNode;
+  const matches = getQuery('highlights').matches(tree.rootNode, {
+    startPosition,
+    endPosition,
+  });
+  for (const match of matches) {
+    for (const { name, node } of match.captures) {
+      if (range && !overlaps(node, range)) {
+        continue;
+      }
+      const key = `${node.startIndex}:${node.endIndex}`;
+      const existing = patterns.get(key);
+      if (existing === undefined || match.pattern < existing) {
+        patterns.set(key, match.pattern);
+        tokens.set(key, {
+          name,
+          startIndex: node.startIndex,
+          endIndex: node.endIndex,
+          startPosition: node.startPosition,
+          endPosition: node.endPosition,
+        });
+      }
+    }
+  }
+  return [...tokens.values()].sort(
+    (a, b) => a.startIndex - b.startIndex || b.endIndex - a.endIndex
+  );
+}
+
+function overlaps(node: SyntaxRange, range: SyntaxRange): boolean {
+  return node.startIndex < range.endIndex && range.startIndex < node.endIndex;
+}
+
+/**
+ * What changed in the highlighting of a document between two trees
+ */
+export interface TokenDiff {
+  /** Changed ranges of the document, in the new tree's positions */
+  ranges: SyntaxRange[];
+  /** Tokens of the old tree in those ranges, which no longer apply */
+  removed: HighlightToken[];
+  /** Tokens of the new tree in those ranges */
+  added: HighlightToken[];
+}
+
+function rangeOf(node: SyntaxNode): SyntaxRange {
+  const { startIndex, endIndex, startPosition, endPosition } = node;
+  return { startIndex, endIndex, startPosition, endPosition };
+}
+
+/**
+ * Ranges of the smallest nodes of an edited tree that contain the edits
+ */
+function editedRanges(node: SyntaxNode): SyntaxRange[] {
+  if (!node.hasChanges) {
+    return [];
+  }
+  const changed = node.children.filter(child => child.hasChanges);
+  return changed.length > 0 ? changed.flatMap(editedRanges) : [rangeOf(node)];
+}
+
+/**
+ * Merge ranges that overlap or touch, in order
+ */
+function mergeRanges(ranges: SyntaxRange[]): SyntaxRange[] {
+  const sorted = [...ranges].sort((a, b) => a.startIndex - b.startIndex);
+  const merged: SyntaxRange[] = [];
+  for (const range of sorted) {
+    const last = merged[merged.length - 1];
+    if (last && range.startIndex <= last.endIndex) {
+      if (range.endIndex > last.endIndex) {
+        merged[merged.length - 1] = {
+          ...last,
+          endIndex: range.endIndex,
+          endPosition: range.endPosition,
+        };
+      }
+    } else {
+      merged.push(range);
+    }
+  }
+  return merged;
+}
+
+/**
+ * Compare the highlighting of a document before and after an edit
+ * oldTree must have had the edit applied (reparseTree does this), so that
+ * its positions line up with newTree. Ranges cover both the nodes whose
+ * structure changed and the text that was edited, since changing the text
+ * of a token can keep the structure and still change the token's extent.
+ */
+export function diffTokens(
+  oldTree: SyntaxTree,
+  newTree: SyntaxTree
+): TokenDiff {
+  const ranges = mergeRanges([
+    ...oldTree.getChangedRanges(newTree),
+    ...editedRanges(oldTree.rootNode),
+  ]);
+  const inRanges = (tree: SyntaxTree) => {
+    const tokens = new Map<string, HighlightToken>();
+    for (const range of ranges) {
+      for (const token of highlightTokens(tree, range)) {
+        tokens.set(`${token.startIndex}:${token.endIndex}`, token);
+      }
+    }
+    return [...tokens.values()].sort(
+      (a, b) => a.startIndex - b.startIndex || b.endIndex - a.endIndex
+    );
+  };
+  return { ranges, removed: inRanges(oldTree), added: inRanges(newTree) };
+}
@@ -0,0 +1,1924 @@
+// Static type checking for MCP Script
+import {
+  Statement,
+  Expression,
+  Assignment,
+  CallExpression,
+  BinaryExpression,
+  ObjectLiteral,
+  ToolDeclaration,
+  PipelineDeclaration,
+  PipelineStage,
+  ToolParameter,
+  TypeExpression,
+  ObjectType,
+  PrimitiveType,
+  UnionType,
+  ParallelStatement,
+  ParallelMapStatement,
+  AnnotatedStatement,
+  CompensatedStatement,
+  PromptDeclaration,
+  TriggerDeclaration,
+  EventHandler,
+  NotificationHandler,
+  ListenStatement,
+} from './ast.js';
+import { SourceLocation, getLocation, formatLocation } from './locations.js';
+import {
+  InterpolationError,
+  interpolatedStrings,
+  parseInterpolation,
+} from './interpolation.js';
+import { ScheduleError, triggerSchedule } from './schedule.js';
+import type { JSONSchema } from '@mcpscript/runtime';
+
+/**
+ * Kinds of problems reported by the type checker
+ */
+export type TypeDiagnosticCode =
+  | 'too-many-arguments'
+  | 'missing-argument'
+  | 'argument-type'
+  | 'default-value'
+  | 'return-type'
+  | 'missing-return-value'
+  | 'missing-return'
+  | 'duplicate-property'
+  | 'parallel-limit'
+  | 'parallel-branch'
+  | 'parallel-conflict'
+  | 'call-options'
+  | 'interpolation'
+  | 'trigger'
+  | 'event'
+  | 'notification'
+  | 'listen'
+  | 'guardrail'
+  | 'annotation'
+  | 'compensation'
+  | 'pipeline'
+  | 'test';
+
+/**
+ * A single type checking diagnostic
+ */
+export interface TypeDiagnostic {
+  code: TypeDiagnosticCode;
+  message: string;
+  severity: 'error' | 'warning';
+  location?: SourceLocation;
+}
+
+/**
+ * Error thrown when a program has type errors
+ */
+export class TypeCheckError extends Error {
+  constructor(public readonly diagnostics: TypeDiagnostic[]) {
+    super(
+      diagnostics
+        .map(d => `${formatLocation(d.location)}: ${d.message}`)
+        .join('\n')
+    );
+    this.name = 'TypeCheckError';
+  }
+}
+
+const ANY: PrimitiveType = { type: 'primitive_type', value: 'any' };
+const STRING: PrimitiveType = { type: 'primitive_type', value: 'string' };
+const NUMBER: PrimitiveType = { type: 'primitive_type', value: 'number' };
+const BOOLEAN: PrimitiveType = { type: 'primitive_type', value: 'boolean' };
+const NULL: PrimitiveType = { type: 'primitive_type', value: 'null' };
+const BYTES: PrimitiveType = { type: 'primitive_type', value: 'bytes' };
+const IDEMPOTENCY_KEY: UnionType = {
+  type: 'union_type',
+  types: [STRING, NUMBER],
+};
+// Levels of @trace points; debug ones only show with --trace-level debug
+const TRACE_POINT_LEVELS = ['info', 'debug'];
+
+/**
+ * Render a type the way it would be written in source
+ */
+export function typeToString(type: TypeExpression): string {
+  switch (type.type) {
+    case 'primitive_type':
+      return type.value;
+    case 'array_type': {
+      const element = typeToString(type.elementType);
+      return type.elementType.type === 'union_type'
+        ? `(${element})[]`
+        : `${element}[]`;
+    }
+    case 'object_type': {
+      const properties = type.properties.map(
+        p => `${p.name}${p.optional ? '?' : ''}: ${typeToString(p.typeAnnotation)}`
+      );
+      return `{ ${properties.join(', ')} }`;
+    }
+    case 'union_type':
+      return type.types.map(typeToString).join(' | ');
+  }
+}
+
+/**
+ * JSON Schema of the values of a type, for hosts that call a script's tools
+ */
+export function typeToJsonSchema(type: TypeExpression): JSONSchema {
+  switch (type.type) {
+    case 'primitive_type':
+      if (type.value === 'bytes') {
+        // Hosts send and get binary values as base64
+        return { type: 'string', contentEncoding: 'base64' };
+      }
+      return type.value === 'any' ? {} : { type: type.value };
+    case 'array_type':
+      return { type: 'array', items: typeToJsonSchema(type.elementType) };
+    case 'object_type': {
+      const required = type.properties.filter(p => !p.optional).map(p => p.name);
+      return {
+        type: 'object',
+        properties: Object.fromEntries(
+          type.properties.map(p => [p.name, typeToJsonSchema(p.typeAnnotation)])
+        ),
+        ...(required.length > 0 && { required }),
+      };
+    }
+    case 'union_type': {
+      const members = type.types.map(typeToJsonSchema);
+      if (members.some(member => Object.keys(member).length === 0)) {
+        return {};
+      }
+      // Unions of primitives list their types, others their schemas
+      const primitive = members.every(
+        member => Object.keys(member).length === 1 && 'type' in member
+      );
+      return primitive
+        ? { type: members.map(member => member.type as string) }
+        : { anyOf: members };
+    }
+  }
+}
+
+/**
+ * Check whether a value of type `source` can be used where `target` is expected
+ */
+export function isAssignable(
+  source: TypeExpression,
+  target: TypeExpression
+): boolean {
+  if (isAny(source) || isAny(target)) {
+    return true;
+  }
+
+  if (source.type === 'union_type') {
+    return source.types.every(t => isAssignable(t, target));
+  }
+  if (target.type === 'union_type') {
+    return target.types.some(t => isAssignable(source, t));
+  }
+
+  switch (target.type) {
+    case 'primitive_type':
+      return source.type === 'primitive_type' && source.value === target.value;
+    case 'array_type':
+      return (
+        source.type === 'array_type' &&
+        isAssignable(source.elementType, target.elementType)
+      );
+    case 'object_type':
+      return (
+        source.type === 'object_type' && isObjectAssignable(source, target)
+      );
+  }
+}
+
+/**
+ * Structural compatibility of object types (extra properties are allowed)
+ */
+function isObjectAssignable(source: ObjectType, target: ObjectType): boolean {
+  for (const prop of target.properties) {
+    const sourceProp = source.properties.find(p => p.name === prop.name);
+    if (!sourceProp) {
+      if (!prop.optional) {
+        return false;
+      }
+      continue;
+    }
+    if (!isAssignable(sourceProp.typeAnnotation, prop.typeAnnotation)) {
+      return false;
+    }
+  }
+  return true;
+}
+
+function isAny(type: TypeExpression): boolean {
+  return type.type === 'primitive_type' && type.value === 'any';
+}
+
+/**
+ * Combine two types into a union, collapsing duplicates
+ */
+function unionOf(a: TypeExpression, b: TypeExpression): TypeExpression {
+  if (isAny(a) || isAny(b)) {
+    return ANY;
+  }
+  const types: TypeExpression[] = [];
+  for (const t of [a, b]) {
+    for (const member of t.type === 'union_type' ? t.types : [t]) {
+      if (!types.some(existing => sameType(existing, member))) {
+        types.push(member);
+      }
+    }
+  }
+  return types.length === 1 ? types[0] : { type: 'union_type', types };
+}
+
+function sameType(a: TypeExpression, b: TypeExpression): boolean {
+  return isAssignable(a, b) && isAssignable(b, a);
+}
+
+/**
+ * Scope tracker mapping variable names to their inferred types
+ */
+class TypeScope {
+  private scopes: Map<string, TypeExpression>[] = [new Map()];
+
+  pushScope(): void {
+    this.scopes.push(new Map());
+  }
+
+  popScope(): void {
+    this.scopes.pop();
+  }
+
+  lookup(name: string): TypeExpression | undefined {
+    for (let i = this.scopes.length - 1; i >= 0; i--) {
+      const type = this.scopes[i].get(name);
+      if (type) {
+        return type;
+      }
+    }
+    return undefined;
+  }
+
+  declare(name: string, type: TypeExpression): void {
+    this.scopes[this.scopes.length - 1].set(name, type);
+  }
+
+  /**
+   * Record an assignment. Checking is flow-insensitive, so a variable that
+   * is reassigned with a different type falls back to 'any'
+   */
+  assign(name: string, type: TypeExpression): void {
+    for (let i = this.scopes.length - 1; i >= 0; i--) {
+      const existing = this.scopes[i].get(name);
+      if (existing) {
+        this.scopes[i].set(name, sameType(existing, type) ? existing : ANY);
+        return;
+      }
+    }
+    this.declare(name, type);
+  }
+}
+
+/**
+ * Type checker state for a single program
+ */
+class TypeChecker {
+  readonly diagnostics: TypeDiagnostic[] = [];
+  private readonly scope = new TypeScope();
+  private readonly tools = new Map<
+    string,
+    ToolDeclaration | PipelineDeclaration
+  >();
+  private readonly servers = new Set<string>();
+  private currentTool: ToolDeclaration | null = null;
+  /** Whether the statements checked run in a notification handler */
+  private inNotificationHandler = false;
+
+  check(statements: Statement[]): void {
+    // Tools and pipelines are hoisted, so collect their signatures first
+    for (const stmt of statements) {
+      if (
+        stmt.type === 'tool_declaration' ||
+        stmt.type === 'pipeline_declaration'
+      ) {
+        this.tools.set(stmt.name, stmt);
+      } else if (stmt.type === 'mcp_declaration') {
+        this.servers.add(stmt.name);
+      } else if (stmt.type === 'prompt_declaration') {
+        this.scope.declare(stmt.name, STRING);
+      }
+    }
+
+    for (const stmt of statements) {
+      if (stmt.type === 'notification_handler') {
+        this.checkNotificationHandler(stmt);
+      } else if (
+        stmt.type !== 'event_handler' &&
+        stmt.type !== 'test_declaration' &&
+        stmt.type !== 'test_hook' &&
+        stmt.type !== 'fixture_declaration'
+      ) {
+        this.checkStatement(stmt);
+      }
+    }
+
+    // Triggers and event handlers run once the script has run, with all
+    // its variables set
+    const triggers = new Set<string>();
+    for (const stmt of statements) {
+      if (stmt.type === 'event_handler') {
+        this.checkEventHandler(stmt);
+      } else if (stmt.type === 'trigger_declaration') {
+        if (triggers.has(stmt.name)) {
+          this.report(
+            'trigger',
+            `Trigger ${stmt.name} is declared more than once`,
+            stmt
+          );
+        }
+        triggers.add(stmt.name);
+        this.checkTrigger(stmt);
+      }
+    }
+
+    this.checkTests(statements);
+  }
+
+  /**
+   * Check the fixtures, hooks and test cases of a test file: they run once
+   * the file has run, test cases with unique names and fixtures set in
+   * order as variables of their own
+   */
+  private checkTests(statements: Statement[]): void {
+    for (const stmt of statements) {
+      if (stmt.type !== 'fixture_declaration') {
+        continue;
+      }
+      if (this.scope.lookup(stmt.name)) {
+        this.report(
+          'test',
+          `Fixture ${stmt.name} is already a variable of the script`,
+          stmt
+        );
+      }
+      this.scope.declare(stmt.name, this.inferExpression(stmt.value));
+    }
+
+    const names = new Set<string>();
+    for (const stmt of statements) {
+      if (stmt.type === 'test_declaration') {
+        if (names.has(stmt.name)) {
+          this.report(
+            'test',
+            `Test "${stmt.name}" is declared more than once`,
+            stmt
+          );
+        }
+        names.add(stmt.name);
+      } else if (stmt.type !== 'test_hook') {
+        continue;
+      }
+      const outerTool = this.currentTool;
+      this.currentTool = null;
+      try {
+        this.checkStatement(stmt.body);
+      } finally {
+        this.currentTool = outerTool;
+      }
+    }
+  }
+
+  private report(
+    code: TypeDiagnosticCode,
+    message: string,
+    node: object
+  ): void {
+    this.diagnostics.push({
+      code,
+      message,
+      severity: 'error',
+      location: getLocation(node),
+    });
+  }
+
+  private checkStatement(stmt: Statement): void {
+    switch (stmt.type) {
+      case 'mcp_declaration':
+        this.checkCallPolicy(stmt.config, this.inferObject(stmt.config), false);
+        this.checkInterpolations(stmt.config);
+        break;
+      case 'model_declaration':
+        this.inferExpression(stmt.config);
+        break;
+      case 'agent_declaration':
+        this.inferExpression(stmt.config);
+        this.checkGuardrails(stmt.config);
+        break;
+      case 'prompt_declaration':
+        this.checkPrompt(stmt);
+        break;
+      case 'tool_declaration':
+        this.checkToolDeclaration(stmt);
+        break;
+      case 'pipeline_declaration':
+        this.checkPipeline(stmt);
+        break;
+      case 'assignment':
+        this.checkAssignment(stmt);
+        break;
+      case 'expression_statement':
+        this.inferExpression(stmt.expression);
+        break;
+      case 'block_statement':
+        this.scope.pushScope();
+        try {
+          for (const s of stmt.statements) {
+            this.checkStatement(s);
+          }
+        } finally {
+          this.scope.popScope();
+        }
+        break;
+      case 'if_statement':
+        this.inferExpression(stmt.condition);
+        this.checkStatement(stmt.then);
+        if (stmt.else) {
+          this.checkStatement(stmt.else);
+        }
+        break;
+      case 'while_statement':
+        this.inferExpression(stmt.condition);
+        this.checkStatement(stmt.body);
+        break;
+      case 'for_statement':
+        this.scope.pushScope();
+        try {
+          if (stmt.init) {
+            this.checkAssignment(stmt.init);
+          }
+          if (stmt.condition) {
+            this.inferExpression(stmt.condition);
+          }
+          if (stmt.update) {
+            this.checkAssignment(stmt.update);
+          }
+          this.checkStatement(stmt.body);
+        } finally {
+          this.scope.popScope();
+        }
+        break;
+      case 'parallel_statement':
+        this.checkParallel(stmt);
+        break;
+      case 'parallel_map_statement':
+        this.checkParallelMap(stmt);
+        break;
+      case 'annotated_statement':
+        this.checkAnnotated(stmt);
+        break;
+      case 'compensated_statement':
+        this.checkCompensated(stmt);
+        break;
+      case 'return_statement':
+        this.checkReturn(stmt.value, stmt);
+        break;
+      case 'event_handler':
+        this.report(
+          'event',
+          'Event handlers must be declared at the top level of a script',
+          stmt
+        );
+        break;
+      case 'notification_handler':
+        this.report(
+          'notification',
+          'Notification handlers must be declared at the top level of a script',
+          stmt
+        );
+        break;
+      case 'listen_statement':
+        this.checkListen(stmt);
+        break;
+      case 'test_declaration':
+      case 'test_hook':
+      case 'fixture_declaration':
+        this.report(
+          'test',
+          'Test cases, hooks and fixtures must be declared at the top level of a script',
+          stmt
+        );
+        break;
+      default:
+        break;
+    }
+  }
+
+  /**
+   * Check a parallel statement: branches run in no particular order, so
+   * they may not leave the block, declare anything, or share a variable
+   * that one of them assigns
+   */
+  private checkParallel(stmt: ParallelStatement): void {
+    if (stmt.limit) {
+      const limitType = this.inferExpression(stmt.limit);
+      if (!isAssignable(limitType, NUMBER)) {
+        this.report(
+          'parallel-limit',
+          `Parallel limit must be a number, got '${typeToString(limitType)}'`,
+          stmt.limit
+        );
+      }
+    }
+
+    // Variables first assigned inside a branch are local to it, except for
+    // branches that are assignments
+    const isShared = (name: string) =>
+      this.scope.lookup(name) !== undefined ||
+      stmt.branches.some(
+        branch =>
+          branch.type === 'assignment' &&
+          branch.target.type === 'identifier' &&
+          branch.target.name === name
+      );
+    const variables = stmt.branches.map(branch => {
+      const assigned = new Set<string>();
+      const used = new Set<string>();
+      collectVariables(branch, assigned, used);
+      return { assigned, used };
+    });
+
+    stmt.branches.forEach((branch, index) => {
+      if (DECLARATIONS.has(branch.type)) {
+        this.report(
+          'parallel-branch',
+          'Declarations are not allowed in a parallel block',
+          branch
+        );
+        return;
+      }
+      const jump = findJump(branch, false);
+      if (jump) {
+        const keyword = jump.type.replace('_statement', '');
+        this.report(
+          'parallel-branch',
+          `'${keyword}' cannot leave a parallel branch`,
+          jump
+        );
+      }
+      for (const name of variables[index].assigned) {
+        const conflict =
+          isShared(name) &&
+          variables.some(
+            (other, otherIndex) =>
+              otherIndex !== index &&
+              (other.used.has(name) ||
+                (otherIndex < index && other.assigned.has(name)))
+          );
+        if (conflict) {
+          this.report(
+            'parallel-conflict',
+            `'${name}' is assigned in one parallel branch and used in another`,
+            branch
+          );
+        }
+      }
+    });
+
+    for (const branch of stmt.branches) {
+      this.checkStatement(branch);
+    }
+  }
+
+  /**
+   * Check a parallel map statement: its body runs for several items at
+   * once, so it may not declare anything, break out of it, or assign
+   * variables of the enclosing scope. A `return` gives the item's result
+   */
+  private checkParallelMap(stmt: ParallelMapStatement): void {
+    const itemsType = this.inferExpression(stmt.items);
+    if (stmt.limit) {
+      const limitType = this.inferExpression(stmt.limit);
+      if (!isAssignable(limitType, NUMBER)) {
+        this.report(
+          'parallel-limit',
+          `parallelMap limit must be a number, got '${typeToString(limitType)}'`,
+          stmt.limit
+        );
+      }
+    }
+
+    const assigned = new Set<string>();
+    stmt.body.forEach(s => collectVariables(s, assigned, new Set()));
+    for (const name of assigned) {
+      if (name !== stmt.item && this.scope.lookup(name) !== undefined) {
+        this.report(
+          'parallel-conflict',
+          `'${name}' is assigned in a parallelMap body, which runs for several items at once`,
+          stmt
+        );
+      }
+    }
+
+    const outerTool = this.currentTool;
+    this.currentTool = null;
+    this.scope.pushScope();
+    try {
+      this.scope.declare(
+        stmt.item,
+        itemsType.type === 'array_type' ? itemsType.elementType : ANY
+      );
+      for (const s of stmt.body) {
+        if (DECLARATIONS.has(s.type)) {
+          this.report(
+            'parallel-branch',
+            'Declarations are not allowed in a parallelMap body',
+            s
+          );
+          continue;
+        }
+        const jump = findJump(s, false);
+        if (jump && jump.type !== 'return_statement') {
+          const keyword = jump.type.replace('_statement', '');
+          this.report(
+            'parallel-branch',
+            `'${keyword}' cannot leave a parallelMap body`,
+            jump
+          );
+        }
+        this.checkStatement(s);
+      }
+    } finally {
+      this.scope.popScope();
+      this.currentTool = outerTool;
+    }
+
+    if (stmt.target?.type === 'identifier') {
+      this.scope.assign(stmt.target.name, {
+        type: 'array_type',
+        elementType: ANY,
+      });
+    } else if (stmt.target) {
+      this.inferExpression(stmt.target);
+    }
+  }
+
+  /**
+   * Check an annotated statement: @idempotent takes a string or number key
+   * and only applies to statements making a call; @trace takes a string
+   * label and a level of "info" or "debug"
+   */
+  private checkAnnotated(stmt: AnnotatedStatement): void {
+    const { annotation, statement } = stmt;
+    const argumentTypes = annotation.arguments.map(arg =>
+      this.inferExpression(arg.value)
+    );
+    if (annotation.name === 'idempotent') {
+      annotation.arguments.forEach((arg, i) => {
+        const type = argumentTypes[i];
+        if (arg.key !== 'key') {
+          this.report(
+            'annotation',
+            `Unknown argument '${arg.key}' of @idempotent; expected key`,
+            arg
+          );
+        } else if (!isAssignable(type, IDEMPOTENCY_KEY)) {
+          this.report(
+            'annotation',
+            `@idempotent key must be a string or a number, got '${typeToString(type)}'`,
+            arg.value
+          );
+        }
+      });
+      if (!annotation.arguments.some(arg => arg.key === 'key')) {
+        this.report('annotation', '@idempotent needs a key', annotation);
+      }
+      const value =
+        statement.type === 'assignment'
+          ? statement.value
+          : statement.expression;
+      if (value.type !== 'call') {
+        this.report(
+          'annotation',
+          '@idempotent only applies to a statement making a call',
+          statement
+        );
+      }
+    } else if (annotation.name === 'trace') {
+      annotation.arguments.forEach((arg, i) => {
+        const type = argumentTypes[i];
+        if (arg.key === 'label') {
+          if (!isAssignable(type, STRING)) {
+            this.report(
+              'annotation',
+              `@trace label must be a string, got '${typeToString(type)}'`,
+              arg.value
+            );
+          }
+        } else if (arg.key === 'level') {
+          if (
+            arg.value.type !== 'string' ||
+            !TRACE_POINT_LEVELS.includes(arg.value.value)
+          ) {
+            this.report(
+              'annotation',
+              '@trace level must be "info" or "debug"',
+              arg.value
+            );
+          }
+        } else {
+          this.report(
+            'annotation',
+            `Unknown argument '${arg.key}' of @trace; expected label or level`,
+            arg
+          );
+        }
+      });
+    } else {
+      this.report(
+        'annotation',
+        `Unknown annotation '@${annotation.name}'; expected @idempotent or @trace`,
+        annotation
+      );
+    }
+    this.checkStatement(statement);
+  }
+
+  /**
+   * Check a compensated statement: its block runs on its own when a later
+   * step fails, so it may not declare anything or leave the block
+   */
+  private checkCompensated(stmt: CompensatedStatement): void {
+    this.checkStatement(stmt.statement);
+    for (const s of stmt.compensation.statements) {
+      if (DECLARATIONS.has(s.type)) {
+        this.report(
+          'compensation',
+          'Declarations are not allowed in a compensate block',
+          s
+        );
+        continue;
+      }
+      const jump = findJump(s, false);
+      if (jump) {
+        const keyword = jump.type.replace('_statement', '');
+        this.report(
+          'compensation',
+          `'${keyword}' cannot leave a compensate block`,
+          jump
+        );
+      }
+    }
+    const outerTool = this.currentTool;
+    this.currentTool = null;
+    try {
+      this.checkStatement(stmt.compensation);
+    } finally {
+      this.currentTool = outerTool;
+    }
+  }
+
+  private checkToolDeclaration(tool: ToolDeclaration): void {
+    for (const param of tool.parameters) {
+      this.checkTypeExpression(param.typeAnnotation);
+    }
+    this.checkTypeExpression(tool.returnType);
+
+    const outerTool = this.currentTool;
+    this.currentTool = tool;
+    this.scope.pushScope();
+    try {
+      for (const param of tool.parameters) {
+        if (param.defaultValue) {
+          this.checkDefaultValue(param, param.defaultValue);
+        }
+        this.scope.declare(param.name, parameterType(param));
+      }
+      for (const s of tool.body.statements) {
+        this.checkStatement(s);
+      }
+    } finally {
+      this.scope.popScope();
+      this.currentTool = outerTool;
+    }
+
+    if (
+      tool.returnType &&
+      !isAssignable(NULL, tool.returnType) &&
+      !containsReturn(tool.body.statements)
+    ) {
+      this.report(
+        'missing-return',
+        `Tool '${tool.name}' declares return type ` +
+          `'${typeToString(tool.returnType)}' but never returns a value`,
+        tool
+      );
+    }
+  }
+
+  /**
+   * Check a pipeline: each step may only use the parameters and earlier
+   * steps, and the types of the results flow from step to step into the
+   * pipeline's result
+   */
+  private checkPipeline(pipeline: PipelineDeclaration): void {
+    for (const param of pipeline.parameters) {
+      this.checkTypeExpression(param.typeAnnotation);
+    }
+    this.checkTypeExpression(pipeline.returnType);
+
+    const steps = pipeline.steps.map(step => step.name);
+    const outerTool = this.currentTool;
+    this.currentTool = null;
+    this.scope.pushScope();
+    try {
+      for (const param of pipeline.parameters) {
+        if (param.defaultValue) {
+          this.checkDefaultValue(param, param.defaultValue);
+        }
+        this.scope.declare(param.name, parameterType(param));
+      }
+      pipeline.steps.forEach((step, index) => {
+        if (pipeline.parameters.some(param => param.name === step.name)) {
+          this.report(
+            'pipeline',
+            `Step '${step.name}' has the name of a parameter of pipeline '${pipeline.name}'`,
+            step
+          );
+        } else if (steps.indexOf(step.name) < index) {
+          this.report(
+            'pipeline',
+            `Pipeline '${pipeline.name}' has more than one step '${step.name}'`,
+            step
+          );
+        }
+        const used = new Set<string>();
+        collectIdentifiers(step.stage, used);
+        for (const name of used) {
+          if (steps.indexOf(name, index) >= 0 && !this.scope.lookup(name)) {
+            this.report(
+              'pipeline',
+              `Step '${step.name}' uses '${name}', which is not an earlier step`,
+              step
+            );
+          }
+        }
+        this.scope.declare(step.name, this.inferStage(step.stage));
+      });
+
+      const outputType = pipeline.output
+        ? this.inferExpression(pipeline.output)
+        : NULL;
+      if (
+        pipeline.output &&
+        pipeline.returnType &&
+        !isAssignable(outputType, pipeline.returnType)
+      ) {
+        this.report(
+          'return-type',
+          `Type '${typeToString(outputType)}' is not assignable to return ` +
+            `type '${typeToString(pipeline.returnType)}' of pipeline '${pipeline.name}'`,
+          pipeline.output
+        );
+      }
+    } finally {
+      this.scope.popScope();
+      this.currentTool = outerTool;
+    }
+
+    if (
+      !pipeline.output &&
+      pipeline.returnType &&
+      !isAssignable(NULL, pipeline.returnType)
+    ) {
+      this.report(
+        'missing-return',
+        `Pipeline '${pipeline.name}' declares return type ` +
+          `'${typeToString(pipeline.returnType)}' but never returns a value`,
+        pipeline
+      );
+    }
+  }
+
+  /**
+   * Infer the type of what a pipeline stage evaluates to
+   */
+  private inferStage(stage: PipelineStage): TypeExpression {
+    switch (stage.type) {
+      case 'call': {
+        const { callee } = stage;
+        if (
+          callee.type === 'identifier' &&
+          !this.tools.has(callee.name) &&
+          !this.scope.lookup(callee.name)
+        ) {
+          this.report(
+            'pipeline',
+            `'${callee.name}' is not a tool or pipeline`,
+            callee
+          );
+        }
+        return this.inferExpression(stage);
+      }
+      case 'conditional_stage': {
+        this.inferExpression(stage.condition);
+        const then = this.inferStage(stage.then);
+        // Without an else stage the result is null when the condition is
+        // false
+        return unionOf(then, stage.else ? this.inferStage(stage.else) : NULL);
+      }
+      case 'fan_out_stage': {
+        const properties: ObjectType['properties'] = [];
+        for (const branch of stage.branches) {
+          if (properties.some(prop => prop.name === branch.name)) {
+            this.report(
+              'pipeline',
+              `Fan-out has more than one branch '${branch.name}'`,
+              branch
+            );
+          }
+          properties.push({
+            name: branch.name,
+            optional: false,
+            typeAnnotation: this.inferStage(branch.stage),
+          });
+        }
+        return { type: 'object_type', properties };
+      }
+      case 'each_stage': {
+        const itemsType = this.inferExpression(stage.items);
+        if (
+          !isAssignable(itemsType, { type: 'array_type', elementType: ANY })
+        ) {
+          this.report(
+            'pipeline',
+            `each needs a list of items, got '${typeToString(itemsType)}'`,
+            stage.items
+          );
+        }
+        if (stage.limit) {
+          const limitType = this.inferExpression(stage.limit);
+          if (!isAssignable(limitType, NUMBER)) {
+            this.report(
+              'pipeline',
+              `each limit must be a number, got '${typeToString(limitType)}'`,
+              stage.limit
+            );
+          }
+        }
+        this.scope.pushScope();
+        try {
+          this.scope.declare(
+            stage.item,
+            itemsType.type === 'array_type' ? itemsType.elementType : ANY
+          );
+          return {
+            type: 'array_type',
+            elementType: this.inferStage(stage.stage),
+          };
+        } finally {
+          this.scope.popScope();
+        }
+      }
+    }
+  }
+
+  /**
+   * Check that a parameter's default value has the parameter's type
+   */
+  private checkDefaultValue(param: ToolParameter, value: Expression): void {
+    const valueType = this.inferExpression(value);
+    const expected = parameterType(param);
+    if (!isAssignable(valueType, expected)) {
+      this.report(
+        'default-value',
+        `Default value of type '${typeToString(valueType)}' is not ` +
+          `assignable to parameter '${param.name}' of type ` +
+          `'${typeToString(expected)}'`,
+        value
+      );
+    }
+  }
+
+  private checkReturn(value: Expression | undefined, stmt: object): void {
+    const tool = this.currentTool;
+    const valueType = value ? this.inferExpression(value) : NULL;
+    if (!tool || !tool.returnType) {
+      return;
+    }
+
+    if (!value) {
+      if (!isAssignable(NULL, tool.returnType)) {
+        this.report(
+          'missing-return-value',
+          `Tool '${tool.name}' must return a value of type ` +
+            `'${typeToString(tool.returnType)}'`,
+          stmt
+        );
+      }
+      return;
+    }
+
+    if (!isAssignable(valueType, tool.returnType)) {
+      this.report(
+        'return-type',
+        `Type '${typeToString(valueType)}' is not assignable to return type ` +
+          `'${typeToString(tool.returnType)}' of tool '${tool.name}'`,
+        value
+      );
+    }
+  }
+
+  private checkAssignment(stmt: Assignment): void {
+    const valueType = this.inferExpression(stmt.value);
+    if (stmt.target.type === 'identifier') {
+      this.scope.assign(stmt.target.name, valueType);
+    } else {
+      this.inferExpression(stmt.target);
+    }
+  }
+
+  /**
+   * Report duplicate property names in declared object types
+   */
+  private checkTypeExpression(type: TypeExpression | undefined): void {
+    if (!type) {
+      return;
+    }
+    switch (type.type) {
+      case 'array_type':
+        this.checkTypeExpression(type.elementType);
+        break;
+      case 'union_type':
+        type.types.forEach(t => this.checkTypeExpression(t));
+        break;
+      case 'object_type': {
+        const seen = new Set<string>();
+        for (const prop of type.properties) {
+          if (seen.has(prop.name)) {
+            this.report(
+              'duplicate-property',
+              `Duplicate property '${prop.name}' in object type`,
+              type
+            );
+          }
+          seen.add(prop.name);
+          this.checkTypeExpression(prop.typeAnnotation);
+        }
+        break;
+      }
+      default:
+        break;
+    }
+  }
+
+  /**
+   * Infer the type of an expression, checking any calls inside it
+   */
+  private inferExpression(expr: Expression): TypeExpression {
+    switch (expr.type) {
+      case 'string':
+        return STRING;
+      case 'number':
+        return NUMBER;
+      case 'boolean':
+        return BOOLEAN;
+      case 'identifier':
+        if (expr.name === 'null' || expr.name === 'undefined') {
+          return NULL;
+        }
+        return this.scope.lookup(expr.name) ?? ANY;
+      case 'array': {
+        const elementTypes = expr.elements.map(e => this.inferExpression(e));
+        return {
+          type: 'array_type',
+          elementType:
+            elementTypes.length > 0 ? elementTypes.reduce(unionOf) : ANY,
+        };
+      }
+      case 'object':
+        return this.inferObject(expr);
+      case 'call':
+        return this.inferCall(expr);
+      case 'member': {
+        const objectType = this.inferExpression(expr.object);
+        if (objectType.type === 'object_type') {
+          const prop = objectType.properties.find(
+            p => p.name === expr.property
+          );
+          return prop ? prop.typeAnnotation : ANY;
+        }
+        if (
+          expr.property === 'length' &&
+          (objectType.type === 'array_type' ||
+            isPrimitive(objectType, 'string') ||
+            isPrimitive(objectType, 'bytes'))
+        ) {
+          return NUMBER;
+        }
+        return ANY;
+      }
+      case 'bracket': {
+        const objectType = this.inferExpression(expr.object);
+        this.inferExpression(expr.index);
+        if (isPrimitive(objectType, 'bytes')) {
+          return NUMBER;
+        }
+        return objectType.type === 'array_type' ? objectType.elementType : ANY;
+      }
+      case 'binary':
+        return this.inferBinary(expr);
+      case 'unary':
+        this.inferExpression(expr.operand);
+        return expr.operator === '!' ? BOOLEAN : NUMBER;
+      default:
+        return ANY;
+    }
+  }
+
+  private inferObject(expr: ObjectLiteral): ObjectType {
+    return {
+      type: 'object_type',
+      properties: expr.properties.map(p => ({
+        name: p.key,
+        optional: false,
+        typeAnnotation: this.inferExpression(p.value),
+      })),
+    };
+  }
+
+  private inferBinary(expr: BinaryExpression): TypeExpression {
+    const left = this.inferExpression(expr.left);
+    const right = this.inferExpression(expr.right);
+
+    switch (expr.operator) {
+      case '+':
+        if (isPrimitive(left, 'string') || isPrimitive(right, 'string')) {
+          return STRING;
+        }
+        if (isPrimitive(left, 'number') && isPrimitive(right, 'number')) {
+          return NUMBER;
+        }
+        return ANY;
+      case '-':
+      case '*':
+      case '/':
+      case '%':
+        return NUMBER;
+      case '==':
+      case '!=':
+      case '<':
+      case '>':
+      case '<=':
+      case '>=':
+        return BOOLEAN;
+      default:
+        return ANY;
+    }
+  }
+
+  /**
+   * Check a call against the signature of a declared tool
+   */
+  private inferCall(expr: CallExpression): TypeExpression {
+    const argTypes = expr.arguments.map(a => this.inferExpression(a));
+    if (expr.options) {
+      this.checkCallOptions(expr, expr.options);
+    }
+    if (expr.callee.type !== 'identifier') {
+      this.inferExpression(expr.callee);
+      return this.isBytesConstructor(expr.callee) ? BYTES : ANY;
+    }
+
+    // Local variables shadow tool names
+    const tool = this.scope.lookup(expr.callee.name)
+      ? undefined
+      : this.tools.get(expr.callee.name);
+    if (!tool) {
+      return ANY;
+    }
+
+    const params = tool.parameters;
+    if (expr.arguments.length > params.length) {
+      this.report(
+        'too-many-arguments',
+        `Tool '${tool.name}' expects at most ${params.length} ` +
+          `argument(s), but got ${expr.arguments.length}`,
+        expr.arguments[params.length]
+      );
+    }
+
+    params.forEach((param, i) => {
+      if (i >= expr.arguments.length) {
+        if (!param.optional && !param.defaultValue) {
+          this.report(
+            'missing-argument',
+            `Missing argument '${param.name}' in call to tool '${tool.name}'`,
+            expr
+          );
+        }
+        return;
+      }
+      const expected = parameterType(param);
+      if (!isAssignable(argTypes[i], expected)) {
+        this.report(
+          'argument-type',
+          `Argument of type '${typeToString(argTypes[i])}' is not ` +
+            `assignable to parameter '${param.name}' of type ` +
+            `'${typeToString(expected)}'`,
+          expr.arguments[i]
+        );
+      }
+    });
+
+    return tool.returnType ?? ANY;
+  }
+
+  /**
+   * Whether a callee is one of the bytes builtins that make a bytes value
+   */
+  private isBytesConstructor(callee: Expression): boolean {
+    return (
+      callee.type === 'member' &&
+      callee.object.type === 'identifier' &&
+      callee.object.name === 'bytes' &&
+      !this.scope.lookup('bytes') &&
+      BYTES_CONSTRUCTORS.includes(callee.property)
+    );
+  }
+
+  /**
+   * Check the options of a call, which only MCP tool calls take
+   */
+  private checkCallOptions(expr: CallExpression, options: ObjectLiteral): void {
+    const type = this.inferObject(options);
+    const { callee } = expr;
+    const onServer =
+      callee.type === 'member' &&
+      callee.object.type === 'identifier' &&
+      this.servers.has(callee.object.name) &&
+      !this.scope.lookup(callee.object.name);
+    if (!onServer) {
+      this.report(
+        'call-options',
+        'Call options are only supported on MCP tool calls',
+        options
+      );
+    }
+    this.checkCallPolicy(options, type, true);
+  }
+
+  /**
+   * Check the timeout, retries and backoff of a call's options or of a
+   * server's defaults, with its circuit breaker; `strict` reports any other
+   * property
+   */
+  private checkCallPolicy(
+    config: ObjectLiteral,
+    type: ObjectType,
+    strict: boolean
+  ): void {
+    config.properties.forEach((prop, i) => {
+      const valueType = type.properties[i].typeAnnotation;
+      const { value } = prop;
+      switch (prop.key) {
+        case 'timeout':
+        case 'retries': {
+          if (!isAssignable(valueType, NUMBER)) {
+            this.report(
+              'call-options',
+              `Call option '${prop.key}' must be a number, ` +
+                `got '${typeToString(valueType)}'`,
+              value
+            );
+          } else if (
+            value.type === 'number' &&
+            (prop.key === 'timeout'
+              ? value.value <= 0
+              : !Number.isInteger(value.value))
+          ) {
+            this.report(
+              'call-options',
+              prop.key === 'timeout'
+                ? 'Call option \'timeout\' must be a positive duration'
+                : 'Call option \'retries\' must be a whole number',
+              value
+            );
+          }
+          break;
+        }
+        case 'backoff':
+          if (!isAssignable(valueType, STRING)) {
+            this.report(
+              'call-options',
+              `Call option 'backoff' must be a string, ` +
+                `got '${typeToString(valueType)}'`,
+              value
+            );
+          } else if (
+            value.type === 'string' &&
+            !BACKOFF_STRATEGIES.includes(value.value)
+          ) {
+            this.report(
+              'call-options',
+              `Unknown backoff '${value.value}'; expected ` +
+                `${BACKOFF_STRATEGIES.join(', ')}`,
+              value
+            );
+          }
+          break;
+        default:
+          if (!strict && prop.key === 'circuitBreaker') {
+            this.checkCircuitBreaker(value, valueType);
+          } else if (strict) {
+            this.report(
+              'call-options',
+              `Unknown call option '${prop.key}'; expected timeout, ` +
+                'retries or backoff',
+              prop
+            );
+          }
+      }
+    });
+  }
+
+  /**
+   * Check the ${NAME} and ${secret:NAME} references of a server's options
+   */
+  private checkInterpolations(config: ObjectLiteral): void {
+    for (const literal of interpolatedStrings(config)) {
+      try {
+        parseInterpolation(literal.value);
+      } catch (error) {
+        if (!(error instanceof InterpolationError)) {
+          throw error;
+        }
+        this.report('interpolation', error.message, literal);
+      }
+    }
+  }
+
+  /**
+   * Check the references of a prompt's text, or of its file if it was read;
+   * prompts are sent to the model, so they may not reference secrets
+   */
+  private checkPrompt(stmt: PromptDeclaration): void {
+    this.inferExpression(stmt.config);
+    const property = (key: string) =>
+      stmt.config.properties.find(prop => prop.key === key);
+    const text = property('text');
+    const file = property('file');
+    const source =
+      text?.value.type === 'string'
+        ? { value: text.value.value, node: text.value }
+        : file && stmt.fileText !== undefined
+          ? { value: stmt.fileText, node: file.value }
+          : undefined;
+    if (!source) {
+      return;
+    }
+    try {
+      const parts = parseInterpolation(source.value, { project: true });
+      const secret = parts.find(
+        (part): part is { secret: string } =>
+          typeof part !== 'string' && 'secret' in part
+      );
+      if (secret) {
+        this.report(
+          'interpolation',
+          `Prompt ${stmt.name} cannot reference \${secret:${secret.secret}}, ` +
+            'since prompts are sent to the model',
+          source.node
+        );
+      }
+    } catch (error) {
+      if (!(error instanceof InterpolationError)) {
+        throw error;
+      }
+      this.report('interpolation', error.message, source.node);
+    }
+  }
+
+  /**
+   * Check that a trigger fires on a valid schedule or a webhook
+   */
+  private checkTrigger(stmt: TriggerDeclaration): void {
+    try {
+      triggerSchedule(stmt.source);
+    } catch (error) {
+      if (!(error instanceof ScheduleError)) {
+        throw error;
+      }
+      this.report(
+        'trigger',
+        `Trigger ${stmt.name}: ${error.message}`,
+        stmt.source
+      );
+    }
+    this.inferExpression(stmt.action);
+  }
+
+  /**
+   * Check an event handler: its body runs on its own, like a tool's, with
+   * the payload of each event as its parameter
+   */
+  private checkEventHandler(handler: EventHandler | NotificationHandler): void {
+    if (handler.type === 'event_handler' && handler.event === '') {
+      this.report('event', 'Event handlers need an event name', handler);
+    }
+    if (handler.parameter?.defaultValue) {
+      this.report(
+        handler.type === 'event_handler' ? 'event' : 'notification',
+        'Handler parameters cannot have default values',
+        handler.parameter.defaultValue
+      );
+    }
+    this.checkTypeExpression(handler.parameter?.typeAnnotation);
+
+    const outerTool = this.currentTool;
+    this.currentTool = null;
+    this.scope.pushScope();
+    try {
+      if (handler.parameter) {
+        this.scope.declare(
+          handler.parameter.name,
+          parameterType(handler.parameter)
+        );
+      }
+      for (const s of handler.body.statements) {
+        this.checkStatement(s);
+      }
+    } finally {
+      this.scope.popScope();
+      this.currentTool = outerTool;
+    }
+  }
+
+  /**
+   * Check a notification handler: it handles a kind of notification a
+   * declared server sends, and its body runs on its own, like an event
+   * handler's
+   */
+  private checkNotificationHandler(handler: NotificationHandler): void {
+    if (!this.servers.has(handler.server)) {
+      this.report(
+        'notification',
+        `${handler.server} is not an MCP server`,
+        handler
+      );
+    }
+    if (!NOTIFICATIONS.includes(handler.notification)) {
+      this.report(
+        'notification',
+        `Unknown notification '${handler.notification}'; expected one of ${NOTIFICATIONS.join(', ')}`,
+        handler
+      );
+    }
+    if (handler.resource) {
+      const resourceType = this.inferExpression(handler.resource);
+      if (handler.notification !== 'resources/updated') {
+        this.report(
+          'notification',
+          'Only resources/updated notifications take a resource URI',
+          handler.resource
+        );
+      } else if (!isAssignable(resourceType, STRING)) {
+        this.report(
+          'notification',
+          `Resource URI must be a string, got '${typeToString(resourceType)}'`,
+          handler.resource
+        );
+      }
+    }
+
+    const outer = this.inNotificationHandler;
+    this.inNotificationHandler = true;
+    try {
+      this.checkEventHandler(handler);
+    } finally {
+      this.inNotificationHandler = outer;
+    }
+  }
+
+  /**
+   * Check a listen statement: its timeout is a number, and it does not
+   * wait in a notification handler, which would hold up the notifications
+   * it waits for
+   */
+  private checkListen(stmt: ListenStatement): void {
+    if (this.inNotificationHandler) {
+      this.report('listen', 'Cannot listen in a notification handler', stmt);
+    }
+    if (stmt.until) {
+      this.inferExpression(stmt.until);
+    }
+    if (stmt.timeout) {
+      const timeoutType = this.inferExpression(stmt.timeout);
+      if (!isAssignable(timeoutType, NUMBER)) {
+        this.report(
+          'listen',
+          `Listen timeout must be a number, got '${typeToString(timeoutType)}'`,
+          stmt.timeout
+        );
+      }
+    }
+  }
+
+  /**
+   * Check the guardrails of an agent: what each one checks, its action
+   * and, where they are written out, its patterns and retries
+   */
+  private checkGuardrails(config: ObjectLiteral): void {
+    const guardrails = config.properties.find(
+      p => p.key === 'guardrails'
+    )?.value;
+    if (!guardrails) {
+      return;
+    }
+    if (guardrails.type !== 'array') {
+      this.report(
+        'guardrail',
+        "Agent option 'guardrails' must be a list of guardrails",
+        guardrails
+      );
+      return;
+    }
+    for (const guardrail of guardrails.elements) {
+      if (guardrail.type !== 'object') {
+        this.report(
+          'guardrail',
+          'A guardrail must be an object with deny, schema or check',
+          guardrail
+        );
+        continue;
+      }
+      if (!guardrail.properties.some(p => GUARDRAIL_CHECKS.includes(p.key))) {
+        this.report(
+          'guardrail',
+          'A guardrail needs deny, schema or check',
+          guardrail
+        );
+      }
+      for (const { key, value } of guardrail.properties) {
+        this.checkGuardrailOption(key, value);
+      }
+    }
+  }
+
+  private checkGuardrailOption(key: string, value: Expression): void {
+    switch (key) {
+      case 'name':
+      case 'schema':
+        break;
+      case 'deny':
+        for (const pattern of value.type === 'array' ? value.elements : []) {
+          if (pattern.type !== 'string') {
+            continue;
+          }
+          try {
+            new RegExp(pattern.value);
+          } catch {
+            this.report(
+              'guardrail',
+              `Invalid deny pattern ${JSON.stringify(pattern.value)}`,
+              pattern
+            );
+          }
+        }
+        break;
+      case 'check':
+        if (value.type === 'identifier' && !this.tools.has(value.name)) {
+          this.report(
+            'guardrail',
+            `Guardrail check '${value.name}' must be a tool, or the name of a validator as a string`,
+            value
+          );
+        }
+        break;
+      case 'action':
+        if (
+          value.type === 'string' &&
+          !GUARDRAIL_ACTIONS.includes(value.value)
+        ) {
+          this.report(
+            'guardrail',
+            `Unknown guardrail action '${value.value}'; expected ` +
+              GUARDRAIL_ACTIONS.join(', '),
+            value
+          );
+        }
+        break;
+      case 'retries':
+        if (
+          value.type === 'number' &&
+          !(Number.isInteger(value.value) && value.value >= 0)
+        ) {
+          this.report(
+            'guardrail',
+            "Guardrail option 'retries' must be a non-negative whole number",
+            value
+          );
+        }
+        break;
+      default:
+        this.report(
+          'guardrail',
+          `Unknown guardrail option '${key}'; expected name, deny, schema, ` +
+            'check, action or retries',
+          value
+        );
+    }
+  }
+
+  /**
+   * Check the failures and cooldown of a server's circuit breaker
+   */
+  private checkCircuitBreaker(value: Expression, type: TypeExpression): void {
+    if (value.type !== 'object') {
+      this.report(
+        'call-options',
+        `Server option 'circuitBreaker' must be an object, ` +
+          `got '${typeToString(type)}'`,
+        value
+      );
+      return;
+    }
+    value.properties.forEach((prop, i) => {
+      const valueType = (type as ObjectType).properties[i].typeAnnotation;
+      if (prop.key !== 'failures' && prop.key !== 'cooldown') {
+        this.report(
+          'call-options',
+          `Unknown circuitBreaker option '${prop.key}'; expected failures ` +
+            'or cooldown',
+          prop
+        );
+      } else if (!isAssignable(valueType, NUMBER)) {
+        this.report(
+          'call-options',
+          `circuitBreaker option '${prop.key}' must be a number, ` +
+            `got '${typeToString(valueType)}'`,
+          prop.value
+        );
+      } else if (
+        prop.value.type === 'number' &&
+        (prop.key === 'cooldown'
+          ? prop.value.value <= 0
+          : !(Number.isInteger(prop.value.value) && prop.value.value > 0))
+      ) {
+        this.report(
+          'call-options',
+          prop.key === 'cooldown'
+            ? "circuitBreaker option 'cooldown' must be a positive duration"
+            : "circuitBreaker option 'failures' must be a positive whole number",
+          prop.value
+        );
+      }
+    });
+  }
+}
+
+/** Functions of the bytes builtin that return bytes */
+const BYTES_CONSTRUCTORS = ['from', 'concat', 'alloc'];
+
+/** What a guardrail can check, and what it can do with rejected output */
+const GUARDRAIL_CHECKS = ['deny', 'schema', 'check'];
+const GUARDRAIL_ACTIONS = ['block', 'retry', 'escalate'];
+
+/** Ways the wait between retries of a tool call can grow */
+const BACKOFF_STRATEGIES = ['exponential', 'linear', 'constant'];
+
+function isPrimitive(type: TypeExpression, value: PrimitiveType['value']) {
+  return type.type === 'primitive_type' && type.value === value;
+}
+
+/**
+ * The type of a parameter inside the tool body
+ * (optional parameters may be null)
+ */
+function parameterType(param: ToolParameter): TypeExpression {
+  const declared = param.typeAnnotation ?? ANY;
+  return param.optional ? unionOf(declared, NULL) : declared;
+}
+
+/**
+ * Notifications scripts can handle: the methods of server notifications,
+ * without their notifications/ prefix
+ */
+const NOTIFICATIONS = [
+  'resources/updated',
+  'resources/list_changed',
+  'tools/list_changed',
+  'prompts/list_changed',
+  'message',
+];
+
+const DECLARATIONS = new Set([
+  'import_statement',
+  'mcp_declaration',
+  'model_declaration',
+  'agent_declaration',
+  'prompt_declaration',
+  'trigger_declaration',
+  'event_handler',
+  'notification_handler',
+  'tool_declaration',
+  'pipeline_declaration',
+  'test_declaration',
+  'test_hook',
+  'fixture_declaration',
+]);
+
+/**
+ * Names of the variables a node refers to
+ */
+function collectIdentifiers(node: unknown, names: Set<string>): void {
+  if (typeof node !== 'object' || node === null) {
+    return;
+  }
+  const { type, name } = node as { type?: unknown; name?: unknown };
+  if (type === 'identifier' && typeof name === 'string') {
+    names.add(name);
+  }
+  Object.values(node).forEach(child => collectIdentifiers(child, names));
+}
+
+/**
+ * The first return, or break or continue outside a loop, in a statement
+ */
+function findJump(stmt: Statement, inLoop: boolean): Statement | undefined {
+  switch (stmt.type) {
+    case 'return_statement':
+      return stmt;
+    case 'break_statement':
+    case 'continue_statement':
+      return inLoop ? undefined : stmt;
+    case 'block_statement':
+      return stmt.statements
+        .map(s => findJump(s, inLoop))
+        .find(jump => jump !== undefined);
+    case 'if_statement':
+      return (
+        findJump(stmt.then, inLoop) ??
+        (stmt.else ? findJump(stmt.else, inLoop) : undefined)
+      );
+    case 'while_statement':
+    case 'for_statement':
+      return findJump(stmt.body, true);
+    default:
+      // Nested parallel blocks check their own branches
+      return undefined;
+  }
+}
+
+/**
+ * Collect the variables a statement assigns and the ones it reads
+ */
+function collectVariables(
+  node: Statement | Expression,
+  assigned: Set<string>,
+  used: Set<string>
+): void {
+  const visit = (child: Statement | Expression) =>
+    collectVariables(child, assigned, used);
+  switch (node.type) {
+    case 'identifier':
+      used.add(node.name);
+      break;
+    case 'assignment':
+      if (node.target.type === 'identifier') {
+        assigned.add(node.target.name);
+      } else {
+        visit(node.target);
+      }
+      visit(node.value);
+      break;
+    case 'expression_statement':
+      visit(node.expression);
+      break;
+    case 'block_statement':
+      node.statements.forEach(visit);
+      break;
+    case 'if_statement':
+      visit(node.condition);
+      visit(node.then);
+      if (node.else) {
+        visit(node.else);
+      }
+      break;
+    case 'while_statement':
+      visit(node.condition);
+      visit(node.body);
+      break;
+    case 'for_statement':
+      [node.init, node.condition, node.update, node.body].forEach(
+        child => child && visit(child)
+      );
+      break;
+    case 'parallel_statement':
+      if (node.limit) {
+        visit(node.limit);
+      }
+      node.branches.forEach(visit);
+      break;
+    case 'annotated_statement':
+      node.annotation.arguments.forEach(arg => visit(arg.value));
+      visit(node.statement);
+      break;
+    case 'compensated_statement':
+      visit(node.statement);
+      visit(node.compensation);
+      break;
+    case 'parallel_map_statement':
+      if (node.target?.type === 'identifier') {
+        assigned.add(node.target.name);
+      } else if (node.target) {
+        visit(node.target);
+      }
+      [node.items, node.limit, ...node.body].forEach(
+        child => child && visit(child)
+      );
+      break;
+    case 'listen_statement':
+      [node.until, node.timeout].forEach(child => child && visit(child));
+      break;
+    case 'return_statement':
+      if (node.value) {
+        visit(node.value);
+      }
+      break;
+    case 'call':
+      visit(node.callee);
+      node.arguments.forEach(visit);
+      if (node.options) {
+        visit(node.options);
+      }
+      break;
+    case 'member':
+      visit(node.object);
+      break;
+    case 'bracket':
+      visit(node.object);
+      visit(node.index);
+      break;
+    case 'binary':
+      visit(node.left);
+      visit(node.right);
+      break;
+    case 'unary':
+      visit(node.operand);
+      break;
+    case 'array':
+      node.elements.forEach(visit);
+      break;
+    case 'object':
+      node.properties.forEach(property => visit(property.value));
+      break;
+    default:
+      break;
+  }
+}
+
+/**
+ * Whether any return statement with a value appears in a statement list
+ */
+function containsReturn(statements: Statement[]): boolean {
+  return statements.some(stmt => {
+    switch (stmt.type) {
+      case 'return_statement':
+        return stmt.value !== undefined;
+      case 'block_statement':
+        return containsReturn(stmt.statements);
+      case 'if_statement':
+        return containsReturn(
+          stmt.else ? [stmt.then, stmt.else] : [stmt.then]
+        );
+      case 'while_statement':
+      case 'for_statement':
+        return containsReturn([stmt.body]);
+      default:
+        return false;
+    }
+  });
+}
+
+/**
+ * Type check a program and return all diagnostics
+ */
+export function typecheck(statements: Statement[]): TypeDiagnostic[] {
+  const checker = new TypeChecker();
+  checker.check(statements);
+  return checker.diagnostics;
+}
+
+/**
+ * Type check a program
+ * Throws TypeCheckError if any errors are found
+ */
+export function checkTypes(statements: Statement[]): void {
+  const errors = typecheck(statements).filter(d => d.severity === 'error');
+  if (errors.length > 0) {
+    throw new TypeCheckError(errors);
+  }
+}
@@ -16,18 +16,33 @@ import {
   IfStatement,
   WhileStatement,
   ForStatement,
+  ParallelStatement,
+  ParallelMapStatement,
+  AnnotatedStatement,
+  CompensatedStatement,
+  ListenStatement,
   ReturnStatement,
+  AssignmentTarget,
   MCPDeclaration,
   ModelDeclaration,
   AgentDeclaration,
+  PromptDeclaration,
+  TriggerDeclaration,
+  EventHandler,
+  NotificationHandler,
   ToolDeclaration,
+  PipelineDeclaration,
+  PipelineStage,
+  TestDeclaration,
+  TestHook,
+  FixtureDeclaration,
 } from './ast.js';
 
 /**
  * List of allowed global variables in MCP Script
  * These are provided by the runtime and don't need to be declared
  */
-const ALLOWED_GLOBALS = new Set([
+export const ALLOWED_GLOBALS = new Set([
   // Logging
   'log',
   'print',
@@ -35,8 +50,37 @@ const ALLOWED_GLOBALS = new Set([
   // Environment
   'env',
 
+  // Caller a served run works for
+  'caller',
+
   // User input
   'input',
+  'prompt',
+
+  // Debugging
+  'inspect',
+  'debug',
+
+  // Testing (mock and fixtures only work under mcps test)
+  'assert',
+  'assertEqual',
+  'assertThrows',
+  'expect',
+  'mock',
+  'fixtures',
+
+  // Embeddings and vector search
+  'embed',
+  'vectors',
+
+  // Events
+  'emit',
+
+  // Locale-aware formatting
+  'format',
+
+  // Binary values
+  'bytes',
 
   // Collections
   'Set',
@@ -133,7 +177,8 @@ class ValidationScope {
 export function validateStatements(statements: Statement[]): void {
   const scope = new ValidationScope();
 
-  // First pass: collect all top-level declarations (MCP servers, models, agents, tools)
+  // First pass: collect all top-level declarations (MCP servers, models,
+  // prompts, agents, tools, pipelines)
   for (const stmt of statements) {
     if (stmt.type === 'mcp_declaration') {
       scope.declare((stmt as MCPDeclaration).name);
@@ -141,8 +186,12 @@ export function validateStatements(statements: Statement[]): void {
       scope.declare((stmt as ModelDeclaration).name);
     } else if (stmt.type === 'agent_declaration') {
       scope.declare((stmt as AgentDeclaration).name);
+    } else if (stmt.type === 'prompt_declaration') {
+      scope.declare((stmt as PromptDeclaration).name);
     } else if (stmt.type === 'tool_declaration') {
       scope.declare((stmt as ToolDeclaration).name);
+    } else if (stmt.type === 'pipeline_declaration') {
+      scope.declare((stmt as PipelineDeclaration).name);
     }
   }
 
@@ -150,6 +199,30 @@ export function validateStatements(statements: Statement[]): void {
   for (const stmt of statements) {
     validateStatement(stmt, scope);
   }
+
+  // Triggers fire and event handlers run once the script has run, so they
+  // may use any variable it sets
+  for (const stmt of statements) {
+    if (stmt.type === 'trigger_declaration') {
+      validateExpression((stmt as TriggerDeclaration).action, scope);
+    } else if (stmt.type === 'event_handler') {
+      validateEventHandler(stmt as EventHandler, scope);
+    }
+  }
+
+  // So do test cases, with the fixtures of the file, which are set in
+  // order and may use the fixtures before them
+  for (const stmt of statements) {
+    if (stmt.type === 'fixture_declaration') {
+      validateExpression((stmt as FixtureDeclaration).value, scope);
+      scope.declare((stmt as FixtureDeclaration).name);
+    }
+  }
+  for (const stmt of statements) {
+    if (stmt.type === 'test_declaration' || stmt.type === 'test_hook') {
+      validateBlockStatement((stmt as TestDeclaration | TestHook).body, scope);
+    }
+  }
 }
 
 /**
@@ -160,12 +233,16 @@ function validateStatement(stmt: Statement, scope: ValidationScope): void {
     case 'mcp_declaration':
     case 'model_declaration':
     case 'agent_declaration':
+    case 'prompt_declaration':
       // These are validated in the first pass
       validateDeclarationConfig(stmt, scope);
       break;
     case 'tool_declaration':
       validateToolDeclaration(stmt as ToolDeclaration, scope);
       break;
+    case 'pipeline_declaration':
+      validatePipelineDeclaration(stmt as PipelineDeclaration, scope);
+      break;
     case 'assignment':
       validateAssignment(stmt as Assignment, scope);
       break;
@@ -184,9 +261,47 @@ function validateStatement(stmt: Statement, scope: ValidationScope): void {
     case 'for_statement':
       validateForStatement(stmt as ForStatement, scope);
       break;
+    case 'parallel_statement':
+      validateParallelStatement(stmt as ParallelStatement, scope);
+      break;
+    case 'parallel_map_statement':
+      validateParallelMapStatement(stmt as ParallelMapStatement, scope);
+      break;
+    case 'annotated_statement': {
+      const { annotation, statement } = stmt as AnnotatedStatement;
+      annotation.arguments.forEach(arg => validateExpression(arg.value, scope));
+      validateStatement(statement, scope);
+      break;
+    }
+    case 'compensated_statement': {
+      const { statement, compensation } = stmt as CompensatedStatement;
+      validateStatement(statement, scope);
+      validateBlockStatement(compensation, scope);
+      break;
+    }
+    case 'notification_handler':
+      validateNotificationHandler(stmt as NotificationHandler, scope);
+      break;
+    case 'listen_statement': {
+      const { until, timeout } = stmt as ListenStatement;
+      if (until) {
+        validateExpression(until, scope);
+      }
+      if (timeout) {
+        validateExpression(timeout, scope);
+      }
+      break;
+    }
     case 'return_statement':
       validateReturnStatement(stmt as ReturnStatement, scope);
       break;
+    case 'trigger_declaration':
+    case 'event_handler':
+    case 'test_declaration':
+    case 'test_hook':
+    case 'fixture_declaration':
+      // Validated once all top-level variables are known
+      break;
     case 'break_statement':
     case 'continue_statement':
     case 'comment':
@@ -199,10 +314,14 @@ function validateStatement(stmt: Statement, scope: ValidationScope): void {
 }
 
 /**
- * Validate declaration configuration (MCP, model, agent)
+ * Validate declaration configuration (MCP, model, agent, prompt)
  */
 function validateDeclarationConfig(
-  stmt: MCPDeclaration | ModelDeclaration | AgentDeclaration,
+  stmt:
+    | MCPDeclaration
+    | ModelDeclaration
+    | AgentDeclaration
+    | PromptDeclaration,
   scope: ValidationScope
 ): void {
   if (stmt.type === 'mcp_declaration') {
@@ -211,6 +330,8 @@ function validateDeclarationConfig(
     validateExpression((stmt as ModelDeclaration).config, scope);
   } else if (stmt.type === 'agent_declaration') {
     validateExpression((stmt as AgentDeclaration).config, scope);
+  } else if (stmt.type === 'prompt_declaration') {
+    validateExpression((stmt as PromptDeclaration).config, scope);
   }
 }
 
@@ -224,8 +345,12 @@ function validateToolDeclaration(
   // Create a new scope for the tool body
   scope.pushScope();
   try {
-    // Declare all parameters in the tool's scope
+    // Declare all parameters in the tool's scope; a default value may use
+    // the parameters before its own
     for (const param of stmt.parameters) {
+      if (param.defaultValue) {
+        validateExpression(param.defaultValue, scope);
+      }
       scope.declare(param.name);
     }
 
@@ -238,6 +363,111 @@ function validateToolDeclaration(
   }
 }
 
+/**
+ * Validate a pipeline declaration; each step may use the parameters and the
+ * steps before it
+ */
+function validatePipelineDeclaration(
+  stmt: PipelineDeclaration,
+  scope: ValidationScope
+): void {
+  scope.pushScope();
+  try {
+    for (const param of stmt.parameters) {
+      if (param.defaultValue) {
+        validateExpression(param.defaultValue, scope);
+      }
+      scope.declare(param.name);
+    }
+    for (const step of stmt.steps) {
+      validatePipelineStage(step.stage, scope);
+      scope.declare(step.name);
+    }
+    if (stmt.output) {
+      validateExpression(stmt.output, scope);
+    }
+  } finally {
+    scope.popScope();
+  }
+}
+
+/**
+ * Validate a stage of a pipeline step
+ */
+function validatePipelineStage(
+  stage: PipelineStage,
+  scope: ValidationScope
+): void {
+  switch (stage.type) {
+    case 'call':
+      validateExpression(stage, scope);
+      break;
+    case 'conditional_stage':
+      validateExpression(stage.condition, scope);
+      validatePipelineStage(stage.then, scope);
+      if (stage.else) {
+        validatePipelineStage(stage.else, scope);
+      }
+      break;
+    case 'fan_out_stage':
+      stage.branches.forEach(branch =>
+        validatePipelineStage(branch.stage, scope)
+      );
+      break;
+    case 'each_stage':
+      validateExpression(stage.items, scope);
+      if (stage.limit) {
+        validateExpression(stage.limit, scope);
+      }
+      scope.pushScope();
+      try {
+        scope.declare(stage.item);
+        validatePipelineStage(stage.stage, scope);
+      } finally {
+        scope.popScope();
+      }
+      break;
+  }
+}
+
+/**
+ * Validate an event or notification handler, whose parameter receives each
+ * event's payload
+ */
+function validateEventHandler(
+  stmt: EventHandler | NotificationHandler,
+  scope: ValidationScope
+): void {
+  scope.pushScope();
+  try {
+    if (stmt.parameter) {
+      scope.declare(stmt.parameter.name);
+    }
+    for (const s of stmt.body.statements) {
+      validateStatement(s, scope);
+    }
+  } finally {
+    scope.popScope();
+  }
+}
+
+/**
+ * Validate a notification handler, whose parameter receives each
+ * notification's parameters
+ */
+function validateNotificationHandler(
+  stmt: NotificationHandler,
+  scope: ValidationScope
+): void {
+  if (!scope.isDefined(stmt.server)) {
+    throw new UndefinedVariableError(stmt.server, 'notification handler');
+  }
+  if (stmt.resource) {
+    validateExpression(stmt.resource, scope);
+  }
+  validateEventHandler(stmt, scope);
+}
+
 /**
  * Validate a return statement
  */
@@ -256,18 +486,27 @@ function validateReturnStatement(
 function validateAssignment(stmt: Assignment, scope: ValidationScope): void {
   // Validate the value expression
   validateExpression(stmt.value, scope);
+  validateAssignmentTarget(stmt.target, scope);
+}
 
+/**
+ * Validate the target of an assignment, once its value is validated
+ */
+function validateAssignmentTarget(
+  target: AssignmentTarget,
+  scope: ValidationScope
+): void {
   // If target is an identifier, declare it (or verify it exists for member/bracket)
-  if (stmt.target.type === 'identifier') {
-    const variable = (stmt.target as Identifier).name;
+  if (target.type === 'identifier') {
+    const variable = (target as Identifier).name;
     // Declare the variable in the current scope
     scope.declare(variable);
-  } else if (stmt.target.type === 'member') {
+  } else if (target.type === 'member') {
     // Validate the object part of member expression
-    validateExpression((stmt.target as MemberExpression).object, scope);
-  } else if (stmt.target.type === 'bracket') {
+    validateExpression((target as MemberExpression).object, scope);
+  } else if (target.type === 'bracket') {
     // Validate both object and index
-    const bracket = stmt.target as BracketExpression;
+    const bracket = target as BracketExpression;
     validateExpression(bracket.object, scope);
     validateExpression(bracket.index, scope);
   }
@@ -375,6 +614,61 @@ function validateForStatement(
   }
 }
 
+/**
+ * Validate a parallel statement
+ */
+function validateParallelStatement(
+  stmt: ParallelStatement,
+  scope: ValidationScope
+): void {
+  if (stmt.limit) {
+    validateExpression(stmt.limit, scope);
+  }
+
+  // Assignment branches assign variables of the enclosing scope; every
+  // other branch runs in a scope of its own
+  for (const branch of stmt.branches) {
+    if (branch.type === 'assignment') {
+      validateStatement(branch, scope);
+    } else {
+      scope.pushScope();
+      try {
+        validateStatement(branch, scope);
+      } finally {
+        scope.popScope();
+      }
+    }
+  }
+}
+
+/**
+ * Validate a parallel map statement
+ */
+function validateParallelMapStatement(
+  stmt: ParallelMapStatement,
+  scope: ValidationScope
+): void {
+  validateExpression(stmt.items, scope);
+  if (stmt.limit) {
+    validateExpression(stmt.limit, scope);
+  }
+
+  // The body runs for each item in a scope of its own
+  scope.pushScope();
+  try {
+    scope.declare(stmt.item);
+    for (const s of stmt.body) {
+      validateStatement(s, scope);
+    }
+  } finally {
+    scope.popScope();
+  }
+
+  if (stmt.target) {
+    validateAssignmentTarget(stmt.target, scope);
+  }
+}
+
 /**
  * Validate an expression
  */
@@ -459,6 +753,9 @@ function validateCallExpression(
   for (const arg of expr.arguments) {
     validateExpression(arg, scope);
   }
+  if (expr.options) {
+    validateExpression(expr.options, scope);
+  }
 }
 
 /**
@@ -0,0 +1,92 @@
+// @mcpscript/transpiler/wasm - Node.js entry without native code
+//
+// Runs the grammar as WebAssembly through web-tree-sitter instead of the
+// native binding, for installs where node-gyp cannot build it: no C
+// toolchain, bundles shipped to other platforms, or sandboxes that refuse
+// native addons. Once initWasmSyntax() has been awaited, the API is that of
+// @mcpscript/transpiler, except ParserPool, whose workers load the native
+// binding.
+import { readFileSync } from 'fs';
+import { dirname, join } from 'path';
+import { fileURLToPath } from 'url';
+import type { Statement } from './ast.js';
+import { initBrowserSyntax, type WebTreeSitter } from './browser.js';
+import { parseSource } from './parser.js';
+import type { QueryName } from './syntax.js';
+
+// Resolve paths relative to the transpiler package root
+const packageDir = join(dirname(fileURLToPath(import.meta.url)), '..');
+
+const QUERY_NAMES: QueryName[] = [
+  'highlights',
+  'locals',
+  'folds',
+  'errors',
+  'tags',
+];
+
+export interface WasmSyntaxOptions {
+  /** The web-tree-sitter module's default export */
+  Parser: WebTreeSitter;
+  /**
+   * Path or contents of the grammar
+   * (default: the dist/tree-sitter-mcpscript.wasm `npm run build:wasm` writes)
+   */
+  wasm?: string | Uint8Array;
+}
+
+/**
+ * Load the WebAssembly grammar, and the queries in grammar/queries
+ * Must be awaited once before anything is parsed
+ */
+export async function initWasmSyntax(
+  options: WasmSyntaxOptions
+): Promise<void> {
+  const { wasm = join(packageDir, 'dist', 'tree-sitter-mcpscript.wasm') } =
+    options;
+  const queries = Object.fromEntries(
+    QUERY_NAMES.map(name => [
+      name,
+      readFileSync(
+        join(packageDir, 'grammar', 'queries', `${name}.scm`),
+        'utf-8'
+      ),
+    ])
+  ) as Record<QueryName, string>;
+  await initBrowserSyntax({
+    Parser: options.Parser,
+    wasm: typeof wasm === 'string' ? readFileSync(wasm) : wasm,
+    queries,
+  });
+}
+
+export function parseFile(filePath: string): Statement[] {
+  const content = readFileSync(filePath, 'utf-8');
+  return parseSource(content);
+}
+
+export * from './parser.js';
+export * from './syntax.js';
+export * from './codegen.js';
+export * from './ast.js';
+export * from './validator.js';
+export * from './locations.js';
+export * from './arena.js';
+export * from './limits.js';
+export * from './features.js';
+export * from './typecheck.js';
+export * from './formatter.js';
+export * from './imports.js';
+export * from './semantics.js';
+export * from './modules.js';
+export * from './lint.js';
+export * from './bench.js';
+export * from './batch.js';
+export * from './tags.js';
+export * from './tokens.js';
+export * from './highlight.js';
+export * from './diff.js';
+export * from './serialize.js';
+export * from './capabilities.js';
+export * from './interpolation.js';
+export type { WebTreeSitter } from './browser.js';
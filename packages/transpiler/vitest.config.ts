@@ -6,6 +6,9 @@ export default defineConfig({
     globals: true,
     include: ['src/**/*.test.ts'],
     exclude: ['node_modules/**', 'dist/**'],
+    // Tests import modules directly rather than through index.ts, which
+    // is where the native grammar binding gets loaded
+    setupFiles: ['src/native.ts'],
     coverage: {
       reporter: ['text', 'json', 'html'],
       exclude: ['node_modules/**', 'dist/**', '**/*.test.ts', '**/*.spec.ts'],